@@ -131,10 +131,20 @@ Validate required TrueNAS configuration
   {{- if not .Values.truenas.url }}
     {{- fail "\n\nCONFIGURATION ERROR: truenas.url is required.\nExample: --set truenas.url=\"wss://YOUR-TRUENAS-IP:443/api/current\"" }}
   {{- end }}
-  {{- if not .Values.truenas.apiKey }}
+  {{- if and (eq (.Values.truenas.apiKeySource | default "secret") "secret") (not .Values.truenas.apiKey) }}
     {{- fail "\n\nCONFIGURATION ERROR: truenas.apiKey is required.\nCreate an API key in TrueNAS UI: Settings > API Keys\nExample: --set truenas.apiKey=\"1-xxxxxxxxxx\"" }}
   {{- end }}
 {{- end }}
+{{- $source := .Values.truenas.apiKeySource | default "secret" }}
+{{- if not (mustHas $source (list "secret" "vault" "secretsStore")) }}
+  {{- fail (printf "\n\nCONFIGURATION ERROR: truenas.apiKeySource must be one of: secret, vault, secretsStore (got %q)" $source) }}
+{{- end }}
+{{- if and (eq $source "vault") (or (not .Values.truenas.vault.address) (not .Values.truenas.vault.secretPath) (not .Values.truenas.vault.role)) }}
+  {{- fail "\n\nCONFIGURATION ERROR: truenas.vault.address, truenas.vault.secretPath and truenas.vault.role are required with truenas.apiKeySource=vault" }}
+{{- end }}
+{{- if and (eq $source "secretsStore") (not .Values.truenas.secretsStore.secretProviderClass) }}
+  {{- fail "\n\nCONFIGURATION ERROR: truenas.secretsStore.secretProviderClass is required with truenas.apiKeySource=secretsStore" }}
+{{- end }}
 {{- range .Values.storageClasses }}
 {{- if .enabled }}
 {{- if not (mustHas .protocol (list "nfs" "nvmeof" "iscsi" "smb")) }}
@@ -159,6 +169,54 @@ Validate required TrueNAS configuration
 {{- end }}
 {{- end }}
 
+{{/*
+Driver arguments for reading the API key from truenas.apiKeySource
+*/}}
+{{- define "tns-csi-driver.apiKeyArgs" -}}
+{{- $source := .Values.truenas.apiKeySource | default "secret" }}
+{{- if eq $source "vault" }}
+{{- with .Values.truenas.vault }}
+- "--vault-addr={{ .address }}"
+- "--vault-secret-path={{ .secretPath }}"
+- "--vault-secret-key={{ .secretKey | default "api-key" }}"
+- "--vault-role={{ .role }}"
+- "--vault-auth-mount={{ .authMount | default "kubernetes" }}"
+{{- if .namespace }}
+- "--vault-namespace={{ .namespace }}"
+{{- end }}
+{{- if .refreshInterval }}
+- "--vault-refresh-interval={{ .refreshInterval }}"
+{{- end }}
+{{- end }}
+{{- else if eq $source "secretsStore" }}
+- "--api-key-file=/etc/tns-csi/credentials/{{ .Values.truenas.secretsStore.objectName | default "api-key" }}"
+{{- else }}
+- "--api-key-file=/etc/tns-csi/credentials/api-key"
+{{- end }}
+{{- end }}
+
+{{/*
+Volume holding the API key file (none when it is read from Vault)
+*/}}
+{{- define "tns-csi-driver.credentialsVolume" -}}
+{{- $source := .Values.truenas.apiKeySource | default "secret" }}
+{{- if eq $source "secretsStore" }}
+- name: credentials
+  csi:
+    driver: secrets-store.csi.k8s.io
+    readOnly: true
+    volumeAttributes:
+      secretProviderClass: {{ .Values.truenas.secretsStore.secretProviderClass | quote }}
+{{- else if ne $source "vault" }}
+- name: credentials
+  secret:
+    secretName: {{ include "tns-csi-driver.secretName" . }}
+    items:
+      - key: api-key
+        path: api-key
+{{- end }}
+{{- end }}
+
 {{/*
 Get the image tag to use.
 Uses .Values.image.tag if explicitly set, otherwise falls back to .Chart.AppVersion.
@@ -221,6 +279,21 @@ parameters:
   {{- if $sc.commentTemplate }}
   commentTemplate: {{ $sc.commentTemplate | quote }}
   {{- end }}
+  {{- if $sc.volumeGroup }}
+  volumeGroup: {{ $sc.volumeGroup | quote }}
+  {{- end }}
+  {{- if $sc.volumeOwner }}
+  volumeOwner: {{ $sc.volumeOwner | quote }}
+  {{- end }}
+  {{- if $sc.volumeMode }}
+  volumeMode: {{ $sc.volumeMode | quote }}
+  {{- end }}
+  {{- if $sc.seLinuxContext }}
+  seLinuxContext: {{ $sc.seLinuxContext | quote }}
+  {{- end }}
+  {{- if $sc.verifyRestore }}
+  verifyRestore: {{ $sc.verifyRestore | quote }}
+  {{- end }}
   {{- if $sc.markAdoptable }}
   markAdoptable: {{ $sc.markAdoptable | quote }}
   {{- end }}
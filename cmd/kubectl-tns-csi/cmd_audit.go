@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/fenio/tns-csi/pkg/dashboard"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// volumeSnapshotContentGVR identifies VolumeSnapshotContents of the external-snapshotter CRDs.
+var volumeSnapshotContentGVR = schema.GroupVersionResource{
+	Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshotcontents",
+}
+
+func newAuditCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID *string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Compare Kubernetes and TrueNAS state and report mismatches",
+		Long: `Audit the consistency of Kubernetes and TrueNAS state.
+
+This command reports:
+  - PVs whose dataset or ZVOL no longer exists
+  - Datasets whose NFS or SMB share ID refers to a deleted share
+  - NVMe-oF namespaces whose ZVOL no longer exists
+  - CSI snapshots that no VolumeSnapshotContent refers to
+
+Nothing is changed. Checks that need PVs or VolumeSnapshotContents are skipped
+when the cluster cannot be reached. The controller runs the same audit
+periodically with --audit-interval.
+
+Examples:
+  # Audit the current cluster
+  kubectl tns-csi audit
+
+  # Output as JSON
+  kubectl tns-csi audit -o json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runAudit(cmd.Context(), url, apiKey, secretRef, outputFormat, skipTLSVerify, clusterID)
+		},
+	}
+	return cmd
+}
+
+func runAudit(ctx context.Context, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID *string) error {
+	// Get connection config
+	cfg, err := getConnectionConfig(ctx, url, apiKey, secretRef, skipTLSVerify)
+	if err != nil {
+		return err
+	}
+
+	// Connect to TrueNAS
+	spin := newSpinner("Auditing volumes...")
+	client, err := connectToTrueNAS(ctx, cfg)
+	if err != nil {
+		spin.stop()
+		return err
+	}
+	defer client.Close()
+
+	report, err := dashboard.Audit(ctx, client, *clusterID, auditInput(ctx))
+	spin.stop()
+	if err != nil {
+		return fmt.Errorf("failed to audit volumes: %w", err)
+	}
+
+	return outputAuditReport(report, *outputFormat)
+}
+
+// auditInput lists the PVs and VolumeSnapshotContents of the cluster. Whatever cannot be listed
+// is left unknown, so the audit skips the checks that need it.
+func auditInput(ctx context.Context) dashboard.AuditInput {
+	var input dashboard.AuditInput
+
+	config, err := getK8sConfig()
+	if err != nil {
+		klog.V(4).Infof("Audit without Kubernetes state: %v", err)
+		return input
+	}
+
+	if k8sClient, err := kubernetes.NewForConfig(config); err != nil {
+		klog.V(4).Infof("Audit without PVs: %v", err)
+	} else if pvs, err := k8sClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{}); err != nil {
+		klog.V(4).Infof("Audit without PVs: %v", err)
+	} else {
+		input.PVs = make([]*corev1.PersistentVolume, len(pvs.Items))
+		for i := range pvs.Items {
+			input.PVs[i] = &pvs.Items[i]
+		}
+		input.PVsKnown = true
+	}
+
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		klog.V(4).Infof("Audit without VolumeSnapshotContents: %v", err)
+		return input
+	}
+	contents, err := dyn.Resource(volumeSnapshotContentGVR).List(ctx, metav1.ListOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		// No snapshot CRDs: no VolumeSnapshotContent refers to any snapshot
+		input.SnapshotsKnown = true
+	case err != nil:
+		klog.V(4).Infof("Audit without VolumeSnapshotContents: %v", err)
+	default:
+		input.SnapshotHandles = snapshotContentHandles(contents.Items)
+		input.SnapshotsKnown = true
+	}
+	return input
+}
+
+// snapshotContentHandles returns the snapshot handles of the tns-csi VolumeSnapshotContents.
+func snapshotContentHandles(contents []unstructured.Unstructured) []string {
+	var handles []string
+	for i := range contents {
+		content := contents[i].Object
+		if driver, _, _ := unstructured.NestedString(content, "spec", "driver"); driver != "tns.csi.io" {
+			continue
+		}
+		handle, _, _ := unstructured.NestedString(content, "status", "snapshotHandle")
+		if handle == "" {
+			handle, _, _ = unstructured.NestedString(content, "spec", "source", "snapshotHandle")
+		}
+		if handle != "" {
+			handles = append(handles, handle)
+		}
+	}
+	return handles
+}
+
+// outputAuditReport outputs the audit report in the specified format.
+func outputAuditReport(report *dashboard.AuditReport, format string) error {
+	switch format {
+	case outputFormatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+
+	case outputFormatYAML:
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		return enc.Encode(report)
+
+	case outputFormatTable, "":
+		return outputAuditReportTable(report)
+
+	default:
+		return fmt.Errorf("%w: %s", errUnknownOutputFormat, format)
+	}
+}
+
+// outputAuditReportTable outputs the audit report in table format.
+func outputAuditReportTable(report *dashboard.AuditReport) error {
+	colorHeader.Println("=== Audit Summary ===") //nolint:errcheck,gosec
+	counts := report.Counts()
+	for _, kind := range dashboard.AuditKinds {
+		count := colorSuccess.Sprint(0)
+		if counts[kind] > 0 {
+			count = colorWarning.Sprint(counts[kind])
+		}
+		fmt.Printf("%-26s %s\n", kind+":", count)
+	}
+	for _, kind := range report.Skipped {
+		fmt.Printf("%s\n", colorMuted.Sprintf("Skipped %s: Kubernetes state not available", kind))
+	}
+	fmt.Println()
+
+	if len(report.Findings) == 0 {
+		colorSuccess.Println("No mismatches found.") //nolint:errcheck,gosec
+		return nil
+	}
+
+	colorHeader.Println("=== Findings ===") //nolint:errcheck,gosec
+	t := newStyledTable()
+	t.AppendHeader(table.Row{"KIND", "RESOURCE", "DETAIL"})
+	for _, finding := range report.Findings {
+		t.AppendRow(table.Row{finding.Kind, finding.Resource, finding.Detail})
+	}
+	renderTable(t)
+	return nil
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Static errors for backup commands.
+var (
+	errInvalidBackupClassRef = errors.New("invalid backup class reference format, expected 'namespace/name'")
+	errBackupClassIncomplete = errors.New("backup class is missing required keys")
+	errBackupBlockVolume     = errors.New("block volumes (nvmeof/iscsi) cannot be exported with cloud sync, only filesystem volumes (nfs/smb)")
+	errInvalidBackupRef      = errors.New("invalid backup reference, expected '<volume>/<backup>'")
+	errRestoreTargetRequired = errors.New("restore target volume is required (--to)")
+)
+
+// Backup class ConfigMap keys.
+const (
+	backupClassKeyCredentials = "credentials" // TrueNAS cloud credential name or ID
+	backupClassKeyBucket      = "bucket"
+	backupClassKeyPrefix      = "prefix"    // Optional folder prefix inside the bucket
+	backupClassKeyTransfers   = "transfers" // Optional number of parallel transfers
+
+	defaultBackupClassRef  = defaultDriverNamespace + "/tns-csi-backup"
+	defaultBackupPrefix    = "tns-csi-backups"
+	backupSnapshotPrefix   = "tns-csi-backup-"
+	backupJobPollInterval  = 5 * time.Second
+	backupSnapshotDirName  = ".zfs/snapshot"
+	defaultMountpointRoot  = "/mnt"
+	cloudSyncTransferCopy  = "COPY"
+	cloudSyncDirectionPush = "PUSH"
+	cloudSyncDirectionPull = "PULL"
+)
+
+// backupClass is the S3 target configuration read from a BackupClass-style ConfigMap:
+//
+//	apiVersion: v1
+//	kind: ConfigMap
+//	metadata:
+//	  name: tns-csi-backup
+//	  namespace: kube-system
+//	data:
+//	  credentials: my-s3     # Cloud credential configured on TrueNAS
+//	  bucket: k8s-backups
+//	  prefix: cluster-a      # optional
+//
+//nolint:govet // field alignment not critical for CLI config struct
+type backupClass struct {
+	Credentials string
+	Bucket      string
+	Prefix      string
+	Transfers   int
+}
+
+// BackupInfo describes a backup stored in the bucket.
+type BackupInfo struct {
+	Volume   string `json:"volume"   yaml:"volume"`
+	Backup   string `json:"backup"   yaml:"backup"`
+	Location string `json:"location" yaml:"location"`
+	Modified string `json:"modified" yaml:"modified"`
+}
+
+// backupClient is the subset of the TrueNAS client used by backup commands.
+type backupClient interface {
+	tnsapi.ClientInterface
+	CloudSyncCredentialByName(ctx context.Context, name string) (*tnsapi.CloudSyncCredential, error)
+	RunOnetimeCloudSyncAndWait(ctx context.Context, params tnsapi.CloudSyncTaskParams, pollInterval time.Duration) error
+	ListCloudSyncDirectory(ctx context.Context, credentialID int, attributes map[string]interface{}) ([]tnsapi.CloudSyncEntry, error)
+}
+
+func newBackupCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool) *cobra.Command {
+	var backupClassRef string
+
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Export volume snapshots to S3-compatible object storage",
+		Long: `Export volume snapshots to S3-compatible object storage using TrueNAS cloud sync.
+
+Backups are copied off-box directly by TrueNAS: no node agents or data movers run
+in the cluster. The target bucket is configured in a ConfigMap (the backup class)
+that references a cloud credential already configured on TrueNAS:
+
+  apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: tns-csi-backup
+    namespace: kube-system
+  data:
+    credentials: my-s3      # TrueNAS cloud credential name (or ID)
+    bucket: k8s-backups
+    prefix: cluster-a       # optional, default: tns-csi-backups
+
+Only filesystem volumes (NFS/SMB) can be exported; their snapshot contents are
+copied file-by-file from the read-only .zfs/snapshot directory.`,
+	}
+
+	cmd.PersistentFlags().StringVar(&backupClassRef, "backup-class", defaultBackupClassRef, "ConfigMap with the backup target (namespace/name)")
+
+	cmd.AddCommand(newBackupCreateCmd(url, apiKey, secretRef, skipTLSVerify, &backupClassRef))
+	cmd.AddCommand(newBackupListCmd(url, apiKey, secretRef, outputFormat, skipTLSVerify, &backupClassRef))
+	cmd.AddCommand(newBackupRestoreCmd(url, apiKey, secretRef, skipTLSVerify, &backupClassRef))
+
+	return cmd
+}
+
+func newBackupCreateCmd(url, apiKey, secretRef *string, skipTLSVerify *bool, backupClassRef *string) *cobra.Command {
+	var (
+		snapshotName string
+		keepSnapshot bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "create <volume>",
+		Short: "Snapshot a volume and export the snapshot to the bucket",
+		Long: `Snapshot a volume and copy the snapshot contents to the backup bucket.
+
+Examples:
+  # Take a new snapshot and export it
+  kubectl tns-csi backup create pvc-12345678-1234-1234-1234-123456789012
+
+  # Export an existing ZFS snapshot of the volume
+  kubectl tns-csi backup create pvc-xxx --snapshot nightly-2024-06-01`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBackupCreate(cmd.Context(), args[0], url, apiKey, secretRef, skipTLSVerify, *backupClassRef, snapshotName, keepSnapshot)
+		},
+	}
+
+	cmd.Flags().StringVar(&snapshotName, "snapshot", "", "Export this existing ZFS snapshot instead of taking a new one")
+	cmd.Flags().BoolVar(&keepSnapshot, "keep-snapshot", false, "Keep the snapshot taken for the backup on TrueNAS")
+
+	return cmd
+}
+
+func newBackupListCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, backupClassRef *string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list [volume]",
+		Short: "List backups in the bucket",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			volume := ""
+			if len(args) == 1 {
+				volume = args[0]
+			}
+			return runBackupList(cmd.Context(), volume, url, apiKey, secretRef, outputFormat, skipTLSVerify, *backupClassRef)
+		},
+	}
+	return cmd
+}
+
+func newBackupRestoreCmd(url, apiKey, secretRef *string, skipTLSVerify *bool, backupClassRef *string) *cobra.Command {
+	var target string
+
+	cmd := &cobra.Command{
+		Use:   "restore <volume>/<backup> --to <volume>",
+		Short: "Copy a backup from the bucket into a volume",
+		Long: `Copy a backup from the bucket into an existing filesystem volume.
+
+Files present in the backup overwrite files in the target; other files in the
+target are left untouched. Restore into a freshly provisioned PVC for an exact copy.
+
+Examples:
+  # Restore into a new, empty PVC's volume
+  kubectl tns-csi backup restore pvc-xxx/tns-csi-backup-20240601-120000 --to pvc-yyy`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBackupRestore(cmd.Context(), args[0], target, url, apiKey, secretRef, skipTLSVerify, *backupClassRef)
+		},
+	}
+
+	cmd.Flags().StringVar(&target, "to", "", "Target volume (CSI volume ID or dataset path)")
+
+	return cmd
+}
+
+// loadBackupClass reads the backup target from a ConfigMap ("namespace/name").
+func loadBackupClass(ctx context.Context, ref string) (*backupClass, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("%w: %q", errInvalidBackupClassRef, ref)
+	}
+
+	client, err := getK8sClient()
+	if err != nil {
+		return nil, err
+	}
+
+	cm, err := client.CoreV1().ConfigMaps(parts[0]).Get(ctx, parts[1], metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup class %s: %w", ref, err)
+	}
+
+	return parseBackupClass(cm.Data)
+}
+
+// parseBackupClass validates backup class ConfigMap data.
+func parseBackupClass(data map[string]string) (*backupClass, error) {
+	bc := &backupClass{
+		Credentials: strings.TrimSpace(data[backupClassKeyCredentials]),
+		Bucket:      strings.TrimSpace(data[backupClassKeyBucket]),
+		Prefix:      strings.Trim(strings.TrimSpace(data[backupClassKeyPrefix]), "/"),
+	}
+
+	var missing []string
+	if bc.Credentials == "" {
+		missing = append(missing, backupClassKeyCredentials)
+	}
+	if bc.Bucket == "" {
+		missing = append(missing, backupClassKeyBucket)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", errBackupClassIncomplete, strings.Join(missing, ", "))
+	}
+
+	if bc.Prefix == "" {
+		bc.Prefix = defaultBackupPrefix
+	}
+
+	if raw := strings.TrimSpace(data[backupClassKeyTransfers]); raw != "" {
+		transfers, err := strconv.Atoi(raw)
+		if err != nil || transfers < 1 {
+			return nil, fmt.Errorf("%w: %s must be a positive integer, got %q", errBackupClassIncomplete, backupClassKeyTransfers, raw)
+		}
+		bc.Transfers = transfers
+	}
+
+	return bc, nil
+}
+
+// folder returns the bucket folder for a volume's backups, or for one backup if name is set.
+func (bc *backupClass) folder(volume, name string) string {
+	return path.Join(bc.Prefix, volume, name)
+}
+
+// attributes returns the cloud sync provider attributes for a bucket folder.
+func (bc *backupClass) attributes(folder string) map[string]interface{} {
+	return map[string]interface{}{
+		"bucket": bc.Bucket,
+		"folder": "/" + folder,
+	}
+}
+
+// resolveCredentialID returns the TrueNAS cloud credential ID for the backup class.
+func (bc *backupClass) resolveCredentialID(ctx context.Context, client backupClient) (int, error) {
+	if id, err := strconv.Atoi(bc.Credentials); err == nil {
+		return id, nil
+	}
+	cred, err := client.CloudSyncCredentialByName(ctx, bc.Credentials)
+	if err != nil {
+		return 0, err
+	}
+	return cred.ID, nil
+}
+
+// backupVolumeName returns the folder name used for a volume's backups.
+func backupVolumeName(dataset string) string {
+	return path.Base(dataset)
+}
+
+// datasetMountpoint returns where a dataset is mounted on TrueNAS.
+func datasetMountpoint(ds *tnsapi.Dataset) string {
+	if ds.Mountpoint != "" {
+		return ds.Mountpoint
+	}
+	return path.Join(defaultMountpointRoot, ds.ID)
+}
+
+// resolveFilesystemVolume finds a managed volume and rejects block volumes.
+func resolveFilesystemVolume(ctx context.Context, client backupClient, volumeRef string) (*tnsapi.Dataset, error) {
+	vol, err := findVolumeByRef(ctx, client, volumeRef)
+	if err != nil {
+		return nil, err
+	}
+	if vol.Protocol == protocolNVMeOF || vol.Protocol == protocolISCSI {
+		return nil, fmt.Errorf("%w: %s (%s)", errBackupBlockVolume, volumeRef, vol.Protocol)
+	}
+	ds, err := client.Dataset(ctx, vol.Dataset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dataset %s: %w", vol.Dataset, err)
+	}
+	return ds, nil
+}
+
+func connectForBackup(ctx context.Context, url, apiKey, secretRef *string, skipTLSVerify *bool, backupClassRef string) (*TrueNASClient, *backupClass, int, error) {
+	bc, err := loadBackupClass(ctx, backupClassRef)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	cfg, err := getConnectionConfig(ctx, url, apiKey, secretRef, skipTLSVerify)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	client, err := connectToTrueNAS(ctx, cfg)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	credentialID, err := bc.resolveCredentialID(ctx, client)
+	if err != nil {
+		client.Close()
+		return nil, nil, 0, err
+	}
+
+	return client, bc, credentialID, nil
+}
+
+func runBackupCreate(ctx context.Context, volumeRef string, url, apiKey, secretRef *string, skipTLSVerify *bool, backupClassRef, snapshotName string, keepSnapshot bool) error {
+	client, bc, credentialID, err := connectForBackup(ctx, url, apiKey, secretRef, skipTLSVerify, backupClassRef)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ds, err := resolveFilesystemVolume(ctx, client, volumeRef)
+	if err != nil {
+		return err
+	}
+
+	createdSnapshot := false
+	if snapshotName == "" {
+		snapshotName = backupSnapshotPrefix + time.Now().UTC().Format("20060102-150405")
+		if _, err := client.CreateSnapshot(ctx, tnsapi.SnapshotCreateParams{Dataset: ds.ID, Name: snapshotName}); err != nil {
+			return fmt.Errorf("failed to snapshot %s: %w", ds.ID, err)
+		}
+		createdSnapshot = true
+		printStepf(colorSuccess, iconOK, "Created snapshot %s@%s", ds.ID, snapshotName)
+	}
+
+	if createdSnapshot && !keepSnapshot {
+		defer func() {
+			if delErr := client.DeleteSnapshot(ctx, ds.ID+"@"+snapshotName); delErr != nil {
+				printStepf(colorWarning, iconWarning, "Failed to delete snapshot %s@%s: %v", ds.ID, snapshotName, delErr)
+			}
+		}()
+	}
+
+	folder := bc.folder(backupVolumeName(ds.ID), snapshotName)
+	params := tnsapi.CloudSyncTaskParams{
+		Description:  fmt.Sprintf("tns-csi backup %s@%s", ds.ID, snapshotName),
+		Path:         path.Join(datasetMountpoint(ds), backupSnapshotDirName, snapshotName),
+		Credentials:  credentialID,
+		Direction:    cloudSyncDirectionPush,
+		TransferMode: cloudSyncTransferCopy,
+		Attributes:   bc.attributes(folder),
+		Transfers:    bc.Transfers,
+	}
+
+	spin := newSpinner(fmt.Sprintf("Exporting %s@%s to s3://%s/%s...", ds.ID, snapshotName, bc.Bucket, folder))
+	err = client.RunOnetimeCloudSyncAndWait(ctx, params, backupJobPollInterval)
+	spin.stop()
+	if err != nil {
+		printStepf(colorError, iconError, "Backup failed")
+		return err
+	}
+
+	printStepf(colorSuccess, iconOK, "Backup %s/%s exported to s3://%s/%s", backupVolumeName(ds.ID), snapshotName, bc.Bucket, folder)
+	return nil
+}
+
+func runBackupList(ctx context.Context, volumeRef string, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, backupClassRef string) error {
+	client, bc, credentialID, err := connectForBackup(ctx, url, apiKey, secretRef, skipTLSVerify, backupClassRef)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var volumes []string
+	if volumeRef != "" {
+		volumes = []string{backupVolumeName(volumeRef)}
+	} else {
+		entries, listErr := client.ListCloudSyncDirectory(ctx, credentialID, bc.attributes(bc.Prefix))
+		if listErr != nil {
+			return listErr
+		}
+		for _, e := range entries {
+			if e.IsDir {
+				volumes = append(volumes, e.Name)
+			}
+		}
+	}
+
+	backups := make([]BackupInfo, 0)
+	for _, volume := range volumes {
+		entries, listErr := client.ListCloudSyncDirectory(ctx, credentialID, bc.attributes(bc.folder(volume, "")))
+		if listErr != nil {
+			return listErr
+		}
+		for _, e := range entries {
+			if !e.IsDir {
+				continue
+			}
+			backups = append(backups, BackupInfo{
+				Volume:   volume,
+				Backup:   e.Name,
+				Location: fmt.Sprintf("s3://%s/%s", bc.Bucket, bc.folder(volume, e.Name)),
+				Modified: e.ModTime,
+			})
+		}
+	}
+
+	return outputBackups(backups, *outputFormat)
+}
+
+// outputBackups outputs backups in the specified format.
+func outputBackups(backups []BackupInfo, format string) error {
+	switch format {
+	case outputFormatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(backups)
+
+	case outputFormatYAML:
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		return enc.Encode(backups)
+
+	case outputFormatTable, "":
+		t := newStyledTable()
+		t.AppendHeader(table.Row{"VOLUME", "BACKUP", "LOCATION"})
+		for _, b := range backups {
+			t.AppendRow(table.Row{b.Volume, b.Backup, b.Location})
+		}
+		renderTable(t)
+		return nil
+
+	default:
+		return fmt.Errorf("%w: %s", errUnknownOutputFormat, format)
+	}
+}
+
+// parseBackupRef splits "<volume>/<backup>" into its parts.
+func parseBackupRef(ref string) (volume, backup string, err error) {
+	idx := strings.LastIndex(ref, "/")
+	if idx <= 0 || idx == len(ref)-1 {
+		return "", "", fmt.Errorf("%w: %q", errInvalidBackupRef, ref)
+	}
+	return backupVolumeName(ref[:idx]), ref[idx+1:], nil
+}
+
+func runBackupRestore(ctx context.Context, backupRef, targetRef string, url, apiKey, secretRef *string, skipTLSVerify *bool, backupClassRef string) error {
+	if targetRef == "" {
+		return errRestoreTargetRequired
+	}
+	volume, backup, err := parseBackupRef(backupRef)
+	if err != nil {
+		return err
+	}
+
+	client, bc, credentialID, err := connectForBackup(ctx, url, apiKey, secretRef, skipTLSVerify, backupClassRef)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ds, err := resolveFilesystemVolume(ctx, client, targetRef)
+	if err != nil {
+		return err
+	}
+
+	folder := bc.folder(volume, backup)
+	params := tnsapi.CloudSyncTaskParams{
+		Description:  fmt.Sprintf("tns-csi restore %s/%s to %s", volume, backup, ds.ID),
+		Path:         datasetMountpoint(ds),
+		Credentials:  credentialID,
+		Direction:    cloudSyncDirectionPull,
+		TransferMode: cloudSyncTransferCopy,
+		Attributes:   bc.attributes(folder),
+		Transfers:    bc.Transfers,
+	}
+
+	spin := newSpinner(fmt.Sprintf("Restoring s3://%s/%s into %s...", bc.Bucket, folder, ds.ID))
+	err = client.RunOnetimeCloudSyncAndWait(ctx, params, backupJobPollInterval)
+	spin.stop()
+	if err != nil {
+		printStepf(colorError, iconError, "Restore failed")
+		return err
+	}
+
+	printStepf(colorSuccess, iconOK, "Restored %s/%s into %s", volume, backup, ds.ID)
+	return nil
+}
@@ -130,7 +130,7 @@ func runCleanup(ctx context.Context, url, apiKey, secretRef, outputFormat *strin
 	}
 
 	// Find orphaned volumes
-	orphaned := findOrphanedVolumes(volumes, pvMap, pvcMap)
+	orphaned := dashboard.FindOrphanedVolumes(volumes, pvMap, pvcMap)
 
 	if len(orphaned) == 0 {
 		fmt.Println("No orphaned volumes found")
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Compatibility self-test (compat-check).
+//
+// compat-check exercises the TrueNAS API families each protocol needs, in a temporary dataset
+// below --parent: creating a dataset or ZVOL and setting user properties on it, taking a
+// snapshot and cloning it, and creating, reading and deleting the NFS/SMB share or NVMe-oF/iSCSI
+// target the driver would create. Everything is deleted again, the temporary dataset last.
+
+// Check families of the compatibility matrix, in column order.
+const (
+	compatDataset  = "dataset"
+	compatSnapshot = "snapshot"
+	compatExport   = "export"
+)
+
+// Check results.
+const (
+	compatPass = "pass"
+	compatFail = "fail"
+	compatSkip = "skip"
+)
+
+// compatProperty is the user property set on test datasets.
+const compatProperty = "tns-csi:compat_check"
+
+// compatZvolSize is the size of test ZVOLs.
+const compatZvolSize int64 = 1 << 30
+
+var (
+	errCompatParentRequired = errors.New("--parent is required (e.g. tank/k8s)")
+	errCompatUnknown        = errors.New("unknown protocol")
+	errCompatFailed         = errors.New("compatibility check failed")
+	errCompatNoPort         = errors.New("no NVMe-oF port configured")
+	errCompatPropertyLost   = errors.New("user property not read back")
+	errCompatNoBinding      = errors.New("port binding not found")
+)
+
+var compatFamilies = []string{compatDataset, compatSnapshot, compatExport}
+
+var compatProtocols = []string{protocolNFS, protocolSMB, protocolNVMeOF, protocolISCSI}
+
+// CompatCheck is the result of one check family for one protocol.
+type CompatCheck struct {
+	Protocol string `json:"protocol" yaml:"protocol"`
+	Family   string `json:"family" yaml:"family"`
+	Result   string `json:"result" yaml:"result"`
+	Error    string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// CompatReport is the outcome of a compatibility check.
+type CompatReport struct {
+	Dataset      string        `json:"dataset" yaml:"dataset"`
+	Checks       []CompatCheck `json:"checks" yaml:"checks"`
+	CleanupError string        `json:"cleanupError,omitempty" yaml:"cleanupError,omitempty"`
+}
+
+// protocolResult returns pass if every check of protocol passed, otherwise fail.
+func (r *CompatReport) protocolResult(protocol string) string {
+	for _, check := range r.Checks {
+		if check.Protocol == protocol && check.Result != compatPass {
+			return compatFail
+		}
+	}
+	return compatPass
+}
+
+// Passed reports whether every check passed and the test dataset was removed.
+func (r *CompatReport) Passed() bool {
+	for _, check := range r.Checks {
+		if check.Result != compatPass {
+			return false
+		}
+	}
+	return r.CleanupError == ""
+}
+
+func newCompatCheckCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool) *cobra.Command {
+	var (
+		parent    string
+		protocols string
+		timeout   time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "compat-check",
+		Short: "Exercise the TrueNAS APIs the driver needs and print a pass/fail matrix",
+		Long: `Check that a TrueNAS system supports everything the driver does, before rollout.
+
+For each protocol this command creates, below a temporary dataset under --parent:
+  dataset   a dataset (nfs, smb) or ZVOL (nvmeof, iscsi) with a ZFS user property
+  snapshot  a snapshot of it and a clone of the snapshot
+  export    an NFS or SMB share, or an NVMe-oF subsystem, namespace and port
+            binding, or an iSCSI target, extent and target-extent
+
+and deletes everything again. The result is a pass/fail matrix per protocol;
+the command fails if any check fails.
+
+Examples:
+  # Check all protocols below tank/k8s
+  kubectl tns-csi compat-check --parent tank/k8s
+
+  # Check NFS and NVMe-oF only
+  kubectl tns-csi compat-check --parent tank/k8s --protocols nfs,nvmeof
+
+  # Output as JSON
+  kubectl tns-csi compat-check --parent tank/k8s -o json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCompatCheck(cmd.Context(), url, apiKey, secretRef, outputFormat, skipTLSVerify, parent, protocols, timeout)
+		},
+	}
+
+	cmd.Flags().StringVar(&parent, "parent", "", "Dataset to create the temporary test dataset in, e.g. tank/k8s (required)")
+	cmd.Flags().StringVar(&protocols, "protocols", strings.Join(compatProtocols, ","), "Comma-separated protocols to check")
+	cmd.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "Timeout for all checks") //nolint:mnd
+
+	return cmd
+}
+
+func runCompatCheck(ctx context.Context, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool,
+	parent, protocols string, timeout time.Duration,
+) error {
+	parent = strings.Trim(parent, "/")
+	if parent == "" {
+		return errCompatParentRequired
+	}
+	selected, err := parseCompatProtocols(protocols)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := getConnectionConfig(ctx, url, apiKey, secretRef, skipTLSVerify)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	spin := newSpinner("Checking TrueNAS compatibility...")
+	client, err := connectToTrueNAS(ctx, cfg)
+	if err != nil {
+		spin.stop()
+		return err
+	}
+	defer client.Close()
+
+	report := checkCompatibility(ctx, client, parent, selected, time.Now().Unix())
+	spin.stop()
+
+	if err := outputCompatReport(report, *outputFormat); err != nil {
+		return err
+	}
+	if !report.Passed() {
+		return errCompatFailed
+	}
+	return nil
+}
+
+// parseCompatProtocols validates a comma-separated protocol list.
+func parseCompatProtocols(value string) ([]string, error) {
+	var protocols []string
+	for _, protocol := range strings.Split(value, ",") {
+		protocol = strings.ToLower(strings.TrimSpace(protocol))
+		if protocol == "" || slices.Contains(protocols, protocol) {
+			continue
+		}
+		if !slices.Contains(compatProtocols, protocol) {
+			return nil, fmt.Errorf("%w %q: want one of %s", errCompatUnknown, protocol, strings.Join(compatProtocols, ", "))
+		}
+		protocols = append(protocols, protocol)
+	}
+	return protocols, nil
+}
+
+// compatChecker runs the checks below one temporary dataset.
+type compatChecker struct {
+	client tnsapi.ClientInterface
+	report *CompatReport
+	root   string // Temporary dataset
+	suffix string // Unique suffix of share, target and subsystem names
+}
+
+// checkCompatibility runs the checks of protocols in a temporary dataset below parent, named
+// after id, and deletes it again.
+func checkCompatibility(ctx context.Context, client tnsapi.ClientInterface, parent string, protocols []string, id int64) *CompatReport {
+	c := &compatChecker{
+		client: client,
+		root:   fmt.Sprintf("%s/tns-csi-compat-%d", parent, id),
+		suffix: fmt.Sprintf("tns-csi-compat-%d", id),
+	}
+	c.report = &CompatReport{Dataset: c.root}
+
+	if _, err := client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: c.root, Type: "FILESYSTEM"}); err != nil {
+		for _, protocol := range protocols {
+			c.record(protocol, compatDataset, fmt.Errorf("failed to create %s: %w", c.root, err))
+			c.skip(protocol, compatSnapshot)
+			c.skip(protocol, compatExport)
+		}
+		return c.report
+	}
+
+	for _, protocol := range protocols {
+		c.checkProtocol(ctx, protocol)
+	}
+
+	if err := client.DeleteDatasetWithOptions(ctx, c.root, tnsapi.DatasetDeleteOptions{Recursive: true, Force: true}); err != nil {
+		c.report.CleanupError = fmt.Sprintf("failed to delete %s: %v", c.root, err)
+	}
+	return c.report
+}
+
+// checkProtocol runs the check families of one protocol; later ones are skipped if the dataset
+// check fails.
+func (c *compatChecker) checkProtocol(ctx context.Context, protocol string) {
+	dataset := c.root + "/" + protocol
+	block := protocol == protocolNVMeOF || protocol == protocolISCSI
+
+	if err := c.checkDataset(ctx, dataset, protocol, block); err != nil {
+		c.record(protocol, compatDataset, err)
+		c.skip(protocol, compatSnapshot)
+		c.skip(protocol, compatExport)
+		return
+	}
+	c.record(protocol, compatDataset, nil)
+	c.record(protocol, compatSnapshot, c.checkSnapshot(ctx, dataset))
+
+	var err error
+	switch protocol {
+	case protocolNFS:
+		err = c.checkNFSShare(ctx, dataset)
+	case protocolSMB:
+		err = c.checkSMBShare(ctx, dataset)
+	case protocolNVMeOF:
+		err = c.checkNVMeOFTarget(ctx, dataset)
+	case protocolISCSI:
+		err = c.checkISCSITarget(ctx, dataset)
+	}
+	c.record(protocol, compatExport, err)
+}
+
+func (c *compatChecker) record(protocol, family string, err error) {
+	check := CompatCheck{Protocol: protocol, Family: family, Result: compatPass}
+	if err != nil {
+		check.Result = compatFail
+		check.Error = err.Error()
+	}
+	c.report.Checks = append(c.report.Checks, check)
+}
+
+func (c *compatChecker) skip(protocol, family string) {
+	c.report.Checks = append(c.report.Checks, CompatCheck{Protocol: protocol, Family: family, Result: compatSkip})
+}
+
+// checkDataset creates the dataset or ZVOL of a protocol and round-trips a user property.
+func (c *compatChecker) checkDataset(ctx context.Context, dataset, protocol string, block bool) error {
+	var err error
+	switch {
+	case block:
+		_, err = c.client.CreateZvol(ctx, tnsapi.ZvolCreateParams{Name: dataset, Type: "VOLUME", Volsize: compatZvolSize})
+	case protocol == protocolSMB:
+		_, err = c.client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: dataset, Type: "FILESYSTEM", ShareType: "SMB"})
+	default:
+		_, err = c.client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: dataset, Type: "FILESYSTEM"})
+	}
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+
+	if err := c.client.SetDatasetProperties(ctx, dataset, map[string]string{compatProperty: "true"}); err != nil {
+		return fmt.Errorf("set property: %w", err)
+	}
+	props, err := c.client.GetDatasetProperties(ctx, dataset, []string{compatProperty})
+	if err != nil {
+		return fmt.Errorf("get property: %w", err)
+	}
+	if props[compatProperty] != "true" {
+		return fmt.Errorf("get property: %w", errCompatPropertyLost)
+	}
+	return nil
+}
+
+// checkSnapshot snapshots a dataset, clones the snapshot and deletes both.
+func (c *compatChecker) checkSnapshot(ctx context.Context, dataset string) error {
+	snapshot, err := c.client.CreateSnapshot(ctx, tnsapi.SnapshotCreateParams{Dataset: dataset, Name: "compat"})
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	clone := dataset + "-clone"
+	if _, err := c.client.CloneSnapshot(ctx, tnsapi.CloneSnapshotParams{Snapshot: snapshot.ID, Dataset: clone}); err != nil {
+		return fmt.Errorf("clone snapshot: %w", err)
+	}
+	if err := c.client.DeleteDataset(ctx, clone); err != nil {
+		return fmt.Errorf("delete clone: %w", err)
+	}
+	if err := c.client.DeleteSnapshot(ctx, snapshot.ID); err != nil {
+		return fmt.Errorf("delete snapshot: %w", err)
+	}
+	return nil
+}
+
+func (c *compatChecker) checkNFSShare(ctx context.Context, dataset string) error {
+	share, err := c.client.CreateNFSShare(ctx, tnsapi.NFSShareCreateParams{
+		Path:    "/mnt/" + dataset,
+		Comment: c.suffix,
+		Enabled: true,
+	})
+	if err != nil {
+		return fmt.Errorf("create share: %w", err)
+	}
+	if _, err := c.client.QueryNFSShareByID(ctx, share.ID); err != nil {
+		c.client.DeleteNFSShare(ctx, share.ID) //nolint:errcheck,gosec // Already failing
+		return fmt.Errorf("query share: %w", err)
+	}
+	if err := c.client.DeleteNFSShare(ctx, share.ID); err != nil {
+		return fmt.Errorf("delete share: %w", err)
+	}
+	return nil
+}
+
+func (c *compatChecker) checkSMBShare(ctx context.Context, dataset string) error {
+	share, err := c.client.CreateSMBShare(ctx, tnsapi.SMBShareCreateParams{
+		Name:    c.suffix,
+		Path:    "/mnt/" + dataset,
+		Comment: c.suffix,
+		Enabled: true,
+	})
+	if err != nil {
+		return fmt.Errorf("create share: %w", err)
+	}
+	if _, err := c.client.QuerySMBShareByID(ctx, share.ID); err != nil {
+		c.client.DeleteSMBShare(ctx, share.ID) //nolint:errcheck,gosec // Already failing
+		return fmt.Errorf("query share: %w", err)
+	}
+	if err := c.client.DeleteSMBShare(ctx, share.ID); err != nil {
+		return fmt.Errorf("delete share: %w", err)
+	}
+	return nil
+}
+
+// checkNVMeOFTarget exports a ZVOL as an NVMe-oF namespace on the first port and removes it.
+func (c *compatChecker) checkNVMeOFTarget(ctx context.Context, zvol string) (err error) {
+	ports, err := c.client.QueryNVMeOFPorts(ctx)
+	if err != nil {
+		return fmt.Errorf("query ports: %w", err)
+	}
+	if len(ports) == 0 {
+		return errCompatNoPort
+	}
+
+	nqn := "nqn.2011-06.com.truenas:" + c.suffix
+	subsystem, err := c.client.CreateNVMeOFSubsystem(ctx, tnsapi.NVMeOFSubsystemCreateParams{Name: nqn, Subnqn: nqn, AllowAnyHost: true})
+	if err != nil {
+		return fmt.Errorf("create subsystem: %w", err)
+	}
+	defer func() {
+		if deleteErr := c.client.DeleteNVMeOFSubsystem(ctx, subsystem.ID); deleteErr != nil && err == nil {
+			err = fmt.Errorf("delete subsystem: %w", deleteErr)
+		}
+	}()
+
+	namespace, err := c.client.CreateNVMeOFNamespace(ctx, tnsapi.NVMeOFNamespaceCreateParams{
+		SubsysID:   subsystem.ID,
+		DevicePath: "zvol/" + zvol,
+		DeviceType: "ZVOL",
+	})
+	if err != nil {
+		return fmt.Errorf("create namespace: %w", err)
+	}
+	defer func() {
+		if deleteErr := c.client.DeleteNVMeOFNamespace(ctx, namespace.ID); deleteErr != nil && err == nil {
+			err = fmt.Errorf("delete namespace: %w", deleteErr)
+		}
+	}()
+
+	if err := c.client.AddSubsystemToPort(ctx, subsystem.ID, ports[0].ID); err != nil {
+		return fmt.Errorf("bind port: %w", err)
+	}
+	bindings, err := c.client.QuerySubsystemPortBindings(ctx, subsystem.ID)
+	if err != nil {
+		return fmt.Errorf("query port bindings: %w", err)
+	}
+	if len(bindings) == 0 {
+		return fmt.Errorf("query port bindings: %w", errCompatNoBinding)
+	}
+	for _, binding := range bindings {
+		if err := c.client.RemoveSubsystemFromPort(ctx, binding.ID); err != nil {
+			return fmt.Errorf("unbind port: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkISCSITarget exports a ZVOL as an iSCSI target and removes it.
+func (c *compatChecker) checkISCSITarget(ctx context.Context, zvol string) (err error) {
+	target, err := c.client.CreateISCSITarget(ctx, tnsapi.ISCSITargetCreateParams{Name: c.suffix, Mode: "ISCSI"})
+	if err != nil {
+		return fmt.Errorf("create target: %w", err)
+	}
+	defer func() {
+		if deleteErr := c.client.DeleteISCSITarget(ctx, target.ID, true); deleteErr != nil && err == nil {
+			err = fmt.Errorf("delete target: %w", deleteErr)
+		}
+	}()
+
+	extent, err := c.client.CreateISCSIExtent(ctx, tnsapi.ISCSIExtentCreateParams{Name: c.suffix, Type: "DISK", Disk: "zvol/" + zvol})
+	if err != nil {
+		return fmt.Errorf("create extent: %w", err)
+	}
+	defer func() {
+		if deleteErr := c.client.DeleteISCSIExtent(ctx, extent.ID, false, true); deleteErr != nil && err == nil {
+			err = fmt.Errorf("delete extent: %w", deleteErr)
+		}
+	}()
+
+	targetExtent, err := c.client.CreateISCSITargetExtent(ctx, tnsapi.ISCSITargetExtentCreateParams{Target: target.ID, Extent: extent.ID})
+	if err != nil {
+		return fmt.Errorf("create target-extent: %w", err)
+	}
+	if err := c.client.DeleteISCSITargetExtent(ctx, targetExtent.ID, true); err != nil {
+		return fmt.Errorf("delete target-extent: %w", err)
+	}
+	return nil
+}
+
+// outputCompatReport outputs the compatibility report in the specified format.
+func outputCompatReport(report *CompatReport, format string) error {
+	switch format {
+	case outputFormatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+
+	case outputFormatYAML:
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		return enc.Encode(report)
+
+	case outputFormatTable, "":
+		outputCompatReportTable(report)
+		return nil
+
+	default:
+		return fmt.Errorf("%w: %s", errUnknownOutputFormat, format)
+	}
+}
+
+// outputCompatReportTable prints the pass/fail matrix and the errors of failed checks.
+func outputCompatReportTable(report *CompatReport) {
+	results := make(map[string]map[string]string)
+	var protocols []string
+	var failures []CompatCheck
+	for _, check := range report.Checks {
+		if results[check.Protocol] == nil {
+			results[check.Protocol] = make(map[string]string)
+			protocols = append(protocols, check.Protocol)
+		}
+		results[check.Protocol][check.Family] = check.Result
+		if check.Result == compatFail {
+			failures = append(failures, check)
+		}
+	}
+
+	colorHeader.Printf("=== Compatibility Matrix (%s) ===\n", report.Dataset) //nolint:errcheck,gosec
+	t := newStyledTable()
+	header := table.Row{"PROTOCOL"}
+	for _, family := range compatFamilies {
+		header = append(header, strings.ToUpper(family))
+	}
+	t.AppendHeader(append(header, "RESULT"))
+	for _, protocol := range protocols {
+		row := table.Row{protocolBadge(protocol)}
+		for _, family := range compatFamilies {
+			row = append(row, compatResultBadge(results[protocol][family]))
+		}
+		t.AppendRow(append(row, compatResultBadge(report.protocolResult(protocol))))
+	}
+	renderTable(t)
+
+	for _, check := range failures {
+		fmt.Printf("%s %s %s: %s\n", colorError.Sprint(iconError), check.Protocol, check.Family, check.Error)
+	}
+	if report.CleanupError != "" {
+		fmt.Printf("%s cleanup: %s\n", colorWarning.Sprint(iconWarning), report.CleanupError)
+	}
+}
+
+// compatResultBadge returns a colored check result.
+func compatResultBadge(result string) string {
+	switch result {
+	case compatPass:
+		return colorSuccess.Sprint("PASS")
+	case compatFail:
+		return colorError.Sprint("FAIL")
+	default:
+		return colorMuted.Sprint("SKIP")
+	}
+}
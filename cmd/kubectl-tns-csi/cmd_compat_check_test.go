@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"github.com/fenio/tns-csi/pkg/tnsapi/tnsapitest"
+)
+
+func TestParseCompatProtocols(t *testing.T) {
+	got, err := parseCompatProtocols(" NFS,nvmeof,,nfs ")
+	if err != nil || len(got) != 2 || got[0] != protocolNFS || got[1] != protocolNVMeOF {
+		t.Errorf("parseCompatProtocols() = %v, %v; want [nfs nvmeof]", got, err)
+	}
+	if _, err := parseCompatProtocols("nfs,fc"); !errors.Is(err, errCompatUnknown) {
+		t.Errorf("parseCompatProtocols(fc) error = %v, want %v", err, errCompatUnknown)
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	srv := tnsapitest.NewServer()
+	t.Cleanup(srv.Close)
+	client, err := tnsapi.NewClient(srv.URL(), tnsapitest.APIKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	ctx := context.Background()
+
+	// The fake server does not emulate iSCSI, so its export check fails
+	report := checkCompatibility(ctx, client, "tank", compatProtocols, 42)
+
+	if report.Dataset != "tank/tns-csi-compat-42" {
+		t.Errorf("Dataset = %q", report.Dataset)
+	}
+	for _, protocol := range []string{protocolNFS, protocolSMB, protocolNVMeOF} {
+		if got := report.protocolResult(protocol); got != compatPass {
+			t.Errorf("%s = %s, want pass: %+v", protocol, got, report.Checks)
+		}
+	}
+	if got := report.protocolResult(protocolISCSI); got != compatFail {
+		t.Errorf("iscsi = %s, want fail", got)
+	}
+	for _, check := range report.Checks {
+		if check.Protocol == protocolISCSI && check.Family != compatExport && check.Result != compatPass {
+			t.Errorf("iscsi %s = %s (%s), want pass", check.Family, check.Result, check.Error)
+		}
+	}
+	if report.Passed() {
+		t.Error("Passed() = true with a failed check")
+	}
+	if report.CleanupError != "" {
+		t.Errorf("CleanupError = %s", report.CleanupError)
+	}
+
+	// Nothing is left behind
+	if _, err := client.Dataset(ctx, report.Dataset); err == nil {
+		t.Errorf("%s still exists", report.Dataset)
+	}
+	if shares, err := client.QueryAllNFSShares(ctx, ""); err != nil || len(shares) != 0 {
+		t.Errorf("NFS shares = %v, %v; want none", shares, err)
+	}
+	if subsystems, err := client.ListAllNVMeOFSubsystems(ctx); err != nil || len(subsystems) != 0 {
+		t.Errorf("NVMe-oF subsystems = %v, %v; want none", subsystems, err)
+	}
+}
+
+func TestCheckCompatibilityWithoutParent(t *testing.T) {
+	srv := tnsapitest.NewServer()
+	t.Cleanup(srv.Close)
+	client, err := tnsapi.NewClient(srv.URL(), tnsapitest.APIKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	report := checkCompatibility(context.Background(), client, "missing/k8s", []string{protocolNFS}, 1)
+	want := []string{compatFail, compatSkip, compatSkip}
+	if len(report.Checks) != len(want) {
+		t.Fatalf("Checks = %+v, want %d", report.Checks, len(want))
+	}
+	for i, check := range report.Checks {
+		if check.Result != want[i] {
+			t.Errorf("%s = %s, want %s", check.Family, check.Result, want[i])
+		}
+	}
+}
@@ -134,6 +134,18 @@ func describeKV(key, value string) {
 	fmt.Printf("  %s  %s\n", colorMuted.Sprintf("%-18s", key+":"), value)
 }
 
+// describeNodeUsage formats the use of a volume on a node, e.g. "worker-1: default/web-0 (Running)".
+func describeNodeUsage(usage *K8sNodeUsage) string {
+	parts := make([]string, 0, len(usage.Pods)+1)
+	for _, pod := range usage.Pods {
+		parts = append(parts, fmt.Sprintf("%s (%s)", pod.Name, pod.Phase))
+	}
+	if usage.Attachment != "" {
+		parts = append(parts, "VolumeAttachment "+usage.Attachment)
+	}
+	return usage.Node + ": " + strings.Join(parts, ", ")
+}
+
 // outputVolumeDetailsTable outputs volume details in table/text format.
 func outputVolumeDetailsTable(details *VolumeDetails) error {
 	// Header
@@ -165,6 +177,9 @@ func outputVolumeDetailsTable(details *VolumeDetails) error {
 		} else {
 			describeKV("Pods", colorMuted.Sprint("none"))
 		}
+		for i := range details.K8s.Nodes {
+			describeKV("Node", describeNodeUsage(&details.K8s.Nodes[i]))
+		}
 		fmt.Println()
 	}
 
@@ -172,6 +187,12 @@ func outputVolumeDetailsTable(details *VolumeDetails) error {
 	colorHeader.Println("=== Capacity ===") //nolint:errcheck,gosec
 	describeKV("Provisioned", fmt.Sprintf("%s (%d bytes)", details.CapacityHuman, details.CapacityBytes))
 	describeKV("Used", fmt.Sprintf("%s (%d bytes)", details.UsedHuman, details.UsedBytes))
+	if details.LogicalUsedHuman != "" {
+		describeKV("Logical Used", fmt.Sprintf("%s (%d bytes)", details.LogicalUsedHuman, details.LogicalUsedBytes))
+	}
+	describeKV("Compression Ratio", details.CompressRatioHuman())
+	describeKV("Compression Saves", details.CompressSavingsHuman())
+	describeKV("Dedup Saves", details.DedupHuman())
 	fmt.Println()
 
 	// Metadata
@@ -12,7 +12,7 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-func newHealthCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool) *cobra.Command {
+func newHealthCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, controllerRef *string) *cobra.Command {
 	var showAll bool
 
 	cmd := &cobra.Command{
@@ -37,7 +37,7 @@ Examples:
   # Output as JSON
   kubectl tns-csi health -o json`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runHealth(cmd.Context(), url, apiKey, secretRef, outputFormat, skipTLSVerify, showAll)
+			return runHealth(cmd.Context(), url, apiKey, secretRef, outputFormat, skipTLSVerify, controllerRef, showAll)
 		},
 	}
 
@@ -45,7 +45,15 @@ Examples:
 	return cmd
 }
 
-func runHealth(ctx context.Context, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, showAll bool) error {
+func runHealth(ctx context.Context, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, controllerRef *string, showAll bool) error {
+	if *controllerRef != "" {
+		var report HealthReport
+		if err := fetchFromController(ctx, *controllerRef, dashboard.AdminHealth, &report); err != nil {
+			return err
+		}
+		return outputHealthReport(&report, *outputFormat, showAll)
+	}
+
 	// Get connection config
 	cfg, err := getConnectionConfig(ctx, url, apiKey, secretRef, skipTLSVerify)
 	if err != nil {
@@ -287,13 +287,38 @@ func handleISCSIImport(ctx context.Context, client tnsapi.ClientInterface, datas
 		return nil, fmt.Errorf("%w: dataset type is %s", errISCSIRequiresZvol, dataset.Type)
 	}
 
+	target, extent, fullIQN, err := lookupISCSIZvol(ctx, client, dataset.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		fmt.Printf("DRY RUN - Found iSCSI resources:\n")
+		fmt.Printf("  Extent: %s (ID: %d)\n", extent.Name, extent.ID)
+		fmt.Printf("  Target: %s (ID: %d)\n", target.Name, target.ID)
+		fmt.Printf("  IQN: %s\n", fullIQN)
+		return props, nil
+	}
+
+	props[tnsapi.PropertyISCSIIQN] = fullIQN
+	props[tnsapi.PropertyISCSITargetID] = strconv.Itoa(target.ID)
+	props[tnsapi.PropertyISCSIExtentID] = strconv.Itoa(extent.ID)
+	props["_iscsi_target_id"] = strconv.Itoa(target.ID)
+	props["_iscsi_extent_id"] = strconv.Itoa(extent.ID)
+
+	fmt.Printf("Found iSCSI target: %s (IQN: %s)\n", target.Name, fullIQN)
+	return props, nil
+}
+
+// lookupISCSIZvol finds the iSCSI extent and target exporting a zvol, and the target's full IQN.
+func lookupISCSIZvol(ctx context.Context, client tnsapi.ClientInterface, zvolID string) (*tnsapi.ISCSITarget, *tnsapi.ISCSIExtent, string, error) {
 	// Get zvol path for extent lookup (format: zvol/pool/path)
-	zvolPath := "zvol/" + dataset.ID
+	zvolPath := "zvol/" + zvolID
 
 	// Find existing extent for this zvol
 	extents, err := client.QueryISCSIExtents(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query iSCSI extents: %w", err)
+		return nil, nil, "", fmt.Errorf("failed to query iSCSI extents: %w", err)
 	}
 
 	var extent *tnsapi.ISCSIExtent
@@ -305,7 +330,7 @@ func handleISCSIImport(ctx context.Context, client tnsapi.ClientInterface, datas
 	}
 
 	if extent == nil {
-		return nil, fmt.Errorf("%w: %s", errNoISCSIExtent, zvolPath)
+		return nil, nil, "", fmt.Errorf("%w: %s", errNoISCSIExtent, zvolPath)
 	}
 
 	// Find target-extent association
@@ -313,11 +338,11 @@ func handleISCSIImport(ctx context.Context, client tnsapi.ClientInterface, datas
 		[]interface{}{"extent", "=", extent.ID},
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to query target-extent associations: %w", err)
+		return nil, nil, "", fmt.Errorf("failed to query target-extent associations: %w", err)
 	}
 
 	if len(targetExtents) == 0 {
-		return nil, fmt.Errorf("%w: extent ID %d", errNoISCSITargetAssoc, extent.ID)
+		return nil, nil, "", fmt.Errorf("%w: extent ID %d", errNoISCSITargetAssoc, extent.ID)
 	}
 
 	targetExtent := targetExtents[0]
@@ -327,7 +352,7 @@ func handleISCSIImport(ctx context.Context, client tnsapi.ClientInterface, datas
 		[]interface{}{"id", "=", targetExtent.Target},
 	})
 	if err != nil || len(targets) == 0 {
-		return nil, fmt.Errorf("failed to get target %d: %w", targetExtent.Target, err)
+		return nil, nil, "", fmt.Errorf("failed to get target %d: %w", targetExtent.Target, err)
 	}
 
 	target := targets[0]
@@ -335,28 +360,11 @@ func handleISCSIImport(ctx context.Context, client tnsapi.ClientInterface, datas
 	// Get global config for base IQN
 	globalConfig, err := client.GetISCSIGlobalConfig(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get iSCSI global config: %w", err)
+		return nil, nil, "", fmt.Errorf("failed to get iSCSI global config: %w", err)
 	}
 
 	// Build full IQN
-	fullIQN := globalConfig.Basename + ":" + target.Name
-
-	if dryRun {
-		fmt.Printf("DRY RUN - Found iSCSI resources:\n")
-		fmt.Printf("  Extent: %s (ID: %d)\n", extent.Name, extent.ID)
-		fmt.Printf("  Target: %s (ID: %d)\n", target.Name, target.ID)
-		fmt.Printf("  IQN: %s\n", fullIQN)
-		return props, nil
-	}
-
-	props[tnsapi.PropertyISCSIIQN] = fullIQN
-	props[tnsapi.PropertyISCSITargetID] = strconv.Itoa(target.ID)
-	props[tnsapi.PropertyISCSIExtentID] = strconv.Itoa(extent.ID)
-	props["_iscsi_target_id"] = strconv.Itoa(target.ID)
-	props["_iscsi_extent_id"] = strconv.Itoa(extent.ID)
-
-	fmt.Printf("Found iSCSI target: %s (IQN: %s)\n", target.Name, fullIQN)
-	return props, nil
+	return &target, extent, globalConfig.Basename + ":" + target.Name, nil
 }
 
 func handleSMBImport(ctx context.Context, client tnsapi.ClientInterface, dataset *tnsapi.Dataset, dryRun bool) (map[string]string, error) {
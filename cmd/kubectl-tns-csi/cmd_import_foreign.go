@@ -0,0 +1,657 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// Static errors for import-foreign command.
+var (
+	errUnsupportedForeignSource = errors.New("unsupported source driver: only 'democratic-csi' is supported")
+	errForeignProtocolUnknown   = errors.New("cannot determine protocol")
+	errNoNFSShareForDataset     = errors.New("no NFS share found for dataset mountpoint")
+	errNoNVMeNamespaceForZvol   = errors.New("no NVMe-oF namespace found for zvol")
+)
+
+// democratic-csi stores its volume metadata in ZFS user properties with this prefix.
+const (
+	foreignSourceDemocraticCSI = "democratic-csi"
+
+	democraticPropPrefix            = "democratic-csi:"
+	democraticPropVolumeName        = "democratic-csi:csi_volume_name"
+	democraticPropVolumeContext     = "democratic-csi:csi_share_volume_context"
+	democraticPropProvisionerDriver = "democratic-csi:volume_context_provisioner_driver"
+	democraticPropNFSShareID        = "democratic-csi:freenas_nfs_share_id"
+	democraticPropSMBShareID        = "democratic-csi:freenas_smb_share_id"
+	democraticPropISCSITargetID     = "democratic-csi:freenas_iscsi_target_id"
+	democraticPropISCSIExtentID     = "democratic-csi:freenas_iscsi_extent_id"
+
+	detectionProperties   = "properties"
+	detectionShareComment = "share comment"
+)
+
+// democraticVolumeContext is the volume context democratic-csi stores as JSON in
+// the csi_share_volume_context property.
+type democraticVolumeContext struct {
+	NodeAttachDriver string `json:"node_attach_driver"`
+	Server           string `json:"server"`
+	Share            string `json:"share"`
+	IQN              string `json:"iqn"`
+	NQN              string `json:"nqn"`
+}
+
+// ForeignVolume is a volume provisioned by another CSI driver and its tns-csi import.
+//
+//nolint:govet // field alignment not critical for CLI output struct
+type ForeignVolume struct {
+	Dataset       string            `json:"dataset"                yaml:"dataset"`
+	Source        string            `json:"source"                 yaml:"source"`
+	Detection     string            `json:"detection"              yaml:"detection"`
+	VolumeName    string            `json:"volumeName"             yaml:"volumeName"`
+	Protocol      string            `json:"protocol"               yaml:"protocol"`
+	PVName        string            `json:"pvName,omitempty"       yaml:"pvName,omitempty"`
+	PVCName       string            `json:"pvcName,omitempty"      yaml:"pvcName,omitempty"`
+	PVCNamespace  string            `json:"pvcNamespace,omitempty" yaml:"pvcNamespace,omitempty"`
+	StorageClass  string            `json:"storageClass,omitempty" yaml:"storageClass,omitempty"`
+	AccessMode    string            `json:"accessMode,omitempty"   yaml:"accessMode,omitempty"`
+	CapacityBytes int64             `json:"capacityBytes"          yaml:"capacityBytes"`
+	Properties    map[string]string `json:"properties,omitempty"   yaml:"properties,omitempty"`
+	Manifests     string            `json:"manifests,omitempty"    yaml:"manifests,omitempty"`
+	Imported      bool              `json:"imported"               yaml:"imported"`
+	Message       string            `json:"message,omitempty"      yaml:"message,omitempty"`
+
+	// Protocol resources resolved on TrueNAS
+	mountpoint  string
+	sharePath   string
+	shareName   string
+	iqn         string
+	nqn         string
+	shareID     int
+	targetID    int
+	extentID    int
+	subsystemID int
+	namespaceID int
+}
+
+func newImportForeignCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID *string) *cobra.Command {
+	var (
+		pool         string
+		parentPath   string
+		source       string
+		namespace    string
+		storageClass string
+		dryRun       bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "import-foreign",
+		Short: "Migrate volumes provisioned by democratic-csi to tns-csi in place",
+		Long: `Scan TrueNAS for volumes provisioned by another CSI driver, rewrite their
+metadata to tns-csi conventions and emit static PV/PVC manifests, so workloads
+can move to tns-csi without copying any data.
+
+Volumes are recognized by democratic-csi's ZFS user properties, or by NFS/SMB
+share comments mentioning democratic-csi when the properties are missing.
+For each volume the command:
+  1. Resolves its NFS/SMB share, iSCSI target or NVMe-oF namespace on TrueNAS
+  2. Looks up the existing PV/PVC in Kubernetes (best effort) for names,
+     StorageClass, access mode and capacity
+  3. Sets tns-csi properties (managed_by, csi_volume_name, cluster_id, ...)
+     with delete_strategy=retain; democratic-csi properties are left untouched
+  4. Prints a PV/PVC pair bound to the dataset
+
+Before applying the manifests, scale down the workload, set the old PV's
+reclaim policy to Retain and delete the old PVC and PV (see docs/ADOPTION.md).
+
+Examples:
+  # Preview what would be migrated
+  kubectl tns-csi import-foreign --pool storage --dry-run
+
+  # Migrate volumes under a parent dataset and save the manifests
+  kubectl tns-csi import-foreign --parent storage/k8s > migrated.yaml
+
+  # Tag migrated volumes with a cluster ID
+  kubectl tns-csi import-foreign --pool storage --cluster-id prod-east`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runImportForeign(cmd.Context(), url, apiKey, secretRef, outputFormat, skipTLSVerify, clusterID,
+				pool, parentPath, source, namespace, storageClass, dryRun)
+		},
+	}
+
+	cmd.Flags().StringVar(&pool, "pool", "", "ZFS pool to search in (required if --parent not specified)")
+	cmd.Flags().StringVar(&parentPath, "parent", "", "Parent dataset path to search under")
+	cmd.Flags().StringVar(&source, "source", foreignSourceDemocraticCSI, "Driver that provisioned the volumes")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", defaultNamespace, "Namespace for PVCs without an existing Kubernetes PVC")
+	cmd.Flags().StringVar(&storageClass, "storage-class", "", "StorageClass for the new PVs (defaults to the existing PV's)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be done without making changes")
+
+	return cmd
+}
+
+//nolint:gocyclo // sequential discovery, resolution and import steps are easier to follow in one place
+func runImportForeign(ctx context.Context, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID *string,
+	pool, parentPath, source, namespace, storageClass string, dryRun bool) error {
+
+	if source != foreignSourceDemocraticCSI {
+		return fmt.Errorf("%w: %s", errUnsupportedForeignSource, source)
+	}
+	if pool == "" && parentPath == "" {
+		return errPoolOrParentMissing
+	}
+	searchPath := parentPath
+	if searchPath == "" {
+		searchPath = pool
+	}
+
+	// Get connection config
+	cfg, err := getConnectionConfig(ctx, url, apiKey, secretRef, skipTLSVerify)
+	if err != nil {
+		return err
+	}
+
+	// Connect to TrueNAS
+	client, err := connectToTrueNAS(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	volumes, err := findForeignVolumes(ctx, client, searchPath)
+	if err != nil {
+		return err
+	}
+	if len(volumes) == 0 {
+		fmt.Printf("No %s volumes found under %s\n", source, searchPath)
+		return nil
+	}
+
+	pvs := listForeignPVs(ctx)
+	createdAt := time.Now().UTC().Format(time.RFC3339)
+
+	for _, vol := range volumes {
+		if err := resolveForeignVolume(ctx, client, vol); err != nil {
+			vol.Message = err.Error()
+			continue
+		}
+
+		applyForeignPV(vol, pvs[vol.VolumeName], namespace)
+		if storageClass != "" {
+			vol.StorageClass = storageClass
+		}
+		vol.Properties = foreignVolumeProperties(vol, *clusterID, createdAt)
+
+		manifests, err := generateAdoptionManifests(foreignAdoptionInfo(vol), cfg.URL)
+		if err != nil {
+			vol.Message = "failed to generate manifests: " + err.Error()
+			continue
+		}
+		vol.Manifests = manifests
+
+		if dryRun {
+			vol.Message = "Dry run - no changes made"
+			continue
+		}
+		if err := client.SetDatasetProperties(ctx, vol.Dataset, vol.Properties); err != nil {
+			vol.Message = "failed to set properties: " + err.Error()
+			vol.Manifests = ""
+			continue
+		}
+		vol.Imported = true
+		vol.Message = "Volume imported successfully"
+	}
+
+	return outputForeignVolumes(volumes, *outputFormat, dryRun)
+}
+
+// findForeignVolumes finds datasets provisioned by democratic-csi that tns-csi does not manage yet.
+func findForeignVolumes(ctx context.Context, client tnsapi.ClientInterface, searchPath string) ([]*ForeignVolume, error) {
+	datasets, err := client.FindDatasetsByProperty(ctx, searchPath, democraticPropVolumeName, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query datasets: %w", err)
+	}
+
+	var volumes []*ForeignVolume
+	seen := make(map[string]bool)
+	for i := range datasets {
+		seen[datasets[i].ID] = true
+		if isTNSCSIManaged(datasets[i].UserProperties) {
+			continue
+		}
+		if vol := detectDemocraticVolume(&datasets[i]); vol != nil {
+			volumes = append(volumes, vol)
+		}
+	}
+
+	// Fall back to share comments for volumes whose properties were lost (e.g. replicated datasets)
+	allDatasets, err := client.QueryAllDatasets(ctx, searchPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query datasets: %w", err)
+	}
+	//nolint:errcheck // share comments are only a fallback
+	nfsShares, _ := client.QueryAllNFSShares(ctx, "")
+	//nolint:errcheck // share comments are only a fallback
+	smbShares, _ := client.QueryAllSMBShares(ctx, "")
+
+	for _, vol := range detectFromShareComments(allDatasets, nfsShares, smbShares, seen) {
+		props, err := client.GetAllDatasetProperties(ctx, vol.Dataset)
+		if err == nil && props[tnsapi.PropertyManagedBy] == tnsapi.ManagedByValue {
+			continue
+		}
+		volumes = append(volumes, vol)
+	}
+
+	return volumes, nil
+}
+
+// isTNSCSIManaged reports whether user properties mark a dataset as managed by tns-csi.
+func isTNSCSIManaged(props map[string]tnsapi.UserProperty) bool {
+	prop, ok := props[tnsapi.PropertyManagedBy]
+	return ok && prop.Value == tnsapi.ManagedByValue
+}
+
+// detectDemocraticVolume builds a ForeignVolume from democratic-csi's user properties,
+// or returns nil if the dataset has none.
+func detectDemocraticVolume(ds *tnsapi.DatasetWithProperties) *ForeignVolume {
+	found := false
+	for name := range ds.UserProperties {
+		if strings.HasPrefix(name, democraticPropPrefix) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	value := func(name string) string {
+		return ds.UserProperties[name].Value
+	}
+
+	vol := &ForeignVolume{
+		Dataset:    ds.ID,
+		Source:     foreignSourceDemocraticCSI,
+		Detection:  detectionProperties,
+		VolumeName: value(democraticPropVolumeName),
+		mountpoint: ds.Mountpoint,
+	}
+	if vol.VolumeName == "" {
+		vol.VolumeName = path.Base(ds.ID)
+	}
+
+	var volumeContext democraticVolumeContext
+	if raw := value(democraticPropVolumeContext); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &volumeContext); err != nil {
+			klog.V(4).Infof("Ignoring malformed %s on %s: %v", democraticPropVolumeContext, ds.ID, err)
+		}
+	}
+	vol.Protocol = democraticProtocol(volumeContext.NodeAttachDriver, value(democraticPropProvisionerDriver))
+
+	switch vol.Protocol {
+	case protocolNFS:
+		vol.sharePath = volumeContext.Share
+		vol.shareID = tnsapi.StringToInt(value(democraticPropNFSShareID))
+	case protocolSMB:
+		vol.shareName = volumeContext.Share
+		vol.shareID = tnsapi.StringToInt(value(democraticPropSMBShareID))
+	case protocolISCSI:
+		vol.iqn = volumeContext.IQN
+		vol.targetID = tnsapi.StringToInt(value(democraticPropISCSITargetID))
+		vol.extentID = tnsapi.StringToInt(value(democraticPropISCSIExtentID))
+	case protocolNVMeOF:
+		vol.nqn = volumeContext.NQN
+	}
+
+	if ds.Type == datasetTypeVolume && ds.Volsize != nil {
+		if size, ok := ds.Volsize["parsed"].(float64); ok {
+			vol.CapacityBytes = int64(size)
+		}
+	}
+
+	return vol
+}
+
+// democraticProtocol maps democratic-csi's node attach driver (or, failing that, its
+// provisioner driver name such as "freenas-api-nfs") to a tns-csi protocol.
+func democraticProtocol(hints ...string) string {
+	for _, hint := range hints {
+		hint = strings.ToLower(hint)
+		switch {
+		case strings.Contains(hint, "nvmeof"):
+			return protocolNVMeOF
+		case strings.Contains(hint, "iscsi"):
+			return protocolISCSI
+		case strings.Contains(hint, "smb"):
+			return protocolSMB
+		case strings.Contains(hint, "nfs"):
+			return protocolNFS
+		}
+	}
+	return ""
+}
+
+// detectFromShareComments finds datasets exported by NFS/SMB shares whose comment mentions
+// democratic-csi. Datasets in skip are already known.
+func detectFromShareComments(datasets []tnsapi.Dataset, nfsShares []tnsapi.NFSShare, smbShares []tnsapi.SMBShare, skip map[string]bool) []*ForeignVolume {
+	byMountpoint := make(map[string]*tnsapi.Dataset)
+	for i := range datasets {
+		if datasets[i].Mountpoint != "" {
+			byMountpoint[datasets[i].Mountpoint] = &datasets[i]
+		}
+	}
+
+	mentionsSource := func(comment string) bool {
+		return strings.Contains(strings.ToLower(comment), foreignSourceDemocraticCSI)
+	}
+
+	var volumes []*ForeignVolume
+	add := func(sharePath string) *ForeignVolume {
+		ds, ok := byMountpoint[sharePath]
+		if !ok || skip[ds.ID] {
+			return nil
+		}
+		skip[ds.ID] = true
+		vol := &ForeignVolume{
+			Dataset:    ds.ID,
+			Source:     foreignSourceDemocraticCSI,
+			Detection:  detectionShareComment,
+			VolumeName: path.Base(ds.ID),
+			mountpoint: ds.Mountpoint,
+		}
+		volumes = append(volumes, vol)
+		return vol
+	}
+
+	for i := range nfsShares {
+		if mentionsSource(nfsShares[i].Comment) {
+			if vol := add(nfsShares[i].Path); vol != nil {
+				vol.Protocol = protocolNFS
+				vol.sharePath = nfsShares[i].Path
+				vol.shareID = nfsShares[i].ID
+			}
+		}
+	}
+	for i := range smbShares {
+		if mentionsSource(smbShares[i].Comment) {
+			if vol := add(smbShares[i].Path); vol != nil {
+				vol.Protocol = protocolSMB
+				vol.shareName = smbShares[i].Name
+				vol.shareID = smbShares[i].ID
+			}
+		}
+	}
+
+	return volumes
+}
+
+// resolveForeignVolume looks up the TrueNAS share or target that exports a volume,
+// preferring what TrueNAS reports over what the foreign driver recorded.
+func resolveForeignVolume(ctx context.Context, client tnsapi.ClientInterface, vol *ForeignVolume) error {
+	switch vol.Protocol {
+	case protocolNFS:
+		shares, err := client.QueryNFSShare(ctx, vol.mountpoint)
+		if err != nil {
+			return fmt.Errorf("failed to query NFS shares: %w", err)
+		}
+		if len(shares) == 0 {
+			return fmt.Errorf("%w: %s", errNoNFSShareForDataset, vol.mountpoint)
+		}
+		vol.shareID = shares[0].ID
+		vol.sharePath = shares[0].Path
+
+	case protocolSMB:
+		shares, err := client.QuerySMBShare(ctx, vol.mountpoint)
+		if err != nil {
+			return fmt.Errorf("failed to query SMB shares: %w", err)
+		}
+		if len(shares) == 0 {
+			return fmt.Errorf("%w: %s", errNoSMBShareForPath, vol.mountpoint)
+		}
+		vol.shareID = shares[0].ID
+		vol.shareName = shares[0].Name
+
+	case protocolISCSI:
+		target, extent, iqn, err := lookupISCSIZvol(ctx, client, vol.Dataset)
+		if err != nil {
+			return err
+		}
+		vol.targetID = target.ID
+		vol.extentID = extent.ID
+		vol.iqn = iqn
+
+	case protocolNVMeOF:
+		namespaces, err := client.QueryAllNVMeOFNamespaces(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to query NVMe-oF namespaces: %w", err)
+		}
+		devicePath := "zvol/" + vol.Dataset
+		for i := range namespaces {
+			if namespaces[i].GetDevice() == devicePath {
+				vol.namespaceID = namespaces[i].ID
+				vol.subsystemID = namespaces[i].GetSubsystemID()
+				if nqn := namespaces[i].GetSubsystemNQN(); nqn != "" {
+					vol.nqn = nqn
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s", errNoNVMeNamespaceForZvol, vol.Dataset)
+
+	default:
+		return fmt.Errorf("%w for %s", errForeignProtocolUnknown, vol.Dataset)
+	}
+	return nil
+}
+
+// foreignPV is the Kubernetes PV of a volume provisioned by another CSI driver.
+type foreignPV struct {
+	name          string
+	pvcName       string
+	pvcNamespace  string
+	storageClass  string
+	accessMode    string
+	capacityBytes int64
+}
+
+// listForeignPVs returns the PVs of other CSI drivers keyed by volume handle.
+// Returns an empty map if Kubernetes is unavailable.
+func listForeignPVs(ctx context.Context) map[string]foreignPV {
+	result := make(map[string]foreignPV)
+
+	k8sCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	k8sClient, err := getK8sClient()
+	if err != nil {
+		klog.V(4).Infof("Kubernetes lookup unavailable: %v", err)
+		return result
+	}
+	pvs, err := k8sClient.CoreV1().PersistentVolumes().List(k8sCtx, metav1.ListOptions{})
+	if err != nil {
+		klog.V(4).Infof("Failed to list PVs: %v", err)
+		return result
+	}
+
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver == "tns.csi.io" {
+			continue
+		}
+		info := foreignPV{
+			name:         pv.Name,
+			storageClass: pv.Spec.StorageClassName,
+		}
+		if pv.Spec.ClaimRef != nil {
+			info.pvcName = pv.Spec.ClaimRef.Name
+			info.pvcNamespace = pv.Spec.ClaimRef.Namespace
+		}
+		if len(pv.Spec.AccessModes) > 0 {
+			info.accessMode = string(pv.Spec.AccessModes[0])
+		}
+		if capacity, ok := pv.Spec.Capacity[corev1.ResourceStorage]; ok {
+			info.capacityBytes = capacity.Value()
+		}
+		result[pv.Spec.CSI.VolumeHandle] = info
+	}
+	return result
+}
+
+// applyForeignPV fills in Kubernetes-side details from the volume's existing PV, if any.
+func applyForeignPV(vol *ForeignVolume, pv foreignPV, defaultNS string) {
+	vol.PVName = pv.name
+	vol.PVCName = pv.pvcName
+	vol.PVCNamespace = pv.pvcNamespace
+	vol.StorageClass = pv.storageClass
+	vol.AccessMode = pv.accessMode
+	if pv.capacityBytes > 0 {
+		vol.CapacityBytes = pv.capacityBytes
+	}
+
+	if vol.PVCName == "" {
+		vol.PVCName = vol.VolumeName
+	}
+	if vol.PVCNamespace == "" {
+		vol.PVCNamespace = defaultNS
+	}
+	if vol.AccessMode == "" {
+		vol.AccessMode = "ReadWriteOnce"
+		if vol.Protocol == protocolNFS || vol.Protocol == protocolSMB {
+			vol.AccessMode = "ReadWriteMany"
+		}
+	}
+}
+
+// foreignVolumeProperties returns the tns-csi properties for an imported volume.
+// Imported volumes use the retain delete strategy so that a mistake during
+// migration cannot destroy data.
+func foreignVolumeProperties(vol *ForeignVolume, clusterID, createdAt string) map[string]string {
+	switch vol.Protocol {
+	case protocolNFS:
+		return tnsapi.NFSVolumePropertiesV1(tnsapi.NFSVolumeParams{
+			VolumeID:       vol.VolumeName,
+			CreatedAt:      createdAt,
+			DeleteStrategy: tnsapi.DeleteStrategyRetain,
+			SharePath:      vol.sharePath,
+			PVCName:        vol.PVCName,
+			PVCNamespace:   vol.PVCNamespace,
+			StorageClass:   vol.StorageClass,
+			ClusterID:      clusterID,
+			CapacityBytes:  vol.CapacityBytes,
+			ShareID:        vol.shareID,
+		})
+	case protocolSMB:
+		return tnsapi.SMBVolumePropertiesV1(tnsapi.SMBVolumeParams{
+			VolumeID:       vol.VolumeName,
+			CreatedAt:      createdAt,
+			DeleteStrategy: tnsapi.DeleteStrategyRetain,
+			ShareName:      vol.shareName,
+			PVCName:        vol.PVCName,
+			PVCNamespace:   vol.PVCNamespace,
+			StorageClass:   vol.StorageClass,
+			ClusterID:      clusterID,
+			CapacityBytes:  vol.CapacityBytes,
+			ShareID:        vol.shareID,
+		})
+	case protocolISCSI:
+		return tnsapi.ISCSIVolumePropertiesV1(tnsapi.ISCSIVolumeParams{
+			VolumeID:       vol.VolumeName,
+			CreatedAt:      createdAt,
+			DeleteStrategy: tnsapi.DeleteStrategyRetain,
+			TargetIQN:      vol.iqn,
+			PVCName:        vol.PVCName,
+			PVCNamespace:   vol.PVCNamespace,
+			StorageClass:   vol.StorageClass,
+			ClusterID:      clusterID,
+			CapacityBytes:  vol.CapacityBytes,
+			TargetID:       vol.targetID,
+			ExtentID:       vol.extentID,
+		})
+	default:
+		return tnsapi.NVMeOFVolumePropertiesV1(tnsapi.NVMeOFVolumeParams{
+			VolumeID:       vol.VolumeName,
+			CreatedAt:      createdAt,
+			DeleteStrategy: tnsapi.DeleteStrategyRetain,
+			SubsystemNQN:   vol.nqn,
+			PVCName:        vol.PVCName,
+			PVCNamespace:   vol.PVCNamespace,
+			StorageClass:   vol.StorageClass,
+			ClusterID:      clusterID,
+			CapacityBytes:  vol.CapacityBytes,
+			SubsystemID:    vol.subsystemID,
+			NamespaceID:    vol.namespaceID,
+		})
+	}
+}
+
+// foreignAdoptionInfo converts an imported volume to the input of generateAdoptionManifests.
+func foreignAdoptionInfo(vol *ForeignVolume) *adoptionVolumeInfo {
+	return &adoptionVolumeInfo{
+		volumeID:      vol.VolumeName,
+		dataset:       vol.Dataset,
+		protocol:      vol.Protocol,
+		pvcName:       vol.PVCName,
+		namespace:     vol.PVCNamespace,
+		storageClass:  vol.StorageClass,
+		accessMode:    vol.AccessMode,
+		nfsSharePath:  vol.sharePath,
+		nvmeNQN:       vol.nqn,
+		iscsiIQN:      vol.iqn,
+		smbShareName:  vol.shareName,
+		capacityBytes: vol.CapacityBytes,
+	}
+}
+
+func outputForeignVolumes(volumes []*ForeignVolume, format string, dryRun bool) error {
+	switch format {
+	case outputFormatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(volumes)
+
+	case outputFormatYAML:
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		return enc.Encode(volumes)
+
+	case outputFormatTable, "":
+		// Summary lines are YAML comments so the output can be applied as-is
+		failed := 0
+		for _, vol := range volumes {
+			if vol.Manifests == "" {
+				failed++
+				fmt.Printf("# SKIPPED %s: %s\n", vol.Dataset, vol.Message)
+				continue
+			}
+			verb := "Imported"
+			if dryRun {
+				verb = "Would import"
+			}
+			fmt.Printf("# %s %s (%s via %s, from %s) as %s/%s\n", verb, vol.Dataset, vol.Protocol,
+				vol.Source, vol.Detection, vol.PVCNamespace, vol.PVCName)
+		}
+		fmt.Printf("# %d volume(s) found, %d skipped\n", len(volumes), failed)
+		fmt.Println("# Delete the old PVCs and PVs (reclaim policy Retain!) before applying")
+		for _, vol := range volumes {
+			if vol.Manifests != "" {
+				fmt.Println("---")
+				fmt.Print(vol.Manifests)
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("%w: %s", errUnknownOutputFormat, format)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+)
+
+func TestDetectDemocraticVolume(t *testing.T) {
+	tests := []struct {
+		ds           tnsapi.DatasetWithProperties
+		check        func(*testing.T, *ForeignVolume)
+		name         string
+		wantProtocol string
+		wantNil      bool
+	}{
+		{
+			name: "NFS volume from volume context",
+			ds: tnsapi.DatasetWithProperties{
+				Dataset: tnsapi.Dataset{ID: "tank/k8s/nfs/v/pvc-111", Type: "FILESYSTEM", Mountpoint: "/mnt/tank/k8s/nfs/v/pvc-111"},
+				UserProperties: map[string]tnsapi.UserProperty{
+					democraticPropVolumeName:    {Value: "pvc-111"},
+					democraticPropVolumeContext: {Value: `{"node_attach_driver":"nfs","server":"10.0.0.1","share":"/mnt/tank/k8s/nfs/v/pvc-111"}`},
+					democraticPropNFSShareID:    {Value: "7"},
+				},
+			},
+			wantProtocol: protocolNFS,
+			check: func(t *testing.T, vol *ForeignVolume) {
+				t.Helper()
+				if vol.VolumeName != "pvc-111" || vol.sharePath != "/mnt/tank/k8s/nfs/v/pvc-111" || vol.shareID != 7 {
+					t.Errorf("got volume %q share %q (ID %d)", vol.VolumeName, vol.sharePath, vol.shareID)
+				}
+			},
+		},
+		{
+			name: "iSCSI zvol from provisioner driver",
+			ds: tnsapi.DatasetWithProperties{
+				Dataset: tnsapi.Dataset{
+					ID:      "tank/k8s/iscsi/v/pvc-222",
+					Type:    datasetTypeVolume,
+					Volsize: map[string]interface{}{"parsed": float64(5 << 30)},
+				},
+				UserProperties: map[string]tnsapi.UserProperty{
+					democraticPropProvisionerDriver: {Value: "freenas-api-iscsi"},
+					democraticPropISCSITargetID:     {Value: "3"},
+				},
+			},
+			wantProtocol: protocolISCSI,
+			check: func(t *testing.T, vol *ForeignVolume) {
+				t.Helper()
+				if vol.VolumeName != "pvc-222" {
+					t.Errorf("VolumeName = %q, want dataset name fallback pvc-222", vol.VolumeName)
+				}
+				if vol.CapacityBytes != 5<<30 || vol.targetID != 3 {
+					t.Errorf("got capacity %d target %d", vol.CapacityBytes, vol.targetID)
+				}
+			},
+		},
+		{
+			name: "malformed volume context falls back to provisioner driver",
+			ds: tnsapi.DatasetWithProperties{
+				Dataset: tnsapi.Dataset{ID: "tank/k8s/v/pvc-333", Type: datasetTypeVolume},
+				UserProperties: map[string]tnsapi.UserProperty{
+					democraticPropVolumeContext:     {Value: "{not json"},
+					democraticPropProvisionerDriver: {Value: "zfs-generic-nvmeof"},
+				},
+			},
+			wantProtocol: protocolNVMeOF,
+		},
+		{
+			name: "no democratic-csi properties",
+			ds: tnsapi.DatasetWithProperties{
+				Dataset: tnsapi.Dataset{ID: "tank/data"},
+				UserProperties: map[string]tnsapi.UserProperty{
+					tnsapi.PropertyManagedBy: {Value: tnsapi.ManagedByValue},
+				},
+			},
+			wantNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vol := detectDemocraticVolume(&tt.ds)
+			if tt.wantNil {
+				if vol != nil {
+					t.Fatalf("expected nil, got %+v", vol)
+				}
+				return
+			}
+			if vol == nil {
+				t.Fatal("expected a volume, got nil")
+			}
+			if vol.Protocol != tt.wantProtocol {
+				t.Errorf("Protocol = %q, want %q", vol.Protocol, tt.wantProtocol)
+			}
+			if vol.Detection != detectionProperties || vol.Source != foreignSourceDemocraticCSI {
+				t.Errorf("got detection %q source %q", vol.Detection, vol.Source)
+			}
+			if tt.check != nil {
+				tt.check(t, vol)
+			}
+		})
+	}
+}
+
+func TestDetectFromShareComments(t *testing.T) {
+	datasets := []tnsapi.Dataset{
+		{ID: "tank/k8s/pvc-a", Mountpoint: "/mnt/tank/k8s/pvc-a"},
+		{ID: "tank/k8s/pvc-b", Mountpoint: "/mnt/tank/k8s/pvc-b"},
+		{ID: "tank/k8s/pvc-c", Mountpoint: "/mnt/tank/k8s/pvc-c"},
+		{ID: "tank/home", Mountpoint: "/mnt/tank/home"},
+	}
+	nfsShares := []tnsapi.NFSShare{
+		{ID: 1, Path: "/mnt/tank/k8s/pvc-a", Comment: "Democratic-CSI (default/data-a)"},
+		{ID: 2, Path: "/mnt/tank/k8s/pvc-b", Comment: "democratic-csi (default/data-b)"},
+		{ID: 3, Path: "/mnt/tank/home", Comment: "home directories"},
+	}
+	smbShares := []tnsapi.SMBShare{
+		{ID: 4, Name: "pvc-c", Path: "/mnt/tank/k8s/pvc-c", Comment: "democratic-csi"},
+	}
+	skip := map[string]bool{"tank/k8s/pvc-b": true}
+
+	volumes := detectFromShareComments(datasets, nfsShares, smbShares, skip)
+	if len(volumes) != 2 {
+		t.Fatalf("got %d volumes, want 2: %+v", len(volumes), volumes)
+	}
+	if v := volumes[0]; v.Dataset != "tank/k8s/pvc-a" || v.Protocol != protocolNFS || v.shareID != 1 || v.Detection != detectionShareComment {
+		t.Errorf("unexpected NFS volume %+v", v)
+	}
+	if v := volumes[1]; v.Dataset != "tank/k8s/pvc-c" || v.Protocol != protocolSMB || v.shareName != "pvc-c" {
+		t.Errorf("unexpected SMB volume %+v", v)
+	}
+}
+
+func TestFindForeignVolumesSkipsManaged(t *testing.T) {
+	m := &mockClient{
+		FindDatasetsByPropertyFunc: func(_ context.Context, _, propertyName, _ string) ([]tnsapi.DatasetWithProperties, error) {
+			if propertyName != democraticPropVolumeName {
+				t.Errorf("searched by %q, want %q", propertyName, democraticPropVolumeName)
+			}
+			return []tnsapi.DatasetWithProperties{
+				{
+					Dataset:        tnsapi.Dataset{ID: "tank/k8s/pvc-new"},
+					UserProperties: map[string]tnsapi.UserProperty{democraticPropVolumeName: {Value: "pvc-new"}},
+				},
+				{
+					Dataset: tnsapi.Dataset{ID: "tank/k8s/pvc-done"},
+					UserProperties: map[string]tnsapi.UserProperty{
+						democraticPropVolumeName: {Value: "pvc-done"},
+						tnsapi.PropertyManagedBy: {Value: tnsapi.ManagedByValue},
+					},
+				},
+			}, nil
+		},
+		QueryAllDatasetsFunc: func(_ context.Context, _ string) ([]tnsapi.Dataset, error) {
+			return nil, nil
+		},
+	}
+
+	volumes, err := findForeignVolumes(context.Background(), m, "tank/k8s")
+	if err != nil {
+		t.Fatalf("findForeignVolumes: %v", err)
+	}
+	if len(volumes) != 1 || volumes[0].Dataset != "tank/k8s/pvc-new" {
+		t.Fatalf("got %+v, want only tank/k8s/pvc-new", volumes)
+	}
+}
+
+func TestForeignVolumeProperties(t *testing.T) {
+	vol := &ForeignVolume{
+		Dataset:       "tank/k8s/pvc-111",
+		VolumeName:    "pvc-111",
+		Protocol:      protocolNFS,
+		CapacityBytes: 1 << 30,
+		sharePath:     "/mnt/tank/k8s/pvc-111",
+		shareID:       7,
+	}
+	applyForeignPV(vol, foreignPV{}, defaultNamespace)
+
+	props := foreignVolumeProperties(vol, "prod-east", "2026-01-01T00:00:00Z")
+	want := map[string]string{
+		tnsapi.PropertyManagedBy:      tnsapi.ManagedByValue,
+		tnsapi.PropertyCSIVolumeName:  "pvc-111",
+		tnsapi.PropertyClusterID:      "prod-east",
+		tnsapi.PropertyProtocol:       protocolNFS,
+		tnsapi.PropertyDeleteStrategy: tnsapi.DeleteStrategyRetain,
+		tnsapi.PropertyNFSShareID:     "7",
+		tnsapi.PropertyNFSSharePath:   "/mnt/tank/k8s/pvc-111",
+		tnsapi.PropertyCapacityBytes:  "1073741824",
+	}
+	for key, value := range want {
+		if props[key] != value {
+			t.Errorf("%s = %q, want %q", key, props[key], value)
+		}
+	}
+	// Without an existing PV, PVC details come from the volume itself
+	if vol.PVCName != "pvc-111" || vol.PVCNamespace != defaultNamespace || vol.AccessMode != "ReadWriteMany" {
+		t.Errorf("got PVC %s/%s access mode %s", vol.PVCNamespace, vol.PVCName, vol.AccessMode)
+	}
+}
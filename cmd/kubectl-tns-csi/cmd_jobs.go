@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	errInvalidJobID    = errors.New("invalid job ID")
+	errJobNotFound     = errors.New("job not found")
+	errJobNotAbortable = errors.New("job cannot be aborted")
+	errJobFinished     = errors.New("job has already finished")
+)
+
+const defaultJobsWatchInterval = 2 * time.Second
+
+// driverJobMethods are the TrueNAS job methods started by the driver or this plugin.
+var driverJobMethods = map[string]bool{
+	"replication.run_onetime": true, // Detached snapshots and detached clones
+	"pool.dataset.delete":     true,
+	"pool.snapshot.delete":    true,
+	"pool.dataset.promote":    true,
+	"filesystem.setacl":       true, // SMB volume ACLs
+	"cloudsync.sync_onetime":  true, // backup create/restore
+}
+
+// jobsClient is the subset of the TrueNAS client used by the jobs command.
+type jobsClient interface {
+	QueryJobs(ctx context.Context, filters []interface{}) ([]tnsapi.Job, error)
+	AbortJob(ctx context.Context, jobID int) error
+}
+
+// JobInfo describes a TrueNAS job for display.
+//
+//nolint:govet // field alignment not critical for CLI output struct
+type JobInfo struct {
+	ID          int    `json:"id"                    yaml:"id"`
+	Method      string `json:"method"                yaml:"method"`
+	Target      string `json:"target,omitempty"      yaml:"target,omitempty"`
+	State       string `json:"state"                 yaml:"state"`
+	Percent     int    `json:"percent"               yaml:"percent"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Started     string `json:"started,omitempty"     yaml:"started,omitempty"`
+	Duration    string `json:"duration,omitempty"    yaml:"duration,omitempty"`
+	Error       string `json:"error,omitempty"       yaml:"error,omitempty"`
+	Abortable   bool   `json:"abortable"             yaml:"abortable"`
+}
+
+func newJobsCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool) *cobra.Command {
+	var (
+		watch    bool
+		all      bool
+		active   bool
+		interval time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "jobs",
+		Short: "List TrueNAS jobs started by tns-csi",
+		Long: `List TrueNAS jobs started by the driver or this plugin, such as the replications
+behind detached snapshots and clones, dataset and snapshot deletions, and backups.
+
+TrueNAS keeps finished jobs for a while, so recent failures are listed too.
+Use this to see why a detached snapshot or a large delete is taking long.
+
+Examples:
+  # List driver jobs
+  kubectl tns-csi jobs
+
+  # Follow running jobs until interrupted
+  kubectl tns-csi jobs --active --watch
+
+  # Include jobs not started by tns-csi
+  kubectl tns-csi jobs --all
+
+  # Abort a running replication
+  kubectl tns-csi jobs abort 1234`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runJobs(cmd.Context(), url, apiKey, secretRef, outputFormat, skipTLSVerify, all, active, watch, interval)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&watch, "watch", "w", false, "Keep refreshing the list until interrupted")
+	cmd.Flags().DurationVar(&interval, "interval", defaultJobsWatchInterval, "Refresh interval for --watch")
+	cmd.Flags().BoolVar(&all, "all", false, "Include jobs not started by tns-csi")
+	cmd.Flags().BoolVar(&active, "active", false, "Only show waiting and running jobs")
+
+	cmd.AddCommand(newJobsAbortCmd(url, apiKey, secretRef, skipTLSVerify))
+
+	return cmd
+}
+
+func newJobsAbortCmd(url, apiKey, secretRef *string, skipTLSVerify *bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   "abort <job-id>",
+		Short: "Abort a running TrueNAS job",
+		Long: `Abort a running TrueNAS job. Only jobs reported as abortable can be aborted.
+
+Aborting a replication leaves the target dataset incomplete; the driver fails the
+operation and the CSI sidecar retries it.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			jobID, err := strconv.Atoi(args[0])
+			if err != nil || jobID <= 0 {
+				return fmt.Errorf("%w: %s", errInvalidJobID, args[0])
+			}
+			return runJobsAbort(cmd.Context(), url, apiKey, secretRef, skipTLSVerify, jobID)
+		},
+	}
+}
+
+func runJobs(ctx context.Context, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, all, active, watch bool, interval time.Duration) error {
+	cfg, err := getConnectionConfig(ctx, url, apiKey, secretRef, skipTLSVerify)
+	if err != nil {
+		return err
+	}
+
+	client, err := connectToTrueNAS(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if !watch {
+		jobs, err := listJobs(ctx, client, all, active)
+		if err != nil {
+			return err
+		}
+		return outputJobs(jobs, *outputFormat)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		jobs, err := listJobs(ctx, client, all, active)
+		if err != nil {
+			return err
+		}
+		if *outputFormat == outputFormatTable || *outputFormat == "" {
+			// Clear the screen so the table redraws in place
+			fmt.Print("\033[H\033[2J")
+			fmt.Printf("Every %s: kubectl tns-csi jobs    %s\n\n", interval, time.Now().Format(time.TimeOnly))
+		}
+		if err := outputJobs(jobs, *outputFormat); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func runJobsAbort(ctx context.Context, url, apiKey, secretRef *string, skipTLSVerify *bool, jobID int) error {
+	cfg, err := getConnectionConfig(ctx, url, apiKey, secretRef, skipTLSVerify)
+	if err != nil {
+		return err
+	}
+
+	client, err := connectToTrueNAS(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := abortJob(ctx, client, jobID); err != nil {
+		return err
+	}
+	printStepf(colorSuccess, "✓", "Abort requested for job %d", jobID)
+	return nil
+}
+
+// listJobs returns the jobs to display, newest first.
+func listJobs(ctx context.Context, client jobsClient, all, active bool) ([]JobInfo, error) {
+	jobs, err := client.QueryJobs(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	infos := make([]JobInfo, 0, len(jobs))
+	for i := range jobs {
+		job := &jobs[i]
+		if !all && !driverJobMethods[job.Method] {
+			continue
+		}
+		if active && job.Finished() {
+			continue
+		}
+		infos = append(infos, newJobInfo(job, now))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID > infos[j].ID })
+	return infos, nil
+}
+
+// abortJob aborts a job after checking that it is still running and abortable.
+func abortJob(ctx context.Context, client jobsClient, jobID int) error {
+	jobs, err := client.QueryJobs(ctx, []interface{}{[]interface{}{"id", "=", jobID}})
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("%w: %d", errJobNotFound, jobID)
+	}
+	job := &jobs[0]
+	if job.Finished() {
+		return fmt.Errorf("%w: job %d is %s", errJobFinished, jobID, job.State)
+	}
+	if !job.Abortable {
+		return fmt.Errorf("%w: job %d (%s)", errJobNotAbortable, jobID, job.Method)
+	}
+	return client.AbortJob(ctx, jobID)
+}
+
+// newJobInfo converts a TrueNAS job for display.
+func newJobInfo(job *tnsapi.Job, now time.Time) JobInfo {
+	info := JobInfo{
+		ID:          job.ID,
+		Method:      job.Method,
+		Target:      jobTarget(job.Arguments),
+		State:       job.State,
+		Percent:     int(job.Progress.Percent),
+		Description: job.Progress.Description,
+		Error:       job.Error,
+		Abortable:   job.Abortable,
+	}
+	if info.Description == "" {
+		info.Description = job.Description
+	}
+	if job.TimeStarted != nil && !job.TimeStarted.IsZero() {
+		info.Started = job.TimeStarted.Format(time.RFC3339)
+		end := now
+		if job.TimeEnded != nil && !job.TimeEnded.IsZero() {
+			end = job.TimeEnded.Time
+		}
+		info.Duration = end.Sub(job.TimeStarted.Time).Round(time.Second).String()
+	}
+	return info
+}
+
+// jobTarget summarizes what a job operates on from its arguments.
+func jobTarget(args []interface{}) string {
+	if len(args) == 0 {
+		return ""
+	}
+	switch arg := args[0].(type) {
+	case string:
+		return arg
+	case map[string]interface{}:
+		if target, ok := arg["target_dataset"].(string); ok {
+			if sources, ok := arg["source_datasets"].([]interface{}); ok && len(sources) > 0 {
+				return fmt.Sprintf("%v -> %s", sources[0], target)
+			}
+			return target
+		}
+		for _, key := range []string{"path", "name", "id"} {
+			if value, ok := arg[key].(string); ok {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// outputJobs outputs job info in the specified format.
+func outputJobs(jobs []JobInfo, format string) error {
+	switch format {
+	case outputFormatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(jobs)
+
+	case outputFormatYAML:
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		return enc.Encode(jobs)
+
+	case outputFormatTable, "":
+		if len(jobs) == 0 {
+			fmt.Println("No jobs found.")
+			return nil
+		}
+		t := newStyledTable()
+		t.AppendHeader(table.Row{"ID", "METHOD", "TARGET", "STATE", "PROGRESS", "DURATION", "DETAILS"})
+		for i := range jobs {
+			job := &jobs[i]
+			details := job.Description
+			if job.Error != "" {
+				details = job.Error
+			}
+			t.AppendRow(table.Row{job.ID, job.Method, truncateString(job.Target, 50), jobStateBadge(job.State),
+				fmt.Sprintf("%d%%", job.Percent), job.Duration, colorMuted.Sprint(truncateString(strings.TrimSpace(details), 60))})
+		}
+		renderTable(t)
+		return nil
+
+	default:
+		return fmt.Errorf("%w: %s", errUnknownOutputFormat, format)
+	}
+}
+
+// jobStateBadge returns a colored job state.
+func jobStateBadge(state string) string {
+	switch state {
+	case tnsapi.JobStateSuccess:
+		return colorSuccess.Sprint(state)
+	case tnsapi.JobStateFailed:
+		return colorError.Sprint(state)
+	case tnsapi.JobStateRunning, tnsapi.JobStateWaiting:
+		return colorWarning.Sprint(state)
+	case tnsapi.JobStateAborted:
+		return colorMuted.Sprint(state)
+	default:
+		return state
+	}
+}
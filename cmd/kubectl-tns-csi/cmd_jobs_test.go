@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+)
+
+// stubJobsClient serves a fixed job list and records aborts.
+type stubJobsClient struct {
+	jobs    []tnsapi.Job
+	aborted []int
+}
+
+func (c *stubJobsClient) QueryJobs(_ context.Context, filters []interface{}) ([]tnsapi.Job, error) {
+	if len(filters) == 0 {
+		return c.jobs, nil
+	}
+	id := filters[0].([]interface{})[2].(int)
+	for i := range c.jobs {
+		if c.jobs[i].ID == id {
+			return c.jobs[i : i+1], nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *stubJobsClient) AbortJob(_ context.Context, jobID int) error {
+	c.aborted = append(c.aborted, jobID)
+	return nil
+}
+
+func newStubJobsClient() *stubJobsClient {
+	return &stubJobsClient{jobs: []tnsapi.Job{
+		{
+			ID:     1,
+			Method: "replication.run_onetime",
+			Arguments: []interface{}{map[string]interface{}{
+				"source_datasets": []interface{}{"tank/csi/pvc-1"},
+				"target_dataset":  "tank/csi/snap-1",
+			}},
+			State:     tnsapi.JobStateRunning,
+			Abortable: true,
+			Progress:  tnsapi.JobProgress{Percent: 42, Description: "Sending tank/csi/pvc-1@snap-1"},
+		},
+		{ID: 2, Method: "pool.dataset.delete", Arguments: []interface{}{"tank/csi/pvc-2"}, State: tnsapi.JobStateFailed, Error: "dataset is busy"},
+		{ID: 3, Method: "pool.scrub", State: tnsapi.JobStateRunning, Abortable: true},
+		{ID: 4, Method: "filesystem.setacl", Arguments: []interface{}{map[string]interface{}{"path": "/mnt/tank/csi/pvc-4"}}, State: tnsapi.JobStateRunning},
+	}}
+}
+
+func TestListJobs(t *testing.T) {
+	client := newStubJobsClient()
+	ctx := context.Background()
+
+	jobs, err := listJobs(ctx, client, false, false)
+	if err != nil {
+		t.Fatalf("listJobs() error = %v", err)
+	}
+	if len(jobs) != 3 || jobs[0].ID != 4 || jobs[2].ID != 1 {
+		t.Fatalf("listJobs() = %+v, want driver jobs 4, 2, 1", jobs)
+	}
+	if jobs[2].Target != "tank/csi/pvc-1 -> tank/csi/snap-1" || jobs[2].Percent != 42 || jobs[2].Description != "Sending tank/csi/pvc-1@snap-1" {
+		t.Errorf("replication job = %+v", jobs[2])
+	}
+	if jobs[1].Target != "tank/csi/pvc-2" || jobs[0].Target != "/mnt/tank/csi/pvc-4" {
+		t.Errorf("targets = %q, %q", jobs[1].Target, jobs[0].Target)
+	}
+
+	active, err := listJobs(ctx, client, false, true)
+	if err != nil || len(active) != 2 {
+		t.Errorf("listJobs(active) = %+v, %v; want 2 running driver jobs", active, err)
+	}
+	all, err := listJobs(ctx, client, true, false)
+	if err != nil || len(all) != 4 {
+		t.Errorf("listJobs(all) = %+v, %v; want 4 jobs", all, err)
+	}
+}
+
+func TestNewJobInfoDuration(t *testing.T) {
+	started := time.Now().Add(-90 * time.Second)
+	data := `{"id": 7, "method": "replication.run_onetime", "state": "RUNNING", "time_started": {"$date": ` +
+		strconv.FormatInt(started.UnixMilli(), 10) + `}}`
+	var job tnsapi.Job
+	if err := json.Unmarshal([]byte(data), &job); err != nil {
+		t.Fatalf("decode job: %v", err)
+	}
+
+	info := newJobInfo(&job, started.Add(90*time.Second))
+	if info.Duration != "1m30s" || info.Started == "" {
+		t.Errorf("newJobInfo() = %+v, want a 1m30s duration", info)
+	}
+}
+
+func TestAbortJob(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		wantErr error
+		name    string
+		jobID   int
+	}{
+		{name: "running and abortable", jobID: 1},
+		{name: "finished", jobID: 2, wantErr: errJobFinished},
+		{name: "not abortable", jobID: 4, wantErr: errJobNotAbortable},
+		{name: "unknown", jobID: 99, wantErr: errJobNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newStubJobsClient()
+			err := abortJob(ctx, client, tt.jobID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("abortJob(%d) error = %v, want %v", tt.jobID, err, tt.wantErr)
+			}
+			if aborted := len(client.aborted) == 1; aborted != (tt.wantErr == nil) {
+				t.Errorf("aborted = %v, want %v", client.aborted, tt.wantErr == nil)
+			}
+		})
+	}
+}
@@ -27,14 +27,16 @@ const (
 	datasetTypeVolume = "VOLUME"
 )
 
-func newListCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID *string) *cobra.Command {
+func newListCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID, controllerRef *string) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List all tns-csi managed volumes on TrueNAS",
 		Long: `List all volumes managed by tns-csi on TrueNAS.
 
 This command queries TrueNAS for all datasets with tns-csi:managed_by property
-and displays their metadata.
+and displays their metadata, space usage and ZFS compression ratio. For volumes
+with deduplication enabled, DEDUP estimates the savings from the pool-wide
+deduplication ratio, as ZFS does not track deduplication per dataset.
 
 Examples:
   # List all volumes in table format
@@ -44,19 +46,49 @@ Examples:
   kubectl tns-csi list -o yaml
 
   # List volumes using specific TrueNAS connection
-  kubectl tns-csi list --url wss://truenas:443/api/current --api-key <key>`,
+  kubectl tns-csi list --url wss://truenas:443/api/current --api-key <key>
+
+  # List volumes through the controller's admin API (no TrueNAS credentials)
+  kubectl tns-csi list --controller kube-system/tns-csi-driver-admin`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runList(cmd.Context(), url, apiKey, secretRef, outputFormat, skipTLSVerify, clusterID)
+			return runList(cmd.Context(), url, apiKey, secretRef, outputFormat, skipTLSVerify, clusterID, controllerRef)
 		},
 	}
 	return cmd
 }
 
-func runList(ctx context.Context, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID *string) error {
+func runList(ctx context.Context, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID, controllerRef *string) error {
+	volumes, err := fetchVolumes(ctx, url, apiKey, secretRef, skipTLSVerify, clusterID, controllerRef)
+	if err != nil {
+		return err
+	}
+
+	// Enrich with Kubernetes PV/PVC data (best-effort, no pods for list view)
+	k8sData := enrichWithK8sData(ctx, false)
+	if k8sData.Available {
+		for i := range volumes {
+			if binding := dashboard.MatchK8sBinding(k8sData.Bindings, volumes[i].Dataset, volumes[i].VolumeID); binding != nil {
+				volumes[i].K8s = binding
+			}
+		}
+	}
+
+	// Output based on format
+	return outputVolumes(volumes, *outputFormat)
+}
+
+// fetchVolumes returns the managed volumes, from the controller with --controller.
+func fetchVolumes(ctx context.Context, url, apiKey, secretRef *string, skipTLSVerify *bool, clusterID, controllerRef *string) ([]VolumeInfo, error) {
+	if *controllerRef != "" {
+		var volumes []VolumeInfo
+		err := fetchFromController(ctx, *controllerRef, dashboard.AdminVolumes, &volumes)
+		return volumes, err
+	}
+
 	// Get connection config
 	cfg, err := getConnectionConfig(ctx, url, apiKey, secretRef, skipTLSVerify)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Connect to TrueNAS
@@ -64,7 +96,7 @@ func runList(ctx context.Context, url, apiKey, secretRef, outputFormat *string,
 	client, err := connectToTrueNAS(ctx, cfg)
 	if err != nil {
 		spin.stop()
-		return err
+		return nil, err
 	}
 	defer client.Close()
 
@@ -72,21 +104,9 @@ func runList(ctx context.Context, url, apiKey, secretRef, outputFormat *string,
 	volumes, err := dashboard.FindManagedVolumes(ctx, client, *clusterID)
 	spin.stop()
 	if err != nil {
-		return fmt.Errorf("failed to query volumes: %w", err)
+		return nil, fmt.Errorf("failed to query volumes: %w", err)
 	}
-
-	// Enrich with Kubernetes PV/PVC data (best-effort, no pods for list view)
-	k8sData := enrichWithK8sData(ctx, false)
-	if k8sData.Available {
-		for i := range volumes {
-			if binding := dashboard.MatchK8sBinding(k8sData.Bindings, volumes[i].Dataset, volumes[i].VolumeID); binding != nil {
-				volumes[i].K8s = binding
-			}
-		}
-	}
-
-	// Output based on format
-	return outputVolumes(volumes, *outputFormat)
+	return volumes, nil
 }
 
 // outputVolumes outputs volumes in the specified format.
@@ -104,7 +124,7 @@ func outputVolumes(volumes []VolumeInfo, format string) error {
 
 	case outputFormatTable, "":
 		t := newStyledTable()
-		t.AppendHeader(table.Row{colDataset, colVolumeID, colProtocol, "CAPACITY", "PVC", "NAMESPACE", colType, "CLONE_SOURCE", "ADOPTABLE"})
+		t.AppendHeader(table.Row{colDataset, colVolumeID, colProtocol, "CAPACITY", "USED", "COMPRESS", "DEDUP", "PVC", "NAMESPACE", colType, "CLONE_SOURCE", "ADOPTABLE"})
 		for i := range volumes {
 			v := &volumes[i]
 			adoptable := ""
@@ -116,14 +136,23 @@ func outputVolumes(volumes []VolumeInfo, format string) error {
 			if v.ContentSourceType != "" && v.ContentSourceID != "" {
 				cloneSource = fmt.Sprintf("%s:%s", v.ContentSourceType, v.ContentSourceID)
 			}
-			// K8s PVC/Namespace
+			// PVC/Namespace (live K8s binding, else recorded on the dataset)
 			pvcName := colorMuted.Sprint("-")
 			pvcNamespace := colorMuted.Sprint("-")
-			if v.K8s != nil && v.K8s.PVCName != "" {
-				pvcName = v.K8s.PVCName
-				pvcNamespace = v.K8s.PVCNamespace
+			if v.ClaimName() != "" {
+				pvcName = v.ClaimName()
+				pvcNamespace = v.ClaimNamespace()
+			}
+			used := v.UsedHuman
+			if used == "" {
+				used = colorMuted.Sprint("-")
+			}
+			dedup := v.DedupHuman()
+			if !v.Deduplication {
+				dedup = colorMuted.Sprint(dedup)
 			}
-			t.AppendRow(table.Row{v.Dataset, v.VolumeID, protocolBadge(v.Protocol), v.CapacityHuman, pvcName, pvcNamespace, v.Type, cloneSource, adoptable})
+			t.AppendRow(table.Row{v.Dataset, v.VolumeID, protocolBadge(v.Protocol), v.CapacityHuman, used, v.CompressRatioHuman(), dedup,
+				pvcName, pvcNamespace, v.Type, cloneSource, adoptable})
 		}
 		renderTable(t)
 		return nil
@@ -14,21 +14,14 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 // Static errors for list-orphaned command.
 var errOrphanedUnknownOutputFormat = errors.New("unknown output format")
 
-// OrphanedVolumeInfo represents a volume that exists on TrueNAS but has no matching PVC.
-type OrphanedVolumeInfo struct {
-	PVCName    string `json:"pvcName,omitempty"   yaml:"pvcName,omitempty"`
-	Namespace  string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
-	Reason     string `json:"reason"              yaml:"reason"`
-	VolumeInfo `json:",inline"             yaml:",inline"`
-}
-
-func newListOrphanedCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID *string) *cobra.Command {
+func newListOrphanedCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID, controllerRef *string) *cobra.Command {
 	var allNamespaces bool
 
 	cmd := &cobra.Command{
@@ -49,7 +42,7 @@ Examples:
   # Output in YAML for scripting
   kubectl tns-csi list-orphaned -o yaml`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runListOrphaned(cmd.Context(), url, apiKey, secretRef, outputFormat, skipTLSVerify, clusterID, allNamespaces)
+			return runListOrphaned(cmd.Context(), url, apiKey, secretRef, outputFormat, skipTLSVerify, clusterID, controllerRef, allNamespaces)
 		},
 	}
 
@@ -58,7 +51,16 @@ Examples:
 	return cmd
 }
 
-func runListOrphaned(ctx context.Context, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID *string, allNamespaces bool) error {
+func runListOrphaned(ctx context.Context, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID, controllerRef *string, allNamespaces bool) error {
+	if *controllerRef != "" {
+		// The controller always compares with the PVCs of all namespaces
+		var orphaned []OrphanedVolumeInfo
+		if err := fetchFromController(ctx, *controllerRef, dashboard.AdminOrphans, &orphaned); err != nil {
+			return err
+		}
+		return outputOrphanedVolumes(orphaned, *outputFormat)
+	}
+
 	// Get connection config
 	cfg, err := getConnectionConfig(ctx, url, apiKey, secretRef, skipTLSVerify)
 	if err != nil {
@@ -91,13 +93,23 @@ func runListOrphaned(ctx context.Context, url, apiKey, secretRef, outputFormat *
 	}
 
 	// Find orphaned volumes
-	orphaned := findOrphanedVolumes(volumes, pvMap, pvcMap)
+	orphaned := dashboard.FindOrphanedVolumes(volumes, pvMap, pvcMap)
 
 	// Output
 	return outputOrphanedVolumes(orphaned, *outputFormat)
 }
 
 func getK8sClient() (*kubernetes.Clientset, error) {
+	config, err := getK8sConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
+// getK8sConfig loads the client configuration of the current kubeconfig context.
+func getK8sConfig() (*rest.Config, error) {
 	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
 	configOverrides := &clientcmd.ConfigOverrides{}
 	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
@@ -106,24 +118,7 @@ func getK8sClient() (*kubernetes.Clientset, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
 	}
-
-	return kubernetes.NewForConfig(config)
-}
-
-// pvInfo holds PV information.
-type pvInfo struct {
-	Name     string
-	VolumeID string // CSI volume handle
-	PVCName  string
-	PVCNs    string
-	Status   string // PV phase (Bound, Released, etc.)
-}
-
-// pvcInfo holds PVC information.
-type pvcInfo struct {
-	Name      string
-	Namespace string
-	PVName    string
+	return config, nil
 }
 
 func getK8sVolumeInfo(ctx context.Context, client *kubernetes.Clientset, allNamespaces bool) (pvMap map[string]pvInfo, pvcMap map[string]pvcInfo, err error) {
@@ -133,28 +128,11 @@ func getK8sVolumeInfo(ctx context.Context, client *kubernetes.Clientset, allName
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to list PVs: %w", err)
 	}
-
-	pvMap = make(map[string]pvInfo)
+	pvPtrs := make([]*corev1.PersistentVolume, len(pvs.Items))
 	for i := range pvs.Items {
-		pv := &pvs.Items[i]
-		// Only consider CSI volumes from our driver
-		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != "tns.csi.io" {
-			continue
-		}
-
-		info := pvInfo{
-			Name:     pv.Name,
-			VolumeID: pv.Spec.CSI.VolumeHandle,
-			Status:   string(pv.Status.Phase),
-		}
-
-		if pv.Spec.ClaimRef != nil {
-			info.PVCName = pv.Spec.ClaimRef.Name
-			info.PVCNs = pv.Spec.ClaimRef.Namespace
-		}
-
-		pvMap[info.VolumeID] = info
+		pvPtrs[i] = &pvs.Items[i]
 	}
+	pvMap = dashboard.PVInfoMap(pvPtrs)
 
 	// Get all PVCs
 	pvcMap = make(map[string]pvcInfo)
@@ -165,68 +143,16 @@ func getK8sVolumeInfo(ctx context.Context, client *kubernetes.Clientset, allName
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to list PVCs: %w", err)
 		}
-
+		pvcPtrs := make([]*corev1.PersistentVolumeClaim, len(pvcs.Items))
 		for i := range pvcs.Items {
-			pvc := &pvcs.Items[i]
-			key := pvc.Namespace + "/" + pvc.Name
-			pvcMap[key] = pvcInfo{
-				Name:      pvc.Name,
-				Namespace: pvc.Namespace,
-				PVName:    pvc.Spec.VolumeName,
-			}
+			pvcPtrs[i] = &pvcs.Items[i]
 		}
+		pvcMap = dashboard.PVCInfoMap(pvcPtrs)
 	}
 
 	return pvMap, pvcMap, nil
 }
 
-func findOrphanedVolumes(volumes []VolumeInfo, pvMap map[string]pvInfo, pvcMap map[string]pvcInfo) []OrphanedVolumeInfo {
-	var orphaned []OrphanedVolumeInfo
-
-	for i := range volumes {
-		vol := &volumes[i]
-		// Check if there's a PV with this volume ID (try dataset path first for new volumes,
-		// then fall back to csi_volume_name for old volumes)
-		pv, hasPV := pvMap[vol.Dataset]
-		if !hasPV && vol.VolumeID != vol.Dataset {
-			pv, hasPV = pvMap[vol.VolumeID]
-		}
-
-		if !hasPV {
-			// No PV exists - definitely orphaned
-			orphaned = append(orphaned, OrphanedVolumeInfo{
-				VolumeInfo: *vol,
-				Reason:     "no PV in cluster",
-			})
-			continue
-		}
-
-		// PV exists - check if it has a bound PVC
-		if pv.PVCName == "" {
-			orphaned = append(orphaned, OrphanedVolumeInfo{
-				VolumeInfo: *vol,
-				Reason:     "PV exists but not bound",
-			})
-			continue
-		}
-
-		// Check if the PVC actually exists
-		pvcKey := pv.PVCNs + "/" + pv.PVCName
-		if _, hasPVC := pvcMap[pvcKey]; !hasPVC {
-			orphaned = append(orphaned, OrphanedVolumeInfo{
-				VolumeInfo: *vol,
-				PVCName:    pv.PVCName,
-				Namespace:  pv.PVCNs,
-				Reason:     "PVC deleted but PV remains",
-			})
-		}
-
-		// If we get here, the volume has both PV and PVC - not orphaned
-	}
-
-	return orphaned
-}
-
 func outputOrphanedVolumes(volumes []OrphanedVolumeInfo, format string) error {
 	if len(volumes) == 0 {
 		fmt.Println("No orphaned volumes found")
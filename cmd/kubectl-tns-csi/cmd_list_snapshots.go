@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/fenio/tns-csi/pkg/dashboard"
 	"github.com/jedib0t/go-pretty/v6/table"
@@ -12,7 +13,7 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-func newListSnapshotsCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID *string) *cobra.Command {
+func newListSnapshotsCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID, controllerRef *string) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "list-snapshots",
 		Short: "List all tns-csi managed snapshots on TrueNAS",
@@ -21,6 +22,10 @@ func newListSnapshotsCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVe
 This command queries TrueNAS for all snapshots associated with tns-csi managed
 volumes, including both attached (on-volume) and detached snapshots.
 
+The CLONES column lists copy-on-write clones that still depend on a snapshot.
+Deleting such a snapshot only marks it for deferred destruction: ZFS removes
+it once the last of those clones is deleted.
+
 Examples:
   # List all snapshots in table format
   kubectl tns-csi list-snapshots
@@ -31,13 +36,21 @@ Examples:
   # List snapshots using specific TrueNAS connection
   kubectl tns-csi list-snapshots --url wss://truenas:443/api/current --api-key <key>`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runListSnapshots(cmd.Context(), url, apiKey, secretRef, outputFormat, skipTLSVerify, clusterID)
+			return runListSnapshots(cmd.Context(), url, apiKey, secretRef, outputFormat, skipTLSVerify, clusterID, controllerRef)
 		},
 	}
 	return cmd
 }
 
-func runListSnapshots(ctx context.Context, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID *string) error {
+func runListSnapshots(ctx context.Context, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool, clusterID, controllerRef *string) error {
+	if *controllerRef != "" {
+		var snapshots []SnapshotInfo
+		if err := fetchFromController(ctx, *controllerRef, dashboard.AdminSnapshots, &snapshots); err != nil {
+			return err
+		}
+		return outputSnapshots(snapshots, *outputFormat)
+	}
+
 	// Get connection config
 	cfg, err := getConnectionConfig(ctx, url, apiKey, secretRef, skipTLSVerify)
 	if err != nil {
@@ -76,13 +89,17 @@ func outputSnapshots(snapshots []SnapshotInfo, format string) error {
 
 	case outputFormatTable, "":
 		t := newStyledTable()
-		t.AppendHeader(table.Row{"NAME", "SOURCE_VOLUME", colProtocol, colType, "SOURCE_DATASET"})
+		t.AppendHeader(table.Row{"NAME", "SOURCE_VOLUME", colProtocol, colType, "SOURCE_DATASET", "CLONES"})
 		for _, s := range snapshots {
 			snapType := colorSuccess.Sprint(s.Type)
 			if s.Type == "detached" {
 				snapType = colorProtocolNFS.Sprint(s.Type)
 			}
-			t.AppendRow(table.Row{s.Name, s.SourceVolume, protocolBadge(s.Protocol), snapType, s.SourceDataset})
+			clones := colorMuted.Sprint("-")
+			if len(s.Clones) > 0 {
+				clones = colorWarning.Sprint(strings.Join(s.Clones, ", "))
+			}
+			t.AppendRow(table.Row{s.Name, s.SourceVolume, protocolBadge(s.Protocol), snapType, s.SourceDataset, clones})
 		}
 		renderTable(t)
 		return nil
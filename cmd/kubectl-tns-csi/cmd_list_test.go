@@ -210,6 +210,52 @@ func TestFindManagedVolumes(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "space efficiency",
+			setupMock: func(m *mockClient) {
+				m.FindDatasetsByPropertyFunc = func(_ context.Context, _, _, _ string) ([]tnsapi.DatasetWithProperties, error) {
+					return []tnsapi.DatasetWithProperties{
+						{
+							Dataset: tnsapi.Dataset{
+								ID:            "tank/csi/pvc-666",
+								Name:          "tank/csi/pvc-666",
+								Type:          "FILESYSTEM",
+								Used:          map[string]interface{}{"parsed": float64(4 << 30)},
+								LogicalUsed:   map[string]interface{}{"parsed": float64(6 << 30)},
+								CompressRatio: map[string]interface{}{"parsed": "1.50", "rawvalue": "1.50"},
+								Deduplication: map[string]interface{}{"value": "ON"},
+							},
+							UserProperties: map[string]tnsapi.UserProperty{
+								tnsapi.PropertyManagedBy:     {Value: tnsapi.ManagedByValue},
+								tnsapi.PropertyCSIVolumeName: {Value: "pvc-666"},
+							},
+						},
+					}, nil
+				}
+				m.QueryPoolFunc = func(_ context.Context, poolName string) (*tnsapi.Pool, error) {
+					pool := &tnsapi.Pool{Name: poolName}
+					pool.Properties.Dedupratio = map[string]interface{}{"parsed": float64(2)}
+					return pool, nil
+				}
+			},
+			wantCount: 1,
+			checkVols: func(t *testing.T, vols []VolumeInfo) {
+				t.Helper()
+				v := vols[0]
+				if v.UsedHuman != "4.0Gi" || v.LogicalUsedHuman != "6.0Gi" {
+					t.Errorf("Used = %q, LogicalUsed = %q, want 4.0Gi, 6.0Gi", v.UsedHuman, v.LogicalUsedHuman)
+				}
+				if got := v.CompressRatioHuman(); got != "1.50x" {
+					t.Errorf("CompressRatioHuman() = %q, want 1.50x", got)
+				}
+				if got := v.CompressSavingsHuman(); got != "2.0Gi" {
+					t.Errorf("CompressSavingsHuman() = %q, want 2.0Gi", got)
+				}
+				if v.DedupSavingsBytes != 2<<30 {
+					t.Errorf("DedupSavingsBytes = %d, want %d", v.DedupSavingsBytes, int64(2<<30))
+				}
+			},
+		},
 		{
 			name: "API error propagates",
 			setupMock: func(m *mockClient) {
@@ -246,3 +292,53 @@ func TestFindManagedVolumes(t *testing.T) {
 		})
 	}
 }
+
+func TestFindManagedSnapshotsReportsClones(t *testing.T) {
+	mc := &mockClient{}
+	mc.FindDatasetsByPropertyFunc = func(_ context.Context, _, propertyName, _ string) ([]tnsapi.DatasetWithProperties, error) {
+		if propertyName != tnsapi.PropertyManagedBy {
+			return nil, nil // no detached snapshots
+		}
+		volume := func(id, name string, extra map[string]string) tnsapi.DatasetWithProperties {
+			props := map[string]tnsapi.UserProperty{
+				tnsapi.PropertyManagedBy:     {Value: tnsapi.ManagedByValue},
+				tnsapi.PropertyCSIVolumeName: {Value: name},
+				tnsapi.PropertyProtocol:      {Value: "nfs"},
+			}
+			for k, v := range extra {
+				props[k] = tnsapi.UserProperty{Value: v}
+			}
+			return tnsapi.DatasetWithProperties{Dataset: tnsapi.Dataset{ID: id, Name: id}, UserProperties: props}
+		}
+		return []tnsapi.DatasetWithProperties{
+			volume("tank/csi/pvc-src", "pvc-src", nil),
+			volume("tank/csi/pvc-cow", "pvc-cow", map[string]string{
+				tnsapi.PropertyCloneMode:      tnsapi.CloneModeCOW,
+				tnsapi.PropertyOriginSnapshot: "tank/csi/pvc-src@snap-1",
+			}),
+			volume("tank/csi/pvc-promoted", "pvc-promoted", map[string]string{
+				tnsapi.PropertyCloneMode: tnsapi.CloneModePromoted,
+			}),
+		}, nil
+	}
+	mc.QuerySnapshotsFunc = func(_ context.Context, _ []interface{}) ([]tnsapi.Snapshot, error) {
+		return []tnsapi.Snapshot{
+			{ID: "tank/csi/pvc-src@snap-1", Name: "snap-1", Dataset: "tank/csi/pvc-src"},
+			{ID: "tank/csi/pvc-src@snap-2", Name: "snap-2", Dataset: "tank/csi/pvc-src"},
+		}, nil
+	}
+
+	snapshots, err := dashboard.FindManagedSnapshots(context.Background(), mc, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("got %d snapshots, want 2", len(snapshots))
+	}
+	if got := snapshots[0].Clones; len(got) != 1 || got[0] != "pvc-cow" {
+		t.Errorf("snap-1 clones = %v, want [pvc-cow]", got)
+	}
+	if got := snapshots[1].Clones; len(got) != 0 {
+		t.Errorf("snap-2 clones = %v, want none", got)
+	}
+}
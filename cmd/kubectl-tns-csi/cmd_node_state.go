@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Static errors for node state fetching.
+var (
+	errNoNodePod         = errors.New("no running tns-csi node pod found")
+	errPortForwardFailed = errors.New("kubectl port-forward failed")
+	errNodeStateRequest  = errors.New("node state request failed")
+	errPortForwardExited = errors.New("port-forward exited")
+	errPortForwardSlow   = errors.New("port-forward did not report its local port")
+)
+
+// Node state endpoint constants (see --debug-addr of the driver).
+const (
+	nodeLabelSelector       = "app.kubernetes.io/component=node,app.kubernetes.io/name=tns-csi-driver"
+	defaultNodeDebugPort    = 9811
+	nodeStatePath           = "/debug/state"
+	nodeStatePortForwardTTL = 15 * time.Second
+)
+
+// forwardingLineRegex matches the local port kubectl port-forward listens on.
+var forwardingLineRegex = regexp.MustCompile(`^Forwarding from 127\.0\.0\.1:(\d+) ->`)
+
+// NodeState is the node plugin state served by its debug endpoint.
+//
+//nolint:govet // field alignment not critical for CLI output struct
+type NodeState struct {
+	CollectedAt   time.Time          `json:"collectedAt"   yaml:"collectedAt"`
+	NodeID        string             `json:"nodeID"        yaml:"nodeID"`
+	NVMeOF        []NodeStateNVMeOF  `json:"nvmeof"        yaml:"nvmeof"`
+	NFS           []NodeStateNFS     `json:"nfs"           yaml:"nfs"`
+	Sessions      []NodeStateSession `json:"sessions"      yaml:"sessions"`
+	SingleWriters map[string]string  `json:"singleWriters" yaml:"singleWriters"`
+	Mounts        []NodeStateMount   `json:"mounts"        yaml:"mounts"`
+	StateFile     bool               `json:"stateFile"     yaml:"stateFile"`
+	Problems      []string           `json:"problems"      yaml:"problems"`
+	Pod           string             `json:"pod"           yaml:"pod"`
+}
+
+// NodeStateNVMeOF is a staged NVMe-oF volume.
+//
+//nolint:govet // field alignment not critical for CLI output struct
+type NodeStateNVMeOF struct {
+	StagedAt      time.Time `json:"stagedAt"               yaml:"stagedAt"`
+	VolumeID      string    `json:"volumeID"               yaml:"volumeID"`
+	StagingPath   string    `json:"stagingPath"            yaml:"stagingPath"`
+	NQN           string    `json:"nqn"                    yaml:"nqn"`
+	NSID          string    `json:"nsid,omitempty"         yaml:"nsid,omitempty"`
+	Server        string    `json:"server"                 yaml:"server"`
+	Endpoint      string    `json:"endpoint,omitempty"     yaml:"endpoint,omitempty"`
+	Transport     string    `json:"transport"              yaml:"transport"`
+	SessionState  string    `json:"sessionState,omitempty" yaml:"sessionState,omitempty"`
+	Block         bool      `json:"block"                  yaml:"block"`
+	StagingExists bool      `json:"stagingExists"          yaml:"stagingExists"`
+}
+
+// NodeStateNFS is a staged NFS volume.
+//
+//nolint:govet // field alignment not critical for CLI output struct
+type NodeStateNFS struct {
+	StagedAt    time.Time `json:"stagedAt"           yaml:"stagedAt"`
+	VolumeID    string    `json:"volumeID"           yaml:"volumeID"`
+	StagingPath string    `json:"stagingPath"        yaml:"stagingPath"`
+	Server      string    `json:"server"             yaml:"server"`
+	Endpoint    string    `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	Share       string    `json:"share"              yaml:"share"`
+	Mounted     bool      `json:"mounted"            yaml:"mounted"`
+}
+
+// NodeStateSession is an NVMe-oF controller of the node.
+type NodeStateSession struct {
+	Controller string `json:"controller" yaml:"controller"`
+	NQN        string `json:"nqn"        yaml:"nqn"`
+	Transport  string `json:"transport"  yaml:"transport"`
+	Address    string `json:"address"    yaml:"address"`
+	State      string `json:"state"      yaml:"state"`
+	Recorded   bool   `json:"recorded"   yaml:"recorded"`
+}
+
+// NodeStateMount is a CSI mount of the driver.
+type NodeStateMount struct {
+	MountPoint string `json:"mountPoint" yaml:"mountPoint"`
+	VolumeID   string `json:"volumeID"   yaml:"volumeID"`
+	FSType     string `json:"fsType"     yaml:"fsType"`
+	Source     string `json:"source"     yaml:"source"`
+	Stale      bool   `json:"stale"      yaml:"stale"`
+}
+
+func newNodeStateCmd(outputFormat *string) *cobra.Command {
+	var port int
+
+	cmd := &cobra.Command{
+		Use:   "node-state <node>",
+		Short: "Show the staged volumes, NVMe-oF sessions and mounts of a node",
+		Long: `Show the internal state of the tns-csi node plugin on a node: the NVMe-oF and NFS
+volumes it recorded as staged, the node's NVMe-oF sessions, single-writer publications
+and the driver's mounts, followed by the inconsistencies between them.
+
+Use this to debug unstages that never finish and NVMe-oF sessions that were left behind.
+The node plugin serves its state on 127.0.0.1 only (node.debugPort in Helm); the plugin
+reaches it through kubectl port-forward, so kubectl must be in PATH.
+
+Examples:
+  # Show the state of a node
+  kubectl tns-csi node-state worker-1
+
+  # Full state as YAML
+  kubectl tns-csi node-state worker-1 -o yaml`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runNodeState(cmd.Context(), args[0], port, *outputFormat)
+		},
+	}
+
+	cmd.Flags().IntVar(&port, "port", defaultNodeDebugPort, "Port of the node state debug endpoint (node.debugPort)")
+
+	return cmd
+}
+
+func runNodeState(ctx context.Context, node string, port int, format string) error {
+	namespace, pod, err := findNodePod(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	state, err := fetchNodeState(ctx, namespace, pod, port)
+	if err != nil {
+		return err
+	}
+	state.Pod = namespace + "/" + pod
+	state.Problems = nodeStateProblems(state)
+	return outputNodeState(state, format)
+}
+
+// findNodePod returns the namespace and name of the running node plugin pod on node.
+func findNodePod(ctx context.Context, node string) (namespace, pod string, err error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{})
+	config, err := kubeConfig.ClientConfig()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	namespace = discoverDriverNamespace(ctx)
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: nodeLabelSelector,
+		FieldSelector: "spec.nodeName=" + node,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to list node pods: %w", err)
+	}
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == "Running" {
+			return namespace, pods.Items[i].Name, nil
+		}
+	}
+	return "", "", fmt.Errorf("%w on node %s in namespace %s", errNoNodePod, node, namespace)
+}
+
+// fetchNodeState reads the node state of a node plugin pod through kubectl port-forward.
+// Node plugin pods use the host network, so the forwarded connection reaches the node's
+// loopback address the debug endpoint listens on.
+func fetchNodeState(ctx context.Context, namespace, pod string, port int) (*NodeState, error) {
+	fwdCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	//nolint:gosec // arguments are a pod name and a port, not shell input
+	fwd := exec.CommandContext(fwdCtx, "kubectl", "port-forward", "-n", namespace, "pod/"+pod, fmt.Sprintf(":%d", port))
+	stdout, err := fwd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stderr strings.Builder
+	fwd.Stderr = &stderr
+	if err := fwd.Start(); err != nil {
+		return nil, fmt.Errorf("%w: %w", errPortForwardFailed, err)
+	}
+	defer func() {
+		cancel()
+		_ = fwd.Wait()
+	}()
+
+	localPort, err := waitForwardedPort(stdout, nodeStatePortForwardTTL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w %s", errPortForwardFailed, err, strings.TrimSpace(stderr.String()))
+	}
+	go func() { _, _ = io.Copy(io.Discard, stdout) }()
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, 30*time.Second)
+	defer reqCancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, fmt.Sprintf("http://127.0.0.1:%s%s", localPort, nodeStatePath), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w (is node.debugPort enabled?)", errNodeStateRequest, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", errNodeStateRequest, resp.Status)
+	}
+
+	var state NodeState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return nil, fmt.Errorf("%w: invalid response: %w", errNodeStateRequest, err)
+	}
+	return &state, nil
+}
+
+// waitForwardedPort reads kubectl port-forward output until it reports its local port.
+func waitForwardedPort(r io.Reader, timeout time.Duration) (string, error) {
+	found := make(chan string, 1)
+	go func() {
+		defer close(found)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if m := forwardingLineRegex.FindStringSubmatch(scanner.Text()); m != nil {
+				found <- m[1]
+				return
+			}
+		}
+	}()
+
+	select {
+	case port, ok := <-found:
+		if !ok {
+			return "", errPortForwardExited
+		}
+		return port, nil
+	case <-time.After(timeout):
+		return "", fmt.Errorf("%w after %s", errPortForwardSlow, timeout)
+	}
+}
+
+// nodeStateProblems lists the inconsistencies between records, sessions and mounts.
+func nodeStateProblems(state *NodeState) []string {
+	problems := []string{}
+	for i := range state.NVMeOF {
+		vol := &state.NVMeOF[i]
+		switch {
+		case !vol.StagingExists:
+			problems = append(problems, fmt.Sprintf("NVMe-oF volume %s: staging path %s is gone but the volume is still recorded (unstage did not finish)", vol.VolumeID, vol.StagingPath))
+		case vol.SessionState == "":
+			problems = append(problems, fmt.Sprintf("NVMe-oF volume %s: no session to %s", vol.VolumeID, vol.NQN))
+		case vol.SessionState != "live":
+			problems = append(problems, fmt.Sprintf("NVMe-oF volume %s: session to %s is %s", vol.VolumeID, vol.NQN, vol.SessionState))
+		}
+	}
+	for i := range state.Sessions {
+		session := &state.Sessions[i]
+		if !session.Recorded && state.StateFile {
+			problems = append(problems, fmt.Sprintf("NVMe-oF session %s to %s belongs to no staged volume (leaked session?)", session.Controller, session.NQN))
+		}
+	}
+	for i := range state.NFS {
+		vol := &state.NFS[i]
+		if !vol.Mounted {
+			problems = append(problems, fmt.Sprintf("NFS volume %s: staging path %s is not mounted", vol.VolumeID, vol.StagingPath))
+		}
+	}
+	for i := range state.Mounts {
+		m := &state.Mounts[i]
+		if m.Stale {
+			problems = append(problems, fmt.Sprintf("Mount %s of volume %s is stale: its device is gone", m.MountPoint, m.VolumeID))
+		}
+	}
+	return problems
+}
+
+// outputNodeState outputs the node state in the specified format.
+func outputNodeState(state *NodeState, format string) error {
+	switch format {
+	case outputFormatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(state)
+
+	case outputFormatYAML:
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		return enc.Encode(state)
+
+	case outputFormatTable, "":
+		return outputNodeStateTable(state)
+
+	default:
+		return fmt.Errorf("%w: %s", errUnknownOutputFormat, format)
+	}
+}
+
+// outputNodeStateTable outputs the node state as tables.
+func outputNodeStateTable(state *NodeState) error {
+	colorHeader.Printf("=== Node %s (%s) ===\n", state.NodeID, state.Pod) //nolint:errcheck,gosec
+	fmt.Printf("Collected: %s\n", state.CollectedAt.Local().Format(time.RFC3339))
+	if !state.StateFile {
+		colorWarning.Println("Node state is not persisted (--node-state-dir not set): staged volumes are not recorded") //nolint:errcheck,gosec
+	}
+	fmt.Println()
+
+	if len(state.NVMeOF) > 0 {
+		colorHeader.Println("=== Staged NVMe-oF Volumes ===") //nolint:errcheck,gosec
+		t := newStyledTable()
+		t.AppendHeader(table.Row{"VOLUME", "NQN", "SERVER", "SESSION", "STAGING PATH"})
+		for i := range state.NVMeOF {
+			vol := &state.NVMeOF[i]
+			t.AppendRow(table.Row{vol.VolumeID, truncateString(vol.NQN, 60), vol.Server, sessionBadge(vol.SessionState), truncateString(vol.StagingPath, 60)})
+		}
+		renderTable(t)
+		fmt.Println()
+	}
+
+	if len(state.NFS) > 0 {
+		colorHeader.Println("=== Staged NFS Volumes ===") //nolint:errcheck,gosec
+		t := newStyledTable()
+		t.AppendHeader(table.Row{"VOLUME", "SOURCE", "MOUNTED", "STAGING PATH"})
+		for i := range state.NFS {
+			vol := &state.NFS[i]
+			mounted := colorSuccess.Sprint(valueTrue)
+			if !vol.Mounted {
+				mounted = colorError.Sprint("false")
+			}
+			t.AppendRow(table.Row{vol.VolumeID, vol.Server + ":" + vol.Share, mounted, truncateString(vol.StagingPath, 60)})
+		}
+		renderTable(t)
+		fmt.Println()
+	}
+
+	if len(state.Sessions) > 0 {
+		colorHeader.Println("=== NVMe-oF Sessions ===") //nolint:errcheck,gosec
+		t := newStyledTable()
+		t.AppendHeader(table.Row{"CONTROLLER", "NQN", "ADDRESS", "STATE", "RECORDED"})
+		for i := range state.Sessions {
+			session := &state.Sessions[i]
+			t.AppendRow(table.Row{session.Controller, truncateString(session.NQN, 60), session.Address, sessionBadge(session.State), session.Recorded})
+		}
+		renderTable(t)
+		fmt.Println()
+	}
+
+	fmt.Printf("Mounts: %d, single-writer publications: %d\n\n", len(state.Mounts), len(state.SingleWriters))
+
+	if len(state.Problems) == 0 {
+		colorSuccess.Println("No inconsistencies found.") //nolint:errcheck,gosec
+		return nil
+	}
+	colorHeader.Println("=== Problems ===") //nolint:errcheck,gosec
+	for _, problem := range state.Problems {
+		colorWarning.Printf("  ! %s\n", problem) //nolint:errcheck,gosec
+	}
+	return nil
+}
+
+// sessionBadge returns a colored NVMe-oF session state.
+func sessionBadge(state string) string {
+	switch state {
+	case "live":
+		return colorSuccess.Sprint(state)
+	case "":
+		return colorError.Sprint("none")
+	default:
+		return colorWarning.Sprint(state)
+	}
+}
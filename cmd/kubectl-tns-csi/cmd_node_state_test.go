@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNodeStateProblems(t *testing.T) {
+	state := &NodeState{
+		StateFile: true,
+		NVMeOF: []NodeStateNVMeOF{
+			{VolumeID: "tank/healthy", NQN: "nqn.a", SessionState: "live", StagingExists: true},
+			{VolumeID: "tank/unstaged", NQN: "nqn.b", SessionState: "live"},
+			{VolumeID: "tank/disconnected", NQN: "nqn.c", StagingExists: true},
+			{VolumeID: "tank/connecting", NQN: "nqn.d", SessionState: "connecting", StagingExists: true},
+		},
+		Sessions: []NodeStateSession{
+			{Controller: "nvme0", NQN: "nqn.a", Recorded: true},
+			{Controller: "nvme1", NQN: "nqn.leaked"},
+		},
+		NFS: []NodeStateNFS{
+			{VolumeID: "tank/mounted", Mounted: true},
+			{VolumeID: "tank/unmounted", StagingPath: "/staging/nfs"},
+		},
+		Mounts: []NodeStateMount{
+			{MountPoint: "/pods/a", VolumeID: "tank/healthy"},
+			{MountPoint: "/pods/b", VolumeID: "tank/disconnected", Stale: true},
+		},
+	}
+
+	problems := nodeStateProblems(state)
+	for _, want := range []string{"tank/unstaged", "tank/disconnected: no session", "tank/connecting", "nvme1", "tank/unmounted", "/pods/b"} {
+		found := false
+		for _, problem := range problems {
+			if strings.Contains(problem, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("problems %q do not mention %q", problems, want)
+		}
+	}
+	if len(problems) != 6 {
+		t.Errorf("got %d problems, want 6: %q", len(problems), problems)
+	}
+
+	// Without a state file no session is recorded, so none is reported as leaked
+	state.StateFile = false
+	for _, problem := range nodeStateProblems(state) {
+		if strings.Contains(problem, "nvme1") {
+			t.Errorf("unexpected leaked session problem without state file: %q", problem)
+		}
+	}
+}
+
+func TestWaitForwardedPort(t *testing.T) {
+	output := "Forwarding from 127.0.0.1:41234 -> 9811\nForwarding from [::1]:41234 -> 9811\n"
+	port, err := waitForwardedPort(strings.NewReader(output), time.Second)
+	if err != nil || port != "41234" {
+		t.Errorf("waitForwardedPort() = %q, %v, want 41234", port, err)
+	}
+
+	_, err = waitForwardedPort(strings.NewReader("error: unable to forward port\n"), time.Second)
+	if !errors.Is(err, errPortForwardExited) {
+		t.Errorf("waitForwardedPort() error = %v, want %v", err, errPortForwardExited)
+	}
+}
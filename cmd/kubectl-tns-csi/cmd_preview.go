@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/dashboard"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+func newPreviewCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "preview",
+		Short: "Browse snapshot contents through a temporary read-only NFS export",
+		Long: `Browse snapshot contents before restoring them.
+
+A preview is a read-only clone of a snapshot exported over NFS (read-only) to the
+given hosts or networks for a limited time. Expired previews are removed automatically
+by the driver's controller (--preview-reap-interval) and by any later preview command.
+
+Only filesystem (NFS/SMB) snapshots can be previewed.`,
+	}
+
+	cmd.AddCommand(newPreviewCreateCmd(url, apiKey, secretRef, outputFormat, skipTLSVerify))
+	cmd.AddCommand(newPreviewListCmd(url, apiKey, secretRef, outputFormat, skipTLSVerify))
+	cmd.AddCommand(newPreviewDeleteCmd(url, apiKey, secretRef, skipTLSVerify))
+
+	return cmd
+}
+
+func newPreviewCreateCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool) *cobra.Command {
+	var ttl time.Duration
+	var access dashboard.PreviewAccess
+
+	cmd := &cobra.Command{
+		Use:   "create <dataset@snapshot>",
+		Short: "Export a snapshot read-only for inspection",
+		Long: `Export a snapshot read-only for inspection.
+
+Examples:
+  # Preview a snapshot for one hour (default) from one workstation
+  kubectl tns-csi preview create tank/k8s/pvc-xxx@snapshot-yyy --hosts 192.168.1.20
+
+  # Preview for 15 minutes from a subnet
+  kubectl tns-csi preview create tank/k8s/pvc-xxx@snapshot-yyy --networks 10.0.0.0/24 --ttl 15m`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPreviewCreate(cmd.Context(), args[0], ttl, access, url, apiKey, secretRef, outputFormat, skipTLSVerify)
+		},
+	}
+
+	cmd.Flags().DurationVar(&ttl, "ttl", dashboard.DefaultPreviewTTL, "How long the preview stays exported (max 168h)")
+	cmd.Flags().StringSliceVar(&access.Hosts, "hosts", nil, "Hosts allowed to mount the preview (--hosts or --networks is required)")
+	cmd.Flags().StringSliceVar(&access.Networks, "networks", nil, "Networks (CIDR) allowed to mount the preview (--hosts or --networks is required)")
+
+	return cmd
+}
+
+func newPreviewListCmd(url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List active snapshot previews",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runPreviewList(cmd.Context(), url, apiKey, secretRef, outputFormat, skipTLSVerify)
+		},
+	}
+}
+
+func newPreviewDeleteCmd(url, apiKey, secretRef *string, skipTLSVerify *bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete <preview-dataset>",
+		Short: "Remove a snapshot preview before its TTL expires",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPreviewDelete(cmd.Context(), args[0], url, apiKey, secretRef, skipTLSVerify)
+		},
+	}
+}
+
+func connectForPreview(ctx context.Context, url, apiKey, secretRef *string, skipTLSVerify *bool) (*TrueNASClient, *connectionConfig, error) {
+	cfg, err := getConnectionConfig(ctx, url, apiKey, secretRef, skipTLSVerify)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := connectToTrueNAS(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Clean up expired previews opportunistically: the dashboard reaper may not be running
+	if n, reapErr := dashboard.ReapExpiredPreviews(ctx, client, time.Now()); reapErr == nil && n > 0 {
+		printStepf(colorMuted, iconOK, "Removed %d expired preview(s)", n)
+	}
+
+	return client, cfg, nil
+}
+
+func runPreviewCreate(ctx context.Context, snapshot string, ttl time.Duration, access dashboard.PreviewAccess, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool) error {
+	if len(access.Hosts) == 0 && len(access.Networks) == 0 {
+		return dashboard.ErrPreviewAccessRequired
+	}
+
+	client, cfg, err := connectForPreview(ctx, url, apiKey, secretRef, skipTLSVerify)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	preview, err := dashboard.CreateSnapshotPreview(ctx, client, snapshot, ttl, access)
+	if err != nil {
+		return err
+	}
+
+	if *outputFormat != outputFormatTable && *outputFormat != "" {
+		return outputPreviews([]dashboard.PreviewInfo{*preview}, *outputFormat)
+	}
+
+	printStepf(colorSuccess, iconOK, "Preview of %s ready until %s", preview.Snapshot, preview.ExpiresAt.Local().Format(time.RFC1123))
+	fmt.Printf("\n  mount -t nfs -o ro %s:%s /mnt/preview\n\n", previewHost(cfg.URL), preview.SharePath)
+	return nil
+}
+
+func runPreviewList(ctx context.Context, url, apiKey, secretRef, outputFormat *string, skipTLSVerify *bool) error {
+	client, _, err := connectForPreview(ctx, url, apiKey, secretRef, skipTLSVerify)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	previews, err := dashboard.ListSnapshotPreviews(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to list previews: %w", err)
+	}
+	return outputPreviews(previews, *outputFormat)
+}
+
+func runPreviewDelete(ctx context.Context, dataset string, url, apiKey, secretRef *string, skipTLSVerify *bool) error {
+	client, _, err := connectForPreview(ctx, url, apiKey, secretRef, skipTLSVerify)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := dashboard.DeleteSnapshotPreview(ctx, client, dataset); err != nil {
+		return err
+	}
+	printStepf(colorSuccess, iconOK, "Removed preview %s", dataset)
+	return nil
+}
+
+// previewHost extracts the storage host from the API URL for the mount hint.
+func previewHost(apiURL string) string {
+	// HA pairs list both controllers; the first is as good a hint as any
+	if urls := tnsapi.ParseAPIURLs(apiURL); len(urls) > 0 {
+		apiURL = urls[0]
+	}
+	u, err := neturl.Parse(apiURL)
+	if err != nil || u.Hostname() == "" {
+		return "<truenas-host>"
+	}
+	return u.Hostname()
+}
+
+// outputPreviews outputs previews in the specified format.
+func outputPreviews(previews []dashboard.PreviewInfo, format string) error {
+	switch format {
+	case outputFormatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(previews)
+
+	case outputFormatYAML:
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		return enc.Encode(previews)
+
+	case outputFormatTable, "":
+		t := newStyledTable()
+		t.AppendHeader(table.Row{"PREVIEW", "SNAPSHOT", "SHARE_PATH", "EXPIRES"})
+		for _, p := range previews {
+			expires := time.Until(p.ExpiresAt).Round(time.Minute).String()
+			if time.Now().After(p.ExpiresAt) {
+				expires = colorWarning.Sprint("expired")
+			}
+			t.AppendRow(table.Row{p.Dataset, p.Snapshot, p.SharePath, expires})
+		}
+		renderTable(t)
+		return nil
+
+	default:
+		return fmt.Errorf("%w: %s", errUnknownOutputFormat, format)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/dashboard"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"github.com/spf13/cobra"
+)
+
+// Static errors for stream commands.
+var (
+	errStreamURLNeedsController = errors.New("--to and --from need --controller: the controller transfers the stream")
+	errStreamURLRequired        = errors.New("with --controller the stream goes through a URL (--to or --from)")
+	errStreamToTerminal         = errors.New("refusing to write a binary stream to a terminal (use -f <file> or redirect stdout)")
+	errIncrementalNeedsSnapshot = errors.New("--incremental-from needs --snapshot")
+)
+
+// exportSnapshotPrefix names the temporary snapshots of exports.
+const exportSnapshotPrefix = "tns-csi-export-"
+
+func newExportCmd(url, apiKey, secretRef *string, skipTLSVerify *bool, controllerRef *string) *cobra.Command {
+	var (
+		snapshotName    string
+		incrementalFrom string
+		file            string
+		to              string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export <volume>",
+		Short: "Export a volume as a raw zfs send stream",
+		Long: `Export a snapshot of a volume as a raw zfs send stream.
+
+Without --snapshot a temporary snapshot is taken and deleted afterwards. Streams
+are raw (zfs send -w) and include the dataset properties, so encrypted volumes
+stay encrypted and an imported volume keeps its tns-csi metadata.
+
+The stream is written to a file or stdout. With --controller the controller
+sends it with an HTTP PUT to --to instead (e.g. an S3 presigned URL), so no
+TrueNAS credentials are needed.
+
+Examples:
+  # Export a volume to a file
+  kubectl tns-csi export pvc-12345678-1234-1234-1234-123456789012 -f pvc.zfs
+
+  # Export an existing snapshot and compress it
+  kubectl tns-csi export pvc-xxx --snapshot nightly | zstd > pvc.zfs.zst
+
+  # Export the changes since an earlier snapshot
+  kubectl tns-csi export pvc-xxx --snapshot nightly-2 --incremental-from nightly-1 -f pvc.incr.zfs
+
+  # Let the controller upload the stream
+  kubectl tns-csi export tank/csi/pvc-xxx --controller kube-system/tns-csi-controller --to "$PRESIGNED_PUT_URL"`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if *controllerRef != "" {
+				if to == "" {
+					return errStreamURLRequired
+				}
+				return runControllerExport(cmd.Context(), *controllerRef, dashboard.ExportRequest{VolumeID: args[0], Snapshot: snapshotName, URL: to})
+			}
+			if to != "" {
+				return errStreamURLNeedsController
+			}
+			return runExport(cmd.Context(), args[0], url, apiKey, secretRef, skipTLSVerify, snapshotName, incrementalFrom, file)
+		},
+	}
+
+	cmd.Flags().StringVar(&snapshotName, "snapshot", "", "Export this existing snapshot instead of taking a temporary one")
+	cmd.Flags().StringVar(&incrementalFrom, "incremental-from", "", "Only export the changes since this earlier snapshot (needs --snapshot)")
+	cmd.Flags().StringVarP(&file, "file", "f", "-", "File to write the stream to, - for stdout")
+	cmd.Flags().StringVar(&to, "to", "", "URL the controller uploads the stream to (with --controller)")
+
+	return cmd
+}
+
+func newImportStreamCmd(url, apiKey, secretRef *string, skipTLSVerify *bool, controllerRef *string) *cobra.Command {
+	var (
+		file  string
+		from  string
+		force bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "import-stream <dataset>",
+		Short: "Receive a zfs send stream into a dataset",
+		Long: `Receive a zfs send stream, as written by export, into a dataset on TrueNAS.
+
+A full stream creates the dataset; an incremental stream updates a dataset
+holding its base snapshot. The received dataset keeps the tns-csi properties
+of the exported volume, so 'kubectl tns-csi adopt' can generate its PV and PVC.
+
+The stream is read from a file or stdin. With --controller the controller
+fetches it with an HTTP GET of --from instead.
+
+Examples:
+  # Restore a volume from a file
+  kubectl tns-csi import-stream tank/csi/pvc-restored -f pvc.zfs
+
+  # Restore a compressed stream
+  zstd -dc pvc.zfs.zst | kubectl tns-csi import-stream tank/csi/pvc-restored
+
+  # Let the controller download the stream
+  kubectl tns-csi import-stream tank/csi/pvc-restored --controller kube-system/tns-csi-controller --from "$PRESIGNED_GET_URL"`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if *controllerRef != "" {
+				if from == "" {
+					return errStreamURLRequired
+				}
+				return runControllerImport(cmd.Context(), *controllerRef, dashboard.ImportRequest{Dataset: args[0], URL: from, Force: force})
+			}
+			if from != "" {
+				return errStreamURLNeedsController
+			}
+			return runImportStream(cmd.Context(), args[0], url, apiKey, secretRef, skipTLSVerify, file, force)
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "-", "File to read the stream from, - for stdin")
+	cmd.Flags().StringVar(&from, "from", "", "URL the controller downloads the stream from (with --controller)")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace an existing dataset with a full stream (zfs receive -F)")
+
+	return cmd
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// exportStream writes the stream of a snapshot of a volume to w and returns the snapshot name and
+// the number of bytes written. Without snapshotName a temporary snapshot is exported.
+func exportStream(ctx context.Context, client tnsapi.ClientInterface, volumeRef, snapshotName, incrementalFrom string, w io.Writer) (string, int64, error) {
+	if incrementalFrom != "" && snapshotName == "" {
+		return "", 0, errIncrementalNeedsSnapshot
+	}
+	vol, err := findVolumeByRef(ctx, client, volumeRef)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if snapshotName == "" {
+		snapshotName = exportSnapshotPrefix + time.Now().UTC().Format("20060102-150405")
+		if _, err := client.CreateSnapshot(ctx, tnsapi.SnapshotCreateParams{Dataset: vol.Dataset, Name: snapshotName}); err != nil {
+			return "", 0, fmt.Errorf("failed to snapshot %s: %w", vol.Dataset, err)
+		}
+		defer func() {
+			if delErr := client.DeleteSnapshot(context.WithoutCancel(ctx), vol.Dataset+"@"+snapshotName); delErr != nil {
+				fmt.Fprintf(os.Stderr, "%s Failed to delete snapshot %s@%s: %v\n", colorWarning.Sprint(iconWarning), vol.Dataset, snapshotName, delErr)
+			}
+		}()
+	}
+
+	params := tnsapi.SnapshotSendParams{Snapshot: vol.Dataset + "@" + snapshotName, Raw: true, Properties: true}
+	if incrementalFrom != "" {
+		params.IncrementalBase = vol.Dataset + "@" + incrementalFrom
+	}
+	stream, err := client.SendSnapshot(ctx, params)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() { _ = stream.Close() }()
+
+	counted := &countingWriter{w: w}
+	if _, err := io.Copy(counted, stream); err != nil {
+		return "", counted.n, fmt.Errorf("failed to export %s: %w", params.Snapshot, err)
+	}
+	return snapshotName, counted.n, nil
+}
+
+func runExport(ctx context.Context, volumeRef string, url, apiKey, secretRef *string, skipTLSVerify *bool, snapshotName, incrementalFrom, file string) error {
+	out := io.Writer(os.Stdout)
+	if file == "-" {
+		if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			return errStreamToTerminal
+		}
+	} else {
+		f, err := os.Create(file) //nolint:gosec // path chosen by the user
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		out = f
+	}
+
+	cfg, err := getConnectionConfig(ctx, url, apiKey, secretRef, skipTLSVerify)
+	if err != nil {
+		return err
+	}
+	client, err := connectToTrueNAS(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	spin := newSpinner(fmt.Sprintf("Exporting %s...", volumeRef))
+	snapshot, n, err := exportStream(ctx, client, volumeRef, snapshotName, incrementalFrom, out)
+	spin.stop()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s Exported %s@%s (%s)\n", colorSuccess.Sprint(iconOK), volumeRef, snapshot, dashboard.FormatBytes(n))
+	return nil
+}
+
+func runImportStream(ctx context.Context, dataset string, url, apiKey, secretRef *string, skipTLSVerify *bool, file string, force bool) error {
+	in := io.Reader(os.Stdin)
+	if file != "-" {
+		f, err := os.Open(file) //nolint:gosec // path chosen by the user
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		in = f
+	}
+
+	cfg, err := getConnectionConfig(ctx, url, apiKey, secretRef, skipTLSVerify)
+	if err != nil {
+		return err
+	}
+	client, err := connectToTrueNAS(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	spin := newSpinner(fmt.Sprintf("Receiving stream into %s...", dataset))
+	err = client.ReceiveSnapshot(ctx, tnsapi.SnapshotReceiveParams{Dataset: dataset, Force: force}, in)
+	spin.stop()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s Received stream into %s\n", colorSuccess.Sprint(iconOK), dataset)
+	return nil
+}
+
+func runControllerExport(ctx context.Context, controllerRef string, req dashboard.ExportRequest) error {
+	var result dashboard.StreamResult
+	spin := newSpinner(fmt.Sprintf("Exporting %s through %s...", req.VolumeID, controllerRef))
+	err := postToController(ctx, controllerRef, dashboard.AdminExport, req, &result)
+	spin.stop()
+	if err != nil {
+		return err
+	}
+	printStepf(colorSuccess, iconOK, "Exported %s@%s (%s)", result.Dataset, result.Snapshot, dashboard.FormatBytes(result.Bytes))
+	return nil
+}
+
+func runControllerImport(ctx context.Context, controllerRef string, req dashboard.ImportRequest) error {
+	var result dashboard.StreamResult
+	spin := newSpinner(fmt.Sprintf("Receiving stream into %s through %s...", req.Dataset, controllerRef))
+	err := postToController(ctx, controllerRef, dashboard.AdminImport, req, &result)
+	spin.stop()
+	if err != nil {
+		return err
+	}
+	printStepf(colorSuccess, iconOK, "Received stream into %s (%s)", result.Dataset, dashboard.FormatBytes(result.Bytes))
+	return nil
+}
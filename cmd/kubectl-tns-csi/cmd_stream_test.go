@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+)
+
+func TestExportStream(t *testing.T) {
+	var created, deleted []string
+	var sent []tnsapi.SnapshotSendParams
+	mc := &mockClient{
+		FindDatasetByCSIVolumeNameFunc: func(_ context.Context, _, name string) (*tnsapi.DatasetWithProperties, error) {
+			if name != "pvc-1" {
+				return nil, nil
+			}
+			return &tnsapi.DatasetWithProperties{Dataset: tnsapi.Dataset{ID: "tank/csi/pvc-1"}}, nil
+		},
+		CreateSnapshotFunc: func(_ context.Context, params tnsapi.SnapshotCreateParams) (*tnsapi.Snapshot, error) {
+			created = append(created, params.Dataset+"@"+params.Name)
+			return &tnsapi.Snapshot{ID: params.Dataset + "@" + params.Name}, nil
+		},
+		DeleteSnapshotFunc: func(_ context.Context, snapshotID string) error {
+			deleted = append(deleted, snapshotID)
+			return nil
+		},
+		SendSnapshotFunc: func(_ context.Context, params tnsapi.SnapshotSendParams) (io.ReadCloser, error) {
+			sent = append(sent, params)
+			return io.NopCloser(strings.NewReader("stream of " + params.Snapshot)), nil
+		},
+	}
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	snapshot, n, err := exportStream(ctx, mc, "pvc-1", "", "", &out)
+	if err != nil {
+		t.Fatalf("exportStream() error = %v", err)
+	}
+	if !strings.HasPrefix(snapshot, exportSnapshotPrefix) || len(created) != 1 || len(deleted) != 1 || created[0] != deleted[0] {
+		t.Errorf("temporary snapshot %s: created %v, deleted %v", snapshot, created, deleted)
+	}
+	if !sent[0].Raw || !sent[0].Properties || n != int64(out.Len()) || out.String() != "stream of "+created[0] {
+		t.Errorf("sent %+v, wrote %d bytes %q", sent[0], n, out.String())
+	}
+
+	out.Reset()
+	if _, _, err := exportStream(ctx, mc, "pvc-1", "nightly-2", "nightly-1", &out); err != nil {
+		t.Fatalf("incremental exportStream() error = %v", err)
+	}
+	if sent[1].Snapshot != "tank/csi/pvc-1@nightly-2" || sent[1].IncrementalBase != "tank/csi/pvc-1@nightly-1" || len(created) != 1 {
+		t.Errorf("incremental send = %+v, snapshots created %v", sent[1], created)
+	}
+
+	if _, _, err := exportStream(ctx, mc, "pvc-1", "", "nightly-1", &out); !errors.Is(err, errIncrementalNeedsSnapshot) {
+		t.Errorf("exportStream() incremental without snapshot error = %v", err)
+	}
+}
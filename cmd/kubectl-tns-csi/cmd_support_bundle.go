@@ -0,0 +1,515 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Support bundle limits.
+const (
+	bundleDefaultLogLines = 2000
+	bundleMaxEvents       = 500
+	redactedValue         = "[REDACTED]"
+)
+
+// systemInfoFields are the system.info fields kept in a support bundle.
+// Hostname, serial numbers and the like are left out.
+var systemInfoFields = []string{
+	"version", "model", "cores", "physical_cores", "physmem", "uptime_seconds", "loadavg",
+}
+
+// Redaction patterns applied to everything written to a support bundle.
+var (
+	credentialPattern = regexp.MustCompile(`(?i)((?:api[_-]?key|password|passwd|secret|token|authorization)["']?\s*[:=]\s*["']?)[^\s"',}]+`)
+	bearerPattern     = regexp.MustCompile(`(?i)(bearer\s+)\S+`)
+	ipv4Pattern       = regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)
+)
+
+// BundleManifest describes the contents of a support bundle.
+//
+//nolint:govet // field alignment not critical for CLI output struct
+type BundleManifest struct {
+	CreatedAt     string   `json:"createdAt"`
+	PluginVersion string   `json:"pluginVersion"`
+	Anonymized    bool     `json:"anonymized"`
+	Files         []string `json:"files"`
+	Errors        []string `json:"errors,omitempty"`
+}
+
+func newSupportBundleCmd(url, apiKey, secretRef *string, skipTLSVerify *bool) *cobra.Command {
+	var (
+		outputFile    string
+		logLines      int64
+		keepAddresses bool
+		skipTrueNAS   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "support-bundle",
+		Short: "Collect diagnostics into a redacted tarball for bug reports",
+		Long: `Collect tns-csi diagnostics into a single .tar.gz file to attach to bug reports.
+
+The bundle contains:
+  - Logs of all tns-csi driver pods (controller and nodes)
+  - Driver pod status and a snapshot of the controller's Prometheus metrics
+  - StorageClasses using tns.csi.io, with sensitive parameters removed
+  - Recent Kubernetes events related to the driver and its volumes
+  - TrueNAS version and basic system information
+  - Datasets managed by tns-csi with their tns-csi properties
+
+Secrets are always redacted: the TrueNAS API key, values of password/token/key
+fields and bearer tokens. IP addresses and the TrueNAS hostname are replaced by
+stable placeholders unless --keep-addresses is set. Review the bundle before
+sharing it.
+
+Examples:
+  # Write tns-csi-support-<timestamp>.tar.gz to the current directory
+  kubectl tns-csi support-bundle
+
+  # Custom file name, more log lines
+  kubectl tns-csi support-bundle -f bundle.tar.gz --log-lines 10000
+
+  # Kubernetes-side information only
+  kubectl tns-csi support-bundle --skip-truenas`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if outputFile == "" {
+				outputFile = "tns-csi-support-" + time.Now().UTC().Format("20060102-150405") + ".tar.gz"
+			}
+			return runSupportBundle(cmd.Context(), url, apiKey, secretRef, skipTLSVerify,
+				outputFile, logLines, !keepAddresses, skipTrueNAS)
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputFile, "file", "f", "", "Output file (default tns-csi-support-<timestamp>.tar.gz)")
+	cmd.Flags().Int64Var(&logLines, "log-lines", bundleDefaultLogLines, "Number of log lines to collect per container")
+	cmd.Flags().BoolVar(&keepAddresses, "keep-addresses", false, "Do not anonymize IP addresses and the TrueNAS hostname")
+	cmd.Flags().BoolVar(&skipTrueNAS, "skip-truenas", false, "Do not connect to TrueNAS")
+
+	return cmd
+}
+
+func runSupportBundle(ctx context.Context, url, apiKey, secretRef *string, skipTLSVerify *bool,
+	outputFile string, logLines int64, anonymize, skipTrueNAS bool) error {
+
+	//nolint:gosec // G304: output path is chosen by the user
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", outputFile, err)
+	}
+	//nolint:errcheck // closed explicitly after the bundle is written; this only covers early returns
+	defer f.Close()
+
+	manifest := &BundleManifest{
+		CreatedAt:     time.Now().UTC().Format(time.RFC3339),
+		PluginVersion: version + " (" + commit + ")",
+		Anonymized:    anonymize,
+	}
+
+	// Resolve the TrueNAS connection first so its credentials can be redacted everywhere
+	var cfg *connectionConfig
+	if !skipTrueNAS {
+		cfg, err = getConnectionConfig(ctx, url, apiKey, secretRef, skipTLSVerify)
+		if err != nil {
+			manifest.Errors = append(manifest.Errors, "truenas: "+err.Error())
+		}
+	}
+
+	var secrets, hosts []string
+	if cfg != nil {
+		secrets = append(secrets, cfg.APIKey)
+		hosts = append(hosts, extractServerFromURL(cfg.URL))
+	}
+	bundle := newSupportBundle(f, newRedactor(secrets, hosts, anonymize))
+
+	spin := newSpinner("Collecting Kubernetes diagnostics...")
+	collectKubernetesDiagnostics(ctx, bundle, manifest, logLines)
+	spin.stop()
+
+	if cfg != nil {
+		spin = newSpinner("Collecting TrueNAS diagnostics...")
+		collectTrueNASDiagnostics(ctx, bundle, manifest, cfg)
+		spin.stop()
+	}
+
+	manifest.Files = bundle.files
+	if err := bundle.addJSON("manifest.json", manifest); err != nil {
+		return err
+	}
+	if err := bundle.close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outputFile, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outputFile, err)
+	}
+
+	printStepf(colorSuccess, iconOK, "Support bundle written to %s (%d files)", outputFile, len(manifest.Files)+1)
+	for _, collectErr := range manifest.Errors {
+		printStepf(colorWarning, iconWarning, "%s", collectErr)
+	}
+	fmt.Println("Review the bundle before attaching it to an issue.")
+	return nil
+}
+
+// collectKubernetesDiagnostics adds driver logs, pods, metrics, StorageClasses and events.
+// Failures are recorded in the manifest; collection continues with the next item.
+func collectKubernetesDiagnostics(ctx context.Context, bundle *supportBundle, manifest *BundleManifest, logLines int64) {
+	recordErr := func(what string, err error) {
+		manifest.Errors = append(manifest.Errors, what+": "+err.Error())
+	}
+
+	k8sClient, err := getK8sClient()
+	if err != nil {
+		recordErr("kubernetes", err)
+		return
+	}
+	driverNamespace := discoverDriverNamespace(ctx)
+
+	pods, err := k8sClient.CoreV1().Pods(driverNamespace).List(ctx, metav1.ListOptions{LabelSelector: driverLabelSelector})
+	if err != nil {
+		recordErr("driver pods", err)
+	} else {
+		if err := bundle.addYAML("kubernetes/driver-pods.yaml", summarizePods(pods.Items)); err != nil {
+			recordErr("driver pods", err)
+		}
+		for i := range pods.Items {
+			collectPodLogs(ctx, k8sClient, bundle, &pods.Items[i], logLines, recordErr)
+		}
+	}
+
+	if raw, err := fetchRawMetrics(ctx); err != nil {
+		recordErr("controller metrics", err)
+	} else if err := bundle.add("metrics/controller.prom", []byte(raw)); err != nil {
+		recordErr("controller metrics", err)
+	}
+
+	if classes, err := k8sClient.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{}); err != nil {
+		recordErr("storage classes", err)
+	} else {
+		var sanitized []map[string]interface{}
+		for i := range classes.Items {
+			sc := &classes.Items[i]
+			if sc.Provisioner != "tns.csi.io" {
+				continue
+			}
+			sanitized = append(sanitized, map[string]interface{}{
+				metaNameKey:            sc.Name,
+				"provisioner":          sc.Provisioner,
+				"parameters":           sanitizeParameters(sc.Parameters),
+				"reclaimPolicy":        sc.ReclaimPolicy,
+				"volumeBindingMode":    sc.VolumeBindingMode,
+				"allowVolumeExpansion": sc.AllowVolumeExpansion,
+				"mountOptions":         sc.MountOptions,
+			})
+		}
+		if err := bundle.addYAML("kubernetes/storageclasses.yaml", sanitized); err != nil {
+			recordErr("storage classes", err)
+		}
+	}
+
+	if events, err := k8sClient.CoreV1().Events("").List(ctx, metav1.ListOptions{}); err != nil {
+		recordErr("events", err)
+	} else if err := bundle.add("kubernetes/events.txt", []byte(formatEvents(relevantEvents(events.Items, driverNamespace)))); err != nil {
+		recordErr("events", err)
+	}
+}
+
+// collectPodLogs adds the current (and, after a restart, previous) logs of every container of a pod.
+func collectPodLogs(ctx context.Context, k8sClient *kubernetes.Clientset, bundle *supportBundle, pod *corev1.Pod, logLines int64, recordErr func(string, error)) {
+	for _, status := range pod.Status.ContainerStatuses {
+		previous := []bool{false}
+		if status.RestartCount > 0 {
+			previous = append(previous, true)
+		}
+		for _, prev := range previous {
+			opts := &corev1.PodLogOptions{Container: status.Name, TailLines: &logLines, Previous: prev}
+			logs, err := k8sClient.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, opts).DoRaw(ctx)
+			name := fmt.Sprintf("logs/%s/%s.log", pod.Name, status.Name)
+			if prev {
+				name = fmt.Sprintf("logs/%s/%s.previous.log", pod.Name, status.Name)
+			}
+			if err != nil {
+				recordErr(name, err)
+				continue
+			}
+			if err := bundle.add(name, logs); err != nil {
+				recordErr(name, err)
+			}
+		}
+	}
+}
+
+// collectTrueNASDiagnostics adds TrueNAS system information and tns-csi managed datasets.
+func collectTrueNASDiagnostics(ctx context.Context, bundle *supportBundle, manifest *BundleManifest, cfg *connectionConfig) {
+	recordErr := func(what string, err error) {
+		manifest.Errors = append(manifest.Errors, what+": "+err.Error())
+	}
+
+	client, err := connectToTrueNAS(ctx, cfg)
+	if err != nil {
+		recordErr("truenas", err)
+		return
+	}
+	defer client.Close()
+
+	var info map[string]interface{}
+	if err := client.Call(ctx, "system.info", []interface{}{}, &info); err != nil {
+		recordErr("system.info", err)
+	} else if err := bundle.addJSON("truenas/system-info.json", filterSystemInfo(info)); err != nil {
+		recordErr("system.info", err)
+	}
+
+	datasets, err := client.FindManagedDatasets(ctx, "")
+	if err != nil {
+		recordErr("managed datasets", err)
+		return
+	}
+	type datasetSummary struct {
+		Properties map[string]string `json:"properties"`
+		Used       interface{}       `json:"used,omitempty"`
+		Available  interface{}       `json:"available,omitempty"`
+		Volsize    interface{}       `json:"volsize,omitempty"`
+		ID         string            `json:"id"`
+		Type       string            `json:"type"`
+	}
+	summaries := make([]datasetSummary, 0, len(datasets))
+	for i := range datasets {
+		ds := &datasets[i]
+		summary := datasetSummary{
+			ID:         ds.ID,
+			Type:       ds.Type,
+			Used:       ds.Used["parsed"],
+			Available:  ds.Available["parsed"],
+			Volsize:    ds.Volsize["parsed"],
+			Properties: make(map[string]string),
+		}
+		for name, prop := range ds.UserProperties {
+			if strings.HasPrefix(name, "tns-csi:") {
+				summary.Properties[name] = prop.Value
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	if err := bundle.addJSON("truenas/managed-datasets.json", summaries); err != nil {
+		recordErr("managed datasets", err)
+	}
+}
+
+// summarizePods reduces driver pods to the fields relevant for debugging.
+func summarizePods(pods []corev1.Pod) []map[string]interface{} {
+	summaries := make([]map[string]interface{}, 0, len(pods))
+	for i := range pods {
+		pod := &pods[i]
+		containers := make([]map[string]interface{}, 0, len(pod.Status.ContainerStatuses))
+		for _, status := range pod.Status.ContainerStatuses {
+			containers = append(containers, map[string]interface{}{
+				metaNameKey:    status.Name,
+				"image":        status.Image,
+				"ready":        status.Ready,
+				"restartCount": status.RestartCount,
+			})
+		}
+		summaries = append(summaries, map[string]interface{}{
+			metaNameKey:  pod.Name,
+			"namespace":  pod.Namespace,
+			"node":       pod.Spec.NodeName,
+			"phase":      pod.Status.Phase,
+			"containers": containers,
+		})
+	}
+	return summaries
+}
+
+// sanitizeParameters redacts StorageClass parameters that look like credentials.
+// Secret references (csi.storage.k8s.io/*-secret-name) are kept.
+func sanitizeParameters(params map[string]string) map[string]string {
+	sanitized := make(map[string]string, len(params))
+	for key, value := range params {
+		lower := strings.ToLower(key)
+		isReference := strings.HasSuffix(lower, "-secret-name") || strings.HasSuffix(lower, "-secret-namespace")
+		if !isReference && (strings.Contains(lower, "password") || strings.Contains(lower, "passphrase") || strings.Contains(lower, "apikey") ||
+			strings.Contains(lower, "api-key") || strings.Contains(lower, "token") || strings.Contains(lower, "secret")) {
+			value = redactedValue
+		}
+		sanitized[key] = value
+	}
+	return sanitized
+}
+
+// filterSystemInfo keeps the non-identifying system.info fields.
+func filterSystemInfo(info map[string]interface{}) map[string]interface{} {
+	filtered := make(map[string]interface{})
+	for _, field := range systemInfoFields {
+		if value, ok := info[field]; ok {
+			filtered[field] = value
+		}
+	}
+	return filtered
+}
+
+// relevantEvents returns the most recent events that involve the driver: events in the
+// driver namespace and events emitted by or mentioning tns.csi.io.
+func relevantEvents(events []corev1.Event, driverNamespace string) []corev1.Event {
+	var relevant []corev1.Event
+	for i := range events {
+		e := &events[i]
+		if e.Namespace == driverNamespace ||
+			strings.Contains(e.Source.Component, "tns.csi.io") ||
+			strings.Contains(e.ReportingController, "tns.csi.io") ||
+			strings.Contains(e.Message, "tns.csi.io") {
+			relevant = append(relevant, *e)
+		}
+	}
+	sort.SliceStable(relevant, func(i, j int) bool {
+		return eventTime(&relevant[i]).Before(eventTime(&relevant[j]))
+	})
+	if len(relevant) > bundleMaxEvents {
+		relevant = relevant[len(relevant)-bundleMaxEvents:]
+	}
+	return relevant
+}
+
+// eventTime returns the best available timestamp of an event.
+func eventTime(e *corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.CreationTimestamp.Time
+	}
+}
+
+// formatEvents renders events one per line, like kubectl get events.
+func formatEvents(events []corev1.Event) string {
+	var b strings.Builder
+	for i := range events {
+		e := &events[i]
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s/%s/%s\t%s\n", eventTime(e).UTC().Format(time.RFC3339), e.Type, e.Reason,
+			e.InvolvedObject.Kind, e.InvolvedObject.Namespace, e.InvolvedObject.Name, e.Message)
+	}
+	return b.String()
+}
+
+// newRedactor returns a function that removes secrets from text. With anonymize set,
+// IPv4 addresses and the given hostnames are replaced by stable placeholders so that
+// related lines can still be correlated.
+func newRedactor(secrets, hosts []string, anonymize bool) func(string) string {
+	addresses := make(map[string]string)
+	return func(text string) string {
+		for _, secret := range secrets {
+			if secret != "" {
+				text = strings.ReplaceAll(text, secret, redactedValue)
+			}
+		}
+		text = credentialPattern.ReplaceAllString(text, "${1}"+redactedValue)
+		text = bearerPattern.ReplaceAllString(text, "${1}"+redactedValue)
+		if !anonymize {
+			return text
+		}
+		for _, host := range hosts {
+			if host != "" && !ipv4Pattern.MatchString(host) {
+				text = strings.ReplaceAll(text, host, "truenas-host")
+			}
+		}
+		var b strings.Builder
+		last := 0
+		for _, loc := range ipv4Pattern.FindAllStringIndex(text, -1) {
+			// Skip dotted numbers that are part of a word, such as TrueNAS-SCALE-25.04.2.1
+			if loc[0] > 0 && isWordByte(text[loc[0]-1]) {
+				continue
+			}
+			ip := text[loc[0]:loc[1]]
+			placeholder, ok := addresses[ip]
+			if !ok {
+				placeholder = fmt.Sprintf("ip-%d", len(addresses)+1)
+				addresses[ip] = placeholder
+			}
+			b.WriteString(text[last:loc[0]])
+			b.WriteString(placeholder)
+			last = loc[1]
+		}
+		b.WriteString(text[last:])
+		return b.String()
+	}
+}
+
+func isWordByte(c byte) bool {
+	return c == '-' || c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// supportBundle writes redacted files into a gzipped tarball.
+type supportBundle struct {
+	gz     *gzip.Writer
+	tw     *tar.Writer
+	redact func(string) string
+	files  []string
+	now    time.Time
+}
+
+func newSupportBundle(w io.Writer, redact func(string) string) *supportBundle {
+	gz := gzip.NewWriter(w)
+	return &supportBundle{
+		gz:     gz,
+		tw:     tar.NewWriter(gz),
+		redact: redact,
+		now:    time.Now(),
+	}
+}
+
+// add writes a redacted file into the bundle.
+func (b *supportBundle) add(name string, data []byte) error {
+	content := []byte(b.redact(string(data)))
+	hdr := &tar.Header{
+		Name:    "tns-csi-support/" + name,
+		Mode:    0o600,
+		Size:    int64(len(content)),
+		ModTime: b.now,
+	}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	if _, err := b.tw.Write(content); err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	b.files = append(b.files, name)
+	return nil
+}
+
+func (b *supportBundle) addJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", name, err)
+	}
+	return b.add(name, data)
+}
+
+func (b *supportBundle) addYAML(name string, v interface{}) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", name, err)
+	}
+	return b.add(name, data)
+}
+
+func (b *supportBundle) close() error {
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	return b.gz.Close()
+}
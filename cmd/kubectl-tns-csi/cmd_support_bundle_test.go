@@ -0,0 +1,183 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRedactor(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		want      string
+		secrets   []string
+		hosts     []string
+		anonymize bool
+	}{
+		{
+			name:    "known secret",
+			input:   "connecting with key 1-abcdef",
+			secrets: []string{"1-abcdef"},
+			want:    "connecting with key [REDACTED]",
+		},
+		{
+			name:  "credential fields",
+			input: `apiKey: "1-xyz" password=hunter2 {"token":"t0k"}`,
+			want:  `apiKey: "[REDACTED]" password=[REDACTED] {"token":"[REDACTED]"}`,
+		},
+		{
+			name:  "bearer token",
+			input: "Authorization header: Bearer eyJhbGciOi",
+			want:  "Authorization header: Bearer [REDACTED]",
+		},
+		{
+			name:  "addresses kept without anonymize",
+			input: "server=10.0.0.5",
+			want:  "server=10.0.0.5",
+		},
+		{
+			name:      "addresses and host anonymized consistently",
+			input:     "nas.example.com 10.0.0.5 -> 10.0.0.6, retry 10.0.0.5",
+			hosts:     []string{"nas.example.com"},
+			anonymize: true,
+			want:      "truenas-host ip-1 -> ip-2, retry ip-1",
+		},
+		{
+			name:      "version strings are not addresses",
+			input:     "TrueNAS-SCALE-25.04.2.1",
+			anonymize: true,
+			want:      "TrueNAS-SCALE-25.04.2.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redact := newRedactor(tt.secrets, tt.hosts, tt.anonymize)
+			if got := redact(tt.input); got != tt.want {
+				t.Errorf("redact(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeParameters(t *testing.T) {
+	params := map[string]string{
+		"protocol": "nfs",
+		"csi.storage.k8s.io/provisioner-secret-name":      "tns-csi-secret",
+		"csi.storage.k8s.io/provisioner-secret-namespace": "kube-system",
+		"encryptionPassphrase":                            "plain",
+		"apiKey":                                          "1-abc",
+		"smbPassword":                                     "pw",
+	}
+	got := sanitizeParameters(params)
+
+	for _, kept := range []string{"protocol", "csi.storage.k8s.io/provisioner-secret-name", "csi.storage.k8s.io/provisioner-secret-namespace"} {
+		if got[kept] != params[kept] {
+			t.Errorf("%s = %q, want it kept as %q", kept, got[kept], params[kept])
+		}
+	}
+	for _, redacted := range []string{"apiKey", "smbPassword", "encryptionPassphrase"} {
+		if got[redacted] != redactedValue {
+			t.Errorf("%s = %q, want %q", redacted, got[redacted], redactedValue)
+		}
+	}
+}
+
+func TestFilterSystemInfo(t *testing.T) {
+	info := map[string]interface{}{
+		"version":        "25.04.2",
+		"hostname":       "nas01",
+		"system_serial":  "ABC123",
+		"physmem":        float64(68719476736),
+		"uptime_seconds": float64(1000),
+	}
+	got := filterSystemInfo(info)
+	if _, ok := got["hostname"]; ok {
+		t.Error("hostname must not be included")
+	}
+	if _, ok := got["system_serial"]; ok {
+		t.Error("serial number must not be included")
+	}
+	if got["version"] != "25.04.2" || got["physmem"] != float64(68719476736) {
+		t.Errorf("unexpected filtered info %v", got)
+	}
+}
+
+func TestRelevantEvents(t *testing.T) {
+	at := func(minute int) metav1.Time {
+		return metav1.NewTime(time.Date(2026, 1, 1, 0, minute, 0, 0, time.UTC))
+	}
+	events := []corev1.Event{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "apps"}, Message: "unrelated", LastTimestamp: at(1)},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "apps"}, Message: "waiting for a volume to be created by external provisioner \"tns.csi.io\"", LastTimestamp: at(3)},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system"}, Reason: "BackOff", LastTimestamp: at(2)},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "apps"}, Source: corev1.EventSource{Component: "tns.csi.io_controller"}, LastTimestamp: at(0)},
+	}
+
+	got := relevantEvents(events, "kube-system")
+	if len(got) != 3 {
+		t.Fatalf("got %d events, want 3", len(got))
+	}
+	for i := 1; i < len(got); i++ {
+		if eventTime(&got[i]).Before(eventTime(&got[i-1])) {
+			t.Errorf("events not sorted by time: %v", got)
+		}
+	}
+}
+
+func TestSupportBundleWriter(t *testing.T) {
+	var buf bytes.Buffer
+	bundle := newSupportBundle(&buf, newRedactor([]string{"1-secret"}, nil, false))
+	if err := bundle.add("logs/controller.log", []byte("auth with 1-secret ok\n")); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := bundle.addJSON("manifest.json", &BundleManifest{PluginVersion: "dev"}); err != nil {
+		t.Fatalf("addJSON: %v", err)
+	}
+	if err := bundle.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("bundle is not gzipped: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	contents := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading tar: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading %s: %v", hdr.Name, err)
+		}
+		contents[hdr.Name] = string(data)
+	}
+
+	log, ok := contents["tns-csi-support/logs/controller.log"]
+	if !ok {
+		t.Fatalf("log file missing, got %v", contents)
+	}
+	if strings.Contains(log, "1-secret") {
+		t.Errorf("secret not redacted: %q", log)
+	}
+	if !strings.Contains(contents["tns-csi-support/manifest.json"], `"pluginVersion": "dev"`) {
+		t.Errorf("unexpected manifest %q", contents["tns-csi-support/manifest.json"])
+	}
+	if len(bundle.files) != 2 {
+		t.Errorf("files = %v, want 2 entries", bundle.files)
+	}
+}
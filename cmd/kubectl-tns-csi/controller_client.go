@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fenio/tns-csi/pkg/dashboard"
+	authnv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Reading from the controller's admin API (--controller).
+//
+// With --controller namespace/service[:port] the read-only commands (list, list-snapshots,
+// list-orphaned, health) ask the controller's admin API instead of connecting to TrueNAS, so
+// they need no TrueNAS credentials, only Kubernetes RBAC. export and import-stream POST the
+// corresponding admin API actions. Requests go through the Kubernetes API server's service proxy
+// to the controller's HTTPS port. The API server authenticates the user with their own
+// credentials and drops them; the admin token header carries a short-lived token for the admin
+// API audience, requested for the <service>-viewer (reads) or <service>-operator (actions)
+// ServiceAccount, which the controller verifies with a TokenReview and SubjectAccessReview.
+
+// defaultAdminPort is the service port name the Helm chart gives the admin API.
+const defaultAdminPort = "admin"
+
+// adminTokenTTL is how long tokens requested for the admin API are valid (the API server's minimum).
+const adminTokenTTL int64 = 600
+
+// adminServiceAccount returns the ServiceAccount whose tokens call method on the admin API
+// Service: <service>-viewer for reads, <service>-operator for actions.
+func adminServiceAccount(service, method string) string {
+	if method == http.MethodGet {
+		return service + "-viewer"
+	}
+	return service + "-operator"
+}
+
+// requestAdminToken requests a token for the admin API audience for serviceAccount.
+func requestAdminToken(ctx context.Context, client kubernetes.Interface, namespace, serviceAccount string) (string, error) {
+	ttl := adminTokenTTL
+	resp, err := client.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, serviceAccount, &authnv1.TokenRequest{
+		Spec: authnv1.TokenRequestSpec{
+			Audiences:         []string{dashboard.AdminTokenAudience},
+			ExpirationSeconds: &ttl,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to request an admin API token for ServiceAccount %s/%s: %w", namespace, serviceAccount, err)
+	}
+	return resp.Status.Token, nil
+}
+
+var errInvalidControllerRef = errors.New("invalid --controller: want namespace/service[:port]")
+
+// parseControllerRef splits a --controller value into namespace, service and port.
+func parseControllerRef(ref string) (namespace, service, port string, err error) {
+	namespace, service, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || service == "" {
+		return "", "", "", fmt.Errorf("%w: %q", errInvalidControllerRef, ref)
+	}
+	service, port, _ = strings.Cut(service, ":")
+	if port == "" {
+		port = defaultAdminPort
+	}
+	return namespace, service, port, nil
+}
+
+// fetchFromController decodes an admin API endpoint of the controller into out.
+func fetchFromController(ctx context.Context, controllerRef, endpoint string, out any) error {
+	return callController(ctx, controllerRef, http.MethodGet, endpoint, nil, out)
+}
+
+// postToController POSTs in to an admin API action of the controller and decodes the result into out.
+func postToController(ctx context.Context, controllerRef, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %w", action, err)
+	}
+	return callController(ctx, controllerRef, http.MethodPost, action, body, out)
+}
+
+// callController sends a request to the controller's admin API through the service proxy.
+func callController(ctx context.Context, controllerRef, method, endpoint string, in []byte, out any) error {
+	namespace, service, port, err := parseControllerRef(controllerRef)
+	if err != nil {
+		return err
+	}
+
+	config, err := getK8sConfig()
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	token, err := requestAdminToken(ctx, client, namespace, adminServiceAccount(service, method))
+	if err != nil {
+		return err
+	}
+
+	req := client.CoreV1().RESTClient().Verb(method).
+		Namespace(namespace).
+		Resource("services").
+		Name("https:"+service+":"+port).
+		SubResource("proxy").
+		Suffix(strings.TrimPrefix(dashboard.AdminAPIPrefix, "/")+endpoint).
+		SetHeader(dashboard.AdminTokenHeader, "Bearer "+token)
+	if in != nil {
+		req = req.SetHeader("Content-Type", "application/json").Body(in)
+	}
+	body, err := req.DoRaw(ctx)
+	if err != nil {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("controller %s: %s", controllerRef, apiErr.Error)
+		}
+		return fmt.Errorf("controller %s: %w", controllerRef, err)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("controller %s returned an invalid %s response: %w", controllerRef, endpoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/fenio/tns-csi/pkg/dashboard"
+	authnv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseControllerRef(t *testing.T) {
+	tests := []struct {
+		ref                      string
+		namespace, service, port string
+		wantErr                  bool
+	}{
+		{ref: "kube-system/tns-csi-driver-admin", namespace: "kube-system", service: "tns-csi-driver-admin", port: defaultAdminPort},
+		{ref: "storage/tns-admin:9091", namespace: "storage", service: "tns-admin", port: "9091"},
+		{ref: "tns-csi-driver-admin", wantErr: true},
+		{ref: "/svc", wantErr: true},
+		{ref: "ns/", wantErr: true},
+	}
+	for _, tt := range tests {
+		namespace, service, port, err := parseControllerRef(tt.ref)
+		if tt.wantErr {
+			if !errors.Is(err, errInvalidControllerRef) {
+				t.Errorf("parseControllerRef(%q) error = %v, want %v", tt.ref, err, errInvalidControllerRef)
+			}
+			continue
+		}
+		if err != nil || namespace != tt.namespace || service != tt.service || port != tt.port {
+			t.Errorf("parseControllerRef(%q) = %q, %q, %q, %v", tt.ref, namespace, service, port, err)
+		}
+	}
+}
+
+func TestRequestAdminToken(t *testing.T) {
+	client := fake.NewClientset()
+	var got *authnv1.TokenRequest
+	client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create := action.(k8stesting.CreateAction)
+		if create.GetSubresource() != "token" || create.GetNamespace() != "kube-system" {
+			return false, nil, nil
+		}
+		got = create.GetObject().(*authnv1.TokenRequest)
+		resp := got.DeepCopy()
+		resp.Status.Token = "admin-api-token"
+		return true, resp, nil
+	})
+
+	for method, want := range map[string]string{http.MethodGet: "tns-csi-driver-admin-viewer", http.MethodPost: "tns-csi-driver-admin-operator"} {
+		if sa := adminServiceAccount("tns-csi-driver-admin", method); sa != want {
+			t.Errorf("adminServiceAccount(%s) = %q, want %q", method, sa, want)
+		}
+	}
+
+	token, err := requestAdminToken(context.Background(), client, "kube-system", "tns-csi-driver-admin-viewer")
+	if err != nil || token != "admin-api-token" {
+		t.Fatalf("requestAdminToken() = %q, %v; want admin-api-token", token, err)
+	}
+	if !slices.Equal(got.Spec.Audiences, []string{dashboard.AdminTokenAudience}) || got.Spec.ExpirationSeconds == nil {
+		t.Errorf("token request = %+v, want audience %s and an expiry", got.Spec, dashboard.AdminTokenAudience)
+	}
+}
@@ -17,6 +17,8 @@ type (
 	HealthReport           = dashboard.HealthReport
 	HealthSummary          = dashboard.HealthSummary
 	K8sVolumeBinding       = dashboard.K8sVolumeBinding
+	K8sNodeUsage           = dashboard.K8sNodeUsage
+	K8sPodUsage            = dashboard.K8sPodUsage
 	K8sEnrichmentResult    = dashboard.K8sEnrichmentResult
 	VolumeDetails          = dashboard.VolumeDetails
 	NFSShareDetails        = dashboard.NFSShareDetails
@@ -31,4 +33,7 @@ type (
 	PaginatedSnapshots     = dashboard.PaginatedSnapshots
 	PaginatedClones        = dashboard.PaginatedClones
 	PaginatedUnmanaged     = dashboard.PaginatedUnmanaged
+	OrphanedVolumeInfo     = dashboard.OrphanedVolume
+	pvInfo                 = dashboard.PVInfo
+	pvcInfo                = dashboard.PVCInfo
 )
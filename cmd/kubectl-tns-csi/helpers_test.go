@@ -183,3 +183,72 @@ func TestExtractConfigFromSecretData(t *testing.T) {
 		})
 	}
 }
+
+func TestParseBackupClass(t *testing.T) {
+	tests := []struct {
+		data    map[string]string
+		want    *backupClass
+		name    string
+		wantErr bool
+	}{
+		{
+			name: "defaults prefix",
+			data: map[string]string{"credentials": "my-s3", "bucket": "backups"},
+			want: &backupClass{Credentials: "my-s3", Bucket: "backups", Prefix: defaultBackupPrefix},
+		},
+		{
+			name: "custom prefix and transfers",
+			data: map[string]string{"credentials": "3", "bucket": "backups", "prefix": "/cluster-a/", "transfers": "8"},
+			want: &backupClass{Credentials: "3", Bucket: "backups", Prefix: "cluster-a", Transfers: 8},
+		},
+		{
+			name:    "missing bucket",
+			data:    map[string]string{"credentials": "my-s3"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid transfers",
+			data:    map[string]string{"credentials": "my-s3", "bucket": "backups", "transfers": "0"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBackupClass(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBackupClass() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBackupClass() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBackupRef(t *testing.T) {
+	tests := []struct {
+		ref        string
+		wantVolume string
+		wantBackup string
+		wantErr    bool
+	}{
+		{ref: "pvc-abc/tns-csi-backup-20240601-120000", wantVolume: "pvc-abc", wantBackup: "tns-csi-backup-20240601-120000"},
+		{ref: "tank/k8s/pvc-abc/nightly", wantVolume: "pvc-abc", wantBackup: "nightly"},
+		{ref: "pvc-abc", wantErr: true},
+		{ref: "pvc-abc/", wantErr: true},
+		{ref: "/nightly", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			volume, backup, err := parseBackupRef(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBackupRef(%q) error = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			}
+			if volume != tt.wantVolume || backup != tt.wantBackup {
+				t.Errorf("parseBackupRef(%q) = (%q, %q), want (%q, %q)", tt.ref, volume, backup, tt.wantVolume, tt.wantBackup)
+			}
+		})
+	}
+}
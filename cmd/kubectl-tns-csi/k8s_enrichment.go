@@ -2,14 +2,15 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"time"
 
+	"github.com/fenio/tns-csi/pkg/dashboard"
+	storagev1 "k8s.io/api/storage/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 )
 
-// enrichWithK8sData fetches K8s PV/PVC data and optionally pod data.
+// enrichWithK8sData fetches K8s PV/PVC data and optionally pod and VolumeAttachment data.
 // Returns best-effort results — if K8s is unavailable, Available will be false.
 func enrichWithK8sData(ctx context.Context, includePods bool) *K8sEnrichmentResult {
 	result := &K8sEnrichmentResult{
@@ -45,37 +46,20 @@ func enrichWithK8sData(ctx context.Context, includePods bool) *K8sEnrichmentResu
 		result.Bindings[volumeID] = binding
 	}
 
-	// Optionally scan pods for PVC usage
+	// Optionally scan pods and VolumeAttachments for volume usage
 	if includePods {
 		pods, err := client.CoreV1().Pods("").List(enrichCtx, metav1.ListOptions{})
 		if err != nil {
 			klog.V(4).Infof("K8s enrichment failed to list pods: %v", err)
 			return result
 		}
-
-		// Build a reverse map: "namespace/pvcName" -> list of "namespace/podName"
-		pvcToPods := make(map[string][]string)
-		for i := range pods.Items {
-			pod := &pods.Items[i]
-			for j := range pod.Spec.Volumes {
-				pvc := pod.Spec.Volumes[j].PersistentVolumeClaim
-				if pvc != nil {
-					key := pod.Namespace + "/" + pvc.ClaimName
-					podRef := fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
-					pvcToPods[key] = append(pvcToPods[key], podRef)
-				}
-			}
-		}
-
-		// Attach pod lists to bindings
-		for _, binding := range result.Bindings {
-			if binding.PVCName != "" && binding.PVCNamespace != "" {
-				key := binding.PVCNamespace + "/" + binding.PVCName
-				if podRefs, ok := pvcToPods[key]; ok {
-					binding.Pods = podRefs
-				}
-			}
+		var attachments []storagev1.VolumeAttachment
+		if vaList, vaErr := client.StorageV1().VolumeAttachments().List(enrichCtx, metav1.ListOptions{}); vaErr != nil {
+			klog.V(4).Infof("K8s enrichment failed to list VolumeAttachments: %v", vaErr)
+		} else {
+			attachments = vaList.Items
 		}
+		dashboard.AddVolumeUsage(result.Bindings, pods.Items, attachments)
 	}
 
 	return result
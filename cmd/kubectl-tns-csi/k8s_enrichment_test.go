@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fenio/tns-csi/pkg/dashboard"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAddVolumeUsage(t *testing.T) {
+	pod := func(name, node string, phase corev1.PodPhase, terminating bool) corev1.Pod {
+		p := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps"},
+			Spec: corev1.PodSpec{NodeName: node, Volumes: []corev1.Volume{{
+				Name:         "data",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}},
+			}}},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+		if terminating {
+			p.DeletionTimestamp = &metav1.Time{}
+		}
+		return p
+	}
+	pvName := "pv-data"
+	attachment := storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: "csi-1", DeletionTimestamp: &metav1.Time{}},
+		Spec: storagev1.VolumeAttachmentSpec{
+			Attacher: "tns.csi.io",
+			NodeName: "worker-a",
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: &pvName},
+		},
+		Status: storagev1.VolumeAttachmentStatus{Attached: true},
+	}
+
+	bindings := map[string]*K8sVolumeBinding{
+		"tank/csi/pvc-1": {PVName: pvName, PVCName: "data", PVCNamespace: "apps"},
+		"tank/csi/pvc-2": {PVName: "pv-other", PVCName: "other", PVCNamespace: "apps"},
+	}
+	dashboard.AddVolumeUsage(bindings, []corev1.Pod{
+		pod("web-1", "worker-b", corev1.PodRunning, false),
+		pod("web-0", "worker-a", corev1.PodRunning, true),
+		pod("web-2", "", corev1.PodPending, false),
+	}, []storagev1.VolumeAttachment{attachment})
+
+	got := bindings["tank/csi/pvc-1"]
+	if want := []string{"apps/web-1", "apps/web-0", "apps/web-2"}; !reflect.DeepEqual(got.Pods, want) {
+		t.Errorf("Pods = %v, want %v", got.Pods, want)
+	}
+	wantNodes := []K8sNodeUsage{
+		{Node: "worker-a", Attachment: "Detaching", Pods: []K8sPodUsage{{Name: "apps/web-0", Phase: "Terminating"}}},
+		{Node: "worker-b", Pods: []K8sPodUsage{{Name: "apps/web-1", Phase: "Running"}}},
+	}
+	if !reflect.DeepEqual(got.Nodes, wantNodes) {
+		t.Errorf("Nodes = %+v, want %+v", got.Nodes, wantNodes)
+	}
+	if other := bindings["tank/csi/pvc-2"]; len(other.Pods) != 0 || len(other.Nodes) != 0 {
+		t.Errorf("unused volume has usage %+v", other)
+	}
+
+	if summary := describeNodeUsage(&wantNodes[0]); summary != "worker-a: apps/web-0 (Terminating), VolumeAttachment Detaching" {
+		t.Errorf("describeNodeUsage() = %q", summary)
+	}
+}
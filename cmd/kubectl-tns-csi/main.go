@@ -12,6 +12,10 @@
 //	kubectl tns-csi adopt <dataset-path>     # Generate static PV manifest
 //	kubectl tns-csi status <pvc-name>        # Show volume status from TrueNAS
 //	kubectl tns-csi connectivity             # Test TrueNAS connection
+//	kubectl tns-csi compat-check --parent tank/k8s  # Exercise the TrueNAS APIs per protocol
+//	kubectl tns-csi backup create <volume>   # Export a volume snapshot to S3
+//	kubectl tns-csi jobs --watch             # Follow TrueNAS jobs started by the driver
+//	kubectl tns-csi support-bundle           # Collect redacted diagnostics for bug reports
 package main
 
 import (
@@ -40,6 +44,7 @@ func newRootCmd() *cobra.Command {
 		outputFormat  string
 		skipTLSVerify bool
 		clusterID     string
+		controllerRef string
 	)
 
 	rootCmd := &cobra.Command{
@@ -64,14 +69,16 @@ Connection to TrueNAS can be configured via:
 	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "table", "Output format: table, yaml, json")
 	rootCmd.PersistentFlags().BoolVar(&skipTLSVerify, "insecure-skip-tls-verify", true, "Skip TLS certificate verification")
 	rootCmd.PersistentFlags().StringVar(&clusterID, "cluster-id", "", "Filter by cluster ID (for multi-cluster TrueNAS sharing)")
+	rootCmd.PersistentFlags().StringVar(&controllerRef, "controller", "", "Read list, list-snapshots, list-orphaned and health from, and run export and import-stream through, the controller's admin API (namespace/service[:port]) instead of TrueNAS")
 
 	// Add subcommands
-	rootCmd.AddCommand(newListCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify, &clusterID))
-	rootCmd.AddCommand(newListSnapshotsCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify, &clusterID))
+	rootCmd.AddCommand(newListCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify, &clusterID, &controllerRef))
+	rootCmd.AddCommand(newListSnapshotsCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify, &clusterID, &controllerRef))
 	rootCmd.AddCommand(newListClonesCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify, &clusterID))
-	rootCmd.AddCommand(newListOrphanedCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify, &clusterID))
+	rootCmd.AddCommand(newListOrphanedCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify, &clusterID, &controllerRef))
 	rootCmd.AddCommand(newDescribeCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify))
-	rootCmd.AddCommand(newHealthCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify))
+	rootCmd.AddCommand(newHealthCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify, &controllerRef))
+	rootCmd.AddCommand(newAuditCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify, &clusterID))
 	rootCmd.AddCommand(newTroubleshootCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify))
 	rootCmd.AddCommand(newSummaryCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify))
 	rootCmd.AddCommand(newCleanupCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify, &clusterID))
@@ -79,9 +86,18 @@ Connection to TrueNAS can be configured via:
 	rootCmd.AddCommand(newAdoptCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify))
 	rootCmd.AddCommand(newStatusCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify))
 	rootCmd.AddCommand(newConnectivityCmd(&truenasURL, &truenasAPIKey, &secretRef, &skipTLSVerify, &clusterID))
+	rootCmd.AddCommand(newCompatCheckCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify))
 	rootCmd.AddCommand(newListUnmanagedCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify, &clusterID))
 	rootCmd.AddCommand(newImportCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify))
+	rootCmd.AddCommand(newImportForeignCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify, &clusterID))
 	rootCmd.AddCommand(newDashboardCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify, &clusterID))
+	rootCmd.AddCommand(newExportCmd(&truenasURL, &truenasAPIKey, &secretRef, &skipTLSVerify, &controllerRef))
+	rootCmd.AddCommand(newImportStreamCmd(&truenasURL, &truenasAPIKey, &secretRef, &skipTLSVerify, &controllerRef))
+	rootCmd.AddCommand(newBackupCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify))
+	rootCmd.AddCommand(newPreviewCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify))
+	rootCmd.AddCommand(newJobsCmd(&truenasURL, &truenasAPIKey, &secretRef, &outputFormat, &skipTLSVerify))
+	rootCmd.AddCommand(newSupportBundleCmd(&truenasURL, &truenasAPIKey, &secretRef, &skipTLSVerify))
+	rootCmd.AddCommand(newNodeStateCmd(&outputFormat))
 
 	return rootCmd
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"io"
 	"time"
 
 	"github.com/fenio/tns-csi/pkg/tnsapi"
@@ -44,6 +45,7 @@ type mockClient struct {
 	QueryNFSShareFunc     func(ctx context.Context, path string) ([]tnsapi.NFSShare, error)
 	QueryNFSShareByIDFunc func(ctx context.Context, shareID int) (*tnsapi.NFSShare, error)
 	QueryAllNFSSharesFunc func(ctx context.Context, pathPrefix string) ([]tnsapi.NFSShare, error)
+	GetNFSConfigFunc      func(ctx context.Context) (*tnsapi.NFSConfig, error)
 
 	// ZVOL operations
 	CreateZvolFunc func(ctx context.Context, params tnsapi.ZvolCreateParams) (*tnsapi.Dataset, error)
@@ -65,16 +67,25 @@ type mockClient struct {
 	QuerySubsystemPortBindingsFunc func(ctx context.Context, subsystemID int) ([]tnsapi.NVMeOFPortSubsystem, error)
 	QueryNVMeOFPortsFunc           func(ctx context.Context) ([]tnsapi.NVMeOFPort, error)
 
+	NVMeOFHostByNQNFunc           func(ctx context.Context, hostNQN string) (*tnsapi.NVMeOFHost, error)
+	CreateNVMeOFHostFunc          func(ctx context.Context, hostNQN string) (*tnsapi.NVMeOFHost, error)
+	QueryNVMeOFHostSubsystemsFunc func(ctx context.Context, subsystemID int) ([]tnsapi.NVMeOFHostSubsystem, error)
+	AddHostToSubsystemFunc        func(ctx context.Context, hostID, subsystemID int) error
+	RemoveHostFromSubsystemFunc   func(ctx context.Context, hostSubsysID int) error
+
 	// iSCSI operations
 	GetISCSIGlobalConfigFunc func(ctx context.Context) (*tnsapi.ISCSIGlobalConfig, error)
 	QueryISCSIPortalsFunc    func(ctx context.Context) ([]tnsapi.ISCSIPortal, error)
 	QueryISCSIInitiatorsFunc func(ctx context.Context) ([]tnsapi.ISCSIInitiator, error)
+	CreateISCSIInitiatorFunc func(ctx context.Context, params tnsapi.ISCSIInitiatorCreateParams) (*tnsapi.ISCSIInitiator, error)
 
 	CreateISCSITargetFunc func(ctx context.Context, params tnsapi.ISCSITargetCreateParams) (*tnsapi.ISCSITarget, error)
 	DeleteISCSITargetFunc func(ctx context.Context, targetID int, force bool) error
 	QueryISCSITargetsFunc func(ctx context.Context, filters []interface{}) ([]tnsapi.ISCSITarget, error)
 	ISCSITargetByNameFunc func(ctx context.Context, name string) (*tnsapi.ISCSITarget, error)
 
+	UpdateISCSITargetGroupsFunc func(ctx context.Context, targetID int, groups []tnsapi.ISCSITargetGroup) (*tnsapi.ISCSITarget, error)
+
 	CreateISCSIExtentFunc func(ctx context.Context, params tnsapi.ISCSIExtentCreateParams) (*tnsapi.ISCSIExtent, error)
 	DeleteISCSIExtentFunc func(ctx context.Context, extentID int, removeFile, force bool) error
 	QueryISCSIExtentsFunc func(ctx context.Context, filters []interface{}) ([]tnsapi.ISCSIExtent, error)
@@ -94,13 +105,19 @@ type mockClient struct {
 	QuerySnapshotsFunc   func(ctx context.Context, filters []interface{}) ([]tnsapi.Snapshot, error)
 	QuerySnapshotIDsFunc func(ctx context.Context, filters []interface{}) ([]string, error)
 	CloneSnapshotFunc    func(ctx context.Context, params tnsapi.CloneSnapshotParams) (*tnsapi.Dataset, error)
+	HoldSnapshotFunc     func(ctx context.Context, snapshotID string) error
+	ReleaseSnapshotFunc  func(ctx context.Context, snapshotID string) error
+
+	// Raw zfs send streams
+	SendSnapshotFunc    func(ctx context.Context, params tnsapi.SnapshotSendParams) (io.ReadCloser, error)
+	ReceiveSnapshotFunc func(ctx context.Context, params tnsapi.SnapshotReceiveParams, stream io.Reader) error
 
 	// Dataset promotion
 	PromoteDatasetFunc func(ctx context.Context, datasetID string) error
 
 	// Replication operations
 	RunOnetimeReplicationFunc        func(ctx context.Context, params tnsapi.ReplicationRunOnetimeParams) (int, error)
-	GetJobStatusFunc                 func(ctx context.Context, jobID int) (*tnsapi.ReplicationJobState, error)
+	GetJobStatusFunc                 func(ctx context.Context, jobID int) (*tnsapi.Job, error)
 	WaitForJobFunc                   func(ctx context.Context, jobID int, pollInterval time.Duration) error
 	RunOnetimeReplicationAndWaitFunc func(ctx context.Context, params tnsapi.ReplicationRunOnetimeParams, pollInterval time.Duration) error
 }
@@ -133,6 +150,18 @@ func (m *mockClient) DeleteDataset(ctx context.Context, datasetID string) error
 	return errNotImplemented
 }
 
+func (m *mockClient) DeleteDatasetWithOptions(ctx context.Context, datasetID string, _ tnsapi.DatasetDeleteOptions) error {
+	return m.DeleteDataset(ctx, datasetID)
+}
+
+func (m *mockClient) StartDatasetDelete(_ context.Context, _ string, _ tnsapi.DatasetDeleteOptions) (int, error) {
+	return 0, errNotImplemented
+}
+
+func (m *mockClient) RenameDataset(_ context.Context, _, _ string) error {
+	return errNotImplemented
+}
+
 func (m *mockClient) Dataset(ctx context.Context, datasetID string) (*tnsapi.Dataset, error) {
 	if m.DatasetFunc != nil {
 		return m.DatasetFunc(ctx, datasetID)
@@ -237,6 +266,10 @@ func (m *mockClient) CreateNFSShare(ctx context.Context, params tnsapi.NFSShareC
 	return nil, errNotImplemented
 }
 
+func (m *mockClient) UpdateNFSShare(_ context.Context, _ int, _ tnsapi.NFSShareUpdateParams) (*tnsapi.NFSShare, error) {
+	return &tnsapi.NFSShare{}, nil
+}
+
 func (m *mockClient) DeleteNFSShare(ctx context.Context, shareID int) error {
 	if m.DeleteNFSShareFunc != nil {
 		return m.DeleteNFSShareFunc(ctx, shareID)
@@ -265,6 +298,21 @@ func (m *mockClient) QueryAllNFSShares(ctx context.Context, pathPrefix string) (
 	return nil, errNotImplemented
 }
 
+func (m *mockClient) GetNFSConfig(ctx context.Context) (*tnsapi.NFSConfig, error) {
+	if m.GetNFSConfigFunc != nil {
+		return m.GetNFSConfigFunc(ctx)
+	}
+	return nil, errNotImplemented
+}
+
+func (m *mockClient) ListNFS4Clients(_ context.Context) ([]tnsapi.NFS4Client, error) {
+	return nil, errNotImplemented
+}
+
+func (m *mockClient) ExpireNFS4Client(_ context.Context, _ string) error {
+	return errNotImplemented
+}
+
 // SMB share operations.
 
 func (m *mockClient) CreateSMBShare(ctx context.Context, params tnsapi.SMBShareCreateParams) (*tnsapi.SMBShare, error) {
@@ -291,6 +339,10 @@ func (m *mockClient) FilesystemStat(ctx context.Context, path string) error {
 	return nil
 }
 
+func (m *mockClient) FilesystemMkdir(ctx context.Context, path, mode string) error {
+	return errNotImplemented
+}
+
 func (m *mockClient) GetFilesystemACL(ctx context.Context, path string) (string, error) {
 	return "NFS4", nil
 }
@@ -299,6 +351,10 @@ func (m *mockClient) SetFilesystemACL(ctx context.Context, path string) error {
 	return nil
 }
 
+func (m *mockClient) SetFilesystemNFS4ACL(ctx context.Context, path string, aces []tnsapi.NFS4ACE) error {
+	return nil
+}
+
 // ZVOL operations.
 
 func (m *mockClient) CreateZvol(ctx context.Context, params tnsapi.ZvolCreateParams) (*tnsapi.Dataset, error) {
@@ -401,6 +457,41 @@ func (m *mockClient) QueryNVMeOFPorts(ctx context.Context) ([]tnsapi.NVMeOFPort,
 	return nil, errNotImplemented
 }
 
+func (m *mockClient) NVMeOFHostByNQN(ctx context.Context, hostNQN string) (*tnsapi.NVMeOFHost, error) {
+	if m.NVMeOFHostByNQNFunc != nil {
+		return m.NVMeOFHostByNQNFunc(ctx, hostNQN)
+	}
+	return nil, errNotImplemented
+}
+
+func (m *mockClient) CreateNVMeOFHost(ctx context.Context, hostNQN string) (*tnsapi.NVMeOFHost, error) {
+	if m.CreateNVMeOFHostFunc != nil {
+		return m.CreateNVMeOFHostFunc(ctx, hostNQN)
+	}
+	return nil, errNotImplemented
+}
+
+func (m *mockClient) QueryNVMeOFHostSubsystems(ctx context.Context, subsystemID int) ([]tnsapi.NVMeOFHostSubsystem, error) {
+	if m.QueryNVMeOFHostSubsystemsFunc != nil {
+		return m.QueryNVMeOFHostSubsystemsFunc(ctx, subsystemID)
+	}
+	return nil, errNotImplemented
+}
+
+func (m *mockClient) AddHostToSubsystem(ctx context.Context, hostID, subsystemID int) error {
+	if m.AddHostToSubsystemFunc != nil {
+		return m.AddHostToSubsystemFunc(ctx, hostID, subsystemID)
+	}
+	return errNotImplemented
+}
+
+func (m *mockClient) RemoveHostFromSubsystem(ctx context.Context, hostSubsysID int) error {
+	if m.RemoveHostFromSubsystemFunc != nil {
+		return m.RemoveHostFromSubsystemFunc(ctx, hostSubsysID)
+	}
+	return errNotImplemented
+}
+
 // iSCSI operations.
 
 func (m *mockClient) GetISCSIGlobalConfig(ctx context.Context) (*tnsapi.ISCSIGlobalConfig, error) {
@@ -424,6 +515,20 @@ func (m *mockClient) QueryISCSIInitiators(ctx context.Context) ([]tnsapi.ISCSIIn
 	return nil, errNotImplemented
 }
 
+func (m *mockClient) CreateISCSIInitiator(ctx context.Context, params tnsapi.ISCSIInitiatorCreateParams) (*tnsapi.ISCSIInitiator, error) {
+	if m.CreateISCSIInitiatorFunc != nil {
+		return m.CreateISCSIInitiatorFunc(ctx, params)
+	}
+	return nil, errNotImplemented
+}
+
+func (m *mockClient) UpdateISCSITargetGroups(ctx context.Context, targetID int, groups []tnsapi.ISCSITargetGroup) (*tnsapi.ISCSITarget, error) {
+	if m.UpdateISCSITargetGroupsFunc != nil {
+		return m.UpdateISCSITargetGroupsFunc(ctx, targetID, groups)
+	}
+	return nil, errNotImplemented
+}
+
 func (m *mockClient) CreateISCSITarget(ctx context.Context, params tnsapi.ISCSITargetCreateParams) (*tnsapi.ISCSITarget, error) {
 	if m.CreateISCSITargetFunc != nil {
 		return m.CreateISCSITargetFunc(ctx, params)
@@ -566,6 +671,20 @@ func (m *mockClient) CloneSnapshot(ctx context.Context, params tnsapi.CloneSnaps
 	return nil, errNotImplemented
 }
 
+func (m *mockClient) HoldSnapshot(ctx context.Context, snapshotID string) error {
+	if m.HoldSnapshotFunc != nil {
+		return m.HoldSnapshotFunc(ctx, snapshotID)
+	}
+	return errNotImplemented
+}
+
+func (m *mockClient) ReleaseSnapshot(ctx context.Context, snapshotID string) error {
+	if m.ReleaseSnapshotFunc != nil {
+		return m.ReleaseSnapshotFunc(ctx, snapshotID)
+	}
+	return errNotImplemented
+}
+
 // Dataset promotion.
 
 func (m *mockClient) PromoteDataset(ctx context.Context, datasetID string) error {
@@ -577,6 +696,20 @@ func (m *mockClient) PromoteDataset(ctx context.Context, datasetID string) error
 
 // Replication operations.
 
+func (m *mockClient) SendSnapshot(ctx context.Context, params tnsapi.SnapshotSendParams) (io.ReadCloser, error) {
+	if m.SendSnapshotFunc != nil {
+		return m.SendSnapshotFunc(ctx, params)
+	}
+	return nil, errNotImplemented
+}
+
+func (m *mockClient) ReceiveSnapshot(ctx context.Context, params tnsapi.SnapshotReceiveParams, stream io.Reader) error {
+	if m.ReceiveSnapshotFunc != nil {
+		return m.ReceiveSnapshotFunc(ctx, params, stream)
+	}
+	return errNotImplemented
+}
+
 func (m *mockClient) RunOnetimeReplication(ctx context.Context, params tnsapi.ReplicationRunOnetimeParams) (int, error) {
 	if m.RunOnetimeReplicationFunc != nil {
 		return m.RunOnetimeReplicationFunc(ctx, params)
@@ -584,7 +717,7 @@ func (m *mockClient) RunOnetimeReplication(ctx context.Context, params tnsapi.Re
 	return 0, errNotImplemented
 }
 
-func (m *mockClient) GetJobStatus(ctx context.Context, jobID int) (*tnsapi.ReplicationJobState, error) {
+func (m *mockClient) GetJobStatus(ctx context.Context, jobID int) (*tnsapi.Job, error) {
 	if m.GetJobStatusFunc != nil {
 		return m.GetJobStatusFunc(ctx, jobID)
 	}
@@ -2,13 +2,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/fenio/tns-csi/pkg/driver"
 	"github.com/fenio/tns-csi/pkg/metrics"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
 	"k8s.io/klog/v2"
 )
 
@@ -23,17 +26,82 @@ var (
 	endpoint                  = flag.String("endpoint", "unix:///var/lib/kubelet/plugins/tns.csi.io/csi.sock", "CSI endpoint")
 	nodeID                    = flag.String("node-id", "", "Node ID")
 	driverName                = flag.String("driver-name", "tns.csi.io", "Name of the driver")
-	apiURL                    = flag.String("api-url", "", "Storage system API URL (e.g., ws://10.10.20.100/api/v2.0/websocket)")
+	apiURL                    = flag.String("api-url", "", "Storage system API URL (e.g., ws://10.10.20.100/api/v2.0/websocket); comma-separate the URLs of both controllers of an HA pair")
 	apiKey                    = flag.String("api-key", "", "Storage system API key")
+	storageAPI                = flag.Bool("storage-api", true, "Connect to the storage API; node plugins may disable it to run without API credentials or network access to the storage system (block volume sizes then come from the volume context only)")
+	apiKeyFile                = flag.String("api-key-file", "", "Path to a file containing the storage system API key (reloaded on change or SIGHUP)")
 	metricsAddr               = flag.String("metrics-addr", "", "Address to expose Prometheus metrics")
+	debugAddr                 = flag.String("debug-addr", "", "Loopback address serving the node state at /debug/state for kubectl tns-csi node-state, e.g. 127.0.0.1:9811 (node only, empty = disabled)")
+	vaultAddr                 = flag.String("vault-addr", "", "HashiCorp Vault address to read the storage API key from instead of --api-key/--api-key-file, e.g. https://vault:8200 (empty = disabled)")
+	vaultSecretPath           = flag.String("vault-secret-path", "", "Vault API path of the KV secret holding the API key, e.g. secret/data/tns-csi (KV v2) or kv/tns-csi (KV v1)")
+	vaultSecretKey            = flag.String("vault-secret-key", "api-key", "Key of the Vault secret holding the API key")
+	vaultRole                 = flag.String("vault-role", "", "Vault Kubernetes auth role to log in with the pod's service account token (empty = use VAULT_TOKEN)")
+	vaultAuthMount            = flag.String("vault-auth-mount", "kubernetes", "Mount path of the Vault Kubernetes auth method")
+	vaultNamespace            = flag.String("vault-namespace", "", "Vault Enterprise namespace (empty = none)")
+	vaultCAFile               = flag.String("vault-ca-file", "", "CA bundle for the Vault server certificate (empty = system roots)")
+	vaultRefreshInterval      = flag.Duration("vault-refresh-interval", driver.DefaultVaultRefreshInterval, "How often the API key is re-read from Vault")
+	proxyURL                  = flag.String("proxy-url", "", "Proxy for the storage API connection (http://, https:// or socks5://; default: HTTPS_PROXY/NO_PROXY from environment)")
 	skipTLSVerify             = flag.Bool("skip-tls-verify", false, "Skip TLS certificate verification (for self-signed certificates)")
 	showVersion               = flag.Bool("show-version", false, "Show version and exit")
 	debug                     = flag.Bool("debug", false, "Enable debug logging (equivalent to -v=4)")
 	enableNVMeDiscovery       = flag.Bool("enable-nvme-discovery", false, "Run nvme discover before nvme connect (default: false, all connection params are known from volume context)")
 	maxConcurrentNVMeConnects = flag.Int("max-concurrent-nvme-connects", 5, "Maximum number of concurrent NVMe-oF connect operations per node (limits kernel NVMe subsystem lock contention)")
+	maxConcurrentProvisions   = flag.Int("max-concurrent-provisions", 0, "Maximum number of concurrent CreateVolume operations; excess requests are queued (controller only, 0 = unlimited)")
+	maxConcurrentSnapshots    = flag.Int("max-concurrent-snapshots", 0, "Maximum number of concurrent CreateSnapshot/DeleteSnapshot operations (controller only, 0 = unlimited)")
+	maxConcurrentDeletes      = flag.Int("max-concurrent-deletes", 0, "Maximum number of concurrent DeleteVolume operations (controller only, 0 = unlimited)")
+	asyncDeleteMinSize        = flag.String("async-delete-min-size", "", "Delete volumes using at least this much space (e.g. '500Gi') in the background as TrueNAS jobs (controller only, empty = disabled)")
+	atomicCreate              = flag.Bool("atomic-create", false, "Create volume datasets under a .provisioning- staging name and rename them into place once configured, so interrupted creations never claim the volume name (controller only)")
+	nfs4ClientExpiry          = flag.Bool("nfs4-client-expiry", false, "Expire the NFSv4 clients of NotReady nodes when nfs.lockRecovery volumes leave them, by writing to /proc/fs/nfsd/clients on TrueNAS through filesystem.file_receive; undocumented by TrueNAS and needs an API key allowed to write files as root (controller only)")
+	hostAccessControl         = flag.Bool("host-access-control", false, "Allow NVMe-oF and iSCSI volumes only to the nodes they are published to, using the host NQN and initiator IQN node plugins publish as Node annotations; needs --kube-informers and a CSIDriver with attachRequired: true (controller only)")
+	kubeInformers             = flag.Bool("kube-informers", false, "Cache PersistentVolumes, PersistentVolumeClaims and VolumeSnapshotContents with informers when running in-cluster, for the orphan GC, the dashboard and PVC events (controller only)")
+	defaultVolumeSize         = flag.String("default-volume-size", "1Gi", "Size of volumes whose PVC requests no capacity; StorageClass defaultSize overrides it (controller only)")
+	capacityRounding          = flag.String("capacity-rounding", "none", "Round volume capacities up on create and expand: none, gib or volblocksize (ZVOLs); StorageClass capacityRounding overrides it (controller only)")
+	commentTemplate           = flag.String("comment-template", "", "Go template for dataset and share comments of StorageClasses without commentTemplate, e.g. '{{ .ClusterID }} {{ .PVCNamespace }}/{{ .PVCName }}' (controller only, empty = none)")
+	defaultZFSProperties      = flag.String("default-zfs-properties", "", "Comma-separated ZFS properties of new volumes, e.g. compression=zstd,atime=off; StorageClass zfs.* parameters and workloadProfile override them (controller only)")
+	defaultZFSPropertiesFile  = flag.String("default-zfs-properties-file", "", "File with name=value default ZFS properties, re-read for each new volume, overriding --default-zfs-properties (controller only, empty = none)")
+	nodeProtocols             = flag.String("node-protocols", "", "Comma-separated protocols this node may mount, e.g. 'nfs,smb' (empty = detect from installed tools)")
 	dashboardAddr             = flag.String("dashboard-addr", "", "Address for in-cluster web dashboard (e.g., ':2137', empty = disabled)")
 	dashboardPool             = flag.String("dashboard-pool", "", "ZFS pool for unmanaged volume discovery in dashboard")
+	adminAddr                 = flag.String("admin-addr", "", "Address of the admin API for kubectl tns-csi --controller, authenticated with Kubernetes tokens and RBAC (controller only, e.g. ':9091', empty = disabled)")
+	adminTLSCert              = flag.String("admin-tls-cert", "", "Certificate file the admin API is served with over TLS (required with --admin-addr)")
+	adminTLSKey               = flag.String("admin-tls-key", "", "Private key file of --admin-tls-cert (required with --admin-addr)")
+	dumpAPISchema             = flag.Bool("dump-api-schema", false, "Log one annotated schema snapshot (field names, types and redacted examples) of the NVMe-oF namespace and port binding query responses; raw payloads are only logged, sampled, at -v=6")
 	clusterID                 = flag.String("cluster-id", "", "Unique identifier for this cluster (for multi-cluster TrueNAS sharing)")
+	volumeMetadataCRD         = flag.Bool("volume-metadata-crd", false, "Cache volume metadata in TNSVolume custom resources so controller RPCs skip storage lookups (requires the TNSVolume CRD)")
+	usageAlertThresholds      = flag.String("usage-alert-thresholds", "", "Comma-separated volume usage percentages (e.g. '80,90,95') that raise Warning events on the PVC (controller only, empty = disabled)")
+	usageAlertInterval        = flag.Duration("usage-alert-interval", driver.DefaultUsageAlertInterval, "How often volume usage is checked for --usage-alert-thresholds and --autogrow")
+	volumeStatsInterval       = flag.Duration("volume-stats-interval", 0, "How often per-volume ZFS usage, compression ratio and snapshot count are exported as tns_csi_volume_stats_* metrics (controller only, 0 = disabled)")
+	nodeProtocolCheck         = flag.Bool("node-protocol-check", false, "Warn on PVCs whose protocol no node can mount, based on the protocols.tns.csi.io node labels (controller only)")
+	protectSnapshotClones     = flag.Bool("protect-snapshot-clones", false, "Refuse to delete snapshots that copy-on-write clones still depend on instead of deferring their destruction (controller only)")
+	allowUnmanagedDelete      = flag.Bool("allow-unmanaged-delete", false, "Delete volume and detached snapshot datasets even without tns-csi:managed_by or with another cluster ID (controller only, for recovery)")
+	nodeStateDir              = flag.String("node-state-dir", "", "Directory on the host where staged NVMe-oF volumes are recorded for recovery after a restart or reboot (node only, empty = disabled)")
+	kubeletDir                = flag.String("kubelet-dir", driver.DefaultKubeletDir, "Kubelet data directory (node only)")
+	staleMountCleanupInterval = flag.Duration("stale-mount-cleanup-interval", 0, "How often to unmount this driver's mounts under --kubelet-dir whose device no longer exists (node only, 0 = disabled)")
+	nfsServerMapFile          = flag.String("nfs-server-map-file", "", "File with '<old-server> <new-server>' lines redirecting NFS mounts after the storage address changed (empty = none)")
+	tenantDatasetMapFile      = flag.String("tenant-dataset-map-file", "", "File with '<namespace> <parentDataset>' lines placing each namespace's new volumes below its own dataset (controller only, empty = none)")
+	tenantQuotaSyncInterval   = flag.Duration("tenant-quota-sync-interval", driver.DefaultTenantQuotaSyncInterval, "How often to set the quota of each --tenant-dataset-map-file dataset to its namespace's storage ResourceQuotas; needs --kube-informers (controller only, 0 = disabled)")
+	maintenanceDir            = flag.String("maintenance-dir", "", "Directory (mounted ConfigMap) whose 'enabled' file switches maintenance mode, refusing provisioning and staging requests (empty = never)")
+	featureGates              = flag.String("feature-gates", "", "Comma-separated Name=bool feature gates, e.g. 'OrphanGC=false,DetachedSnapshots=true' (empty = defaults)")
+	nfsKrb5Keytab             = flag.String("nfs-krb5-keytab", "", "Keytab installed on the host before mounting Kerberos NFS volumes (node only, requires --nfs-krb5-host-etc, empty = host-managed)")
+	nfsKrb5HostEtc            = flag.String("nfs-krb5-host-etc", "", "Directory where the host /etc is mounted, for the Kerberos keytab and idmapd.conf (node only)")
+	nsenterHostNetwork        = flag.Bool("nsenter-host-network", false, "Run the commands making storage connections (iscsiadm, nvme connect, NFS and SMB mounts) in the host network namespace with nsenter, for node plugins without hostNetwork; needs hostPID (node only)")
+	volumeMountGroup          = flag.Bool("volume-mount-group", false, "Advertise VOLUME_MOUNT_GROUP so kubelet passes pods' fsGroup to the driver, which sets it on the volume root instead of kubelet changing the ownership of every file (node only)")
+	portalIPFamily            = flag.String("portal-ip-family", "", "Address family to connect to when a volume's server lists one address per family: ipv4 or ipv6 (node only, empty = first listed)")
+	nvmeofNSIDCooldown        = flag.Duration("nvmeof-nsid-cooldown", driver.DefaultNVMeOFNSIDCooldown, "How long an NVMe-oF subsystem must have been empty before NSID allocation restarts at 1 (controller only)")
+	orphanGCInterval          = flag.Duration("orphan-gc-interval", 0, "How often to look for volumes no PersistentVolume refers to; needs --kube-informers (controller only, 0 = disabled)")
+	orphanGCDeleteAfter       = flag.Duration("orphan-gc-delete-after", 0, "Delete volumes that have been orphaned this long, e.g. 24h (controller only, 0 = only report orphans)")
+	auditInterval             = flag.Duration("audit-interval", 0, "How often to compare PVs, shares, NVMe-oF namespaces and snapshots with TrueNAS and report mismatches; PV and snapshot checks need --kube-informers (controller only, 0 = disabled)")
+	previewReapInterval       = flag.Duration("preview-reap-interval", 0, "How often to remove snapshot previews whose TTL has expired (controller only, 0 = disabled)")
+	volumePopulatorSources    = flag.String("volume-populator-sources", "", "Comma-separated datasets that PVCs may be prepopulated from, with a dataSourceRef to a DatasetPopulator naming one of them or a dataset below (controller only, empty = disabled)")
+	volumePopulatorNamespace  = flag.String("volume-populator-namespace", "", "Namespace the volume populator creates its prime PVCs in, usually the controller's (controller only)")
+	markRetainedAdoptable     = flag.Bool("mark-retained-adoptable", false, "During orphan scans, mark volumes of Released or deleted Retain PVs adoptable and clear the hosts of their NFS shares (controller only)")
+	autoGrow                  = flag.Bool("autogrow", false, "Expand NFS/SMB volumes whose StorageClass sets autoGrow when they run out of headroom (controller only)")
+	provisioningTimeout       = flag.Duration("provisioning-timeout", driver.DefaultProvisioningTimeout, "Timeout for a single storage API call")
+	jobTimeout                = flag.Duration("job-timeout", driver.DefaultJobTimeout, "Timeout for waiting on long-running storage jobs such as replications")
+	nodeStageTimeout          = flag.Duration("node-stage-timeout", driver.DefaultNodeStageTimeout, "Timeout for node stage/publish RPCs (node only)")
+	mountTimeout              = flag.Duration("mount-timeout", driver.DefaultMountTimeout, "Timeout for NFS and SMB mount commands")
+	apiConnectTimeout         = flag.Duration("api-connect-timeout", tnsapi.DefaultConnectTimeout, "Timeout for connecting to the storage API")
+	apiAuthTimeout            = flag.Duration("api-auth-timeout", tnsapi.DefaultAuthTimeout, "Timeout for authenticating with the storage API")
 )
 
 func main() {
@@ -60,12 +128,49 @@ func main() {
 		klog.Fatal("Node ID must be provided")
 	}
 
-	if *apiURL == "" {
+	if *storageAPI && *apiURL == "" {
 		klog.Fatal("Storage API URL must be provided")
 	}
 
-	if *apiKey == "" {
-		klog.Fatal("Storage API key must be provided")
+	if *apiKeyFile != "" && *vaultAddr != "" {
+		klog.Fatal("--api-key-file and --vault-addr are mutually exclusive")
+	}
+
+	var keySource driver.APIKeySource
+	var keyRefreshInterval time.Duration
+	switch {
+	case !*storageAPI:
+		// No API key to load
+	case *vaultAddr != "":
+		source, err := driver.NewVaultAPIKeySource(driver.VaultConfig{
+			Address:    *vaultAddr,
+			SecretPath: *vaultSecretPath,
+			SecretKey:  *vaultSecretKey,
+			Role:       *vaultRole,
+			AuthMount:  *vaultAuthMount,
+			Namespace:  *vaultNamespace,
+			CAFile:     *vaultCAFile,
+			Token:      os.Getenv("VAULT_TOKEN"),
+		})
+		if err != nil {
+			klog.Fatalf("Invalid Vault configuration: %v", err)
+		}
+		keySource, keyRefreshInterval = source, *vaultRefreshInterval
+	case *apiKeyFile != "":
+		keySource = driver.FileAPIKeySource{Path: *apiKeyFile}
+	}
+	if keySource != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		key, err := keySource.FetchAPIKey(ctx)
+		cancel()
+		if err != nil {
+			klog.Fatalf("Failed to load API key from %s: %v", keySource, err)
+		}
+		*apiKey = key
+	}
+
+	if *storageAPI && *apiKey == "" {
+		klog.Fatal("Storage API key must be provided (--api-key, --api-key-file or --vault-addr)")
 	}
 
 	// Set version info for metrics endpoint
@@ -82,13 +187,74 @@ func main() {
 		Endpoint:                  *endpoint,
 		APIURL:                    *apiURL,
 		APIKey:                    *apiKey,
+		NoStorageAPI:              !*storageAPI,
+		APIKeyFile:                *apiKeyFile,
+		APIKeySource:              keySource,
+		APIKeyRefreshInterval:     keyRefreshInterval,
 		MetricsAddr:               *metricsAddr,
+		DebugAddr:                 *debugAddr,
+		ProxyURL:                  *proxyURL,
 		SkipTLSVerify:             *skipTLSVerify,
 		EnableNVMeDiscovery:       *enableNVMeDiscovery,
 		MaxConcurrentNVMeConnects: *maxConcurrentNVMeConnects,
+		MaxConcurrentProvisions:   *maxConcurrentProvisions,
+		MaxConcurrentSnapshots:    *maxConcurrentSnapshots,
+		MaxConcurrentDeletes:      *maxConcurrentDeletes,
+		AsyncDeleteMinSize:        *asyncDeleteMinSize,
+		AtomicCreate:              *atomicCreate,
+		KubeInformers:             *kubeInformers,
+		NFS4ClientExpiry:          *nfs4ClientExpiry,
+		HostAccessControl:         *hostAccessControl,
+		DefaultVolumeSize:         *defaultVolumeSize,
+		CapacityRounding:          *capacityRounding,
+		CommentTemplate:           *commentTemplate,
+		DefaultZFSProperties:      *defaultZFSProperties,
+		DefaultZFSPropertiesFile:  *defaultZFSPropertiesFile,
+		NodeProtocols:             *nodeProtocols,
 		DashboardAddr:             *dashboardAddr,
 		DashboardPool:             *dashboardPool,
+		AdminAddr:                 *adminAddr,
+		AdminTLSCertFile:          *adminTLSCert,
+		AdminTLSKeyFile:           *adminTLSKey,
+		DumpAPISchema:             *dumpAPISchema,
 		ClusterID:                 *clusterID,
+		VolumeMetadataCRD:         *volumeMetadataCRD,
+		UsageAlertThresholds:      *usageAlertThresholds,
+		UsageAlertInterval:        *usageAlertInterval,
+		VolumeStatsInterval:       *volumeStatsInterval,
+		AutoGrow:                  *autoGrow,
+		NodeProtocolCheck:         *nodeProtocolCheck,
+		ProtectSnapshotClones:     *protectSnapshotClones,
+		AllowUnmanagedDelete:      *allowUnmanagedDelete,
+		NVMeOFNSIDCooldown:        *nvmeofNSIDCooldown,
+		OrphanGCInterval:          *orphanGCInterval,
+		OrphanGCDeleteAfter:       *orphanGCDeleteAfter,
+		AuditInterval:             *auditInterval,
+		TenantQuotaSyncInterval:   *tenantQuotaSyncInterval,
+		PreviewReapInterval:       *previewReapInterval,
+		VolumePopulatorSources:    *volumePopulatorSources,
+		VolumePopulatorNamespace:  *volumePopulatorNamespace,
+		MarkRetainedAdoptable:     *markRetainedAdoptable,
+		NodeStateDir:              *nodeStateDir,
+		KubeletDir:                *kubeletDir,
+		NFSServerMapFile:          *nfsServerMapFile,
+		TenantDatasetMapFile:      *tenantDatasetMapFile,
+		MaintenanceDir:            *maintenanceDir,
+		FeatureGates:              *featureGates,
+		PortalIPFamily:            *portalIPFamily,
+		NFSKerberosKeytab:         *nfsKrb5Keytab,
+		NFSKerberosHostEtc:        *nfsKrb5HostEtc,
+		VolumeMountGroup:          *volumeMountGroup,
+		NsenterHostNetwork:        *nsenterHostNetwork,
+		StaleMountCleanupInterval: *staleMountCleanupInterval,
+		Timeouts: driver.Timeouts{
+			Provisioning: *provisioningTimeout,
+			Job:          *jobTimeout,
+			NodeStage:    *nodeStageTimeout,
+			Mount:        *mountTimeout,
+			APIConnect:   *apiConnectTimeout,
+			APIAuth:      *apiAuthTimeout,
+		},
 	})
 	if err != nil {
 		klog.Fatalf("Failed to create driver: %v", err)
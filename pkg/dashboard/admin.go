@@ -0,0 +1,50 @@
+package dashboard
+
+// Admin API of the controller (--admin-addr). The controller serves these endpoints below
+// AdminAPIPrefix; the kubectl plugin uses them with --controller instead of connecting to
+// TrueNAS itself.
+const (
+	// AdminAPIPrefix is the path prefix of all admin API endpoints.
+	AdminAPIPrefix = "/admin/v1/"
+	// AdminTokenHeader carries the caller's Kubernetes bearer token ("Bearer <token>") through the
+	// API server's service proxy, which consumes the Authorization header.
+	AdminTokenHeader = "X-Tns-Csi-Authorization"
+	// AdminTokenAudience is the only audience of tokens the admin API accepts. kubectl tns-csi
+	// requests such tokens for the admin ServiceAccounts, so they are useless against the API server.
+	AdminTokenAudience = "tns.csi.io/admin"
+)
+
+// Admin API endpoints, relative to AdminAPIPrefix.
+const (
+	AdminVolumes   = "volumes"   // []VolumeInfo
+	AdminOrphans   = "orphans"   // []OrphanedVolume
+	AdminSnapshots = "snapshots" // []SnapshotInfo
+	AdminHealth    = "health"    // HealthReport
+)
+
+// Admin API actions, relative to AdminAPIPrefix. They are POSTed a JSON request.
+const (
+	AdminExport = "export" // ExportRequest -> StreamResult
+	AdminImport = "import" // ImportRequest -> StreamResult
+)
+
+// ExportRequest asks the controller to send a volume as a raw zfs send stream to URL.
+type ExportRequest struct {
+	VolumeID string `json:"volumeId"`
+	Snapshot string `json:"snapshot,omitempty"` // Existing snapshot name; empty = a temporary snapshot
+	URL      string `json:"url"`                // HTTP(S) endpoint the stream is PUT to
+}
+
+// ImportRequest asks the controller to receive a raw zfs send stream from URL into Dataset.
+type ImportRequest struct {
+	Dataset string `json:"dataset"`
+	URL     string `json:"url"`             // HTTP(S) endpoint the stream is fetched from with GET
+	Force   bool   `json:"force,omitempty"` // Overwrite an existing dataset
+}
+
+// StreamResult describes a finished export or import.
+type StreamResult struct {
+	Dataset  string `json:"dataset"`
+	Snapshot string `json:"snapshot,omitempty"`
+	Bytes    int64  `json:"bytes"`
+}
@@ -0,0 +1,301 @@
+package dashboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Consistency audit.
+//
+// The audit compares what Kubernetes believes about tns-csi volumes with what TrueNAS has, to find
+// broken state before a pod fails to mount it. It reports:
+//   - PVs whose dataset or ZVOL is gone
+//   - datasets whose recorded NFS or SMB share ID refers to a share that no longer exists
+//   - NVMe-oF namespaces whose ZVOL is gone
+//   - CSI snapshots that no VolumeSnapshotContent refers to
+//
+// The audit only reads; cleaning up is left to the administrator (or the orphan GC). Checks whose
+// Kubernetes input is unknown are skipped and listed as such rather than reported as clean.
+
+// Audit finding kinds.
+const (
+	AuditPVMissingDataset       = "pv_missing_dataset"
+	AuditStaleShareID           = "stale_share_id"
+	AuditNamespaceMissingZvol   = "namespace_missing_zvol"
+	AuditSnapshotWithoutContent = "snapshot_without_content"
+)
+
+// AuditKinds lists every audit finding kind.
+var AuditKinds = []string{AuditPVMissingDataset, AuditStaleShareID, AuditNamespaceMissingZvol, AuditSnapshotWithoutContent}
+
+// AuditInput is the Kubernetes state the audit compares TrueNAS with.
+type AuditInput struct {
+	PVs             []*corev1.PersistentVolume // all PVs; only those of tns-csi are audited
+	SnapshotHandles []string                   // snapshot handles of the VolumeSnapshotContents of tns-csi
+	PVsKnown        bool                       // false skips the PV check
+	SnapshotsKnown  bool                       // false skips the snapshot check
+}
+
+// AuditFinding is one mismatch between Kubernetes and TrueNAS.
+type AuditFinding struct {
+	Kind     string `json:"kind"     yaml:"kind"`
+	Resource string `json:"resource" yaml:"resource"` // PV, dataset, namespace or snapshot the finding is about
+	Detail   string `json:"detail"   yaml:"detail"`
+}
+
+// AuditReport is the result of a consistency audit.
+type AuditReport struct {
+	Findings []AuditFinding `json:"findings"          yaml:"findings"`
+	Skipped  []string       `json:"skipped,omitempty" yaml:"skipped,omitempty"` // kinds that could not be checked
+}
+
+// Counts returns the number of findings of every kind, including kinds without findings.
+func (r *AuditReport) Counts() map[string]int {
+	counts := make(map[string]int, len(AuditKinds))
+	for _, kind := range AuditKinds {
+		counts[kind] = 0
+	}
+	for i := range r.Findings {
+		counts[r.Findings[i].Kind]++
+	}
+	return counts
+}
+
+// auditState is what the audit knows about TrueNAS.
+type auditState struct {
+	client tnsapi.ClientInterface
+	// datasets holds the IDs and CSI volume names of all managed datasets
+	datasets map[string]bool
+}
+
+// exists reports whether a dataset or ZVOL exists, looking it up when it is not a managed one.
+func (a *auditState) exists(ctx context.Context, datasetID string) (bool, error) {
+	if a.datasets[datasetID] {
+		return true, nil
+	}
+	if _, err := a.client.Dataset(ctx, datasetID); err != nil {
+		if errors.Is(err, tnsapi.ErrDatasetNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Audit compares the tns-csi state of a cluster in Kubernetes with TrueNAS. clusterID limits the
+// share and snapshot checks to datasets of that cluster (empty = all).
+func Audit(ctx context.Context, client tnsapi.ClientInterface, clusterID string, input AuditInput) (*AuditReport, error) {
+	datasets, err := client.FindManagedDatasets(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list managed datasets: %w", err)
+	}
+	state := &auditState{client: client, datasets: make(map[string]bool, 2*len(datasets))}
+	for i := range datasets {
+		state.datasets[datasets[i].ID] = true
+		if name := datasets[i].UserProperties[tnsapi.PropertyCSIVolumeName].Value; name != "" {
+			state.datasets[name] = true
+		}
+	}
+	if clusterID != "" {
+		datasets = filterDatasetsByClusterID(datasets, clusterID)
+	}
+
+	report := &AuditReport{Findings: []AuditFinding{}}
+	checks := []struct {
+		run   func() ([]AuditFinding, error)
+		kind  string
+		known bool
+	}{
+		{kind: AuditPVMissingDataset, known: input.PVsKnown, run: func() ([]AuditFinding, error) {
+			return auditPVs(ctx, state, input.PVs)
+		}},
+		{kind: AuditStaleShareID, known: true, run: func() ([]AuditFinding, error) {
+			return auditShareIDs(ctx, client, datasets)
+		}},
+		{kind: AuditNamespaceMissingZvol, known: true, run: func() ([]AuditFinding, error) {
+			return auditNamespaces(ctx, state)
+		}},
+		{kind: AuditSnapshotWithoutContent, known: input.SnapshotsKnown, run: func() ([]AuditFinding, error) {
+			return auditSnapshots(ctx, client, datasets, input.SnapshotHandles)
+		}},
+	}
+	for _, check := range checks {
+		if !check.known {
+			report.Skipped = append(report.Skipped, check.kind)
+			continue
+		}
+		findings, err := check.run()
+		if err != nil {
+			return nil, fmt.Errorf("%s check failed: %w", check.kind, err)
+		}
+		report.Findings = append(report.Findings, findings...)
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		if report.Findings[i].Kind != report.Findings[j].Kind {
+			return report.Findings[i].Kind < report.Findings[j].Kind
+		}
+		return report.Findings[i].Resource < report.Findings[j].Resource
+	})
+	return report, nil
+}
+
+// auditPVs finds tns-csi PVs whose dataset or ZVOL does not exist.
+func auditPVs(ctx context.Context, state *auditState, pvs []*corev1.PersistentVolume) ([]AuditFinding, error) {
+	var findings []AuditFinding
+	for _, pv := range pvs {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != csiDriverName {
+			continue
+		}
+		handle := pv.Spec.CSI.VolumeHandle
+		if state.datasets[handle] {
+			continue
+		}
+		datasetID := pv.Spec.CSI.VolumeAttributes["datasetName"]
+		if datasetID == "" {
+			datasetID = handle
+		}
+		exists, err := state.exists(ctx, datasetID)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			findings = append(findings, AuditFinding{
+				Kind:     AuditPVMissingDataset,
+				Resource: pv.Name,
+				Detail:   fmt.Sprintf("dataset %s of volume %s does not exist (PV %s)", datasetID, handle, pv.Status.Phase),
+			})
+		}
+	}
+	return findings, nil
+}
+
+// auditShareIDs finds datasets whose NFS or SMB share ID refers to a share that does not exist.
+func auditShareIDs(ctx context.Context, client tnsapi.ClientInterface, datasets []tnsapi.DatasetWithProperties) ([]AuditFinding, error) {
+	nfsShares, err := client.QueryAllNFSShares(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list NFS shares: %w", err)
+	}
+	smbShares, err := client.QueryAllSMBShares(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list SMB shares: %w", err)
+	}
+	shares := map[string]map[int]bool{tnsapi.PropertyNFSShareID: {}, tnsapi.PropertySMBShareID: {}}
+	for i := range nfsShares {
+		shares[tnsapi.PropertyNFSShareID][nfsShares[i].ID] = true
+	}
+	for i := range smbShares {
+		shares[tnsapi.PropertySMBShareID][smbShares[i].ID] = true
+	}
+
+	var findings []AuditFinding
+	for i := range datasets {
+		ds := &datasets[i]
+		if ds.UserProperties[tnsapi.PropertyPendingDelete].Value == valueTrue ||
+			ds.UserProperties[tnsapi.PropertyNFSSharePending].Value == valueTrue {
+			continue
+		}
+		for _, property := range []string{tnsapi.PropertyNFSShareID, tnsapi.PropertySMBShareID} {
+			shareID, err := strconv.Atoi(ds.UserProperties[property].Value)
+			if err != nil || shareID <= 0 || shares[property][shareID] {
+				continue
+			}
+			findings = append(findings, AuditFinding{
+				Kind:     AuditStaleShareID,
+				Resource: ds.ID,
+				Detail:   fmt.Sprintf("%s is %d but no such share exists", property, shareID),
+			})
+		}
+	}
+	return findings, nil
+}
+
+// auditNamespaces finds NVMe-oF namespaces whose ZVOL does not exist.
+func auditNamespaces(ctx context.Context, state *auditState) ([]AuditFinding, error) {
+	namespaces, err := state.client.QueryAllNVMeOFNamespaces(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list NVMe-oF namespaces: %w", err)
+	}
+	var findings []AuditFinding
+	for i := range namespaces {
+		ns := &namespaces[i]
+		zvol, isZvol := strings.CutPrefix(ns.GetDevice(), "zvol/")
+		if !isZvol || zvol == "" {
+			continue
+		}
+		exists, err := state.exists(ctx, zvol)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			findings = append(findings, AuditFinding{
+				Kind:     AuditNamespaceMissingZvol,
+				Resource: fmt.Sprintf("namespace %d", ns.ID),
+				Detail:   fmt.Sprintf("ZVOL %s of namespace %d of subsystem %s does not exist", zvol, ns.NSID, ns.GetSubsystemNQN()),
+			})
+		}
+	}
+	return findings, nil
+}
+
+// auditSnapshots finds CSI snapshots, attached and detached, that no VolumeSnapshotContent
+// refers to. Snapshot handles end in @<CSI snapshot name>, which CreateSnapshot records in
+// tns-csi:snapshot_id.
+func auditSnapshots(ctx context.Context, client tnsapi.ClientInterface, datasets []tnsapi.DatasetWithProperties, handles []string) ([]AuditFinding, error) {
+	referenced := make(map[string]bool, len(handles))
+	for _, handle := range handles {
+		if idx := strings.LastIndex(handle, "@"); idx != -1 {
+			referenced[handle[idx+1:]] = true
+		}
+	}
+
+	var findings []AuditFinding
+	volumes := make(map[string]bool, len(datasets))
+	for i := range datasets {
+		ds := &datasets[i]
+		if ds.UserProperties[tnsapi.PropertyDetachedSnapshot].Value != valueTrue {
+			volumes[ds.ID] = true
+			continue
+		}
+		name := ds.UserProperties[tnsapi.PropertySnapshotID].Value
+		if name != "" && !referenced[name] && ds.UserProperties[tnsapi.PropertyPendingDelete].Value != valueTrue {
+			findings = append(findings, AuditFinding{
+				Kind:     AuditSnapshotWithoutContent,
+				Resource: ds.ID,
+				Detail:   fmt.Sprintf("no VolumeSnapshotContent refers to detached snapshot %s", name),
+			})
+		}
+	}
+
+	snapshots, err := client.QuerySnapshotsWithProperties(ctx, []interface{}{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	for i := range snapshots {
+		snap := snapshots[i]
+		if !volumes[snap.Dataset] {
+			continue
+		}
+		name, isCSI := tnsapi.GetSnapshotPropertyValue(snap, tnsapi.PropertySnapshotID)
+		if !isCSI || name == "" || referenced[name] {
+			continue
+		}
+		// DeleteSnapshot already ran; ZFS destroys it once its clones are gone
+		if deferred, _ := tnsapi.GetSnapshotPropertyValue(snap, "defer_destroy"); deferred == "on" {
+			continue
+		}
+		findings = append(findings, AuditFinding{
+			Kind:     AuditSnapshotWithoutContent,
+			Resource: snap.ID,
+			Detail:   fmt.Sprintf("no VolumeSnapshotContent refers to snapshot %s", name),
+		})
+	}
+	return findings, nil
+}
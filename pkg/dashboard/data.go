@@ -28,8 +28,74 @@ func FindManagedVolumes(ctx context.Context, client tnsapi.ClientInterface, clus
 	if err != nil {
 		return nil, err
 	}
-	volumes := extractVolumes(datasets)
-	return filterByClusterID(volumes, clusterID), nil
+	volumes := filterByClusterID(extractVolumes(datasets), clusterID)
+
+	var deduplicated []string
+	for i := range volumes {
+		if volumes[i].Deduplication {
+			deduplicated = append(deduplicated, volumes[i].Dataset)
+		}
+	}
+	if len(deduplicated) > 0 {
+		ratios := poolDedupRatios(ctx, client, deduplicated)
+		for i := range volumes {
+			volumes[i].setDedupRatio(ratios[datasetPool(volumes[i].Dataset)])
+		}
+	}
+	return volumes, nil
+}
+
+// spaceEfficiency returns the space usage, compression and deduplication setting of a dataset.
+func spaceEfficiency(ds *tnsapi.Dataset) SpaceEfficiency {
+	e := SpaceEfficiency{
+		UsedBytes:        ds.UsedBytes(),
+		LogicalUsedBytes: ds.LogicalUsedBytes(),
+		CompressRatio:    ds.CompressionRatio(),
+		Deduplication:    ds.DeduplicationEnabled(),
+	}
+	if ds.Used != nil {
+		e.UsedHuman = FormatBytes(e.UsedBytes)
+	}
+	if ds.LogicalUsed != nil {
+		e.LogicalUsedHuman = FormatBytes(e.LogicalUsedBytes)
+	}
+	return e
+}
+
+// setDedupRatio records the deduplication ratio of the volume's pool and estimates the space
+// deduplication saves on the volume from it. ZFS only tracks deduplication per pool.
+func (e *SpaceEfficiency) setDedupRatio(ratio float64) {
+	if !e.Deduplication || ratio <= 0 {
+		return
+	}
+	e.DedupRatio = ratio
+	if ratio > 1 {
+		e.DedupSavingsBytes = e.UsedBytes - int64(float64(e.UsedBytes)/ratio)
+	}
+	e.DedupSavingsHuman = FormatBytes(e.DedupSavingsBytes)
+}
+
+// poolDedupRatios returns the deduplication ratio of the pools of the given datasets.
+// Pools that cannot be queried are left out.
+func poolDedupRatios(ctx context.Context, client tnsapi.ClientInterface, datasetIDs []string) map[string]float64 {
+	ratios := make(map[string]float64)
+	for _, id := range datasetIDs {
+		name := datasetPool(id)
+		if _, done := ratios[name]; done {
+			continue
+		}
+		ratios[name] = 0
+		if pool, err := client.QueryPool(ctx, name); err == nil {
+			ratios[name] = pool.DedupRatio()
+		}
+	}
+	return ratios
+}
+
+// datasetPool returns the pool of a dataset.
+func datasetPool(datasetID string) string {
+	pool, _, _ := strings.Cut(datasetID, "/")
+	return pool
 }
 
 // FindManagedSnapshots finds all snapshots managed by tns-csi.
@@ -85,6 +151,8 @@ func findAttachedSnapshots(ctx context.Context, client tnsapi.ClientInterface, c
 		}
 	}
 
+	dependents := cowClonesByOrigin(datasets)
+
 	// Query all snapshots in a single API call instead of per-dataset
 	allSnaps, err := client.QuerySnapshots(ctx, []interface{}{})
 	if err != nil {
@@ -103,12 +171,34 @@ func findAttachedSnapshots(ctx context.Context, client tnsapi.ClientInterface, c
 			SourceDataset: snap.Dataset,
 			Protocol:      meta.protocol,
 			Type:          "attached",
+			Clones:        dependents[snap.ID],
 		})
 	}
 
 	return snapshots, nil
 }
 
+// cowClonesByOrigin maps each origin snapshot to the copy-on-write clones that depend on it.
+// Promoted and detached clones are independent of their source snapshot and are skipped.
+func cowClonesByOrigin(datasets []tnsapi.DatasetWithProperties) map[string][]string {
+	dependents := make(map[string][]string)
+	for _, ds := range datasets {
+		origin, ok := ds.UserProperties[tnsapi.PropertyOriginSnapshot]
+		if !ok || origin.Value == "" {
+			continue
+		}
+		if prop, ok := ds.UserProperties[tnsapi.PropertyCloneMode]; ok && prop.Value != tnsapi.CloneModeCOW {
+			continue
+		}
+		name := ds.ID
+		if prop, ok := ds.UserProperties[tnsapi.PropertyCSIVolumeName]; ok && prop.Value != "" {
+			name = prop.Value
+		}
+		dependents[origin.Value] = append(dependents[origin.Value], name)
+	}
+	return dependents
+}
+
 func findDetachedSnapshots(ctx context.Context, client tnsapi.ClientInterface, clusterID string) ([]SnapshotInfo, error) {
 	datasets, err := client.FindDatasetsByProperty(ctx, "", tnsapi.PropertyDetachedSnapshot, valueTrue)
 	if err != nil {
@@ -263,11 +353,9 @@ func GetVolumeDetails(ctx context.Context, client tnsapi.ClientInterface, volume
 	if dataset.Mountpoint != "" {
 		details.MountPath = dataset.Mountpoint
 	}
-	if dataset.Used != nil {
-		if val, ok := dataset.Used["parsed"].(float64); ok {
-			details.UsedBytes = int64(val)
-			details.UsedHuman = FormatBytes(details.UsedBytes)
-		}
+	details.SpaceEfficiency = spaceEfficiency(&dataset.Dataset)
+	if details.Deduplication {
+		details.setDedupRatio(poolDedupRatios(ctx, client, []string{dataset.ID})[datasetPool(dataset.ID)])
 	}
 
 	for key, prop := range dataset.UserProperties {
@@ -287,6 +375,12 @@ func GetVolumeDetails(ctx context.Context, client tnsapi.ClientInterface, volume
 			details.DeleteStrategy = prop.Value
 		case tnsapi.PropertyAdoptable:
 			details.Adoptable = prop.Value == valueTrue
+		case tnsapi.PropertyPVCName:
+			details.PVCName = prop.Value
+		case tnsapi.PropertyPVCNamespace:
+			details.PVCNamespace = prop.Value
+		case tnsapi.PropertyPVName:
+			details.PVName = prop.Value
 		case tnsapi.PropertyContentSourceType:
 			details.ContentSourceType = prop.Value
 		case tnsapi.PropertyContentSourceID:
@@ -477,9 +571,10 @@ func extractVolumes(datasets []tnsapi.DatasetWithProperties) []VolumeInfo {
 		}
 
 		vol := VolumeInfo{
-			Dataset:  ds.ID,
-			VolumeID: volumeID,
-			Type:     ds.Type,
+			Dataset:         ds.ID,
+			VolumeID:        volumeID,
+			Type:            ds.Type,
+			SpaceEfficiency: spaceEfficiency(&ds.Dataset),
 		}
 
 		if prop, ok := ds.UserProperties[tnsapi.PropertyProtocol]; ok {
@@ -498,6 +593,15 @@ func extractVolumes(datasets []tnsapi.DatasetWithProperties) []VolumeInfo {
 		if prop, ok := ds.UserProperties[tnsapi.PropertyClusterID]; ok {
 			vol.ClusterID = prop.Value
 		}
+		if prop, ok := ds.UserProperties[tnsapi.PropertyPVCName]; ok {
+			vol.PVCName = prop.Value
+		}
+		if prop, ok := ds.UserProperties[tnsapi.PropertyPVCNamespace]; ok {
+			vol.PVCNamespace = prop.Value
+		}
+		if prop, ok := ds.UserProperties[tnsapi.PropertyPVName]; ok {
+			vol.PVName = prop.Value
+		}
 		if prop, ok := ds.UserProperties[tnsapi.PropertyContentSourceType]; ok {
 			vol.ContentSourceType = prop.Value
 		}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 	"k8s.io/klog/v2"
@@ -27,7 +28,7 @@ func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
 	// All other data (snapshots, clones, unmanaged, health, summary) loads
 	// via HTMX in the background after the page renders.
 	data := Data{Version: s.version}
-	volumes, _ := FetchK8sVolumes(ctx)
+	volumes, _ := s.fetchK8sVolumes(ctx)
 	if len(volumes) > 0 {
 		data.Volumes = volumes
 		data.Summary = CalculateSummary(volumes, nil, nil)
@@ -158,7 +159,7 @@ func (s *Server) handlePartialVolumes(w http.ResponseWriter, r *http.Request) {
 
 	AnnotateVolumesWithHealth(ctx, s.client, volumes)
 
-	k8sData := EnrichWithK8sData(ctx, false)
+	k8sData := s.enrichWithK8sData(ctx, false)
 	if k8sData.Available {
 		for i := range volumes {
 			if binding := MatchK8sBinding(k8sData.Bindings, volumes[i].Dataset, volumes[i].VolumeID); binding != nil {
@@ -314,7 +315,7 @@ func (s *Server) handlePartialVolumeDetail(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	k8sData := EnrichWithK8sData(ctx, true)
+	k8sData := s.enrichWithK8sData(ctx, true)
 	if k8sData.Available {
 		if binding := MatchK8sBinding(k8sData.Bindings, details.Dataset, details.VolumeID); binding != nil {
 			details.K8s = binding
@@ -372,6 +373,86 @@ func (s *Server) handleAPIMetricsRaw(w http.ResponseWriter, _ *http.Request) {
 	w.Write([]byte(rawMetrics))
 }
 
+// previewRequest is the body of POST /dashboard/api/previews.
+type previewRequest struct {
+	Snapshot string   `json:"snapshot"`
+	TTL      string   `json:"ttl,omitempty"` // Go duration, e.g. "30m" (default: 1h)
+	Hosts    []string `json:"hosts,omitempty"`
+	Networks []string `json:"networks,omitempty"` // CIDRs; hosts or networks are required
+}
+
+// handleAPIPreviews lists (GET) or creates (POST) snapshot previews.
+func (s *Server) handleAPIPreviews(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		previews, err := ListSnapshotPreviews(ctx, s.client)
+		if err != nil {
+			writeJSONError(w, err)
+			return
+		}
+		writeJSONResponse(w, previews)
+
+	case http.MethodPost:
+		var req previewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONErrorStatus(w, http.StatusBadRequest, err)
+			return
+		}
+		var ttl time.Duration
+		if req.TTL != "" {
+			parsed, err := time.ParseDuration(req.TTL)
+			if err != nil {
+				writeJSONErrorStatus(w, http.StatusBadRequest, err)
+				return
+			}
+			ttl = parsed
+		}
+		access := PreviewAccess{Hosts: req.Hosts, Networks: req.Networks}
+		preview, err := CreateSnapshotPreview(ctx, s.client, req.Snapshot, ttl, access)
+		if err != nil {
+			if errors.Is(err, ErrInvalidPreviewSnapshot) || errors.Is(err, ErrInvalidPreviewTTL) ||
+				errors.Is(err, ErrPreviewBlockSnapshot) || errors.Is(err, ErrPreviewAccessRequired) {
+				writeJSONErrorStatus(w, http.StatusBadRequest, err)
+				return
+			}
+			writeJSONError(w, err)
+			return
+		}
+		writeJSONResponse(w, preview)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAPIPreviewDetail deletes a snapshot preview (DELETE /dashboard/api/previews/<dataset>).
+func (s *Server) handleAPIPreviewDetail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	dataset := strings.TrimPrefix(r.URL.Path, "/dashboard/api/previews/")
+	if dataset == "" {
+		writeJSONErrorStatus(w, http.StatusBadRequest, ErrPreviewNotFound)
+		return
+	}
+
+	if err := DeleteSnapshotPreview(r.Context(), s.client, dataset); err != nil {
+		if errors.Is(err, ErrPreviewNotFound) {
+			writeJSONErrorStatus(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSONError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func writeJSONResponse(w http.ResponseWriter, data any) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(data); err != nil {
@@ -380,8 +461,12 @@ func writeJSONResponse(w http.ResponseWriter, data any) {
 }
 
 func writeJSONError(w http.ResponseWriter, err error) {
+	writeJSONErrorStatus(w, http.StatusInternalServerError, err)
+}
+
+func writeJSONErrorStatus(w http.ResponseWriter, code int, err error) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusInternalServerError)
+	w.WriteHeader(code)
 	//nolint:errcheck,errchkjson,gosec // Best effort error response
 	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 }
@@ -3,15 +3,20 @@ package dashboard
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
 )
 
+// csiDriverName is the name of the tns-csi CSI driver in Kubernetes.
+const csiDriverName = "tns.csi.io"
+
 // FetchK8sVolumes builds VolumeInfo directly from K8s PVs without any TrueNAS API calls.
 // This provides a fast initial view of volumes using only the Kubernetes API.
 func FetchK8sVolumes(ctx context.Context) ([]VolumeInfo, *K8sEnrichmentResult) {
@@ -40,26 +45,28 @@ func FetchK8sVolumes(ctx context.Context) ([]VolumeInfo, *K8sEnrichmentResult) {
 		return nil, result
 	}
 
-	result.Available = true
-	var volumes []VolumeInfo
-
+	pvs := make([]*corev1.PersistentVolume, len(pvList.Items))
 	for i := range pvList.Items {
-		pv := &pvList.Items[i]
+		pvs[i] = &pvList.Items[i]
+	}
+	return volumesFromPVs(pvs)
+}
 
-		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != "tns.csi.io" {
+// volumesFromPVs builds VolumeInfo and bindings from the tns-csi PVs among pvs.
+func volumesFromPVs(pvs []*corev1.PersistentVolume) ([]VolumeInfo, *K8sEnrichmentResult) {
+	result := &K8sEnrichmentResult{
+		Bindings:  make(map[string]*K8sVolumeBinding),
+		Available: true,
+	}
+	var volumes []VolumeInfo
+
+	for _, pv := range pvs {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != csiDriverName {
 			continue
 		}
 
 		attrs := pv.Spec.CSI.VolumeAttributes
-		binding := &K8sVolumeBinding{
-			PVName:   pv.Name,
-			PVStatus: string(pv.Status.Phase),
-		}
-		if pv.Spec.ClaimRef != nil {
-			binding.PVCName = pv.Spec.ClaimRef.Name
-			binding.PVCNamespace = pv.Spec.ClaimRef.Namespace
-		}
-
+		binding := bindingFromPV(pv)
 		vol := VolumeInfo{
 			Dataset:  pv.Spec.CSI.VolumeHandle,
 			VolumeID: attrs["datasetName"],
@@ -91,6 +98,41 @@ func FetchK8sVolumes(ctx context.Context) ([]VolumeInfo, *K8sEnrichmentResult) {
 	return volumes, result
 }
 
+// fetchK8sVolumes is FetchK8sVolumes served from the PV source when it is available.
+func (s *Server) fetchK8sVolumes(ctx context.Context) ([]VolumeInfo, *K8sEnrichmentResult) {
+	if s.pvSource != nil {
+		if pvs, ok := s.pvSource(); ok {
+			return volumesFromPVs(pvs)
+		}
+	}
+	return FetchK8sVolumes(ctx)
+}
+
+// enrichWithK8sData is EnrichWithK8sData served from the PV source when it is available. Pods
+// are not cached and always come from the API.
+func (s *Server) enrichWithK8sData(ctx context.Context, includePods bool) *K8sEnrichmentResult {
+	if s.pvSource != nil && !includePods {
+		if pvs, ok := s.pvSource(); ok {
+			_, result := volumesFromPVs(pvs)
+			return result
+		}
+	}
+	return EnrichWithK8sData(ctx, includePods)
+}
+
+// bindingFromPV returns the binding of a PV.
+func bindingFromPV(pv *corev1.PersistentVolume) *K8sVolumeBinding {
+	binding := &K8sVolumeBinding{
+		PVName:   pv.Name,
+		PVStatus: string(pv.Status.Phase),
+	}
+	if pv.Spec.ClaimRef != nil {
+		binding.PVCName = pv.Spec.ClaimRef.Name
+		binding.PVCNamespace = pv.Spec.ClaimRef.Namespace
+	}
+	return binding
+}
+
 // MatchK8sBinding tries to find a K8s binding by dataset path first (new volumes),
 // then falls back to csi_volume_name (old volumes).
 func MatchK8sBinding(bindings map[string]*K8sVolumeBinding, dataset, volumeID string) *K8sVolumeBinding {
@@ -105,7 +147,7 @@ func MatchK8sBinding(bindings map[string]*K8sVolumeBinding, dataset, volumeID st
 	return nil
 }
 
-// EnrichWithK8sData fetches K8s PV/PVC data and optionally pod data.
+// EnrichWithK8sData fetches K8s PV/PVC data and optionally pod and VolumeAttachment data.
 // When running in-cluster, uses the service account token.
 // Returns best-effort results — if K8s is unavailable, Available will be false.
 func EnrichWithK8sData(ctx context.Context, includePods bool) *K8sEnrichmentResult {
@@ -141,21 +183,11 @@ func EnrichWithK8sData(ctx context.Context, includePods bool) *K8sEnrichmentResu
 		pv := &pvList.Items[i]
 
 		// Only include PVs from our driver
-		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != "tns.csi.io" {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != csiDriverName {
 			continue
 		}
 
-		binding := &K8sVolumeBinding{
-			PVName:   pv.Name,
-			PVStatus: string(pv.Status.Phase),
-		}
-
-		if pv.Spec.ClaimRef != nil {
-			binding.PVCName = pv.Spec.ClaimRef.Name
-			binding.PVCNamespace = pv.Spec.ClaimRef.Namespace
-		}
-
-		result.Bindings[pv.Spec.CSI.VolumeHandle] = binding
+		result.Bindings[pv.Spec.CSI.VolumeHandle] = bindingFromPV(pv)
 	}
 
 	if includePods {
@@ -164,29 +196,97 @@ func EnrichWithK8sData(ctx context.Context, includePods bool) *K8sEnrichmentResu
 			klog.V(4).Infof("K8s enrichment failed to list pods: %v", podErr)
 			return result
 		}
+		// VolumeAttachments only exist when attachRequired is set on the CSIDriver
+		var attachments []storagev1.VolumeAttachment
+		if vaList, vaErr := clientset.StorageV1().VolumeAttachments().List(enrichCtx, metav1.ListOptions{}); vaErr != nil {
+			klog.V(4).Infof("K8s enrichment failed to list VolumeAttachments: %v", vaErr)
+		} else {
+			attachments = vaList.Items
+		}
+		AddVolumeUsage(result.Bindings, pods.Items, attachments)
+	}
 
-		pvcToPods := make(map[string][]string)
-		for i := range pods.Items {
-			pod := &pods.Items[i]
-			for j := range pod.Spec.Volumes {
-				pvc := pod.Spec.Volumes[j].PersistentVolumeClaim
-				if pvc != nil {
-					key := pod.Namespace + "/" + pvc.ClaimName
-					podRef := fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
-					pvcToPods[key] = append(pvcToPods[key], podRef)
-				}
-			}
+	return result
+}
+
+// AddVolumeUsage records on bindings (keyed by CSI volume handle) which pods use each volume and
+// on which nodes, from the pods and VolumeAttachments of the cluster.
+func AddVolumeUsage(bindings map[string]*K8sVolumeBinding, pods []corev1.Pod, attachments []storagev1.VolumeAttachment) {
+	byPVC := make(map[string]*K8sVolumeBinding)
+	byPV := make(map[string]*K8sVolumeBinding)
+	for _, binding := range bindings {
+		if binding.PVCName != "" && binding.PVCNamespace != "" {
+			byPVC[binding.PVCNamespace+"/"+binding.PVCName] = binding
 		}
+		byPV[binding.PVName] = binding
+	}
 
-		for _, binding := range result.Bindings {
-			if binding.PVCName != "" && binding.PVCNamespace != "" {
-				key := binding.PVCNamespace + "/" + binding.PVCName
-				if podRefs, ok := pvcToPods[key]; ok {
-					binding.Pods = podRefs
-				}
+	for i := range pods {
+		pod := &pods[i]
+		podRef := fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
+		for j := range pod.Spec.Volumes {
+			pvc := pod.Spec.Volumes[j].PersistentVolumeClaim
+			if pvc == nil {
+				continue
+			}
+			binding, ok := byPVC[pod.Namespace+"/"+pvc.ClaimName]
+			if !ok {
+				continue
+			}
+			binding.Pods = append(binding.Pods, podRef)
+			if pod.Spec.NodeName != "" {
+				usage := binding.nodeUsage(pod.Spec.NodeName)
+				usage.Pods = append(usage.Pods, K8sPodUsage{Name: podRef, Phase: podPhase(pod)})
 			}
 		}
 	}
 
-	return result
+	for i := range attachments {
+		va := &attachments[i]
+		if va.Spec.Attacher != csiDriverName || va.Spec.Source.PersistentVolumeName == nil {
+			continue
+		}
+		if binding, ok := byPV[*va.Spec.Source.PersistentVolumeName]; ok {
+			binding.nodeUsage(va.Spec.NodeName).Attachment = attachmentState(va)
+		}
+	}
+
+	for _, binding := range bindings {
+		sort.Slice(binding.Nodes, func(i, j int) bool { return binding.Nodes[i].Node < binding.Nodes[j].Node })
+	}
+}
+
+// nodeUsage returns the usage of the volume on node, adding it if needed.
+func (b *K8sVolumeBinding) nodeUsage(node string) *K8sNodeUsage {
+	for i := range b.Nodes {
+		if b.Nodes[i].Node == node {
+			return &b.Nodes[i]
+		}
+	}
+	b.Nodes = append(b.Nodes, K8sNodeUsage{Node: node})
+	return &b.Nodes[len(b.Nodes)-1]
+}
+
+// podPhase returns the phase of a pod, or Terminating once it is being deleted.
+func podPhase(pod *corev1.Pod) string {
+	if pod.DeletionTimestamp != nil {
+		return "Terminating"
+	}
+	return string(pod.Status.Phase)
+}
+
+// attachmentState describes the state of a VolumeAttachment.
+func attachmentState(va *storagev1.VolumeAttachment) string {
+	switch {
+	case va.DeletionTimestamp != nil && va.Status.DetachError != nil:
+		return "Detach failed: " + va.Status.DetachError.Message
+	case va.DeletionTimestamp != nil:
+		return "Detaching"
+	case va.Status.AttachError != nil:
+		return "Attach failed: " + va.Status.AttachError.Message
+	case va.Status.Attached:
+		return "Attached"
+	default:
+		return "Attaching"
+	}
 }
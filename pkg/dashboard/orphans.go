@@ -0,0 +1,116 @@
+package dashboard
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PVInfo is a PersistentVolume of a tns-csi volume.
+type PVInfo struct {
+	Name     string
+	VolumeID string // CSI volume handle
+	PVCName  string
+	PVCNs    string
+	Status   string // PV phase (Bound, Released, etc.)
+}
+
+// PVCInfo is a PersistentVolumeClaim.
+type PVCInfo struct {
+	Name      string
+	Namespace string
+	PVName    string
+}
+
+// OrphanedVolume is a volume that exists on TrueNAS but has no matching PVC.
+type OrphanedVolume struct {
+	PVCName    string `json:"pvcName,omitempty"   yaml:"pvcName,omitempty"`
+	Namespace  string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Reason     string `json:"reason"              yaml:"reason"`
+	VolumeInfo `json:",inline"             yaml:",inline"`
+}
+
+// PVInfoMap indexes the PersistentVolumes of tns-csi by CSI volume handle.
+func PVInfoMap(pvs []*corev1.PersistentVolume) map[string]PVInfo {
+	pvMap := make(map[string]PVInfo)
+	for _, pv := range pvs {
+		// Only consider CSI volumes from our driver
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != csiDriverName {
+			continue
+		}
+
+		info := PVInfo{
+			Name:     pv.Name,
+			VolumeID: pv.Spec.CSI.VolumeHandle,
+			Status:   string(pv.Status.Phase),
+		}
+
+		if pv.Spec.ClaimRef != nil {
+			info.PVCName = pv.Spec.ClaimRef.Name
+			info.PVCNs = pv.Spec.ClaimRef.Namespace
+		}
+
+		pvMap[info.VolumeID] = info
+	}
+	return pvMap
+}
+
+// PVCInfoMap indexes PersistentVolumeClaims by namespace/name.
+func PVCInfoMap(pvcs []*corev1.PersistentVolumeClaim) map[string]PVCInfo {
+	pvcMap := make(map[string]PVCInfo, len(pvcs))
+	for _, pvc := range pvcs {
+		pvcMap[pvc.Namespace+"/"+pvc.Name] = PVCInfo{
+			Name:      pvc.Name,
+			Namespace: pvc.Namespace,
+			PVName:    pvc.Spec.VolumeName,
+		}
+	}
+	return pvcMap
+}
+
+// FindOrphanedVolumes returns the volumes without a PV, with an unbound PV, or whose PVC was
+// deleted while the PV remained.
+func FindOrphanedVolumes(volumes []VolumeInfo, pvMap map[string]PVInfo, pvcMap map[string]PVCInfo) []OrphanedVolume {
+	var orphaned []OrphanedVolume
+
+	for i := range volumes {
+		vol := &volumes[i]
+		// Check if there's a PV with this volume ID (try dataset path first for new volumes,
+		// then fall back to csi_volume_name for old volumes)
+		pv, hasPV := pvMap[vol.Dataset]
+		if !hasPV && vol.VolumeID != vol.Dataset {
+			pv, hasPV = pvMap[vol.VolumeID]
+		}
+
+		if !hasPV {
+			// No PV exists - definitely orphaned
+			orphaned = append(orphaned, OrphanedVolume{
+				VolumeInfo: *vol,
+				Reason:     "no PV in cluster",
+			})
+			continue
+		}
+
+		// PV exists - check if it has a bound PVC
+		if pv.PVCName == "" {
+			orphaned = append(orphaned, OrphanedVolume{
+				VolumeInfo: *vol,
+				Reason:     "PV exists but not bound",
+			})
+			continue
+		}
+
+		// Check if the PVC actually exists
+		pvcKey := pv.PVCNs + "/" + pv.PVCName
+		if _, hasPVC := pvcMap[pvcKey]; !hasPVC {
+			orphaned = append(orphaned, OrphanedVolume{
+				VolumeInfo: *vol,
+				PVCName:    pv.PVCName,
+				Namespace:  pv.PVCNs,
+				Reason:     "PVC deleted but PV remains",
+			})
+		}
+
+		// If we get here, the volume has both PV and PVC - not orphaned
+	}
+
+	return orphaned
+}
@@ -208,8 +208,8 @@ func volumeMatchesQuery(v *VolumeInfo, q string) bool {
 	return strings.Contains(strings.ToLower(v.VolumeID), q) ||
 		strings.Contains(strings.ToLower(v.Dataset), q) ||
 		strings.Contains(strings.ToLower(v.Protocol), q) ||
-		(v.K8s != nil && (strings.Contains(strings.ToLower(v.K8s.PVCName), q) ||
-			strings.Contains(strings.ToLower(v.K8s.PVCNamespace), q)))
+		strings.Contains(strings.ToLower(v.ClaimName()), q) ||
+		strings.Contains(strings.ToLower(v.ClaimNamespace()), q)
 }
 
 func snapshotMatchesQuery(s *SnapshotInfo, q string) bool {
@@ -247,10 +247,14 @@ func sortVolumes(volumes []VolumeInfo, field, order string) {
 			less = volumes[i].Protocol < volumes[j].Protocol
 		case "capacity":
 			less = volumes[i].CapacityBytes < volumes[j].CapacityBytes
+		case "used":
+			less = volumes[i].UsedBytes < volumes[j].UsedBytes
+		case "compression":
+			less = volumes[i].CompressRatio < volumes[j].CompressRatio
 		case "pvc":
-			less = pvcName(volumes[i].K8s) < pvcName(volumes[j].K8s)
+			less = volumes[i].ClaimName() < volumes[j].ClaimName()
 		case "namespace":
-			less = pvcNamespace(volumes[i].K8s) < pvcNamespace(volumes[j].K8s)
+			less = volumes[i].ClaimNamespace() < volumes[j].ClaimNamespace()
 		case "health":
 			less = volumes[i].HealthStatus < volumes[j].HealthStatus
 		default:
@@ -330,17 +334,3 @@ func sortUnmanaged(volumes []UnmanagedVolume, field, order string) {
 		return less
 	})
 }
-
-func pvcName(k *K8sVolumeBinding) string {
-	if k != nil {
-		return k.PVCName
-	}
-	return ""
-}
-
-func pvcNamespace(k *K8sVolumeBinding) string {
-	if k != nil {
-		return k.PVCNamespace
-	}
-	return ""
-}
@@ -0,0 +1,296 @@
+package dashboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"k8s.io/klog/v2"
+)
+
+// Snapshot preview defaults.
+const (
+	// PreviewsFolder is the dataset (under the snapshot's pool) that holds preview clones.
+	PreviewsFolder = "csi-previews"
+
+	// DefaultPreviewTTL is how long a preview stays exported when no TTL is given.
+	DefaultPreviewTTL = time.Hour
+
+	// MaxPreviewTTL caps preview lifetime so forgotten previews do not pin snapshots forever.
+	MaxPreviewTTL = 7 * 24 * time.Hour
+)
+
+// Static errors for snapshot previews.
+var (
+	ErrInvalidPreviewSnapshot = errors.New("snapshot must be a ZFS snapshot name (dataset@snapshot)")
+	ErrPreviewBlockSnapshot   = errors.New("previews are only supported for filesystem (NFS/SMB) snapshots")
+	ErrInvalidPreviewTTL      = errors.New("preview TTL must be positive and at most 7 days")
+	ErrPreviewNotFound        = errors.New("preview not found")
+	ErrPreviewAccessRequired  = errors.New("previews must be restricted to allowed hosts or networks")
+	ErrPreviewShareConflict   = errors.New("path is exported by an NFS share that is not a snapshot preview")
+)
+
+// previewShareCommentPrefix starts the comment of every preview NFS share.
+const previewShareCommentPrefix = "tns-csi snapshot preview of "
+
+// PreviewAccess lists the NFS clients a preview is exported to. At least one host or network
+// is required: previews are never exported to everyone.
+type PreviewAccess struct {
+	Hosts    []string `json:"hosts,omitempty"    yaml:"hosts,omitempty"`
+	Networks []string `json:"networks,omitempty" yaml:"networks,omitempty"`
+}
+
+// PreviewInfo describes a temporary read-only export of a snapshot.
+type PreviewInfo struct {
+	Dataset   string    `json:"dataset"   yaml:"dataset"`
+	Snapshot  string    `json:"snapshot"  yaml:"snapshot"`
+	SharePath string    `json:"sharePath" yaml:"sharePath"`
+	ExpiresAt time.Time `json:"expiresAt" yaml:"expiresAt"`
+	ShareID   int       `json:"shareId"   yaml:"shareId"`
+}
+
+// previewDatasetName returns the preview clone dataset for a snapshot ("pool/a/vol@snap" -> "pool/csi-previews/vol-snap").
+func previewDatasetName(snapshot string) (string, error) {
+	dataset, snapName, ok := strings.Cut(snapshot, "@")
+	if !ok || dataset == "" || snapName == "" || strings.Contains(snapName, "/") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPreviewSnapshot, snapshot)
+	}
+	pool, _, _ := strings.Cut(dataset, "/")
+	base := dataset[strings.LastIndex(dataset, "/")+1:]
+	return fmt.Sprintf("%s/%s/%s-%s", pool, PreviewsFolder, base, snapName), nil
+}
+
+// CreateSnapshotPreview clones a snapshot read-only and exports the clone over NFS (read-only)
+// to the hosts and networks of access until the TTL expires. Calling it again for the same
+// snapshot extends the TTL; the share keeps the clients it was created with.
+func CreateSnapshotPreview(ctx context.Context, client tnsapi.ClientInterface, snapshot string, ttl time.Duration, access PreviewAccess) (*PreviewInfo, error) {
+	if len(access.Hosts) == 0 && len(access.Networks) == 0 {
+		return nil, ErrPreviewAccessRequired
+	}
+	if ttl == 0 {
+		ttl = DefaultPreviewTTL
+	}
+	if ttl < 0 || ttl > MaxPreviewTTL {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPreviewTTL, ttl)
+	}
+
+	previewDataset, err := previewDatasetName(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	sourceDataset, _, _ := strings.Cut(snapshot, "@")
+
+	source, err := client.Dataset(ctx, sourceDataset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get source dataset %s: %w", sourceDataset, err)
+	}
+	if source.Type == datasetTypeVolume {
+		return nil, fmt.Errorf("%w: %s is a zvol", ErrPreviewBlockSnapshot, sourceDataset)
+	}
+
+	parent := previewDataset[:strings.LastIndex(previewDataset, "/")]
+	if err := ensurePreviewsParent(ctx, client, parent); err != nil {
+		return nil, err
+	}
+
+	clone, err := client.Dataset(ctx, previewDataset)
+	created := false
+	if err != nil || clone == nil {
+		klog.Infof("Creating snapshot preview %s from %s", previewDataset, snapshot)
+		clone, err = client.CloneSnapshot(ctx, tnsapi.CloneSnapshotParams{
+			Snapshot:          snapshot,
+			Dataset:           previewDataset,
+			DatasetProperties: map[string]string{"readonly": "on"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to clone snapshot %s: %w", snapshot, err)
+		}
+		created = true
+	}
+
+	sharePath := clone.Mountpoint
+	if sharePath == "" {
+		sharePath = "/mnt/" + previewDataset
+	}
+
+	share, err := ensurePreviewShare(ctx, client, sharePath, snapshot, access)
+	if err != nil {
+		// Do not leave an unexported clone pinning the snapshot. A clone from an earlier call is
+		// still exported and recorded, and the reaper removes it when it expires.
+		if created {
+			if delErr := client.DeleteDataset(ctx, previewDataset); delErr != nil {
+				klog.Warningf("Failed to clean up preview clone %s: %v", previewDataset, delErr)
+			}
+		}
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(ttl).UTC().Truncate(time.Second)
+	props := map[string]string{
+		tnsapi.PropertyPreviewSource:    snapshot,
+		tnsapi.PropertyPreviewExpiresAt: expiresAt.Format(time.RFC3339),
+		tnsapi.PropertyPreviewShareID:   strconv.Itoa(share.ID),
+	}
+	if err := client.SetDatasetProperties(ctx, previewDataset, props); err != nil {
+		return nil, fmt.Errorf("failed to record preview expiry on %s: %w", previewDataset, err)
+	}
+
+	klog.Infof("Snapshot preview %s exported read-only at %s until %s", previewDataset, sharePath, expiresAt.Format(time.RFC3339))
+	return &PreviewInfo{
+		Dataset:   previewDataset,
+		Snapshot:  snapshot,
+		SharePath: sharePath,
+		ShareID:   share.ID,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// ensurePreviewsParent creates the previews parent dataset if it does not exist.
+func ensurePreviewsParent(ctx context.Context, client tnsapi.ClientInterface, parent string) error {
+	if ds, err := client.Dataset(ctx, parent); err == nil && ds != nil {
+		return nil
+	}
+	if _, err := client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: parent, Type: "FILESYSTEM"}); err != nil {
+		return fmt.Errorf("failed to create previews dataset %s: %w", parent, err)
+	}
+	return nil
+}
+
+// ensurePreviewShare returns the NFS share of a preview path, creating a read-only one restricted
+// to the clients of access if needed. A share of the path that is not the preview's is an error.
+func ensurePreviewShare(ctx context.Context, client tnsapi.ClientInterface, sharePath, snapshot string, access PreviewAccess) (*tnsapi.NFSShare, error) {
+	shares, err := client.QueryNFSShare(ctx, sharePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query NFS shares of %s: %w", sharePath, err)
+	}
+	for i := range shares {
+		if shares[i].Path != sharePath {
+			continue
+		}
+		if shares[i].Comment != previewShareCommentPrefix+snapshot {
+			return nil, fmt.Errorf("%w: %s (share %d)", ErrPreviewShareConflict, sharePath, shares[i].ID)
+		}
+		return &shares[i], nil
+	}
+	share, err := client.CreateNFSShare(ctx, tnsapi.NFSShareCreateParams{
+		Path:     sharePath,
+		Comment:  previewShareCommentPrefix + snapshot,
+		Hosts:    access.Hosts,
+		Networks: access.Networks,
+		Enabled:  true,
+		ReadOnly: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export preview %s: %w", sharePath, err)
+	}
+	return share, nil
+}
+
+// ListSnapshotPreviews returns all snapshot previews currently on the storage system.
+func ListSnapshotPreviews(ctx context.Context, client tnsapi.ClientInterface) ([]PreviewInfo, error) {
+	datasets, err := client.FindDatasetsByProperty(ctx, "", tnsapi.PropertyPreviewSource, "")
+	if err != nil {
+		return nil, err
+	}
+
+	previews := make([]PreviewInfo, 0, len(datasets))
+	for i := range datasets {
+		previews = append(previews, previewFromDataset(&datasets[i]))
+	}
+	return previews, nil
+}
+
+// previewFromDataset builds PreviewInfo from a preview dataset's properties.
+func previewFromDataset(ds *tnsapi.DatasetWithProperties) PreviewInfo {
+	info := PreviewInfo{Dataset: ds.ID, SharePath: ds.Mountpoint}
+	if info.SharePath == "" {
+		info.SharePath = "/mnt/" + ds.ID
+	}
+	if prop, ok := ds.UserProperties[tnsapi.PropertyPreviewSource]; ok {
+		info.Snapshot = prop.Value
+	}
+	if prop, ok := ds.UserProperties[tnsapi.PropertyPreviewExpiresAt]; ok {
+		if t, err := time.Parse(time.RFC3339, prop.Value); err == nil {
+			info.ExpiresAt = t
+		}
+	}
+	if prop, ok := ds.UserProperties[tnsapi.PropertyPreviewShareID]; ok {
+		if id, err := strconv.Atoi(prop.Value); err == nil {
+			info.ShareID = id
+		}
+	}
+	return info
+}
+
+// DeleteSnapshotPreview removes a preview's NFS share and clone dataset.
+func DeleteSnapshotPreview(ctx context.Context, client tnsapi.ClientInterface, dataset string) error {
+	ds, err := client.GetDatasetWithProperties(ctx, dataset)
+	if err != nil || ds == nil {
+		return fmt.Errorf("%w: %s", ErrPreviewNotFound, dataset)
+	}
+	if _, ok := ds.UserProperties[tnsapi.PropertyPreviewSource]; !ok {
+		// Never delete a dataset that is not a preview
+		return fmt.Errorf("%w: %s (not a snapshot preview)", ErrPreviewNotFound, dataset)
+	}
+	return deletePreview(ctx, client, previewFromDataset(ds))
+}
+
+// deletePreview tears down a preview without re-validating it.
+func deletePreview(ctx context.Context, client tnsapi.ClientInterface, preview PreviewInfo) error {
+	if preview.ShareID > 0 {
+		if err := client.DeleteNFSShare(ctx, preview.ShareID); err != nil {
+			klog.Warningf("Failed to delete preview share %d for %s: %v", preview.ShareID, preview.Dataset, err)
+		}
+	}
+	if err := client.DeleteDataset(ctx, preview.Dataset); err != nil {
+		return fmt.Errorf("failed to delete preview %s: %w", preview.Dataset, err)
+	}
+	klog.Infof("Removed snapshot preview %s (source %s)", preview.Dataset, preview.Snapshot)
+	return nil
+}
+
+// ReapExpiredPreviews deletes previews whose TTL has passed. Returns the number removed.
+func ReapExpiredPreviews(ctx context.Context, client tnsapi.ClientInterface, now time.Time) (int, error) {
+	previews, err := ListSnapshotPreviews(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, p := range previews {
+		if p.ExpiresAt.IsZero() || now.Before(p.ExpiresAt) {
+			continue
+		}
+		if err := deletePreview(ctx, client, p); err != nil {
+			klog.Warningf("Failed to reap expired preview: %v", err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// RunPreviewReaper tears down expired previews every interval until stopCh is closed.
+func RunPreviewReaper(client tnsapi.ClientInterface, interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			if n, err := ReapExpiredPreviews(ctx, client, time.Now()); err != nil {
+				klog.V(4).Infof("Preview reaper: %v", err)
+			} else if n > 0 {
+				klog.Infof("Preview reaper removed %d expired snapshot preview(s)", n)
+			}
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"github.com/fenio/tns-csi/pkg/tnsapi/tnsapitest"
+)
+
+// testSnapshot is the snapshot newPreviewTestClient creates.
+const testSnapshot = "tank/k8s/pvc-1@snap-1"
+
+// newPreviewTestClient connects a client to an in-process TrueNAS API server holding the
+// filesystem volume tank/k8s/pvc-1 with the snapshot testSnapshot.
+func newPreviewTestClient(t *testing.T) (*tnsapitest.Server, *tnsapi.Client) {
+	t.Helper()
+	srv := tnsapitest.NewServer()
+	client, err := tnsapi.NewClient(srv.URL(), tnsapitest.APIKey, false)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		srv.Close()
+	})
+
+	ctx := context.Background()
+	for _, name := range []string{"tank/k8s", "tank/k8s/pvc-1"} {
+		if _, err := client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: name, Type: "FILESYSTEM"}); err != nil {
+			t.Fatalf("CreateDataset(%s) error = %v", name, err)
+		}
+	}
+	if _, err := client.CreateSnapshot(ctx, tnsapi.SnapshotCreateParams{Dataset: "tank/k8s/pvc-1", Name: "snap-1"}); err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+	return srv, client
+}
+
+// testAccess is a valid preview access list.
+var testAccess = PreviewAccess{Networks: []string{"192.168.1.0/24"}}
+
+func TestPreviewDatasetName(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot string
+		want     string
+		wantErr  bool
+	}{
+		{name: "nested dataset", snapshot: "tank/k8s/pvc-1@snap-1", want: "tank/csi-previews/pvc-1-snap-1"},
+		{name: "pool root dataset", snapshot: "tank@daily", want: "tank/csi-previews/tank-daily"},
+		{name: "no snapshot name", snapshot: "tank/k8s/pvc-1@", wantErr: true},
+		{name: "no dataset", snapshot: "@snap-1", wantErr: true},
+		{name: "not a snapshot", snapshot: "tank/k8s/pvc-1", wantErr: true},
+		{name: "slash in snapshot name", snapshot: "tank/k8s/pvc-1@a/b", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := previewDatasetName(tt.snapshot)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidPreviewSnapshot) {
+					t.Errorf("previewDatasetName(%q) error = %v, want ErrInvalidPreviewSnapshot", tt.snapshot, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("previewDatasetName(%q) = %q, %v; want %q", tt.snapshot, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateSnapshotPreviewValidation(t *testing.T) {
+	tests := []struct {
+		wantErr  error
+		name     string
+		snapshot string
+		access   PreviewAccess
+		ttl      time.Duration
+	}{
+		{name: "negative TTL", snapshot: testSnapshot, ttl: -time.Minute, access: testAccess, wantErr: ErrInvalidPreviewTTL},
+		{name: "TTL above maximum", snapshot: testSnapshot, ttl: MaxPreviewTTL + time.Second, access: testAccess, wantErr: ErrInvalidPreviewTTL},
+		{name: "no hosts or networks", snapshot: testSnapshot, ttl: time.Hour, wantErr: ErrPreviewAccessRequired},
+		{name: "empty host list", snapshot: testSnapshot, ttl: time.Hour, access: PreviewAccess{Hosts: []string{}}, wantErr: ErrPreviewAccessRequired},
+		{name: "invalid snapshot", snapshot: "tank/k8s/pvc-1", ttl: time.Hour, access: testAccess, wantErr: ErrInvalidPreviewSnapshot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client := newPreviewTestClient(t)
+
+			if _, err := CreateSnapshotPreview(context.Background(), client, tt.snapshot, tt.ttl, tt.access); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateSnapshotPreview() error = %v, want %v", err, tt.wantErr)
+			}
+			if srv.DatasetExists("tank/" + PreviewsFolder) {
+				t.Error("rejected preview created the previews dataset")
+			}
+		})
+	}
+}
+
+// previewShare is an NFS share with the fields previews set.
+type previewShare struct {
+	Comment  string   `json:"comment"`
+	Hosts    []string `json:"hosts"`
+	Networks []string `json:"networks"`
+	ID       int      `json:"id"`
+	ReadOnly bool     `json:"ro"`
+}
+
+// queryPreviewShares returns the NFS shares of a path.
+func queryPreviewShares(t *testing.T, client *tnsapi.Client, path string) []previewShare {
+	t.Helper()
+	var shares []previewShare
+	if err := client.Call(context.Background(), "sharing.nfs.query", []interface{}{
+		[]interface{}{[]interface{}{"path", "=", path}},
+	}, &shares); err != nil {
+		t.Fatalf("sharing.nfs.query error = %v", err)
+	}
+	return shares
+}
+
+func TestCreateSnapshotPreview(t *testing.T) {
+	srv, client := newPreviewTestClient(t)
+	ctx := context.Background()
+
+	preview, err := CreateSnapshotPreview(ctx, client, testSnapshot, 0, testAccess)
+	if err != nil {
+		t.Fatalf("CreateSnapshotPreview() error = %v", err)
+	}
+	if preview.Dataset != "tank/csi-previews/pvc-1-snap-1" || preview.SharePath != "/mnt/tank/csi-previews/pvc-1-snap-1" {
+		t.Errorf("preview = %+v, want dataset tank/csi-previews/pvc-1-snap-1", preview)
+	}
+	if d := time.Until(preview.ExpiresAt); d <= 0 || d > DefaultPreviewTTL {
+		t.Errorf("preview expires in %v, want at most %v", d, DefaultPreviewTTL)
+	}
+	shares := queryPreviewShares(t, client, preview.SharePath)
+	if len(shares) != 1 || !shares[0].ReadOnly || !slices.Equal(shares[0].Networks, testAccess.Networks) || len(shares[0].Hosts) != 0 {
+		t.Fatalf("preview shares = %+v, want one read-only share for %v", shares, testAccess.Networks)
+	}
+
+	// A second call reuses the clone and share and extends the TTL
+	again, err := CreateSnapshotPreview(ctx, client, testSnapshot, 2*time.Hour, PreviewAccess{Hosts: []string{"10.0.0.5"}})
+	if err != nil {
+		t.Fatalf("repeated CreateSnapshotPreview() error = %v", err)
+	}
+	if again.ShareID != preview.ShareID || !again.ExpiresAt.After(preview.ExpiresAt) {
+		t.Errorf("repeated preview = %+v, want share %d and a later expiry than %v", again, preview.ShareID, preview.ExpiresAt)
+	}
+	if n := srv.Counts()["nfs"]; n != 1 {
+		t.Errorf("%d NFS shares after repeated preview, want 1", n)
+	}
+}
+
+func TestCreateSnapshotPreviewShareFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		reuse     bool // an earlier call created the clone
+		wantClone bool
+	}{
+		{name: "new clone is deleted", wantClone: false},
+		{name: "clone of an earlier call is kept", reuse: true, wantClone: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client := newPreviewTestClient(t)
+			ctx := context.Background()
+
+			if tt.reuse {
+				preview, err := CreateSnapshotPreview(ctx, client, testSnapshot, time.Hour, testAccess)
+				if err != nil {
+					t.Fatalf("CreateSnapshotPreview() error = %v", err)
+				}
+				if err := client.DeleteNFSShare(ctx, preview.ShareID); err != nil {
+					t.Fatalf("DeleteNFSShare() error = %v", err)
+				}
+			}
+			srv.Handle("sharing.nfs.create", func(_ []json.RawMessage) (interface{}, error) {
+				return nil, errors.New("NFS service unavailable")
+			})
+
+			if _, err := CreateSnapshotPreview(ctx, client, testSnapshot, time.Hour, testAccess); err == nil {
+				t.Fatal("CreateSnapshotPreview() succeeded although the share could not be created")
+			}
+			if got := srv.DatasetExists("tank/csi-previews/pvc-1-snap-1"); got != tt.wantClone {
+				t.Errorf("preview clone exists = %v, want %v", got, tt.wantClone)
+			}
+		})
+	}
+}
+
+func TestCreateSnapshotPreviewForeignShare(t *testing.T) {
+	srv, client := newPreviewTestClient(t)
+	ctx := context.Background()
+
+	preview, err := CreateSnapshotPreview(ctx, client, testSnapshot, time.Hour, testAccess)
+	if err != nil {
+		t.Fatalf("CreateSnapshotPreview() error = %v", err)
+	}
+	// Replace the preview's share with one that is not a preview's
+	if err := client.DeleteNFSShare(ctx, preview.ShareID); err != nil {
+		t.Fatalf("DeleteNFSShare() error = %v", err)
+	}
+	if _, err := client.CreateNFSShare(ctx, tnsapi.NFSShareCreateParams{Path: preview.SharePath, Comment: "someone else's export", Enabled: true}); err != nil {
+		t.Fatalf("CreateNFSShare() error = %v", err)
+	}
+
+	if _, err := CreateSnapshotPreview(ctx, client, testSnapshot, time.Hour, testAccess); !errors.Is(err, ErrPreviewShareConflict) {
+		t.Errorf("CreateSnapshotPreview() error = %v, want ErrPreviewShareConflict", err)
+	}
+	if !srv.DatasetExists(preview.Dataset) {
+		t.Error("preview clone of an earlier call was deleted")
+	}
+}
+
+func TestReapExpiredPreviews(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	tests := []struct {
+		name        string
+		expiresAt   string // tnsapi.PropertyPreviewExpiresAt ("" = not set)
+		wantRemoved int
+	}{
+		{name: "expired", expiresAt: now.Add(-time.Minute).Format(time.RFC3339), wantRemoved: 1},
+		{name: "expires now", expiresAt: now.Format(time.RFC3339), wantRemoved: 1},
+		{name: "not yet expired", expiresAt: now.Add(time.Minute).Format(time.RFC3339)},
+		{name: "no expiry", expiresAt: ""},
+		{name: "unparsable expiry", expiresAt: "tomorrow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client := newPreviewTestClient(t)
+			ctx := context.Background()
+
+			preview, err := CreateSnapshotPreview(ctx, client, testSnapshot, time.Hour, testAccess)
+			if err != nil {
+				t.Fatalf("CreateSnapshotPreview() error = %v", err)
+			}
+			props := map[string]string{tnsapi.PropertyPreviewExpiresAt: tt.expiresAt}
+			if err := client.SetDatasetProperties(ctx, preview.Dataset, props); err != nil {
+				t.Fatalf("SetDatasetProperties() error = %v", err)
+			}
+
+			removed, err := ReapExpiredPreviews(ctx, client, now)
+			if err != nil || removed != tt.wantRemoved {
+				t.Fatalf("ReapExpiredPreviews() = %d, %v; want %d", removed, err, tt.wantRemoved)
+			}
+			wantShares := 1 - tt.wantRemoved
+			if got := srv.DatasetExists(preview.Dataset); got != (tt.wantRemoved == 0) {
+				t.Errorf("preview clone exists = %v after reaping %d", got, removed)
+			}
+			if n := srv.Counts()["nfs"]; n != wantShares {
+				t.Errorf("%d NFS shares left, want %d", n, wantShares)
+			}
+			if !srv.DatasetExists("tank/k8s/pvc-1") {
+				t.Error("reaper deleted the preview's source volume")
+			}
+		})
+	}
+}
+
+func TestDeleteSnapshotPreview(t *testing.T) {
+	tests := []struct {
+		wantErr error
+		name    string
+		dataset string
+		deleted bool
+	}{
+		{name: "preview", dataset: "tank/csi-previews/pvc-1-snap-1", deleted: true},
+		{name: "volume that is not a preview", dataset: "tank/k8s/pvc-1", wantErr: ErrPreviewNotFound},
+		{name: "previews parent dataset", dataset: "tank/" + PreviewsFolder, wantErr: ErrPreviewNotFound},
+		{name: "missing dataset", dataset: "tank/csi-previews/missing", wantErr: ErrPreviewNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client := newPreviewTestClient(t)
+			ctx := context.Background()
+
+			if _, err := CreateSnapshotPreview(ctx, client, testSnapshot, time.Hour, testAccess); err != nil {
+				t.Fatalf("CreateSnapshotPreview() error = %v", err)
+			}
+
+			err := DeleteSnapshotPreview(ctx, client, tt.dataset)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DeleteSnapshotPreview(%s) error = %v, want %v", tt.dataset, err, tt.wantErr)
+			}
+			if tt.deleted {
+				if srv.DatasetExists(tt.dataset) || srv.Counts()["nfs"] != 0 {
+					t.Errorf("preview %s or its share left after DeleteSnapshotPreview", tt.dataset)
+				}
+				return
+			}
+			for _, ds := range []string{"tank/k8s/pvc-1", "tank/" + PreviewsFolder, "tank/csi-previews/pvc-1-snap-1"} {
+				if !srv.DatasetExists(ds) {
+					t.Errorf("rejected DeleteSnapshotPreview(%s) deleted %s", tt.dataset, ds)
+				}
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/fenio/tns-csi/pkg/tnsapi"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
 
@@ -20,6 +21,7 @@ type Server struct {
 	client    tnsapi.ClientInterface
 	templates *template.Template
 	httpSrv   *http.Server
+	pvSource  PVSource // Cached PVs (nil = list them from the API on every request)
 	pool      string
 	version   string
 	clusterID string
@@ -46,6 +48,16 @@ func NewServer(client tnsapi.ClientInterface, pool, version, clusterID string) (
 	}, nil
 }
 
+// PVSource returns the cluster's PersistentVolumes from a cache; ok is false while the cache is
+// unavailable.
+type PVSource func() (pvs []*corev1.PersistentVolume, ok bool)
+
+// SetPVSource makes the dashboard read PersistentVolumes from a cache (e.g. the controller's
+// informers) instead of listing them from the Kubernetes API on every request.
+func (s *Server) SetPVSource(source PVSource) {
+	s.pvSource = source
+}
+
 // RegisterRoutes registers dashboard routes on an existing mux with a path prefix.
 func (s *Server) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/dashboard/", s.handleDashboard)
@@ -57,6 +69,8 @@ func (s *Server) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/dashboard/api/unmanaged", s.handleAPIUnmanaged)
 	mux.HandleFunc("/dashboard/api/metrics", s.handleAPIMetrics)
 	mux.HandleFunc("/dashboard/api/metrics/raw", s.handleAPIMetricsRaw)
+	mux.HandleFunc("/dashboard/api/previews", s.handleAPIPreviews)
+	mux.HandleFunc("/dashboard/api/previews/", s.handleAPIPreviewDetail)
 	mux.HandleFunc("/dashboard/partials/volumes", s.handlePartialVolumes)
 	mux.HandleFunc("/dashboard/partials/snapshots", s.handlePartialSnapshots)
 	mux.HandleFunc("/dashboard/partials/clones", s.handlePartialClones)
@@ -3,6 +3,8 @@
 // metrics directly from prometheus.DefaultGatherer.
 package dashboard
 
+import "fmt"
+
 // Data contains all data for the dashboard template.
 //
 //nolint:govet // field alignment not critical for this struct
@@ -49,19 +51,86 @@ type VolumeInfo struct {
 	HealthStatus      string            `json:"healthStatus"      yaml:"healthStatus"`
 	HealthIssue       string            `json:"healthIssue"       yaml:"healthIssue"`
 	ClusterID         string            `json:"clusterId"         yaml:"clusterId"`
+	PVCName           string            `json:"pvcName,omitempty" yaml:"pvcName,omitempty"`
+	PVCNamespace      string            `json:"pvcNamespace,omitempty" yaml:"pvcNamespace,omitempty"`
+	PVName            string            `json:"pvName,omitempty"  yaml:"pvName,omitempty"`
 	K8s               *K8sVolumeBinding `json:"k8s,omitempty"     yaml:"k8s,omitempty"`
 	CapacityBytes     int64             `json:"capacityBytes"     yaml:"capacityBytes"`
 	Adoptable         bool              `json:"adoptable"         yaml:"adoptable"`
+	SpaceEfficiency   `yaml:",inline"`
+}
+
+// SpaceEfficiency contains the space used by a volume and what ZFS compression and
+// deduplication save on it.
+//
+//nolint:govet // field alignment not critical for display struct
+type SpaceEfficiency struct {
+	UsedBytes         int64   `json:"usedBytes"                   yaml:"usedBytes"`
+	UsedHuman         string  `json:"usedHuman"                   yaml:"usedHuman"`
+	LogicalUsedBytes  int64   `json:"logicalUsedBytes"            yaml:"logicalUsedBytes"`
+	LogicalUsedHuman  string  `json:"logicalUsedHuman"            yaml:"logicalUsedHuman"`
+	CompressRatio     float64 `json:"compressRatio"               yaml:"compressRatio"`
+	Deduplication     bool    `json:"deduplication"               yaml:"deduplication"`
+	DedupRatio        float64 `json:"dedupRatio,omitempty"        yaml:"dedupRatio,omitempty"`        // Pool-wide; ZFS does not track dedup per dataset
+	DedupSavingsBytes int64   `json:"dedupSavingsBytes,omitempty" yaml:"dedupSavingsBytes,omitempty"` // Estimated from the pool ratio
+	DedupSavingsHuman string  `json:"dedupSavingsHuman,omitempty" yaml:"dedupSavingsHuman,omitempty"`
+}
+
+// CompressRatioHuman returns the compression ratio as shown by zfs get, e.g. "1.52x".
+func (e SpaceEfficiency) CompressRatioHuman() string {
+	if e.CompressRatio <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2fx", e.CompressRatio)
+}
+
+// CompressSavingsHuman returns the space saved by compression, e.g. "1.2Gi".
+func (e SpaceEfficiency) CompressSavingsHuman() string {
+	if e.LogicalUsedBytes <= e.UsedBytes {
+		return "-"
+	}
+	return FormatBytes(e.LogicalUsedBytes - e.UsedBytes)
+}
+
+// DedupHuman returns the estimated deduplication savings with the pool ratio, "off" if
+// deduplication is disabled on the volume, or "-" if the pool ratio is unknown.
+func (e SpaceEfficiency) DedupHuman() string {
+	switch {
+	case !e.Deduplication:
+		return "off"
+	case e.DedupRatio <= 0:
+		return "-"
+	}
+	return fmt.Sprintf("~%s (pool %.2fx)", FormatBytes(e.DedupSavingsBytes), e.DedupRatio)
+}
+
+// ClaimName returns the PVC name from live Kubernetes data, falling back to the
+// name recorded in the dataset's tns-csi:pvc_name property.
+func (v VolumeInfo) ClaimName() string {
+	if v.K8s != nil && v.K8s.PVCName != "" {
+		return v.K8s.PVCName
+	}
+	return v.PVCName
+}
+
+// ClaimNamespace returns the PVC namespace from live Kubernetes data, falling back to the
+// namespace recorded in the dataset's tns-csi:pvc_namespace property.
+func (v VolumeInfo) ClaimNamespace() string {
+	if v.K8s != nil && v.K8s.PVCNamespace != "" {
+		return v.K8s.PVCNamespace
+	}
+	return v.PVCNamespace
 }
 
 // SnapshotInfo represents a tns-csi managed snapshot.
 type SnapshotInfo struct {
-	Name           string `json:"name"           yaml:"name"`
-	SourceVolume   string `json:"sourceVolume"   yaml:"sourceVolume"`
-	SourceDataset  string `json:"sourceDataset"  yaml:"sourceDataset"`
-	Protocol       string `json:"protocol"       yaml:"protocol"`
-	Type           string `json:"type"           yaml:"type"`
-	DeleteStrategy string `json:"deleteStrategy" yaml:"deleteStrategy"`
+	Name           string   `json:"name"             yaml:"name"`
+	SourceVolume   string   `json:"sourceVolume"     yaml:"sourceVolume"`
+	SourceDataset  string   `json:"sourceDataset"    yaml:"sourceDataset"`
+	Protocol       string   `json:"protocol"         yaml:"protocol"`
+	Type           string   `json:"type"             yaml:"type"`
+	DeleteStrategy string   `json:"deleteStrategy"   yaml:"deleteStrategy"`
+	Clones         []string `json:"clones,omitempty" yaml:"clones,omitempty"` // COW clones still depending on the snapshot
 }
 
 // CloneInfo represents a tns-csi managed cloned volume.
@@ -137,11 +206,25 @@ type HealthSummary struct {
 
 // K8sVolumeBinding holds Kubernetes PV/PVC/Pod data for a volume.
 type K8sVolumeBinding struct {
-	PVName       string   `json:"pvName"                 yaml:"pvName"`
-	PVCName      string   `json:"pvcName,omitempty"      yaml:"pvcName,omitempty"`
-	PVCNamespace string   `json:"pvcNamespace,omitempty" yaml:"pvcNamespace,omitempty"`
-	PVStatus     string   `json:"pvStatus"               yaml:"pvStatus"`
-	Pods         []string `json:"pods,omitempty"         yaml:"pods,omitempty"`
+	PVName       string         `json:"pvName"                 yaml:"pvName"`
+	PVCName      string         `json:"pvcName,omitempty"      yaml:"pvcName,omitempty"`
+	PVCNamespace string         `json:"pvcNamespace,omitempty" yaml:"pvcNamespace,omitempty"`
+	PVStatus     string         `json:"pvStatus"               yaml:"pvStatus"`
+	Pods         []string       `json:"pods,omitempty"         yaml:"pods,omitempty"`
+	Nodes        []K8sNodeUsage `json:"nodes,omitempty"        yaml:"nodes,omitempty"` // where pods or VolumeAttachments use the volume
+}
+
+// K8sNodeUsage describes the use of a volume on one node.
+type K8sNodeUsage struct {
+	Node       string        `json:"node"                 yaml:"node"`
+	Attachment string        `json:"attachment,omitempty" yaml:"attachment,omitempty"` // VolumeAttachment state; empty without one
+	Pods       []K8sPodUsage `json:"pods,omitempty"       yaml:"pods,omitempty"`
+}
+
+// K8sPodUsage is a pod using a volume.
+type K8sPodUsage struct {
+	Name  string `json:"name"  yaml:"name"`  // namespace/name
+	Phase string `json:"phase" yaml:"phase"` // pod phase, or Terminating
 }
 
 // K8sEnrichmentResult contains the results of K8s enrichment.
@@ -154,18 +237,20 @@ type K8sEnrichmentResult struct {
 //
 //nolint:govet // field alignment not critical for display struct
 type VolumeDetails struct {
-	Dataset           string                  `json:"dataset"                     yaml:"dataset"`
-	VolumeID          string                  `json:"volumeId"                    yaml:"volumeId"`
-	Protocol          string                  `json:"protocol"                    yaml:"protocol"`
-	Type              string                  `json:"type"                        yaml:"type"`
-	MountPath         string                  `json:"mountPath"                   yaml:"mountPath"`
-	CapacityBytes     int64                   `json:"capacityBytes"               yaml:"capacityBytes"`
-	CapacityHuman     string                  `json:"capacityHuman"               yaml:"capacityHuman"`
-	UsedBytes         int64                   `json:"usedBytes"                   yaml:"usedBytes"`
-	UsedHuman         string                  `json:"usedHuman"                   yaml:"usedHuman"`
+	Dataset           string `json:"dataset"                     yaml:"dataset"`
+	VolumeID          string `json:"volumeId"                    yaml:"volumeId"`
+	Protocol          string `json:"protocol"                    yaml:"protocol"`
+	Type              string `json:"type"                        yaml:"type"`
+	MountPath         string `json:"mountPath"                   yaml:"mountPath"`
+	CapacityBytes     int64  `json:"capacityBytes"               yaml:"capacityBytes"`
+	CapacityHuman     string `json:"capacityHuman"               yaml:"capacityHuman"`
+	SpaceEfficiency   `yaml:",inline"`
 	CreatedAt         string                  `json:"createdAt"                   yaml:"createdAt"`
 	DeleteStrategy    string                  `json:"deleteStrategy"              yaml:"deleteStrategy"`
 	Adoptable         bool                    `json:"adoptable"                   yaml:"adoptable"`
+	PVCName           string                  `json:"pvcName,omitempty" yaml:"pvcName,omitempty"`
+	PVCNamespace      string                  `json:"pvcNamespace,omitempty" yaml:"pvcNamespace,omitempty"`
+	PVName            string                  `json:"pvName,omitempty" yaml:"pvName,omitempty"`
 	ContentSourceType string                  `json:"contentSourceType,omitempty" yaml:"contentSourceType,omitempty"`
 	ContentSourceID   string                  `json:"contentSourceId,omitempty"   yaml:"contentSourceId,omitempty"`
 	CloneMode         string                  `json:"cloneMode,omitempty"         yaml:"cloneMode,omitempty"`
@@ -0,0 +1,251 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/fenio/tns-csi/pkg/dashboard"
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// Admin API.
+//
+// With --admin-addr the controller serves a small JSON API below /admin/v1/: the managed volumes,
+// orphaned volumes, snapshots and volume health, as kubectl tns-csi and the dashboard compute
+// them, and the export and import actions (volume_stream.go). Clients use the controller's
+// TrueNAS connection instead of their own, so neither the plugin nor its users need the TrueNAS
+// API key.
+//
+// The API is served over TLS only (--admin-tls-cert, --admin-tls-key). Access is controlled with
+// Kubernetes RBAC only. Every request carries a Kubernetes bearer token for the tns.csi.io/admin
+// audience, in the Authorization header or, through the API server's service proxy (which
+// consumes Authorization), in X-Tns-Csi-Authorization. kubectl tns-csi requests these tokens for
+// the <release>-admin-viewer and <release>-admin-operator ServiceAccounts, so callers never hand
+// the controller a token the API server accepts. The controller verifies the token with a
+// TokenReview for that audience and lets the user in if a SubjectAccessReview allows "get" on the
+// "admin" resource of the tns.csi.io API group, or "create" for the actions, which are POSTed.
+
+// Authorization attributes a user needs for the admin API.
+const (
+	adminAPIGroup    = "tns.csi.io"
+	adminAPIResource = "admin"
+	adminAPIVerb     = "get"
+	adminActionVerb  = "create"
+)
+
+// Admin API configuration and authentication errors.
+var (
+	errAdminNoToken      = errors.New("no bearer token: send a Kubernetes token in the Authorization or " + dashboard.AdminTokenHeader + " header")
+	errAdminInvalidToken = errors.New("invalid or expired token, or not issued for the " + dashboard.AdminTokenAudience + " audience")
+	// errInvalidAdminRequest is wrapped by errors about the request of an action.
+	errInvalidAdminRequest = errors.New("invalid request")
+	// errAdminTLSRequired is returned by NewDriver for --admin-addr without a certificate: callers
+	// send bearer tokens, which must never cross the network in plaintext.
+	errAdminTLSRequired = errors.New("--admin-addr requires --admin-tls-cert and --admin-tls-key")
+)
+
+// adminAuthenticator verifies admin API callers against the Kubernetes API.
+type adminAuthenticator struct {
+	kube kubernetes.Interface
+}
+
+// newAdminKubeClient returns the Kubernetes client the admin API authenticates callers with.
+func newAdminKubeClient() (kubernetes.Interface, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// requestToken returns the bearer token of a request, preferring the proxied header.
+func requestToken(r *http.Request) string {
+	for _, header := range []string{dashboard.AdminTokenHeader, "Authorization"} {
+		if token, ok := strings.CutPrefix(r.Header.Get(header), "Bearer "); ok && strings.TrimSpace(token) != "" {
+			return strings.TrimSpace(token)
+		}
+	}
+	return ""
+}
+
+// authorize returns the user of a request allowed to use the admin API, or the HTTP status and
+// error to reject it with.
+func (a *adminAuthenticator) authorize(ctx context.Context, r *http.Request, verb string) (string, int, error) {
+	token := requestToken(r)
+	if token == "" {
+		return "", http.StatusUnauthorized, errAdminNoToken
+	}
+
+	review, err := a.kube.AuthenticationV1().TokenReviews().Create(ctx, &authnv1.TokenReview{
+		Spec: authnv1.TokenReviewSpec{Token: token, Audiences: []string{dashboard.AdminTokenAudience}},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("token review failed: %w", err)
+	}
+	// Authenticators that ignore audiences leave them out of the status
+	if !review.Status.Authenticated || !slices.Contains(review.Status.Audiences, dashboard.AdminTokenAudience) {
+		return "", http.StatusUnauthorized, errAdminInvalidToken
+	}
+	user := review.Status.User
+
+	extra := make(map[string]authzv1.ExtraValue, len(user.Extra))
+	for key, values := range user.Extra {
+		extra[key] = authzv1.ExtraValue(values)
+	}
+	access, err := a.kube.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authzv1.SubjectAccessReview{
+		Spec: authzv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authzv1.ResourceAttributes{
+				Group:    adminAPIGroup,
+				Resource: adminAPIResource,
+				Verb:     verb,
+			},
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("access review failed: %w", err)
+	}
+	if !access.Status.Allowed {
+		return "", http.StatusForbidden, fmt.Errorf("user %q may not %s %s.%s", user.Username, verb, adminAPIResource, adminAPIGroup)
+	}
+	return user.Username, 0, nil
+}
+
+// adminAPIHandler serves the admin API of the controller, authenticating callers with kube.
+func adminAPIHandler(controller *ControllerService, kube kubernetes.Interface) http.Handler {
+	auth := &adminAuthenticator{kube: kube}
+	endpoints := map[string]func(ctx context.Context) (any, error){
+		dashboard.AdminVolumes: func(ctx context.Context) (any, error) {
+			return dashboard.FindManagedVolumes(ctx, controller.apiClient, controller.clusterID)
+		},
+		dashboard.AdminOrphans: func(ctx context.Context) (any, error) {
+			return adminOrphans(ctx, controller, kube)
+		},
+		dashboard.AdminSnapshots: func(ctx context.Context) (any, error) {
+			return dashboard.FindManagedSnapshots(ctx, controller.apiClient, controller.clusterID)
+		},
+		dashboard.AdminHealth: func(ctx context.Context) (any, error) {
+			return dashboard.CheckVolumeHealth(ctx, controller.apiClient)
+		},
+	}
+	actions := map[string]func(ctx context.Context, body io.Reader) (any, error){
+		dashboard.AdminExport: func(ctx context.Context, body io.Reader) (any, error) {
+			var req dashboard.ExportRequest
+			if err := json.NewDecoder(body).Decode(&req); err != nil {
+				return nil, fmt.Errorf("%w: %w", errInvalidAdminRequest, err)
+			}
+			return controller.exportVolume(ctx, req)
+		},
+		dashboard.AdminImport: func(ctx context.Context, body io.Reader) (any, error) {
+			var req dashboard.ImportRequest
+			if err := json.NewDecoder(body).Decode(&req); err != nil {
+				return nil, fmt.Errorf("%w: %w", errInvalidAdminRequest, err)
+			}
+			return controller.importVolume(ctx, req)
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, dashboard.AdminAPIPrefix)
+		endpoint, isEndpoint := endpoints[name]
+		action, isAction := actions[name]
+		if (!isEndpoint && !isAction) || !strings.HasPrefix(r.URL.Path, dashboard.AdminAPIPrefix) {
+			writeAdminError(w, http.StatusNotFound, fmt.Errorf("unknown endpoint %s", r.URL.Path))
+			return
+		}
+		verb, method := adminAPIVerb, http.MethodGet
+		if isAction {
+			verb, method = adminActionVerb, http.MethodPost
+		}
+		if r.Method != method {
+			writeAdminError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+
+		user, code, err := auth.authorize(r.Context(), r, verb)
+		if err != nil {
+			klog.V(4).Infof("Admin API request %s rejected: %v", r.URL.Path, err)
+			writeAdminError(w, code, err)
+			return
+		}
+		klog.V(4).Infof("Admin API request %s by %s", r.URL.Path, user)
+
+		var result any
+		if isAction {
+			klog.Infof("Admin API action %s by %s", name, user)
+			result, err = action(r.Context(), r.Body)
+		} else {
+			result, err = endpoint(r.Context())
+		}
+		if err != nil {
+			klog.Warningf("Admin API request %s failed: %v", r.URL.Path, err)
+			code := http.StatusInternalServerError
+			if errors.Is(err, errInvalidAdminRequest) {
+				code = http.StatusBadRequest
+			}
+			writeAdminError(w, code, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			klog.Warningf("Failed to write admin API response: %v", err)
+		}
+	})
+}
+
+// adminOrphans returns the managed volumes of this cluster without a bound PVC.
+func adminOrphans(ctx context.Context, controller *ControllerService, kube kubernetes.Interface) ([]dashboard.OrphanedVolume, error) {
+	volumes, err := dashboard.FindManagedVolumes(ctx, controller.apiClient, controller.clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	pvs, ok := controller.kubeView.listPVs()
+	if !ok {
+		list, err := kube.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list PVs: %w", err)
+		}
+		pvs = make([]*corev1.PersistentVolume, len(list.Items))
+		for i := range list.Items {
+			pvs[i] = &list.Items[i]
+		}
+	}
+	list, err := kube.CoreV1().PersistentVolumeClaims("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PVCs: %w", err)
+	}
+	pvcs := make([]*corev1.PersistentVolumeClaim, len(list.Items))
+	for i := range list.Items {
+		pvcs[i] = &list.Items[i]
+	}
+
+	orphans := dashboard.FindOrphanedVolumes(volumes, dashboard.PVInfoMap(pvs), dashboard.PVCInfoMap(pvcs))
+	if orphans == nil {
+		orphans = []dashboard.OrphanedVolume{}
+	}
+	return orphans, nil
+}
+
+// writeAdminError writes an admin API error as {"error": "..."}.
+func writeAdminError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	//nolint:errcheck,errchkjson,gosec // Best effort error response
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
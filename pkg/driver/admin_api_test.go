@@ -0,0 +1,167 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/dashboard"
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestAdminAPIIntegration(t *testing.T) {
+	controller, _ := newIntegrationController(t)
+	ctx := context.Background()
+
+	var volumeIDs []string
+	for _, name := range []string{"pvc-bound", "pvc-orphan"} {
+		resp, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:          name,
+			CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			Parameters: map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local"},
+		})
+		if err != nil {
+			t.Fatalf("CreateVolume(%s) error = %v", name, err)
+		}
+		volumeIDs = append(volumeIDs, resp.GetVolume().GetVolumeId())
+	}
+
+	kube := fake.NewClientset(testPV("pv-bound", volumeIDs[0], "data"),
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "apps"}})
+	tokens := map[string]string{"admin-token": "admin", "operator-token": "operator", "viewer-token": "viewer", "apiserver-token": "admin"}
+	kube.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authnv1.TokenReview)
+		user, ok := tokens[review.Spec.Token]
+		// apiserver-token is only valid for the API server's own audience
+		ok = ok && slices.Contains(review.Spec.Audiences, dashboard.AdminTokenAudience) && review.Spec.Token != "apiserver-token"
+		review.Status = authnv1.TokenReviewStatus{Authenticated: ok, User: authnv1.UserInfo{Username: user}}
+		if ok {
+			review.Status.Audiences = []string{dashboard.AdminTokenAudience}
+		}
+		return true, review, nil
+	})
+	kube.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authzv1.SubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		verbs := map[string][]string{"admin": {adminAPIVerb}, "operator": {adminAPIVerb, adminActionVerb}}[review.Spec.User]
+		review.Status.Allowed = attrs.Group == adminAPIGroup && attrs.Resource == adminAPIResource && slices.Contains(verbs, attrs.Verb)
+		return true, review, nil
+	})
+
+	srv := httptest.NewServer(adminAPIHandler(controller, kube))
+	t.Cleanup(srv.Close)
+	get := func(endpoint, header, token string, out any) int {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+dashboard.AdminAPIPrefix+endpoint, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set(header, "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil && resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				t.Fatalf("decoding %s: %v", endpoint, err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	for _, tt := range []struct {
+		name, token string
+		want        int
+	}{
+		{name: "no token", want: http.StatusUnauthorized},
+		{name: "invalid token", token: "stolen", want: http.StatusUnauthorized},
+		{name: "token for another audience", token: "apiserver-token", want: http.StatusUnauthorized},
+		{name: "not allowed", token: "viewer-token", want: http.StatusForbidden},
+	} {
+		if code := get(dashboard.AdminVolumes, "Authorization", tt.token, nil); code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+	if code := get("unknown", "Authorization", "admin-token", nil); code != http.StatusNotFound {
+		t.Errorf("unknown endpoint: status = %d, want %d", code, http.StatusNotFound)
+	}
+
+	var volumes []dashboard.VolumeInfo
+	if code := get(dashboard.AdminVolumes, "Authorization", "admin-token", &volumes); code != http.StatusOK || len(volumes) != 2 {
+		t.Errorf("volumes = %d, %v; want both volumes", code, volumes)
+	}
+
+	// Through the API server's service proxy the token arrives in the admin token header
+	var orphans []dashboard.OrphanedVolume
+	if code := get(dashboard.AdminOrphans, dashboard.AdminTokenHeader, "admin-token", &orphans); code != http.StatusOK {
+		t.Fatalf("orphans status = %d", code)
+	}
+	if len(orphans) != 1 || orphans[0].Dataset != volumeIDs[1] || orphans[0].Reason != "no PV in cluster" {
+		t.Errorf("orphans = %+v, want %s without a PV", orphans, volumeIDs[1])
+	}
+
+	var health dashboard.HealthReport
+	if code := get(dashboard.AdminHealth, "Authorization", "admin-token", &health); code != http.StatusOK || health.Summary.TotalVolumes != 2 {
+		t.Errorf("health = %d, %+v; want 2 volumes", code, health.Summary)
+	}
+	var snapshots []dashboard.SnapshotInfo
+	if code := get(dashboard.AdminSnapshots, "Authorization", "admin-token", &snapshots); code != http.StatusOK {
+		t.Errorf("snapshots status = %d", code)
+	}
+
+	// Actions are POSTed and need "create"
+	post := func(action, token, body string) int {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+dashboard.AdminAPIPrefix+action, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get(dashboard.AdminExport, "Authorization", "operator-token", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET export: status = %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+	if code := post(dashboard.AdminExport, "admin-token", `{"volumeId": "`+volumeIDs[0]+`"}`); code != http.StatusForbidden {
+		t.Errorf("export without create: status = %d, want %d", code, http.StatusForbidden)
+	}
+	if code := post(dashboard.AdminExport, "operator-token", `{"volumeId": "`+volumeIDs[0]+`"}`); code != http.StatusBadRequest {
+		t.Errorf("export without url: status = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestNewDriverAdminTLS(t *testing.T) {
+	cfg := Config{DriverName: "tns.csi.io", NodeID: "controller", Endpoint: "unix:///tmp/csi.sock", AdminAddr: ":9091", TestMode: true}
+	if _, err := NewDriverWithClient(cfg, nil); !errors.Is(err, errAdminTLSRequired) {
+		t.Errorf("NewDriverWithClient() with an admin API without TLS error = %v, want %v", err, errAdminTLSRequired)
+	}
+
+	cfg.AdminTLSCertFile, cfg.AdminTLSKeyFile = "/etc/tns-csi/admin-tls/tls.crt", "/etc/tns-csi/admin-tls/tls.key"
+	if _, err := NewDriverWithClient(cfg, nil); err != nil {
+		t.Errorf("NewDriverWithClient() with an admin API certificate error = %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API key sources.
+//
+// The storage API key is read at startup and re-fetched periodically (and on SIGHUP) from an
+// APIKeySource, so a rotated key is picked up without a restart:
+//   - a file (--api-key-file): a mounted Kubernetes Secret, or a secret object mounted by the
+//     Secrets Store CSI driver from an external store (Vault, AWS, Azure, GCP)
+//   - HashiCorp Vault (--vault-addr): a KV secret read over the Vault HTTP API, authenticated
+//     with the pod's service account token (Kubernetes auth) or VAULT_TOKEN
+//
+// Orgs that forbid long-lived keys in Kubernetes Secrets use one of the latter two.
+
+// DefaultVaultRefreshInterval is how often the API key is re-read from Vault.
+const DefaultVaultRefreshInterval = 5 * time.Minute
+
+// Vault defaults.
+const (
+	defaultVaultSecretKey = "api-key"
+	defaultVaultAuthMount = "kubernetes"
+	serviceAccountToken   = "/var/run/secrets/kubernetes.io/serviceaccount/token" //nolint:gosec // path, not a credential
+	vaultRequestTimeout   = 30 * time.Second
+	vaultTokenRenewMargin = time.Minute // Log in again this long before the token expires
+)
+
+// Vault errors.
+var (
+	ErrVaultSecretPathRequired = errors.New("--vault-secret-path is required with --vault-addr")
+	ErrVaultNoCredentials      = errors.New("no Vault credentials: set --vault-role for Kubernetes auth or VAULT_TOKEN")
+	errVaultKeyMissing         = errors.New("key missing from the Vault secret")
+	errVaultRequest            = errors.New("request to Vault failed")
+	errVaultNoCACerts          = errors.New("no certificates in the Vault CA file")
+)
+
+// APIKeySource fetches the storage API key.
+type APIKeySource interface {
+	FetchAPIKey(ctx context.Context) (string, error)
+	String() string // Where the key comes from, for logs
+}
+
+// FileAPIKeySource reads the API key from a mounted file.
+type FileAPIKeySource struct {
+	Path string
+}
+
+// FetchAPIKey implements APIKeySource.
+func (f FileAPIKeySource) FetchAPIKey(_ context.Context) (string, error) {
+	return ReadAPIKeyFile(f.Path)
+}
+
+func (f FileAPIKeySource) String() string {
+	return "file " + f.Path
+}
+
+// VaultConfig configures reading the API key from HashiCorp Vault.
+type VaultConfig struct {
+	Address    string // Vault address, e.g. https://vault.example.com:8200
+	SecretPath string // API path of the secret below /v1/: secret/data/tns-csi (KV v2) or kv/tns-csi (KV v1)
+	SecretKey  string // Key of the secret holding the API key (empty = api-key)
+	Role       string // Kubernetes auth role (empty = use Token)
+	AuthMount  string // Mount path of the Kubernetes auth method (empty = kubernetes)
+	Namespace  string // Vault Enterprise namespace (empty = none)
+	CAFile     string // CA bundle for the Vault server certificate (empty = system roots)
+	Token      string // Static Vault token, used without Role (typically VAULT_TOKEN)
+}
+
+// vaultAPIKeySource reads the API key from a Vault KV secret.
+type vaultAPIKeySource struct {
+	client    *http.Client
+	cfg       VaultConfig
+	jwtPath   string // Service account token for Kubernetes auth
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time // Zero = does not expire
+}
+
+// NewVaultAPIKeySource returns an APIKeySource reading from Vault.
+func NewVaultAPIKeySource(cfg VaultConfig) (APIKeySource, error) {
+	cfg.Address = strings.TrimRight(cfg.Address, "/")
+	cfg.SecretPath = strings.Trim(cfg.SecretPath, "/")
+	if cfg.SecretPath == "" {
+		return nil, ErrVaultSecretPathRequired
+	}
+	if cfg.Role == "" && cfg.Token == "" {
+		return nil, ErrVaultNoCredentials
+	}
+	if cfg.SecretKey == "" {
+		cfg.SecretKey = defaultVaultSecretKey
+	}
+	if cfg.AuthMount == "" {
+		cfg.AuthMount = defaultVaultAuthMount
+	}
+
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Vault CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: %s", errVaultNoCACerts, cfg.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return &vaultAPIKeySource{
+		client:  &http.Client{Transport: transport, Timeout: vaultRequestTimeout},
+		cfg:     cfg,
+		jwtPath: serviceAccountToken,
+		token:   cfg.Token,
+	}, nil
+}
+
+func (v *vaultAPIKeySource) String() string {
+	return fmt.Sprintf("Vault %s/v1/%s (key %s)", v.cfg.Address, v.cfg.SecretPath, v.cfg.SecretKey)
+}
+
+// FetchAPIKey implements APIKeySource. With Kubernetes auth a rejected token is replaced once.
+func (v *vaultAPIKeySource) FetchAPIKey(ctx context.Context) (string, error) {
+	token, err := v.vaultToken(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	key, status, err := v.readSecret(ctx, token)
+	if status == http.StatusForbidden && v.cfg.Role != "" {
+		if token, err = v.vaultToken(ctx, true); err != nil {
+			return "", err
+		}
+		key, _, err = v.readSecret(ctx, token)
+	}
+	return key, err
+}
+
+// vaultToken returns the Vault token, logging in with the service account token when there is
+// none yet, it is about to expire, or renew is set.
+func (v *vaultAPIKeySource) vaultToken(ctx context.Context, renew bool) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.cfg.Role == "" {
+		return v.token, nil
+	}
+	if v.token != "" && !renew && (v.expiresAt.IsZero() || time.Until(v.expiresAt) > vaultTokenRenewMargin) {
+		return v.token, nil
+	}
+
+	jwt, err := os.ReadFile(v.jwtPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read service account token for Vault login: %w", err)
+	}
+	body, err := json.Marshal(map[string]string{"role": v.cfg.Role, "jwt": strings.TrimSpace(string(jwt))})
+	if err != nil {
+		return "", err
+	}
+	var login struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if _, err := v.do(ctx, http.MethodPost, "auth/"+strings.Trim(v.cfg.AuthMount, "/")+"/login", "", body, &login); err != nil {
+		return "", fmt.Errorf("login to Vault with role %s failed: %w", v.cfg.Role, err)
+	}
+
+	v.token = login.Auth.ClientToken
+	v.expiresAt = time.Time{}
+	if login.Auth.LeaseDuration > 0 {
+		v.expiresAt = time.Now().Add(time.Duration(login.Auth.LeaseDuration) * time.Second)
+	}
+	return v.token, nil
+}
+
+// readSecret reads the API key from the KV secret, returning the HTTP status on failure.
+func (v *vaultAPIKeySource) readSecret(ctx context.Context, token string) (string, int, error) {
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	status, err := v.do(ctx, http.MethodGet, v.cfg.SecretPath, token, nil, &secret)
+	if err != nil {
+		return "", status, fmt.Errorf("failed to read Vault secret %s: %w", v.cfg.SecretPath, err)
+	}
+
+	// KV v2 nests the secret in data.data
+	data := secret.Data
+	if nested, ok := data["data"]; ok {
+		var inner map[string]json.RawMessage
+		if err := json.Unmarshal(nested, &inner); err == nil {
+			data = inner
+		}
+	}
+	var key string
+	if raw, ok := data[v.cfg.SecretKey]; !ok || json.Unmarshal(raw, &key) != nil || strings.TrimSpace(key) == "" {
+		return "", status, fmt.Errorf("%w: %s in %s", errVaultKeyMissing, v.cfg.SecretKey, v.cfg.SecretPath)
+	}
+	return strings.TrimSpace(key), status, nil
+}
+
+// do sends a Vault API request and decodes the response into out.
+func (v *vaultAPIKeySource) do(ctx context.Context, method, path, token string, body []byte, out any) (int, error) {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.cfg.Address+"/v1/"+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(data, &vaultErr) == nil && len(vaultErr.Errors) > 0 {
+			return resp.StatusCode, fmt.Errorf("%w: %s: %s", errVaultRequest, resp.Status, strings.Join(vaultErr.Errors, "; "))
+		}
+		return resp.StatusCode, fmt.Errorf("%w: %s", errVaultRequest, resp.Status)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return resp.StatusCode, fmt.Errorf("invalid Vault response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
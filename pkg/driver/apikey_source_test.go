@@ -0,0 +1,198 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeVault serves Kubernetes auth logins and one KV v2 secret.
+func fakeVault(t *testing.T, apiKey *atomic.Value, logins *atomic.Int32) *httptest.Server {
+	t.Helper()
+	var issued atomic.Value
+	issued.Store("")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			var body map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["role"] != "tns-csi" || body["jwt"] != "sa-token" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors":["invalid role or service account token"]}`)) //nolint:errcheck,gosec // test server
+				return
+			}
+			token := "vault-token-" + string(rune('0'+logins.Add(1)))
+			issued.Store(token)
+			w.Write([]byte(`{"auth":{"client_token":"` + token + `","lease_duration":3600}}`)) //nolint:errcheck,gosec // test server
+		case "/v1/secret/data/tns-csi":
+			if r.Header.Get("X-Vault-Token") != issued.Load() {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"errors":["permission denied"]}`)) //nolint:errcheck,gosec // test server
+				return
+			}
+			w.Write([]byte(`{"data":{"data":{"api-key":"` + apiKey.Load().(string) + `"},"metadata":{"version":1}}}`)) //nolint:errcheck,gosec,forcetypeassert // test server
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVaultAPIKeySourceKubernetesAuth(t *testing.T) {
+	var apiKey atomic.Value
+	apiKey.Store("1-first")
+	var logins atomic.Int32
+	srv := fakeVault(t, &apiKey, &logins)
+
+	jwt := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(jwt, []byte("sa-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	source, err := NewVaultAPIKeySource(VaultConfig{Address: srv.URL, SecretPath: "/secret/data/tns-csi", Role: "tns-csi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.(*vaultAPIKeySource).jwtPath = jwt
+	ctx := context.Background()
+
+	if key, err := source.FetchAPIKey(ctx); err != nil || key != "1-first" {
+		t.Fatalf("FetchAPIKey() = %q, %v; want 1-first", key, err)
+	}
+	apiKey.Store("1-rotated")
+	if key, err := source.FetchAPIKey(ctx); err != nil || key != "1-rotated" {
+		t.Errorf("FetchAPIKey() after rotation = %q, %v; want 1-rotated", key, err)
+	}
+	if n := logins.Load(); n != 1 {
+		t.Errorf("logins = %d, want the token reused", n)
+	}
+
+	// A revoked token is replaced by logging in again
+	source.(*vaultAPIKeySource).token = "revoked"
+	if key, err := source.FetchAPIKey(ctx); err != nil || key != "1-rotated" {
+		t.Errorf("FetchAPIKey() with a revoked token = %q, %v", key, err)
+	}
+	if n := logins.Load(); n != 2 {
+		t.Errorf("logins = %d, want 2", n)
+	}
+}
+
+func TestVaultAPIKeySourceErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "static" || r.Header.Get("X-Vault-Namespace") != "team" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data":{"other":"x"}}`)) //nolint:errcheck,gosec // test server, KV v1 layout
+	}))
+	t.Cleanup(srv.Close)
+
+	if _, err := NewVaultAPIKeySource(VaultConfig{Address: srv.URL, Token: "static"}); !errors.Is(err, ErrVaultSecretPathRequired) {
+		t.Errorf("without secret path: error = %v, want %v", err, ErrVaultSecretPathRequired)
+	}
+	if _, err := NewVaultAPIKeySource(VaultConfig{Address: srv.URL, SecretPath: "kv/tns-csi"}); !errors.Is(err, ErrVaultNoCredentials) {
+		t.Errorf("without credentials: error = %v, want %v", err, ErrVaultNoCredentials)
+	}
+
+	source, err := NewVaultAPIKeySource(VaultConfig{Address: srv.URL, SecretPath: "kv/tns-csi", Token: "static", Namespace: "team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.FetchAPIKey(context.Background()); !errors.Is(err, errVaultKeyMissing) {
+		t.Errorf("FetchAPIKey() error = %v, want %v", err, errVaultKeyMissing)
+	}
+
+	source, err = NewVaultAPIKeySource(VaultConfig{Address: srv.URL, SecretPath: "kv/tns-csi", Token: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.FetchAPIKey(context.Background()); !errors.Is(err, errVaultRequest) {
+		t.Errorf("FetchAPIKey() with a wrong token error = %v, want %v", err, errVaultRequest)
+	}
+}
+
+// fakeKeyUpdater records the API keys a client was re-authenticated with.
+type fakeKeyUpdater struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (f *fakeKeyUpdater) UpdateAPIKey(_ context.Context, apiKey string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys = append(f.keys, apiKey)
+	return nil
+}
+
+func TestReloadCredentialsFromSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api-key")
+	if err := os.WriteFile(path, []byte("1-old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d := &Driver{config: Config{APIKey: "1-old", APIKeyFile: path}}
+	source := d.apiKeySource()
+	updater := &fakeKeyUpdater{}
+
+	d.reloadCredentials(source, updater, false)
+	if len(updater.keys) != 0 {
+		t.Errorf("unchanged key applied: %v", updater.keys)
+	}
+	if err := os.WriteFile(path, []byte("1-new"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d.reloadCredentials(source, updater, false)
+	if len(updater.keys) != 1 || updater.keys[0] != "1-new" || d.currentAPIKey() != "1-new" {
+		t.Errorf("keys = %v, APIKey = %q; want 1-new applied", updater.keys, d.currentAPIKey())
+	}
+}
+
+// TestReloadCredentialsConcurrentReads rotates the key while other goroutines read it; run with
+// -race to catch unguarded access to the API key.
+func TestReloadCredentialsConcurrentReads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api-key")
+	if err := os.WriteFile(path, []byte("1-old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d := &Driver{config: Config{APIKey: "1-old", APIKeyFile: path}}
+	source := d.apiKeySource()
+	updater := &fakeKeyUpdater{}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					if key := d.currentAPIKey(); key == "" {
+						t.Error("currentAPIKey() returned an empty key during rotation")
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := range 20 {
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("1-key-%d", i)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		d.reloadCredentials(source, updater, i%2 == 0)
+	}
+	close(stop)
+	wg.Wait()
+
+	if got := d.currentAPIKey(); got != "1-key-19" {
+		t.Errorf("currentAPIKey() = %q, want 1-key-19", got)
+	}
+}
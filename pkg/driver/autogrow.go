@@ -0,0 +1,204 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// Automatic quota headroom expansion.
+//
+// With autoGrow on an NFS/SMB StorageClass (e.g. autoGrow: "20%:max=500Gi") the controller
+// keeps a minimum amount of free space on the volume. When the usage monitor (--autogrow)
+// sees free space drop below the headroom, it raises the PVC's storage request by the
+// headroom; the external-resizer then expands the quota through ControllerExpandVolume and
+// updates the PV and PVC capacity as for any manual resize. Growth stops at max.
+//
+// The policy is recorded on the dataset (tns-csi:autogrow) when the volume is created.
+const (
+	// AutoGrowParam is the StorageClass parameter enabling automatic expansion.
+	AutoGrowParam = "autoGrow"
+
+	autoGrowEventReason      = "VolumeAutoGrow"
+	autoGrowLimitEventReason = "VolumeAutoGrowLimitReached"
+)
+
+// autoGrowPolicy is a parsed autoGrow parameter.
+type autoGrowPolicy struct {
+	headroomBytes   int64 // Absolute headroom (e.g. "50Gi"); 0 when a percentage is used
+	maxBytes        int64 // Capacity limit; 0 = unlimited
+	headroomPercent int   // Headroom as a percentage of the capacity
+}
+
+// parseAutoGrowPolicy parses "<headroom>[:max=<size>]" where headroom is a percentage of the
+// capacity ("20%") or a size ("50Gi").
+func parseAutoGrowPolicy(value string) (*autoGrowPolicy, error) {
+	fields := strings.Split(strings.TrimSpace(value), ":")
+	policy := &autoGrowPolicy{}
+
+	headroom := strings.TrimSpace(fields[0])
+	if pct, ok := strings.CutSuffix(headroom, "%"); ok {
+		n, err := strconv.Atoi(pct)
+		if err != nil || n <= 0 || n >= 100 {
+			return nil, fmt.Errorf("invalid headroom %q: percentage must be between 1 and 99", headroom)
+		}
+		policy.headroomPercent = n
+	} else {
+		// Sizes below 1Gi are almost certainly a missing % sign
+		q, err := resource.ParseQuantity(headroom)
+		if err != nil || q.Value() < MinVolumeSize {
+			return nil, fmt.Errorf("invalid headroom %q: must be a percentage (20%%) or a size of at least 1Gi (50Gi)", headroom)
+		}
+		policy.headroomBytes = q.Value()
+	}
+
+	for _, option := range fields[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(option), "=")
+		if key != "max" {
+			return nil, fmt.Errorf("unknown option %q: only max=<size> is supported", option)
+		}
+		q, err := resource.ParseQuantity(val)
+		if err != nil || q.Value() <= 0 {
+			return nil, fmt.Errorf("invalid max %q: must be a size such as 500Gi", val)
+		}
+		policy.maxBytes = q.Value()
+	}
+	return policy, nil
+}
+
+// String returns the policy in parameter syntax, as stored in PropertyAutoGrow.
+func (p *autoGrowPolicy) String() string {
+	s := strconv.Itoa(p.headroomPercent) + "%"
+	if p.headroomBytes > 0 {
+		s = resource.NewQuantity(p.headroomBytes, resource.BinarySI).String()
+	}
+	if p.maxBytes > 0 {
+		s += ":max=" + resource.NewQuantity(p.maxBytes, resource.BinarySI).String()
+	}
+	return s
+}
+
+// headroom returns the free space to keep on a volume of the given capacity.
+func (p *autoGrowPolicy) headroom(capacity int64) int64 {
+	if p.headroomBytes > 0 {
+		return p.headroomBytes
+	}
+	return capacity * int64(p.headroomPercent) / 100
+}
+
+// nextCapacity returns the capacity a volume should grow to, or 0 if no growth is needed.
+// atLimit reports that growth is needed but max has already been reached.
+func (p *autoGrowPolicy) nextCapacity(capacity, used int64) (next int64, atLimit bool) {
+	headroom := p.headroom(capacity)
+	if capacity-used >= headroom {
+		return 0, false
+	}
+	next = roundUpToGiB(capacity + headroom)
+	if p.maxBytes > 0 && next > p.maxBytes {
+		next = p.maxBytes
+	}
+	if next <= capacity {
+		return 0, true
+	}
+	return next, false
+}
+
+// roundUpToGiB rounds a size up to a whole GiB.
+func roundUpToGiB(size int64) int64 {
+	const gib = 1 << 30
+	return (size + gib - 1) / gib * gib
+}
+
+// validateAutoGrowParam validates the autoGrow StorageClass parameter for a protocol.
+func validateAutoGrowParam(params map[string]string, protocol string) (*autoGrowPolicy, error) {
+	value := params[AutoGrowParam]
+	if value == "" {
+		return nil, nil //nolint:nilnil // nil policy means autoGrow is not configured
+	}
+	if p := controllerProtocolFor(nil, protocol); p == nil || p.BlockDevice() {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported for NFS and SMB volumes", AutoGrowParam)
+	}
+	policy, err := parseAutoGrowPolicy(value)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: %v", AutoGrowParam, value, err)
+	}
+	return policy, nil
+}
+
+// recordAutoGrowPolicy stores the autoGrow policy on a newly provisioned volume's dataset.
+func (s *ControllerService) recordAutoGrowPolicy(ctx context.Context, datasetID string, policy *autoGrowPolicy) error {
+	if err := s.apiClient.SetDatasetProperties(ctx, datasetID, map[string]string{tnsapi.PropertyAutoGrow: policy.String()}); err != nil {
+		return status.Errorf(codes.Internal, "Failed to record %s policy on %s: %v", AutoGrowParam, datasetID, err)
+	}
+	return nil
+}
+
+// autoGrow raises the PVC storage request of a volume running out of headroom.
+// atLimit volumes are reported once until they have headroom again.
+func (m *usageMonitor) autoGrow(ctx context.Context, ds *tnsapi.DatasetWithProperties, capacity, used int64) {
+	value := ds.UserProperties[tnsapi.PropertyAutoGrow].Value
+	namespace := ds.UserProperties[tnsapi.PropertyPVCNamespace].Value
+	name := ds.UserProperties[tnsapi.PropertyPVCName].Value
+	if value == "" || namespace == "" || name == "" {
+		return
+	}
+	policy, err := parseAutoGrowPolicy(value)
+	if err != nil {
+		klog.Warningf("Ignoring invalid %s policy %q on %s: %v", AutoGrowParam, value, ds.ID, err)
+		return
+	}
+
+	next, atLimit := policy.nextCapacity(capacity, used)
+	if atLimit {
+		if !m.growLimited[ds.ID] {
+			m.growLimited[ds.ID] = true
+			m.event(ctx, namespace, name, corev1.EventTypeWarning, autoGrowLimitEventReason, fmt.Sprintf(
+				"Volume %s is below its %s headroom but already at the autoGrow maximum of %s",
+				ds.ID, policy, formatUsageBytes(policy.maxBytes)))
+		}
+		return
+	}
+	delete(m.growLimited, ds.ID)
+	if next == 0 {
+		return
+	}
+
+	pvcs := m.kube.CoreV1().PersistentVolumeClaims(namespace)
+	pvc, err := pvcs.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		klog.Warningf("Cannot auto-grow volume %s: failed to get PVC %s/%s: %v", ds.ID, namespace, name, err)
+		return
+	}
+	if pvName := ds.UserProperties[tnsapi.PropertyPVName].Value; pvName != "" && pvc.Spec.VolumeName != pvName {
+		klog.Warningf("Cannot auto-grow volume %s: PVC %s/%s is bound to another volume", ds.ID, namespace, name)
+		return
+	}
+	requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if requested.Value() >= next {
+		// Already requested: waiting for the resizer
+		return
+	}
+
+	if pvc.Spec.Resources.Requests == nil {
+		pvc.Spec.Resources.Requests = corev1.ResourceList{}
+	}
+	pvc.Spec.Resources.Requests[corev1.ResourceStorage] = *resource.NewQuantity(next, resource.BinarySI)
+	if _, err := pvcs.Update(ctx, pvc, metav1.UpdateOptions{}); err != nil {
+		klog.Warningf("Failed to auto-grow PVC %s/%s: %v", namespace, name, err)
+		return
+	}
+
+	message := fmt.Sprintf("Expanding volume %s from %s to %s (%s used, autoGrow %s)",
+		ds.ID, formatUsageBytes(capacity), formatUsageBytes(next), formatUsageBytes(used), policy)
+	klog.Infof("PVC %s/%s: %s", namespace, name, message)
+	m.recorder.Event(pvc, corev1.EventTypeNormal, autoGrowEventReason, message)
+}
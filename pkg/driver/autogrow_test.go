@@ -0,0 +1,162 @@
+package driver
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+const testGiB = int64(1 << 30)
+
+func TestParseAutoGrowPolicy(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "20%", want: "20%"},
+		{value: "20%:max=500Gi", want: "20%:max=500Gi"},
+		{value: "10Gi:max=1Ti", want: "10Gi:max=1Ti"},
+		{value: "0%", wantErr: true},
+		{value: "100%", wantErr: true},
+		{value: "lots", wantErr: true},
+		{value: "20%:min=1Gi", wantErr: true},
+		{value: "20%:max=big", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			policy, err := parseAutoGrowPolicy(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAutoGrowPolicy(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && policy.String() != tt.want {
+				t.Errorf("parseAutoGrowPolicy(%q) = %q, want %q", tt.value, policy.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoGrowPolicyNextCapacity(t *testing.T) {
+	policy, err := parseAutoGrowPolicy("20%:max=15Gi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		capacity    int64
+		used        int64
+		wantNext    int64
+		wantAtLimit bool
+	}{
+		{name: "enough headroom", capacity: 10 * testGiB, used: 7 * testGiB},
+		{name: "grows by headroom", capacity: 10 * testGiB, used: 9 * testGiB, wantNext: 12 * testGiB},
+		{name: "capped at max", capacity: 14 * testGiB, used: 13 * testGiB, wantNext: 15 * testGiB},
+		{name: "at max", capacity: 15 * testGiB, used: 14 * testGiB, wantAtLimit: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, atLimit := policy.nextCapacity(tt.capacity, tt.used)
+			if next != tt.wantNext || atLimit != tt.wantAtLimit {
+				t.Errorf("nextCapacity() = (%d, %v), want (%d, %v)", next, atLimit, tt.wantNext, tt.wantAtLimit)
+			}
+		})
+	}
+}
+
+func TestValidateAutoGrowParam(t *testing.T) {
+	if _, err := validateAutoGrowParam(map[string]string{AutoGrowParam: "20%"}, ProtocolNVMeOF); err == nil {
+		t.Error("autoGrow accepted for NVMe-oF")
+	}
+	if _, err := validateAutoGrowParam(map[string]string{AutoGrowParam: "20"}, ProtocolNFS); err == nil {
+		t.Error("invalid autoGrow accepted")
+	}
+	if policy, err := validateAutoGrowParam(map[string]string{}, ProtocolNFS); err != nil || policy != nil {
+		t.Errorf("validateAutoGrowParam() without autoGrow = %v, %v", policy, err)
+	}
+}
+
+func TestUsageMonitorAutoGrow(t *testing.T) {
+	ctx := context.Background()
+
+	capacity, used := 10*testGiB, 9*testGiB
+	client := &MockAPIClientForSnapshots{
+		FindManagedDatasetsFunc: func(_ context.Context, _ string) ([]tnsapi.DatasetWithProperties, error) {
+			return []tnsapi.DatasetWithProperties{{
+				Dataset: tnsapi.Dataset{
+					ID:   "tank/csi/pvc-logs",
+					Type: datasetTypeFilesystem,
+					Used: map[string]interface{}{"parsed": float64(used)},
+				},
+				UserProperties: map[string]tnsapi.UserProperty{
+					tnsapi.PropertyCapacityBytes: {Value: strconv.FormatInt(capacity, 10)},
+					tnsapi.PropertyAutoGrow:      {Value: "20%:max=12Gi"},
+					tnsapi.PropertyPVCName:       {Value: "logs"},
+					tnsapi.PropertyPVCNamespace:  {Value: "default"},
+					tnsapi.PropertyPVName:        {Value: "pvc-logs"},
+				},
+			}}, nil
+		},
+	}
+	kube := fake.NewClientset(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "logs", Namespace: "default"},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			VolumeName: "pvc-logs",
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+			},
+		},
+	})
+	recorder := record.NewFakeRecorder(10)
+	monitor := &usageMonitor{
+		apiClient:       client,
+		kube:            kube,
+		recorder:        recorder,
+		levels:          make(map[string]int),
+		growLimited:     make(map[string]bool),
+		autoGrowEnabled: true,
+	}
+
+	requested := func() int64 {
+		t.Helper()
+		pvc, err := kube.CoreV1().PersistentVolumeClaims("default").Get(ctx, "logs", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		return q.Value()
+	}
+
+	monitor.check(ctx)
+	if got := requested(); got != 12*testGiB {
+		t.Fatalf("PVC request = %d, want %d", got, 12*testGiB)
+	}
+	if ev := <-recorder.Events; !strings.Contains(ev, autoGrowEventReason) {
+		t.Errorf("event = %q, want %s", ev, autoGrowEventReason)
+	}
+
+	// The resizer has not caught up yet: nothing new to request
+	monitor.check(ctx)
+	if len(recorder.Events) != 0 {
+		t.Errorf("unexpected event while waiting for the resizer: %q", <-recorder.Events)
+	}
+
+	// Expanded to max and still filling up: reported once
+	capacity, used = 12*testGiB, 11*testGiB+testGiB/2
+	monitor.check(ctx)
+	monitor.check(ctx)
+	if len(recorder.Events) != 1 {
+		t.Fatalf("got %d events at the autoGrow maximum, want 1", len(recorder.Events))
+	}
+	if ev := <-recorder.Events; !strings.Contains(ev, autoGrowLimitEventReason) {
+		t.Errorf("event = %q, want %s", ev, autoGrowLimitEventReason)
+	}
+}
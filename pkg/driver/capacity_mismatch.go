@@ -0,0 +1,105 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// Existing volumes of a different size.
+//
+// Volume names are deterministic (pvc-<PVC UUID> with the external-provisioner), so a
+// CreateVolume request for a name that already exists on TrueNAS refers to that volume: a retry,
+// or a volume left behind and now adopted. If the existing volume has a different capacity, the
+// CSI spec requires AlreadyExists; the error names both sizes and the dataset, and says what to
+// do about it. With adoptExistingWithDifferentSize: expand in the StorageClass, an existing volume
+// smaller than the request is instead grown to the requested size, as ControllerExpandVolume would
+// grow it. Volumes larger than the request always fail, since volumes cannot shrink.
+
+// AdoptExistingWithDifferentSizeParam is the StorageClass parameter selecting what CreateVolume
+// does when the volume exists with a different capacity: "fail" (default) or "expand".
+const AdoptExistingWithDifferentSizeParam = "adoptExistingWithDifferentSize"
+
+// Modes of adoptExistingWithDifferentSize.
+const (
+	adoptSizeFail   = "fail"
+	adoptSizeExpand = "expand"
+)
+
+// parseAdoptExistingWithDifferentSize validates adoptExistingWithDifferentSize. An empty value
+// selects "fail".
+func parseAdoptExistingWithDifferentSize(value string) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(value))
+	switch mode {
+	case "":
+		return adoptSizeFail, nil
+	case adoptSizeFail, adoptSizeExpand:
+		return mode, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "invalid %s %q: must be %s or %s",
+			AdoptExistingWithDifferentSizeParam, value, adoptSizeFail, adoptSizeExpand)
+	}
+}
+
+// expandsExistingVolumes reports whether a StorageClass grows existing volumes smaller than the
+// request. The parameter is validated by applyCapacityPolicy.
+func expandsExistingVolumes(params map[string]string) bool {
+	mode, err := parseAdoptExistingWithDifferentSize(params[AdoptExistingWithDifferentSizeParam])
+	return err == nil && mode == adoptSizeExpand
+}
+
+// capacityMismatchError returns the AlreadyExists error of a volume that exists as datasetID with
+// existing bytes when requested bytes were asked for.
+func capacityMismatchError(volumeName, datasetID string, existing, requested int64) error {
+	klog.Warningf("Volume %s already exists as dataset %s with a different capacity (existing: %d bytes, requested: %d bytes)",
+		volumeName, datasetID, existing, requested)
+	hint := "delete or rename the dataset, or request the existing size"
+	if requested > existing {
+		hint += fmt.Sprintf(", or set %s: %s in the StorageClass to grow it", AdoptExistingWithDifferentSizeParam, adoptSizeExpand)
+	}
+	return status.Errorf(codes.AlreadyExists,
+		"Volume %s already exists as dataset %s with a different capacity (existing: %d bytes (%s), requested: %d bytes (%s)). "+
+			"Volume names are derived from the PVC, so the request refers to this volume, e.g. one left behind by a previous cluster: %s",
+		volumeName, datasetID, existing, formatCapacity(existing), requested, formatCapacity(requested), hint)
+}
+
+// formatCapacity formats bytes as a Kubernetes quantity, e.g. "10Gi".
+func formatCapacity(bytes int64) string {
+	return resource.NewQuantity(bytes, resource.BinarySI).String()
+}
+
+// markGrownExisting asks the node to grow the filesystem of a ZVOL grown by
+// adoptExistingWithDifferentSize: expand when it stages the volume.
+func markGrownExisting(volumeContext map[string]string, grown bool) {
+	if grown {
+		volumeContext[VolumeContextKeyNodeExpansionRequired] = VolumeContextValueTrue
+	}
+}
+
+// expandExistingVolume grows the dataset of an existing volume to requested bytes for
+// adoptExistingWithDifferentSize: expand: the volsize of ZVOLs, the refquota of filesystems.
+func (s *ControllerService) expandExistingVolume(ctx context.Context, volumeName, datasetID string, zvol bool, existing, requested int64) error {
+	var params tnsapi.DatasetUpdateParams
+	if zvol {
+		params.Volsize = &requested
+	} else {
+		params.RefQuota = &requested
+	}
+	batch := tnsapi.NewDatasetUpdateBatch(datasetID).
+		SetProperty(tnsapi.PropertyCapacityBytes, strconv.FormatInt(requested, 10))
+	batch.Params = params
+	if err := batch.Apply(ctx, s.apiClient); err != nil {
+		return status.Errorf(codes.Internal, "failed to grow existing volume %s (dataset %s) from %d to %d bytes: %v",
+			volumeName, datasetID, existing, requested, err)
+	}
+	klog.Infof("Grew existing volume %s (dataset %s) from %d to %d bytes (%s: %s)",
+		volumeName, datasetID, existing, requested, AdoptExistingWithDifferentSizeParam, adoptSizeExpand)
+	return nil
+}
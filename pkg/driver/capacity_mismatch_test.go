@@ -0,0 +1,88 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCreateVolumeExistingWithDifferentSizeIntegration(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		block    bool
+	}{
+		{name: "nfs", protocol: ProtocolNFS},
+		{name: "nvmeof", protocol: ProtocolNVMeOF, block: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, _ := newIntegrationController(t)
+			ctx := context.Background()
+
+			capability := &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}
+			if tt.block {
+				capability.AccessType = &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+			}
+			createVolume := func(capacity int64, params map[string]string) (*csi.CreateVolumeResponse, error) {
+				parameters := map[string]string{"protocol": tt.protocol, "pool": "tank", "server": "truenas.local"}
+				for k, v := range params {
+					parameters[k] = v
+				}
+				return controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+					Name:               "pvc-" + tt.name,
+					CapacityRange:      &csi.CapacityRange{RequiredBytes: capacity},
+					VolumeCapabilities: []*csi.VolumeCapability{capability},
+					Parameters:         parameters,
+				})
+			}
+
+			created, err := createVolume(2<<30, nil)
+			if err != nil {
+				t.Fatalf("CreateVolume() error = %v", err)
+			}
+			volumeID := created.GetVolume().GetVolumeId()
+
+			_, err = createVolume(3<<30, nil)
+			if status.Code(err) != codes.AlreadyExists {
+				t.Fatalf("CreateVolume() of a larger size error = %v, want AlreadyExists", err)
+			}
+			for _, want := range []string{volumeID, "2Gi", "3Gi", AdoptExistingWithDifferentSizeParam} {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+
+			expand := map[string]string{AdoptExistingWithDifferentSizeParam: adoptSizeExpand}
+			if _, err := createVolume(1<<30, expand); status.Code(err) != codes.AlreadyExists {
+				t.Errorf("CreateVolume() of a smaller size with expand error = %v, want AlreadyExists", err)
+			}
+
+			grown, err := createVolume(3<<30, expand)
+			if err != nil {
+				t.Fatalf("CreateVolume() with expand error = %v", err)
+			}
+			if got := grown.GetVolume().GetCapacityBytes(); got != 3<<30 {
+				t.Errorf("capacity = %d, want %d", got, 3<<30)
+			}
+			if grown.GetVolume().GetVolumeId() != volumeID {
+				t.Errorf("volume ID = %s, want %s", grown.GetVolume().GetVolumeId(), volumeID)
+			}
+			if tt.block && grown.GetVolume().GetVolumeContext()[VolumeContextKeyNodeExpansionRequired] != VolumeContextValueTrue {
+				t.Error("grown ZVOL is not marked for node expansion")
+			}
+
+			// The grown volume now matches the request, also without expand
+			if _, err := createVolume(3<<30, nil); err != nil {
+				t.Errorf("repeated CreateVolume() error = %v", err)
+			}
+		})
+	}
+}
@@ -5,12 +5,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/dashboard"
 	"github.com/fenio/tns-csi/pkg/tnsapi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -34,24 +37,32 @@ const (
 
 // VolumeContext key constants - these are used consistently across the driver.
 const (
-	VolumeContextKeyProtocol          = "protocol"
-	VolumeContextKeyServer            = "server"
-	VolumeContextKeyShare             = "share"
-	VolumeContextKeyDatasetID         = "datasetID"
-	VolumeContextKeyDatasetName       = "datasetName"
-	VolumeContextKeyNFSShareID        = "nfsShareID"
-	VolumeContextKeyNQN               = "nqn"
-	VolumeContextKeyNVMeOFSubsystemID = "nvmeofSubsystemID"
-	VolumeContextKeyNVMeOFNamespaceID = "nvmeofNamespaceID"
-	VolumeContextKeyNSID              = "nsid"
-	VolumeContextKeyISCSIIQN          = "iscsiIQN"
-	VolumeContextKeyISCSITargetID     = "iscsiTargetID"
-	VolumeContextKeyISCSIExtentID     = "iscsiExtentID"
-	VolumeContextKeySMBShareID        = "smbShareID"
-	VolumeContextKeyExpectedCapacity  = "expectedCapacity"
-	VolumeContextKeyClonedFromSnap    = "clonedFromSnapshot"
-	VolumeContextValueTrue            = "true"
-	VolumeContextValueFalse           = "false"
+	VolumeContextKeyProtocol              = "protocol"
+	VolumeContextKeyServer                = "server"
+	VolumeContextKeyShare                 = "share"
+	VolumeContextKeyDatasetID             = "datasetID"
+	VolumeContextKeyDatasetName           = "datasetName"
+	VolumeContextKeyNFSShareID            = "nfsShareID"
+	VolumeContextKeyNQN                   = "nqn"
+	VolumeContextKeyNVMeOFSubsystemID     = "nvmeofSubsystemID"
+	VolumeContextKeyNVMeOFNamespaceID     = "nvmeofNamespaceID"
+	VolumeContextKeyNSID                  = "nsid"
+	VolumeContextKeyTransport             = "transport"
+	VolumeContextKeyTransportAddress      = "transportAddress"
+	VolumeContextKeyPort                  = "port"
+	VolumeContextKeyISCSIIQN              = "iscsiIQN"
+	VolumeContextKeyISCSITargetID         = "iscsiTargetID"
+	VolumeContextKeyISCSIExtentID         = "iscsiExtentID"
+	VolumeContextKeySMBShareID            = "smbShareID"
+	VolumeContextKeyExpectedCapacity      = "expectedCapacity"
+	VolumeContextKeyClonedFromSnap        = "clonedFromSnapshot"
+	VolumeContextKeyNodeExpansionRequired = "nodeExpansionRequired"
+	VolumeContextKeySharePending          = "sharePending"
+	VolumeContextKeyReadOnly              = "readOnly"
+	VolumeContextKeySubPath               = "subPath"
+	VolumeContextKeyPool                  = "pool"
+	VolumeContextValueTrue                = "true"
+	VolumeContextValueFalse               = "false"
 )
 
 // TrueNAS dataset/zfs type values and kubectl verbs used across the driver package.
@@ -88,8 +99,6 @@ var (
 
 // capacityErrorSubstrings are error message patterns that indicate insufficient pool capacity.
 // TrueNAS returns these when a pool or dataset doesn't have enough free space.
-var errNoDeferredClonesToPromote = errors.New("no deferred-destroy snapshot clones to promote")
-
 var capacityErrorSubstrings = []string{
 	"insufficient space",
 	"out of space",
@@ -115,8 +124,12 @@ func isCapacityError(err error) bool {
 }
 
 // createVolumeError returns an appropriate gRPC status error for volume creation failures.
-// Maps capacity-related errors to ResourceExhausted per CSI spec.
+// Maps capacity-related errors to ResourceExhausted per CSI spec; errors that already carry a
+// code (e.g. Aborted for a concurrent attempt) keep it.
 func createVolumeError(msg string, err error) error {
+	if st, ok := status.FromError(err); ok && st.Code() != codes.Unknown {
+		return status.Errorf(st.Code(), "%s: %s", msg, st.Message())
+	}
 	if isCapacityError(err) {
 		return status.Errorf(codes.ResourceExhausted, "%s: %v", msg, err)
 	}
@@ -148,6 +161,7 @@ type VolumeMetadata struct {
 	ISCSITargetID     int
 	ISCSIExtentID     int
 	SMBShareID        int
+	NonRecursive      bool // Delete the dataset without its children (recursiveDelete=false)
 }
 
 // buildVolumeContext creates a VolumeContext map from VolumeMetadata.
@@ -168,35 +182,8 @@ func buildVolumeContext(meta VolumeMetadata) map[string]string {
 	}
 
 	// Protocol-specific fields
-	switch meta.Protocol {
-	case ProtocolNFS:
-		if meta.NFSShareID != 0 {
-			ctx[VolumeContextKeyNFSShareID] = strconv.Itoa(meta.NFSShareID)
-		}
-	case ProtocolNVMeOF:
-		if meta.NVMeOFNQN != "" {
-			ctx[VolumeContextKeyNQN] = meta.NVMeOFNQN
-		}
-		if meta.NVMeOFSubsystemID != 0 {
-			ctx[VolumeContextKeyNVMeOFSubsystemID] = strconv.Itoa(meta.NVMeOFSubsystemID)
-		}
-		if meta.NVMeOFNamespaceID != 0 {
-			ctx[VolumeContextKeyNVMeOFNamespaceID] = strconv.Itoa(meta.NVMeOFNamespaceID)
-		}
-	case ProtocolISCSI:
-		if meta.ISCSIIQN != "" {
-			ctx[VolumeContextKeyISCSIIQN] = meta.ISCSIIQN
-		}
-		if meta.ISCSITargetID != 0 {
-			ctx[VolumeContextKeyISCSITargetID] = strconv.Itoa(meta.ISCSITargetID)
-		}
-		if meta.ISCSIExtentID != 0 {
-			ctx[VolumeContextKeyISCSIExtentID] = strconv.Itoa(meta.ISCSIExtentID)
-		}
-	case ProtocolSMB:
-		if meta.SMBShareID != 0 {
-			ctx[VolumeContextKeySMBShareID] = strconv.Itoa(meta.SMBShareID)
-		}
+	if protocol := controllerProtocolFor(nil, meta.Protocol); protocol != nil {
+		protocol.BuildContext(&meta, ctx)
 	}
 
 	return ctx
@@ -228,13 +215,93 @@ func getProtocolFromVolumeContext(ctx map[string]string) string {
 // ControllerService implements the CSI Controller service.
 type ControllerService struct {
 	csi.UnimplementedControllerServer
+	csi.UnimplementedGroupControllerServer
 	apiClient    tnsapi.ClientInterface
 	nodeRegistry *NodeRegistry
 	// publishedVolumes tracks volumes published to nodes with their readonly state.
 	// Key format: "volumeID:nodeID", value: readonly state.
 	// Used to detect incompatible re-publish attempts per CSI spec.
-	publishedVolumes   map[string]bool
+	publishedVolumes map[string]bool
+	// metadataCache, when set, records volume metadata in TNSVolume objects so lookups
+	// can skip the storage system (nil = disabled, the default).
+	metadataCache VolumeMetadataCache
+	// deferredShares tracks background NFS share creation for deferShareCreation volumes.
+	deferredShares deferredShareTracker
+	// nodeProtocols, when set, warns about PVCs whose protocol no node can mount (nil = disabled).
+	nodeProtocols *nodeProtocolChecker
+	// snapshotHolds reference-counts the ZFS holds placed on snapshots during clone operations.
+	snapshotHolds snapshotHoldTracker
+	// protectSnapshotClones fails DeleteSnapshot while copy-on-write clones depend on the
+	// snapshot instead of deferring its destruction until they are gone.
+	protectSnapshotClones bool
+	// allowUnmanagedDelete lets DeleteVolume and DeleteSnapshot destroy datasets that are not
+	// marked as managed by this driver and cluster (see checkVolumeDeletable).
+	allowUnmanagedDelete bool
+	// nvmeofNSIDCooldown is how long a subsystem must have been empty before NSID
+	// allocation restarts at 1 (0 = restart as soon as it is empty).
+	nvmeofNSIDCooldown time.Duration
+	// nfsServers maps old NFS server addresses to new ones for new volumes (nil = none).
+	nfsServers *nfsServerMap
+	// tenantDatasets maps PVC namespaces to the parent datasets of their volumes (nil = none).
+	tenantDatasets *tenantDatasetMap
+	// maintenance pauses background deletion and the orphan GC (nil = never in maintenance).
+	maintenance *maintenanceMode
+	// features are the feature gates of --feature-gates (nil = defaults).
+	features FeatureGates
+	// provisionLimit, snapshotLimit and deleteLimit bound concurrent CreateVolume,
+	// CreateSnapshot/DeleteSnapshot and DeleteVolume calls (nil = unlimited).
+	provisionLimit *operationLimiter
+	snapshotLimit  *operationLimiter
+	deleteLimit    *operationLimiter
+	// nfs4ClientExpiry expires the NFSv4 clients of NotReady nodes on TrueNAS when NFS lock
+	// recovery volumes leave them (see nfs_lock_recovery.go).
+	nfs4ClientExpiry bool
+	// atomicCreate creates volume datasets under a staging name and renames them into place
+	// once configured (see controller_staged_create.go).
+	atomicCreate bool
+	// asyncDeleteMinSize is the used space from which volumes are deleted in the background
+	// (0 = always synchronously).
+	asyncDeleteMinSize int64
+	// asyncDeletes tracks the datasets being deleted in the background.
+	asyncDeletes asyncDeleteTracker
+	// hostAccessControl restricts block volumes to the nodes they are published to
+	// (see controller_host_access.go).
+	hostAccessControl bool
+	// kubeView caches Kubernetes storage objects (nil without --kube-informers).
+	kubeView *clusterView
+	// populator provisions PVCs with a DatasetPopulator data source (nil without
+	// --volume-populator-sources, see volume_populator.go).
+	populator *volumePopulator
+	// orphanGCDeleteAfter is how long a volume stays orphaned before the orphan GC deletes it
+	// (0 = report only).
+	orphanGCDeleteAfter time.Duration
+	// markRetainedAdoptable makes the orphan scan mark volumes of Retain PVs adoptable.
+	markRetainedAdoptable bool
+	// orphanSince records when the orphan GC first saw each orphaned volume.
+	orphanSince map[string]time.Time
+	// auditFindings are the findings of the last consistency audit, so only new ones are logged.
+	auditFindings map[dashboard.AuditFinding]bool
+	// defaultVolumeSize is the size of volumes requested without one (0 = 1 GiB).
+	defaultVolumeSize int64
+	// capacityRounding rounds volume capacities up unless the StorageClass sets capacityRounding.
+	capacityRounding string
+	// commentTemplate renders dataset and share comments of StorageClasses without a
+	// commentTemplate (nil = none).
+	commentTemplate *template.Template
+	// driverVersion is reported to comment templates as .DriverVersion.
+	driverVersion string
+	// poolCursor rotates round-robin placement over the pools of a pools StorageClass parameter.
+	poolCursor poolRoundRobin
+	// zfsDefaults are the driver-wide default ZFS properties of new volumes (nil = none).
+	zfsDefaults *zfsDefaults
+	// lookupIP resolves server hostnames of serverResolution: controller volumes
+	// (nil = net.DefaultResolver.LookupIP; replaced in tests).
+	lookupIP lookupIPFunc
+	// removeSubdir removes a directory volume (nil = s.removeSubdirOverNFS; replaced in tests).
+	removeSubdir       func(ctx context.Context, server, exportPath, name string) error
 	clusterID          string
+	timeouts           Timeouts
+	deferredResumeOnce sync.Once
 	publishedVolumesMu sync.RWMutex
 }
 
@@ -245,6 +312,7 @@ func NewControllerService(apiClient tnsapi.ClientInterface, nodeRegistry *NodeRe
 		nodeRegistry:     nodeRegistry,
 		clusterID:        clusterID,
 		publishedVolumes: make(map[string]bool),
+		orphanSince:      make(map[string]time.Time),
 	}
 }
 
@@ -262,13 +330,28 @@ func isDatasetPathVolumeID(volumeID string) bool {
 func (s *ControllerService) lookupVolumeByCSIName(ctx context.Context, poolDatasetPrefix, volumeName string) (*VolumeMetadata, error) {
 	klog.V(4).Infof("Looking up volume by CSI name: %s (prefix: %s)", volumeName, poolDatasetPrefix)
 
-	// New-format volume IDs are the full dataset path — use O(1) direct lookup
+	if meta := s.cachedVolumeMetadata(ctx, volumeName); meta != nil {
+		klog.V(4).Infof("Found volume %s in metadata cache (dataset=%s, protocol=%s)", volumeName, meta.DatasetID, meta.Protocol)
+		return meta, nil
+	}
+
+	var (
+		meta *VolumeMetadata
+		err  error
+	)
 	if isDatasetPathVolumeID(volumeName) {
-		return s.lookupVolumeByDatasetPath(ctx, volumeName)
+		// New-format volume IDs are the full dataset path — use O(1) direct lookup
+		meta, err = s.lookupVolumeByDatasetPath(ctx, volumeName)
+	} else {
+		// Legacy volume IDs are plain names — use O(n) property scan
+		meta, err = s.lookupVolumeByPropertyScan(ctx, poolDatasetPrefix, volumeName)
 	}
 
-	// Legacy volume IDs are plain names — use O(n) property scan
-	return s.lookupVolumeByPropertyScan(ctx, poolDatasetPrefix, volumeName)
+	// Populate the cache for volumes provisioned before it was enabled
+	if err == nil && meta != nil {
+		s.cacheVolumeMetadata(ctx, *meta)
+	}
+	return meta, err
 }
 
 // lookupVolumeByDatasetPath looks up a volume by its full dataset path (O(1) lookup).
@@ -432,7 +515,7 @@ func (s *ControllerService) lookupSnapshotByCSIName(ctx context.Context, poolDat
 // from pool.snapshot.query. The extra.properties list option is silently ignored for snapshots.
 func (s *ControllerService) datasetHasCSIManagedSnapshots(_ context.Context, datasetID string) (bool, error) {
 	// Use background context — parent gRPC context deadline is too short for reliable checks.
-	snapCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	snapCtx, cancel := context.WithTimeout(context.Background(), s.timeouts.provisioning())
 	defer cancel()
 
 	filters := []interface{}{
@@ -474,14 +557,14 @@ func (s *ControllerService) datasetHasCSIManagedSnapshots(_ context.Context, dat
 //   - The original source volume becomes a dependent of the promoted snapshot.
 //   - Without deleting the snapshot first, neither the clone nor the source can be deleted.
 //
-// Uses a 30-second timeout as a safety net — this is best-effort cleanup, not critical path.
+// Uses the provisioning timeout as a safety net — this is best-effort cleanup, not critical path.
 // Skips CSI-managed snapshots (those with tns-csi:managed_by property) to prevent
 // VolSync deadlock — those must be deleted via DeleteSnapshot by their owner.
 func (s *ControllerService) deleteDatasetSnapshots(_ context.Context, datasetID string) {
 	klog.V(4).Infof("Checking for non-CSI snapshots on dataset %s before deletion", datasetID)
 
 	// Use background context — parent gRPC context may have a short deadline
-	snapCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	snapCtx, cancel := context.WithTimeout(context.Background(), s.timeouts.provisioning())
 	defer cancel()
 
 	filters := []interface{}{
@@ -520,69 +603,155 @@ func (s *ControllerService) deleteDatasetSnapshots(_ context.Context, datasetID
 	}
 }
 
-// promoteClonesOfDeferredSnapshots promotes clones of deferred-destroy snapshots on a dataset.
-// When CSI DeleteSnapshot is called with defer=true (because a clone depends on the snapshot),
-// the snapshot remains on the source dataset and blocks deletion. Promoting the clone reverses
-// the dependency, allowing the source dataset to be deleted.
-// Returns true if any clones were promoted (caller should retry deletion).
-func (s *ControllerService) promoteClonesOfDeferredSnapshots(_ context.Context, datasetID string) bool {
-	snapCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// promoteDependentClones promotes the clones that depend on snapshots of a dataset so that
+// they survive its deletion: ZFS refuses to destroy a dataset whose snapshots have clones. This
+// covers COW clones of the temporary snapshots taken for volume-to-volume clones, and clones of
+// snapshots whose CSI DeleteSnapshot was deferred (defer=true) because of the clone. Promotion
+// reverses the dependency: the snapshot moves to the clone and the source dataset becomes
+// deletable. Clones of live CSI snapshots are left alone — those snapshots block DeleteVolume
+// until they are removed via DeleteSnapshot. Returns an error recognized by
+// isDependentClonesError if any clones remain.
+func (s *ControllerService) promoteDependentClones(ctx context.Context, datasetID string) error {
+	snapCtx, cancel := context.WithTimeout(ctx, s.timeouts.provisioning())
 	defer cancel()
 
-	snapshots, err := s.apiClient.QuerySnapshotsWithProperties(snapCtx, []interface{}{ //nolint:contextcheck // intentional: background context needed
+	snapshots, err := s.apiClient.QuerySnapshotsWithProperties(snapCtx, []interface{}{
 		[]interface{}{verbDataset, "=", datasetID},
 	})
 	if err != nil {
-		klog.Warningf("Failed to query snapshots for %s: %v", datasetID, err)
-		return false
+		return fmt.Errorf("failed to check %s for dependent clones: %w", datasetID, err)
 	}
 
-	promoted := false
+	var remaining []string
 	for _, snap := range snapshots {
-		dv, dok := tnsapi.GetSnapshotPropertyValue(snap, "defer_destroy")
-		if !dok || dv != "on" {
-			continue
-		}
 		cloneVal, cok := tnsapi.GetSnapshotPropertyValue(snap, "clones")
 		if !cok || cloneVal == "" {
 			continue
 		}
 		// clones value can be comma-separated for multiple clones
-		for _, clone := range strings.Split(cloneVal, ",") {
+		clones := strings.Split(cloneVal, ",")
+		if _, isCSI := tnsapi.GetSnapshotPropertyValue(snap, tnsapi.PropertySnapshotID); isCSI {
+			if dv, dok := tnsapi.GetSnapshotPropertyValue(snap, "defer_destroy"); !dok || dv != "on" {
+				klog.Infof("Not promoting clones of live CSI snapshot %s", snap.ID)
+				remaining = append(remaining, clones...)
+				continue
+			}
+		}
+		for _, clone := range clones {
 			clone = strings.TrimSpace(clone)
 			if clone == "" {
 				continue
 			}
-			klog.Infof("Promoting clone %s of deferred-destroy snapshot %s", clone, snap.ID)
-			if err := s.apiClient.PromoteDataset(snapCtx, clone); err != nil { //nolint:contextcheck // intentional
+			klog.Infof("Promoting clone %s of snapshot %s before deleting %s", clone, snap.ID, datasetID)
+			if err := s.apiClient.PromoteDataset(snapCtx, clone); err != nil {
 				klog.Warningf("Failed to promote clone %s: %v", clone, err)
-			} else {
-				promoted = true
+				remaining = append(remaining, clone)
+				continue
 			}
+			s.markClonePromoted(snapCtx, clone)
 		}
 	}
-	return promoted
+	if len(remaining) > 0 {
+		return fmt.Errorf("dataset %s has dependent clones that could not be promoted: %s", datasetID, strings.Join(remaining, ", "))
+	}
+	return nil
 }
 
-// tryPromoteAndDeleteDataset handles the dependent-clones case during dataset deletion.
-// When DeleteDataset fails with "dependent clones", this tries promoting clones of
-// deferred-destroy snapshots (CSI-deleted but still present due to clone dependency),
-// then retries deletion. Returns nil on success, or the original error if unresolvable.
-func (s *ControllerService) tryPromoteAndDeleteDataset(ctx context.Context, datasetID string) error {
-	if s.promoteClonesOfDeferredSnapshots(ctx, datasetID) {
-		retryErr := s.apiClient.DeleteDataset(ctx, datasetID)
-		if retryErr == nil || isNotFoundError(retryErr) {
-			klog.Infof("Dataset %s deleted after promoting deferred snapshot clones", datasetID)
-			return nil
-		}
-		klog.Warningf("Dataset %s still has dependent clones after promotion: %v", datasetID, retryErr)
-		return retryErr
+// markClonePromoted updates the clone tracking properties of a CSI volume that was promoted,
+// so tooling no longer reports it as depending on its origin snapshot.
+func (s *ControllerService) markClonePromoted(ctx context.Context, datasetID string) {
+	props, err := s.apiClient.GetDatasetProperties(ctx, datasetID, []string{tnsapi.PropertyCloneMode})
+	if err != nil || props[tnsapi.PropertyCloneMode] != tnsapi.CloneModeCOW {
+		return
+	}
+	if err := s.apiClient.SetDatasetProperties(ctx, datasetID, map[string]string{tnsapi.PropertyCloneMode: tnsapi.CloneModePromoted}); err != nil {
+		klog.Warningf("Failed to record promotion of clone %s: %v", datasetID, err)
+		return
+	}
+	if err := s.apiClient.ClearDatasetProperties(ctx, datasetID, []string{tnsapi.PropertyOriginSnapshot}); err != nil {
+		klog.Warningf("Failed to clear origin snapshot of promoted clone %s: %v", datasetID, err)
 	}
-	return errNoDeferredClonesToPromote
 }
 
 // CreateVolume creates a new volume.
 func (s *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	release, err := s.provisionLimit.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// nfs.version and vers= mount options: the NFS service must have the protocol enabled
+	versionContext, err := s.nfsVersionVolumeContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	// nfs.security: Kerberos must be usable on TrueNAS before anything is provisioned
+	krbContext, err := s.nfsKerberosVolumeContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	lockContext, err := nfsLockRecoveryVolumeContext(req)
+	if err != nil {
+		return nil, err
+	}
+	ownershipContext, err := volumeOwnershipVolumeContext(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.createVolume(ctx, req)
+	if err == nil && resp.GetVolume() != nil && req.GetParameters()[AutoGrowParam] != "" {
+		// Validated in createVolume; recorded here so idempotent retries repair a failed write
+		policy, _ := parseAutoGrowPolicy(req.GetParameters()[AutoGrowParam])
+		if recErr := s.recordAutoGrowPolicy(ctx, resp.GetVolume().GetVolumeId(), policy); recErr != nil {
+			return nil, recErr
+		}
+	}
+	if err == nil && resp.GetVolume() != nil && (req.GetParameters()[MaxSizeParam] != "" || req.GetParameters()[CapacityRoundingParam] != "") {
+		if _, isSubdir := parseSubdirVolumeID(resp.GetVolume().GetVolumeId()); !isSubdir {
+			if recErr := s.recordSizePolicy(ctx, resp.GetVolume().GetVolumeId(), req.GetParameters()); recErr != nil {
+				return nil, recErr
+			}
+		}
+	}
+	if err == nil && resp.GetVolume() != nil && req.GetParameters()[RecursiveDeleteParam] != "" {
+		if _, isSubdir := parseSubdirVolumeID(resp.GetVolume().GetVolumeId()); !isSubdir {
+			if recErr := s.recordRecursiveDelete(ctx, resp.GetVolume().GetVolumeId(), req.GetParameters()); recErr != nil {
+				return nil, recErr
+			}
+		}
+	}
+	if err == nil && resp.GetVolume() != nil && req.GetParameters()[PoolsParam] != "" {
+		resp.Volume.VolumeContext[VolumeContextKeyPool] = placedPool(resp.GetVolume())
+	}
+	if err == nil && resp.GetVolume() != nil && isReadOnlyContentSourceRequest(req) {
+		// Also covers idempotent retries that return an existing clone
+		resp.Volume.VolumeContext[VolumeContextKeyReadOnly] = VolumeContextValueTrue
+	}
+	if err == nil && resp.GetVolume() != nil {
+		for key, value := range krbContext {
+			resp.Volume.VolumeContext[key] = value
+		}
+		for key, value := range versionContext {
+			resp.Volume.VolumeContext[key] = value
+		}
+		for key, value := range lockContext {
+			resp.Volume.VolumeContext[key] = value
+		}
+		for key, value := range ownershipContext {
+			resp.Volume.VolumeContext[key] = value
+		}
+		stampVolumeContext(resp.Volume.VolumeContext)
+	}
+	if err == nil && resp.GetVolume() != nil {
+		s.cacheVolumeMetadata(ctx, volumeMetadataFromContext(resp.GetVolume().GetVolumeId(), resp.GetVolume().GetVolumeContext()))
+	}
+	return resp, err
+}
+
+// createVolume implements CreateVolume; the wrapper records the result in the metadata cache.
+func (s *ControllerService) createVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	// Log at Info level (not V(4)) so we can see when CreateVolume is called in CI
 	klog.Infof("=== CreateVolume CALLED === Name: %s", req.GetName())
 	if req.GetVolumeContentSource() != nil {
@@ -593,7 +762,6 @@ func (s *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 			klog.Infof("CreateVolume from Volume: VolumeId=%s", vol.GetVolumeId())
 		}
 	}
-	klog.V(4).Infof("CreateVolume called with request: %+v", req)
 
 	// Log detailed debug info for troubleshooting
 	s.logCreateVolumeDebugInfo(req)
@@ -620,6 +788,59 @@ func (s *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 		return nil, err
 	}
 
+	if _, err := validateAutoGrowParam(params, protocol); err != nil {
+		return nil, err
+	}
+	if _, err := parseRecursiveDelete(params); err != nil {
+		return nil, err
+	}
+
+	// Every provisioning path below reads the capacity from the adjusted request
+	req, err := s.applyCapacityPolicy(req, protocol)
+	if err != nil {
+		return nil, err
+	}
+	// Namespaces listed in --tenant-dataset-map-file provision below their own dataset
+	req, err = s.applyTenantDataset(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	// StorageClasses listing several pools provision on the one chosen here
+	req, err = s.applyPoolPlacement(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	// Volumes of a volume group are provisioned in the group's shared dataset
+	req, err = s.applyVolumeGroup(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	// serverResolution: controller pins the volume to the addresses server resolves to now
+	req, err = s.applyServerPinning(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if placed := req.GetParameters(); placed != nil {
+		params = placed
+	}
+	if _, err := validateSizeParams(req); err != nil {
+		return nil, err
+	}
+
+	if s.nodeProtocols != nil {
+		s.nodeProtocols.check(ctx, protocol, params)
+	}
+
+	// Directory volumes have no dataset: none of the dataset-based checks below apply
+	if params[VolumeTypeParam] != "" {
+		return s.createSubdirVolume(ctx, req, protocol)
+	}
+
+	// Reject templated names that would land on another PVC's dataset
+	if err := s.checkVolumeNameCollision(ctx, req, params); err != nil {
+		return nil, err
+	}
+
 	// Check for idempotency: if volume with same name already exists
 	existingVolume, err := s.checkExistingVolume(ctx, req, params, protocol)
 	if err != nil && !errors.Is(err, ErrVolumeNotFound) {
@@ -711,7 +932,7 @@ func validateAccessModeForProtocol(caps []*csi.VolumeCapability, protocol string
 			continue
 		}
 		// Multi-node requested — block protocols only allow raw block mode
-		if protocol == ProtocolNVMeOF || protocol == ProtocolISCSI {
+		if isBlockProtocol(protocol) {
 			if cap.GetMount() != nil {
 				return status.Errorf(codes.InvalidArgument,
 					"multi-node access mode %s with mounted filesystem is not supported for %s — "+
@@ -723,7 +944,7 @@ func validateAccessModeForProtocol(caps []*csi.VolumeCapability, protocol string
 	return nil
 }
 
-// handleVolumeContentSource handles creating volumes from snapshots or clones.
+// handleVolumeContentSource handles creating volumes from snapshots, clones or a DatasetPopulator.
 // Returns (response, true, nil) if handled successfully, (nil, true, error) if handled with error,
 // or (nil, false, nil) if not a content source request.
 func (s *ControllerService) handleVolumeContentSource(ctx context.Context, req *csi.CreateVolumeRequest, protocol string) (*csi.CreateVolumeResponse, bool, error) {
@@ -731,6 +952,19 @@ func (s *ControllerService) handleVolumeContentSource(ctx context.Context, req *
 	klog.V(4).Infof("Checking VolumeContentSource for volume %s: %+v", req.GetName(), contentSource)
 
 	if contentSource == nil {
+		// No CSI data source: prime PVCs of the volume populator are prepopulated from their dataset
+		source, err := s.populator.sourceFor(ctx, req)
+		if err != nil {
+			return nil, true, err
+		}
+		if source != nil {
+			resp, err := s.createVolumeFromPopulator(ctx, req, source, protocol)
+			if err != nil {
+				klog.Errorf("Failed to populate volume %s: %v", req.GetName(), err)
+				return nil, true, err
+			}
+			return resp, true, nil
+		}
 		klog.V(4).Infof("VolumeContentSource is nil for volume %s (normal volume creation)", req.GetName())
 		return nil, false, nil
 	}
@@ -768,18 +1002,65 @@ func (s *ControllerService) handleVolumeContentSource(ctx context.Context, req *
 
 // createVolumeByProtocol creates a volume using the specified protocol.
 func (s *ControllerService) createVolumeByProtocol(ctx context.Context, req *csi.CreateVolumeRequest, protocol string) (*csi.CreateVolumeResponse, error) {
-	switch protocol {
-	case ProtocolNFS:
-		return s.createNFSVolume(ctx, req)
-	case ProtocolNVMeOF:
-		return s.createNVMeOFVolume(ctx, req)
-	case ProtocolISCSI:
-		return s.createISCSIVolume(ctx, req)
-	case ProtocolSMB:
-		return s.createSMBVolume(ctx, req)
-	default:
+	handler := controllerProtocolFor(s, protocol)
+	if handler == nil {
 		return nil, status.Errorf(codes.InvalidArgument, "Unsupported protocol: %s (supported: nfs, nvmeof, iscsi, smb)", protocol)
 	}
+	return handler.Provision(ctx, req)
+}
+
+// checkVolumeNameCollision validates the resolved dataset name against ZFS limits and, when a name
+// template is configured, makes sure an existing dataset with that name belongs to the same PVC.
+// PV names are unique cluster-wide, but a template (e.g. "{{ .PVCName }}" without the namespace)
+// can render the same name for two different PVCs.
+func (s *ControllerService) checkVolumeNameCollision(ctx context.Context, req *csi.CreateVolumeRequest, params map[string]string) error {
+	parentDataset := params["parentDataset"]
+	if parentDataset == "" {
+		parentDataset = params["pool"]
+	}
+	if parentDataset == "" {
+		// Missing pool/parentDataset is reported by the protocol-specific validation
+		return nil
+	}
+
+	volumeName, err := ResolveVolumeName(params, req.GetName())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "Failed to resolve volume name: %v", err)
+	}
+
+	datasetName := fmt.Sprintf("%s/%s", parentDataset, volumeName)
+	if err := validateDatasetNameLength(datasetName); err != nil {
+		return status.Errorf(codes.InvalidArgument, "Invalid volume name: %v", err)
+	}
+
+	if volumeName == req.GetName() {
+		return nil
+	}
+
+	existing, err := s.apiClient.GetDatasetWithProperties(ctx, datasetName)
+	if err != nil || existing == nil {
+		// Nothing there yet (or not queryable) - creation will surface real errors
+		return nil //nolint:nilerr // lookup failure means no collision to report
+	}
+
+	props := existing.UserProperties
+	if managedBy, ok := props[tnsapi.PropertyManagedBy]; !ok || managedBy.Value != tnsapi.ManagedByValue {
+		return status.Errorf(codes.AlreadyExists,
+			"Volume name %q rendered from template collides with dataset %s, which is not managed by tns-csi", volumeName, datasetName)
+	}
+
+	reqPVCName := params[CSIPVCName]
+	reqPVCNamespace := params[CSIPVCNamespace]
+	ownerName := props[tnsapi.PropertyPVCName].Value
+	ownerNamespace := props[tnsapi.PropertyPVCNamespace].Value
+	if ownerName != "" && (ownerName != reqPVCName || ownerNamespace != reqPVCNamespace) {
+		return status.Errorf(codes.AlreadyExists,
+			"Volume name %q rendered from template is already used by PVC %s/%s (dataset %s); include more tokens in %s to make names unique",
+			volumeName, ownerNamespace, ownerName, datasetName, ParamVolumeNameTemplate)
+	}
+
+	klog.V(4).Infof("Dataset %s already exists for PVC %s/%s - reusing it", datasetName, reqPVCNamespace, reqPVCName)
+	return nil
 }
 
 // checkExistingVolume checks if a volume with the same name already exists and returns it for idempotency.
@@ -795,7 +1076,12 @@ func (s *ControllerService) checkExistingVolume(ctx context.Context, req *csi.Cr
 		return nil, ErrVolumeNotFound
 	}
 
-	expectedDatasetName := fmt.Sprintf("%s/%s", parentDataset, req.GetName())
+	volumeName, err := ResolveVolumeName(params, req.GetName())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Failed to resolve volume name: %v", err)
+	}
+
+	expectedDatasetName := fmt.Sprintf("%s/%s", parentDataset, volumeName)
 	existingDataset, err := s.apiClient.Dataset(ctx, expectedDatasetName)
 	if err != nil || existingDataset == nil {
 		// Dataset doesn't exist or error querying - continue with creation
@@ -808,43 +1094,23 @@ func (s *ControllerService) checkExistingVolume(ctx context.Context, req *csi.Cr
 	// Volume already exists - check capacity compatibility
 	klog.V(4).Infof("Volume %s already exists as dataset %s", req.GetName(), expectedDatasetName)
 
-	reqCapacity := req.GetCapacityRange().GetRequiredBytes()
-	if reqCapacity == 0 {
-		reqCapacity = 1 * 1024 * 1024 * 1024 // 1 GiB default
-	}
-
-	// Build complete volume metadata based on protocol
-	var volumeMeta VolumeMetadata
-	var volumeContext map[string]string
+	capacity := requestedVolumeCapacity(req)
 
-	switch protocol {
-	case ProtocolNFS:
-		meta, ctx, err := s.checkExistingNFSVolume(ctx, req, params, existingDataset, expectedDatasetName, reqCapacity)
-		if err != nil {
-			return nil, err
-		}
-		volumeMeta = meta
-		volumeContext = ctx
-
-	case ProtocolNVMeOF, ProtocolISCSI, ProtocolSMB:
-		// Defer to protocol-specific handler which validates all resources
-		// (subsystem/target/share exist + capacity match + builds complete VolumeContext)
-		return nil, ErrVolumeNotFound
-
-	default:
+	// Build complete volume metadata based on protocol. Protocols that must also validate
+	// their targets or shares return ErrVolumeNotFound and handle the volume in Provision.
+	handler := controllerProtocolFor(s, protocol)
+	if handler == nil {
 		klog.Errorf("Unknown protocol: %s", protocol)
 		return nil, ErrVolumeNotFound
 	}
+	volumeMeta, volumeContext, err := handler.FindExisting(ctx, req, params, existingDataset, expectedDatasetName, capacity)
+	if err != nil {
+		return nil, err
+	}
 
 	// Volume ID is the full dataset path for O(1) lookups
 	volumeID := expectedDatasetName
 
-	// Return capacity from request if specified, otherwise use a default
-	capacity := reqCapacity
-	if capacity <= 0 {
-		capacity = 1 * 1024 * 1024 * 1024 // 1 GiB default
-	}
-
 	// Ensure volume context includes protocol
 	if volumeContext == nil {
 		volumeContext = buildVolumeContext(volumeMeta)
@@ -862,52 +1128,6 @@ func (s *ControllerService) checkExistingVolume(ctx context.Context, req *csi.Cr
 	}, nil
 }
 
-// checkExistingNFSVolume validates an existing NFS volume for idempotency.
-func (s *ControllerService) checkExistingNFSVolume(ctx context.Context, req *csi.CreateVolumeRequest, params map[string]string, existingDataset *tnsapi.Dataset, expectedDatasetName string, reqCapacity int64) (VolumeMetadata, map[string]string, error) {
-	// Query for NFS share to get share ID
-	shares, err := s.apiClient.QueryNFSShare(ctx, existingDataset.Mountpoint)
-	if err != nil {
-		klog.Errorf("Failed to query NFS shares for existing volume: %v", err)
-		return VolumeMetadata{}, nil, ErrVolumeNotFound
-	}
-
-	if len(shares) == 0 {
-		klog.Errorf("No NFS share found for dataset %s (mountpoint: %s)", expectedDatasetName, existingDataset.Mountpoint)
-		return VolumeMetadata{}, nil, ErrVolumeNotFound
-	}
-
-	// Parse capacity from NFS share comment and validate compatibility
-	existingCapacity := parseNFSShareCapacity(shares[0].Comment)
-	if err := validateCapacityCompatibility(req.GetName(), existingCapacity, reqCapacity); err != nil {
-		return VolumeMetadata{}, nil, err
-	}
-
-	// Get server parameter
-	server := params["server"]
-	if server == "" {
-		server = "defaultServerAddress" // Default for testing
-	}
-
-	volumeMeta := VolumeMetadata{
-		Name:        req.GetName(),
-		Protocol:    ProtocolNFS,
-		DatasetID:   existingDataset.ID,
-		DatasetName: expectedDatasetName,
-		Server:      server,
-		NFSShareID:  shares[0].ID,
-	}
-
-	volumeContext := map[string]string{
-		VolumeContextKeyServer: server,
-		"share":                existingDataset.Mountpoint,
-		"datasetID":            existingDataset.ID,
-		"datasetName":          expectedDatasetName,
-		"nfsShareID":           strconv.Itoa(shares[0].ID),
-	}
-
-	return volumeMeta, volumeContext, nil
-}
-
 // parseNFSShareCapacity extracts capacity from NFS share comment.
 // Supports multiple formats:
 // - "CSI Volume: <name>, Capacity: <bytes>"
@@ -927,9 +1147,11 @@ func parseNFSShareCapacity(comment string) int64 {
 		return 0
 	}
 
-	parsed, err := strconv.ParseInt(parts[1], 10, 64)
+	// Newer comments carry PVC details after the capacity ("... | Capacity: 1073741824 | PVC: ns/name")
+	capacityStr, _, _ := strings.Cut(parts[1], " | ")
+	parsed, err := strconv.ParseInt(capacityStr, 10, 64)
 	if err != nil {
-		klog.V(4).Infof("Could not parse capacity number: %s (error: %v)", parts[1], err)
+		klog.V(4).Infof("Could not parse capacity number: %s (error: %v)", capacityStr, err)
 		return 0
 	}
 
@@ -937,21 +1159,77 @@ func parseNFSShareCapacity(comment string) int64 {
 	return parsed
 }
 
+// withPVCComment appends the PVC identity to a share comment so the claim is visible in the TrueNAS UI.
+// It is appended after the capacity, which parseNFSShareCapacity expects right after "Capacity: ".
+func withPVCComment(comment, pvcNamespace, pvcName, pvName string) string {
+	if pvcName == "" {
+		return comment
+	}
+	comment += fmt.Sprintf(" | PVC: %s/%s", pvcNamespace, pvcName)
+	if pvName != "" {
+		comment += " | PV: " + pvName
+	}
+	return comment
+}
+
 // validateCapacityCompatibility checks if the requested capacity matches the existing capacity.
-func validateCapacityCompatibility(volumeName string, existingCapacity, reqCapacity int64) error {
+func validateCapacityCompatibility(volumeName, datasetID string, existingCapacity, reqCapacity int64) error {
 	klog.V(4).Infof("Validating capacity - existing: %d, requested: %d", existingCapacity, reqCapacity)
 
 	if existingCapacity > 0 && reqCapacity != existingCapacity {
-		klog.Errorf("Volume %s already exists with different capacity (existing: %d, requested: %d)",
-			volumeName, existingCapacity, reqCapacity)
-		return status.Errorf(codes.AlreadyExists,
-			"Volume %s already exists with different capacity", volumeName)
+		return capacityMismatchError(volumeName, datasetID, existingCapacity, reqCapacity)
 	}
 
 	klog.V(4).Infof("Capacity check passed (existing: %d, requested: %d)", existingCapacity, reqCapacity)
 	return nil
 }
 
+// maxZvolSizeRounding is how far ZFS may round a ZVOL's volsize up from the requested size
+// (to a multiple of volblocksize, at most 16M) before an existing ZVOL no longer counts as
+// the requested volume.
+const maxZvolSizeRounding = 16 * 1024 * 1024
+
+// zvolCapacityMatches reports whether an existing ZVOL of existing bytes satisfies a request
+// for requested bytes, allowing for volblocksize rounding.
+func zvolCapacityMatches(existing, requested int64) bool {
+	return existing >= requested && existing-requested < maxZvolSizeRounding
+}
+
+// datasetCapacity returns the provisioned size of a dataset as TrueNAS reports it: volsize
+// for ZVOLs, refquota for filesystems. Returns 0 if the dataset does not carry it.
+func datasetCapacity(dataset *tnsapi.Dataset) int64 {
+	if dataset == nil {
+		return 0
+	}
+	if dataset.Type == datasetTypeVolume {
+		return getZvolCapacity(dataset)
+	}
+	return parsedPropertyInt(dataset.RefQuota)
+}
+
+// provisionedCapacity returns the size to report for a newly created volume. ZFS rounds
+// volsize up to whole volblocksize blocks and quotas may differ from the request, so the
+// size is read back from the dataset (re-querying it if the create response lacked it).
+// Falls back to requested if the size cannot be determined.
+func (s *ControllerService) provisionedCapacity(ctx context.Context, dataset *tnsapi.Dataset, requested int64) int64 {
+	capacity := datasetCapacity(dataset)
+	if capacity == 0 && dataset != nil && dataset.ID != "" {
+		current, err := s.apiClient.GetDatasetWithProperties(ctx, dataset.ID)
+		if err != nil {
+			klog.V(4).Infof("Could not read provisioned size of %s: %v (reporting requested size)", dataset.ID, err)
+		} else if current != nil {
+			capacity = datasetCapacity(&current.Dataset)
+		}
+	}
+	if capacity <= 0 {
+		return requested
+	}
+	if capacity != requested {
+		klog.Infof("Dataset %s provisioned with %d bytes (requested %d bytes)", dataset.ID, capacity, requested)
+	}
+	return capacity
+}
+
 // createVolumeFromVolume creates a new volume by cloning an existing volume.
 // This is done by creating a temporary snapshot and cloning from it.
 //
@@ -1060,9 +1338,13 @@ func (s *ControllerService) createVolumeFromVolume(ctx context.Context, req *csi
 	// - Default (COW clone): Keep snapshot - clone depends on it
 	// - Promoted: Delete snapshot - dependency was reversed, snapshot depends on clone
 	// - Detached: Delete snapshot - no dependency exists (full data copy)
-	if promotedMode || detachedMode {
+	// - Cross-pool (restoreParentDataset on another pool): Delete snapshot - copied with send/receive
+	sourcePool, _, _ := strings.Cut(sourceDatasetName, "/")
+	clonePool, _, _ := strings.Cut(resp.GetVolume().GetVolumeId(), "/")
+	crossPool := isDatasetPathVolumeID(resp.GetVolume().GetVolumeId()) && clonePool != sourcePool
+	if promotedMode || detachedMode || crossPool {
 		modeDesc := "promoted"
-		if detachedMode {
+		if detachedMode || crossPool {
 			modeDesc = "detached"
 		}
 		klog.V(4).Infof("Deleting temporary snapshot %s (%s mode - no clone dependency)", snapshot.ID, modeDesc)
@@ -1082,15 +1364,27 @@ func (s *ControllerService) createVolumeFromVolume(ctx context.Context, req *csi
 
 // DeleteVolume deletes a volume.
 func (s *ControllerService) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
-	klog.V(4).Infof("DeleteVolume called with request: %+v", req)
-
 	if req.GetVolumeId() == "" {
 		return nil, status.Error(codes.InvalidArgument, errMsgVolumeIDRequired)
 	}
 
+	release, err := s.deleteLimit.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	volumeID := req.GetVolumeId()
 	klog.V(4).Infof("Deleting volume %s", volumeID)
 
+	// Stop any background share creation first so the lookup below sees its final state
+	// (a share created by the job is recorded on the dataset and deleted with the volume)
+	s.deferredShares.cancelAndWait(ctx, volumeID)
+
+	if volume, ok := parseSubdirVolumeID(volumeID); ok {
+		return s.deleteSubdirVolume(ctx, volume)
+	}
+
 	// Try property-based lookup first (preferred method - uses ZFS properties as source of truth)
 	// Pass empty prefix to search all datasets across all pools
 	volumeMeta, err := s.lookupVolumeByCSIName(ctx, "", volumeID)
@@ -1106,24 +1400,69 @@ func (s *ControllerService) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 	}
 
 	klog.V(4).Infof("Found volume %s via property lookup: dataset=%s, protocol=%s", volumeID, volumeMeta.DatasetID, volumeMeta.Protocol)
-	switch volumeMeta.Protocol {
-	case ProtocolNFS:
-		return s.deleteNFSVolume(ctx, volumeMeta)
-	case ProtocolNVMeOF:
-		return s.deleteNVMeOFVolume(ctx, volumeMeta)
-	case ProtocolISCSI:
-		return s.deleteISCSIVolume(ctx, volumeMeta)
-	case ProtocolSMB:
-		return s.deleteSMBVolume(ctx, volumeMeta)
-	default:
+	handler := controllerProtocolFor(s, volumeMeta.Protocol)
+	if handler == nil {
 		return nil, status.Errorf(codes.Internal, "Unknown protocol %s for volume %s", volumeMeta.Protocol, volumeID)
 	}
+	if err := s.checkVolumeDeletable(ctx, volumeMeta); err != nil {
+		return nil, err
+	}
+	sourceSnapshot := s.volumeSourceSnapshot(ctx, volumeMeta.DatasetID)
+	resp, err := handler.Teardown(ctx, volumeMeta)
+	// Evict even on failure: retries then re-read the storage system instead of trusting a possibly stale entry
+	s.evictVolumeMetadata(ctx, volumeID)
+	if err == nil && strings.Contains(volumeMeta.DatasetID, "/") {
+		s.removeEmptyVolumeGroup(ctx, path.Dir(volumeMeta.DatasetID))
+	}
+	if err == nil && sourceSnapshot != "" {
+		s.deleteVolumeSourceSnapshot(ctx, sourceSnapshot)
+	}
+	return resp, err
 }
 
-// ControllerPublishVolume attaches a volume to a node.
-func (s *ControllerService) ControllerPublishVolume(_ context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
-	klog.V(4).Infof("ControllerPublishVolume called with request: %+v", req)
+// volumeSourceSnapshot returns the temporary snapshot a COW clone of a volume or populator
+// source was created from (volume-source-for-volume-<name>), or "" if the volume has none.
+// Only the clone uses it, so it goes with the volume; sources that are never deleted, like
+// populator datasets, would otherwise collect one snapshot per volume ever populated.
+func (s *ControllerService) volumeSourceSnapshot(ctx context.Context, datasetID string) string {
+	props, err := s.apiClient.GetDatasetProperties(ctx, datasetID, []string{tnsapi.PropertyOriginSnapshot})
+	if err != nil {
+		klog.V(4).Infof("Failed to read origin snapshot of %s: %v", datasetID, err)
+		return ""
+	}
+	origin := props[tnsapi.PropertyOriginSnapshot]
+	if _, name, ok := strings.Cut(origin, "@"); !ok || !strings.HasPrefix(name, VolumeSourceSnapshotPrefix) {
+		return ""
+	}
+	return origin
+}
+
+// deleteVolumeSourceSnapshot deletes the source snapshot of a deleted clone. The deletion is
+// deferred by ZFS while a background deletion of the clone is still running.
+func (s *ControllerService) deleteVolumeSourceSnapshot(ctx context.Context, snapshotID string) {
+	if err := s.apiClient.DeleteSnapshot(ctx, snapshotID); err != nil && !isNotFoundError(err) {
+		klog.Warningf("Failed to delete source snapshot %s of deleted clone: %v (non-fatal)", snapshotID, err)
+		return
+	}
+	klog.Infof("Deleted source snapshot %s of deleted clone", snapshotID)
+}
 
+// nodeExists reports whether a CSI node exists; known is false when that cannot be told. Node
+// plugins register in their own pods, so the Node cache (--kube-informers) is the source of truth;
+// without it only node plugins of this process (single-process deployments, sanity tests) are
+// known from their NodeGetInfo registration.
+func (s *ControllerService) nodeExists(nodeID string) (exists, known bool) {
+	if node, known := s.kubeView.node(nodeID); known {
+		return node != nil, true
+	}
+	if s.nodeRegistry != nil && s.nodeRegistry.Count() > 0 {
+		return s.nodeRegistry.IsRegistered(nodeID), true
+	}
+	return false, false
+}
+
+// ControllerPublishVolume attaches a volume to a node.
+func (s *ControllerService) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
 	// Validate required parameters per CSI spec
 	if req.GetVolumeId() == "" {
 		return nil, status.Error(codes.InvalidArgument, errMsgVolumeIDRequired)
@@ -1141,9 +1480,23 @@ func (s *ControllerService) ControllerPublishVolume(_ context.Context, req *csi.
 		return nil, status.Error(codes.InvalidArgument, "Volume capability is required")
 	}
 
-	// Validate node exists in registry
-	// Per CSI spec: return NotFound if node doesn't exist
-	if s.nodeRegistry != nil && !s.nodeRegistry.IsRegistered(nodeID) {
+	// Per CSI spec: return NotFound if the volume or the node doesn't exist
+	var meta *VolumeMetadata
+	if volume, ok := parseSubdirVolumeID(volumeID); ok {
+		if _, err := s.getSubdirVolume(ctx, volumeID, volume); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		meta, err = s.lookupVolumeByCSIName(ctx, "", volumeID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to lookup volume: %v", err)
+		}
+		if meta == nil {
+			return nil, status.Errorf(codes.NotFound, "Volume %s not found", volumeID)
+		}
+	}
+	if exists, known := s.nodeExists(nodeID); known && !exists {
 		return nil, status.Errorf(codes.NotFound, "node %s not found", nodeID)
 	}
 
@@ -1165,7 +1518,15 @@ func (s *ControllerService) ControllerPublishVolume(_ context.Context, req *csi.
 			volumeID, nodeID, readonly)
 		return &csi.ControllerPublishVolumeResponse{}, nil
 	}
+	s.publishedVolumesMu.Unlock()
+
+	// --host-access-control: allow the node's host NQN or initiator IQN on the volume
+	if err := s.grantHostAccess(ctx, meta, nodeID); err != nil {
+		return nil, err
+	}
+
 	// Track this publish
+	s.publishedVolumesMu.Lock()
 	s.publishedVolumes[publishKey] = readonly
 	s.publishedVolumesMu.Unlock()
 
@@ -1176,9 +1537,7 @@ func (s *ControllerService) ControllerPublishVolume(_ context.Context, req *csi.
 }
 
 // ControllerUnpublishVolume detaches a volume from a node.
-func (s *ControllerService) ControllerUnpublishVolume(_ context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
-	klog.V(4).Infof("ControllerUnpublishVolume called with request: %+v", req)
-
+func (s *ControllerService) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
 	// Validate required parameters per CSI spec
 	if req.GetVolumeId() == "" {
 		return nil, status.Error(codes.InvalidArgument, errMsgVolumeIDRequired)
@@ -1189,11 +1548,24 @@ func (s *ControllerService) ControllerUnpublishVolume(_ context.Context, req *cs
 
 	// Remove from published volumes tracking
 	if nodeID != "" {
+		if _, subdir := parseSubdirVolumeID(volumeID); s.hostAccessControl && !subdir {
+			meta, err := s.lookupVolumeByCSIName(ctx, "", volumeID)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "Failed to lookup volume: %v", err)
+			}
+			if err := s.revokeHostAccess(ctx, meta, nodeID); err != nil {
+				return nil, err
+			}
+		}
+
 		publishKey := fmt.Sprintf("%s:%s", volumeID, nodeID)
 		s.publishedVolumesMu.Lock()
 		delete(s.publishedVolumes, publishKey)
 		s.publishedVolumesMu.Unlock()
 		klog.V(4).Infof("ControllerUnpublishVolume: unpublished volume %s from node %s", volumeID, nodeID)
+
+		// nfs.lockRecovery: release the NFSv4 state a failed node still holds
+		s.recoverNFSLocks(ctx, volumeID, nodeID)
 	}
 
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
@@ -1201,8 +1573,6 @@ func (s *ControllerService) ControllerUnpublishVolume(_ context.Context, req *cs
 
 // ValidateVolumeCapabilities validates volume capabilities.
 func (s *ControllerService) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	klog.V(4).Infof("ValidateVolumeCapabilities called with request: %+v", req)
-
 	if req.GetVolumeId() == "" {
 		return nil, status.Error(codes.InvalidArgument, errMsgVolumeIDRequired)
 	}
@@ -1217,7 +1587,12 @@ func (s *ControllerService) ValidateVolumeCapabilities(ctx context.Context, req
 	// Look up the volume and determine its protocol
 	var protocol string
 
-	if isDatasetPathVolumeID(volumeID) {
+	if volume, ok := parseSubdirVolumeID(volumeID); ok {
+		if _, err := s.getSubdirVolume(ctx, volumeID, volume); err != nil {
+			return nil, err
+		}
+		protocol = ProtocolNFS
+	} else if isDatasetPathVolumeID(volumeID) {
 		// New format: volume ID is the dataset path, query directly (O(1))
 		dataset, err := s.apiClient.GetDatasetWithProperties(ctx, volumeID)
 		if err != nil || dataset == nil {
@@ -1252,9 +1627,17 @@ func (s *ControllerService) ValidateVolumeCapabilities(ctx context.Context, req
 	}, nil
 }
 
+// managedDatasetPager is implemented by API clients that can page through datasets server-side.
+// ListVolumes streams such clients page by page instead of loading every dataset into memory.
+type managedDatasetPager interface {
+	QueryDatasetsPageAfter(ctx context.Context, afterID string, limit int) ([]tnsapi.DatasetWithProperties, error)
+}
+
 // ListVolumes lists all volumes.
 func (s *ControllerService) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	klog.V(4).Infof("ListVolumes called with request: %+v", req)
+	if pager, ok := s.apiClient.(managedDatasetPager); ok {
+		return s.listVolumesPaged(ctx, pager, req)
+	}
 
 	// Single API call: get all CSI-managed datasets with their ZFS properties
 	entries, err := s.listManagedVolumes(ctx)
@@ -1307,6 +1690,55 @@ func (s *ControllerService) ListVolumes(ctx context.Context, req *csi.ListVolume
 	}, nil
 }
 
+// listVolumesPaged serves ListVolumes by streaming ID-ordered dataset pages from the storage system.
+// The token is the volume ID (dataset path) of the last returned entry; the next call resumes with
+// datasets sorting after it, so volumes created or deleted between calls never invalidate the token.
+func (s *ControllerService) listVolumesPaged(ctx context.Context, pager managedDatasetPager, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	maxEntries := int(req.GetMaxEntries())
+	cursor := req.GetStartingToken()
+
+	// Tokens are dataset paths we handed out; anything else cannot resume a listing
+	if cursor != "" && !isDatasetPathVolumeID(cursor) {
+		return nil, status.Errorf(codes.Aborted, "invalid starting_token: %s", cursor)
+	}
+
+	pageSize := tnsapi.DefaultQueryPageSize
+	if maxEntries > 0 && maxEntries+1 > pageSize {
+		pageSize = maxEntries + 1
+	}
+
+	var entries []*csi.ListVolumesResponse_Entry
+	scanned := 0
+	for {
+		page, err := pager.QueryDatasetsPageAfter(ctx, cursor, pageSize)
+		if err != nil {
+			klog.Errorf("Failed to list managed volumes: %v", err)
+			return nil, status.Errorf(codes.Internal, "failed to list managed volumes: %v", err)
+		}
+		scanned += len(page)
+
+		for i := range page {
+			if entry := s.volumeEntryFromDataset(&page[i]); entry != nil {
+				entries = append(entries, entry)
+			}
+			// One extra entry tells us whether another page exists
+			if maxEntries > 0 && len(entries) > maxEntries {
+				nextToken := entries[maxEntries-1].Volume.VolumeId
+				klog.V(4).Infof("Returning %d volumes (scanned %d datasets, next token: %s)", maxEntries, scanned, nextToken)
+				return &csi.ListVolumesResponse{Entries: entries[:maxEntries], NextToken: nextToken}, nil
+			}
+		}
+
+		if len(page) < pageSize {
+			break
+		}
+		cursor = page[len(page)-1].ID
+	}
+
+	klog.V(4).Infof("Returning %d volumes (scanned %d datasets)", len(entries), scanned)
+	return &csi.ListVolumesResponse{Entries: entries}, nil
+}
+
 // listManagedVolumes lists all CSI-managed volumes using a single FindManagedDatasets call.
 // ZFS properties store all metadata needed to build ListVolumes entries, so no need
 // to query shares/namespaces/extents separately.
@@ -1320,30 +1752,7 @@ func (s *ControllerService) listManagedVolumes(ctx context.Context) ([]*csi.List
 
 	var entries []*csi.ListVolumesResponse_Entry
 	for i := range datasets {
-		ds := &datasets[i]
-
-		// Skip detached snapshots — they are not volumes
-		if ds.UserProperties != nil {
-			if detached, ok := ds.UserProperties[tnsapi.PropertyDetachedSnapshot]; ok && detached.Value == VolumeContextValueTrue {
-				continue
-			}
-			if _, ok := ds.UserProperties[tnsapi.PropertySnapshotID]; ok {
-				continue
-			}
-		}
-
-		meta, err := extractVolumeMetadata(ds.ID, ds)
-		if err != nil {
-			klog.Warningf("Skipping dataset %s: failed to extract metadata: %v", ds.ID, err)
-			continue
-		}
-		if meta == nil {
-			// Not managed by tns-csi or missing properties
-			continue
-		}
-
-		entry := s.buildVolumeEntry(ds.Dataset, *meta)
-		if entry != nil {
+		if entry := s.volumeEntryFromDataset(&datasets[i]); entry != nil {
 			entries = append(entries, entry)
 		}
 	}
@@ -1352,6 +1761,47 @@ func (s *ControllerService) listManagedVolumes(ctx context.Context) ([]*csi.List
 	return entries, nil
 }
 
+// volumeEntryFromDataset builds a ListVolumes entry for a tns-csi managed volume dataset.
+// Returns nil for datasets that are not volumes (unmanaged datasets, detached snapshots).
+func (s *ControllerService) volumeEntryFromDataset(ds *tnsapi.DatasetWithProperties) *csi.ListVolumesResponse_Entry {
+	if ds.UserProperties == nil {
+		return nil
+	}
+	if managedBy, ok := ds.UserProperties[tnsapi.PropertyManagedBy]; !ok || managedBy.Value != tnsapi.ManagedByValue {
+		return nil
+	}
+
+	// Skip datasets still being configured by --atomic-create
+	if isStagingDataset(ds.ID) {
+		return nil
+	}
+
+	// Skip volumes that are being deleted in the background
+	if pending, ok := ds.UserProperties[tnsapi.PropertyPendingDelete]; ok && pending.Value == tnsapi.PropertyValueTrue {
+		return nil
+	}
+
+	// Skip detached snapshots — they are not volumes
+	if detached, ok := ds.UserProperties[tnsapi.PropertyDetachedSnapshot]; ok && detached.Value == VolumeContextValueTrue {
+		return nil
+	}
+	if _, ok := ds.UserProperties[tnsapi.PropertySnapshotID]; ok {
+		return nil
+	}
+
+	meta, err := extractVolumeMetadata(ds.ID, ds)
+	if err != nil {
+		klog.Warningf("Skipping dataset %s: failed to extract metadata: %v", ds.ID, err)
+		return nil
+	}
+	if meta == nil {
+		// Not managed by tns-csi or missing properties
+		return nil
+	}
+
+	return s.buildVolumeEntry(ds.Dataset, *meta)
+}
+
 // buildVolumeEntry constructs a ListVolumesResponse_Entry from dataset and metadata.
 func (s *ControllerService) buildVolumeEntry(dataset tnsapi.Dataset, meta VolumeMetadata) *csi.ListVolumesResponse_Entry {
 	// Volume ID is the full dataset path for O(1) lookups
@@ -1376,8 +1826,6 @@ func (s *ControllerService) buildVolumeEntry(dataset tnsapi.Dataset, meta Volume
 
 // GetCapacity returns the capacity of the storage pool.
 func (s *ControllerService) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	klog.V(4).Infof("GetCapacity called with request: %+v", req)
-
 	// Extract pool name from StorageClass parameters
 	params := req.GetParameters()
 	if params == nil {
@@ -1385,6 +1833,14 @@ func (s *ControllerService) GetCapacity(ctx context.Context, req *csi.GetCapacit
 		return &csi.GetCapacityResponse{}, nil
 	}
 
+	if params[PoolsParam] != "" {
+		pools, _, err := parsePoolPlacement(params)
+		if err != nil {
+			return nil, err
+		}
+		return s.poolsCapacity(ctx, pools)
+	}
+
 	poolName := params["pool"]
 	if poolName == "" {
 		klog.Warning("GetCapacity called without pool parameter")
@@ -1444,34 +1900,13 @@ func IsVolumeAdoptable(props map[string]tnsapi.UserProperty) bool {
 		return false
 	}
 
-	// Verify protocol-specific required properties exist
-	switch protocol.Value {
-	case tnsapi.ProtocolNFS:
-		// NFS requires share path
-		if _, ok := props[tnsapi.PropertyNFSSharePath]; !ok {
-			return false
-		}
-	case tnsapi.ProtocolNVMeOF:
-		// NVMe-oF requires NQN
-		if _, ok := props[tnsapi.PropertyNVMeSubsystemNQN]; !ok {
-			return false
-		}
-	case tnsapi.ProtocolISCSI:
-		// iSCSI requires IQN
-		if _, ok := props[tnsapi.PropertyISCSIIQN]; !ok {
-			return false
-		}
-	case tnsapi.ProtocolSMB:
-		// SMB requires share name
-		if _, ok := props[tnsapi.PropertySMBShareName]; !ok {
-			return false
-		}
-	default:
-		// Unknown protocol - don't adopt
+	// Verify the protocol is known and its required property exists (NFS share path, NVMe-oF NQN, ...)
+	handler := controllerProtocolFor(nil, protocol.Value)
+	if handler == nil {
 		return false
 	}
-
-	return true
+	_, ok = props[handler.AdoptionProperty()]
+	return ok
 }
 
 // GetAdoptionInfo extracts adoption-relevant information from volume properties.
@@ -1500,6 +1935,9 @@ func GetAdoptionInfo(props map[string]tnsapi.UserProperty) map[string]string {
 	if v, ok := props[tnsapi.PropertyPVCNamespace]; ok {
 		info["pvcNamespace"] = v.Value
 	}
+	if v, ok := props[tnsapi.PropertyPVName]; ok {
+		info["pvName"] = v.Value
+	}
 	if v, ok := props[tnsapi.PropertyStorageClass]; ok {
 		info["storageClass"] = v.Value
 	}
@@ -1592,10 +2030,7 @@ func (s *ControllerService) checkAndAdoptVolume(ctx context.Context, req *csi.Cr
 	if capacityProp, ok := props[tnsapi.PropertyCapacityBytes]; ok {
 		existingCapacity = tnsapi.StringToInt64(capacityProp.Value)
 	}
-	requestedCapacity := req.GetCapacityRange().GetRequiredBytes()
-	if requestedCapacity == 0 {
-		requestedCapacity = 1 * 1024 * 1024 * 1024 // 1 GiB default
-	}
+	requestedCapacity := requestedVolumeCapacity(req)
 
 	if requestedCapacity > existingCapacity && existingCapacity > 0 {
 		klog.Infof("Expanding adopted volume %s from %d to %d bytes", volumeName, existingCapacity, requestedCapacity)
@@ -1606,67 +2041,40 @@ func (s *ControllerService) checkAndAdoptVolume(ctx context.Context, req *csi.Cr
 	}
 
 	// Adopt the volume: re-create missing TrueNAS resources based on protocol
-	switch protocol {
-	case ProtocolNFS:
-		resp, err := s.adoptNFSVolume(ctx, req, dataset, params)
-		if err != nil {
-			return nil, true, err
-		}
-		return resp, true, nil
-
-	case ProtocolNVMeOF:
-		resp, err := s.adoptNVMeOFVolume(ctx, req, dataset, params)
-		if err != nil {
-			return nil, true, err
-		}
-		return resp, true, nil
-
-	case ProtocolISCSI:
-		resp, err := s.adoptISCSIVolume(ctx, req, dataset, params)
-		if err != nil {
-			return nil, true, err
-		}
-		return resp, true, nil
-
-	case ProtocolSMB:
-		resp, err := s.adoptSMBVolume(ctx, req, dataset, params)
-		if err != nil {
-			return nil, true, err
-		}
-		return resp, true, nil
-
-	default:
+	handler := controllerProtocolFor(s, protocol)
+	if handler == nil {
 		return nil, true, status.Errorf(codes.InvalidArgument,
 			"Unsupported protocol for adoption: %s", protocol)
 	}
+	resp, err := s.adoptVolume(ctx, handler, req, dataset)
+	if err != nil {
+		return nil, true, err
+	}
+	return resp, true, nil
 }
 
 // expandAdoptedVolume expands a volume during adoption if requested capacity is larger.
 func (s *ControllerService) expandAdoptedVolume(ctx context.Context, dataset *tnsapi.DatasetWithProperties, protocol string, newCapacityBytes int64) error {
 	updateParams := tnsapi.DatasetUpdateParams{}
 
-	switch protocol {
-	case ProtocolNFS, ProtocolSMB:
-		// NFS and SMB use quota (both are FILESYSTEM datasets)
-		updateParams.Quota = &newCapacityBytes
-	case ProtocolNVMeOF, ProtocolISCSI:
-		// NVMe-oF and iSCSI use volsize (both are ZVOLs)
-		updateParams.Volsize = &newCapacityBytes
+	if handler := controllerProtocolFor(s, protocol); handler != nil {
+		if handler.BlockDevice() {
+			// NVMe-oF and iSCSI use volsize (both are ZVOLs)
+			updateParams.Volsize = &newCapacityBytes
+		} else {
+			// NFS and SMB use quota (both are FILESYSTEM datasets)
+			updateParams.Quota = &newCapacityBytes
+		}
 	}
 
-	_, err := s.apiClient.UpdateDataset(ctx, dataset.ID, updateParams)
-	if err != nil {
+	// Resize and record the new capacity property in one update
+	batch := tnsapi.NewDatasetUpdateBatch(dataset.ID).
+		SetProperty(tnsapi.PropertyCapacityBytes, strconv.FormatInt(newCapacityBytes, 10))
+	batch.Params = updateParams
+	if err := batch.Apply(ctx, s.apiClient); err != nil {
 		return fmt.Errorf("failed to expand dataset %s: %w", dataset.ID, err)
 	}
 
-	// Update capacity property
-	capacityProps := map[string]string{
-		tnsapi.PropertyCapacityBytes: strconv.FormatInt(newCapacityBytes, 10),
-	}
-	if propErr := s.apiClient.SetDatasetProperties(ctx, dataset.ID, capacityProps); propErr != nil {
-		klog.Warningf("Failed to update capacity property on %s: %v", dataset.ID, propErr)
-	}
-
 	return nil
 }
 
@@ -1674,6 +2082,10 @@ func (s *ControllerService) expandAdoptedVolume(ctx context.Context, dataset *tn
 func (s *ControllerService) ControllerGetCapabilities(_ context.Context, _ *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
 	klog.V(4).Info("ControllerGetCapabilities called")
 
+	// The external-provisioner asks for capabilities on startup: a good moment to resume
+	// background work interrupted by a controller restart
+	s.resumeDeferredWorkOnce()
+
 	return &csi.ControllerGetCapabilitiesResponse{
 		Capabilities: []*csi.ControllerServiceCapability{
 			{
@@ -1777,8 +2189,6 @@ func (s *ControllerService) ControllerGetCapabilities(_ context.Context, _ *csi.
 
 // ControllerExpandVolume expands a volume.
 func (s *ControllerService) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	klog.V(4).Infof("ControllerExpandVolume called with request: %+v", req)
-
 	// Validate request
 	if req.GetVolumeId() == "" {
 		return nil, status.Error(codes.InvalidArgument, errMsgVolumeIDRequired)
@@ -1798,6 +2208,11 @@ func (s *ControllerService) ControllerExpandVolume(ctx context.Context, req *csi
 
 	klog.Infof("ControllerExpandVolume: Expanding volume %s to %d bytes", volumeID, requiredBytes)
 
+	if _, ok := parseSubdirVolumeID(volumeID); ok {
+		// Directory volumes have no quota to raise
+		return &csi.ControllerExpandVolumeResponse{CapacityBytes: requiredBytes}, nil
+	}
+
 	// Look up volume using ZFS properties as source of truth
 	volumeMeta, err := s.lookupVolumeByCSIName(ctx, "", volumeID)
 	if err != nil {
@@ -1811,30 +2226,22 @@ func (s *ControllerService) ControllerExpandVolume(ctx context.Context, req *csi
 	}
 
 	klog.V(4).Infof("ControllerExpandVolume: Found volume %s via property lookup: dataset=%s, protocol=%s", volumeID, volumeMeta.DatasetID, volumeMeta.Protocol)
-	switch volumeMeta.Protocol {
-	case ProtocolNFS:
-		klog.Infof("Expanding NFS volume %s with dataset %s to %d bytes", volumeID, volumeMeta.DatasetName, requiredBytes)
-		return s.expandNFSVolume(ctx, volumeMeta, requiredBytes)
-	case ProtocolNVMeOF:
-		klog.Infof("Expanding NVMe-oF volume %s with dataset %s to %d bytes", volumeID, volumeMeta.DatasetName, requiredBytes)
-		return s.expandNVMeOFVolume(ctx, volumeMeta, requiredBytes)
-	case ProtocolISCSI:
-		klog.Infof("Expanding iSCSI volume %s with dataset %s to %d bytes", volumeID, volumeMeta.DatasetName, requiredBytes)
-		return s.expandISCSIVolume(ctx, volumeMeta, requiredBytes)
-	case ProtocolSMB:
-		klog.Infof("Expanding SMB volume %s with dataset %s to %d bytes", volumeID, volumeMeta.DatasetName, requiredBytes)
-		return s.expandSMBVolume(ctx, volumeMeta, requiredBytes)
-	default:
+	requiredBytes, err = s.expansionCapacity(ctx, volumeMeta.DatasetID, req.GetCapacityRange())
+	if err != nil {
+		return nil, err
+	}
+	handler := controllerProtocolFor(s, volumeMeta.Protocol)
+	if handler == nil {
 		return nil, status.Errorf(codes.Internal, "Unknown protocol %s for volume %s", volumeMeta.Protocol, volumeID)
 	}
+	klog.Infof("Expanding %s volume %s with dataset %s to %d bytes", handler.Name(), volumeID, volumeMeta.DatasetName, requiredBytes)
+	return handler.Expand(ctx, volumeMeta, requiredBytes)
 }
 
 // ControllerGetVolume returns volume information including health status.
 // This is used by Kubernetes to monitor volume health and report conditions.
 // Per CSI spec, this returns VolumeCondition with Abnormal flag and Message.
 func (s *ControllerService) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
-	klog.V(4).Infof("ControllerGetVolume called with request: %+v", req)
-
 	// Validate request
 	if req.GetVolumeId() == "" {
 		return nil, status.Error(codes.InvalidArgument, errMsgVolumeIDRequired)
@@ -1843,6 +2250,10 @@ func (s *ControllerService) ControllerGetVolume(ctx context.Context, req *csi.Co
 	volumeID := req.GetVolumeId()
 	klog.V(4).Infof("Getting volume info for: %s", volumeID)
 
+	if volume, ok := parseSubdirVolumeID(volumeID); ok {
+		return s.getSubdirVolume(ctx, volumeID, volume)
+	}
+
 	// Look up volume using ZFS properties as source of truth
 	volumeMeta, err := s.lookupVolumeByCSIName(ctx, "", volumeID)
 	if err != nil {
@@ -1855,198 +2266,15 @@ func (s *ControllerService) ControllerGetVolume(ctx context.Context, req *csi.Co
 		return nil, status.Errorf(codes.NotFound, "Volume %s not found", volumeID)
 	}
 
-	switch volumeMeta.Protocol {
-	case ProtocolNFS:
-		return s.getNFSVolumeInfo(ctx, volumeMeta)
-	case ProtocolNVMeOF:
-		return s.getNVMeOFVolumeInfo(ctx, volumeMeta)
-	case ProtocolISCSI:
-		return s.getISCSIVolumeInfo(ctx, volumeMeta)
-	case ProtocolSMB:
-		return s.getSMBVolumeInfo(ctx, volumeMeta)
-	default:
+	handler := controllerProtocolFor(s, volumeMeta.Protocol)
+	if handler == nil {
 		return nil, status.Errorf(codes.Internal, "Unknown protocol %s for volume %s", volumeMeta.Protocol, volumeID)
 	}
-}
-
-// getNFSVolumeInfo retrieves volume information and health status for an NFS volume.
-func (s *ControllerService) getNFSVolumeInfo(ctx context.Context, meta *VolumeMetadata) (*csi.ControllerGetVolumeResponse, error) {
-	klog.V(4).Infof("Getting NFS volume info: %s (dataset: %s, shareID: %d)", meta.Name, meta.DatasetName, meta.NFSShareID)
-
-	abnormal := false
-	var messages []string
-
-	// Check 1: Verify dataset exists
-	dataset, err := s.apiClient.Dataset(ctx, meta.DatasetName)
-	if err != nil || dataset == nil {
-		abnormal = true
-		messages = append(messages, fmt.Sprintf("Dataset %s not accessible: %v", meta.DatasetName, err))
-	} else {
-		klog.V(4).Infof("Dataset %s exists (ID: %s)", meta.DatasetName, dataset.ID)
-	}
-
-	// Check 2: Verify NFS share exists and is enabled
-	if meta.NFSShareID > 0 {
-		foundShare, err := s.apiClient.QueryNFSShareByID(ctx, meta.NFSShareID)
-		if err != nil {
-			abnormal = true
-			messages = append(messages, fmt.Sprintf("Failed to query NFS share %d: %v", meta.NFSShareID, err))
-		} else {
-			switch {
-			case foundShare == nil:
-				abnormal = true
-				messages = append(messages, fmt.Sprintf("NFS share %d not found", meta.NFSShareID))
-			case !foundShare.Enabled:
-				abnormal = true
-				messages = append(messages, fmt.Sprintf("NFS share %d is disabled", meta.NFSShareID))
-			default:
-				klog.V(4).Infof("NFS share %d is healthy (enabled: %t, path: %s)", foundShare.ID, foundShare.Enabled, foundShare.Path)
-			}
-		}
-	}
-
-	// Build response message
-	message := msgVolumeIsHealthy
-	if abnormal {
-		message = strings.Join(messages, "; ")
-	}
-
-	// Build volume context
-	volumeContext := buildVolumeContext(*meta)
-
-	// Get capacity from dataset if available
-	var capacityBytes int64
-	if dataset != nil && dataset.Available != nil {
-		if val, ok := dataset.Available["parsed"].(float64); ok {
-			capacityBytes = int64(val)
-		}
-	}
-
-	klog.V(4).Infof("NFS volume %s status: abnormal=%t, message=%s", meta.Name, abnormal, message)
-
-	return &csi.ControllerGetVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      meta.Name,
-			CapacityBytes: capacityBytes,
-			VolumeContext: volumeContext,
-		},
-		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
-			VolumeCondition: &csi.VolumeCondition{
-				Abnormal: abnormal,
-				Message:  message,
-			},
-		},
-	}, nil
-}
-
-// getNVMeOFVolumeInfo retrieves volume information and health status for an NVMe-oF volume.
-func (s *ControllerService) getNVMeOFVolumeInfo(ctx context.Context, meta *VolumeMetadata) (*csi.ControllerGetVolumeResponse, error) {
-	klog.V(4).Infof("Getting NVMe-oF volume info: %s (dataset: %s, subsystemID: %d, namespaceID: %d)",
-		meta.Name, meta.DatasetName, meta.NVMeOFSubsystemID, meta.NVMeOFNamespaceID)
-
-	abnormal := false
-	var messages []string
-
-	// Check 1: Verify ZVOL exists
-	var datasets []tnsapi.Dataset
-	datasets, err := s.apiClient.QueryAllDatasets(ctx, meta.DatasetName)
-	switch {
-	case err != nil:
-		abnormal = true
-		messages = append(messages, fmt.Sprintf("ZVOL %s query failed: %v", meta.DatasetName, err))
-	case len(datasets) == 0:
-		abnormal = true
-		messages = append(messages, fmt.Sprintf("ZVOL %s not found", meta.DatasetName))
-	default:
-		klog.V(4).Infof("ZVOL %s exists (ID: %s)", meta.DatasetName, datasets[0].ID)
-	}
-
-	// Check 2: Verify NVMe-oF subsystem exists (use NQN-based lookup if available)
-	var subsystemHealthy bool
-	if meta.NVMeOFNQN != "" {
-		foundSubsystem, err := s.apiClient.NVMeOFSubsystemByNQN(ctx, meta.NVMeOFNQN)
-		if err != nil {
-			abnormal = true
-			messages = append(messages, fmt.Sprintf("NVMe-oF subsystem not found for NQN %s: %v", meta.NVMeOFNQN, err))
-		} else {
-			subsystemHealthy = true
-			klog.V(4).Infof("NVMe-oF subsystem %d is healthy (NQN: %s)", foundSubsystem.ID, foundSubsystem.NQN)
-		}
-	} else if meta.NVMeOFSubsystemID > 0 {
-		// Fallback: no NQN stored, list all subsystems to find by ID
-		subsystems, err := s.apiClient.ListAllNVMeOFSubsystems(ctx)
-		if err != nil {
-			abnormal = true
-			messages = append(messages, fmt.Sprintf("Failed to query NVMe-oF subsystems: %v", err))
-		} else {
-			var found bool
-			for i := range subsystems {
-				if subsystems[i].ID == meta.NVMeOFSubsystemID {
-					found = true
-					subsystemHealthy = true
-					klog.V(4).Infof("NVMe-oF subsystem %d is healthy (NQN: %s)", subsystems[i].ID, subsystems[i].NQN)
-					break
-				}
-			}
-			if !found {
-				abnormal = true
-				messages = append(messages, fmt.Sprintf("NVMe-oF subsystem %d not found", meta.NVMeOFSubsystemID))
-			}
-		}
-	}
-
-	// Check 3: Verify NVMe-oF namespace exists (O(1) server-side filter)
-	if meta.NVMeOFNamespaceID > 0 && subsystemHealthy {
-		foundNamespace, err := s.apiClient.QueryNVMeOFNamespaceByID(ctx, meta.NVMeOFNamespaceID)
-		switch {
-		case err != nil:
-			abnormal = true
-			messages = append(messages, fmt.Sprintf("Failed to query NVMe-oF namespace %d: %v", meta.NVMeOFNamespaceID, err))
-		case foundNamespace == nil:
-			abnormal = true
-			messages = append(messages, fmt.Sprintf("NVMe-oF namespace %d not found", meta.NVMeOFNamespaceID))
-		default:
-			klog.V(4).Infof("NVMe-oF namespace %d is healthy (NSID: %d, device: %s)",
-				foundNamespace.ID, foundNamespace.NSID, foundNamespace.GetDevice())
-		}
-	}
-
-	// Build response message
-	message := msgVolumeIsHealthy
-	if abnormal {
-		message = strings.Join(messages, "; ")
-	}
-
-	// Build volume context
-	volumeContext := buildVolumeContext(*meta)
-
-	// Get capacity from ZVOL if available
-	var capacityBytes int64
-	if len(datasets) > 0 {
-		capacityBytes = getZvolCapacity(&datasets[0])
-	}
-
-	klog.V(4).Infof("NVMe-oF volume %s status: abnormal=%t, message=%s", meta.Name, abnormal, message)
-
-	return &csi.ControllerGetVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      meta.Name,
-			CapacityBytes: capacityBytes,
-			VolumeContext: volumeContext,
-		},
-		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
-			VolumeCondition: &csi.VolumeCondition{
-				Abnormal: abnormal,
-				Message:  message,
-			},
-		},
-	}, nil
+	return handler.Describe(ctx, volumeMeta)
 }
 
 // ControllerModifyVolume modifies a volume.
 func (s *ControllerService) ControllerModifyVolume(_ context.Context, req *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
-	klog.V(4).Infof("ControllerModifyVolume called with request: %+v", req)
-
 	if req.GetVolumeId() == "" {
 		return nil, status.Error(codes.InvalidArgument, errMsgVolumeIDRequired)
 	}
@@ -0,0 +1,67 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/dashboard"
+	"github.com/fenio/tns-csi/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// Consistency audit.
+//
+// With --audit-interval the controller runs the consistency audit of pkg/dashboard (also behind
+// kubectl tns-csi audit) at that interval and exports the number of findings of each kind. PVs and
+// VolumeSnapshotContents come from the caches of --kube-informers; without them, or while they are
+// not synced, the checks that need them are skipped. Each finding is logged when it first shows
+// up, so a standing mismatch does not flood the log. The audit never changes anything.
+
+// runAudit audits the volumes of this cluster every interval until stopCh is closed.
+func (s *ControllerService) runAudit(interval time.Duration, stopCh <-chan struct{}) {
+	klog.Infof("Consistency audit enabled: auditing every %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		s.audit(ctx)
+		cancel()
+	}
+}
+
+// audit runs one consistency audit and returns its report (nil if it failed).
+func (s *ControllerService) audit(ctx context.Context) *dashboard.AuditReport {
+	var input dashboard.AuditInput
+	input.PVs, input.PVsKnown = s.kubeView.listPVs()
+	input.SnapshotHandles, input.SnapshotsKnown = s.kubeView.snapshotHandles()
+
+	report, err := dashboard.Audit(ctx, s.apiClient, s.clusterID, input)
+	if err != nil {
+		klog.Warningf("Consistency audit failed: %v", err)
+		return nil
+	}
+
+	seen := make(map[dashboard.AuditFinding]bool, len(report.Findings))
+	for _, finding := range report.Findings {
+		seen[finding] = true
+		if !s.auditFindings[finding] {
+			klog.Warningf("Consistency audit: %s %s: %s", finding.Kind, finding.Resource, finding.Detail)
+		}
+	}
+	s.auditFindings = seen
+	for kind, count := range report.Counts() {
+		metrics.SetAuditFindings(kind, count)
+	}
+	if len(report.Skipped) > 0 {
+		klog.V(4).Infof("Consistency audit skipped %v: Kubernetes caches not available", report.Skipped)
+	}
+	return report
+}
@@ -0,0 +1,140 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/dashboard"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAuditIntegration(t *testing.T) {
+	controller, srv := newIntegrationController(t)
+	ctx := context.Background()
+
+	create := func(name, protocol string) string {
+		t.Helper()
+		capability := &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}
+		if protocol == ProtocolNVMeOF {
+			capability.AccessType = &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+		}
+		resp, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:               name,
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+			VolumeCapabilities: []*csi.VolumeCapability{capability},
+			Parameters:         map[string]string{"protocol": protocol, "pool": "tank", "server": "truenas.local"},
+		})
+		if err != nil {
+			t.Fatalf("CreateVolume(%s) error = %v", name, err)
+		}
+		return resp.GetVolume().GetVolumeId()
+	}
+	snapshot := func(name, volumeID string) string {
+		t.Helper()
+		resp, err := controller.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: name, SourceVolumeId: volumeID})
+		if err != nil {
+			t.Fatalf("CreateSnapshot(%s) error = %v", name, err)
+		}
+		return resp.GetSnapshot().GetSnapshotId()
+	}
+
+	healthy := create("pvc-healthy", ProtocolNFS)
+	unshared := create("pvc-unshared", ProtocolNFS)
+	block := create("pvc-block", ProtocolNVMeOF)
+	kept := snapshot("snap-kept", healthy)
+	snapshot("snap-lost", healthy)
+
+	// unshared lost its NFS share behind the driver's back
+	datasets, err := controller.apiClient.FindManagedDatasets(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range datasets {
+		if datasets[i].ID != unshared {
+			continue
+		}
+		shareID, err := strconv.Atoi(datasets[i].UserProperties[tnsapi.PropertyNFSShareID].Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := controller.apiClient.DeleteNFSShare(ctx, shareID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the ZVOL of block was destroyed while its namespace stayed
+	namespaces, err := controller.apiClient.QueryAllNVMeOFNamespaces(ctx)
+	if err != nil || len(namespaces) != 1 {
+		t.Fatalf("QueryAllNVMeOFNamespaces() = %v, %v; want one namespace", namespaces, err)
+	}
+	if err := controller.apiClient.DeleteDataset(ctx, block); err != nil {
+		t.Fatal(err)
+	}
+	srv.Handle("nvmet.namespace.query", func(_ []json.RawMessage) (interface{}, error) {
+		return namespaces, nil
+	})
+
+	// Without Kubernetes caches only the TrueNAS-side checks run
+	report := controller.audit(ctx)
+	if report == nil {
+		t.Fatal("audit() failed")
+	}
+	if len(report.Skipped) != 2 {
+		t.Errorf("skipped checks without caches = %v, want %s and %s", report.Skipped, dashboard.AuditPVMissingDataset, dashboard.AuditSnapshotWithoutContent)
+	}
+
+	content := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "snapshot.storage.k8s.io/v1",
+		"kind":       "VolumeSnapshotContent",
+		"metadata":   map[string]interface{}{"name": "snapcontent-kept"},
+		"spec":       map[string]interface{}{"driver": testDriverName},
+		"status":     map[string]interface{}{"snapshotHandle": kept},
+	}}
+	controller.kubeView = newTestClusterView(t, fake.NewClientset(
+		testPV("pv-healthy", healthy, "healthy"),
+		testPV("pv-unshared", unshared, "unshared"),
+		testPV("pv-block", block, "block"),
+		testPV("pv-gone", "tank/pvc-gone", "gone"),
+	), content)
+
+	report = controller.audit(ctx)
+	if report == nil {
+		t.Fatal("audit() failed")
+	}
+	if len(report.Skipped) != 0 {
+		t.Errorf("skipped checks = %v, want none", report.Skipped)
+	}
+	got := make(map[string]string)
+	for _, finding := range report.Findings {
+		if previous, dup := got[finding.Resource]; dup {
+			t.Errorf("%s reported as %s and %s", finding.Resource, previous, finding.Kind)
+		}
+		got[finding.Resource] = finding.Kind
+	}
+	want := map[string]string{
+		"pv-gone":  dashboard.AuditPVMissingDataset,
+		"pv-block": dashboard.AuditPVMissingDataset,
+		unshared:   dashboard.AuditStaleShareID,
+		fmt.Sprintf("namespace %d", namespaces[0].ID): dashboard.AuditNamespaceMissingZvol,
+		healthy + "@snap-lost":                        dashboard.AuditSnapshotWithoutContent,
+	}
+	for resource, kind := range want {
+		if got[resource] != kind {
+			t.Errorf("finding of %s = %q, want %q", resource, got[resource], kind)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("findings = %v, want %v", got, want)
+	}
+	if counts := report.Counts(); counts[dashboard.AuditPVMissingDataset] != 2 || counts[dashboard.AuditStaleShareID] != 1 {
+		t.Errorf("Counts() = %v", counts)
+	}
+}
@@ -0,0 +1,240 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// Asynchronous deletion of large volumes.
+//
+// Destroying a dataset frees all of its blocks before pool.dataset.delete returns, which for a
+// multi-TB ZVOL can take minutes: DeleteVolume outlives the external-provisioner timeout and
+// the retries queue up behind the destroy that is still running. With --async-delete-min-size,
+// volumes using at least that much space are deleted in two phases. DeleteVolume marks the
+// dataset with tns-csi:pending_delete, renames it to .deleting-<name> under the same parent (so
+// the volume name is free again), submits the destroy as a TrueNAS job and returns success.
+// The controller's deletion reconciler then lists the marked datasets every minute: datasets
+// whose job is still running are left alone, and datasets whose job finished (or is unknown,
+// e.g. after a TrueNAS restart) without removing them are submitted again until they are gone.
+// Clones of the dataset's snapshots are promoted before it is marked, as for synchronous
+// deletions. Should a clone appear afterwards, the job fails on it: such datasets are reported
+// as errors and not resubmitted until tns-csi:delete_job_id is cleared on them.
+//
+// Volumes with recursiveDelete=false are always deleted synchronously, so that ZFS still refuses
+// child datasets and snapshots created after the check.
+
+// deletingPrefix is prepended to the name of a dataset that is being deleted in the background.
+const deletingPrefix = ".deleting-"
+
+// asyncDeleteInterval is how often marked datasets are reconciled (a variable so tests can shorten it).
+var asyncDeleteInterval = time.Minute
+
+// ParseAsyncDeleteMinSize parses the used space from which volumes are deleted in the background
+// (e.g. "500Gi"). An empty value disables asynchronous deletion.
+func ParseAsyncDeleteMinSize(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil || q.Value() <= 0 {
+		return 0, fmt.Errorf("invalid async delete min size %q: must be a positive size", value)
+	}
+	return q.Value(), nil
+}
+
+// asyncDeleteTracker remembers when this controller started deleting datasets in the background,
+// to report completed deletions, and which deletions are blocked. The zero value is ready to use.
+type asyncDeleteTracker struct {
+	started map[string]time.Time
+	blocked map[string]int // dataset -> failed job
+	mu      sync.Mutex
+}
+
+// add records that the deletion of datasetID started, unless it is already known.
+func (t *asyncDeleteTracker) add(datasetID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.started == nil {
+		t.started = make(map[string]time.Time)
+	}
+	if _, ok := t.started[datasetID]; !ok {
+		t.started[datasetID] = time.Now()
+	}
+}
+
+// block records that job jobID could not delete datasetID and reports whether that is news.
+func (t *asyncDeleteTracker) block(datasetID string, jobID int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.blocked == nil {
+		t.blocked = make(map[string]int)
+	}
+	if t.blocked[datasetID] == jobID {
+		return false
+	}
+	t.blocked[datasetID] = jobID
+	return true
+}
+
+// finished forgets the datasets not in pending and returns how long each of them took.
+func (t *asyncDeleteTracker) finished(pending map[string]bool) map[string]time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for datasetID := range t.blocked {
+		if !pending[datasetID] {
+			delete(t.blocked, datasetID)
+		}
+	}
+	done := make(map[string]time.Duration)
+	for datasetID, started := range t.started {
+		if !pending[datasetID] {
+			done[datasetID] = time.Since(started)
+			delete(t.started, datasetID)
+		}
+	}
+	return done
+}
+
+// startAsyncDelete runs the first phase of an asynchronous volume deletion. It returns false,
+// leaving the deletion to the caller, when the dataset uses less than --async-delete-min-size.
+func (s *ControllerService) startAsyncDelete(ctx context.Context, meta *VolumeMetadata) (bool, error) {
+	dataset, err := s.apiClient.GetDatasetWithProperties(ctx, meta.DatasetID)
+	if err != nil {
+		return false, err
+	}
+	if dataset == nil {
+		return false, nil
+	}
+	if dataset.UserProperties[tnsapi.PropertyPendingDelete].Value == tnsapi.PropertyValueTrue {
+		klog.Infof("Dataset %s is already being deleted in the background", meta.DatasetID)
+		return true, nil
+	}
+	used := dataset.UsedBytes()
+	if used < s.asyncDeleteMinSize {
+		return false, nil
+	}
+
+	// Mark first: whatever fails from here on, the reconciler finds the dataset and finishes the job
+	if err := s.apiClient.SetDatasetProperties(ctx, meta.DatasetID, map[string]string{
+		tnsapi.PropertyPendingDelete: tnsapi.PropertyValueTrue,
+	}); err != nil {
+		return true, fmt.Errorf("failed to mark dataset %s for deletion: %w", meta.DatasetID, err)
+	}
+
+	datasetID := meta.DatasetID
+	trashID := path.Join(path.Dir(datasetID), deletingPrefix+path.Base(datasetID))
+	if err := s.apiClient.RenameDataset(ctx, datasetID, trashID); err != nil {
+		klog.Warningf("Cannot rename %s to %s, deleting it under its own name: %v", datasetID, trashID, err)
+	} else {
+		datasetID = trashID
+	}
+
+	klog.Infof("Deleting volume %s (%s used) in the background as %s", meta.Name, formatGiB(used), datasetID)
+	s.asyncDeletes.add(datasetID)
+	s.submitAsyncDelete(ctx, datasetID)
+	return true, nil
+}
+
+// submitAsyncDelete starts the TrueNAS job destroying a marked dataset and records its ID on the
+// dataset. Failures are only logged: the reconciler submits the deletion again.
+func (s *ControllerService) submitAsyncDelete(ctx context.Context, datasetID string) {
+	jobID, err := s.apiClient.StartDatasetDelete(ctx, datasetID, tnsapi.DatasetDeleteOptions{Recursive: true, Force: true})
+	if err != nil {
+		klog.Warningf("Failed to start background deletion of %s, will retry: %v", datasetID, err)
+		return
+	}
+	err = s.apiClient.SetDatasetProperties(ctx, datasetID, map[string]string{tnsapi.PropertyDeleteJobID: strconv.Itoa(jobID)})
+	if err != nil && !isNotFoundError(err) {
+		klog.Warningf("Failed to record deletion job %d on %s: %v", jobID, datasetID, err)
+	}
+}
+
+// reconcileAsyncDeletes runs the second phase of asynchronous deletions: it reports datasets that
+// are gone and resubmits the deletion of those whose job finished without removing them, unless
+// the job failed on dependent clones, which no retry resolves.
+func (s *ControllerService) reconcileAsyncDeletes(ctx context.Context) {
+	if enabled, _ := s.maintenance.active(); enabled {
+		klog.V(4).Infof("Background deletion paused: %v", errMaintenanceMode)
+		return
+	}
+	datasets, err := s.apiClient.FindDatasetsByProperty(ctx, "", tnsapi.PropertyPendingDelete, tnsapi.PropertyValueTrue)
+	if err != nil {
+		klog.Warningf("Failed to look up datasets pending deletion: %v", err)
+		return
+	}
+
+	pending := make(map[string]bool, len(datasets))
+	for i := range datasets {
+		ds := &datasets[i]
+		prop := func(name string) string { return ds.UserProperties[name].Value }
+		if owner := prop(tnsapi.PropertyClusterID); owner != "" && owner != s.clusterID {
+			continue
+		}
+		pending[ds.ID] = true
+		s.asyncDeletes.add(ds.ID)
+
+		if jobID, _ := strconv.Atoi(prop(tnsapi.PropertyDeleteJobID)); jobID > 0 {
+			job, jobErr := s.apiClient.GetJobStatus(ctx, jobID)
+			if jobErr == nil && !job.Finished() {
+				continue
+			}
+			if jobErr == nil {
+				failure := jobFailure(job)
+				if strings.Contains(failure, "dependent clones") {
+					if s.asyncDeletes.block(ds.ID, jobID) {
+						klog.Errorf("Background deletion of %s (job %d) is blocked by dependent clones and will not be retried: %s; "+
+							"promote or delete the clones, then clear %s on the dataset", ds.ID, jobID, failure, tnsapi.PropertyDeleteJobID)
+					}
+					continue
+				}
+				klog.Warningf("Background deletion of %s (job %d) left the dataset behind, retrying: %s", ds.ID, jobID, failure)
+			}
+		}
+		s.submitAsyncDelete(ctx, ds.ID)
+	}
+
+	for datasetID, took := range s.asyncDeletes.finished(pending) {
+		klog.Infof("Background deletion of %s completed after %s", datasetID, took.Round(time.Second))
+	}
+}
+
+// jobFailure describes why a deletion job did not remove its dataset.
+func jobFailure(job *tnsapi.Job) string {
+	if errs := job.BulkErrors(); len(errs) > 0 {
+		return errs[0]
+	}
+	if job.Error != "" {
+		return job.Error
+	}
+	return "job " + job.State
+}
+
+// runAsyncDeletes reconciles asynchronous deletions every asyncDeleteInterval until stopCh is closed.
+func (s *ControllerService) runAsyncDeletes(stopCh <-chan struct{}) {
+	klog.Infof("Background deletion enabled for volumes using at least %s (reconciled every %s)",
+		formatGiB(s.asyncDeleteMinSize), asyncDeleteInterval)
+
+	ticker := time.NewTicker(asyncDeleteInterval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), asyncDeleteInterval)
+		s.reconcileAsyncDeletes(ctx)
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+)
+
+func TestParseAsyncDeleteMinSize(t *testing.T) {
+	for value, want := range map[string]int64{"": 0, "500Gi": 500 << 30, "1Ti": 1 << 40} {
+		if got, err := ParseAsyncDeleteMinSize(value); err != nil || got != want {
+			t.Errorf("ParseAsyncDeleteMinSize(%q) = %d, %v; want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"big", "0", "-1Gi"} {
+		if _, err := ParseAsyncDeleteMinSize(value); err == nil {
+			t.Errorf("ParseAsyncDeleteMinSize(%q) succeeded", value)
+		}
+	}
+}
+
+func TestStartAsyncDelete(t *testing.T) {
+	const gib = int64(1 << 30)
+	tests := []struct {
+		properties  map[string]tnsapi.UserProperty
+		renameErr   error
+		name        string
+		wantDeleted string
+		used        int64
+		wantStarted bool
+	}{
+		{name: "small volume", used: gib},
+		{name: "large volume", used: 2048 * gib, wantStarted: true, wantDeleted: "tank/csi/.deleting-pvc-1"},
+		{
+			name:        "rename fails",
+			used:        2048 * gib,
+			renameErr:   errors.New("dataset is busy"),
+			wantStarted: true,
+			wantDeleted: "tank/csi/pvc-1",
+		},
+		{
+			name:        "already pending",
+			used:        2048 * gib,
+			properties:  map[string]tnsapi.UserProperty{tnsapi.PropertyPendingDelete: {Value: tnsapi.PropertyValueTrue}},
+			wantStarted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deleted string
+			props := make(map[string]map[string]string)
+			mockClient := &MockAPIClientForSnapshots{
+				GetDatasetWithPropertiesFunc: func(_ context.Context, datasetID string) (*tnsapi.DatasetWithProperties, error) {
+					ds := &tnsapi.DatasetWithProperties{UserProperties: tt.properties}
+					ds.ID = datasetID
+					ds.Used = map[string]interface{}{"parsed": float64(tt.used)}
+					return ds, nil
+				},
+				SetDatasetPropertiesFunc: func(_ context.Context, datasetID string, properties map[string]string) error {
+					if props[datasetID] == nil {
+						props[datasetID] = make(map[string]string)
+					}
+					for k, v := range properties {
+						props[datasetID][k] = v
+					}
+					return nil
+				},
+				RenameDatasetFunc: func(_ context.Context, datasetID, newName string) error {
+					if tt.renameErr != nil {
+						return tt.renameErr
+					}
+					props[newName] = props[datasetID]
+					delete(props, datasetID)
+					return nil
+				},
+				StartDatasetDeleteFunc: func(_ context.Context, datasetID string, opts tnsapi.DatasetDeleteOptions) (int, error) {
+					if !opts.Recursive || !opts.Force {
+						t.Errorf("StartDatasetDelete() options = %+v, want recursive and force", opts)
+					}
+					deleted = datasetID
+					return 42, nil
+				},
+			}
+			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+			controller.asyncDeleteMinSize = 1024 * gib
+
+			started, err := controller.startAsyncDelete(context.Background(), &VolumeMetadata{Name: "pvc-1", DatasetID: "tank/csi/pvc-1"})
+			if err != nil {
+				t.Fatalf("startAsyncDelete() error = %v", err)
+			}
+			if started != tt.wantStarted || deleted != tt.wantDeleted {
+				t.Fatalf("startAsyncDelete() = %t deleting %q, want %t deleting %q", started, deleted, tt.wantStarted, tt.wantDeleted)
+			}
+			if deleted == "" {
+				return
+			}
+			want := map[string]string{tnsapi.PropertyPendingDelete: tnsapi.PropertyValueTrue, tnsapi.PropertyDeleteJobID: "42"}
+			if !reflect.DeepEqual(props[deleted], want) {
+				t.Errorf("properties of %s = %v, want %v", deleted, props[deleted], want)
+			}
+		})
+	}
+}
+
+func TestReconcileAsyncDeletes(t *testing.T) {
+	pending := func(id, jobID, clusterID string) tnsapi.DatasetWithProperties {
+		ds := tnsapi.DatasetWithProperties{UserProperties: map[string]tnsapi.UserProperty{
+			tnsapi.PropertyPendingDelete: {Value: tnsapi.PropertyValueTrue},
+			tnsapi.PropertyDeleteJobID:   {Value: jobID},
+			tnsapi.PropertyClusterID:     {Value: clusterID},
+		}}
+		ds.ID = id
+		return ds
+	}
+	datasets := []tnsapi.DatasetWithProperties{
+		pending("tank/csi/.deleting-running", "1", ""),
+		pending("tank/csi/.deleting-failed", "2", "cluster-a"),
+		pending("tank/csi/.deleting-unknown-job", "3", ""),
+		pending("tank/csi/.deleting-no-job", "", ""),
+		pending("tank/csi/.deleting-other-cluster", "4", "cluster-b"),
+		pending("tank/csi/.deleting-clones", "5", ""),
+	}
+	jobs := map[int]*tnsapi.Job{
+		1: {ID: 1, State: tnsapi.JobStateRunning},
+		2: {ID: 2, State: tnsapi.JobStateSuccess, Result: []interface{}{
+			map[string]interface{}{"result": nil, "error": "[EBUSY] dataset is busy"},
+		}},
+		5: {ID: 5, State: tnsapi.JobStateFailed, Error: "[EBUSY] cannot destroy 'tank/csi/.deleting-clones@snap': snapshot has dependent clones"},
+	}
+
+	var submitted []string
+	mockClient := &MockAPIClientForSnapshots{
+		FindDatasetsByPropertyFunc: func(_ context.Context, _, propertyName, _ string) ([]tnsapi.DatasetWithProperties, error) {
+			if propertyName != tnsapi.PropertyPendingDelete {
+				t.Errorf("FindDatasetsByProperty(%s), want %s", propertyName, tnsapi.PropertyPendingDelete)
+			}
+			return datasets, nil
+		},
+		GetJobStatusFunc: func(_ context.Context, jobID int) (*tnsapi.Job, error) {
+			if job, ok := jobs[jobID]; ok {
+				return job, nil
+			}
+			return nil, errors.New("job not found")
+		},
+		StartDatasetDeleteFunc: func(_ context.Context, datasetID string, _ tnsapi.DatasetDeleteOptions) (int, error) {
+			submitted = append(submitted, datasetID)
+			return 10, nil
+		},
+	}
+	controller := NewControllerService(mockClient, NewNodeRegistry(), "cluster-a")
+
+	controller.reconcileAsyncDeletes(context.Background())
+	sort.Strings(submitted)
+	want := []string{"tank/csi/.deleting-failed", "tank/csi/.deleting-no-job", "tank/csi/.deleting-unknown-job"}
+	if !reflect.DeepEqual(submitted, want) {
+		t.Errorf("resubmitted deletions = %v, want %v", submitted, want)
+	}
+	if !reflect.DeepEqual(controller.asyncDeletes.blocked, map[string]int{"tank/csi/.deleting-clones": 5}) {
+		t.Errorf("blocked deletions = %v, want the one whose job failed on dependent clones", controller.asyncDeletes.blocked)
+	}
+
+	// Datasets that disappeared are reported once and forgotten
+	datasets = datasets[:1]
+	controller.reconcileAsyncDeletes(context.Background())
+	if got := len(controller.asyncDeletes.started); got != 1 {
+		t.Errorf("tracked deletions = %d, want 1", got)
+	}
+	if got := len(controller.asyncDeletes.blocked); got != 0 {
+		t.Errorf("blocked deletions = %d, want 0", got)
+	}
+}
+
+func TestDeleteVolumeDatasetAsyncPromotesClones(t *testing.T) {
+	const gib = int64(1 << 30)
+	var calls []string
+	mockClient := &MockAPIClientForSnapshots{
+		QuerySnapshotsWithPropertiesFunc: func(_ context.Context, _ []interface{}) ([]tnsapi.Snapshot, error) {
+			return []tnsapi.Snapshot{{
+				ID:         "tank/csi/pvc-1@volume-source-for-volume-pvc-2",
+				Properties: map[string]interface{}{"clones": map[string]interface{}{"value": "tank/csi/pvc-2"}},
+			}}, nil
+		},
+		PromoteDatasetFunc: func(_ context.Context, datasetID string) error {
+			calls = append(calls, "promote "+datasetID)
+			return nil
+		},
+		GetDatasetWithPropertiesFunc: func(_ context.Context, datasetID string) (*tnsapi.DatasetWithProperties, error) {
+			ds := &tnsapi.DatasetWithProperties{}
+			ds.ID = datasetID
+			ds.Used = map[string]interface{}{"parsed": float64(2048 * gib)}
+			return ds, nil
+		},
+		RenameDatasetFunc: func(_ context.Context, datasetID, _ string) error {
+			calls = append(calls, "rename "+datasetID)
+			return nil
+		},
+		StartDatasetDeleteFunc: func(_ context.Context, datasetID string, _ tnsapi.DatasetDeleteOptions) (int, error) {
+			calls = append(calls, "delete "+datasetID)
+			return 42, nil
+		},
+	}
+	controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+	controller.asyncDeleteMinSize = 1024 * gib
+
+	if err := controller.deleteVolumeDataset(context.Background(), &VolumeMetadata{Name: "pvc-1", DatasetID: "tank/csi/pvc-1"}); err != nil {
+		t.Fatalf("deleteVolumeDataset() error = %v", err)
+	}
+	want := []string{"promote tank/csi/pvc-2", "rename tank/csi/pvc-1", "delete tank/csi/.deleting-pvc-1"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+
+	// Clones that cannot be promoted keep the volume from being marked and renamed
+	calls = nil
+	mockClient.PromoteDatasetFunc = func(_ context.Context, _ string) error { return errors.New("promotion failed") }
+	err := controller.deleteVolumeDataset(context.Background(), &VolumeMetadata{Name: "pvc-1", DatasetID: "tank/csi/pvc-1"})
+	if !isDependentClonesError(err) {
+		t.Errorf("deleteVolumeDataset() error = %v, want dependent clones", err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("calls = %v after a failed promotion, want none", calls)
+	}
+}
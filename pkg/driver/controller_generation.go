@@ -0,0 +1,66 @@
+package driver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Creation generations.
+//
+// CreateVolume takes several storage calls: the dataset, then its NFS or SMB share, NVMe-oF
+// namespace or iSCSI target and extent. When a sidecar retries while an earlier attempt is
+// still running (or two controller replicas briefly both act as leader), both attempts can
+// find the dataset without a share and each create one. Before finalizing, every attempt
+// therefore claims the dataset by writing a random generation to tns-csi:create_generation.
+// After creating its share or namespace, an attempt checks that the property still holds its
+// generation; if a later attempt has claimed the dataset in the meantime, it removes what it
+// created and returns Aborted, leaving the volume to the later attempt. An attempt that finds
+// the dataset already present looks for a share again after claiming, so it returns one an
+// earlier attempt finished instead of creating another.
+
+// newCreateGeneration returns a random creation generation.
+func newCreateGeneration() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b) // never fails (crypto/rand panics on entropy exhaustion)
+	return hex.EncodeToString(b)
+}
+
+// claimCreation marks the dataset as being finalized by this CreateVolume attempt and
+// returns the generation to check with ownsCreation.
+func (s *ControllerService) claimCreation(ctx context.Context, datasetID string) (string, error) {
+	generation := newCreateGeneration()
+	if err := s.apiClient.SetDatasetProperties(ctx, datasetID, map[string]string{
+		tnsapi.PropertyCreateGeneration: generation,
+	}); err != nil {
+		return "", status.Errorf(codes.Internal, "Failed to claim dataset %s for volume creation: %v", datasetID, err)
+	}
+	klog.V(4).Infof("Claimed dataset %s for volume creation (generation %s)", datasetID, generation)
+	return generation, nil
+}
+
+// ownsCreation reports whether the dataset is still claimed by generation. If the property
+// cannot be read the attempt keeps the dataset, as it would have without generations.
+func (s *ControllerService) ownsCreation(ctx context.Context, datasetID, generation string) bool {
+	props, err := s.apiClient.GetDatasetProperties(ctx, datasetID, []string{tnsapi.PropertyCreateGeneration})
+	if err != nil {
+		klog.Warningf("Failed to verify creation generation of dataset %s: %v (assuming ownership)", datasetID, err)
+		return true
+	}
+	current := props[tnsapi.PropertyCreateGeneration]
+	if current != "" && current != generation {
+		klog.Warningf("Dataset %s was claimed by a concurrent CreateVolume (generation %s, ours %s)", datasetID, current, generation)
+		return false
+	}
+	return true
+}
+
+// creationSupersededError is returned by an attempt that lost its claim on the dataset.
+func creationSupersededError(volumeName string) error {
+	return status.Errorf(codes.Aborted, "a concurrent CreateVolume is finalizing volume %s; retry", volumeName)
+}
@@ -0,0 +1,315 @@
+package driver
+
+import (
+	"context"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/klog/v2"
+)
+
+// Volume group snapshots.
+//
+// A VolumeGroupSnapshot of volumes of one volume group (volume_group.go) is a single recursive
+// ZFS snapshot of the group dataset: all volumes are captured in the same transaction group, so
+// the snapshot is crash-consistent across them. Snapshots of group members that were not
+// requested are destroyed right away. Each member snapshot is a regular snapshot of its volume
+// (restorable, listable and deletable on its own); the snapshot of the group dataset itself
+// anchors the group snapshot, whose ID is group:<groupDataset>@<name>.
+
+// groupSnapshotIDPrefix marks group snapshot IDs.
+const groupSnapshotIDPrefix = "group:"
+
+// groupMember is a volume of a group snapshot.
+type groupMember struct {
+	volumeID string
+	protocol string
+	capacity int64
+}
+
+// encodeGroupSnapshotID returns the ID of the group snapshot name of groupDataset.
+func encodeGroupSnapshotID(groupDataset, name string) string {
+	return groupSnapshotIDPrefix + groupDataset + "@" + name
+}
+
+// decodeGroupSnapshotID returns the group dataset and snapshot name of a group snapshot ID.
+func decodeGroupSnapshotID(groupSnapshotID string) (groupDataset, name string, ok bool) {
+	rest, found := strings.CutPrefix(groupSnapshotID, groupSnapshotIDPrefix)
+	if !found {
+		return "", "", false
+	}
+	groupDataset, name, found = strings.Cut(rest, "@")
+	if !found || groupDataset == "" || name == "" {
+		return "", "", false
+	}
+	return groupDataset, name, true
+}
+
+// GroupControllerGetCapabilities returns the group controller capabilities.
+func (s *ControllerService) GroupControllerGetCapabilities(_ context.Context, _ *csi.GroupControllerGetCapabilitiesRequest) (*csi.GroupControllerGetCapabilitiesResponse, error) {
+	return &csi.GroupControllerGetCapabilitiesResponse{
+		Capabilities: []*csi.GroupControllerServiceCapability{
+			{
+				Type: &csi.GroupControllerServiceCapability_Rpc{
+					Rpc: &csi.GroupControllerServiceCapability_RPC{
+						Type: csi.GroupControllerServiceCapability_RPC_CREATE_DELETE_GET_VOLUME_GROUP_SNAPSHOT,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// CreateVolumeGroupSnapshot snapshots volumes of one volume group atomically.
+func (s *ControllerService) CreateVolumeGroupSnapshot(ctx context.Context, req *csi.CreateVolumeGroupSnapshotRequest) (*csi.CreateVolumeGroupSnapshotResponse, error) {
+	name := req.GetName()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Group snapshot name is required")
+	}
+	if len(req.GetSourceVolumeIds()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Source volume IDs are required")
+	}
+
+	release, err := s.snapshotLimit.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	groupDataset, members, err := s.volumeGroupMembers(ctx, req.GetSourceVolumeIds())
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := s.groupSnapshots(ctx, groupDataset, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := snapshots[groupDataset]; exists {
+		// Idempotent retry: the group snapshot must cover the same volumes
+		for _, member := range members {
+			if _, ok := snapshots[member.volumeID]; !ok {
+				return nil, status.Errorf(codes.AlreadyExists, "group snapshot %s already exists for other volumes of %s", name, groupDataset)
+			}
+		}
+		klog.Infof("Group snapshot %s of %s already exists (idempotent)", name, groupDataset)
+	} else {
+		klog.Infof("Creating group snapshot %s of %d volumes in %s", name, len(members), groupDataset)
+		if _, err := s.apiClient.CreateSnapshot(ctx, tnsapi.SnapshotCreateParams{Dataset: groupDataset, Name: name, Recursive: true}); err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to create group snapshot: %v", err)
+		}
+		if snapshots, err = s.groupSnapshots(ctx, groupDataset, name); err != nil {
+			return nil, err
+		}
+		s.dropUnrequestedGroupSnapshots(ctx, groupDataset, members, snapshots)
+	}
+
+	anchor, ok := snapshots[groupDataset]
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "group snapshot %s of %s disappeared after creation", name, groupDataset)
+	}
+	if err := s.apiClient.SetSnapshotProperties(ctx, anchor.ID, map[string]string{tnsapi.PropertyGroupSnapshotName: name}, nil); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to set tracking properties on group snapshot: %v", err)
+	}
+
+	groupSnapshotID := encodeGroupSnapshotID(groupDataset, name)
+	createdAt := snapshotCreatedAt(&anchor)
+	resp := &csi.VolumeGroupSnapshot{
+		GroupSnapshotId: groupSnapshotID,
+		CreationTime:    timestamppb.New(time.Unix(createdAt, 0)),
+		ReadyToUse:      true, // ZFS snapshots are immediately available
+	}
+	for _, member := range members {
+		snapshot := snapshots[member.volumeID]
+		props := s.regularSnapshotProperties(name, member.volumeID, member.protocol)
+		props[tnsapi.PropertyGroupSnapshotName] = name
+		if err := s.apiClient.SetSnapshotProperties(ctx, snapshot.ID, props, nil); err != nil {
+			// Without snapshot_id the deletion guard would not protect the source volume: retry
+			return nil, status.Errorf(codes.Internal, "Failed to set tracking properties on snapshot %s: %v", snapshot.ID, err)
+		}
+		csiSnapshot, err := groupMemberSnapshot(&snapshot, member.protocol, member.capacity, groupSnapshotID)
+		if err != nil {
+			return nil, err
+		}
+		resp.Snapshots = append(resp.Snapshots, csiSnapshot)
+	}
+
+	return &csi.CreateVolumeGroupSnapshotResponse{GroupSnapshot: resp}, nil
+}
+
+// DeleteVolumeGroupSnapshot deletes the snapshots of a group snapshot.
+func (s *ControllerService) DeleteVolumeGroupSnapshot(ctx context.Context, req *csi.DeleteVolumeGroupSnapshotRequest) (*csi.DeleteVolumeGroupSnapshotResponse, error) {
+	if req.GetGroupSnapshotId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Group snapshot ID is required")
+	}
+	groupDataset, name, ok := decodeGroupSnapshotID(req.GetGroupSnapshotId())
+	if !ok {
+		// Not one of ours: nothing to delete
+		klog.Warningf("Ignoring DeleteVolumeGroupSnapshot for unknown group snapshot ID %q", req.GetGroupSnapshotId())
+		return &csi.DeleteVolumeGroupSnapshotResponse{}, nil
+	}
+
+	// Members go through DeleteSnapshot, which knows about clones and holds
+	for _, snapshotID := range req.GetSnapshotIds() {
+		if _, err := s.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: snapshotID}); err != nil {
+			return nil, err
+		}
+	}
+
+	snapshots, err := s.groupSnapshots(ctx, groupDataset, name)
+	if err != nil {
+		return nil, err
+	}
+	for dataset, snapshot := range snapshots {
+		if dataset != groupDataset {
+			klog.Warningf("Deleting snapshot %s of group snapshot %s not listed in the request", snapshot.ID, name)
+		}
+		if err := s.apiClient.DeleteSnapshot(ctx, snapshot.ID); err != nil && !isNotFoundError(err) {
+			return nil, status.Errorf(codes.Internal, "Failed to delete snapshot %s: %v", snapshot.ID, err)
+		}
+	}
+	klog.Infof("Deleted group snapshot %s of %s", name, groupDataset)
+	return &csi.DeleteVolumeGroupSnapshotResponse{}, nil
+}
+
+// GetVolumeGroupSnapshot returns a group snapshot.
+func (s *ControllerService) GetVolumeGroupSnapshot(ctx context.Context, req *csi.GetVolumeGroupSnapshotRequest) (*csi.GetVolumeGroupSnapshotResponse, error) {
+	if req.GetGroupSnapshotId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Group snapshot ID is required")
+	}
+	groupDataset, name, ok := decodeGroupSnapshotID(req.GetGroupSnapshotId())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "group snapshot %s not found", req.GetGroupSnapshotId())
+	}
+	snapshots, err := s.groupSnapshots(ctx, groupDataset, name)
+	if err != nil {
+		return nil, err
+	}
+	anchor, ok := snapshots[groupDataset]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "group snapshot %s not found", req.GetGroupSnapshotId())
+	}
+
+	resp := &csi.VolumeGroupSnapshot{
+		GroupSnapshotId: req.GetGroupSnapshotId(),
+		CreationTime:    timestamppb.New(time.Unix(snapshotCreatedAt(&anchor), 0)),
+		ReadyToUse:      true,
+	}
+	datasets := make([]string, 0, len(snapshots))
+	for dataset := range snapshots {
+		if dataset != groupDataset {
+			datasets = append(datasets, dataset)
+		}
+	}
+	slices.Sort(datasets)
+	for _, dataset := range datasets {
+		snapshot := snapshots[dataset]
+		protocol, _ := tnsapi.GetSnapshotPropertyValue(snapshot, tnsapi.PropertyProtocol)
+		if protocol == "" {
+			continue // Not a CSI snapshot
+		}
+		csiSnapshot, err := groupMemberSnapshot(&snapshot, protocol, 0, req.GetGroupSnapshotId())
+		if err != nil {
+			return nil, err
+		}
+		resp.Snapshots = append(resp.Snapshots, csiSnapshot)
+	}
+	return &csi.GetVolumeGroupSnapshotResponse{GroupSnapshot: resp}, nil
+}
+
+// volumeGroupMembers returns the volume group dataset and the volumes of a group snapshot
+// request. All volumes must be in the same volume group.
+func (s *ControllerService) volumeGroupMembers(ctx context.Context, volumeIDs []string) (string, []groupMember, error) {
+	var groupDataset string
+	members := make([]groupMember, 0, len(volumeIDs))
+	for _, volumeID := range volumeIDs {
+		group := s.volumeGroupOf(ctx, volumeID)
+		if group == "" {
+			return "", nil, status.Errorf(codes.InvalidArgument,
+				"volume %s is not in a volume group: group snapshots need volumes provisioned with %s", volumeID, VolumeGroupParam)
+		}
+		if groupDataset != "" && group != groupDataset {
+			return "", nil, status.Errorf(codes.InvalidArgument,
+				"volumes of a group snapshot must be in one volume group: %s is in %s, not %s", volumeID, group, groupDataset)
+		}
+		groupDataset = group
+
+		dataset, err := s.apiClient.GetDatasetWithProperties(ctx, volumeID)
+		if err != nil {
+			return "", nil, status.Errorf(codes.Internal, "Failed to look up volume %s: %v", volumeID, err)
+		}
+		if dataset == nil {
+			return "", nil, status.Errorf(codes.NotFound, "Source volume %s not found", volumeID)
+		}
+		protocol := dataset.UserProperties[tnsapi.PropertyProtocol].Value
+		if protocol == "" {
+			protocol = ProtocolNFS
+		}
+		members = append(members, groupMember{volumeID: volumeID, protocol: protocol, capacity: datasetCapacityBytes(dataset)})
+	}
+	return groupDataset, members, nil
+}
+
+// groupSnapshots returns the snapshots named name of groupDataset and its descendants, by dataset.
+func (s *ControllerService) groupSnapshots(ctx context.Context, groupDataset, name string) (map[string]tnsapi.Snapshot, error) {
+	found, err := s.apiClient.QuerySnapshotsWithProperties(ctx, []interface{}{
+		[]interface{}{"dataset", "^", groupDataset},
+		[]interface{}{"snapshot_name", "=", name},
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to query snapshots of %s: %v", groupDataset, err)
+	}
+	snapshots := make(map[string]tnsapi.Snapshot, len(found))
+	for _, snapshot := range found {
+		dataset, snapName, _ := strings.Cut(snapshot.ID, "@")
+		if snapName == name && (dataset == groupDataset || path.Dir(dataset) == groupDataset) {
+			snapshots[dataset] = snapshot
+		}
+	}
+	return snapshots, nil
+}
+
+// dropUnrequestedGroupSnapshots destroys the snapshots a recursive group snapshot took of
+// volumes that were not requested.
+func (s *ControllerService) dropUnrequestedGroupSnapshots(ctx context.Context, groupDataset string, members []groupMember, snapshots map[string]tnsapi.Snapshot) {
+	for dataset, snapshot := range snapshots {
+		requested := dataset == groupDataset || slices.ContainsFunc(members, func(m groupMember) bool { return m.volumeID == dataset })
+		if requested {
+			continue
+		}
+		if err := s.apiClient.DeleteSnapshot(ctx, snapshot.ID); err != nil {
+			klog.Warningf("Failed to delete snapshot %s of a volume outside the group snapshot: %v", snapshot.ID, err)
+			continue
+		}
+		delete(snapshots, dataset)
+	}
+}
+
+// groupMemberSnapshot returns the CSI snapshot of a member of a group snapshot.
+func groupMemberSnapshot(snapshot *tnsapi.Snapshot, protocol string, capacity int64, groupSnapshotID string) (*csi.Snapshot, error) {
+	volumeID, _, _ := strings.Cut(snapshot.ID, "@")
+	snapshotID, err := encodeSnapshotID(SnapshotMetadata{
+		SnapshotName: snapshot.ID,
+		SourceVolume: volumeID,
+		DatasetName:  volumeID,
+		Protocol:     protocol,
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to encode snapshot ID: %v", err)
+	}
+	return &csi.Snapshot{
+		SnapshotId:      snapshotID,
+		SourceVolumeId:  volumeID,
+		CreationTime:    timestamppb.New(time.Unix(snapshotCreatedAt(snapshot), 0)),
+		ReadyToUse:      true,
+		SizeBytes:       snapshotSizeBytes(snapshot, capacity),
+		GroupSnapshotId: groupSnapshotID,
+	}, nil
+}
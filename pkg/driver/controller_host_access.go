@@ -0,0 +1,283 @@
+package driver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Host access control.
+//
+// By default NVMe-oF subsystems allow any host and iSCSI targets use an initiator group that may
+// allow any initiator: every host that reaches the portal can connect to every volume. With
+// --host-access-control the controller grants access per node instead. New subsystems are created
+// without allow_any_host; ControllerPublishVolume links the node's host NQN to the volume's
+// subsystem, or adds the node's own initiator group to the volume's target, and
+// ControllerUnpublishVolume removes it again. The node identity comes from the annotations node
+// plugins set on their Node objects (node_identity.go), read through the Node cache
+// (--kube-informers); node plugins of this process are also found in the node registry.
+//
+// iSCSI targets keep the portal/initiator group they were created with until their first
+// publish, which replaces it with the node's group; a target left without groups by its last
+// unpublish gets the first portal again. NVMe-oF subsystems created before the option
+// was enabled keep allow_any_host; the host links are added to them but do not restrict access.
+// ControllerPublishVolume is only called when the CSIDriver has attachRequired: true.
+
+// iscsiNodeInitiatorComment prefixes the comment of per-node iSCSI initiator groups.
+const iscsiNodeInitiatorComment = "tns-csi node "
+
+// nodeIdentity returns the initiator identity a node published; known is false when neither the
+// Node cache nor the node registry has the node.
+func (s *ControllerService) nodeIdentity(nodeID string) (identity NodeIdentity, known bool) {
+	if node, known := s.kubeView.node(nodeID); known {
+		if node == nil {
+			return NodeIdentity{}, false
+		}
+		return NodeIdentity{
+			NodeID:       nodeID,
+			HostNQN:      node.Annotations[NodeAnnotationHostNQN],
+			InitiatorIQN: node.Annotations[NodeAnnotationInitiatorIQN],
+		}, true
+	}
+	if s.nodeRegistry != nil {
+		return s.nodeRegistry.Identity(nodeID)
+	}
+	return NodeIdentity{}, false
+}
+
+// grantHostAccess allows a node to connect to a block volume (--host-access-control).
+func (s *ControllerService) grantHostAccess(ctx context.Context, meta *VolumeMetadata, nodeID string) error {
+	if !s.hostAccessControl || meta == nil || !isBlockProtocol(meta.Protocol) {
+		return nil
+	}
+	identity, known := s.nodeIdentity(nodeID)
+	if !known {
+		return status.Errorf(codes.FailedPrecondition, "identity of node %s is unknown: host access control needs --kube-informers and a node plugin that published its Node annotations", nodeID)
+	}
+
+	switch meta.Protocol {
+	case ProtocolNVMeOF:
+		if identity.HostNQN == "" {
+			return status.Errorf(codes.FailedPrecondition, "node %s has no NVMe host NQN (annotation %s): is nvme-cli installed on the node?", nodeID, NodeAnnotationHostNQN)
+		}
+		return s.allowNVMeOFHost(ctx, meta, identity.HostNQN)
+	case ProtocolISCSI:
+		if identity.InitiatorIQN == "" {
+			return status.Errorf(codes.FailedPrecondition, "node %s has no iSCSI initiator IQN (annotation %s): is open-iscsi installed on the node?", nodeID, NodeAnnotationInitiatorIQN)
+		}
+		return s.allowISCSIInitiator(ctx, meta, nodeID, identity.InitiatorIQN)
+	}
+	return nil
+}
+
+// revokeHostAccess removes the access grantHostAccess gave a node.
+func (s *ControllerService) revokeHostAccess(ctx context.Context, meta *VolumeMetadata, nodeID string) error {
+	if !s.hostAccessControl || meta == nil || !isBlockProtocol(meta.Protocol) {
+		return nil
+	}
+
+	switch meta.Protocol {
+	case ProtocolNVMeOF:
+		identity, known := s.nodeIdentity(nodeID)
+		if !known || identity.HostNQN == "" {
+			// A deleted node's NQN is not reused by other hosts; its link is harmless
+			klog.Warningf("Host NQN of node %s is unknown: its access to volume %s is left on subsystem %d", nodeID, meta.Name, meta.NVMeOFSubsystemID)
+			return nil
+		}
+		return s.disallowNVMeOFHost(ctx, meta, identity.HostNQN)
+	case ProtocolISCSI:
+		return s.disallowISCSIInitiator(ctx, meta, nodeID)
+	}
+	return nil
+}
+
+// volumeSubsystemID returns the NVMe-oF subsystem ID of a volume, looking it up by NQN for
+// volumes whose properties predate the ID.
+func (s *ControllerService) volumeSubsystemID(ctx context.Context, meta *VolumeMetadata) (int, error) {
+	if meta.NVMeOFSubsystemID != 0 {
+		return meta.NVMeOFSubsystemID, nil
+	}
+	subsystem, err := s.apiClient.NVMeOFSubsystemByNQN(ctx, meta.NVMeOFNQN)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "Failed to find NVMe-oF subsystem of volume %s: %v", meta.Name, err)
+	}
+	if subsystem == nil {
+		return 0, status.Errorf(codes.NotFound, "NVMe-oF subsystem %s of volume %s not found", meta.NVMeOFNQN, meta.Name)
+	}
+	return subsystem.ID, nil
+}
+
+// allowNVMeOFHost links a host NQN to the volume's subsystem.
+func (s *ControllerService) allowNVMeOFHost(ctx context.Context, meta *VolumeMetadata, hostNQN string) error {
+	subsystemID, err := s.volumeSubsystemID(ctx, meta)
+	if err != nil {
+		return err
+	}
+	host, err := s.apiClient.NVMeOFHostByNQN(ctx, hostNQN)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to look up NVMe-oF host %s: %v", hostNQN, err)
+	}
+	if host == nil {
+		if host, err = s.apiClient.CreateNVMeOFHost(ctx, hostNQN); err != nil {
+			return status.Errorf(codes.Internal, "Failed to register NVMe-oF host %s: %v", hostNQN, err)
+		}
+	}
+
+	links, err := s.apiClient.QueryNVMeOFHostSubsystems(ctx, subsystemID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to query hosts of NVMe-oF subsystem %d: %v", subsystemID, err)
+	}
+	for i := range links {
+		if links[i].GetHostID() == host.ID {
+			return nil
+		}
+	}
+	if err := s.apiClient.AddHostToSubsystem(ctx, host.ID, subsystemID); err != nil {
+		return status.Errorf(codes.Internal, "Failed to allow host %s on NVMe-oF subsystem %d: %v", hostNQN, subsystemID, err)
+	}
+	klog.Infof("Allowed NVMe host %s on subsystem %d (volume %s)", hostNQN, subsystemID, meta.Name)
+	return nil
+}
+
+// disallowNVMeOFHost removes a host NQN's link to the volume's subsystem.
+func (s *ControllerService) disallowNVMeOFHost(ctx context.Context, meta *VolumeMetadata, hostNQN string) error {
+	subsystemID, err := s.volumeSubsystemID(ctx, meta)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	links, err := s.apiClient.QueryNVMeOFHostSubsystems(ctx, subsystemID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to query hosts of NVMe-oF subsystem %d: %v", subsystemID, err)
+	}
+	for i := range links {
+		if links[i].Host.HostNQN != hostNQN {
+			continue
+		}
+		if err := s.apiClient.RemoveHostFromSubsystem(ctx, links[i].ID); err != nil {
+			return status.Errorf(codes.Internal, "Failed to remove host %s from NVMe-oF subsystem %d: %v", hostNQN, subsystemID, err)
+		}
+		klog.Infof("Removed NVMe host %s from subsystem %d (volume %s)", hostNQN, subsystemID, meta.Name)
+	}
+	return nil
+}
+
+// nodeInitiatorGroup returns the node's iSCSI initiator group among groups (nil = none).
+func nodeInitiatorGroup(groups []tnsapi.ISCSIInitiator, nodeID string) *tnsapi.ISCSIInitiator {
+	for i := range groups {
+		if groups[i].Comment == iscsiNodeInitiatorComment+nodeID {
+			return &groups[i]
+		}
+	}
+	return nil
+}
+
+// volumeTarget returns the iSCSI target of a volume.
+func (s *ControllerService) volumeTarget(ctx context.Context, meta *VolumeMetadata) (*tnsapi.ISCSITarget, error) {
+	targets, err := s.apiClient.QueryISCSITargets(ctx, []interface{}{[]interface{}{"id", "=", meta.ISCSITargetID}})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to query iSCSI target %d of volume %s: %v", meta.ISCSITargetID, meta.Name, err)
+	}
+	if len(targets) == 0 {
+		return nil, status.Errorf(codes.NotFound, "iSCSI target %d of volume %s not found", meta.ISCSITargetID, meta.Name)
+	}
+	return &targets[0], nil
+}
+
+// nodeGroupIDs returns the IDs of the per-node initiator groups.
+func nodeGroupIDs(groups []tnsapi.ISCSIInitiator) map[int]bool {
+	ids := make(map[int]bool)
+	for i := range groups {
+		if strings.HasPrefix(groups[i].Comment, iscsiNodeInitiatorComment) {
+			ids[groups[i].ID] = true
+		}
+	}
+	return ids
+}
+
+// allowISCSIInitiator adds the node's initiator group to the volume's target, creating the group
+// for the node's IQN. Groups of other initiator groups (the one the target was created with) are
+// replaced. A target left without groups by unpublishing gets the first portal.
+func (s *ControllerService) allowISCSIInitiator(ctx context.Context, meta *VolumeMetadata, nodeID, iqn string) error {
+	target, err := s.volumeTarget(ctx, meta)
+	if err != nil {
+		return err
+	}
+	allGroups, err := s.apiClient.QueryISCSIInitiators(ctx)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to query iSCSI initiator groups: %v", err)
+	}
+	group := nodeInitiatorGroup(allGroups, nodeID)
+	switch {
+	case group == nil:
+		group, err = s.apiClient.CreateISCSIInitiator(ctx, tnsapi.ISCSIInitiatorCreateParams{Comment: iscsiNodeInitiatorComment + nodeID, Initiators: []string{iqn}})
+		if err != nil {
+			return status.Errorf(codes.Internal, "Failed to create iSCSI initiator group of node %s: %v", nodeID, err)
+		}
+	case len(group.Initiators) != 1 || group.Initiators[0] != iqn:
+		return status.Errorf(codes.FailedPrecondition, "iSCSI initiator group %d of node %s lists %v, not the node's IQN %s: delete it to have it recreated",
+			group.ID, nodeID, group.Initiators, iqn)
+	}
+	nodeGroups := nodeGroupIDs(allGroups)
+
+	var portal int
+	if len(target.Groups) > 0 {
+		portal = target.Groups[0].Portal
+	} else if portal, _, err = s.resolveISCSIPortalAndInitiator(ctx, 0, group.ID); err != nil {
+		return err
+	}
+	groups := make([]tnsapi.ISCSITargetGroup, 0, len(target.Groups)+1)
+	for _, g := range target.Groups {
+		if g.Initiator == group.ID {
+			return nil
+		}
+		if nodeGroups[g.Initiator] {
+			groups = append(groups, g)
+		}
+	}
+	groups = append(groups, tnsapi.ISCSITargetGroup{Portal: portal, Initiator: group.ID})
+	if _, err := s.apiClient.UpdateISCSITargetGroups(ctx, target.ID, groups); err != nil {
+		return status.Errorf(codes.Internal, "Failed to allow node %s on iSCSI target %s: %v", nodeID, target.Name, err)
+	}
+	klog.Infof("Allowed initiator %s of node %s on iSCSI target %s", iqn, nodeID, target.Name)
+	return nil
+}
+
+// disallowISCSIInitiator removes the node's initiator group from the volume's target.
+func (s *ControllerService) disallowISCSIInitiator(ctx context.Context, meta *VolumeMetadata, nodeID string) error {
+	allGroups, err := s.apiClient.QueryISCSIInitiators(ctx)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to query iSCSI initiator groups: %v", err)
+	}
+	group := nodeInitiatorGroup(allGroups, nodeID)
+	if group == nil {
+		return nil
+	}
+	target, err := s.volumeTarget(ctx, meta)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	groups := make([]tnsapi.ISCSITargetGroup, 0, len(target.Groups))
+	for _, g := range target.Groups {
+		if g.Initiator != group.ID {
+			groups = append(groups, g)
+		}
+	}
+	if len(groups) == len(target.Groups) {
+		return nil
+	}
+	if _, err := s.apiClient.UpdateISCSITargetGroups(ctx, target.ID, groups); err != nil {
+		return status.Errorf(codes.Internal, "Failed to remove node %s from iSCSI target %s: %v", nodeID, target.Name, err)
+	}
+	klog.Infof("Removed node %s from iSCSI target %s", nodeID, target.Name)
+	return nil
+}
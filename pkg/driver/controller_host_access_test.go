@@ -0,0 +1,215 @@
+package driver
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHostAccessControlNVMeOFIntegration(t *testing.T) {
+	controller, _ := newIntegrationController(t)
+	controller.hostAccessControl = true
+	ctx := context.Background()
+	const hostNQN = "nqn.2014-08.org.nvmexpress:uuid:node-1"
+	controller.nodeRegistry.RegisterIdentity(NodeIdentity{NodeID: "node-1", HostNQN: hostNQN})
+	controller.nodeRegistry.RegisterIdentity(NodeIdentity{NodeID: "node-2"})
+
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	created, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "pvc-acl",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: []*csi.VolumeCapability{capability},
+		Parameters:         map[string]string{"protocol": ProtocolNVMeOF, "pool": "tank", "server": "truenas.local"},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	volumeID := created.GetVolume().GetVolumeId()
+	meta, err := controller.lookupVolumeByCSIName(ctx, "", volumeID)
+	if err != nil || meta == nil {
+		t.Fatalf("lookupVolumeByCSIName() = %v, %v", meta, err)
+	}
+	hosts := func() []string {
+		t.Helper()
+		links, err := controller.apiClient.QueryNVMeOFHostSubsystems(ctx, meta.NVMeOFSubsystemID)
+		if err != nil {
+			t.Fatalf("QueryNVMeOFHostSubsystems() error = %v", err)
+		}
+		nqns := make([]string, 0, len(links))
+		for _, link := range links {
+			nqns = append(nqns, link.Host.HostNQN)
+		}
+		return nqns
+	}
+
+	publish := func(nodeID string) error {
+		_, err := controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{VolumeId: volumeID, NodeId: nodeID, VolumeCapability: capability})
+		return err
+	}
+	if err := publish("node-1"); err != nil {
+		t.Fatalf("ControllerPublishVolume(node-1) error = %v", err)
+	}
+	if got := hosts(); len(got) != 1 || got[0] != hostNQN {
+		t.Fatalf("subsystem hosts after publish = %v, want [%s]", got, hostNQN)
+	}
+	if err := publish("node-2"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("ControllerPublishVolume(node without host NQN) error = %v, want FailedPrecondition", err)
+	}
+
+	if _, err := controller.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: volumeID, NodeId: "node-1"}); err != nil {
+		t.Fatalf("ControllerUnpublishVolume() error = %v", err)
+	}
+	if got := hosts(); len(got) != 0 {
+		t.Errorf("subsystem hosts after unpublish = %v, want none", got)
+	}
+	if _, err := controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+		t.Fatalf("DeleteVolume() error = %v", err)
+	}
+}
+
+func TestNodeIdentityFromAnnotations(t *testing.T) {
+	node := testNode("node-1", "10.0.0.1", corev1.ConditionTrue)
+	node.Annotations = map[string]string{
+		NodeAnnotationHostNQN:      "nqn.2014-08.org.nvmexpress:uuid:node-1",
+		NodeAnnotationInitiatorIQN: "iqn.1993-08.org.debian:01:node-1",
+	}
+	controller := NewControllerService(&mockAPIClient{}, NewNodeRegistry(), "")
+	// The registry only knows node plugins of this process; the Node cache wins
+	controller.nodeRegistry.RegisterIdentity(NodeIdentity{NodeID: "node-2", HostNQN: "stale"})
+	controller.kubeView = newTestClusterView(t, fake.NewClientset(node))
+
+	identity, known := controller.nodeIdentity("node-1")
+	if !known || identity.HostNQN != node.Annotations[NodeAnnotationHostNQN] || identity.InitiatorIQN != node.Annotations[NodeAnnotationInitiatorIQN] {
+		t.Errorf("nodeIdentity(node-1) = %+v, %v; want the Node annotations", identity, known)
+	}
+	if identity, known := controller.nodeIdentity("node-2"); known {
+		t.Errorf("nodeIdentity(node-2) = %+v, want unknown for a node without a Node object", identity)
+	}
+}
+
+// iscsiACLClient keeps the initiator groups and target groups host access control changes.
+type iscsiACLClient struct {
+	mockAPIClient
+	initiators []tnsapi.ISCSIInitiator
+	target     tnsapi.ISCSITarget
+}
+
+func (c *iscsiACLClient) QueryISCSIInitiators(_ context.Context) ([]tnsapi.ISCSIInitiator, error) {
+	return c.initiators, nil
+}
+
+func (c *iscsiACLClient) CreateISCSIInitiator(_ context.Context, params tnsapi.ISCSIInitiatorCreateParams) (*tnsapi.ISCSIInitiator, error) {
+	group := tnsapi.ISCSIInitiator{ID: len(c.initiators) + 1, Comment: params.Comment, Initiators: params.Initiators}
+	c.initiators = append(c.initiators, group)
+	return &group, nil
+}
+
+func (c *iscsiACLClient) QueryISCSITargets(_ context.Context, _ []interface{}) ([]tnsapi.ISCSITarget, error) {
+	return []tnsapi.ISCSITarget{c.target}, nil
+}
+
+func (c *iscsiACLClient) UpdateISCSITargetGroups(_ context.Context, _ int, groups []tnsapi.ISCSITargetGroup) (*tnsapi.ISCSITarget, error) {
+	c.target.Groups = groups
+	return &c.target, nil
+}
+
+func TestHostAccessControlISCSI(t *testing.T) {
+	client := &iscsiACLClient{
+		initiators: []tnsapi.ISCSIInitiator{{ID: 1, Comment: "Allow all initiators", Initiators: []string{}}},
+		target:     tnsapi.ISCSITarget{ID: 7, Name: "pvc-1", Groups: []tnsapi.ISCSITargetGroup{{Portal: 3, Initiator: 1}}},
+	}
+	controller := NewControllerService(client, NewNodeRegistry(), "")
+	controller.hostAccessControl = true
+	controller.nodeRegistry.RegisterIdentity(NodeIdentity{NodeID: "node-1", InitiatorIQN: "iqn.1993-08.org.debian:01:node-1"})
+	controller.nodeRegistry.RegisterIdentity(NodeIdentity{NodeID: "node-2", InitiatorIQN: "iqn.1993-08.org.debian:01:node-2"})
+	meta := &VolumeMetadata{Name: "pvc-1", Protocol: ProtocolISCSI, ISCSITargetID: 7}
+	ctx := context.Background()
+
+	for _, nodeID := range []string{"node-1", "node-2", "node-1"} {
+		if err := controller.grantHostAccess(ctx, meta, nodeID); err != nil {
+			t.Fatalf("grantHostAccess(%s) error = %v", nodeID, err)
+		}
+	}
+	// The allow-all group the target was created with is gone; each node has its own group
+	want := []tnsapi.ISCSITargetGroup{{Portal: 3, Initiator: 2}, {Portal: 3, Initiator: 3}}
+	if len(client.target.Groups) != len(want) || client.target.Groups[0] != want[0] || client.target.Groups[1] != want[1] {
+		t.Fatalf("target groups = %+v, want %+v", client.target.Groups, want)
+	}
+	if group := nodeInitiatorGroup(client.initiators, "node-2"); group == nil || group.Initiators[0] != "iqn.1993-08.org.debian:01:node-2" {
+		t.Errorf("initiator group of node-2 = %+v", group)
+	}
+
+	if err := controller.revokeHostAccess(ctx, meta, "node-1"); err != nil {
+		t.Fatalf("revokeHostAccess() error = %v", err)
+	}
+	if len(client.target.Groups) != 1 || client.target.Groups[0].Initiator != 3 {
+		t.Errorf("target groups after revoking node-1 = %+v, want only node-2's", client.target.Groups)
+	}
+
+	controller.nodeRegistry.RegisterIdentity(NodeIdentity{NodeID: "node-3"})
+	if err := controller.grantHostAccess(ctx, meta, "node-3"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("grantHostAccess(node without IQN) error = %v, want FailedPrecondition", err)
+	}
+}
+
+func TestHostAccessControlDisabled(t *testing.T) {
+	controller := NewControllerService(&mockAPIClient{}, NewNodeRegistry(), "")
+	meta := &VolumeMetadata{Name: "pvc-1", Protocol: ProtocolNVMeOF, NVMeOFSubsystemID: 1}
+	// Without the option nothing is looked up: mockAPIClient fails every host access call
+	if err := controller.grantHostAccess(context.Background(), meta, "unknown-node"); err != nil {
+		t.Errorf("grantHostAccess() without --host-access-control error = %v", err)
+	}
+	if err := controller.revokeHostAccess(context.Background(), meta, "unknown-node"); err != nil {
+		t.Errorf("revokeHostAccess() without --host-access-control error = %v", err)
+	}
+}
+
+func TestPublishNodeInfo(t *testing.T) {
+	kube := fake.NewClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node-1",
+		Annotations: map[string]string{NodeAnnotationInitiatorIQN: "iqn.stale", "other": "kept"},
+		Labels:      map[string]string{NodeProtocolLabelPrefix + ProtocolISCSI: "true", "other": "kept"},
+	}})
+	service := NewNodeService("node-1", nil, true, nil, false, 5)
+	service.kube = kube
+
+	service.publishNodeInfo(context.Background(), NodeIdentity{
+		NodeID:    "node-1",
+		HostNQN:   "nqn.2014-08.org.nvmexpress:uuid:node-1",
+		Protocols: []string{ProtocolNFS, ProtocolNVMeOF},
+	})
+
+	node, err := kube.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := node.Annotations[NodeAnnotationHostNQN]; got != "nqn.2014-08.org.nvmexpress:uuid:node-1" {
+		t.Errorf("host NQN annotation = %q", got)
+	}
+	if got, ok := node.Annotations[NodeAnnotationInitiatorIQN]; ok {
+		t.Errorf("initiator IQN annotation = %q, want it removed for a node without open-iscsi", got)
+	}
+	if node.Annotations["other"] != "kept" {
+		t.Errorf("unrelated annotations = %v, want them kept", node.Annotations)
+	}
+	for _, protocol := range allProtocols {
+		want := strconv.FormatBool(protocol == ProtocolNFS || protocol == ProtocolNVMeOF)
+		if got := node.Labels[NodeProtocolLabelPrefix+protocol]; got != want {
+			t.Errorf("label %s = %q, want %q", NodeProtocolLabelPrefix+protocol, got, want)
+		}
+	}
+	if node.Labels["other"] != "kept" {
+		t.Errorf("unrelated labels = %v, want them kept", node.Labels)
+	}
+}
@@ -0,0 +1,292 @@
+package driver
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"github.com/fenio/tns-csi/pkg/tnsapi/tnsapitest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newIntegrationController returns a controller wired to an in-process TrueNAS API server.
+func newIntegrationController(t *testing.T) (*ControllerService, *tnsapitest.Server) {
+	t.Helper()
+	srv := tnsapitest.NewServer()
+	client, err := tnsapi.NewClient(srv.URL(), tnsapitest.APIKey, false)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		srv.Close()
+	})
+	return NewControllerService(client, NewNodeRegistry(), ""), srv
+}
+
+func TestControllerVolumeLifecycleIntegration(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]string
+		block  bool
+	}{
+		{name: "nfs", params: map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local"}},
+		{name: "nvmeof", params: map[string]string{"protocol": ProtocolNVMeOF, "pool": "tank", "server": "truenas.local"}, block: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, srv := newIntegrationController(t)
+			ctx := context.Background()
+
+			capability := &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}
+			if tt.block {
+				capability.AccessType = &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+			}
+			req := &csi.CreateVolumeRequest{
+				Name:               "pvc-" + tt.name,
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+				VolumeCapabilities: []*csi.VolumeCapability{capability},
+				Parameters:         tt.params,
+			}
+
+			created, err := controller.CreateVolume(ctx, req)
+			if err != nil {
+				t.Fatalf("CreateVolume() error = %v", err)
+			}
+			volumeID := created.GetVolume().GetVolumeId()
+
+			again, err := controller.CreateVolume(ctx, req)
+			if err != nil || again.GetVolume().GetVolumeId() != volumeID {
+				t.Fatalf("repeated CreateVolume() = %v, %v; want volume %s", again.GetVolume(), err, volumeID)
+			}
+
+			if _, err := controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+				t.Fatalf("DeleteVolume() error = %v", err)
+			}
+			for kind, n := range srv.Counts() {
+				if n != 0 {
+					t.Errorf("%d %s left on TrueNAS after DeleteVolume", n, kind)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteVolumePromotesDependentClonesIntegration(t *testing.T) {
+	controller, srv := newIntegrationController(t)
+	ctx := context.Background()
+
+	params := map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local"}
+	capabilities := []*csi.VolumeCapability{{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}}
+	source, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "pvc-source",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: capabilities,
+		Parameters:         params,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume(source) error = %v", err)
+	}
+	sourceID := source.GetVolume().GetVolumeId()
+
+	// Default COW clone: depends on a temporary snapshot of the source
+	clone, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "pvc-clone",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: capabilities,
+		Parameters:         params,
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: sourceID}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume(clone) error = %v", err)
+	}
+	cloneID := clone.GetVolume().GetVolumeId()
+
+	before := len(srv.Calls())
+	if _, err := controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: sourceID}); err != nil {
+		t.Fatalf("DeleteVolume(source) error = %v", err)
+	}
+	if srv.DatasetExists(sourceID) {
+		t.Errorf("source dataset %s still exists", sourceID)
+	}
+	// The clone is promoted before the source is deleted, not after a failed attempt
+	var calls []string
+	for _, method := range srv.Calls()[before:] {
+		if method == "pool.dataset.promote" || method == "pool.dataset.delete" {
+			calls = append(calls, method)
+		}
+	}
+	if want := []string{"pool.dataset.promote", "pool.dataset.delete"}; !slices.Equal(calls, want) {
+		t.Errorf("DeleteVolume(source) called %v, want %v", calls, want)
+	}
+	if !srv.DatasetExists(cloneID) {
+		t.Fatalf("clone dataset %s was destroyed with its source", cloneID)
+	}
+
+	if _, err := controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: cloneID}); err != nil {
+		t.Fatalf("DeleteVolume(clone) error = %v", err)
+	}
+	for kind, n := range srv.Counts() {
+		if n != 0 {
+			t.Errorf("%d %s left on TrueNAS after deleting both volumes", n, kind)
+		}
+	}
+}
+
+func TestDeleteVolumeCloneDeletesSourceSnapshotIntegration(t *testing.T) {
+	controller, srv := newIntegrationController(t)
+	ctx := context.Background()
+
+	params := map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local"}
+	capabilities := []*csi.VolumeCapability{{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}}
+	source, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "pvc-source",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: capabilities,
+		Parameters:         params,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume(source) error = %v", err)
+	}
+	sourceID := source.GetVolume().GetVolumeId()
+
+	clone, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "pvc-clone",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: capabilities,
+		Parameters:         params,
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: sourceID}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume(clone) error = %v", err)
+	}
+	if n := srv.Counts()["snapshots"]; n != 1 {
+		t.Fatalf("%d snapshots after cloning, want the clone's source snapshot", n)
+	}
+
+	// The clone's source snapshot goes with the clone; the source volume stays untouched
+	if _, err := controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: clone.GetVolume().GetVolumeId()}); err != nil {
+		t.Fatalf("DeleteVolume(clone) error = %v", err)
+	}
+	if n := srv.Counts()["snapshots"]; n != 0 {
+		t.Errorf("%d snapshots left on the source after deleting the clone", n)
+	}
+	if !srv.DatasetExists(sourceID) {
+		t.Errorf("source dataset %s was deleted with the clone", sourceID)
+	}
+}
+
+func TestDeleteVolumeRecursiveDeleteOptOutIntegration(t *testing.T) {
+	controller, srv := newIntegrationController(t)
+	ctx := context.Background()
+
+	created, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:          "pvc-keep-children",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}},
+		Parameters: map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local", RecursiveDeleteParam: "false"},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	volumeID := created.GetVolume().GetVolumeId()
+
+	child := volumeID + "/manual"
+	if _, err := controller.apiClient.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: child, Type: "FILESYSTEM"}); err != nil {
+		t.Fatalf("CreateDataset(%s) error = %v", child, err)
+	}
+	snapshot, err := controller.apiClient.CreateSnapshot(ctx, tnsapi.SnapshotCreateParams{Dataset: volumeID, Name: "before-upgrade"})
+	if err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+
+	_, err = controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+	if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), child) || !strings.Contains(err.Error(), snapshot.ID) {
+		t.Fatalf("DeleteVolume() error = %v, want FailedPrecondition listing %s and %s", err, child, snapshot.ID)
+	}
+	if !srv.DatasetExists(volumeID) || !srv.DatasetExists(child) {
+		t.Fatal("DeleteVolume() removed datasets although recursiveDelete=false")
+	}
+
+	if err := controller.apiClient.DeleteDataset(ctx, child); err != nil {
+		t.Fatalf("DeleteDataset(%s) error = %v", child, err)
+	}
+	if err := controller.apiClient.DeleteSnapshot(ctx, snapshot.ID); err != nil {
+		t.Fatalf("DeleteSnapshot() error = %v", err)
+	}
+	if _, err := controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+		t.Fatalf("DeleteVolume() without children error = %v", err)
+	}
+	if srv.DatasetExists(volumeID) {
+		t.Errorf("dataset %s still exists", volumeID)
+	}
+}
+
+func TestNFSCapacityFromPropertyIntegration(t *testing.T) {
+	controller, _ := newIntegrationController(t)
+	ctx := context.Background()
+
+	req := &csi.CreateVolumeRequest{
+		Name:          "pvc-capacity",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}},
+		Parameters: map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local"},
+	}
+	created, err := controller.CreateVolume(ctx, req)
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	volumeID := created.GetVolume().GetVolumeId()
+
+	// An admin rewrites the share comment; the capacity is tracked in the dataset's properties
+	shares, err := controller.apiClient.QueryAllNFSShares(ctx, "")
+	if err != nil || len(shares) != 1 {
+		t.Fatalf("QueryAllNFSShares() = %v, %v; want one share", shares, err)
+	}
+	if _, err := controller.apiClient.UpdateNFSShare(ctx, shares[0].ID, tnsapi.NFSShareUpdateParams{Comment: "CSI Volume: x | Capacity: 42 | backups"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := controller.CreateVolume(ctx, req); err != nil {
+		t.Fatalf("CreateVolume() after editing the share comment error = %v", err)
+	}
+
+	if _, err := controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      volumeID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 2 << 30},
+	}); err != nil {
+		t.Fatalf("ControllerExpandVolume() error = %v", err)
+	}
+	props, err := controller.apiClient.GetDatasetProperties(ctx, volumeID, []string{tnsapi.PropertyCapacityBytes})
+	if err != nil || props[tnsapi.PropertyCapacityBytes] != strconv.Itoa(2<<30) {
+		t.Fatalf("%s after expansion = %v, %v; want %d", tnsapi.PropertyCapacityBytes, props, err, 2<<30)
+	}
+	req.CapacityRange.RequiredBytes = 2 << 30
+	if _, err := controller.CreateVolume(ctx, req); err != nil {
+		t.Errorf("CreateVolume() of the expanded size error = %v", err)
+	}
+}
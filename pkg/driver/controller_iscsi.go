@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/fenio/tns-csi/pkg/metrics"
@@ -19,23 +18,13 @@ import (
 
 // iscsiVolumeParams holds validated parameters for iSCSI volume creation.
 type iscsiVolumeParams struct {
-	zfsProps          *zfsZvolProperties
-	encryption        *encryptionConfig
-	volumeName        string
-	deleteStrategy    string
-	storageClass      string
-	zvolName          string
-	targetIQN         string
-	pvcNamespace      string
-	pvcName           string
-	parentDataset     string
-	comment           string
-	server            string
-	pool              string
-	initiatorID       int
-	portalID          int
-	requestedCapacity int64
-	markAdoptable     bool
+	volumeParams
+	zfsProps    *zfsZvolProperties
+	targetIQN   string
+	initiatorID int
+	portalID    int
+	// grownExisting is set once an existing ZVOL was grown: its filesystem must be grown on stage
+	grownExisting bool
 }
 
 // generateIQN creates a unique IQN for a volume's dedicated iSCSI target.
@@ -45,147 +34,75 @@ func generateIQN(volumeName string) string {
 }
 
 // validateISCSIParams validates and extracts iSCSI volume parameters from the request.
-func validateISCSIParams(req *csi.CreateVolumeRequest) (*iscsiVolumeParams, error) {
-	params := req.GetParameters()
-
-	pool := params["pool"]
-	if pool == "" {
-		return nil, status.Error(codes.InvalidArgument, "pool parameter is required for iSCSI volumes")
+func (s iscsiController) validateISCSIParams(req *csi.CreateVolumeRequest) (*iscsiVolumeParams, error) {
+	common, err := s.parseVolumeParams(s, req)
+	if err != nil {
+		return nil, err
 	}
-
-	server := params["server"]
-	if server == "" {
-		return nil, status.Error(codes.InvalidArgument, "server parameter is required for iSCSI volumes")
+	portalID, initiatorID, err := parseISCSIGroupIDs(req.GetParameters())
+	if err != nil {
+		return nil, err
 	}
 
-	parentDataset := params["parentDataset"]
-	if parentDataset == "" {
-		parentDataset = pool
-	}
+	return &iscsiVolumeParams{
+		volumeParams: *common,
+		zfsProps:     parseZFSZvolProperties(common.zfsParams),
+		targetIQN:    generateIQN(common.volumeName),
+		portalID:     portalID,
+		initiatorID:  initiatorID,
+	}, nil
+}
 
-	// Extract portal ID if specified (optional - will use first available if not specified)
-	var portalID int
+// parseISCSIGroupIDs extracts the optional portal and initiator group IDs. Zero means the
+// first available group is used.
+func parseISCSIGroupIDs(params map[string]string) (portalID, initiatorID int, err error) {
 	if portalIDStr := params["portalId"]; portalIDStr != "" {
-		var err error
 		portalID, err = strconv.Atoi(portalIDStr)
 		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "Invalid portalId '%s': %v", portalIDStr, err)
+			return 0, 0, status.Errorf(codes.InvalidArgument, "Invalid portalId '%s': %v", portalIDStr, err)
 		}
 	}
-
-	// Extract initiator ID if specified (optional - will use first available if not specified)
-	var initiatorID int
 	if initiatorIDStr := params["initiatorId"]; initiatorIDStr != "" {
-		var err error
 		initiatorID, err = strconv.Atoi(initiatorIDStr)
 		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "Invalid initiatorId '%s': %v", initiatorIDStr, err)
+			return 0, 0, status.Errorf(codes.InvalidArgument, "Invalid initiatorId '%s': %v", initiatorIDStr, err)
 		}
 	}
-
-	// Resolve volume name using templating (if configured in StorageClass)
-	volumeName, err := ResolveVolumeName(params, req.GetName())
-	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve volume name: %v", err)
-	}
-	zvolName := parentDataset + "/" + volumeName
-
-	// Resolve dataset comment from commentTemplate (if configured in StorageClass)
-	comment, err := ResolveComment(params, req.GetName())
-	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve comment template: %v", err)
-	}
-
-	// Get delete strategy (default: delete)
-	deleteStrategy := params["deleteStrategy"]
-	if deleteStrategy == "" {
-		deleteStrategy = verbDelete
-	}
-
-	// Check if volume should be marked as adoptable
-	markAdoptable := params["markAdoptable"] == VolumeContextValueTrue
-
-	// Get capacity
-	capacityRange := req.GetCapacityRange()
-	var requestedCapacity int64
-	if capacityRange != nil {
-		requestedCapacity = capacityRange.GetRequiredBytes()
-	}
-	if requestedCapacity == 0 {
-		requestedCapacity = 1 * 1024 * 1024 * 1024 // Default 1GB
-	}
-
-	// Parse ZFS ZVOL properties from StorageClass parameters
-	zfsProps := parseZFSZvolProperties(params)
-
-	// Parse encryption configuration
-	encryptionConf := parseEncryptionConfig(params, req.GetSecrets())
-
-	// Extract adoption metadata from CSI parameters
-	pvcName := params["csi.storage.k8s.io/pvc/name"]
-	pvcNamespace := params["csi.storage.k8s.io/pvc/namespace"]
-	storageClass := params["csi.storage.k8s.io/sc/name"]
-
-	return &iscsiVolumeParams{
-		requestedCapacity: requestedCapacity,
-		pool:              pool,
-		server:            server,
-		parentDataset:     parentDataset,
-		volumeName:        volumeName,
-		zvolName:          zvolName,
-		targetIQN:         generateIQN(volumeName),
-		portalID:          portalID,
-		initiatorID:       initiatorID,
-		deleteStrategy:    deleteStrategy,
-		markAdoptable:     markAdoptable,
-		zfsProps:          zfsProps,
-		encryption:        encryptionConf,
-		comment:           comment,
-		pvcName:           pvcName,
-		pvcNamespace:      pvcNamespace,
-		storageClass:      storageClass,
-	}, nil
+	return portalID, initiatorID, nil
 }
 
 // buildISCSIVolumeResponse constructs a CSI CreateVolumeResponse for an iSCSI volume.
 func buildISCSIVolumeResponse(volumeName, server, targetIQN string, zvol *tnsapi.Dataset, target *tnsapi.ISCSITarget, extent *tnsapi.ISCSIExtent, capacity int64) *csi.CreateVolumeResponse {
-	meta := VolumeMetadata{
-		Name:          volumeName,
-		Protocol:      ProtocolISCSI,
-		DatasetID:     zvol.ID,
-		DatasetName:   zvol.Name,
-		Server:        server,
-		ISCSITargetID: target.ID,
-		ISCSIExtentID: extent.ID,
-		ISCSIIQN:      targetIQN,
-	}
-
-	// Volume ID is the full dataset path for O(1) lookups (e.g., "pool/parent/pvc-xxx")
-	volumeID := zvol.ID
-
-	// Build volume context with all necessary metadata
-	volumeContext := buildVolumeContext(meta)
-	volumeContext[VolumeContextKeyExpectedCapacity] = strconv.FormatInt(capacity, 10)
-
-	// Record volume capacity metric
-	metrics.SetVolumeCapacity(volumeID, metrics.ProtocolISCSI, capacity)
+	export := iscsiExport(zvol, &volumeParams{volumeName: volumeName, server: server}, target, extent, targetIQN)
+	export.context[VolumeContextKeyExpectedCapacity] = strconv.FormatInt(capacity, 10)
+	return export.response(capacity)
+}
 
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      volumeID,
-			CapacityBytes: capacity,
-			VolumeContext: volumeContext,
+// iscsiExport describes a ZVOL exported as the LUN of a dedicated iSCSI target.
+func iscsiExport(zvol *tnsapi.Dataset, params *volumeParams, target *tnsapi.ISCSITarget, extent *tnsapi.ISCSIExtent, fullIQN string) *volumeExport {
+	return &volumeExport{
+		meta: VolumeMetadata{
+			Name:          params.volumeName,
+			Protocol:      ProtocolISCSI,
+			DatasetID:     zvol.ID,
+			DatasetName:   zvol.Name,
+			Server:        params.server,
+			ISCSITargetID: target.ID,
+			ISCSIExtentID: extent.ID,
+			ISCSIIQN:      fullIQN,
 		},
+		context:    map[string]string{},
+		properties: tnsapi.ISCSITargetProperties(target.ID, extent.ID, fullIQN),
 	}
 }
 
-// createISCSIVolume creates an iSCSI volume (ZVOL + extent + target + target-extent).
-func (s *ControllerService) createISCSIVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+// Provision creates an iSCSI volume (ZVOL + extent + target + target-extent).
+func (s iscsiController) Provision(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolISCSI, "create")
 	klog.V(4).Info("Creating iSCSI volume")
 
 	// Validate and extract parameters
-	params, err := validateISCSIParams(req)
+	params, err := s.validateISCSIParams(req)
 	if err != nil {
 		timer.ObserveError()
 		return nil, err
@@ -202,7 +119,7 @@ func (s *ControllerService) createISCSIVolume(ctx context.Context, req *csi.Crea
 		params.volumeName, params.requestedCapacity, globalConfig.Basename)
 
 	// Check if ZVOL already exists (idempotency)
-	existingZvols, err := s.apiClient.QueryAllDatasets(ctx, params.zvolName)
+	existingZvols, err := s.apiClient.QueryAllDatasets(ctx, params.datasetName)
 	if err != nil {
 		timer.ObserveError()
 		return nil, status.Errorf(codes.Internal, "Failed to query existing ZVOLs: %v", err)
@@ -226,6 +143,23 @@ func (s *ControllerService) createISCSIVolume(ctx context.Context, req *csi.Crea
 		return nil, err
 	}
 
+	// Claim the ZVOL so a concurrent attempt does not create a second target and extent
+	generation, err := s.claimCreation(ctx, zvol.ID)
+	if err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+	if !zvolIsNew {
+		// An earlier attempt may have finished the target and extent in the meantime
+		resp, done, handleErr := s.handleExistingISCSIVolume(ctx, params, zvol, timer)
+		if handleErr != nil {
+			return nil, handleErr
+		}
+		if done {
+			return resp, nil
+		}
+	}
+
 	// Step 2: Create iSCSI extent (points to the ZVOL)
 	extent, err := s.createISCSIExtent(ctx, params, timer)
 	if err != nil {
@@ -280,6 +214,18 @@ func (s *ControllerService) createISCSIVolume(ctx context.Context, req *csi.Crea
 		return nil, err
 	}
 
+	// A later attempt claimed the ZVOL: leave the volume to it
+	if !s.ownsCreation(ctx, zvol.ID, generation) {
+		if delErr := s.apiClient.DeleteISCSITarget(ctx, target.ID, true); delErr != nil {
+			klog.Errorf("Failed to remove duplicate iSCSI target %d: %v", target.ID, delErr)
+		}
+		if delErr := s.apiClient.DeleteISCSIExtent(ctx, extent.ID, false, true); delErr != nil {
+			klog.Errorf("Failed to remove duplicate iSCSI extent %d: %v", extent.ID, delErr)
+		}
+		timer.ObserveError()
+		return nil, creationSupersededError(params.volumeName)
+	}
+
 	// Step 4.5: Reload iSCSI service to make the new target discoverable
 	// Without this, newly created targets may not be visible to iSCSI discovery
 	if reloadErr := s.apiClient.ReloadISCSIService(ctx); reloadErr != nil {
@@ -293,20 +239,7 @@ func (s *ControllerService) createISCSIVolume(ctx context.Context, req *csi.Crea
 	klog.V(4).Infof("Constructed full IQN: %s (basename=%s, target=%s)", fullIQN, globalConfig.Basename, target.Name)
 
 	// Step 5: Store ZFS user properties for metadata tracking
-	props := tnsapi.ISCSIVolumePropertiesV1(tnsapi.ISCSIVolumeParams{
-		VolumeID:       params.volumeName,
-		CapacityBytes:  params.requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: params.deleteStrategy,
-		TargetID:       target.ID,
-		ExtentID:       extent.ID,
-		TargetIQN:      fullIQN, // Full IQN for node to use during login
-		PVCName:        params.pvcName,
-		PVCNamespace:   params.pvcNamespace,
-		StorageClass:   params.storageClass,
-		Adoptable:      params.markAdoptable,
-		ClusterID:      s.clusterID,
-	})
+	props := s.volumeProperties(ProtocolISCSI, &params.volumeParams, tnsapi.ISCSITargetProperties(target.ID, extent.ID, fullIQN))
 
 	if propErr := s.apiClient.SetDatasetProperties(ctx, zvol.ID, props); propErr != nil {
 		klog.Warningf("Failed to set ZFS properties on %s: %v (volume created successfully)", zvol.ID, propErr)
@@ -316,30 +249,21 @@ func (s *ControllerService) createISCSIVolume(ctx context.Context, req *csi.Crea
 		params.volumeName, zvol.ID, target.Name, fullIQN, extent.ID)
 
 	timer.ObserveSuccess()
-	return buildISCSIVolumeResponse(params.volumeName, params.server, fullIQN, zvol, target, extent, params.requestedCapacity), nil
+	resp := buildISCSIVolumeResponse(params.volumeName, params.server, fullIQN, zvol, target, extent, s.provisionedCapacity(ctx, zvol, params.requestedCapacity))
+	markGrownExisting(resp.Volume.VolumeContext, params.grownExisting)
+	return resp, nil
 }
 
 // handleExistingISCSIVolume handles the case when a ZVOL already exists (idempotency).
 func (s *ControllerService) handleExistingISCSIVolume(ctx context.Context, params *iscsiVolumeParams, existingZvol *tnsapi.Dataset, timer *metrics.OperationTimer) (*csi.CreateVolumeResponse, bool, error) {
-	klog.V(4).Infof("ZVOL %s already exists (ID: %s), checking idempotency", params.zvolName, existingZvol.ID)
+	klog.V(4).Infof("ZVOL %s already exists (ID: %s), checking idempotency", params.datasetName, existingZvol.ID)
 
-	// Extract existing ZVOL capacity
-	existingCapacity := getZvolCapacity(existingZvol)
-	if existingCapacity > 0 {
-		klog.V(4).Infof("Existing ZVOL capacity: %d bytes, requested: %d bytes", existingCapacity, params.requestedCapacity)
-
-		// Check if capacity matches (CSI idempotency requirement)
-		if existingCapacity != params.requestedCapacity {
-			timer.ObserveError()
-			return nil, false, status.Errorf(codes.AlreadyExists,
-				"Volume '%s' already exists with different capacity: existing=%d bytes, requested=%d bytes",
-				params.volumeName, existingCapacity, params.requestedCapacity)
-		}
-	} else {
-		// If we can't determine capacity, assume compatible (backward compatibility)
-		klog.Warningf("Could not determine capacity for existing ZVOL %s, assuming compatible", params.zvolName)
-		existingCapacity = params.requestedCapacity
+	existingCapacity, grown, err := s.checkExistingZvolCapacity(ctx, params.volumeName, existingZvol, params.requestedCapacity, params.expandExisting)
+	if err != nil {
+		timer.ObserveError()
+		return nil, false, err
 	}
+	params.grownExisting = grown
 
 	// Check if target exists for this volume
 	target, err := s.apiClient.ISCSITargetByName(ctx, params.volumeName)
@@ -368,6 +292,7 @@ func (s *ControllerService) handleExistingISCSIVolume(ctx context.Context, param
 					s.ensureISCSIProperties(ctx, existingZvol.ID, params, &targets[0], &extents[0], storedIQN)
 
 					resp := buildISCSIVolumeResponse(params.volumeName, params.server, storedIQN, existingZvol, &targets[0], &extents[0], existingCapacity)
+					markGrownExisting(resp.Volume.VolumeContext, grown)
 					timer.ObserveSuccess()
 					return resp, true, nil
 				}
@@ -403,6 +328,7 @@ func (s *ControllerService) handleExistingISCSIVolume(ctx context.Context, param
 	s.ensureISCSIProperties(ctx, existingZvol.ID, params, target, extent, fullIQN)
 
 	resp := buildISCSIVolumeResponse(params.volumeName, params.server, fullIQN, existingZvol, target, extent, existingCapacity)
+	markGrownExisting(resp.Volume.VolumeContext, grown)
 	timer.ObserveSuccess()
 	return resp, true, nil
 }
@@ -420,20 +346,7 @@ func (s *ControllerService) ensureISCSIProperties(ctx context.Context, zvolID st
 	}
 
 	klog.Infof("Recovering missing ZFS properties on ZVOL %s (orphaned from interrupted creation)", zvolID)
-	props := tnsapi.ISCSIVolumePropertiesV1(tnsapi.ISCSIVolumeParams{
-		VolumeID:       params.volumeName,
-		CapacityBytes:  params.requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: params.deleteStrategy,
-		TargetID:       target.ID,
-		ExtentID:       extent.ID,
-		TargetIQN:      fullIQN,
-		PVCName:        params.pvcName,
-		PVCNamespace:   params.pvcNamespace,
-		StorageClass:   params.storageClass,
-		Adoptable:      params.markAdoptable,
-		ClusterID:      s.clusterID,
-	})
+	props := s.volumeProperties(ProtocolISCSI, &params.volumeParams, tnsapi.ISCSITargetProperties(target.ID, extent.ID, fullIQN))
 	if err := s.apiClient.SetDatasetProperties(ctx, zvolID, props); err != nil {
 		klog.Warningf("Failed to recover ZFS properties on ZVOL %s: %v (volume will still work)", zvolID, err)
 	} else {
@@ -450,11 +363,11 @@ func (s *ControllerService) getOrCreateZVOLForISCSI(ctx context.Context, params
 		return &existingZvols[0], false, nil
 	}
 
-	klog.V(4).Infof("Creating new ZVOL: %s with size %d bytes", params.zvolName, params.requestedCapacity)
+	klog.V(4).Infof("Creating new ZVOL: %s with size %d bytes", params.datasetName, params.requestedCapacity)
 
 	// Build ZVOL create parameters
 	createParams := tnsapi.ZvolCreateParams{
-		Name:     params.zvolName,
+		Name:     params.datasetName,
 		Volsize:  params.requestedCapacity,
 		Type:     datasetTypeVolume,
 		Comments: params.comment,
@@ -491,33 +404,36 @@ func (s *ControllerService) getOrCreateZVOLForISCSI(ctx context.Context, params
 		}
 	}
 
-	zvol, err := s.apiClient.CreateZvol(ctx, createParams)
+	zvol, err := s.createDatasetStaged(ctx, params.datasetName, params.volumeName, func(name string) (*tnsapi.Dataset, error) {
+		createParams.Name = name
+		return s.apiClient.CreateZvol(ctx, createParams)
+	}, func(datasetID string) { s.applyZFSQoSProperties(ctx, datasetID, params.qos) })
 	if err != nil {
 		timer.ObserveError()
-		return nil, false, createVolumeError(fmt.Sprintf("Failed to create ZVOL %s (%d bytes)", params.zvolName, params.requestedCapacity), err)
+		return nil, false, createVolumeError(fmt.Sprintf("Failed to create ZVOL %s (%d bytes)", params.datasetName, params.requestedCapacity), err)
 	}
 
-	klog.V(4).Infof("Created ZVOL: %s (ID: %s)", params.zvolName, zvol.ID)
+	klog.V(4).Infof("Created ZVOL: %s (ID: %s)", params.datasetName, zvol.ID)
 	return zvol, true, nil
 }
 
 // createISCSIExtent creates an iSCSI extent pointing to the ZVOL.
 func (s *ControllerService) createISCSIExtent(ctx context.Context, params *iscsiVolumeParams, timer *metrics.OperationTimer) (*tnsapi.ISCSIExtent, error) {
-	klog.V(4).Infof("Creating iSCSI extent for ZVOL: %s", params.zvolName)
+	klog.V(4).Infof("Creating iSCSI extent for ZVOL: %s", params.datasetName)
 
 	extentParams := tnsapi.ISCSIExtentCreateParams{
 		Name: params.volumeName,
 		Type: zfsLogicalDiskType,
-		Disk: "zvol/" + params.zvolName,
+		Disk: "zvol/" + params.datasetName,
 	}
 
 	extent, err := s.apiClient.CreateISCSIExtent(ctx, extentParams)
 	if err != nil {
 		timer.ObserveError()
-		return nil, status.Errorf(codes.Internal, "Failed to create iSCSI extent for ZVOL %s (target: %s): %v", params.zvolName, params.volumeName, err)
+		return nil, status.Errorf(codes.Internal, "Failed to create iSCSI extent for ZVOL %s (target: %s): %v", params.datasetName, params.volumeName, err)
 	}
 
-	klog.V(4).Infof("Created iSCSI extent: %d for ZVOL %s", extent.ID, params.zvolName)
+	klog.V(4).Infof("Created iSCSI extent: %d for ZVOL %s", extent.ID, params.datasetName)
 	return extent, nil
 }
 
@@ -574,7 +490,7 @@ func (s *ControllerService) createISCSITarget(ctx context.Context, params *iscsi
 	target, err := s.apiClient.CreateISCSITarget(ctx, targetParams)
 	if err != nil {
 		timer.ObserveError()
-		return nil, status.Errorf(codes.Internal, "Failed to create iSCSI target '%s' for ZVOL %s: %v", params.volumeName, params.zvolName, err)
+		return nil, status.Errorf(codes.Internal, "Failed to create iSCSI target '%s' for ZVOL %s: %v", params.volumeName, params.datasetName, err)
 	}
 
 	klog.V(4).Infof("Created iSCSI target: %s (ID: %d)", target.Name, target.ID)
@@ -659,12 +575,12 @@ func (s *ControllerService) verifyISCSIOwnership(ctx context.Context, meta *Volu
 	return deleteStrategy, false, nil
 }
 
-// deleteISCSIVolume deletes an iSCSI volume and all associated resources.
+// Teardown deletes an iSCSI volume and all associated resources.
 // Uses ZVOL-first delete order: if the ZVOL can't be deleted (dependent clones), bail without
 // touching iSCSI resources (target, extent) to prevent orphaning the ZVOL.
 //
 //nolint:gocognit // Complexity from ownership verification + CSI snapshot guard + dependent clones guard + ZVOL-first delete order
-func (s *ControllerService) deleteISCSIVolume(ctx context.Context, meta *VolumeMetadata) (*csi.DeleteVolumeResponse, error) {
+func (s iscsiController) Teardown(ctx context.Context, meta *VolumeMetadata) (*csi.DeleteVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolISCSI, verbDelete)
 	klog.Infof("Deleting iSCSI volume: %s (Dataset: %s, Target: %d, Extent: %d)",
 		meta.Name, meta.DatasetID, meta.ISCSITargetID, meta.ISCSIExtentID)
@@ -707,43 +623,36 @@ func (s *ControllerService) deleteISCSIVolume(ctx context.Context, meta *VolumeM
 	// If the ZVOL has dependent clones, we must bail immediately — deleting target/extent
 	// would leave an orphaned ZVOL with no presentation layer, making recovery impossible.
 	if meta.DatasetID != "" {
-		firstErr := s.apiClient.DeleteDataset(ctx, meta.DatasetID)
+		firstErr := s.deleteVolumeDataset(ctx, meta)
 		if firstErr != nil && !isNotFoundError(firstErr) {
-			resolved := false
 			if isDependentClonesError(firstErr) {
-				if err := s.tryPromoteAndDeleteDataset(ctx, meta.DatasetID); err == nil {
-					resolved = true
-				} else {
-					klog.Warningf("ZVOL %s has dependent clones — skipping iSCSI resource cleanup to prevent orphaning", meta.DatasetID)
-					timer.ObserveError()
-					return nil, status.Errorf(codes.FailedPrecondition,
-						"cannot delete volume %s: ZVOL %s has dependent clones; delete the cloned volumes first",
-						meta.Name, meta.DatasetID)
-				}
+				klog.Warningf("ZVOL %s has dependent clones — skipping iSCSI resource cleanup to prevent orphaning", meta.DatasetID)
+				timer.ObserveError()
+				return nil, status.Errorf(codes.FailedPrecondition,
+					"cannot delete volume %s: ZVOL %s has dependent clones; delete the cloned volumes first",
+					meta.Name, meta.DatasetID)
 			}
 
-			if !resolved {
-				// Try snapshot cleanup + retry for other errors
-				klog.Infof("Direct deletion failed for %s: %v — cleaning up snapshots before retry",
-					meta.DatasetID, firstErr)
-				s.deleteDatasetSnapshots(ctx, meta.DatasetID)
-
-				retryConfig := retry.DeletionConfig("delete-iscsi-zvol")
-				err := retry.WithRetryNoResult(ctx, retryConfig, func() error {
-					deleteErr := s.apiClient.DeleteDataset(ctx, meta.DatasetID)
-					if deleteErr != nil && isNotFoundError(deleteErr) {
-						return nil
-					}
-					return deleteErr
-				})
-
-				if err != nil {
-					// ZVOL still exists — don't touch iSCSI resources to avoid orphaning
-					klog.Errorf("ZVOL %s deletion failed — skipping iSCSI resource cleanup to avoid orphaning: %v", meta.DatasetID, err)
-					timer.ObserveError()
-					return nil, status.Errorf(codes.Internal,
-						"Failed to delete ZVOL %s: %v (iSCSI resources preserved to prevent orphaning)", meta.DatasetID, err)
+			// Try snapshot cleanup + retry for other errors
+			klog.Infof("Direct deletion failed for %s: %v — cleaning up snapshots before retry",
+				meta.DatasetID, firstErr)
+			s.deleteVolumeSnapshots(ctx, meta)
+
+			retryConfig := retry.DeletionConfig("delete-iscsi-zvol")
+			err := retry.WithRetryNoResult(ctx, retryConfig, func() error {
+				deleteErr := s.deleteVolumeDataset(ctx, meta)
+				if deleteErr != nil && isNotFoundError(deleteErr) {
+					return nil
 				}
+				return deleteErr
+			})
+
+			if err != nil {
+				// ZVOL still exists — don't touch iSCSI resources to avoid orphaning
+				klog.Errorf("ZVOL %s deletion failed — skipping iSCSI resource cleanup to avoid orphaning: %v", meta.DatasetID, err)
+				timer.ObserveError()
+				return nil, status.Errorf(codes.Internal,
+					"Failed to delete ZVOL %s: %v (iSCSI resources preserved to prevent orphaning)", meta.DatasetID, err)
 			}
 		}
 		klog.V(4).Infof("Deleted ZVOL: %s", meta.DatasetID)
@@ -794,10 +703,10 @@ func (s *ControllerService) deleteISCSIVolume(ctx context.Context, meta *VolumeM
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
-// expandISCSIVolume expands an iSCSI volume by updating the ZVOL size.
+// Expand expands an iSCSI volume by updating the ZVOL size.
 //
 //nolint:dupl // Intentionally similar to NFS/NVMe-oF expansion logic
-func (s *ControllerService) expandISCSIVolume(ctx context.Context, meta *VolumeMetadata, requiredBytes int64) (*csi.ControllerExpandVolumeResponse, error) {
+func (s iscsiController) Expand(ctx context.Context, meta *VolumeMetadata, requiredBytes int64) (*csi.ControllerExpandVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolISCSI, "expand")
 	klog.V(4).Infof("Expanding iSCSI volume: %s (ZVOL: %s) to %d bytes", meta.Name, meta.DatasetName, requiredBytes)
 
@@ -836,8 +745,8 @@ func (s *ControllerService) expandISCSIVolume(ctx context.Context, meta *VolumeM
 	}, nil
 }
 
-// getISCSIVolumeInfo retrieves volume information and health status for an iSCSI volume.
-func (s *ControllerService) getISCSIVolumeInfo(ctx context.Context, meta *VolumeMetadata) (*csi.ControllerGetVolumeResponse, error) {
+// Describe retrieves volume information and health status for an iSCSI volume.
+func (s iscsiController) Describe(ctx context.Context, meta *VolumeMetadata) (*csi.ControllerGetVolumeResponse, error) {
 	klog.V(4).Infof("Getting iSCSI volume info: %s (dataset: %s, targetID: %d, extentID: %d)",
 		meta.Name, meta.DatasetName, meta.ISCSITargetID, meta.ISCSIExtentID)
 
@@ -927,12 +836,10 @@ func (s *ControllerService) getISCSIVolumeInfo(ctx context.Context, meta *Volume
 	}, nil
 }
 
-// setupISCSIVolumeFromClone sets up iSCSI infrastructure (extent, target, target-extent) for a cloned ZVOL.
+// ExportClone sets up iSCSI infrastructure (extent, target, target-extent) for a cloned ZVOL.
 // The ZVOL already exists from the clone operation - this function creates the iSCSI resources on top of it.
-func (s *ControllerService) setupISCSIVolumeFromClone(ctx context.Context, req *csi.CreateVolumeRequest, zvol *tnsapi.Dataset, server string, info *cloneInfo) (*csi.CreateVolumeResponse, error) {
-	klog.V(4).Infof("Setting up iSCSI infrastructure for cloned ZVOL: %s (cloneMode: %s)", zvol.Name, info.Mode)
-
-	volumeName := req.GetName()
+func (s iscsiController) ExportClone(ctx context.Context, req *csi.CreateVolumeRequest, zvol *tnsapi.Dataset, params *volumeParams) (*volumeExport, error) {
+	klog.V(4).Infof("Setting up iSCSI infrastructure for cloned ZVOL: %s", zvol.Name)
 
 	// Get iSCSI global config to construct full IQN
 	globalConfig, err := s.apiClient.GetISCSIGlobalConfig(ctx)
@@ -940,32 +847,11 @@ func (s *ControllerService) setupISCSIVolumeFromClone(ctx context.Context, req *
 		return nil, status.Errorf(codes.Internal, "Failed to get iSCSI global config: %v", err)
 	}
 
-	// Get requested capacity
-	requestedCapacity := req.GetCapacityRange().GetRequiredBytes()
-	if requestedCapacity == 0 {
-		requestedCapacity = 1 * 1024 * 1024 * 1024 // Default 1GB
-	}
-
-	// Get parameters from request
-	params := req.GetParameters()
-	if params == nil {
-		params = make(map[string]string)
-	}
-	deleteStrategy := params["deleteStrategy"]
-	if deleteStrategy == "" {
-		deleteStrategy = tnsapi.DeleteStrategyDelete
-	}
-
-	// Extract portal/initiator IDs from params or use first available
-	var portalID, initiatorID int
-	if portalIDStr := params["portalId"]; portalIDStr != "" {
-		portalID, _ = strconv.Atoi(portalIDStr) //nolint:errcheck // Invalid values will use default (0)
-	}
-	if initiatorIDStr := params["initiatorId"]; initiatorIDStr != "" {
-		initiatorID, _ = strconv.Atoi(initiatorIDStr) //nolint:errcheck // Invalid values will use default (0)
-	}
-
 	// Resolve portal/initiator IDs (query TrueNAS if not specified)
+	portalID, initiatorID, err := parseISCSIGroupIDs(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
 	portalID, initiatorID, err = s.resolveISCSIPortalAndInitiator(ctx, portalID, initiatorID)
 	if err != nil {
 		return nil, err
@@ -973,17 +859,13 @@ func (s *ControllerService) setupISCSIVolumeFromClone(ctx context.Context, req *
 
 	// Step 1: Create iSCSI extent (points to the cloned ZVOL)
 	extent, err := s.apiClient.CreateISCSIExtent(ctx, tnsapi.ISCSIExtentCreateParams{
-		Name:      volumeName,
+		Name:      params.volumeName,
 		Type:      zfsLogicalDiskType,
 		Disk:      "zvol/" + zvol.ID,
 		Blocksize: 512,
+		ReadOnly:  isReadOnlyContentSourceRequest(req),
 	})
 	if err != nil {
-		// Cleanup: delete the cloned ZVOL if extent creation fails
-		klog.Errorf("Failed to create iSCSI extent for cloned ZVOL, cleaning up: %v", err)
-		if delErr := s.apiClient.DeleteDataset(ctx, zvol.ID); delErr != nil {
-			klog.Errorf("Failed to cleanup cloned ZVOL: %v", delErr)
-		}
 		return nil, status.Errorf(codes.Internal, "Failed to create iSCSI extent for cloned volume: %v", err)
 	}
 
@@ -992,7 +874,7 @@ func (s *ControllerService) setupISCSIVolumeFromClone(ctx context.Context, req *
 	// Step 2: Create iSCSI target WITH portal/initiator groups (critical for discoverability!)
 	// Without groups, the target won't be advertised on any portal and won't be discoverable.
 	target, err := s.apiClient.CreateISCSITarget(ctx, tnsapi.ISCSITargetCreateParams{
-		Name: volumeName,
+		Name: params.volumeName,
 		Mode: iscsiExtentType,
 		Groups: []tnsapi.ISCSITargetGroup{
 			{
@@ -1002,14 +884,10 @@ func (s *ControllerService) setupISCSIVolumeFromClone(ctx context.Context, req *
 		},
 	})
 	if err != nil {
-		// Cleanup: delete extent and ZVOL
 		klog.Errorf("Failed to create iSCSI target, cleaning up: %v", err)
 		if delErr := s.apiClient.DeleteISCSIExtent(ctx, extent.ID, false, false); delErr != nil {
 			klog.Errorf("Failed to cleanup iSCSI extent: %v", delErr)
 		}
-		if delErr := s.apiClient.DeleteDataset(ctx, zvol.ID); delErr != nil {
-			klog.Errorf("Failed to cleanup cloned ZVOL: %v", delErr)
-		}
 		return nil, status.Errorf(codes.Internal, "Failed to create iSCSI target for cloned volume: %v", err)
 	}
 
@@ -1022,7 +900,6 @@ func (s *ControllerService) setupISCSIVolumeFromClone(ctx context.Context, req *
 		LunID:  0,
 	})
 	if err != nil {
-		// Cleanup: delete target, extent, and ZVOL
 		klog.Errorf("Failed to create target-extent association, cleaning up: %v", err)
 		if delErr := s.apiClient.DeleteISCSITarget(ctx, target.ID, false); delErr != nil {
 			klog.Errorf("Failed to cleanup iSCSI target: %v", delErr)
@@ -1030,9 +907,6 @@ func (s *ControllerService) setupISCSIVolumeFromClone(ctx context.Context, req *
 		if delErr := s.apiClient.DeleteISCSIExtent(ctx, extent.ID, false, false); delErr != nil {
 			klog.Errorf("Failed to cleanup iSCSI extent: %v", delErr)
 		}
-		if delErr := s.apiClient.DeleteDataset(ctx, zvol.ID); delErr != nil {
-			klog.Errorf("Failed to cleanup cloned ZVOL: %v", delErr)
-		}
 		return nil, status.Errorf(codes.Internal, "Failed to create target-extent association for cloned volume: %v", err)
 	}
 
@@ -1043,98 +917,20 @@ func (s *ControllerService) setupISCSIVolumeFromClone(ctx context.Context, req *
 
 	// Construct full IQN
 	fullIQN := globalConfig.Basename + ":" + target.Name
-	klog.V(4).Infof("Constructed full IQN: %s", fullIQN)
-
-	// Step 5: Store ZFS user properties for metadata tracking
-	props := tnsapi.ISCSIVolumePropertiesV1(tnsapi.ISCSIVolumeParams{
-		VolumeID:       volumeName,
-		CapacityBytes:  requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: deleteStrategy,
-		TargetID:       target.ID,
-		ExtentID:       extent.ID,
-		TargetIQN:      fullIQN,
-		PVCName:        params["csi.storage.k8s.io/pvc/name"],
-		PVCNamespace:   params["csi.storage.k8s.io/pvc/namespace"],
-		StorageClass:   params["csi.storage.k8s.io/sc/name"],
-		ClusterID:      s.clusterID,
-	})
-	// Add clone-specific properties (including clone mode for dependency tracking)
-	cloneProps := tnsapi.ClonedVolumePropertiesV2(tnsapi.ContentSourceSnapshot, info.SnapshotID, info.Mode, info.OriginSnapshot)
-	for k, v := range cloneProps {
-		props[k] = v
-	}
-	if err := s.apiClient.SetDatasetProperties(ctx, zvol.ID, props); err != nil {
-		klog.Warningf("Failed to set ZFS user properties on cloned ZVOL %s: %v (volume will still work)", zvol.ID, err)
-	} else {
-		klog.V(4).Infof("Stored ZFS user properties on cloned ZVOL %s", zvol.ID)
-	}
-
-	// Set dataset comment from commentTemplate (if configured) — CloneSnapshot doesn't support setting comments
-	if comment, commentErr := ResolveComment(req.GetParameters(), req.GetName()); commentErr == nil && comment != "" {
-		if _, err := s.apiClient.UpdateDataset(ctx, zvol.ID, tnsapi.DatasetUpdateParams{Comments: comment}); err != nil {
-			klog.Warningf("Failed to set comment on cloned ZVOL %s: %v (non-fatal)", zvol.ID, err)
-		}
-	}
-
 	klog.Infof("Created iSCSI volume from clone: %s (ZVOL: %s, Target: %s, IQN: %s, Extent: %d)",
-		volumeName, zvol.ID, target.Name, fullIQN, extent.ID)
-
-	// Build volume metadata
-	meta := VolumeMetadata{
-		Name:          volumeName,
-		Protocol:      ProtocolISCSI,
-		DatasetID:     zvol.ID,
-		DatasetName:   zvol.Name,
-		Server:        server,
-		ISCSITargetID: target.ID,
-		ISCSIExtentID: extent.ID,
-		ISCSIIQN:      fullIQN,
-	}
-
-	// Update volume capacity metric
-	metrics.SetVolumeCapacity(volumeName, metrics.ProtocolISCSI, requestedCapacity)
+		params.volumeName, zvol.ID, target.Name, fullIQN, extent.ID)
 
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      zvol.ID,
-			CapacityBytes: requestedCapacity,
-			VolumeContext: buildVolumeContext(meta),
-			ContentSource: &csi.VolumeContentSource{
-				Type: &csi.VolumeContentSource_Snapshot{
-					Snapshot: &csi.VolumeContentSource_SnapshotSource{
-						SnapshotId: info.SnapshotID,
-					},
-				},
-			},
-		},
-	}, nil
+	return iscsiExport(zvol, params, target, extent, fullIQN), nil
 }
 
-// adoptISCSIVolume adopts an orphaned iSCSI volume by recreating missing TrueNAS resources.
+// ExportAdopted adopts an orphaned iSCSI volume by recreating missing TrueNAS resources.
 // This enables GitOps workflows where clusters are recreated and need to adopt existing volumes.
-func (s *ControllerService) adoptISCSIVolume(ctx context.Context, req *csi.CreateVolumeRequest, dataset *tnsapi.DatasetWithProperties, params map[string]string) (*csi.CreateVolumeResponse, error) {
-	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolISCSI, "adopt")
-	volumeName := req.GetName()
-	klog.Infof("Adopting iSCSI volume: %s (dataset=%s)", volumeName, dataset.ID)
-
-	// Get server parameter
-	server := params["server"]
-	if server == "" {
-		timer.ObserveError()
-		return nil, status.Error(codes.InvalidArgument, "server parameter is required for iSCSI volumes")
-	}
-
-	// Get requested capacity
-	requestedCapacity := req.GetCapacityRange().GetRequiredBytes()
-	if requestedCapacity == 0 {
-		requestedCapacity = 1 * 1024 * 1024 * 1024 // 1 GiB default
-	}
+func (s iscsiController) ExportAdopted(ctx context.Context, _ *csi.CreateVolumeRequest, dataset *tnsapi.DatasetWithProperties, params *volumeParams) (*volumeExport, error) {
+	volumeName := params.volumeName
 
 	// Get iSCSI global config to construct full IQN
 	globalConfig, err := s.apiClient.GetISCSIGlobalConfig(ctx)
 	if err != nil {
-		timer.ObserveError()
 		return nil, status.Errorf(codes.Internal, "Failed to get iSCSI global config: %v", err)
 	}
 
@@ -1186,7 +982,6 @@ func (s *ControllerService) adoptISCSIVolume(ctx context.Context, req *csi.Creat
 			},
 		})
 		if createErr != nil {
-			timer.ObserveError()
 			return nil, status.Errorf(codes.Internal, "Failed to create iSCSI target for adopted volume: %v", createErr)
 		}
 		target = newTarget
@@ -1207,7 +1002,6 @@ func (s *ControllerService) adoptISCSIVolume(ctx context.Context, req *csi.Creat
 			Blocksize: 512, // Standard block size
 		})
 		if createErr != nil {
-			timer.ObserveError()
 			return nil, status.Errorf(codes.Internal, "Failed to create iSCSI extent for adopted volume: %v", createErr)
 		}
 		extent = newExtent
@@ -1237,7 +1031,6 @@ func (s *ControllerService) adoptISCSIVolume(ctx context.Context, req *csi.Creat
 			LunID:  0, // LUN 0
 		})
 		if err != nil {
-			timer.ObserveError()
 			return nil, status.Errorf(codes.Internal, "Failed to create target-extent association: %v", err)
 		}
 		klog.Infof("Created target-extent association for adopted volume")
@@ -1251,56 +1044,6 @@ func (s *ControllerService) adoptISCSIVolume(ctx context.Context, req *csi.Creat
 	// Construct full IQN
 	fullIQN := globalConfig.Basename + ":" + target.Name
 
-	// Update ZFS properties with new IDs
-	deleteStrategy := params["deleteStrategy"]
-	if deleteStrategy == "" {
-		deleteStrategy = tnsapi.DeleteStrategyDelete
-	}
-	markAdoptable := params["markAdoptable"] == VolumeContextValueTrue
-
-	props := tnsapi.ISCSIVolumePropertiesV1(tnsapi.ISCSIVolumeParams{
-		VolumeID:       volumeName,
-		CapacityBytes:  requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: deleteStrategy,
-		TargetID:       target.ID,
-		ExtentID:       extent.ID,
-		TargetIQN:      fullIQN,
-		PVCName:        params["csi.storage.k8s.io/pvc/name"],
-		PVCNamespace:   params["csi.storage.k8s.io/pvc/namespace"],
-		StorageClass:   params["csi.storage.k8s.io/sc/name"],
-		Adoptable:      markAdoptable,
-		ClusterID:      s.clusterID,
-	})
-	if propErr := s.apiClient.SetDatasetProperties(ctx, dataset.ID, props); propErr != nil {
-		klog.Warningf("Failed to update ZFS properties on adopted volume %s: %v", dataset.ID, propErr)
-	}
-
-	// Build response
-	meta := VolumeMetadata{
-		Name:          volumeName,
-		Protocol:      ProtocolISCSI,
-		DatasetID:     dataset.ID,
-		DatasetName:   dataset.Name,
-		Server:        server,
-		ISCSITargetID: target.ID,
-		ISCSIExtentID: extent.ID,
-		ISCSIIQN:      fullIQN,
-	}
-
-	volumeContext := buildVolumeContext(meta)
-
-	// Record volume capacity metric
-	metrics.SetVolumeCapacity(volumeName, metrics.ProtocolISCSI, requestedCapacity)
-
-	klog.Infof("Successfully adopted iSCSI volume: %s (target=%s, IQN=%s)", volumeName, target.Name, fullIQN)
-	timer.ObserveSuccess()
-
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      dataset.ID,
-			CapacityBytes: requestedCapacity,
-			VolumeContext: volumeContext,
-		},
-	}, nil
+	klog.Infof("Found or created iSCSI resources for adopted volume: %s (target=%s, IQN=%s)", volumeName, target.Name, fullIQN)
+	return iscsiExport(&dataset.Dataset, params, target, extent, fullIQN), nil
 }
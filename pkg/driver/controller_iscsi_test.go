@@ -143,7 +143,7 @@ func TestValidateISCSIParams(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			params, err := validateISCSIParams(tt.req)
+			params, err := iscsiController{&ControllerService{}}.validateISCSIParams(tt.req)
 			if tt.wantErr {
 				if err == nil {
 					t.Error("Expected error but got nil")
@@ -389,6 +389,71 @@ func TestCreateISCSIVolume(t *testing.T) {
 			wantErr:  true,
 			wantCode: codes.ResourceExhausted,
 		},
+		{
+			name: "concurrent attempt claimed the ZVOL",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-iscsi-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Block{
+							Block: &csi.VolumeCapability_BlockVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"protocol":      "iscsi",
+					"pool":          "tank",
+					"server":        "192.168.1.100",
+					"parentDataset": "tank/csi",
+				},
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 5 * 1024 * 1024 * 1024,
+				},
+			},
+			mockSetup: func(m *MockAPIClientForSnapshots) {
+				m.QueryAllDatasetsFunc = func(ctx context.Context, prefix string) ([]tnsapi.Dataset, error) {
+					return []tnsapi.Dataset{}, nil
+				}
+				m.CreateZvolFunc = func(ctx context.Context, params tnsapi.ZvolCreateParams) (*tnsapi.Dataset, error) {
+					return &tnsapi.Dataset{
+						ID:   "tank/csi/test-iscsi-volume",
+						Name: "tank/csi/test-iscsi-volume",
+						Type: "VOLUME",
+					}, nil
+				}
+				// Another attempt claimed the ZVOL after this one
+				m.GetDatasetPropertiesFunc = func(ctx context.Context, datasetID string, propertyNames []string) (map[string]string, error) {
+					return map[string]string{tnsapi.PropertyCreateGeneration: "0123456789abcdef"}, nil
+				}
+				m.DeleteISCSITargetFunc = func(ctx context.Context, targetID int, force bool) error {
+					if targetID != 1 {
+						t.Errorf("Expected duplicate target 1 to be removed, got %d", targetID)
+					}
+					return nil
+				}
+				m.DeleteISCSIExtentFunc = func(ctx context.Context, extentID int, removeFile, force bool) error {
+					if extentID != 1 || removeFile {
+						t.Errorf("Expected duplicate extent 1 to be removed without its ZVOL, got %d (removeFile=%v)", extentID, removeFile)
+					}
+					return nil
+				}
+				m.DeleteDatasetFunc = func(ctx context.Context, datasetID string) error {
+					t.Error("ZVOL must be left to the attempt that claimed it")
+					return nil
+				}
+				m.SetDatasetPropertiesFunc = func(ctx context.Context, datasetID string, properties map[string]string) error {
+					if _, ok := properties[tnsapi.PropertyISCSITargetID]; ok {
+						t.Error("Volume properties must be left to the attempt that claimed the ZVOL")
+					}
+					return nil
+				}
+			},
+			wantErr:  true,
+			wantCode: codes.Aborted,
+		},
 	}
 
 	for _, tt := range tests {
@@ -402,7 +467,7 @@ func TestCreateISCSIVolume(t *testing.T) {
 				apiClient: mockClient,
 			}
 
-			resp, err := controller.createISCSIVolume(ctx, tt.req)
+			resp, err := iscsiController{controller}.Provision(ctx, tt.req)
 			if tt.wantErr {
 				if err == nil {
 					t.Error("Expected error but got nil")
@@ -510,7 +575,7 @@ func TestDeleteISCSIVolume(t *testing.T) {
 				apiClient: mockClient,
 			}
 
-			resp, err := controller.deleteISCSIVolume(ctx, tt.meta)
+			resp, err := iscsiController{controller}.Teardown(ctx, tt.meta)
 			if tt.wantErr {
 				if err == nil {
 					t.Error("Expected error but got nil")
@@ -610,7 +675,7 @@ func TestExpandISCSIVolume(t *testing.T) {
 				apiClient: mockClient,
 			}
 
-			resp, err := controller.expandISCSIVolume(ctx, tt.meta, tt.requiredBytes)
+			resp, err := iscsiController{controller}.Expand(ctx, tt.meta, tt.requiredBytes)
 			if tt.wantErr {
 				if err == nil {
 					t.Error("Expected error but got nil")
@@ -745,7 +810,7 @@ func TestGetISCSIVolumeInfo(t *testing.T) {
 				apiClient: mockClient,
 			}
 
-			resp, err := controller.getISCSIVolumeInfo(ctx, tt.meta)
+			resp, err := iscsiController{controller}.Describe(ctx, tt.meta)
 			if tt.wantErr {
 				if err == nil {
 					t.Error("Expected error but got nil")
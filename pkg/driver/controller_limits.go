@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/metrics"
+	"golang.org/x/sync/semaphore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Controller concurrency limits.
+//
+// The provisioner sidecars call CreateVolume for every pending PVC at once. A burst of a few
+// hundred PVCs turns into as many simultaneous dataset creations, which TrueNAS serializes
+// internally until most of them hit the provisioning timeout and start over. With
+// --max-concurrent-provisions (and --max-concurrent-snapshots / --max-concurrent-deletes) the
+// controller queues the excess operations instead; queue length and wait time are exported as
+// tns_csi_controller_operations_queued and tns_csi_controller_operations_wait_seconds.
+
+// Controller operation classes with separate concurrency limits (metric label values).
+const (
+	opClassProvision = "provision"
+	opClassSnapshot  = "snapshot"
+	opClassDelete    = "delete"
+)
+
+// operationLimiter bounds the number of concurrent operations of one class. A nil
+// *operationLimiter does not limit.
+type operationLimiter struct {
+	sem   *semaphore.Weighted
+	class string
+	limit int64
+}
+
+// newOperationLimiter returns a limiter allowing limit concurrent operations, or nil if
+// limit is not positive.
+func newOperationLimiter(class string, limit int) *operationLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &operationLimiter{
+		sem:   semaphore.NewWeighted(int64(limit)),
+		class: class,
+		limit: int64(limit),
+	}
+}
+
+// acquire waits for a slot and returns the function that releases it. Waiting ends with
+// DeadlineExceeded when ctx is done, so the sidecar retries.
+func (l *operationLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	metrics.ControllerOpQueued(l.class)
+	start := time.Now()
+	err := l.sem.Acquire(ctx, 1)
+	metrics.ControllerOpDequeued(l.class, time.Since(start))
+	if err != nil {
+		return nil, status.Errorf(codes.DeadlineExceeded,
+			"timed out waiting for a %s slot (max concurrent: %d): %v", l.class, l.limit, err)
+	}
+	if wait := time.Since(start); wait > time.Second {
+		klog.V(4).Infof("Waited %v for a %s slot (max concurrent: %d)", wait.Round(time.Millisecond), l.class, l.limit)
+	}
+
+	metrics.ControllerOpStart(l.class)
+	return func() {
+		l.sem.Release(1)
+		metrics.ControllerOpDone(l.class)
+	}, nil
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/fenio/tns-csi/pkg/metrics"
@@ -28,21 +27,12 @@ type encryptionConfig struct {
 
 // nfsVolumeParams holds validated parameters for NFS volume creation.
 type nfsVolumeParams struct {
-	zfsProps          *zfsDatasetProperties
-	encryption        *encryptionConfig
-	parentDataset     string
-	volumeName        string
-	datasetName       string
-	deleteStrategy    string
-	server            string
-	pool              string
-	comment           string
-	shareType         string
-	pvcName           string
-	pvcNamespace      string
-	storageClass      string
-	requestedCapacity int64
-	markAdoptable     bool
+	volumeParams
+	zfsProps      *zfsDatasetProperties
+	shareAccess   nfsShareAccess
+	shareType     string
+	shareStrategy string
+	deferShare    bool
 }
 
 // zfsDatasetProperties holds ZFS properties for dataset creation.
@@ -117,6 +107,8 @@ func parseZFSDatasetProperties(params map[string]string) *zfsDatasetProperties {
 		case "casesensitivity":
 			// TrueNAS API requires uppercase: SENSITIVE, INSENSITIVE, MIXED
 			props.Casesensitivity = strings.ToUpper(value)
+		case zfsQoSLogbias, zfsQoSPrimarycache, zfsQoSSecondarycache, zfsQoSSpecialSmallBlocks:
+			// Validated and applied separately (see parseZFSQoSProperties)
 		default:
 			klog.V(4).Infof("Unknown ZFS property: %s=%s (ignoring)", propName, value)
 		}
@@ -175,94 +167,46 @@ func parseEncryptionConfig(params, secrets map[string]string) *encryptionConfig
 }
 
 // validateNFSParams validates and extracts NFS volume parameters from the request.
-func validateNFSParams(req *csi.CreateVolumeRequest) (*nfsVolumeParams, error) {
-	params := req.GetParameters()
-
-	pool := params["pool"]
-	if pool == "" {
-		return nil, status.Error(codes.InvalidArgument, "pool parameter is required for NFS volumes")
-	}
-
-	// Server parameter - optional for testing with default value
-	server := params["server"]
-	if server == "" {
-		server = defaultServerAddress // Default for testing
-		klog.V(4).Infof("No server parameter provided, using default: %s", defaultServerAddress)
-	}
-
-	parentDataset := params["parentDataset"]
-	if parentDataset == "" {
-		parentDataset = pool
-	}
-
-	requestedCapacity := req.GetCapacityRange().GetRequiredBytes()
-	if requestedCapacity == 0 {
-		requestedCapacity = 1 * 1024 * 1024 * 1024 // Default 1GB
-	}
-
-	// Resolve volume name using templating (if configured in StorageClass)
-	volumeName, err := ResolveVolumeName(params, req.GetName())
+func (s nfsController) validateNFSParams(req *csi.CreateVolumeRequest) (*nfsVolumeParams, error) {
+	common, err := s.parseVolumeParams(s, req)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve volume name: %v", err)
+		return nil, err
 	}
-	datasetName := fmt.Sprintf("%s/%s", parentDataset, volumeName)
 
-	// Resolve dataset comment from commentTemplate (if configured in StorageClass)
-	comment, err := ResolveComment(params, req.GetName())
+	// Derive NFS export options from the requested access modes
+	shareAccess, err := nfsShareAccessForRequest(req)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve comment template: %v", err)
+		return nil, err
 	}
 
-	// Parse ZFS properties from StorageClass parameters
-	zfsProps := parseZFSDatasetProperties(params)
-
-	// Parse encryption config from StorageClass parameters and secrets
-	encryption := parseEncryptionConfig(params, req.GetSecrets())
-
-	// Parse deleteStrategy from StorageClass parameters (default: "delete")
-	deleteStrategy := params["deleteStrategy"]
-	if deleteStrategy == "" {
-		deleteStrategy = tnsapi.DeleteStrategyDelete
+	// Parse shareStrategy from StorageClass parameters (default: one export per volume)
+	shareStrategy, err := parseNFSShareStrategy(req.GetParameters())
+	if err != nil {
+		return nil, err
 	}
 
-	// Parse markAdoptable from StorageClass parameters (default: false)
-	markAdoptable := params["markAdoptable"] == VolumeContextValueTrue
-
-	// Extract adoption metadata from CSI parameters
-	pvcName := params["csi.storage.k8s.io/pvc/name"]
-	pvcNamespace := params["csi.storage.k8s.io/pvc/namespace"]
-	storageClass := params["csi.storage.k8s.io/sc/name"]
-
 	return &nfsVolumeParams{
-		pool:              pool,
-		server:            server,
-		parentDataset:     parentDataset,
-		requestedCapacity: requestedCapacity,
-		volumeName:        volumeName,
-		datasetName:       datasetName,
-		deleteStrategy:    deleteStrategy,
-		markAdoptable:     markAdoptable,
-		zfsProps:          zfsProps,
-		encryption:        encryption,
-		comment:           comment,
-		pvcName:           pvcName,
-		pvcNamespace:      pvcNamespace,
-		storageClass:      storageClass,
+		volumeParams:  *common,
+		zfsProps:      parseZFSDatasetProperties(common.zfsParams),
+		shareAccess:   shareAccess,
+		shareStrategy: shareStrategy,
+		// Parse deferShareCreation from StorageClass parameters (default: false)
+		deferShare: req.GetParameters()[DeferShareCreationParam] == VolumeContextValueTrue,
 	}, nil
 }
 
-// parseCapacityFromComment parses the capacity from an NFS share comment.
-// Returns 0 if capacity cannot be parsed (backward compatibility).
-func parseCapacityFromComment(comment string) int64 {
-	if comment == "" {
-		return 0
-	}
-	var parsedCapacity int64
-	_, err := fmt.Sscanf(comment, "CSI Volume: %s | Capacity: %d", new(string), &parsedCapacity)
+// nfsVolumeCapacity returns the capacity of an existing NFS volume: its tns-csi:capacity_bytes
+// property, kept up to date by expansion. Volumes created before the property was recorded fall
+// back to the capacity in their share comment, which is otherwise only informational (admins may
+// edit it). Returns 0 if neither is known (backward compatibility).
+func (s *ControllerService) nfsVolumeCapacity(ctx context.Context, datasetID, shareComment string) int64 {
+	props, err := s.apiClient.GetDatasetProperties(ctx, datasetID, []string{tnsapi.PropertyCapacityBytes})
 	if err != nil {
-		return 0
+		klog.V(4).Infof("Could not read capacity property of dataset %s, using share comment: %v", datasetID, err)
+	} else if capacity := tnsapi.StringToInt64(props[tnsapi.PropertyCapacityBytes]); capacity > 0 {
+		return capacity
 	}
-	return parsedCapacity
+	return parseNFSShareCapacity(shareComment)
 }
 
 // buildNFSVolumeResponse builds the CreateVolumeResponse for an NFS volume.
@@ -323,16 +267,19 @@ func (s *ControllerService) handleExistingNFSVolume(ctx context.Context, params
 	}
 	klog.V(4).Infof("NFS volume already exists (share ID: %d), checking capacity compatibility", existingShare.ID)
 
-	existingCapacity := parseCapacityFromComment(existingShare.Comment)
+	existingCapacity := s.nfsVolumeCapacity(ctx, existingDataset.ID, existingShare.Comment)
 
 	// CSI spec: return AlreadyExists if volume exists with incompatible capacity
 	if existingCapacity > 0 && existingCapacity != params.requestedCapacity {
-		klog.Warningf("Volume %s exists with different capacity (existing: %d, requested: %d)",
-			params.volumeName, existingCapacity, params.requestedCapacity)
-		timer.ObserveError()
-		return nil, false, status.Errorf(codes.AlreadyExists,
-			"Volume %s already exists with different capacity (existing: %d bytes, requested: %d bytes)",
-			params.volumeName, existingCapacity, params.requestedCapacity)
+		if !params.expandExisting || existingCapacity > params.requestedCapacity {
+			timer.ObserveError()
+			return nil, false, capacityMismatchError(params.volumeName, existingDataset.ID, existingCapacity, params.requestedCapacity)
+		}
+		if err := s.expandExistingNFSVolume(ctx, params, existingDataset, existingShare, existingCapacity); err != nil {
+			timer.ObserveError()
+			return nil, false, err
+		}
+		existingCapacity = params.requestedCapacity
 	}
 
 	klog.V(4).Infof("Capacity is compatible, returning existing volume")
@@ -352,6 +299,25 @@ func (s *ControllerService) handleExistingNFSVolume(ctx context.Context, params
 	return resp, true, nil
 }
 
+// expandExistingNFSVolume grows an existing NFS volume to the requested capacity. The new capacity
+// is recorded in tns-csi:capacity_bytes; the informational share comment is updated best-effort.
+func (s *ControllerService) expandExistingNFSVolume(ctx context.Context, params *nfsVolumeParams, dataset *tnsapi.Dataset, share *tnsapi.NFSShare, existingCapacity int64) error {
+	if err := s.expandExistingVolume(ctx, params.volumeName, dataset.ID, false, existingCapacity, params.requestedCapacity); err != nil {
+		return err
+	}
+	comment := strings.Replace(share.Comment, "Capacity: "+strconv.FormatInt(existingCapacity, 10),
+		"Capacity: "+strconv.FormatInt(params.requestedCapacity, 10), 1)
+	if comment == share.Comment {
+		return nil
+	}
+	if _, err := s.apiClient.UpdateNFSShare(ctx, share.ID, tnsapi.NFSShareUpdateParams{Comment: comment}); err != nil {
+		klog.Warningf("Failed to update the comment of NFS share %d of volume %s: %v", share.ID, params.volumeName, err)
+		return nil
+	}
+	share.Comment = comment
+	return nil
+}
+
 // ensureNFSProperties checks if ZFS properties are set on the dataset and sets them if missing.
 // This handles the case where a dataset was created but context expired before properties were set.
 //
@@ -367,19 +333,7 @@ func (s *ControllerService) ensureNFSProperties(ctx context.Context, datasetID s
 	}
 
 	klog.Infof("Recovering missing ZFS properties on dataset %s (orphaned from interrupted creation)", datasetID)
-	props := tnsapi.NFSVolumePropertiesV1(tnsapi.NFSVolumeParams{
-		VolumeID:       params.volumeName,
-		CapacityBytes:  params.requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: params.deleteStrategy,
-		ShareID:        share.ID,
-		SharePath:      share.Path,
-		PVCName:        params.pvcName,
-		PVCNamespace:   params.pvcNamespace,
-		StorageClass:   params.storageClass,
-		Adoptable:      params.markAdoptable,
-		ClusterID:      s.clusterID,
-	})
+	props := s.volumeProperties(ProtocolNFS, &params.volumeParams, tnsapi.NFSShareProperties(share.ID, share.Path))
 	if err := s.apiClient.SetDatasetProperties(ctx, datasetID, props); err != nil {
 		klog.Warningf("Failed to recover ZFS properties on dataset %s: %v (volume will still work)", datasetID, err)
 	} else {
@@ -455,7 +409,10 @@ func (s *ControllerService) getOrCreateDataset(ctx context.Context, params *nfsV
 	}
 
 	// Create new dataset
-	dataset, err := s.apiClient.CreateDataset(ctx, createParams)
+	dataset, err := s.createDatasetStaged(ctx, params.datasetName, params.volumeName, func(name string) (*tnsapi.Dataset, error) {
+		createParams.Name = name
+		return s.apiClient.CreateDataset(ctx, createParams)
+	}, func(datasetID string) { s.applyZFSQoSProperties(ctx, datasetID, params.qos) })
 	if err != nil {
 		timer.ObserveError()
 		return nil, false, createVolumeError(fmt.Sprintf("Failed to create dataset %s (%d bytes)", params.datasetName, params.requestedCapacity), err)
@@ -467,16 +424,12 @@ func (s *ControllerService) getOrCreateDataset(ctx context.Context, params *nfsV
 
 // createNFSShareForDataset creates an NFS share for a dataset and stores ZFS properties for tracking.
 // datasetIsNew indicates whether the dataset was just created by this operation — if false, the dataset
-// is pre-existing and must NOT be deleted on failure (prevents data loss).
-func (s *ControllerService) createNFSShareForDataset(ctx context.Context, dataset *tnsapi.Dataset, params *nfsVolumeParams, datasetIsNew bool, timer *metrics.OperationTimer) (*tnsapi.NFSShare, error) {
-	comment := fmt.Sprintf("CSI Volume: %s | Capacity: %d", params.volumeName, params.requestedCapacity)
-	nfsShare, err := s.apiClient.CreateNFSShare(ctx, tnsapi.NFSShareCreateParams{
-		Path:         dataset.Mountpoint,
-		Comment:      comment,
-		MaprootUser:  zfsACLModeRoot,
-		MaprootGroup: zfsACLModeWheel,
-		Enabled:      true,
-	})
+// is pre-existing and must NOT be deleted on failure (prevents data loss). generation is this
+// attempt's claim on the dataset (see claimCreation).
+func (s *ControllerService) createNFSShareForDataset(ctx context.Context, dataset *tnsapi.Dataset, params *nfsVolumeParams, datasetIsNew bool, generation string, timer *metrics.OperationTimer) (*tnsapi.NFSShare, error) {
+	comment := volumeShareComment(params.volumeName, params.requestedCapacity,
+		params.pvcNamespace, params.pvcName, params.pvName, params.comment)
+	nfsShare, err := s.apiClient.CreateNFSShare(ctx, params.shareAccess.shareCreateParams(dataset.Mountpoint, comment))
 	if err != nil {
 		klog.Errorf("Failed to create NFS share for dataset %s (mountpoint: %s): %v", dataset.ID, dataset.Mountpoint, err)
 		if datasetIsNew {
@@ -492,21 +445,18 @@ func (s *ControllerService) createNFSShareForDataset(ctx context.Context, datase
 
 	klog.V(4).Infof("Created NFS share with ID: %d for path: %s", nfsShare.ID, nfsShare.Path)
 
+	// A later attempt claimed the dataset: leave the volume to it (the dataset is in use by it)
+	if !s.ownsCreation(ctx, dataset.ID, generation) {
+		if delErr := s.apiClient.DeleteNFSShare(ctx, nfsShare.ID); delErr != nil {
+			klog.Errorf("Failed to remove duplicate NFS share %d: %v", nfsShare.ID, delErr)
+		}
+		timer.ObserveError()
+		return nil, creationSupersededError(params.volumeName)
+	}
+
 	// Store ZFS user properties for CSI metadata tracking (Schema v1)
 	// This enables safe deletion (verify ownership before delete), debugging, and cross-cluster adoption
-	props := tnsapi.NFSVolumePropertiesV1(tnsapi.NFSVolumeParams{
-		VolumeID:       params.volumeName,
-		CapacityBytes:  params.requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: params.deleteStrategy,
-		ShareID:        nfsShare.ID,
-		SharePath:      nfsShare.Path,
-		PVCName:        params.pvcName,
-		PVCNamespace:   params.pvcNamespace,
-		StorageClass:   params.storageClass,
-		Adoptable:      params.markAdoptable,
-		ClusterID:      s.clusterID,
-	})
+	props := s.volumeProperties(ProtocolNFS, &params.volumeParams, tnsapi.NFSShareProperties(nfsShare.ID, nfsShare.Path))
 	klog.V(4).Infof("Storing ZFS properties on dataset %s: deleteStrategy=%q, props=%v", dataset.ID, params.deleteStrategy, props)
 	if err := s.apiClient.SetDatasetProperties(ctx, dataset.ID, props); err != nil {
 		// Log warning but don't fail - properties are not critical for basic operation
@@ -519,17 +469,18 @@ func (s *ControllerService) createNFSShareForDataset(ctx context.Context, datase
 	return nfsShare, nil
 }
 
-// createNFSVolume creates an NFS volume with a ZFS dataset and NFS share.
-func (s *ControllerService) createNFSVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+// Provision creates an NFS volume with a ZFS dataset and NFS share.
+func (s nfsController) Provision(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolNFS, "create")
 	klog.V(4).Info("Creating NFS volume")
 
 	// Validate and extract parameters
-	params, err := validateNFSParams(req)
+	params, err := s.validateNFSParams(req)
 	if err != nil {
 		timer.ObserveError()
 		return nil, err
 	}
+	params.server = s.nfsServers.resolve(params.server)
 
 	klog.V(4).Infof("Creating dataset: %s with capacity: %d bytes", params.datasetName, params.requestedCapacity)
 
@@ -540,6 +491,11 @@ func (s *ControllerService) createNFSVolume(ctx context.Context, req *csi.Create
 		return nil, status.Errorf(codes.Internal, "Failed to query existing datasets: %v", err)
 	}
 
+	// Volumes below a shared parent export have no share of their own
+	if params.shareStrategy == NFSShareStrategyParent {
+		return s.createParentSharedNFSVolume(ctx, params, existingDatasets, timer)
+	}
+
 	// Handle existing dataset (idempotency check)
 	if len(existingDatasets) > 0 {
 		resp, done, handleErr := s.handleExistingNFSVolume(ctx, params, &existingDatasets[0], timer)
@@ -558,26 +514,48 @@ func (s *ControllerService) createNFSVolume(ctx context.Context, req *csi.Create
 		return nil, err
 	}
 
+	// Export the share in the background if requested (pod start gates on it in NodeStageVolume)
+	if params.deferShare {
+		return s.createDeferredNFSVolume(ctx, params, dataset, datasetIsNew, timer)
+	}
+
+	// Claim the dataset so a concurrent attempt does not create a second share
+	generation, err := s.claimCreation(ctx, dataset.ID)
+	if err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+	if !datasetIsNew {
+		// An earlier attempt may have finished the share in the meantime
+		resp, done, handleErr := s.handleExistingNFSVolume(ctx, params, dataset, timer)
+		if handleErr != nil {
+			return nil, handleErr
+		}
+		if done {
+			return resp, nil
+		}
+	}
+
 	// Create NFS share for the dataset
-	nfsShare, err := s.createNFSShareForDataset(ctx, dataset, params, datasetIsNew, timer)
+	nfsShare, err := s.createNFSShareForDataset(ctx, dataset, params, datasetIsNew, generation, timer)
 	if err != nil {
 		return nil, err
 	}
 
 	// Build and return response
-	resp := buildNFSVolumeResponse(params.volumeName, params.server, dataset, nfsShare, params.requestedCapacity)
+	resp := buildNFSVolumeResponse(params.volumeName, params.server, dataset, nfsShare, s.provisionedCapacity(ctx, dataset, params.requestedCapacity))
 
 	klog.Infof("Created NFS volume: %s", params.volumeName)
 	timer.ObserveSuccess()
 	return resp, nil
 }
 
-// deleteNFSVolume deletes an NFS volume with ownership verification.
+// Teardown deletes an NFS volume with ownership verification.
 // Dataset deletion is retried for busy resource errors.
 // If deleteStrategy is "retain", the volume is kept but CSI returns success.
 //
 //nolint:dupl,gocyclo,gocognit // Intentionally similar dataset deletion pattern as iSCSI; complexity from ownership checks + CSI snapshot guard + dependent clones guard
-func (s *ControllerService) deleteNFSVolume(ctx context.Context, meta *VolumeMetadata) (*csi.DeleteVolumeResponse, error) {
+func (s nfsController) Teardown(ctx context.Context, meta *VolumeMetadata) (*csi.DeleteVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolNFS, verbDelete)
 	klog.V(4).Infof("Deleting NFS volume: %s (dataset: %s, share ID: %d)", meta.Name, meta.DatasetName, meta.NFSShareID)
 
@@ -585,12 +563,13 @@ func (s *ControllerService) deleteNFSVolume(ctx context.Context, meta *VolumeMet
 	// This prevents accidental deletion if share IDs were reused after TrueNAS restart
 	// Also check deleteStrategy to determine if we should actually delete
 	deleteStrategy := tnsapi.DeleteStrategyDelete // Default to delete
-	klog.V(4).Infof("deleteNFSVolume called for volume %s, datasetID=%q", meta.Name, meta.DatasetID)
+	klog.V(4).Infof("NFS Teardown called for volume %s, datasetID=%q", meta.Name, meta.DatasetID)
 	if meta.DatasetID != "" {
 		props, err := s.apiClient.GetDatasetProperties(ctx, meta.DatasetID, []string{
 			tnsapi.PropertyManagedBy,
 			tnsapi.PropertyCSIVolumeName,
 			tnsapi.PropertyNFSShareID,
+			tnsapi.PropertyNFSShareStrategy,
 			tnsapi.PropertyDeleteStrategy,
 		})
 		if err != nil {
@@ -635,6 +614,11 @@ func (s *ControllerService) deleteNFSVolume(ctx context.Context, meta *VolumeMet
 				}
 			}
 
+			// Never delete a shared parent export along with one of its volumes
+			if props[tnsapi.PropertyNFSShareStrategy] == NFSShareStrategyParent {
+				meta.NFSShareID = 0
+			}
+
 			// Check deleteStrategy
 			if strategy, ok := props[tnsapi.PropertyDeleteStrategy]; ok && strategy != "" {
 				klog.V(4).Infof("Found deleteStrategy property: %q", strategy)
@@ -697,39 +681,32 @@ func (s *ControllerService) deleteNFSVolume(ctx context.Context, meta *VolumeMet
 
 		klog.V(4).Infof("Deleting dataset: %s", meta.DatasetID)
 
-		firstErr := s.apiClient.DeleteDataset(ctx, meta.DatasetID)
+		firstErr := s.deleteVolumeDataset(ctx, meta)
 		if firstErr != nil && !isNotFoundError(firstErr) {
-			resolved := false
 			if isDependentClonesError(firstErr) {
-				if err := s.tryPromoteAndDeleteDataset(ctx, meta.DatasetID); err == nil {
-					resolved = true
-				} else {
-					klog.Warningf("Dataset %s has dependent clones — cannot delete", meta.DatasetID)
-					timer.ObserveError()
-					return nil, status.Errorf(codes.FailedPrecondition,
-						"cannot delete volume %s: dataset %s has dependent clones; delete the cloned volumes first",
-						meta.Name, meta.DatasetID)
-				}
+				klog.Warningf("Dataset %s has dependent clones — cannot delete", meta.DatasetID)
+				timer.ObserveError()
+				return nil, status.Errorf(codes.FailedPrecondition,
+					"cannot delete volume %s: dataset %s has dependent clones; delete the cloned volumes first",
+					meta.Name, meta.DatasetID)
 			}
 
-			if !resolved {
-				klog.Infof("Direct deletion failed for %s: %v — cleaning up snapshots before retry",
-					meta.DatasetID, firstErr)
-				s.deleteDatasetSnapshots(ctx, meta.DatasetID)
-
-				retryConfig := retry.DeletionConfig("delete-nfs-dataset")
-				err := retry.WithRetryNoResult(ctx, retryConfig, func() error {
-					deleteErr := s.apiClient.DeleteDataset(ctx, meta.DatasetID)
-					if deleteErr != nil && isNotFoundError(deleteErr) {
-						return nil
-					}
-					return deleteErr
-				})
-
-				if err != nil {
-					timer.ObserveError()
-					return nil, status.Errorf(codes.Internal, "Failed to delete dataset %s: %v", meta.DatasetID, err)
+			klog.Infof("Direct deletion failed for %s: %v — cleaning up snapshots before retry",
+				meta.DatasetID, firstErr)
+			s.deleteVolumeSnapshots(ctx, meta)
+
+			retryConfig := retry.DeletionConfig("delete-nfs-dataset")
+			err := retry.WithRetryNoResult(ctx, retryConfig, func() error {
+				deleteErr := s.deleteVolumeDataset(ctx, meta)
+				if deleteErr != nil && isNotFoundError(deleteErr) {
+					return nil
 				}
+				return deleteErr
+			})
+
+			if err != nil {
+				timer.ObserveError()
+				return nil, status.Errorf(codes.Internal, "Failed to delete dataset %s: %v", meta.DatasetID, err)
 			}
 		}
 		klog.V(4).Infof("Successfully deleted dataset %s", meta.DatasetID)
@@ -744,227 +721,104 @@ func (s *ControllerService) deleteNFSVolume(ctx context.Context, meta *VolumeMet
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
-// setupNFSVolumeFromClone sets up an NFS share for a cloned dataset.
-func (s *ControllerService) setupNFSVolumeFromClone(ctx context.Context, req *csi.CreateVolumeRequest, dataset *tnsapi.Dataset, server string, info *cloneInfo) (*csi.CreateVolumeResponse, error) {
-	klog.V(4).Infof("Setting up NFS share for cloned dataset: %s (cloneMode: %s)", dataset.Name, info.Mode)
-
-	volumeName := req.GetName()
-
-	// Create NFS share for the cloned dataset
-	nfsShare, err := s.apiClient.CreateNFSShare(ctx, tnsapi.NFSShareCreateParams{
-		Path:         dataset.Mountpoint,
-		Comment:      "CSI Volume (from snapshot): " + volumeName,
-		MaprootUser:  zfsACLModeRoot,
-		MaprootGroup: zfsACLModeWheel,
-		Enabled:      true,
-	})
+// ExportClone exports a cloned dataset with its own NFS share, or through its parent's export
+// with shareStrategy=parent.
+func (s nfsController) ExportClone(ctx context.Context, req *csi.CreateVolumeRequest, dataset *tnsapi.Dataset, params *volumeParams) (*volumeExport, error) {
+	shareAccess, err := nfsShareAccessForRequest(req)
 	if err != nil {
-		// Cleanup: delete the cloned dataset if NFS share creation fails
-		klog.Errorf("Failed to create NFS share for cloned dataset, cleaning up: %v", err)
-		if delErr := s.apiClient.DeleteDataset(ctx, dataset.ID); delErr != nil {
-			klog.Errorf("Failed to cleanup cloned dataset after NFS share creation failure: %v", delErr)
-		}
-		return nil, status.Errorf(codes.Internal, "Failed to create NFS share for cloned volume: %v", err)
-	}
-
-	klog.V(4).Infof("Created NFS share with ID: %d for cloned dataset path: %s", nfsShare.ID, nfsShare.Path)
-
-	// Get requested capacity (needed before creating metadata)
-	requestedCapacity := req.GetCapacityRange().GetRequiredBytes()
-	if requestedCapacity == 0 {
-		requestedCapacity = 1 * 1024 * 1024 * 1024 // Default 1GB
-	}
-
-	// Get deleteStrategy and adoption metadata from StorageClass parameters
-	params := req.GetParameters()
-	deleteStrategy := params["deleteStrategy"]
-	if deleteStrategy == "" {
-		deleteStrategy = tnsapi.DeleteStrategyDelete
-	}
-
-	// Store ZFS user properties for CSI metadata tracking (Schema v1, including clone source info)
-	props := tnsapi.NFSVolumePropertiesV1(tnsapi.NFSVolumeParams{
-		VolumeID:       volumeName,
-		CapacityBytes:  requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: deleteStrategy,
-		ShareID:        nfsShare.ID,
-		SharePath:      nfsShare.Path,
-		PVCName:        params["csi.storage.k8s.io/pvc/name"],
-		PVCNamespace:   params["csi.storage.k8s.io/pvc/namespace"],
-		StorageClass:   params["csi.storage.k8s.io/sc/name"],
-		ClusterID:      s.clusterID,
-	})
-	// Add clone-specific properties (including clone mode for dependency tracking)
-	cloneProps := tnsapi.ClonedVolumePropertiesV2(tnsapi.ContentSourceSnapshot, info.SnapshotID, info.Mode, info.OriginSnapshot)
-	for k, v := range cloneProps {
-		props[k] = v
+		return nil, err
 	}
-	if err := s.apiClient.SetDatasetProperties(ctx, dataset.ID, props); err != nil {
-		klog.Warningf("Failed to set ZFS user properties on cloned dataset %s: %v (volume will still work)", dataset.ID, err)
-	} else {
-		klog.V(4).Infof("Stored ZFS user properties on cloned dataset %s: %v", dataset.ID, props)
+	shareStrategy, err := parseNFSShareStrategy(req.GetParameters())
+	if err != nil {
+		return nil, err
 	}
 
-	// Set dataset comment from commentTemplate (if configured) — CloneSnapshot doesn't support setting comments
-	if comment, commentErr := ResolveComment(req.GetParameters(), req.GetName()); commentErr == nil && comment != "" {
-		if _, err := s.apiClient.UpdateDataset(ctx, dataset.ID, tnsapi.DatasetUpdateParams{Comments: comment}); err != nil {
-			klog.Warningf("Failed to set comment on cloned dataset %s: %v (non-fatal)", dataset.ID, err)
-		}
+	if shareStrategy == NFSShareStrategyParent {
+		return s.exportBelowParent(ctx, dataset, params)
 	}
 
-	// Build volume metadata
-	meta := VolumeMetadata{
-		Name:        volumeName,
-		Protocol:    ProtocolNFS,
-		DatasetID:   dataset.ID,
-		DatasetName: dataset.Name,
-		Server:      server,
-		NFSShareID:  nfsShare.ID,
+	// Create NFS share for the cloned dataset
+	comment := withShareNote(withPVCComment("CSI Volume (from snapshot): "+params.volumeName, params.pvcNamespace, params.pvcName, params.pvName), params.comment)
+	nfsShare, err := s.apiClient.CreateNFSShare(ctx, shareAccess.shareCreateParams(dataset.Mountpoint, comment))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to create NFS share for cloned volume: %v", err)
 	}
-
-	// Volume ID is the full dataset path for O(1) lookups
-	volumeID := dataset.ID
-
-	// Construct volume context with metadata for node plugin
-	// CRITICAL: Add clonedFromSnapshot flag to prevent reformatting of cloned volumes
-	// ZFS clones inherit filesystems from snapshots, but detection may fail due to caching
-	volumeContext := buildVolumeContext(meta)
-	volumeContext[VolumeContextKeyShare] = dataset.Mountpoint
-	volumeContext[VolumeContextKeyClonedFromSnap] = VolumeContextValueTrue
-
-	klog.Infof("Created NFS volume from snapshot: %s", volumeName)
-
-	// Record volume capacity metric
-	metrics.SetVolumeCapacity(volumeID, metrics.ProtocolNFS, requestedCapacity)
-
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      volumeID,
-			CapacityBytes: requestedCapacity,
-			VolumeContext: volumeContext,
-			ContentSource: &csi.VolumeContentSource{
-				Type: &csi.VolumeContentSource_Snapshot{
-					Snapshot: &csi.VolumeContentSource_SnapshotSource{
-						SnapshotId: info.SnapshotID,
-					},
-				},
-			},
-		},
-	}, nil
+	klog.V(4).Infof("Created NFS share with ID: %d for cloned dataset path: %s", nfsShare.ID, nfsShare.Path)
+	return nfsExport(dataset, params, nfsShare), nil
 }
 
-// adoptNFSVolume adopts an orphaned NFS volume by re-creating its NFS share.
-// This is called when a volume is found by CSI name but needs to be adopted into a new cluster.
-func (s *ControllerService) adoptNFSVolume(ctx context.Context, req *csi.CreateVolumeRequest, dataset *tnsapi.DatasetWithProperties, params map[string]string) (*csi.CreateVolumeResponse, error) {
-	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolNFS, "adopt")
-	volumeName := req.GetName()
-	klog.Infof("Adopting NFS volume: %s (dataset=%s)", volumeName, dataset.ID)
-
-	// Get server parameter
-	server := params["server"]
-	if server == "" {
-		server = defaultServerAddress
+// exportBelowParent exports a dataset through its parent's shared export, creating the export
+// if needed. The shared export's ID is not recorded: DeleteVolume must never remove it.
+func (s nfsController) exportBelowParent(ctx context.Context, dataset *tnsapi.Dataset, params *volumeParams) (*volumeExport, error) {
+	exportPath, subPath := splitParentExportPath(dataset.Mountpoint)
+	parentShare, err := s.ensureParentNFSShare(ctx, exportPath)
+	if err != nil {
+		return nil, err
 	}
+	export := nfsExport(dataset, params, &tnsapi.NFSShare{Path: parentShare.Path})
+	export.properties[tnsapi.PropertyNFSShareStrategy] = NFSShareStrategyParent
+	setParentExportContext(export.context, parentShare.Path, subPath)
+	return export, nil
+}
 
-	// Get requested capacity (use existing if not expanded)
-	requestedCapacity := req.GetCapacityRange().GetRequiredBytes()
-	if requestedCapacity == 0 {
-		requestedCapacity = 1 * 1024 * 1024 * 1024 // 1 GiB default
+// nfsExport describes a dataset exported with an NFS share.
+func nfsExport(dataset *tnsapi.Dataset, params *volumeParams, nfsShare *tnsapi.NFSShare) *volumeExport {
+	return &volumeExport{
+		meta: VolumeMetadata{
+			Name:        params.volumeName,
+			Protocol:    ProtocolNFS,
+			DatasetID:   dataset.ID,
+			DatasetName: dataset.Name,
+			Server:      params.server,
+			NFSShareID:  nfsShare.ID,
+		},
+		context:    map[string]string{VolumeContextKeyShare: dataset.Mountpoint},
+		properties: tnsapi.NFSShareProperties(nfsShare.ID, nfsShare.Path),
 	}
+}
 
+// ExportAdopted re-creates the NFS share of an orphaned volume, or reuses its existing share.
+func (s nfsController) ExportAdopted(ctx context.Context, req *csi.CreateVolumeRequest, dataset *tnsapi.DatasetWithProperties, params *volumeParams) (*volumeExport, error) {
 	// Check if dataset has a mountpoint
 	if dataset.Mountpoint == "" {
-		timer.ObserveError()
 		return nil, status.Errorf(codes.Internal, "Dataset %s has no mountpoint", dataset.ID)
 	}
 
+	// Volumes below a shared parent export stay there
+	if dataset.UserProperties[tnsapi.PropertyNFSShareStrategy].Value == NFSShareStrategyParent ||
+		req.GetParameters()[NFSShareStrategyParam] == NFSShareStrategyParent {
+		return s.exportBelowParent(ctx, &dataset.Dataset, params)
+	}
+
 	// Check if an NFS share already exists for this mountpoint
 	existingShares, err := s.apiClient.QueryNFSShare(ctx, dataset.Mountpoint)
 	if err != nil {
 		klog.Warningf("Failed to query NFS shares for %s: %v", dataset.Mountpoint, err)
 	}
-
-	var nfsShare *tnsapi.NFSShare
 	if len(existingShares) > 0 {
-		// NFS share already exists - use it
-		nfsShare = &existingShares[0]
-		klog.Infof("Found existing NFS share for adopted volume: ID=%d, path=%s", nfsShare.ID, nfsShare.Path)
-	} else {
-		// Create new NFS share
-		klog.Infof("Creating NFS share for adopted volume: %s", dataset.Mountpoint)
-		comment := fmt.Sprintf("CSI Volume: %s | Capacity: %d", volumeName, requestedCapacity)
-		newShare, createErr := s.apiClient.CreateNFSShare(ctx, tnsapi.NFSShareCreateParams{
-			Path:         dataset.Mountpoint,
-			Comment:      comment,
-			MaprootUser:  zfsACLModeRoot,
-			MaprootGroup: zfsACLModeWheel,
-			Enabled:      true,
-		})
-		if createErr != nil {
-			timer.ObserveError()
-			return nil, status.Errorf(codes.Internal, "Failed to create NFS share for adopted volume: %v", createErr)
-		}
-		nfsShare = newShare
-		klog.Infof("Created NFS share for adopted volume: ID=%d, path=%s", nfsShare.ID, nfsShare.Path)
-	}
-
-	// Update ZFS properties with new share ID
-	deleteStrategy := params["deleteStrategy"]
-	if deleteStrategy == "" {
-		deleteStrategy = tnsapi.DeleteStrategyDelete
-	}
-	markAdoptable := params["markAdoptable"] == VolumeContextValueTrue
-
-	props := tnsapi.NFSVolumePropertiesV1(tnsapi.NFSVolumeParams{
-		VolumeID:       volumeName,
-		CapacityBytes:  requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: deleteStrategy,
-		ShareID:        nfsShare.ID,
-		SharePath:      nfsShare.Path,
-		PVCName:        params["csi.storage.k8s.io/pvc/name"],
-		PVCNamespace:   params["csi.storage.k8s.io/pvc/namespace"],
-		StorageClass:   params["csi.storage.k8s.io/sc/name"],
-		Adoptable:      markAdoptable,
-		ClusterID:      s.clusterID,
-	})
-	if propErr := s.apiClient.SetDatasetProperties(ctx, dataset.ID, props); propErr != nil {
-		klog.Warningf("Failed to update ZFS properties on adopted volume %s: %v", dataset.ID, propErr)
-	}
-
-	// Build response
-	meta := VolumeMetadata{
-		Name:        volumeName,
-		Protocol:    ProtocolNFS,
-		DatasetID:   dataset.ID,
-		DatasetName: dataset.Name,
-		Server:      server,
-		NFSShareID:  nfsShare.ID,
+		klog.Infof("Found existing NFS share for adopted volume: ID=%d, path=%s", existingShares[0].ID, existingShares[0].Path)
+		return nfsExport(&dataset.Dataset, params, &existingShares[0]), nil
 	}
 
-	volumeContext := buildVolumeContext(meta)
-	volumeContext[VolumeContextKeyShare] = dataset.Mountpoint
-
-	// Record volume capacity metric
-	metrics.SetVolumeCapacity(volumeName, metrics.ProtocolNFS, requestedCapacity)
-
-	klog.Infof("Successfully adopted NFS volume: %s (shareID=%d)", volumeName, nfsShare.ID)
-	timer.ObserveSuccess()
-
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      dataset.ID,
-			CapacityBytes: requestedCapacity,
-			VolumeContext: volumeContext,
-		},
-	}, nil
+	klog.Infof("Creating NFS share for adopted volume: %s", dataset.Mountpoint)
+	shareAccess, err := nfsShareAccessForRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	comment := volumeShareComment(params.volumeName, params.requestedCapacity,
+		params.pvcNamespace, params.pvcName, params.pvName, params.comment)
+	nfsShare, err := s.apiClient.CreateNFSShare(ctx, shareAccess.shareCreateParams(dataset.Mountpoint, comment))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to create NFS share for adopted volume: %v", err)
+	}
+	klog.Infof("Created NFS share for adopted volume: ID=%d, path=%s", nfsShare.ID, nfsShare.Path)
+	return nfsExport(&dataset.Dataset, params, nfsShare), nil
 }
 
-// expandNFSVolume expands an NFS volume by updating the dataset quota.
+// Expand expands an NFS volume by updating the dataset quota.
 //
-//nolint:dupl // Similar to expandNVMeOFVolume but with different parameters (Quota vs Volsize, NodeExpansionRequired)
-func (s *ControllerService) expandNFSVolume(ctx context.Context, meta *VolumeMetadata, requiredBytes int64) (*csi.ControllerExpandVolumeResponse, error) {
+//nolint:dupl // Similar to nvmeofController.Expand but with different parameters (Quota vs Volsize, NodeExpansionRequired)
+func (s nfsController) Expand(ctx context.Context, meta *VolumeMetadata, requiredBytes int64) (*csi.ControllerExpandVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolNFS, "expand")
 	klog.V(4).Infof("Expanding NFS volume: %s (dataset: %s) to %d bytes", meta.Name, meta.DatasetName, requiredBytes)
 
@@ -979,12 +833,11 @@ func (s *ControllerService) expandNFSVolume(ctx context.Context, meta *VolumeMet
 	klog.V(4).Infof("Expanding NFS dataset - DatasetID: %s, DatasetName: %s, New RefQuota: %d bytes",
 		meta.DatasetID, meta.DatasetName, requiredBytes)
 
-	updateParams := tnsapi.DatasetUpdateParams{
-		RefQuota: &requiredBytes,
-	}
-
-	_, err := s.apiClient.UpdateDataset(ctx, meta.DatasetID, updateParams)
-	if err != nil {
+	// Resize and record the new capacity, which idempotent CreateVolume calls compare with, in one update
+	batch := tnsapi.NewDatasetUpdateBatch(meta.DatasetID).
+		SetProperty(tnsapi.PropertyCapacityBytes, strconv.FormatInt(requiredBytes, 10))
+	batch.Params = tnsapi.DatasetUpdateParams{RefQuota: &requiredBytes}
+	if err := batch.Apply(ctx, s.apiClient); err != nil {
 		// Provide detailed error information to help diagnose dataset issues
 		klog.Errorf("Failed to update dataset refquota for %s (Name: %s): %v", meta.DatasetID, meta.DatasetName, err)
 		timer.ObserveError()
@@ -1005,3 +858,123 @@ func (s *ControllerService) expandNFSVolume(ctx context.Context, meta *VolumeMet
 		NodeExpansionRequired: false, // NFS volumes don't require node-side expansion
 	}, nil
 }
+
+// FindExisting validates an existing NFS volume for idempotency.
+func (s nfsController) FindExisting(ctx context.Context, req *csi.CreateVolumeRequest, params map[string]string, existingDataset *tnsapi.Dataset, expectedDatasetName string, reqCapacity int64) (VolumeMetadata, map[string]string, error) {
+	// Query for NFS share to get share ID
+	shares, err := s.apiClient.QueryNFSShare(ctx, existingDataset.Mountpoint)
+	if err != nil {
+		klog.Errorf("Failed to query NFS shares for existing volume: %v", err)
+		return VolumeMetadata{}, nil, ErrVolumeNotFound
+	}
+
+	if len(shares) == 0 {
+		klog.Errorf("No NFS share found for dataset %s (mountpoint: %s)", expectedDatasetName, existingDataset.Mountpoint)
+		return VolumeMetadata{}, nil, ErrVolumeNotFound
+	}
+
+	// Validate capacity compatibility
+	existingCapacity := s.nfsVolumeCapacity(ctx, existingDataset.ID, shares[0].Comment)
+	if existingCapacity > 0 && existingCapacity < reqCapacity && expandsExistingVolumes(params) {
+		// Provision grows the volume
+		return VolumeMetadata{}, nil, ErrVolumeNotFound
+	}
+	if err := validateCapacityCompatibility(req.GetName(), existingDataset.ID, existingCapacity, reqCapacity); err != nil {
+		return VolumeMetadata{}, nil, err
+	}
+
+	// Get server parameter
+	server := params["server"]
+	if server == "" {
+		server = "defaultServerAddress" // Default for testing
+	}
+
+	volumeMeta := VolumeMetadata{
+		Name:        req.GetName(),
+		Protocol:    ProtocolNFS,
+		DatasetID:   existingDataset.ID,
+		DatasetName: expectedDatasetName,
+		Server:      server,
+		NFSShareID:  shares[0].ID,
+	}
+
+	volumeContext := map[string]string{
+		VolumeContextKeyServer: server,
+		"share":                existingDataset.Mountpoint,
+		"datasetID":            existingDataset.ID,
+		"datasetName":          expectedDatasetName,
+		"nfsShareID":           strconv.Itoa(shares[0].ID),
+	}
+
+	return volumeMeta, volumeContext, nil
+}
+
+// Describe retrieves volume information and health status for an NFS volume.
+func (s nfsController) Describe(ctx context.Context, meta *VolumeMetadata) (*csi.ControllerGetVolumeResponse, error) {
+	klog.V(4).Infof("Getting NFS volume info: %s (dataset: %s, shareID: %d)", meta.Name, meta.DatasetName, meta.NFSShareID)
+
+	abnormal := false
+	var messages []string
+
+	// Check 1: Verify dataset exists
+	dataset, err := s.apiClient.Dataset(ctx, meta.DatasetName)
+	if err != nil || dataset == nil {
+		abnormal = true
+		messages = append(messages, fmt.Sprintf("Dataset %s not accessible: %v", meta.DatasetName, err))
+	} else {
+		klog.V(4).Infof("Dataset %s exists (ID: %s)", meta.DatasetName, dataset.ID)
+	}
+
+	// Check 2: Verify NFS share exists and is enabled
+	if meta.NFSShareID > 0 {
+		foundShare, err := s.apiClient.QueryNFSShareByID(ctx, meta.NFSShareID)
+		if err != nil {
+			abnormal = true
+			messages = append(messages, fmt.Sprintf("Failed to query NFS share %d: %v", meta.NFSShareID, err))
+		} else {
+			switch {
+			case foundShare == nil:
+				abnormal = true
+				messages = append(messages, fmt.Sprintf("NFS share %d not found", meta.NFSShareID))
+			case !foundShare.Enabled:
+				abnormal = true
+				messages = append(messages, fmt.Sprintf("NFS share %d is disabled", meta.NFSShareID))
+			default:
+				klog.V(4).Infof("NFS share %d is healthy (enabled: %t, path: %s)", foundShare.ID, foundShare.Enabled, foundShare.Path)
+			}
+		}
+	}
+
+	// Build response message
+	message := msgVolumeIsHealthy
+	if abnormal {
+		message = strings.Join(messages, "; ")
+	}
+
+	// Build volume context
+	volumeContext := buildVolumeContext(*meta)
+
+	// Get capacity from dataset if available
+	var capacityBytes int64
+	if dataset != nil && dataset.Available != nil {
+		if val, ok := dataset.Available["parsed"].(float64); ok {
+			capacityBytes = int64(val)
+		}
+	}
+
+	klog.V(4).Infof("NFS volume %s status: abnormal=%t, message=%s", meta.Name, abnormal, message)
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      meta.Name,
+			CapacityBytes: capacityBytes,
+			VolumeContext: volumeContext,
+		},
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			VolumeCondition: &csi.VolumeCondition{
+				Abnormal: abnormal,
+				Message:  message,
+			},
+		},
+	}, nil
+}
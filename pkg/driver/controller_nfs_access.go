@@ -0,0 +1,110 @@
+package driver
+
+import (
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Access mode-aware NFS exports.
+//
+// The NFS share is shaped by the access modes requested for the volume:
+//   - ReadOnlyMany restores/clones: exported read-only (on top of ZFS readonly=on)
+//   - ReadWriteMany: every client user is mapped (mapall) to one identity, so files written
+//     from different nodes, whose UIDs rarely line up, stay readable and writable by all pods
+//   - everything else: root is mapped to root (maproot), other users keep their UIDs
+//
+// The mapall identity defaults to root:wheel and can be changed with the nfs.mapallUser and
+// nfs.mapallGroup StorageClass parameters. Kerberos volumes (nfs.security, see nfs_kerberos.go)
+// are exported for their security flavor only.
+const (
+	NFSMapallUserParam  = "nfs.mapallUser"
+	NFSMapallGroupParam = "nfs.mapallGroup"
+)
+
+// nfsShareAccess holds the access mode-dependent options of an NFS export.
+type nfsShareAccess struct {
+	mapallUser  string
+	mapallGroup string
+	security    string // Kerberos flavor ("" = server default)
+	readOnly    bool
+}
+
+// isMultiWriterVolumeRequest reports whether any requested capability allows writers on several nodes.
+func isMultiWriterVolumeRequest(caps []*csi.VolumeCapability) bool {
+	for _, c := range caps {
+		if c.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER {
+			return true
+		}
+	}
+	return false
+}
+
+// nfsShareAccessForRequest derives NFS export options from a CreateVolume request.
+func nfsShareAccessForRequest(req *csi.CreateVolumeRequest) (nfsShareAccess, error) {
+	security, err := parseNFSSecurity(req.GetParameters())
+	if err != nil {
+		return nfsShareAccess{}, err
+	}
+	access := nfsShareAccess{readOnly: isReadOnlyContentSourceRequest(req), security: security}
+	if access.readOnly || !isMultiWriterVolumeRequest(req.GetVolumeCapabilities()) {
+		return access, nil
+	}
+
+	params := req.GetParameters()
+	access.mapallUser = strings.TrimSpace(params[NFSMapallUserParam])
+	access.mapallGroup = strings.TrimSpace(params[NFSMapallGroupParam])
+	if access.mapallUser == "" {
+		access.mapallUser = zfsACLModeRoot
+	}
+	if access.mapallGroup == "" {
+		access.mapallGroup = zfsACLModeWheel
+	}
+	if strings.ContainsAny(access.mapallUser+access.mapallGroup, ": ") {
+		return nfsShareAccess{}, status.Errorf(codes.InvalidArgument, "invalid %s/%s %q:%q: names must not contain ':' or spaces",
+			NFSMapallUserParam, NFSMapallGroupParam, access.mapallUser, access.mapallGroup)
+	}
+	return access, nil
+}
+
+// mapallProperty returns the PropertyNFSShareMapall value for the access options ("" for maproot).
+func (a nfsShareAccess) mapallProperty() string {
+	if a.mapallUser == "" {
+		return ""
+	}
+	return a.mapallUser + ":" + a.mapallGroup
+}
+
+// nfsShareAccessFromProperty restores export options recorded with mapallProperty.
+func nfsShareAccessFromProperty(value string) nfsShareAccess {
+	user, group, ok := strings.Cut(value, ":")
+	if !ok || user == "" {
+		return nfsShareAccess{}
+	}
+	return nfsShareAccess{mapallUser: user, mapallGroup: group}
+}
+
+// shareCreateParams builds the share creation parameters for a path.
+func (a nfsShareAccess) shareCreateParams(path, comment string) tnsapi.NFSShareCreateParams {
+	params := tnsapi.NFSShareCreateParams{
+		Path:     path,
+		Comment:  comment,
+		Enabled:  true,
+		ReadOnly: a.readOnly,
+	}
+	if a.security != "" {
+		params.Security = []string{strings.ToUpper(a.security)}
+	}
+	// TrueNAS rejects shares that set both maproot and mapall
+	if a.mapallUser != "" {
+		params.MapallUser = a.mapallUser
+		params.MapallGroup = a.mapallGroup
+	} else {
+		params.MaprootUser = zfsACLModeRoot
+		params.MaprootGroup = zfsACLModeWheel
+	}
+	return params
+}
@@ -0,0 +1,342 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/metrics"
+	"github.com/fenio/tns-csi/pkg/retry"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Deferred NFS share creation.
+//
+// With WaitForFirstConsumer binding, CreateVolume runs while a pod is waiting to start.
+// On busy storage systems creating and exporting the NFS share (which reloads the NFS
+// service) is the slow part. With deferShareCreation=true the controller validates the
+// parameters and creates the dataset synchronously, returns the volume immediately and
+// exports the share in the background. The dataset carries PropertyNFSSharePending until
+// the share exists, and NodeStageVolume waits on that marker (returning Unavailable so
+// kubelet retries) instead of attempting a mount that cannot succeed yet. Shares whose creation
+// gave up, or was interrupted by a controller restart, are picked up again by a periodic pass
+// over the datasets carrying the marker.
+//
+// Only plain NFS volumes are deferred: snapshot/clone restores and block protocols need
+// their export identifiers (NQN, IQN) in the volume context, so they stay synchronous.
+const (
+	// DeferShareCreationParam is the StorageClass parameter enabling deferred NFS share creation.
+	DeferShareCreationParam = "deferShareCreation"
+
+	// deferredShareTimeout bounds one background share creation attempt sequence.
+	deferredShareTimeout = 10 * time.Minute
+)
+
+// errDeferredShareCanceled is returned when a pending share job was canceled (volume deleted).
+var errDeferredShareCanceled = errors.New("deferred share creation canceled")
+
+// deferredShareRetryConfig configures background share creation (a variable so tests can shorten it).
+var deferredShareRetryConfig = func() retry.Config {
+	return retry.Config{
+		MaxAttempts:       6,
+		InitialBackoff:    2 * time.Second,
+		MaxBackoff:        30 * time.Second,
+		BackoffMultiplier: 2.0,
+		RetryableFunc: func(err error) bool {
+			return !errors.Is(err, errDeferredShareCanceled)
+		},
+		OperationName: "deferred-nfs-share",
+	}
+}
+
+// deferredShareResumeInterval is how often the controller resumes pending share creations
+// (a variable so tests can shorten it).
+var deferredShareResumeInterval = 5 * time.Minute
+
+// Node-side readiness gate timing (variables so tests can shorten them).
+var (
+	deferredShareNodeWait = 15 * time.Second
+	deferredSharePoll     = time.Second
+)
+
+// deferredShareTracker tracks in-flight background share jobs by dataset ID.
+// The zero value is ready to use.
+type deferredShareTracker struct {
+	jobs map[string]*deferredShareJob
+	mu   sync.Mutex
+}
+
+type deferredShareJob struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// start runs fn for datasetID in the background unless a job for it is already running.
+// Returns false if a job was already in flight.
+func (t *deferredShareTracker) start(datasetID string, fn func(ctx context.Context)) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.jobs == nil {
+		t.jobs = make(map[string]*deferredShareJob)
+	}
+	if _, running := t.jobs[datasetID]; running {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deferredShareTimeout)
+	job := &deferredShareJob{cancel: cancel, done: make(chan struct{})}
+	t.jobs[datasetID] = job
+
+	go func() {
+		defer func() {
+			cancel()
+			t.mu.Lock()
+			delete(t.jobs, datasetID)
+			t.mu.Unlock()
+			close(job.done)
+		}()
+		fn(ctx)
+	}()
+	return true
+}
+
+// cancelAndWait cancels the job for datasetID (if any) and waits for it to finish.
+func (t *deferredShareTracker) cancelAndWait(ctx context.Context, datasetID string) {
+	t.mu.Lock()
+	job := t.jobs[datasetID]
+	t.mu.Unlock()
+	if job == nil {
+		return
+	}
+
+	job.cancel()
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+	}
+}
+
+// createDeferredNFSVolume records the pending-share marker on a freshly provisioned dataset,
+// schedules the share creation and returns the volume without waiting for the export.
+func (s *ControllerService) createDeferredNFSVolume(ctx context.Context, params *nfsVolumeParams, dataset *tnsapi.Dataset, datasetIsNew bool, timer *metrics.OperationTimer) (*csi.CreateVolumeResponse, error) {
+	props := s.volumeProperties(ProtocolNFS, &params.volumeParams, tnsapi.NFSShareProperties(0, dataset.Mountpoint))
+	props[tnsapi.PropertyNFSSharePending] = VolumeContextValueTrue
+	if mapall := params.shareAccess.mapallProperty(); mapall != "" {
+		// Lets a restarted controller export the share with the same client mapping
+		props[tnsapi.PropertyNFSShareMapall] = mapall
+	}
+	if security := params.shareAccess.security; security != "" {
+		props[tnsapi.PropertyNFSShareSecurity] = security
+	}
+
+	// The marker is what gates node staging and drives recovery after a controller restart,
+	// so unlike regular metadata it must be written before the volume is handed out.
+	if err := s.apiClient.SetDatasetProperties(ctx, dataset.ID, props); err != nil {
+		if datasetIsNew {
+			if delErr := s.apiClient.DeleteDataset(ctx, dataset.ID); delErr != nil {
+				klog.Errorf("Failed to cleanup dataset %s after property failure: %v", dataset.ID, delErr)
+			}
+		}
+		timer.ObserveError()
+		return nil, status.Errorf(codes.Internal, "Failed to mark dataset %s for deferred share creation: %v", dataset.ID, err)
+	}
+
+	s.startDeferredNFSShare(dataset.ID, dataset.Mountpoint, params)
+
+	resp := buildNFSVolumeResponse(params.volumeName, params.server, dataset, &tnsapi.NFSShare{Path: dataset.Mountpoint}, s.provisionedCapacity(ctx, dataset, params.requestedCapacity))
+	resp.Volume.VolumeContext[VolumeContextKeySharePending] = VolumeContextValueTrue
+
+	klog.Infof("Created NFS volume %s (share creation deferred)", params.volumeName)
+	timer.ObserveSuccess()
+	return resp, nil
+}
+
+// startDeferredNFSShare schedules background share creation for a dataset.
+func (s *ControllerService) startDeferredNFSShare(datasetID, mountpoint string, params *nfsVolumeParams) {
+	started := s.deferredShares.start(datasetID, func(ctx context.Context) {
+		err := retry.WithRetryNoResult(ctx, deferredShareRetryConfig(), func() error {
+			return s.completeDeferredNFSShare(ctx, datasetID, mountpoint, params)
+		})
+		if err != nil {
+			klog.Errorf("Deferred NFS share creation for %s failed: %v (will resume in the next pass)", datasetID, err)
+		}
+	})
+	if !started {
+		klog.V(4).Infof("Deferred NFS share creation for %s already in progress", datasetID)
+	}
+}
+
+// completeDeferredNFSShare exports the dataset (reusing an existing share for its path) and
+// replaces the pending marker with the share metadata.
+func (s *ControllerService) completeDeferredNFSShare(ctx context.Context, datasetID, mountpoint string, params *nfsVolumeParams) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %s", errDeferredShareCanceled, datasetID)
+	}
+
+	var share *tnsapi.NFSShare
+	existing, err := s.apiClient.QueryAllNFSShares(ctx, mountpoint)
+	if err != nil {
+		return fmt.Errorf("failed to query NFS shares: %w", err)
+	}
+	for i := range existing {
+		if existing[i].Path == mountpoint {
+			share = &existing[i]
+			break
+		}
+	}
+
+	if share == nil {
+		comment := volumeShareComment(params.volumeName, params.requestedCapacity,
+			params.pvcNamespace, params.pvcName, params.pvName, params.comment)
+		share, err = s.apiClient.CreateNFSShare(ctx, params.shareAccess.shareCreateParams(mountpoint, comment))
+		if err != nil {
+			return fmt.Errorf("failed to create NFS share for %s: %w", mountpoint, err)
+		}
+	}
+
+	// Record the share even if the job was canceled meanwhile, so DeleteVolume finds and removes it.
+	// Use a fresh context for that write: ctx may already be canceled.
+	propCtx, cancel := context.WithTimeout(context.Background(), s.timeouts.provisioning())
+	defer cancel()
+	batch := tnsapi.NewDatasetUpdateBatch(datasetID).
+		SetProperty(tnsapi.PropertyNFSShareID, strconv.Itoa(share.ID)).
+		SetProperty(tnsapi.PropertyNFSSharePath, share.Path).
+		RemoveProperty(tnsapi.PropertyNFSSharePending)
+	if err := batch.Apply(propCtx, s.apiClient); err != nil {
+		return fmt.Errorf("failed to record NFS share %d on %s: %w", share.ID, datasetID, err)
+	}
+
+	klog.Infof("Deferred NFS share %d for %s is ready", share.ID, datasetID)
+	return nil
+}
+
+// resumeDeferredNFSShares restarts share creation for volumes of this cluster whose share is still
+// pending and not being created, i.e. left behind by a previous controller or by a creation that
+// gave up.
+func (s *ControllerService) resumeDeferredNFSShares(ctx context.Context) {
+	datasets, err := s.apiClient.FindDatasetsByProperty(ctx, "", tnsapi.PropertyNFSSharePending, VolumeContextValueTrue)
+	if err != nil {
+		klog.Warningf("Failed to look up volumes with pending NFS shares: %v", err)
+		return
+	}
+
+	for i := range datasets {
+		ds := &datasets[i]
+		prop := func(name string) string { return ds.UserProperties[name].Value }
+		if owner := prop(tnsapi.PropertyClusterID); owner != "" && owner != s.clusterID {
+			continue
+		}
+
+		mountpoint := ds.Mountpoint
+		if mountpoint == "" {
+			mountpoint = prop(tnsapi.PropertyNFSSharePath)
+		}
+		params := &nfsVolumeParams{
+			volumeParams: volumeParams{
+				volumeName:        prop(tnsapi.PropertyCSIVolumeName),
+				requestedCapacity: tnsapi.StringToInt64(prop(tnsapi.PropertyCapacityBytes)),
+				pvcName:           prop(tnsapi.PropertyPVCName),
+				pvcNamespace:      prop(tnsapi.PropertyPVCNamespace),
+				pvName:            prop(tnsapi.PropertyPVName),
+			},
+			shareAccess: nfsShareAccessFromProperty(prop(tnsapi.PropertyNFSShareMapall)),
+		}
+		params.shareAccess.security = prop(tnsapi.PropertyNFSShareSecurity)
+		klog.Infof("Resuming deferred NFS share creation for %s", ds.ID)
+		s.startDeferredNFSShare(ds.ID, mountpoint, params)
+	}
+}
+
+// resumeDeferredWorkOnce starts resuming pending background work, right away and then every
+// deferredShareResumeInterval, the first time a controller RPC arrives. Node plugins never
+// receive controller RPCs, so only the controller picks up the work.
+func (s *ControllerService) resumeDeferredWorkOnce() {
+	if s.apiClient == nil {
+		return
+	}
+	s.deferredResumeOnce.Do(func() {
+		// For the lifetime of the controller: a nil channel is never closed
+		go s.runDeferredShareResumes(nil)
+	})
+}
+
+// runDeferredShareResumes resumes pending share creations every deferredShareResumeInterval
+// until stopCh is closed.
+func (s *ControllerService) runDeferredShareResumes(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(deferredShareResumeInterval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		s.resumeDeferredNFSShares(ctx)
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// deferredSharePending reports whether the volume was created with deferShareCreation, so its
+// share may not have been exported when it was provisioned.
+func deferredSharePending(volumeContext map[string]string) bool {
+	return volumeContext[VolumeContextKeySharePending] == VolumeContextValueTrue
+}
+
+// waitForDeferredShare is the node-side readiness gate for volumes created with deferShareCreation.
+// It waits briefly for the controller to finish exporting the share and returns Unavailable
+// (so kubelet retries with backoff) if it is still pending. Without the storage API
+// (--storage-api=false) the marker cannot be read; the mount itself is the gate then
+// (see uncheckedDeferredShare).
+func (s *NodeService) waitForDeferredShare(ctx context.Context, volumeID string, volumeContext map[string]string) error {
+	if !deferredSharePending(volumeContext) {
+		return nil
+	}
+	if s.apiClient == nil {
+		klog.V(4).Infof("Cannot check the deferred NFS share of volume %s without the storage API, trying the mount", volumeID)
+		return nil
+	}
+	datasetID := volumeContext[VolumeContextKeyDatasetID]
+	if datasetID == "" {
+		datasetID = volumeID
+	}
+
+	deadline := time.Now().Add(deferredShareNodeWait)
+	for {
+		props, err := s.apiClient.GetDatasetProperties(ctx, datasetID, []string{tnsapi.PropertyNFSSharePending})
+		if err != nil {
+			// Cannot tell: let the mount attempt decide
+			klog.Warningf("Failed to check share readiness for volume %s: %v", volumeID, err)
+			return nil
+		}
+		if props[tnsapi.PropertyNFSSharePending] != VolumeContextValueTrue {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return status.Errorf(codes.Unavailable, "NFS share for volume %s is still being created on the storage system", volumeID)
+		}
+
+		klog.V(4).Infof("Waiting for deferred NFS share of volume %s", volumeID)
+		select {
+		case <-ctx.Done():
+			return status.Errorf(codes.Unavailable, "NFS share for volume %s is still being created: %v", volumeID, ctx.Err())
+		case <-time.After(deferredSharePoll):
+		}
+	}
+}
+
+// uncheckedDeferredShare reports whether the volume's share may still be pending while the node
+// cannot read the marker. A failed mount is then reported as Unavailable, since the share is most
+// likely still being exported; kubelet retries with backoff as it would for a pending marker.
+func (s *NodeService) uncheckedDeferredShare(volumeContext map[string]string) bool {
+	return deferredSharePending(volumeContext) && s.apiClient == nil
+}
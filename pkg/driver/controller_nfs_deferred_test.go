@@ -0,0 +1,296 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/retry"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// propertyStoreClient records dataset user properties in memory on top of MockAPIClientForSnapshots.
+type propertyStoreClient struct {
+	*MockAPIClientForSnapshots
+	props map[string]map[string]string
+	mu    sync.Mutex
+}
+
+func newPropertyStoreClient(mock *MockAPIClientForSnapshots) *propertyStoreClient {
+	return &propertyStoreClient{MockAPIClientForSnapshots: mock, props: make(map[string]map[string]string)}
+}
+
+func (c *propertyStoreClient) SetDatasetProperties(_ context.Context, datasetID string, properties map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.props[datasetID] == nil {
+		c.props[datasetID] = make(map[string]string)
+	}
+	for k, v := range properties {
+		c.props[datasetID][k] = v
+	}
+	return nil
+}
+
+func (c *propertyStoreClient) ClearDatasetProperties(_ context.Context, datasetID string, propertyNames []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range propertyNames {
+		delete(c.props[datasetID], name)
+	}
+	return nil
+}
+
+func (c *propertyStoreClient) GetDatasetProperties(_ context.Context, datasetID string, propertyNames []string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make(map[string]string)
+	for _, name := range propertyNames {
+		if v, ok := c.props[datasetID][name]; ok {
+			result[name] = v
+		}
+	}
+	return result, nil
+}
+
+func (c *propertyStoreClient) property(datasetID, name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.props[datasetID][name]
+}
+
+func TestCreateNFSVolumeDeferredShare(t *testing.T) {
+	ctx := context.Background()
+	datasetID := "tank/csi/pvc-deferred"
+
+	release := make(chan struct{})
+	created := make(chan tnsapi.NFSShareCreateParams, 1)
+	client := newPropertyStoreClient(&MockAPIClientForSnapshots{
+		QueryAllDatasetsFunc: func(_ context.Context, _ string) ([]tnsapi.Dataset, error) {
+			return nil, nil
+		},
+		CreateDatasetFunc: func(_ context.Context, params tnsapi.DatasetCreateParams) (*tnsapi.Dataset, error) {
+			return &tnsapi.Dataset{ID: params.Name, Name: params.Name, Mountpoint: "/mnt/" + params.Name}, nil
+		},
+		QueryAllNFSSharesFunc: func(_ context.Context, _ string) ([]tnsapi.NFSShare, error) {
+			return nil, nil
+		},
+		CreateNFSShareFunc: func(_ context.Context, params tnsapi.NFSShareCreateParams) (*tnsapi.NFSShare, error) {
+			<-release
+			created <- params
+			return &tnsapi.NFSShare{ID: 42, Path: params.Path, Enabled: true}, nil
+		},
+	})
+	service := NewControllerService(client, NewNodeRegistry(), "")
+
+	resp, err := service.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "pvc-deferred",
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		}},
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+		Parameters: map[string]string{
+			"protocol":              ProtocolNFS,
+			"pool":                  "tank",
+			"parentDataset":         "tank/csi",
+			"server":                "10.0.0.1",
+			DeferShareCreationParam: VolumeContextValueTrue,
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+
+	// The volume is returned before the share exists
+	volCtx := resp.GetVolume().GetVolumeContext()
+	if volCtx[VolumeContextKeySharePending] != VolumeContextValueTrue {
+		t.Errorf("volume context %s = %q, want %q", VolumeContextKeySharePending, volCtx[VolumeContextKeySharePending], VolumeContextValueTrue)
+	}
+	if volCtx["share"] != "/mnt/"+datasetID {
+		t.Errorf("volume context share = %q, want %q", volCtx["share"], "/mnt/"+datasetID)
+	}
+	if got := client.property(datasetID, tnsapi.PropertyNFSSharePending); got != VolumeContextValueTrue {
+		t.Errorf("pending marker = %q, want %q", got, VolumeContextValueTrue)
+	}
+	if got := client.property(datasetID, tnsapi.PropertyNFSShareMapall); got != "root:wheel" {
+		t.Errorf("recorded mapall = %q, want %q", got, "root:wheel")
+	}
+
+	close(release)
+	select {
+	case params := <-created:
+		if params.Path != "/mnt/"+datasetID {
+			t.Errorf("share path = %q, want %q", params.Path, "/mnt/"+datasetID)
+		}
+		if params.MapallUser != zfsACLModeRoot || params.MaprootUser != "" {
+			t.Errorf("RWX share mapall=%q maproot=%q, want mapall only", params.MapallUser, params.MaprootUser)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("background share creation did not run")
+	}
+
+	// Wait for the job to finish recording the share
+	service.deferredShares.cancelAndWait(ctx, datasetID)
+	if got := client.property(datasetID, tnsapi.PropertyNFSShareID); got != "42" {
+		t.Errorf("recorded share ID = %q, want %q", got, "42")
+	}
+	if got := client.property(datasetID, tnsapi.PropertyNFSSharePending); got != "" {
+		t.Errorf("pending marker still set to %q after share creation", got)
+	}
+}
+
+func TestDeferredShareTrackerCancel(t *testing.T) {
+	var tracker deferredShareTracker
+
+	started := make(chan struct{})
+	var canceled bool
+	if !tracker.start("tank/pvc-1", func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		canceled = errors.Is(ctx.Err(), context.Canceled)
+	}) {
+		t.Fatal("start() = false for a new job")
+	}
+	<-started
+
+	if tracker.start("tank/pvc-1", func(context.Context) {}) {
+		t.Error("start() = true while a job for the same dataset is running")
+	}
+
+	tracker.cancelAndWait(context.Background(), "tank/pvc-1")
+	if !canceled {
+		t.Error("job was not canceled")
+	}
+	// Nothing to wait for once the job is gone
+	tracker.cancelAndWait(context.Background(), "tank/pvc-1")
+}
+
+func TestResumeDeferredNFSShares(t *testing.T) {
+	origInterval, origRetry := deferredShareResumeInterval, deferredShareRetryConfig
+	deferredShareResumeInterval = 10 * time.Millisecond
+	deferredShareRetryConfig = func() retry.Config {
+		return retry.Config{MaxAttempts: 1, OperationName: "deferred-nfs-share"}
+	}
+	defer func() { deferredShareResumeInterval, deferredShareRetryConfig = origInterval, origRetry }()
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	created := make(chan string, 1)
+	var client *propertyStoreClient
+	client = newPropertyStoreClient(&MockAPIClientForSnapshots{
+		FindDatasetsByPropertyFunc: func(_ context.Context, _, _, _ string) ([]tnsapi.DatasetWithProperties, error) {
+			var pending []tnsapi.DatasetWithProperties
+			for _, owner := range []string{"cluster-a", "cluster-b"} {
+				datasetID := "tank/csi/pvc-" + owner
+				if client.property(datasetID, tnsapi.PropertyNFSSharePending) != VolumeContextValueTrue {
+					continue
+				}
+				ds := tnsapi.DatasetWithProperties{UserProperties: map[string]tnsapi.UserProperty{
+					tnsapi.PropertyClusterID: {Value: owner},
+				}}
+				ds.ID = datasetID
+				ds.Mountpoint = "/mnt/" + datasetID
+				pending = append(pending, ds)
+			}
+			return pending, nil
+		},
+		QueryAllNFSSharesFunc: func(_ context.Context, _ string) ([]tnsapi.NFSShare, error) {
+			return nil, nil
+		},
+		CreateNFSShareFunc: func(_ context.Context, params tnsapi.NFSShareCreateParams) (*tnsapi.NFSShare, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts[params.Path]++
+			if attempts[params.Path] == 1 {
+				return nil, errors.New("NFS service reload timed out")
+			}
+			created <- params.Path
+			return &tnsapi.NFSShare{ID: 42, Path: params.Path, Enabled: true}, nil
+		},
+	})
+	for _, owner := range []string{"cluster-a", "cluster-b"} {
+		client.props["tank/csi/pvc-"+owner] = map[string]string{tnsapi.PropertyNFSSharePending: VolumeContextValueTrue}
+	}
+	service := NewControllerService(client, NewNodeRegistry(), "cluster-a")
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		service.runDeferredShareResumes(stopCh)
+		close(done)
+	}()
+	defer func() {
+		close(stopCh)
+		<-done
+	}()
+
+	// The first attempt gives up; a later pass creates the share
+	select {
+	case path := <-created:
+		if path != "/mnt/tank/csi/pvc-cluster-a" {
+			t.Errorf("created share for %s, want /mnt/tank/csi/pvc-cluster-a", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("share creation was not resumed after giving up")
+	}
+
+	service.deferredShares.cancelAndWait(context.Background(), "tank/csi/pvc-cluster-a")
+	mu.Lock()
+	defer mu.Unlock()
+	if n := attempts["/mnt/tank/csi/pvc-cluster-b"]; n != 0 {
+		t.Errorf("%d share creations for a volume of another cluster, want 0", n)
+	}
+}
+
+func TestWaitForDeferredShare(t *testing.T) {
+	origWait, origPoll := deferredShareNodeWait, deferredSharePoll
+	deferredShareNodeWait, deferredSharePoll = 50*time.Millisecond, 10*time.Millisecond
+	defer func() { deferredShareNodeWait, deferredSharePoll = origWait, origPoll }()
+
+	ctx := context.Background()
+	datasetID := "tank/csi/pvc-wait"
+	client := newPropertyStoreClient(&MockAPIClientForSnapshots{})
+	service := NewNodeService("node-1", client, true, nil, false, 5)
+	volCtx := map[string]string{
+		VolumeContextKeyDatasetID:    datasetID,
+		VolumeContextKeySharePending: VolumeContextValueTrue,
+	}
+
+	if err := client.SetDatasetProperties(ctx, datasetID, map[string]string{tnsapi.PropertyNFSSharePending: VolumeContextValueTrue}); err != nil {
+		t.Fatal(err)
+	}
+	err := service.waitForDeferredShare(ctx, datasetID, volCtx)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("waitForDeferredShare() while pending = %v, want Unavailable", err)
+	}
+
+	if err := client.ClearDatasetProperties(ctx, datasetID, []string{tnsapi.PropertyNFSSharePending}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.waitForDeferredShare(ctx, datasetID, volCtx); err != nil {
+		t.Errorf("waitForDeferredShare() after share creation = %v, want nil", err)
+	}
+
+	// Volumes without the marker never touch the API
+	if err := service.waitForDeferredShare(ctx, datasetID, map[string]string{}); err != nil {
+		t.Errorf("waitForDeferredShare() for a regular volume = %v, want nil", err)
+	}
+	if service.uncheckedDeferredShare(volCtx) {
+		t.Error("uncheckedDeferredShare() = true on a node with the storage API")
+	}
+
+	// Without the storage API the mount is the gate
+	noAPI := NewNodeService("node-1", nil, true, nil, false, 5)
+	if err := noAPI.waitForDeferredShare(ctx, datasetID, volCtx); err != nil {
+		t.Errorf("waitForDeferredShare() without the storage API = %v, want nil", err)
+	}
+	if !noAPI.uncheckedDeferredShare(volCtx) || noAPI.uncheckedDeferredShare(map[string]string{}) {
+		t.Error("uncheckedDeferredShare() without the storage API must only hold for deferred shares")
+	}
+}
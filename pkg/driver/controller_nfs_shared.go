@@ -0,0 +1,161 @@
+package driver
+
+import (
+	"context"
+	"path"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/metrics"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Shared parent NFS exports.
+//
+// By default every NFS volume gets its own export. Some TrueNAS configurations limit the
+// number of exports, and every export adds to mountd reload time. With shareStrategy: parent
+// a single export of the parent dataset covers all volumes below it: each volume is still a
+// child dataset with its own refquota, but is not exported itself. Nodes mount the parent
+// export and bind-mount the volume's subdirectory (VolumeContextKeySubPath) into pods.
+//
+// The parent export is created on first use and never deleted by the driver.
+const (
+	// NFSShareStrategyParam is the StorageClass parameter selecting how volumes are exported.
+	NFSShareStrategyParam = "shareStrategy"
+
+	// NFSShareStrategyDataset exports every volume's dataset (default).
+	NFSShareStrategyDataset = "dataset"
+
+	// NFSShareStrategyParent exports the parent dataset once and reaches volumes through it.
+	NFSShareStrategyParent = "parent"
+)
+
+// parseNFSShareStrategy validates the shareStrategy parameter and its combination with
+// per-export options. Returns NFSShareStrategyDataset when unset.
+func parseNFSShareStrategy(params map[string]string) (string, error) {
+	switch strategy := params[NFSShareStrategyParam]; strategy {
+	case "", NFSShareStrategyDataset:
+		return NFSShareStrategyDataset, nil
+	case NFSShareStrategyParent:
+		if params[DeferShareCreationParam] == VolumeContextValueTrue {
+			return "", status.Errorf(codes.InvalidArgument, "%s=%s cannot be combined with %s: the parent export is not created per volume",
+				NFSShareStrategyParam, NFSShareStrategyParent, DeferShareCreationParam)
+		}
+		if params[NFSMapallUserParam] != "" || params[NFSMapallGroupParam] != "" {
+			return "", status.Errorf(codes.InvalidArgument, "%s/%s cannot be combined with %s=%s: export options are shared by all volumes",
+				NFSMapallUserParam, NFSMapallGroupParam, NFSShareStrategyParam, NFSShareStrategyParent)
+		}
+		if security, _ := parseNFSSecurity(params); security != "" {
+			return "", status.Errorf(codes.InvalidArgument, "%s cannot be combined with %s=%s: export options are shared by all volumes",
+				NFSSecurityParam, NFSShareStrategyParam, NFSShareStrategyParent)
+		}
+		return strategy, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "invalid %s %q: must be %q or %q",
+			NFSShareStrategyParam, strategy, NFSShareStrategyDataset, NFSShareStrategyParent)
+	}
+}
+
+// splitParentExportPath returns the parent export path and the volume's subdirectory for a
+// dataset mountpoint (child datasets inherit their mountpoint below the parent's).
+func splitParentExportPath(mountpoint string) (exportPath, subPath string) {
+	return path.Dir(mountpoint), path.Base(mountpoint)
+}
+
+// ensureParentNFSShare returns the export of exportPath, creating it if needed.
+func (s *ControllerService) ensureParentNFSShare(ctx context.Context, exportPath string) (*tnsapi.NFSShare, error) {
+	findShare := func() (*tnsapi.NFSShare, error) {
+		shares, err := s.apiClient.QueryAllNFSShares(ctx, exportPath)
+		if err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			if shares[i].Path == exportPath {
+				return &shares[i], nil
+			}
+		}
+		return nil, nil //nolint:nilnil // nil share means the parent is not exported yet
+	}
+
+	share, err := findShare()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to query NFS shares for %s: %v", exportPath, err)
+	}
+	if share != nil {
+		if !share.Enabled {
+			klog.Warningf("Shared NFS export %s (ID %d) is disabled; volumes below it cannot be mounted", exportPath, share.ID)
+		}
+		return share, nil
+	}
+
+	klog.Infof("Creating shared NFS export for parent dataset mountpoint %s", exportPath)
+	share, err = s.apiClient.CreateNFSShare(ctx, nfsShareAccess{}.shareCreateParams(exportPath, "CSI shared parent export"))
+	if err != nil {
+		// A concurrent CreateVolume may have exported the parent first
+		if existing, findErr := findShare(); findErr == nil && existing != nil {
+			return existing, nil
+		}
+		return nil, status.Errorf(codes.Internal, "Failed to create shared NFS export for %s: %v", exportPath, err)
+	}
+	return share, nil
+}
+
+// setParentExportContext points a volume context at the parent export and the volume's subdirectory.
+func setParentExportContext(volumeContext map[string]string, exportPath, subPath string) {
+	volumeContext[VolumeContextKeyShare] = exportPath
+	volumeContext[VolumeContextKeySubPath] = subPath
+	delete(volumeContext, VolumeContextKeyNFSShareID)
+}
+
+// createParentSharedNFSVolume creates an NFS volume reached through its parent dataset's export.
+func (s *ControllerService) createParentSharedNFSVolume(ctx context.Context, params *nfsVolumeParams, existingDatasets []tnsapi.Dataset, timer *metrics.OperationTimer) (*csi.CreateVolumeResponse, error) {
+	if len(existingDatasets) > 0 {
+		// Idempotency: CSI requires AlreadyExists for an incompatible capacity
+		existing, err := s.apiClient.GetDatasetProperties(ctx, existingDatasets[0].ID, []string{tnsapi.PropertyCapacityBytes})
+		if err == nil {
+			if capacity := tnsapi.StringToInt64(existing[tnsapi.PropertyCapacityBytes]); capacity > 0 && capacity != params.requestedCapacity {
+				if !params.expandExisting || capacity > params.requestedCapacity {
+					timer.ObserveError()
+					return nil, capacityMismatchError(params.volumeName, existingDatasets[0].ID, capacity, params.requestedCapacity)
+				}
+				if err := s.expandExistingVolume(ctx, params.volumeName, existingDatasets[0].ID, false, capacity, params.requestedCapacity); err != nil {
+					timer.ObserveError()
+					return nil, err
+				}
+			}
+		}
+	}
+
+	dataset, datasetIsNew, err := s.getOrCreateDataset(ctx, params, existingDatasets, timer)
+	if err != nil {
+		return nil, err
+	}
+
+	exportPath, subPath := splitParentExportPath(dataset.Mountpoint)
+	share, err := s.ensureParentNFSShare(ctx, exportPath)
+	if err != nil {
+		if datasetIsNew {
+			if delErr := s.apiClient.DeleteDataset(ctx, dataset.ID); delErr != nil {
+				klog.Errorf("Failed to cleanup dataset after shared NFS export failure: %v", delErr)
+			}
+		}
+		timer.ObserveError()
+		return nil, err
+	}
+
+	// No share ID is recorded: DeleteVolume must never remove the shared export
+	props := s.volumeProperties(ProtocolNFS, &params.volumeParams, tnsapi.NFSShareProperties(0, share.Path))
+	props[tnsapi.PropertyNFSShareStrategy] = NFSShareStrategyParent
+	if err := s.apiClient.SetDatasetProperties(ctx, dataset.ID, props); err != nil {
+		klog.Warningf("Failed to set ZFS user properties on dataset %s: %v (volume will still work)", dataset.ID, err)
+	}
+
+	resp := buildNFSVolumeResponse(params.volumeName, params.server, dataset, &tnsapi.NFSShare{}, params.requestedCapacity)
+	setParentExportContext(resp.Volume.VolumeContext, share.Path, subPath)
+
+	klog.Infof("Created NFS volume %s below shared export %s (share ID %d)", params.volumeName, share.Path, share.ID)
+	timer.ObserveSuccess()
+	return resp, nil
+}
@@ -3,6 +3,7 @@ package driver
 import (
 	"context"
 	"errors"
+	"reflect"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -229,6 +230,59 @@ func TestCreateNFSVolume(t *testing.T) {
 			wantErr:  true,
 			wantCode: codes.Internal,
 		},
+		{
+			name: "concurrent attempt claimed the dataset",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-nfs-volume",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"protocol": "nfs",
+					"pool":     "tank",
+					"server":   "192.168.1.100",
+				},
+			},
+			mockSetup: func(m *MockAPIClientForSnapshots) {
+				m.QueryAllDatasetsFunc = func(ctx context.Context, prefix string) ([]tnsapi.Dataset, error) {
+					return []tnsapi.Dataset{}, nil
+				}
+				m.CreateDatasetFunc = func(ctx context.Context, params tnsapi.DatasetCreateParams) (*tnsapi.Dataset, error) {
+					return &tnsapi.Dataset{
+						ID:         "tank/test-nfs-volume",
+						Name:       "tank/test-nfs-volume",
+						Type:       "FILESYSTEM",
+						Mountpoint: "/mnt/tank/test-nfs-volume",
+					}, nil
+				}
+				m.CreateNFSShareFunc = func(ctx context.Context, params tnsapi.NFSShareCreateParams) (*tnsapi.NFSShare, error) {
+					return &tnsapi.NFSShare{ID: 7, Path: "/mnt/tank/test-nfs-volume", Enabled: true}, nil
+				}
+				// Another attempt claimed the dataset after this one
+				m.GetDatasetPropertiesFunc = func(ctx context.Context, datasetID string, propertyNames []string) (map[string]string, error) {
+					return map[string]string{tnsapi.PropertyCreateGeneration: "0123456789abcdef"}, nil
+				}
+				m.DeleteNFSShareFunc = func(ctx context.Context, shareID int) error {
+					if shareID != 7 {
+						t.Errorf("Expected duplicate share 7 to be removed, got %d", shareID)
+					}
+					return nil
+				}
+				m.DeleteDatasetFunc = func(ctx context.Context, datasetID string) error {
+					t.Error("Dataset must be left to the attempt that claimed it")
+					return nil
+				}
+			},
+			wantErr:  true,
+			wantCode: codes.Aborted,
+		},
 	}
 
 	for _, tt := range tests {
@@ -237,7 +291,7 @@ func TestCreateNFSVolume(t *testing.T) {
 			tt.mockSetup(mockClient)
 
 			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
-			resp, err := controller.createNFSVolume(ctx, tt.req)
+			resp, err := nfsController{controller}.Provision(ctx, tt.req)
 
 			if tt.wantErr {
 				if err == nil {
@@ -367,7 +421,7 @@ func TestDeleteNFSVolume(t *testing.T) {
 			tt.mockSetup(mockClient)
 
 			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
-			_, err := controller.deleteNFSVolume(ctx, tt.meta)
+			_, err := nfsController{controller}.Teardown(ctx, tt.meta)
 
 			if tt.wantErr && err == nil {
 				t.Error("Expected error but got nil")
@@ -465,7 +519,7 @@ func TestExpandNFSVolume(t *testing.T) {
 			tt.mockSetup(mockClient)
 
 			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
-			resp, err := controller.expandNFSVolume(ctx, tt.meta, tt.requiredBytes)
+			resp, err := nfsController{controller}.Expand(ctx, tt.meta, tt.requiredBytes)
 
 			if tt.wantErr {
 				if err == nil {
@@ -492,7 +546,7 @@ func TestExpandNFSVolume(t *testing.T) {
 	}
 }
 
-func TestSetupNFSVolumeFromClone(t *testing.T) {
+func TestProvisionNFSVolumeFromClone(t *testing.T) {
 	ctx := context.Background()
 
 	tests := []struct {
@@ -592,7 +646,8 @@ func TestSetupNFSVolumeFromClone(t *testing.T) {
 				Mode:       "cow",
 				SnapshotID: "snapshot-id",
 			}
-			resp, err := controller.setupNFSVolumeFromClone(ctx, tt.req, tt.dataset, tt.server, testCloneInfo)
+			tt.req.Parameters = map[string]string{"server": tt.server}
+			resp, err := controller.provisionFromClone(ctx, tt.req, tt.dataset, ProtocolNFS, testCloneInfo)
 
 			if tt.wantErr {
 				if err == nil {
@@ -758,3 +813,181 @@ func TestParseEncryptionConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestNFSShareAccessForRequest(t *testing.T) {
+	snapshotSource := &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "tank/csi/pvc-src@snap-1"}},
+	}
+
+	tests := []struct {
+		source  *csi.VolumeContentSource
+		params  map[string]string
+		name    string
+		want    tnsapi.NFSShareCreateParams
+		mode    csi.VolumeCapability_AccessMode_Mode
+		wantErr bool
+	}{
+		{
+			name: "single writer keeps maproot",
+			mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			want: tnsapi.NFSShareCreateParams{MaprootUser: "root", MaprootGroup: "wheel"},
+		},
+		{
+			name: "RWX maps all users to root by default",
+			mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			want: tnsapi.NFSShareCreateParams{MapallUser: "root", MapallGroup: "wheel"},
+		},
+		{
+			name:   "RWX with configured mapall identity",
+			mode:   csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			params: map[string]string{NFSMapallUserParam: "apps", NFSMapallGroupParam: "apps"},
+			want:   tnsapi.NFSShareCreateParams{MapallUser: "apps", MapallGroup: "apps"},
+		},
+		{
+			name:    "RWX with invalid mapall user",
+			mode:    csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			params:  map[string]string{NFSMapallUserParam: "apps:x"},
+			wantErr: true,
+		},
+		{
+			name:   "ROX restore is exported read-only",
+			mode:   csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+			source: snapshotSource,
+			want:   tnsapi.NFSShareCreateParams{MaprootUser: "root", MaprootGroup: "wheel", ReadOnly: true},
+		},
+		{
+			name:   "RWX Kerberos export",
+			mode:   csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			params: map[string]string{NFSSecurityParam: "KRB5P"},
+			want:   tnsapi.NFSShareCreateParams{MapallUser: "root", MapallGroup: "wheel", Security: []string{"KRB5P"}},
+		},
+		{
+			name:    "invalid security",
+			mode:    csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			params:  map[string]string{NFSSecurityParam: "ntlm"},
+			wantErr: true,
+		},
+		{
+			name: "empty ROX volume stays writable for provisioning",
+			mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+			want: tnsapi.NFSShareCreateParams{MaprootUser: "root", MaprootGroup: "wheel"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &csi.CreateVolumeRequest{
+				Name:                "pvc-access",
+				Parameters:          tt.params,
+				VolumeCapabilities:  []*csi.VolumeCapability{readOnlyTestCapability(tt.mode)},
+				VolumeContentSource: tt.source,
+			}
+			access, err := nfsShareAccessForRequest(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("nfsShareAccessForRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			tt.want.Path = "/mnt/tank/csi/pvc-access"
+			tt.want.Comment = "comment"
+			tt.want.Enabled = true
+			got := access.shareCreateParams(tt.want.Path, tt.want.Comment)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("shareCreateParams() = %+v, want %+v", got, tt.want)
+			}
+			if restored := nfsShareAccessFromProperty(access.mapallProperty()); restored.mapallUser != access.mapallUser || restored.mapallGroup != access.mapallGroup {
+				t.Errorf("mapall property round trip = %+v, want %+v", restored, access)
+			}
+		})
+	}
+}
+
+func TestCreateNFSVolumeSharedParentExport(t *testing.T) {
+	ctx := context.Background()
+
+	var shares []tnsapi.NFSShare
+	mockClient := &MockAPIClientForSnapshots{
+		QueryAllDatasetsFunc: func(_ context.Context, _ string) ([]tnsapi.Dataset, error) {
+			return nil, nil
+		},
+		CreateDatasetFunc: func(_ context.Context, params tnsapi.DatasetCreateParams) (*tnsapi.Dataset, error) {
+			return &tnsapi.Dataset{ID: params.Name, Name: params.Name, Type: "FILESYSTEM", Mountpoint: "/mnt/" + params.Name}, nil
+		},
+		QueryAllNFSSharesFunc: func(_ context.Context, _ string) ([]tnsapi.NFSShare, error) {
+			return shares, nil
+		},
+		CreateNFSShareFunc: func(_ context.Context, params tnsapi.NFSShareCreateParams) (*tnsapi.NFSShare, error) {
+			share := tnsapi.NFSShare{ID: len(shares) + 1, Path: params.Path, Enabled: true}
+			shares = append(shares, share)
+			return &share, nil
+		},
+	}
+	controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+
+	for _, name := range []string{"pvc-a", "pvc-b"} {
+		resp, err := nfsController{controller}.Provision(ctx, &csi.CreateVolumeRequest{
+			Name: name,
+			Parameters: map[string]string{
+				"pool":                "tank",
+				"parentDataset":       "tank/csi",
+				NFSShareStrategyParam: NFSShareStrategyParent,
+			},
+		})
+		if err != nil {
+			t.Fatalf("Provision(%s) error = %v", name, err)
+		}
+		volumeContext := resp.GetVolume().GetVolumeContext()
+		if volumeContext[VolumeContextKeyShare] != "/mnt/tank/csi" || volumeContext[VolumeContextKeySubPath] != name {
+			t.Errorf("%s: share = %q, subPath = %q, want /mnt/tank/csi and %s",
+				name, volumeContext[VolumeContextKeyShare], volumeContext[VolumeContextKeySubPath], name)
+		}
+		if id, ok := volumeContext[VolumeContextKeyNFSShareID]; ok {
+			t.Errorf("%s: volume context records the shared export ID %s", name, id)
+		}
+	}
+
+	if len(shares) != 1 || shares[0].Path != "/mnt/tank/csi" {
+		t.Errorf("exports = %+v, want a single export of /mnt/tank/csi", shares)
+	}
+}
+
+func TestParseNFSShareStrategy(t *testing.T) {
+	tests := []struct {
+		params  map[string]string
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "default", params: map[string]string{}, want: NFSShareStrategyDataset},
+		{name: "parent", params: map[string]string{NFSShareStrategyParam: NFSShareStrategyParent}, want: NFSShareStrategyParent},
+		{name: "unknown", params: map[string]string{NFSShareStrategyParam: "pool"}, wantErr: true},
+		{
+			name:    "parent with deferred share",
+			params:  map[string]string{NFSShareStrategyParam: NFSShareStrategyParent, DeferShareCreationParam: VolumeContextValueTrue},
+			wantErr: true,
+		},
+		{
+			name:    "parent with mapall",
+			params:  map[string]string{NFSShareStrategyParam: NFSShareStrategyParent, NFSMapallUserParam: "apps"},
+			wantErr: true,
+		},
+		{
+			name:    "parent with kerberos",
+			params:  map[string]string{NFSShareStrategyParam: NFSShareStrategyParent, NFSSecurityParam: NFSSecurityKrb5},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNFSShareStrategy(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNFSShareStrategy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseNFSShareStrategy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
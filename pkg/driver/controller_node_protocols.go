@@ -0,0 +1,134 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// Protocol capability gating.
+//
+// Node plugins publish the protocols they can mount as protocols.tns.csi.io/<protocol> node
+// labels (see node_protocols.go). On CreateVolume the controller checks those labels and emits a
+// Warning event on the PVC when no node can mount the requested protocol, so a PVC that would
+// provision fine but never attach is flagged before a pod gets stuck in ContainerCreating.
+// Provisioning is not blocked: nodes may be added or fixed later.
+//
+// Clusters whose node plugins predate the labels (no node carries any label) are not checked.
+
+// Protocol gating constants.
+const (
+	// protocolUnavailableEventReason is the reason of PVC events for unmountable protocols.
+	protocolUnavailableEventReason = "ProtocolUnavailable"
+
+	// nodeProtocolCacheTTL is how long the node list is reused between CreateVolume calls.
+	nodeProtocolCacheTTL = time.Minute
+)
+
+// nodeProtocolChecker warns about volumes no node can mount.
+type nodeProtocolChecker struct {
+	kube     kubernetes.Interface
+	recorder record.EventRecorder
+	view     *clusterView // Cached PVCs (nil without --kube-informers)
+	listedAt time.Time
+	nodes    []corev1.Node
+	mu       sync.Mutex
+}
+
+// newNodeProtocolChecker creates a checker using the in-cluster Kubernetes API.
+func newNodeProtocolChecker() (*nodeProtocolChecker, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+	}
+	kube, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kube.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: usageAlertComponent})
+
+	return &nodeProtocolChecker{kube: kube, recorder: recorder}, nil
+}
+
+// listNodes returns the cluster's nodes, cached for nodeProtocolCacheTTL.
+func (c *nodeProtocolChecker) listNodes(ctx context.Context) ([]corev1.Node, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nodes != nil && time.Since(c.listedAt) < nodeProtocolCacheTTL {
+		return c.nodes, nil
+	}
+	list, err := c.kube.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	c.nodes, c.listedAt = list.Items, time.Now()
+	return c.nodes, nil
+}
+
+// nodesSupporting counts the nodes labeled as able to mount protocol, and the nodes carrying
+// any protocol label at all.
+func nodesSupporting(nodes []corev1.Node, protocol string) (supported, labeled int) {
+	for i := range nodes {
+		hasLabels := false
+		for _, p := range allProtocols {
+			if _, ok := nodes[i].Labels[NodeProtocolLabelPrefix+p]; ok {
+				hasLabels = true
+				break
+			}
+		}
+		if !hasLabels {
+			continue
+		}
+		labeled++
+		if nodes[i].Labels[NodeProtocolLabelPrefix+protocol] == VolumeContextValueTrue {
+			supported++
+		}
+	}
+	return supported, labeled
+}
+
+// check warns when no labeled node can mount protocol. Failures are logged, never returned:
+// the check must not get in the way of provisioning.
+func (c *nodeProtocolChecker) check(ctx context.Context, protocol string, params map[string]string) {
+	nodes, err := c.listNodes(ctx)
+	if err != nil {
+		klog.V(4).Infof("Skipping node protocol check: failed to list nodes: %v", err)
+		return
+	}
+	supported, labeled := nodesSupporting(nodes, protocol)
+	if labeled == 0 || supported > 0 {
+		return
+	}
+
+	message := fmt.Sprintf("No node can mount %s volumes (%d nodes checked, label %s%s=true): the volume is provisioned, but pods using it will not start until such a node joins",
+		protocol, labeled, NodeProtocolLabelPrefix, protocol)
+	klog.Warningf("Volume %s/%s: %s", params[CSIPVCNamespace], params[CSIPVCName], message)
+
+	if params[CSIPVCName] == "" || params[CSIPVCNamespace] == "" {
+		return
+	}
+	pvc, known := c.view.pvc(params[CSIPVCNamespace], params[CSIPVCName])
+	if !known {
+		if pvc, err = c.kube.CoreV1().PersistentVolumeClaims(params[CSIPVCNamespace]).Get(ctx, params[CSIPVCName], metav1.GetOptions{}); err != nil {
+			klog.V(4).Infof("Failed to get PVC %s/%s for protocol warning: %v", params[CSIPVCNamespace], params[CSIPVCName], err)
+			return
+		}
+	}
+	if pvc == nil {
+		return
+	}
+	c.recorder.Event(pvc, corev1.EventTypeWarning, protocolUnavailableEventReason, message)
+}
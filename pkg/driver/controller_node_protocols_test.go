@@ -0,0 +1,78 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func protocolTestNode(name string, protocols map[string]string) *corev1.Node {
+	labels := map[string]string{}
+	for protocol, value := range protocols {
+		labels[NodeProtocolLabelPrefix+protocol] = value
+	}
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestNodeProtocolCheck(t *testing.T) {
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"}}
+	params := map[string]string{"protocol": ProtocolNVMeOF, CSIPVCName: "db", CSIPVCNamespace: "default"}
+
+	tests := []struct {
+		name      string
+		nodes     []*corev1.Node
+		wantEvent bool
+	}{
+		{
+			name: "no node supports the protocol",
+			nodes: []*corev1.Node{
+				protocolTestNode("pi-1", map[string]string{ProtocolNFS: "true", ProtocolNVMeOF: "false"}),
+				protocolTestNode("pi-2", map[string]string{ProtocolNFS: "true", ProtocolNVMeOF: "false"}),
+			},
+			wantEvent: true,
+		},
+		{
+			name: "one node supports the protocol",
+			nodes: []*corev1.Node{
+				protocolTestNode("pi-1", map[string]string{ProtocolNFS: "true", ProtocolNVMeOF: "false"}),
+				protocolTestNode("x86-1", map[string]string{ProtocolNFS: "true", ProtocolNVMeOF: "true"}),
+			},
+		},
+		{
+			name:  "nodes without labels are not checked",
+			nodes: []*corev1.Node{protocolTestNode("old-1", nil)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kube := fake.NewClientset(pvc)
+			for _, node := range tt.nodes {
+				if _, err := kube.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			recorder := record.NewFakeRecorder(10)
+			checker := &nodeProtocolChecker{kube: kube, recorder: recorder}
+
+			checker.check(context.Background(), ProtocolNVMeOF, params)
+
+			select {
+			case event := <-recorder.Events:
+				if !tt.wantEvent {
+					t.Errorf("unexpected event %q", event)
+				} else if !strings.Contains(event, protocolUnavailableEventReason) {
+					t.Errorf("event = %q, want reason %s", event, protocolUnavailableEventReason)
+				}
+			default:
+				if tt.wantEvent {
+					t.Error("expected a ProtocolUnavailable event")
+				}
+			}
+		})
+	}
+}
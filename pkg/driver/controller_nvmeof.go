@@ -5,6 +5,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"net"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -18,38 +21,60 @@ import (
 	"k8s.io/klog/v2"
 )
 
-// Common error message constants to reduce duplication.
 const (
-	msgFailedCleanupClonedZVOL = "Failed to cleanup cloned ZVOL: %v"
 	// NQN prefix for CSI-managed subsystems.
 	// Format: nqn.2026-02.csi.tns:<volume-name>
-	// Each volume gets its own subsystem with NSID=1 (independent subsystem architecture).
+	// Each volume gets its own subsystem (independent subsystem architecture).
 	defaultNQNPrefix = "nqn.2026-02.csi.tns"
 )
 
+// NVMe-oF transport types (StorageClass "transport" parameter, nvme-cli -t values).
+const (
+	nvmeTransportTCP  = "tcp"
+	nvmeTransportRDMA = "rdma"
+	nvmeTransportFC   = "fc"
+)
+
+// fcTransportAddressPattern matches Fibre Channel transport addresses: the node and port
+// world wide names of a target or host port, as nvme-cli takes them in -a and -w.
+var fcTransportAddressPattern = regexp.MustCompile(`^nn-0x[0-9a-fA-F]{16}:pn-0x[0-9a-fA-F]{16}$`)
+
 // Common deletion errors.
-var errSubsystemDeletionSkipped = errors.New("subsystem deletion skipped: namespace still exists")
+var (
+	errSubsystemDeletionSkipped = errors.New("subsystem deletion skipped: namespace still exists")
+	errSubsystemNotEmpty        = errors.New("subsystem still has this volume's namespace attached")
+	errPortBindingsRemain       = errors.New("subsystem still has port bindings")
+	errSubsystemStillExists     = errors.New("subsystem still exists after deletion")
+)
+
+// subsystemCleanupRetryConfig configures the verification loops of subsystem cleanup.
+// TrueNAS applies namespace and port_subsys deletions asynchronously, so emptiness is
+// re-checked with a short backoff before giving up (a variable so tests can shorten it).
+var subsystemCleanupRetryConfig = func(operationName string) retry.Config {
+	return retry.Config{
+		MaxAttempts:       5,
+		InitialBackoff:    1 * time.Second,
+		MaxBackoff:        4 * time.Second,
+		BackoffMultiplier: 2.0,
+		RetryableFunc: func(err error) bool {
+			return errors.Is(err, errSubsystemNotEmpty) || errors.Is(err, errPortBindingsRemain) ||
+				errors.Is(err, errSubsystemStillExists) || retry.IsRetryableDeletionError(err)
+		},
+		OperationName: operationName,
+	}
+}
 
 // nvmeofVolumeParams holds validated parameters for NVMe-oF volume creation.
 type nvmeofVolumeParams struct {
-	zfsProps          *zfsZvolProperties
-	encryption        *encryptionConfig
-	deleteStrategy    string
-	comment           string
-	volumeName        string
-	zvolName          string
-	subsystemNQN      string
-	queueSize         string
-	nrIOQueues        string
-	storageClass      string
-	server            string
-	pool              string
-	parentDataset     string
-	pvcName           string
-	pvcNamespace      string
-	requestedCapacity int64
-	portID            int
-	markAdoptable     bool
+	volumeParams
+	zfsProps     *zfsZvolProperties
+	subsystemNQN string
+	transport    string
+	queueSize    string
+	nrIOQueues   string
+	portID       int
+	// grownExisting is set once an existing ZVOL was grown: its filesystem must be grown on stage
+	grownExisting bool
 }
 
 // zfsZvolProperties holds ZFS properties for ZVOL creation.
@@ -110,6 +135,8 @@ func parseZFSZvolProperties(params map[string]string) *zfsZvolProperties {
 		case "volblocksize":
 			// Volblocksize can be like "16K" - normalize to uppercase
 			props.Volblocksize = strings.ToUpper(value)
+		case zfsQoSLogbias, zfsQoSPrimarycache, zfsQoSSecondarycache, zfsQoSSpecialSmallBlocks:
+			// Validated and applied separately (see parseZFSQoSProperties)
 		default:
 			klog.V(4).Infof("Unknown or unsupported ZFS ZVOL property: %s=%s (ignoring)", propName, value)
 		}
@@ -124,102 +151,130 @@ func parseZFSZvolProperties(params map[string]string) *zfsZvolProperties {
 	return props
 }
 
-// validateNVMeOFParams validates and extracts NVMe-oF volume parameters from the request.
-func validateNVMeOFParams(req *csi.CreateVolumeRequest) (*nvmeofVolumeParams, error) {
-	params := req.GetParameters()
-
-	pool := params["pool"]
-	if pool == "" {
-		return nil, status.Error(codes.InvalidArgument, "pool parameter is required for NVMe-oF volumes")
+// parseNVMeOFTransport extracts the NVMe-oF transport from StorageClass parameters.
+// Defaults to tcp; rdma and fc require matching ports on TrueNAS and kernel support on nodes.
+func parseNVMeOFTransport(params map[string]string) (string, error) {
+	transport := strings.ToLower(strings.TrimSpace(params["transport"]))
+	switch transport {
+	case "":
+		return nvmeTransportTCP, nil
+	case nvmeTransportTCP, nvmeTransportRDMA, nvmeTransportFC:
+		return transport, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument,
+			"invalid transport parameter %q: must be one of tcp, rdma, fc", params["transport"])
 	}
+}
 
-	server := params["server"]
-	if server == "" {
-		return nil, status.Error(codes.InvalidArgument, "server parameter is required for NVMe-oF volumes")
+// portMatchesTransport reports whether a TrueNAS NVMe-oF port serves the given transport.
+// Ports without an addr_trtype (older TrueNAS releases) are TCP-only.
+func portMatchesTransport(port *tnsapi.NVMeOFPort, transport string) bool {
+	portTransport := strings.ToLower(port.Transport)
+	if portTransport == "" {
+		portTransport = nvmeTransportTCP
 	}
+	return portTransport == transport
+}
 
-	parentDataset := params["parentDataset"]
-	if parentDataset == "" {
-		parentDataset = pool
+// injectTransportParams records a non-default NVMe-oF transport in the volume context so the
+// node plugin passes the matching -t argument to nvme connect. RDMA hosts connect to the
+// address and service ID of the port the subsystem is bound to, not to the server parameter:
+// RDMA ports usually listen on a dedicated fabric address. FC hosts connect to the port's
+// node and port names (nn-0x…:pn-0x…), which have no service ID.
+func (s *ControllerService) injectTransportParams(ctx context.Context, volumeContext map[string]string, subsystemID int, transport string) error {
+	if transport == "" || transport == nvmeTransportTCP {
+		return nil
 	}
+	volumeContext[VolumeContextKeyTransport] = transport
 
-	requestedCapacity := req.GetCapacityRange().GetRequiredBytes()
-	if requestedCapacity == 0 {
-		requestedCapacity = 1 * 1024 * 1024 * 1024 // Default 1GB
+	port, err := s.boundNVMeOFPort(ctx, subsystemID, transport)
+	if err != nil {
+		return err
+	}
+	if transport == nvmeTransportFC {
+		if !fcTransportAddressPattern.MatchString(port.Address) {
+			return status.Errorf(codes.FailedPrecondition,
+				"NVMe-oF FC port %d has address %q, want nn-0x<WWNN>:pn-0x<WWPN>", port.ID, port.Address)
+		}
+		volumeContext[VolumeContextKeyTransportAddress] = port.Address
+		return nil
+	}
+	if ip := net.ParseIP(strings.Trim(port.Address, "[]")); port.Address != "" && (ip == nil || !ip.IsUnspecified()) {
+		volumeContext[VolumeContextKeyTransportAddress] = strings.Trim(port.Address, "[]")
 	}
+	if port.Port != 0 {
+		volumeContext[VolumeContextKeyPort] = strconv.Itoa(port.Port)
+	}
+	return nil
+}
 
-	// Resolve volume name using templating (if configured in StorageClass)
-	volumeName, err := ResolveVolumeName(params, req.GetName())
+// boundNVMeOFPort returns the port of the transport a subsystem is bound to.
+func (s *ControllerService) boundNVMeOFPort(ctx context.Context, subsystemID int, transport string) (*tnsapi.NVMeOFPort, error) {
+	bindings, err := s.apiClient.QuerySubsystemPortBindings(ctx, subsystemID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to query port bindings for subsystem %d: %v", subsystemID, err)
+	}
+	ports, err := s.apiClient.QueryNVMeOFPorts(ctx)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve volume name: %v", err)
+		return nil, status.Errorf(codes.Internal, "Failed to query NVMe-oF ports: %v", err)
 	}
-	zvolName := fmt.Sprintf("%s/%s", parentDataset, volumeName)
+	for i := range bindings {
+		portID := bindings[i].GetPortID()
+		for j := range ports {
+			if ports[j].ID == portID && portMatchesTransport(&ports[j], transport) {
+				return &ports[j], nil
+			}
+		}
+	}
+	return nil, status.Errorf(codes.FailedPrecondition, "NVMe-oF subsystem %d is not bound to a %s port", subsystemID, strings.ToUpper(transport))
+}
 
-	// Resolve dataset comment from commentTemplate (if configured in StorageClass)
-	comment, err := ResolveComment(params, req.GetName())
+// validateNVMeOFParams validates and extracts NVMe-oF volume parameters from the request.
+func (s nvmeofController) validateNVMeOFParams(req *csi.CreateVolumeRequest) (*nvmeofVolumeParams, error) {
+	common, err := s.parseVolumeParams(s, req)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve comment template: %v", err)
+		return nil, err
 	}
+	params := req.GetParameters()
 
 	// Generate unique NQN for this volume's dedicated subsystem
-	nqnPrefix := params["subsystemNQN"]
-	if nqnPrefix == "" {
-		nqnPrefix = defaultNQNPrefix
+	subsystemNQN, err := s.subsystemNQN(params, common.volumeName)
+	if err != nil {
+		return nil, err
 	}
-	subsystemNQN := generateNQN(nqnPrefix, volumeName)
-
-	// Parse optional port ID from StorageClass parameters
-	var portID int
-	if portIDStr := params["portID"]; portIDStr != "" {
-		var err error
-		portID, err = strconv.Atoi(portIDStr)
-		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "invalid portID parameter: %v", err)
-		}
+	portID, err := parseNVMeOFPortID(params)
+	if err != nil {
+		return nil, err
 	}
-
-	// Parse ZFS properties from StorageClass parameters
-	zfsProps := parseZFSZvolProperties(params)
-
-	// Parse encryption config from StorageClass parameters and secrets
-	encryption := parseEncryptionConfig(params, req.GetSecrets())
-
-	// Parse deleteStrategy from StorageClass parameters (default: "delete")
-	deleteStrategy := params["deleteStrategy"]
-	if deleteStrategy == "" {
-		deleteStrategy = tnsapi.DeleteStrategyDelete
+	transport, err := parseNVMeOFTransport(params)
+	if err != nil {
+		return nil, err
 	}
 
-	// Parse markAdoptable from StorageClass parameters (default: false)
-	markAdoptable := params["markAdoptable"] == VolumeContextValueTrue
-
-	// Extract adoption metadata from CSI parameters
-	pvcName := params["csi.storage.k8s.io/pvc/name"]
-	pvcNamespace := params["csi.storage.k8s.io/pvc/namespace"]
-	storageClass := params["csi.storage.k8s.io/sc/name"]
-
 	return &nvmeofVolumeParams{
-		pool:              pool,
-		server:            server,
-		parentDataset:     parentDataset,
-		requestedCapacity: requestedCapacity,
-		volumeName:        volumeName,
-		zvolName:          zvolName,
-		subsystemNQN:      subsystemNQN,
-		portID:            portID,
-		deleteStrategy:    deleteStrategy,
-		markAdoptable:     markAdoptable,
-		zfsProps:          zfsProps,
-		encryption:        encryption,
-		comment:           comment,
-		pvcName:           pvcName,
-		pvcNamespace:      pvcNamespace,
-		storageClass:      storageClass,
-		nrIOQueues:        params["nvmeof.nr-io-queues"],
-		queueSize:         params["nvmeof.queue-size"],
+		volumeParams: *common,
+		zfsProps:     parseZFSZvolProperties(common.zfsParams),
+		subsystemNQN: subsystemNQN,
+		transport:    transport,
+		portID:       portID,
+		nrIOQueues:   params["nvmeof.nr-io-queues"],
+		queueSize:    params["nvmeof.queue-size"],
 	}, nil
 }
 
+// parseNVMeOFPortID extracts the optional portID from StorageClass parameters.
+func parseNVMeOFPortID(params map[string]string) (int, error) {
+	portIDStr := params["portID"]
+	if portIDStr == "" {
+		return 0, nil
+	}
+	portID, err := strconv.Atoi(portIDStr)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid portID parameter: %v", err)
+	}
+	return portID, nil
+}
+
 // findExistingNVMeOFNamespace finds an existing namespace for a ZVOL in a subsystem.
 func (s *ControllerService) findExistingNVMeOFNamespace(ctx context.Context, devicePath string, subsystemID int) (*tnsapi.NVMeOFNamespace, error) {
 	namespaces, err := s.apiClient.QueryAllNVMeOFNamespaces(ctx)
@@ -253,6 +308,15 @@ func (s *ControllerService) findExistingNVMeOFNamespace(ctx context.Context, dev
 	return nil, nil //nolint:nilnil // nil, nil indicates "not found" - callers check for nil namespace
 }
 
+// namespaceNSID returns the NSID TrueNAS reports for a namespace, or 1 for responses without one
+// (volumes created before NSIDs were allocated explicitly always used NSID 1).
+func namespaceNSID(namespace *tnsapi.NVMeOFNamespace) int {
+	if namespace.NSID > 0 {
+		return namespace.NSID
+	}
+	return 1
+}
+
 // injectQueueParams adds optional NVMe-oF queue tuning parameters into the volume context.
 // These are passed from StorageClass parameters to the node plugin via volumeContext so the
 // node can apply --nr-io-queues and --queue-size when running nvme connect.
@@ -265,64 +329,52 @@ func injectQueueParams(volumeContext map[string]string, nrIOQueues, queueSize st
 	}
 }
 
-// buildNVMeOFVolumeResponse builds the CreateVolumeResponse for an NVMe-oF volume.
-// With independent subsystem architecture, NSID is always 1.
-// The nqn parameter should be the NQN returned by TrueNAS (subsystem.NQN), which may differ
-// from what we requested. TrueNAS generates its own NQN with a different prefix.
+// buildNVMeOFVolumeResponse constructs a CSI CreateVolumeResponse for an NVMe-oF volume.
 func buildNVMeOFVolumeResponse(volumeName, server, nqn string, zvol *tnsapi.Dataset, subsystem *tnsapi.NVMeOFSubsystem, namespace *tnsapi.NVMeOFNamespace, capacity int64) *csi.CreateVolumeResponse {
-	meta := VolumeMetadata{
-		Name:              volumeName,
-		Protocol:          ProtocolNVMeOF,
-		DatasetID:         zvol.ID,
-		DatasetName:       zvol.Name,
-		Server:            server,
-		NVMeOFSubsystemID: subsystem.ID,
-		NVMeOFNamespaceID: namespace.ID,
-		NVMeOFNQN:         nqn, // Use the NQN from TrueNAS (subsystem.NQN), not what we requested
-	}
-
-	// Volume ID is the full dataset path for O(1) lookups (e.g., "pool/parent/pvc-xxx")
-	volumeID := zvol.ID
-
-	// Build volume context with all necessary metadata
-	volumeContext := buildVolumeContext(meta)
-	// NSID is always 1 with independent subsystem architecture
-	volumeContext[VolumeContextKeyNSID] = "1"
-	volumeContext[VolumeContextKeyExpectedCapacity] = strconv.FormatInt(capacity, 10)
-
-	// Record volume capacity metric
-	metrics.SetVolumeCapacity(volumeID, metrics.ProtocolNVMeOF, capacity)
-
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      volumeID,
-			CapacityBytes: capacity,
-			VolumeContext: volumeContext,
+	export := nvmeofExport(zvol, &volumeParams{volumeName: volumeName, server: server}, subsystem, namespace, capacity)
+	export.meta.NVMeOFNQN = nqn // Use the NQN from TrueNAS (subsystem.NQN), not what we requested
+	return export.response(capacity)
+}
+
+// nvmeofExport describes a ZVOL exported as a namespace of a dedicated NVMe-oF subsystem.
+// IMPORTANT: it records subsystem.NQN (the full NQN from TrueNAS including UUID prefix), not the
+// short name we generated: the node plugin must use the full NQN to connect.
+func nvmeofExport(zvol *tnsapi.Dataset, params *volumeParams, subsystem *tnsapi.NVMeOFSubsystem, namespace *tnsapi.NVMeOFNamespace, capacity int64) *volumeExport {
+	return &volumeExport{
+		meta: VolumeMetadata{
+			Name:              params.volumeName,
+			Protocol:          ProtocolNVMeOF,
+			DatasetID:         zvol.ID,
+			DatasetName:       zvol.Name,
+			Server:            params.server,
+			NVMeOFSubsystemID: subsystem.ID,
+			NVMeOFNamespaceID: namespace.ID,
+			NVMeOFNQN:         subsystem.NQN,
+		},
+		context: map[string]string{
+			VolumeContextKeyNSID:             strconv.Itoa(namespaceNSID(namespace)),
+			VolumeContextKeyExpectedCapacity: strconv.FormatInt(capacity, 10),
 		},
+		properties: tnsapi.NVMeOFSubsystemProperties(subsystem.ID, namespace.ID, subsystem.NQN),
 	}
 }
 
 // handleExistingNVMeOFVolume handles the case when a ZVOL already exists (idempotency).
-func (s *ControllerService) handleExistingNVMeOFVolume(ctx context.Context, params *nvmeofVolumeParams, existingZvol *tnsapi.Dataset, timer *metrics.OperationTimer) (*csi.CreateVolumeResponse, bool, error) {
-	klog.V(4).Infof("ZVOL %s already exists (ID: %s), checking idempotency", params.zvolName, existingZvol.ID)
-
-	// Extract existing ZVOL capacity
-	existingCapacity := getZvolCapacity(existingZvol)
-	if existingCapacity > 0 {
-		klog.V(4).Infof("Existing ZVOL capacity: %d bytes, requested: %d bytes", existingCapacity, params.requestedCapacity)
-
-		// Check if capacity matches (CSI idempotency requirement)
-		if existingCapacity != params.requestedCapacity {
-			timer.ObserveError()
-			return nil, false, status.Errorf(codes.AlreadyExists,
-				"Volume '%s' already exists with different capacity: existing=%d bytes, requested=%d bytes",
-				params.volumeName, existingCapacity, params.requestedCapacity)
-		}
-	} else {
-		// If we can't determine capacity, assume compatible (backward compatibility)
-		klog.Warningf("Could not determine capacity for existing ZVOL %s, assuming compatible", params.zvolName)
-		existingCapacity = params.requestedCapacity
+// It reconstructs the volume from what is on TrueNAS: the subsystem (by NQN or stored properties),
+// its port binding and the ZVOL's namespace. A controller restart can interrupt CreateVolume after
+// any step, so each missing piece is reported back for the caller to finish:
+//   - response != nil: the volume is complete and the response carries the full VolumeContext
+//   - subsystem != nil: the subsystem exists (and is bound to a port) but the namespace must be created
+//   - both nil: the subsystem is missing and must be created
+func (s *ControllerService) handleExistingNVMeOFVolume(ctx context.Context, params *nvmeofVolumeParams, existingZvol *tnsapi.Dataset, timer *metrics.OperationTimer) (*csi.CreateVolumeResponse, *tnsapi.NVMeOFSubsystem, error) {
+	klog.V(4).Infof("ZVOL %s already exists (ID: %s), checking idempotency", params.datasetName, existingZvol.ID)
+
+	existingCapacity, grown, err := s.checkExistingZvolCapacity(ctx, params.volumeName, existingZvol, params.requestedCapacity, params.expandExisting)
+	if err != nil {
+		timer.ObserveError()
+		return nil, nil, err
 	}
+	params.grownExisting = grown
 
 	// Check if subsystem exists for this volume
 	klog.V(4).Infof("Checking for existing subsystem with NQN: %s", params.subsystemNQN)
@@ -345,16 +397,21 @@ func (s *ControllerService) handleExistingNVMeOFVolume(ctx context.Context, para
 		if err != nil {
 			// Subsystem still not found - this could mean partial creation, continue to create it
 			klog.V(4).Infof("Subsystem not found for existing ZVOL (including property fallback), will create: %v", err)
-			return nil, false, nil
+			return nil, nil, nil
 		}
 	}
 
+	// A restart between subsystem creation and port binding leaves an unreachable subsystem
+	if err := s.ensureSubsystemPortBinding(ctx, subsystem.ID, params, timer); err != nil {
+		return nil, nil, err
+	}
+
 	// Check if namespace already exists for this ZVOL
-	devicePath := "zvol/" + params.zvolName
+	devicePath := "zvol/" + params.datasetName
 	namespace, err := s.findExistingNVMeOFNamespace(ctx, devicePath, subsystem.ID)
 	if err != nil {
 		timer.ObserveError()
-		return nil, false, err
+		return nil, nil, err
 	}
 
 	if namespace != nil {
@@ -368,12 +425,37 @@ func (s *ControllerService) handleExistingNVMeOFVolume(ctx context.Context, para
 		// Use subsystem.NQN (what TrueNAS actually has) not params.subsystemNQN (what we would request)
 		resp := buildNVMeOFVolumeResponse(params.volumeName, params.server, subsystem.NQN, existingZvol, subsystem, namespace, existingCapacity)
 		injectQueueParams(resp.Volume.VolumeContext, params.nrIOQueues, params.queueSize)
+		if err := s.injectTransportParams(ctx, resp.Volume.VolumeContext, subsystem.ID, params.transport); err != nil {
+			timer.ObserveError()
+			return nil, nil, err
+		}
+		markGrownExisting(resp.Volume.VolumeContext, params.grownExisting)
 		timer.ObserveSuccess()
-		return resp, true, nil
+		return resp, nil, nil
 	}
 
-	// ZVOL exists but no namespace - continue with namespace creation
-	return nil, false, nil
+	// ZVOL and subsystem exist but no namespace - continue with namespace creation on this subsystem
+	klog.Infof("Resuming NVMe-oF volume %s: subsystem %s exists without a namespace", params.volumeName, subsystem.NQN)
+	return nil, subsystem, nil
+}
+
+// ensureSubsystemPortBinding binds an existing subsystem to a port if it has no port binding yet.
+func (s *ControllerService) ensureSubsystemPortBinding(ctx context.Context, subsystemID int, params *nvmeofVolumeParams, timer *metrics.OperationTimer) error {
+	bindings, err := s.apiClient.QuerySubsystemPortBindings(ctx, subsystemID)
+	if err != nil {
+		timer.ObserveError()
+		return status.Errorf(codes.Internal, "Failed to query port bindings for subsystem %d: %v", subsystemID, err)
+	}
+	if len(bindings) > 0 {
+		return nil
+	}
+
+	klog.Infof("Existing subsystem %d has no port binding (interrupted creation), binding it now", subsystemID)
+	if err := s.bindSubsystemToPort(ctx, subsystemID, params.portID, params.transport, params.server); err != nil {
+		timer.ObserveError()
+		return err
+	}
+	return nil
 }
 
 // ensureNVMeOFProperties checks if ZFS properties are set on the ZVOL and sets them if missing.
@@ -389,20 +471,7 @@ func (s *ControllerService) ensureNVMeOFProperties(ctx context.Context, zvolID s
 	}
 
 	klog.Infof("Recovering missing ZFS properties on ZVOL %s (orphaned from interrupted creation)", zvolID)
-	props := tnsapi.NVMeOFVolumePropertiesV1(tnsapi.NVMeOFVolumeParams{
-		VolumeID:       params.volumeName,
-		CapacityBytes:  params.requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: params.deleteStrategy,
-		SubsystemID:    subsystem.ID,
-		NamespaceID:    namespace.ID,
-		SubsystemNQN:   subsystem.NQN,
-		PVCName:        params.pvcName,
-		PVCNamespace:   params.pvcNamespace,
-		StorageClass:   params.storageClass,
-		Adoptable:      params.markAdoptable,
-		ClusterID:      s.clusterID,
-	})
+	props := s.volumeProperties(ProtocolNVMeOF, &params.volumeParams, tnsapi.NVMeOFSubsystemProperties(subsystem.ID, namespace.ID, subsystem.NQN))
 	if err := s.apiClient.SetDatasetProperties(ctx, zvolID, props); err != nil {
 		klog.Warningf("Failed to recover ZFS properties on ZVOL %s: %v (volume will still work)", zvolID, err)
 	} else {
@@ -418,30 +487,43 @@ func getZvolCapacity(dataset *tnsapi.Dataset) int64 {
 		return 0
 	}
 
-	// TrueNAS returns volsize as a map with "parsed" field containing the integer value
-	if parsed, ok := dataset.Volsize["parsed"]; ok {
-		switch v := parsed.(type) {
-		case float64:
-			return int64(v)
-		case int64:
-			return v
-		case int:
-			return int64(v)
-		default:
-			klog.Warningf("Unexpected volsize parsed value type: %T", parsed)
-		}
+	if size := parsedPropertyInt(dataset.Volsize); size > 0 {
+		return size
 	}
 
 	klog.V(5).Infof("Could not extract parsed capacity from volsize: %+v", dataset.Volsize)
 	return 0
 }
 
-func (s *ControllerService) createNVMeOFVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+// parsedPropertyInt returns the integer "parsed" field of a dataset property as TrueNAS
+// returns it, or 0.
+func parsedPropertyInt(prop map[string]interface{}) int64 {
+	parsed, ok := prop["parsed"]
+	if !ok {
+		return 0
+	}
+	switch v := parsed.(type) {
+	case float64:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case nil:
+		return 0
+	default:
+		klog.Warningf("Unexpected parsed property value type: %T", parsed)
+		return 0
+	}
+}
+
+// Provision creates an NVMe-oF volume (ZVOL + dedicated subsystem + namespace).
+func (s nvmeofController) Provision(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolNVMeOF, "create")
 	klog.V(4).Info("Creating NVMe-oF volume (independent subsystem architecture)")
 
 	// Validate and extract parameters
-	params, err := validateNVMeOFParams(req)
+	params, err := s.validateNVMeOFParams(req)
 	if err != nil {
 		timer.ObserveError()
 		return nil, err
@@ -451,22 +533,24 @@ func (s *ControllerService) createNVMeOFVolume(ctx context.Context, req *csi.Cre
 		params.volumeName, params.requestedCapacity, params.subsystemNQN)
 
 	// Check if ZVOL already exists (idempotency)
-	existingZvols, err := s.apiClient.QueryAllDatasets(ctx, params.zvolName)
+	existingZvols, err := s.apiClient.QueryAllDatasets(ctx, params.datasetName)
 	if err != nil {
 		timer.ObserveError()
 		return nil, status.Errorf(codes.Internal, "Failed to query existing ZVOLs: %v", err)
 	}
 
 	// Handle existing ZVOL (idempotency check)
+	var existingSubsystem *tnsapi.NVMeOFSubsystem
 	if len(existingZvols) > 0 {
-		resp, done, handleErr := s.handleExistingNVMeOFVolume(ctx, params, &existingZvols[0], timer)
+		resp, subsystem, handleErr := s.handleExistingNVMeOFVolume(ctx, params, &existingZvols[0], timer)
 		if handleErr != nil {
 			return nil, handleErr
 		}
-		if done {
+		if resp != nil {
 			return resp, nil
 		}
-		// If not done, ZVOL exists but no subsystem/namespace - continue with creation
+		// Not complete: ZVOL exists, subsystem may exist (already bound to a port) - finish creation
+		existingSubsystem = subsystem
 	}
 
 	// Step 1: Create ZVOL
@@ -475,6 +559,10 @@ func (s *ControllerService) createNVMeOFVolume(ctx context.Context, req *csi.Cre
 		return nil, err
 	}
 
+	if existingSubsystem != nil {
+		return s.finishNVMeOFVolume(ctx, params, zvol, existingSubsystem, false, zvolIsNew, timer)
+	}
+
 	// Step 2: Create dedicated subsystem for this volume
 	subsystem, err := s.createSubsystemForVolume(ctx, params, timer)
 	if err != nil {
@@ -491,7 +579,8 @@ func (s *ControllerService) createNVMeOFVolume(ctx context.Context, req *csi.Cre
 	}
 
 	// Step 3: Bind subsystem to port (if portID specified or use first available port)
-	if bindErr := s.bindSubsystemToPort(ctx, subsystem.ID, params.portID, timer); bindErr != nil {
+	if bindErr := s.bindSubsystemToPort(ctx, subsystem.ID, params.portID, params.transport, params.server); bindErr != nil {
+		timer.ObserveError()
 		// Cleanup: delete subsystem (always new), only delete ZVOL if newly created
 		klog.Errorf("Failed to bind subsystem to port, cleaning up: %v", bindErr)
 		if delErr := s.apiClient.DeleteNVMeOFSubsystem(ctx, subsystem.ID); delErr != nil {
@@ -507,13 +596,29 @@ func (s *ControllerService) createNVMeOFVolume(ctx context.Context, req *csi.Cre
 		return nil, bindErr
 	}
 
-	// Step 4: Create NVMe-oF namespace (NSID will be 1 since this is a new subsystem)
+	return s.finishNVMeOFVolume(ctx, params, zvol, subsystem, true, zvolIsNew, timer)
+}
+
+// finishNVMeOFVolume creates the namespace for a ZVOL in its (port-bound) subsystem, records the ZFS
+// properties and builds the response. subsystemIsNew and zvolIsNew guard cleanup on failure: resources
+// left behind by an interrupted earlier attempt are kept so the next retry can resume from them.
+func (s *ControllerService) finishNVMeOFVolume(ctx context.Context, params *nvmeofVolumeParams, zvol *tnsapi.Dataset, subsystem *tnsapi.NVMeOFSubsystem, subsystemIsNew, zvolIsNew bool, timer *metrics.OperationTimer) (*csi.CreateVolumeResponse, error) {
+	// Claim the ZVOL so a concurrent attempt does not add a second namespace
+	generation, err := s.claimCreation(ctx, zvol.ID)
+	if err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+
+	// Step 4: Create NVMe-oF namespace
 	namespace, err := s.createNVMeOFNamespaceForZVOL(ctx, zvol, subsystem, timer)
 	if err != nil {
-		// Cleanup: delete subsystem (always new), only delete ZVOL if newly created
+		// Cleanup: delete subsystem only if created by this call, only delete ZVOL if newly created
 		klog.Errorf("Failed to create namespace, cleaning up: %v", err)
-		if delErr := s.apiClient.DeleteNVMeOFSubsystem(ctx, subsystem.ID); delErr != nil {
-			klog.Errorf("Failed to cleanup subsystem: %v", delErr)
+		if subsystemIsNew {
+			if delErr := s.apiClient.DeleteNVMeOFSubsystem(ctx, subsystem.ID); delErr != nil {
+				klog.Errorf("Failed to cleanup subsystem: %v", delErr)
+			}
 		}
 		if zvolIsNew {
 			if delErr := s.apiClient.DeleteDataset(ctx, zvol.ID); delErr != nil {
@@ -525,6 +630,15 @@ func (s *ControllerService) createNVMeOFVolume(ctx context.Context, req *csi.Cre
 		return nil, err
 	}
 
+	// A later attempt claimed the ZVOL: leave the volume to it
+	if !s.ownsCreation(ctx, zvol.ID, generation) {
+		if delErr := s.apiClient.DeleteNVMeOFNamespace(ctx, namespace.ID); delErr != nil {
+			klog.Errorf("Failed to remove duplicate NVMe-oF namespace %d: %v", namespace.ID, delErr)
+		}
+		timer.ObserveError()
+		return nil, creationSupersededError(params.volumeName)
+	}
+
 	// Wait for TrueNAS NVMe-oF target to fully initialize the namespace
 	// Without this delay, the node may connect before the namespace is ready,
 	// resulting in a device that reports zero size
@@ -533,20 +647,7 @@ func (s *ControllerService) createNVMeOFVolume(ctx context.Context, req *csi.Cre
 	time.Sleep(namespaceInitDelay)
 
 	// Step 5: Store ZFS user properties for metadata tracking and ownership verification (Schema v1)
-	props := tnsapi.NVMeOFVolumePropertiesV1(tnsapi.NVMeOFVolumeParams{
-		VolumeID:       params.volumeName,
-		CapacityBytes:  params.requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: params.deleteStrategy,
-		SubsystemID:    subsystem.ID,
-		NamespaceID:    namespace.ID,
-		SubsystemNQN:   subsystem.NQN,
-		PVCName:        params.pvcName,
-		PVCNamespace:   params.pvcNamespace,
-		StorageClass:   params.storageClass,
-		Adoptable:      params.markAdoptable,
-		ClusterID:      s.clusterID,
-	})
+	props := s.volumeProperties(ProtocolNVMeOF, &params.volumeParams, tnsapi.NVMeOFSubsystemProperties(subsystem.ID, namespace.ID, subsystem.NQN))
 	if err := s.apiClient.SetDatasetProperties(ctx, zvol.ID, props); err != nil {
 		// Non-fatal: volume works without properties, but deletion safety is reduced
 		klog.Warningf("Failed to set ZFS properties on ZVOL %s: %v (volume will still work)", zvol.ID, err)
@@ -557,10 +658,15 @@ func (s *ControllerService) createNVMeOFVolume(ctx context.Context, req *csi.Cre
 	// Build and return response
 	// Use subsystem.NQN (what TrueNAS actually created) not params.subsystemNQN (what we requested)
 	// TrueNAS may assign a different NQN prefix than what we requested
-	resp := buildNVMeOFVolumeResponse(params.volumeName, params.server, subsystem.NQN, zvol, subsystem, namespace, params.requestedCapacity)
+	resp := buildNVMeOFVolumeResponse(params.volumeName, params.server, subsystem.NQN, zvol, subsystem, namespace, s.provisionedCapacity(ctx, zvol, params.requestedCapacity))
 	injectQueueParams(resp.Volume.VolumeContext, params.nrIOQueues, params.queueSize)
+	if err := s.injectTransportParams(ctx, resp.Volume.VolumeContext, subsystem.ID, params.transport); err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+	markGrownExisting(resp.Volume.VolumeContext, params.grownExisting)
 
-	klog.Infof("Created NVMe-oF volume: %s (subsystem: %s, NSID: 1)", params.volumeName, subsystem.NQN)
+	klog.Infof("Created NVMe-oF volume: %s (subsystem: %s, NSID: %s)", params.volumeName, subsystem.NQN, resp.Volume.VolumeContext[VolumeContextKeyNSID])
 	timer.ObserveSuccess()
 	return resp, nil
 }
@@ -569,14 +675,20 @@ func (s *ControllerService) createNVMeOFVolume(ctx context.Context, req *csi.Cre
 func (s *ControllerService) createSubsystemForVolume(ctx context.Context, params *nvmeofVolumeParams, timer *metrics.OperationTimer) (*tnsapi.NVMeOFSubsystem, error) {
 	klog.V(4).Infof("Creating dedicated NVMe-oF subsystem: %s", params.subsystemNQN)
 
+	serial, err := s.checkSubsystemFree(ctx, params.subsystemNQN, params.volumeName)
+	if err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
 	subsystem, err := s.apiClient.CreateNVMeOFSubsystem(ctx, tnsapi.NVMeOFSubsystemCreateParams{
 		Name:         params.subsystemNQN,
 		Subnqn:       params.subsystemNQN,
-		AllowAnyHost: true, // Allow any initiator to connect
+		Serial:       serial,
+		AllowAnyHost: !s.hostAccessControl, // Without host access control any initiator may connect
 	})
 	if err != nil {
 		timer.ObserveError()
-		return nil, status.Errorf(codes.Internal, "Failed to create NVMe-oF subsystem '%s' for ZVOL %s: %v", params.subsystemNQN, params.zvolName, err)
+		return nil, status.Errorf(codes.Internal, "Failed to create NVMe-oF subsystem '%s' for ZVOL %s: %v", params.subsystemNQN, params.datasetName, err)
 	}
 
 	klog.V(4).Infof("Created NVMe-oF subsystem: ID=%d, Name=%s, NQN=%s", subsystem.ID, subsystem.Name, subsystem.NQN)
@@ -584,27 +696,31 @@ func (s *ControllerService) createSubsystemForVolume(ctx context.Context, params
 }
 
 // bindSubsystemToPort binds a subsystem to an NVMe-oF port.
-// If portID is 0, it uses the first available port.
-func (s *ControllerService) bindSubsystemToPort(ctx context.Context, subsystemID, portID int, timer *metrics.OperationTimer) error {
-	// If no specific port requested, find the first available port
+// If portID is 0, a port serving the requested transport is selected, preferring one
+// listening on an address (or address family) of server.
+func (s *ControllerService) bindSubsystemToPort(ctx context.Context, subsystemID, portID int, transport, server string) error {
+	// If no specific port requested, select a port for the requested transport
 	if portID == 0 {
 		ports, err := s.apiClient.QueryNVMeOFPorts(ctx)
 		if err != nil {
-			timer.ObserveError()
 			return status.Errorf(codes.Internal, "Failed to query NVMe-oF ports: %v", err)
 		}
 		if len(ports) == 0 {
-			timer.ObserveError()
 			return status.Error(codes.FailedPrecondition,
 				"No NVMe-oF ports configured. Create a port in TrueNAS (Shares > NVMe-oF Targets > Ports) first.")
 		}
-		portID = ports[0].ID
-		klog.Infof("Using first available NVMe-oF port: ID=%d", portID)
+		port := selectNVMeOFPort(ports, transport, server)
+		if port == nil {
+			return status.Errorf(codes.FailedPrecondition,
+				"No NVMe-oF port with transport %q configured. Create a %s port in TrueNAS (Shares > NVMe-oF Targets > Ports) first.",
+				transport, strings.ToUpper(transport))
+		}
+		portID = port.ID
+		klog.Infof("Using NVMe-oF %s port: ID=%d, address=%s", transport, portID, port.Address)
 	}
 
 	klog.Infof("Binding subsystem %d to port %d", subsystemID, portID)
 	if err := s.apiClient.AddSubsystemToPort(ctx, subsystemID, portID); err != nil {
-		timer.ObserveError()
 		return status.Errorf(codes.Internal, "Failed to bind subsystem (ID: %d) to port %d: %v", subsystemID, portID, err)
 	}
 
@@ -624,7 +740,7 @@ func (s *ControllerService) getOrCreateZVOL(ctx context.Context, params *nvmeofV
 
 	// Build ZVOL creation parameters with ZFS properties
 	createParams := tnsapi.ZvolCreateParams{
-		Name:         params.zvolName,
+		Name:         params.datasetName,
 		Type:         datasetTypeVolume,
 		Volsize:      params.requestedCapacity,
 		Volblocksize: "16K", // Default block size for NVMe-oF
@@ -679,10 +795,13 @@ func (s *ControllerService) getOrCreateZVOL(ctx context.Context, params *nvmeofV
 	}
 
 	// Create new ZVOL
-	zvol, err := s.apiClient.CreateZvol(ctx, createParams)
+	zvol, err := s.createDatasetStaged(ctx, params.datasetName, params.volumeName, func(name string) (*tnsapi.Dataset, error) {
+		createParams.Name = name
+		return s.apiClient.CreateZvol(ctx, createParams)
+	}, func(datasetID string) { s.applyZFSQoSProperties(ctx, datasetID, params.qos) })
 	if err != nil {
 		timer.ObserveError()
-		return nil, false, createVolumeError(fmt.Sprintf("Failed to create ZVOL %s (%d bytes)", params.zvolName, params.requestedCapacity), err)
+		return nil, false, createVolumeError(fmt.Sprintf("Failed to create ZVOL %s (%d bytes)", params.datasetName, params.requestedCapacity), err)
 	}
 
 	klog.V(4).Infof("Created ZVOL: %s (ID: %s)", zvol.Name, zvol.ID)
@@ -690,18 +809,18 @@ func (s *ControllerService) getOrCreateZVOL(ctx context.Context, params *nvmeofV
 }
 
 // createNVMeOFNamespaceForZVOL creates an NVMe-oF namespace for a ZVOL.
-// With independent subsystem architecture, NSID is always 1.
+// The NSID is requested explicitly from the ZVOL's NSID allocator (see allocateNSID).
 func (s *ControllerService) createNVMeOFNamespaceForZVOL(ctx context.Context, zvol *tnsapi.Dataset, subsystem *tnsapi.NVMeOFSubsystem, timer *metrics.OperationTimer) (*tnsapi.NVMeOFNamespace, error) {
 	devicePath := "zvol/" + zvol.Name
 
-	klog.V(4).Infof("Creating NVMe-oF namespace for device: %s in subsystem %d (ZVOL ID: %s)", devicePath, subsystem.ID, zvol.ID)
+	nsid := s.allocateNSID(ctx, zvol.ID, subsystem.ID)
+	klog.V(4).Infof("Creating NVMe-oF namespace for device: %s in subsystem %d with NSID %d (ZVOL ID: %s)", devicePath, subsystem.ID, nsid, zvol.ID)
 
-	// With independent subsystem architecture, NSID is always 1 (first namespace in new subsystem)
 	namespace, err := s.apiClient.CreateNVMeOFNamespace(ctx, tnsapi.NVMeOFNamespaceCreateParams{
 		SubsysID:   subsystem.ID,
 		DevicePath: devicePath,
 		DeviceType: datasetTypeZVOL,
-		NSID:       1, // Always NSID 1 with independent subsystems
+		NSID:       nsid,
 	})
 	if err != nil {
 		timer.ObserveError()
@@ -780,12 +899,12 @@ func (s *ControllerService) verifyNVMeOFOwnership(ctx context.Context, meta *Vol
 	return deleteStrategy, nil
 }
 
-// deleteNVMeOFVolume deletes an NVMe-oF volume.
+// Teardown deletes an NVMe-oF volume.
 // With independent subsystem architecture, this deletes the namespace, subsystem, and ZVOL.
 // Uses best-effort cleanup: continues deleting resources even if earlier steps fail.
 // This prevents orphaned resources on TrueNAS when partial failures occur.
 // If deleteStrategy is "retain", the volume is kept but CSI returns success.
-func (s *ControllerService) deleteNVMeOFVolume(ctx context.Context, meta *VolumeMetadata) (*csi.DeleteVolumeResponse, error) {
+func (s nvmeofController) Teardown(ctx context.Context, meta *VolumeMetadata) (*csi.DeleteVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolNVMeOF, verbDelete)
 	klog.V(4).Infof("Deleting NVMe-oF volume: %s (dataset: %s, namespace ID: %d, subsystem ID: %d)",
 		meta.Name, meta.DatasetName, meta.NVMeOFNamespaceID, meta.NVMeOFSubsystemID)
@@ -894,7 +1013,9 @@ func (s *ControllerService) deleteNVMeOFVolume(ctx context.Context, meta *Volume
 }
 
 // deleteNVMeOFSubsystem deletes an NVMe-oF subsystem with retry logic for busy resources.
-// This function first verifies all namespaces are removed, then unbinds from ports, then deletes the subsystem.
+// The subsystem must be empty (only this volume's namespace may have been attached, and it must be gone),
+// then all port bindings are removed and verified before the subsystem itself is deleted.
+// A final consistency check removes port_subsys records TrueNAS left behind for the deleted subsystem.
 // TrueNAS will refuse to delete subsystems with active namespaces or port bindings.
 func (s *ControllerService) deleteNVMeOFSubsystem(ctx context.Context, meta *VolumeMetadata) error {
 	if meta.NVMeOFSubsystemID <= 0 {
@@ -905,47 +1026,29 @@ func (s *ControllerService) deleteNVMeOFSubsystem(ctx context.Context, meta *Vol
 
 	// Step 1: Verify no namespaces are attached to this subsystem
 	// TrueNAS will refuse to delete subsystems with active namespaces
-	namespaces, err := s.apiClient.QueryAllNVMeOFNamespaces(ctx)
+	err := retry.WithRetryNoResult(ctx, subsystemCleanupRetryConfig("wait-nvmeof-subsystem-empty"), func() error {
+		return s.verifySubsystemEmpty(ctx, meta)
+	})
 	if err != nil {
-		klog.Warningf("Failed to query namespaces for subsystem cleanup verification (continuing anyway): %v", err)
-	} else {
-		// Count namespaces still attached to this subsystem
-		attachedCount := 0
-		for _, ns := range namespaces {
-			if ns.GetSubsystemID() == meta.NVMeOFSubsystemID {
-				attachedCount++
-				klog.Warningf("Namespace %d (NSID: %d, device: %s) still attached to subsystem %d",
-					ns.ID, ns.NSID, ns.GetDevice(), meta.NVMeOFSubsystemID)
-			}
+		if status.Code(err) == codes.FailedPrecondition {
+			klog.Error(err)
+			return err
 		}
-		if attachedCount > 0 {
-			e := status.Errorf(codes.FailedPrecondition,
-				"Cannot delete subsystem %d: %d namespace(s) still attached. TrueNAS requires all namespaces to be deleted first.",
-				meta.NVMeOFSubsystemID, attachedCount)
-			klog.Error(e)
-			// Don't call timer.ObserveError() here - let the caller handle it
-			return e
-		}
-		klog.V(4).Infof("Verified no namespaces attached to subsystem %d", meta.NVMeOFSubsystemID)
+		e := status.Errorf(codes.FailedPrecondition,
+			"Cannot delete subsystem %d: namespace still attached after deletion. TrueNAS requires all namespaces to be deleted first: %v",
+			meta.NVMeOFSubsystemID, err)
+		klog.Error(e)
+		// Don't call timer.ObserveError() here - let the caller handle it
+		return e
 	}
 
-	// Step 2: Query and unbind all port associations
+	// Step 2: Remove all port associations and verify they are gone
 	// TrueNAS may silently fail to delete subsystems with active port bindings
-	bindings, err := s.apiClient.QuerySubsystemPortBindings(ctx, meta.NVMeOFSubsystemID)
-	if err != nil {
-		klog.Warningf("Failed to query port bindings for subsystem %d (continuing anyway): %v",
+	if err := s.removeSubsystemPortBindings(ctx, meta.NVMeOFSubsystemID); err != nil {
+		// Continue anyway - the subsystem delete below reports the real failure, and the
+		// consistency check after it retries the unbinding
+		klog.Warningf("Port bindings of subsystem %d not fully removed before deletion (continuing anyway): %v",
 			meta.NVMeOFSubsystemID, err)
-	} else if len(bindings) > 0 {
-		klog.V(4).Infof("Unbinding subsystem %d from %d port(s)", meta.NVMeOFSubsystemID, len(bindings))
-		for _, binding := range bindings {
-			if unbindErr := s.apiClient.RemoveSubsystemFromPort(ctx, binding.ID); unbindErr != nil {
-				// Log warning but continue - we still want to try deleting the subsystem
-				klog.Warningf("Failed to unbind subsystem %d from port binding %d (continuing anyway): %v",
-					meta.NVMeOFSubsystemID, binding.ID, unbindErr)
-			} else {
-				klog.V(4).Infof("Unbound subsystem %d from port binding %d", meta.NVMeOFSubsystemID, binding.ID)
-			}
-		}
 	}
 
 	// Step 3: Delete the subsystem with retry logic for busy resources
@@ -968,10 +1071,123 @@ func (s *ControllerService) deleteNVMeOFSubsystem(ctx context.Context, meta *Vol
 		return e
 	}
 
+	// Step 4: Final consistency check - no subsystem and no dangling port_subsys records
+	if err := s.verifySubsystemCleanup(ctx, meta.NVMeOFSubsystemID); err != nil {
+		e := status.Errorf(codes.Internal, "NVMe-oF subsystem %d cleanup incomplete: %v", meta.NVMeOFSubsystemID, err)
+		klog.Error(e)
+		return e
+	}
+
 	klog.V(4).Infof("Deleted NVMe-oF subsystem %d", meta.NVMeOFSubsystemID)
 	return nil
 }
 
+// verifySubsystemEmpty checks that no namespace is attached to the volume's subsystem.
+// A namespace of another ZVOL means the subsystem is not dedicated to this volume, so it must
+// not be deleted (FailedPrecondition, not retried). This volume's own namespace still being
+// listed is a deletion in progress and is reported as retryable errSubsystemNotEmpty.
+func (s *ControllerService) verifySubsystemEmpty(ctx context.Context, meta *VolumeMetadata) error {
+	namespaces, err := s.apiClient.QueryAllNVMeOFNamespaces(ctx)
+	if err != nil {
+		klog.Warningf("Failed to query namespaces for subsystem cleanup verification (continuing anyway): %v", err)
+		return nil
+	}
+
+	ownDevice := ""
+	if meta.DatasetID != "" {
+		ownDevice = "zvol/" + meta.DatasetID
+	}
+
+	ownCount, foreignCount := 0, 0
+	for _, ns := range namespaces {
+		if ns.GetSubsystemID() != meta.NVMeOFSubsystemID {
+			continue
+		}
+		klog.Warningf("Namespace %d (NSID: %d, device: %s) still attached to subsystem %d",
+			ns.ID, ns.NSID, ns.GetDevice(), meta.NVMeOFSubsystemID)
+		if ownDevice != "" && ns.GetDevice() == ownDevice {
+			ownCount++
+		} else {
+			foreignCount++
+		}
+	}
+
+	if foreignCount > 0 {
+		return status.Errorf(codes.FailedPrecondition,
+			"Cannot delete subsystem %d: %d namespace(s) of other volumes still attached. TrueNAS requires all namespaces to be deleted first.",
+			meta.NVMeOFSubsystemID, foreignCount)
+	}
+	if ownCount > 0 {
+		return fmt.Errorf("%w: subsystem %d", errSubsystemNotEmpty, meta.NVMeOFSubsystemID)
+	}
+
+	klog.V(4).Infof("Verified no namespaces attached to subsystem %d", meta.NVMeOFSubsystemID)
+	return nil
+}
+
+// removeSubsystemPortBindings unbinds a subsystem from all ports and re-queries until no binding is left.
+// Query failures are logged and treated as "nothing to remove" (the bindings cannot be inspected).
+func (s *ControllerService) removeSubsystemPortBindings(ctx context.Context, subsystemID int) error {
+	return retry.WithRetryNoResult(ctx, subsystemCleanupRetryConfig("remove-nvmeof-port-bindings"), func() error {
+		bindings, err := s.apiClient.QuerySubsystemPortBindings(ctx, subsystemID)
+		if err != nil {
+			klog.Warningf("Failed to query port bindings for subsystem %d (continuing anyway): %v", subsystemID, err)
+			return nil
+		}
+		if len(bindings) == 0 {
+			return nil
+		}
+
+		klog.V(4).Infof("Unbinding subsystem %d from %d port(s)", subsystemID, len(bindings))
+		for _, binding := range bindings {
+			if unbindErr := s.apiClient.RemoveSubsystemFromPort(ctx, binding.ID); unbindErr != nil && !isNotFoundError(unbindErr) {
+				klog.Warningf("Failed to unbind subsystem %d from port binding %d: %v", subsystemID, binding.ID, unbindErr)
+			} else {
+				klog.V(4).Infof("Unbound subsystem %d from port binding %d", subsystemID, binding.ID)
+			}
+		}
+
+		remaining, err := s.apiClient.QuerySubsystemPortBindings(ctx, subsystemID)
+		if err != nil {
+			klog.Warningf("Failed to re-query port bindings for subsystem %d: %v", subsystemID, err)
+			return nil
+		}
+		if len(remaining) > 0 {
+			return fmt.Errorf("%w: subsystem %d has %d binding(s) left", errPortBindingsRemain, subsystemID, len(remaining))
+		}
+		return nil
+	})
+}
+
+// verifySubsystemCleanup is the final consistency check after a subsystem was deleted:
+// the subsystem must no longer be listed and no port_subsys record may still reference it.
+func (s *ControllerService) verifySubsystemCleanup(ctx context.Context, subsystemID int) error {
+	err := retry.WithRetryNoResult(ctx, subsystemCleanupRetryConfig("verify-nvmeof-subsystem-deleted"), func() error {
+		subsystems, err := s.apiClient.ListAllNVMeOFSubsystems(ctx)
+		if err != nil {
+			klog.V(4).Infof("Could not verify subsystem %d deletion: %v", subsystemID, err)
+			return nil
+		}
+		for i := range subsystems {
+			if subsystems[i].ID == subsystemID {
+				return fmt.Errorf("%w: %d", errSubsystemStillExists, subsystemID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Dangling port_subsys records for a deleted subsystem block nothing on TrueNAS but accumulate forever
+	if err := s.removeSubsystemPortBindings(ctx, subsystemID); err != nil {
+		return fmt.Errorf("dangling port bindings: %w", err)
+	}
+
+	klog.V(4).Infof("Verified subsystem %d and its port bindings are fully deleted", subsystemID)
+	return nil
+}
+
 // deleteNVMeOFNamespace deletes an NVMe-oF namespace with retry logic for busy resources.
 func (s *ControllerService) deleteNVMeOFNamespace(ctx context.Context, meta *VolumeMetadata) error {
 	if meta.NVMeOFNamespaceID <= 0 {
@@ -1032,7 +1248,7 @@ func (s *ControllerService) verifyNamespaceDeletion(ctx context.Context, meta *V
 // deleteZVOL deletes a ZVOL dataset with retry logic for busy resources.
 // Uses a try-first approach: attempts direct deletion (which handles the common case where
 // recursive=true succeeds), then falls back to snapshot cleanup + retry if the direct
-// attempt fails. This avoids the expensive snapshot query in the common case.
+// attempt fails. This avoids the expensive snapshot cleanup in the common case.
 func (s *ControllerService) deleteZVOL(ctx context.Context, meta *VolumeMetadata) error {
 	if meta.DatasetID == "" {
 		klog.Infof("deleteZVOL: DatasetID is empty, skipping deletion")
@@ -1042,27 +1258,25 @@ func (s *ControllerService) deleteZVOL(ctx context.Context, meta *VolumeMetadata
 	klog.Infof("deleteZVOL: Starting deletion of ZVOL %s for volume %s", meta.DatasetID, meta.Name)
 
 	// Try direct deletion first (common case: no dependent snapshots)
-	firstErr := s.apiClient.DeleteDataset(ctx, meta.DatasetID)
+	firstErr := s.deleteVolumeDataset(ctx, meta)
 	if firstErr == nil || isNotFoundError(firstErr) {
 		klog.Infof("deleteZVOL: Successfully deleted ZVOL %s", meta.DatasetID)
 		return nil
 	}
 
-	// If dependent clones from deferred-destroy snapshots, promote them and retry
+	// Clones that could not be promoted will not go away by retrying
 	if isDependentClonesError(firstErr) {
-		if err := s.tryPromoteAndDeleteDataset(ctx, meta.DatasetID); err == nil {
-			return nil
-		}
+		return firstErr
 	}
 
 	// Clean up non-CSI snapshots and retry
 	klog.Infof("deleteZVOL: Direct deletion failed for %s: %v — cleaning up snapshots before retry",
 		meta.DatasetID, firstErr)
-	s.deleteDatasetSnapshots(ctx, meta.DatasetID)
+	s.deleteVolumeSnapshots(ctx, meta)
 
 	retryConfig := retry.DeletionConfig("delete-zvol")
 	err := retry.WithRetryNoResult(ctx, retryConfig, func() error {
-		deleteErr := s.apiClient.DeleteDataset(ctx, meta.DatasetID)
+		deleteErr := s.deleteVolumeDataset(ctx, meta)
 		if deleteErr != nil && isNotFoundError(deleteErr) {
 			return nil
 		}
@@ -1078,102 +1292,100 @@ func (s *ControllerService) deleteZVOL(ctx context.Context, meta *VolumeMetadata
 	return nil
 }
 
-// setupNVMeOFVolumeFromClone sets up NVMe-oF infrastructure for a cloned ZVOL.
+// ExportClone sets up NVMe-oF infrastructure for a cloned ZVOL.
 // With independent subsystem architecture, creates a new subsystem for the clone.
-func (s *ControllerService) setupNVMeOFVolumeFromClone(ctx context.Context, req *csi.CreateVolumeRequest, zvol *tnsapi.Dataset, server, _ string, info *cloneInfo) (*csi.CreateVolumeResponse, error) {
-	klog.Infof("Setting up NVMe-oF namespace for cloned ZVOL: %s (from snapshot, type: %s, cloneMode: %s)", zvol.Name, zvol.Type, info.Mode)
-
-	volumeName := req.GetName()
-	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolNVMeOF, "clone")
-	params := req.GetParameters()
+func (s nvmeofController) ExportClone(ctx context.Context, req *csi.CreateVolumeRequest, zvol *tnsapi.Dataset, params *volumeParams) (*volumeExport, error) {
+	klog.Infof("Setting up NVMe-oF namespace for cloned ZVOL: %s (from snapshot, type: %s)", zvol.Name, zvol.Type)
 
 	// Validate that the dataset is a ZVOL (type=VOLUME), not a filesystem
 	// This can happen if detached snapshot was created incorrectly
 	if zvol.Type != datasetTypeVolume {
 		klog.Errorf("Expected ZVOL (type=VOLUME) but got type=%q for dataset %s. "+
 			"This can happen if the source detached snapshot was not a ZVOL.", zvol.Type, zvol.Name)
-		// Cleanup the non-ZVOL dataset
-		if delErr := s.apiClient.DeleteDataset(ctx, zvol.ID); delErr != nil {
-			klog.Errorf("Failed to cleanup non-ZVOL dataset: %v", delErr)
-		}
-		timer.ObserveError()
 		return nil, status.Errorf(codes.Internal,
 			"Cannot create NVMe-oF volume from snapshot: cloned dataset %s has type %q, expected VOLUME (ZVOL). "+
 				"The source detached snapshot may not have been created correctly from an NVMe-oF volume.",
 			zvol.Name, zvol.Type)
 	}
 
+	// A clone has the volsize of the snapshot's source: grow it to the requested capacity
+	grown, err := s.growClonedZvol(ctx, req, zvol, params.requestedCapacity)
+	if err != nil {
+		return nil, err
+	}
+
+	reqParams := req.GetParameters()
 	// Generate NQN for the cloned volume's dedicated subsystem
-	nqnPrefix := params["subsystemNQN"]
-	if nqnPrefix == "" {
-		nqnPrefix = defaultNQNPrefix
+	subsystemNQN, err := s.subsystemNQN(reqParams, params.volumeName)
+	if err != nil {
+		return nil, err
 	}
-	subsystemNQN := generateNQN(nqnPrefix, volumeName)
 	klog.Infof("Generated NQN for cloned volume: %s", subsystemNQN)
-
-	// Parse optional port ID from StorageClass parameters
-	var portID int
-	if portIDStr := params["portID"]; portIDStr != "" {
-		var err error
-		portID, err = strconv.Atoi(portIDStr)
-		if err != nil {
-			timer.ObserveError()
-			return nil, status.Errorf(codes.InvalidArgument, "invalid portID parameter: %v", err)
-		}
+	portID, err := parseNVMeOFPortID(reqParams)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := parseNVMeOFTransport(reqParams)
+	if err != nil {
+		return nil, err
 	}
 
 	// Step 1: Create dedicated subsystem for the cloned volume
 	klog.Infof("Creating dedicated NVMe-oF subsystem for clone: %s", subsystemNQN)
+	serial, err := s.checkSubsystemFree(ctx, subsystemNQN, params.volumeName)
+	if err != nil {
+		return nil, err
+	}
 	subsystem, err := s.apiClient.CreateNVMeOFSubsystem(ctx, tnsapi.NVMeOFSubsystemCreateParams{
 		Name:         subsystemNQN,
 		Subnqn:       subsystemNQN,
-		AllowAnyHost: true,
+		Serial:       serial,
+		AllowAnyHost: !s.hostAccessControl,
 	})
 	if err != nil {
-		// Cleanup: delete the cloned ZVOL if subsystem creation fails
-		klog.Errorf("Failed to create NVMe-oF subsystem '%s', cleaning up cloned ZVOL: %v", subsystemNQN, err)
-		if delErr := s.apiClient.DeleteDataset(ctx, zvol.ID); delErr != nil {
-			klog.Errorf(msgFailedCleanupClonedZVOL, delErr)
-		}
-		timer.ObserveError()
 		return nil, status.Errorf(codes.Internal, "Failed to create NVMe-oF subsystem '%s' for cloned ZVOL %s: %v", subsystemNQN, zvol.ID, err)
 	}
 
 	klog.Infof("Created NVMe-oF subsystem: ID=%d, Name=%s", subsystem.ID, subsystem.Name)
 
 	// Step 2: Bind subsystem to port
-	if bindErr := s.bindSubsystemToPort(ctx, subsystem.ID, portID, timer); bindErr != nil {
-		// Cleanup: delete subsystem and cloned ZVOL
+	if bindErr := s.bindSubsystemToPort(ctx, subsystem.ID, portID, transport, params.server); bindErr != nil {
 		klog.Errorf("Failed to bind subsystem to port, cleaning up: %v", bindErr)
 		if delErr := s.apiClient.DeleteNVMeOFSubsystem(ctx, subsystem.ID); delErr != nil {
 			klog.Errorf("Failed to cleanup subsystem: %v", delErr)
 		}
-		if delErr := s.apiClient.DeleteDataset(ctx, zvol.ID); delErr != nil {
-			klog.Errorf(msgFailedCleanupClonedZVOL, delErr)
-		}
 		return nil, bindErr
 	}
+	transportContext := map[string]string{}
+	if err := s.injectTransportParams(ctx, transportContext, subsystem.ID, transport); err != nil {
+		if delErr := s.apiClient.DeleteNVMeOFSubsystem(ctx, subsystem.ID); delErr != nil {
+			klog.Errorf("Failed to cleanup subsystem: %v", delErr)
+		}
+		return nil, err
+	}
+
+	// Wait for ZFS metadata to sync before exposing the clone as a namespace:
+	// without it the namespace may be created before the cloned ZVOL's device appears
+	const zfsSyncDelay = 5 * time.Second
+	klog.Infof("Waiting %v for ZFS metadata sync before creating NVMe-oF namespace", zfsSyncDelay)
+	time.Sleep(zfsSyncDelay)
 
-	// Step 3: Create NVMe-oF namespace (NSID = 1)
+	// Step 3: Create NVMe-oF namespace with an explicitly allocated NSID
 	devicePath := "zvol/" + zvol.Name
-	klog.Infof("Creating NVMe-oF namespace for device: %s in subsystem %d", devicePath, subsystem.ID)
+	nsid := s.allocateNSID(ctx, zvol.ID, subsystem.ID)
+	klog.Infof("Creating NVMe-oF namespace for device: %s in subsystem %d with NSID %d", devicePath, subsystem.ID, nsid)
 
 	namespace, err := s.apiClient.CreateNVMeOFNamespace(ctx, tnsapi.NVMeOFNamespaceCreateParams{
 		SubsysID:   subsystem.ID,
 		DevicePath: devicePath,
 		DeviceType: datasetTypeZVOL,
-		NSID:       1, // Always NSID 1 with independent subsystems
+		NSID:       nsid,
 	})
 	if err != nil {
-		// Cleanup: delete subsystem and cloned ZVOL
 		klog.Errorf("Failed to create NVMe-oF namespace, cleaning up: %v", err)
 		if delErr := s.apiClient.DeleteNVMeOFSubsystem(ctx, subsystem.ID); delErr != nil {
 			klog.Errorf("Failed to cleanup subsystem: %v", delErr)
 		}
-		if delErr := s.apiClient.DeleteDataset(ctx, zvol.ID); delErr != nil {
-			klog.Errorf(msgFailedCleanupClonedZVOL, delErr)
-		}
-		timer.ObserveError()
 		return nil, status.Errorf(codes.Internal, "Failed to create NVMe-oF namespace: %v", err)
 	}
 
@@ -1186,128 +1398,54 @@ func (s *ControllerService) setupNVMeOFVolumeFromClone(ctx context.Context, req
 	klog.V(4).Infof("Waiting %v for NVMe-oF namespace to be fully initialized", namespaceInitDelay)
 	time.Sleep(namespaceInitDelay)
 
-	// Get requested capacity
-	requestedCapacity := req.GetCapacityRange().GetRequiredBytes()
-	if requestedCapacity == 0 {
-		requestedCapacity = 1 * 1024 * 1024 * 1024 // Default 1GB
-	}
-
-	// Get deleteStrategy from StorageClass parameters (default: "delete")
-	deleteStrategy := params["deleteStrategy"]
-	if deleteStrategy == "" {
-		deleteStrategy = tnsapi.DeleteStrategyDelete
-	}
-
-	// Step 4: Store ZFS user properties for metadata tracking and ownership verification (Schema v1)
-	props := tnsapi.NVMeOFVolumePropertiesV1(tnsapi.NVMeOFVolumeParams{
-		VolumeID:       volumeName,
-		CapacityBytes:  requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: deleteStrategy,
-		SubsystemID:    subsystem.ID,
-		NamespaceID:    namespace.ID,
-		SubsystemNQN:   subsystem.NQN,
-		PVCName:        params["csi.storage.k8s.io/pvc/name"],
-		PVCNamespace:   params["csi.storage.k8s.io/pvc/namespace"],
-		StorageClass:   params["csi.storage.k8s.io/sc/name"],
-		ClusterID:      s.clusterID,
-	})
-	// Add clone source properties (including clone mode for dependency tracking)
-	for k, v := range tnsapi.ClonedVolumePropertiesV2(tnsapi.ContentSourceSnapshot, info.SnapshotID, info.Mode, info.OriginSnapshot) {
-		props[k] = v
-	}
-	if err := s.apiClient.SetDatasetProperties(ctx, zvol.ID, props); err != nil {
-		// Non-fatal: volume works without properties, but deletion safety is reduced
-		klog.Warningf("Failed to set ZFS properties on cloned ZVOL %s: %v (volume will still work)", zvol.ID, err)
-	} else {
-		klog.V(4).Infof("Set ZFS properties on cloned ZVOL %s: %v", zvol.ID, props)
+	export := nvmeofExport(zvol, params, subsystem, namespace, params.requestedCapacity)
+	if grown {
+		// The filesystem still has the source's size: the node grows it when staging
+		export.context[VolumeContextKeyNodeExpansionRequired] = VolumeContextValueTrue
 	}
+	injectQueueParams(export.context, reqParams["nvmeof.nr-io-queues"], reqParams["nvmeof.queue-size"])
+	maps.Copy(export.context, transportContext)
+	return export, nil
+}
 
-	// Set dataset comment from commentTemplate (if configured) — CloneSnapshot doesn't support setting comments
-	if comment, commentErr := ResolveComment(req.GetParameters(), req.GetName()); commentErr == nil && comment != "" {
-		if _, err := s.apiClient.UpdateDataset(ctx, zvol.ID, tnsapi.DatasetUpdateParams{Comments: comment}); err != nil {
-			klog.Warningf("Failed to set comment on cloned ZVOL %s: %v (non-fatal)", zvol.ID, err)
+// growClonedZvol grows a cloned ZVOL to the requested capacity if the snapshot's source was
+// smaller, and reports whether it did. Read-only restores keep the source's size.
+func (s *ControllerService) growClonedZvol(ctx context.Context, req *csi.CreateVolumeRequest, zvol *tnsapi.Dataset, requestedCapacity int64) (bool, error) {
+	if isReadOnlyContentSourceRequest(req) {
+		return false, nil
+	}
+	current := getZvolCapacity(zvol)
+	if current == 0 {
+		dataset, err := s.apiClient.Dataset(ctx, zvol.ID)
+		if err != nil {
+			klog.Warningf("Cannot read size of cloned ZVOL %s, keeping the size of the snapshot's source: %v", zvol.ID, err)
+			return false, nil
 		}
+		current = getZvolCapacity(dataset)
 	}
-
-	// Build volume metadata
-	// IMPORTANT: Use subsystem.NQN (the full NQN from TrueNAS including UUID prefix),
-	// not subsystemNQN (the short name we generated). TrueNAS adds a UUID prefix to create
-	// the subnqn, and the node plugin must use this full NQN to connect.
-	meta := VolumeMetadata{
-		Name:              volumeName,
-		Protocol:          ProtocolNVMeOF,
-		DatasetID:         zvol.ID,
-		DatasetName:       zvol.Name,
-		Server:            server,
-		NVMeOFSubsystemID: subsystem.ID,
-		NVMeOFNamespaceID: namespace.ID,
-		NVMeOFNQN:         subsystem.NQN, // Use full NQN from TrueNAS (subnqn), not short name
+	if current == 0 || requestedCapacity <= current {
+		return false, nil
 	}
 
-	// Volume ID is the full dataset path for O(1) lookups
-	volumeID := zvol.ID
-
-	// Construct volume context with metadata for node plugin
-	volumeContext := buildVolumeContext(meta)
-	volumeContext[VolumeContextKeyNSID] = "1" // Always NSID 1 with independent subsystems
-	volumeContext[VolumeContextKeyExpectedCapacity] = strconv.FormatInt(requestedCapacity, 10)
-	// CRITICAL: Mark this volume as cloned from snapshot in VolumeContext
-	// This signals to the node that the volume has existing data and should NEVER be formatted
-	volumeContext[VolumeContextKeyClonedFromSnap] = VolumeContextValueTrue
-	injectQueueParams(volumeContext, params["nvmeof.nr-io-queues"], params["nvmeof.queue-size"])
-
-	klog.Infof("Created NVMe-oF volume from snapshot: %s (subsystem: %s, NSID: 1)", volumeName, subsystem.NQN)
-
-	// Record volume capacity metric
-	metrics.SetVolumeCapacity(volumeID, metrics.ProtocolNVMeOF, requestedCapacity)
-
-	timer.ObserveSuccess()
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      volumeID,
-			CapacityBytes: requestedCapacity,
-			VolumeContext: volumeContext,
-			ContentSource: &csi.VolumeContentSource{
-				Type: &csi.VolumeContentSource_Snapshot{
-					Snapshot: &csi.VolumeContentSource_SnapshotSource{
-						SnapshotId: info.SnapshotID,
-					},
-				},
-			},
-		},
-	}, nil
+	klog.Infof("Growing cloned ZVOL %s from %d to the requested %d bytes", zvol.ID, current, requestedCapacity)
+	if _, err := s.apiClient.UpdateDataset(ctx, zvol.ID, tnsapi.DatasetUpdateParams{Volsize: &requestedCapacity}); err != nil {
+		return false, status.Errorf(codes.Internal, "Failed to grow cloned ZVOL %s to %d bytes: %v", zvol.ID, requestedCapacity, err)
+	}
+	return true, nil
 }
 
-// adoptNVMeOFVolume adopts an orphaned NVMe-oF volume by re-creating its subsystem and namespace.
+// ExportAdopted adopts an orphaned NVMe-oF volume by re-creating its subsystem and namespace.
 // This is called when a volume is found by CSI name but needs to be adopted into a new cluster.
-func (s *ControllerService) adoptNVMeOFVolume(ctx context.Context, req *csi.CreateVolumeRequest, dataset *tnsapi.DatasetWithProperties, params map[string]string) (*csi.CreateVolumeResponse, error) {
-	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolNVMeOF, "adopt")
-	volumeName := req.GetName()
-	klog.Infof("Adopting NVMe-oF volume: %s (dataset=%s)", volumeName, dataset.ID)
-
-	// Get server parameter
-	server := params["server"]
-	if server == "" {
-		timer.ObserveError()
-		return nil, status.Error(codes.InvalidArgument, "server parameter is required for NVMe-oF volumes")
-	}
-
-	// Get requested capacity
-	requestedCapacity := req.GetCapacityRange().GetRequiredBytes()
-	if requestedCapacity == 0 {
-		requestedCapacity = 1 * 1024 * 1024 * 1024 // 1 GiB default
+func (s nvmeofController) ExportAdopted(ctx context.Context, req *csi.CreateVolumeRequest, dataset *tnsapi.DatasetWithProperties, params *volumeParams) (*volumeExport, error) {
+	volumeName := params.volumeName
+	reqParams := req.GetParameters()
+	portID, err := parseNVMeOFPortID(reqParams)
+	if err != nil {
+		return nil, err
 	}
-
-	// Parse optional port ID from StorageClass parameters
-	var portID int
-	if portIDStr := params["portID"]; portIDStr != "" {
-		var err error
-		portID, err = strconv.Atoi(portIDStr)
-		if err != nil {
-			timer.ObserveError()
-			return nil, status.Errorf(codes.InvalidArgument, "invalid portID parameter: %v", err)
-		}
+	transport, err := parseNVMeOFTransport(reqParams)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check if subsystem already exists (by looking up stored NQN in properties)
@@ -1333,27 +1471,30 @@ func (s *ControllerService) adoptNVMeOFVolume(ctx context.Context, req *csi.Crea
 
 	// If no subsystem found, create new one
 	if subsystem == nil {
-		nqnPrefix := params["subsystemNQN"]
-		if nqnPrefix == "" {
-			nqnPrefix = defaultNQNPrefix
+		subsystemNQN, err := s.subsystemNQN(reqParams, volumeName)
+		if err != nil {
+			return nil, err
 		}
-		subsystemNQN := generateNQN(nqnPrefix, volumeName)
 		klog.Infof("Creating new subsystem for adopted volume: %s", subsystemNQN)
+		serial, err := s.checkSubsystemFree(ctx, subsystemNQN, volumeName)
+		if err != nil {
+			return nil, err
+		}
 
 		newSubsys, err := s.apiClient.CreateNVMeOFSubsystem(ctx, tnsapi.NVMeOFSubsystemCreateParams{
 			Name:         subsystemNQN,
 			Subnqn:       subsystemNQN,
-			AllowAnyHost: true,
+			Serial:       serial,
+			AllowAnyHost: !s.hostAccessControl,
 		})
 		if err != nil {
-			timer.ObserveError()
 			return nil, status.Errorf(codes.Internal, "Failed to create subsystem for adopted volume: %v", err)
 		}
 		subsystem = newSubsys
 		klog.Infof("Created subsystem for adopted volume: ID=%d, NQN=%s", subsystem.ID, subsystem.NQN)
 
 		// Bind to port
-		if bindErr := s.bindSubsystemToPort(ctx, subsystem.ID, portID, timer); bindErr != nil {
+		if bindErr := s.bindSubsystemToPort(ctx, subsystem.ID, portID, transport, params.server); bindErr != nil {
 			// Cleanup subsystem on failure
 			if delErr := s.apiClient.DeleteNVMeOFSubsystem(ctx, subsystem.ID); delErr != nil {
 				klog.Errorf("Failed to cleanup subsystem after port bind failure: %v", delErr)
@@ -1366,81 +1507,33 @@ func (s *ControllerService) adoptNVMeOFVolume(ctx context.Context, req *csi.Crea
 	if namespace == nil {
 		klog.Infof("Creating namespace for adopted volume: device=%s, subsystem=%d", devicePath, subsystem.ID)
 
+		nsid := s.allocateNSID(ctx, dataset.ID, subsystem.ID)
 		newNS, err := s.apiClient.CreateNVMeOFNamespace(ctx, tnsapi.NVMeOFNamespaceCreateParams{
 			SubsysID:   subsystem.ID,
 			DevicePath: devicePath,
 			DeviceType: datasetTypeZVOL,
-			NSID:       1, // Always NSID 1 with independent subsystems
+			NSID:       nsid,
 		})
 		if err != nil {
-			timer.ObserveError()
 			return nil, status.Errorf(codes.Internal, "Failed to create namespace for adopted volume: %v", err)
 		}
 		namespace = newNS
 		klog.Infof("Created namespace for adopted volume: ID=%d, NSID=%d", namespace.ID, namespace.NSID)
 	}
 
-	// Update ZFS properties with new IDs
-	deleteStrategy := params["deleteStrategy"]
-	if deleteStrategy == "" {
-		deleteStrategy = tnsapi.DeleteStrategyDelete
-	}
-	markAdoptable := params["markAdoptable"] == VolumeContextValueTrue
-
-	props := tnsapi.NVMeOFVolumePropertiesV1(tnsapi.NVMeOFVolumeParams{
-		VolumeID:       volumeName,
-		CapacityBytes:  requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: deleteStrategy,
-		SubsystemID:    subsystem.ID,
-		NamespaceID:    namespace.ID,
-		SubsystemNQN:   subsystem.NQN,
-		PVCName:        params["csi.storage.k8s.io/pvc/name"],
-		PVCNamespace:   params["csi.storage.k8s.io/pvc/namespace"],
-		StorageClass:   params["csi.storage.k8s.io/sc/name"],
-		Adoptable:      markAdoptable,
-		ClusterID:      s.clusterID,
-	})
-	if propErr := s.apiClient.SetDatasetProperties(ctx, dataset.ID, props); propErr != nil {
-		klog.Warningf("Failed to update ZFS properties on adopted volume %s: %v", dataset.ID, propErr)
-	}
-
-	// Build response
-	meta := VolumeMetadata{
-		Name:              volumeName,
-		Protocol:          ProtocolNVMeOF,
-		DatasetID:         dataset.ID,
-		DatasetName:       dataset.Name,
-		Server:            server,
-		NVMeOFSubsystemID: subsystem.ID,
-		NVMeOFNamespaceID: namespace.ID,
-		NVMeOFNQN:         subsystem.NQN,
+	klog.Infof("Found or created NVMe-oF resources for adopted volume: %s (subsystem=%s, namespaceID=%d)", volumeName, subsystem.NQN, namespace.ID)
+	export := nvmeofExport(&dataset.Dataset, params, subsystem, namespace, params.requestedCapacity)
+	injectQueueParams(export.context, reqParams["nvmeof.nr-io-queues"], reqParams["nvmeof.queue-size"])
+	if err := s.injectTransportParams(ctx, export.context, subsystem.ID, transport); err != nil {
+		return nil, err
 	}
-
-	volumeContext := buildVolumeContext(meta)
-	volumeContext[VolumeContextKeyNSID] = "1"
-	volumeContext[VolumeContextKeyExpectedCapacity] = strconv.FormatInt(requestedCapacity, 10)
-	injectQueueParams(volumeContext, params["nvmeof.nr-io-queues"], params["nvmeof.queue-size"])
-
-	// Record volume capacity metric
-	metrics.SetVolumeCapacity(volumeName, metrics.ProtocolNVMeOF, requestedCapacity)
-
-	klog.Infof("Successfully adopted NVMe-oF volume: %s (subsystem=%s, namespaceID=%d)", volumeName, subsystem.NQN, namespace.ID)
-	timer.ObserveSuccess()
-
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      dataset.ID,
-			CapacityBytes: requestedCapacity,
-			VolumeContext: volumeContext,
-		},
-	}, nil
+	return export, nil
 }
 
-// expandNVMeOFVolume expands an NVMe-oF volume by updating the ZVOL size.
+// Expand expands an NVMe-oF volume by updating the ZVOL size.
 //
-//nolint:dupl // Similar to expandNFSVolume but with different parameters (Volsize vs Quota, NodeExpansionRequired)
-func (s *ControllerService) expandNVMeOFVolume(ctx context.Context, meta *VolumeMetadata, requiredBytes int64) (*csi.ControllerExpandVolumeResponse, error) {
+//nolint:dupl // Similar to nfsController.Expand but with different parameters (Volsize vs Quota, NodeExpansionRequired)
+func (s nvmeofController) Expand(ctx context.Context, meta *VolumeMetadata, requiredBytes int64) (*csi.ControllerExpandVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolNVMeOF, "expand")
 	klog.V(4).Infof("Expanding NVMe-oF volume: %s (ZVOL: %s) to %d bytes", meta.Name, meta.DatasetName, requiredBytes)
 
@@ -1479,3 +1572,111 @@ func (s *ControllerService) expandNVMeOFVolume(ctx context.Context, meta *Volume
 		NodeExpansionRequired: true, // NVMe-oF volumes require node-side filesystem expansion
 	}, nil
 }
+
+// Describe retrieves volume information and health status for an NVMe-oF volume.
+func (s nvmeofController) Describe(ctx context.Context, meta *VolumeMetadata) (*csi.ControllerGetVolumeResponse, error) {
+	klog.V(4).Infof("Getting NVMe-oF volume info: %s (dataset: %s, subsystemID: %d, namespaceID: %d)",
+		meta.Name, meta.DatasetName, meta.NVMeOFSubsystemID, meta.NVMeOFNamespaceID)
+
+	abnormal := false
+	var messages []string
+
+	// Check 1: Verify ZVOL exists
+	var datasets []tnsapi.Dataset
+	datasets, err := s.apiClient.QueryAllDatasets(ctx, meta.DatasetName)
+	switch {
+	case err != nil:
+		abnormal = true
+		messages = append(messages, fmt.Sprintf("ZVOL %s query failed: %v", meta.DatasetName, err))
+	case len(datasets) == 0:
+		abnormal = true
+		messages = append(messages, fmt.Sprintf("ZVOL %s not found", meta.DatasetName))
+	default:
+		klog.V(4).Infof("ZVOL %s exists (ID: %s)", meta.DatasetName, datasets[0].ID)
+	}
+
+	// Check 2: Verify NVMe-oF subsystem exists (use NQN-based lookup if available)
+	var subsystemHealthy bool
+	if meta.NVMeOFNQN != "" {
+		foundSubsystem, err := s.apiClient.NVMeOFSubsystemByNQN(ctx, meta.NVMeOFNQN)
+		if err != nil {
+			abnormal = true
+			messages = append(messages, fmt.Sprintf("NVMe-oF subsystem not found for NQN %s: %v", meta.NVMeOFNQN, err))
+		} else {
+			subsystemHealthy = true
+			klog.V(4).Infof("NVMe-oF subsystem %d is healthy (NQN: %s)", foundSubsystem.ID, foundSubsystem.NQN)
+		}
+	} else if meta.NVMeOFSubsystemID > 0 {
+		// Fallback: no NQN stored, list all subsystems to find by ID
+		subsystems, err := s.apiClient.ListAllNVMeOFSubsystems(ctx)
+		if err != nil {
+			abnormal = true
+			messages = append(messages, fmt.Sprintf("Failed to query NVMe-oF subsystems: %v", err))
+		} else {
+			var found bool
+			for i := range subsystems {
+				if subsystems[i].ID == meta.NVMeOFSubsystemID {
+					found = true
+					subsystemHealthy = true
+					klog.V(4).Infof("NVMe-oF subsystem %d is healthy (NQN: %s)", subsystems[i].ID, subsystems[i].NQN)
+					break
+				}
+			}
+			if !found {
+				abnormal = true
+				messages = append(messages, fmt.Sprintf("NVMe-oF subsystem %d not found", meta.NVMeOFSubsystemID))
+			}
+		}
+	}
+
+	// Check 3: Verify NVMe-oF namespace exists (O(1) server-side filter)
+	if meta.NVMeOFNamespaceID > 0 && subsystemHealthy {
+		foundNamespace, err := s.apiClient.QueryNVMeOFNamespaceByID(ctx, meta.NVMeOFNamespaceID)
+		switch {
+		case err != nil:
+			abnormal = true
+			messages = append(messages, fmt.Sprintf("Failed to query NVMe-oF namespace %d: %v", meta.NVMeOFNamespaceID, err))
+		case foundNamespace == nil:
+			abnormal = true
+			messages = append(messages, fmt.Sprintf("NVMe-oF namespace %d not found", meta.NVMeOFNamespaceID))
+			if len(datasets) > 0 {
+				// Start the NSID cool-down so a recreated namespace does not reuse the NSID right away
+				s.noteNSIDReleased(ctx, datasets[0].ID)
+			}
+		default:
+			klog.V(4).Infof("NVMe-oF namespace %d is healthy (NSID: %d, device: %s)",
+				foundNamespace.ID, foundNamespace.NSID, foundNamespace.GetDevice())
+		}
+	}
+
+	// Build response message
+	message := msgVolumeIsHealthy
+	if abnormal {
+		message = strings.Join(messages, "; ")
+	}
+
+	// Build volume context
+	volumeContext := buildVolumeContext(*meta)
+
+	// Get capacity from ZVOL if available
+	var capacityBytes int64
+	if len(datasets) > 0 {
+		capacityBytes = getZvolCapacity(&datasets[0])
+	}
+
+	klog.V(4).Infof("NVMe-oF volume %s status: abnormal=%t, message=%s", meta.Name, abnormal, message)
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      meta.Name,
+			CapacityBytes: capacityBytes,
+			VolumeContext: volumeContext,
+		},
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			VolumeCondition: &csi.VolumeCondition{
+				Abnormal: abnormal,
+				Message:  message,
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,97 @@
+package driver
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"k8s.io/klog/v2"
+)
+
+// NSID allocation.
+//
+// When a namespace is deleted and recreated in the same subsystem, TrueNAS hands out the lowest
+// free NSID again. A host that still has the old namespace cached (a lingering controller
+// connection, a missed AEN) then sees the new ZVOL behind the old device - and writes meant for
+// one volume can land on another. The controller therefore requests NSIDs explicitly: the next
+// NSID is kept in a ZFS property on the ZVOL that owns the subsystem and only moves forward.
+// Numbering restarts at 1 only once the subsystem has been empty for longer than the NSID
+// cool-down, measured from when the controller first saw the previous namespace gone.
+
+// DefaultNVMeOFNSIDCooldown is the default for --nvmeof-nsid-cooldown.
+const DefaultNVMeOFNSIDCooldown = 10 * time.Minute
+
+// allocateNSID returns the NSID to request for a new namespace in the ZVOL's subsystem and
+// records the following one on the ZVOL. Allocation is best effort: lookup failures are logged and
+// it falls back to what is known, since TrueNAS itself rejects an NSID that is still in use.
+func (s *ControllerService) allocateNSID(ctx context.Context, zvolID string, subsystemID int) int {
+	props, err := s.apiClient.GetDatasetProperties(ctx, zvolID, []string{
+		tnsapi.PropertyNVMeNextNSID,
+		tnsapi.PropertyNVMeNSIDReleasedAt,
+	})
+	if err != nil {
+		klog.Warningf("Failed to read NSID bookkeeping for ZVOL %s: %v (allocating from NSIDs in use)", zvolID, err)
+		props = nil
+	}
+
+	namespaces, err := s.apiClient.QueryAllNVMeOFNamespaces(ctx)
+	listed := err == nil
+	if !listed {
+		klog.Warningf("Failed to list NVMe-oF namespaces for subsystem %d: %v (allocating from NSID bookkeeping only)", subsystemID, err)
+	}
+	maxInUse := 0
+	for i := range namespaces {
+		if namespaces[i].GetSubsystemID() == subsystemID && namespaces[i].NSID > maxInUse {
+			maxInUse = namespaces[i].NSID
+		}
+	}
+
+	nsid := max(tnsapi.StringToInt(props[tnsapi.PropertyNVMeNextNSID]), maxInUse+1, 1)
+	releasedAt := parseNSIDReleasedAt(props[tnsapi.PropertyNVMeNSIDReleasedAt])
+	if listed && maxInUse == 0 && !releasedAt.IsZero() && time.Since(releasedAt) >= s.nvmeofNSIDCooldown {
+		klog.V(4).Infof("Subsystem %d empty since %s (cool-down %v elapsed), restarting NSIDs at 1",
+			subsystemID, releasedAt.Format(time.RFC3339), s.nvmeofNSIDCooldown)
+		nsid = 1
+	}
+
+	// Persist before the namespace exists: a crash in between only skips an NSID, never reuses one
+	next := map[string]string{tnsapi.PropertyNVMeNextNSID: strconv.Itoa(nsid + 1)}
+	if err := s.apiClient.SetDatasetProperties(ctx, zvolID, next); err != nil {
+		klog.Warningf("Failed to record next NSID %d on ZVOL %s: %v", nsid+1, zvolID, err)
+	}
+	if !releasedAt.IsZero() {
+		if err := s.apiClient.ClearDatasetProperties(ctx, zvolID, []string{tnsapi.PropertyNVMeNSIDReleasedAt}); err != nil {
+			klog.Warningf("Failed to clear NSID release time on ZVOL %s: %v", zvolID, err)
+		}
+	}
+
+	klog.V(4).Infof("Allocated NSID %d in subsystem %d (highest in use: %d)", nsid, subsystemID, maxInUse)
+	return nsid
+}
+
+// noteNSIDReleased records when the controller first saw a ZVOL's namespace missing, which starts
+// the NSID cool-down. Later sightings keep the original time.
+func (s *ControllerService) noteNSIDReleased(ctx context.Context, zvolID string) {
+	props, err := s.apiClient.GetDatasetProperties(ctx, zvolID, []string{tnsapi.PropertyNVMeNSIDReleasedAt})
+	if err != nil {
+		klog.V(4).Infof("Failed to read NSID release time on ZVOL %s: %v", zvolID, err)
+		return
+	}
+	if !parseNSIDReleasedAt(props[tnsapi.PropertyNVMeNSIDReleasedAt]).IsZero() {
+		return
+	}
+	releasedAt := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := s.apiClient.SetDatasetProperties(ctx, zvolID, map[string]string{tnsapi.PropertyNVMeNSIDReleasedAt: releasedAt}); err != nil {
+		klog.Warningf("Failed to record NSID release time on ZVOL %s: %v", zvolID, err)
+	}
+}
+
+// parseNSIDReleasedAt parses a PropertyNVMeNSIDReleasedAt value, returning the zero time if unset.
+func parseNSIDReleasedAt(value string) time.Time {
+	seconds := tnsapi.StringToInt64(value)
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
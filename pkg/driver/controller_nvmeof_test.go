@@ -3,9 +3,13 @@ package driver
 import (
 	"context"
 	"errors"
+	"maps"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/retry"
 	"github.com/fenio/tns-csi/pkg/tnsapi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -474,15 +478,119 @@ func TestCreateNVMeOFVolume(t *testing.T) {
 			wantErr:  true,
 			wantCode: codes.Internal,
 		},
+		{
+			name: "resume after restart: subsystem exists without port binding or namespace",
+			req: &csi.CreateVolumeRequest{
+				Name: "resumed-volume",
+				Parameters: map[string]string{
+					"protocol":      "nvmeof",
+					"pool":          "tank",
+					"server":        "192.168.1.100",
+					"parentDataset": "tank/nvme",
+				},
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 1024 * 1024 * 1024},
+			},
+			mockSetup: func(m *MockAPIClientForSnapshots) {
+				m.QueryAllDatasetsFunc = func(ctx context.Context, prefix string) ([]tnsapi.Dataset, error) {
+					return []tnsapi.Dataset{{ID: "tank/nvme/resumed-volume", Name: "tank/nvme/resumed-volume", Type: "VOLUME"}}, nil
+				}
+				m.NVMeOFSubsystemByNQNFunc = func(ctx context.Context, nqn string) (*tnsapi.NVMeOFSubsystem, error) {
+					return &tnsapi.NVMeOFSubsystem{ID: 100, Name: nqn, NQN: nqn}, nil
+				}
+				m.QuerySubsystemPortBindingsFunc = func(ctx context.Context, subsystemID int) ([]tnsapi.NVMeOFPortSubsystem, error) {
+					return nil, nil // Interrupted before the port binding
+				}
+				m.QueryNVMeOFPortsFunc = func(ctx context.Context) ([]tnsapi.NVMeOFPort, error) {
+					return []tnsapi.NVMeOFPort{{ID: 1}}, nil
+				}
+				bound := false
+				m.AddSubsystemToPortFunc = func(ctx context.Context, subsystemID, portID int) error {
+					bound = true
+					return nil
+				}
+				m.QueryAllNVMeOFNamespacesFunc = func(ctx context.Context) ([]tnsapi.NVMeOFNamespace, error) {
+					return nil, nil
+				}
+				m.CreateNVMeOFSubsystemFunc = func(ctx context.Context, params tnsapi.NVMeOFSubsystemCreateParams) (*tnsapi.NVMeOFSubsystem, error) {
+					t.Error("Existing subsystem must be reused, not re-created")
+					return nil, errors.New("duplicate subsystem")
+				}
+				m.CreateNVMeOFNamespaceFunc = func(ctx context.Context, params tnsapi.NVMeOFNamespaceCreateParams) (*tnsapi.NVMeOFNamespace, error) {
+					if !bound {
+						t.Error("Expected subsystem to be bound to a port before namespace creation")
+					}
+					if params.SubsysID != 100 {
+						t.Errorf("Expected namespace in existing subsystem 100, got %d", params.SubsysID)
+					}
+					return &tnsapi.NVMeOFNamespace{ID: 200, NSID: 1}, nil
+				}
+			},
+			checkResponse: func(t *testing.T, resp *csi.CreateVolumeResponse) {
+				t.Helper()
+				ctx := resp.GetVolume().GetVolumeContext()
+				if ctx[VolumeContextKeyNQN] != defaultNQNPrefix+":resumed-volume" {
+					t.Errorf("Expected nqn in volume context, got %q", ctx[VolumeContextKeyNQN])
+				}
+				if ctx[VolumeContextKeyNSID] != "1" {
+					t.Errorf("Expected nsid 1, got %q", ctx[VolumeContextKeyNSID])
+				}
+			},
+		},
+		{
+			name: "existing complete volume is reconstructed with the namespace's NSID",
+			req: &csi.CreateVolumeRequest{
+				Name: "complete-volume",
+				Parameters: map[string]string{
+					"protocol":      "nvmeof",
+					"pool":          "tank",
+					"server":        "192.168.1.100",
+					"parentDataset": "tank/nvme",
+				},
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 1024 * 1024 * 1024},
+			},
+			mockSetup: func(m *MockAPIClientForSnapshots) {
+				m.QueryAllDatasetsFunc = func(ctx context.Context, prefix string) ([]tnsapi.Dataset, error) {
+					return []tnsapi.Dataset{{ID: "tank/nvme/complete-volume", Name: "tank/nvme/complete-volume", Type: "VOLUME"}}, nil
+				}
+				m.NVMeOFSubsystemByNQNFunc = func(ctx context.Context, nqn string) (*tnsapi.NVMeOFSubsystem, error) {
+					return &tnsapi.NVMeOFSubsystem{ID: 100, Name: nqn, NQN: nqn}, nil
+				}
+				m.QuerySubsystemPortBindingsFunc = func(ctx context.Context, subsystemID int) ([]tnsapi.NVMeOFPortSubsystem, error) {
+					return []tnsapi.NVMeOFPortSubsystem{{ID: 7, PortID: 1, SubsystemID: subsystemID}}, nil
+				}
+				m.AddSubsystemToPortFunc = func(ctx context.Context, subsystemID, portID int) error {
+					t.Error("Already bound subsystem must not be bound again")
+					return nil
+				}
+				m.QueryAllNVMeOFNamespacesFunc = func(ctx context.Context) ([]tnsapi.NVMeOFNamespace, error) {
+					return []tnsapi.NVMeOFNamespace{{
+						ID:     200,
+						NSID:   3,
+						Device: "zvol/tank/nvme/complete-volume",
+						Subsys: &tnsapi.NVMeOFNamespaceSubsystem{ID: 100},
+					}}, nil
+				}
+			},
+			checkResponse: func(t *testing.T, resp *csi.CreateVolumeResponse) {
+				t.Helper()
+				ctx := resp.GetVolume().GetVolumeContext()
+				if ctx[VolumeContextKeyNSID] != "3" {
+					t.Errorf("Expected nsid 3 from TrueNAS, got %q", ctx[VolumeContextKeyNSID])
+				}
+				if ctx[VolumeContextKeyNQN] == "" {
+					t.Error("Expected nqn in volume context")
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mockClient := &MockAPIClientForSnapshots{}
+			mockClient := &MockAPIClientForSnapshots{QueryNVMeOFSubsystemFunc: noNVMeOFSubsystems, ListAllNVMeOFSubsystemsFunc: noNVMeOFSubsystemList}
 			tt.mockSetup(mockClient)
 
 			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
-			resp, err := controller.createNVMeOFVolume(ctx, tt.req)
+			resp, err := nvmeofController{controller}.Provision(ctx, tt.req)
 
 			if tt.wantErr {
 				if err == nil {
@@ -685,11 +793,11 @@ func TestDeleteNVMeOFVolume(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mockClient := &MockAPIClientForSnapshots{}
+			mockClient := &MockAPIClientForSnapshots{QueryNVMeOFSubsystemFunc: noNVMeOFSubsystems, ListAllNVMeOFSubsystemsFunc: noNVMeOFSubsystemList}
 			tt.mockSetup(mockClient)
 
 			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
-			_, err := controller.deleteNVMeOFVolume(ctx, tt.meta)
+			_, err := nvmeofController{controller}.Teardown(ctx, tt.meta)
 
 			if tt.wantErr && err == nil {
 				t.Error("Expected error but got nil")
@@ -700,6 +808,164 @@ func TestDeleteNVMeOFVolume(t *testing.T) {
 	}
 }
 
+func TestDeleteNVMeOFSubsystemCleanup(t *testing.T) {
+	ctx := context.Background()
+
+	orig := subsystemCleanupRetryConfig
+	subsystemCleanupRetryConfig = func(operationName string) retry.Config {
+		cfg := orig(operationName)
+		cfg.InitialBackoff = time.Millisecond
+		cfg.MaxBackoff = time.Millisecond
+		return cfg
+	}
+	t.Cleanup(func() { subsystemCleanupRetryConfig = orig })
+
+	meta := &VolumeMetadata{
+		Name:              "vol",
+		Protocol:          ProtocolNVMeOF,
+		DatasetID:         "tank/nvme/vol",
+		NVMeOFSubsystemID: 100,
+		NVMeOFNamespaceID: 200,
+	}
+	ownNamespace := tnsapi.NVMeOFNamespace{ID: 200, NSID: 1, Device: "zvol/tank/nvme/vol", Subsys: &tnsapi.NVMeOFNamespaceSubsystem{ID: 100}}
+
+	tests := []struct {
+		setup         func(m *MockAPIClientForSnapshots, deleted *bool, bindings *[]tnsapi.NVMeOFPortSubsystem)
+		name          string
+		wantCode      codes.Code
+		wantDeleted   bool
+		wantNoBinding bool
+	}{
+		{
+			name: "own namespace deletion lags and then completes",
+			setup: func(m *MockAPIClientForSnapshots, _ *bool, _ *[]tnsapi.NVMeOFPortSubsystem) {
+				calls := 0
+				m.QueryAllNVMeOFNamespacesFunc = func(ctx context.Context) ([]tnsapi.NVMeOFNamespace, error) {
+					calls++
+					if calls == 1 {
+						return []tnsapi.NVMeOFNamespace{ownNamespace}, nil
+					}
+					return nil, nil
+				}
+			},
+			wantCode:      codes.OK,
+			wantDeleted:   true,
+			wantNoBinding: true,
+		},
+		{
+			name: "namespace of another volume keeps the subsystem",
+			setup: func(m *MockAPIClientForSnapshots, _ *bool, _ *[]tnsapi.NVMeOFPortSubsystem) {
+				m.QueryAllNVMeOFNamespacesFunc = func(ctx context.Context) ([]tnsapi.NVMeOFNamespace, error) {
+					return []tnsapi.NVMeOFNamespace{{ID: 201, NSID: 2, Device: "zvol/tank/nvme/other", Subsys: &tnsapi.NVMeOFNamespaceSubsystem{ID: 100}}}, nil
+				}
+			},
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name: "own namespace never goes away",
+			setup: func(m *MockAPIClientForSnapshots, _ *bool, _ *[]tnsapi.NVMeOFPortSubsystem) {
+				m.QueryAllNVMeOFNamespacesFunc = func(ctx context.Context) ([]tnsapi.NVMeOFNamespace, error) {
+					return []tnsapi.NVMeOFNamespace{ownNamespace}, nil
+				}
+			},
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name: "port binding removal is retried until verified",
+			setup: func(m *MockAPIClientForSnapshots, _ *bool, bindings *[]tnsapi.NVMeOFPortSubsystem) {
+				*bindings = []tnsapi.NVMeOFPortSubsystem{{ID: 7}, {ID: 8}}
+				failOnce := true
+				m.RemoveSubsystemFromPortFunc = func(ctx context.Context, portSubsysID int) error {
+					if portSubsysID == 8 && failOnce {
+						failOnce = false
+						return errors.New("port binding busy")
+					}
+					removeBinding(bindings, portSubsysID)
+					return nil
+				}
+			},
+			wantCode:      codes.OK,
+			wantDeleted:   true,
+			wantNoBinding: true,
+		},
+		{
+			name: "dangling port_subsys record after deletion is removed",
+			setup: func(m *MockAPIClientForSnapshots, deleted *bool, bindings *[]tnsapi.NVMeOFPortSubsystem) {
+				m.DeleteNVMeOFSubsystemFunc = func(ctx context.Context, subsystemID int) error {
+					*deleted = true
+					// TrueNAS deleted the subsystem but left its port_subsys record behind
+					*bindings = append(*bindings, tnsapi.NVMeOFPortSubsystem{ID: 9})
+					return nil
+				}
+			},
+			wantCode:      codes.OK,
+			wantDeleted:   true,
+			wantNoBinding: true,
+		},
+		{
+			name: "subsystem still listed after deletion",
+			setup: func(m *MockAPIClientForSnapshots, _ *bool, _ *[]tnsapi.NVMeOFPortSubsystem) {
+				m.ListAllNVMeOFSubsystemsFunc = func(ctx context.Context) ([]tnsapi.NVMeOFSubsystem, error) {
+					return []tnsapi.NVMeOFSubsystem{{ID: 100}}, nil
+				}
+			},
+			wantCode:    codes.Internal,
+			wantDeleted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleted := false
+			var bindings []tnsapi.NVMeOFPortSubsystem
+			m := &MockAPIClientForSnapshots{
+				QueryAllNVMeOFNamespacesFunc: func(ctx context.Context) ([]tnsapi.NVMeOFNamespace, error) {
+					return nil, nil
+				},
+				QuerySubsystemPortBindingsFunc: func(ctx context.Context, subsystemID int) ([]tnsapi.NVMeOFPortSubsystem, error) {
+					return append([]tnsapi.NVMeOFPortSubsystem(nil), bindings...), nil
+				},
+				RemoveSubsystemFromPortFunc: func(ctx context.Context, portSubsysID int) error {
+					removeBinding(&bindings, portSubsysID)
+					return nil
+				},
+				DeleteNVMeOFSubsystemFunc: func(ctx context.Context, subsystemID int) error {
+					deleted = true
+					return nil
+				},
+				ListAllNVMeOFSubsystemsFunc: func(ctx context.Context) ([]tnsapi.NVMeOFSubsystem, error) {
+					return nil, nil
+				},
+			}
+			tt.setup(m, &deleted, &bindings)
+
+			controller := NewControllerService(m, NewNodeRegistry(), "")
+			err := controller.deleteNVMeOFSubsystem(ctx, meta)
+
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("deleteNVMeOFSubsystem() code = %v, want %v (err: %v)", got, tt.wantCode, err)
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("subsystem deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+			if tt.wantNoBinding && len(bindings) > 0 {
+				t.Errorf("port bindings left behind: %+v", bindings)
+			}
+		})
+	}
+}
+
+// removeBinding removes a port_subsys record by ID from a mock binding list.
+func removeBinding(bindings *[]tnsapi.NVMeOFPortSubsystem, id int) {
+	kept := (*bindings)[:0]
+	for _, b := range *bindings {
+		if b.ID != id {
+			kept = append(kept, b)
+		}
+	}
+	*bindings = kept
+}
+
 func TestExpandNVMeOFVolume(t *testing.T) {
 	ctx := context.Background()
 
@@ -787,11 +1053,11 @@ func TestExpandNVMeOFVolume(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mockClient := &MockAPIClientForSnapshots{}
+			mockClient := &MockAPIClientForSnapshots{QueryNVMeOFSubsystemFunc: noNVMeOFSubsystems, ListAllNVMeOFSubsystemsFunc: noNVMeOFSubsystemList}
 			tt.mockSetup(mockClient)
 
 			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
-			resp, err := controller.expandNVMeOFVolume(ctx, tt.meta, tt.requiredBytes)
+			resp, err := nvmeofController{controller}.Expand(ctx, tt.meta, tt.requiredBytes)
 
 			if tt.wantErr {
 				if err == nil {
@@ -818,7 +1084,7 @@ func TestExpandNVMeOFVolume(t *testing.T) {
 	}
 }
 
-func TestSetupNVMeOFVolumeFromClone(t *testing.T) {
+func TestProvisionNVMeOFVolumeFromClone(t *testing.T) {
 	ctx := context.Background()
 
 	tests := []struct {
@@ -932,6 +1198,50 @@ func TestSetupNVMeOFVolumeFromClone(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "restore into a larger volume grows the ZVOL",
+			req: &csi.CreateVolumeRequest{
+				Name:          "cloned-nvmeof-volume",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 5 * 1024 * 1024 * 1024},
+				Parameters:    map[string]string{"protocol": "nvmeof", "pool": "tank", "server": "192.168.1.100"},
+			},
+			zvol: &tnsapi.Dataset{
+				ID:      "tank/cloned-nvmeof-volume",
+				Name:    "tank/cloned-nvmeof-volume",
+				Type:    "VOLUME",
+				Volsize: map[string]interface{}{"parsed": float64(1024 * 1024 * 1024)},
+			},
+			server: "192.168.1.100",
+			mockSetup: func(m *MockAPIClientForSnapshots) {
+				m.UpdateDatasetFunc = func(ctx context.Context, datasetID string, params tnsapi.DatasetUpdateParams) (*tnsapi.Dataset, error) {
+					if params.Volsize == nil || *params.Volsize != 5*1024*1024*1024 {
+						t.Errorf("Expected volsize update to 5GiB, got %v", params.Volsize)
+					}
+					return &tnsapi.Dataset{ID: datasetID}, nil
+				}
+				m.CreateNVMeOFSubsystemFunc = func(ctx context.Context, params tnsapi.NVMeOFSubsystemCreateParams) (*tnsapi.NVMeOFSubsystem, error) {
+					return &tnsapi.NVMeOFSubsystem{ID: 100, Name: params.Name, NQN: params.Subnqn}, nil
+				}
+				m.QueryNVMeOFPortsFunc = func(ctx context.Context) ([]tnsapi.NVMeOFPort, error) {
+					return []tnsapi.NVMeOFPort{{ID: 1}}, nil
+				}
+				m.AddSubsystemToPortFunc = func(ctx context.Context, subsystemID, portID int) error {
+					return nil
+				}
+				m.CreateNVMeOFNamespaceFunc = func(ctx context.Context, params tnsapi.NVMeOFNamespaceCreateParams) (*tnsapi.NVMeOFNamespace, error) {
+					return &tnsapi.NVMeOFNamespace{ID: 200, NSID: 1}, nil
+				}
+			},
+			checkResponse: func(t *testing.T, resp *csi.CreateVolumeResponse) {
+				t.Helper()
+				if resp.Volume.CapacityBytes != 5*1024*1024*1024 {
+					t.Errorf("Expected capacity 5GiB, got %d", resp.Volume.CapacityBytes)
+				}
+				if resp.Volume.VolumeContext[VolumeContextKeyNodeExpansionRequired] != VolumeContextValueTrue {
+					t.Error("Expected nodeExpansionRequired for a grown clone")
+				}
+			},
+		},
 		{
 			name: "subsystem creation failure with cleanup",
 			req: &csi.CreateVolumeRequest{
@@ -1053,16 +1363,16 @@ func TestSetupNVMeOFVolumeFromClone(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mockClient := &MockAPIClientForSnapshots{}
+			mockClient := &MockAPIClientForSnapshots{QueryNVMeOFSubsystemFunc: noNVMeOFSubsystems, ListAllNVMeOFSubsystemsFunc: noNVMeOFSubsystemList}
 			tt.mockSetup(mockClient)
 
 			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
-			// Note: subsystemNQN parameter is ignored in the new architecture - NQN is generated from volume name
 			testCloneInfo := &cloneInfo{
 				Mode:       "cow",
 				SnapshotID: "snapshot-id",
 			}
-			resp, err := controller.setupNVMeOFVolumeFromClone(ctx, tt.req, tt.zvol, tt.server, "", testCloneInfo)
+			tt.req.Parameters["server"] = tt.server
+			resp, err := controller.provisionFromClone(ctx, tt.req, tt.zvol, ProtocolNVMeOF, testCloneInfo)
 
 			if tt.wantErr {
 				if err == nil {
@@ -1164,6 +1474,8 @@ func TestCreateNVMeOFVolumeQueueParams(t *testing.T) {
 	ctx := context.Background()
 
 	commonMockSetup := func(m *MockAPIClientForSnapshots) {
+		m.QueryNVMeOFSubsystemFunc = noNVMeOFSubsystems
+		m.ListAllNVMeOFSubsystemsFunc = noNVMeOFSubsystemList
 		m.QueryAllDatasetsFunc = func(ctx context.Context, prefix string) ([]tnsapi.Dataset, error) {
 			return []tnsapi.Dataset{}, nil
 		}
@@ -1272,9 +1584,9 @@ func TestCreateNVMeOFVolumeQueueParams(t *testing.T) {
 			}
 
 			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
-			resp, err := controller.createNVMeOFVolume(ctx, req)
+			resp, err := nvmeofController{controller}.Provision(ctx, req)
 			if err != nil {
-				t.Fatalf("createNVMeOFVolume() unexpected error: %v", err)
+				t.Fatalf("Provision() unexpected error: %v", err)
 			}
 			if resp.Volume == nil {
 				t.Fatal("Expected non-nil volume in response")
@@ -1309,3 +1621,242 @@ func TestGenerateNQN(t *testing.T) {
 		})
 	}
 }
+
+func TestParseNVMeOFTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport string
+		want      string
+		wantErr   bool
+	}{
+		{name: "default is tcp", transport: "", want: nvmeTransportTCP},
+		{name: "tcp", transport: "tcp", want: nvmeTransportTCP},
+		{name: "rdma uppercase", transport: "RDMA", want: nvmeTransportRDMA},
+		{name: "fc", transport: "fc", want: nvmeTransportFC},
+		{name: "invalid", transport: "loop", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNVMeOFTransport(map[string]string{"transport": tt.transport})
+			if tt.wantErr {
+				if status.Code(err) != codes.InvalidArgument {
+					t.Errorf("Expected InvalidArgument, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseNVMeOFTransport(%q) = %q, want %q", tt.transport, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindSubsystemToPortTransport(t *testing.T) {
+	ctx := context.Background()
+	ports := []tnsapi.NVMeOFPort{
+		{ID: 1, Transport: "TCP", Address: "0.0.0.0"},
+		{ID: 2, Transport: "RDMA"},
+		{ID: 3, Transport: "TCP", Address: "::"},
+		{ID: 4, Transport: "TCP", Address: "2001:db8::10"},
+	}
+
+	tests := []struct {
+		name      string
+		transport string
+		server    string
+		wantPort  int
+		wantCode  codes.Code
+	}{
+		{name: "tcp selects tcp port", transport: nvmeTransportTCP, wantPort: 1},
+		{name: "rdma selects rdma port", transport: nvmeTransportRDMA, wantPort: 2},
+		{name: "fc without fc port", transport: nvmeTransportFC, wantCode: codes.FailedPrecondition},
+		{name: "ipv4 server selects ipv4 wildcard", transport: nvmeTransportTCP, server: "192.0.2.10", wantPort: 1},
+		{name: "ipv6 server selects its address", transport: nvmeTransportTCP, server: "[2001:db8::10]", wantPort: 4},
+		{name: "ipv6 server selects ipv6 wildcard", transport: nvmeTransportTCP, server: "2001:db8::20", wantPort: 3},
+		{name: "dual-stack server selects its address", transport: nvmeTransportTCP, server: "192.0.2.10,2001:db8::10", wantPort: 4},
+		{name: "hostname selects first port", transport: nvmeTransportTCP, server: "truenas.local", wantPort: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var boundPort int
+			mockClient := &MockAPIClientForSnapshots{}
+			mockClient.QueryNVMeOFPortsFunc = func(ctx context.Context) ([]tnsapi.NVMeOFPort, error) {
+				return ports, nil
+			}
+			mockClient.AddSubsystemToPortFunc = func(ctx context.Context, subsystemID, portID int) error {
+				boundPort = portID
+				return nil
+			}
+
+			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+			err := controller.bindSubsystemToPort(ctx, 100, 0, tt.transport, tt.server)
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Errorf("Expected %v, got %v", tt.wantCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if boundPort != tt.wantPort {
+				t.Errorf("Bound to port %d, want %d", boundPort, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestInjectTransportParams(t *testing.T) {
+	ctx := context.Background()
+	mockClient := &MockAPIClientForSnapshots{
+		QuerySubsystemPortBindingsFunc: func(_ context.Context, subsystemID int) ([]tnsapi.NVMeOFPortSubsystem, error) {
+			switch subsystemID {
+			case 1:
+				return []tnsapi.NVMeOFPortSubsystem{{PortID: 10}, {PortID: 20}}, nil
+			case 2:
+				return []tnsapi.NVMeOFPortSubsystem{{PortID: 30}}, nil
+			case 4:
+				return []tnsapi.NVMeOFPortSubsystem{{PortID: 40}}, nil
+			case 5:
+				return []tnsapi.NVMeOFPortSubsystem{{PortID: 50}}, nil
+			}
+			return []tnsapi.NVMeOFPortSubsystem{{PortID: 10}}, nil
+		},
+		QueryNVMeOFPortsFunc: func(_ context.Context) ([]tnsapi.NVMeOFPort, error) {
+			return []tnsapi.NVMeOFPort{
+				{ID: 10, Transport: "TCP", Address: "0.0.0.0", Port: 4420},
+				{ID: 20, Transport: "RDMA", Address: "10.10.0.5", Port: 4421},
+				{ID: 30, Transport: "RDMA", Address: "0.0.0.0", Port: 4420},
+				{ID: 40, Transport: "FC", Address: "nn-0x200000109b123456:pn-0x100000109b123456"},
+				{ID: 50, Transport: "FC", Address: "0.0.0.0"},
+			}, nil
+		},
+	}
+	controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+
+	tests := []struct {
+		want        map[string]string
+		name        string
+		transport   string
+		subsystemID int
+		wantCode    codes.Code
+	}{
+		{name: "tcp keeps server and default port", transport: nvmeTransportTCP, subsystemID: 1, want: map[string]string{}},
+		{
+			name: "rdma connects to the bound port", transport: nvmeTransportRDMA, subsystemID: 1,
+			want: map[string]string{VolumeContextKeyTransport: "rdma", VolumeContextKeyTransportAddress: "10.10.0.5", VolumeContextKeyPort: "4421"},
+		},
+		{
+			name: "rdma wildcard port keeps server", transport: nvmeTransportRDMA, subsystemID: 2,
+			want: map[string]string{VolumeContextKeyTransport: "rdma", VolumeContextKeyPort: "4420"},
+		},
+		{name: "rdma without rdma binding", transport: nvmeTransportRDMA, subsystemID: 3, wantCode: codes.FailedPrecondition},
+		{
+			name: "fc connects to the port names", transport: nvmeTransportFC, subsystemID: 4,
+			want: map[string]string{VolumeContextKeyTransport: "fc", VolumeContextKeyTransportAddress: "nn-0x200000109b123456:pn-0x100000109b123456"},
+		},
+		{name: "fc port without port names", transport: nvmeTransportFC, subsystemID: 5, wantCode: codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			volumeContext := map[string]string{}
+			err := controller.injectTransportParams(ctx, volumeContext, tt.subsystemID, tt.transport)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("injectTransportParams() error = %v, want %v", err, tt.wantCode)
+			}
+			if err == nil && !maps.Equal(volumeContext, tt.want) {
+				t.Errorf("volume context = %v, want %v", volumeContext, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocateNSID(t *testing.T) {
+	ctx := context.Background()
+	releasedAgo := func(d time.Duration) string {
+		return strconv.FormatInt(time.Now().Add(-d).Unix(), 10)
+	}
+
+	tests := []struct {
+		props      map[string]string
+		name       string
+		inUse      []int
+		wantNSID   int
+		wantNext   string
+		wantListOK bool
+	}{
+		{name: "fresh subsystem", wantNSID: 1, wantNext: "2", wantListOK: true},
+		{
+			name:       "namespace recreated without observed release",
+			props:      map[string]string{tnsapi.PropertyNVMeNextNSID: "3"},
+			wantNSID:   3,
+			wantNext:   "4",
+			wantListOK: true,
+		},
+		{
+			name:       "released within cool-down",
+			props:      map[string]string{tnsapi.PropertyNVMeNextNSID: "3", tnsapi.PropertyNVMeNSIDReleasedAt: releasedAgo(time.Minute)},
+			wantNSID:   3,
+			wantNext:   "4",
+			wantListOK: true,
+		},
+		{
+			name:       "cool-down elapsed",
+			props:      map[string]string{tnsapi.PropertyNVMeNextNSID: "3", tnsapi.PropertyNVMeNSIDReleasedAt: releasedAgo(time.Hour)},
+			wantNSID:   1,
+			wantNext:   "2",
+			wantListOK: true,
+		},
+		{
+			name:       "cool-down elapsed but subsystem in use",
+			props:      map[string]string{tnsapi.PropertyNVMeNextNSID: "2", tnsapi.PropertyNVMeNSIDReleasedAt: releasedAgo(time.Hour)},
+			inUse:      []int{4},
+			wantNSID:   5,
+			wantNext:   "6",
+			wantListOK: true,
+		},
+		{
+			name:     "namespaces cannot be listed",
+			props:    map[string]string{tnsapi.PropertyNVMeNextNSID: "3", tnsapi.PropertyNVMeNSIDReleasedAt: releasedAgo(time.Hour)},
+			wantNSID: 3,
+			wantNext: "4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var recorded map[string]string
+			mockClient := &MockAPIClientForSnapshots{}
+			mockClient.GetDatasetPropertiesFunc = func(ctx context.Context, datasetID string, propertyNames []string) (map[string]string, error) {
+				return tt.props, nil
+			}
+			mockClient.SetDatasetPropertiesFunc = func(ctx context.Context, datasetID string, properties map[string]string) error {
+				recorded = properties
+				return nil
+			}
+			if tt.wantListOK {
+				mockClient.QueryAllNVMeOFNamespacesFunc = func(ctx context.Context) ([]tnsapi.NVMeOFNamespace, error) {
+					namespaces := []tnsapi.NVMeOFNamespace{{ID: 1, NSID: 9, Subsys: &tnsapi.NVMeOFNamespaceSubsystem{ID: 200}}}
+					for i, nsid := range tt.inUse {
+						namespaces = append(namespaces, tnsapi.NVMeOFNamespace{ID: 10 + i, NSID: nsid, Subsys: &tnsapi.NVMeOFNamespaceSubsystem{ID: 100}})
+					}
+					return namespaces, nil
+				}
+			}
+
+			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+			controller.nvmeofNSIDCooldown = 10 * time.Minute
+			if nsid := controller.allocateNSID(ctx, "tank/nvme/pvc-1", 100); nsid != tt.wantNSID {
+				t.Errorf("allocateNSID() = %d, want %d", nsid, tt.wantNSID)
+			}
+			if next := recorded[tnsapi.PropertyNVMeNextNSID]; next != tt.wantNext {
+				t.Errorf("recorded next NSID %q, want %q", next, tt.wantNext)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package driver
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/metrics"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"k8s.io/klog/v2"
+)
+
+// Orphaned volume collection.
+//
+// A volume is orphaned when its dataset is still on TrueNAS but no PersistentVolume refers to it:
+// the provisioner gave up on a CreateVolume that had already succeeded, a PV was removed without
+// DeleteVolume, or a Retain PV was deleted by hand. With --orphan-gc-interval the controller
+// compares the datasets of this cluster with the PV cache of --kube-informers at that interval,
+// logs every orphan and exports their count. Volumes that VolumeSnapshotContents still refer to
+// are not orphans: deleting them would destroy the snapshots.
+//
+// With --orphan-gc-delete-after, orphans that stay orphaned that long are deleted through
+// DeleteVolume (shares, targets and all). The clock starts when this controller first sees the
+// orphan, so a restart postpones deletions rather than hastening them. Deletion only happens while
+// both the PV and the VolumeSnapshotContent caches are synced; volumes with deleteStrategy retain
+// or marked adoptable are only ever reported. With --mark-retained-adoptable the scan also marks
+// volumes of Retain PVs adoptable (controller_retained.go).
+
+// runOrphanGC scans for orphaned volumes every interval until stopCh is closed.
+func (s *ControllerService) runOrphanGC(interval time.Duration, stopCh <-chan struct{}) {
+	if s.orphanGCDeleteAfter > 0 {
+		klog.Infof("Orphan GC enabled: scanning every %s, deleting volumes orphaned for %s", interval, s.orphanGCDeleteAfter)
+	} else {
+		klog.Infof("Orphan GC enabled: scanning every %s (report only)", interval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		s.collectOrphans(ctx, time.Now())
+		cancel()
+	}
+}
+
+// collectOrphans runs one orphan scan.
+func (s *ControllerService) collectOrphans(ctx context.Context, now time.Time) {
+	if !s.kubeView.pvsSynced() {
+		klog.V(4).Infof("Orphan scan skipped: PersistentVolume cache not available")
+		return
+	}
+	if enabled, _ := s.maintenance.active(); enabled {
+		klog.V(4).Infof("Orphan scan skipped: %v", errMaintenanceMode)
+		return
+	}
+	snapshotSources, snapshotsKnown := s.kubeView.snapshotSourceVolumes()
+
+	datasets, err := s.apiClient.FindManagedDatasets(ctx, "")
+	if err != nil {
+		klog.Warningf("Orphan scan skipped: %v", err)
+		return
+	}
+
+	orphans := make(map[string]bool)
+	for i := range datasets {
+		ds := &datasets[i]
+		if !s.orphanCandidate(ds) {
+			continue
+		}
+		csiName := ds.UserProperties[tnsapi.PropertyCSIVolumeName].Value
+		pv, _ := s.kubeView.pvForVolume(ds.ID)
+		if pv == nil {
+			pv, _ = s.kubeView.pvForVolume(csiName)
+		}
+		if s.markRetainedAdoptable {
+			s.reconcileRetainedVolume(ctx, ds, pv)
+		}
+		if pv != nil {
+			continue
+		}
+		if snapshotSources[ds.ID] || snapshotSources[csiName] {
+			klog.V(4).Infof("Volume %s has no PersistentVolume but VolumeSnapshotContents refer to it", ds.ID)
+			continue
+		}
+
+		orphans[ds.ID] = true
+		since, seen := s.orphanSince[ds.ID]
+		if !seen {
+			since = now
+			s.orphanSince[ds.ID] = now
+			klog.Warningf("Volume %s (%s) is orphaned: no PersistentVolume refers to it", ds.ID, csiName)
+		}
+
+		if s.orphanGCDeleteAfter <= 0 || now.Sub(since) < s.orphanGCDeleteAfter || !orphanDeletable(ds) {
+			continue
+		}
+		if !snapshotsKnown {
+			klog.Warningf("Not deleting orphaned volume %s: VolumeSnapshotContents cannot be checked", ds.ID)
+			continue
+		}
+		klog.Infof("Deleting volume %s, orphaned since %s", ds.ID, since.Format(time.RFC3339))
+		if _, err := s.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: ds.ID}); err != nil {
+			klog.Warningf("Failed to delete orphaned volume %s, will retry: %v", ds.ID, err)
+			continue
+		}
+		metrics.RecordOrphanedVolumeDeleted()
+		delete(orphans, ds.ID)
+	}
+
+	for datasetID := range s.orphanSince {
+		if !orphans[datasetID] {
+			delete(s.orphanSince, datasetID)
+		}
+	}
+	metrics.SetOrphanedVolumes(len(orphans))
+}
+
+// orphanCandidate reports whether a managed dataset is a volume of this cluster, as opposed to
+// detached snapshots, previews, datasets of other clusters and datasets being created or deleted.
+func (s *ControllerService) orphanCandidate(ds *tnsapi.DatasetWithProperties) bool {
+	prop := func(name string) string { return ds.UserProperties[name].Value }
+	switch {
+	case prop(tnsapi.PropertyClusterID) != s.clusterID:
+		return false
+	case prop(tnsapi.PropertyDetachedSnapshot) == tnsapi.PropertyValueTrue, prop(tnsapi.PropertyPreviewSource) != "":
+		return false
+	case prop(tnsapi.PropertyPendingDelete) == tnsapi.PropertyValueTrue:
+		return false
+	case isStagingDataset(ds.ID), strings.HasPrefix(path.Base(ds.ID), deletingPrefix):
+		return false
+	}
+	return true
+}
+
+// orphanDeletable reports whether the orphan GC may delete an orphaned volume.
+func orphanDeletable(ds *tnsapi.DatasetWithProperties) bool {
+	return ds.UserProperties[tnsapi.PropertyDeleteStrategy].Value != tnsapi.DeleteStrategyRetain &&
+		ds.UserProperties[tnsapi.PropertyAdoptable].Value != tnsapi.PropertyValueTrue
+}
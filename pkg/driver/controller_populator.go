@@ -0,0 +1,77 @@
+package driver
+
+import (
+	"context"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// populatorSchemeDataset prefixes the content source ID of volumes populated from a dataset
+// (see volume_populator.go).
+const populatorSchemeDataset = "dataset://"
+
+// populatorSource is the source of a populated volume.
+type populatorSource struct {
+	scheme string // e.g. "dataset://"
+	ref    string // Scheme-specific reference (dataset path for dataset://)
+}
+
+// datasetTypeForProtocol returns the ZFS dataset type a protocol's volumes are backed by.
+func datasetTypeForProtocol(protocol string) string {
+	if isBlockProtocol(protocol) {
+		return datasetTypeVolume
+	}
+	return datasetTypeFilesystem
+}
+
+// createVolumeFromPopulator creates a volume prepopulated from a DatasetPopulator source.
+// Dataset sources reuse the volume-to-volume clone path, so the clone mode StorageClass
+// parameters (detachedVolumesFromVolumes) apply the same way.
+func (s *ControllerService) createVolumeFromPopulator(ctx context.Context, req *csi.CreateVolumeRequest, source *populatorSource, protocol string) (*csi.CreateVolumeResponse, error) {
+	params := req.GetParameters()
+
+	// Promotion would make the golden dataset depend on the new volume, blocking its deletion
+	// for as long as the source exists. Refuse it rather than surprise the operator.
+	if params[PromotedVolumesFromVolumesParam] == VolumeContextValueTrue && params[DetachedVolumesFromVolumesParam] != VolumeContextValueTrue {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"%s sources cannot be combined with %s=true (the populator source must stay independent)",
+			DatasetPopulatorKind, PromotedVolumesFromVolumesParam)
+	}
+
+	sourceDataset, err := s.apiClient.Dataset(ctx, source.ref)
+	if err != nil || sourceDataset == nil {
+		klog.Warningf("Populator source dataset %s not found: %v", source.ref, err)
+		return nil, status.Errorf(codes.NotFound, "Populator source dataset not found: %s", source.ref)
+	}
+
+	if want := datasetTypeForProtocol(protocol); sourceDataset.Type != "" && sourceDataset.Type != want {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Populator source %s is a %s but %s volumes require a %s", source.ref, sourceDataset.Type, protocol, want)
+	}
+
+	klog.Infof("Populating volume %s from %s%s (protocol: %s)", req.GetName(), source.scheme, source.ref, protocol)
+
+	resp, err := s.createVolumeFromVolume(ctx, req, source.ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the populator as the content source so the volume's origin is visible on the storage side.
+	// The clone path tagged it with the temporary snapshot; overwrite that with the user-facing reference.
+	if volumeID := resp.GetVolume().GetVolumeId(); isDatasetPathVolumeID(volumeID) {
+		props := map[string]string{
+			tnsapi.PropertyContentSourceType: tnsapi.ContentSourcePopulator,
+			tnsapi.PropertyContentSourceID:   source.scheme + source.ref,
+		}
+		if propErr := s.apiClient.SetDatasetProperties(ctx, volumeID, props); propErr != nil {
+			klog.Warningf("Failed to record populator source on %s: %v (non-fatal)", volumeID, propErr)
+		}
+	}
+
+	klog.Infof("Volume %s populated from %s%s", req.GetName(), source.scheme, source.ref)
+	return resp, nil
+}
@@ -0,0 +1,423 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strconv"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/metrics"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Controller protocol layer.
+//
+// Each storage protocol implements controllerProtocol, and the controller RPCs dispatch
+// through controllerProtocolFor instead of switching on the protocol name themselves. An
+// implementation embeds the ControllerService and lives in its controller_<protocol>.go file;
+// the flows all protocols share (parameter parsing, volume properties, adoption and, in
+// controller_snapshot_clone.go, restoring from a snapshot) call into it and are defined here.
+
+// controllerProtocol is the controller-side implementation of a storage protocol.
+type controllerProtocol interface {
+	// Name returns the protocol name (ProtocolNFS, ...), which is also its metrics label.
+	Name() string
+	// Label returns the protocol name used in messages ("NFS", "NVMe-oF", ...).
+	Label() string
+	// BlockDevice reports whether volumes are ZVOLs exported as block devices.
+	BlockDevice() bool
+	// AdoptionProperty returns the property an adoptable volume of the protocol must have.
+	AdoptionProperty() string
+	// Server returns the TrueNAS address volumes are exported on for StorageClass parameters.
+	Server(params map[string]string) (string, error)
+	// Provision creates a new volume.
+	Provision(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error)
+	// ExportClone exports a dataset cloned from a snapshot. On error it removes the resources it
+	// created; the cloned dataset is left to the caller.
+	ExportClone(ctx context.Context, req *csi.CreateVolumeRequest, dataset *tnsapi.Dataset, params *volumeParams) (*volumeExport, error)
+	// ExportAdopted finds or re-creates the storage resources of an orphaned volume.
+	ExportAdopted(ctx context.Context, req *csi.CreateVolumeRequest, dataset *tnsapi.DatasetWithProperties, params *volumeParams) (*volumeExport, error)
+	// FindExisting returns the metadata and context of an existing, complete volume for an
+	// idempotent CreateVolume, or ErrVolumeNotFound to let Provision handle it.
+	FindExisting(ctx context.Context, req *csi.CreateVolumeRequest, params map[string]string, dataset *tnsapi.Dataset, datasetName string, capacity int64) (VolumeMetadata, map[string]string, error)
+	// Teardown deletes a volume.
+	Teardown(ctx context.Context, meta *VolumeMetadata) (*csi.DeleteVolumeResponse, error)
+	// Expand grows a volume to requiredBytes.
+	Expand(ctx context.Context, meta *VolumeMetadata, requiredBytes int64) (*csi.ControllerExpandVolumeResponse, error)
+	// Describe returns volume information and health.
+	Describe(ctx context.Context, meta *VolumeMetadata) (*csi.ControllerGetVolumeResponse, error)
+	// BuildContext adds the protocol's VolumeContext keys for meta.
+	BuildContext(meta *VolumeMetadata, volumeContext map[string]string)
+}
+
+// readOnlyCloneExporter is implemented by protocols whose ExportClone makes read-only restores
+// read-only itself, after work that needs a writable dataset (SMB ACL conversion).
+type readOnlyCloneExporter interface {
+	exportsReadOnlyClones()
+}
+
+// controllerProtocolFor returns the implementation of a protocol, or nil if it is unknown.
+// s may be nil for the methods that do not use the controller (Name, BuildContext, ...).
+func controllerProtocolFor(s *ControllerService, protocol string) controllerProtocol {
+	switch protocol {
+	case ProtocolNFS:
+		return nfsController{s}
+	case ProtocolNVMeOF:
+		return nvmeofController{s}
+	case ProtocolISCSI:
+		return iscsiController{s}
+	case ProtocolSMB:
+		return smbController{s}
+	default:
+		return nil
+	}
+}
+
+// isBlockProtocol reports whether a protocol's volumes are ZVOLs exported as block devices.
+func isBlockProtocol(protocol string) bool {
+	p := controllerProtocolFor(nil, protocol)
+	return p != nil && p.BlockDevice()
+}
+
+// nfsController implements controllerProtocol for NFS (controller_nfs.go).
+type nfsController struct{ *ControllerService }
+
+func (nfsController) Name() string             { return ProtocolNFS }
+func (nfsController) Label() string            { return "NFS" }
+func (nfsController) BlockDevice() bool        { return false }
+func (nfsController) AdoptionProperty() string { return tnsapi.PropertyNFSSharePath }
+
+// Server maps the address with --nfs-server-map; it defaults for tests.
+func (s nfsController) Server(params map[string]string) (string, error) {
+	if server := s.nfsServers.resolve(params["server"]); server != "" {
+		return server, nil
+	}
+	klog.V(4).Infof("No server parameter provided, using default: %s", defaultServerAddress)
+	return defaultServerAddress, nil
+}
+
+func (nfsController) BuildContext(meta *VolumeMetadata, volumeContext map[string]string) {
+	if meta.NFSShareID != 0 {
+		volumeContext[VolumeContextKeyNFSShareID] = strconv.Itoa(meta.NFSShareID)
+	}
+}
+
+// nvmeofController implements controllerProtocol for NVMe-oF (controller_nvmeof.go).
+type nvmeofController struct{ *ControllerService }
+
+func (nvmeofController) Name() string             { return ProtocolNVMeOF }
+func (nvmeofController) Label() string            { return "NVMe-oF" }
+func (nvmeofController) BlockDevice() bool        { return true }
+func (nvmeofController) AdoptionProperty() string { return tnsapi.PropertyNVMeSubsystemNQN }
+func (p nvmeofController) Server(params map[string]string) (string, error) {
+	return requiredServer(p, params)
+}
+
+// FindExisting defers to Provision, which validates the subsystem and namespace as well.
+func (nvmeofController) FindExisting(context.Context, *csi.CreateVolumeRequest, map[string]string, *tnsapi.Dataset, string, int64) (VolumeMetadata, map[string]string, error) {
+	return VolumeMetadata{}, nil, ErrVolumeNotFound
+}
+
+func (nvmeofController) BuildContext(meta *VolumeMetadata, volumeContext map[string]string) {
+	if meta.NVMeOFNQN != "" {
+		volumeContext[VolumeContextKeyNQN] = meta.NVMeOFNQN
+	}
+	if meta.NVMeOFSubsystemID != 0 {
+		volumeContext[VolumeContextKeyNVMeOFSubsystemID] = strconv.Itoa(meta.NVMeOFSubsystemID)
+	}
+	if meta.NVMeOFNamespaceID != 0 {
+		volumeContext[VolumeContextKeyNVMeOFNamespaceID] = strconv.Itoa(meta.NVMeOFNamespaceID)
+	}
+}
+
+// iscsiController implements controllerProtocol for iSCSI (controller_iscsi.go).
+type iscsiController struct{ *ControllerService }
+
+func (iscsiController) Name() string             { return ProtocolISCSI }
+func (iscsiController) Label() string            { return "iSCSI" }
+func (iscsiController) BlockDevice() bool        { return true }
+func (iscsiController) AdoptionProperty() string { return tnsapi.PropertyISCSIIQN }
+func (p iscsiController) Server(params map[string]string) (string, error) {
+	return requiredServer(p, params)
+}
+
+// FindExisting defers to Provision, which validates the target and extent as well.
+func (iscsiController) FindExisting(context.Context, *csi.CreateVolumeRequest, map[string]string, *tnsapi.Dataset, string, int64) (VolumeMetadata, map[string]string, error) {
+	return VolumeMetadata{}, nil, ErrVolumeNotFound
+}
+
+func (iscsiController) BuildContext(meta *VolumeMetadata, volumeContext map[string]string) {
+	if meta.ISCSIIQN != "" {
+		volumeContext[VolumeContextKeyISCSIIQN] = meta.ISCSIIQN
+	}
+	if meta.ISCSITargetID != 0 {
+		volumeContext[VolumeContextKeyISCSITargetID] = strconv.Itoa(meta.ISCSITargetID)
+	}
+	if meta.ISCSIExtentID != 0 {
+		volumeContext[VolumeContextKeyISCSIExtentID] = strconv.Itoa(meta.ISCSIExtentID)
+	}
+}
+
+// smbController implements controllerProtocol for SMB (controller_smb.go).
+type smbController struct{ *ControllerService }
+
+func (smbController) Name() string             { return ProtocolSMB }
+func (smbController) Label() string            { return "SMB" }
+func (smbController) BlockDevice() bool        { return false }
+func (smbController) AdoptionProperty() string { return tnsapi.PropertySMBShareName }
+func (smbController) exportsReadOnlyClones()   {}
+
+// Server defaults for tests.
+func (smbController) Server(params map[string]string) (string, error) {
+	if server := params["server"]; server != "" {
+		return server, nil
+	}
+	klog.V(4).Infof("No server parameter provided, using default: %s", defaultServerAddress)
+	return defaultServerAddress, nil
+}
+
+// FindExisting defers to Provision, which validates the share as well.
+func (smbController) FindExisting(context.Context, *csi.CreateVolumeRequest, map[string]string, *tnsapi.Dataset, string, int64) (VolumeMetadata, map[string]string, error) {
+	return VolumeMetadata{}, nil, ErrVolumeNotFound
+}
+
+func (smbController) BuildContext(meta *VolumeMetadata, volumeContext map[string]string) {
+	if meta.SMBShareID != 0 {
+		volumeContext[VolumeContextKeySMBShareID] = strconv.Itoa(meta.SMBShareID)
+	}
+}
+
+// requiredServer returns the server parameter of a block protocol, which has no default.
+func requiredServer(p controllerProtocol, params map[string]string) (string, error) {
+	if server := params["server"]; server != "" {
+		return server, nil
+	}
+	return "", status.Errorf(codes.InvalidArgument, "server parameter is required for %s volumes", p.Label())
+}
+
+// Shared flows.
+
+// volumeParams holds the CreateVolume parameters all protocols share. The parameters of each
+// protocol embed it: parseVolumeParams fills it for a new volume, existingVolumeParams for a
+// volume whose dataset already exists (restored from a snapshot or adopted).
+type volumeParams struct {
+	zfsParams         map[string]string // zfs.* properties after profiles and driver defaults
+	qos               map[string]string
+	encryption        *encryptionConfig
+	pool              string
+	server            string
+	parentDataset     string
+	volumeName        string
+	datasetName       string // parentDataset/volumeName, a filesystem or a ZVOL
+	comment           string
+	deleteStrategy    string
+	pvcName           string
+	pvcNamespace      string
+	pvName            string
+	storageClass      string
+	requestedCapacity int64
+	markAdoptable     bool
+	// expandExisting grows an existing dataset smaller than the request (adoptExistingWithDifferentSize)
+	expandExisting bool
+}
+
+// parseVolumeParams validates and extracts the shared parameters of a new volume.
+func (s *ControllerService) parseVolumeParams(p controllerProtocol, req *csi.CreateVolumeRequest) (*volumeParams, error) {
+	params := req.GetParameters()
+
+	pool := params["pool"]
+	if pool == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "pool parameter is required for %s volumes", p.Label())
+	}
+	server, err := p.Server(params)
+	if err != nil {
+		return nil, err
+	}
+	parentDataset := params["parentDataset"]
+	if parentDataset == "" {
+		parentDataset = pool
+	}
+
+	// Resolve volume name using templating (if configured in StorageClass)
+	volumeName, err := ResolveVolumeName(params, req.GetName())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve volume name: %v", err)
+	}
+
+	// Resolve dataset comment from commentTemplate (StorageClass or driver default)
+	comment, err := s.resolveComment(params, req.GetName())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve comment template: %v", err)
+	}
+
+	// Expand workloadProfile and the driver default ZFS properties into zfs.* properties (explicit zfs.* parameters win)
+	zfsParams, err := s.zfsParameters(params, p.BlockDevice())
+	if err != nil {
+		return nil, err
+	}
+	qos, err := parseZFSQoSProperties(zfsParams, p.BlockDevice())
+	if err != nil {
+		return nil, err
+	}
+
+	vp := s.existingVolumeParams(req, server)
+	vp.zfsParams = zfsParams
+	vp.qos = qos
+	vp.encryption = parseEncryptionConfig(params, req.GetSecrets())
+	vp.pool = pool
+	vp.parentDataset = parentDataset
+	vp.volumeName = volumeName
+	vp.datasetName = parentDataset + "/" + volumeName
+	vp.comment = comment
+	vp.expandExisting = expandsExistingVolumes(params)
+	return vp, nil
+}
+
+// existingVolumeParams extracts the shared parameters that apply to a volume whose dataset
+// already exists. A broken comment template must not block a restore or an adoption.
+func (s *ControllerService) existingVolumeParams(req *csi.CreateVolumeRequest, server string) *volumeParams {
+	params := req.GetParameters()
+	comment, err := s.resolveComment(params, req.GetName())
+	if err != nil {
+		klog.Warningf("Ignoring comment template for volume %s: %v", req.GetName(), err)
+	}
+	deleteStrategy := params["deleteStrategy"]
+	if deleteStrategy == "" {
+		deleteStrategy = tnsapi.DeleteStrategyDelete
+	}
+	return &volumeParams{
+		server:            server,
+		volumeName:        req.GetName(),
+		comment:           comment,
+		deleteStrategy:    deleteStrategy,
+		markAdoptable:     params["markAdoptable"] == VolumeContextValueTrue,
+		pvcName:           params[CSIPVCName],
+		pvcNamespace:      params[CSIPVCNamespace],
+		pvName:            req.GetName(),
+		storageClass:      params["csi.storage.k8s.io/sc/name"],
+		requestedCapacity: requestedVolumeCapacity(req),
+	}
+}
+
+// volumeProperties returns the Schema v1 properties of a volume with the properties of the
+// protocol's storage resources (tnsapi.NFSShareProperties, ...).
+func (s *ControllerService) volumeProperties(protocol string, params *volumeParams, resources map[string]string) map[string]string {
+	return tnsapi.VolumePropertiesV1(protocol, tnsapi.VolumeParams{
+		VolumeID:       params.volumeName,
+		CapacityBytes:  params.requestedCapacity,
+		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
+		DeleteStrategy: params.deleteStrategy,
+		PVCName:        params.pvcName,
+		PVCNamespace:   params.pvcNamespace,
+		PVName:         params.pvName,
+		StorageClass:   params.storageClass,
+		Adoptable:      params.markAdoptable,
+		ClusterID:      s.clusterID,
+	}, resources)
+}
+
+// volumeExport describes how a protocol exported an existing dataset: the volume's metadata,
+// the VolumeContext keys it adds to buildVolumeContext and the properties of its resources.
+type volumeExport struct {
+	context    map[string]string
+	properties map[string]string
+	meta       VolumeMetadata
+}
+
+// response returns the CreateVolumeResponse of an exported volume and records its capacity.
+func (e *volumeExport) response(capacity int64) *csi.CreateVolumeResponse {
+	volumeContext := buildVolumeContext(e.meta)
+	maps.Copy(volumeContext, e.context)
+	metrics.SetVolumeCapacity(e.meta.DatasetID, e.meta.Protocol, capacity)
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			// Volume ID is the full dataset path for O(1) lookups
+			VolumeId:      e.meta.DatasetID,
+			CapacityBytes: capacity,
+			VolumeContext: volumeContext,
+		},
+	}
+}
+
+// adoptVolume adopts an orphaned volume into this cluster: it re-creates the protocol's
+// storage resources and records them with the adopting PVC in the volume's properties.
+func (s *ControllerService) adoptVolume(ctx context.Context, p controllerProtocol, req *csi.CreateVolumeRequest, dataset *tnsapi.DatasetWithProperties) (*csi.CreateVolumeResponse, error) {
+	timer := metrics.NewVolumeOperationTimer(p.Name(), "adopt")
+	klog.Infof("Adopting %s volume: %s (dataset=%s)", p.Label(), req.GetName(), dataset.ID)
+
+	server, err := p.Server(req.GetParameters())
+	if err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+	params := s.existingVolumeParams(req, server)
+	export, err := p.ExportAdopted(ctx, req, dataset, params)
+	if err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+
+	props := s.volumeProperties(p.Name(), params, export.properties)
+	if propErr := s.apiClient.SetDatasetProperties(ctx, dataset.ID, props); propErr != nil {
+		klog.Warningf("Failed to update ZFS properties on adopted volume %s: %v", dataset.ID, propErr)
+	}
+
+	klog.Infof("Successfully adopted %s volume: %s", p.Label(), req.GetName())
+	timer.ObserveSuccess()
+	return export.response(params.requestedCapacity), nil
+}
+
+// Shared helpers.
+
+// requestedVolumeCapacity returns the capacity a CreateVolume request provisions. The
+// controller's default size is applied before dispatch (applyCapacityPolicy); the 1 GiB
+// fallback covers requests that reach a protocol directly.
+func requestedVolumeCapacity(req *csi.CreateVolumeRequest) int64 {
+	if capacity := req.GetCapacityRange().GetRequiredBytes(); capacity > 0 {
+		return capacity
+	}
+	return MinVolumeSize
+}
+
+// volumeShareComment returns the comment of a volume's NFS or SMB share, ending with the
+// resolved comment template (note) if any. The comment is informational: the capacity is tracked
+// in tns-csi:capacity_bytes and only read back from it for volumes created without that property.
+func volumeShareComment(volumeName string, capacity int64, pvcNamespace, pvcName, pvName, note string) string {
+	return withShareNote(withPVCComment(fmt.Sprintf("CSI Volume: %s | Capacity: %d", volumeName, capacity), pvcNamespace, pvcName, pvName), note)
+}
+
+// withShareNote appends a resolved comment template to a share comment.
+func withShareNote(comment, note string) string {
+	if note == "" {
+		return comment
+	}
+	return comment + " | " + note
+}
+
+// checkExistingZvolCapacity checks that an existing ZVOL satisfies a CreateVolume request for
+// requested bytes and returns the capacity to report. A ZVOL without a readable volsize is
+// assumed compatible. With expand, a smaller ZVOL is grown to the request and grown is true.
+func (s *ControllerService) checkExistingZvolCapacity(ctx context.Context, volumeName string, zvol *tnsapi.Dataset, requested int64, expand bool) (capacity int64, grown bool, err error) {
+	existing := getZvolCapacity(zvol)
+	if existing <= 0 {
+		klog.Warningf("Could not determine capacity for existing ZVOL %s, assuming compatible", zvol.ID)
+		return requested, false, nil
+	}
+	klog.V(4).Infof("Existing ZVOL capacity: %d bytes, requested: %d bytes", existing, requested)
+	// CSI idempotency requirement; ZFS may have rounded the size up
+	if zvolCapacityMatches(existing, requested) {
+		return existing, false, nil
+	}
+	if !expand || existing > requested {
+		return 0, false, capacityMismatchError(volumeName, zvol.ID, existing, requested)
+	}
+	if err := s.expandExistingVolume(ctx, volumeName, zvol.ID, true, existing, requested); err != nil {
+		return 0, false, err
+	}
+	return requested, true, nil
+}
@@ -0,0 +1,92 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestControllerProtocolFor(t *testing.T) {
+	tests := []struct {
+		protocol    string
+		blockDevice bool
+	}{
+		{ProtocolNFS, false},
+		{ProtocolNVMeOF, true},
+		{ProtocolISCSI, true},
+		{ProtocolSMB, false},
+	}
+	for _, tt := range tests {
+		p := controllerProtocolFor(nil, tt.protocol)
+		if p == nil {
+			t.Fatalf("controllerProtocolFor(%q) = nil", tt.protocol)
+		}
+		if p.Name() != tt.protocol {
+			t.Errorf("Name() = %q, want %q", p.Name(), tt.protocol)
+		}
+		if p.BlockDevice() != tt.blockDevice {
+			t.Errorf("%s: BlockDevice() = %v, want %v", tt.protocol, p.BlockDevice(), tt.blockDevice)
+		}
+	}
+	if p := controllerProtocolFor(nil, "ceph"); p != nil {
+		t.Errorf("controllerProtocolFor(unknown) = %T, want nil", p)
+	}
+}
+
+func TestParseVolumeParams(t *testing.T) {
+	s := &ControllerService{}
+	req := &csi.CreateVolumeRequest{Name: "pvc-1", Parameters: map[string]string{"pool": "tank", "markAdoptable": "true"}}
+
+	// Block protocols cannot fall back to a default server
+	_, err := s.parseVolumeParams(iscsiController{s}, req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("parseVolumeParams(iSCSI) without server: error = %v, want InvalidArgument", err)
+	}
+
+	req.Parameters["server"] = "10.0.0.1"
+	params, err := s.parseVolumeParams(nvmeofController{s}, req)
+	if err != nil {
+		t.Fatalf("parseVolumeParams(NVMe-oF) error = %v", err)
+	}
+	if params.parentDataset != "tank" || params.datasetName != "tank/pvc-1" || params.deleteStrategy != tnsapi.DeleteStrategyDelete {
+		t.Errorf("parseVolumeParams() = %+v, want parent tank, dataset tank/pvc-1 and the delete strategy", params)
+	}
+
+	props := s.volumeProperties(ProtocolNVMeOF, params, tnsapi.NVMeOFSubsystemProperties(3, 7, "nqn.test"))
+	if props[tnsapi.PropertyProtocol] != ProtocolNVMeOF || props[tnsapi.PropertyAdoptable] != "true" ||
+		props[tnsapi.PropertyNVMeSubsystemNQN] != "nqn.test" {
+		t.Errorf("volumeProperties() = %v, want the protocol, adoptable and subsystem properties", props)
+	}
+}
+
+func TestBuildVolumeContextProtocolKeys(t *testing.T) {
+	ctx := buildVolumeContext(VolumeMetadata{
+		Name:              "pvc-1",
+		Protocol:          ProtocolNVMeOF,
+		DatasetName:       "tank/pvc-1",
+		NVMeOFNQN:         "nqn.2011-06.com.truenas:pvc-1",
+		NVMeOFSubsystemID: 3,
+		NVMeOFNamespaceID: 7,
+		NFSShareID:        9, // not an NFS volume
+	})
+	if ctx[VolumeContextKeyNQN] != "nqn.2011-06.com.truenas:pvc-1" ||
+		ctx[VolumeContextKeyNVMeOFSubsystemID] != "3" || ctx[VolumeContextKeyNVMeOFNamespaceID] != "7" {
+		t.Errorf("missing NVMe-oF keys: %v", ctx)
+	}
+	if _, ok := ctx[VolumeContextKeyNFSShareID]; ok {
+		t.Errorf("NFS share ID set on NVMe-oF volume: %v", ctx)
+	}
+}
+
+func TestRequestedVolumeCapacity(t *testing.T) {
+	if got := requestedVolumeCapacity(&csi.CreateVolumeRequest{}); got != MinVolumeSize {
+		t.Errorf("no capacity range: got %d, want %d", got, MinVolumeSize)
+	}
+	req := &csi.CreateVolumeRequest{CapacityRange: &csi.CapacityRange{RequiredBytes: 5 * MinVolumeSize}}
+	if got := requestedVolumeCapacity(req); got != 5*MinVolumeSize {
+		t.Errorf("got %d, want %d", got, 5*MinVolumeSize)
+	}
+}
@@ -0,0 +1,96 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/fenio/tns-csi/pkg/metrics"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// Retained volumes.
+//
+// DeleteVolume is never called for PVs with reclaim policy Retain: when the PVC is deleted the PV
+// turns Released, and once the PV is deleted or the cluster is rebuilt the volume stays on TrueNAS
+// with its share still restricted to nodes of the old cluster. With --mark-retained-adoptable the
+// orphan scan (controller_orphan_gc.go) reconciles such volumes:
+//   - while a PV refers to a volume, its reclaim policy is recorded in tns-csi:reclaim_policy, so
+//     the volume is still known to be retained after the PV is gone
+//   - a volume is retained when its PV is Released with reclaim policy Retain, or when no PV
+//     refers to it and it was recorded as Retain or uses deleteStrategy retain
+//   - retained volumes are marked adoptable, so CreateVolume of a new cluster adopts them and the
+//     orphan GC never deletes them, after the hosts list of their NFS share is cleared: it names
+//     nodes of the cluster that released the volume. Network restrictions of the share are kept.
+
+// reconcileRetainedVolume records the reclaim policy of a volume's PV and marks the volume
+// adoptable if it is retained. pv is nil when no PV refers to the volume.
+func (s *ControllerService) reconcileRetainedVolume(ctx context.Context, ds *tnsapi.DatasetWithProperties, pv *corev1.PersistentVolume) {
+	prop := func(name string) string { return ds.UserProperties[name].Value }
+	props := make(map[string]string)
+
+	var retained bool
+	if pv != nil {
+		if policy := string(pv.Spec.PersistentVolumeReclaimPolicy); policy != "" && policy != prop(tnsapi.PropertyReclaimPolicy) {
+			props[tnsapi.PropertyReclaimPolicy] = policy
+		}
+		retained = pv.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimRetain && pv.Status.Phase == corev1.VolumeReleased
+	} else {
+		retained = prop(tnsapi.PropertyReclaimPolicy) == string(corev1.PersistentVolumeReclaimRetain) ||
+			prop(tnsapi.PropertyDeleteStrategy) == tnsapi.DeleteStrategyRetain
+	}
+
+	marking := retained && prop(tnsapi.PropertyAdoptable) != tnsapi.PropertyValueTrue
+	if marking {
+		// Clear the share first: a volume marked adoptable is not looked at again
+		if err := s.clearNFSShareHosts(ctx, ds); err != nil {
+			klog.Warningf("Not marking retained volume %s adoptable yet: %v", ds.ID, err)
+			marking = false
+		} else {
+			props[tnsapi.PropertyAdoptable] = tnsapi.PropertyValueTrue
+		}
+	}
+	if len(props) == 0 {
+		return
+	}
+
+	if err := s.apiClient.SetDatasetProperties(ctx, ds.ID, props); err != nil {
+		klog.Warningf("Failed to record retention of volume %s: %v", ds.ID, err)
+		return
+	}
+	if ds.UserProperties == nil {
+		ds.UserProperties = make(map[string]tnsapi.UserProperty)
+	}
+	for name, value := range props {
+		ds.UserProperties[name] = tnsapi.UserProperty{Value: value}
+	}
+	if marking {
+		klog.Infof("Marked retained volume %s (%s) adoptable", ds.ID, prop(tnsapi.PropertyCSIVolumeName))
+		metrics.RecordRetainedVolumeMarkedAdoptable()
+	}
+}
+
+// clearNFSShareHosts removes the hosts restriction from the NFS share of a volume, if it has one.
+func (s *ControllerService) clearNFSShareHosts(ctx context.Context, ds *tnsapi.DatasetWithProperties) error {
+	if ds.UserProperties[tnsapi.PropertyProtocol].Value != tnsapi.ProtocolNFS {
+		return nil
+	}
+	shareID, err := strconv.Atoi(ds.UserProperties[tnsapi.PropertyNFSShareID].Value)
+	if err != nil || shareID <= 0 {
+		return nil
+	}
+	share, err := s.apiClient.QueryNFSShareByID(ctx, shareID)
+	if err != nil {
+		return fmt.Errorf("failed to look up NFS share %d: %w", shareID, err)
+	}
+	if share == nil || len(share.Hosts) == 0 {
+		return nil
+	}
+	if _, err := s.apiClient.UpdateNFSShare(ctx, shareID, tnsapi.NFSShareUpdateParams{Hosts: &[]string{}}); err != nil {
+		return fmt.Errorf("failed to clear hosts of NFS share %d: %w", shareID, err)
+	}
+	klog.Infof("Cleared hosts %v of NFS share %d of retained volume %s", share.Hosts, shareID, ds.ID)
+	return nil
+}
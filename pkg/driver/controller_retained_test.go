@@ -0,0 +1,102 @@
+package driver
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMarkRetainedAdoptableIntegration(t *testing.T) {
+	controller, srv := newIntegrationController(t)
+	ctx := context.Background()
+
+	create := func(name string) string {
+		t.Helper()
+		resp, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:          name,
+			CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			Parameters: map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local"},
+		})
+		if err != nil {
+			t.Fatalf("CreateVolume(%s) error = %v", name, err)
+		}
+		return resp.GetVolume().GetVolumeId()
+	}
+	released := create("pvc-released")
+	bound := create("pvc-bound")
+	rebuilt := create("pvc-rebuilt")
+	orphan := create("pvc-orphan")
+
+	properties := func(volumeID string) map[string]tnsapi.UserProperty {
+		t.Helper()
+		datasets, err := controller.apiClient.FindManagedDatasets(ctx, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range datasets {
+			if datasets[i].ID == volumeID {
+				return datasets[i].UserProperties
+			}
+		}
+		t.Fatalf("dataset %s not found", volumeID)
+		return nil
+	}
+	shareID, err := strconv.Atoi(properties(released)[tnsapi.PropertyNFSShareID].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := controller.apiClient.UpdateNFSShare(ctx, shareID, tnsapi.NFSShareUpdateParams{Hosts: &[]string{"10.0.0.5"}}); err != nil {
+		t.Fatal(err)
+	}
+	// rebuilt lost its PV with the cluster after a scan had recorded its reclaim policy
+	if err := controller.apiClient.SetDatasetProperties(ctx, rebuilt, map[string]string{tnsapi.PropertyReclaimPolicy: "Retain"}); err != nil {
+		t.Fatal(err)
+	}
+
+	releasedPV := testPV("pv-released", released, "released")
+	releasedPV.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimRetain
+	releasedPV.Status.Phase = corev1.VolumeReleased
+	boundPV := testPV("pv-bound", bound, "bound")
+	boundPV.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimRetain
+	boundPV.Status.Phase = corev1.VolumeBound
+	controller.kubeView = newTestClusterView(t, fake.NewClientset(releasedPV, boundPV))
+	controller.orphanGCDeleteAfter = time.Hour
+	controller.markRetainedAdoptable = true
+
+	start := time.Now()
+	controller.collectOrphans(ctx, start)
+	controller.collectOrphans(ctx, start.Add(2*time.Hour))
+
+	for volumeID, want := range map[string]bool{released: true, bound: false, rebuilt: true} {
+		if got := properties(volumeID)[tnsapi.PropertyAdoptable].Value == tnsapi.PropertyValueTrue; got != want {
+			t.Errorf("volume %s adoptable = %v, want %v", volumeID, got, want)
+		}
+	}
+	if got := properties(bound)[tnsapi.PropertyReclaimPolicy].Value; got != "Retain" {
+		t.Errorf("reclaim policy of %s = %q, want Retain", bound, got)
+	}
+	if !srv.DatasetExists(rebuilt) {
+		t.Error("retained orphan deleted by the orphan GC")
+	}
+	if srv.DatasetExists(orphan) {
+		t.Error("orphan without a Retain policy not deleted")
+	}
+
+	share, err := controller.apiClient.QueryNFSShareByID(ctx, shareID)
+	if err != nil || share == nil {
+		t.Fatalf("QueryNFSShareByID(%d) = %v, %v", shareID, share, err)
+	}
+	if len(share.Hosts) != 0 {
+		t.Errorf("hosts of the released volume's share = %v, want none", share.Hosts)
+	}
+}
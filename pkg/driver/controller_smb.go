@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/fenio/tns-csi/pkg/metrics"
@@ -18,83 +17,25 @@ import (
 
 // smbVolumeParams holds validated parameters for SMB volume creation.
 type smbVolumeParams struct {
-	zfsProps          *zfsDatasetProperties
-	encryption        *encryptionConfig
-	parentDataset     string
-	volumeName        string
-	datasetName       string
-	deleteStrategy    string
-	server            string
-	pool              string
-	comment           string
-	pvcName           string
-	pvcNamespace      string
-	storageClass      string
-	requestedCapacity int64
-	markAdoptable     bool
+	volumeParams
+	zfsProps    *zfsDatasetProperties
+	shareAccess smbShareAccess
 }
 
 // validateSMBParams validates and extracts SMB volume parameters from the request.
-func validateSMBParams(req *csi.CreateVolumeRequest) (*smbVolumeParams, error) {
-	params := req.GetParameters()
-
-	pool := params["pool"]
-	if pool == "" {
-		return nil, status.Error(codes.InvalidArgument, "pool parameter is required for SMB volumes")
-	}
-
-	server := params["server"]
-	if server == "" {
-		server = defaultServerAddress
-		klog.V(4).Infof("No server parameter provided, using default: %s", defaultServerAddress)
-	}
-
-	parentDataset := params["parentDataset"]
-	if parentDataset == "" {
-		parentDataset = pool
-	}
-
-	requestedCapacity := req.GetCapacityRange().GetRequiredBytes()
-	if requestedCapacity == 0 {
-		requestedCapacity = 1 * 1024 * 1024 * 1024 // Default 1GB
-	}
-
-	volumeName, err := ResolveVolumeName(params, req.GetName())
+func (s smbController) validateSMBParams(req *csi.CreateVolumeRequest) (*smbVolumeParams, error) {
+	common, err := s.parseVolumeParams(s, req)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve volume name: %v", err)
+		return nil, err
 	}
-	datasetName := fmt.Sprintf("%s/%s", parentDataset, volumeName)
-
-	comment, err := ResolveComment(params, req.GetName())
+	shareAccess, err := parseSMBShareAccess(req.GetParameters())
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve comment template: %v", err)
-	}
-
-	zfsProps := parseZFSDatasetProperties(params)
-	encryption := parseEncryptionConfig(params, req.GetSecrets())
-
-	deleteStrategy := params["deleteStrategy"]
-	if deleteStrategy == "" {
-		deleteStrategy = tnsapi.DeleteStrategyDelete
+		return nil, err
 	}
-
-	markAdoptable := params["markAdoptable"] == VolumeContextValueTrue
-
 	return &smbVolumeParams{
-		pool:              pool,
-		server:            server,
-		parentDataset:     parentDataset,
-		requestedCapacity: requestedCapacity,
-		volumeName:        volumeName,
-		datasetName:       datasetName,
-		deleteStrategy:    deleteStrategy,
-		markAdoptable:     markAdoptable,
-		zfsProps:          zfsProps,
-		encryption:        encryption,
-		comment:           comment,
-		pvcName:           params["csi.storage.k8s.io/pvc/name"],
-		pvcNamespace:      params["csi.storage.k8s.io/pvc/namespace"],
-		storageClass:      params["csi.storage.k8s.io/sc/name"],
+		volumeParams: *common,
+		zfsProps:     parseZFSDatasetProperties(common.zfsParams),
+		shareAccess:  shareAccess,
 	}, nil
 }
 
@@ -174,19 +115,7 @@ func (s *ControllerService) ensureSMBProperties(ctx context.Context, datasetID s
 	}
 
 	klog.Infof("Recovering missing ZFS properties on dataset %s (orphaned from interrupted creation)", datasetID)
-	props := tnsapi.SMBVolumePropertiesV1(tnsapi.SMBVolumeParams{
-		VolumeID:       params.volumeName,
-		CapacityBytes:  params.requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: params.deleteStrategy,
-		ShareID:        share.ID,
-		ShareName:      share.Name,
-		PVCName:        params.pvcName,
-		PVCNamespace:   params.pvcNamespace,
-		StorageClass:   params.storageClass,
-		Adoptable:      params.markAdoptable,
-		ClusterID:      s.clusterID,
-	})
+	props := s.volumeProperties(ProtocolSMB, &params.volumeParams, tnsapi.SMBShareProperties(share.ID, share.Name))
 	if err := s.apiClient.SetDatasetProperties(ctx, datasetID, props); err != nil {
 		klog.Warningf("Failed to recover ZFS properties on dataset %s: %v (volume will still work)", datasetID, err)
 	} else {
@@ -196,15 +125,12 @@ func (s *ControllerService) ensureSMBProperties(ctx context.Context, datasetID s
 
 // createSMBShareForDataset creates an SMB share for a dataset and stores ZFS properties.
 // datasetIsNew indicates whether the dataset was just created by this operation — if false, the dataset
-// is pre-existing and must NOT be deleted on failure (prevents data loss).
-func (s *ControllerService) createSMBShareForDataset(ctx context.Context, dataset *tnsapi.Dataset, params *smbVolumeParams, datasetIsNew bool, timer *metrics.OperationTimer) (*tnsapi.SMBShare, error) {
-	comment := fmt.Sprintf("CSI Volume: %s | Capacity: %d", params.volumeName, params.requestedCapacity)
-	smbShare, err := s.apiClient.CreateSMBShare(ctx, tnsapi.SMBShareCreateParams{
-		Name:    params.volumeName,
-		Path:    dataset.Mountpoint,
-		Comment: comment,
-		Enabled: true,
-	})
+// is pre-existing and must NOT be deleted on failure (prevents data loss). generation is this
+// attempt's claim on the dataset (see claimCreation).
+func (s *ControllerService) createSMBShareForDataset(ctx context.Context, dataset *tnsapi.Dataset, params *smbVolumeParams, datasetIsNew bool, generation string, timer *metrics.OperationTimer) (*tnsapi.SMBShare, error) {
+	comment := volumeShareComment(params.volumeName, params.requestedCapacity,
+		params.pvcNamespace, params.pvcName, params.pvName, params.comment)
+	smbShare, err := s.apiClient.CreateSMBShare(ctx, params.shareAccess.shareCreateParams(params.volumeName, dataset.Mountpoint, comment, true, false))
 	if err != nil {
 		klog.Errorf("Failed to create SMB share '%s' for dataset %s (mountpoint: %s): %v", params.volumeName, dataset.ID, dataset.Mountpoint, err)
 		if datasetIsNew {
@@ -220,19 +146,13 @@ func (s *ControllerService) createSMBShareForDataset(ctx context.Context, datase
 
 	klog.V(4).Infof("Created SMB share %q with ID: %d for path: %s", smbShare.Name, smbShare.ID, smbShare.Path)
 
-	props := tnsapi.SMBVolumePropertiesV1(tnsapi.SMBVolumeParams{
-		VolumeID:       params.volumeName,
-		CapacityBytes:  params.requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: params.deleteStrategy,
-		ShareID:        smbShare.ID,
-		ShareName:      smbShare.Name,
-		PVCName:        params.pvcName,
-		PVCNamespace:   params.pvcNamespace,
-		StorageClass:   params.storageClass,
-		Adoptable:      params.markAdoptable,
-		ClusterID:      s.clusterID,
-	})
+	// A later attempt claimed the dataset: leave the volume to it
+	if !s.ownsSMBShare(ctx, dataset.ID, generation, smbShare) {
+		timer.ObserveError()
+		return nil, creationSupersededError(params.volumeName)
+	}
+
+	props := s.volumeProperties(ProtocolSMB, &params.volumeParams, tnsapi.SMBShareProperties(smbShare.ID, smbShare.Name))
 	if err := s.apiClient.SetDatasetProperties(ctx, dataset.ID, props); err != nil {
 		klog.Warningf("Failed to set ZFS user properties on dataset %s: %v (volume will still work)", dataset.ID, err)
 	}
@@ -240,12 +160,24 @@ func (s *ControllerService) createSMBShareForDataset(ctx context.Context, datase
 	return smbShare, nil
 }
 
-// createSMBVolume creates an SMB volume with a ZFS dataset and SMB share.
-func (s *ControllerService) createSMBVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+// ownsSMBShare reports whether this attempt still holds its claim on the dataset (see
+// ownsCreation). If a later attempt claimed it, the share this attempt created is removed.
+func (s *ControllerService) ownsSMBShare(ctx context.Context, datasetID, generation string, smbShare *tnsapi.SMBShare) bool {
+	if s.ownsCreation(ctx, datasetID, generation) {
+		return true
+	}
+	if delErr := s.apiClient.DeleteSMBShare(ctx, smbShare.ID); delErr != nil {
+		klog.Errorf("Failed to remove duplicate SMB share %d: %v", smbShare.ID, delErr)
+	}
+	return false
+}
+
+// Provision creates an SMB volume with a ZFS dataset and SMB share.
+func (s smbController) Provision(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolSMB, "create")
 	klog.V(4).Info("Creating SMB volume")
 
-	params, err := validateSMBParams(req)
+	params, err := s.validateSMBParams(req)
 	if err != nil {
 		timer.ObserveError()
 		return nil, err
@@ -273,49 +205,57 @@ func (s *ControllerService) createSMBVolume(ctx context.Context, req *csi.Create
 	// share_type: "SMB" tells TrueNAS to configure NFSv4 ACLs on the dataset,
 	// which is required for SMB sharing (matching democratic-csi's approach).
 	nfsParams := &nfsVolumeParams{
-		pool:              params.pool,
-		server:            params.server,
-		parentDataset:     params.parentDataset,
-		requestedCapacity: params.requestedCapacity,
-		volumeName:        params.volumeName,
-		datasetName:       params.datasetName,
-		deleteStrategy:    params.deleteStrategy,
-		markAdoptable:     params.markAdoptable,
-		zfsProps:          params.zfsProps,
-		encryption:        params.encryption,
-		comment:           params.comment,
-		shareType:         "SMB",
+		volumeParams: params.volumeParams,
+		zfsProps:     params.zfsProps,
+		shareType:    "SMB",
 	}
 	dataset, datasetIsNew, err := s.getOrCreateDataset(ctx, nfsParams, existingDatasets, timer)
 	if err != nil {
 		return nil, err
 	}
 
-	smbShare, err := s.createSMBShareForDataset(ctx, dataset, params, datasetIsNew, timer)
+	// Claim the dataset so a concurrent attempt does not create a second share
+	generation, err := s.claimCreation(ctx, dataset.ID)
+	if err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+	if !datasetIsNew {
+		// An earlier attempt may have finished the share in the meantime
+		resp, done, handleErr := s.handleExistingSMBVolume(ctx, params, dataset, timer)
+		if handleErr != nil {
+			return nil, handleErr
+		}
+		if done {
+			return resp, nil
+		}
+	}
+
+	smbShare, err := s.createSMBShareForDataset(ctx, dataset, params, datasetIsNew, generation, timer)
 	if err != nil {
 		return nil, err
 	}
 
 	// Set NFSv4 ACLs AFTER share creation — TrueNAS may apply a preset ACL
-	// when creating the share, so we override it to allow full access for
-	// authenticated SMB users.
+	// when creating the share, so we override it with the smb.aclPreset/smb.adGroups
+	// ACL (full access for authenticated SMB users by default).
 	if dataset.Mountpoint != "" {
-		if aclErr := s.apiClient.SetFilesystemACL(ctx, dataset.Mountpoint); aclErr != nil {
+		if aclErr := s.apiClient.SetFilesystemNFS4ACL(ctx, dataset.Mountpoint, params.shareAccess.acl); aclErr != nil {
 			klog.Errorf("Failed to set ACL on %s: %v (SMB writes will likely fail with Permission denied)", dataset.Mountpoint, aclErr)
 		}
 	}
 
-	resp := buildSMBVolumeResponse(params.volumeName, params.server, dataset, smbShare, params.requestedCapacity)
+	resp := buildSMBVolumeResponse(params.volumeName, params.server, dataset, smbShare, s.provisionedCapacity(ctx, dataset, params.requestedCapacity))
 
 	klog.Infof("Created SMB volume: %s", params.volumeName)
 	timer.ObserveSuccess()
 	return resp, nil
 }
 
-// deleteSMBVolume deletes an SMB volume with ownership verification.
+// Teardown deletes an SMB volume with ownership verification.
 //
 //nolint:dupl,gocyclo,gocognit // Intentionally similar dataset deletion pattern as NFS/iSCSI; complexity from ownership checks + CSI snapshot guard + dependent clones guard
-func (s *ControllerService) deleteSMBVolume(ctx context.Context, meta *VolumeMetadata) (*csi.DeleteVolumeResponse, error) {
+func (s smbController) Teardown(ctx context.Context, meta *VolumeMetadata) (*csi.DeleteVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolSMB, verbDelete)
 	klog.V(4).Infof("Deleting SMB volume: %s (dataset: %s, share ID: %d)", meta.Name, meta.DatasetName, meta.SMBShareID)
 
@@ -402,38 +342,31 @@ func (s *ControllerService) deleteSMBVolume(ctx context.Context, meta *VolumeMet
 
 		klog.V(4).Infof("Deleting dataset: %s", meta.DatasetID)
 
-		firstErr := s.apiClient.DeleteDataset(ctx, meta.DatasetID)
+		firstErr := s.deleteVolumeDataset(ctx, meta)
 		if firstErr != nil && !isNotFoundError(firstErr) {
-			resolved := false
 			if isDependentClonesError(firstErr) {
-				if err := s.tryPromoteAndDeleteDataset(ctx, meta.DatasetID); err == nil {
-					resolved = true
-				} else {
-					klog.Warningf("Dataset %s has dependent clones — cannot delete", meta.DatasetID)
-					timer.ObserveError()
-					return nil, status.Errorf(codes.FailedPrecondition,
-						"cannot delete volume %s: dataset %s has dependent clones; delete the cloned volumes first",
-						meta.Name, meta.DatasetID)
-				}
+				klog.Warningf("Dataset %s has dependent clones — cannot delete", meta.DatasetID)
+				timer.ObserveError()
+				return nil, status.Errorf(codes.FailedPrecondition,
+					"cannot delete volume %s: dataset %s has dependent clones; delete the cloned volumes first",
+					meta.Name, meta.DatasetID)
 			}
 
-			if !resolved {
-				klog.Infof("Direct deletion failed for %s: %v — cleaning up snapshots before retry", meta.DatasetID, firstErr)
-				s.deleteDatasetSnapshots(ctx, meta.DatasetID)
+			klog.Infof("Direct deletion failed for %s: %v — cleaning up snapshots before retry", meta.DatasetID, firstErr)
+			s.deleteVolumeSnapshots(ctx, meta)
 
-				retryConfig := retry.DeletionConfig("delete-smb-dataset")
-				err := retry.WithRetryNoResult(ctx, retryConfig, func() error {
-					deleteErr := s.apiClient.DeleteDataset(ctx, meta.DatasetID)
-					if deleteErr != nil && isNotFoundError(deleteErr) {
-						return nil
-					}
-					return deleteErr
-				})
-
-				if err != nil {
-					timer.ObserveError()
-					return nil, status.Errorf(codes.Internal, "Failed to delete dataset %s: %v", meta.DatasetID, err)
+			retryConfig := retry.DeletionConfig("delete-smb-dataset")
+			err := retry.WithRetryNoResult(ctx, retryConfig, func() error {
+				deleteErr := s.deleteVolumeDataset(ctx, meta)
+				if deleteErr != nil && isNotFoundError(deleteErr) {
+					return nil
 				}
+				return deleteErr
+			})
+
+			if err != nil {
+				timer.ObserveError()
+				return nil, status.Errorf(codes.Internal, "Failed to delete dataset %s: %v", meta.DatasetID, err)
 			}
 		}
 		klog.V(4).Infof("Successfully deleted dataset %s", meta.DatasetID)
@@ -446,11 +379,19 @@ func (s *ControllerService) deleteSMBVolume(ctx context.Context, meta *VolumeMet
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
-// setupSMBVolumeFromClone sets up an SMB share for a cloned dataset.
-func (s *ControllerService) setupSMBVolumeFromClone(ctx context.Context, req *csi.CreateVolumeRequest, dataset *tnsapi.Dataset, server string, info *cloneInfo) (*csi.CreateVolumeResponse, error) {
-	klog.V(4).Infof("Setting up SMB share for cloned dataset: %s (cloneMode: %s)", dataset.Name, info.Mode)
+// ExportClone exports a cloned dataset with an SMB share. Read-only restores are made
+// read-only here, once the ACL conversion no longer needs a writable dataset.
+func (s smbController) ExportClone(ctx context.Context, req *csi.CreateVolumeRequest, dataset *tnsapi.Dataset, params *volumeParams) (*volumeExport, error) {
+	shareAccess, err := parseSMBShareAccess(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
 
-	volumeName := req.GetName()
+	// Claim the dataset so a concurrent attempt does not create a second share
+	generation, err := s.claimCreation(ctx, dataset.ID)
+	if err != nil {
+		return nil, err
+	}
 
 	// ZFS clones inherit acltype from the PARENT in the hierarchy (e.g., "storage"),
 	// NOT from the origin snapshot's dataset. The parent typically has acltype=posixacl,
@@ -465,50 +406,25 @@ func (s *ControllerService) setupSMBVolumeFromClone(ctx context.Context, req *cs
 	// Step 2: Update dataset acltype to NFSV4 (allowed because share is disabled)
 	// Step 3: Set NFSv4 ACEs on the filesystem
 	// Step 4: Enable the share (triggers config generation with correct ACLs)
-	smbShare, err := s.apiClient.CreateSMBShare(ctx, tnsapi.SMBShareCreateParams{
-		Name:    volumeName,
-		Path:    dataset.Mountpoint,
-		Comment: "CSI Volume (from snapshot): " + volumeName,
-		Enabled: false, // Created disabled — will be enabled after ACL conversion
-	})
+	// Created disabled — will be enabled after ACL conversion
+	smbShare, err := s.apiClient.CreateSMBShare(ctx, shareAccess.shareCreateParams(params.volumeName, dataset.Mountpoint,
+		withShareNote(withPVCComment("CSI Volume (from snapshot): "+params.volumeName, params.pvcNamespace, params.pvcName, params.pvName), params.comment),
+		false, isReadOnlyContentSourceRequest(req)))
 	if err != nil {
-		klog.Errorf("Failed to create SMB share for cloned dataset, cleaning up: %v", err)
-		if delErr := s.apiClient.DeleteDataset(ctx, dataset.ID); delErr != nil {
-			klog.Errorf("Failed to cleanup cloned dataset after SMB share creation failure: %v", delErr)
-		}
 		return nil, status.Errorf(codes.Internal, "Failed to create SMB share for cloned volume: %v", err)
 	}
 	klog.Infof("SMB clone: created disabled share %q (ID: %d) for %s", smbShare.Name, smbShare.ID, dataset.ID)
 
-	if dataset.Mountpoint != "" {
-		klog.Infof("SMB clone: converting ACLs from POSIX to NFSv4 for %s", dataset.ID)
-
-		_, updateErr := s.apiClient.UpdateDataset(ctx, dataset.ID, tnsapi.DatasetUpdateParams{
-			Acltype: "NFSV4",
-			Aclmode: "RESTRICTED",
-		})
-		if updateErr != nil {
-			klog.Errorf("SMB clone: failed to update ACL properties on %s: %v", dataset.ID, updateErr)
-			if delShareErr := s.apiClient.DeleteSMBShare(ctx, smbShare.ID); delShareErr != nil {
-				klog.Errorf("Failed to cleanup SMB share after ACL update failure: %v", delShareErr)
-			}
-			if delErr := s.apiClient.DeleteDataset(ctx, dataset.ID); delErr != nil {
-				klog.Errorf("Failed to cleanup cloned dataset after ACL update failure: %v", delErr)
-			}
-			return nil, status.Errorf(codes.Internal, "Failed to set NFSv4 ACL type on cloned dataset: %v", updateErr)
-		}
-		klog.Infof("SMB clone: updated dataset ACL properties to NFSv4 for %s", dataset.ID)
-
-		if aclErr := s.apiClient.SetFilesystemACL(ctx, dataset.Mountpoint); aclErr != nil {
-			klog.Errorf("SMB clone: failed to set NFSv4 ACEs on %s: %v", dataset.Mountpoint, aclErr)
-		}
+	// A later attempt claimed the dataset: leave the volume to it
+	if !s.ownsSMBShare(ctx, dataset.ID, generation, smbShare) {
+		return nil, creationSupersededError(params.volumeName)
+	}
 
-		// Verify the conversion worked.
-		if acltype, verifyErr := s.apiClient.GetFilesystemACL(ctx, dataset.Mountpoint); verifyErr != nil {
-			klog.Warningf("SMB clone: failed to verify ACL type for %s: %v", dataset.Mountpoint, verifyErr)
-		} else {
-			klog.Infof("SMB clone: verified ACL type after conversion: acltype=%s for %s", acltype, dataset.Mountpoint)
+	if prepErr := s.prepareClonedDataset(ctx, req, dataset, shareAccess); prepErr != nil {
+		if delShareErr := s.apiClient.DeleteSMBShare(ctx, smbShare.ID); delShareErr != nil {
+			klog.Errorf("Failed to cleanup SMB share of cloned dataset: %v", delShareErr)
 		}
+		return nil, prepErr
 	}
 
 	// Enable the share — this updates the DB and may trigger etc.generate('smb'),
@@ -535,173 +451,101 @@ func (s *ControllerService) setupSMBVolumeFromClone(ctx context.Context, req *cs
 		klog.Infof("SMB clone: SMB service reloaded after enabling share %q", smbShare.Name)
 	}
 
-	requestedCapacity := req.GetCapacityRange().GetRequiredBytes()
-	if requestedCapacity == 0 {
-		requestedCapacity = 1 * 1024 * 1024 * 1024
-	}
+	return smbExport(dataset, params, smbShare), nil
+}
 
-	params := req.GetParameters()
-	deleteStrategy := params["deleteStrategy"]
-	if deleteStrategy == "" {
-		deleteStrategy = tnsapi.DeleteStrategyDelete
-	}
+// prepareClonedDataset converts the ACLs of a cloned dataset to NFSv4 while its share is
+// disabled, then makes read-only restores read-only.
+func (s smbController) prepareClonedDataset(ctx context.Context, req *csi.CreateVolumeRequest, dataset *tnsapi.Dataset, shareAccess smbShareAccess) error {
+	if dataset.Mountpoint != "" {
+		klog.Infof("SMB clone: converting ACLs from POSIX to NFSv4 for %s", dataset.ID)
 
-	props := tnsapi.SMBVolumePropertiesV1(tnsapi.SMBVolumeParams{
-		VolumeID:       volumeName,
-		CapacityBytes:  requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: deleteStrategy,
-		ShareID:        smbShare.ID,
-		ShareName:      smbShare.Name,
-		PVCName:        params["csi.storage.k8s.io/pvc/name"],
-		PVCNamespace:   params["csi.storage.k8s.io/pvc/namespace"],
-		StorageClass:   params["csi.storage.k8s.io/sc/name"],
-		ClusterID:      s.clusterID,
-	})
-	cloneProps := tnsapi.ClonedVolumePropertiesV2(tnsapi.ContentSourceSnapshot, info.SnapshotID, info.Mode, info.OriginSnapshot)
-	for k, v := range cloneProps {
-		props[k] = v
-	}
-	if err := s.apiClient.SetDatasetProperties(ctx, dataset.ID, props); err != nil {
-		klog.Warningf("Failed to set ZFS user properties on cloned dataset %s: %v (volume will still work)", dataset.ID, err)
-	}
+		_, updateErr := s.apiClient.UpdateDataset(ctx, dataset.ID, tnsapi.DatasetUpdateParams{
+			Acltype: "NFSV4",
+			Aclmode: "RESTRICTED",
+		})
+		if updateErr != nil {
+			klog.Errorf("SMB clone: failed to update ACL properties on %s: %v", dataset.ID, updateErr)
+			return status.Errorf(codes.Internal, "Failed to set NFSv4 ACL type on cloned dataset: %v", updateErr)
+		}
+		klog.Infof("SMB clone: updated dataset ACL properties to NFSv4 for %s", dataset.ID)
 
-	if comment, commentErr := ResolveComment(req.GetParameters(), req.GetName()); commentErr == nil && comment != "" {
-		if _, err := s.apiClient.UpdateDataset(ctx, dataset.ID, tnsapi.DatasetUpdateParams{Comments: comment}); err != nil {
-			klog.Warningf("Failed to set comment on cloned dataset %s: %v (non-fatal)", dataset.ID, err)
+		if aclErr := s.apiClient.SetFilesystemNFS4ACL(ctx, dataset.Mountpoint, shareAccess.acl); aclErr != nil {
+			klog.Errorf("SMB clone: failed to set NFSv4 ACEs on %s: %v", dataset.Mountpoint, aclErr)
 		}
-	}
 
-	meta := VolumeMetadata{
-		Name:        volumeName,
-		Protocol:    ProtocolSMB,
-		DatasetID:   dataset.ID,
-		DatasetName: dataset.Name,
-		Server:      server,
-		SMBShareID:  smbShare.ID,
+		// Verify the conversion worked.
+		if acltype, verifyErr := s.apiClient.GetFilesystemACL(ctx, dataset.Mountpoint); verifyErr != nil {
+			klog.Warningf("SMB clone: failed to verify ACL type for %s: %v", dataset.Mountpoint, verifyErr)
+		} else {
+			klog.Infof("SMB clone: verified ACL type after conversion: acltype=%s for %s", acltype, dataset.Mountpoint)
+		}
 	}
 
-	volumeID := dataset.ID
-	volumeContext := buildVolumeContext(meta)
-	volumeContext[VolumeContextKeyShare] = smbShare.Name
-	volumeContext[VolumeContextKeyClonedFromSnap] = VolumeContextValueTrue
-
-	klog.Infof("Created SMB volume from snapshot: %s", volumeName)
-	metrics.SetVolumeCapacity(volumeID, metrics.ProtocolSMB, requestedCapacity)
-
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      volumeID,
-			CapacityBytes: requestedCapacity,
-			VolumeContext: volumeContext,
-			ContentSource: &csi.VolumeContentSource{
-				Type: &csi.VolumeContentSource_Snapshot{
-					Snapshot: &csi.VolumeContentSource_SnapshotSource{
-						SnapshotId: info.SnapshotID,
-					},
-				},
-			},
-		},
-	}, nil
-}
-
-// adoptSMBVolume adopts an orphaned SMB volume by re-creating its SMB share.
-func (s *ControllerService) adoptSMBVolume(ctx context.Context, req *csi.CreateVolumeRequest, dataset *tnsapi.DatasetWithProperties, params map[string]string) (*csi.CreateVolumeResponse, error) {
-	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolSMB, "adopt")
-	volumeName := req.GetName()
-	klog.Infof("Adopting SMB volume: %s (dataset=%s)", volumeName, dataset.ID)
-
-	server := params["server"]
-	if server == "" {
-		server = defaultServerAddress
+	// Read-only restores: lock the dataset now that the ACL work is done
+	if isReadOnlyContentSourceRequest(req) {
+		return s.setDatasetReadOnly(ctx, dataset.ID, ProtocolSMB)
 	}
+	return nil
+}
 
-	requestedCapacity := req.GetCapacityRange().GetRequiredBytes()
-	if requestedCapacity == 0 {
-		requestedCapacity = 1 * 1024 * 1024 * 1024
+// smbExport describes a dataset exported with an SMB share.
+func smbExport(dataset *tnsapi.Dataset, params *volumeParams, smbShare *tnsapi.SMBShare) *volumeExport {
+	return &volumeExport{
+		meta: VolumeMetadata{
+			Name:        params.volumeName,
+			Protocol:    ProtocolSMB,
+			DatasetID:   dataset.ID,
+			DatasetName: dataset.Name,
+			Server:      params.server,
+			SMBShareID:  smbShare.ID,
+		},
+		context:    map[string]string{VolumeContextKeyShare: smbShare.Name},
+		properties: tnsapi.SMBShareProperties(smbShare.ID, smbShare.Name),
 	}
+}
 
+// ExportAdopted re-creates the SMB share of an orphaned volume, or reuses its existing share.
+func (s smbController) ExportAdopted(ctx context.Context, req *csi.CreateVolumeRequest, dataset *tnsapi.DatasetWithProperties, params *volumeParams) (*volumeExport, error) {
 	if dataset.Mountpoint == "" {
-		timer.ObserveError()
 		return nil, status.Errorf(codes.Internal, "Dataset %s has no mountpoint", dataset.ID)
 	}
 
+	// Claim the dataset before looking for a share, so a share an earlier attempt finished is reused
+	generation, err := s.claimCreation(ctx, dataset.ID)
+	if err != nil {
+		return nil, err
+	}
 	existingShares, err := s.apiClient.QuerySMBShare(ctx, dataset.Mountpoint)
 	if err != nil {
 		klog.Warningf("Failed to query SMB shares for %s: %v", dataset.Mountpoint, err)
 	}
-
-	var smbShare *tnsapi.SMBShare
 	if len(existingShares) > 0 {
-		smbShare = &existingShares[0]
-		klog.Infof("Found existing SMB share for adopted volume: ID=%d, name=%s", smbShare.ID, smbShare.Name)
-	} else {
-		klog.Infof("Creating SMB share for adopted volume: %s", dataset.Mountpoint)
-		comment := fmt.Sprintf("CSI Volume: %s | Capacity: %d", volumeName, requestedCapacity)
-		newShare, createErr := s.apiClient.CreateSMBShare(ctx, tnsapi.SMBShareCreateParams{
-			Name:    volumeName,
-			Path:    dataset.Mountpoint,
-			Comment: comment,
-			Enabled: true,
-		})
-		if createErr != nil {
-			timer.ObserveError()
-			return nil, status.Errorf(codes.Internal, "Failed to create SMB share for adopted volume: %v", createErr)
-		}
-		smbShare = newShare
-	}
-
-	deleteStrategy := params["deleteStrategy"]
-	if deleteStrategy == "" {
-		deleteStrategy = tnsapi.DeleteStrategyDelete
-	}
-	markAdoptable := params["markAdoptable"] == VolumeContextValueTrue
-
-	props := tnsapi.SMBVolumePropertiesV1(tnsapi.SMBVolumeParams{
-		VolumeID:       volumeName,
-		CapacityBytes:  requestedCapacity,
-		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
-		DeleteStrategy: deleteStrategy,
-		ShareID:        smbShare.ID,
-		ShareName:      smbShare.Name,
-		PVCName:        params["csi.storage.k8s.io/pvc/name"],
-		PVCNamespace:   params["csi.storage.k8s.io/pvc/namespace"],
-		StorageClass:   params["csi.storage.k8s.io/sc/name"],
-		Adoptable:      markAdoptable,
-		ClusterID:      s.clusterID,
-	})
-	if propErr := s.apiClient.SetDatasetProperties(ctx, dataset.ID, props); propErr != nil {
-		klog.Warningf("Failed to update ZFS properties on adopted volume %s: %v", dataset.ID, propErr)
+		klog.Infof("Found existing SMB share for adopted volume: ID=%d, name=%s", existingShares[0].ID, existingShares[0].Name)
+		return smbExport(&dataset.Dataset, params, &existingShares[0]), nil
 	}
 
-	meta := VolumeMetadata{
-		Name:        volumeName,
-		Protocol:    ProtocolSMB,
-		DatasetID:   dataset.ID,
-		DatasetName: dataset.Name,
-		Server:      server,
-		SMBShareID:  smbShare.ID,
+	klog.Infof("Creating SMB share for adopted volume: %s", dataset.Mountpoint)
+	comment := volumeShareComment(params.volumeName, params.requestedCapacity,
+		params.pvcNamespace, params.pvcName, params.pvName, params.comment)
+	// Share options apply, but the adopted data keeps its ACL
+	shareAccess, err := parseSMBShareAccess(req.GetParameters())
+	if err != nil {
+		return nil, err
 	}
-
-	volumeContext := buildVolumeContext(meta)
-	volumeContext[VolumeContextKeyShare] = smbShare.Name
-
-	metrics.SetVolumeCapacity(volumeName, metrics.ProtocolSMB, requestedCapacity)
-
-	klog.Infof("Successfully adopted SMB volume: %s (shareID=%d)", volumeName, smbShare.ID)
-	timer.ObserveSuccess()
-
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      dataset.ID,
-			CapacityBytes: requestedCapacity,
-			VolumeContext: volumeContext,
-		},
-	}, nil
+	smbShare, err := s.apiClient.CreateSMBShare(ctx, shareAccess.shareCreateParams(params.volumeName, dataset.Mountpoint, comment, true, false))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to create SMB share for adopted volume: %v", err)
+	}
+	// A later attempt claimed the dataset: leave the volume to it
+	if !s.ownsSMBShare(ctx, dataset.ID, generation, smbShare) {
+		return nil, creationSupersededError(params.volumeName)
+	}
+	return smbExport(&dataset.Dataset, params, smbShare), nil
 }
 
-// expandSMBVolume expands an SMB volume by updating the dataset quota.
-func (s *ControllerService) expandSMBVolume(ctx context.Context, meta *VolumeMetadata, requiredBytes int64) (*csi.ControllerExpandVolumeResponse, error) {
+// Expand expands an SMB volume by updating the dataset quota.
+func (s smbController) Expand(ctx context.Context, meta *VolumeMetadata, requiredBytes int64) (*csi.ControllerExpandVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolSMB, "expand")
 	klog.V(4).Infof("Expanding SMB volume: %s (dataset: %s) to %d bytes", meta.Name, meta.DatasetName, requiredBytes)
 
@@ -731,8 +575,8 @@ func (s *ControllerService) expandSMBVolume(ctx context.Context, meta *VolumeMet
 	}, nil
 }
 
-// getSMBVolumeInfo retrieves volume information and health status for an SMB volume.
-func (s *ControllerService) getSMBVolumeInfo(ctx context.Context, meta *VolumeMetadata) (*csi.ControllerGetVolumeResponse, error) {
+// Describe retrieves volume information and health status for an SMB volume.
+func (s smbController) Describe(ctx context.Context, meta *VolumeMetadata) (*csi.ControllerGetVolumeResponse, error) {
 	klog.V(4).Infof("Getting SMB volume info: %s (dataset: %s, shareID: %d)", meta.Name, meta.DatasetName, meta.SMBShareID)
 
 	abnormal := false
@@ -0,0 +1,124 @@
+package driver
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SMB share access.
+//
+// By default SMB volumes are browsable shares whose ACL grants everyone@ full control, so any
+// authenticated user can write. StorageClass parameters restrict this:
+//   - smb.aclPreset: "open" (default), "restricted" (owner@/group@ only) or "readonly"
+//     (everyone@ may read, owner@/group@ write)
+//   - smb.adGroups: comma-separated groups granted access, optionally with a permission:
+//     "CORP\k8s-devs:modify,CORP\k8s-readers:read". Permissions are full, modify (default) or
+//     read. Names are resolved by TrueNAS, so Active Directory groups work once TrueNAS is
+//     joined to the domain. Setting groups makes "restricted" the default preset.
+//   - smb.browsable / smb.guestok: share visibility and guest access ("true"/"false")
+//
+// The ACL is applied once, when the volume is created.
+const (
+	SMBACLPresetParam = "smb.aclPreset"
+	SMBADGroupsParam  = "smb.adGroups"
+	SMBBrowsableParam = "smb.browsable"
+	SMBGuestOKParam   = "smb.guestok"
+
+	SMBACLPresetOpen       = "open"
+	SMBACLPresetRestricted = "restricted"
+	SMBACLPresetReadOnly   = "readonly"
+)
+
+// smbACLPresets maps presets to the NFSv4 ACL entries applied to the dataset.
+var smbACLPresets = map[string][]tnsapi.NFS4ACE{
+	SMBACLPresetOpen: tnsapi.OpenSMBACL,
+	SMBACLPresetRestricted: {
+		{Tag: tnsapi.ACETagOwner, Perms: tnsapi.ACLPermsFullControl},
+		{Tag: tnsapi.ACETagGroup, Perms: tnsapi.ACLPermsFullControl},
+	},
+	SMBACLPresetReadOnly: {
+		{Tag: tnsapi.ACETagOwner, Perms: tnsapi.ACLPermsFullControl},
+		{Tag: tnsapi.ACETagGroup, Perms: tnsapi.ACLPermsFullControl},
+		{Tag: tnsapi.ACETagEveryone, Perms: tnsapi.ACLPermsRead},
+	},
+}
+
+// smbGroupPerms maps smb.adGroups permissions to NFSv4 BASIC permission sets.
+var smbGroupPerms = map[string]string{
+	"full":   tnsapi.ACLPermsFullControl,
+	"modify": tnsapi.ACLPermsModify,
+	"read":   tnsapi.ACLPermsRead,
+}
+
+// smbShareAccess holds the share options and ACL of an SMB volume.
+type smbShareAccess struct {
+	browsable *bool
+	guestOK   *bool
+	acl       []tnsapi.NFS4ACE
+}
+
+// parseSMBShareAccess validates the smb.* access parameters.
+func parseSMBShareAccess(params map[string]string) (smbShareAccess, error) {
+	var access smbShareAccess
+	for param, target := range map[string]**bool{SMBBrowsableParam: &access.browsable, SMBGuestOKParam: &access.guestOK} {
+		value := strings.TrimSpace(params[param])
+		if value == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return smbShareAccess{}, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be true or false", param, value)
+		}
+		*target = &b
+	}
+
+	var groupACEs []tnsapi.NFS4ACE
+	for _, entry := range strings.Split(params[SMBADGroupsParam], ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, perm := entry, "modify"
+		if i := strings.LastIndex(entry, ":"); i >= 0 {
+			name, perm = strings.TrimSpace(entry[:i]), strings.ToLower(strings.TrimSpace(entry[i+1:]))
+		}
+		perms, ok := smbGroupPerms[perm]
+		if name == "" || !ok {
+			return smbShareAccess{}, status.Errorf(codes.InvalidArgument,
+				"invalid %s entry %q: expected GROUP or GROUP:PERM with PERM one of full, modify, read", SMBADGroupsParam, entry)
+		}
+		groupACEs = append(groupACEs, tnsapi.NFS4ACE{Tag: tnsapi.ACETagNamedGroup, Who: name, Perms: perms})
+	}
+
+	preset := strings.ToLower(strings.TrimSpace(params[SMBACLPresetParam]))
+	if preset == "" {
+		preset = SMBACLPresetOpen
+		if len(groupACEs) > 0 {
+			preset = SMBACLPresetRestricted
+		}
+	}
+	presetACEs, ok := smbACLPresets[preset]
+	if !ok {
+		return smbShareAccess{}, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be %q, %q or %q",
+			SMBACLPresetParam, params[SMBACLPresetParam], SMBACLPresetOpen, SMBACLPresetRestricted, SMBACLPresetReadOnly)
+	}
+	access.acl = append(append(make([]tnsapi.NFS4ACE, 0, len(presetACEs)+len(groupACEs)), presetACEs...), groupACEs...)
+	return access, nil
+}
+
+// shareCreateParams builds the share creation parameters for a dataset mountpoint.
+func (a smbShareAccess) shareCreateParams(name, path, comment string, enabled, readOnly bool) tnsapi.SMBShareCreateParams {
+	return tnsapi.SMBShareCreateParams{
+		Name:      name,
+		Path:      path,
+		Comment:   comment,
+		Enabled:   enabled,
+		ReadOnly:  readOnly,
+		Browsable: a.browsable,
+		GuestOK:   a.guestOK,
+	}
+}
@@ -0,0 +1,139 @@
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseSMBShareAccess(t *testing.T) {
+	tests := []struct {
+		params  map[string]string
+		name    string
+		want    []tnsapi.NFS4ACE
+		wantErr bool
+	}{
+		{name: "default", params: map[string]string{}, want: tnsapi.OpenSMBACL},
+		{
+			name:   "groups default to restricted",
+			params: map[string]string{SMBADGroupsParam: `CORP\k8s-devs, CORP\k8s-readers:read`},
+			want: []tnsapi.NFS4ACE{
+				{Tag: tnsapi.ACETagOwner, Perms: tnsapi.ACLPermsFullControl},
+				{Tag: tnsapi.ACETagGroup, Perms: tnsapi.ACLPermsFullControl},
+				{Tag: tnsapi.ACETagNamedGroup, Who: `CORP\k8s-devs`, Perms: tnsapi.ACLPermsModify},
+				{Tag: tnsapi.ACETagNamedGroup, Who: `CORP\k8s-readers`, Perms: tnsapi.ACLPermsRead},
+			},
+		},
+		{
+			name:   "readonly preset",
+			params: map[string]string{SMBACLPresetParam: "ReadOnly"},
+			want:   smbACLPresets[SMBACLPresetReadOnly],
+		},
+		{name: "unknown preset", params: map[string]string{SMBACLPresetParam: "private"}, wantErr: true},
+		{name: "unknown permission", params: map[string]string{SMBADGroupsParam: "devs:write"}, wantErr: true},
+		{name: "empty group name", params: map[string]string{SMBADGroupsParam: ":read"}, wantErr: true},
+		{name: "invalid browsable", params: map[string]string{SMBBrowsableParam: "hidden"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			access, err := parseSMBShareAccess(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSMBShareAccess() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(access.acl, tt.want) {
+				t.Errorf("acl = %+v, want %+v", access.acl, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateSMBVolumeShareAccess(t *testing.T) {
+	var shareParams tnsapi.SMBShareCreateParams
+	var acl []tnsapi.NFS4ACE
+	mockClient := &MockAPIClientForSnapshots{
+		QueryAllDatasetsFunc: func(_ context.Context, _ string) ([]tnsapi.Dataset, error) {
+			return nil, nil
+		},
+		CreateDatasetFunc: func(_ context.Context, params tnsapi.DatasetCreateParams) (*tnsapi.Dataset, error) {
+			return &tnsapi.Dataset{ID: params.Name, Name: params.Name, Type: "FILESYSTEM", Mountpoint: "/mnt/" + params.Name}, nil
+		},
+		CreateSMBShareFunc: func(_ context.Context, params tnsapi.SMBShareCreateParams) (*tnsapi.SMBShare, error) {
+			shareParams = params
+			return &tnsapi.SMBShare{ID: 3, Name: params.Name, Path: params.Path, Enabled: params.Enabled}, nil
+		},
+		SetFilesystemNFS4ACLFunc: func(_ context.Context, _ string, aces []tnsapi.NFS4ACE) error {
+			acl = aces
+			return nil
+		},
+	}
+	controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+
+	_, err := smbController{controller}.Provision(context.Background(), &csi.CreateVolumeRequest{
+		Name: "pvc-smb",
+		Parameters: map[string]string{
+			"pool":            "tank",
+			SMBADGroupsParam:  `CORP\k8s-devs:full`,
+			SMBBrowsableParam: "false",
+			SMBGuestOKParam:   "false",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Provision() error = %v", err)
+	}
+
+	if shareParams.Browsable == nil || *shareParams.Browsable || shareParams.GuestOK == nil || *shareParams.GuestOK {
+		t.Errorf("share browsable = %v, guestok = %v, want both false", shareParams.Browsable, shareParams.GuestOK)
+	}
+	if len(acl) != 3 || acl[2].Who != `CORP\k8s-devs` || acl[2].Perms != tnsapi.ACLPermsFullControl {
+		t.Errorf("acl = %+v, want owner@, group@ and CORP\\k8s-devs full control", acl)
+	}
+	for _, ace := range acl {
+		if ace.Tag == tnsapi.ACETagEveryone {
+			t.Errorf("restricted ACL grants everyone@ %s", ace.Perms)
+		}
+	}
+}
+
+func TestCreateSMBVolumeSuperseded(t *testing.T) {
+	var removed []int
+	mockClient := &MockAPIClientForSnapshots{
+		QueryAllDatasetsFunc: func(_ context.Context, _ string) ([]tnsapi.Dataset, error) {
+			return nil, nil
+		},
+		CreateDatasetFunc: func(_ context.Context, params tnsapi.DatasetCreateParams) (*tnsapi.Dataset, error) {
+			return &tnsapi.Dataset{ID: params.Name, Name: params.Name, Type: "FILESYSTEM", Mountpoint: "/mnt/" + params.Name}, nil
+		},
+		CreateSMBShareFunc: func(_ context.Context, params tnsapi.SMBShareCreateParams) (*tnsapi.SMBShare, error) {
+			return &tnsapi.SMBShare{ID: 3, Name: params.Name, Path: params.Path, Enabled: params.Enabled}, nil
+		},
+		// Another attempt claimed the dataset after this one
+		GetDatasetPropertiesFunc: func(_ context.Context, _ string, _ []string) (map[string]string, error) {
+			return map[string]string{tnsapi.PropertyCreateGeneration: "0123456789abcdef"}, nil
+		},
+		DeleteSMBShareFunc: func(_ context.Context, shareID int) error {
+			removed = append(removed, shareID)
+			return nil
+		},
+		DeleteDatasetFunc: func(_ context.Context, _ string) error {
+			t.Error("dataset must be left to the attempt that claimed it")
+			return nil
+		},
+	}
+	controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+
+	_, err := smbController{controller}.Provision(context.Background(), &csi.CreateVolumeRequest{
+		Name:       "pvc-smb",
+		Parameters: map[string]string{"pool": "tank"},
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("Provision() error = %v, want Aborted", err)
+	}
+	if len(removed) != 1 || removed[0] != 3 {
+		t.Errorf("removed shares = %v, want the duplicate share 3", removed)
+	}
+}
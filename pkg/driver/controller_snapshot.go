@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/fenio/tns-csi/pkg/metrics"
 	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -62,6 +64,12 @@ const (
 	// Slower than clone+promote but provides complete independence.
 	DetachedVolumesFromVolumesParam = "detachedVolumesFromVolumes"
 
+	// RestoreParentDatasetParam is the StorageClass parameter for the parent dataset of volumes
+	// restored from snapshots or cloned from volumes. It takes precedence over parentDataset and
+	// the parent inferred from the source. If it is on another pool than the source, the volume
+	// is created with send/receive instead of a clone, as ZFS clones cannot cross pools.
+	RestoreParentDatasetParam = "restoreParentDataset"
+
 	// VolumeSourceSnapshotPrefix is the prefix for temporary snapshots created during volume-to-volume
 	// cloning. Uses the same naming convention as democratic-csi for compatibility.
 	VolumeSourceSnapshotPrefix = "volume-source-for-volume-"
@@ -92,6 +100,9 @@ var (
 	ErrDetachedSnapshotFailed       = errors.New("detached snapshot creation failed")
 	ErrDetachedParentDatasetMissing = errors.New("detached snapshots parent dataset is required")
 	ErrDetachedSnapshotNotFound     = errors.New("detached snapshot not found")
+
+	// errVolumeProbeMatched stops the remaining protocol probes once one of them found the volume.
+	errVolumeProbeMatched = errors.New("volume found")
 )
 
 // SnapshotMetadata contains information needed to manage a snapshot.
@@ -179,7 +190,7 @@ func decodeCompactSnapshotID(snapshotID string) (*SnapshotMetadata, error) {
 	remainder := snapshotID[colonIdx+1:]
 
 	// Validate protocol
-	if protocol != ProtocolNFS && protocol != ProtocolNVMeOF && protocol != ProtocolISCSI && protocol != ProtocolSMB {
+	if controllerProtocolFor(nil, protocol) == nil {
 		return nil, fmt.Errorf("%w: %s", ErrInvalidProtocol, protocol)
 	}
 
@@ -217,7 +228,6 @@ func decodeCompactSnapshotID(snapshotID string) (*SnapshotMetadata, error) {
 // 2. Detached snapshots (detachedSnapshots=true): Full copy via zfs send/receive, survives source deletion.
 func (s *ControllerService) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
 	timer := metrics.NewVolumeOperationTimer("snapshot", "create")
-	klog.V(4).Infof("CreateSnapshot called with request: %+v", req)
 
 	// Validate request
 	if req.GetName() == "" {
@@ -230,9 +240,21 @@ func (s *ControllerService) CreateSnapshot(ctx context.Context, req *csi.CreateS
 		return nil, status.Error(codes.InvalidArgument, "Source volume ID is required")
 	}
 
+	release, err := s.snapshotLimit.acquire(ctx)
+	if err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+	defer release()
+
 	snapshotName := req.GetName()
 	sourceVolumeID := req.GetSourceVolumeId()
 
+	if _, ok := parseSubdirVolumeID(sourceVolumeID); ok {
+		timer.ObserveError()
+		return nil, status.Errorf(codes.InvalidArgument, "snapshots are not supported for %s volumes: %s", VolumeTypeSubdir, sourceVolumeID)
+	}
+
 	// With plain volume IDs (just the volume name), we need to look up the volume in TrueNAS.
 	// We need to find the dataset name and protocol for the source volume.
 	params := req.GetParameters()
@@ -251,6 +273,10 @@ func (s *ControllerService) CreateSnapshot(ctx context.Context, req *csi.CreateS
 	// Check if detached snapshots are requested
 	detached := params[DetachedSnapshotsParam] == VolumeContextValueTrue
 	detachedParentDataset := params[DetachedSnapshotsParentDatasetParam]
+	if detached && !s.features.Enabled(FeatureDetachedSnapshots) {
+		timer.ObserveError()
+		return nil, status.Errorf(codes.InvalidArgument, "%s is disabled on this driver (--feature-gates=%s=false)", DetachedSnapshotsParam, FeatureDetachedSnapshots)
+	}
 
 	// Try to find the volume's dataset using property-based lookup (preferred method)
 	var datasetName string
@@ -294,13 +320,7 @@ func (s *ControllerService) CreateSnapshot(ctx context.Context, req *csi.CreateS
 	var sourceCapacityBytes int64
 	dataset, getErr := s.apiClient.GetDatasetWithProperties(ctx, datasetName)
 	if getErr == nil && dataset != nil {
-		if capProp, ok := dataset.UserProperties[tnsapi.PropertyCapacityBytes]; ok {
-			sourceCapacityBytes = tnsapi.StringToInt64(capProp.Value)
-		}
-		// Fallback: for ZVOLs, use volsize directly
-		if sourceCapacityBytes == 0 {
-			sourceCapacityBytes = getZvolCapacity(&dataset.Dataset)
-		}
+		sourceCapacityBytes = datasetCapacityBytes(dataset)
 	}
 
 	// Route to appropriate snapshot creation method
@@ -342,7 +362,7 @@ func (s *ControllerService) createRegularSnapshot(ctx context.Context, timer *me
 				// Snapshot exists on the same dataset - this is idempotent, return existing
 				klog.Infof("Snapshot %s already exists on dataset %s (idempotent)", snapshotName, datasetName)
 
-				createdAt := time.Now().Unix() // Use current time as we don't have creation time from API
+				createdAt := snapshotCreatedAt(&snapshot)
 				snapshotMeta := SnapshotMetadata{
 					SnapshotName: snapshot.ID,
 					SourceVolume: sourceVolumeID,
@@ -365,7 +385,7 @@ func (s *ControllerService) createRegularSnapshot(ctx context.Context, timer *me
 						SourceVolumeId: sourceVolumeID,
 						CreationTime:   timestamppb.New(time.Unix(createdAt, 0)),
 						ReadyToUse:     true, // ZFS snapshots are immediately available
-						SizeBytes:      sizeBytes,
+						SizeBytes:      snapshotSizeBytes(&snapshot, sizeBytes),
 					},
 				}, nil
 			}
@@ -394,16 +414,9 @@ func (s *ControllerService) createRegularSnapshot(ctx context.Context, timer *me
 	klog.Infof("Successfully created snapshot: %s", snapshot.ID)
 
 	// Step 4: Set CSI metadata properties on the snapshot
-	props := map[string]string{
-		tnsapi.PropertyManagedBy:        tnsapi.ManagedByValue,
-		tnsapi.PropertySnapshotID:       snapshotName,
-		tnsapi.PropertySourceVolumeID:   sourceVolumeID,
-		tnsapi.PropertyDetachedSnapshot: VolumeContextValueFalse,
-		tnsapi.PropertyProtocol:         protocol,
-		tnsapi.PropertyDeleteStrategy:   verbDelete,
-	}
-	if s.clusterID != "" {
-		props[tnsapi.PropertyClusterID] = s.clusterID
+	props := s.regularSnapshotProperties(snapshotName, sourceVolumeID, protocol)
+	if checksum := snapshotChecksum(snapshot); checksum != "" {
+		props[tnsapi.PropertySnapshotChecksum] = checksum
 	}
 	if err := s.apiClient.SetSnapshotProperties(ctx, snapshot.ID, props, nil); err != nil {
 		// Fatal: without snapshot_id the deletion guard cannot identify this as a CSI snapshot,
@@ -417,7 +430,7 @@ func (s *ControllerService) createRegularSnapshot(ctx context.Context, timer *me
 	}
 
 	// Create snapshot metadata
-	createdAt := time.Now().Unix()
+	createdAt := snapshotCreatedAt(snapshot)
 	snapshotMeta := SnapshotMetadata{
 		SnapshotName: snapshot.ID,
 		SourceVolume: sourceVolumeID,
@@ -440,21 +453,43 @@ func (s *ControllerService) createRegularSnapshot(ctx context.Context, timer *me
 			SourceVolumeId: sourceVolumeID,
 			CreationTime:   timestamppb.New(time.Unix(createdAt, 0)),
 			ReadyToUse:     true, // ZFS snapshots are immediately available
-			SizeBytes:      sizeBytes,
+			SizeBytes:      snapshotSizeBytes(snapshot, sizeBytes),
 		},
 	}, nil
 }
 
+// regularSnapshotProperties returns the CSI metadata properties of a COW snapshot.
+func (s *ControllerService) regularSnapshotProperties(snapshotName, sourceVolumeID, protocol string) map[string]string {
+	props := map[string]string{
+		tnsapi.PropertyManagedBy:        tnsapi.ManagedByValue,
+		tnsapi.PropertySnapshotID:       snapshotName,
+		tnsapi.PropertySourceVolumeID:   sourceVolumeID,
+		tnsapi.PropertyDetachedSnapshot: VolumeContextValueFalse,
+		tnsapi.PropertyProtocol:         protocol,
+		tnsapi.PropertyDeleteStrategy:   verbDelete,
+	}
+	if s.clusterID != "" {
+		props[tnsapi.PropertyClusterID] = s.clusterID
+	}
+	return props
+}
+
 // DeleteSnapshot deletes a snapshot.
 func (s *ControllerService) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
 	timer := metrics.NewVolumeOperationTimer("snapshot", verbDelete)
-	klog.V(4).Infof("DeleteSnapshot called with request: %+v", req)
 
 	if req.GetSnapshotId() == "" {
 		timer.ObserveError()
 		return nil, status.Error(codes.InvalidArgument, "Snapshot ID is required")
 	}
 
+	release, err := s.snapshotLimit.acquire(ctx)
+	if err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+	defer release()
+
 	snapshotID := req.GetSnapshotId()
 	klog.Infof("Deleting snapshot %s", snapshotID)
 
@@ -489,6 +524,20 @@ func (s *ControllerService) deleteRegularSnapshot(ctx context.Context, timer *me
 		return &csi.DeleteSnapshotResponse{}, nil
 	}
 
+	if s.protectSnapshotClones {
+		clones, cloneErr := s.snapshotDependentClones(ctx, zfsSnapshotName)
+		if cloneErr != nil {
+			timer.ObserveError()
+			return nil, status.Errorf(codes.Internal, "Failed to check clones of snapshot %s: %v", zfsSnapshotName, cloneErr)
+		}
+		if len(clones) > 0 {
+			timer.ObserveError()
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"snapshot %s has dependent clones: %s (delete them first, or disable --protect-snapshot-clones to defer deletion)",
+				zfsSnapshotName, strings.Join(clones, ", "))
+		}
+	}
+
 	klog.Infof("Deleting ZFS snapshot: %s", zfsSnapshotName)
 
 	// Delete snapshot using TrueNAS API
@@ -547,13 +596,68 @@ func (s *ControllerService) resolveZFSSnapshotName(ctx context.Context, meta *Sn
 	return "", fmt.Errorf("%w: snapshot %s for volume %s", ErrSnapshotNotFoundTrueNAS, snapshotName, volumeID)
 }
 
+// snapshotCreatedAt returns the snapshot's ZFS creation time as Unix seconds.
+// Falls back to the current time when TrueNAS did not report it.
+func snapshotCreatedAt(snapshot *tnsapi.Snapshot) int64 {
+	if created := snapshot.CreationTime(); !created.IsZero() {
+		return created.Unix()
+	}
+	return time.Now().Unix()
+}
+
+// snapshotDependentClones returns the managed volumes cloned copy-on-write from a ZFS snapshot.
+// Promoted and detached clones do not depend on their source snapshot and are not included.
+func (s *ControllerService) snapshotDependentClones(ctx context.Context, zfsSnapshotName string) ([]string, error) {
+	pool, _, _ := strings.Cut(zfsSnapshotName, "/")
+	datasets, err := s.apiClient.FindDatasetsByProperty(ctx, pool+"/", tnsapi.PropertyOriginSnapshot, zfsSnapshotName)
+	if err != nil {
+		return nil, err
+	}
+	var clones []string
+	for i := range datasets {
+		if prop, ok := datasets[i].UserProperties[tnsapi.PropertyOriginSnapshot]; !ok || prop.Value != zfsSnapshotName {
+			continue
+		}
+		if prop, ok := datasets[i].UserProperties[tnsapi.PropertyCloneMode]; ok && prop.Value != tnsapi.CloneModeCOW {
+			continue
+		}
+		name := datasets[i].ID
+		if prop, ok := datasets[i].UserProperties[tnsapi.PropertyCSIVolumeName]; ok && prop.Value != "" {
+			name = prop.Value
+		}
+		clones = append(clones, name)
+	}
+	return clones, nil
+}
+
+// datasetCapacityBytes returns the provisioned capacity of a volume dataset: the capacity
+// recorded when it was created, or the zvol volsize. Returns 0 if unknown.
+func datasetCapacityBytes(dataset *tnsapi.DatasetWithProperties) int64 {
+	if capProp, ok := dataset.UserProperties[tnsapi.PropertyCapacityBytes]; ok {
+		if capacity := tnsapi.StringToInt64(capProp.Value); capacity > 0 {
+			return capacity
+		}
+	}
+	return getZvolCapacity(&dataset.Dataset)
+}
+
+// snapshotSizeBytes returns the restore size reported for a snapshot.
+// The source volume capacity wins so a restore always fits a PVC of the original size;
+// the snapshot's own volsize/referenced properties cover volumes without a recorded capacity.
+func snapshotSizeBytes(snapshot *tnsapi.Snapshot, sourceCapacityBytes int64) int64 {
+	if sourceCapacityBytes > 0 {
+		return sourceCapacityBytes
+	}
+	return snapshot.RestoreSizeBytes()
+}
+
 // volumeDiscoveryResult holds the result of searching for a volume across protocols.
 type volumeDiscoveryResult struct {
 	datasetName string
 	protocol    string
 }
 
-// discoverVolumeBySearching searches for a volume by querying NFS shares, NVMe-oF namespaces, and iSCSI extents.
+// discoverVolumeBySearching searches for a volume by querying NFS shares, SMB shares, NVMe-oF namespaces and iSCSI extents.
 // This is used as a fallback when the parent dataset is not specified.
 func (s *ControllerService) discoverVolumeBySearching(ctx context.Context, volumeID string) *volumeDiscoveryResult {
 	// Use property-based lookup first (handles both new and legacy volume IDs)
@@ -562,58 +666,108 @@ func (s *ControllerService) discoverVolumeBySearching(ctx context.Context, volum
 		return &volumeDiscoveryResult{datasetName: meta.DatasetName, protocol: meta.Protocol}
 	}
 
-	// Fallback for unmigrated volumes: search NFS shares, SMB shares, NVMe-oF namespaces, iSCSI extents
+	// Fallback for unmigrated volumes: probe all protocols concurrently.
+	// The first match wins and cancels the remaining queries.
+	probes := []func(context.Context, string) *volumeDiscoveryResult{
+		s.findVolumeByNFSShare,
+		s.findVolumeBySMBShare,
+		s.findVolumeByNVMeOFNamespace,
+		s.findVolumeByISCSIExtent,
+	}
+
+	var (
+		found   *volumeDiscoveryResult
+		foundMu sync.Mutex
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	for _, probe := range probes {
+		g.Go(func() error {
+			result := probe(gctx, volumeID)
+			if result == nil {
+				return nil
+			}
+			foundMu.Lock()
+			defer foundMu.Unlock()
+			if found == nil {
+				found = result
+			}
+			return errVolumeProbeMatched
+		})
+	}
+	// Probes swallow query errors, so the only possible error is the match sentinel
+	_ = g.Wait()
+
+	return found
+}
+
+// findVolumeByNFSShare looks for an NFS share whose path ends with the volume ID.
+func (s *ControllerService) findVolumeByNFSShare(ctx context.Context, volumeID string) *volumeDiscoveryResult {
 	shares, err := s.apiClient.QueryAllNFSShares(ctx, volumeID)
-	if err == nil && len(shares) > 0 {
-		for _, share := range shares {
-			if strings.HasSuffix(share.Path, "/"+volumeID) {
-				datasetID := mountpointToDatasetID(share.Path)
-				datasets, dsErr := s.apiClient.QueryAllDatasets(ctx, datasetID)
-				if dsErr == nil && len(datasets) > 0 {
-					return &volumeDiscoveryResult{datasetName: datasets[0].Name, protocol: ProtocolNFS}
-				}
+	if err != nil {
+		return nil
+	}
+	for _, share := range shares {
+		if strings.HasSuffix(share.Path, "/"+volumeID) {
+			datasetID := mountpointToDatasetID(share.Path)
+			datasets, dsErr := s.apiClient.QueryAllDatasets(ctx, datasetID)
+			if dsErr == nil && len(datasets) > 0 {
+				return &volumeDiscoveryResult{datasetName: datasets[0].Name, protocol: ProtocolNFS}
 			}
 		}
 	}
+	return nil
+}
 
-	smbShares, err := s.apiClient.QueryAllSMBShares(ctx, volumeID)
-	if err == nil && len(smbShares) > 0 {
-		for _, share := range smbShares {
-			if strings.HasSuffix(share.Path, "/"+volumeID) {
-				datasetID := mountpointToDatasetID(share.Path)
-				datasets, dsErr := s.apiClient.QueryAllDatasets(ctx, datasetID)
-				if dsErr == nil && len(datasets) > 0 {
-					return &volumeDiscoveryResult{datasetName: datasets[0].Name, protocol: ProtocolSMB}
-				}
+// findVolumeBySMBShare looks for an SMB share whose path ends with the volume ID.
+func (s *ControllerService) findVolumeBySMBShare(ctx context.Context, volumeID string) *volumeDiscoveryResult {
+	shares, err := s.apiClient.QueryAllSMBShares(ctx, volumeID)
+	if err != nil {
+		return nil
+	}
+	for _, share := range shares {
+		if strings.HasSuffix(share.Path, "/"+volumeID) {
+			datasetID := mountpointToDatasetID(share.Path)
+			datasets, dsErr := s.apiClient.QueryAllDatasets(ctx, datasetID)
+			if dsErr == nil && len(datasets) > 0 {
+				return &volumeDiscoveryResult{datasetName: datasets[0].Name, protocol: ProtocolSMB}
 			}
 		}
 	}
+	return nil
+}
 
+// findVolumeByNVMeOFNamespace looks for an NVMe-oF namespace backed by a ZVOL named after the volume ID.
+func (s *ControllerService) findVolumeByNVMeOFNamespace(ctx context.Context, volumeID string) *volumeDiscoveryResult {
 	namespaces, err := s.apiClient.QueryAllNVMeOFNamespaces(ctx)
-	if err == nil {
-		for _, ns := range namespaces {
-			devicePath := ns.GetDevice()
-			if strings.Contains(devicePath, volumeID) {
-				return &volumeDiscoveryResult{
-					datasetName: strings.TrimPrefix(devicePath, "zvol/"),
-					protocol:    ProtocolNVMeOF,
-				}
+	if err != nil {
+		return nil
+	}
+	for _, ns := range namespaces {
+		devicePath := ns.GetDevice()
+		if strings.Contains(devicePath, volumeID) {
+			return &volumeDiscoveryResult{
+				datasetName: strings.TrimPrefix(devicePath, "zvol/"),
+				protocol:    ProtocolNVMeOF,
 			}
 		}
 	}
+	return nil
+}
 
+// findVolumeByISCSIExtent looks for an iSCSI extent backed by a ZVOL named after the volume ID.
+func (s *ControllerService) findVolumeByISCSIExtent(ctx context.Context, volumeID string) *volumeDiscoveryResult {
 	extents, err := s.apiClient.QueryISCSIExtents(ctx, nil)
-	if err == nil {
-		for _, extent := range extents {
-			if strings.Contains(extent.Disk, volumeID) {
-				return &volumeDiscoveryResult{
-					datasetName: strings.TrimPrefix(extent.Disk, "zvol/"),
-					protocol:    ProtocolISCSI,
-				}
+	if err != nil {
+		return nil
+	}
+	for _, extent := range extents {
+		if strings.Contains(extent.Disk, volumeID) {
+			return &volumeDiscoveryResult{
+				datasetName: strings.TrimPrefix(extent.Disk, "zvol/"),
+				protocol:    ProtocolISCSI,
 			}
 		}
 	}
-
 	return nil
 }
 
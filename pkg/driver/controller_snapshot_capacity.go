@@ -0,0 +1,67 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Capacity check before restores.
+//
+// A COW clone takes no space until it is written to, and a send/receive copy writes the
+// snapshot's data once more, but either way a restored volume can grow to everything the
+// snapshot references without the pool having agreed to it. Restoring a multi-TB snapshot onto
+// a nearly full pool would only postpone ENOSPC to the first writes, and take every other volume
+// of the pool down with it. So before cloning, the snapshot's referenced size is compared with
+// the free space of the target pool, and the restore fails with ResourceExhausted when it does
+// not fit. If either size cannot be read the restore goes ahead.
+
+// checkRestoreCapacity fails with ResourceExhausted when the pool a snapshot is restored to has
+// less free space than the snapshot references.
+func (s *ControllerService) checkRestoreCapacity(ctx context.Context, snapshotMeta *SnapshotMetadata, params *cloneParameters) error {
+	needed := s.snapshotReferencedBytes(ctx, snapshotMeta)
+	if needed <= 0 {
+		return nil
+	}
+	targetPool, _, _ := strings.Cut(params.parentDataset, "/")
+	pool, err := s.apiClient.QueryPool(ctx, targetPool)
+	if err != nil {
+		klog.Warningf("Cannot check free space of pool %s before restoring %s: %v", targetPool, snapshotMeta.SnapshotName, err)
+		return nil
+	}
+	if free := pool.Properties.Free.Parsed; needed > free {
+		return status.Errorf(codes.ResourceExhausted, "cannot restore snapshot %s: it references %s but pool %s has only %s free",
+			snapshotMeta.SnapshotName, formatGiB(needed), targetPool, formatGiB(free))
+	}
+	return nil
+}
+
+// snapshotReferencedBytes returns the data a snapshot references: the referenced size of the
+// ZFS snapshot, or of the dataset holding a detached snapshot. Returns 0 if it cannot be read.
+func (s *ControllerService) snapshotReferencedBytes(ctx context.Context, snapshotMeta *SnapshotMetadata) int64 {
+	if snapshotMeta.Detached {
+		dataset, err := s.apiClient.Dataset(ctx, snapshotMeta.DatasetName)
+		if err != nil {
+			klog.Warningf("Cannot read size of detached snapshot %s: %v", snapshotMeta.DatasetName, err)
+			return 0
+		}
+		return dataset.ReferencedBytes()
+	}
+	snapshots, err := s.apiClient.QuerySnapshotsWithProperties(ctx, []interface{}{
+		[]interface{}{"id", "=", snapshotMeta.SnapshotName},
+	})
+	if err != nil || len(snapshots) == 0 {
+		klog.Warningf("Cannot read size of snapshot %s: %v", snapshotMeta.SnapshotName, err)
+		return 0
+	}
+	return snapshots[0].ReferencedBytes()
+}
+
+// formatGiB formats a size in bytes as GiB with one decimal.
+func formatGiB(size int64) string {
+	return fmt.Sprintf("%.1f GiB", float64(size)/(1<<30))
+}
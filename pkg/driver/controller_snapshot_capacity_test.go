@@ -0,0 +1,91 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckRestoreCapacity(t *testing.T) {
+	const gib = int64(1 << 30)
+	referenced := func(size int64) map[string]interface{} {
+		return map[string]interface{}{"referenced": map[string]interface{}{"rawvalue": strconv.FormatInt(size, 10)}}
+	}
+	tests := []struct {
+		meta      *SnapshotMetadata
+		snapshots []tnsapi.Snapshot
+		dataset   *tnsapi.Dataset
+		poolErr   error
+		name      string
+		free      int64
+		wantCode  codes.Code
+	}{
+		{
+			name:      "fits",
+			meta:      &SnapshotMetadata{SnapshotName: "tank/csi/pvc-src@snap", DatasetName: "tank/csi/pvc-src"},
+			snapshots: []tnsapi.Snapshot{{ID: "tank/csi/pvc-src@snap", Properties: referenced(100 * gib)}},
+			free:      200 * gib,
+		},
+		{
+			name:      "snapshot larger than free space",
+			meta:      &SnapshotMetadata{SnapshotName: "tank/csi/pvc-src@snap", DatasetName: "tank/csi/pvc-src"},
+			snapshots: []tnsapi.Snapshot{{ID: "tank/csi/pvc-src@snap", Properties: referenced(2048 * gib)}},
+			free:      500 * gib,
+			wantCode:  codes.ResourceExhausted,
+		},
+		{
+			name:     "detached snapshot larger than free space",
+			meta:     &SnapshotMetadata{SnapshotName: "snap", DatasetName: "tank/csi-detached-snapshots/snap", Detached: true},
+			dataset:  &tnsapi.Dataset{ID: "tank/csi-detached-snapshots/snap", Referenced: map[string]interface{}{"parsed": float64(10 * gib)}},
+			free:     gib,
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name: "unknown snapshot size",
+			meta: &SnapshotMetadata{SnapshotName: "tank/csi/pvc-src@snap", DatasetName: "tank/csi/pvc-src"},
+			free: 0,
+		},
+		{
+			name:      "pool not queryable",
+			meta:      &SnapshotMetadata{SnapshotName: "tank/csi/pvc-src@snap", DatasetName: "tank/csi/pvc-src"},
+			snapshots: []tnsapi.Snapshot{{ID: "tank/csi/pvc-src@snap", Properties: referenced(100 * gib)}},
+			poolErr:   errors.New("connection lost"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queriedPool string
+			mockClient := &MockAPIClientForSnapshots{
+				QuerySnapshotsWithPropertiesFunc: func(_ context.Context, _ []interface{}) ([]tnsapi.Snapshot, error) {
+					return tt.snapshots, nil
+				},
+				GetDatasetFunc: func(_ context.Context, _ string) (*tnsapi.Dataset, error) {
+					return tt.dataset, nil
+				},
+				QueryPoolFunc: func(_ context.Context, name string) (*tnsapi.Pool, error) {
+					queriedPool = name
+					if tt.poolErr != nil {
+						return nil, tt.poolErr
+					}
+					pool := &tnsapi.Pool{Name: name}
+					pool.Properties.Free.Parsed = tt.free
+					return pool, nil
+				},
+			}
+			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+
+			err := controller.checkRestoreCapacity(context.Background(), tt.meta, &cloneParameters{parentDataset: "fast/restores"})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("checkRestoreCapacity() error = %v, want %v", err, tt.wantCode)
+			}
+			if queriedPool != "" && queriedPool != "fast" {
+				t.Errorf("queried pool %q, want the target pool fast", queriedPool)
+			}
+		})
+	}
+}
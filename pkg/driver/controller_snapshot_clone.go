@@ -3,10 +3,11 @@ package driver
 import (
 	"context"
 	"fmt"
+	"maps"
 	"strings"
-	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/metrics"
 	"github.com/fenio/tns-csi/pkg/tnsapi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -20,6 +21,8 @@ type cloneParameters struct {
 	parentDataset     string
 	newVolumeName     string
 	newDatasetName    string
+	verify            *restoreChecksum // Set with verifyRestore
+	crossPool         bool             // parentDataset is on another pool than the snapshot
 }
 
 // cloneInfo holds metadata about how a clone was created.
@@ -36,7 +39,6 @@ type cloneInfo struct {
 // createVolumeFromSnapshot creates a new volume from a snapshot by cloning.
 func (s *ControllerService) createVolumeFromSnapshot(ctx context.Context, req *csi.CreateVolumeRequest, snapshotID string) (*csi.CreateVolumeResponse, error) {
 	klog.Infof("=== createVolumeFromSnapshot CALLED === Volume: %s, SnapshotID: %s", req.GetName(), snapshotID)
-	klog.V(4).Infof("Full request: %+v", req)
 
 	// Decode snapshot metadata
 	snapshotMeta, decodeErr := decodeSnapshotID(snapshotID)
@@ -61,12 +63,25 @@ func (s *ControllerService) createVolumeFromSnapshot(ctx context.Context, req *c
 		return nil, validateErr
 	}
 
+	// Fail fast rather than letting the restored volume run the pool full
+	if capacityErr := s.checkRestoreCapacity(ctx, snapshotMeta, cloneParams); capacityErr != nil {
+		return nil, capacityErr
+	}
+
 	// Get request parameters for later use
 	params := req.GetParameters()
 	if params == nil {
 		params = make(map[string]string)
 	}
 
+	if params[VerifyRestoreParam] == VolumeContextValueTrue {
+		checksum, err := s.restoreSourceChecksum(ctx, snapshotMeta)
+		if err != nil {
+			return nil, err
+		}
+		cloneParams.verify = checksum
+	}
+
 	// Determine clone mode from StorageClass parameters:
 	// - detachedVolumesFromSnapshots=true: Use send/receive for truly independent copy
 	// - promotedVolumesFromSnapshots=true: Use clone+promote (reversed dependency)
@@ -93,6 +108,8 @@ func (s *ControllerService) createVolumeFromSnapshot(ctx context.Context, req *c
 	// Clone modes (applies to both source types):
 	// 1. detachedVolumesFromSnapshots=true -> send/receive (truly independent, slow)
 	//    Note: Not supported for detached snapshot sources; falls back to COW
+	//    Also used whenever the target parent dataset is on another pool than the snapshot,
+	//    as ZFS clones cannot cross pools
 	// 2. promotedVolumesFromSnapshots=true -> clone+promote (reversed dependency)
 	// 3. default -> COW clone (clone depends on snapshot, can be deleted freely)
 
@@ -119,6 +136,10 @@ func (s *ControllerService) createVolumeFromSnapshot(ctx context.Context, req *c
 		}
 		// Only promote if explicitly requested via promotedVolumesFromSnapshots.
 		promoteDetachedRestore = promotedMode
+	case cloneParams.crossPool:
+		klog.Infof("Target parent dataset %s is on another pool than snapshot dataset %s; using send/receive",
+			cloneParams.parentDataset, snapshotMeta.DatasetName)
+		mode = cloneModeDetached
 	case detachedMode:
 		mode = cloneModeDetached
 	case promotedMode:
@@ -180,20 +201,14 @@ func (s *ControllerService) createVolumeFromSnapshot(ctx context.Context, req *c
 		cloneInfoData.OriginSnapshot = snapshotMeta.SnapshotName
 	}
 
-	// Wait for ZFS metadata sync for NVMe-oF volumes
-	s.waitForZFSSyncIfNVMeOF(snapshotMeta.Protocol)
-
-	// Get server and subsystemNQN parameters
-	server, subsystemNQN, err := s.getVolumeParametersForSnapshot(ctx, params, snapshotMeta, clonedDataset)
-	if err != nil {
-		klog.Errorf("Failed to get volume parameters for snapshot: %v", err)
-		return nil, err
+	// Send/receive copies were verified before their received snapshot was removed
+	if cloneParams.verify != nil && mode != cloneModeDetached {
+		if err := s.verifyRestoredClone(ctx, cloneParams.verify, clonedDataset.ID); err != nil {
+			return nil, s.failRestoreVerification(ctx, clonedDataset.ID, err)
+		}
 	}
-	klog.Infof("Got volume parameters: server=%s, subsystemNQN=%s, protocol=%s", server, subsystemNQN, snapshotMeta.Protocol)
 
-	// Route to protocol-specific volume setup
-	klog.Infof("Routing to protocol-specific setup: protocol=%s, cloneMode=%s", snapshotMeta.Protocol, cloneInfoData.Mode)
-	return s.setupVolumeFromClone(ctx, req, clonedDataset, snapshotMeta.Protocol, server, subsystemNQN, &cloneInfoData)
+	return s.provisionFromClone(ctx, req, clonedDataset, snapshotMeta.Protocol, &cloneInfoData)
 }
 
 // resolveSnapshotMetadata resolves missing metadata fields for compact format snapshots.
@@ -297,6 +312,18 @@ func (s *ControllerService) validateCloneParameters(req *csi.CreateVolumeRequest
 		}
 	}
 
+	// restoreParentDataset explicitly places restored volumes and takes precedence over
+	// parentDataset; the pool follows from it
+	if restoreParent := params[RestoreParentDatasetParam]; restoreParent != "" {
+		if strings.HasPrefix(restoreParent, "/") || strings.HasSuffix(restoreParent, "/") ||
+			strings.Contains(restoreParent, "//") || strings.ContainsAny(restoreParent, "@# ") {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be a dataset path such as pool/parent",
+				RestoreParentDatasetParam, restoreParent)
+		}
+		parentDataset = restoreParent
+		pool, _, _ = strings.Cut(restoreParent, "/")
+	}
+
 	// If parentDataset is not provided, infer from snapshot's dataset path or use pool
 	if parentDataset == "" {
 		// For detached snapshots, use pool directly since the snapshot is stored in a
@@ -321,6 +348,17 @@ func (s *ControllerService) validateCloneParameters(req *csi.CreateVolumeRequest
 		}
 	}
 
+	// ZFS clones must stay on the pool of their origin. A parent dataset on another pool is
+	// served by send/receive, which detached snapshots (already full copies) do not support.
+	sourcePool, _, _ := strings.Cut(snapshotMeta.DatasetName, "/")
+	targetPool, _, _ := strings.Cut(parentDataset, "/")
+	crossPool := targetPool != sourcePool
+	if crossPool && snapshotMeta.Detached {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"cannot restore detached snapshot dataset %s on pool %s into %s on pool %s: restores from detached snapshots must stay on the same pool",
+			snapshotMeta.DatasetName, sourcePool, parentDataset, targetPool)
+	}
+
 	newVolumeName := req.GetName()
 	newDatasetName := fmt.Sprintf("%s/%s", parentDataset, newVolumeName)
 
@@ -332,6 +370,7 @@ func (s *ControllerService) validateCloneParameters(req *csi.CreateVolumeRequest
 		parentDataset:  parentDataset,
 		newVolumeName:  newVolumeName,
 		newDatasetName: newDatasetName,
+		crossPool:      crossPool,
 	}
 
 	// SMB clones: Do NOT set explicit acltype/aclmode/aclinherit properties.
@@ -348,6 +387,9 @@ func (s *ControllerService) validateCloneParameters(req *csi.CreateVolumeRequest
 func (s *ControllerService) executeSnapshotClone(ctx context.Context, snapshotMeta *SnapshotMetadata, params *cloneParameters) (*tnsapi.Dataset, error) {
 	klog.Infof("Cloning snapshot %s to dataset %s", snapshotMeta.SnapshotName, params.newDatasetName)
 
+	release := s.holdSnapshot(ctx, snapshotMeta.SnapshotName)
+	defer release()
+
 	cloneParams := tnsapi.CloneSnapshotParams{
 		Snapshot:          snapshotMeta.SnapshotName,
 		Dataset:           params.newDatasetName,
@@ -386,7 +428,10 @@ func (s *ControllerService) executePromotedSnapshotClone(ctx context.Context, sn
 		DatasetProperties: params.datasetProperties,
 	}
 
+	// Released before promotion, which moves the snapshot to the clone
+	release := s.holdSnapshot(ctx, snapshotMeta.SnapshotName)
 	clonedDataset, err := s.apiClient.CloneSnapshot(ctx, cloneParams)
+	release()
 	if err != nil {
 		klog.Errorf("Failed to clone snapshot for promotion: %v", err)
 		s.cleanupPartialClone(ctx, params.newDatasetName)
@@ -431,6 +476,10 @@ func (s *ControllerService) executeDetachedVolumeClone(ctx context.Context, snap
 	klog.V(4).Infof("Running one-time replication from %s (snapshot: %s) to %s",
 		sourceDataset, snapshotNameOnly, params.newDatasetName)
 
+	// Replication can run for a long time; keep the source snapshot from being destroyed meanwhile
+	release := s.holdSnapshot(ctx, snapshotMeta.SnapshotName)
+	defer release()
+
 	replicationParams := tnsapi.ReplicationRunOnetimeParams{
 		Direction:               "PUSH",
 		Transport:               "LOCAL",
@@ -469,8 +518,13 @@ func (s *ControllerService) executeDetachedVolumeClone(ctx context.Context, snap
 		klog.V(4).Infof("Successfully promoted detached volume clone: %s", params.newDatasetName)
 	}
 
-	// Step 3: Clean up the replicated snapshot from the target dataset
+	// Step 3: Clean up the replicated snapshot from the target dataset, once verified
 	targetSnapshot := fmt.Sprintf("%s@%s", params.newDatasetName, snapshotNameOnly)
+	if params.verify != nil {
+		if err := s.verifyReceivedSnapshot(ctx, params.verify, targetSnapshot); err != nil {
+			return nil, s.failRestoreVerification(ctx, params.newDatasetName, err)
+		}
+	}
 	klog.V(4).Infof("Cleaning up replicated snapshot %s", targetSnapshot)
 	if delErr := s.apiClient.DeleteSnapshot(ctx, targetSnapshot); delErr != nil {
 		klog.Warningf("Failed to delete replicated snapshot %s: %v (non-fatal)", targetSnapshot, delErr)
@@ -538,6 +592,9 @@ func (s *ControllerService) executeDetachedSnapshotRestore(ctx context.Context,
 	// Step 2: Clone the snapshot to create the new volume
 	klog.V(4).Infof("Cloning snapshot %s to %s", tempSnapshotFullName, params.newDatasetName)
 
+	// Hold the temp snapshot until the clone exists: concurrent restores share and clean it up
+	release := s.holdSnapshot(ctx, tempSnapshotFullName)
+
 	cloneSnapshotParams := tnsapi.CloneSnapshotParams{
 		Snapshot:          tempSnapshotFullName,
 		Dataset:           params.newDatasetName,
@@ -545,6 +602,7 @@ func (s *ControllerService) executeDetachedSnapshotRestore(ctx context.Context,
 	}
 
 	clonedDataset, err := s.apiClient.CloneSnapshot(ctx, cloneSnapshotParams)
+	release()
 	if err != nil {
 		klog.Errorf("Failed to clone snapshot: %v", err)
 		// Don't delete the temp snapshot - it might be used by other restores
@@ -592,114 +650,80 @@ func (s *ControllerService) cleanupPartialClone(ctx context.Context, datasetName
 	}
 }
 
-// waitForZFSSyncIfNVMeOF waits for ZFS metadata to sync for NVMe-oF volumes.
-func (s *ControllerService) waitForZFSSyncIfNVMeOF(protocol string) {
-	if protocol != ProtocolNVMeOF {
-		return
+// provisionFromClone finishes a volume restored from a snapshot: it exports the cloned dataset
+// with the protocol of the snapshot's source and records the volume and its clone source in the
+// dataset's properties. The cloned dataset is deleted if it cannot be exported.
+func (s *ControllerService) provisionFromClone(ctx context.Context, req *csi.CreateVolumeRequest, clonedDataset *tnsapi.Dataset, protocol string, info *cloneInfo) (*csi.CreateVolumeResponse, error) {
+	p := controllerProtocolFor(s, protocol)
+	if p == nil {
+		s.cleanupPartialClone(ctx, clonedDataset.ID)
+		return nil, status.Errorf(codes.InvalidArgument, "Unknown protocol in snapshot: %s", protocol)
 	}
-	const zfsSyncDelay = 5 * time.Second
-	klog.Infof("Waiting %v for ZFS metadata to sync before creating NVMe-oF namespace", zfsSyncDelay)
-	time.Sleep(zfsSyncDelay)
-	klog.V(4).Infof("ZFS sync delay complete, proceeding with NVMe-oF namespace creation")
-}
-
-// setupVolumeFromClone routes to the appropriate protocol-specific volume setup.
-func (s *ControllerService) setupVolumeFromClone(ctx context.Context, req *csi.CreateVolumeRequest, clonedDataset *tnsapi.Dataset, protocol, server, subsystemNQN string, info *cloneInfo) (*csi.CreateVolumeResponse, error) {
-	switch protocol {
-	case ProtocolNFS:
-		return s.setupNFSVolumeFromClone(ctx, req, clonedDataset, server, info)
-	case ProtocolNVMeOF:
-		return s.setupNVMeOFVolumeFromCloneWithValidation(ctx, req, clonedDataset, server, subsystemNQN, info)
-	case ProtocolISCSI:
-		return s.setupISCSIVolumeFromClone(ctx, req, clonedDataset, server, info)
-	case ProtocolSMB:
-		return s.setupSMBVolumeFromClone(ctx, req, clonedDataset, server, info)
-	default:
-		return s.handleUnknownProtocol(ctx, clonedDataset, protocol)
-	}
-}
+	timer := metrics.NewVolumeOperationTimer(p.Name(), "clone")
+	klog.Infof("Setting up %s volume for cloned dataset: %s (cloneMode: %s)", p.Label(), clonedDataset.Name, info.Mode)
 
-// setupNVMeOFVolumeFromCloneWithValidation validates subsystemNQN and sets up NVMe-oF volume.
-func (s *ControllerService) setupNVMeOFVolumeFromCloneWithValidation(ctx context.Context, req *csi.CreateVolumeRequest, clonedDataset *tnsapi.Dataset, server, subsystemNQN string, info *cloneInfo) (*csi.CreateVolumeResponse, error) {
-	if subsystemNQN == "" {
-		klog.Errorf("subsystemNQN parameter is required for NVMe-oF volumes, cleaning up")
-		if delErr := s.apiClient.DeleteDataset(ctx, clonedDataset.ID); delErr != nil {
-			klog.Errorf("Failed to cleanup cloned dataset: %v", delErr)
-		}
-		return nil, status.Error(codes.InvalidArgument,
-			"subsystemNQN parameter is required for NVMe-oF volumes. "+
-				"Pre-configure an NVMe-oF subsystem in TrueNAS (Shares > NVMe-oF Subsystems) "+
-				"and provide its NQN in the StorageClass parameters.")
+	export, params, err := s.exportClone(ctx, p, req, clonedDataset)
+	if err != nil {
+		s.cleanupPartialClone(ctx, clonedDataset.ID)
+		timer.ObserveError()
+		return nil, err
 	}
-	return s.setupNVMeOFVolumeFromClone(ctx, req, clonedDataset, server, subsystemNQN, info)
-}
 
-// handleUnknownProtocol handles the case when protocol is not recognized.
-func (s *ControllerService) handleUnknownProtocol(ctx context.Context, clonedDataset *tnsapi.Dataset, protocol string) (*csi.CreateVolumeResponse, error) {
-	klog.Errorf("Unknown protocol %s in snapshot metadata, cleaning up", protocol)
-	if delErr := s.apiClient.DeleteDataset(ctx, clonedDataset.ID); delErr != nil {
-		klog.Errorf("Failed to cleanup cloned dataset: %v", delErr)
+	// Set the properties and the dataset comment from commentTemplate (if configured) in one
+	// update — CloneSnapshot doesn't support setting comments
+	props := s.volumeProperties(p.Name(), params, export.properties)
+	maps.Copy(props, tnsapi.ClonedVolumePropertiesV2(tnsapi.ContentSourceSnapshot, info.SnapshotID, info.Mode, info.OriginSnapshot))
+	batch := tnsapi.NewDatasetUpdateBatch(clonedDataset.ID).SetProperties(props)
+	if params.comment != "" {
+		batch.SetComment(params.comment)
 	}
-	return nil, status.Errorf(codes.InvalidArgument, "Unknown protocol in snapshot: %s", protocol)
+	if err := batch.Apply(ctx, s.apiClient); err != nil {
+		klog.Warningf("Failed to set ZFS user properties on cloned dataset %s: %v (volume will still work)", clonedDataset.ID, err)
+	} else {
+		klog.V(4).Infof("Stored ZFS user properties on cloned dataset %s: %v", clonedDataset.ID, props)
+	}
+
+	// CRITICAL: mark the volume as cloned from a snapshot so that the node never formats it.
+	// ZFS clones inherit filesystems from snapshots, but detection may fail due to caching.
+	export.context[VolumeContextKeyClonedFromSnap] = VolumeContextValueTrue
+	resp := export.response(params.requestedCapacity)
+	resp.Volume.ContentSource = &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{
+				SnapshotId: info.SnapshotID,
+			},
+		},
+	}
+	klog.Infof("Created %s volume from snapshot: %s", p.Label(), req.GetName())
+	timer.ObserveSuccess()
+	return resp, nil
 }
 
-// getVolumeParametersForSnapshot extracts server and subsystemNQN parameters
-// from either the request parameters (StorageClass) or the source volume metadata.
-func (s *ControllerService) getVolumeParametersForSnapshot(
-	ctx context.Context,
-	params map[string]string,
-	snapshotMeta *SnapshotMetadata,
-	clonedDataset *tnsapi.Dataset,
-) (server, subsystemNQN string, err error) {
-	// First try to get from request parameters (StorageClass)
-	server = params["server"]
-	subsystemNQN = params["subsystemNQN"]
-
-	// If not provided in parameters, extract from source volume metadata
-	needsSourceExtraction := server == "" || (snapshotMeta.Protocol == ProtocolNVMeOF && subsystemNQN == "")
-	if !needsSourceExtraction {
-		// All required parameters are available
-		return server, subsystemNQN, s.validateServerParameter(ctx, server, clonedDataset)
-	}
-
-	klog.V(4).Infof("Server or subsystemNQN not in parameters, will derive from context (source volume: %s)", snapshotMeta.SourceVolume)
-
-	// For NFS, server should be provided in StorageClass parameters
-	// For NVMe-oF, we can try to find the subsystem NQN from TrueNAS
-	if server == "" {
-		// Server must come from StorageClass - we can't discover it
-		klog.Errorf("Server parameter is required but not provided in StorageClass, cleaning up")
-		if delErr := s.apiClient.DeleteDataset(ctx, clonedDataset.ID); delErr != nil {
-			klog.Errorf("Failed to cleanup cloned dataset: %v", delErr)
-		}
-		return "", "", status.Error(codes.InvalidArgument,
+// exportClone resolves the shared parameters of a restored volume and exports its dataset.
+// Read-only restores are protected at the storage level before anything is exported, unless
+// the protocol needs a writable dataset to export it (readOnlyCloneExporter).
+func (s *ControllerService) exportClone(ctx context.Context, p controllerProtocol, req *csi.CreateVolumeRequest, clonedDataset *tnsapi.Dataset) (*volumeExport, *volumeParams, error) {
+	// The server cannot be derived from the snapshot
+	if req.GetParameters()["server"] == "" {
+		return nil, nil, status.Error(codes.InvalidArgument,
 			"server parameter is required in StorageClass for restoring from snapshot")
 	}
-
-	// For NVMe-oF with independent subsystems, we generate a new NQN for each clone.
-	// The source volume's NQN is not needed - the clone gets its own dedicated subsystem.
-	// We use a placeholder value to satisfy the validation; setupNVMeOFVolumeFromClone
-	// will generate the actual NQN based on the new volume name.
-	if subsystemNQN == "" && snapshotMeta.Protocol == ProtocolNVMeOF {
-		// For clone operations, we don't need the source volume's subsystemNQN.
-		// Each cloned volume gets its own independent subsystem with a newly generated NQN.
-		// This allows restoring from detached snapshots even after the source volume is deleted.
-		klog.V(4).Infof("NVMe-oF clone: will generate new subsystem NQN for cloned volume (source volume NQN not required)")
-		subsystemNQN = "clone-will-generate-new-nqn" // Placeholder to pass validation
+	server, err := p.Server(req.GetParameters())
+	if err != nil {
+		return nil, nil, err
 	}
-
-	return server, subsystemNQN, s.validateServerParameter(ctx, server, clonedDataset)
-}
-
-// validateServerParameter validates that the server parameter is not empty.
-func (s *ControllerService) validateServerParameter(ctx context.Context, server string, clonedDataset *tnsapi.Dataset) error {
-	if server == "" {
-		// Cleanup the cloned dataset
-		klog.Errorf("server parameter is required, cleaning up")
-		if delErr := s.apiClient.DeleteDataset(ctx, clonedDataset.ID); delErr != nil {
-			klog.Errorf("Failed to cleanup cloned dataset: %v", delErr)
+	if _, ok := p.(readOnlyCloneExporter); !ok && isReadOnlyContentSourceRequest(req) {
+		if err := s.setDatasetReadOnly(ctx, clonedDataset.ID, p.Name()); err != nil {
+			return nil, nil, err
 		}
-		return status.Error(codes.InvalidArgument, "server parameter is required")
 	}
-	return nil
+	params := s.existingVolumeParams(req, server)
+	export, err := p.ExportClone(ctx, req, clonedDataset, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	if export.context == nil {
+		export.context = make(map[string]string)
+	}
+	return export, params, nil
 }
@@ -299,24 +299,25 @@ func (s *ControllerService) deleteDetachedSnapshot(ctx context.Context, timer *m
 	klog.Infof("Deleting detached snapshot dataset: %s (snapshot: %s)", datasetPath, snapshotMeta.SnapshotName)
 
 	// Verify this is actually a detached snapshot by checking properties (if dataset exists)
-	props, err := s.apiClient.GetDatasetProperties(ctx, datasetPath, []string{tnsapi.PropertyDetachedSnapshot, tnsapi.PropertyManagedBy})
-	if err != nil {
+	props, err := s.apiClient.GetDatasetProperties(ctx, datasetPath,
+		[]string{tnsapi.PropertyDetachedSnapshot, tnsapi.PropertyManagedBy, tnsapi.PropertyClusterID})
+	switch {
+	case err != nil && isNotFoundError(err):
 		// If dataset doesn't exist, consider deletion successful (idempotent)
-		if isNotFoundError(err) {
-			klog.Infof("Detached snapshot dataset %s not found, assuming already deleted", datasetPath)
-			timer.ObserveSuccess()
-			return &csi.DeleteSnapshotResponse{}, nil
-		}
-		// Log warning but continue - we'll try to delete anyway
-		klog.Warningf("Failed to get properties for detached snapshot dataset %s: %v", datasetPath, err)
-	} else {
+		klog.Infof("Detached snapshot dataset %s not found, assuming already deleted", datasetPath)
+		timer.ObserveSuccess()
+		return &csi.DeleteSnapshotResponse{}, nil
+	case err != nil && s.allowUnmanagedDelete:
+		klog.Warningf("Cannot verify ownership of detached snapshot dataset %s, deleting anyway (--allow-unmanaged-delete): %v", datasetPath, err)
+	case err != nil:
+		timer.ObserveError()
+		return nil, status.Errorf(codes.Unavailable,
+			"cannot verify ownership of detached snapshot dataset %s: %v; will retry with backoff", datasetPath, err)
+	default:
 		// Verify it's a tns-csi managed detached snapshot
-		if props[tnsapi.PropertyManagedBy] != tnsapi.ManagedByValue {
-			klog.Warningf("Dataset %s is not managed by tns-csi (managed_by=%s), refusing to delete",
-				datasetPath, props[tnsapi.PropertyManagedBy])
+		if guardErr := s.guardDelete(datasetPath, unmanagedReason(props[tnsapi.PropertyManagedBy], props[tnsapi.PropertyClusterID], s.clusterID)); guardErr != nil {
 			timer.ObserveError()
-			return nil, status.Errorf(codes.FailedPrecondition,
-				"Dataset %s is not managed by tns-csi", datasetPath)
+			return nil, guardErr
 		}
 		if props[tnsapi.PropertyDetachedSnapshot] != VolumeContextValueTrue {
 			klog.Warningf("Dataset %s is not marked as a detached snapshot, refusing to delete", datasetPath)
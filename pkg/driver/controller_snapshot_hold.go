@@ -0,0 +1,68 @@
+package driver
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// Snapshot holds.
+//
+// While a clone or restore reads from a snapshot, the controller places a ZFS user hold on it
+// so that a concurrent DeleteSnapshot or a TrueNAS periodic snapshot retention task cannot
+// destroy it mid-operation (a deferred delete waits for the release). TrueNAS uses a single
+// hold tag, so concurrent operations on the same snapshot share one hold, reference-counted
+// here. Holds are best effort: a failure to hold is logged and the operation proceeds.
+
+// snapshotHoldTracker reference-counts the holds this controller placed.
+type snapshotHoldTracker struct {
+	counts map[string]int
+	mu     sync.Mutex
+}
+
+// holdSnapshot holds a ZFS snapshot for the duration of a clone or restore.
+// The returned function releases it and is safe to call more than once.
+func (s *ControllerService) holdSnapshot(ctx context.Context, snapshotName string) (release func()) {
+	h := &s.snapshotHolds
+	h.mu.Lock()
+	if h.counts[snapshotName] == 0 {
+		if err := s.apiClient.HoldSnapshot(ctx, snapshotName); err != nil {
+			h.mu.Unlock()
+			klog.Warningf("Failed to hold snapshot %s: %v (continuing without hold)", snapshotName, err)
+			return func() {}
+		}
+		klog.V(4).Infof("Placed hold on snapshot %s", snapshotName)
+	}
+	if h.counts == nil {
+		h.counts = make(map[string]int)
+	}
+	h.counts[snapshotName]++
+	h.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { s.releaseSnapshot(snapshotName) })
+	}
+}
+
+// releaseSnapshot drops one reference to a held snapshot, releasing the hold with the last one.
+func (s *ControllerService) releaseSnapshot(snapshotName string) {
+	h := &s.snapshotHolds
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.counts[snapshotName]--
+	if h.counts[snapshotName] > 0 {
+		return
+	}
+	delete(h.counts, snapshotName)
+
+	// Background context: the release must happen even if the CSI call was canceled
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeouts.provisioning())
+	defer cancel()
+	// Not found: the snapshot moved (promotion) or is already gone, so there is nothing to release
+	if err := s.apiClient.ReleaseSnapshot(ctx, snapshotName); err != nil && !isNotFoundError(err) {
+		klog.Warningf("Failed to release hold on snapshot %s: %v", snapshotName, err)
+	}
+}
@@ -31,8 +31,6 @@ func parseSnapshotToken(token string) (int, error) {
 
 // ListSnapshots lists snapshots.
 func (s *ControllerService) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	klog.V(4).Infof("ListSnapshots called with request: %+v", req)
-
 	// Special case: If filtering by snapshot ID, we can decode it and return directly if it exists
 	if req.GetSnapshotId() != "" {
 		return s.listSnapshotByID(ctx, req)
@@ -51,8 +49,6 @@ func (s *ControllerService) ListSnapshots(ctx context.Context, req *csi.ListSnap
 // This is a CSI 1.12+ capability that provides a more efficient way to get a single snapshot
 // compared to ListSnapshots with a snapshot_id filter.
 func (s *ControllerService) ControllerGetSnapshot(ctx context.Context, req *csi.GetSnapshotRequest) (*csi.GetSnapshotResponse, error) {
-	klog.V(4).Infof("ControllerGetSnapshot called with request: %+v", req)
-
 	snapshotID := req.GetSnapshotId()
 	if snapshotID == "" {
 		return nil, status.Error(codes.InvalidArgument, "Snapshot ID is required")
@@ -123,30 +119,22 @@ func (s *ControllerService) listSnapshotByID(ctx context.Context, req *csi.ListS
 		}, nil
 	}
 
-	// Query source volume capacity for SizeBytes
-	var sizeBytes int64
-	sourceVolumeID := snapshotMeta.SourceVolume
-	if isDatasetPathVolumeID(sourceVolumeID) {
-		ds, dsErr := s.apiClient.GetDatasetWithProperties(ctx, sourceVolumeID)
-		if dsErr == nil && ds != nil {
-			if capProp, ok := ds.UserProperties[tnsapi.PropertyCapacityBytes]; ok {
-				sizeBytes = tnsapi.StringToInt64(capProp.Value)
-			}
-			if sizeBytes == 0 {
-				sizeBytes = getZvolCapacity(&ds.Dataset)
-			}
-		}
+	// Query source volume capacity for SizeBytes (the snapshot's parent dataset is the source volume)
+	var sourceCapacityBytes int64
+	if ds, dsErr := s.apiClient.GetDatasetWithProperties(ctx, snapshots[0].Dataset); dsErr == nil && ds != nil {
+		sourceCapacityBytes = datasetCapacityBytes(ds)
 	}
 
 	// Snapshot exists - return it with the metadata we decoded
-	// (which includes protocol, source volume, etc.)
+	// (which includes protocol, source volume, etc.) and its ZFS creation time
+	snapshotMeta.CreatedAt = snapshotCreatedAt(&snapshots[0])
 	entry := &csi.ListSnapshotsResponse_Entry{
 		Snapshot: &csi.Snapshot{
 			SnapshotId:     req.GetSnapshotId(), // Return the same ID we were queried with
 			SourceVolumeId: snapshotMeta.SourceVolume,
 			CreationTime:   timestamppb.New(time.Unix(snapshotMeta.CreatedAt, 0)),
 			ReadyToUse:     true,
-			SizeBytes:      sizeBytes,
+			SizeBytes:      snapshotSizeBytes(&snapshots[0], sourceCapacityBytes),
 		},
 	}
 
@@ -184,12 +172,7 @@ func (s *ControllerService) listDetachedSnapshotByID(ctx context.Context, req *c
 	if resolvedMeta.SourceVolume != "" && isDatasetPathVolumeID(resolvedMeta.SourceVolume) {
 		ds, dsErr := s.apiClient.GetDatasetWithProperties(ctx, resolvedMeta.SourceVolume)
 		if dsErr == nil && ds != nil {
-			if capProp, ok := ds.UserProperties[tnsapi.PropertyCapacityBytes]; ok {
-				sizeBytes = tnsapi.StringToInt64(capProp.Value)
-			}
-			if sizeBytes == 0 {
-				sizeBytes = getZvolCapacity(&ds.Dataset)
-			}
+			sizeBytes = datasetCapacityBytes(ds)
 		}
 	}
 
@@ -226,12 +209,7 @@ func (s *ControllerService) listSnapshotsBySourceVolume(ctx context.Context, req
 			if prop, ok := dataset.UserProperties[tnsapi.PropertyProtocol]; ok {
 				protocol = prop.Value
 			}
-			if capProp, ok := dataset.UserProperties[tnsapi.PropertyCapacityBytes]; ok {
-				sizeBytes = tnsapi.StringToInt64(capProp.Value)
-			}
-			if sizeBytes == 0 {
-				sizeBytes = getZvolCapacity(&dataset.Dataset)
-			}
+			sizeBytes = datasetCapacityBytes(dataset)
 		}
 	} else {
 		// Legacy format: plain volume name, search by shares/namespaces/extents
@@ -293,7 +271,7 @@ func (s *ControllerService) listSnapshotsBySourceVolume(ctx context.Context, req
 			SourceVolume: req.GetSourceVolumeId(),
 			DatasetName:  snapshot.Dataset,
 			Protocol:     protocol,
-			CreatedAt:    time.Now().Unix(),
+			CreatedAt:    snapshotCreatedAt(&snapshot),
 		}
 
 		snapshotID, encodeErr := encodeSnapshotID(snapshotMeta)
@@ -308,7 +286,7 @@ func (s *ControllerService) listSnapshotsBySourceVolume(ctx context.Context, req
 				SourceVolumeId: req.GetSourceVolumeId(),
 				CreationTime:   timestamppb.New(time.Unix(snapshotMeta.CreatedAt, 0)),
 				ReadyToUse:     true,
-				SizeBytes:      sizeBytes,
+				SizeBytes:      snapshotSizeBytes(&snapshot, sizeBytes),
 			},
 		}
 		entries = append(entries, entry)
@@ -355,14 +333,7 @@ func (s *ControllerService) listAllSnapshots(ctx context.Context, req *csi.ListS
 		if prop, ok := ds.UserProperties[tnsapi.PropertyProtocol]; ok && prop.Value != "" {
 			protocol = prop.Value
 		}
-		var capacityBytes int64
-		if capProp, ok := ds.UserProperties[tnsapi.PropertyCapacityBytes]; ok {
-			capacityBytes = tnsapi.StringToInt64(capProp.Value)
-		}
-		if capacityBytes == 0 {
-			capacityBytes = getZvolCapacity(&ds.Dataset)
-		}
-		managedMeta[ds.ID] = datasetMeta{volumeID: volumeID, protocol: protocol, capacityBytes: capacityBytes}
+		managedMeta[ds.ID] = datasetMeta{volumeID: volumeID, protocol: protocol, capacityBytes: datasetCapacityBytes(&ds)}
 	}
 
 	// Query snapshots per managed dataset (each query is small and filtered)
@@ -419,7 +390,7 @@ func (s *ControllerService) listAllSnapshots(ctx context.Context, req *csi.ListS
 			SourceVolume: meta.volumeID,
 			DatasetName:  snapshot.Dataset,
 			Protocol:     meta.protocol,
-			CreatedAt:    time.Now().Unix(),
+			CreatedAt:    snapshotCreatedAt(&snapshot),
 		}
 
 		snapshotID, encodeErr := encodeSnapshotID(snapshotMeta)
@@ -434,7 +405,7 @@ func (s *ControllerService) listAllSnapshots(ctx context.Context, req *csi.ListS
 				SourceVolumeId: meta.volumeID,
 				CreationTime:   timestamppb.New(time.Unix(snapshotMeta.CreatedAt, 0)),
 				ReadyToUse:     true,
-				SizeBytes:      meta.capacityBytes,
+				SizeBytes:      snapshotSizeBytes(&snapshot, meta.capacityBytes),
 			},
 		}
 		entries = append(entries, entry)
@@ -3,6 +3,7 @@ package driver
 import (
 	"context"
 	"errors"
+	"io"
 	"testing"
 	"time"
 
@@ -19,6 +20,8 @@ type MockAPIClientForSnapshots struct {
 	QuerySnapshotsFunc             func(ctx context.Context, filters []interface{}) ([]tnsapi.Snapshot, error)
 	CloneSnapshotFunc              func(ctx context.Context, params tnsapi.CloneSnapshotParams) (*tnsapi.Dataset, error)
 	PromoteDatasetFunc             func(ctx context.Context, datasetID string) error
+	HoldSnapshotFunc               func(ctx context.Context, snapshotID string) error
+	ReleaseSnapshotFunc            func(ctx context.Context, snapshotID string) error
 	CreateDatasetFunc              func(ctx context.Context, params tnsapi.DatasetCreateParams) (*tnsapi.Dataset, error)
 	DeleteDatasetFunc              func(ctx context.Context, datasetID string) error
 	GetDatasetFunc                 func(ctx context.Context, datasetID string) (*tnsapi.Dataset, error)
@@ -41,13 +44,29 @@ type MockAPIClientForSnapshots struct {
 	QueryAllNFSSharesFunc          func(ctx context.Context, pathPrefix string) ([]tnsapi.NFSShare, error)
 	QueryNVMeOFNamespaceByIDFunc   func(ctx context.Context, namespaceID int) (*tnsapi.NVMeOFNamespace, error)
 	QueryAllNVMeOFNamespacesFunc   func(ctx context.Context) ([]tnsapi.NVMeOFNamespace, error)
+	QuerySubsystemPortBindingsFunc func(ctx context.Context, subsystemID int) ([]tnsapi.NVMeOFPortSubsystem, error)
+	RemoveSubsystemFromPortFunc    func(ctx context.Context, portSubsysID int) error
 	QueryPoolFunc                  func(ctx context.Context, poolName string) (*tnsapi.Pool, error)
 	FindManagedDatasetsFunc        func(ctx context.Context, prefix string) ([]tnsapi.DatasetWithProperties, error)
 	FindDatasetByCSIVolumeNameFunc func(ctx context.Context, poolDatasetPrefix, volumeName string) (*tnsapi.DatasetWithProperties, error)
 	FindDatasetsByPropertyFunc     func(ctx context.Context, poolDatasetPrefix, propertyName, propertyValue string) ([]tnsapi.DatasetWithProperties, error)
 	GetDatasetWithPropertiesFunc   func(ctx context.Context, datasetID string) (*tnsapi.DatasetWithProperties, error)
+	GetDatasetPropertiesFunc       func(ctx context.Context, datasetID string, propertyNames []string) (map[string]string, error)
+	SetDatasetPropertiesFunc       func(ctx context.Context, datasetID string, properties map[string]string) error
 	QueryISCSITargetsFunc          func(ctx context.Context, filters []interface{}) ([]tnsapi.ISCSITarget, error)
 	QueryISCSIExtentsFunc          func(ctx context.Context, filters []interface{}) ([]tnsapi.ISCSIExtent, error)
+	FilesystemStatFunc             func(ctx context.Context, path string) error
+	FilesystemMkdirFunc            func(ctx context.Context, path, mode string) error
+	CreateSMBShareFunc             func(ctx context.Context, params tnsapi.SMBShareCreateParams) (*tnsapi.SMBShare, error)
+	DeleteSMBShareFunc             func(ctx context.Context, shareID int) error
+	DeleteISCSITargetFunc          func(ctx context.Context, targetID int, force bool) error
+	DeleteISCSIExtentFunc          func(ctx context.Context, extentID int, removeFile, force bool) error
+	SetFilesystemNFS4ACLFunc       func(ctx context.Context, path string, aces []tnsapi.NFS4ACE) error
+
+	QuerySnapshotsWithPropertiesFunc func(ctx context.Context, filters []interface{}) ([]tnsapi.Snapshot, error)
+	StartDatasetDeleteFunc           func(ctx context.Context, datasetID string, opts tnsapi.DatasetDeleteOptions) (int, error)
+	RenameDatasetFunc                func(ctx context.Context, datasetID, newName string) error
+	GetJobStatusFunc                 func(ctx context.Context, jobID int) (*tnsapi.Job, error)
 }
 
 func (m *MockAPIClientForSnapshots) CreateSnapshot(ctx context.Context, params tnsapi.SnapshotCreateParams) (*tnsapi.Snapshot, error) {
@@ -72,6 +91,9 @@ func (m *MockAPIClientForSnapshots) QuerySnapshots(ctx context.Context, filters
 }
 
 func (m *MockAPIClientForSnapshots) QuerySnapshotsWithProperties(ctx context.Context, filters []interface{}) ([]tnsapi.Snapshot, error) {
+	if m.QuerySnapshotsWithPropertiesFunc != nil {
+		return m.QuerySnapshotsWithPropertiesFunc(ctx, filters)
+	}
 	return nil, nil
 }
 
@@ -86,6 +108,20 @@ func (m *MockAPIClientForSnapshots) CloneSnapshot(ctx context.Context, params tn
 	return nil, errors.New("CloneSnapshotFunc not implemented")
 }
 
+func (m *MockAPIClientForSnapshots) HoldSnapshot(ctx context.Context, snapshotID string) error {
+	if m.HoldSnapshotFunc != nil {
+		return m.HoldSnapshotFunc(ctx, snapshotID)
+	}
+	return nil
+}
+
+func (m *MockAPIClientForSnapshots) ReleaseSnapshot(ctx context.Context, snapshotID string) error {
+	if m.ReleaseSnapshotFunc != nil {
+		return m.ReleaseSnapshotFunc(ctx, snapshotID)
+	}
+	return nil
+}
+
 func (m *MockAPIClientForSnapshots) PromoteDataset(ctx context.Context, datasetID string) error {
 	if m.PromoteDatasetFunc != nil {
 		return m.PromoteDatasetFunc(ctx, datasetID)
@@ -108,6 +144,24 @@ func (m *MockAPIClientForSnapshots) DeleteDataset(ctx context.Context, datasetID
 	return errors.New("DeleteDatasetFunc not implemented")
 }
 
+func (m *MockAPIClientForSnapshots) DeleteDatasetWithOptions(ctx context.Context, datasetID string, _ tnsapi.DatasetDeleteOptions) error {
+	return m.DeleteDataset(ctx, datasetID)
+}
+
+func (m *MockAPIClientForSnapshots) StartDatasetDelete(ctx context.Context, datasetID string, opts tnsapi.DatasetDeleteOptions) (int, error) {
+	if m.StartDatasetDeleteFunc != nil {
+		return m.StartDatasetDeleteFunc(ctx, datasetID, opts)
+	}
+	return 0, errors.New("StartDatasetDeleteFunc not implemented")
+}
+
+func (m *MockAPIClientForSnapshots) RenameDataset(ctx context.Context, datasetID, newName string) error {
+	if m.RenameDatasetFunc != nil {
+		return m.RenameDatasetFunc(ctx, datasetID, newName)
+	}
+	return errors.New("RenameDatasetFunc not implemented")
+}
+
 func (m *MockAPIClientForSnapshots) Dataset(ctx context.Context, datasetID string) (*tnsapi.Dataset, error) {
 	if m.GetDatasetFunc != nil {
 		return m.GetDatasetFunc(ctx, datasetID)
@@ -129,6 +183,10 @@ func (m *MockAPIClientForSnapshots) CreateNFSShare(ctx context.Context, params t
 	return nil, errors.New("CreateNFSShareFunc not implemented")
 }
 
+func (m *MockAPIClientForSnapshots) UpdateNFSShare(_ context.Context, _ int, _ tnsapi.NFSShareUpdateParams) (*tnsapi.NFSShare, error) {
+	return &tnsapi.NFSShare{}, nil
+}
+
 func (m *MockAPIClientForSnapshots) DeleteNFSShare(ctx context.Context, shareID int) error {
 	if m.DeleteNFSShareFunc != nil {
 		return m.DeleteNFSShareFunc(ctx, shareID)
@@ -144,10 +202,16 @@ func (m *MockAPIClientForSnapshots) QueryNFSShare(ctx context.Context, path stri
 }
 
 func (m *MockAPIClientForSnapshots) CreateSMBShare(ctx context.Context, params tnsapi.SMBShareCreateParams) (*tnsapi.SMBShare, error) {
+	if m.CreateSMBShareFunc != nil {
+		return m.CreateSMBShareFunc(ctx, params)
+	}
 	return nil, errors.New("CreateSMBShareFunc not implemented")
 }
 
 func (m *MockAPIClientForSnapshots) DeleteSMBShare(ctx context.Context, shareID int) error {
+	if m.DeleteSMBShareFunc != nil {
+		return m.DeleteSMBShareFunc(ctx, shareID)
+	}
 	return errors.New("DeleteSMBShareFunc not implemented")
 }
 
@@ -164,9 +228,19 @@ func (m *MockAPIClientForSnapshots) QueryAllSMBShares(ctx context.Context, pathP
 }
 
 func (m *MockAPIClientForSnapshots) FilesystemStat(ctx context.Context, path string) error {
+	if m.FilesystemStatFunc != nil {
+		return m.FilesystemStatFunc(ctx, path)
+	}
 	return nil
 }
 
+func (m *MockAPIClientForSnapshots) FilesystemMkdir(ctx context.Context, path, mode string) error {
+	if m.FilesystemMkdirFunc != nil {
+		return m.FilesystemMkdirFunc(ctx, path, mode)
+	}
+	return errors.New("FilesystemMkdirFunc not implemented")
+}
+
 func (m *MockAPIClientForSnapshots) GetFilesystemACL(ctx context.Context, path string) (string, error) {
 	return "NFS4", nil
 }
@@ -175,6 +249,13 @@ func (m *MockAPIClientForSnapshots) SetFilesystemACL(ctx context.Context, path s
 	return nil
 }
 
+func (m *MockAPIClientForSnapshots) SetFilesystemNFS4ACL(ctx context.Context, path string, aces []tnsapi.NFS4ACE) error {
+	if m.SetFilesystemNFS4ACLFunc != nil {
+		return m.SetFilesystemNFS4ACLFunc(ctx, path, aces)
+	}
+	return nil
+}
+
 func (m *MockAPIClientForSnapshots) CreateZvol(ctx context.Context, params tnsapi.ZvolCreateParams) (*tnsapi.Dataset, error) {
 	if m.CreateZvolFunc != nil {
 		return m.CreateZvolFunc(ctx, params)
@@ -266,6 +347,18 @@ func (m *MockAPIClientForSnapshots) QueryAllNFSShares(ctx context.Context, pathP
 	return nil, errors.New("QueryAllNFSSharesFunc not implemented")
 }
 
+func (m *MockAPIClientForSnapshots) GetNFSConfig(_ context.Context) (*tnsapi.NFSConfig, error) {
+	return &tnsapi.NFSConfig{Protocols: []string{"NFSV3", "NFSV4"}}, nil
+}
+
+func (m *MockAPIClientForSnapshots) ListNFS4Clients(_ context.Context) ([]tnsapi.NFS4Client, error) {
+	return nil, nil
+}
+
+func (m *MockAPIClientForSnapshots) ExpireNFS4Client(_ context.Context, _ string) error {
+	return nil
+}
+
 func (m *MockAPIClientForSnapshots) QueryNVMeOFNamespaceByID(ctx context.Context, namespaceID int) (*tnsapi.NVMeOFNamespace, error) {
 	if m.QueryNVMeOFNamespaceByIDFunc != nil {
 		return m.QueryNVMeOFNamespaceByIDFunc(ctx, namespaceID)
@@ -288,16 +381,25 @@ func (m *MockAPIClientForSnapshots) QueryPool(ctx context.Context, poolName stri
 }
 
 func (m *MockAPIClientForSnapshots) RemoveSubsystemFromPort(ctx context.Context, portSubsysID int) error {
+	if m.RemoveSubsystemFromPortFunc != nil {
+		return m.RemoveSubsystemFromPortFunc(ctx, portSubsysID)
+	}
 	return nil
 }
 
 func (m *MockAPIClientForSnapshots) QuerySubsystemPortBindings(ctx context.Context, subsystemID int) ([]tnsapi.NVMeOFPortSubsystem, error) {
+	if m.QuerySubsystemPortBindingsFunc != nil {
+		return m.QuerySubsystemPortBindingsFunc(ctx, subsystemID)
+	}
 	return nil, nil
 }
 
 // ZFS User Property methods - mock implementations for Phase 1
 
 func (m *MockAPIClientForSnapshots) SetDatasetProperties(ctx context.Context, datasetID string, properties map[string]string) error {
+	if m.SetDatasetPropertiesFunc != nil {
+		return m.SetDatasetPropertiesFunc(ctx, datasetID, properties)
+	}
 	// Mock implementation - always succeed
 	return nil
 }
@@ -308,6 +410,9 @@ func (m *MockAPIClientForSnapshots) SetSnapshotProperties(ctx context.Context, s
 }
 
 func (m *MockAPIClientForSnapshots) GetDatasetProperties(ctx context.Context, datasetID string, propertyNames []string) (map[string]string, error) {
+	if m.GetDatasetPropertiesFunc != nil {
+		return m.GetDatasetPropertiesFunc(ctx, datasetID, propertyNames)
+	}
 	// Mock implementation - return empty map (no properties)
 	return make(map[string]string), nil
 }
@@ -328,17 +433,28 @@ func (m *MockAPIClientForSnapshots) ClearDatasetProperties(ctx context.Context,
 }
 
 // Replication methods for detached snapshots.
+func (m *MockAPIClientForSnapshots) SendSnapshot(ctx context.Context, params tnsapi.SnapshotSendParams) (io.ReadCloser, error) {
+	return nil, tnsapi.ErrStreamTransfer
+}
+
+func (m *MockAPIClientForSnapshots) ReceiveSnapshot(ctx context.Context, params tnsapi.SnapshotReceiveParams, stream io.Reader) error {
+	return tnsapi.ErrStreamTransfer
+}
+
 func (m *MockAPIClientForSnapshots) RunOnetimeReplication(ctx context.Context, params tnsapi.ReplicationRunOnetimeParams) (int, error) {
 	// Mock implementation - return a job ID
 	return 12345, nil
 }
 
-func (m *MockAPIClientForSnapshots) GetJobStatus(ctx context.Context, jobID int) (*tnsapi.ReplicationJobState, error) {
+func (m *MockAPIClientForSnapshots) GetJobStatus(ctx context.Context, jobID int) (*tnsapi.Job, error) {
+	if m.GetJobStatusFunc != nil {
+		return m.GetJobStatusFunc(ctx, jobID)
+	}
 	// Mock implementation - return completed status
-	return &tnsapi.ReplicationJobState{
+	return &tnsapi.Job{
 		ID:       jobID,
-		State:    "SUCCESS",
-		Progress: map[string]interface{}{"percent": float64(100)},
+		State:    tnsapi.JobStateSuccess,
+		Progress: tnsapi.JobProgress{Percent: 100},
 	}, nil
 }
 
@@ -413,7 +529,10 @@ func (m *MockAPIClientForSnapshots) CreateISCSITarget(_ context.Context, params
 	}, nil
 }
 
-func (m *MockAPIClientForSnapshots) DeleteISCSITarget(_ context.Context, _ int, _ bool) error {
+func (m *MockAPIClientForSnapshots) DeleteISCSITarget(ctx context.Context, targetID int, force bool) error {
+	if m.DeleteISCSITargetFunc != nil {
+		return m.DeleteISCSITargetFunc(ctx, targetID, force)
+	}
 	return nil
 }
 
@@ -439,7 +558,10 @@ func (m *MockAPIClientForSnapshots) CreateISCSIExtent(_ context.Context, params
 	}, nil
 }
 
-func (m *MockAPIClientForSnapshots) DeleteISCSIExtent(_ context.Context, _ int, _, _ bool) error {
+func (m *MockAPIClientForSnapshots) DeleteISCSIExtent(ctx context.Context, extentID int, removeFile, force bool) error {
+	if m.DeleteISCSIExtentFunc != nil {
+		return m.DeleteISCSIExtentFunc(ctx, extentID, removeFile, force)
+	}
 	return nil
 }
 
@@ -821,6 +943,7 @@ func TestDeleteSnapshot(t *testing.T) {
 		name      string
 		wantCode  codes.Code
 		wantErr   bool
+		protect   bool
 	}{
 		{
 			name: "successful snapshot deletion",
@@ -890,6 +1013,57 @@ func TestDeleteSnapshot(t *testing.T) {
 			wantErr:  true,
 			wantCode: codes.Internal,
 		},
+		{
+			name: "protected snapshot with COW clone is refused",
+			req: &csi.DeleteSnapshotRequest{
+				SnapshotId: snapshotID,
+			},
+			protect: true,
+			mockSetup: func(m *MockAPIClientForSnapshots) {
+				m.QuerySnapshotsFunc = func(ctx context.Context, filters []interface{}) ([]tnsapi.Snapshot, error) {
+					return []tnsapi.Snapshot{
+						{ID: "tank/" + volumeID + "@test-snapshot", Dataset: "tank/" + volumeID},
+					}, nil
+				}
+				m.FindDatasetsByPropertyFunc = func(ctx context.Context, prefix, propertyName, propertyValue string) ([]tnsapi.DatasetWithProperties, error) {
+					return []tnsapi.DatasetWithProperties{{
+						Dataset: tnsapi.Dataset{ID: "tank/restored"},
+						UserProperties: map[string]tnsapi.UserProperty{
+							tnsapi.PropertyCSIVolumeName:  {Value: "pvc-restored"},
+							tnsapi.PropertyCloneMode:      {Value: tnsapi.CloneModeCOW},
+							tnsapi.PropertyOriginSnapshot: {Value: propertyValue},
+						},
+					}}, nil
+				}
+				m.DeleteSnapshotFunc = func(ctx context.Context, snapshotID string) error {
+					t.Error("DeleteSnapshot must not be called while clones depend on the snapshot")
+					return nil
+				}
+			},
+			wantErr:  true,
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name: "protected snapshot without clones is deleted",
+			req: &csi.DeleteSnapshotRequest{
+				SnapshotId: snapshotID,
+			},
+			protect: true,
+			mockSetup: func(m *MockAPIClientForSnapshots) {
+				m.QuerySnapshotsFunc = func(ctx context.Context, filters []interface{}) ([]tnsapi.Snapshot, error) {
+					return []tnsapi.Snapshot{
+						{ID: "tank/" + volumeID + "@test-snapshot", Dataset: "tank/" + volumeID},
+					}, nil
+				}
+				m.FindDatasetsByPropertyFunc = func(ctx context.Context, prefix, propertyName, propertyValue string) ([]tnsapi.DatasetWithProperties, error) {
+					return nil, nil
+				}
+				m.DeleteSnapshotFunc = func(ctx context.Context, snapshotID string) error {
+					return nil
+				}
+			},
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -898,6 +1072,7 @@ func TestDeleteSnapshot(t *testing.T) {
 			tt.mockSetup(mockClient)
 
 			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+			controller.protectSnapshotClones = tt.protect
 			_, err := controller.DeleteSnapshot(ctx, tt.req)
 
 			if tt.wantErr {
@@ -1007,7 +1182,14 @@ func TestListSnapshots(t *testing.T) {
 			mockSetup: func(m *MockAPIClientForSnapshots) {
 				m.QuerySnapshotsFunc = func(ctx context.Context, filters []interface{}) ([]tnsapi.Snapshot, error) {
 					return []tnsapi.Snapshot{
-						{ID: "tank/test-volume@test-snapshot", Dataset: "tank/test-volume"},
+						{
+							ID:      "tank/test-volume@test-snapshot",
+							Dataset: "tank/test-volume",
+							Properties: map[string]interface{}{
+								"creation":   map[string]interface{}{"rawvalue": "1704110400"},
+								"referenced": map[string]interface{}{"rawvalue": "5368709120"},
+							},
+						},
 					}, nil
 				}
 			},
@@ -1015,7 +1197,14 @@ func TestListSnapshots(t *testing.T) {
 			checkResponse: func(t *testing.T, resp *csi.ListSnapshotsResponse) {
 				t.Helper()
 				if len(resp.Entries) != 1 {
-					t.Errorf("Expected 1 entry, got %d", len(resp.Entries))
+					t.Fatalf("Expected 1 entry, got %d", len(resp.Entries))
+				}
+				if got := resp.Entries[0].Snapshot.CreationTime.AsTime().Unix(); got != 1704110400 {
+					t.Errorf("Expected CreationTime from the ZFS creation property (1704110400), got %d", got)
+				}
+				// No recorded source capacity: falls back to the snapshot's referenced size
+				if got := resp.Entries[0].Snapshot.SizeBytes; got != 5368709120 {
+					t.Errorf("Expected SizeBytes from the referenced property (5368709120), got %d", got)
 				}
 			},
 		},
@@ -1295,6 +1484,7 @@ func TestValidateCloneParameters(t *testing.T) {
 		wantDataset  string
 		errContains  string
 		wantErr      bool
+		wantCross    bool
 	}{
 		{
 			name: "pool and parentDataset provided explicitly",
@@ -1310,6 +1500,61 @@ func TestValidateCloneParameters(t *testing.T) {
 			wantParent:  "mypool/csi",
 			wantDataset: "mypool/csi/test-volume",
 			wantErr:     false,
+			wantCross:   true,
+		},
+		{
+			name: "restoreParentDataset on the snapshot pool takes precedence",
+			params: map[string]string{
+				"pool":                    "tank",
+				"parentDataset":           "tank/csi",
+				RestoreParentDatasetParam: "tank/restores",
+			},
+			snapshotMeta: &SnapshotMetadata{
+				DatasetName: "tank/csi/pvc-source",
+				Protocol:    ProtocolNFS,
+			},
+			wantPool:    "tank",
+			wantParent:  "tank/restores",
+			wantDataset: "tank/restores/test-volume",
+		},
+		{
+			name: "restoreParentDataset on another pool",
+			params: map[string]string{
+				RestoreParentDatasetParam: "fast/restores",
+			},
+			snapshotMeta: &SnapshotMetadata{
+				DatasetName: "tank/csi/pvc-source",
+				Protocol:    ProtocolNFS,
+			},
+			wantPool:    "fast",
+			wantParent:  "fast/restores",
+			wantDataset: "fast/restores/test-volume",
+			wantCross:   true,
+		},
+		{
+			name: "restoreParentDataset on another pool from a detached snapshot",
+			params: map[string]string{
+				RestoreParentDatasetParam: "fast/restores",
+			},
+			snapshotMeta: &SnapshotMetadata{
+				DatasetName: "tank/csi-detached-snapshots/snap-1",
+				Protocol:    ProtocolNFS,
+				Detached:    true,
+			},
+			wantErr:     true,
+			errContains: "must stay on the same pool",
+		},
+		{
+			name: "invalid restoreParentDataset",
+			params: map[string]string{
+				RestoreParentDatasetParam: "/tank/restores",
+			},
+			snapshotMeta: &SnapshotMetadata{
+				DatasetName: "tank/csi/pvc-source",
+				Protocol:    ProtocolNFS,
+			},
+			wantErr:     true,
+			errContains: "invalid restoreParentDataset",
 		},
 		{
 			name:   "infer pool and parentDataset from NFS snapshot dataset",
@@ -1420,10 +1665,186 @@ func TestValidateCloneParameters(t *testing.T) {
 			if result.newVolumeName != "test-volume" {
 				t.Errorf("validateCloneParameters() newVolumeName = %v, want %v", result.newVolumeName, "test-volume")
 			}
+			if result.crossPool != tt.wantCross {
+				t.Errorf("validateCloneParameters() crossPool = %v, want %v", result.crossPool, tt.wantCross)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeFromPopulatorValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     map[string]string
+		protocol   string
+		sourceType string
+		wantCode   codes.Code
+	}{
+		{
+			name:     "source not found",
+			params:   map[string]string{},
+			protocol: ProtocolNFS,
+			wantCode: codes.NotFound,
+		},
+		{
+			name:       "zvol source for NFS volume",
+			params:     map[string]string{},
+			protocol:   ProtocolNFS,
+			sourceType: datasetTypeVolume,
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name:       "filesystem source for NVMe-oF volume",
+			params:     map[string]string{},
+			protocol:   ProtocolNVMeOF,
+			sourceType: datasetTypeFilesystem,
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name:       "promoted mode rejected",
+			params:     map[string]string{PromotedVolumesFromVolumesParam: VolumeContextValueTrue},
+			protocol:   ProtocolNFS,
+			sourceType: datasetTypeFilesystem,
+			wantCode:   codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockAPIClientForSnapshots{
+				GetDatasetFunc: func(_ context.Context, datasetID string) (*tnsapi.Dataset, error) {
+					if tt.sourceType == "" {
+						return nil, errors.New("dataset not found")
+					}
+					return &tnsapi.Dataset{ID: datasetID, Name: datasetID, Type: tt.sourceType}, nil
+				},
+				CreateSnapshotFunc: func(_ context.Context, _ tnsapi.SnapshotCreateParams) (*tnsapi.Snapshot, error) {
+					t.Fatal("CreateSnapshot must not be called when validation fails")
+					return nil, nil
+				},
+			}
+			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+			req := &csi.CreateVolumeRequest{Name: "pvc-populated", Parameters: tt.params}
+			source := &populatorSource{scheme: populatorSchemeDataset, ref: "tank/golden/seed"}
+
+			_, err := controller.createVolumeFromPopulator(context.Background(), req, source, tt.protocol)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("createVolumeFromPopulator() error = %v, want code %v", err, tt.wantCode)
+			}
 		})
 	}
 }
 
+func TestDiscoverVolumeBySearching(t *testing.T) {
+	t.Run("NFS share", func(t *testing.T) {
+		mockClient := &MockAPIClientForSnapshots{
+			QueryAllNFSSharesFunc: func(_ context.Context, _ string) ([]tnsapi.NFSShare, error) {
+				return []tnsapi.NFSShare{{ID: 1, Path: "/mnt/tank/csi/pvc-legacy"}}, nil
+			},
+			QueryAllDatasetsFunc: func(_ context.Context, prefix string) ([]tnsapi.Dataset, error) {
+				return []tnsapi.Dataset{{ID: prefix, Name: prefix}}, nil
+			},
+			QueryAllNVMeOFNamespacesFunc: func(_ context.Context) ([]tnsapi.NVMeOFNamespace, error) {
+				return nil, nil
+			},
+		}
+		controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+
+		result := controller.discoverVolumeBySearching(context.Background(), "pvc-legacy")
+		if result == nil || result.protocol != ProtocolNFS || result.datasetName != "tank/csi/pvc-legacy" {
+			t.Fatalf("got %+v, want NFS volume tank/csi/pvc-legacy", result)
+		}
+	})
+
+	t.Run("first match cancels slow probes", func(t *testing.T) {
+		mockClient := &MockAPIClientForSnapshots{
+			// The NFS probe only returns once it is canceled by the NVMe-oF match
+			QueryAllNFSSharesFunc: func(ctx context.Context, _ string) ([]tnsapi.NFSShare, error) {
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(10 * time.Second):
+					return nil, nil
+				}
+			},
+			QueryAllNVMeOFNamespacesFunc: func(_ context.Context) ([]tnsapi.NVMeOFNamespace, error) {
+				return []tnsapi.NVMeOFNamespace{{ID: 1, Device: "zvol/tank/csi/pvc-block"}}, nil
+			},
+		}
+		controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+
+		start := time.Now()
+		result := controller.discoverVolumeBySearching(context.Background(), "pvc-block")
+		if result == nil || result.protocol != ProtocolNVMeOF || result.datasetName != "tank/csi/pvc-block" {
+			t.Fatalf("got %+v, want NVMe-oF volume tank/csi/pvc-block", result)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("lookup took %v, slow probe was not canceled", elapsed)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		mockClient := &MockAPIClientForSnapshots{
+			QueryAllNFSSharesFunc: func(_ context.Context, _ string) ([]tnsapi.NFSShare, error) {
+				return nil, nil
+			},
+			QueryAllNVMeOFNamespacesFunc: func(_ context.Context) ([]tnsapi.NVMeOFNamespace, error) {
+				return nil, nil
+			},
+		}
+		controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+
+		if result := controller.discoverVolumeBySearching(context.Background(), "pvc-missing"); result != nil {
+			t.Fatalf("got %+v, want nil", result)
+		}
+	})
+}
+
+func TestHoldSnapshotReferenceCounting(t *testing.T) {
+	const snap = "tank/pvc-1@snap-1"
+	var holds, releases int
+	mockClient := &MockAPIClientForSnapshots{
+		HoldSnapshotFunc: func(_ context.Context, snapshotID string) error {
+			if snapshotID != snap {
+				t.Errorf("HoldSnapshot(%q), want %q", snapshotID, snap)
+			}
+			holds++
+			return nil
+		},
+		ReleaseSnapshotFunc: func(_ context.Context, _ string) error {
+			releases++
+			return nil
+		},
+	}
+	controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+	ctx := context.Background()
+
+	first := controller.holdSnapshot(ctx, snap)
+	second := controller.holdSnapshot(ctx, snap)
+	if holds != 1 {
+		t.Fatalf("holds = %d after two concurrent holds, want 1", holds)
+	}
+
+	first()
+	first() // idempotent
+	if releases != 0 {
+		t.Fatalf("hold released while still in use by another operation")
+	}
+	second()
+	if releases != 1 {
+		t.Fatalf("releases = %d, want 1", releases)
+	}
+
+	// A failed hold is not fatal and its release is a no-op
+	mockClient.HoldSnapshotFunc = func(_ context.Context, _ string) error {
+		return errors.New("hold failed")
+	}
+	controller.holdSnapshot(ctx, snap)()
+	if releases != 1 {
+		t.Errorf("released a hold that was never placed")
+	}
+}
+
 // Helper function to check if a string contains a substring.
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && indexOf(s, substr) >= 0
@@ -1437,3 +1858,31 @@ func indexOf(s, substr string) int {
 	}
 	return -1
 }
+
+func (m *MockAPIClientForSnapshots) NVMeOFHostByNQN(_ context.Context, _ string) (*tnsapi.NVMeOFHost, error) {
+	return nil, errNotImplemented
+}
+
+func (m *MockAPIClientForSnapshots) CreateNVMeOFHost(_ context.Context, _ string) (*tnsapi.NVMeOFHost, error) {
+	return nil, errNotImplemented
+}
+
+func (m *MockAPIClientForSnapshots) QueryNVMeOFHostSubsystems(_ context.Context, _ int) ([]tnsapi.NVMeOFHostSubsystem, error) {
+	return nil, errNotImplemented
+}
+
+func (m *MockAPIClientForSnapshots) AddHostToSubsystem(_ context.Context, _, _ int) error {
+	return errNotImplemented
+}
+
+func (m *MockAPIClientForSnapshots) RemoveHostFromSubsystem(_ context.Context, _ int) error {
+	return errNotImplemented
+}
+
+func (m *MockAPIClientForSnapshots) CreateISCSIInitiator(_ context.Context, _ tnsapi.ISCSIInitiatorCreateParams) (*tnsapi.ISCSIInitiator, error) {
+	return nil, errNotImplemented
+}
+
+func (m *MockAPIClientForSnapshots) UpdateISCSITargetGroups(_ context.Context, _ int, _ []tnsapi.ISCSITargetGroup) (*tnsapi.ISCSITarget, error) {
+	return nil, errNotImplemented
+}
@@ -0,0 +1,144 @@
+package driver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Staged (atomic) volume creation.
+//
+// A CreateVolume interrupted after the dataset was created, e.g. by a controller restart or a
+// lost connection, leaves a dataset under the volume's name without its ZFS configuration or
+// ownership properties. The retry then adopts it as is, and the deletion guard refuses to remove
+// it. With --atomic-create the dataset or ZVOL is created as .provisioning-<id> next to its final
+// name instead, gets the tns-csi ownership properties and its QoS properties there, and is only
+// renamed into place once all of that succeeded.
+//
+// The staging dataset is claimed with a creation generation (controller_generation.go) and the
+// time of the claim as soon as it exists. A retry that finds a staging dataset claimed less than
+// the provisioning timeout ago returns Aborted, since the attempt that created it may still be
+// running; older or unclaimed ones were left by an interrupted attempt (they were never exported,
+// so they hold no data) and are removed before starting over. An attempt renames the staging
+// dataset only while it still holds its claim.
+//
+// The rename cannot wait for the export: NFS and SMB shares, NVMe-oF namespaces and iSCSI extents
+// refer to their dataset by path, which a rename does not update, and TrueNAS refuses to create a
+// share for a path that does not exist yet. They are created after the rename, so a volume
+// interrupted at that point is fully configured and owned, and the retry finishes its export.
+
+// provisioningPrefix starts the name of a dataset that is still being configured.
+const provisioningPrefix = ".provisioning-"
+
+// stagingDatasetName returns the staging name of a dataset. It is derived from the final name,
+// so retries of an interrupted creation find the staging dataset of the earlier attempt.
+func stagingDatasetName(datasetName string) string {
+	sum := sha256.Sum256([]byte(datasetName))
+	return path.Join(path.Dir(datasetName), provisioningPrefix+hex.EncodeToString(sum[:8]))
+}
+
+// isStagingDataset reports whether a dataset is a staging dataset of --atomic-create.
+func isStagingDataset(datasetID string) bool {
+	return strings.HasPrefix(path.Base(datasetID), provisioningPrefix)
+}
+
+// createDatasetStaged creates the dataset of a volume with create (which receives the name to
+// create) and configure. With --atomic-create both run on a staging dataset that is renamed to
+// datasetName afterwards; otherwise the dataset is created under its final name directly.
+func (s *ControllerService) createDatasetStaged(ctx context.Context, datasetName, volumeName string,
+	create func(name string) (*tnsapi.Dataset, error), configure func(datasetID string),
+) (*tnsapi.Dataset, error) {
+	if !s.atomicCreate {
+		dataset, err := create(datasetName)
+		if err != nil {
+			return nil, err
+		}
+		configure(dataset.ID)
+		return dataset, nil
+	}
+
+	stagingName := stagingDatasetName(datasetName)
+	if err := s.removeStaleStagingDataset(ctx, stagingName, volumeName); err != nil {
+		return nil, err
+	}
+
+	dataset, err := create(stagingName)
+	if err != nil {
+		return nil, err
+	}
+
+	generation := newCreateGeneration()
+	props := map[string]string{
+		tnsapi.PropertySchemaVersion:    tnsapi.SchemaVersionV1,
+		tnsapi.PropertyManagedBy:        tnsapi.ManagedByValue,
+		tnsapi.PropertyCSIVolumeName:    volumeName,
+		tnsapi.PropertyCreateGeneration: generation,
+		tnsapi.PropertyCreatedAt:        time.Now().UTC().Format(time.RFC3339),
+	}
+	if s.clusterID != "" {
+		props[tnsapi.PropertyClusterID] = s.clusterID
+	}
+	if err := s.apiClient.SetDatasetProperties(ctx, dataset.ID, props); err != nil {
+		s.removeStagingDataset(ctx, dataset.ID)
+		return nil, fmt.Errorf("failed to set ownership properties on %s: %w", dataset.ID, err)
+	}
+	configure(dataset.ID)
+
+	// A later attempt took the staging dataset over (this one outlived the provisioning timeout)
+	if !s.ownsCreation(ctx, dataset.ID, generation) {
+		return nil, creationSupersededError(volumeName)
+	}
+	if err := s.apiClient.RenameDataset(ctx, dataset.ID, datasetName); err != nil {
+		s.removeStagingDataset(ctx, dataset.ID)
+		return nil, err
+	}
+	klog.V(4).Infof("Renamed staging dataset %s to %s", dataset.ID, datasetName)
+
+	dataset.ID, dataset.Name = datasetName, datasetName
+	if dataset.Mountpoint != "" {
+		dataset.Mountpoint = "/mnt/" + datasetName
+	}
+	return dataset, nil
+}
+
+// removeStaleStagingDataset removes the staging dataset an interrupted attempt left behind. A
+// staging dataset claimed less than the provisioning timeout ago is left to the attempt that may
+// still be configuring it, and Aborted is returned.
+func (s *ControllerService) removeStaleStagingDataset(ctx context.Context, stagingName, volumeName string) error {
+	if _, err := s.apiClient.Dataset(ctx, stagingName); err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to look up staging dataset %s: %w", stagingName, err)
+	}
+	props, err := s.apiClient.GetDatasetProperties(ctx, stagingName, []string{tnsapi.PropertyCreatedAt})
+	if err != nil {
+		return fmt.Errorf("failed to check staging dataset %s: %w", stagingName, err)
+	}
+	if claimed, perr := time.Parse(time.RFC3339, props[tnsapi.PropertyCreatedAt]); perr == nil && time.Since(claimed) < s.timeouts.provisioning() {
+		klog.Infof("Staging dataset %s of %s was claimed at %s, leaving it to that attempt", stagingName, volumeName, claimed.Format(time.RFC3339))
+		return status.Errorf(codes.Aborted, "a concurrent CreateVolume is creating volume %s in %s; retry", volumeName, stagingName)
+	}
+
+	klog.Infof("Removing staging dataset %s left by an interrupted creation of %s", stagingName, volumeName)
+	if err := s.apiClient.DeleteDataset(ctx, stagingName); err != nil && !isNotFoundError(err) {
+		return fmt.Errorf("failed to remove stale staging dataset %s: %w", stagingName, err)
+	}
+	return nil
+}
+
+// removeStagingDataset deletes a staging dataset after a failed creation step.
+func (s *ControllerService) removeStagingDataset(ctx context.Context, datasetID string) {
+	if err := s.apiClient.DeleteDataset(ctx, datasetID); err != nil && !isNotFoundError(err) {
+		klog.Warningf("Failed to remove staging dataset %s (the next attempt retries): %v", datasetID, err)
+	}
+}
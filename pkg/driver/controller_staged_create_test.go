@@ -0,0 +1,160 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStagingDatasetName(t *testing.T) {
+	name := stagingDatasetName("tank/csi/pvc-1")
+	if !isStagingDataset(name) || name[:len("tank/csi/")] != "tank/csi/" {
+		t.Errorf("stagingDatasetName() = %q, want a staging dataset in tank/csi", name)
+	}
+	if name != stagingDatasetName("tank/csi/pvc-1") || name == stagingDatasetName("tank/csi/pvc-2") {
+		t.Error("staging names must be stable per dataset and differ between datasets")
+	}
+	if isStagingDataset("tank/csi/pvc-1") {
+		t.Error("isStagingDataset(tank/csi/pvc-1) = true")
+	}
+}
+
+func TestAtomicCreateIntegration(t *testing.T) {
+	tests := []struct {
+		params map[string]string
+		name   string
+		block  bool
+	}{
+		{name: "nfs", params: map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local"}},
+		{name: "nvmeof", params: map[string]string{"protocol": ProtocolNVMeOF, "pool": "tank", "server": "truenas.local"}, block: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, srv := newIntegrationController(t)
+			controller.atomicCreate = true
+			ctx := context.Background()
+
+			// Left behind by an interrupted attempt
+			datasetName := "tank/pvc-" + tt.name
+			stale := stagingDatasetName(datasetName)
+			if _, err := controller.apiClient.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: stale, Type: datasetTypeFilesystem}); err != nil {
+				t.Fatalf("CreateDataset(%s) error = %v", stale, err)
+			}
+
+			capability := &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}
+			if tt.block {
+				capability.AccessType = &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+			}
+			created, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+				Name:               "pvc-" + tt.name,
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+				VolumeCapabilities: []*csi.VolumeCapability{capability},
+				Parameters:         tt.params,
+			})
+			if err != nil {
+				t.Fatalf("CreateVolume() error = %v", err)
+			}
+			volumeID := created.GetVolume().GetVolumeId()
+
+			if srv.DatasetExists(stale) {
+				t.Errorf("staging dataset %s still exists", stale)
+			}
+			if got := srv.Counts()["datasets"]; got != 1 {
+				t.Errorf("%d datasets on TrueNAS, want only the volume", got)
+			}
+			props, err := controller.apiClient.GetDatasetProperties(ctx, datasetName, []string{tnsapi.PropertyManagedBy})
+			if err != nil || props[tnsapi.PropertyManagedBy] != tnsapi.ManagedByValue {
+				t.Errorf("properties of %s = %v, %v; want it managed by tns-csi", datasetName, props, err)
+			}
+
+			if _, err := controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+				t.Fatalf("DeleteVolume() error = %v", err)
+			}
+			for kind, n := range srv.Counts() {
+				if n != 0 {
+					t.Errorf("%d %s left on TrueNAS after DeleteVolume", n, kind)
+				}
+			}
+		})
+	}
+}
+
+func TestAtomicCreateClaimedStagingIntegration(t *testing.T) {
+	controller, srv := newIntegrationController(t)
+	controller.atomicCreate = true
+	ctx := context.Background()
+
+	datasetName := "tank/pvc-claimed"
+	staging := stagingDatasetName(datasetName)
+	if _, err := controller.apiClient.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: staging, Type: datasetTypeFilesystem}); err != nil {
+		t.Fatalf("CreateDataset(%s) error = %v", staging, err)
+	}
+	claim := func(at time.Time) {
+		t.Helper()
+		if err := controller.apiClient.SetDatasetProperties(ctx, staging, map[string]string{
+			tnsapi.PropertyCreateGeneration: "earlier-attempt",
+			tnsapi.PropertyCreatedAt:        at.UTC().Format(time.RFC3339),
+		}); err != nil {
+			t.Fatalf("SetDatasetProperties(%s) error = %v", staging, err)
+		}
+	}
+	req := &csi.CreateVolumeRequest{
+		Name:          "pvc-claimed",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}},
+		Parameters: map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local"},
+	}
+
+	// An attempt that may still be running keeps its staging dataset
+	claim(time.Now())
+	_, err := controller.CreateVolume(ctx, req)
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("CreateVolume() error = %v, want Aborted", err)
+	}
+	if !srv.DatasetExists(staging) {
+		t.Fatalf("staging dataset %s of a running attempt was removed", staging)
+	}
+
+	// One claimed longer than the provisioning timeout ago was interrupted
+	claim(time.Now().Add(-2 * controller.timeouts.provisioning()))
+	if _, err := controller.CreateVolume(ctx, req); err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	if srv.DatasetExists(staging) || !srv.DatasetExists(datasetName) {
+		t.Errorf("staging dataset exists = %t, volume exists = %t; want only the volume",
+			srv.DatasetExists(staging), srv.DatasetExists(datasetName))
+	}
+}
+
+func TestCreateDatasetStagedLostClaim(t *testing.T) {
+	controller, srv := newIntegrationController(t)
+	controller.atomicCreate = true
+	ctx := context.Background()
+
+	datasetName := "tank/pvc-taken-over"
+	_, err := controller.createDatasetStaged(ctx, datasetName, "pvc-taken-over", func(name string) (*tnsapi.Dataset, error) {
+		return controller.apiClient.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: name, Type: datasetTypeFilesystem})
+	}, func(datasetID string) {
+		// A later attempt claims the staging dataset while this one configures it
+		if err := controller.apiClient.SetDatasetProperties(ctx, datasetID, map[string]string{tnsapi.PropertyCreateGeneration: "later-attempt"}); err != nil {
+			t.Errorf("SetDatasetProperties(%s) error = %v", datasetID, err)
+		}
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("createDatasetStaged() error = %v, want Aborted", err)
+	}
+	if !srv.DatasetExists(stagingDatasetName(datasetName)) || srv.DatasetExists(datasetName) {
+		t.Error("an attempt that lost its claim must leave the staging dataset to the later attempt")
+	}
+}
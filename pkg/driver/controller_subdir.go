@@ -0,0 +1,257 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/metrics"
+	"github.com/fenio/tns-csi/pkg/mount"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Directory volumes.
+//
+// A dataset and an export per PVC is heavy for thousands of tiny ReadWriteMany volumes. With
+// volumeType: subdir, CreateVolume only creates a directory in the pre-provisioned parentDataset,
+// which is exported once (see controller_nfs_shared.go). Nodes mount that export and bind-mount
+// the directory into pods.
+//
+// Directory volumes have no quota: the TrueNAS API cannot assign ZFS project IDs to directories,
+// so the capacity is recorded but not enforced, and expansion always succeeds. Snapshots, clones
+// and ZFS properties are per dataset and therefore not available.
+//
+// TrueNAS has no API to remove a directory, so DeleteVolume mounts the export in the controller
+// and removes the directory itself; this requires the controller to be allowed to mount NFS.
+const (
+	// VolumeTypeParam is the StorageClass parameter selecting the kind of volume to provision.
+	VolumeTypeParam = "volumeType"
+
+	// VolumeTypeSubdir provisions a directory in parentDataset instead of a dataset.
+	VolumeTypeSubdir = "subdir"
+
+	// SubdirModeParam sets the permissions of new directories (octal, default "777").
+	SubdirModeParam = "subdirMode"
+
+	defaultSubdirMode = "777"
+
+	// subdirVolumeIDPrefix marks directory volume IDs: subdir#<server>#<parentDataset>#<directory>.
+	// DeleteVolume has no StorageClass parameters, so the ID carries everything needed to find the directory.
+	subdirVolumeIDPrefix = "subdir#"
+)
+
+// subdirModePattern matches octal directory modes such as "755" or "2775".
+var subdirModePattern = regexp.MustCompile(`^[0-7]?[0-7]{3}$`)
+
+// subdirVolume identifies a directory volume.
+type subdirVolume struct {
+	server        string
+	parentDataset string
+	name          string
+}
+
+// volumeID encodes the directory volume as a CSI volume ID.
+func (v subdirVolume) volumeID() string {
+	return subdirVolumeIDPrefix + strings.Join([]string{v.server, v.parentDataset, v.name}, "#")
+}
+
+// parseSubdirVolumeID decodes a directory volume ID. ok is false for all other volume IDs.
+func parseSubdirVolumeID(volumeID string) (v subdirVolume, ok bool) {
+	rest, found := strings.CutPrefix(volumeID, subdirVolumeIDPrefix)
+	if !found {
+		return subdirVolume{}, false
+	}
+	parts := strings.Split(rest, "#")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || !isValidSubdirName(parts[2]) {
+		return subdirVolume{}, false
+	}
+	return subdirVolume{server: parts[0], parentDataset: parts[1], name: parts[2]}, true
+}
+
+// isValidSubdirName reports whether name is a single path component inside the parent dataset.
+func isValidSubdirName(name string) bool {
+	return filepath.IsLocal(name) && !strings.ContainsAny(name, "/#")
+}
+
+// createSubdirVolume provisions a directory volume.
+func (s *ControllerService) createSubdirVolume(ctx context.Context, req *csi.CreateVolumeRequest, protocol string) (*csi.CreateVolumeResponse, error) {
+	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolNFS, "create")
+	params := req.GetParameters()
+
+	if err := validateSubdirVolumeRequest(req, protocol); err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+	mode := params[SubdirModeParam]
+	if mode == "" {
+		mode = defaultSubdirMode
+	}
+
+	name, err := ResolveVolumeName(params, req.GetName())
+	if err != nil {
+		timer.ObserveError()
+		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve volume name: %v", err)
+	}
+	if !isValidSubdirName(name) {
+		timer.ObserveError()
+		return nil, status.Errorf(codes.InvalidArgument, "volume name %q is not a valid directory name", name)
+	}
+	server := s.nfsServers.resolve(params["server"])
+	if server == "" {
+		server = defaultServerAddress
+	}
+	volume := subdirVolume{server: server, parentDataset: params["parentDataset"], name: name}
+
+	parent, err := s.apiClient.Dataset(ctx, volume.parentDataset)
+	if err != nil || parent == nil || parent.Mountpoint == "" {
+		timer.ObserveError()
+		return nil, status.Errorf(codes.InvalidArgument, "parentDataset %s for %s volumes must be an existing mounted dataset: %v",
+			volume.parentDataset, VolumeTypeSubdir, err)
+	}
+	share, err := s.ensureParentNFSShare(ctx, parent.Mountpoint)
+	if err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+
+	dirPath := path.Join(parent.Mountpoint, name)
+	if statErr := s.apiClient.FilesystemStat(ctx, dirPath); statErr == nil {
+		klog.V(4).Infof("Directory %s already exists, returning existing volume", dirPath)
+	} else if err := s.apiClient.FilesystemMkdir(ctx, dirPath, mode); err != nil {
+		timer.ObserveError()
+		return nil, status.Errorf(codes.Internal, "Failed to create directory %s: %v", dirPath, err)
+	}
+
+	capacity := req.GetCapacityRange().GetRequiredBytes()
+	if capacity == 0 {
+		capacity = MinVolumeSize
+	}
+
+	volumeContext := buildVolumeContext(VolumeMetadata{Protocol: ProtocolNFS, Server: server})
+	setParentExportContext(volumeContext, share.Path, name)
+
+	klog.Infof("Created directory volume %s in %s", name, share.Path)
+	timer.ObserveSuccess()
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volume.volumeID(),
+			CapacityBytes: capacity,
+			VolumeContext: volumeContext,
+		},
+	}, nil
+}
+
+// validateSubdirVolumeRequest rejects StorageClass options that need a dataset per volume.
+func validateSubdirVolumeRequest(req *csi.CreateVolumeRequest, protocol string) error {
+	params := req.GetParameters()
+	switch {
+	case params[VolumeTypeParam] != VolumeTypeSubdir:
+		return status.Errorf(codes.InvalidArgument, "invalid %s %q: only %q is supported", VolumeTypeParam, params[VolumeTypeParam], VolumeTypeSubdir)
+	case protocol != ProtocolNFS:
+		return status.Errorf(codes.InvalidArgument, "%s=%s is only supported for NFS volumes", VolumeTypeParam, VolumeTypeSubdir)
+	case params["parentDataset"] == "":
+		return status.Errorf(codes.InvalidArgument, "parentDataset is required for %s volumes", VolumeTypeSubdir)
+	case req.GetVolumeContentSource() != nil:
+		return status.Errorf(codes.InvalidArgument, "%s volumes cannot be restored from snapshots or cloned", VolumeTypeSubdir)
+	case params[AutoGrowParam] != "":
+		return status.Errorf(codes.InvalidArgument, "%s is not supported for %s volumes (no quota)", AutoGrowParam, VolumeTypeSubdir)
+	case params[SubdirModeParam] != "" && !subdirModePattern.MatchString(params[SubdirModeParam]):
+		return status.Errorf(codes.InvalidArgument, "invalid %s %q: must be an octal mode such as 775", SubdirModeParam, params[SubdirModeParam])
+	}
+	return nil
+}
+
+// deleteSubdirVolume removes a directory volume.
+func (s *ControllerService) deleteSubdirVolume(ctx context.Context, volume subdirVolume) (*csi.DeleteVolumeResponse, error) {
+	timer := metrics.NewVolumeOperationTimer(metrics.ProtocolNFS, verbDelete)
+
+	parent, err := s.apiClient.Dataset(ctx, volume.parentDataset)
+	if err != nil && !isNotFoundError(err) {
+		timer.ObserveError()
+		return nil, status.Errorf(codes.Internal, "Failed to look up parentDataset %s: %v", volume.parentDataset, err)
+	}
+	if parent == nil || parent.Mountpoint == "" {
+		klog.V(4).Infof("Parent dataset %s of directory volume %s not found, assuming deleted", volume.parentDataset, volume.name)
+		timer.ObserveSuccess()
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	dirPath := path.Join(parent.Mountpoint, volume.name)
+	if statErr := s.apiClient.FilesystemStat(ctx, dirPath); statErr != nil {
+		if isNotFoundError(statErr) {
+			klog.V(4).Infof("Directory %s not found, assuming deleted", dirPath)
+			timer.ObserveSuccess()
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+		timer.ObserveError()
+		return nil, status.Errorf(codes.Internal, "Failed to check directory %s: %v", dirPath, statErr)
+	}
+
+	remove := s.removeSubdir
+	if remove == nil {
+		remove = s.removeSubdirOverNFS
+	}
+	if err := remove(ctx, volume.server, parent.Mountpoint, volume.name); err != nil {
+		timer.ObserveError()
+		return nil, status.Errorf(codes.Internal,
+			"Failed to remove directory %s (the controller must be allowed to mount NFS to delete %s volumes): %v",
+			dirPath, VolumeTypeSubdir, err)
+	}
+
+	klog.Infof("Deleted directory volume %s", dirPath)
+	timer.ObserveSuccess()
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// removeSubdirOverNFS mounts server:exportPath in a temporary directory and removes name from it.
+func (s *ControllerService) removeSubdirOverNFS(ctx context.Context, server, exportPath, name string) error {
+	mountDir, err := os.MkdirTemp("", "tns-csi-subdir-")
+	if err != nil {
+		return fmt.Errorf("failed to create mount directory: %w", err)
+	}
+	defer func() {
+		if err := os.Remove(mountDir); err != nil {
+			klog.Warningf("Failed to remove temporary mount directory %s: %v", mountDir, err)
+		}
+	}()
+
+	mountCtx, cancel := context.WithTimeout(ctx, s.timeouts.mount())
+	defer cancel()
+	source := nfsMountSource(selectPortalAddress(server, ""), exportPath)
+	args := []string{"-t", ProtocolNFS, "-o", mount.JoinMountOptions(getNFSMountOptions(nil)), source, mountDir}
+	if output, err := exec.CommandContext(mountCtx, "mount", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to mount %s: %w, output: %s", source, err, string(output))
+	}
+	defer func() {
+		if err := mount.Unmount(ctx, mountDir); err != nil {
+			klog.Warningf("Failed to unmount %s: %v", mountDir, err)
+		}
+	}()
+
+	return os.RemoveAll(filepath.Join(mountDir, name))
+}
+
+// getSubdirVolume reports a directory volume's health for ControllerGetVolume.
+func (s *ControllerService) getSubdirVolume(ctx context.Context, volumeID string, volume subdirVolume) (*csi.ControllerGetVolumeResponse, error) {
+	parent, err := s.apiClient.Dataset(ctx, volume.parentDataset)
+	if err != nil || parent == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %s not found: parentDataset %s is missing", volumeID, volume.parentDataset)
+	}
+
+	condition := &csi.VolumeCondition{Abnormal: false, Message: "Directory is present"}
+	if err := s.apiClient.FilesystemStat(ctx, path.Join(parent.Mountpoint, volume.name)); err != nil {
+		condition = &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("Directory %s is not accessible: %v", volume.name, err)}
+	}
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{VolumeId: volumeID},
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{VolumeCondition: condition},
+	}, nil
+}
@@ -0,0 +1,140 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseSubdirVolumeID(t *testing.T) {
+	volume := subdirVolume{server: "10.0.0.5", parentDataset: "tank/tiny", name: "pvc-1"}
+	got, ok := parseSubdirVolumeID(volume.volumeID())
+	if !ok || got != volume {
+		t.Errorf("parseSubdirVolumeID(%q) = %+v, %v", volume.volumeID(), got, ok)
+	}
+
+	for _, id := range []string{"tank/csi/pvc-1", "subdir#10.0.0.5#tank/tiny", "subdir#10.0.0.5#tank/tiny#..", "subdir##tank/tiny#pvc-1"} {
+		if _, ok := parseSubdirVolumeID(id); ok {
+			t.Errorf("parseSubdirVolumeID(%q) accepted", id)
+		}
+	}
+}
+
+func subdirTestClient(existing map[string]bool) *MockAPIClientForSnapshots {
+	return &MockAPIClientForSnapshots{
+		GetDatasetFunc: func(_ context.Context, datasetID string) (*tnsapi.Dataset, error) {
+			if datasetID != "tank/tiny" {
+				return nil, errors.New("dataset not found")
+			}
+			return &tnsapi.Dataset{ID: datasetID, Name: datasetID, Mountpoint: "/mnt/tank/tiny"}, nil
+		},
+		QueryAllNFSSharesFunc: func(_ context.Context, _ string) ([]tnsapi.NFSShare, error) {
+			return []tnsapi.NFSShare{{ID: 7, Path: "/mnt/tank/tiny", Enabled: true}}, nil
+		},
+		FilesystemStatFunc: func(_ context.Context, path string) error {
+			if !existing[path] {
+				return errors.New("[ENOENT] path not found")
+			}
+			return nil
+		},
+		FilesystemMkdirFunc: func(_ context.Context, path, _ string) error {
+			existing[path] = true
+			return nil
+		},
+	}
+}
+
+func TestSubdirVolumeLifecycle(t *testing.T) {
+	ctx := context.Background()
+	existing := map[string]bool{}
+	controller := NewControllerService(subdirTestClient(existing), NewNodeRegistry(), "")
+	var removed []string
+	controller.removeSubdir = func(_ context.Context, server, exportPath, name string) error {
+		removed = append(removed, server+":"+exportPath+"/"+name)
+		delete(existing, exportPath+"/"+name)
+		return nil
+	}
+
+	req := &csi.CreateVolumeRequest{
+		Name: "pvc-1",
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		}},
+		Parameters: map[string]string{"server": "10.0.0.5", "parentDataset": "tank/tiny", VolumeTypeParam: VolumeTypeSubdir},
+	}
+	resp, err := controller.CreateVolume(ctx, req)
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	if !existing["/mnt/tank/tiny/pvc-1"] {
+		t.Error("directory was not created")
+	}
+	volumeContext := resp.GetVolume().GetVolumeContext()
+	if volumeContext[VolumeContextKeyShare] != "/mnt/tank/tiny" || volumeContext[VolumeContextKeySubPath] != "pvc-1" {
+		t.Errorf("volume context = %v, want share /mnt/tank/tiny and subPath pvc-1", volumeContext)
+	}
+
+	// Retries return the same volume
+	again, err := controller.CreateVolume(ctx, req)
+	if err != nil || again.GetVolume().GetVolumeId() != resp.GetVolume().GetVolumeId() {
+		t.Errorf("retried CreateVolume() = %v, %v", again.GetVolume().GetVolumeId(), err)
+	}
+
+	if _, err := controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: resp.GetVolume().GetVolumeId()}); err != nil {
+		t.Fatalf("DeleteVolume() error = %v", err)
+	}
+	if _, err := controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: resp.GetVolume().GetVolumeId()}); err != nil {
+		t.Fatalf("repeated DeleteVolume() error = %v", err)
+	}
+	if len(removed) != 1 || removed[0] != "10.0.0.5:/mnt/tank/tiny/pvc-1" {
+		t.Errorf("removed = %v, want the directory removed once", removed)
+	}
+}
+
+func TestCreateSubdirVolumeValidation(t *testing.T) {
+	base := map[string]string{"parentDataset": "tank/tiny", VolumeTypeParam: VolumeTypeSubdir}
+	with := func(extra map[string]string) map[string]string {
+		params := map[string]string{}
+		for k, v := range base {
+			params[k] = v
+		}
+		for k, v := range extra {
+			params[k] = v
+		}
+		return params
+	}
+
+	tests := []struct {
+		params map[string]string
+		name   string
+	}{
+		{name: "unknown volume type", params: with(map[string]string{VolumeTypeParam: "file"})},
+		{name: "block protocol", params: with(map[string]string{"protocol": ProtocolISCSI})},
+		{name: "missing parentDataset", params: with(map[string]string{"parentDataset": ""})},
+		{name: "parentDataset does not exist", params: with(map[string]string{"parentDataset": "tank/missing"})},
+		{name: "autoGrow", params: with(map[string]string{AutoGrowParam: "20%"})},
+		{name: "invalid mode", params: with(map[string]string{SubdirModeParam: "rwx"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := NewControllerService(subdirTestClient(map[string]bool{}), NewNodeRegistry(), "")
+			_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "pvc-1",
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				}},
+				Parameters: tt.params,
+			})
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("CreateVolume() error = %v, want InvalidArgument", err)
+			}
+		})
+	}
+}
@@ -3,6 +3,9 @@ package driver
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,6 +13,8 @@ import (
 	"github.com/fenio/tns-csi/pkg/tnsapi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestControllerGetCapabilities(t *testing.T) {
@@ -70,6 +75,7 @@ func requireNotNilController(t *testing.T, v any, msg string) {
 // mockAPIClient is a mock implementation of APIClient for testing.
 type mockAPIClient struct {
 	queryPoolFunc                func(ctx context.Context, poolName string) (*tnsapi.Pool, error)
+	datasetFunc                  func(ctx context.Context, datasetID string) (*tnsapi.Dataset, error)
 	updateDatasetFunc            func(ctx context.Context, datasetID string, params tnsapi.DatasetUpdateParams) (*tnsapi.Dataset, error)
 	getDatasetWithPropertiesFunc func(ctx context.Context, datasetID string) (*tnsapi.DatasetWithProperties, error)
 }
@@ -84,7 +90,22 @@ func (m *mockAPIClient) DeleteDataset(ctx context.Context, datasetID string) err
 	return nil
 }
 
+func (m *mockAPIClient) DeleteDatasetWithOptions(ctx context.Context, datasetID string, opts tnsapi.DatasetDeleteOptions) error {
+	return nil
+}
+
+func (m *mockAPIClient) StartDatasetDelete(ctx context.Context, datasetID string, opts tnsapi.DatasetDeleteOptions) (int, error) {
+	return 0, errNotImplemented
+}
+
+func (m *mockAPIClient) RenameDataset(ctx context.Context, datasetID, newName string) error {
+	return errNotImplemented
+}
+
 func (m *mockAPIClient) Dataset(ctx context.Context, datasetID string) (*tnsapi.Dataset, error) {
+	if m.datasetFunc != nil {
+		return m.datasetFunc(ctx, datasetID)
+	}
 	return nil, errNotImplemented
 }
 
@@ -99,6 +120,10 @@ func (m *mockAPIClient) CreateNFSShare(ctx context.Context, params tnsapi.NFSSha
 	return nil, errNotImplemented
 }
 
+func (m *mockAPIClient) UpdateNFSShare(_ context.Context, _ int, _ tnsapi.NFSShareUpdateParams) (*tnsapi.NFSShare, error) {
+	return &tnsapi.NFSShare{}, nil
+}
+
 func (m *mockAPIClient) DeleteNFSShare(ctx context.Context, shareID int) error {
 	return nil
 }
@@ -131,6 +156,10 @@ func (m *mockAPIClient) FilesystemStat(ctx context.Context, path string) error {
 	return nil
 }
 
+func (m *mockAPIClient) FilesystemMkdir(ctx context.Context, path, mode string) error {
+	return nil
+}
+
 func (m *mockAPIClient) GetFilesystemACL(ctx context.Context, path string) (string, error) {
 	return "NFS4", nil
 }
@@ -139,6 +168,10 @@ func (m *mockAPIClient) SetFilesystemACL(ctx context.Context, path string) error
 	return nil
 }
 
+func (m *mockAPIClient) SetFilesystemNFS4ACL(ctx context.Context, path string, aces []tnsapi.NFS4ACE) error {
+	return nil
+}
+
 func (m *mockAPIClient) CreateZvol(ctx context.Context, params tnsapi.ZvolCreateParams) (*tnsapi.Dataset, error) {
 	return nil, errNotImplemented
 }
@@ -211,6 +244,14 @@ func (m *mockAPIClient) CloneSnapshot(ctx context.Context, params tnsapi.CloneSn
 	return nil, errNotImplemented
 }
 
+func (m *mockAPIClient) HoldSnapshot(ctx context.Context, snapshotID string) error {
+	return nil
+}
+
+func (m *mockAPIClient) ReleaseSnapshot(ctx context.Context, snapshotID string) error {
+	return nil
+}
+
 func (m *mockAPIClient) PromoteDataset(ctx context.Context, datasetID string) error {
 	return nil // Stub implementation - always succeed
 }
@@ -227,6 +268,18 @@ func (m *mockAPIClient) QueryAllNFSShares(ctx context.Context, pathPrefix string
 	return nil, nil
 }
 
+func (m *mockAPIClient) GetNFSConfig(_ context.Context) (*tnsapi.NFSConfig, error) {
+	return &tnsapi.NFSConfig{Protocols: []string{"NFSV3", "NFSV4"}}, nil
+}
+
+func (m *mockAPIClient) ListNFS4Clients(_ context.Context) ([]tnsapi.NFS4Client, error) {
+	return nil, nil
+}
+
+func (m *mockAPIClient) ExpireNFS4Client(_ context.Context, _ string) error {
+	return nil
+}
+
 func (m *mockAPIClient) QueryNVMeOFNamespaceByID(_ context.Context, _ int) (*tnsapi.NVMeOFNamespace, error) {
 	return nil, nil //nolint:nilnil // Stub - not found
 }
@@ -267,15 +320,23 @@ func (m *mockAPIClient) ClearDatasetProperties(ctx context.Context, datasetID st
 }
 
 // Replication methods for detached snapshots.
+func (m *mockAPIClient) SendSnapshot(ctx context.Context, params tnsapi.SnapshotSendParams) (io.ReadCloser, error) {
+	return nil, tnsapi.ErrStreamTransfer
+}
+
+func (m *mockAPIClient) ReceiveSnapshot(ctx context.Context, params tnsapi.SnapshotReceiveParams, stream io.Reader) error {
+	return tnsapi.ErrStreamTransfer
+}
+
 func (m *mockAPIClient) RunOnetimeReplication(ctx context.Context, params tnsapi.ReplicationRunOnetimeParams) (int, error) {
 	return 12345, nil // Stub implementation
 }
 
-func (m *mockAPIClient) GetJobStatus(ctx context.Context, jobID int) (*tnsapi.ReplicationJobState, error) {
-	return &tnsapi.ReplicationJobState{
+func (m *mockAPIClient) GetJobStatus(ctx context.Context, jobID int) (*tnsapi.Job, error) {
+	return &tnsapi.Job{
 		ID:       jobID,
-		State:    "SUCCESS",
-		Progress: map[string]interface{}{"percent": float64(100)},
+		State:    tnsapi.JobStateSuccess,
+		Progress: tnsapi.JobProgress{Percent: 100},
 	}, nil // Stub implementation
 }
 
@@ -565,6 +626,11 @@ func TestParseNFSShareCapacity(t *testing.T) {
 			comment: "CSI Volume: my-volume | Capacity: 5368709120",
 			want:    5368709120,
 		},
+		{
+			name:    "capacity followed by PVC details",
+			comment: "CSI Volume: my-volume | Capacity: 5368709120 | PVC: default/data | PV: pvc-123",
+			want:    5368709120,
+		},
 	}
 
 	for _, tt := range tests {
@@ -577,6 +643,37 @@ func TestParseNFSShareCapacity(t *testing.T) {
 	}
 }
 
+func TestWithPVCComment(t *testing.T) {
+	base := "CSI Volume: vol | Capacity: 1073741824"
+
+	if got := withPVCComment(base, "", "", "pvc-123"); got != base {
+		t.Errorf("withPVCComment() without PVC = %q, want %q", got, base)
+	}
+
+	got := withPVCComment(base, "default", "data", "pvc-123")
+	want := base + " | PVC: default/data | PV: pvc-123"
+	if got != want {
+		t.Errorf("withPVCComment() = %q, want %q", got, want)
+	}
+	if capacity := parseNFSShareCapacity(got); capacity != 1073741824 {
+		t.Errorf("parseNFSShareCapacity(withPVCComment()) = %d, want 1073741824", capacity)
+	}
+}
+
+func TestVolumeShareCommentNote(t *testing.T) {
+	got := volumeShareComment("vol", 1073741824, "default", "data", "pvc-123", "prod default/data")
+	want := "CSI Volume: vol | Capacity: 1073741824 | PVC: default/data | PV: pvc-123 | prod default/data"
+	if got != want {
+		t.Errorf("volumeShareComment() = %q, want %q", got, want)
+	}
+	if capacity := parseNFSShareCapacity(got); capacity != 1073741824 {
+		t.Errorf("parseNFSShareCapacity(volumeShareComment()) = %d, want 1073741824", capacity)
+	}
+	if got := volumeShareComment("vol", 1073741824, "", "", "", ""); got != "CSI Volume: vol | Capacity: 1073741824" {
+		t.Errorf("volumeShareComment() without note = %q", got)
+	}
+}
+
 func TestValidateCapacityCompatibility(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -620,7 +717,7 @@ func TestValidateCapacityCompatibility(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateCapacityCompatibility(tt.volumeName, tt.existingCapacity, tt.reqCapacity)
+			err := validateCapacityCompatibility(tt.volumeName, "tank/"+tt.volumeName, tt.existingCapacity, tt.reqCapacity)
 
 			if tt.wantErr {
 				if err == nil {
@@ -631,6 +728,9 @@ func TestValidateCapacityCompatibility(t *testing.T) {
 					if st.Code() != tt.wantCode {
 						t.Errorf("Expected error code %v, got %v", tt.wantCode, st.Code())
 					}
+					if !strings.Contains(st.Message(), "tank/test-vol") || !strings.Contains(st.Message(), "1Gi") || !strings.Contains(st.Message(), "2Gi") {
+						t.Errorf("error %q does not name the dataset and both sizes", st.Message())
+					}
 				}
 				return
 			}
@@ -642,18 +742,86 @@ func TestValidateCapacityCompatibility(t *testing.T) {
 	}
 }
 
+func TestProvisionedCapacity(t *testing.T) {
+	const requested = int64(1000 * 1024 * 1024)
+	const rounded = int64(1008 * 1024 * 1024) // next 16K volblocksize multiple
+
+	//nolint:govet // fieldalignment not critical for test code
+	tests := []struct {
+		name    string
+		dataset *tnsapi.Dataset
+		queried *tnsapi.DatasetWithProperties
+		want    int64
+	}{
+		{
+			name: "zvol rounded up by ZFS",
+			dataset: &tnsapi.Dataset{ID: "tank/pvc-1", Type: datasetTypeVolume,
+				Volsize: map[string]interface{}{"parsed": float64(rounded)}},
+			want: rounded,
+		},
+		{
+			name: "filesystem refquota",
+			dataset: &tnsapi.Dataset{ID: "tank/pvc-1", Type: datasetTypeFilesystem,
+				RefQuota: map[string]interface{}{"parsed": float64(requested)}},
+			want: requested,
+		},
+		{
+			name:    "create response without size is re-queried",
+			dataset: &tnsapi.Dataset{ID: "tank/pvc-1", Type: datasetTypeVolume},
+			queried: &tnsapi.DatasetWithProperties{Dataset: tnsapi.Dataset{ID: "tank/pvc-1", Type: datasetTypeVolume,
+				Volsize: map[string]interface{}{"parsed": float64(rounded)}}},
+			want: rounded,
+		},
+		{
+			name:    "size unknown falls back to requested",
+			dataset: &tnsapi.Dataset{ID: "tank/pvc-1", Type: datasetTypeFilesystem},
+			want:    requested,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockAPIClientForSnapshots{
+				GetDatasetWithPropertiesFunc: func(ctx context.Context, datasetID string) (*tnsapi.DatasetWithProperties, error) {
+					return tt.queried, nil
+				},
+			}
+			s := NewControllerService(mockClient, NewNodeRegistry(), "")
+			if got := s.provisionedCapacity(context.Background(), tt.dataset, requested); got != tt.want {
+				t.Errorf("provisionedCapacity() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZvolCapacityMatches(t *testing.T) {
+	const gib = int64(1024 * 1024 * 1024)
+	if !zvolCapacityMatches(gib, gib) {
+		t.Error("equal sizes should match")
+	}
+	if !zvolCapacityMatches(gib+16*1024, gib) {
+		t.Error("volblocksize rounding should match")
+	}
+	if zvolCapacityMatches(2*gib, gib) {
+		t.Error("larger ZVOL should not match")
+	}
+	if zvolCapacityMatches(gib, 2*gib) {
+		t.Error("smaller ZVOL should not match")
+	}
+}
+
 func TestControllerPublishVolume(t *testing.T) {
 	ctx := context.Background()
 
-	// Use plain volume ID (CSI spec compliant - under 128 bytes)
-	volumeID := "test-volume"
+	volumeID := "tank/csi/test-volume"
 
 	tests := []struct {
-		req      *csi.ControllerPublishVolumeRequest
-		nodeReg  *NodeRegistry
-		name     string
-		wantCode codes.Code
-		wantErr  bool
+		req       *csi.ControllerPublishVolumeRequest
+		nodeReg   *NodeRegistry
+		name      string
+		kubeNodes []string // Nodes in the Kubernetes cache (nil: no --kube-informers)
+		wantCode  codes.Code
+		wantErr   bool
 	}{
 		{
 			name: "successful publish",
@@ -721,6 +889,22 @@ func TestControllerPublishVolume(t *testing.T) {
 			wantErr:  true,
 			wantCode: codes.InvalidArgument,
 		},
+		{
+			name: "volume not found",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId: "tank/csi/missing-volume",
+				NodeId:   "test-node",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+				},
+			},
+			nodeReg:   NewNodeRegistry(),
+			kubeNodes: []string{"test-node"},
+			wantErr:   true,
+			wantCode:  codes.NotFound,
+		},
 		{
 			name: "node not found",
 			req: &csi.ControllerPublishVolumeRequest{
@@ -732,16 +916,87 @@ func TestControllerPublishVolume(t *testing.T) {
 					},
 				},
 			},
-			nodeReg:  NewNodeRegistry(),
+			nodeReg:   NewNodeRegistry(),
+			kubeNodes: []string{"test-node"},
+			wantErr:   true,
+			wantCode:  codes.NotFound,
+		},
+		{
+			name: "node not registered with this process",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId: volumeID,
+				NodeId:   "unknown-node",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+				},
+			},
+			nodeReg: func() *NodeRegistry {
+				r := NewNodeRegistry()
+				r.Register("test-node")
+				return r
+			}(),
 			wantErr:  true,
 			wantCode: codes.NotFound,
 		},
+		{
+			// Node plugins register in their own pods, never in the controller's registry
+			name: "node not registered with the controller",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId: volumeID,
+				NodeId:   "test-node",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+				},
+			},
+			nodeReg:   NewNodeRegistry(),
+			kubeNodes: []string{"test-node"},
+		},
+		{
+			name: "unknown node without a Node cache",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId: volumeID,
+				NodeId:   "unknown-node",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+				},
+			},
+			nodeReg: NewNodeRegistry(),
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mockClient := &mockAPIClient{}
+			mockClient := &mockAPIClient{
+				getDatasetWithPropertiesFunc: func(_ context.Context, id string) (*tnsapi.DatasetWithProperties, error) {
+					if id != volumeID {
+						return nil, nil //nolint:nilnil // not found
+					}
+					return &tnsapi.DatasetWithProperties{
+						Dataset: tnsapi.Dataset{ID: volumeID, Name: volumeID},
+						UserProperties: map[string]tnsapi.UserProperty{
+							tnsapi.PropertyManagedBy:     {Value: tnsapi.ManagedByValue},
+							tnsapi.PropertyProtocol:      {Value: tnsapi.ProtocolNFS},
+							tnsapi.PropertyCSIVolumeName: {Value: volumeID},
+						},
+					}, nil
+				},
+			}
 			service := NewControllerService(mockClient, tt.nodeReg, "")
+			if tt.kubeNodes != nil {
+				kube := fake.NewClientset()
+				for _, name := range tt.kubeNodes {
+					if err := kube.Tracker().Add(testNode(name, "10.0.0.1", corev1.ConditionTrue)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				service.kubeView = newTestClusterView(t, kube)
+			}
 
 			_, err := service.ControllerPublishVolume(ctx, tt.req)
 
@@ -1053,6 +1308,38 @@ func TestControllerExpandVolume(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "expansion beyond recorded maxSize",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId:      nfsVolumeID,
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 20 * 1024 * 1024 * 1024},
+			},
+			mockSetup: func(m *MockAPIClientForSnapshots) {
+				m.FindDatasetByCSIVolumeNameFunc = func(ctx context.Context, prefix, volumeName string) (*tnsapi.DatasetWithProperties, error) {
+					return &tnsapi.DatasetWithProperties{
+						Dataset: tnsapi.Dataset{ID: "tank/csi/" + nfsVolumeID, Name: "tank/csi/" + nfsVolumeID},
+						UserProperties: map[string]tnsapi.UserProperty{
+							tnsapi.PropertyManagedBy: {Value: tnsapi.ManagedByValue},
+							tnsapi.PropertyProtocol:  {Value: tnsapi.ProtocolNFS},
+						},
+					}, nil
+				}
+				m.GetDatasetWithPropertiesFunc = func(ctx context.Context, datasetID string) (*tnsapi.DatasetWithProperties, error) {
+					return &tnsapi.DatasetWithProperties{
+						Dataset: tnsapi.Dataset{ID: datasetID, Type: datasetTypeFilesystem},
+						UserProperties: map[string]tnsapi.UserProperty{
+							tnsapi.PropertyMaxSize: {Value: "10737418240"}, // 10Gi
+						},
+					}, nil
+				}
+				m.UpdateDatasetFunc = func(ctx context.Context, datasetID string, params tnsapi.DatasetUpdateParams) (*tnsapi.Dataset, error) {
+					t.Error("dataset must not be expanded beyond maxSize")
+					return &tnsapi.Dataset{ID: datasetID}, nil
+				}
+			},
+			wantErr:  true,
+			wantCode: codes.InvalidArgument,
+		},
 		{
 			name: "NVMe-oF expansion - NodeExpansionRequired should be true",
 			req: &csi.ControllerExpandVolumeRequest{
@@ -1187,6 +1474,7 @@ func TestGetCapacity(t *testing.T) {
 						Capacity struct {
 							Parsed int64 `json:"parsed"`
 						} `json:"capacity"`
+						Dedupratio map[string]interface{} `json:"dedupratio,omitempty"`
 					}{
 						Size: struct {
 							Parsed int64 `json:"parsed"`
@@ -1288,6 +1576,57 @@ func TestGetCapacity(t *testing.T) {
 	}
 }
 
+func TestOperationLimiter(t *testing.T) {
+	var unlimited *operationLimiter
+	release, err := unlimited.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("nil limiter acquire() error = %v", err)
+	}
+	release()
+
+	if newOperationLimiter(opClassProvision, 0) != nil {
+		t.Error("newOperationLimiter(0) should not limit")
+	}
+
+	limiter := newOperationLimiter(opClassProvision, 1)
+	release, err = limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.acquire(ctx); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("acquire() over the limit: got %v, want DeadlineExceeded", err)
+	}
+
+	release()
+	release, err = limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire() after release error = %v", err)
+	}
+	release()
+}
+
+func TestCreateVolumeConcurrencyLimit(t *testing.T) {
+	service := NewControllerService(&mockAPIClient{}, NewNodeRegistry(), "")
+	service.provisionLimit = newOperationLimiter(opClassProvision, 1)
+
+	// Hold the only slot: CreateVolume must wait instead of reaching the storage system
+	release, err := service.provisionLimit.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = service.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "pvc-limited"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("CreateVolume() with no free slot: got %v, want DeadlineExceeded", err)
+	}
+}
+
 func TestNodeRegistryUnregisterAndCount(t *testing.T) {
 	t.Run("basic operations", func(t *testing.T) {
 		registry := NewNodeRegistry()
@@ -1777,6 +2116,108 @@ func TestListVolumes(t *testing.T) {
 	}
 }
 
+// pagingMockClient adds server-side dataset paging to the snapshot mock.
+type pagingMockClient struct {
+	*MockAPIClientForSnapshots
+	datasets []tnsapi.DatasetWithProperties // sorted by ID
+	calls    int
+}
+
+func (m *pagingMockClient) QueryDatasetsPageAfter(_ context.Context, afterID string, limit int) ([]tnsapi.DatasetWithProperties, error) {
+	m.calls++
+	var page []tnsapi.DatasetWithProperties
+	for _, ds := range m.datasets {
+		if ds.ID > afterID && len(page) < limit {
+			page = append(page, ds)
+		}
+	}
+	return page, nil
+}
+
+func pagedTestDataset(id string, managed bool) tnsapi.DatasetWithProperties {
+	ds := tnsapi.DatasetWithProperties{Dataset: tnsapi.Dataset{ID: id, Name: id}}
+	if managed {
+		ds.UserProperties = map[string]tnsapi.UserProperty{
+			tnsapi.PropertyManagedBy:    {Value: tnsapi.ManagedByValue},
+			tnsapi.PropertyProtocol:     {Value: tnsapi.ProtocolNFS},
+			tnsapi.PropertyNFSShareID:   {Value: "1"},
+			tnsapi.PropertyNFSSharePath: {Value: "/mnt/" + id},
+		}
+	}
+	return ds
+}
+
+func TestListVolumesPaged(t *testing.T) {
+	ctx := context.Background()
+
+	var datasets []tnsapi.DatasetWithProperties
+	datasets = append(datasets, pagedTestDataset("tank/csi", false))
+	for i := 0; i < 1200; i++ {
+		datasets = append(datasets, pagedTestDataset(fmt.Sprintf("tank/csi/pvc-%04d", i), true))
+	}
+	mock := &pagingMockClient{MockAPIClientForSnapshots: &MockAPIClientForSnapshots{}, datasets: datasets}
+	service := NewControllerService(mock, NewNodeRegistry(), "")
+
+	// Walk all pages and make sure every volume is returned exactly once
+	seen := make(map[string]bool)
+	token := ""
+	pages := 0
+	for {
+		resp, err := service.ListVolumes(ctx, &csi.ListVolumesRequest{MaxEntries: 250, StartingToken: token})
+		if err != nil {
+			t.Fatalf("ListVolumes() error = %v", err)
+		}
+		pages++
+		if len(resp.Entries) > 250 {
+			t.Fatalf("page %d has %d entries, want at most 250", pages, len(resp.Entries))
+		}
+		for _, e := range resp.Entries {
+			if seen[e.Volume.VolumeId] {
+				t.Fatalf("volume %s returned twice", e.Volume.VolumeId)
+			}
+			seen[e.Volume.VolumeId] = true
+		}
+		if resp.NextToken == "" {
+			break
+		}
+		token = resp.NextToken
+	}
+	if len(seen) != 1200 {
+		t.Errorf("listed %d volumes, want 1200", len(seen))
+	}
+	if pages != 5 {
+		t.Errorf("got %d pages, want 5", pages)
+	}
+
+	// Without max_entries everything is streamed in bounded server-side pages
+	mock.calls = 0
+	resp, err := service.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatalf("ListVolumes() error = %v", err)
+	}
+	if len(resp.Entries) != 1200 || resp.NextToken != "" {
+		t.Errorf("got %d entries (next token %q), want 1200 and no token", len(resp.Entries), resp.NextToken)
+	}
+	if mock.calls != 3 {
+		t.Errorf("got %d page queries, want 3", mock.calls)
+	}
+
+	// A token whose volume was deleted in the meantime still resumes the listing
+	resp, err = service.ListVolumes(ctx, &csi.ListVolumesRequest{MaxEntries: 10, StartingToken: "tank/csi/pvc-0099x"})
+	if err != nil {
+		t.Fatalf("ListVolumes() with stale token error = %v", err)
+	}
+	if len(resp.Entries) == 0 || resp.Entries[0].Volume.VolumeId != "tank/csi/pvc-0100" {
+		t.Errorf("stale token did not resume after it: %+v", resp.Entries)
+	}
+
+	// Tokens that are not dataset paths are rejected
+	_, err = service.ListVolumes(ctx, &csi.ListVolumesRequest{StartingToken: "garbage"})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("invalid token error = %v, want Aborted", err)
+	}
+}
+
 func TestControllerGetVolume(t *testing.T) {
 	ctx := context.Background()
 
@@ -1851,7 +2292,7 @@ func TestControllerGetVolume(t *testing.T) {
 					}
 					return nil, nil //nolint:nilnil // not found
 				}
-				// Mock Dataset() for getNFSVolumeInfo health check
+				// Mock Dataset() for the NFS Describe health check
 				m.GetDatasetFunc = func(ctx context.Context, datasetID string) (*tnsapi.Dataset, error) {
 					return &tnsapi.Dataset{
 						ID:         "tank/csi/" + nfsVolumeID,
@@ -1918,7 +2359,7 @@ func TestControllerGetVolume(t *testing.T) {
 					}
 					return nil, nil //nolint:nilnil // not found
 				}
-				// Mock Dataset() for getNFSVolumeInfo health check - returns error to simulate missing dataset
+				// Mock Dataset() for the NFS Describe health check - returns error to simulate missing dataset
 				m.GetDatasetFunc = func(ctx context.Context, datasetID string) (*tnsapi.Dataset, error) {
 					return nil, errors.New("dataset not found")
 				}
@@ -1972,7 +2413,7 @@ func TestControllerGetVolume(t *testing.T) {
 					}
 					return nil, nil //nolint:nilnil // not found
 				}
-				// Mock Dataset() for getNFSVolumeInfo health check
+				// Mock Dataset() for the NFS Describe health check
 				m.GetDatasetFunc = func(ctx context.Context, datasetID string) (*tnsapi.Dataset, error) {
 					return &tnsapi.Dataset{
 						ID:         "tank/csi/" + nfsVolumeID,
@@ -2942,3 +3383,31 @@ func TestValidateVolumeCapabilities_ProtocolAware(t *testing.T) {
 		})
 	}
 }
+
+func (m *mockAPIClient) NVMeOFHostByNQN(_ context.Context, _ string) (*tnsapi.NVMeOFHost, error) {
+	return nil, errNotImplemented
+}
+
+func (m *mockAPIClient) CreateNVMeOFHost(_ context.Context, _ string) (*tnsapi.NVMeOFHost, error) {
+	return nil, errNotImplemented
+}
+
+func (m *mockAPIClient) QueryNVMeOFHostSubsystems(_ context.Context, _ int) ([]tnsapi.NVMeOFHostSubsystem, error) {
+	return nil, errNotImplemented
+}
+
+func (m *mockAPIClient) AddHostToSubsystem(_ context.Context, _, _ int) error {
+	return errNotImplemented
+}
+
+func (m *mockAPIClient) RemoveHostFromSubsystem(_ context.Context, _ int) error {
+	return errNotImplemented
+}
+
+func (m *mockAPIClient) CreateISCSIInitiator(_ context.Context, _ tnsapi.ISCSIInitiatorCreateParams) (*tnsapi.ISCSIInitiator, error) {
+	return nil, errNotImplemented
+}
+
+func (m *mockAPIClient) UpdateISCSITargetGroups(_ context.Context, _ int, _ []tnsapi.ISCSITargetGroup) (*tnsapi.ISCSITarget, error) {
+	return nil, errNotImplemented
+}
@@ -0,0 +1,117 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// credentialPollInterval is how often the API key file is checked for changes by default.
+// Kubernetes updates mounted secrets atomically via symlink swap, so polling the
+// file contents is more reliable than watching inode events.
+const credentialPollInterval = 30 * time.Second
+
+// ErrAPIKeyFileEmpty is returned when the API key file exists but contains no key.
+var ErrAPIKeyFileEmpty = errors.New("API key file is empty")
+
+// apiKeyUpdater is implemented by API clients that support re-authenticating
+// an existing session with a rotated API key.
+type apiKeyUpdater interface {
+	UpdateAPIKey(ctx context.Context, apiKey string) error
+}
+
+// ReadAPIKeyFile reads and trims an API key from a mounted secret file.
+func ReadAPIKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // G304: path is provided by the operator via --api-key-file
+	if err != nil {
+		return "", fmt.Errorf("failed to read API key file %s: %w", path, err)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("%w: %s", ErrAPIKeyFileEmpty, path)
+	}
+	return key, nil
+}
+
+// apiKeySource returns the source the API key is re-fetched from, or nil if it is static.
+func (d *Driver) apiKeySource() APIKeySource {
+	if d.config.APIKeySource != nil {
+		return d.config.APIKeySource
+	}
+	if d.config.APIKeyFile != "" {
+		return FileAPIKeySource{Path: d.config.APIKeyFile}
+	}
+	return nil
+}
+
+// watchCredentials re-fetches the API key from source periodically and when the process
+// receives SIGHUP.
+func (d *Driver) watchCredentials(source APIKeySource, stopCh <-chan struct{}) {
+	updater, ok := d.apiClient.(apiKeyUpdater)
+	if !ok {
+		klog.Warningf("API client does not support credential reload, ignoring changes of the API key in %s", source)
+		return
+	}
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	interval := durationOrDefault(d.config.APIKeyRefreshInterval, credentialPollInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	klog.Infof("Re-reading the API key from %s every %v (send SIGHUP to force reload)", source, interval)
+
+	for {
+		select {
+		case <-ticker.C:
+			d.reloadCredentials(source, updater, false)
+		case <-sighup:
+			klog.Info("Received SIGHUP, reloading API key")
+			d.reloadCredentials(source, updater, true)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// reloadCredentials re-fetches the API key and re-authenticates the client if the key changed.
+// Errors are logged rather than returned: the client keeps working with the previous key.
+func (d *Driver) reloadCredentials(source APIKeySource, updater apiKeyUpdater, forced bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	key, err := source.FetchAPIKey(ctx)
+	if err != nil {
+		klog.Errorf("Credential reload skipped: %v", err)
+		return
+	}
+
+	if key == d.currentAPIKey() && !forced {
+		return
+	}
+
+	if err := updater.UpdateAPIKey(ctx, key); err != nil {
+		klog.Errorf("Failed to apply rotated API key, continuing with previous key: %v", err)
+		return
+	}
+
+	d.credMu.Lock()
+	d.config.APIKey = key
+	d.credMu.Unlock()
+}
+
+// currentAPIKey returns the API key the client was last authenticated with.
+func (d *Driver) currentAPIKey() string {
+	d.credMu.Lock()
+	defer d.credMu.Unlock()
+	return d.config.APIKey
+}
@@ -0,0 +1,180 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Deletion guard.
+//
+// Volumes and detached snapshots are destroyed recursively and with force, so a lookup that
+// resolves to the wrong dataset would take unrelated data with it. Before anything is torn
+// down, the dataset is re-read from TrueNAS (bypassing the volume metadata cache) and must
+// carry tns-csi:managed_by and, if it records one, the cluster ID of this driver. Otherwise
+// the deletion fails with FailedPrecondition and nothing is touched. --allow-unmanaged-delete
+// turns the check into a warning, for cleaning up volumes whose properties were lost.
+//
+// Child datasets and snapshots are destroyed with the volume by default. A StorageClass with
+// recursiveDelete: "false" records the opt-out on the dataset; DeleteVolume then fails with
+// FailedPrecondition listing the child datasets and snapshots that block it, and the dataset
+// is finally deleted without recursion or force so ZFS refuses anything created in between.
+
+// RecursiveDeleteParam is the StorageClass parameter that disables deleting child datasets
+// and snapshots together with a volume.
+const RecursiveDeleteParam = "recursiveDelete"
+
+// maxListedBlockers bounds the child datasets and snapshots named in a DeleteVolume error.
+const maxListedBlockers = 10
+
+// unmanagedReason returns why a dataset with the given ownership properties must not be
+// deleted by this driver, or "" if it may.
+func unmanagedReason(managedBy, owner, clusterID string) string {
+	if managedBy != tnsapi.ManagedByValue {
+		return fmt.Sprintf("it is not managed by tns-csi (%s=%q)", tnsapi.PropertyManagedBy, managedBy)
+	}
+	if owner != "" && owner != clusterID {
+		return fmt.Sprintf("it belongs to cluster %q, not %q", owner, clusterID)
+	}
+	return ""
+}
+
+// guardDelete returns a FailedPrecondition error if reason is set and unmanaged deletes are not allowed.
+func (s *ControllerService) guardDelete(datasetID, reason string) error {
+	if reason == "" {
+		return nil
+	}
+	if s.allowUnmanagedDelete {
+		klog.Warningf("Deleting dataset %s although %s (--allow-unmanaged-delete)", datasetID, reason)
+		return nil
+	}
+	klog.Errorf("Refusing to delete dataset %s: %s", datasetID, reason)
+	return status.Errorf(codes.FailedPrecondition,
+		"refusing to delete dataset %s: %s; start the controller with --allow-unmanaged-delete to override", datasetID, reason)
+}
+
+// checkVolumeDeletable verifies that the dataset of a volume may be deleted by this driver.
+// A dataset that no longer exists passes, as the teardown is idempotent.
+func (s *ControllerService) checkVolumeDeletable(ctx context.Context, meta *VolumeMetadata) error {
+	if meta.DatasetID == "" {
+		return nil
+	}
+	dataset, err := s.apiClient.GetDatasetWithProperties(ctx, meta.DatasetID)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		if s.allowUnmanagedDelete {
+			klog.Warningf("Cannot verify ownership of dataset %s, deleting anyway (--allow-unmanaged-delete): %v", meta.DatasetID, err)
+			return nil
+		}
+		return status.Errorf(codes.Unavailable, "cannot verify ownership of dataset %s: %v; will retry with backoff", meta.DatasetID, err)
+	}
+	if dataset == nil {
+		return nil
+	}
+	prop := func(name string) string {
+		if p, ok := dataset.UserProperties[name]; ok {
+			return p.Value
+		}
+		return ""
+	}
+	if err := s.guardDelete(meta.DatasetID, unmanagedReason(prop(tnsapi.PropertyManagedBy), prop(tnsapi.PropertyClusterID), s.clusterID)); err != nil {
+		return err
+	}
+
+	if prop(tnsapi.PropertyRecursiveDelete) != "false" || prop(tnsapi.PropertyDeleteStrategy) == tnsapi.DeleteStrategyRetain {
+		return nil
+	}
+	meta.NonRecursive = true
+	return s.checkNoDeleteBlockers(ctx, meta)
+}
+
+// parseRecursiveDelete returns the recursiveDelete StorageClass parameter (default true).
+func parseRecursiveDelete(params map[string]string) (bool, error) {
+	switch params[RecursiveDeleteParam] {
+	case "", VolumeContextValueTrue:
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be true or false", RecursiveDeleteParam, params[RecursiveDeleteParam])
+}
+
+// recordRecursiveDelete stores a recursiveDelete opt-out on a newly provisioned volume's dataset.
+func (s *ControllerService) recordRecursiveDelete(ctx context.Context, datasetID string, params map[string]string) error {
+	if recursive, _ := parseRecursiveDelete(params); recursive {
+		return nil
+	}
+	if err := s.apiClient.SetDatasetProperties(ctx, datasetID, map[string]string{tnsapi.PropertyRecursiveDelete: "false"}); err != nil {
+		return status.Errorf(codes.Internal, "Failed to record %s on %s: %v", RecursiveDeleteParam, datasetID, err)
+	}
+	return nil
+}
+
+// checkNoDeleteBlockers fails with FailedPrecondition if the dataset of a volume has child
+// datasets or snapshots, naming them.
+func (s *ControllerService) checkNoDeleteBlockers(ctx context.Context, meta *VolumeMetadata) error {
+	datasets, err := s.apiClient.QueryAllDatasets(ctx, meta.DatasetID+"/")
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "cannot list child datasets of %s: %v; will retry with backoff", meta.DatasetID, err)
+	}
+	snapshots, err := s.apiClient.QuerySnapshotIDs(ctx, []interface{}{[]interface{}{verbDataset, "=", meta.DatasetID}})
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "cannot list snapshots of %s: %v; will retry with backoff", meta.DatasetID, err)
+	}
+
+	var blockers []string
+	for i := range datasets {
+		if strings.HasPrefix(datasets[i].ID, meta.DatasetID+"/") {
+			blockers = append(blockers, datasets[i].ID)
+		}
+	}
+	blockers = append(blockers, snapshots...)
+	if len(blockers) == 0 {
+		return nil
+	}
+
+	listed := blockers
+	if len(listed) > maxListedBlockers {
+		listed = append(listed[:maxListedBlockers:maxListedBlockers], fmt.Sprintf("and %d more", len(blockers)-maxListedBlockers))
+	}
+	klog.Warningf("Not deleting volume %s: dataset %s has %d child datasets/snapshots and %s=false", meta.Name, meta.DatasetID, len(blockers), RecursiveDeleteParam)
+	return status.Errorf(codes.FailedPrecondition,
+		"cannot delete volume %s: %s=false and dataset %s has child datasets or snapshots: %s; remove them first",
+		meta.Name, RecursiveDeleteParam, meta.DatasetID, strings.Join(listed, ", "))
+}
+
+// deleteVolumeDataset deletes the dataset of a volume, recursively and with force unless the
+// volume opted out with recursiveDelete=false. Clones of the dataset's snapshots are promoted
+// first, since ZFS refuses to destroy their origin. Large volumes are deleted in the background
+// when --async-delete-min-size is set (see controller_delete_async.go).
+func (s *ControllerService) deleteVolumeDataset(ctx context.Context, meta *VolumeMetadata) error {
+	if err := s.promoteDependentClones(ctx, meta.DatasetID); err != nil {
+		return err
+	}
+	if meta.NonRecursive {
+		return s.apiClient.DeleteDatasetWithOptions(ctx, meta.DatasetID, tnsapi.DatasetDeleteOptions{})
+	}
+	if s.asyncDeleteMinSize > 0 {
+		if started, err := s.startAsyncDelete(ctx, meta); started || err != nil {
+			return err
+		}
+	}
+	return s.apiClient.DeleteDataset(ctx, meta.DatasetID)
+}
+
+// deleteVolumeSnapshots removes the non-CSI snapshots blocking the deletion of a volume's
+// dataset. Volumes with recursiveDelete=false keep them, so the deletion fails instead.
+func (s *ControllerService) deleteVolumeSnapshots(ctx context.Context, meta *VolumeMetadata) {
+	if meta.NonRecursive {
+		klog.Infof("Keeping snapshots of %s (%s=false)", meta.DatasetID, RecursiveDeleteParam)
+		return
+	}
+	s.deleteDatasetSnapshots(ctx, meta.DatasetID)
+}
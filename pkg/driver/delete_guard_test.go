@@ -0,0 +1,79 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnmanagedReason(t *testing.T) {
+	tests := []struct {
+		name      string
+		managedBy string
+		owner     string
+		clusterID string
+		want      string
+	}{
+		{name: "managed", managedBy: tnsapi.ManagedByValue},
+		{name: "managed by this cluster", managedBy: tnsapi.ManagedByValue, owner: "prod", clusterID: "prod"},
+		{name: "not managed", want: "not managed by tns-csi"},
+		{name: "other manager", managedBy: "democratic-csi", want: `"democratic-csi"`},
+		{name: "other cluster", managedBy: tnsapi.ManagedByValue, owner: "staging", clusterID: "prod", want: `cluster "staging"`},
+		{name: "cluster ID unset on driver", managedBy: tnsapi.ManagedByValue, owner: "staging", want: `cluster "staging"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unmanagedReason(tt.managedBy, tt.owner, tt.clusterID)
+			if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+				t.Errorf("unmanagedReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteVolumeRefusesForeignDataset(t *testing.T) {
+	owner := "staging"
+	client := &mockAPIClient{
+		getDatasetWithPropertiesFunc: func(_ context.Context, datasetID string) (*tnsapi.DatasetWithProperties, error) {
+			return &tnsapi.DatasetWithProperties{
+				Dataset: tnsapi.Dataset{ID: datasetID, Name: datasetID},
+				UserProperties: map[string]tnsapi.UserProperty{
+					tnsapi.PropertyManagedBy: {Value: tnsapi.ManagedByValue},
+					tnsapi.PropertyProtocol:  {Value: ProtocolNFS},
+					tnsapi.PropertyClusterID: {Value: owner},
+				},
+			}, nil
+		},
+	}
+	s := NewControllerService(client, NewNodeRegistry(), "prod")
+
+	_, err := s.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "tank/csi/pvc-1"})
+	if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "--allow-unmanaged-delete") {
+		t.Fatalf("DeleteVolume() error = %v, want FailedPrecondition naming --allow-unmanaged-delete", err)
+	}
+
+	meta := &VolumeMetadata{Name: "tank/csi/pvc-1", DatasetID: "tank/csi/pvc-1"}
+	s.allowUnmanagedDelete = true
+	if err := s.checkVolumeDeletable(context.Background(), meta); err != nil {
+		t.Errorf("checkVolumeDeletable() with --allow-unmanaged-delete error = %v", err)
+	}
+
+	s.allowUnmanagedDelete = false
+	owner = "prod"
+	if err := s.checkVolumeDeletable(context.Background(), meta); err != nil {
+		t.Errorf("checkVolumeDeletable() of own dataset error = %v", err)
+	}
+
+	client.getDatasetWithPropertiesFunc = func(context.Context, string) (*tnsapi.DatasetWithProperties, error) {
+		return nil, errors.New("connection reset")
+	}
+	if err := s.checkVolumeDeletable(context.Background(), meta); status.Code(err) != codes.Unavailable {
+		t.Errorf("checkVolumeDeletable() on lookup failure error = %v, want Unavailable", err)
+	}
+}
@@ -7,7 +7,8 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"strings"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -27,25 +28,99 @@ type Config struct {
 	Endpoint                  string
 	APIURL                    string
 	APIKey                    string
-	MetricsAddr               string // Address to expose Prometheus metrics (e.g., ":8080")
-	DashboardAddr             string // Address for in-cluster dashboard (e.g., ":9090", empty = disabled)
-	DashboardPool             string // ZFS pool for unmanaged volume discovery in dashboard
-	ClusterID                 string // Unique identifier for this cluster (for multi-cluster TrueNAS sharing)
-	TestMode                  bool   // Enable test mode for sanity tests (skips actual mounts)
-	SkipTLSVerify             bool   // Skip TLS certificate verification (for self-signed certs)
-	EnableNVMeDiscovery       bool   // Run nvme discover before nvme connect (default: false)
-	MaxConcurrentNVMeConnects int    // Max concurrent NVMe-oF connect operations per node (default: 5)
+	NoStorageAPI              bool          // Run without a storage API client: block volume sizes come from the volume context (node only)
+	APIKeyFile                string        // Path to a mounted secret file with the API key (enables reload on rotation)
+	APIKeySource              APIKeySource  // Source the API key is re-fetched from (nil = APIKeyFile, if set)
+	APIKeyRefreshInterval     time.Duration // How often the API key is re-fetched (0 = every 30s)
+	ProxyURL                  string        // Explicit proxy for the storage API connection (empty = honor HTTPS_PROXY/NO_PROXY)
+	MetricsAddr               string        // Address to expose Prometheus metrics (e.g., ":8080")
+	DebugAddr                 string        // Loopback address of the node state debug endpoint (node only, empty = disabled)
+	DashboardAddr             string        // Address for in-cluster dashboard (e.g., ":9090", empty = disabled)
+	DashboardPool             string        // ZFS pool for unmanaged volume discovery in dashboard
+	AdminAddr                 string        // Address of the authenticated admin API (controller only, e.g., ":9091", empty = disabled)
+	AdminTLSCertFile          string        // Certificate the admin API is served with (required with AdminAddr)
+	AdminTLSKeyFile           string        // Private key of AdminTLSCertFile
+	DumpAPISchema             bool          // Log an annotated schema of the first NVMe-oF namespace and port binding query responses
+	ClusterID                 string        // Unique identifier for this cluster (for multi-cluster TrueNAS sharing)
+	TestMode                  bool          // Enable test mode for sanity tests (skips actual mounts)
+	SkipTLSVerify             bool          // Skip TLS certificate verification (for self-signed certs)
+	EnableNVMeDiscovery       bool          // Run nvme discover before nvme connect (default: false)
+	MaxConcurrentNVMeConnects int           // Max concurrent NVMe-oF connect operations per node (default: 5)
+	MaxConcurrentProvisions   int           // Max concurrent CreateVolume operations (controller only, 0 = unlimited)
+	MaxConcurrentSnapshots    int           // Max concurrent CreateSnapshot/DeleteSnapshot operations (controller only, 0 = unlimited)
+	MaxConcurrentDeletes      int           // Max concurrent DeleteVolume operations (controller only, 0 = unlimited)
+	AsyncDeleteMinSize        string        // Used space from which volumes are deleted in the background, e.g. "500Gi" (controller only, empty = disabled)
+	AtomicCreate              bool          // Create volume datasets under a staging name and rename them into place once configured (controller only)
+	NFS4ClientExpiry          bool          // Expire NotReady nodes' NFSv4 clients through nfsd's procfs files (controller only)
+	HostAccessControl         bool          // Allow block volumes only to the nodes they are published to (controller only)
+	KubeInformers             bool          // Cache PVs, PVCs and VolumeSnapshotContents with informers when running in-cluster (controller only)
+	DefaultVolumeSize         string        // Size of volumes requested without one, e.g. "10Gi" (controller only, empty = 1Gi)
+	CapacityRounding          string        // Capacity rounding mode: none, gib or volblocksize (controller only, empty = none)
+	CommentTemplate           string        // Default dataset/share comment template for StorageClasses without commentTemplate (controller only)
+	DefaultZFSProperties      string        // Comma-separated name=value ZFS properties of new volumes, e.g. "compression=zstd" (controller only)
+	DefaultZFSPropertiesFile  string        // File with name=value default ZFS properties overriding DefaultZFSProperties (controller only, empty = none)
+	NodeProtocols             string        // Comma-separated protocols this node may mount (empty = auto-detect)
+	NodeStateDir              string        // Directory for state that survives node plugin restarts (node only, empty = disabled)
+	KubeletDir                string        // Kubelet data directory scanned for stale mounts (node only)
+	NFSServerMapFile          string        // File mapping old NFS server addresses to new ones (empty = none)
+	TenantDatasetMapFile      string        // File mapping PVC namespaces to parent datasets (controller only, empty = none)
+	MaintenanceDir            string        // Directory whose "enabled" and "reason" files switch maintenance mode (empty = never)
+	FeatureGates              string        // Comma-separated Name=bool feature gates, e.g. "OrphanGC=false" (empty = defaults)
+	PortalIPFamily            string        // Address family preferred in dual-stack server lists: ipv4 or ipv6 (node only, empty = first listed)
+	NFSKerberosKeytab         string        // Keytab installed on the host before Kerberos NFS mounts (node only, empty = host-managed)
+	NFSKerberosHostEtc        string        // Host /etc mounted in the node container, for the keytab and idmapd.conf (node only)
+	VolumeMountGroup          bool          // Apply pods' fsGroups to volume roots instead of kubelet (node only)
+	NsenterHostNetwork        bool          // Make storage connections in the host network namespace, for nodes without hostNetwork (node only)
+	VolumeMetadataCRD         bool          // Cache volume metadata in TNSVolume custom resources (controller only)
+	UsageAlertThresholds      string        // Comma-separated usage percentages raising PVC warning events (controller only, empty = disabled)
+	UsageAlertInterval        time.Duration
+	AutoGrow                  bool          // Expand volumes with an autoGrow StorageClass policy (controller only)
+	NodeProtocolCheck         bool          // Warn on PVCs whose protocol no node can mount (controller only)
+	ProtectSnapshotClones     bool          // Refuse to delete snapshots that copy-on-write clones depend on (controller only)
+	AllowUnmanagedDelete      bool          // Delete datasets without tns-csi ownership properties of this cluster (controller only)
+	VolumeStatsInterval       time.Duration // How often per-volume ZFS statistics are exported (controller only, 0 = disabled)
+	NVMeOFNSIDCooldown        time.Duration // Minimum time before a freed NVMe-oF NSID may be reused (controller only)
+	OrphanGCInterval          time.Duration // How often volumes without a PV are looked for; needs KubeInformers (controller only, 0 = disabled)
+	OrphanGCDeleteAfter       time.Duration // How long a volume stays orphaned before it is deleted (controller only, 0 = report only)
+	MarkRetainedAdoptable     bool          // Mark volumes of Retain PVs adoptable during orphan scans (controller only)
+	AuditInterval             time.Duration // How often Kubernetes and TrueNAS state are compared (controller only, 0 = disabled)
+	TenantQuotaSyncInterval   time.Duration // How often tenant dataset quotas follow ResourceQuotas; needs KubeInformers (controller only, 0 = disabled)
+	PreviewReapInterval       time.Duration // How often expired snapshot previews are removed (controller only, 0 = disabled)
+	VolumePopulatorSources    string        // Comma-separated datasets DatasetPopulators may copy from (controller only, empty = disabled)
+	VolumePopulatorNamespace  string        // Namespace of the volume populator's prime PVCs (controller only)
+	StaleMountCleanupInterval time.Duration // How often stale mounts are cleaned up (node only, 0 = disabled)
+	Timeouts                  Timeouts
 }
 
 // Driver is the TNS CSI driver.
 type Driver struct {
 	srv          *grpc.Server
 	metricsSrv   *http.Server
+	debugSrv     *http.Server // Node state debug endpoint (nil when disabled)
 	dashboardSrv *dashboard.Server
+	adminSrv     *http.Server // Admin API (nil when disabled)
 	apiClient    tnsapi.ClientInterface
 	controller   *ControllerService
 	node         *NodeService
 	identity     *IdentityService
+	credStopCh   chan struct{} // Stops the credential watcher (nil when the API key is static)
+	credMu       sync.Mutex    // Guards config.APIKey, which the credential watcher replaces on rotation
+	usageMonitor *usageMonitor // Volume usage alerts (nil when disabled)
+	usageStopCh  chan struct{}
+	volumeStats  *volumeStatsExporter // Per-volume ZFS statistics (nil when disabled)
+	statsStopCh  chan struct{}
+	janitor      *staleMountJanitor // Stale mount cleanup (nil when disabled)
+	deleteStopCh chan struct{}      // Stops the background deletion reconciler (nil when disabled)
+	kubeStopCh   chan struct{}      // Stops the Kubernetes informers (nil when disabled)
+	orphanStopCh chan struct{}      // Stops the orphan GC (nil when disabled)
+	auditStopCh  chan struct{}      // Stops the consistency audit (nil when disabled)
+	quotaStopCh  chan struct{}      // Stops the tenant quota sync (nil when disabled)
+	reapStopCh   chan struct{}      // Stops the snapshot preview reaper (nil when disabled)
+	popStopCh    chan struct{}      // Stops the volume populator (nil when disabled)
+	maintenance  *maintenanceMode   // Maintenance switch (nil when --maintenance-dir is not set)
+	maintStopCh  chan struct{}
+	features     FeatureGates // Feature gates (--feature-gates)
+	janitorStop  chan struct{}
 	config       Config
 	testMode     bool // Test mode flag for sanity tests
 }
@@ -55,8 +130,18 @@ func NewDriver(cfg Config) (*Driver, error) {
 	klog.V(4).Infof("Creating new driver with config: DriverName=%s, NodeID=%s, Endpoint=%s, APIURL=%s, MetricsAddr=%s, TestMode=%v, SkipTLSVerify=%v",
 		cfg.DriverName, cfg.NodeID, cfg.Endpoint, cfg.APIURL, cfg.MetricsAddr, cfg.TestMode, cfg.SkipTLSVerify)
 
+	if cfg.NoStorageAPI {
+		if err := checkStorageAPIOptions(cfg); err != nil {
+			return nil, err
+		}
+		klog.Info("Storage API disabled (--storage-api=false)")
+		return NewDriverWithClient(cfg, nil)
+	}
+
 	// Create API client
-	apiClient, err := tnsapi.NewClient(cfg.APIURL, cfg.APIKey, cfg.SkipTLSVerify)
+	apiClient, err := tnsapi.NewClient(cfg.APIURL, cfg.APIKey, cfg.SkipTLSVerify,
+		tnsapi.WithProxyURL(cfg.ProxyURL), tnsapi.WithTimeouts(cfg.Timeouts.apiTimeouts()),
+		tnsapi.WithSchemaDump(cfg.DumpAPISchema))
 	if err != nil {
 		return nil, err
 	}
@@ -75,17 +160,187 @@ func NewDriverWithClient(cfg Config, client tnsapi.ClientInterface) (*Driver, er
 		testMode:  cfg.TestMode,
 	}
 
+	features, err := ParseFeatureGates(cfg.FeatureGates)
+	if err != nil {
+		return nil, err
+	}
+	d.features = features
+	klog.Infof("Feature gates: %s", features)
+	features.export()
+
 	// Create shared node registry for both controller and node services
 	nodeRegistry := NewNodeRegistry()
 
 	// Initialize CSI services
 	d.identity = NewIdentityService(cfg.DriverName, cfg.Version)
 	d.controller = NewControllerService(client, nodeRegistry, cfg.ClusterID)
+	d.controller.timeouts = cfg.Timeouts
+	d.controller.protectSnapshotClones = cfg.ProtectSnapshotClones
+	d.controller.allowUnmanagedDelete = cfg.AllowUnmanagedDelete
+	d.controller.nvmeofNSIDCooldown = cfg.NVMeOFNSIDCooldown
+	d.controller.nfsServers = newNFSServerMap(cfg.NFSServerMapFile)
+	d.controller.tenantDatasets = newTenantDatasetMap(cfg.TenantDatasetMapFile)
+	d.maintenance = newMaintenanceMode(cfg.MaintenanceDir)
+	d.controller.maintenance = d.maintenance
+	d.controller.features = features
+	d.controller.provisionLimit = newOperationLimiter(opClassProvision, cfg.MaxConcurrentProvisions)
+	d.controller.snapshotLimit = newOperationLimiter(opClassSnapshot, cfg.MaxConcurrentSnapshots)
+	d.controller.deleteLimit = newOperationLimiter(opClassDelete, cfg.MaxConcurrentDeletes)
+	asyncDeleteMinSize, err := ParseAsyncDeleteMinSize(cfg.AsyncDeleteMinSize)
+	if err != nil {
+		return nil, err
+	}
+	d.controller.asyncDeleteMinSize = asyncDeleteMinSize
+	d.controller.atomicCreate = cfg.AtomicCreate
+	d.controller.nfs4ClientExpiry = cfg.NFS4ClientExpiry
+	d.controller.hostAccessControl = cfg.HostAccessControl
+	defaultVolumeSize, err := ParseDefaultVolumeSize(cfg.DefaultVolumeSize)
+	if err != nil {
+		return nil, err
+	}
+	d.controller.defaultVolumeSize = defaultVolumeSize
+	capacityRounding, err := ParseCapacityRounding(cfg.CapacityRounding)
+	if err != nil {
+		return nil, err
+	}
+	d.controller.capacityRounding = capacityRounding
+	zfsProps, err := ParseDefaultZFSProperties(cfg.DefaultZFSProperties)
+	if err != nil {
+		return nil, err
+	}
+	d.controller.zfsDefaults = newZFSDefaults(zfsProps, cfg.DefaultZFSPropertiesFile)
+	commentTemplate, err := ParseCommentTemplate(cfg.CommentTemplate)
+	if err != nil {
+		return nil, err
+	}
+	d.controller.commentTemplate = commentTemplate
+	d.controller.driverVersion = cfg.Version
+	if cfg.VolumeMetadataCRD {
+		cache, err := NewCRDVolumeMetadataCache(cfg.ClusterID)
+		if err != nil {
+			klog.Warningf("Volume metadata cache disabled: %v", err)
+		} else {
+			d.controller.metadataCache = cache
+			klog.Infof("Volume metadata cache enabled (%s objects)", TNSVolumeKind)
+		}
+	}
+	if cfg.KubeInformers && !cfg.TestMode {
+		view, viewErr := newClusterView(context.Background(), cfg.DriverName)
+		if viewErr != nil {
+			klog.Warningf("Kubernetes informers disabled: %v", viewErr)
+		} else {
+			d.controller.kubeView = view
+		}
+	}
+	d.controller.orphanGCDeleteAfter = cfg.OrphanGCDeleteAfter
+	d.controller.markRetainedAdoptable = cfg.MarkRetainedAdoptable
+	if cfg.NodeProtocolCheck {
+		checker, checkErr := newNodeProtocolChecker()
+		if checkErr != nil {
+			klog.Warningf("Node protocol check disabled: %v", checkErr)
+		} else {
+			checker.view = d.controller.kubeView
+			d.controller.nodeProtocols = checker
+		}
+	}
+	thresholds, err := ParseUsageAlertThresholds(cfg.UsageAlertThresholds)
+	if err != nil {
+		return nil, err
+	}
+	if len(thresholds) > 0 || cfg.AutoGrow {
+		interval := cfg.UsageAlertInterval
+		if interval <= 0 {
+			interval = DefaultUsageAlertInterval
+		}
+		monitor, monErr := newUsageMonitor(client, cfg.ClusterID, thresholds, interval, cfg.AutoGrow)
+		if monErr != nil {
+			klog.Warningf("Volume usage alerts and autoGrow disabled: %v", monErr)
+		} else {
+			monitor.view = d.controller.kubeView
+			d.usageMonitor = monitor
+		}
+	}
+	if cfg.VolumeStatsInterval > 0 && !cfg.TestMode {
+		d.volumeStats = newVolumeStatsExporter(client, cfg.ClusterID, cfg.VolumeStatsInterval)
+	}
 	d.node = NewNodeService(cfg.NodeID, client, cfg.TestMode, nodeRegistry, cfg.EnableNVMeDiscovery, cfg.MaxConcurrentNVMeConnects)
+	protocols, err := ParseNodeProtocols(cfg.NodeProtocols)
+	if err != nil {
+		return nil, err
+	}
+	d.node.protocols = protocols
+	if proxy := newHostCSIProxy(); proxy != nil && !cfg.TestMode {
+		d.node.useCSIProxy(proxy)
+	}
+	d.node.timeouts = cfg.Timeouts
+	d.node.nfsServers = newNFSServerMap(cfg.NFSServerMapFile)
+	d.node.maintenance = d.maintenance
+	d.node.features = features
+	portalIPFamily, err := ParsePortalIPFamily(cfg.PortalIPFamily)
+	if err != nil {
+		return nil, err
+	}
+	d.node.portalIPFamily = portalIPFamily
+	if cfg.NFSKerberosKeytab != "" && cfg.NFSKerberosHostEtc == "" {
+		return nil, errKeytabWithoutHostEtc
+	}
+	if cfg.AdminAddr != "" && (cfg.AdminTLSCertFile == "" || cfg.AdminTLSKeyFile == "") {
+		return nil, errAdminTLSRequired
+	}
+	if sources := parsePopulatorSources(cfg.VolumePopulatorSources); len(sources) > 0 {
+		if cfg.VolumePopulatorNamespace == "" {
+			return nil, errPopulatorNamespaceRequired
+		}
+		if !cfg.TestMode {
+			populator, popErr := newVolumePopulator(cfg.DriverName, cfg.VolumePopulatorNamespace, sources)
+			if popErr != nil {
+				klog.Warningf("Volume populator disabled: %v", popErr)
+			} else {
+				d.controller.populator = populator
+			}
+		}
+	}
+	d.node.krb5Keytab = cfg.NFSKerberosKeytab
+	d.node.krb5HostEtc = cfg.NFSKerberosHostEtc
+	d.node.volumeMountGroup = cfg.VolumeMountGroup
+	if cfg.NsenterHostNetwork {
+		d.node.hostNetNS = hostInitNetNS
+		if !cfg.TestMode {
+			checkHostNetworkNamespace()
+		}
+	}
+	if _, err := ParseDebugAddr(cfg.DebugAddr); err != nil {
+		return nil, err
+	}
+	if cfg.NodeStateDir != "" && !cfg.TestMode {
+		state, stateErr := loadNodeState(cfg.NodeStateDir)
+		if stateErr != nil {
+			klog.Warningf("NVMe-oF restart recovery disabled: %v", stateErr)
+		} else {
+			d.node.state = state
+		}
+	}
+	if cfg.StaleMountCleanupInterval > 0 && !cfg.TestMode && d.node.proxy == nil {
+		kubeletDir := cfg.KubeletDir
+		if kubeletDir == "" {
+			kubeletDir = DefaultKubeletDir
+		}
+		d.janitor = newStaleMountJanitor(d.node, kubeletDir, cfg.DriverName, cfg.StaleMountCleanupInterval)
+	}
 
 	return d, nil
 }
 
+// unixSocketPath returns the socket path of a unix:// endpoint. Windows paths are written as
+// unix:///C:/var/lib/kubelet/plugins/tns.csi.io/csi.sock, whose URL path has a slash before the
+// drive letter.
+func unixSocketPath(u *url.URL) string {
+	if len(u.Path) > 2 && u.Path[0] == '/' && u.Path[2] == ':' {
+		return filepath.FromSlash(u.Path[1:])
+	}
+	return u.Path
+}
+
 // Run starts the CSI driver.
 func (d *Driver) Run() error {
 	u, err := url.Parse(d.config.Endpoint)
@@ -95,7 +350,7 @@ func (d *Driver) Run() error {
 
 	var addr string
 	if u.Scheme == "unix" {
-		addr = u.Path
+		addr = unixSocketPath(u)
 		if removeErr := os.Remove(addr); removeErr != nil && !os.IsNotExist(removeErr) {
 			return removeErr
 		}
@@ -121,12 +376,38 @@ func (d *Driver) Run() error {
 		}()
 	}
 
+	// Serve the node state for kubectl tns-csi node-state
+	if d.config.DebugAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle(DebugStatePath, debugStateHandler(d.node, d.config.DriverName))
+		d.debugSrv = &http.Server{
+			Addr:              d.config.DebugAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			klog.Infof("Starting node state debug endpoint on %s", d.config.DebugAddr)
+			if serveErr := d.debugSrv.ListenAndServe(); serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+				klog.Errorf("Debug server error: %v", serveErr)
+			}
+		}()
+	}
+
+	// Cache Kubernetes storage objects (--kube-informers)
+	if d.controller.kubeView != nil {
+		d.kubeStopCh = make(chan struct{})
+		d.controller.kubeView.start(d.kubeStopCh)
+	}
+
 	// Start dashboard server if configured
 	if d.config.DashboardAddr != "" {
 		dashSrv, dashErr := dashboard.NewServer(d.apiClient, d.config.DashboardPool, d.config.Version, d.config.ClusterID)
 		if dashErr != nil {
 			klog.Errorf("Failed to create dashboard server: %v", dashErr)
 		} else {
+			if d.controller.kubeView != nil {
+				dashSrv.SetPVSource(d.controller.kubeView.listPVs)
+			}
 			d.dashboardSrv = dashSrv
 			go func() {
 				if serveErr := d.dashboardSrv.Start(d.config.DashboardAddr); serveErr != nil {
@@ -136,6 +417,110 @@ func (d *Driver) Run() error {
 		}
 	}
 
+	// Serve the admin API for kubectl tns-csi --controller
+	if d.config.AdminAddr != "" {
+		kube, kubeErr := newAdminKubeClient()
+		if kubeErr != nil {
+			klog.Errorf("Admin API disabled: %v", kubeErr)
+		} else {
+			mux := http.NewServeMux()
+			mux.Handle(dashboard.AdminAPIPrefix, adminAPIHandler(d.controller, kube))
+			d.adminSrv = &http.Server{
+				Addr:              d.config.AdminAddr,
+				Handler:           mux,
+				ReadHeaderTimeout: 5 * time.Second,
+			}
+			go func() {
+				klog.Infof("Starting admin API on %s (TLS)", d.config.AdminAddr)
+				if serveErr := d.adminSrv.ListenAndServeTLS(d.config.AdminTLSCertFile, d.config.AdminTLSKeyFile); serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+					klog.Errorf("Admin API server error: %v", serveErr)
+				}
+			}()
+		}
+	}
+
+	// Follow the maintenance switch (--maintenance-dir)
+	if d.maintenance != nil {
+		d.maintStopCh = make(chan struct{})
+		go d.maintenance.run(d.maintStopCh)
+	}
+
+	// Re-fetch the API key so rotated credentials are picked up without a restart
+	if source := d.apiKeySource(); source != nil && d.apiClient != nil {
+		d.credStopCh = make(chan struct{})
+		go d.watchCredentials(source, d.credStopCh)
+	}
+
+	// Watch volume usage and warn on PVCs approaching their quota
+	if d.usageMonitor != nil {
+		d.usageStopCh = make(chan struct{})
+		go d.usageMonitor.run(d.usageStopCh)
+	}
+
+	// Export per-volume ZFS statistics
+	if d.volumeStats != nil {
+		d.statsStopCh = make(chan struct{})
+		go d.volumeStats.run(d.statsStopCh)
+	}
+
+	// Finish deleting large volumes in the background (--async-delete-min-size)
+	if d.controller.asyncDeleteMinSize > 0 {
+		d.deleteStopCh = make(chan struct{})
+		go d.controller.runAsyncDeletes(d.deleteStopCh)
+	}
+
+	// Report (and with --orphan-gc-delete-after delete) volumes no PV refers to
+	if d.config.OrphanGCInterval > 0 {
+		switch {
+		case !d.features.Enabled(FeatureOrphanGC):
+			klog.Warningf("Orphan GC disabled by --feature-gates=%s=false", FeatureOrphanGC)
+		case d.controller.kubeView == nil:
+			klog.Warningf("Orphan GC disabled: it needs --kube-informers in a cluster")
+		default:
+			d.orphanStopCh = make(chan struct{})
+			go d.controller.runOrphanGC(d.config.OrphanGCInterval, d.orphanStopCh)
+		}
+	}
+
+	// Compare Kubernetes and TrueNAS state (--audit-interval)
+	if d.config.AuditInterval > 0 {
+		d.auditStopCh = make(chan struct{})
+		go d.controller.runAudit(d.config.AuditInterval, d.auditStopCh)
+	}
+
+	// Keep tenant dataset quotas equal to the namespaces' ResourceQuotas
+	if d.config.TenantDatasetMapFile != "" && d.config.TenantQuotaSyncInterval > 0 {
+		if d.controller.kubeView == nil {
+			klog.Warningf("Tenant quota sync disabled: it needs --kube-informers in a cluster")
+		} else {
+			d.quotaStopCh = make(chan struct{})
+			go d.controller.runTenantQuotaSync(d.config.TenantQuotaSyncInterval, d.quotaStopCh)
+		}
+	}
+
+	// Tear down snapshot previews once their TTL expires
+	if d.config.PreviewReapInterval > 0 && d.apiClient != nil {
+		d.reapStopCh = make(chan struct{})
+		go dashboard.RunPreviewReaper(d.apiClient, d.config.PreviewReapInterval, d.reapStopCh)
+	}
+
+	// Provision PVCs with a DatasetPopulator data source
+	if d.controller.populator != nil {
+		d.popStopCh = make(chan struct{})
+		go d.controller.populator.run(d.popStopCh)
+	}
+
+	// Unmount mounts whose device disappeared (e.g. after a storage reboot)
+	if d.janitor != nil {
+		d.janitorStop = make(chan struct{})
+		go d.janitor.run(d.janitorStop)
+	}
+
+	// Reconnect or clean up NVMe-oF sessions recorded before a plugin restart or node reboot
+	if d.node.state != nil {
+		go d.node.reconcileNVMeOFSessions(context.Background())
+	}
+
 	klog.Infof("Listening on %s://%s", u.Scheme, addr)
 	//nolint:noctx // net.Listen is acceptable here - CSI driver lifecycle is managed by gRPC server
 	listener, err := net.Listen(u.Scheme, addr)
@@ -143,15 +528,17 @@ func (d *Driver) Run() error {
 		return err
 	}
 
-	// Create gRPC server with metrics interceptor
+	// Create gRPC server with logging/metrics, panic recovery, transient error mapping and
+	// timeout interceptors (see grpc_interceptors.go)
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(d.metricsInterceptor),
+		grpc.ChainUnaryInterceptor(d.chainedInterceptors()...),
 	}
 	d.srv = grpc.NewServer(opts...)
 
 	// Register CSI services
 	csi.RegisterIdentityServer(d.srv, d.identity)
 	csi.RegisterControllerServer(d.srv, d.controller)
+	csi.RegisterGroupControllerServer(d.srv, d.controller)
 	csi.RegisterNodeServer(d.srv, d.node)
 
 	klog.Info("TNS CSI Driver is ready")
@@ -162,11 +549,80 @@ func (d *Driver) Run() error {
 func (d *Driver) Stop() {
 	klog.Info("Stopping TNS CSI Driver")
 
+	// Stop credential watcher
+	if d.credStopCh != nil {
+		close(d.credStopCh)
+		d.credStopCh = nil
+	}
+
+	// Stop maintenance switch watcher
+	if d.maintStopCh != nil {
+		close(d.maintStopCh)
+		d.maintStopCh = nil
+	}
+
+	// Stop volume usage monitor
+	if d.usageStopCh != nil {
+		close(d.usageStopCh)
+		d.usageStopCh = nil
+	}
+
+	// Stop background deletion reconciler
+	if d.deleteStopCh != nil {
+		close(d.deleteStopCh)
+		d.deleteStopCh = nil
+	}
+
+	// Stop orphan GC, consistency audit, tenant quota sync, preview reaper and Kubernetes informers
+	if d.orphanStopCh != nil {
+		close(d.orphanStopCh)
+		d.orphanStopCh = nil
+	}
+	if d.auditStopCh != nil {
+		close(d.auditStopCh)
+		d.auditStopCh = nil
+	}
+	if d.quotaStopCh != nil {
+		close(d.quotaStopCh)
+		d.quotaStopCh = nil
+	}
+	if d.reapStopCh != nil {
+		close(d.reapStopCh)
+		d.reapStopCh = nil
+	}
+	if d.popStopCh != nil {
+		close(d.popStopCh)
+		d.popStopCh = nil
+	}
+	if d.kubeStopCh != nil {
+		close(d.kubeStopCh)
+		d.kubeStopCh = nil
+	}
+
+	// Stop stale mount janitor
+	if d.statsStopCh != nil {
+		close(d.statsStopCh)
+		d.statsStopCh = nil
+	}
+	if d.janitorStop != nil {
+		close(d.janitorStop)
+		d.janitorStop = nil
+	}
+
 	// Stop dashboard server
 	if d.dashboardSrv != nil {
 		d.dashboardSrv.Stop()
 	}
 
+	// Stop admin API
+	if d.adminSrv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := d.adminSrv.Shutdown(ctx); err != nil {
+			klog.Errorf("Error shutting down admin API: %v", err)
+		}
+	}
+
 	// Stop metrics server
 	if d.metricsSrv != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -176,6 +632,15 @@ func (d *Driver) Stop() {
 		}
 	}
 
+	// Stop debug server
+	if d.debugSrv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := d.debugSrv.Shutdown(ctx); err != nil {
+			klog.Errorf("Error shutting down debug server: %v", err)
+		}
+	}
+
 	// Stop gRPC server
 	if d.srv != nil {
 		d.srv.GracefulStop()
@@ -186,29 +651,3 @@ func (d *Driver) Stop() {
 		d.apiClient.Close()
 	}
 }
-
-// metricsInterceptor intercepts gRPC calls to record metrics and log requests.
-func (d *Driver) metricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	methodParts := strings.Split(info.FullMethod, "/")
-	method := methodParts[len(methodParts)-1]
-
-	klog.V(3).Infof("GRPC call: %s", method)
-	klog.V(5).Infof("GRPC request: %+v", req)
-
-	// Start timing
-	timer := metrics.NewOperationTimer(method)
-
-	// Execute the handler
-	resp, err := handler(ctx, req)
-
-	// Record metrics
-	if err != nil {
-		klog.Errorf("GRPC error: %s returned error: %v", method, err)
-		timer.ObserveError()
-	} else {
-		klog.V(5).Infof("GRPC response: %+v", resp)
-		timer.ObserveSuccess()
-	}
-
-	return resp, err
-}
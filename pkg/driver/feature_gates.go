@@ -0,0 +1,127 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fenio/tns-csi/pkg/metrics"
+)
+
+// Feature gates.
+//
+// Subsystems that are new or risky ship behind a feature gate, so deployments can switch them
+// on or off with --feature-gates=Name=true,Other=false instead of running a different build.
+// Every gate has a maturity stage and a default: alpha features are off unless enabled, beta
+// features are on unless disabled, and GA features can still be disabled until their gate is
+// removed. Unknown gate names are rejected at startup so typos do not go unnoticed.
+
+// Feature is the name of a feature gate.
+type Feature string
+
+// Feature gates of the driver.
+const (
+	// FeatureDetachedSnapshots allows detachedSnapshots: "true" in VolumeSnapshotClasses.
+	FeatureDetachedSnapshots Feature = "DetachedSnapshots"
+	// FeatureOrphanGC allows the orphan GC of --orphan-gc-interval (controller_orphan_gc.go).
+	FeatureOrphanGC Feature = "OrphanGC"
+	// FeatureVolumeContextMigration backfills attributes of older volume contexts on
+	// NodeStageVolume (volume_context_version.go).
+	FeatureVolumeContextMigration Feature = "VolumeContextMigration"
+)
+
+// Feature maturity stages (alpha gates default to off).
+const (
+	featureBeta = "Beta"
+	featureGA   = "GA"
+)
+
+// featureSpec is the stage and default of a feature gate.
+type featureSpec struct {
+	stage   string
+	enabled bool
+}
+
+// knownFeatures are the feature gates --feature-gates accepts.
+var knownFeatures = map[Feature]featureSpec{
+	FeatureDetachedSnapshots:      {stage: featureGA, enabled: true},
+	FeatureOrphanGC:               {stage: featureBeta, enabled: true},
+	FeatureVolumeContextMigration: {stage: featureBeta, enabled: true},
+}
+
+// Static errors for feature gate parsing.
+var (
+	errInvalidFeatureGate = errors.New("invalid feature gate")
+	errUnknownFeatureGate = errors.New("unknown feature gate")
+)
+
+// FeatureGates holds the feature gates set with --feature-gates. A nil FeatureGates uses the
+// defaults of all gates.
+type FeatureGates map[Feature]bool
+
+// ParseFeatureGates parses a comma-separated "Name=bool" list such as
+// "DetachedSnapshots=true,OrphanGC=false". An empty value returns nil.
+func ParseFeatureGates(value string) (FeatureGates, error) {
+	var gates FeatureGates
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, setting, found := strings.Cut(field, "=")
+		if !found {
+			return nil, fmt.Errorf("%w %q: expected Name=true or Name=false", errInvalidFeatureGate, field)
+		}
+		feature := Feature(strings.TrimSpace(name))
+		if _, known := knownFeatures[feature]; !known {
+			return nil, fmt.Errorf("%w %q (known: %s)", errUnknownFeatureGate, feature, strings.Join(knownFeatureNames(), ", "))
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(setting))
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %w", errInvalidFeatureGate, field, err)
+		}
+		if gates == nil {
+			gates = make(FeatureGates)
+		}
+		gates[feature] = enabled
+	}
+	return gates, nil
+}
+
+// knownFeatureNames returns the names of all feature gates, sorted.
+func knownFeatureNames() []string {
+	names := make([]string, 0, len(knownFeatures))
+	for feature := range knownFeatures {
+		names = append(names, string(feature))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Enabled reports whether a feature is enabled.
+func (g FeatureGates) Enabled(feature Feature) bool {
+	if enabled, set := g[feature]; set {
+		return enabled
+	}
+	return knownFeatures[feature].enabled
+}
+
+// String lists every feature gate with its setting and stage, e.g. "OrphanGC=true (Beta)".
+func (g FeatureGates) String() string {
+	features := slices.Sorted(maps.Keys(knownFeatures))
+	settings := make([]string, 0, len(features))
+	for _, feature := range features {
+		settings = append(settings, fmt.Sprintf("%s=%t (%s)", feature, g.Enabled(feature), knownFeatures[feature].stage))
+	}
+	return strings.Join(settings, ", ")
+}
+
+// export records the setting of every feature gate in the feature_enabled metric.
+func (g FeatureGates) export() {
+	for feature := range knownFeatures {
+		metrics.SetFeatureEnabled(string(feature), knownFeatures[feature].stage, g.Enabled(feature))
+	}
+}
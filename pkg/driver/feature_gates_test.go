@@ -0,0 +1,48 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseFeatureGates(t *testing.T) {
+	gates, err := ParseFeatureGates(" OrphanGC=false, DetachedSnapshots=true ,")
+	if err != nil {
+		t.Fatalf("ParseFeatureGates() error = %v", err)
+	}
+	if gates.Enabled(FeatureOrphanGC) || !gates.Enabled(FeatureDetachedSnapshots) || !gates.Enabled(FeatureVolumeContextMigration) {
+		t.Errorf("gates = %s", gates)
+	}
+
+	if gates, err := ParseFeatureGates(""); err != nil || gates != nil {
+		t.Errorf("ParseFeatureGates(\"\") = %v, %v; want nil", gates, err)
+	}
+	var defaults FeatureGates
+	for feature, spec := range knownFeatures {
+		if defaults.Enabled(feature) != spec.enabled {
+			t.Errorf("default of %s = %v, want %v", feature, defaults.Enabled(feature), spec.enabled)
+		}
+	}
+
+	for value, want := range map[string]error{
+		"OrphanGC":        errInvalidFeatureGate,
+		"OrphanGC=maybe":  errInvalidFeatureGate,
+		"OrphanGc=false":  errUnknownFeatureGate,
+		"NoSuchGate=true": errUnknownFeatureGate,
+	} {
+		if _, err := ParseFeatureGates(value); !errors.Is(err, want) {
+			t.Errorf("ParseFeatureGates(%q) error = %v, want %v", value, err, want)
+		}
+	}
+}
+
+func TestVolumeContextMigrationGateOff(t *testing.T) {
+	node := NewNodeService("node-1", nil, true, NewNodeRegistry(), false, 1)
+	node.features = FeatureGates{FeatureVolumeContextMigration: false}
+
+	legacy := map[string]string{VolumeContextKeyServer: "truenas.local", VolumeContextKeyShare: "/mnt/tank/pvc-nfs"}
+	if got := node.migrateVolumeContext(context.Background(), "tank/pvc-nfs", legacy); contextVersion(got) != volumeContextVersionLegacy {
+		t.Errorf("context migrated with VolumeContextMigration=false: %v", got)
+	}
+}
@@ -0,0 +1,154 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"k8s.io/klog/v2"
+)
+
+// Transient error mapping.
+//
+// Most RPC handlers report storage failures as codes.Internal. The CSI sidecars treat that as
+// a final failure of the attempt, while Unavailable and ResourceExhausted tell them the
+// operation may succeed later and should be retried with backoff. transientErrorInterceptor
+// reclassifies Internal (and plain, Unknown) errors whose cause is transient, and attaches a
+// google.rpc.RetryInfo detail with the suggested delay.
+//
+//	class           code               retry after  matched by
+//	connection      Unavailable        5s           API connection lost, refused, reset, closed
+//	api-timeout     Unavailable        10s          storage API call timed out
+//	busy            Unavailable        10s          storage busy, HTTP 502/503/504, "try again"
+//	rate-limited    ResourceExhausted  30s          too many requests, rate limit
+//	capacity        ResourceExhausted  -            out of space, quota exceeded
+
+// transientErrorClass maps a class of storage failures to a gRPC code.
+type transientErrorClass struct {
+	name       string
+	substrings []string // matched case-insensitively against the error message
+	code       codes.Code
+	retryAfter time.Duration // 0 = no RetryInfo
+}
+
+// transientErrorClasses is checked in order; the first match wins.
+var transientErrorClasses = []transientErrorClass{
+	{
+		name: "connection",
+		substrings: []string{
+			strings.ToLower(tnsapi.ErrConnectionClosed.Error()),
+			strings.ToLower(tnsapi.ErrClientClosed.Error()),
+			"connection refused",
+			"connection reset",
+			"broken pipe",
+			"use of closed network connection",
+			"network is unreachable",
+			"no route to host",
+			"unexpected eof",
+			"websocket closed",
+			"failed to reconnect",
+		},
+		code:       codes.Unavailable,
+		retryAfter: 5 * time.Second,
+	},
+	{
+		name:       "api-timeout",
+		substrings: []string{"i/o timeout", "context deadline exceeded", "connection timed out"},
+		code:       codes.Unavailable,
+		retryAfter: 10 * time.Second,
+	},
+	{
+		name: "busy",
+		substrings: []string{
+			"temporarily unavailable",
+			"try again",
+			"service unavailable",
+			"bad gateway",
+			"gateway timeout",
+			"pool is busy",
+			"dataset is busy",
+			"resource busy",
+		},
+		code:       codes.Unavailable,
+		retryAfter: 10 * time.Second,
+	},
+	{
+		name:       "rate-limited",
+		substrings: []string{"too many requests", "rate limit"},
+		code:       codes.ResourceExhausted,
+		retryAfter: 30 * time.Second,
+	},
+	{
+		name:       "capacity",
+		substrings: capacityErrorSubstrings,
+		code:       codes.ResourceExhausted,
+	},
+}
+
+// classifyTransientError returns the class of a transient storage failure, or nil.
+func classifyTransientError(err error) *transientErrorClass {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, tnsapi.ErrConnectionClosed) || errors.Is(err, tnsapi.ErrClientClosed) {
+		return &transientErrorClasses[0]
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &transientErrorClasses[1]
+	}
+
+	msg := strings.ToLower(err.Error())
+	if st, ok := status.FromError(err); ok {
+		msg = strings.ToLower(st.Message())
+	}
+	for i := range transientErrorClasses {
+		class := &transientErrorClasses[i]
+		for _, substr := range class.substrings {
+			if strings.Contains(msg, strings.ToLower(substr)) {
+				return class
+			}
+		}
+	}
+	return nil
+}
+
+// transientError rewrites an Internal or Unknown error caused by a transient storage failure
+// to the class's code with RetryInfo. Other errors are returned unchanged.
+func transientError(err error) error {
+	if code := status.Code(err); code != codes.Internal && code != codes.Unknown {
+		return err
+	}
+	class := classifyTransientError(err)
+	if class == nil {
+		return err
+	}
+
+	msg := err.Error()
+	if st, ok := status.FromError(err); ok {
+		msg = st.Message()
+	}
+	klog.V(4).Infof("Reporting transient %s failure as %s: %v", class.name, class.code, err)
+	st := status.New(class.code, msg)
+	if class.retryAfter > 0 {
+		if detailed, detailErr := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(class.retryAfter)}); detailErr == nil {
+			st = detailed
+		}
+	}
+	return st.Err()
+}
+
+// transientErrorInterceptor applies transientError to every RPC error.
+func transientErrorInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return resp, transientError(err)
+	}
+	return resp, nil
+}
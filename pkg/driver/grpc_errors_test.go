@@ -0,0 +1,134 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errTestValidation = errors.New("[EINVAL] pool.dataset.create.name: invalid dataset name")
+
+func TestTransientError(t *testing.T) {
+	//nolint:govet // fieldalignment not critical for test code
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   codes.Code
+		wantRetry  time.Duration // 0 = no RetryInfo expected
+		wantSameAs bool          // error must be returned unchanged
+	}{
+		{
+			name:      "connection closed",
+			err:       status.Errorf(codes.Internal, "Failed to create dataset: %v", tnsapi.ErrConnectionClosed),
+			wantCode:  codes.Unavailable,
+			wantRetry: 5 * time.Second,
+		},
+		{
+			name:      "client closed (plain error)",
+			err:       fmt.Errorf("failed to query pools: %w", tnsapi.ErrClientClosed),
+			wantCode:  codes.Unavailable,
+			wantRetry: 5 * time.Second,
+		},
+		{
+			name:      "connection refused",
+			err:       status.Error(codes.Internal, "Failed to lookup volume: dial tcp 10.0.0.1:443: connect: connection refused"),
+			wantCode:  codes.Unavailable,
+			wantRetry: 5 * time.Second,
+		},
+		{
+			name:      "api timeout",
+			err:       status.Error(codes.Internal, "Failed to create NFS share: context deadline exceeded"),
+			wantCode:  codes.Unavailable,
+			wantRetry: 10 * time.Second,
+		},
+		{
+			name:      "storage busy",
+			err:       status.Error(codes.Internal, "Failed to delete dataset: Storage API error [EBUSY]: dataset is busy"),
+			wantCode:  codes.Unavailable,
+			wantRetry: 10 * time.Second,
+		},
+		{
+			name:      "gateway error",
+			err:       status.Error(codes.Internal, "Failed to create zvol: 503 Service Unavailable"),
+			wantCode:  codes.Unavailable,
+			wantRetry: 10 * time.Second,
+		},
+		{
+			name:      "rate limited",
+			err:       status.Error(codes.Internal, "Failed to create snapshot: 429 Too Many Requests"),
+			wantCode:  codes.ResourceExhausted,
+			wantRetry: 30 * time.Second,
+		},
+		{
+			name:     "out of space",
+			err:      status.Error(codes.Internal, "Failed to expand volume: [ENOSPC] out of space"),
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name:       "non-transient internal error",
+			err:        status.Errorf(codes.Internal, "Failed to create dataset: %v", errTestValidation),
+			wantCode:   codes.Internal,
+			wantSameAs: true,
+		},
+		{
+			name:       "other codes are kept",
+			err:        status.Error(codes.NotFound, "volume not found: connection refused"),
+			wantCode:   codes.NotFound,
+			wantSameAs: true,
+		},
+		{
+			name:       "limiter deadline is kept",
+			err:        status.Error(codes.DeadlineExceeded, "timed out waiting for a provision slot: context deadline exceeded"),
+			wantCode:   codes.DeadlineExceeded,
+			wantSameAs: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := transientError(tt.err)
+			if status.Code(got) != tt.wantCode {
+				t.Errorf("code = %s, want %s (error: %v)", status.Code(got), tt.wantCode, got)
+			}
+			if tt.wantSameAs && got != tt.err { //nolint:errorlint // identity check
+				t.Errorf("error was rewritten: %v", got)
+			}
+
+			var retry time.Duration
+			for _, detail := range status.Convert(got).Details() {
+				if info, ok := detail.(*errdetails.RetryInfo); ok {
+					retry = info.GetRetryDelay().AsDuration()
+				}
+			}
+			if retry != tt.wantRetry {
+				t.Errorf("retry delay = %v, want %v", retry, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestTransientErrorInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+
+	_, err := transientErrorInterceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return nil, status.Errorf(codes.Internal, "Failed to create dataset: %v", tnsapi.ErrConnectionClosed)
+	})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("code = %s, want Unavailable", status.Code(err))
+	}
+
+	resp, err := transientErrorInterceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+	if err != nil || resp != "ok" {
+		t.Errorf("successful call: got (%v, %v)", resp, err)
+	}
+}
@@ -0,0 +1,163 @@
+package driver
+
+import (
+	"context"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/metrics"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"k8s.io/klog/v2"
+)
+
+// gRPC server interceptors.
+//
+// Every controller and node RPC passes through, outermost first:
+//
+//	metricsInterceptor        logs the redacted request and the outcome, records latency and error metrics
+//	maintenanceInterceptor    refuses provisioning and staging requests during maintenance (maintenance.go)
+//	recoveryInterceptor       turns a panic into codes.Internal instead of crashing the plugin
+//	transientErrorInterceptor reclassifies transient storage failures (grpc_errors.go)
+//	timeoutInterceptor        bounds the RPC with its configured timeout (timeouts.go)
+//
+// Requests are logged with the fields the CSI spec marks csi_secret (StorageClass and
+// VolumeSnapshotClass secrets) replaced, so the RPC handlers do not log requests themselves.
+
+// chainedInterceptors returns the interceptors of the gRPC server in order.
+func (d *Driver) chainedInterceptors() []grpc.UnaryServerInterceptor {
+	return []grpc.UnaryServerInterceptor{d.metricsInterceptor, d.maintenanceInterceptor, recoveryInterceptor, transientErrorInterceptor, d.timeoutInterceptor}
+}
+
+// metricsInterceptor intercepts gRPC calls to record metrics and log requests.
+func (d *Driver) metricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := rpcMethodName(info.FullMethod)
+
+	klog.V(3).InfoS("GRPC call", "method", method)
+	klog.V(4).InfoS("GRPC request", "method", method, "request", redactSecrets(req))
+
+	// Start timing
+	start := time.Now()
+	timer := metrics.NewOperationTimer(method)
+
+	// Execute the handler
+	resp, err := handler(ctx, req)
+
+	// Record metrics
+	if err != nil {
+		code := status.Code(err)
+		klog.ErrorS(err, "GRPC error", "method", method, "code", code.String(), "duration", time.Since(start))
+		metrics.RecordGRPCError(method, code.String())
+		timer.ObserveError()
+	} else {
+		klog.V(5).InfoS("GRPC response", "method", method, "response", resp, "duration", time.Since(start))
+		timer.ObserveSuccess()
+	}
+
+	return resp, err
+}
+
+// recoveryInterceptor converts a panic in an RPC handler to an Internal error.
+func recoveryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			method := rpcMethodName(info.FullMethod)
+			klog.Errorf("Panic in %s: %v\n%s", method, r, debug.Stack())
+			metrics.RecordGRPCPanic(method)
+			resp = nil
+			err = status.Errorf(codes.Internal, "internal error in %s: %v", method, r)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// rpcMethodName returns the method name of a full gRPC method ("/csi.v1.Node/NodeStageVolume").
+func rpcMethodName(fullMethod string) string {
+	methodParts := strings.Split(fullMethod, "/")
+	return methodParts[len(methodParts)-1]
+}
+
+// redactSecrets returns a copy of a request for logging with its csi_secret fields replaced.
+// Values that are not protobuf messages are returned unchanged.
+func redactSecrets(req interface{}) interface{} {
+	msg, ok := req.(proto.Message)
+	if !ok || msg == nil {
+		return req
+	}
+	redacted := proto.Clone(msg)
+	stripSecretFields(redacted.ProtoReflect())
+	return redacted
+}
+
+// stripSecretFields replaces the csi_secret fields of a message and its nested messages.
+func stripSecretFields(m protoreflect.Message) {
+	type field struct {
+		fd protoreflect.FieldDescriptor
+		v  protoreflect.Value
+	}
+	var fields []field
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		fields = append(fields, field{fd, v})
+		return true
+	})
+
+	for _, f := range fields {
+		switch {
+		case isSecretField(f.fd):
+			stripSecretField(m, f.fd, f.v)
+		case f.fd.IsMap():
+			if f.fd.MapValue().Message() != nil {
+				f.v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+					stripSecretFields(v.Message())
+					return true
+				})
+			}
+		case f.fd.IsList():
+			if f.fd.Message() != nil {
+				list := f.v.List()
+				for i := range list.Len() {
+					stripSecretFields(list.Get(i).Message())
+				}
+			}
+		case f.fd.Message() != nil:
+			stripSecretFields(f.v.Message())
+		}
+	}
+}
+
+// stripSecretField replaces the value of a secret field, keeping map keys visible.
+func stripSecretField(m protoreflect.Message, fd protoreflect.FieldDescriptor, v protoreflect.Value) {
+	switch {
+	case fd.IsMap() && fd.MapValue().Kind() == protoreflect.StringKind:
+		secrets := v.Map()
+		var keys []protoreflect.MapKey
+		secrets.Range(func(key protoreflect.MapKey, _ protoreflect.Value) bool {
+			keys = append(keys, key)
+			return true
+		})
+		for _, key := range keys {
+			secrets.Set(key, protoreflect.ValueOfString(tnsapi.RedactedValue))
+		}
+	case !fd.IsList() && !fd.IsMap() && fd.Kind() == protoreflect.StringKind:
+		m.Set(fd, protoreflect.ValueOfString(tnsapi.RedactedValue))
+	default:
+		m.Clear(fd)
+	}
+}
+
+// isSecretField reports whether the CSI spec marks a field csi_secret.
+func isSecretField(fd protoreflect.FieldDescriptor) bool {
+	opts, ok := fd.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return false
+	}
+	secret, ok := proto.GetExtension(opts, csi.E_CsiSecret).(bool)
+	return ok && secret
+}
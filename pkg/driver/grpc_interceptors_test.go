@@ -0,0 +1,72 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	req := &csi.CreateVolumeRequest{
+		Name:       "pvc-1",
+		Parameters: map[string]string{"pool": "tank"},
+		Secrets:    map[string]string{"apiKey": "s3cr3t"},
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "snap-1"}},
+		},
+	}
+
+	redacted, ok := redactSecrets(req).(*csi.CreateVolumeRequest)
+	if !ok {
+		t.Fatalf("redactSecrets() returned %T", redactSecrets(req))
+	}
+	if got := redacted.GetSecrets()["apiKey"]; got != tnsapi.RedactedValue {
+		t.Errorf("secret = %q, want %q", got, tnsapi.RedactedValue)
+	}
+	if redacted.GetParameters()["pool"] != "tank" || redacted.GetName() != "pvc-1" ||
+		redacted.GetVolumeContentSource().GetSnapshot().GetSnapshotId() != "snap-1" {
+		t.Errorf("non-secret fields changed: %v", redacted)
+	}
+	if req.GetSecrets()["apiKey"] != "s3cr3t" {
+		t.Error("redactSecrets() modified the request")
+	}
+	if strings.Contains(redacted.String(), "s3cr3t") {
+		t.Errorf("secret in logged request: %s", redacted.String())
+	}
+
+	stage := &csi.NodeStageVolumeRequest{VolumeId: "pvc-1", Secrets: map[string]string{"password": "hunter2"}}
+	if strings.Contains(redactSecrets(stage).(*csi.NodeStageVolumeRequest).String(), "hunter2") {
+		t.Error("NodeStageVolume secret not redacted")
+	}
+
+	if got := redactSecrets("plain"); got != "plain" {
+		t.Errorf("redactSecrets(non-proto) = %v", got)
+	}
+}
+
+func TestRecoveryInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeStageVolume"}
+
+	resp, err := recoveryInterceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		panic("nil map")
+	})
+	if resp != nil || status.Code(err) != codes.Internal {
+		t.Fatalf("got (%v, %v), want Internal error", resp, err)
+	}
+	if !strings.Contains(err.Error(), "NodeStageVolume") {
+		t.Errorf("error %q does not name the method", err)
+	}
+
+	resp, err = recoveryInterceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+	if err != nil || resp != "ok" {
+		t.Errorf("successful call: got (%v, %v)", resp, err)
+	}
+}
@@ -0,0 +1,69 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+
+	"k8s.io/klog/v2"
+)
+
+// Node plugin without hostNetwork.
+//
+// The node plugin runs with hostNetwork by default, so the storage connections it sets up (iSCSI
+// sessions through iscsid, NVMe-oF controllers, NFS and SMB mounts) belong to the host's network
+// namespace. Clusters that do not grant hostNetwork (e.g. OpenShift without the hostnetwork SCC)
+// would get them in the pod's network namespace instead, which goes away when the node plugin
+// restarts, hanging every mount and session it made. iscsiadm and multipath cannot even reach
+// their host daemons there: their abstract UNIX sockets are per network namespace.
+//
+// With --nsenter-host-network, the commands creating connections run in the network namespace of
+// the host's init process (nsenter --net=/proc/1/ns/net, which needs hostPID), while the plugin
+// itself stays in the pod network. With --storage-api=false, the node does not connect to the
+// TrueNAS API at all and needs neither its credentials nor a route to it: block volumes are
+// checked against the expectedCapacity of their volume context only.
+
+// hostInitNetNS is the network namespace of the host's init process, seen with hostPID.
+const hostInitNetNS = "/proc/1/ns/net"
+
+// errStorageAPIRequired is returned by NewDriver when --storage-api=false is combined with
+// options that use the storage API.
+var errStorageAPIRequired = errors.New("--storage-api=false is only supported on node plugins without controller options")
+
+// checkHostNetworkNamespace warns if the host's network namespace is the plugin's own anyway.
+func checkHostNetworkNamespace() {
+	host, hostErr := os.Readlink(hostInitNetNS)
+	own, ownErr := os.Readlink("/proc/self/ns/net")
+	switch {
+	case hostErr != nil || ownErr != nil:
+		klog.Warningf("Cannot compare network namespaces (%v, %v); connection commands will run with nsenter --net=%s", hostErr, ownErr, hostInitNetNS)
+	case host == own:
+		klog.Warningf("--nsenter-host-network: PID 1 shares the plugin's network namespace (hostNetwork is set, or hostPID is missing)")
+	default:
+		klog.Infof("Storage connections are made in the host network namespace (%s)", host)
+	}
+}
+
+// checkStorageAPIOptions rejects options that need the storage API when it is disabled.
+func checkStorageAPIOptions(cfg Config) error {
+	if cfg.DashboardAddr != "" || cfg.AdminAddr != "" || cfg.UsageAlertThresholds != "" || cfg.AutoGrow ||
+		cfg.VolumeStatsInterval > 0 || cfg.OrphanGCInterval > 0 || cfg.AuditInterval > 0 || cfg.AsyncDeleteMinSize != "" || cfg.PreviewReapInterval > 0 ||
+		cfg.VolumePopulatorSources != "" {
+		return errStorageAPIRequired
+	}
+	return nil
+}
+
+// hostNetCmd builds a command that creates storage connections, running it in the host's
+// network namespace with --nsenter-host-network.
+func (s *NodeService) hostNetCmd(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if s.hostNetNS == "" {
+		return exec.CommandContext(ctx, name, args...)
+	}
+	nsenterArgs := make([]string, 0, 3+len(args))
+	nsenterArgs = append(nsenterArgs, "--net="+s.hostNetNS, "--", name)
+	nsenterArgs = append(nsenterArgs, args...)
+	klog.V(5).Infof("Running %s in the host network namespace: nsenter %v", name, nsenterArgs)
+	return exec.CommandContext(ctx, "nsenter", nsenterArgs...)
+}
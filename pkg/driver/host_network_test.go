@@ -0,0 +1,65 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestHostNetCmd(t *testing.T) {
+	ctx := context.Background()
+	service := NewNodeService("test-node", nil, true, nil, false, 5)
+
+	cmd := service.hostNetCmd(ctx, "nvme", "connect", "-n", "nqn.test")
+	if want := []string{"nvme", "connect", "-n", "nqn.test"}; !slices.Equal(cmd.Args, want) {
+		t.Errorf("hostNetCmd() args = %v, want %v", cmd.Args, want)
+	}
+
+	service.hostNetNS = hostInitNetNS
+	cmd = service.hostNetCmd(ctx, "mount", "-t", "nfs", "server:/export", "/staging")
+	if want := []string{"nsenter", "--net=" + hostInitNetNS, "--", "mount", "-t", "nfs", "server:/export", "/staging"}; !slices.Equal(cmd.Args, want) {
+		t.Errorf("hostNetCmd() with --nsenter-host-network args = %v, want %v", cmd.Args, want)
+	}
+	if cmd := service.hostCmd(ctx, "iscsiadm", "-m", "session"); cmd.Args[0] == "nsenter" && !slices.Contains(cmd.Args, "--net="+hostInitNetNS) {
+		t.Errorf("hostCmd() with --nsenter-host-network args = %v, want the host network namespace", cmd.Args)
+	}
+}
+
+func TestNewDriverWithoutStorageAPI(t *testing.T) {
+	cfg := Config{DriverName: "tns.csi.io", NodeID: "worker-1", Endpoint: "unix:///tmp/csi.sock", NoStorageAPI: true, TestMode: true}
+	drv, err := NewDriver(cfg)
+	if err != nil {
+		t.Fatalf("NewDriver() error = %v", err)
+	}
+	if drv.apiClient != nil || drv.node.apiClient != nil {
+		t.Error("NewDriver() with NoStorageAPI created an API client")
+	}
+	if got := drv.node.getExpectedCapacity(context.Background(), "/dev/zd0", "tank/pvc-1", map[string]string{}); got != 0 {
+		t.Errorf("getExpectedCapacity() without volume context = %d, want 0", got)
+	}
+
+	cfg.DashboardAddr = ":2137"
+	if _, err := NewDriver(cfg); !errors.Is(err, errStorageAPIRequired) {
+		t.Errorf("NewDriver() with NoStorageAPI and a dashboard error = %v, want %v", err, errStorageAPIRequired)
+	}
+}
+
+func TestNewDriverHostNetworkNamespace(t *testing.T) {
+	cfg := Config{DriverName: "tns.csi.io", NodeID: "worker-1", Endpoint: "unix:///tmp/csi.sock", NoStorageAPI: true, TestMode: true}
+	plain, err := NewDriver(cfg)
+	if err != nil {
+		t.Fatalf("NewDriver() error = %v", err)
+	}
+	cfg.NsenterHostNetwork = true
+	nsenter, err := NewDriver(cfg)
+	if err != nil {
+		t.Fatalf("NewDriver() with NsenterHostNetwork error = %v", err)
+	}
+	if nsenter.node.hostNetNS != hostInitNetNS {
+		t.Errorf("hostNetNS with NsenterHostNetwork = %q, want %q", nsenter.node.hostNetNS, hostInitNetNS)
+	}
+	if plain.node.hostNetNS != "" {
+		t.Errorf("hostNetNS of a driver without NsenterHostNetwork = %q, want it unaffected", plain.node.hostNetNS)
+	}
+}
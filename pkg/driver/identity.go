@@ -56,6 +56,13 @@ func (s *IdentityService) GetPluginCapabilities(_ context.Context, _ *csi.GetPlu
 					},
 				},
 			},
+			{
+				Type: &csi.PluginCapability_Service_{
+					Service: &csi.PluginCapability_Service{
+						Type: csi.PluginCapability_Service_GROUP_CONTROLLER_SERVICE,
+					},
+				},
+			},
 			// VOLUME_ACCESSIBILITY_CONSTRAINTS removed - not needed and causes issues
 			// with csi-provisioner v5+ which enables topology by default when this is present
 			{
@@ -0,0 +1,359 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// Kubernetes informers of the controller.
+//
+// Without --kube-informers the controller only learns about Kubernetes objects from CSI requests.
+// With it, the controller keeps informer caches of the cluster's PersistentVolumes,
+// PersistentVolumeClaims and VolumeSnapshotContents. The caches power the orphan GC
+// (controller_orphan_gc.go), answer the dashboard's cluster-wide PV queries without listing every
+// PV on each request, and let usage and protocol events find the PVC of a volume from the cache,
+// also for volumes whose datasets carry no PVC metadata. StorageClasses and ResourceQuotas are
+// cached for the tenant quota sync (tenant_quota.go), and Nodes for NFS lock recovery
+// (nfs_lock_recovery.go).
+//
+// Every cache is optional. A resource the service account may not list, or whose CRD is not
+// installed, is reported once and left out. All users treat a missing or unsynced cache as
+// "unknown" and fall back to what they did without informers.
+
+// Kubernetes informer settings.
+const (
+	// kubeInformerResync is how often the informers replay their caches.
+	kubeInformerResync = 10 * time.Minute
+
+	// volumeHandleIndex indexes the driver's PersistentVolumes by CSI volume handle.
+	volumeHandleIndex = "volumeHandle"
+
+	// Resource names used in logs and the kube_informer_available metric.
+	kubeResourcePVs              = "persistentvolumes"
+	kubeResourcePVCs             = "persistentvolumeclaims"
+	kubeResourceSnapshotContents = "volumesnapshotcontents"
+	kubeResourceStorageClasses   = "storageclasses"
+	kubeResourceQuotas           = "resourcequotas"
+	kubeResourceNodes            = "nodes"
+)
+
+// volumeSnapshotContentGVR identifies VolumeSnapshotContents of the external-snapshotter CRDs.
+var volumeSnapshotContentGVR = schema.GroupVersionResource{
+	Group: "snapshot.storage.k8s.io", Version: "v1", Resource: kubeResourceSnapshotContents,
+}
+
+// clusterView is the controller's cached view of Kubernetes storage objects. A nil *clusterView
+// is valid and knows nothing.
+type clusterView struct {
+	factory    informers.SharedInformerFactory
+	dynFactory dynamicinformer.DynamicSharedInformerFactory
+	pvs        cache.SharedIndexInformer // nil when unavailable
+	pvcs       cache.SharedIndexInformer // nil when unavailable
+	snapshots  cache.SharedIndexInformer // VolumeSnapshotContents, nil when unavailable
+	classes    cache.SharedIndexInformer // StorageClasses, nil when unavailable
+	quotas     cache.SharedIndexInformer // ResourceQuotas, nil when unavailable
+	nodes      cache.SharedIndexInformer // Nodes, nil when unavailable
+	driverName string
+	// noSnapshotCRD is set when VolumeSnapshotContents do not exist in the cluster, so no
+	// snapshot can refer to a volume
+	noSnapshotCRD bool
+}
+
+// newClusterView creates the informers of the controller using the in-cluster Kubernetes API.
+func newClusterView(ctx context.Context, driverName string) (*clusterView, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+	}
+	kube, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic Kubernetes client: %w", err)
+	}
+	return newClusterViewForClients(ctx, kube, dyn, driverName), nil
+}
+
+// newClusterViewForClients creates informers for the resources the clients may list.
+func newClusterViewForClients(ctx context.Context, kube kubernetes.Interface, dyn dynamic.Interface, driverName string) *clusterView {
+	v := &clusterView{
+		factory:    informers.NewSharedInformerFactory(kube, kubeInformerResync),
+		dynFactory: dynamicinformer.NewDynamicSharedInformerFactory(dyn, kubeInformerResync),
+		driverName: driverName,
+	}
+	probe := metav1.ListOptions{Limit: 1}
+
+	if _, err := kube.CoreV1().PersistentVolumes().List(ctx, probe); err != nil {
+		v.unavailable(kubeResourcePVs, err)
+	} else {
+		v.pvs = v.factory.Core().V1().PersistentVolumes().Informer()
+		if err := v.pvs.AddIndexers(cache.Indexers{volumeHandleIndex: v.volumeHandles}); err != nil {
+			klog.Warningf("Kubernetes %s cache disabled: %v", kubeResourcePVs, err)
+			v.pvs = nil
+		}
+	}
+
+	if _, err := kube.CoreV1().PersistentVolumeClaims("").List(ctx, probe); err != nil {
+		v.unavailable(kubeResourcePVCs, err)
+	} else {
+		v.pvcs = v.factory.Core().V1().PersistentVolumeClaims().Informer()
+	}
+
+	if _, err := kube.StorageV1().StorageClasses().List(ctx, probe); err != nil {
+		v.unavailable(kubeResourceStorageClasses, err)
+	} else {
+		v.classes = v.factory.Storage().V1().StorageClasses().Informer()
+	}
+
+	if _, err := kube.CoreV1().ResourceQuotas("").List(ctx, probe); err != nil {
+		v.unavailable(kubeResourceQuotas, err)
+	} else {
+		v.quotas = v.factory.Core().V1().ResourceQuotas().Informer()
+	}
+
+	if _, err := kube.CoreV1().Nodes().List(ctx, probe); err != nil {
+		v.unavailable(kubeResourceNodes, err)
+	} else {
+		v.nodes = v.factory.Core().V1().Nodes().Informer()
+	}
+
+	_, err := dyn.Resource(volumeSnapshotContentGVR).List(ctx, probe)
+	switch {
+	case err == nil:
+		v.snapshots = v.dynFactory.ForResource(volumeSnapshotContentGVR).Informer()
+	case apierrors.IsNotFound(err):
+		klog.Infof("VolumeSnapshotContent CRD not installed: no snapshots refer to volumes")
+		v.noSnapshotCRD = true
+	default:
+		v.unavailable(kubeResourceSnapshotContents, err)
+	}
+	return v
+}
+
+// unavailable reports a resource the controller cannot cache.
+func (v *clusterView) unavailable(resource string, err error) {
+	if apierrors.IsForbidden(err) {
+		klog.Warningf("Kubernetes %s cache disabled: the controller service account may not list them (check its RBAC): %v", resource, err)
+	} else {
+		klog.Warningf("Kubernetes %s cache disabled: %v", resource, err)
+	}
+	metrics.SetKubeInformerAvailable(resource, false)
+}
+
+// volumeHandles indexes PersistentVolumes of this driver by CSI volume handle.
+func (v *clusterView) volumeHandles(obj interface{}) ([]string, error) {
+	pv, ok := obj.(*corev1.PersistentVolume)
+	if !ok || pv.Spec.CSI == nil || pv.Spec.CSI.Driver != v.driverName {
+		return nil, nil
+	}
+	return []string{pv.Spec.CSI.VolumeHandle}, nil
+}
+
+// start runs the informers until stopCh is closed. It returns at once; the caches become usable
+// when their initial lists have completed.
+func (v *clusterView) start(stopCh <-chan struct{}) {
+	v.factory.Start(stopCh)
+	v.dynFactory.Start(stopCh)
+
+	caches := map[string]cache.SharedIndexInformer{
+		kubeResourcePVs:              v.pvs,
+		kubeResourcePVCs:             v.pvcs,
+		kubeResourceSnapshotContents: v.snapshots,
+		kubeResourceStorageClasses:   v.classes,
+		kubeResourceQuotas:           v.quotas,
+		kubeResourceNodes:            v.nodes,
+	}
+	for resource, informer := range caches {
+		if informer == nil {
+			continue
+		}
+		go func() {
+			if cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+				klog.Infof("Kubernetes %s cache synced", resource)
+				metrics.SetKubeInformerAvailable(resource, true)
+			}
+		}()
+	}
+}
+
+// synced reports whether an informer exists and has completed its initial list.
+func synced(informer cache.SharedIndexInformer) bool {
+	return informer != nil && informer.HasSynced()
+}
+
+// pvsSynced reports whether the PersistentVolume cache is usable.
+func (v *clusterView) pvsSynced() bool {
+	return v != nil && synced(v.pvs)
+}
+
+// listPVs returns the cached PersistentVolumes; ok is false while the cache is unavailable.
+func (v *clusterView) listPVs() (pvs []*corev1.PersistentVolume, ok bool) {
+	if !v.pvsSynced() {
+		return nil, false
+	}
+	for _, obj := range v.pvs.GetStore().List() {
+		if pv, isPV := obj.(*corev1.PersistentVolume); isPV {
+			pvs = append(pvs, pv)
+		}
+	}
+	return pvs, true
+}
+
+// pvForVolume returns the PersistentVolume of a CSI volume handle (nil if there is none); known
+// is false while the cache is unavailable.
+func (v *clusterView) pvForVolume(volumeID string) (pv *corev1.PersistentVolume, known bool) {
+	if !v.pvsSynced() {
+		return nil, false
+	}
+	objs, err := v.pvs.GetIndexer().ByIndex(volumeHandleIndex, volumeID)
+	if err != nil {
+		return nil, false
+	}
+	for _, obj := range objs {
+		if pv, ok := obj.(*corev1.PersistentVolume); ok {
+			return pv, true
+		}
+	}
+	return nil, true
+}
+
+// pvc returns a cached PersistentVolumeClaim (nil if it does not exist); known is false while the
+// cache is unavailable.
+func (v *clusterView) pvc(namespace, name string) (pvc *corev1.PersistentVolumeClaim, known bool) {
+	if v == nil || !synced(v.pvcs) {
+		return nil, false
+	}
+	obj, exists, err := v.pvcs.GetStore().GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, false
+	}
+	if !exists {
+		return nil, true
+	}
+	pvc, ok := obj.(*corev1.PersistentVolumeClaim)
+	return pvc, ok
+}
+
+// claimForVolume returns the namespace and name of the PVC bound to the PV of any of volumeIDs,
+// or empty strings if the cache does not know one.
+func (v *clusterView) claimForVolume(volumeIDs ...string) (namespace, name string) {
+	for _, volumeID := range volumeIDs {
+		if volumeID == "" {
+			continue
+		}
+		if pv, _ := v.pvForVolume(volumeID); pv != nil && pv.Spec.ClaimRef != nil {
+			return pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name
+		}
+	}
+	return "", ""
+}
+
+// storageClasses returns the names of this driver's StorageClasses; known is false while the
+// cache is unavailable.
+func (v *clusterView) storageClasses() (names []string, known bool) {
+	if v == nil || !synced(v.classes) {
+		return nil, false
+	}
+	for _, obj := range v.classes.GetStore().List() {
+		if class, ok := obj.(*storagev1.StorageClass); ok && class.Provisioner == v.driverName {
+			names = append(names, class.Name)
+		}
+	}
+	return names, true
+}
+
+// resourceQuotas returns the cached ResourceQuotas of a namespace; known is false while the
+// cache is unavailable.
+func (v *clusterView) resourceQuotas(namespace string) (quotas []*corev1.ResourceQuota, known bool) {
+	if v == nil || !synced(v.quotas) {
+		return nil, false
+	}
+	for _, obj := range v.quotas.GetStore().List() {
+		if quota, ok := obj.(*corev1.ResourceQuota); ok && quota.Namespace == namespace {
+			quotas = append(quotas, quota)
+		}
+	}
+	return quotas, true
+}
+
+// node returns a cached Node (nil if it does not exist); known is false while the cache is
+// unavailable.
+func (v *clusterView) node(name string) (node *corev1.Node, known bool) {
+	if v == nil || !synced(v.nodes) {
+		return nil, false
+	}
+	obj, exists, err := v.nodes.GetStore().GetByKey(name)
+	if err != nil {
+		return nil, false
+	}
+	if !exists {
+		return nil, true
+	}
+	node, ok := obj.(*corev1.Node)
+	return node, ok
+}
+
+// snapshotSourceVolumes returns the IDs of the volumes that VolumeSnapshotContents of this driver
+// were taken from; known is false while that cannot be told.
+func (v *clusterView) snapshotSourceVolumes() (volumeIDs map[string]bool, known bool) {
+	handles, known := v.snapshotHandles()
+	if !known {
+		return nil, false
+	}
+	volumeIDs = make(map[string]bool)
+	for _, handle := range handles {
+		if meta, err := decodeSnapshotID(handle); err == nil {
+			volumeIDs[meta.SourceVolume] = true
+		}
+	}
+	return volumeIDs, true
+}
+
+// snapshotHandles returns the snapshot handles of the VolumeSnapshotContents of this driver;
+// known is false while they cannot be told.
+func (v *clusterView) snapshotHandles() (handles []string, known bool) {
+	if v == nil {
+		return nil, false
+	}
+	if v.noSnapshotCRD {
+		return nil, true
+	}
+	if !synced(v.snapshots) {
+		return nil, false
+	}
+	for _, obj := range v.snapshots.GetStore().List() {
+		content, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		if driver, _, _ := unstructured.NestedString(content.Object, "spec", "driver"); driver != v.driverName {
+			continue
+		}
+		handle, _, _ := unstructured.NestedString(content.Object, "status", "snapshotHandle")
+		if handle == "" {
+			handle, _, _ = unstructured.NestedString(content.Object, "spec", "source", "snapshotHandle")
+		}
+		if handle != "" {
+			handles = append(handles, handle)
+		}
+	}
+	return handles, true
+}
@@ -0,0 +1,186 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+const testDriverName = "tns.csi.io"
+
+func testPV(name, volumeHandle, claim string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: testDriverName, VolumeHandle: volumeHandle},
+			},
+			ClaimRef: &corev1.ObjectReference{Namespace: "apps", Name: claim},
+		},
+	}
+}
+
+func testSnapshotContent(t *testing.T, sourceVolume string) *unstructured.Unstructured {
+	t.Helper()
+	handle, err := encodeSnapshotID(SnapshotMetadata{Protocol: ProtocolNFS, SourceVolume: sourceVolume, SnapshotName: "snap-1"})
+	if err != nil {
+		t.Fatalf("encodeSnapshotID() error = %v", err)
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "snapshot.storage.k8s.io/v1",
+		"kind":       "VolumeSnapshotContent",
+		"metadata":   map[string]interface{}{"name": "snapcontent-1"},
+		"spec":       map[string]interface{}{"driver": testDriverName},
+		"status":     map[string]interface{}{"snapshotHandle": handle},
+	}}
+}
+
+// newTestClusterView starts a clusterView on fake clients and waits for its caches.
+func newTestClusterView(t *testing.T, kube *fake.Clientset, snapshotContents ...runtime.Object) *clusterView {
+	t.Helper()
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{volumeSnapshotContentGVR: "VolumeSnapshotContentList"}, snapshotContents...)
+	view := newClusterViewForClients(context.Background(), kube, dyn, testDriverName)
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	view.start(stopCh)
+	for _, informer := range []cache.SharedIndexInformer{view.pvs, view.pvcs, view.snapshots, view.classes, view.quotas, view.nodes} {
+		if informer != nil && !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+			t.Fatal("informer caches did not sync")
+		}
+	}
+	return view
+}
+
+func TestClusterView(t *testing.T) {
+	other := testPV("pv-other", "tank/k8s/pvc-2", "other")
+	other.Spec.CSI.Driver = "other.csi.io"
+	kube := fake.NewClientset(testPV("pv-1", "tank/k8s/pvc-1", "data"), other,
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "apps"}})
+	view := newTestClusterView(t, kube, testSnapshotContent(t, "tank/k8s/pvc-1"))
+
+	if pv, known := view.pvForVolume("tank/k8s/pvc-1"); !known || pv == nil || pv.Name != "pv-1" {
+		t.Errorf("pvForVolume(pvc-1) = %v, %v; want pv-1", pv, known)
+	}
+	if pv, known := view.pvForVolume("tank/k8s/pvc-2"); !known || pv != nil {
+		t.Errorf("pvForVolume() of another driver's PV = %v, %v; want nil, true", pv, known)
+	}
+	if ns, name := view.claimForVolume("", "tank/k8s/pvc-1"); ns != "apps" || name != "data" {
+		t.Errorf("claimForVolume() = %s/%s, want apps/data", ns, name)
+	}
+	if pvc, known := view.pvc("apps", "data"); !known || pvc == nil {
+		t.Errorf("pvc(apps/data) = %v, %v; want the claim", pvc, known)
+	}
+	if pvc, known := view.pvc("apps", "missing"); !known || pvc != nil {
+		t.Errorf("pvc(apps/missing) = %v, %v; want nil, true", pvc, known)
+	}
+	if sources, known := view.snapshotSourceVolumes(); !known || !sources["tank/k8s/pvc-1"] || len(sources) != 1 {
+		t.Errorf("snapshotSourceVolumes() = %v, %v; want pvc-1", sources, known)
+	}
+
+	var nilView *clusterView
+	if _, known := nilView.pvForVolume("tank/k8s/pvc-1"); known {
+		t.Error("nil clusterView knows a PV")
+	}
+	if _, known := nilView.snapshotSourceVolumes(); known {
+		t.Error("nil clusterView knows snapshot sources")
+	}
+}
+
+func TestClusterViewForbiddenResource(t *testing.T) {
+	kube := fake.NewClientset(testPV("pv-1", "tank/k8s/pvc-1", "data"))
+	kube.PrependReactor("list", "persistentvolumeclaims", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: kubeResourcePVCs}, "", nil)
+	})
+	view := newTestClusterView(t, kube)
+
+	if view.pvcs != nil {
+		t.Error("PVC cache created although listing PVCs is forbidden")
+	}
+	if _, known := view.pvc("apps", "data"); known {
+		t.Error("pvc() known without a PVC cache")
+	}
+	if ns, name := view.claimForVolume("tank/k8s/pvc-1"); ns != "apps" || name != "data" {
+		t.Errorf("claimForVolume() = %s/%s, want apps/data from the PV cache", ns, name)
+	}
+}
+
+func TestCollectOrphansIntegration(t *testing.T) {
+	controller, srv := newIntegrationController(t)
+	ctx := context.Background()
+
+	params := map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local"}
+	create := func(name string, extra map[string]string) string {
+		t.Helper()
+		p := make(map[string]string, len(params)+len(extra))
+		for k, v := range params {
+			p[k] = v
+		}
+		for k, v := range extra {
+			p[k] = v
+		}
+		resp, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:          name,
+			CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			Parameters: p,
+		})
+		if err != nil {
+			t.Fatalf("CreateVolume(%s) error = %v", name, err)
+		}
+		return resp.GetVolume().GetVolumeId()
+	}
+	bound := create("pvc-bound", nil)
+	orphan := create("pvc-orphan", nil)
+	retained := create("pvc-retained", map[string]string{"deleteStrategy": tnsapi.DeleteStrategyRetain})
+	snapshotted := create("pvc-snapshotted", nil)
+
+	controller.kubeView = newTestClusterView(t, fake.NewClientset(testPV("pv-bound", bound, "bound")),
+		testSnapshotContent(t, snapshotted))
+	controller.orphanGCDeleteAfter = time.Hour
+
+	start := time.Now()
+	controller.collectOrphans(ctx, start)
+	wantOrphans := map[string]bool{orphan: true, retained: true}
+	if len(controller.orphanSince) != len(wantOrphans) {
+		t.Fatalf("orphans = %v, want %v", controller.orphanSince, wantOrphans)
+	}
+	for id := range controller.orphanSince {
+		if !wantOrphans[id] {
+			t.Errorf("volume %s reported as orphan", id)
+		}
+	}
+	if !srv.DatasetExists(orphan) {
+		t.Fatal("orphan deleted before the grace period")
+	}
+
+	controller.collectOrphans(ctx, start.Add(2*time.Hour))
+	if srv.DatasetExists(orphan) {
+		t.Error("orphan not deleted after the grace period")
+	}
+	for _, id := range []string{bound, retained, snapshotted} {
+		if !srv.DatasetExists(id) {
+			t.Errorf("volume %s deleted by the orphan GC", id)
+		}
+	}
+	if _, tracked := controller.orphanSince[orphan]; tracked {
+		t.Error("deleted orphan still tracked")
+	}
+}
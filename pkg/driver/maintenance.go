@@ -0,0 +1,168 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Maintenance mode.
+//
+// While TrueNAS is upgraded or rebooted, provisioning requests time out one after another and
+// nodes keep reconnecting to a storage system that is not there. --maintenance-dir names a
+// directory, usually a mounted ConfigMap, whose "enabled" file switches maintenance mode on
+// ("true") and whose optional "reason" file says why. Both plugins re-read it every
+// maintenancePollInterval; kubelet refreshes mounted ConfigMaps within about a minute.
+//
+// During maintenance:
+//   - the controller refuses CreateVolume, DeleteVolume, CreateSnapshot, DeleteSnapshot,
+//     ControllerExpandVolume and ControllerModifyVolume with Unavailable, so the sidecars retry
+//     them with backoff after the window, and pauses background deletion and the orphan GC
+//   - the node plugin refuses NodeStageVolume with Unavailable instead of connecting to the
+//     storage, and does not remount stale NFS mounts
+//
+// Mounted volumes, unstaging and unpublishing are not affected. tns_csi_maintenance_mode
+// exports the mode and tns_csi_maintenance_rejected_total counts refused requests.
+
+// Maintenance mode settings.
+const (
+	// maintenancePollInterval is how often the maintenance directory is re-read.
+	maintenancePollInterval = 10 * time.Second
+
+	// Files of the maintenance directory (ConfigMap keys).
+	maintenanceEnabledFile = "enabled"
+	maintenanceReasonFile  = "reason"
+)
+
+// errMaintenanceMode is returned by work skipped during maintenance.
+var errMaintenanceMode = errors.New("maintenance mode is on")
+
+// maintenanceRefusedMethods are the RPCs refused during maintenance.
+var maintenanceRefusedMethods = map[string]bool{
+	"CreateVolume":           true,
+	"DeleteVolume":           true,
+	"CreateSnapshot":         true,
+	"DeleteSnapshot":         true,
+	"ControllerExpandVolume": true,
+	"ControllerModifyVolume": true,
+	"NodeStageVolume":        true,
+}
+
+// maintenanceMode tracks the maintenance switch. A nil *maintenanceMode is never on.
+type maintenanceMode struct {
+	dir     string
+	reason  string
+	mu      sync.RWMutex
+	enabled bool
+}
+
+// newMaintenanceMode returns a maintenance switch reading dir, or nil if dir is empty. The
+// directory is read once before it returns.
+func newMaintenanceMode(dir string) *maintenanceMode {
+	if dir == "" {
+		return nil
+	}
+	m := &maintenanceMode{dir: dir}
+	m.refresh()
+	return m
+}
+
+// active reports whether maintenance mode is on and why.
+func (m *maintenanceMode) active() (enabled bool, reason string) {
+	if m == nil {
+		return false, ""
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled, m.reason
+}
+
+// refresh re-reads the maintenance directory. A missing "enabled" file turns maintenance off;
+// an unreadable or invalid one keeps the current mode.
+func (m *maintenanceMode) refresh() {
+	enabled, err := readMaintenanceEnabled(filepath.Join(m.dir, maintenanceEnabledFile))
+	if err != nil {
+		klog.Warningf("Failed to read maintenance mode from %s, keeping the current mode: %v", m.dir, err)
+		return
+	}
+	var reason string
+	if data, readErr := os.ReadFile(filepath.Join(m.dir, maintenanceReasonFile)); readErr == nil {
+		reason = strings.TrimSpace(string(data))
+	}
+
+	m.mu.Lock()
+	changed := enabled != m.enabled
+	m.enabled, m.reason = enabled, reason
+	m.mu.Unlock()
+
+	switch {
+	case changed && enabled:
+		klog.Warningf("Maintenance mode on (%s): refusing provisioning, deletion and staging requests", maintenanceReason(reason))
+	case changed:
+		klog.Infof("Maintenance mode off")
+	}
+	metrics.SetMaintenanceMode(enabled)
+}
+
+// readMaintenanceEnabled parses the "enabled" file; a missing file means off.
+func readMaintenanceEnabled(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// run re-reads the maintenance directory every maintenancePollInterval until stopCh is closed.
+func (m *maintenanceMode) run(stopCh <-chan struct{}) {
+	klog.Infof("Watching %s for maintenance mode", m.dir)
+
+	ticker := time.NewTicker(maintenancePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.refresh()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// maintenanceReason returns reason, or a generic one if it is empty.
+func maintenanceReason(reason string) string {
+	if reason == "" {
+		return "no reason given"
+	}
+	return reason
+}
+
+// maintenanceInterceptor refuses maintenanceRefusedMethods with Unavailable during maintenance.
+func (d *Driver) maintenanceInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := rpcMethodName(info.FullMethod)
+	if enabled, reason := d.maintenance.active(); enabled && maintenanceRefusedMethods[method] {
+		metrics.RecordMaintenanceRejected(method)
+		return nil, status.Errorf(codes.Unavailable, "TrueNAS maintenance in progress (%s): %s will be retried after the maintenance window",
+			maintenanceReason(reason), method)
+	}
+	return handler(ctx, req)
+}
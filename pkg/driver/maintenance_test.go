@@ -0,0 +1,90 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := newMaintenanceMode(dir)
+	if enabled, _ := m.active(); enabled {
+		t.Error("maintenance on without an enabled file")
+	}
+
+	write(maintenanceEnabledFile, "true\n")
+	write(maintenanceReasonFile, "TrueNAS 25.10 upgrade\n")
+	m.refresh()
+	if enabled, reason := m.active(); !enabled || reason != "TrueNAS 25.10 upgrade" {
+		t.Errorf("active() = %v, %q; want on with the reason", enabled, reason)
+	}
+
+	write(maintenanceEnabledFile, "maybe")
+	m.refresh()
+	if enabled, _ := m.active(); !enabled {
+		t.Error("invalid enabled file changed the mode")
+	}
+
+	write(maintenanceEnabledFile, "false")
+	m.refresh()
+	if enabled, _ := m.active(); enabled {
+		t.Error("maintenance still on after enabled=false")
+	}
+
+	if newMaintenanceMode("") != nil {
+		t.Error("newMaintenanceMode(\"\") != nil")
+	}
+	var never *maintenanceMode
+	if enabled, _ := never.active(); enabled {
+		t.Error("nil maintenanceMode is on")
+	}
+}
+
+func TestMaintenanceInterceptor(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, maintenanceEnabledFile), []byte("true"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d := &Driver{maintenance: newMaintenanceMode(dir)}
+	handler := func(context.Context, interface{}) (interface{}, error) { return "ok", nil }
+
+	for method, refused := range map[string]bool{
+		"/csi.v1.Controller/CreateVolume":        true,
+		"/csi.v1.Controller/DeleteSnapshot":      true,
+		"/csi.v1.Node/NodeStageVolume":           true,
+		"/csi.v1.Controller/ListVolumes":         false,
+		"/csi.v1.Node/NodeUnstageVolume":         false,
+		"/csi.v1.Node/NodeGetVolumeStats":        false,
+		"/csi.v1.Identity/Probe":                 false,
+		"/csi.v1.Controller/ControllerGetVolume": false,
+	} {
+		resp, err := d.maintenanceInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		switch {
+		case refused && status.Code(err) != codes.Unavailable:
+			t.Errorf("%s during maintenance = %v, %v; want Unavailable", method, resp, err)
+		case refused && !strings.Contains(err.Error(), "no reason given"):
+			t.Errorf("%s error %q does not mention the reason", method, err)
+		case !refused && (err != nil || resp != "ok"):
+			t.Errorf("%s during maintenance = %v, %v; want it handled", method, resp, err)
+		}
+	}
+
+	d.maintenance = nil
+	if _, err := d.maintenanceInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}, handler); err != nil {
+		t.Errorf("CreateVolume without maintenance switch = %v", err)
+	}
+}
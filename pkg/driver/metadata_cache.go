@@ -0,0 +1,249 @@
+package driver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// Volume metadata cache.
+//
+// Controller RPCs only receive a volume ID, so they normally rebuild protocol metadata
+// (share, subsystem, namespace, target IDs) from ZFS user properties on every call. For
+// legacy plain-name volume IDs this is a full property scan. With --volume-metadata-crd the
+// controller records the metadata in a cluster-scoped TNSVolume object at provision time
+// and consults it first; the storage system stays the source of truth on cache misses.
+
+// TNSVolume custom resource coordinates.
+const (
+	TNSVolumeGroup    = "tns.csi.io"
+	TNSVolumeVersion  = "v1alpha1"
+	TNSVolumeResource = "tnsvolumes"
+	TNSVolumeKind     = "TNSVolume"
+
+	// tnsVolumeNamePrefix prefixes TNSVolume object names. Volume IDs are dataset paths,
+	// which are not valid object names, so names are derived from a hash of the ID.
+	tnsVolumeNamePrefix = "tnsvol-"
+
+	// labelTNSVolumeProtocol lets operators select TNSVolume objects by protocol.
+	labelTNSVolumeProtocol = "tns.csi.io/protocol"
+)
+
+// tnsVolumeGVR is the GroupVersionResource of the TNSVolume CRD.
+var tnsVolumeGVR = schema.GroupVersionResource{Group: TNSVolumeGroup, Version: TNSVolumeVersion, Resource: TNSVolumeResource}
+
+// VolumeMetadataCache stores VolumeMetadata outside the storage system for fast lookups.
+// Get returns nil, nil on a cache miss.
+type VolumeMetadataCache interface {
+	Get(ctx context.Context, volumeID string) (*VolumeMetadata, error)
+	Put(ctx context.Context, meta VolumeMetadata) error
+	Delete(ctx context.Context, volumeID string) error
+}
+
+// crdVolumeMetadataCache is a VolumeMetadataCache backed by TNSVolume custom resources.
+type crdVolumeMetadataCache struct {
+	client    dynamic.Interface
+	clusterID string
+}
+
+// NewCRDVolumeMetadataCache creates a TNSVolume-backed metadata cache using the in-cluster config.
+func NewCRDVolumeMetadataCache(clusterID string) (VolumeMetadataCache, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	return newCRDVolumeMetadataCache(client, clusterID), nil
+}
+
+func newCRDVolumeMetadataCache(client dynamic.Interface, clusterID string) *crdVolumeMetadataCache {
+	return &crdVolumeMetadataCache{client: client, clusterID: clusterID}
+}
+
+// tnsVolumeObjectName returns the TNSVolume object name for a volume ID.
+func tnsVolumeObjectName(volumeID string) string {
+	sum := sha256.Sum256([]byte(volumeID))
+	return tnsVolumeNamePrefix + hex.EncodeToString(sum[:])[:32]
+}
+
+// Get returns the cached metadata for a volume, or nil, nil if none is recorded.
+func (c *crdVolumeMetadataCache) Get(ctx context.Context, volumeID string) (*VolumeMetadata, error) {
+	obj, err := c.client.Resource(tnsVolumeGVR).Get(ctx, tnsVolumeObjectName(volumeID), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil //nolint:nilnil // nil, nil indicates a cache miss
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s for volume %s: %w", TNSVolumeKind, volumeID, err)
+	}
+
+	meta := metadataFromTNSVolume(obj)
+	if meta.Name != volumeID {
+		// Hash collision or hand-edited object: never trust it
+		klog.Warningf("%s %s records volume %q, expected %q; ignoring", TNSVolumeKind, obj.GetName(), meta.Name, volumeID)
+		return nil, nil //nolint:nilnil // treated as a cache miss
+	}
+	return meta, nil
+}
+
+// Put records (or replaces) the metadata for a volume.
+func (c *crdVolumeMetadataCache) Put(ctx context.Context, meta VolumeMetadata) error {
+	obj := tnsVolumeFromMetadata(meta, c.clusterID)
+	resource := c.client.Resource(tnsVolumeGVR)
+
+	_, err := resource.Create(ctx, obj, metav1.CreateOptions{})
+	if !apierrors.IsAlreadyExists(err) {
+		if err != nil {
+			return fmt.Errorf("failed to create %s for volume %s: %w", TNSVolumeKind, meta.Name, err)
+		}
+		return nil
+	}
+
+	existing, err := resource.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s for volume %s: %w", TNSVolumeKind, meta.Name, err)
+	}
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	if _, err := resource.Update(ctx, obj, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update %s for volume %s: %w", TNSVolumeKind, meta.Name, err)
+	}
+	return nil
+}
+
+// Delete removes the cached metadata for a volume. Missing entries are not an error.
+func (c *crdVolumeMetadataCache) Delete(ctx context.Context, volumeID string) error {
+	err := c.client.Resource(tnsVolumeGVR).Delete(ctx, tnsVolumeObjectName(volumeID), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete %s for volume %s: %w", TNSVolumeKind, volumeID, err)
+	}
+	return nil
+}
+
+// tnsVolumeFromMetadata builds a TNSVolume object from volume metadata.
+func tnsVolumeFromMetadata(meta VolumeMetadata, clusterID string) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"volumeID": meta.Name,
+		"protocol": meta.Protocol,
+	}
+	setString := func(key, value string) {
+		if value != "" {
+			spec[key] = value
+		}
+	}
+	setInt := func(key string, value int) {
+		if value != 0 {
+			spec[key] = int64(value)
+		}
+	}
+	setString("datasetID", meta.DatasetID)
+	setString("datasetName", meta.DatasetName)
+	setString("server", meta.Server)
+	setString("clusterID", clusterID)
+	setInt("nfsShareID", meta.NFSShareID)
+	setInt("smbShareID", meta.SMBShareID)
+	setString("nvmeofNQN", meta.NVMeOFNQN)
+	setInt("nvmeofSubsystemID", meta.NVMeOFSubsystemID)
+	setInt("nvmeofNamespaceID", meta.NVMeOFNamespaceID)
+	setString("iscsiIQN", meta.ISCSIIQN)
+	setInt("iscsiTargetID", meta.ISCSITargetID)
+	setInt("iscsiExtentID", meta.ISCSIExtentID)
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetAPIVersion(TNSVolumeGroup + "/" + TNSVolumeVersion)
+	obj.SetKind(TNSVolumeKind)
+	obj.SetName(tnsVolumeObjectName(meta.Name))
+	obj.SetLabels(map[string]string{labelTNSVolumeProtocol: meta.Protocol})
+	return obj
+}
+
+// metadataFromTNSVolume converts a TNSVolume object back to volume metadata.
+func metadataFromTNSVolume(obj *unstructured.Unstructured) *VolumeMetadata {
+	getString := func(key string) string {
+		value, _, _ := unstructured.NestedString(obj.Object, "spec", key)
+		return value
+	}
+	getInt := func(key string) int {
+		value, _, _ := unstructured.NestedInt64(obj.Object, "spec", key)
+		return int(value)
+	}
+	return &VolumeMetadata{
+		Name:              getString("volumeID"),
+		Protocol:          getString("protocol"),
+		DatasetID:         getString("datasetID"),
+		DatasetName:       getString("datasetName"),
+		Server:            getString("server"),
+		NFSShareID:        getInt("nfsShareID"),
+		SMBShareID:        getInt("smbShareID"),
+		NVMeOFNQN:         getString("nvmeofNQN"),
+		NVMeOFSubsystemID: getInt("nvmeofSubsystemID"),
+		NVMeOFNamespaceID: getInt("nvmeofNamespaceID"),
+		ISCSIIQN:          getString("iscsiIQN"),
+		ISCSITargetID:     getInt("iscsiTargetID"),
+		ISCSIExtentID:     getInt("iscsiExtentID"),
+	}
+}
+
+// volumeMetadataFromContext rebuilds volume metadata from a CreateVolume volume context.
+func volumeMetadataFromContext(volumeID string, volCtx map[string]string) VolumeMetadata {
+	return VolumeMetadata{
+		Name:              volumeID,
+		Protocol:          getProtocolFromVolumeContext(volCtx),
+		DatasetID:         volCtx[VolumeContextKeyDatasetID],
+		DatasetName:       volCtx[VolumeContextKeyDatasetName],
+		Server:            volCtx[VolumeContextKeyServer],
+		NFSShareID:        tnsapi.StringToInt(volCtx[VolumeContextKeyNFSShareID]),
+		SMBShareID:        tnsapi.StringToInt(volCtx[VolumeContextKeySMBShareID]),
+		NVMeOFNQN:         volCtx[VolumeContextKeyNQN],
+		NVMeOFSubsystemID: tnsapi.StringToInt(volCtx[VolumeContextKeyNVMeOFSubsystemID]),
+		NVMeOFNamespaceID: tnsapi.StringToInt(volCtx[VolumeContextKeyNVMeOFNamespaceID]),
+		ISCSIIQN:          volCtx[VolumeContextKeyISCSIIQN],
+		ISCSITargetID:     tnsapi.StringToInt(volCtx[VolumeContextKeyISCSITargetID]),
+		ISCSIExtentID:     tnsapi.StringToInt(volCtx[VolumeContextKeyISCSIExtentID]),
+	}
+}
+
+// cacheVolumeMetadata records metadata in the cache (if enabled). Failures are logged, never fatal:
+// the storage system remains the source of truth.
+func (s *ControllerService) cacheVolumeMetadata(ctx context.Context, meta VolumeMetadata) {
+	if s.metadataCache == nil || meta.Name == "" || meta.Protocol == "" {
+		return
+	}
+	if err := s.metadataCache.Put(ctx, meta); err != nil {
+		klog.Warningf("Failed to cache metadata for volume %s: %v (non-fatal)", meta.Name, err)
+	}
+}
+
+// cachedVolumeMetadata returns cached metadata for a volume, or nil on a miss or when the cache is disabled.
+func (s *ControllerService) cachedVolumeMetadata(ctx context.Context, volumeID string) *VolumeMetadata {
+	if s.metadataCache == nil {
+		return nil
+	}
+	meta, err := s.metadataCache.Get(ctx, volumeID)
+	if err != nil {
+		klog.Warningf("Metadata cache lookup for volume %s failed: %v (falling back to storage queries)", volumeID, err)
+		return nil
+	}
+	return meta
+}
+
+// evictVolumeMetadata removes a volume from the cache (if enabled).
+func (s *ControllerService) evictVolumeMetadata(ctx context.Context, volumeID string) {
+	if s.metadataCache == nil {
+		return
+	}
+	if err := s.metadataCache.Delete(ctx, volumeID); err != nil {
+		klog.Warningf("Failed to remove cached metadata for volume %s: %v (non-fatal)", volumeID, err)
+	}
+}
@@ -0,0 +1,178 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newFakeCRDCache() *crdVolumeMetadataCache {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{tnsVolumeGVR: TNSVolumeKind + "List"})
+	return newCRDVolumeMetadataCache(client, "cluster-a")
+}
+
+func TestCRDVolumeMetadataCache(t *testing.T) {
+	ctx := context.Background()
+	cache := newFakeCRDCache()
+
+	meta := VolumeMetadata{
+		Name:              "tank/k8s/pvc-1",
+		Protocol:          ProtocolNVMeOF,
+		DatasetID:         "tank/k8s/pvc-1",
+		DatasetName:       "tank/k8s/pvc-1",
+		Server:            "10.0.0.1",
+		NVMeOFNQN:         "nqn.2011-06.com.truenas:uuid:abc:pvc-1",
+		NVMeOFSubsystemID: 7,
+		NVMeOFNamespaceID: 12,
+	}
+
+	got, err := cache.Get(ctx, meta.Name)
+	if err != nil || got != nil {
+		t.Fatalf("Get() before Put = %+v, %v; want miss", got, err)
+	}
+
+	if err := cache.Put(ctx, meta); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	got, err = cache.Get(ctx, meta.Name)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got == nil || *got != meta {
+		t.Errorf("Get() = %+v, want %+v", got, meta)
+	}
+
+	obj, err := cache.client.Resource(tnsVolumeGVR).Get(ctx, tnsVolumeObjectName(meta.Name), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("TNSVolume object not created: %v", err)
+	}
+	if obj.GetLabels()[labelTNSVolumeProtocol] != ProtocolNVMeOF {
+		t.Errorf("protocol label = %q, want %q", obj.GetLabels()[labelTNSVolumeProtocol], ProtocolNVMeOF)
+	}
+
+	// Put again replaces the entry (e.g. adoption recreated the namespace)
+	meta.NVMeOFNamespaceID = 13
+	if err := cache.Put(ctx, meta); err != nil {
+		t.Fatalf("second Put() error = %v", err)
+	}
+	if got, _ := cache.Get(ctx, meta.Name); got == nil || got.NVMeOFNamespaceID != 13 {
+		t.Errorf("Get() after update = %+v, want namespace 13", got)
+	}
+
+	if err := cache.Delete(ctx, meta.Name); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := cache.Delete(ctx, meta.Name); err != nil {
+		t.Errorf("Delete() of missing entry error = %v, want nil", err)
+	}
+	if got, _ := cache.Get(ctx, meta.Name); got != nil {
+		t.Errorf("Get() after Delete = %+v, want miss", got)
+	}
+}
+
+func TestTNSVolumeObjectName(t *testing.T) {
+	a := tnsVolumeObjectName("tank/k8s/pvc-1")
+	if a != tnsVolumeObjectName("tank/k8s/pvc-1") {
+		t.Error("object name is not deterministic")
+	}
+	if a == tnsVolumeObjectName("tank/k8s/pvc-2") {
+		t.Error("different volumes map to the same object name")
+	}
+	if len(a) > 63 {
+		t.Errorf("object name %q longer than 63 characters", a)
+	}
+}
+
+func TestVolumeMetadataFromContext(t *testing.T) {
+	meta := VolumeMetadata{
+		Name:          "tank/k8s/pvc-iscsi",
+		Protocol:      ProtocolISCSI,
+		DatasetID:     "tank/k8s/pvc-iscsi",
+		DatasetName:   "tank/k8s/pvc-iscsi",
+		Server:        "10.0.0.1",
+		ISCSIIQN:      "iqn.2005-10.org.freenas.ctl:pvc-iscsi",
+		ISCSITargetID: 3,
+		ISCSIExtentID: 4,
+	}
+	if got := volumeMetadataFromContext(meta.Name, buildVolumeContext(meta)); got != meta {
+		t.Errorf("volumeMetadataFromContext() = %+v, want %+v", got, meta)
+	}
+}
+
+// memoryMetadataCache is an in-memory VolumeMetadataCache for controller tests.
+type memoryMetadataCache struct {
+	entries map[string]VolumeMetadata
+}
+
+func (c *memoryMetadataCache) Get(_ context.Context, volumeID string) (*VolumeMetadata, error) {
+	if meta, ok := c.entries[volumeID]; ok {
+		return &meta, nil
+	}
+	return nil, nil //nolint:nilnil // cache miss
+}
+
+func (c *memoryMetadataCache) Put(_ context.Context, meta VolumeMetadata) error {
+	c.entries[meta.Name] = meta
+	return nil
+}
+
+func (c *memoryMetadataCache) Delete(_ context.Context, volumeID string) error {
+	delete(c.entries, volumeID)
+	return nil
+}
+
+func TestLookupVolumeUsesMetadataCache(t *testing.T) {
+	ctx := context.Background()
+	volumeID := "tank/k8s/pvc-cached"
+
+	lookups := 0
+	mockClient := &MockAPIClientForSnapshots{
+		GetDatasetWithPropertiesFunc: func(_ context.Context, datasetID string) (*tnsapi.DatasetWithProperties, error) {
+			lookups++
+			return &tnsapi.DatasetWithProperties{
+				Dataset: tnsapi.Dataset{ID: datasetID, Name: datasetID},
+				UserProperties: map[string]tnsapi.UserProperty{
+					tnsapi.PropertyManagedBy:    {Value: tnsapi.ManagedByValue},
+					tnsapi.PropertyProtocol:     {Value: tnsapi.ProtocolNFS},
+					tnsapi.PropertyNFSShareID:   {Value: "5"},
+					tnsapi.PropertyNFSSharePath: {Value: "/mnt/" + datasetID},
+				},
+			}, nil
+		},
+	}
+	cache := &memoryMetadataCache{entries: map[string]VolumeMetadata{}}
+	service := NewControllerService(mockClient, NewNodeRegistry(), "")
+	service.metadataCache = cache
+
+	// First lookup misses the cache, queries the storage system and populates the cache
+	meta, err := service.lookupVolumeByCSIName(ctx, "", volumeID)
+	if err != nil || meta == nil || meta.NFSShareID != 5 {
+		t.Fatalf("lookupVolumeByCSIName() = %+v, %v", meta, err)
+	}
+	if _, ok := cache.entries[volumeID]; !ok {
+		t.Fatal("lookup did not populate the cache")
+	}
+
+	// Second lookup is served from the cache
+	if _, err := service.lookupVolumeByCSIName(ctx, "", volumeID); err != nil {
+		t.Fatalf("cached lookupVolumeByCSIName() error = %v", err)
+	}
+	if lookups != 1 {
+		t.Errorf("storage lookups = %d, want 1", lookups)
+	}
+
+	// Without a cache every lookup goes to the storage system
+	service.metadataCache = nil
+	if _, err := service.lookupVolumeByCSIName(ctx, "", volumeID); err != nil {
+		t.Fatalf("uncached lookupVolumeByCSIName() error = %v", err)
+	}
+	if lookups != 2 {
+		t.Errorf("storage lookups = %d, want 2", lookups)
+	}
+}
@@ -0,0 +1,239 @@
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// NFS Kerberos.
+//
+// nfs.security: krb5, krb5i or krb5p exports an NFS volume for RPCSEC_GSS only and mounts it
+// with the matching sec= option, for environments where AUTH_SYS (trusting client UIDs) is not
+// acceptable. CreateVolume checks that the TrueNAS NFS service can use Kerberos (a keytab is
+// configured through Directory Services) and records the server's NFSv4 idmap domain in the
+// volume context.
+//
+// Nodes need rpc.gssd running and a keytab for their host principal. Hosts joined to the realm
+// already have one; otherwise --nfs-krb5-keytab names a keytab in the node container (e.g. from
+// a Secret) that the node plugin installs as krb5.keytab into the host /etc mounted at
+// --nfs-krb5-host-etc before mounting. With the host /etc mounted the node also compares the
+// Domain of idmapd.conf with the server's: when they differ every file shows up as nobody.
+
+// NFSSecurityParam is the StorageClass parameter selecting the RPC security flavor of NFS volumes.
+const NFSSecurityParam = "nfs.security"
+
+// NFS security flavors.
+const (
+	NFSSecuritySys   = "sys"   // AUTH_SYS (default)
+	NFSSecurityKrb5  = "krb5"  // Kerberos authentication
+	NFSSecurityKrb5i = "krb5i" // Kerberos authentication and integrity checking
+	NFSSecurityKrb5p = "krb5p" // Kerberos authentication and encryption
+)
+
+// Volume context keys of Kerberos NFS volumes.
+const (
+	VolumeContextKeyNFSSecurity    = "nfsSecurity"
+	VolumeContextKeyNFSIdmapDomain = "nfsIdmapDomain"
+)
+
+// nfsProtocolV4 is how TrueNAS lists NFSv4 in the protocols of the NFS service.
+const nfsProtocolV4 = "NFSV4"
+
+// Host files used for Kerberos NFS mounts.
+const (
+	hostKeytabFile     = "krb5.keytab"
+	hostIdmapdConfFile = "idmapd.conf"
+	gssdProcessName    = "rpc.gssd"
+)
+
+// errKeytabWithoutHostEtc is returned by NewDriver when a keytab is configured without the host /etc.
+var errKeytabWithoutHostEtc = errors.New("--nfs-krb5-keytab requires --nfs-krb5-host-etc to install the keytab on the host")
+
+// parseNFSSecurity validates the nfs.security parameter. Returns "" for AUTH_SYS.
+func parseNFSSecurity(params map[string]string) (string, error) {
+	switch security := strings.ToLower(strings.TrimSpace(params[NFSSecurityParam])); security {
+	case "", NFSSecuritySys:
+		return "", nil
+	case NFSSecurityKrb5, NFSSecurityKrb5i, NFSSecurityKrb5p:
+		return security, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "invalid %s %q: must be %s, %s, %s or %s",
+			NFSSecurityParam, params[NFSSecurityParam], NFSSecuritySys, NFSSecurityKrb5, NFSSecurityKrb5i, NFSSecurityKrb5p)
+	}
+}
+
+// nfsKerberosVolumeContext checks that the TrueNAS NFS service can serve the nfs.security of an
+// NFS CreateVolume request and returns the volume context entries of the volume (nil for AUTH_SYS).
+func (s *ControllerService) nfsKerberosVolumeContext(ctx context.Context, req *csi.CreateVolumeRequest) (map[string]string, error) {
+	params := req.GetParameters()
+	if protocol := params["protocol"]; protocol != "" && protocol != ProtocolNFS {
+		return nil, nil
+	}
+	security, err := parseNFSSecurity(params)
+	if err != nil || security == "" {
+		return nil, err
+	}
+	if params[VolumeTypeParam] != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s cannot be combined with %s: directory volumes share their parent's export",
+			NFSSecurityParam, VolumeTypeParam)
+	}
+
+	config, err := s.apiClient.GetNFSConfig(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "cannot check the Kerberos configuration of the NFS service: %v", err)
+	}
+	if !config.V4KrbEnabled && !config.V4Krb {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"%s=%s needs Kerberos on the TrueNAS NFS service: join a Kerberos realm or add a keytab in Directory Services", NFSSecurityParam, security)
+	}
+	if len(config.Protocols) > 0 && !containsFold(config.Protocols, nfsProtocolV4) {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s=%s needs NFSv4 enabled on the TrueNAS NFS service (protocols: %s)",
+			NFSSecurityParam, security, strings.Join(config.Protocols, ", "))
+	}
+
+	volumeContext := map[string]string{VolumeContextKeyNFSSecurity: security}
+	if config.V4Domain != "" {
+		volumeContext[VolumeContextKeyNFSIdmapDomain] = config.V4Domain
+	}
+	return volumeContext, nil
+}
+
+// containsFold reports whether list contains value, ignoring case.
+func containsFold(list []string, value string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// nfsKerberosMountOptions adds sec=<security> to mount options unless they select a flavor already.
+func nfsKerberosMountOptions(options []string, security string) []string {
+	if security == "" {
+		return options
+	}
+	for _, opt := range options {
+		if strings.HasPrefix(opt, "sec=") {
+			return options
+		}
+	}
+	return append(options, "sec="+security)
+}
+
+// prepareNFSKerberos gets the node ready to mount a Kerberos NFS volume: rpc.gssd must be
+// running, the configured keytab is installed on the host and the idmap domain is compared
+// with the server's. No-op for AUTH_SYS volumes.
+func (s *NodeService) prepareNFSKerberos(volumeID string, volumeContext map[string]string) error {
+	security := volumeContext[VolumeContextKeyNFSSecurity]
+	if security == "" {
+		return nil
+	}
+	if !processRunning(procDir, gssdProcessName) {
+		return status.Errorf(codes.FailedPrecondition, "volume %s uses %s=%s but %s is not running on node %s",
+			volumeID, NFSSecurityParam, security, gssdProcessName, s.nodeID)
+	}
+	if s.krb5HostEtc == "" {
+		return nil
+	}
+	if s.krb5Keytab != "" {
+		installed, err := installKeytab(s.krb5Keytab, filepath.Join(s.krb5HostEtc, hostKeytabFile))
+		if err != nil {
+			return status.Errorf(codes.FailedPrecondition, "cannot install Kerberos keytab for volume %s: %v", volumeID, err)
+		}
+		if installed {
+			klog.Infof("Installed Kerberos keytab %s on node %s", s.krb5Keytab, s.nodeID)
+		}
+	}
+	if want := volumeContext[VolumeContextKeyNFSIdmapDomain]; want != "" {
+		if got := readIdmapDomain(filepath.Join(s.krb5HostEtc, hostIdmapdConfFile)); got != "" && !strings.EqualFold(got, want) {
+			klog.Warningf("NFSv4 idmap domain %q of node %s differs from the server's %q: files of volume %s will be owned by nobody",
+				got, s.nodeID, want, volumeID)
+		}
+	}
+	return nil
+}
+
+// procDir is where processes of the host are listed (the node plugin runs with hostPID).
+const procDir = "/proc"
+
+// processRunning reports whether a process named name is listed in dir.
+func processRunning(dir, name string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if pid := entry.Name(); pid[0] < '0' || pid[0] > '9' {
+			continue // not a process
+		}
+		comm, err := os.ReadFile(filepath.Join(dir, entry.Name(), "comm"))
+		if err == nil && strings.TrimSpace(string(comm)) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// installKeytab copies the keytab at src to dst unless dst already has the same content.
+// Returns whether dst was written.
+func installKeytab(src, dst string) (bool, error) {
+	keytab, err := os.ReadFile(src) //nolint:gosec // G304: path is provided by the operator via --nfs-krb5-keytab
+	if err != nil {
+		return false, fmt.Errorf("failed to read keytab: %w", err)
+	}
+	if len(keytab) == 0 {
+		return false, fmt.Errorf("keytab %s is empty", src)
+	}
+	if current, err := os.ReadFile(dst); err == nil && bytes.Equal(current, keytab) {
+		return false, nil
+	}
+	// Write next to dst and rename, so rpc.gssd never reads a partial keytab
+	tmp := dst + ".tns-csi"
+	if err := os.WriteFile(tmp, keytab, 0o600); err != nil {
+		return false, fmt.Errorf("failed to write keytab: %w", err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return false, fmt.Errorf("failed to install keytab: %w", err)
+	}
+	return true, nil
+}
+
+// readIdmapDomain returns the Domain set in the [General] section of an idmapd.conf, or "".
+func readIdmapDomain(path string) string {
+	f, err := os.Open(path) //nolint:gosec // path is built from the --nfs-krb5-host-etc flag
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case line[0] == '[':
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if ok && section == "general" && strings.EqualFold(strings.TrimSpace(key), "domain") {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,179 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNFSKerberosMountOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		security string
+		options  []string
+		want     []string
+	}{
+		{name: "auth sys", options: []string{"vers=4.2"}, want: []string{"vers=4.2"}},
+		{name: "krb5p", security: NFSSecurityKrb5p, options: []string{"vers=4.2"}, want: []string{"vers=4.2", "sec=krb5p"}},
+		{name: "mount option wins", security: NFSSecurityKrb5, options: []string{"sec=krb5i"}, want: []string{"sec=krb5i"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nfsKerberosMountOptions(tt.options, tt.security); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nfsKerberosMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessRunning(t *testing.T) {
+	dir := t.TempDir()
+	for pid, comm := range map[string]string{"1": "systemd\n", "812": "rpc.gssd\n", "self": "rpc.gssd\n"} {
+		if err := os.MkdirAll(filepath.Join(dir, pid), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, pid, "comm"), []byte(comm), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !processRunning(dir, gssdProcessName) {
+		t.Errorf("processRunning(%q) = false, want true", gssdProcessName)
+	}
+	if processRunning(dir, "rpc.svcgssd") {
+		t.Error("processRunning(rpc.svcgssd) = true, want false")
+	}
+}
+
+func TestInstallKeytab(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "secret.keytab")
+	dst := filepath.Join(dir, hostKeytabFile)
+	if err := os.WriteFile(src, []byte("keytab-v1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, wantInstalled := range []bool{true, false} {
+		installed, err := installKeytab(src, dst)
+		if err != nil {
+			t.Fatalf("installKeytab() #%d error = %v", i, err)
+		}
+		if installed != wantInstalled {
+			t.Errorf("installKeytab() #%d = %t, want %t", i, installed, wantInstalled)
+		}
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("keytab mode = %o, want 600", info.Mode().Perm())
+	}
+
+	if err := os.WriteFile(src, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := installKeytab(src, dst); err == nil {
+		t.Error("installKeytab() of an empty keytab succeeded")
+	}
+}
+
+func TestReadIdmapDomain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), hostIdmapdConfFile)
+	conf := "[General]\n# Domain = wrong\nVerbosity = 0\nDomain = corp.example.com\n\n[Mapping]\nDomain = other\n"
+	if err := os.WriteFile(path, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := readIdmapDomain(path); got != "corp.example.com" {
+		t.Errorf("readIdmapDomain() = %q, want corp.example.com", got)
+	}
+	if got := readIdmapDomain(filepath.Join(t.TempDir(), "missing")); got != "" {
+		t.Errorf("readIdmapDomain(missing) = %q, want empty", got)
+	}
+}
+
+func TestNFSKerberosIntegration(t *testing.T) {
+	tests := []struct {
+		config      map[string]interface{}
+		params      map[string]string
+		wantContext map[string]string
+		name        string
+		wantCode    codes.Code
+	}{
+		{
+			name:   "kerberos configured",
+			config: map[string]interface{}{"v4_krb_enabled": true, "v4_domain": "corp.example.com", "protocols": []string{"NFSV4"}},
+			params: map[string]string{NFSSecurityParam: NFSSecurityKrb5i},
+			wantContext: map[string]string{
+				VolumeContextKeyNFSSecurity:    NFSSecurityKrb5i,
+				VolumeContextKeyNFSIdmapDomain: "corp.example.com",
+			},
+		},
+		{
+			name:     "kerberos not configured",
+			config:   map[string]interface{}{"v4_krb_enabled": false, "protocols": []string{"NFSV3", "NFSV4"}},
+			params:   map[string]string{NFSSecurityParam: NFSSecurityKrb5},
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name:     "nfsv4 disabled",
+			config:   map[string]interface{}{"v4_krb_enabled": true, "protocols": []string{"NFSV3"}},
+			params:   map[string]string{NFSSecurityParam: NFSSecurityKrb5},
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name:     "directory volume",
+			config:   map[string]interface{}{"v4_krb_enabled": true},
+			params:   map[string]string{NFSSecurityParam: NFSSecurityKrb5, VolumeTypeParam: VolumeTypeSubdir, "parentDataset": "tank/csi"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:        "auth sys",
+			params:      map[string]string{NFSSecurityParam: NFSSecuritySys},
+			wantContext: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, srv := newIntegrationController(t)
+			srv.Handle("nfs.config", func(_ []json.RawMessage) (interface{}, error) {
+				return tt.config, nil
+			})
+
+			params := map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "192.0.2.10"}
+			for key, value := range tt.params {
+				params[key] = value
+			}
+			resp, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:          "pvc-krb",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				}},
+				Parameters: params,
+			})
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Fatalf("CreateVolume() error = %v, want %v", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateVolume() error = %v", err)
+			}
+			volumeContext := resp.GetVolume().GetVolumeContext()
+			for _, key := range []string{VolumeContextKeyNFSSecurity, VolumeContextKeyNFSIdmapDomain} {
+				if volumeContext[key] != tt.wantContext[key] {
+					t.Errorf("volume context %s = %q, want %q", key, volumeContext[key], tt.wantContext[key])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// NFS lock recovery.
+//
+// When the node of a ReadWriteOnce NFS volume fails, the volume is remounted on another node,
+// but the NFS server still holds the failed node's NFSv4 opens, locks and delegations until
+// its lease expires (and a courteous server keeps them longer), so an application that locks
+// its files on the new node blocks or fails meanwhile. With the StorageClass parameter
+// nfs.lockRecovery: "true":
+//
+//   - NFSv3 mounts keep their locks on the node (local_lock=all) even if the mount options enable
+//     NLM, so no server-side lock outlives its node
+//   - with --nfs4-client-expiry, ControllerUnpublishVolume from a node that Kubernetes reports
+//     NotReady expires the NFSv4 clients connected from that Node's addresses on TrueNAS
+//     (tnsapi.ExpireNFS4Client), releasing their state at once (a deleted Node has no addresses
+//     left to match, so its clients are left to lease expiry)
+//
+// Only single-node access modes may use it: local locks do not exclude other nodes, and expiring
+// a client drops its state for every volume the node mounted. Kubernetes only calls
+// ControllerUnpublishVolume when the CSIDriver sets attachRequired (Helm:
+// controller.nfsLockRecovery), and the node and PV lookups need --kube-informers. Expiring writes
+// to nfsd's procfs files through TrueNAS' file upload method, which is undocumented and needs an
+// API key allowed to write files as root, so it is a separate opt-in. Without it, and when
+// expiring fails, the locks are released by the lease expiry as before.
+
+// NFSLockRecoveryParam is the StorageClass parameter enabling NFS lock recovery.
+const NFSLockRecoveryParam = "nfs.lockRecovery"
+
+// VolumeContextKeyNFSLockRecovery marks volumes with NFS lock recovery in the volume context.
+const VolumeContextKeyNFSLockRecovery = "nfsLockRecovery"
+
+// mountOptLocalLockAll keeps flock and POSIX locks local to the NFSv3 client.
+const mountOptLocalLockAll = "local_lock=all"
+
+// nfsLockRecoveryVolumeContext validates nfs.lockRecovery of an NFS CreateVolume request and
+// returns the volume context entries of the volume (nil unless it is enabled).
+func nfsLockRecoveryVolumeContext(req *csi.CreateVolumeRequest) (map[string]string, error) {
+	params := req.GetParameters()
+	if protocol := params["protocol"]; protocol != "" && protocol != ProtocolNFS {
+		return nil, nil
+	}
+	switch value := strings.TrimSpace(params[NFSLockRecoveryParam]); value {
+	case "", "false":
+		return nil, nil
+	case VolumeContextValueTrue:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be true or false", NFSLockRecoveryParam, value)
+	}
+	for _, capability := range req.GetVolumeCapabilities() {
+		if !isSingleNodeAccessMode(capability.GetAccessMode().GetMode()) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s needs a single-node access mode, not %s",
+				NFSLockRecoveryParam, capability.GetAccessMode().GetMode())
+		}
+	}
+	return map[string]string{VolumeContextKeyNFSLockRecovery: VolumeContextValueTrue}, nil
+}
+
+// isSingleNodeAccessMode reports whether an access mode allows mounting on one node only.
+func isSingleNodeAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:
+		return true
+	default:
+		return false
+	}
+}
+
+// nfsLockRecoveryMountOptions adds local_lock=all to the mount options of NFSv3 mounts of volumes
+// with lock recovery, unless they choose a local_lock mode already.
+func nfsLockRecoveryMountOptions(options []string, volumeContext map[string]string) []string {
+	if volumeContext[VolumeContextKeyNFSLockRecovery] != VolumeContextValueTrue || mountOptionsNFSVersion(options) != "3" {
+		return options
+	}
+	for _, opt := range options {
+		if strings.HasPrefix(opt, "local_lock=") {
+			return options
+		}
+	}
+	return append(options, mountOptLocalLockAll)
+}
+
+// nodeReady reports whether a Node has the Ready condition set to True.
+func nodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// nodeAddresses returns the IP addresses a node's NFS clients may connect from: the Node's internal
+// and external addresses.
+func nodeAddresses(node *corev1.Node) map[string]bool {
+	addresses := make(map[string]bool)
+	if node != nil {
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == corev1.NodeInternalIP || addr.Type == corev1.NodeExternalIP {
+				addresses[addr.Address] = true
+			}
+		}
+	}
+	return addresses
+}
+
+// recoverNFSLocks expires the NFSv4 clients of a NotReady node on TrueNAS when a volume with NFS
+// lock recovery is unpublished from it. Failures are only logged: the lease expiry still
+// releases the locks.
+func (s *ControllerService) recoverNFSLocks(ctx context.Context, volumeID, nodeID string) {
+	pv, _ := s.kubeView.pvForVolume(volumeID)
+	if pv == nil || pv.Spec.CSI.VolumeAttributes[VolumeContextKeyNFSLockRecovery] != VolumeContextValueTrue {
+		return
+	}
+	node, known := s.kubeView.node(nodeID)
+	if !known || (node != nil && nodeReady(node)) {
+		return
+	}
+
+	if !s.nfs4ClientExpiry {
+		klog.Infof("NFS lock recovery: node %s is NotReady; its NFSv4 state for volume %s is left to lease expiry (--nfs4-client-expiry is disabled)", nodeID, volumeID)
+		return
+	}
+
+	addresses := nodeAddresses(node)
+	if len(addresses) == 0 {
+		klog.Warningf("NFS lock recovery: no addresses known for NotReady node %s, cannot clear its NFSv4 state for volume %s", nodeID, volumeID)
+		return
+	}
+	clients, err := s.apiClient.ListNFS4Clients(ctx)
+	if err != nil {
+		klog.Warningf("NFS lock recovery: volume %s, node %s: %v", volumeID, nodeID, err)
+		return
+	}
+	expired := 0
+	for _, client := range clients {
+		if !addresses[client.Address()] {
+			continue
+		}
+		if err := s.apiClient.ExpireNFS4Client(ctx, client.ID); err != nil {
+			if errors.Is(err, tnsapi.ErrNFS4ClientCtlNotFound) {
+				klog.Infof("NFS lock recovery: volume %s, node %s: %v", volumeID, nodeID, err)
+				continue
+			}
+			klog.Warningf("NFS lock recovery: volume %s, node %s: %v", volumeID, nodeID, err)
+			continue
+		}
+		expired++
+	}
+	klog.Infof("NFS lock recovery: expired %d NFSv4 client(s) of NotReady node %s after unpublishing volume %s", expired, nodeID, volumeID)
+}
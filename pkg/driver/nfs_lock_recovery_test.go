@@ -0,0 +1,141 @@
+package driver
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testNode(name, address string, ready corev1.ConditionStatus) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+			Addresses:  []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: address}},
+		},
+	}
+}
+
+func TestNFSLockRecoveryVolumeContext(t *testing.T) {
+	capability := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+		}
+	}
+	tests := []struct {
+		name    string
+		params  map[string]string
+		mode    csi.VolumeCapability_AccessMode_Mode
+		want    bool
+		wantErr bool
+	}{
+		{name: "unset", params: map[string]string{}, mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		{name: "rwo", params: map[string]string{NFSLockRecoveryParam: "true"}, mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, want: true},
+		{name: "rwop", params: map[string]string{NFSLockRecoveryParam: "true"}, mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER, want: true},
+		{name: "rwx", params: map[string]string{NFSLockRecoveryParam: "true"}, mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, wantErr: true},
+		{name: "invalid", params: map[string]string{NFSLockRecoveryParam: "yes"}, mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, wantErr: true},
+		{name: "other protocol", params: map[string]string{"protocol": ProtocolNVMeOF, NFSLockRecoveryParam: "true"}, mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nfsLockRecoveryVolumeContext(&csi.CreateVolumeRequest{
+				Parameters:         tt.params,
+				VolumeCapabilities: []*csi.VolumeCapability{capability(tt.mode)},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("nfsLockRecoveryVolumeContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if enabled := got[VolumeContextKeyNFSLockRecovery] == VolumeContextValueTrue; enabled != tt.want {
+				t.Errorf("nfsLockRecoveryVolumeContext() = %v, want enabled=%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNFSLockRecoveryMountOptions(t *testing.T) {
+	enabled := map[string]string{VolumeContextKeyNFSLockRecovery: VolumeContextValueTrue}
+	tests := []struct {
+		name          string
+		options       []string
+		volumeContext map[string]string
+		want          []string
+	}{
+		{name: "disabled", options: []string{"vers=3", "lock"}, want: []string{"vers=3", "lock"}},
+		{name: "nfsv3", options: []string{"vers=3", "lock"}, volumeContext: enabled, want: []string{"vers=3", "lock", mountOptLocalLockAll}},
+		{name: "local_lock chosen", options: []string{"nfsvers=3", "local_lock=flock"}, volumeContext: enabled, want: []string{"nfsvers=3", "local_lock=flock"}},
+		{name: "nfsv4", options: []string{"vers=4.2"}, volumeContext: enabled, want: []string{"vers=4.2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nfsLockRecoveryMountOptions(tt.options, tt.volumeContext); !slices.Equal(got, tt.want) {
+				t.Errorf("nfsLockRecoveryMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecoverNFSLocksIntegration(t *testing.T) {
+	ctx := context.Background()
+	controller, srv := newIntegrationController(t)
+
+	resp, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "pvc-locked",
+		Parameters: map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local", NFSLockRecoveryParam: "true"},
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	volume := resp.GetVolume()
+	if volume.GetVolumeContext()[VolumeContextKeyNFSLockRecovery] != VolumeContextValueTrue {
+		t.Fatalf("volume context = %v, want %s=true", volume.GetVolumeContext(), VolumeContextKeyNFSLockRecovery)
+	}
+	pv := testPV("pv-locked", volume.GetVolumeId(), "locked")
+	pv.Spec.CSI.VolumeAttributes = volume.GetVolumeContext()
+
+	failed := srv.AddNFS4Client("10.0.0.11:871", "Linux NFSv4.2 worker-1")
+	healthy := srv.AddNFS4Client("10.0.0.12:702", "Linux NFSv4.2 worker-2")
+	controller.kubeView = newTestClusterView(t, fake.NewClientset(pv,
+		testNode("worker-1", "10.0.0.11", corev1.ConditionUnknown),
+		testNode("worker-2", "10.0.0.12", corev1.ConditionTrue)))
+
+	unpublish := func(nodeID string) {
+		t.Helper()
+		if _, err := controller.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: volume.GetVolumeId(), NodeId: nodeID}); err != nil {
+			t.Fatalf("ControllerUnpublishVolume(%s) error = %v", nodeID, err)
+		}
+	}
+
+	unpublish("worker-2")
+	if got := srv.NFS4Clients(); len(got) != 2 || !slices.Contains(got, failed) {
+		t.Fatalf("NFSv4 clients after unpublishing from a Ready node = %v, want both", got)
+	}
+
+	unpublish("worker-1")
+	if got := srv.NFS4Clients(); len(got) != 2 {
+		t.Fatalf("NFSv4 clients after unpublishing without --nfs4-client-expiry = %v, want both", got)
+	}
+
+	// Kernels without /proc/fs/nfsd/clients fail the expiry; unpublishing still succeeds
+	controller.nfs4ClientExpiry = true
+	srv.SetNFSDClientDirs(false)
+	unpublish("worker-1")
+	if got := srv.NFS4Clients(); len(got) != 2 {
+		t.Fatalf("NFSv4 clients without nfsd client directories = %v, want both", got)
+	}
+
+	srv.SetNFSDClientDirs(true)
+	unpublish("worker-1")
+	if got := srv.NFS4Clients(); !slices.Equal(got, []string{healthy}) {
+		t.Errorf("NFSv4 clients after unpublishing from a NotReady node = %v, want only %s", got, healthy)
+	}
+}
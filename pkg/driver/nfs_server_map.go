@@ -0,0 +1,64 @@
+package driver
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// NFS server mapping.
+//
+// The NFS server address is part of a PV's volume attributes, which Kubernetes does not allow
+// to change. When the TrueNAS address moves (HA failover to a new VIP, renumbering), existing
+// PVs keep pointing at the old one. --nfs-server-map-file names a file, usually a mounted
+// ConfigMap, with one "<old-server> <new-server>" pair per line. The node plugin applies it
+// when mounting and when recovering a stale mount; the controller applies it to new volumes so
+// StorageClasses still naming the old address keep working. Kubelet refreshes mounted
+// ConfigMaps, so edits take effect without restarting the driver.
+
+// nfsServerMap resolves NFS server addresses through a mapping file. A nil map resolves every
+// server to itself.
+type nfsServerMap struct {
+	path string
+}
+
+// newNFSServerMap returns a server map reading path, or nil if path is empty.
+func newNFSServerMap(path string) *nfsServerMap {
+	if path == "" {
+		return nil
+	}
+	return &nfsServerMap{path: path}
+}
+
+// resolve returns the address to use for server. The file is read on every call.
+func (m *nfsServerMap) resolve(server string) string {
+	if m == nil || server == "" {
+		return server
+	}
+	f, err := os.Open(m.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("Failed to read NFS server map %s: %v", m.path, err)
+		}
+		return server
+	}
+	defer func() { _ = f.Close() }()
+
+	// IPv6 servers may be written with or without brackets
+	bare := strings.Trim(server, "[]")
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if fields[0] == server || strings.Trim(fields[0], "[]") == bare {
+			klog.V(4).Infof("Using NFS server %s instead of %s (--nfs-server-map-file)", fields[1], server)
+			return fields[1]
+		}
+	}
+	return server
+}
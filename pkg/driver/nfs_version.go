@@ -0,0 +1,115 @@
+package driver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NFS protocol versions.
+//
+// TrueNAS enables NFSv3 and NFSv4 for the whole NFS service; shares cannot restrict them. A
+// StorageClass that depends on one of them sets nfs.version or chooses it with a vers= (or
+// nfsvers=) mount option, and CreateVolume checks the protocols of the NFS service before
+// anything is provisioned: a volume whose mounts could never succeed fails with
+// FailedPrecondition instead of leaving its pods stuck in ContainerCreating. nfs.version is
+// recorded in the volume context and mounted as vers=<version> unless the mount options
+// already choose a version.
+
+// NFSVersionParam is the StorageClass parameter selecting the NFS version volumes are mounted with.
+const NFSVersionParam = "nfs.version"
+
+// VolumeContextKeyNFSVersion is the volume context key of the NFS version of a volume.
+const VolumeContextKeyNFSVersion = "nfsVersion"
+
+// nfsProtocolV3 is how TrueNAS lists NFSv3 in the protocols of the NFS service.
+const nfsProtocolV3 = "NFSV3"
+
+// parseNFSVersion validates an NFS version: 3, 4, 4.0, 4.1 or 4.2. Returns "" for "".
+func parseNFSVersion(value string) (string, error) {
+	switch version := strings.TrimSpace(value); version {
+	case "", "3", "4", "4.0", "4.1", "4.2":
+		return version, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "invalid %s %q: must be 3, 4, 4.0, 4.1 or 4.2", NFSVersionParam, value)
+	}
+}
+
+// nfsVersionProtocol returns the NFS service protocol serving an NFS version.
+func nfsVersionProtocol(version string) string {
+	if version == "3" {
+		return nfsProtocolV3
+	}
+	return nfsProtocolV4
+}
+
+// mountOptionsNFSVersion returns the NFS version chosen by vers= or nfsvers= in mount options,
+// or "" if they choose none.
+func mountOptionsNFSVersion(options []string) string {
+	for _, opt := range options {
+		key, value, ok := strings.Cut(opt, "=")
+		if ok && (key == "vers" || key == "nfsvers") {
+			return value
+		}
+	}
+	return ""
+}
+
+// nfsVersionMountOptions adds vers=<version> to mount options unless they choose a version already.
+func nfsVersionMountOptions(options []string, version string) []string {
+	if version == "" || mountOptionsNFSVersion(options) != "" {
+		return options
+	}
+	return append(append([]string(nil), options...), "vers="+version)
+}
+
+// nfsVersionVolumeContext checks that the TrueNAS NFS service has the protocol an NFS
+// CreateVolume request depends on enabled and returns the volume context entries of the volume
+// (nil unless nfs.version is set).
+func (s *ControllerService) nfsVersionVolumeContext(ctx context.Context, req *csi.CreateVolumeRequest) (map[string]string, error) {
+	params := req.GetParameters()
+	if protocol := params["protocol"]; protocol != "" && protocol != ProtocolNFS {
+		return nil, nil
+	}
+	version, err := parseNFSVersion(params[NFSVersionParam])
+	if err != nil {
+		return nil, err
+	}
+
+	required, source := version, NFSVersionParam
+	for _, capability := range req.GetVolumeCapabilities() {
+		mountVersion := mountOptionsNFSVersion(capability.GetMount().GetMountFlags())
+		if mountVersion == "" || (mountVersion != "3" && !strings.HasPrefix(mountVersion, "4")) {
+			continue
+		}
+		if version != "" && nfsVersionProtocol(mountVersion) != nfsVersionProtocol(version) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s=%s conflicts with mount option vers=%s", NFSVersionParam, version, mountVersion)
+		}
+		if required == "" {
+			required, source = mountVersion, "mount option vers="+mountVersion
+		}
+	}
+	if required == "" {
+		return nil, nil
+	}
+	if security, _ := parseNFSSecurity(params); security != "" && nfsVersionProtocol(required) == nfsProtocolV3 {
+		return nil, status.Errorf(codes.InvalidArgument, "%s=%s needs NFSv4, not NFSv3", NFSSecurityParam, security)
+	}
+
+	config, err := s.apiClient.GetNFSConfig(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "cannot check the protocols of the NFS service: %v", err)
+	}
+	if protocol := nfsVersionProtocol(required); len(config.Protocols) > 0 && !containsFold(config.Protocols, protocol) {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s needs %s but the TrueNAS NFS service only has %s enabled",
+			source, protocol, strings.Join(config.Protocols, ", "))
+	}
+
+	if version == "" {
+		return nil, nil
+	}
+	return map[string]string{VolumeContextKeyNFSVersion: version}, nil
+}
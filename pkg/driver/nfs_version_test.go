@@ -0,0 +1,90 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNFSVersionMountOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		options []string
+		want    []string
+	}{
+		{name: "no version", options: []string{"hard"}, want: []string{"hard"}},
+		{name: "nfsv3", version: "3", options: []string{"hard"}, want: []string{"hard", "vers=3"}},
+		{name: "mount option wins", version: "4.1", options: []string{"nfsvers=4.2"}, want: []string{"nfsvers=4.2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nfsVersionMountOptions(tt.options, tt.version); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nfsVersionMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if _, err := parseNFSVersion("5"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("parseNFSVersion(5) error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestNFSVersionIntegration(t *testing.T) {
+	tests := []struct {
+		params      map[string]string
+		name        string
+		wantVersion string
+		mountFlags  []string
+		protocols   []string
+		wantCode    codes.Code
+	}{
+		{name: "nfsv3 enabled", params: map[string]string{NFSVersionParam: "3"}, protocols: []string{"NFSV3", "NFSV4"}, wantVersion: "3"},
+		{name: "nfsv4 only", params: map[string]string{NFSVersionParam: "3"}, protocols: []string{"NFSV4"}, wantCode: codes.FailedPrecondition},
+		{name: "mount option", mountFlags: []string{"vers=4.1"}, protocols: []string{"NFSV3"}, wantCode: codes.FailedPrecondition},
+		{name: "conflict", params: map[string]string{NFSVersionParam: "4"}, mountFlags: []string{"nfsvers=3"}, wantCode: codes.InvalidArgument},
+		{name: "kerberos over nfsv3", params: map[string]string{NFSVersionParam: "3", NFSSecurityParam: NFSSecurityKrb5}, wantCode: codes.InvalidArgument},
+		{name: "not requested", protocols: []string{"NFSV3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, srv := newIntegrationController(t)
+			srv.Handle("nfs.config", func(_ []json.RawMessage) (interface{}, error) {
+				return map[string]interface{}{"protocols": tt.protocols}, nil
+			})
+
+			params := map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "192.0.2.10"}
+			for key, value := range tt.params {
+				params[key] = value
+			}
+			resp, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:          "pvc-vers",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: tt.mountFlags}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				}},
+				Parameters: params,
+			})
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Fatalf("CreateVolume() error = %v, want %v", err, tt.wantCode)
+				}
+				if n := srv.Counts()["datasets"]; n != 0 {
+					t.Errorf("%d datasets created for a rejected volume", n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateVolume() error = %v", err)
+			}
+			if got := resp.GetVolume().GetVolumeContext()[VolumeContextKeyNFSVersion]; got != tt.wantVersion {
+				t.Errorf("volume context %s = %q, want %q", VolumeContextKeyNFSVersion, got, tt.wantVersion)
+			}
+		})
+	}
+}
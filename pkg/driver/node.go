@@ -2,6 +2,7 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,6 +15,7 @@ import (
 	"github.com/fenio/tns-csi/pkg/tnsapi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 )
 
@@ -36,12 +38,30 @@ const (
 // NodeService implements the CSI Node service.
 type NodeService struct {
 	csi.UnimplementedNodeServer
-	apiClient       tnsapi.ClientInterface
-	nodeRegistry    *NodeRegistry
-	nvmeConnectSem  chan struct{}
-	nodeID          string
-	testMode        bool
-	enableDiscovery bool
+	apiClient         tnsapi.ClientInterface
+	nodeRegistry      *NodeRegistry
+	kube              kubernetes.Interface // Updates this node's Node object (nil = in-cluster client, none in test mode)
+	portalIPFamily    string               // Preferred portal address family (--portal-ip-family, "" = first listed)
+	lookupIP          lookupIPFunc         // Resolves server hostnames (nil = net.DefaultResolver.LookupIP)
+	krb5Keytab        string               // Keytab installed on the host for Kerberos NFS mounts (--nfs-krb5-keytab, "" = host-managed)
+	krb5HostEtc       string               // Host /etc mounted in the node container (--nfs-krb5-host-etc, "" = not mounted)
+	volumeMountGroup  bool                 // Advertise VOLUME_MOUNT_GROUP and apply fsGroups (--volume-mount-group)
+	hostNetNS         string               // Network namespace storage connections are made in (--nsenter-host-network; empty = the plugin's own)
+	nvmeConnectSem    chan struct{}
+	nfsServers        *nfsServerMap           // NFS server address mapping (nil = none)
+	maintenance       *maintenanceMode        // Maintenance switch (nil = never in maintenance)
+	contextMigrations volumeContextMigrations // Migrated contexts of staged volumes (volume_context_version.go)
+	features          FeatureGates            // Feature gates (--feature-gates, nil = defaults)
+	nfsRemounts       nfsRemountGuard
+	protocols         []string // Protocols set with --node-protocols (nil = auto-detect)
+	singleWriters     singleWriterTargets
+	proxy             csiProxy              // Host storage API of Windows nodes (nil elsewhere, see node_csiproxy.go)
+	stagers           map[string]nodeStager // Protocol implementations (see node_stager.go)
+	state             *nodeState            // Staged NVMe-oF volumes persisted across restarts (nil = disabled)
+	timeouts          Timeouts
+	nodeID            string
+	testMode          bool
+	enableDiscovery   bool
 }
 
 // NewNodeService creates a new node service.
@@ -49,7 +69,7 @@ func NewNodeService(nodeID string, apiClient tnsapi.ClientInterface, testMode bo
 	if maxConcurrentNVMeConnects <= 0 {
 		maxConcurrentNVMeConnects = 5
 	}
-	return &NodeService{
+	s := &NodeService{
 		nodeID:          nodeID,
 		apiClient:       apiClient,
 		testMode:        testMode,
@@ -57,12 +77,13 @@ func NewNodeService(nodeID string, apiClient tnsapi.ClientInterface, testMode bo
 		enableDiscovery: enableDiscovery,
 		nvmeConnectSem:  make(chan struct{}, maxConcurrentNVMeConnects),
 	}
+	s.stagers = newNodeStagers(s)
+	return s
 }
 
 // NodeStageVolume stages a volume to a staging path.
 func (s *NodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer("node", "stage")
-	klog.V(4).Infof("NodeStageVolume called with request: %+v", req)
 
 	if req.GetVolumeId() == "" {
 		timer.ObserveError()
@@ -81,7 +102,7 @@ func (s *NodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 
 	volumeID := req.GetVolumeId()
 	stagingTargetPath := req.GetStagingTargetPath()
-	volumeContext := req.GetVolumeContext()
+	volumeContext := s.migrateVolumeContext(ctx, volumeID, req.GetVolumeContext())
 
 	// Determine protocol from VolumeContext
 	// With plain volume IDs (just the volume name), all metadata is passed via VolumeContext
@@ -89,54 +110,34 @@ func (s *NodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 
 	klog.V(4).Infof("Staging volume %s (protocol: %s) to %s", volumeID, protocol, stagingTargetPath)
 
-	// Stage volume based on protocol
-	switch protocol {
-	case ProtocolNFS:
-		resp, err := s.stageNFSVolume(ctx, req, volumeContext)
-		if err != nil {
-			timer.ObserveError()
-			return nil, err
-		}
-		timer.ObserveSuccess()
-		return resp, nil
-
-	case ProtocolNVMeOF:
-		resp, err := s.stageNVMeOFVolume(ctx, req, volumeContext)
-		if err != nil {
-			timer.ObserveError()
-			return nil, err
-		}
-		timer.ObserveSuccess()
-		return resp, nil
-
-	case ProtocolISCSI:
-		resp, err := s.stageISCSIVolume(ctx, req, volumeContext)
-		if err != nil {
-			timer.ObserveError()
-			return nil, err
-		}
-		timer.ObserveSuccess()
-		return resp, nil
-
-	case ProtocolSMB:
-		resp, err := s.stageSMBVolume(ctx, req, volumeContext)
-		if err != nil {
-			timer.ObserveError()
-			return nil, err
-		}
-		timer.ObserveSuccess()
-		return resp, nil
+	if err := s.checkProtocolAllowed(protocol); err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
 
-	default:
+	// Stage volume based on protocol
+	stager := s.stagerFor(protocol)
+	if stager == nil {
 		timer.ObserveError()
 		return nil, status.Errorf(codes.InvalidArgument, "Unsupported protocol: %s (supported: nfs, nvmeof, iscsi, smb)", protocol)
 	}
+	resp, err := stager.Stage(ctx, req, volumeContext)
+	if err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+	// volumeOwner, volumeMode and VolumeMountGroup: adjust the root directory of the staged volume
+	if err := applyVolumeOwnership(req, volumeContext, protocol); err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+	timer.ObserveSuccess()
+	return resp, nil
 }
 
 // NodeUnstageVolume unstages a volume from a staging path.
 func (s *NodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer("node", "unstage")
-	klog.V(4).Infof("NodeUnstageVolume called with request: %+v", req)
 
 	if req.GetVolumeId() == "" {
 		timer.ObserveError()
@@ -155,62 +156,35 @@ func (s *NodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 	// NVMe-oF volumes use block devices, NFS volumes use NFS mounts
 	// Try to detect the mount type from the staging path
 	protocol := s.detectProtocolFromStagingPath(ctx, stagingTargetPath)
+	if _, ok := s.state.nvmeof(volumeID); ok {
+		// After a reboot the staging path is no longer mounted, but the volume was staged as NVMe-oF
+		protocol = ProtocolNVMeOF
+	}
 
 	klog.V(4).Infof("Unstaging volume %s (protocol: %s) from %s", volumeID, protocol, stagingTargetPath)
 
-	switch protocol {
-	case ProtocolNVMeOF:
-		// For NVMe-oF, we need to pass the NQN which is derived from the volume ID
-		// With independent subsystems, NQN format is: nqn.2137.csi.tns:<volumeID>
-		volumeContext := map[string]string{}
-		resp, err := s.unstageNVMeOFVolume(ctx, req, volumeContext)
-		if err != nil {
-			timer.ObserveError()
-			return nil, err
-		}
-		timer.ObserveSuccess()
-		return resp, nil
-
-	case ProtocolISCSI:
-		// For iSCSI, we need to pass the IQN which is derived from the volume ID
-		// IQN format is: iqn.2024-01.io.truenas.csi:<volumeID>
-		volumeContext := map[string]string{
-			VolumeContextKeyISCSIIQN: "iqn.2024-01.io.truenas.csi:" + volumeID,
-		}
-		resp, err := s.unstageISCSIVolume(ctx, req, volumeContext)
-		if err != nil {
-			timer.ObserveError()
-			return nil, err
-		}
-		timer.ObserveSuccess()
-		return resp, nil
-
-	case ProtocolSMB:
-		klog.V(4).Infof("Unstaging SMB volume %s from %s", volumeID, stagingTargetPath)
-		resp, err := s.unstageSMBVolume(ctx, req)
-		if err != nil {
-			timer.ObserveError()
-			return nil, err
-		}
-		timer.ObserveSuccess()
-		return resp, nil
-
-	default:
+	stager := s.stagerFor(protocol)
+	if stager == nil {
 		// Default to NFS volume unstaging
-		klog.V(4).Infof("Unstaging NFS volume %s from %s", volumeID, stagingTargetPath)
-		resp, err := s.unstageNFSVolume(ctx, req)
-		if err != nil {
-			timer.ObserveError()
-			return nil, err
-		}
-		timer.ObserveSuccess()
-		return resp, nil
+		stager = s.stagerFor(ProtocolNFS)
 	}
+	resp, err := stager.Unstage(ctx, req)
+	if err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+	s.contextMigrations.forget(volumeID)
+	timer.ObserveSuccess()
+	return resp, nil
 }
 
 // detectProtocolFromStagingPath attempts to detect the protocol from the staging path.
 // It checks the mount source to determine if it's a block device (NVMe-oF/iSCSI) or NFS mount.
 func (s *NodeService) detectProtocolFromStagingPath(ctx context.Context, stagingPath string) string {
+	if s.proxy != nil {
+		return csiProxyProtocolOfPath(stagingPath)
+	}
+
 	// Check if the path exists first
 	if _, err := os.Stat(stagingPath); os.IsNotExist(err) {
 		// Path doesn't exist, default to NFS (most common case for cleanup)
@@ -325,7 +299,6 @@ func (s *NodeService) isISCSIDevice(devicePath string) bool {
 // NodePublishVolume mounts the volume to the target path.
 func (s *NodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer("node", "publish")
-	klog.V(4).Infof("NodePublishVolume called with request: %+v", req)
 
 	if req.GetVolumeId() == "" {
 		timer.ObserveError()
@@ -351,61 +324,32 @@ func (s *NodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 
 	klog.V(4).Infof("Publishing volume %s (protocol: %s) to %s", volumeID, protocol, targetPath)
 
-	// Publish volume based on protocol
-	switch protocol {
-	case ProtocolNFS:
-		resp, respErr := s.publishNFSVolume(ctx, req)
-		if respErr != nil {
-			timer.ObserveError()
-			return nil, respErr
-		}
-		timer.ObserveSuccess()
-		return resp, nil
-
-	case ProtocolSMB:
-		resp, respErr := s.publishSMBVolume(ctx, req)
-		if respErr != nil {
-			timer.ObserveError()
-			return nil, respErr
-		}
-		timer.ObserveSuccess()
-		return resp, nil
-
-	case ProtocolNVMeOF, ProtocolISCSI:
-		// Block protocols (NVMe-oF and iSCSI) support both block and filesystem volume modes
-		stagingTargetPath := req.GetStagingTargetPath()
-		if stagingTargetPath == "" {
-			timer.ObserveError()
-			return nil, status.Errorf(codes.InvalidArgument, "Staging target path is required for %s volumes", protocol)
-		}
-
-		// Check volume capability to determine how to publish
-		var resp *csi.NodePublishVolumeResponse
-		var err error
-		if req.GetVolumeCapability().GetBlock() != nil {
-			// Block volume: staging path is a device file, bind mount it
-			resp, err = s.publishBlockVolume(ctx, stagingTargetPath, targetPath, req.GetReadonly())
-		} else {
-			// Filesystem volume: staging path is a mounted directory, bind mount the directory
-			resp, err = s.publishFilesystemVolume(ctx, stagingTargetPath, targetPath, req.GetReadonly())
-		}
-		if err != nil {
-			timer.ObserveError()
-			return nil, err
-		}
-		timer.ObserveSuccess()
-		return resp, nil
-
-	default:
+	if err := s.singleWriters.acquire(volumeID, targetPath, req.GetVolumeCapability()); err != nil {
+		timer.ObserveError()
+		return nil, err
+	}
+	resp, err := s.publishVolumeByProtocol(ctx, req, protocol)
+	if err != nil {
+		s.singleWriters.release(volumeID, targetPath)
 		timer.ObserveError()
+		return nil, err
+	}
+	timer.ObserveSuccess()
+	return resp, nil
+}
+
+// publishVolumeByProtocol publishes a volume with the protocol-specific implementation.
+func (s *NodeService) publishVolumeByProtocol(ctx context.Context, req *csi.NodePublishVolumeRequest, protocol string) (*csi.NodePublishVolumeResponse, error) {
+	stager := s.stagerFor(protocol)
+	if stager == nil {
 		return nil, status.Errorf(codes.InvalidArgument, "Unknown protocol: %s", protocol)
 	}
+	return stager.Publish(ctx, req)
 }
 
 // NodeUnpublishVolume unmounts the volume from the target path.
 func (s *NodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	timer := metrics.NewVolumeOperationTimer("node", "unpublish")
-	klog.V(4).Infof("NodeUnpublishVolume called with request: %+v", req)
 
 	if req.GetVolumeId() == "" {
 		timer.ObserveError()
@@ -429,6 +373,7 @@ func (s *NodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		if err := os.Remove(targetPath); err != nil && !os.IsNotExist(err) {
 			klog.Warningf("Failed to remove target path %s: %v", targetPath, err)
 		}
+		s.singleWriters.release(volumeID, targetPath)
 		timer.ObserveSuccess()
 		return &csi.NodeUnpublishVolumeResponse{}, nil
 	}
@@ -456,6 +401,7 @@ func (s *NodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		klog.Warningf("Failed to remove target path %s: %v", targetPath, err)
 	}
 
+	s.singleWriters.release(volumeID, targetPath)
 	klog.V(4).Infof("Unmounted volume %s from %s", volumeID, targetPath)
 	timer.ObserveSuccess()
 	return &csi.NodeUnpublishVolumeResponse{}, nil
@@ -463,8 +409,6 @@ func (s *NodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 
 // NodeGetVolumeStats returns volume capacity statistics.
 func (s *NodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	klog.V(4).Infof("NodeGetVolumeStats called with request: %+v", req)
-
 	if req.GetVolumeId() == "" {
 		return nil, status.Error(codes.InvalidArgument, errMsgVolumeIDRequired)
 	}
@@ -480,6 +424,9 @@ func (s *NodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 		if os.IsNotExist(err) {
 			return nil, status.Errorf(codes.NotFound, "Volume path %s does not exist", volumePath)
 		}
+		if errors.Is(err, syscall.ESTALE) && !s.testMode {
+			return s.staleNFSVolumeStats(ctx, req, "Stale NFS file handle"), nil
+		}
 		return nil, status.Errorf(codes.Internal, "Failed to stat volume path: %v", err)
 	}
 
@@ -511,19 +458,22 @@ func (s *NodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 		return nil, status.Errorf(codes.InvalidArgument, "Volume is not mounted at path %s", volumePath)
 	}
 
-	// Get filesystem statistics
-	var statfs syscall.Statfs_t
-	if err := syscall.Statfs(volumePath, &statfs); err != nil {
+	// Get filesystem statistics (statfs blocks while an NFS server is unreachable)
+	stats, err := statfsWithTimeout(ctx, volumePath, nfsStatTimeout)
+	if err != nil {
+		if errors.Is(err, syscall.ESTALE) {
+			return s.staleNFSVolumeStats(ctx, req, "Stale NFS file handle"), nil
+		}
+		if errors.Is(err, errStatfsTimeout) && s.detectProtocolFromVolumePath(ctx, volumePath) == ProtocolNFS {
+			return s.staleNFSVolumeStats(ctx, req, "NFS server not responding"), nil
+		}
 		return nil, status.Errorf(codes.Internal, "Failed to get volume stats: %v", err)
 	}
 
 	// Calculate capacity, used, and available bytes
-	// Note: statfs returns values in blocks, need to multiply by block size
-	// Use platform-specific helper to safely convert Bsize to uint64
-	blockSize := getBlockSize(&statfs)
-	totalBytes := statfs.Blocks * blockSize
-	availableBytes := statfs.Bavail * blockSize
-	usedBytes := totalBytes - (statfs.Bfree * blockSize)
+	totalBytes := stats.totalBytes
+	availableBytes := stats.availableBytes
+	usedBytes := totalBytes - stats.freeBytes
 
 	klog.V(4).Infof("Volume stats for %s: total=%d, used=%d, available=%d",
 		volumePath, totalBytes, usedBytes, availableBytes)
@@ -539,10 +489,10 @@ func (s *NodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 		},
 	}
 
-	// For directories (filesystem mounts), also report inode statistics
-	if pathInfo.IsDir() {
-		totalInodes := statfs.Files
-		freeInodes := statfs.Ffree
+	// For directories (filesystem mounts with inodes), also report inode statistics
+	if pathInfo.IsDir() && stats.totalInodes > 0 {
+		totalInodes := stats.totalInodes
+		freeInodes := stats.freeInodes
 		usedInodes := totalInodes - freeInodes
 
 		resp.Usage = append(resp.Usage, &csi.VolumeUsage{
@@ -558,6 +508,9 @@ func (s *NodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 
 	// Check volume health and add VolumeCondition to response
 	health := s.checkVolumeHealth(ctx, volumePath, req.GetStagingTargetPath())
+	if health.staleNFS {
+		health.Message = s.recoverNFSMount(ctx, req.GetVolumeId(), req.GetStagingTargetPath(), health.Message)
+	}
 	resp.VolumeCondition = health.ToCSI()
 
 	if health.Abnormal {
@@ -574,8 +527,6 @@ func (s *NodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 // For NVMe-oF block volumes, no action is needed.
 // For NVMe-oF filesystem volumes, we resize the filesystem.
 func (s *NodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-	klog.V(4).Infof("NodeExpandVolume called with request: %+v", req)
-
 	// Validate request
 	if req.GetVolumeId() == "" {
 		return nil, status.Error(codes.InvalidArgument, errMsgVolumeIDRequired)
@@ -615,51 +566,18 @@ func (s *NodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 
 	klog.V(4).Infof("Expanding volume %s (protocol: %s) at path %s", volumeID, protocol, volumePath)
 
-	// For NFS and SMB volumes, no node-side expansion is needed
-	if protocol == ProtocolNFS || protocol == ProtocolSMB {
-		klog.Infof("%s volume expansion handled by controller, no node-side action needed", strings.ToUpper(protocol))
-		return &csi.NodeExpandVolumeResponse{
-			CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
-		}, nil
-	}
-
-	// For block protocol volumes (NVMe-oF or iSCSI), check if this is a block or filesystem volume
-	volumeCap := req.GetVolumeCapability()
-	if volumeCap != nil && volumeCap.GetBlock() != nil {
-		klog.Info("Block volume expansion, no filesystem resize needed")
-		return &csi.NodeExpandVolumeResponse{
-			CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
-		}, nil
-	}
-
-	// For filesystem volumes, we need to resize the filesystem
-	klog.V(4).Infof("Resizing filesystem on volume path: %s", volumePath)
-
-	// Detect filesystem type
-	fsType, err := detectFilesystemType(ctx, volumePath)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to detect filesystem type: %v", err)
-	}
-
-	klog.V(4).Infof("Detected filesystem type: %s", fsType)
-
-	// Resize based on filesystem type
-	if err := resizeFilesystem(ctx, volumePath, fsType); err != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to resize filesystem: %v", err)
+	stager := s.stagerFor(protocol)
+	if stager == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Unknown protocol: %s", protocol)
 	}
-
-	klog.V(4).Infof("Resized filesystem for volume %s", volumeID)
-
-	return &csi.NodeExpandVolumeResponse{
-		CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
-	}, nil
+	return stager.Expand(ctx, req)
 }
 
 // NodeGetCapabilities returns node capabilities.
 func (s *NodeService) NodeGetCapabilities(_ context.Context, _ *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
 	klog.V(4).Info("NodeGetCapabilities called")
 
-	return &csi.NodeGetCapabilitiesResponse{
+	resp := &csi.NodeGetCapabilitiesResponse{
 		Capabilities: []*csi.NodeServiceCapability{
 			{
 				Type: &csi.NodeServiceCapability_Rpc{
@@ -697,16 +615,37 @@ func (s *NodeService) NodeGetCapabilities(_ context.Context, _ *csi.NodeGetCapab
 				},
 			},
 		},
-	}, nil
+	}
+	if s.volumeMountGroup {
+		// kubelet passes the pod's fsGroup to NodeStageVolume instead of changing ownership itself
+		resp.Capabilities = append(resp.Capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+				},
+			},
+		})
+	}
+	return resp, nil
 }
 
 // NodeGetInfo returns node information.
-func (s *NodeService) NodeGetInfo(_ context.Context, _ *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+func (s *NodeService) NodeGetInfo(ctx context.Context, _ *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
 	klog.V(4).Info("NodeGetInfo called")
 
-	// Register this node with the node registry
+	protocols := s.supportedProtocols(ctx)
+	klog.Infof("Node %s supports protocols: %v", s.nodeID, protocols)
+
+	// Publish the initiator identity (host NQN, IQN) and protocol labels on the Node object. The
+	// registry only reaches a controller in this process (single-process deployments, sanity tests).
+	identity := discoverNodeIdentity(s.nodeID)
+	if s.proxy != nil && identity.InitiatorIQN == "" {
+		identity.InitiatorIQN = s.csiProxyInitiatorIQN(ctx)
+	}
+	identity.Protocols = protocols
+	s.publishNodeInfo(ctx, identity)
 	if s.nodeRegistry != nil {
-		s.nodeRegistry.Register(s.nodeID)
+		s.nodeRegistry.RegisterIdentity(identity)
 		klog.V(4).Infof("Registered node %s with node registry", s.nodeID)
 	}
 
@@ -258,7 +258,8 @@ func getBlockDeviceSize(ctx context.Context, devicePath string) (int64, error) {
 	return actualSize, nil
 }
 
-// getExpectedCapacity retrieves the expected capacity from volumeContext or TrueNAS API.
+// getExpectedCapacity retrieves the expected capacity from volumeContext or TrueNAS API
+// (unless the node runs with --storage-api=false).
 func (s *NodeService) getExpectedCapacity(ctx context.Context, devicePath, datasetName string, volumeContext map[string]string) int64 {
 	// Try volume context first
 	if expectedCapacityStr := volumeContext["expectedCapacity"]; expectedCapacityStr != "" {
@@ -0,0 +1,440 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Windows nodes.
+//
+// Windows nodes cannot mount NFS exports or connect NVMe-oF namespaces, and have neither mount
+// nor iscsiadm. The Windows build of the node plugin stages SMB and iSCSI volumes through the
+// storage APIs of csi-proxy instead:
+//
+//   - SMB shares become SMB global mappings, made with the nodeStageSecretRef credentials
+//     (username, password and optional domain), and the staging path is a symbolic link to the
+//     share (\\server\share)
+//   - iSCSI targets are connected with the Microsoft iSCSI initiator; the target's disk is
+//     partitioned, formatted with NTFS unless it has a filesystem, and mounted at the staging
+//     path as a partition access path
+//
+// Published volumes are symbolic links to the staging path. The node plugin runs as a
+// HostProcess container and runs the cmdlets behind csi-proxy's API groups itself (the csi-proxy
+// v2 model), so no csi-proxy service has to be installed on the nodes.
+//
+// Windows nodes report smb and iscsi as their protocols (protocols.tns.csi.io labels) unless
+// --node-protocols lists others. They refuse raw block volumes and filesystems other than NTFS,
+// and ignore volumeOwner, volumeMode and VolumeMountGroup: NTFS and SMB volumes have ACLs.
+
+// goosWindows is runtime.GOOS on Windows nodes.
+const goosWindows = "windows"
+
+// fsTypeNTFS is the only filesystem iSCSI volumes are formatted with on Windows nodes.
+const fsTypeNTFS = "ntfs"
+
+// csiProxyProtocols are the protocols Windows nodes can mount.
+var csiProxyProtocols = []string{ProtocolSMB, ProtocolISCSI}
+
+// csiProxyDiskTimeout bounds the wait for the disk of a newly connected iSCSI target.
+const csiProxyDiskTimeout = 30 * time.Second
+
+// csiProxy is the host storage API of Windows nodes, following csi-proxy's SMB, iSCSI, Disk
+// and Volume API groups. Every call is idempotent.
+type csiProxy interface {
+	// NewSMBGlobalMapping maps an SMB share (\\server\share) for all users of the host.
+	NewSMBGlobalMapping(ctx context.Context, remotePath, username, password string) error
+	// RemoveSMBGlobalMapping removes the mapping of an SMB share.
+	RemoveSMBGlobalMapping(ctx context.Context, remotePath string) error
+	// ConnectISCSITarget adds the target portal and connects to a target, persistently.
+	ConnectISCSITarget(ctx context.Context, address, port, iqn string) error
+	// DisconnectISCSITarget disconnects from a target and forgets its persistent login.
+	DisconnectISCSITarget(ctx context.Context, iqn string) error
+	// ISCSITargetDisks returns the numbers of the disks of a connected target.
+	ISCSITargetDisks(ctx context.Context, iqn string) ([]string, error)
+	// InitiatorIQN returns the node name of the host's iSCSI initiator.
+	InitiatorIQN(ctx context.Context) (string, error)
+	// PartitionDisk brings a disk online and gives a raw disk a GPT partition filling it.
+	PartitionDisk(ctx context.Context, disk string) error
+	// DiskVolume returns the unique ID of the data volume of a partitioned disk.
+	DiskVolume(ctx context.Context, disk string) (string, error)
+	// FormatVolume formats a volume with NTFS unless it already has a filesystem.
+	FormatVolume(ctx context.Context, volumeID string) error
+	// MountVolume adds an access path (an empty directory) to a volume.
+	MountVolume(ctx context.Context, volumeID, path string) error
+	// UnmountVolume flushes the write cache of a volume and removes one of its access paths.
+	UnmountVolume(ctx context.Context, volumeID, path string) error
+	// VolumeAtPath returns the unique ID of the volume mounted at an access path.
+	VolumeAtPath(ctx context.Context, path string) (string, error)
+	// ResizeVolume grows the partition of a volume to the size of its disk.
+	ResizeVolume(ctx context.Context, volumeID string) error
+}
+
+// useCSIProxy makes a node service stage SMB and iSCSI volumes through proxy and, unless
+// --node-protocols was set, refuse the other protocols.
+func (s *NodeService) useCSIProxy(proxy csiProxy) {
+	s.proxy = proxy
+	s.registerStager(ProtocolSMB, windowsSMBStager{s: s, proxy: proxy})
+	s.registerStager(ProtocolISCSI, windowsISCSIStager{s: s, proxy: proxy})
+	if s.protocols == nil {
+		s.protocols = csiProxyProtocols
+	}
+}
+
+// csiProxyInitiatorIQN returns the initiator IQN of a Windows node ("" if unknown).
+func (s *NodeService) csiProxyInitiatorIQN(ctx context.Context) string {
+	iqn, err := s.proxy.InitiatorIQN(ctx)
+	if err != nil {
+		klog.Warningf("Failed to read the iSCSI initiator name of node %s: %v", s.nodeID, err)
+		return ""
+	}
+	return iqn
+}
+
+// csiProxyProtocolOfPath returns the protocol of a volume staged or published on a Windows
+// node: links ending at an SMB share are SMB volumes, everything else (partition access paths,
+// missing paths) iSCSI, whose unstaging also cleans up a half-unstaged volume.
+func csiProxyProtocolOfPath(path string) string {
+	if isUNCPath(resolveLinks(path)) {
+		return ProtocolSMB
+	}
+	return ProtocolISCSI
+}
+
+// resolveLinks follows the symbolic links at path. Mount points are not followed.
+func resolveLinks(path string) string {
+	for range 8 {
+		info, err := os.Lstat(path)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return path
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return path
+		}
+		if !isUNCPath(target) && !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return path
+}
+
+// isUNCPath reports whether path is an SMB share path (\\server\share, or UNC\server\share as
+// some link targets are reported).
+func isUNCPath(path string) bool {
+	return strings.HasPrefix(path, `\\`) || strings.HasPrefix(path, `UNC\`) || strings.HasPrefix(path, "//")
+}
+
+// isLinkOrMountPoint reports whether path is a symbolic link or a volume mount point.
+func isLinkOrMountPoint(path string) (bool, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return info.Mode()&(os.ModeSymlink|os.ModeIrregular) != 0, nil
+}
+
+// linkPath creates a symbolic link at link pointing to target, replacing the empty directory
+// kubelet may have created there.
+func linkPath(target, link string) error {
+	if err := os.MkdirAll(filepath.Dir(link), 0o750); err != nil {
+		return err
+	}
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(target, link)
+}
+
+// smbRemotePath returns the UNC path of an SMB share.
+func smbRemotePath(server, share string) string {
+	return `\\` + server + `\` + strings.ReplaceAll(share, "/", `\`)
+}
+
+// windowsSMBStager stages SMB volumes on Windows nodes as SMB global mappings.
+type windowsSMBStager struct {
+	s     *NodeService
+	proxy csiProxy
+}
+
+func (p windowsSMBStager) Stage(ctx context.Context, req *csi.NodeStageVolumeRequest, volumeContext map[string]string) (*csi.NodeStageVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	stagingTargetPath := req.GetStagingTargetPath()
+
+	server := volumeContext["server"]
+	share := volumeContext["share"]
+	if server == "" || share == "" {
+		return nil, status.Error(codes.InvalidArgument, "server and share must be provided in volume context for SMB volumes")
+	}
+	if err := checkWindowsCapability(req.GetVolumeCapability()); err != nil {
+		return nil, err
+	}
+
+	// Global mappings need credentials: Windows has no guest or Kerberos mapping for all users
+	secrets := req.GetSecrets()
+	username, domain := splitSMBUsername(secrets["username"], secrets["domain"])
+	if username == "" {
+		return nil, status.Error(codes.InvalidArgument, "SMB volumes on Windows nodes need a nodeStageSecretRef with username and password")
+	}
+	if domain != "" {
+		username = domain + `\` + username
+	}
+
+	remotePath := smbRemotePath(server, share)
+	klog.Infof("Staging SMB volume %s from %s to %s", volumeID, remotePath, stagingTargetPath)
+
+	linked, err := isLinkOrMountPoint(stagingTargetPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to check staging path: %v", err)
+	}
+	if linked {
+		klog.V(4).Infof("Staging path %s is already linked", stagingTargetPath)
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	if err := p.proxy.NewSMBGlobalMapping(ctx, remotePath, username, secrets["password"]); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to map SMB share %s: %v", remotePath, err)
+	}
+	if err := linkPath(remotePath, stagingTargetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to link staging path %s to %s: %v", stagingTargetPath, remotePath, err)
+	}
+
+	klog.V(4).Infof("Staged SMB volume %s at %s", volumeID, stagingTargetPath)
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func (p windowsSMBStager) Unstage(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	stagingTargetPath := req.GetStagingTargetPath()
+
+	klog.V(4).Infof("Unstaging SMB volume %s from %s", volumeID, stagingTargetPath)
+
+	remotePath, err := os.Readlink(stagingTargetPath)
+	if err != nil {
+		// Not linked (any more): only the directory kubelet created may be left
+		if removeErr := os.Remove(stagingTargetPath); removeErr != nil && !os.IsNotExist(removeErr) {
+			klog.Warningf("Failed to remove staging target path %s: %v", stagingTargetPath, removeErr)
+		}
+		return &csi.NodeUnstageVolumeResponse{}, nil
+	}
+
+	if err := os.Remove(stagingTargetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to remove SMB staging link %s: %v", stagingTargetPath, err)
+	}
+	if err := p.proxy.RemoveSMBGlobalMapping(ctx, remotePath); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to remove the mapping of SMB share %s: %v", remotePath, err)
+	}
+
+	klog.V(4).Infof("Unstaged SMB volume %s from %s", volumeID, stagingTargetPath)
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+func (p windowsSMBStager) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	return p.s.publishWindowsVolume(ctx, req)
+}
+
+func (windowsSMBStager) Expand(_ context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	return expandOnServer(ProtocolSMB, req), nil
+}
+
+func (windowsSMBStager) Stats(_ context.Context, volumePath string) VolumeHealth {
+	return checkBasicHealth(volumePath)
+}
+
+// windowsISCSIStager stages iSCSI volumes on Windows nodes as NTFS volumes mounted at the
+// staging path.
+type windowsISCSIStager struct {
+	s     *NodeService
+	proxy csiProxy
+}
+
+func (p windowsISCSIStager) Stage(ctx context.Context, req *csi.NodeStageVolumeRequest, volumeContext map[string]string) (*csi.NodeStageVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	stagingTargetPath := req.GetStagingTargetPath()
+
+	if err := checkWindowsCapability(req.GetVolumeCapability()); err != nil {
+		return nil, err
+	}
+	params, err := p.s.validateISCSIParams(volumeContext)
+	if err != nil {
+		return nil, err
+	}
+	if params.server, _, err = p.s.resolvePortal(ctx, params.server); err != nil {
+		return nil, err
+	}
+
+	klog.V(4).Infof("Staging iSCSI volume %s: server=%s:%s, IQN=%s", volumeID, params.server, params.port, params.iqn)
+
+	mounted, err := isLinkOrMountPoint(stagingTargetPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to check staging path: %v", err)
+	}
+	if mounted {
+		klog.V(4).Infof("Staging path %s is already mounted", stagingTargetPath)
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	if err := p.proxy.ConnectISCSITarget(ctx, params.server, params.port, params.iqn); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to connect to iSCSI target %s: %v", params.iqn, err)
+	}
+	disk, err := p.waitForTargetDisk(ctx, params.iqn)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.proxy.PartitionDisk(ctx, disk); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to partition disk %s of volume %s: %v", disk, volumeID, err)
+	}
+	volume, err := p.proxy.DiskVolume(ctx, disk)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to find the volume on disk %s of volume %s: %v", disk, volumeID, err)
+	}
+	if err := p.proxy.FormatVolume(ctx, volume); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to format volume %s: %v", volumeID, err)
+	}
+	// Restored and adopted ZVOLs CreateVolume grew still have the partition of their previous size
+	if volumeContext[VolumeContextKeyNodeExpansionRequired] == VolumeContextValueTrue && !isReadOnlyVolumeContext(volumeContext) {
+		if err := p.proxy.ResizeVolume(ctx, volume); err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to grow the partition of restored volume %s: %v", volumeID, err)
+		}
+	}
+
+	if err := os.MkdirAll(stagingTargetPath, 0o750); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to create staging target path: %v", err)
+	}
+	if err := p.proxy.MountVolume(ctx, volume, stagingTargetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to mount volume %s at %s: %v", volumeID, stagingTargetPath, err)
+	}
+
+	klog.V(4).Infof("Staged iSCSI volume %s at %s", volumeID, stagingTargetPath)
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+// waitForTargetDisk waits for the disk of a connected target to appear.
+func (p windowsISCSIStager) waitForTargetDisk(ctx context.Context, iqn string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, csiProxyDiskTimeout)
+	defer cancel()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		disks, err := p.proxy.ISCSITargetDisks(ctx, iqn)
+		if err == nil && len(disks) > 0 {
+			return disks[0], nil
+		}
+		if err != nil {
+			klog.V(4).Infof("Disks of iSCSI target %s not listed yet: %v", iqn, err)
+		}
+		select {
+		case <-ctx.Done():
+			return "", status.Errorf(codes.DeadlineExceeded, "%v: target %s", ErrISCSIDeviceTimeout, iqn)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p windowsISCSIStager) Unstage(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	stagingTargetPath := req.GetStagingTargetPath()
+
+	klog.V(4).Infof("Unstaging iSCSI volume %s from %s", volumeID, stagingTargetPath)
+
+	mounted, err := isLinkOrMountPoint(stagingTargetPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to check staging path: %v", err)
+	}
+	if mounted {
+		volume, err := p.proxy.VolumeAtPath(ctx, stagingTargetPath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to find the volume mounted at %s: %v", stagingTargetPath, err)
+		}
+		if err := p.proxy.UnmountVolume(ctx, volume, stagingTargetPath); err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to unmount staging path: %v", err)
+		}
+	}
+	if err := os.Remove(stagingTargetPath); err != nil && !os.IsNotExist(err) {
+		klog.Warningf("Failed to remove staging target path %s: %v", stagingTargetPath, err)
+	}
+
+	iqn := generateIQN(volumeID)
+	if err := p.proxy.DisconnectISCSITarget(ctx, iqn); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to disconnect from iSCSI target %s: %v", iqn, err)
+	}
+
+	klog.V(4).Infof("Unstaged iSCSI volume %s from %s", volumeID, stagingTargetPath)
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+func (p windowsISCSIStager) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	return p.s.publishWindowsVolume(ctx, req)
+}
+
+func (p windowsISCSIStager) Expand(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	volumePath := resolveLinks(req.GetVolumePath())
+	volume, err := p.proxy.VolumeAtPath(ctx, volumePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to find the volume mounted at %s: %v", volumePath, err)
+	}
+	if err := p.proxy.ResizeVolume(ctx, volume); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to resize volume %s: %v", req.GetVolumeId(), err)
+	}
+	return &csi.NodeExpandVolumeResponse{
+		CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+	}, nil
+}
+
+func (windowsISCSIStager) Stats(_ context.Context, volumePath string) VolumeHealth {
+	return checkBasicHealth(volumePath)
+}
+
+// checkWindowsCapability rejects volume capabilities Windows nodes cannot stage.
+func checkWindowsCapability(capability *csi.VolumeCapability) error {
+	if capability.GetBlock() != nil {
+		return status.Error(codes.InvalidArgument, "raw block volumes are not supported on Windows nodes")
+	}
+	if fsType := strings.ToLower(capability.GetMount().GetFsType()); fsType != "" && fsType != fsTypeNTFS {
+		return status.Errorf(codes.InvalidArgument, "filesystem %s is not supported on Windows nodes (only %s)", fsType, fsTypeNTFS)
+	}
+	return nil
+}
+
+// publishWindowsVolume publishes a staged volume on a Windows node by linking the target path
+// to the staging path. The container runtime enforces read-only volume mounts.
+func (s *NodeService) publishWindowsVolume(_ context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	targetPath := req.GetTargetPath()
+	stagingTargetPath := req.GetStagingTargetPath()
+
+	if stagingTargetPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "Staging target path is required on Windows nodes")
+	}
+	if err := checkWindowsCapability(req.GetVolumeCapability()); err != nil {
+		return nil, err
+	}
+
+	klog.V(4).Infof("Publishing volume %s from staging %s to %s", volumeID, stagingTargetPath, targetPath)
+
+	linked, err := isLinkOrMountPoint(targetPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to check target path: %v", err)
+	}
+	if linked {
+		klog.V(4).Infof("Path %s is already linked", targetPath)
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+	if err := linkPath(stagingTargetPath, targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to link %s to %s: %v", targetPath, stagingTargetPath, err)
+	}
+
+	klog.V(4).Infof("Published volume %s at %s", volumeID, targetPath)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
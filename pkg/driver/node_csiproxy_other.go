@@ -0,0 +1,8 @@
+//go:build !windows
+
+package driver
+
+// newHostCSIProxy returns nil: only Windows nodes stage volumes through csi-proxy.
+func newHostCSIProxy() csiProxy {
+	return nil
+}
@@ -0,0 +1,281 @@
+package driver
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeCSIProxy records the host storage calls of the Windows stagers. Mounted volumes are
+// symbolic links to a directory standing in for the volume.
+type fakeCSIProxy struct {
+	mappings     map[string]string // remote path -> username
+	connected    []string
+	disconnected []string
+	formatted    []string
+	resized      []string
+	mounts       map[string]string // access path -> volume ID
+	volumeDir    string
+}
+
+func newFakeCSIProxy(t *testing.T) *fakeCSIProxy {
+	t.Helper()
+	return &fakeCSIProxy{
+		mappings:  make(map[string]string),
+		mounts:    make(map[string]string),
+		volumeDir: t.TempDir(),
+	}
+}
+
+func (f *fakeCSIProxy) NewSMBGlobalMapping(_ context.Context, remotePath, username, _ string) error {
+	f.mappings[remotePath] = username
+	return nil
+}
+
+func (f *fakeCSIProxy) RemoveSMBGlobalMapping(_ context.Context, remotePath string) error {
+	delete(f.mappings, remotePath)
+	return nil
+}
+
+func (f *fakeCSIProxy) ConnectISCSITarget(_ context.Context, address, port, iqn string) error {
+	f.connected = append(f.connected, address+":"+port+"/"+iqn)
+	return nil
+}
+
+func (f *fakeCSIProxy) DisconnectISCSITarget(_ context.Context, iqn string) error {
+	f.disconnected = append(f.disconnected, iqn)
+	return nil
+}
+
+func (f *fakeCSIProxy) ISCSITargetDisks(context.Context, string) ([]string, error) {
+	return []string{"3"}, nil
+}
+
+func (f *fakeCSIProxy) InitiatorIQN(context.Context) (string, error) {
+	return "iqn.1991-05.com.microsoft:win-worker", nil
+}
+
+func (f *fakeCSIProxy) PartitionDisk(context.Context, string) error { return nil }
+
+func (f *fakeCSIProxy) DiskVolume(_ context.Context, disk string) (string, error) {
+	return `\\?\Volume{disk-` + disk + `}\`, nil
+}
+
+func (f *fakeCSIProxy) FormatVolume(_ context.Context, volumeID string) error {
+	f.formatted = append(f.formatted, volumeID)
+	return nil
+}
+
+func (f *fakeCSIProxy) MountVolume(_ context.Context, volumeID, path string) error {
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	f.mounts[path] = volumeID
+	return os.Symlink(f.volumeDir, path)
+}
+
+func (f *fakeCSIProxy) UnmountVolume(_ context.Context, _, path string) error {
+	delete(f.mounts, path)
+	return os.Remove(path)
+}
+
+func (f *fakeCSIProxy) VolumeAtPath(_ context.Context, path string) (string, error) {
+	return f.mounts[path], nil
+}
+
+func (f *fakeCSIProxy) ResizeVolume(_ context.Context, volumeID string) error {
+	f.resized = append(f.resized, volumeID)
+	return nil
+}
+
+// newCSIProxyNodeService returns a node service staging through a fake csi-proxy.
+func newCSIProxyNodeService(t *testing.T) (*NodeService, *fakeCSIProxy) {
+	t.Helper()
+	proxy := newFakeCSIProxy(t)
+	service := NewNodeService("win-worker", nil, true, nil, false, 5)
+	service.useCSIProxy(proxy)
+	return service, proxy
+}
+
+func fsTypeCapability(fsType string) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fsType}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+func TestCSIProxySMBVolumeLifecycle(t *testing.T) {
+	ctx := context.Background()
+	service, proxy := newCSIProxyNodeService(t)
+	dir := t.TempDir()
+	stagingPath := filepath.Join(dir, "globalmount")
+	targetPath := filepath.Join(dir, "pods", "mount")
+	remotePath := `\\10.0.0.1\pvc-1`
+
+	_, err := service.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          "tank/csi/pvc-1",
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  fsTypeCapability(""),
+		VolumeContext:     map[string]string{VolumeContextKeyProtocol: ProtocolSMB, "server": "10.0.0.1", "share": "pvc-1"},
+		Secrets:           map[string]string{"username": "alice@corp.example.com", "password": "secret"},
+	})
+	if err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+	if got := proxy.mappings[remotePath]; got != `corp.example.com\alice` {
+		t.Errorf("mapping user = %q, want corp.example.com\\alice", got)
+	}
+	if target, _ := os.Readlink(stagingPath); target != remotePath {
+		t.Errorf("staging path links to %q, want %q", target, remotePath)
+	}
+
+	_, err = service.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          "tank/csi/pvc-1",
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  fsTypeCapability(""),
+		VolumeContext:     map[string]string{VolumeContextKeyProtocol: ProtocolSMB},
+	})
+	if err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+	if got := csiProxyProtocolOfPath(targetPath); got != ProtocolSMB {
+		t.Errorf("protocol of published path = %s, want smb", got)
+	}
+
+	if _, err := service.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{
+		VolumeId:          "tank/csi/pvc-1",
+		StagingTargetPath: stagingPath,
+	}); err != nil {
+		t.Fatalf("NodeUnstageVolume() error = %v", err)
+	}
+	if _, err := os.Lstat(stagingPath); !os.IsNotExist(err) {
+		t.Errorf("staging link still exists: %v", err)
+	}
+	if _, ok := proxy.mappings[remotePath]; ok {
+		t.Error("SMB global mapping not removed")
+	}
+}
+
+func TestCSIProxySMBStageRequiresCredentials(t *testing.T) {
+	service, _ := newCSIProxyNodeService(t)
+	_, err := service.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "tank/csi/pvc-1",
+		StagingTargetPath: filepath.Join(t.TempDir(), "globalmount"),
+		VolumeCapability:  fsTypeCapability(""),
+		VolumeContext:     map[string]string{VolumeContextKeyProtocol: ProtocolSMB, "server": "10.0.0.1", "share": "pvc-1"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("NodeStageVolume() error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestCSIProxyISCSIVolumeLifecycle(t *testing.T) {
+	ctx := context.Background()
+	service, proxy := newCSIProxyNodeService(t)
+	stagingPath := filepath.Join(t.TempDir(), "globalmount")
+	volumeID := `\\?\Volume{disk-3}\`
+	iqn := generateIQN("pvc-2")
+
+	_, err := service.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          "pvc-2",
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  fsTypeCapability("NTFS"),
+		VolumeContext: map[string]string{
+			VolumeContextKeyProtocol:              ProtocolISCSI,
+			VolumeContextKeyISCSIIQN:              iqn,
+			VolumeContextKeyNodeExpansionRequired: VolumeContextValueTrue,
+			"server":                              "10.0.0.1",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+	if want := []string{"10.0.0.1:3260/" + iqn}; !slices.Equal(proxy.connected, want) {
+		t.Errorf("connected = %v, want %v", proxy.connected, want)
+	}
+	if !slices.Equal(proxy.formatted, []string{volumeID}) || !slices.Equal(proxy.resized, []string{volumeID}) {
+		t.Errorf("formatted = %v, resized = %v, want %s once each", proxy.formatted, proxy.resized, volumeID)
+	}
+	if proxy.mounts[stagingPath] != volumeID {
+		t.Errorf("volume mounted at staging path = %q, want %q", proxy.mounts[stagingPath], volumeID)
+	}
+	if got := csiProxyProtocolOfPath(stagingPath); got != ProtocolISCSI {
+		t.Errorf("protocol of staging path = %s, want iscsi", got)
+	}
+
+	if _, err := service.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{
+		VolumeId:          "pvc-2",
+		StagingTargetPath: stagingPath,
+	}); err != nil {
+		t.Fatalf("NodeUnstageVolume() error = %v", err)
+	}
+	if len(proxy.mounts) != 0 {
+		t.Errorf("volume still mounted: %v", proxy.mounts)
+	}
+	if !slices.Equal(proxy.disconnected, []string{iqn}) {
+		t.Errorf("disconnected = %v, want %s", proxy.disconnected, iqn)
+	}
+}
+
+func TestCSIProxyRejectsUnsupportedVolumes(t *testing.T) {
+	service, _ := newCSIProxyNodeService(t)
+	iscsiContext := map[string]string{VolumeContextKeyProtocol: ProtocolISCSI, VolumeContextKeyISCSIIQN: generateIQN("pvc-3"), "server": "10.0.0.1"}
+	tests := []struct {
+		name          string
+		capability    *csi.VolumeCapability
+		volumeContext map[string]string
+		want          codes.Code
+	}{
+		{
+			name: "block",
+			capability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			},
+			volumeContext: iscsiContext,
+			want:          codes.InvalidArgument,
+		},
+		{name: "ext4", capability: fsTypeCapability("ext4"), volumeContext: iscsiContext, want: codes.InvalidArgument},
+		{name: "nfs", capability: fsTypeCapability(""), volumeContext: map[string]string{VolumeContextKeyProtocol: ProtocolNFS}, want: codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          "pvc-" + tt.name,
+				StagingTargetPath: filepath.Join(t.TempDir(), "globalmount"),
+				VolumeCapability:  tt.capability,
+				VolumeContext:     tt.volumeContext,
+			})
+			if status.Code(err) != tt.want {
+				t.Errorf("NodeStageVolume() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnixSocketPath(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"unix:///csi/csi.sock", "/csi/csi.sock"},
+		{"unix:///C:/var/lib/kubelet/plugins/tns.csi.io/csi.sock", filepath.FromSlash("C:/var/lib/kubelet/plugins/tns.csi.io/csi.sock")},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.endpoint)
+		if err != nil {
+			t.Fatalf("url.Parse(%q) error = %v", tt.endpoint, err)
+		}
+		if got := unixSocketPath(u); got != tt.want {
+			t.Errorf("unixSocketPath(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+//go:build windows
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Static errors of the PowerShell storage API.
+var (
+	errNoVolumeOnDisk = errors.New("no volume found on disk")
+	errNotMountPoint  = errors.New("not a volume mount point")
+)
+
+// powershellProxy implements csiProxy with the storage cmdlets csi-proxy runs. Parameters are
+// passed to the scripts as environment variables and never interpolated into them.
+type powershellProxy struct{}
+
+// newHostCSIProxy returns the host storage API of this node.
+func newHostCSIProxy() csiProxy {
+	return powershellProxy{}
+}
+
+// runPowershell runs a script with extra environment variables ("NAME=value") and returns its
+// trimmed output.
+func runPowershell(ctx context.Context, script string, env ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Mta", "-Command",
+		"$ErrorActionPreference = 'Stop'; "+script)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%w, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// outputLines splits script output into its non-empty lines.
+func outputLines(output string) []string {
+	var lines []string
+	for line := range strings.SplitSeq(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func (powershellProxy) NewSMBGlobalMapping(ctx context.Context, remotePath, username, password string) error {
+	// A mapping whose connection broke (Status other than OK) is replaced
+	script := `$mapping = Get-SmbGlobalMapping -RemotePath $Env:smbremotepath -ErrorAction SilentlyContinue
+if ($mapping -and $mapping.Status -eq 'OK') { exit 0 }
+if ($mapping) { Remove-SmbGlobalMapping -RemotePath $Env:smbremotepath -Force }
+if ($Env:smbpassword) {
+  $password = ConvertTo-SecureString -String $Env:smbpassword -AsPlainText -Force
+} else {
+  $password = New-Object System.Security.SecureString
+}
+$credential = New-Object System.Management.Automation.PSCredential -ArgumentList $Env:smbuser, $password
+New-SmbGlobalMapping -RemotePath $Env:smbremotepath -Credential $credential | Out-Null`
+	_, err := runPowershell(ctx, script, "smbremotepath="+remotePath, "smbuser="+username, "smbpassword="+password)
+	return err
+}
+
+func (powershellProxy) RemoveSMBGlobalMapping(ctx context.Context, remotePath string) error {
+	script := `if (Get-SmbGlobalMapping -RemotePath $Env:smbremotepath -ErrorAction SilentlyContinue) {
+  Remove-SmbGlobalMapping -RemotePath $Env:smbremotepath -Force
+}`
+	_, err := runPowershell(ctx, script, "smbremotepath="+remotePath)
+	return err
+}
+
+func (powershellProxy) ConnectISCSITarget(ctx context.Context, address, port, iqn string) error {
+	// Existing portals are refreshed so targets created after the portal was added are found
+	script := `$portal = Get-IscsiTargetPortal -TargetPortalAddress $Env:iscsi_portal_address -TargetPortalPortNumber $Env:iscsi_portal_port -ErrorAction SilentlyContinue
+if ($portal) {
+  $portal | Update-IscsiTargetPortal | Out-Null
+} else {
+  New-IscsiTargetPortal -TargetPortalAddress $Env:iscsi_portal_address -TargetPortalPortNumber $Env:iscsi_portal_port | Out-Null
+}
+$target = Get-IscsiTarget -NodeAddress $Env:iscsi_target_iqn
+if (-not $target.IsConnected) {
+  Connect-IscsiTarget -NodeAddress $Env:iscsi_target_iqn -TargetPortalAddress $Env:iscsi_portal_address -TargetPortalPortNumber $Env:iscsi_portal_port -IsPersistent $true | Out-Null
+}`
+	_, err := runPowershell(ctx, script, "iscsi_portal_address="+address, "iscsi_portal_port="+port, "iscsi_target_iqn="+iqn)
+	return err
+}
+
+func (powershellProxy) DisconnectISCSITarget(ctx context.Context, iqn string) error {
+	script := `$target = Get-IscsiTarget -NodeAddress $Env:iscsi_target_iqn -ErrorAction SilentlyContinue
+if ($target -and $target.IsConnected) {
+  Get-IscsiSession -IscsiTarget $target | Where-Object IsPersistent | Unregister-IscsiSession
+  Disconnect-IscsiTarget -NodeAddress $Env:iscsi_target_iqn -Confirm:$false
+}`
+	_, err := runPowershell(ctx, script, "iscsi_target_iqn="+iqn)
+	return err
+}
+
+func (powershellProxy) ISCSITargetDisks(ctx context.Context, iqn string) ([]string, error) {
+	script := `Get-IscsiTarget -NodeAddress $Env:iscsi_target_iqn | Get-IscsiSession | Get-Disk | Select-Object -ExpandProperty Number`
+	output, err := runPowershell(ctx, script, "iscsi_target_iqn="+iqn)
+	if err != nil {
+		return nil, err
+	}
+	return outputLines(output), nil
+}
+
+func (powershellProxy) InitiatorIQN(ctx context.Context) (string, error) {
+	script := `(Get-InitiatorPort | Where-Object ConnectionType -eq 'iSCSI' | Select-Object -First 1).NodeAddress`
+	return runPowershell(ctx, script)
+}
+
+func (powershellProxy) PartitionDisk(ctx context.Context, disk string) error {
+	script := `$disk = Get-Disk -Number $Env:disk_number
+if ($disk.IsOffline) { Set-Disk -Number $Env:disk_number -IsOffline $false }
+if ($disk.IsReadOnly) { Set-Disk -Number $Env:disk_number -IsReadOnly $false }
+if ($disk.PartitionStyle -eq 'RAW') { Initialize-Disk -Number $Env:disk_number -PartitionStyle GPT }
+if (-not (Get-Partition -DiskNumber $Env:disk_number -ErrorAction SilentlyContinue | Where-Object Type -eq 'Basic')) {
+  New-Partition -DiskNumber $Env:disk_number -UseMaximumSize | Out-Null
+}`
+	_, err := runPowershell(ctx, script, "disk_number="+disk)
+	return err
+}
+
+func (powershellProxy) DiskVolume(ctx context.Context, disk string) (string, error) {
+	script := `(Get-Partition -DiskNumber $Env:disk_number | Where-Object Type -eq 'Basic' | Get-Volume | Select-Object -First 1).UniqueId`
+	output, err := runPowershell(ctx, script, "disk_number="+disk)
+	if err != nil {
+		return "", err
+	}
+	if output == "" {
+		return "", fmt.Errorf("%w: %s", errNoVolumeOnDisk, disk)
+	}
+	return output, nil
+}
+
+func (powershellProxy) FormatVolume(ctx context.Context, volumeID string) error {
+	script := `$volume = Get-Volume -UniqueId $Env:volume_id
+if ($volume.FileSystemType -eq 'Unknown') { $volume | Format-Volume -FileSystem NTFS -Confirm:$false | Out-Null }`
+	_, err := runPowershell(ctx, script, "volume_id="+volumeID)
+	return err
+}
+
+func (powershellProxy) MountVolume(ctx context.Context, volumeID, path string) error {
+	script := `Get-Volume -UniqueId $Env:volume_id | Get-Partition | Add-PartitionAccessPath -AccessPath $Env:mount_path`
+	_, err := runPowershell(ctx, script, "volume_id="+volumeID, "mount_path="+path)
+	return err
+}
+
+func (powershellProxy) UnmountVolume(ctx context.Context, volumeID, path string) error {
+	script := `$volume = Get-Volume -UniqueId $Env:volume_id
+$volume | Write-VolumeCache
+$volume | Get-Partition | Remove-PartitionAccessPath -AccessPath $Env:mount_path`
+	_, err := runPowershell(ctx, script, "volume_id="+volumeID, "mount_path="+path)
+	return err
+}
+
+func (powershellProxy) VolumeAtPath(ctx context.Context, path string) (string, error) {
+	// The target of a mount point is its volume's name without the \\?\ prefix of unique IDs
+	script := `(Get-Item -Path $Env:mount_path).Target`
+	output, err := runPowershell(ctx, script, "mount_path="+path)
+	if err != nil {
+		return "", err
+	}
+	lines := outputLines(output)
+	if len(lines) == 0 {
+		return "", fmt.Errorf("%w: %s", errNotMountPoint, path)
+	}
+	volumeID := lines[0]
+	if !strings.HasPrefix(volumeID, `\\?\`) {
+		volumeID = `\\?\` + volumeID
+	}
+	return volumeID, nil
+}
+
+func (powershellProxy) ResizeVolume(ctx context.Context, volumeID string) error {
+	// Resize-Partition refuses growth below its alignment, so only grow by more than 1 MB
+	script := `$partition = Get-Volume -UniqueId $Env:volume_id | Get-Partition
+Update-Disk -Number $partition.DiskNumber
+$size = ($partition | Get-PartitionSupportedSize).SizeMax
+if (($size - $partition.Size) -gt 1MB) { $partition | Resize-Partition -Size $size }`
+	_, err := runPowershell(ctx, script, "volume_id="+volumeID)
+	return err
+}
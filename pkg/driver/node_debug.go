@@ -0,0 +1,235 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Node state debug endpoint.
+//
+// With --debug-addr the node plugin serves its internal state as JSON at /debug/state: the
+// NVMe-oF and NFS volumes recorded as staged, whether their staging paths, mounts and NVMe-oF
+// sessions still exist, the node's NVMe-oF sessions, the single-writer publications and this
+// driver's mounts. Stuck unstages and leaked sessions show up as records without a mount or
+// sessions without a record. The endpoint is unauthenticated and the node plugin runs with hostNetwork, so it
+// only listens on loopback addresses; kubectl tns-csi node-state reaches it through
+// kubectl port-forward.
+
+// DebugStatePath is the path of the node state debug endpoint.
+const DebugStatePath = "/debug/state"
+
+// NodeDebugState is the node plugin state served at DebugStatePath.
+type NodeDebugState struct {
+	CollectedAt   time.Time               `json:"collectedAt"`
+	NodeID        string                  `json:"nodeID"`
+	NVMeOF        []NodeDebugNVMeOFVolume `json:"nvmeof"`
+	NFS           []NodeDebugNFSVolume    `json:"nfs"`
+	Sessions      []NodeDebugNVMeSession  `json:"sessions"`
+	SingleWriters map[string]string       `json:"singleWriters"` // volume ID -> target path
+	Mounts        []NodeDebugMount        `json:"mounts"`
+	StateFile     bool                    `json:"stateFile"` // false when --node-state-dir is not set
+}
+
+// NodeDebugNVMeOFVolume is a staged NVMe-oF volume with its live session state.
+type NodeDebugNVMeOFVolume struct {
+	StagedAt      time.Time `json:"stagedAt"`
+	VolumeID      string    `json:"volumeID"`
+	StagingPath   string    `json:"stagingPath"`
+	NQN           string    `json:"nqn"`
+	NSID          string    `json:"nsid,omitempty"`
+	Server        string    `json:"server"`
+	Endpoint      string    `json:"endpoint,omitempty"`
+	Transport     string    `json:"transport"`
+	SessionState  string    `json:"sessionState,omitempty"` // nvme list-subsys state, "" = no session
+	Block         bool      `json:"block"`
+	StagingExists bool      `json:"stagingExists"`
+}
+
+// NodeDebugNFSVolume is a staged NFS volume with its live mount state.
+type NodeDebugNFSVolume struct {
+	StagedAt    time.Time `json:"stagedAt"`
+	VolumeID    string    `json:"volumeID"`
+	StagingPath string    `json:"stagingPath"`
+	Server      string    `json:"server"`
+	Endpoint    string    `json:"endpoint,omitempty"`
+	Share       string    `json:"share"`
+	Mounted     bool      `json:"mounted"`
+}
+
+// NodeDebugNVMeSession is an NVMe-oF controller the node is connected to.
+type NodeDebugNVMeSession struct {
+	Controller string `json:"controller"`
+	NQN        string `json:"nqn"`
+	Transport  string `json:"transport"`
+	Address    string `json:"address"`
+	State      string `json:"state"`
+	Recorded   bool   `json:"recorded"` // a staged volume of the node state uses the subsystem
+}
+
+// NodeDebugMount is a CSI mount of this driver.
+type NodeDebugMount struct {
+	MountPoint string `json:"mountPoint"`
+	VolumeID   string `json:"volumeID"`
+	FSType     string `json:"fsType"`
+	Source     string `json:"source"`
+	Stale      bool   `json:"stale"` // the device behind the mount is gone
+}
+
+// ParseDebugAddr validates a --debug-addr value ("" = disabled): the host must be a loopback
+// address or localhost.
+func ParseDebugAddr(addr string) (string, error) {
+	if addr == "" {
+		return "", nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid debug address %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", fmt.Errorf("invalid debug address %q: the debug endpoint only listens on loopback addresses", addr)
+	}
+	return addr, nil
+}
+
+// debugStateHandler serves the node state of a node service.
+func debugStateHandler(node *NodeService, driverName string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		state := node.debugState(r.Context(), driverName)
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(state); err != nil {
+			klog.Warningf("Failed to write node debug state: %v", err)
+		}
+	})
+}
+
+// debugState collects the node state.
+func (s *NodeService) debugState(ctx context.Context, driverName string) *NodeDebugState {
+	state := &NodeDebugState{
+		CollectedAt:   time.Now().UTC(),
+		NodeID:        s.nodeID,
+		NVMeOF:        []NodeDebugNVMeOFVolume{},
+		NFS:           []NodeDebugNFSVolume{},
+		SingleWriters: s.singleWriters.snapshot(),
+		Sessions:      []NodeDebugNVMeSession{},
+		Mounts:        []NodeDebugMount{},
+		StateFile:     s.state != nil,
+	}
+
+	mounts, err := readMountInfo(procMountInfo)
+	if err != nil {
+		klog.Warningf("Node debug state without mounts: %v", err)
+	}
+	mounted := make(map[string]bool, len(mounts))
+	for i := range mounts {
+		m := &mounts[i]
+		mounted[m.MountPoint] = true
+		if !isCSIMountPoint(m.MountPoint) {
+			continue
+		}
+		volume := readCSIVolumeData(m.MountPoint)
+		if volume.DriverName != driverName {
+			continue
+		}
+		state.Mounts = append(state.Mounts, NodeDebugMount{
+			MountPoint: m.MountPoint,
+			VolumeID:   volume.VolumeHandle,
+			FSType:     m.FSType,
+			Source:     m.Source,
+			Stale:      isDeviceGone(m),
+		})
+	}
+
+	for _, vol := range s.state.nvmeofVolumes() {
+		_, statErr := os.Lstat(vol.StagingPath)
+		state.NVMeOF = append(state.NVMeOF, NodeDebugNVMeOFVolume{
+			StagedAt:      vol.StagedAt,
+			VolumeID:      vol.VolumeID,
+			StagingPath:   vol.StagingPath,
+			NQN:           vol.NQN,
+			NSID:          vol.NSID,
+			Server:        vol.Server,
+			Endpoint:      vol.Endpoint,
+			Transport:     vol.Transport,
+			SessionState:  getSubsystemState(ctx, vol.NQN),
+			Block:         vol.Block,
+			StagingExists: statErr == nil,
+		})
+	}
+	recorded := make(map[string]bool, len(state.NVMeOF))
+	for i := range state.NVMeOF {
+		recorded[state.NVMeOF[i].NQN] = true
+	}
+	for _, session := range readNVMeSessions(nvmeClassDir) {
+		session.Recorded = recorded[session.NQN]
+		state.Sessions = append(state.Sessions, session)
+	}
+
+	for _, vol := range s.state.nfsVolumes() {
+		state.NFS = append(state.NFS, NodeDebugNFSVolume{
+			StagedAt:    vol.StagedAt,
+			VolumeID:    vol.VolumeID,
+			StagingPath: vol.StagingPath,
+			Server:      vol.Server,
+			Endpoint:    vol.Endpoint,
+			Share:       vol.Share,
+			Mounted:     mounted[vol.StagingPath],
+		})
+	}
+
+	sort.Slice(state.NVMeOF, func(i, j int) bool { return state.NVMeOF[i].VolumeID < state.NVMeOF[j].VolumeID })
+	sort.Slice(state.NFS, func(i, j int) bool { return state.NFS[i].VolumeID < state.NFS[j].VolumeID })
+	sort.Slice(state.Mounts, func(i, j int) bool { return state.Mounts[i].MountPoint < state.Mounts[j].MountPoint })
+	return state
+}
+
+// nvmeClassDir lists the NVMe controllers of the node.
+const nvmeClassDir = "/sys/class/nvme"
+
+// nvmeDiscoveryNQN is the well-known NQN of NVMe-oF discovery controllers.
+const nvmeDiscoveryNQN = "nqn.2014-08.org.nvmexpress.discovery"
+
+// readNVMeSessions returns the fabrics controllers under dir (local PCIe controllers and
+// discovery controllers are skipped).
+func readNVMeSessions(dir string) []NodeDebugNVMeSession {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var sessions []NodeDebugNVMeSession
+	for _, entry := range entries {
+		name := entry.Name()
+		// Controllers are named nvme0, nvme1, ...; skip namespaces and nvme-fabrics
+		if !strings.HasPrefix(name, "nvme") || strings.ContainsAny(name[4:], "n-") {
+			continue
+		}
+		controllerDir := filepath.Join(dir, name)
+		session := NodeDebugNVMeSession{
+			Controller: name,
+			NQN:        readSysfsValue(filepath.Join(controllerDir, "subsysnqn")),
+			Transport:  readSysfsValue(filepath.Join(controllerDir, "transport")),
+			Address:    readSysfsValue(filepath.Join(controllerDir, "address")),
+			State:      readSysfsValue(filepath.Join(controllerDir, "state")),
+		}
+		if session.Transport == "pcie" || session.NQN == nvmeDiscoveryNQN {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
@@ -0,0 +1,93 @@
+package driver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestParseDebugAddr(t *testing.T) {
+	for _, addr := range []string{"", "127.0.0.1:9811", "[::1]:9811", "localhost:9811"} {
+		if _, err := ParseDebugAddr(addr); err != nil {
+			t.Errorf("ParseDebugAddr(%q) error = %v", addr, err)
+		}
+	}
+	for _, addr := range []string{":9811", "0.0.0.0:9811", "192.0.2.10:9811", "127.0.0.1"} {
+		if _, err := ParseDebugAddr(addr); err == nil {
+			t.Errorf("ParseDebugAddr(%q) succeeded, want error", addr)
+		}
+	}
+}
+
+func TestReadNVMeSessions(t *testing.T) {
+	dir := t.TempDir()
+	controllers := map[string]map[string]string{
+		"nvme0":        {"transport": "pcie", "subsysnqn": "nqn.local", "state": "live"},
+		"nvme1":        {"transport": "tcp", "subsysnqn": "nqn.2026-02.csi.tns:pvc-1", "state": "live", "address": "traddr=192.0.2.10,trsvcid=4420"},
+		"nvme2":        {"transport": "tcp", "subsysnqn": nvmeDiscoveryNQN, "state": "live"},
+		"nvme-fabrics": {},
+	}
+	for name, attrs := range controllers {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		for attr, value := range attrs {
+			if err := os.WriteFile(filepath.Join(dir, name, attr), []byte(value+"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	sessions := readNVMeSessions(dir)
+	if len(sessions) != 1 {
+		t.Fatalf("readNVMeSessions() = %+v, want only nvme1", sessions)
+	}
+	if got := sessions[0]; got.Controller != "nvme1" || got.NQN != "nqn.2026-02.csi.tns:pvc-1" || got.Address != "traddr=192.0.2.10,trsvcid=4420" {
+		t.Errorf("readNVMeSessions() = %+v", got)
+	}
+}
+
+func TestDebugStateHandler(t *testing.T) {
+	st, err := loadNodeState(t.TempDir())
+	if err != nil {
+		t.Fatalf("loadNodeState() error = %v", err)
+	}
+	st.recordNFS(&nfsStagedVolume{VolumeID: "tank/pvc-nfs", StagingPath: "/nonexistent/staging", Server: "192.0.2.10", Share: "/mnt/tank/pvc-nfs"})
+	node := &NodeService{nodeID: "worker-1", state: st}
+	singleWriter := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER},
+	}
+	if err := node.singleWriters.acquire("tank/pvc-rwop", "/pods/a", singleWriter); err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	debugStateHandler(node, "tns.csi.io").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DebugStatePath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var state NodeDebugState
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if state.NodeID != "worker-1" || !state.StateFile {
+		t.Errorf("state = %+v", state)
+	}
+	if len(state.NFS) != 1 || state.NFS[0].VolumeID != "tank/pvc-nfs" || state.NFS[0].Mounted {
+		t.Errorf("NFS = %+v, want tank/pvc-nfs not mounted", state.NFS)
+	}
+	if state.SingleWriters["tank/pvc-rwop"] != "/pods/a" {
+		t.Errorf("SingleWriters = %v", state.SingleWriters)
+	}
+
+	rec = httptest.NewRecorder()
+	debugStateHandler(node, "tns.csi.io").ServeHTTP(rec, httptest.NewRequest(http.MethodPost, DebugStatePath, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", rec.Code)
+	}
+}
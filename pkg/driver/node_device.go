@@ -165,8 +165,10 @@ func (s *NodeService) stageBlockDevice(devicePath, stagingTargetPath string) (*c
 		// Staging path exists - check if it's a valid symlink or device
 		klog.V(4).Infof("Staging path %s already exists", stagingTargetPath)
 		// Verify it points to the correct device
+		// Compare resolved paths: devicePath may itself be a link (e.g. /dev/disk/by-id/...)
 		targetDevice, err := filepath.EvalSymlinks(stagingTargetPath)
-		if err == nil && targetDevice == devicePath {
+		wantDevice, wantErr := filepath.EvalSymlinks(devicePath)
+		if err == nil && wantErr == nil && targetDevice == wantDevice {
 			klog.V(4).Infof("Staging path already points to correct device")
 			return &csi.NodeStageVolumeResponse{}, nil
 		}
@@ -484,6 +486,10 @@ func handleFinalResult(devicePath string, maxRetries int, lastOutput []byte, las
 // getLogicalSectorSize reads the logical block size for a device from sysfs.
 // devicePath should be an absolute path like /dev/nvme0n1 or /dev/sda.
 func getLogicalSectorSize(devicePath string) (int, error) {
+	// Resolve /dev/mapper/* and other symlinks to the kernel device name (e.g. dm-3)
+	if resolved, err := filepath.EvalSymlinks(devicePath); err == nil {
+		devicePath = resolved
+	}
 	devName := filepath.Base(devicePath)
 	sysPath := "/sys/block/" + devName + "/queue/logical_block_size"
 	data, err := os.ReadFile(sysPath) //nolint:gosec // path is constructed from filepath.Base output, not arbitrary user input
@@ -2,6 +2,7 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -287,3 +288,66 @@ func TestWaitForNVMeStabilization(t *testing.T) {
 		}
 	})
 }
+
+func TestStableNVMeDevicePath(t *testing.T) {
+	const nqn = "nqn.2026-02.csi.tns:pvc-1"
+	dir := t.TempDir()
+	origSysBlockDir, origDiskByIDDir := sysBlockDir, diskByIDDir
+	sysBlockDir = filepath.Join(dir, "sys", "block")
+	diskByIDDir = filepath.Join(dir, "by-id")
+	defer func() { sysBlockDir, diskByIDDir = origSysBlockDir, origDiskByIDDir }()
+
+	writeFile := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(target, path string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// nvme0n1 has a UUID link, nvme1n1 only a WWID link, nvme2n1 no link, nvme3n1 is another subsystem
+	devDir := filepath.Join(dir, "dev")
+	for _, dev := range []string{"nvme0n1", "nvme1n1", "nvme2n1", "nvme3n1"} {
+		writeFile(filepath.Join(devDir, dev), "")
+		writeFile(filepath.Join(sysBlockDir, dev, "device", "subsysnqn"), nqn+"\n")
+	}
+	writeFile(filepath.Join(sysBlockDir, "nvme0n1", "uuid"), "6c2a1e6d-0b5e-4a3c-9d2f-2b8e0f6a7c11\n")
+	link(filepath.Join(devDir, "nvme0n1"), filepath.Join(diskByIDDir, "nvme-uuid.6c2a1e6d-0b5e-4a3c-9d2f-2b8e0f6a7c11"))
+	writeFile(filepath.Join(sysBlockDir, "nvme1n1", "uuid"), nvmeNullUUID+"\n")
+	writeFile(filepath.Join(sysBlockDir, "nvme1n1", "wwid"), "eui.6479a77cd0000001\n")
+	link(filepath.Join(devDir, "nvme1n1"), filepath.Join(diskByIDDir, "nvme-eui.6479a77cd0000001"))
+	writeFile(filepath.Join(sysBlockDir, "nvme3n1", "device", "subsysnqn"), "nqn.2026-02.csi.tns:pvc-other\n")
+
+	tests := []struct {
+		wantErr error
+		device  string
+		want    string
+	}{
+		{device: "nvme0n1", want: filepath.Join(diskByIDDir, "nvme-uuid.6c2a1e6d-0b5e-4a3c-9d2f-2b8e0f6a7c11")},
+		{device: "nvme1n1", want: filepath.Join(diskByIDDir, "nvme-eui.6479a77cd0000001")},
+		{device: "nvme2n1", want: filepath.Join(devDir, "nvme2n1")},
+		{device: "nvme3n1", wantErr: ErrNVMeDeviceNQNMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.device, func(t *testing.T) {
+			got, err := stableNVMeDevicePath(filepath.Join(devDir, tt.device), nqn)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("stableNVMeDevicePath() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("stableNVMeDevicePath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
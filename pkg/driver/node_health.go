@@ -32,6 +32,7 @@ var sysClassNVMePath = "/sys/class/nvme"
 type VolumeHealth struct {
 	Message  string
 	Abnormal bool
+	staleNFS bool // NFS mount is stale or unresponsive and can be remounted
 }
 
 // Healthy returns a VolumeHealth indicating the volume is healthy.
@@ -50,6 +51,11 @@ func Unhealthy(message string) VolumeHealth {
 	}
 }
 
+// unhealthyStaleNFS returns a VolumeHealth for an NFS mount that needs remounting.
+func unhealthyStaleNFS(message string) VolumeHealth {
+	return VolumeHealth{Abnormal: true, Message: message, staleNFS: true}
+}
+
 // ToCSI converts VolumeHealth to a CSI VolumeCondition.
 func (h VolumeHealth) ToCSI() *csi.VolumeCondition {
 	return &csi.VolumeCondition{
@@ -66,23 +72,20 @@ func (s *NodeService) checkVolumeHealth(ctx context.Context, volumePath, _ strin
 
 	klog.V(4).Infof("Checking health for volume at %s (protocol: %s)", volumePath, protocol)
 
-	switch protocol {
-	case ProtocolNFS:
-		return s.checkNFSHealth(ctx, volumePath)
-	case ProtocolNVMeOF:
-		return s.checkNVMeOFHealth(ctx, volumePath)
-	case ProtocolISCSI:
-		return s.checkISCSIHealth(ctx, volumePath)
-	case ProtocolSMB:
-		return s.checkSMBHealth(ctx, volumePath)
-	default:
+	stager := s.stagerFor(protocol)
+	if stager == nil {
 		// Unknown protocol - just check if path is accessible
 		return checkBasicHealth(volumePath)
 	}
+	return stager.Stats(ctx, volumePath)
 }
 
 // detectProtocolFromVolumePath detects the protocol from the volume path.
 func (s *NodeService) detectProtocolFromVolumePath(ctx context.Context, volumePath string) string {
+	if s.proxy != nil {
+		return csiProxyProtocolOfPath(volumePath)
+	}
+
 	// Check the filesystem type using findmnt
 	fsType, err := detectFilesystemType(ctx, volumePath)
 	if err != nil {
@@ -113,7 +116,7 @@ func (s *NodeService) checkNFSHealth(ctx context.Context, volumePath string) Vol
 		}
 		// Check for stale NFS handle
 		if strings.Contains(err.Error(), "stale") || strings.Contains(err.Error(), "Stale") {
-			return Unhealthy("Stale NFS file handle")
+			return unhealthyStaleNFS("Stale NFS file handle")
 		}
 		return Unhealthy(fmt.Sprintf("NFS mount path not accessible: %v", err))
 	}
@@ -130,7 +133,10 @@ func (s *NodeService) checkNFSHealth(ctx context.Context, volumePath string) Vol
 	// Check 3: Try to read the directory (detects stale handles that stat might miss)
 	if err := checkDirectoryReadable(ctx, volumePath); err != nil {
 		if strings.Contains(err.Error(), "stale") || strings.Contains(err.Error(), "Stale") {
-			return Unhealthy("Stale NFS file handle")
+			return unhealthyStaleNFS("Stale NFS file handle")
+		}
+		if errors.Is(err, errReadTimeout) {
+			return unhealthyStaleNFS("NFS server not responding")
 		}
 		return Unhealthy(fmt.Sprintf("NFS mount not readable: %v", err))
 	}
@@ -296,8 +302,11 @@ func getSourceDevice(ctx context.Context, mountPath string) (string, error) {
 
 // getNVMeControllerState reads the NVMe controller state from sysfs.
 func getNVMeControllerState(devicePath string) (string, error) {
-	// Device path is like /dev/nvme0n1 or /dev/nvme0n1p1
+	// Device path is like /dev/nvme0n1 or /dev/nvme0n1p1, possibly behind a by-id link
 	// We need to extract the controller name (nvme0)
+	if resolved, err := filepath.EvalSymlinks(devicePath); err == nil {
+		devicePath = resolved
+	}
 	base := filepath.Base(devicePath)
 	if !strings.HasPrefix(base, "nvme") {
 		return "", fmt.Errorf("%w: %s", errNotNVMeDevice, devicePath)
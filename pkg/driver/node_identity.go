@@ -0,0 +1,180 @@
+package driver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// Node identity publishing.
+//
+// The controller runs in its own pod and never sees the node plugin's process, so the node plugin
+// writes its initiator identity to its Node object in NodeGetInfo: the host NQN and the initiator
+// IQN become tns.csi.io/ annotations, which the controller reads when it grants a node access to a
+// volume (--host-access-control, controller_host_access.go). Node addresses are not duplicated:
+// they are in the Node status already. Identities that are missing on the node (no nvme-cli or
+// open-iscsi) remove the annotation, so a stale NQN never stays authorized. The same patch sets
+// the per-protocol labels (node_protocols.go).
+
+// Node identity annotations.
+const (
+	NodeAnnotationHostNQN      = "tns.csi.io/nvme-host-nqn"
+	NodeAnnotationInitiatorIQN = "tns.csi.io/iscsi-initiator-iqn"
+)
+
+// Host identity files. The /proc/1/root variants are the host root filesystem
+// when the node plugin runs with hostPID (the initiator identity lives on the host).
+var (
+	hostNQNPaths       = []string{"/proc/1/root/etc/nvme/hostnqn", "/etc/nvme/hostnqn"}
+	initiatorNamePaths = []string{"/proc/1/root/etc/iscsi/initiatorname.iscsi", "/etc/iscsi/initiatorname.iscsi"}
+)
+
+// discoverNodeIdentity collects the NVMe host NQN, iSCSI initiator IQN and IP addresses of this node.
+// Missing pieces are left empty: a node without nvme-cli or open-iscsi simply has no identity for that protocol.
+func discoverNodeIdentity(nodeID string) NodeIdentity {
+	identity := NodeIdentity{
+		NodeID:       nodeID,
+		HostNQN:      readHostNQN(),
+		InitiatorIQN: readInitiatorIQN(),
+		IPs:          nodeIPAddresses(),
+	}
+	klog.V(4).Infof("Discovered node identity: node=%s, hostNQN=%q, initiatorIQN=%q, IPs=%v",
+		identity.NodeID, identity.HostNQN, identity.InitiatorIQN, identity.IPs)
+	return identity
+}
+
+// readHostNQN reads the NVMe host NQN.
+func readHostNQN() string {
+	for _, path := range hostNQNPaths {
+		data, err := os.ReadFile(path) //nolint:gosec // fixed well-known host path
+		if err != nil {
+			continue
+		}
+		if nqn := strings.TrimSpace(string(data)); nqn != "" {
+			return nqn
+		}
+	}
+	return ""
+}
+
+// readInitiatorIQN reads the iSCSI initiator name ("InitiatorName=iqn....").
+func readInitiatorIQN() string {
+	for _, path := range initiatorNamePaths {
+		if iqn := parseInitiatorNameFile(path); iqn != "" {
+			return iqn
+		}
+	}
+	return ""
+}
+
+// parseInitiatorNameFile extracts the InitiatorName value from an open-iscsi initiatorname file.
+func parseInitiatorNameFile(path string) string {
+	f, err := os.Open(path) //nolint:gosec // fixed well-known host path
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "InitiatorName="); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// nodeIPAddresses returns the non-loopback, non-link-local unicast addresses of this node.
+func nodeIPAddresses() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		klog.V(4).Infof("Failed to list interface addresses: %v", err)
+		return nil
+	}
+
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+			continue
+		}
+		ips = append(ips, ip.String())
+	}
+	return ips
+}
+
+// nodeClient returns the Kubernetes client the node plugin updates its Node object with.
+func (s *NodeService) nodeClient() (kubernetes.Interface, error) {
+	if s.kube != nil {
+		return s.kube, nil
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// nodeInfoPatch builds the merge patch setting the identity annotations and protocol labels of a
+// node. Empty identities are sent as null, which removes the annotation.
+func nodeInfoPatch(identity NodeIdentity) ([]byte, error) {
+	annotations := map[string]interface{}{}
+	for key, value := range map[string]string{
+		NodeAnnotationHostNQN:      identity.HostNQN,
+		NodeAnnotationInitiatorIQN: identity.InitiatorIQN,
+	} {
+		if value == "" {
+			annotations[key] = nil
+		} else {
+			annotations[key] = value
+		}
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+			"labels":      protocolLabels(identity.Protocols),
+		},
+	})
+}
+
+// publishNodeInfo writes the identity annotations and protocol labels to this node's Node object.
+// Failures are logged, not returned: the node plugin must register even when the API server is
+// unreachable, and kubelet calls NodeGetInfo again on every plugin restart.
+func (s *NodeService) publishNodeInfo(ctx context.Context, identity NodeIdentity) {
+	if s.kube == nil && s.testMode {
+		return
+	}
+	kube, err := s.nodeClient()
+	if err != nil {
+		klog.Warningf("Node info not published to Node %s: %v", s.nodeID, err)
+		return
+	}
+	patch, err := nodeInfoPatch(identity)
+	if err != nil {
+		klog.Warningf("Node info not published to Node %s: %v", s.nodeID, err)
+		return
+	}
+	if _, err := kube.CoreV1().Nodes().Patch(ctx, s.nodeID, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		klog.Warningf("Node info not published to Node %s: %v", s.nodeID, err)
+		return
+	}
+	klog.V(4).Infof("Published node info to Node %s (hostNQN=%q, initiatorIQN=%q, protocols=%v)", s.nodeID, identity.HostNQN, identity.InitiatorIQN, identity.Protocols)
+}
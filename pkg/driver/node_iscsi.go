@@ -32,22 +32,34 @@ var defaultISCSIMountOptions = []string{zfsNoatime, "_netdev"}
 // iscsiadmCmd builds a command to run iscsiadm, using nsenter to execute
 // in the host's namespaces when running in a container. This allows the
 // container to use the host's iscsid daemon.
-func iscsiadmCmd(ctx context.Context, args ...string) *exec.Cmd {
+func (s *NodeService) iscsiadmCmd(ctx context.Context, args ...string) *exec.Cmd {
+	return s.hostCmd(ctx, "iscsiadm", args...)
+}
+
+// hostCmd builds a command that runs in the host's mount and IPC namespaces when
+// running in a container, so host daemons (iscsid, multipathd) and their config are used.
+// With --nsenter-host-network it also enters the host's network namespace, where the
+// daemons' abstract sockets are.
+func (s *NodeService) hostCmd(ctx context.Context, name string, args ...string) *exec.Cmd {
 	// Check if we're in a container by looking for /proc/1/ns/mnt
 	// If accessible and we have hostPID, use nsenter to run in host namespace
 	if _, err := os.Stat("/proc/1/ns/mnt"); err == nil {
 		// Use nsenter to enter host's mount namespace (for /etc/iscsi, /run)
 		// and IPC namespace (for iscsid communication)
-		nsenterArgs := make([]string, 0, 4+len(args))
-		nsenterArgs = append(nsenterArgs, "--mount=/proc/1/ns/mnt", "--ipc=/proc/1/ns/ipc", "--", "iscsiadm")
+		nsenterArgs := make([]string, 0, 5+len(args))
+		nsenterArgs = append(nsenterArgs, "--mount=/proc/1/ns/mnt", "--ipc=/proc/1/ns/ipc")
+		if s.hostNetNS != "" {
+			nsenterArgs = append(nsenterArgs, "--net="+s.hostNetNS)
+		}
+		nsenterArgs = append(nsenterArgs, "--", name)
 		nsenterArgs = append(nsenterArgs, args...)
-		klog.V(5).Infof("Running iscsiadm via nsenter: nsenter %v", nsenterArgs)
+		klog.V(5).Infof("Running %s via nsenter: nsenter %v", name, nsenterArgs)
 		return exec.CommandContext(ctx, "nsenter", nsenterArgs...)
 	}
 
 	// Not in container or no access to host namespaces - run directly
-	klog.V(5).Infof("Running iscsiadm directly: iscsiadm %v", args)
-	return exec.CommandContext(ctx, "iscsiadm", args...)
+	klog.V(5).Infof("Running %s directly: %s %v", name, name, args)
+	return exec.CommandContext(ctx, name, args...)
 }
 
 // iscsiConnectionParams holds validated iSCSI connection parameters.
@@ -70,6 +82,9 @@ func (s *NodeService) stageISCSIVolume(ctx context.Context, req *csi.NodeStageVo
 	if err != nil {
 		return nil, err
 	}
+	if params.server, _, err = s.resolvePortal(ctx, params.server); err != nil {
+		return nil, err
+	}
 
 	isBlockVolume := volumeCapability.GetBlock() != nil
 	datasetName := volumeContext["datasetName"]
@@ -111,8 +126,11 @@ func (s *NodeService) stageISCSIVolume(ctx context.Context, req *csi.NodeStageVo
 			continue
 		}
 
-		// Wait for device to appear
+		// Wait for device to appear (and for dm-multipath to aggregate paths, if configured)
 		devicePath, err := s.waitForISCSIDevice(ctx, params, 30*time.Second)
+		if err == nil {
+			devicePath = s.waitForISCSIMultipath(ctx, params, devicePath)
+		}
 		if err != nil {
 			lastErr = err
 			klog.Warningf("iSCSI device wait failed on attempt %d: %v", attempt, err)
@@ -161,7 +179,7 @@ func (s *NodeService) stageISCSIVolume(ctx context.Context, req *csi.NodeStageVo
 func (s *NodeService) validateISCSIParams(volumeContext map[string]string) (*iscsiConnectionParams, error) {
 	params := &iscsiConnectionParams{
 		iqn:    volumeContext[VolumeContextKeyISCSIIQN],
-		server: volumeContext["server"],
+		server: s.portalAddress(volumeContext["server"]),
 		port:   volumeContext["port"],
 		lun:    0, // Always LUN 0 with dedicated targets
 	}
@@ -187,7 +205,7 @@ func (s *NodeService) validateISCSIParams(volumeContext map[string]string) (*isc
 func (s *NodeService) checkISCSIAdm(ctx context.Context) error {
 	checkCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	cmd := iscsiadmCmd(checkCtx, "--version")
+	cmd := s.iscsiadmCmd(checkCtx, "--version")
 	if err := cmd.Run(); err != nil {
 		return ErrISCSIAdmNotFound
 	}
@@ -196,14 +214,14 @@ func (s *NodeService) checkISCSIAdm(ctx context.Context) error {
 
 // loginISCSITarget discovers and logs into an iSCSI target.
 func (s *NodeService) loginISCSITarget(ctx context.Context, params *iscsiConnectionParams) error {
-	portal := params.server + ":" + params.port
+	portal := portalHostPort(params.server, params.port)
 
 	// Step 1: Discovery
 	klog.Infof("iSCSI: Discovering targets at portal %s for IQN %s", portal, params.iqn)
 	discoverCtx, discoverCancel := context.WithTimeout(ctx, 30*time.Second)
 	defer discoverCancel()
 
-	discoverCmd := iscsiadmCmd(discoverCtx, "-m", "discovery", "-t", "sendtargets", "-p", portal)
+	discoverCmd := s.iscsiadmCmd(discoverCtx, "-m", "discovery", "-t", "sendtargets", "-p", portal)
 	output, err := discoverCmd.CombinedOutput()
 	if err != nil {
 		// Log the discovery error - this is critical for debugging
@@ -225,7 +243,7 @@ func (s *NodeService) loginISCSITarget(ctx context.Context, params *iscsiConnect
 	klog.Infof("iSCSI: Checking if target '%s' is in node database", params.iqn)
 	checkCtx, checkCancel := context.WithTimeout(ctx, 5*time.Second)
 	defer checkCancel()
-	checkCmd := iscsiadmCmd(checkCtx, "-m", "node", "-T", params.iqn)
+	checkCmd := s.iscsiadmCmd(checkCtx, "-m", "node", "-T", params.iqn)
 	klog.Infof("iSCSI: Running node check command: iscsiadm -m node -T %s", params.iqn)
 	checkOutput, checkErr := checkCmd.CombinedOutput()
 	if checkErr != nil {
@@ -241,7 +259,7 @@ func (s *NodeService) loginISCSITarget(ctx context.Context, params *iscsiConnect
 	loginCtx, loginCancel := context.WithTimeout(ctx, 30*time.Second)
 	defer loginCancel()
 
-	loginCmd := iscsiadmCmd(loginCtx, "-m", "node", "-T", params.iqn, "--login")
+	loginCmd := s.iscsiadmCmd(loginCtx, "-m", "node", "-T", params.iqn, "--login")
 	output, err = loginCmd.CombinedOutput()
 	if err != nil {
 		// Check if already logged in
@@ -266,7 +284,7 @@ func (s *NodeService) logoutISCSITarget(ctx context.Context, params *iscsiConnec
 	defer cancel()
 
 	// Don't specify portal - logout from target on all portals
-	cmd := iscsiadmCmd(logoutCtx, "-m", "node", "-T", params.iqn, "--logout")
+	cmd := s.iscsiadmCmd(logoutCtx, "-m", "node", "-T", params.iqn, "--logout")
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		// Check if already logged out
@@ -289,7 +307,7 @@ func (s *NodeService) findISCSIDevice(ctx context.Context, params *iscsiConnecti
 	sessionCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	cmd := iscsiadmCmd(sessionCtx, "-m", "session", "-P", "3")
+	cmd := s.iscsiadmCmd(sessionCtx, "-m", "session", "-P", "3")
 	output, err := cmd.CombinedOutput()
 
 	// Always log the output for debugging
@@ -299,22 +317,30 @@ func (s *NodeService) findISCSIDevice(ctx context.Context, params *iscsiConnecti
 		return "", ErrISCSIDeviceNotFound
 	}
 
-	deviceName := parseISCSISessionDevice(string(output), params.iqn)
-	if deviceName == "" {
-		klog.Infof("parseISCSISessionDevice found no device for IQN: %s", params.iqn)
+	deviceNames := parseISCSISessionDevices(string(output), params.iqn)
+	if len(deviceNames) == 0 {
+		klog.Infof("parseISCSISessionDevices found no device for IQN: %s", params.iqn)
 		return "", ErrISCSIDeviceNotFound
 	}
 
-	devicePath := "/dev/" + deviceName
+	// With dm-multipath, every session path is aggregated into one /dev/mapper device
+	if mapperPath := findMultipathDevice(deviceNames); mapperPath != "" {
+		klog.Infof("Found iSCSI multipath device: %s (paths: %v)", mapperPath, deviceNames)
+		return mapperPath, nil
+	}
+
+	devicePath := "/dev/" + deviceNames[0]
 	klog.Infof("Found iSCSI device: %s", devicePath)
 	return devicePath, nil
 }
 
-// parseISCSISessionDevice parses iscsiadm -m session -P 3 output to find
-// the attached disk for a specific IQN.
-func parseISCSISessionDevice(output, targetIQN string) string {
+// parseISCSISessionDevices parses iscsiadm -m session -P 3 output to find all
+// attached disks for a specific IQN. Multiple disks are returned when the target
+// is reachable through several portals (one SCSI path per session).
+func parseISCSISessionDevices(output, targetIQN string) []string {
 	lines := strings.Split(output, "\n")
 	inTargetSection := false
+	var devices []string
 
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -337,13 +363,14 @@ func parseISCSISessionDevice(output, targetIQN string) string {
 			parts := strings.Fields(line)
 			for i, part := range parts {
 				if part == "disk" && i+1 < len(parts) {
-					return parts[i+1] // Return device name like "sda"
+					devices = append(devices, parts[i+1]) // Device name like "sda"
+					break
 				}
 			}
 		}
 	}
 
-	return ""
+	return devices
 }
 
 // waitForISCSIDevice waits for the iSCSI device to appear after login.
@@ -459,6 +486,9 @@ func (s *NodeService) formatAndMountISCSIDevice(ctx context.Context, volumeID, d
 	}
 	if mounted {
 		klog.V(4).Infof("Staging path %s is already mounted", stagingTargetPath)
+		if err := growRestoredFilesystem(ctx, volumeID, stagingTargetPath, volumeContext); err != nil {
+			return nil, err
+		}
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
@@ -469,7 +499,10 @@ func (s *NodeService) formatAndMountISCSIDevice(ctx context.Context, volumeID, d
 	if mnt := volumeCapability.GetMount(); mnt != nil {
 		userMountOptions = mnt.MountFlags
 	}
-	mountOptions := getISCSIMountOptions(userMountOptions)
+	mountOptions := seLinuxMountOptions(getISCSIMountOptions(userMountOptions), volumeContext)
+	if isReadOnlyVolumeContext(volumeContext) {
+		mountOptions = readOnlyStageMountOptions(mountOptions, fsType)
+	}
 
 	klog.V(4).Infof("iSCSI mount options: user=%v, final=%v", userMountOptions, mountOptions)
 
@@ -488,6 +521,9 @@ func (s *NodeService) formatAndMountISCSIDevice(ctx context.Context, volumeID, d
 	}
 
 	klog.V(4).Infof("Mounted iSCSI device to staging path")
+	if err := growRestoredFilesystem(ctx, volumeID, stagingTargetPath, volumeContext); err != nil {
+		return nil, err
+	}
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
@@ -521,7 +557,7 @@ func (s *NodeService) unstageISCSIVolume(ctx context.Context, req *csi.NodeUnsta
 	}
 
 	// Logout from the iSCSI target
-	server := volumeContext["server"]
+	server := s.portalAddress(volumeContext["server"])
 	port := volumeContext["port"]
 	if port == "" {
 		port = "3260"
@@ -533,6 +569,10 @@ func (s *NodeService) unstageISCSIVolume(ctx context.Context, req *csi.NodeUnsta
 		port:   port,
 	}
 
+	// Flush the dm-multipath map before removing its paths, otherwise multipathd
+	// keeps a map with failed paths that blocks reuse of the device names
+	s.flushISCSIMultipath(ctx, params)
+
 	klog.V(4).Infof("Logging out from iSCSI target for volume %s: IQN=%s", volumeID, iqn)
 	if err := s.logoutISCSITarget(ctx, params); err != nil {
 		klog.Warningf("Failed to logout from iSCSI target (continuing anyway): %v", err)
@@ -0,0 +1,115 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Paths used for dm-multipath detection (overridable in tests).
+var (
+	sysBlockDir = "/sys/block"
+	// multipathConfPaths are checked to decide whether multipathd manages iSCSI paths on this host.
+	// /proc/1/root is the host root filesystem when the node plugin runs with hostPID.
+	multipathConfPaths = []string{"/proc/1/root/etc/multipath.conf", "/etc/multipath.conf"}
+)
+
+// multipathPathAggregationTimeout is how long to wait for multipathd to build a map
+// over freshly logged-in iSCSI paths before falling back to a single SCSI disk.
+const multipathPathAggregationTimeout = 15 * time.Second
+
+// isMultipathConfigured reports whether dm-multipath is configured on the host.
+func isMultipathConfigured() bool {
+	for _, p := range multipathConfPaths {
+		if _, err := os.Stat(p); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// multipathHolder returns the dm-multipath map name holding a SCSI disk (e.g. "mpatha"),
+// or "" if the disk is not part of a multipath map.
+func multipathHolder(deviceName string) string {
+	holders, err := os.ReadDir(filepath.Join(sysBlockDir, deviceName, "holders"))
+	if err != nil {
+		return ""
+	}
+	for _, holder := range holders {
+		if !strings.HasPrefix(holder.Name(), "dm-") {
+			continue
+		}
+		dmDir := filepath.Join(sysBlockDir, holder.Name(), "dm")
+		uuid, err := os.ReadFile(filepath.Join(dmDir, "uuid")) //nolint:gosec // sysfs path built from kernel device names
+		if err != nil || !strings.HasPrefix(strings.TrimSpace(string(uuid)), "mpath-") {
+			continue
+		}
+		name, err := os.ReadFile(filepath.Join(dmDir, "name")) //nolint:gosec // sysfs path built from kernel device names
+		if err != nil {
+			continue
+		}
+		return strings.TrimSpace(string(name))
+	}
+	return ""
+}
+
+// findMultipathDevice returns the /dev/mapper path of the multipath map that aggregates
+// the given SCSI disks, or "" if none of them belong to a multipath map.
+func findMultipathDevice(deviceNames []string) string {
+	for _, dev := range deviceNames {
+		if name := multipathHolder(dev); name != "" {
+			return "/dev/mapper/" + name
+		}
+	}
+	return ""
+}
+
+// waitForISCSIMultipath waits for multipathd to aggregate iSCSI paths into a /dev/mapper device.
+// If multipath is not configured on the host, or the map does not appear in time, the
+// single-path device is returned unchanged.
+func (s *NodeService) waitForISCSIMultipath(ctx context.Context, params *iscsiConnectionParams, devicePath string) string {
+	if strings.HasPrefix(devicePath, "/dev/mapper/") || !isMultipathConfigured() {
+		return devicePath
+	}
+
+	klog.V(4).Infof("dm-multipath configured, waiting up to %v for map over IQN %s", multipathPathAggregationTimeout, params.iqn)
+	deadline := time.Now().Add(multipathPathAggregationTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return devicePath
+		case <-time.After(time.Second):
+		}
+		if path, err := s.findISCSIDevice(ctx, params); err == nil && strings.HasPrefix(path, "/dev/mapper/") {
+			klog.Infof("iSCSI paths for %s aggregated into multipath device %s", params.iqn, path)
+			return path
+		}
+	}
+
+	klog.Warningf("No dm-multipath map appeared for IQN %s within %v, using single path %s (check multipath.conf blacklist)",
+		params.iqn, multipathPathAggregationTimeout, devicePath)
+	return devicePath
+}
+
+// flushISCSIMultipath removes the dm-multipath map for an iSCSI target, if any.
+// Errors are logged: a leftover map is cleaned up by multipathd once all paths are gone.
+func (s *NodeService) flushISCSIMultipath(ctx context.Context, params *iscsiConnectionParams) {
+	devicePath, err := s.findISCSIDevice(ctx, params)
+	if err != nil || !strings.HasPrefix(devicePath, "/dev/mapper/") {
+		return
+	}
+	mapName := strings.TrimPrefix(devicePath, "/dev/mapper/")
+
+	klog.V(4).Infof("Flushing dm-multipath map %s for IQN %s", mapName, params.iqn)
+	flushCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	if output, flushErr := s.hostCmd(flushCtx, "multipath", "-f", mapName).CombinedOutput(); flushErr != nil {
+		klog.Warningf("Failed to flush multipath map %s: %v, output: %s", mapName, flushErr, string(output))
+		return
+	}
+	klog.V(4).Infof("Flushed dm-multipath map %s", mapName)
+}
@@ -0,0 +1,314 @@
+package driver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// Stale mount cleanup.
+//
+// When TrueNAS reboots for longer than the NVMe-oF controller loss timeout (or an iSCSI session
+// is torn down), the kernel removes the block device but the filesystems mounted from it stay
+// in the mount table. Kubelet keeps bind-mounting the dead staging mount into restarted
+// containers, which then fail with CreateContainerError until someone unmounts it by hand.
+// With --stale-mount-cleanup-interval the node plugin periodically looks for this driver's
+// mounts under the kubelet directory whose device is gone, lazily unmounts them, disconnects
+// the NVMe-oF session of a cleaned staging mount and reports each cleanup as a Node event.
+// Kubelet then stages the volume again for the next pod that uses it.
+
+// Stale mount cleanup defaults.
+const (
+	// DefaultKubeletDir is the default kubelet data directory.
+	DefaultKubeletDir = "/var/lib/kubelet"
+
+	// staleMountEventReason is the reason of Node events for cleaned mounts.
+	staleMountEventReason = "StaleMountCleaned"
+
+	// staleMountComponent is the event source component.
+	staleMountComponent = "tns-csi-node"
+
+	// deletedMountRootSuffix marks a bind mount whose source file was removed.
+	deletedMountRootSuffix = "//deleted"
+)
+
+// Paths read by the stale mount janitor (overridable in tests).
+var (
+	procMountInfo  = "/proc/self/mountinfo"
+	sysDevBlockDir = "/sys/dev/block"
+)
+
+// mountInfo is one line of /proc/self/mountinfo.
+type mountInfo struct {
+	MountPoint string
+	Root       string
+	Options    string // per-mount options (e.g. "rw,relatime")
+	Device     string // major:minor
+	FSType     string
+	Source     string
+}
+
+// csiVolumeData is the part of kubelet's vol_data.json the node plugin uses.
+type csiVolumeData struct {
+	DriverName   string `json:"driverName"`
+	VolumeHandle string `json:"volumeHandle"`
+}
+
+// staleMountJanitor cleans up mounts whose device no longer exists.
+type staleMountJanitor struct {
+	node       *NodeService
+	recorder   record.EventRecorder // nil when the Kubernetes API is unavailable
+	kubeletDir string
+	driverName string
+	interval   time.Duration
+}
+
+// newStaleMountJanitor creates a janitor. Events are skipped when the in-cluster
+// Kubernetes API is unavailable; the cleanup itself does not need it.
+func newStaleMountJanitor(node *NodeService, kubeletDir, driverName string, interval time.Duration) *staleMountJanitor {
+	j := &staleMountJanitor{
+		node:       node,
+		kubeletDir: filepath.Clean(kubeletDir),
+		driverName: driverName,
+		interval:   interval,
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		klog.Warningf("Stale mount cleanup events disabled: failed to load in-cluster config: %v", err)
+		return j
+	}
+	kube, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Warningf("Stale mount cleanup events disabled: failed to create Kubernetes client: %v", err)
+		return j
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kube.CoreV1().Events("")})
+	j.recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: staleMountComponent, Host: node.nodeID})
+	return j
+}
+
+// run cleans up stale mounts every interval until stopCh is closed.
+func (j *staleMountJanitor) run(stopCh <-chan struct{}) {
+	klog.Infof("Stale mount janitor started (kubelet dir %s, every %s)", j.kubeletDir, j.interval)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), j.interval)
+		j.clean(ctx)
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// clean runs one pass over the mount table.
+func (j *staleMountJanitor) clean(ctx context.Context) {
+	mounts, err := readMountInfo(procMountInfo)
+	if err != nil {
+		klog.Warningf("Stale mount check skipped: %v", err)
+		return
+	}
+
+	for _, m := range j.staleMounts(mounts) {
+		volume := readCSIVolumeData(m.MountPoint)
+		klog.Warningf("Cleaning up stale mount %s of volume %s: %s", m.MountPoint, volume.VolumeHandle, staleReason(&m))
+
+		if err := lazyUnmount(ctx, m.MountPoint); err != nil {
+			klog.Warningf("Failed to unmount stale mount %s: %v", m.MountPoint, err)
+			continue
+		}
+
+		// A dead staging mount leaves a dead NVMe-oF controller behind; kubelet reconnects on re-stage
+		if j.isStagingPath(m.MountPoint) {
+			if staged, ok := j.node.state.nvmeof(volume.VolumeHandle); ok {
+				if err := j.node.disconnectNVMeOF(ctx, staged.NQN); err != nil {
+					klog.Warningf("Failed to disconnect NVMe-oF session %s of stale mount %s: %v", staged.NQN, m.MountPoint, err)
+				}
+			}
+		}
+
+		j.event(fmt.Sprintf("Unmounted stale mount %s of volume %s (%s); pods using the volume may need to be restarted",
+			m.MountPoint, volume.VolumeHandle, staleReason(&m)))
+	}
+}
+
+// staleMounts returns this driver's mounts under the kubelet directory whose device is gone.
+func (j *staleMountJanitor) staleMounts(mounts []mountInfo) []mountInfo {
+	var stale []mountInfo
+	for i := range mounts {
+		m := &mounts[i]
+		if !strings.HasPrefix(m.MountPoint, j.kubeletDir+"/") || !isCSIMountPoint(m.MountPoint) {
+			continue
+		}
+		if !isDeviceGone(m) {
+			continue
+		}
+		if readCSIVolumeData(m.MountPoint).DriverName != j.driverName {
+			continue
+		}
+		stale = append(stale, *m)
+	}
+	return stale
+}
+
+// isStagingPath reports whether mountPoint is a staging (global) mount rather than a pod mount.
+func (j *staleMountJanitor) isStagingPath(mountPoint string) bool {
+	return strings.HasPrefix(mountPoint, filepath.Join(j.kubeletDir, "plugins")+"/")
+}
+
+// readCSIVolumeData reads kubelet's vol_data.json for a CSI mount point. Filesystem mounts keep
+// it next to the mount point; block publish targets in the volumeDevices data directory.
+func readCSIVolumeData(mountPoint string) csiVolumeData {
+	candidates := []string{filepath.Join(filepath.Dir(mountPoint), "vol_data.json")}
+	// <kubelet>/plugins/kubernetes.io/csi/volumeDevices/publish/<pv>/<pod-uid>
+	if pvDir := filepath.Dir(mountPoint); filepath.Base(filepath.Dir(pvDir)) == "publish" {
+		devicesDir := filepath.Dir(filepath.Dir(pvDir))
+		candidates = append(candidates, filepath.Join(devicesDir, filepath.Base(pvDir), "data", "vol_data.json"))
+	}
+
+	var data csiVolumeData
+	for _, path := range candidates {
+		raw, err := os.ReadFile(path) //nolint:gosec // path is under the kubelet directory
+		if err != nil {
+			continue
+		}
+		if err := json.Unmarshal(raw, &data); err == nil {
+			return data
+		}
+	}
+	return data
+}
+
+// event records a warning event on this node.
+func (j *staleMountJanitor) event(message string) {
+	if j.recorder == nil {
+		return
+	}
+	nodeRef := &corev1.ObjectReference{Kind: "Node", Name: j.node.nodeID, UID: types.UID(j.node.nodeID)}
+	j.recorder.Event(nodeRef, corev1.EventTypeWarning, staleMountEventReason, message)
+}
+
+// isCSIMountPoint reports whether a path under the kubelet directory belongs to a CSI volume.
+func isCSIMountPoint(mountPoint string) bool {
+	return strings.Contains(mountPoint, "/kubernetes.io/csi/") || strings.Contains(mountPoint, "/kubernetes.io~csi/")
+}
+
+// isDeviceGone reports whether the block device behind a mount has been removed. Network
+// filesystems (major 0) never qualify; block publish targets are bind mounts of the device node,
+// which the kernel marks deleted once the device disappears.
+func isDeviceGone(m *mountInfo) bool {
+	if strings.HasSuffix(m.Root, deletedMountRootSuffix) {
+		return true
+	}
+	if m.Device == "" || strings.HasPrefix(m.Device, "0:") {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(sysDevBlockDir, m.Device))
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// staleReason describes why a mount is considered stale.
+func staleReason(m *mountInfo) string {
+	if strings.HasSuffix(m.Root, deletedMountRootSuffix) {
+		return "device node " + strings.TrimSuffix(m.Root, deletedMountRootSuffix) + " was removed"
+	}
+	return fmt.Sprintf("device %s (%s) no longer exists", m.Source, m.Device)
+}
+
+// lazyUnmount detaches a mount even if it is busy or its device is unreachable.
+func lazyUnmount(ctx context.Context, mountPoint string) error {
+	umountCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(umountCtx, "umount", "-l", mountPoint).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("umount -l failed: %w, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// readMountInfo parses a mountinfo file.
+func readMountInfo(path string) ([]mountInfo, error) {
+	f, err := os.Open(path) //nolint:gosec // path is procMountInfo
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mount table: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	return parseMountInfo(f)
+}
+
+// parseMountInfo parses mountinfo lines:
+// "36 35 98:0 /mnt1 /mnt/parent rw,noatime master:1 - ext3 /dev/root rw,errors=continue".
+func parseMountInfo(r io.Reader) ([]mountInfo, error) {
+	var mounts []mountInfo
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 6 || sep < 0 || sep+2 >= len(fields) {
+			continue
+		}
+		mounts = append(mounts, mountInfo{
+			Device:     fields[2],
+			Root:       unescapeMountInfo(fields[3]),
+			MountPoint: unescapeMountInfo(fields[4]),
+			Options:    fields[5],
+			FSType:     fields[sep+1],
+			Source:     unescapeMountInfo(fields[sep+2]),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse mount table: %w", err)
+	}
+	return mounts, nil
+}
+
+// unescapeMountInfo decodes the octal escapes (\040 for space etc.) used in mountinfo.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
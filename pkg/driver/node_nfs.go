@@ -3,9 +3,9 @@ package driver
 
 import (
 	"context"
-	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -22,7 +22,7 @@ func (s *NodeService) stageNFSVolume(ctx context.Context, req *csi.NodeStageVolu
 	stagingTargetPath := req.GetStagingTargetPath()
 
 	// Get server and share from volume context (set during CreateVolume)
-	server := volumeContext["server"]
+	server := s.nfsServers.resolve(s.portalAddress(volumeContext["server"]))
 	share := volumeContext["share"]
 
 	if server == "" || share == "" {
@@ -31,6 +31,11 @@ func (s *NodeService) stageNFSVolume(ctx context.Context, req *csi.NodeStageVolu
 
 	klog.V(4).Infof("Staging NFS volume %s from %s:%s to %s", volumeID, server, share, stagingTargetPath)
 
+	// Volumes created with deferShareCreation may not be exported yet
+	if err := s.waitForDeferredShare(ctx, volumeID, volumeContext); err != nil {
+		return nil, err
+	}
+
 	// Check if staging target path exists, create if not
 	if _, err := os.Stat(stagingTargetPath); os.IsNotExist(err) {
 		klog.V(4).Infof("Creating staging target path: %s", stagingTargetPath)
@@ -56,15 +61,30 @@ func (s *NodeService) stageNFSVolume(ctx context.Context, req *csi.NodeStageVolu
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
-	// Mount NFS share to staging path
-	nfsSource := fmt.Sprintf("%s:%s", server, share)
+	// nfs.security: rpc.gssd and the host keytab must be in place before a Kerberos mount
+	if err := s.prepareNFSKerberos(volumeID, volumeContext); err != nil {
+		return nil, err
+	}
+
+	// Mount NFS share to staging path, resolving a server hostname now
+	addr, endpoint, err := s.resolvePortal(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	nfsSource := nfsMountSource(addr, share)
 
 	// Get user-specified mount options from StorageClass (passed via VolumeCapability)
 	var userMountOptions []string
 	if mnt := req.GetVolumeCapability().GetMount(); mnt != nil {
 		userMountOptions = mnt.MountFlags
 	}
-	mountOptions := getNFSMountOptions(userMountOptions)
+	mountOptions := nfsVersionMountOptions(userMountOptions, volumeContext[VolumeContextKeyNFSVersion])
+	mountOptions = nfsKerberosMountOptions(getNFSMountOptions(mountOptions), volumeContext[VolumeContextKeyNFSSecurity])
+	mountOptions = nfsLockRecoveryMountOptions(mountOptions, volumeContext)
+	mountOptions = seLinuxMountOptions(mountOptions, volumeContext)
+	if isReadOnlyVolumeContext(volumeContext) || isReadOnlyAccessMode(req.GetVolumeCapability().GetAccessMode().GetMode()) {
+		mountOptions = append(mountOptions, "ro")
+	}
 
 	klog.V(4).Infof("NFS mount options: user=%v, final=%v", userMountOptions, mountOptions)
 
@@ -72,14 +92,26 @@ func (s *NodeService) stageNFSVolume(ctx context.Context, req *csi.NodeStageVolu
 	args := []string{"-t", ProtocolNFS, "-o", mount.JoinMountOptions(mountOptions), nfsSource, stagingTargetPath}
 
 	klog.V(4).Infof("Executing mount command for staging: mount %v", args)
-	mountCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	mountCtx, cancel := context.WithTimeout(ctx, s.timeouts.mount())
 	defer cancel()
-	cmd := exec.CommandContext(mountCtx, "mount", args...)
+	cmd := s.hostNetCmd(mountCtx, "mount", args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
+		if s.uncheckedDeferredShare(volumeContext) {
+			return nil, status.Errorf(codes.Unavailable, "NFS share for volume %s may still be being created on the storage system: %v, output: %s", volumeID, err, string(output))
+		}
 		return nil, status.Errorf(codes.Internal, "Failed to mount NFS share for staging: %v, output: %s", err, string(output))
 	}
 
+	s.state.recordNFS(&nfsStagedVolume{
+		VolumeID:     volumeID,
+		StagingPath:  stagingTargetPath,
+		Server:       addr,
+		Endpoint:     endpoint,
+		Share:        share,
+		MountOptions: mountOptions,
+		StagedAt:     time.Now().UTC(),
+	})
 	klog.V(4).Infof("Staged NFS volume %s at %s", volumeID, stagingTargetPath)
 	return &csi.NodeStageVolumeResponse{}, nil
 }
@@ -123,6 +155,7 @@ func (s *NodeService) unstageNFSVolume(ctx context.Context, req *csi.NodeUnstage
 		klog.Warningf("Failed to remove staging target path %s: %v", stagingTargetPath, err)
 	}
 
+	s.state.forgetNFS(volumeID)
 	klog.V(4).Infof("Unstaged NFS volume %s from %s", volumeID, stagingTargetPath)
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
@@ -133,7 +166,16 @@ func (s *NodeService) publishNFSVolume(ctx context.Context, req *csi.NodePublish
 	targetPath := req.GetTargetPath()
 	stagingTargetPath := req.GetStagingTargetPath()
 
-	klog.V(4).Infof("Publishing NFS volume %s from staging %s to %s", volumeID, stagingTargetPath, targetPath)
+	// Volumes below a shared parent export are a subdirectory of the staged mount
+	source := stagingTargetPath
+	if subPath := req.GetVolumeContext()[VolumeContextKeySubPath]; subPath != "" {
+		if !filepath.IsLocal(subPath) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q in volume context", VolumeContextKeySubPath, subPath)
+		}
+		source = filepath.Join(stagingTargetPath, subPath)
+	}
+
+	klog.V(4).Infof("Publishing NFS volume %s from staging %s to %s", volumeID, source, targetPath)
 
 	// Check if target path exists, create if not
 	if _, err := os.Stat(targetPath); os.IsNotExist(err) {
@@ -167,7 +209,7 @@ func (s *NodeService) publishNFSVolume(ctx context.Context, req *csi.NodePublish
 	}
 
 	// Bind mount from staging path to target path
-	args := []string{"-o", mount.JoinMountOptions(mountOptions), stagingTargetPath, targetPath}
+	args := []string{"-o", mount.JoinMountOptions(mountOptions), source, targetPath}
 
 	klog.V(4).Infof("Executing bind mount command: mount %v", args)
 	mountCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
@@ -0,0 +1,247 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/mount"
+	"k8s.io/klog/v2"
+)
+
+// NFS mount recovery.
+//
+// After a TrueNAS failover or reboot, NFS mounts can be left returning ESTALE or hanging on a
+// server address that no longer answers. NodeGetVolumeStats, which kubelet calls periodically,
+// detects both and remounts the volume's staging path - through --nfs-server-map-file if the
+// server moved - then re-binds the pod mounts from the fresh staging mount. Containers that are
+// already running keep their old view of the mount and must be restarted; restarted containers
+// and new pods get the working mount instead of failing to start.
+
+// nfsRemountInterval is the minimum time between remount attempts of one volume.
+const nfsRemountInterval = time.Minute
+
+// nfsStatTimeout bounds statfs on NFS mounts, which blocks while the server is unreachable.
+const nfsStatTimeout = 5 * time.Second
+
+// Static errors for NFS mount recovery.
+var (
+	errNFSStagingPathUnknown = errors.New("staging path of the volume is unknown")
+	errNFSRemountThrottled   = errors.New("remount attempted recently")
+	errNFSStagingNotMounted  = errors.New("staging path is not an NFS mount")
+	errStatfsTimeout         = errors.New("timeout getting filesystem statistics")
+)
+
+// nfsRemountGuard rate-limits remount attempts per volume.
+type nfsRemountGuard struct {
+	last map[string]time.Time
+	mu   sync.Mutex
+}
+
+// allow reports whether a remount of volumeID may be attempted now, and if so records the attempt.
+func (g *nfsRemountGuard) allow(volumeID string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if last, ok := g.last[volumeID]; ok && time.Since(last) < nfsRemountInterval {
+		return false
+	}
+	if g.last == nil {
+		g.last = make(map[string]time.Time)
+	}
+	g.last[volumeID] = time.Now()
+	return true
+}
+
+// staleNFSVolumeStats attempts to recover a stale NFS volume and reports it as abnormal.
+func (s *NodeService) staleNFSVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest, reason string) *csi.NodeGetVolumeStatsResponse {
+	klog.Warningf("Volume %s at %s: %s", req.GetVolumeId(), req.GetVolumePath(), reason)
+	return &csi.NodeGetVolumeStatsResponse{
+		VolumeCondition: Unhealthy(s.recoverNFSMount(ctx, req.GetVolumeId(), req.GetStagingTargetPath(), reason)).ToCSI(),
+	}
+}
+
+// recoverNFSMount remounts a stale NFS volume and returns reason extended with the outcome.
+func (s *NodeService) recoverNFSMount(ctx context.Context, volumeID, stagingPath, reason string) string {
+	server, err := s.remountNFS(ctx, volumeID, stagingPath)
+	switch {
+	case errors.Is(err, errNFSRemountThrottled):
+		return reason
+	case errors.Is(err, errMaintenanceMode):
+		return reason + "; not remounting during TrueNAS maintenance"
+	case err != nil:
+		klog.Warningf("Failed to remount stale NFS volume %s: %v", volumeID, err)
+		return fmt.Sprintf("%s; remount failed: %v", reason, err)
+	default:
+		return fmt.Sprintf("%s; remounted from %s, restart pods using the volume", reason, server)
+	}
+}
+
+// remountNFS replaces the NFS mount at the volume's staging path and re-binds its pod mounts.
+// Returns the server the volume was remounted from.
+func (s *NodeService) remountNFS(ctx context.Context, volumeID, stagingPath string) (string, error) {
+	staged, recorded := s.state.nfs(volumeID)
+	if stagingPath == "" {
+		stagingPath = staged.StagingPath
+	}
+	if stagingPath == "" {
+		return "", errNFSStagingPathUnknown
+	}
+	if enabled, _ := s.maintenance.active(); enabled {
+		return "", errMaintenanceMode
+	}
+	if !s.nfsRemounts.allow(volumeID) {
+		return "", errNFSRemountThrottled
+	}
+
+	mounts, err := readMountInfo(procMountInfo)
+	if err != nil {
+		return "", err
+	}
+	staging := findMountInfo(mounts, stagingPath)
+	if staging == nil || !strings.HasPrefix(staging.FSType, ProtocolNFS) {
+		return "", fmt.Errorf("%w: %s", errNFSStagingNotMounted, stagingPath)
+	}
+	server, share, ok := splitNFSSource(staging.Source)
+	if !ok {
+		return "", fmt.Errorf("%w: unexpected source %q", errNFSStagingNotMounted, staging.Source)
+	}
+
+	mountOptions := staged.MountOptions
+	if !recorded {
+		// Staged before mount options were recorded
+		mountOptions = getNFSMountOptions(nil)
+		if hasMountOption(staging.Options, "ro") {
+			mountOptions = append(mountOptions, "ro")
+		}
+	}
+	if staged.Endpoint != "" {
+		// Resolve the hostname again: a failover may have moved it to another address
+		server = staged.Endpoint
+	}
+	server = s.nfsServers.resolve(server)
+	server, endpoint, err := s.resolvePortal(ctx, server)
+	if err != nil {
+		return "", err
+	}
+
+	// Pod mounts are bind mounts of the same NFS superblock
+	var targets []mountInfo
+	for i := range mounts {
+		m := &mounts[i]
+		if m.Device == staging.Device && m.MountPoint != stagingPath && readCSIVolumeData(m.MountPoint).VolumeHandle == volumeID {
+			targets = append(targets, *m)
+		}
+	}
+
+	klog.Infof("Remounting stale NFS volume %s from %s:%s at %s (%d pod mount(s))", volumeID, server, share, stagingPath, len(targets))
+	if err := lazyUnmount(ctx, stagingPath); err != nil {
+		return "", err
+	}
+	args := []string{"-t", ProtocolNFS, "-o", mount.JoinMountOptions(mountOptions), nfsMountSource(server, share), stagingPath}
+	if err := s.runMountCommand(ctx, s.timeouts.mount(), args); err != nil {
+		return "", err
+	}
+	s.state.recordNFS(&nfsStagedVolume{
+		VolumeID:     volumeID,
+		StagingPath:  stagingPath,
+		Server:       server,
+		Endpoint:     endpoint,
+		Share:        share,
+		MountOptions: mountOptions,
+		StagedAt:     time.Now().UTC(),
+	})
+
+	for i := range targets {
+		target := &targets[i]
+		source := filepath.Join(stagingPath, strings.TrimPrefix(target.Root, staging.Root))
+		bindOptions := []string{mountTypeBind}
+		if hasMountOption(target.Options, "ro") {
+			bindOptions = append(bindOptions, "ro")
+		}
+		if err := lazyUnmount(ctx, target.MountPoint); err != nil {
+			klog.Warningf("Failed to unmount stale pod mount %s: %v", target.MountPoint, err)
+			continue
+		}
+		if err := s.runMountCommand(ctx, 30*time.Second, []string{"-o", mount.JoinMountOptions(bindOptions), source, target.MountPoint}); err != nil {
+			klog.Warningf("Failed to re-bind pod mount %s: %v", target.MountPoint, err)
+		}
+	}
+	return server, nil
+}
+
+// runMountCommand runs mount with args.
+func (s *NodeService) runMountCommand(ctx context.Context, timeout time.Duration, args []string) error {
+	mountCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	output, err := s.hostNetCmd(mountCtx, "mount", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mount %v failed: %w, output: %s", args, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// findMountInfo returns the topmost mount at mountPoint, or nil.
+func findMountInfo(mounts []mountInfo, mountPoint string) *mountInfo {
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if mounts[i].MountPoint == mountPoint {
+			return &mounts[i]
+		}
+	}
+	return nil
+}
+
+// splitNFSSource splits an NFS mount source ("server:/share", "[fd00::1]:/share").
+func splitNFSSource(source string) (server, share string, ok bool) {
+	idx := strings.Index(source, ":/")
+	if idx <= 0 {
+		return "", "", false
+	}
+	return source[:idx], source[idx+1:], true
+}
+
+// hasMountOption reports whether a comma-separated option list contains option.
+func hasMountOption(options, option string) bool {
+	return slices.Contains(strings.Split(options, ","), option)
+}
+
+// filesystemStats holds the capacity and inode counts of a mounted filesystem.
+// Filesystems without inodes (NTFS on Windows nodes) report zero inodes.
+type filesystemStats struct {
+	totalBytes     uint64
+	freeBytes      uint64
+	availableBytes uint64
+	totalInodes    uint64
+	freeInodes     uint64
+}
+
+// statfsWithTimeout runs statfs, giving up after timeout on unresponsive mounts.
+func statfsWithTimeout(ctx context.Context, path string, timeout time.Duration) (*filesystemStats, error) {
+	type result struct {
+		err   error
+		stats filesystemStats
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		r.stats, r.err = statFilesystem(path)
+		done <- r
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return &r.stats, nil
+	case <-time.After(timeout):
+		return nil, errStatfsTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -32,6 +32,9 @@ var (
 	ErrNVMeEmptyNQN                = errors.New("empty NQN in sysfs")
 	ErrNVMeNotNVMeDevice           = errors.New("not an NVMe device")
 	ErrNVMeNonNVMeStagingDevice    = errors.New("staging path resolved to non-NVMe device")
+	ErrNVMeTransportUnsupported    = errors.New("NVMe-oF transport not supported by node kernel")
+	ErrNVMeDeviceNQNMismatch       = errors.New("NVMe device belongs to a different subsystem")
+	errKernelModuleUnavailable     = errors.New("kernel module not available")
 )
 
 // NVMe subsystem states.
@@ -48,6 +51,7 @@ var defaultNVMeOFMountOptions = []string{zfsNoatime}
 type nvmeOFConnectionParams struct {
 	nqn        string
 	server     string
+	endpoint   string // hostname server was resolved from ("" = server is an address)
 	transport  string
 	port       string
 	nrIOQueues string // optional: --nr-io-queues flag value
@@ -66,6 +70,12 @@ func (s *NodeService) stageNVMeOFVolume(ctx context.Context, req *csi.NodeStageV
 	if err != nil {
 		return nil, err
 	}
+	// FC targets are addressed by port names, not by a host to resolve
+	if params.transport != nvmeTransportFC {
+		if params.server, params.endpoint, err = s.resolvePortal(ctx, params.server); err != nil {
+			return nil, err
+		}
+	}
 
 	isBlockVolume := volumeCapability.GetBlock() != nil
 	datasetName := volumeContext["datasetName"]
@@ -76,6 +86,7 @@ func (s *NodeService) stageNVMeOFVolume(ctx context.Context, req *csi.NodeStageV
 	if resp, _, reuseErr := s.tryReuseExistingConnection(ctx, params, volumeID, stagingTargetPath, volumeCapability, isBlockVolume, volumeContext); reuseErr != nil {
 		return nil, reuseErr
 	} else if resp != nil {
+		s.recordStagedNVMeOF(volumeID, stagingTargetPath, params, volumeContext, isBlockVolume)
 		return resp, nil
 	}
 
@@ -84,6 +95,11 @@ func (s *NodeService) stageNVMeOFVolume(ctx context.Context, req *csi.NodeStageV
 		return nil, status.Errorf(codes.FailedPrecondition, "nvme-cli not available: %v", checkErr)
 	}
 
+	// RDMA and FC need host kernel support (nvme_rdma / nvme_fc)
+	if checkErr := checkNVMeTransportSupport(ctx, params.transport); checkErr != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v", checkErr)
+	}
+
 	// Acquire semaphore to limit concurrent NVMe-oF connect operations.
 	// This prevents overwhelming the kernel's NVMe subsystem registration lock
 	// when many volumes are being staged simultaneously.
@@ -106,7 +122,12 @@ func (s *NodeService) stageNVMeOFVolume(ctx context.Context, req *csi.NodeStageV
 	klog.V(4).Infof("Acquired NVMe-oF connect semaphore for NQN: %s", params.nqn)
 
 	// Connect to NVMe-oF target and stage device
-	return s.connectAndStageDevice(ctx, params, volumeID, stagingTargetPath, volumeCapability, isBlockVolume, volumeContext, datasetName)
+	resp, err := s.connectAndStageDevice(ctx, params, volumeID, stagingTargetPath, volumeCapability, isBlockVolume, volumeContext, datasetName)
+	if err != nil {
+		return nil, err
+	}
+	s.recordStagedNVMeOF(volumeID, stagingTargetPath, params, volumeContext, isBlockVolume)
+	return resp, nil
 }
 
 // tryReuseExistingConnection attempts to reuse an existing NVMe-oF connection.
@@ -328,13 +349,14 @@ func (s *NodeService) connectAndStageDevice(ctx context.Context, params *nvmeOFC
 }
 
 // validateNVMeOFParams validates and extracts NVMe-oF connection parameters from volume context.
-// With independent subsystems, nsid is not required (always 1).
+// With independent subsystems, nsid is not required: the subsystem's only namespace is always
+// exposed as n1 on the host, whatever NSID the controller allocated for it.
 func (s *NodeService) validateNVMeOFParams(volumeContext map[string]string) (*nvmeOFConnectionParams, error) {
 	params := &nvmeOFConnectionParams{
 		nqn:        volumeContext["nqn"],
-		server:     volumeContext["server"],
-		transport:  volumeContext["transport"],
-		port:       volumeContext["port"],
+		server:     s.portalAddress(volumeContext["server"]),
+		transport:  strings.ToLower(volumeContext[VolumeContextKeyTransport]),
+		port:       volumeContext[VolumeContextKeyPort],
 		nrIOQueues: volumeContext["nvmeof.nr-io-queues"],
 		queueSize:  volumeContext["nvmeof.queue-size"],
 	}
@@ -344,8 +366,27 @@ func (s *NodeService) validateNVMeOFParams(volumeContext map[string]string) (*nv
 	}
 
 	// Default values
-	if params.transport == "" {
-		params.transport = "tcp"
+	switch params.transport {
+	case "":
+		params.transport = nvmeTransportTCP
+	case nvmeTransportTCP:
+	case nvmeTransportRDMA:
+		// RDMA ports listen on their own fabric address, recorded by the controller
+		if address := volumeContext[VolumeContextKeyTransportAddress]; address != "" {
+			params.server = address
+		}
+	case nvmeTransportFC:
+		// FC targets are only reachable by the node and port names of their TrueNAS port
+		address := volumeContext[VolumeContextKeyTransportAddress]
+		if !fcTransportAddressPattern.MatchString(address) {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"NVMe-oF FC volume needs a %s of the form nn-0x<WWNN>:pn-0x<WWPN>, got %q", VolumeContextKeyTransportAddress, address)
+		}
+		params.server = address
+		params.port = ""
+		return params, nil
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported NVMe-oF transport %q in volume context", params.transport)
 	}
 	if params.port == "" {
 		params.port = "4420"
@@ -375,10 +416,17 @@ func (s *NodeService) stageNVMeDevice(ctx context.Context, volumeID, devicePath,
 		klog.V(4).Infof("Device metadata stabilization delay complete for %s", devicePath)
 	}
 
+	// Stage through the persistent by-id link rather than the /dev/nvmeXnY name,
+	// which changes when the controller reconnects
+	stablePath, err := stableNVMeDevicePath(devicePath, volumeContext["nqn"])
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "NVMe device %s failed identity check: %v", devicePath, err)
+	}
+
 	if isBlockVolume {
-		return s.stageBlockDevice(devicePath, stagingTargetPath)
+		return s.stageBlockDevice(stablePath, stagingTargetPath)
 	}
-	return s.formatAndMountNVMeDevice(ctx, volumeID, devicePath, stagingTargetPath, volumeCapability, volumeContext)
+	return s.formatAndMountNVMeDevice(ctx, volumeID, stablePath, stagingTargetPath, volumeCapability, volumeContext)
 }
 
 // unstageNVMeOFVolume unstages an NVMe-oF volume by disconnecting from the target.
@@ -389,8 +437,11 @@ func (s *NodeService) unstageNVMeOFVolume(ctx context.Context, req *csi.NodeUnst
 
 	klog.V(4).Infof("Unstaging NVMe-oF volume %s from %s", volumeID, stagingTargetPath)
 
-	// Get NQN from volume context
+	// Get NQN from volume context, then from what was recorded at staging time
 	nqn := volumeContext["nqn"]
+	if staged, ok := s.state.nvmeof(volumeID); nqn == "" && ok {
+		nqn = staged.NQN
+	}
 	if nqn == "" {
 		derivedNQN, deriveErr := s.deriveNQNFromStagingPath(ctx, stagingTargetPath)
 		if deriveErr != nil {
@@ -416,6 +467,7 @@ func (s *NodeService) unstageNVMeOFVolume(ctx context.Context, req *csi.NodeUnst
 
 	// If we don't have NQN, we can't disconnect
 	if nqn == "" {
+		s.state.forgetNVMeOF(volumeID)
 		klog.Warningf("Cannot determine NQN for volume %s - skipping NVMe-oF disconnect", volumeID)
 		return &csi.NodeUnstageVolumeResponse{}, nil
 	}
@@ -427,6 +479,7 @@ func (s *NodeService) unstageNVMeOFVolume(ctx context.Context, req *csi.NodeUnst
 	} else {
 		klog.V(4).Infof("Disconnected from NVMe-oF target: %s", nqn)
 	}
+	s.state.forgetNVMeOF(volumeID)
 
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
@@ -467,12 +520,15 @@ func (s *NodeService) getStagedNVMeDevicePath(ctx context.Context, stagingTarget
 			return "", fmt.Errorf("findmnt source lookup failed for %s: %w", stagingTargetPath, cmdErr)
 		}
 		source := strings.TrimSpace(string(output))
+		if resolved, resolveErr := filepath.EvalSymlinks(source); resolveErr == nil {
+			source = resolved // mounted through a by-id link
+		}
 		if source != "" && strings.HasPrefix(filepath.Base(source), "nvme") {
 			return source, nil
 		}
 	}
 
-	// Block mode: staging path is a symlink to /dev/nvmeXnY.
+	// Block mode: staging path is a symlink to a by-id link or /dev/nvmeXnY.
 	resolved, err := filepath.EvalSymlinks(stagingTargetPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to resolve staging path %s: %w", stagingTargetPath, err)
@@ -560,6 +616,9 @@ func (s *NodeService) formatAndMountNVMeDevice(ctx context.Context, volumeID, de
 
 	if mounted {
 		klog.V(4).Infof("Staging path %s is already mounted", stagingTargetPath)
+		if err := growRestoredFilesystem(ctx, volumeID, stagingTargetPath, volumeContext); err != nil {
+			return nil, err
+		}
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
@@ -571,7 +630,10 @@ func (s *NodeService) formatAndMountNVMeDevice(ctx context.Context, volumeID, de
 	if mnt := volumeCapability.GetMount(); mnt != nil {
 		userMountOptions = mnt.MountFlags
 	}
-	mountOptions := getNVMeOFMountOptions(userMountOptions)
+	mountOptions := seLinuxMountOptions(getNVMeOFMountOptions(userMountOptions), volumeContext)
+	if isReadOnlyVolumeContext(volumeContext) {
+		mountOptions = readOnlyStageMountOptions(mountOptions, fsType)
+	}
 
 	klog.V(4).Infof("NVMe-oF mount options: user=%v, final=%v", userMountOptions, mountOptions)
 
@@ -590,6 +652,9 @@ func (s *NodeService) formatAndMountNVMeDevice(ctx context.Context, volumeID, de
 	}
 
 	klog.V(4).Infof("Mounted NVMe device to staging path")
+	if err := growRestoredFilesystem(ctx, volumeID, stagingTargetPath, volumeContext); err != nil {
+		return nil, err
+	}
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
@@ -0,0 +1,70 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// diskByIDDir holds the persistent device links udev creates (overridable in tests).
+var diskByIDDir = "/dev/disk/by-id"
+
+// nvmeNullUUID is reported in sysfs by namespaces without a UUID.
+const nvmeNullUUID = "00000000-0000-0000-0000-000000000000"
+
+// stableNVMeDevicePath returns the udev by-id link for the namespace behind devicePath.
+// Kernel names like /dev/nvme0n1 are handed out in connect order and shift when controllers
+// reconnect, so a staging symlink pointing at one can end up on another volume's namespace.
+// The by-id links are keyed by the namespace UUID (or WWID) and udev moves them with the
+// device. The device is first checked to belong to the expected subsystem NQN. When udev has
+// not created a link, the resolved kernel path is returned.
+func stableNVMeDevicePath(devicePath, nqn string) (string, error) {
+	kernelPath, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", devicePath, err)
+	}
+	sysDev := filepath.Join(sysBlockDir, filepath.Base(kernelPath))
+
+	// device is the controller, or the subsystem for a native multipath head; both expose subsysnqn
+	if deviceNQN := readSysfsValue(filepath.Join(sysDev, "device", "subsysnqn")); nqn != "" && deviceNQN != "" && deviceNQN != nqn {
+		return "", fmt.Errorf("%w: %s is in %s, expected %s", ErrNVMeDeviceNQNMismatch, kernelPath, deviceNQN, nqn)
+	}
+
+	for _, link := range nvmeByIDLinks(sysDev) {
+		if resolved, resolveErr := filepath.EvalSymlinks(link); resolveErr == nil && resolved == kernelPath {
+			klog.V(4).Infof("Using persistent link %s for NVMe device %s (NQN: %s)", link, kernelPath, nqn)
+			return link, nil
+		}
+	}
+
+	klog.V(4).Infof("No persistent by-id link found for NVMe device %s, using kernel path", kernelPath)
+	return kernelPath, nil
+}
+
+// nvmeByIDLinks returns the by-id links udev creates for a namespace, most specific first.
+func nvmeByIDLinks(sysDev string) []string {
+	var links []string
+	if uuid := readSysfsValue(filepath.Join(sysDev, "uuid")); uuid != "" && uuid != nvmeNullUUID {
+		links = append(links, filepath.Join(diskByIDDir, "nvme-uuid."+uuid))
+	}
+	if wwid := readSysfsValue(filepath.Join(sysDev, "wwid")); wwid != "" {
+		// udev replaces whitespace in the WWID with underscores
+		link := filepath.Join(diskByIDDir, "nvme-"+strings.Join(strings.Fields(wwid), "_"))
+		if len(links) == 0 || links[0] != link {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// readSysfsValue returns a trimmed sysfs attribute, or "" if it cannot be read.
+func readSysfsValue(path string) string {
+	data, err := os.ReadFile(path) //nolint:gosec // path is built from a kernel device name under sysfs
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -1,9 +1,12 @@
 package driver
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -18,9 +21,13 @@ func (s *NodeService) connectNVMeOFTarget(ctx context.Context, params *nvmeOFCon
 	if s.enableDiscovery {
 		// Discover the NVMe-oF target
 		klog.V(4).Infof("Discovering NVMe-oF target at %s:%s", params.server, params.port)
+		targetArgs, err := nvmeTargetArgs(params)
+		if err != nil {
+			return err
+		}
 		discoverCtx, discoverCancel := context.WithTimeout(ctx, 15*time.Second)
 		defer discoverCancel()
-		discoverCmd := exec.CommandContext(discoverCtx, "nvme", "discover", "-t", params.transport, "-a", params.server, "-s", params.port)
+		discoverCmd := s.hostNetCmd(discoverCtx, "nvme", append([]string{"discover"}, targetArgs...)...)
 		if output, discoverErr := discoverCmd.CombinedOutput(); discoverErr != nil {
 			klog.Warningf("NVMe discover failed (this may be OK if target is already known): %v, output: %s", discoverErr, string(output))
 		}
@@ -72,16 +79,16 @@ func (s *NodeService) attemptNVMeConnect(ctx context.Context, params *nvmeOFConn
 	// --keep-alive-tmo=5: Send keepalive every 5 seconds to detect dead connections
 	// --nr-io-queues: Number of I/O queues (default 4; configurable via StorageClass)
 	// --queue-size: Queue depth per I/O queue (kernel default 127; configurable via StorageClass)
-	connectArgs := []string{
-		"connect",
-		"-t", params.transport,
-		"-n", params.nqn,
-		"-a", params.server,
-		"-s", params.port,
+	targetArgs, err := nvmeTargetArgs(params)
+	if err != nil {
+		return err
+	}
+	connectArgs := append([]string{"connect", "-n", params.nqn}, targetArgs...)
+	connectArgs = append(connectArgs,
 		"--reconnect-delay=2",
 		"--ctrl-loss-tmo=60",
 		"--keep-alive-tmo=5",
-	}
+	)
 
 	if params.nrIOQueues != "" {
 		connectArgs = append(connectArgs, "--nr-io-queues="+params.nrIOQueues)
@@ -95,7 +102,7 @@ func (s *NodeService) attemptNVMeConnect(ctx context.Context, params *nvmeOFConn
 		klog.V(4).Infof("Using custom queue-size=%s for NVMe-oF connection", params.queueSize)
 	}
 
-	connectCmd := exec.CommandContext(connectCtx, "nvme", connectArgs...)
+	connectCmd := s.hostNetCmd(connectCtx, "nvme", connectArgs...)
 	output, err := connectCmd.CombinedOutput()
 	if err != nil {
 		// Check if already connected (this is success, not an error)
@@ -149,6 +156,145 @@ func (s *NodeService) checkNVMeCLI(ctx context.Context) error {
 	return nil
 }
 
+// nvmeTargetArgs returns the nvme-cli arguments addressing a connection's target: transport,
+// address and service ID, or for FC the target's port names and those of the local port (-w).
+func nvmeTargetArgs(params *nvmeOFConnectionParams) ([]string, error) {
+	args := []string{"-t", params.transport, "-a", params.server}
+	if params.transport != nvmeTransportFC {
+		return append(args, "-s", params.port), nil
+	}
+	hostAddress, err := localFCAddress()
+	if err != nil {
+		return nil, err
+	}
+	return append(args, "-w", hostAddress), nil
+}
+
+// fcHostDir is where the kernel exposes the host's Fibre Channel ports (overridable in tests).
+var fcHostDir = "/sys/class/fc_host"
+
+// localFCAddress returns the nn-0x…:pn-0x… address of the first online Fibre Channel port
+// of the host, which FC connections originate from.
+func localFCAddress() (string, error) {
+	hosts, err := os.ReadDir(fcHostDir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to list Fibre Channel ports: %w", err)
+	}
+	for _, host := range hosts {
+		dir := filepath.Join(fcHostDir, host.Name())
+		if state, readErr := os.ReadFile(filepath.Join(dir, "port_state")); readErr != nil || strings.TrimSpace(string(state)) != "Online" {
+			continue
+		}
+		nodeName, nodeErr := os.ReadFile(filepath.Join(dir, "node_name"))
+		portName, portErr := os.ReadFile(filepath.Join(dir, "port_name"))
+		if nodeErr != nil || portErr != nil {
+			continue
+		}
+		address := "nn-" + strings.TrimSpace(string(nodeName)) + ":pn-" + strings.TrimSpace(string(portName))
+		if fcTransportAddressPattern.MatchString(address) {
+			return address, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s (no online Fibre Channel port in %s)", ErrNVMeTransportUnsupported, nvmeTransportFC, fcHostDir)
+}
+
+// sysModuleDir is where the kernel exposes loaded modules (overridable in tests).
+var sysModuleDir = "/sys/module"
+
+// Kernel module index locations (overridable in tests). The /proc/1/root variant is the host's
+// module tree when the node plugin runs with hostPID; the image's own /lib/modules rarely matches
+// the host kernel.
+var (
+	moduleTreeDirs    = []string{"/proc/1/root/lib/modules", "/lib/modules"}
+	kernelReleaseFile = "/proc/sys/kernel/osrelease"
+)
+
+// nvmeTransportModules maps non-TCP NVMe-oF transports to the kernel module the host needs.
+var nvmeTransportModules = map[string]string{
+	nvmeTransportRDMA: "nvme_rdma",
+	nvmeTransportFC:   "nvme_fc",
+}
+
+// checkNVMeTransportSupport verifies the node kernel can use the requested NVMe-oF transport,
+// and for FC that the host has an online Fibre Channel port.
+// If the transport module is not loaded, a modprobe is attempted before giving up.
+func checkNVMeTransportSupport(ctx context.Context, transport string) error {
+	module, ok := nvmeTransportModules[transport]
+	if !ok {
+		return nil // tcp is always available when nvme-cli works
+	}
+	if err := loadKernelModule(ctx, module); err != nil {
+		return fmt.Errorf("%w: %s (%w)", ErrNVMeTransportUnsupported, transport, err)
+	}
+	if transport == nvmeTransportFC {
+		_, err := localFCAddress()
+		return err
+	}
+	return nil
+}
+
+// loadKernelModule makes sure a kernel module is loaded, attempting a modprobe if it is not.
+func loadKernelModule(ctx context.Context, module string) error {
+	modulePath := filepath.Join(sysModuleDir, module)
+	if _, err := os.Stat(modulePath); err == nil {
+		return nil
+	}
+
+	klog.V(4).Infof("Kernel module %s not loaded, attempting modprobe", module)
+	probeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if output, err := exec.CommandContext(probeCtx, "modprobe", module).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: modprobe %s: %w, output: %s", errKernelModuleUnavailable, module, err, strings.TrimSpace(string(output)))
+	}
+
+	if _, err := os.Stat(modulePath); err != nil {
+		return fmt.Errorf("%w: %s not present after modprobe", errKernelModuleUnavailable, module)
+	}
+	return nil
+}
+
+// kernelModuleAvailable reports whether a kernel module is loaded, built into the running kernel
+// or installed for it. Unlike loadKernelModule it changes nothing on the host.
+func kernelModuleAvailable(module string) bool {
+	if _, err := os.Stat(filepath.Join(sysModuleDir, module)); err == nil {
+		return true
+	}
+	release, err := os.ReadFile(kernelReleaseFile)
+	if err != nil {
+		return false
+	}
+	for _, dir := range moduleTreeDirs {
+		base := filepath.Join(dir, strings.TrimSpace(string(release)))
+		for _, index := range []string{"modules.builtin", "modules.dep"} {
+			if moduleIndexed(filepath.Join(base, index), module) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// moduleIndexed reports whether a modules.builtin or modules.dep file lists a module. Entries are
+// paths such as kernel/drivers/nvme/host/nvme-tcp.ko.zst, followed by ": <dependencies>" in
+// modules.dep; dashes and underscores in module names are interchangeable.
+func moduleIndexed(path, module string) bool {
+	f, err := os.Open(path) //nolint:gosec // kernel module index of the running kernel
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry, _, _ := strings.Cut(scanner.Text(), ":")
+		name, _, _ := strings.Cut(filepath.Base(entry), ".ko")
+		if strings.ReplaceAll(name, "-", "_") == module {
+			return true
+		}
+	}
+	return false
+}
+
 // disconnectNVMeOF disconnects from an NVMe-oF target and waits for device cleanup.
 func (s *NodeService) disconnectNVMeOF(ctx context.Context, nqn string) error {
 	klog.V(4).Infof("Disconnecting from NVMe-oF target: %s", nqn)
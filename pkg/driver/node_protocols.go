@@ -0,0 +1,127 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Per-node protocol support.
+//
+// Not every node can mount every protocol: small arm64 workers (e.g. Raspberry Pi) often lack
+// nvme-cli or open-iscsi. The node plugin probes the mount helpers, initiator tools and kernel
+// modules it needs without changing the host, and in NodeGetInfo sets node labels such as
+// protocols.tns.csi.io/nvmeof=false on its Node object (node_identity.go). Pods using block
+// volumes can select nodes with nodeAffinity on those labels.
+//
+// The labels are not reported as NodeGetInfo topology segments: kubelet refuses to register a
+// plugin whose topology values differ from the node's existing labels, so a node whose tools
+// changed would stay unregistered until the labels were edited by hand. Labels set through the
+// API follow the detection on every registration.
+//
+// The --node-protocols flag replaces probing with an explicit list (e.g. "nfs" for an NFS-only
+// node); NodeStageVolume then rejects other protocols with FailedPrecondition.
+
+// NodeProtocolLabelPrefix prefixes the per-protocol node labels ("true" or "false").
+const NodeProtocolLabelPrefix = "protocols.tns.csi.io/"
+
+// nvmeTCPModule is the kernel module NVMe/TCP connections need.
+const nvmeTCPModule = "nvme_tcp"
+
+// allProtocols lists the protocols in the order they are reported.
+var allProtocols = []string{ProtocolNFS, ProtocolSMB, ProtocolNVMeOF, ProtocolISCSI}
+
+// ParseNodeProtocols parses a comma-separated protocol list. An empty value means auto-detect (nil).
+func ParseNodeProtocols(value string) ([]string, error) {
+	var protocols []string
+	for _, field := range strings.Split(value, ",") {
+		protocol := strings.ToLower(strings.TrimSpace(field))
+		if protocol == "" {
+			continue
+		}
+		if !slices.Contains(allProtocols, protocol) {
+			return nil, fmt.Errorf("invalid node protocol %q: must be one of %s", protocol, strings.Join(allProtocols, ", "))
+		}
+		if !slices.Contains(protocols, protocol) {
+			protocols = append(protocols, protocol)
+		}
+	}
+	return protocols, nil
+}
+
+// supportedProtocols returns the protocols this node can mount.
+func (s *NodeService) supportedProtocols(ctx context.Context) []string {
+	if s.protocols != nil {
+		return s.protocols
+	}
+	if s.testMode {
+		return allProtocols
+	}
+	return detectNodeProtocols(ctx, s)
+}
+
+// detectNodeProtocols probes the tools each protocol needs.
+func detectNodeProtocols(ctx context.Context, s *NodeService) []string {
+	var protocols []string
+	for _, protocol := range allProtocols {
+		var err error
+		switch protocol {
+		case ProtocolNFS:
+			_, err = exec.LookPath("mount.nfs")
+		case ProtocolSMB:
+			_, err = exec.LookPath("mount.cifs")
+		case ProtocolNVMeOF:
+			// nvme-cli ships in the image, so the host kernel decides (transport modules are often missing on small arm64 boards)
+			if err = s.checkNVMeCLI(ctx); err == nil {
+				err = checkNVMeTransportModules()
+			}
+		case ProtocolISCSI:
+			err = s.checkISCSIAdm(ctx)
+		}
+		if err != nil {
+			klog.Infof("Protocol %s is not available on node %s: %v", protocol, s.nodeID, err)
+			continue
+		}
+		protocols = append(protocols, protocol)
+	}
+	return protocols
+}
+
+// checkNVMeTransportModules checks that the host kernel has the module of a transport NVMe-oF
+// volumes connect with (nvme_tcp, nvme_rdma or nvme_fc), loaded or installed. Nothing is loaded: detection
+// runs in NodeGetInfo, which must not change the host; NodeStageVolume loads what a volume needs.
+func checkNVMeTransportModules() error {
+	modules := []string{nvmeTCPModule, nvmeTransportModules[nvmeTransportRDMA], nvmeTransportModules[nvmeTransportFC]}
+	for _, module := range modules {
+		if kernelModuleAvailable(module) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: neither %s is loaded or installed for the running kernel", errKernelModuleUnavailable, strings.Join(modules, " nor "))
+}
+
+// protocolLabels builds the per-protocol node labels for the supported protocols.
+func protocolLabels(protocols []string) map[string]string {
+	labels := make(map[string]string, len(allProtocols))
+	for _, protocol := range allProtocols {
+		labels[NodeProtocolLabelPrefix+protocol] = strconv.FormatBool(slices.Contains(protocols, protocol))
+	}
+	return labels
+}
+
+// checkProtocolAllowed rejects staging protocols excluded with --node-protocols.
+// Auto-detected nodes rely on the per-protocol tool checks made while staging.
+func (s *NodeService) checkProtocolAllowed(protocol string) error {
+	if s.protocols == nil || slices.Contains(s.protocols, protocol) {
+		return nil
+	}
+	return status.Errorf(codes.FailedPrecondition, "protocol %s is not enabled on node %s (node protocols: %s)",
+		protocol, s.nodeID, strings.Join(s.protocols, ", "))
+}
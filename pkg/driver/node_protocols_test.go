@@ -0,0 +1,131 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseNodeProtocols(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{value: "", want: nil},
+		{value: "nfs", want: []string{ProtocolNFS}},
+		{value: " NFS, smb,nfs ", want: []string{ProtocolNFS, ProtocolSMB}},
+		{value: "nfs,fc", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseNodeProtocols(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseNodeProtocols(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseNodeProtocols(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNodeGetInfoProtocolLabels(t *testing.T) {
+	registry := NewNodeRegistry()
+	service := NewNodeService("pi-worker", nil, true, registry, false, 5)
+	service.protocols = []string{ProtocolNFS}
+	service.kube = fake.NewClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "pi-worker"}})
+
+	resp, err := service.NodeGetInfo(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("NodeGetInfo() error = %v", err)
+	}
+	// Topology keys cannot change after registration; the protocols are labels instead
+	if resp.GetAccessibleTopology() != nil {
+		t.Errorf("NodeGetInfo() topology = %v, want none", resp.GetAccessibleTopology())
+	}
+	node, err := service.kube.CoreV1().Nodes().Get(context.Background(), "pi-worker", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		NodeProtocolLabelPrefix + ProtocolNFS:    "true",
+		NodeProtocolLabelPrefix + ProtocolSMB:    "false",
+		NodeProtocolLabelPrefix + ProtocolNVMeOF: "false",
+		NodeProtocolLabelPrefix + ProtocolISCSI:  "false",
+	}
+	if !reflect.DeepEqual(node.Labels, want) {
+		t.Errorf("node labels = %v, want %v", node.Labels, want)
+	}
+
+	identity, ok := registry.Identity("pi-worker")
+	if !ok || !reflect.DeepEqual(identity.Protocols, []string{ProtocolNFS}) {
+		t.Errorf("registered protocols = %v, want [nfs]", identity.Protocols)
+	}
+}
+
+func TestCheckNVMeTransportModules(t *testing.T) {
+	root := t.TempDir()
+	release := filepath.Join(root, "osrelease")
+	if err := os.WriteFile(release, []byte("6.8.0-test\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	modules := filepath.Join(root, "lib", "modules", "6.8.0-test")
+	if err := os.MkdirAll(modules, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	oldSys, oldTrees, oldRelease := sysModuleDir, moduleTreeDirs, kernelReleaseFile
+	sysModuleDir, moduleTreeDirs, kernelReleaseFile = filepath.Join(root, "sys"), []string{filepath.Join(root, "lib", "modules")}, release
+	t.Cleanup(func() { sysModuleDir, moduleTreeDirs, kernelReleaseFile = oldSys, oldTrees, oldRelease })
+
+	if err := checkNVMeTransportModules(); !errors.Is(err, errKernelModuleUnavailable) {
+		t.Errorf("checkNVMeTransportModules() without modules error = %v, want errKernelModuleUnavailable", err)
+	}
+
+	// An RDMA-only node: nvme_rdma installed as a compressed module, nvme_tcp absent
+	dep := "kernel/drivers/nvme/host/nvme-core.ko.zst:\nkernel/drivers/nvme/host/nvme-rdma.ko.zst: kernel/drivers/nvme/host/nvme-fabrics.ko.zst\n"
+	if err := os.WriteFile(filepath.Join(modules, "modules.dep"), []byte(dep), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkNVMeTransportModules(); err != nil {
+		t.Errorf("checkNVMeTransportModules() with nvme_rdma installed error = %v", err)
+	}
+	if kernelModuleAvailable(nvmeTCPModule) {
+		t.Errorf("kernelModuleAvailable(%s) = true, want false", nvmeTCPModule)
+	}
+
+	// Loaded modules count without an index
+	if err := os.MkdirAll(filepath.Join(sysModuleDir, nvmeTCPModule), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if !kernelModuleAvailable(nvmeTCPModule) {
+		t.Errorf("kernelModuleAvailable(%s) with the module loaded = false, want true", nvmeTCPModule)
+	}
+}
+
+func TestNodeStageVolumeRejectsDisabledProtocol(t *testing.T) {
+	service := NewNodeService("pi-worker", nil, true, nil, false, 5)
+	service.protocols = []string{ProtocolNFS}
+
+	_, err := service.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "tank/csi/pvc-1",
+		StagingTargetPath: t.TempDir(),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+		VolumeContext: map[string]string{VolumeContextKeyProtocol: ProtocolISCSI},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("NodeStageVolume() error = %v, want FailedPrecondition", err)
+	}
+}
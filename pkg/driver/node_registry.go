@@ -5,25 +5,63 @@ import (
 	"time"
 )
 
+// NodeIdentity describes the storage initiator identity of a node.
+// The node plugin publishes it as Node annotations (node_identity.go); the
+// controller grants hosts access to volumes with it (controller_host_access.go).
+type NodeIdentity struct {
+	RegisteredAt time.Time
+	NodeID       string
+	HostNQN      string   // NVMe host NQN (/etc/nvme/hostnqn)
+	InitiatorIQN string   // iSCSI initiator name (/etc/iscsi/initiatorname.iscsi)
+	IPs          []string // Non-loopback IP addresses
+	Protocols    []string // Protocols the node can mount (see node_protocols.go)
+}
+
 // NodeRegistry tracks registered nodes for validation.
 // This is used by ControllerPublishVolume to verify node existence.
 type NodeRegistry struct {
-	nodes map[string]time.Time
+	nodes map[string]NodeIdentity
 	mu    sync.RWMutex
 }
 
 // NewNodeRegistry creates a new node registry.
 func NewNodeRegistry() *NodeRegistry {
 	return &NodeRegistry{
-		nodes: make(map[string]time.Time),
+		nodes: make(map[string]NodeIdentity),
 	}
 }
 
 // Register adds a node to the registry.
 func (r *NodeRegistry) Register(nodeID string) {
+	r.RegisterIdentity(NodeIdentity{NodeID: nodeID})
+}
+
+// RegisterIdentity adds or updates a node along with its initiator identity.
+func (r *NodeRegistry) RegisterIdentity(identity NodeIdentity) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.nodes[nodeID] = time.Now()
+	identity.RegisteredAt = time.Now()
+	r.nodes[identity.NodeID] = identity
+}
+
+// Identity returns the registered identity of a node.
+func (r *NodeRegistry) Identity(nodeID string) (NodeIdentity, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	identity, exists := r.nodes[nodeID]
+	return identity, exists
+}
+
+// FindByHostNQN returns the node registered with the given NVMe host NQN.
+func (r *NodeRegistry) FindByHostNQN(hostNQN string) (NodeIdentity, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, identity := range r.nodes {
+		if hostNQN != "" && identity.HostNQN == hostNQN {
+			return identity, true
+		}
+	}
+	return NodeIdentity{}, false
 }
 
 // IsRegistered checks if a node is registered.
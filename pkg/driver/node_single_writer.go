@@ -0,0 +1,59 @@
+package driver
+
+import (
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// singleWriterTargets enforces SINGLE_NODE_SINGLE_WRITER (ReadWriteOncePod) on the node: such a
+// volume may be published at one target path at a time. Kubelet already serializes pods for
+// ReadWriteOncePod; this catches the remaining cases the CSI spec requires drivers to reject.
+//
+// The map is in memory only. After a node plugin restart, existing publications are unknown
+// until they are unpublished and published again.
+type singleWriterTargets struct {
+	targets map[string]string // volume ID -> target path
+	mu      sync.Mutex
+}
+
+// acquire records targetPath as the publication of a single-writer volume. Publishing the same
+// target again is allowed (idempotency); a different target fails with FailedPrecondition.
+func (t *singleWriterTargets) acquire(volumeID, targetPath string, capability *csi.VolumeCapability) error {
+	if capability.GetAccessMode().GetMode() != csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if existing, ok := t.targets[volumeID]; ok && existing != targetPath {
+		return status.Errorf(codes.FailedPrecondition,
+			"Volume %s has SINGLE_NODE_SINGLE_WRITER access and is already published at %s", volumeID, existing)
+	}
+	if t.targets == nil {
+		t.targets = make(map[string]string)
+	}
+	t.targets[volumeID] = targetPath
+	return nil
+}
+
+// release forgets the publication of volumeID at targetPath.
+func (t *singleWriterTargets) release(volumeID, targetPath string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.targets[volumeID] == targetPath {
+		delete(t.targets, volumeID)
+	}
+}
+
+// snapshot returns a copy of the single-writer publications, keyed by volume ID.
+func (t *singleWriterTargets) snapshot() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	targets := make(map[string]string, len(t.targets))
+	for volumeID, targetPath := range t.targets {
+		targets[volumeID] = targetPath
+	}
+	return targets
+}
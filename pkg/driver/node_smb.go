@@ -12,6 +12,7 @@ import (
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/fenio/tns-csi/pkg/mount"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/klog/v2"
@@ -19,11 +20,26 @@ import (
 
 var errSMBUsernameRequired = errors.New("SMB secret must contain a 'username' key")
 
+// splitSMBUsername splits domain-qualified usernames ("CORP\alice" or "alice@corp.example.com")
+// into user and domain. An explicit domain secret takes precedence over the qualified form.
+func splitSMBUsername(username, domain string) (user, userDomain string) {
+	if domain != "" {
+		return username, domain
+	}
+	if d, u, ok := strings.Cut(username, `\`); ok && d != "" && u != "" {
+		return u, d
+	}
+	if u, d, ok := strings.Cut(username, "@"); ok && u != "" && d != "" {
+		return u, d
+	}
+	return username, ""
+}
+
 // writeSMBCredentialsFile writes SMB credentials to a temporary file with 0600 permissions.
 // The caller is responsible for removing the file (defer os.Remove(path)).
-// Secret keys: "username" (required), "password" (optional), "domain" (optional).
+// Secret keys: "username" (required, may be domain-qualified), "password" (optional), "domain" (optional).
 func writeSMBCredentialsFile(secrets map[string]string) (path string, retErr error) {
-	username := secrets["username"]
+	username, domain := splitSMBUsername(secrets["username"], secrets["domain"])
 	if username == "" {
 		return "", errSMBUsernameRequired
 	}
@@ -54,7 +70,7 @@ func writeSMBCredentialsFile(secrets map[string]string) (path string, retErr err
 			return "", fmt.Errorf("failed to write credentials: %w", err)
 		}
 	}
-	if domain, ok := secrets["domain"]; ok && domain != "" {
+	if domain != "" {
 		if _, err := fmt.Fprintf(f, "domain=%s\n", domain); err != nil {
 			return "", fmt.Errorf("failed to write credentials: %w", err)
 		}
@@ -120,7 +136,14 @@ func (s *NodeService) stageSMBVolume(ctx context.Context, req *csi.NodeStageVolu
 	if mnt := req.GetVolumeCapability().GetMount(); mnt != nil {
 		userMountOptions = mnt.MountFlags
 	}
-	mountOptions := getSMBMountOptions(userMountOptions)
+	ownershipOptions, err := smbOwnershipMountOptions(userMountOptions, volumeContext, req.GetVolumeCapability())
+	if err != nil {
+		return nil, err
+	}
+	mountOptions := seLinuxMountOptions(getSMBMountOptions(ownershipOptions), volumeContext)
+	if isReadOnlyVolumeContext(volumeContext) || isReadOnlyAccessMode(req.GetVolumeCapability().GetAccessMode().GetMode()) {
+		mountOptions = append(mountOptions, "ro")
+	}
 
 	// Handle SMB credentials from nodeStageSecretRef
 	secrets := req.GetSecrets()
@@ -139,14 +162,15 @@ func (s *NodeService) stageSMBVolume(ctx context.Context, req *csi.NodeStageVolu
 		klog.V(4).Infof("Kerberos authentication detected for volume %s, skipping credentials", volumeID)
 	}
 
-	klog.Infof("SMB mount options: user=%v, final=%v", userMountOptions, mountOptions)
+	klog.Infof("SMB mount options: user=%s, final=%s",
+		tnsapi.RedactString(strings.Join(userMountOptions, ",")), tnsapi.RedactString(mount.JoinMountOptions(mountOptions)))
 
 	args := []string{"-t", fsTypeCIFS, "-o", mount.JoinMountOptions(mountOptions), cifsSource, stagingTargetPath}
 
-	klog.Infof("Executing mount command for staging: mount %v", args)
-	mountCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	klog.Infof("Executing mount command for staging: mount %s", tnsapi.RedactString(strings.Join(args, " ")))
+	mountCtx, cancel := context.WithTimeout(ctx, s.timeouts.mount())
 	defer cancel()
-	cmd := exec.CommandContext(mountCtx, "mount", args...)
+	cmd := s.hostNetCmd(mountCtx, "mount", args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Failed to mount SMB share for staging: %v, output: %s", err, string(output))
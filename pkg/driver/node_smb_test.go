@@ -128,3 +128,23 @@ func TestIsSMBKerberosAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitSMBUsername(t *testing.T) {
+	tests := []struct {
+		username, domain     string
+		wantUser, wantDomain string
+	}{
+		{`CORP\alice`, "", "alice", "CORP"},
+		{"alice@corp.example.com", "", "alice", "corp.example.com"},
+		{`CORP\alice`, "OTHER", `CORP\alice`, "OTHER"},
+		{"alice", "", "alice", ""},
+		{`\alice`, "", `\alice`, ""},
+	}
+
+	for _, tt := range tests {
+		user, domain := splitSMBUsername(tt.username, tt.domain)
+		if user != tt.wantUser || domain != tt.wantDomain {
+			t.Errorf("splitSMBUsername(%q, %q) = %q, %q, want %q, %q", tt.username, tt.domain, user, domain, tt.wantUser, tt.wantDomain)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package driver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Node protocol stagers.
+//
+// The node RPCs dispatch to a nodeStager registered for the volume's protocol (the VolumeContext
+// protocol when staging and publishing, the protocol detected from the mount otherwise) instead
+// of switching on the protocol name themselves. NewNodeService registers the built-in stagers;
+// registerStager replaces one or adds a protocol, which lets tests exercise the RPCs with a fake
+// stager and lets experimental protocols be added without touching the RPC handlers.
+
+// nodeStager is the node-side implementation of a storage protocol.
+type nodeStager interface {
+	// Stage makes the volume available at the staging path.
+	Stage(ctx context.Context, req *csi.NodeStageVolumeRequest, volumeContext map[string]string) (*csi.NodeStageVolumeResponse, error)
+	// Unstage undoes Stage.
+	Unstage(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error)
+	// Publish bind mounts the staged volume to the target path.
+	Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error)
+	// Expand grows the node side of a mounted volume after the controller expanded it.
+	Expand(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error)
+	// Stats returns the protocol-specific health reported with NodeGetVolumeStats.
+	Stats(ctx context.Context, volumePath string) VolumeHealth
+}
+
+// newNodeStagers returns the built-in stagers of a node service.
+func newNodeStagers(s *NodeService) map[string]nodeStager {
+	return map[string]nodeStager{
+		ProtocolNFS:    nfsStager{s},
+		ProtocolSMB:    smbStager{s},
+		ProtocolNVMeOF: nvmeofStager{s},
+		ProtocolISCSI:  iscsiStager{s},
+	}
+}
+
+// registerStager sets the stager of a protocol.
+func (s *NodeService) registerStager(protocol string, stager nodeStager) {
+	if s.stagers == nil {
+		s.stagers = newNodeStagers(s)
+	}
+	s.stagers[protocol] = stager
+}
+
+// stagerFor returns the stager of a protocol, or nil if none is registered.
+func (s *NodeService) stagerFor(protocol string) nodeStager {
+	if s.stagers == nil {
+		return newNodeStagers(s)[protocol]
+	}
+	return s.stagers[protocol]
+}
+
+// nfsStager stages NFS volumes.
+type nfsStager struct{ s *NodeService }
+
+func (p nfsStager) Stage(ctx context.Context, req *csi.NodeStageVolumeRequest, volumeContext map[string]string) (*csi.NodeStageVolumeResponse, error) {
+	return p.s.stageNFSVolume(ctx, req, volumeContext)
+}
+
+func (p nfsStager) Unstage(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	klog.V(4).Infof("Unstaging NFS volume %s from %s", req.GetVolumeId(), req.GetStagingTargetPath())
+	return p.s.unstageNFSVolume(ctx, req)
+}
+
+func (p nfsStager) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	return p.s.publishNFSVolume(ctx, req)
+}
+
+func (nfsStager) Expand(_ context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	return expandOnServer(ProtocolNFS, req), nil
+}
+
+func (p nfsStager) Stats(ctx context.Context, volumePath string) VolumeHealth {
+	return p.s.checkNFSHealth(ctx, volumePath)
+}
+
+// smbStager stages SMB volumes.
+type smbStager struct{ s *NodeService }
+
+func (p smbStager) Stage(ctx context.Context, req *csi.NodeStageVolumeRequest, volumeContext map[string]string) (*csi.NodeStageVolumeResponse, error) {
+	return p.s.stageSMBVolume(ctx, req, volumeContext)
+}
+
+func (p smbStager) Unstage(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	klog.V(4).Infof("Unstaging SMB volume %s from %s", req.GetVolumeId(), req.GetStagingTargetPath())
+	return p.s.unstageSMBVolume(ctx, req)
+}
+
+func (p smbStager) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	return p.s.publishSMBVolume(ctx, req)
+}
+
+func (smbStager) Expand(_ context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	return expandOnServer(ProtocolSMB, req), nil
+}
+
+func (p smbStager) Stats(ctx context.Context, volumePath string) VolumeHealth {
+	return p.s.checkSMBHealth(ctx, volumePath)
+}
+
+// nvmeofStager stages NVMe-oF volumes.
+type nvmeofStager struct{ s *NodeService }
+
+func (p nvmeofStager) Stage(ctx context.Context, req *csi.NodeStageVolumeRequest, volumeContext map[string]string) (*csi.NodeStageVolumeResponse, error) {
+	return p.s.stageNVMeOFVolume(ctx, req, volumeContext)
+}
+
+func (p nvmeofStager) Unstage(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	// The NQN is recovered from the staged device or the node state
+	return p.s.unstageNVMeOFVolume(ctx, req, map[string]string{})
+}
+
+func (p nvmeofStager) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	return p.s.publishStagedBlockVolume(ctx, req, ProtocolNVMeOF)
+}
+
+func (p nvmeofStager) Expand(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	return expandBlockVolume(ctx, req)
+}
+
+func (p nvmeofStager) Stats(ctx context.Context, volumePath string) VolumeHealth {
+	return p.s.checkNVMeOFHealth(ctx, volumePath)
+}
+
+// iscsiStager stages iSCSI volumes.
+type iscsiStager struct{ s *NodeService }
+
+func (p iscsiStager) Stage(ctx context.Context, req *csi.NodeStageVolumeRequest, volumeContext map[string]string) (*csi.NodeStageVolumeResponse, error) {
+	return p.s.stageISCSIVolume(ctx, req, volumeContext)
+}
+
+func (p iscsiStager) Unstage(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	// IQN format is: iqn.2024-01.io.truenas.csi:<volumeID>
+	volumeContext := map[string]string{
+		VolumeContextKeyISCSIIQN: "iqn.2024-01.io.truenas.csi:" + req.GetVolumeId(),
+	}
+	return p.s.unstageISCSIVolume(ctx, req, volumeContext)
+}
+
+func (p iscsiStager) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	return p.s.publishStagedBlockVolume(ctx, req, ProtocolISCSI)
+}
+
+func (p iscsiStager) Expand(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	return expandBlockVolume(ctx, req)
+}
+
+func (p iscsiStager) Stats(ctx context.Context, volumePath string) VolumeHealth {
+	return p.s.checkISCSIHealth(ctx, volumePath)
+}
+
+// publishStagedBlockVolume publishes an NVMe-oF or iSCSI volume, which supports both block
+// and filesystem volume modes.
+func (s *NodeService) publishStagedBlockVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, protocol string) (*csi.NodePublishVolumeResponse, error) {
+	stagingTargetPath := req.GetStagingTargetPath()
+	if stagingTargetPath == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Staging target path is required for %s volumes", protocol)
+	}
+
+	// Check volume capability to determine how to publish
+	if req.GetVolumeCapability().GetBlock() != nil {
+		// Block volume: staging path is a device file, bind mount it
+		return s.publishBlockVolume(ctx, stagingTargetPath, req.GetTargetPath(), req.GetReadonly())
+	}
+	// Filesystem volume: staging path is a mounted directory, bind mount the directory
+	return s.publishFilesystemVolume(ctx, stagingTargetPath, req.GetTargetPath(), req.GetReadonly())
+}
+
+// expandOnServer answers NodeExpandVolume for a network filesystem whose quota the
+// controller already raised.
+func expandOnServer(protocol string, req *csi.NodeExpandVolumeRequest) *csi.NodeExpandVolumeResponse {
+	klog.Infof("%s volume expansion handled by controller, no node-side action needed", strings.ToUpper(protocol))
+	return &csi.NodeExpandVolumeResponse{
+		CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+	}
+}
+
+// growRestoredFilesystem grows the filesystem staged at stagingTargetPath when CreateVolume grew
+// the ZVOL of a snapshot restored into a larger volume, or an existing ZVOL adopted with
+// adoptExistingWithDifferentSize: expand (nodeExpansionRequired): the filesystem still has the
+// ZVOL's previous size. Growing an already grown filesystem is a no-op.
+func growRestoredFilesystem(ctx context.Context, volumeID, stagingTargetPath string, volumeContext map[string]string) error {
+	if volumeContext[VolumeContextKeyNodeExpansionRequired] != VolumeContextValueTrue || isReadOnlyVolumeContext(volumeContext) {
+		return nil
+	}
+	fsType, err := detectFilesystemType(ctx, stagingTargetPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to detect filesystem type of restored volume %s: %v", volumeID, err)
+	}
+	klog.Infof("Growing %s filesystem of restored volume %s to the size of its device", fsType, volumeID)
+	if err := resizeFilesystem(ctx, stagingTargetPath, fsType); err != nil {
+		return status.Errorf(codes.Internal, "Failed to grow filesystem of restored volume %s: %v", volumeID, err)
+	}
+	return nil
+}
+
+// expandBlockVolume resizes the filesystem of an NVMe-oF or iSCSI volume mounted at the
+// volume path. Raw block volumes need no node-side action.
+func expandBlockVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	volumePath := req.GetVolumePath()
+
+	volumeCap := req.GetVolumeCapability()
+	if volumeCap != nil && volumeCap.GetBlock() != nil {
+		klog.Info("Block volume expansion, no filesystem resize needed")
+		return &csi.NodeExpandVolumeResponse{
+			CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+		}, nil
+	}
+
+	// For filesystem volumes, we need to resize the filesystem
+	klog.V(4).Infof("Resizing filesystem on volume path: %s", volumePath)
+
+	// Detect filesystem type
+	fsType, err := detectFilesystemType(ctx, volumePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to detect filesystem type: %v", err)
+	}
+
+	klog.V(4).Infof("Detected filesystem type: %s", fsType)
+
+	// Resize based on filesystem type
+	if err := resizeFilesystem(ctx, volumePath, fsType); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to resize filesystem: %v", err)
+	}
+
+	klog.V(4).Infof("Resized filesystem for volume %s", req.GetVolumeId())
+
+	return &csi.NodeExpandVolumeResponse{
+		CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+	}, nil
+}
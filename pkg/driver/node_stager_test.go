@@ -0,0 +1,102 @@
+package driver
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeStager records the node RPCs dispatched to it.
+type fakeStager struct {
+	calls []string
+}
+
+func (f *fakeStager) Stage(context.Context, *csi.NodeStageVolumeRequest, map[string]string) (*csi.NodeStageVolumeResponse, error) {
+	f.calls = append(f.calls, "stage")
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func (f *fakeStager) Unstage(context.Context, *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	f.calls = append(f.calls, "unstage")
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+func (f *fakeStager) Publish(context.Context, *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	f.calls = append(f.calls, "publish")
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+func (f *fakeStager) Expand(context.Context, *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	f.calls = append(f.calls, "expand")
+	return &csi.NodeExpandVolumeResponse{}, nil
+}
+
+func (f *fakeStager) Stats(context.Context, string) VolumeHealth {
+	f.calls = append(f.calls, "stats")
+	return Healthy()
+}
+
+func TestNodeStagerRegistry(t *testing.T) {
+	service := NewNodeService("node-1", nil, true, nil, false, 5)
+	for _, protocol := range allProtocols {
+		if service.stagerFor(protocol) == nil {
+			t.Errorf("no stager registered for %s", protocol)
+		}
+	}
+	if service.stagerFor("ceph") != nil {
+		t.Error("stager registered for unknown protocol")
+	}
+
+	// A node service built without the constructor still finds the built-in stagers
+	if (&NodeService{}).stagerFor(ProtocolNFS) == nil {
+		t.Error("zero NodeService has no NFS stager")
+	}
+}
+
+func TestNodeStagerDispatch(t *testing.T) {
+	service := NewNodeService("node-1", nil, true, nil, false, 5)
+	fake := &fakeStager{}
+	service.registerStager("fake", fake)
+
+	ctx := context.Background()
+	volumeCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	volumeContext := map[string]string{VolumeContextKeyProtocol: "fake"}
+
+	if _, err := service.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          "pvc-1",
+		StagingTargetPath: "/staging/pvc-1",
+		VolumeCapability:  volumeCap,
+		VolumeContext:     volumeContext,
+	}); err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+	if _, err := service.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          "pvc-1",
+		StagingTargetPath: "/staging/pvc-1",
+		TargetPath:        filepath.Join(t.TempDir(), "target"),
+		VolumeCapability:  volumeCap,
+		VolumeContext:     volumeContext,
+	}); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+	if len(fake.calls) != 2 || fake.calls[0] != "stage" || fake.calls[1] != "publish" {
+		t.Errorf("calls = %v, want [stage publish]", fake.calls)
+	}
+
+	_, err := service.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          "pvc-2",
+		StagingTargetPath: "/staging/pvc-2",
+		VolumeCapability:  volumeCap,
+		VolumeContext:     map[string]string{VolumeContextKeyProtocol: "ceph"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("NodeStageVolume(unknown protocol) code = %s, want InvalidArgument", status.Code(err))
+	}
+}
@@ -0,0 +1,286 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Node state.
+//
+// NodeUnstageVolume carries no volume context, so after a node plugin restart or a node reboot
+// the NQN of a staged NVMe-oF volume can only be recovered from a still-mounted staging path.
+// The node plugin therefore records every NVMe-oF volume it stages in a state file on the host
+// (--node-state-dir, /var/lib/tns-csi in the Helm chart) and reconciles it against the actual
+// NVMe sessions when it starts. NFS volumes are recorded too, so that a stale NFS mount can be
+// remounted with the options it was staged with.
+
+// nodeStateFile is the state file name inside --node-state-dir.
+const nodeStateFile = "node-state.json"
+
+// nvmeofStagedVolume is what the node plugin remembers about a staged NVMe-oF volume.
+type nvmeofStagedVolume struct {
+	StagedAt    time.Time `json:"stagedAt"`
+	VolumeID    string    `json:"volumeID"`
+	StagingPath string    `json:"stagingPath"`
+	NQN         string    `json:"nqn"`
+	NSID        string    `json:"nsid,omitempty"`
+	Server      string    `json:"server"`
+	Endpoint    string    `json:"endpoint,omitempty"` // Hostname Server was resolved from
+	Port        string    `json:"port"`
+	Transport   string    `json:"transport"`
+	NrIOQueues  string    `json:"nrIOQueues,omitempty"`
+	QueueSize   string    `json:"queueSize,omitempty"`
+	Block       bool      `json:"block"`
+}
+
+// nfsStagedVolume is what the node plugin remembers about a staged NFS volume.
+type nfsStagedVolume struct {
+	StagedAt     time.Time `json:"stagedAt"`
+	VolumeID     string    `json:"volumeID"`
+	StagingPath  string    `json:"stagingPath"`
+	Server       string    `json:"server"`
+	Endpoint     string    `json:"endpoint,omitempty"` // Hostname Server was resolved from
+	Share        string    `json:"share"`
+	MountOptions []string  `json:"mountOptions,omitempty"`
+}
+
+// connectionParams returns the parameters to reconnect the volume's NVMe-oF session.
+func (v *nvmeofStagedVolume) connectionParams() *nvmeOFConnectionParams {
+	return &nvmeOFConnectionParams{
+		nqn:        v.NQN,
+		server:     v.Server,
+		endpoint:   v.Endpoint,
+		transport:  v.Transport,
+		port:       v.Port,
+		nrIOQueues: v.NrIOQueues,
+		queueSize:  v.QueueSize,
+	}
+}
+
+// nodeState is the persisted node plugin state. A nil *nodeState disables persistence.
+type nodeState struct {
+	NVMeOF map[string]nvmeofStagedVolume `json:"nvmeof"` // keyed by volume ID
+	NFS    map[string]nfsStagedVolume    `json:"nfs"`    // keyed by volume ID
+	path   string
+	mu     sync.Mutex
+}
+
+// loadNodeState reads the state file in dir, starting empty if there is none yet.
+func loadNodeState(dir string) (*nodeState, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create node state directory %s: %w", dir, err)
+	}
+	st := &nodeState{path: filepath.Join(dir, nodeStateFile)}
+
+	data, err := os.ReadFile(st.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read node state %s: %w", st.path, err)
+	default:
+		if jsonErr := json.Unmarshal(data, st); jsonErr != nil {
+			// A corrupt file must not keep the node plugin from starting; staging rebuilds it
+			klog.Warningf("Ignoring unreadable node state %s: %v", st.path, jsonErr)
+		}
+	}
+	if st.NVMeOF == nil {
+		st.NVMeOF = make(map[string]nvmeofStagedVolume)
+	}
+	if st.NFS == nil {
+		st.NFS = make(map[string]nfsStagedVolume)
+	}
+	return st, nil
+}
+
+// recordNVMeOF remembers a staged NVMe-oF volume.
+func (st *nodeState) recordNVMeOF(vol *nvmeofStagedVolume) {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.NVMeOF[vol.VolumeID] = *vol
+	st.saveLocked()
+}
+
+// nvmeof returns the recorded NVMe-oF volume, if any.
+func (st *nodeState) nvmeof(volumeID string) (nvmeofStagedVolume, bool) {
+	if st == nil {
+		return nvmeofStagedVolume{}, false
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	vol, ok := st.NVMeOF[volumeID]
+	return vol, ok
+}
+
+// nvmeofVolumes returns a snapshot of all recorded NVMe-oF volumes.
+func (st *nodeState) nvmeofVolumes() []nvmeofStagedVolume {
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	vols := make([]nvmeofStagedVolume, 0, len(st.NVMeOF))
+	for _, vol := range st.NVMeOF {
+		vols = append(vols, vol)
+	}
+	return vols
+}
+
+// forgetNVMeOF drops a volume once it has been unstaged.
+func (st *nodeState) forgetNVMeOF(volumeID string) {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.NVMeOF[volumeID]; !ok {
+		return
+	}
+	delete(st.NVMeOF, volumeID)
+	st.saveLocked()
+}
+
+// recordNFS remembers a staged NFS volume.
+func (st *nodeState) recordNFS(vol *nfsStagedVolume) {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.NFS[vol.VolumeID] = *vol
+	st.saveLocked()
+}
+
+// nfs returns the recorded NFS volume, if any.
+func (st *nodeState) nfs(volumeID string) (nfsStagedVolume, bool) {
+	if st == nil {
+		return nfsStagedVolume{}, false
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	vol, ok := st.NFS[volumeID]
+	return vol, ok
+}
+
+// nfsVolumes returns the recorded NFS volumes.
+func (st *nodeState) nfsVolumes() []nfsStagedVolume {
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	vols := make([]nfsStagedVolume, 0, len(st.NFS))
+	for _, vol := range st.NFS {
+		vols = append(vols, vol)
+	}
+	return vols
+}
+
+// forgetNFS drops an NFS volume once it has been unstaged.
+func (st *nodeState) forgetNFS(volumeID string) {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.NFS[volumeID]; !ok {
+		return
+	}
+	delete(st.NFS, volumeID)
+	st.saveLocked()
+}
+
+// saveLocked writes the state file atomically. Failures are logged: losing the file only
+// loses the restart recovery, never a staged volume.
+func (st *nodeState) saveLocked() {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		klog.Warningf("Failed to encode node state: %v", err)
+		return
+	}
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		klog.Warningf("Failed to write node state %s: %v", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, st.path); err != nil {
+		klog.Warningf("Failed to replace node state %s: %v", st.path, err)
+	}
+}
+
+// recordStagedNVMeOF remembers a successfully staged NVMe-oF volume.
+func (s *NodeService) recordStagedNVMeOF(volumeID, stagingTargetPath string, params *nvmeOFConnectionParams, volumeContext map[string]string, isBlockVolume bool) {
+	s.state.recordNVMeOF(&nvmeofStagedVolume{
+		VolumeID:    volumeID,
+		StagingPath: stagingTargetPath,
+		NQN:         params.nqn,
+		NSID:        volumeContext[VolumeContextKeyNSID],
+		Server:      params.server,
+		Endpoint:    params.endpoint,
+		Port:        params.port,
+		Transport:   params.transport,
+		NrIOQueues:  params.nrIOQueues,
+		QueueSize:   params.queueSize,
+		Block:       isBlockVolume,
+		StagedAt:    time.Now().UTC(),
+	})
+}
+
+// reconcileNVMeOFSessions compares the recorded NVMe-oF volumes with the node's NVMe sessions
+// after a restart. A volume whose staging path is gone was unstaged while the plugin was down,
+// so its session is disconnected and the record dropped. A volume that is still staged but has
+// lost its session (node reboot) is reconnected, so that kubelet's re-stage finds the device.
+func (s *NodeService) reconcileNVMeOFSessions(ctx context.Context) {
+	vols := s.state.nvmeofVolumes()
+	if len(vols) == 0 {
+		return
+	}
+	klog.Infof("Reconciling %d recorded NVMe-oF volume(s) with node sessions", len(vols))
+
+	for i := range vols {
+		vol := &vols[i]
+		connected := getSubsystemState(ctx, vol.NQN) != ""
+
+		if _, err := os.Lstat(vol.StagingPath); errors.Is(err, os.ErrNotExist) {
+			if connected {
+				klog.Infof("Disconnecting stale NVMe-oF session for unstaged volume %s (NQN: %s)", vol.VolumeID, vol.NQN)
+				if discErr := s.disconnectNVMeOF(ctx, vol.NQN); discErr != nil {
+					klog.Warningf("Failed to disconnect stale NVMe-oF session %s: %v", vol.NQN, discErr)
+					continue
+				}
+			}
+			s.state.forgetNVMeOF(vol.VolumeID)
+			continue
+		}
+
+		if connected {
+			klog.V(4).Infof("NVMe-oF volume %s is still connected (NQN: %s)", vol.VolumeID, vol.NQN)
+			continue
+		}
+		klog.Infof("Reconnecting NVMe-oF session for staged volume %s (NQN: %s)", vol.VolumeID, vol.NQN)
+		params := vol.connectionParams()
+		if params.endpoint != "" {
+			// The server may have moved since the volume was staged
+			addr, _, err := s.resolvePortal(ctx, params.endpoint)
+			if err != nil {
+				klog.Warningf("Failed to reconnect NVMe-oF session for volume %s: %v", vol.VolumeID, err)
+				continue
+			}
+			params.server = addr
+		}
+		if err := s.connectNVMeOFTarget(ctx, params); err != nil {
+			// Kubelet's next NodeStageVolume connects again
+			klog.Warningf("Failed to reconnect NVMe-oF session for volume %s: %v", vol.VolumeID, err)
+		}
+	}
+}
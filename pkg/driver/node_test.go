@@ -2,9 +2,14 @@ package driver
 
 import (
 	"context"
+	"errors"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
@@ -328,6 +333,25 @@ func TestNodePublishVolume_Validation(t *testing.T) {
 			wantErr:  true,
 			wantCode: codes.InvalidArgument,
 		},
+		{
+			name: "NFS subPath escaping the shared export",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:          "tank/csi/pvc-1",
+				TargetPath:        "/target/path",
+				StagingTargetPath: "/staging/path",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+				},
+				VolumeContext: map[string]string{
+					VolumeContextKeyProtocol: ProtocolNFS,
+					VolumeContextKeySubPath:  "../pvc-2",
+				},
+			},
+			wantErr:  true,
+			wantCode: codes.InvalidArgument,
+		},
 	}
 
 	for _, tt := range tests {
@@ -442,6 +466,38 @@ func TestNodeUnpublishVolume_TestMode(t *testing.T) {
 	}
 }
 
+func TestSingleWriterTargets(t *testing.T) {
+	singleWriter := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER},
+	}
+	multiWriter := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER},
+	}
+	var targets singleWriterTargets
+
+	if err := targets.acquire("vol-1", "/target/a", singleWriter); err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	if err := targets.acquire("vol-1", "/target/a", singleWriter); err != nil {
+		t.Errorf("repeated acquire of the same target: %v", err)
+	}
+	if err := targets.acquire("vol-1", "/target/b", singleWriter); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("acquire of a second target: error = %v, want FailedPrecondition", err)
+	}
+	if err := targets.acquire("vol-2", "/target/a", multiWriter); err != nil {
+		t.Errorf("multi-writer volumes are not tracked: %v", err)
+	}
+
+	targets.release("vol-1", "/target/b") // not the recorded target: no-op
+	if err := targets.acquire("vol-1", "/target/b", singleWriter); err == nil {
+		t.Error("release of a different target freed the volume")
+	}
+	targets.release("vol-1", "/target/a")
+	if err := targets.acquire("vol-1", "/target/b", singleWriter); err != nil {
+		t.Errorf("acquire after release: %v", err)
+	}
+}
+
 func TestNodeGetVolumeStats_Validation(t *testing.T) {
 	service := NewNodeService("test-node", nil, true, nil, false, 5)
 	ctx := context.Background()
@@ -955,6 +1011,101 @@ func TestExtractNVMeOFOptionKey(t *testing.T) {
 	}
 }
 
+func TestValidateNVMeOFParamsTransport(t *testing.T) {
+	service := NewNodeService("test-node", nil, true, nil, false, 5)
+	base := map[string]string{"nqn": "nqn.2137.csi.tns:test-vol", "server": "192.168.1.100"}
+	with := func(extra map[string]string) map[string]string {
+		volumeContext := maps.Clone(base)
+		maps.Copy(volumeContext, extra)
+		return volumeContext
+	}
+
+	tests := []struct {
+		volumeContext map[string]string
+		name          string
+		wantServer    string
+		wantPort      string
+		wantErr       bool
+	}{
+		{name: "tcp defaults", volumeContext: base, wantServer: "192.168.1.100", wantPort: "4420"},
+		{
+			name:          "tcp ignores a transport address",
+			volumeContext: with(map[string]string{VolumeContextKeyTransportAddress: "10.10.0.5"}),
+			wantServer:    "192.168.1.100", wantPort: "4420",
+		},
+		{
+			name:          "rdma connects to the port address",
+			volumeContext: with(map[string]string{VolumeContextKeyTransport: "rdma", VolumeContextKeyTransportAddress: "10.10.0.5", VolumeContextKeyPort: "4421"}),
+			wantServer:    "10.10.0.5", wantPort: "4421",
+		},
+		{
+			name:          "rdma on a wildcard port uses server",
+			volumeContext: with(map[string]string{VolumeContextKeyTransport: "rdma"}),
+			wantServer:    "192.168.1.100", wantPort: "4420",
+		},
+		{
+			name:          "fc connects to the port names",
+			volumeContext: with(map[string]string{VolumeContextKeyTransport: "fc", VolumeContextKeyTransportAddress: "nn-0x200000109b123456:pn-0x100000109b123456"}),
+			wantServer:    "nn-0x200000109b123456:pn-0x100000109b123456",
+		},
+		{name: "fc without port names", volumeContext: with(map[string]string{VolumeContextKeyTransport: "fc"}), wantErr: true},
+		{
+			name:          "fc with an IP address",
+			volumeContext: with(map[string]string{VolumeContextKeyTransport: "fc", VolumeContextKeyTransportAddress: "10.10.0.5"}),
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := service.validateNVMeOFParams(tt.volumeContext)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateNVMeOFParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (params.server != tt.wantServer || params.port != tt.wantPort) {
+				t.Errorf("validateNVMeOFParams() = %s:%s, want %s:%s", params.server, params.port, tt.wantServer, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestNVMeTargetArgs(t *testing.T) {
+	root := t.TempDir()
+	oldDir := fcHostDir
+	fcHostDir = root
+	t.Cleanup(func() { fcHostDir = oldDir })
+	writeHost := func(name, state, nodeName, portName string) {
+		t.Helper()
+		dir := filepath.Join(root, name)
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			t.Fatal(err)
+		}
+		for file, value := range map[string]string{"port_state": state, "node_name": nodeName, "port_name": portName} {
+			if err := os.WriteFile(filepath.Join(dir, file), []byte(value+"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tcp := &nvmeOFConnectionParams{transport: nvmeTransportTCP, server: "192.168.1.100", port: "4420"}
+	args, err := nvmeTargetArgs(tcp)
+	if err != nil || !slices.Equal(args, []string{"-t", "tcp", "-a", "192.168.1.100", "-s", "4420"}) {
+		t.Errorf("nvmeTargetArgs(tcp) = %v, %v", args, err)
+	}
+
+	fc := &nvmeOFConnectionParams{transport: nvmeTransportFC, server: "nn-0x200000109b123456:pn-0x100000109b123456"}
+	if _, err = nvmeTargetArgs(fc); !errors.Is(err, ErrNVMeTransportUnsupported) {
+		t.Errorf("nvmeTargetArgs(fc) without FC ports: error = %v, want %v", err, ErrNVMeTransportUnsupported)
+	}
+
+	writeHost("host1", "Linkdown", "0x20000090fa000001", "0x10000090fa000001")
+	writeHost("host2", "Online", "0x20000090fa000002", "0x10000090fa000002")
+	args, err = nvmeTargetArgs(fc)
+	want := []string{"-t", "fc", "-a", fc.server, "-w", "nn-0x20000090fa000002:pn-0x10000090fa000002"}
+	if err != nil || !slices.Equal(args, want) {
+		t.Errorf("nvmeTargetArgs(fc) = %v, %v, want %v", args, err, want)
+	}
+}
+
 func TestValidateNVMeOFParamsQueueParams(t *testing.T) {
 	service := NewNodeService("test-node", nil, true, nil, false, 5)
 
@@ -1035,3 +1186,291 @@ func TestValidateNVMeOFParamsQueueParams(t *testing.T) {
 		})
 	}
 }
+
+func TestParseISCSISessionDevices(t *testing.T) {
+	output := `Target: iqn.2005-10.org.freenas.ctl:pvc-1 (non-flash)
+	Current Portal: 10.0.0.1:3260,1
+		Attached scsi disk sdb		State: running
+	Current Portal: 10.0.1.1:3260,1
+		Attached scsi disk sdc		State: running
+Target: iqn.2005-10.org.freenas.ctl:pvc-2 (non-flash)
+		Attached scsi disk sdd		State: running
+`
+
+	got := parseISCSISessionDevices(output, "iqn.2005-10.org.freenas.ctl:pvc-1")
+	if len(got) != 2 || got[0] != "sdb" || got[1] != "sdc" {
+		t.Errorf("parseISCSISessionDevices() = %v, want [sdb sdc]", got)
+	}
+	if got := parseISCSISessionDevices(output, "iqn.2005-10.org.freenas.ctl:pvc-2"); len(got) != 1 || got[0] != "sdd" {
+		t.Errorf("parseISCSISessionDevices() = %v, want [sdd]", got)
+	}
+}
+
+func TestFindMultipathDevice(t *testing.T) {
+	dir := t.TempDir()
+	origSysBlockDir := sysBlockDir
+	sysBlockDir = dir
+	defer func() { sysBlockDir = origSysBlockDir }()
+
+	writeFile := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// sdb and sdc are both paths of dm-3 (mpatha); sde is held by a non-multipath dm device
+	for _, dev := range []string{"sdb", "sdc"} {
+		writeFile(filepath.Join(dir, dev, "holders", "dm-3"), "")
+	}
+	writeFile(filepath.Join(dir, "dm-3", "dm", "uuid"), "mpath-36589cfc000000abc\n")
+	writeFile(filepath.Join(dir, "dm-3", "dm", "name"), "mpatha\n")
+	writeFile(filepath.Join(dir, "sde", "holders", "dm-4"), "")
+	writeFile(filepath.Join(dir, "dm-4", "dm", "uuid"), "LVM-abcdef\n")
+	writeFile(filepath.Join(dir, "dm-4", "dm", "name"), "vg-lv\n")
+
+	if got := findMultipathDevice([]string{"sdb", "sdc"}); got != "/dev/mapper/mpatha" {
+		t.Errorf("findMultipathDevice(sdb, sdc) = %q, want /dev/mapper/mpatha", got)
+	}
+	if got := findMultipathDevice([]string{"sde"}); got != "" {
+		t.Errorf("findMultipathDevice(sde) = %q, want empty", got)
+	}
+	if got := findMultipathDevice([]string{"sdz"}); got != "" {
+		t.Errorf("findMultipathDevice(sdz) = %q, want empty", got)
+	}
+}
+
+func TestParseInitiatorNameFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "initiatorname.iscsi")
+	content := "## DO NOT EDIT OR REMOVE THIS FILE!\n# InitiatorName=iqn.commented.out\nInitiatorName=iqn.1993-08.org.debian:01:abcdef\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := parseInitiatorNameFile(path); got != "iqn.1993-08.org.debian:01:abcdef" {
+		t.Errorf("parseInitiatorNameFile() = %q, want iqn.1993-08.org.debian:01:abcdef", got)
+	}
+	if got := parseInitiatorNameFile(filepath.Join(t.TempDir(), "missing")); got != "" {
+		t.Errorf("parseInitiatorNameFile(missing) = %q, want empty", got)
+	}
+}
+
+func TestNodeRegistryIdentity(t *testing.T) {
+	registry := NewNodeRegistry()
+	registry.RegisterIdentity(NodeIdentity{
+		NodeID:       "node-1",
+		HostNQN:      "nqn.2014-08.org.nvmexpress:uuid:1234",
+		InitiatorIQN: "iqn.1993-08.org.debian:01:node1",
+	})
+	registry.Register("node-2")
+
+	identity, ok := registry.Identity("node-1")
+	if !ok || identity.InitiatorIQN != "iqn.1993-08.org.debian:01:node1" || identity.RegisteredAt.IsZero() {
+		t.Errorf("Identity(node-1) = %+v, %v", identity, ok)
+	}
+	if !registry.IsRegistered("node-2") {
+		t.Error("Expected node-2 to be registered")
+	}
+	if found, ok := registry.FindByHostNQN("nqn.2014-08.org.nvmexpress:uuid:1234"); !ok || found.NodeID != "node-1" {
+		t.Errorf("FindByHostNQN() = %+v, %v, want node-1", found, ok)
+	}
+	if _, ok := registry.FindByHostNQN(""); ok {
+		t.Error("FindByHostNQN(\"\") should not match nodes without a host NQN")
+	}
+}
+
+func TestNodeStatePersistence(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tns-csi")
+
+	st, err := loadNodeState(dir)
+	if err != nil {
+		t.Fatalf("loadNodeState() error = %v", err)
+	}
+	if vols := st.nvmeofVolumes(); len(vols) != 0 {
+		t.Fatalf("new state has volumes: %+v", vols)
+	}
+
+	params := &nvmeOFConnectionParams{nqn: "nqn.2026-02.csi.tns:pvc-1", server: "10.0.0.1", transport: "tcp", port: "4420", nrIOQueues: "4"}
+	service := &NodeService{state: st}
+	service.recordStagedNVMeOF("pvc-1", "/staging/pvc-1", params, map[string]string{VolumeContextKeyNSID: "3"}, true)
+	service.recordStagedNVMeOF("pvc-2", "/staging/pvc-2", params, nil, false)
+	st.forgetNVMeOF("pvc-2")
+
+	reloaded, err := loadNodeState(dir)
+	if err != nil {
+		t.Fatalf("reload error = %v", err)
+	}
+	vol, ok := reloaded.nvmeof("pvc-1")
+	if !ok {
+		t.Fatal("pvc-1 not recorded after reload")
+	}
+	if vol.StagingPath != "/staging/pvc-1" || vol.NSID != "3" || !vol.Block || vol.StagedAt.IsZero() {
+		t.Errorf("pvc-1 = %+v", vol)
+	}
+	if got := vol.connectionParams(); *got != *params {
+		t.Errorf("connectionParams() = %+v, want %+v", got, params)
+	}
+	if _, ok := reloaded.nvmeof("pvc-2"); ok {
+		t.Error("pvc-2 still recorded after forgetNVMeOF")
+	}
+
+	// A corrupt file starts over instead of failing the plugin
+	if err := os.WriteFile(filepath.Join(dir, nodeStateFile), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if corrupt, err := loadNodeState(dir); err != nil || len(corrupt.nvmeofVolumes()) != 0 {
+		t.Errorf("loadNodeState(corrupt) = %+v, %v; want empty state", corrupt, err)
+	}
+
+	// Persistence is disabled without a state
+	var disabled *nodeState
+	disabled.recordNVMeOF(&nvmeofStagedVolume{VolumeID: "pvc-3"})
+	disabled.forgetNVMeOF("pvc-3")
+	if _, ok := disabled.nvmeof("pvc-3"); ok {
+		t.Error("nil state returned a volume")
+	}
+}
+
+func TestParseMountInfo(t *testing.T) {
+	input := `36 35 98:0 /mnt1 /mnt/parent rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+412 30 259:3 / /var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pvc\0401/mount rw,relatime shared:200 - ext4 /dev/nvme0n1 rw
+413 30 0:5 /nvme1n1//deleted /var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pvc-2/uid rw - devtmpfs udev rw
+garbage line
+`
+	mounts, err := parseMountInfo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseMountInfo() error = %v", err)
+	}
+	if len(mounts) != 3 {
+		t.Fatalf("parseMountInfo() returned %d mounts, want 3: %+v", len(mounts), mounts)
+	}
+	want := mountInfo{Device: "259:3", Root: "/", Options: "rw,relatime", MountPoint: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pvc 1/mount", FSType: "ext4", Source: "/dev/nvme0n1"}
+	if mounts[1] != want {
+		t.Errorf("mounts[1] = %+v, want %+v", mounts[1], want)
+	}
+	if mounts[2].FSType != "devtmpfs" || mounts[2].Root != "/nvme1n1//deleted" {
+		t.Errorf("mounts[2] = %+v", mounts[2])
+	}
+}
+
+func TestStaleMounts(t *testing.T) {
+	kubelet := t.TempDir()
+	sysDir := t.TempDir()
+	origSysDevBlockDir := sysDevBlockDir
+	sysDevBlockDir = sysDir
+	t.Cleanup(func() { sysDevBlockDir = origSysDevBlockDir })
+
+	if err := os.Mkdir(filepath.Join(sysDir, "259:1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeVolData := func(dir, driverName, volumeID string) {
+		t.Helper()
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		data := `{"driverName":"` + driverName + `","volumeHandle":"` + volumeID + `"}`
+		if err := os.WriteFile(filepath.Join(dir, "vol_data.json"), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	csiDir := filepath.Join(kubelet, "plugins/kubernetes.io/csi")
+	staging := filepath.Join(csiDir, "tns.csi.io/abc")
+	otherDriver := filepath.Join(csiDir, "other.csi.io/def")
+	podMount := filepath.Join(kubelet, "pods/uid/volumes/kubernetes.io~csi/pvc-3")
+	writeVolData(staging, "tns.csi.io", "pvc-1")
+	writeVolData(otherDriver, "other.csi.io", "pvc-2")
+	writeVolData(podMount, "tns.csi.io", "pvc-3")
+	writeVolData(filepath.Join(csiDir, "volumeDevices/pvc-4/data"), "tns.csi.io", "pvc-4")
+
+	mounts := []mountInfo{
+		{MountPoint: filepath.Join(staging, "globalmount"), Device: "259:2", Root: "/"},           // device gone
+		{MountPoint: filepath.Join(staging, "globalmount"), Device: "259:1", Root: "/"},           // device present
+		{MountPoint: filepath.Join(otherDriver, "globalmount"), Device: "259:9", Root: "/"},       // another driver
+		{MountPoint: filepath.Join(podMount, "mount"), Device: "0:52", Root: "/", FSType: "nfs4"}, // network filesystem
+		{MountPoint: filepath.Join(csiDir, "volumeDevices/publish/pvc-4/uid"), Device: "0:5", Root: "/nvme1n1//deleted"},
+		{MountPoint: "/mnt/data", Device: "259:7", Root: "/"}, // outside kubelet
+	}
+
+	j := &staleMountJanitor{node: &NodeService{}, kubeletDir: kubelet, driverName: "tns.csi.io"}
+	stale := j.staleMounts(mounts)
+	if len(stale) != 2 {
+		t.Fatalf("staleMounts() = %+v, want the gone staging device and the deleted block device", stale)
+	}
+	if got := readCSIVolumeData(stale[0].MountPoint).VolumeHandle; got != "pvc-1" || !j.isStagingPath(stale[0].MountPoint) {
+		t.Errorf("stale[0] volume = %q, staging = %v", got, j.isStagingPath(stale[0].MountPoint))
+	}
+	if got := readCSIVolumeData(stale[1].MountPoint).VolumeHandle; got != "pvc-4" {
+		t.Errorf("stale[1] volume = %q, want pvc-4", got)
+	}
+	if j.isStagingPath(filepath.Join(podMount, "mount")) {
+		t.Error("pod mount reported as staging path")
+	}
+}
+
+func TestNFSServerMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nfs-servers")
+	data := "# <old-server> <new-server>\n10.0.0.10 10.0.0.20 # failover VIP\n[fd00::10] fd00::20\nmalformed\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newNFSServerMap(path)
+	tests := map[string]string{
+		"10.0.0.10":  "10.0.0.20",
+		"10.0.0.11":  "10.0.0.11",
+		"fd00::10":   "fd00::20",
+		"[fd00::10]": "fd00::20",
+		"malformed":  "malformed",
+		"":           "",
+	}
+	for server, want := range tests {
+		if got := m.resolve(server); got != want {
+			t.Errorf("resolve(%q) = %q, want %q", server, got, want)
+		}
+	}
+
+	if got := newNFSServerMap(filepath.Join(t.TempDir(), "missing")).resolve("10.0.0.10"); got != "10.0.0.10" {
+		t.Errorf("resolve() with missing file = %q", got)
+	}
+	if got := newNFSServerMap("").resolve("10.0.0.10"); got != "10.0.0.10" {
+		t.Errorf("resolve() without map = %q", got)
+	}
+}
+
+func TestSplitNFSSource(t *testing.T) {
+	tests := []struct {
+		source, server, share string
+		ok                    bool
+	}{
+		{source: "10.0.0.10:/mnt/tank/csi/pvc-1", server: "10.0.0.10", share: "/mnt/tank/csi/pvc-1", ok: true},
+		{source: "[fd00::10]:/mnt/tank/csi/pvc-1", server: "[fd00::10]", share: "/mnt/tank/csi/pvc-1", ok: true},
+		{source: "/dev/nvme0n1"},
+	}
+	for _, tt := range tests {
+		server, share, ok := splitNFSSource(tt.source)
+		if server != tt.server || share != tt.share || ok != tt.ok {
+			t.Errorf("splitNFSSource(%q) = %q, %q, %v", tt.source, server, share, ok)
+		}
+	}
+}
+
+func TestNFSRemountGuard(t *testing.T) {
+	var g nfsRemountGuard
+	if !g.allow("pvc-1") {
+		t.Fatal("first remount not allowed")
+	}
+	if g.allow("pvc-1") {
+		t.Error("second remount within the interval allowed")
+	}
+	if !g.allow("pvc-2") {
+		t.Error("remount of another volume not allowed")
+	}
+	g.last["pvc-1"] = time.Now().Add(-nfsRemountInterval)
+	if !g.allow("pvc-1") {
+		t.Error("remount after the interval not allowed")
+	}
+}
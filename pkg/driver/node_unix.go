@@ -0,0 +1,34 @@
+//go:build linux || darwin
+
+package driver
+
+import (
+	"os"
+	"syscall"
+)
+
+// statFilesystem returns the capacity and inode counts of the filesystem mounted at path.
+func statFilesystem(path string) (filesystemStats, error) {
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(path, &statfs); err != nil {
+		return filesystemStats{}, err
+	}
+	// statfs reports blocks; use the platform-specific helper to convert Bsize safely
+	blockSize := getBlockSize(&statfs)
+	return filesystemStats{
+		totalBytes:     statfs.Blocks * blockSize,
+		freeBytes:      statfs.Bfree * blockSize,
+		availableBytes: statfs.Bavail * blockSize,
+		totalInodes:    statfs.Files,
+		freeInodes:     statfs.Ffree,
+	}, nil
+}
+
+// fileOwner returns the owner and group of a file. ok is false if the platform has none.
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
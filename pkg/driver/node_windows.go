@@ -0,0 +1,38 @@
+//go:build windows
+
+package driver
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// statFilesystem returns the capacity of the volume or SMB share mounted at path. NTFS and
+// SMB shares report no inodes.
+func statFilesystem(path string) (filesystemStats, error) {
+	// Through the links of published and staged volumes; UNC share roots need a trailing backslash
+	path = resolveLinks(path)
+	if !strings.HasSuffix(path, `\`) {
+		path += `\`
+	}
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return filesystemStats{}, err
+	}
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(pathPtr, &available, &total, &free); err != nil {
+		return filesystemStats{}, err
+	}
+	return filesystemStats{
+		totalBytes:     total,
+		freeBytes:      free,
+		availableBytes: available,
+	}, nil
+}
+
+// fileOwner returns false: Windows files have ACLs instead of POSIX owners.
+func fileOwner(_ os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
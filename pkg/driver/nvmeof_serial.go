@@ -0,0 +1,73 @@
+package driver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// NVMe-oF subsystem serial numbers.
+//
+// A subsystem's serial number is reported to hosts as the controller serial (SN), and multipath
+// and udev identify controllers by it. Left to TrueNAS, serials are assigned per installation and
+// can repeat after the TrueNAS configuration is restored from a backup, so two subsystems look
+// like paths of one device. Subsystems are therefore created with a serial derived from the
+// volume name (pvc-<PVC UUID> with the external-provisioner), so a volume keeps its serial when
+// its subsystem is re-created, e.g. on adoption. Before a subsystem is created, the serial is
+// checked against those of all existing subsystems; on a collision the next candidate is derived.
+
+// Subsystem serial settings.
+const (
+	// nvmeSerialLength is the length of a serial number in hex digits (the SN field holds 20
+	// ASCII characters).
+	nvmeSerialLength = 20
+
+	// maxNVMeSerialAttempts is how many candidate serials are tried before giving up.
+	maxNVMeSerialAttempts = 8
+)
+
+// nvmeSubsystemSerial returns candidate number attempt of the serial of a volume's subsystem.
+func nvmeSubsystemSerial(volumeName string, attempt int) string {
+	seed := volumeName
+	if attempt > 0 {
+		seed += "#" + strconv.Itoa(attempt)
+	}
+	sum := sha256.Sum256([]byte(seed))
+	return hex.EncodeToString(sum[:])[:nvmeSerialLength]
+}
+
+// checkSubsystemFree checks that the name of a volume's new subsystem is free and returns a free
+// serial for it.
+func (s *ControllerService) checkSubsystemFree(ctx context.Context, nqn, volumeName string) (string, error) {
+	if err := s.checkSubsystemNameFree(ctx, nqn); err != nil {
+		return "", err
+	}
+	return s.subsystemSerial(ctx, volumeName)
+}
+
+// subsystemSerial returns a serial for the subsystem of a volume that no existing subsystem uses.
+func (s *ControllerService) subsystemSerial(ctx context.Context, volumeName string) (string, error) {
+	subsystems, err := s.apiClient.ListAllNVMeOFSubsystems(ctx)
+	if err != nil {
+		return "", status.Errorf(codes.Unavailable, "cannot check NVMe-oF subsystem serials: %v", err)
+	}
+	used := make(map[string]int, len(subsystems))
+	for i := range subsystems {
+		used[subsystems[i].Serial] = subsystems[i].ID
+	}
+
+	for attempt := range maxNVMeSerialAttempts {
+		serial := nvmeSubsystemSerial(volumeName, attempt)
+		id, taken := used[serial]
+		if !taken {
+			return serial, nil
+		}
+		klog.Warningf("NVMe-oF subsystem serial %s of volume %s is already used by subsystem %d, deriving another one", serial, volumeName, id)
+	}
+	return "", status.Errorf(codes.AlreadyExists, "no free NVMe-oF subsystem serial for volume %s after %d attempts", volumeName, maxNVMeSerialAttempts)
+}
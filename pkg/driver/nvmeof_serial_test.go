@@ -0,0 +1,60 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// noNVMeOFSubsystemList is a ListAllNVMeOFSubsystems mock finding no subsystems.
+func noNVMeOFSubsystemList(context.Context) ([]tnsapi.NVMeOFSubsystem, error) {
+	return nil, nil
+}
+
+func TestNVMeSubsystemSerial(t *testing.T) {
+	serial := nvmeSubsystemSerial("pvc-0b7e5c4e-3c1a-4b8e-9d2f-6a1e2f3b4c5d", 0)
+	if len(serial) != nvmeSerialLength {
+		t.Errorf("serial %q has %d characters, want %d", serial, len(serial), nvmeSerialLength)
+	}
+	if again := nvmeSubsystemSerial("pvc-0b7e5c4e-3c1a-4b8e-9d2f-6a1e2f3b4c5d", 0); again != serial {
+		t.Errorf("serial not deterministic: %q, then %q", serial, again)
+	}
+	if other := nvmeSubsystemSerial("pvc-0b7e5c4e-3c1a-4b8e-9d2f-6a1e2f3b4c5e", 0); other == serial {
+		t.Errorf("volumes share serial %q", serial)
+	}
+	if next := nvmeSubsystemSerial("pvc-0b7e5c4e-3c1a-4b8e-9d2f-6a1e2f3b4c5d", 1); next == serial {
+		t.Errorf("next candidate repeats serial %q", serial)
+	}
+}
+
+func TestSubsystemSerial(t *testing.T) {
+	ctx := context.Background()
+	mockClient := &MockAPIClientForSnapshots{ListAllNVMeOFSubsystemsFunc: noNVMeOFSubsystemList}
+	s := NewControllerService(mockClient, NewNodeRegistry(), "")
+
+	if got, err := s.subsystemSerial(ctx, "pvc-1"); err != nil || got != nvmeSubsystemSerial("pvc-1", 0) {
+		t.Errorf("subsystemSerial() = %q, %v; want the first candidate", got, err)
+	}
+
+	// A restored TrueNAS configuration already uses the first two candidates
+	mockClient.ListAllNVMeOFSubsystemsFunc = func(context.Context) ([]tnsapi.NVMeOFSubsystem, error) {
+		return []tnsapi.NVMeOFSubsystem{
+			{ID: 3, Serial: nvmeSubsystemSerial("pvc-1", 0)},
+			{ID: 4, Serial: nvmeSubsystemSerial("pvc-1", 1)},
+		}, nil
+	}
+	if got, err := s.subsystemSerial(ctx, "pvc-1"); err != nil || got != nvmeSubsystemSerial("pvc-1", 2) {
+		t.Errorf("subsystemSerial() with collisions = %q, %v; want the third candidate", got, err)
+	}
+
+	mockClient.ListAllNVMeOFSubsystemsFunc = func(context.Context) ([]tnsapi.NVMeOFSubsystem, error) {
+		return nil, errors.New("connection refused")
+	}
+	if _, err := s.subsystemSerial(ctx, "pvc-1"); status.Code(err) != codes.Unavailable {
+		t.Errorf("subsystemSerial() without a subsystem list error = %v, want Unavailable", err)
+	}
+}
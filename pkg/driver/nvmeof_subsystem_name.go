@@ -0,0 +1,105 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// NVMe-oF subsystem naming.
+//
+// Every NVMe-oF volume gets a dedicated subsystem named <subsystemNQN>:<volume name>, and
+// subsystems are looked up by that name. Two clusters or StorageClasses sharing a TrueNAS
+// with the same volume names would therefore find each other's subsystems. The
+// subsystemNamePrefix StorageClass parameter is prepended to the volume name to keep them
+// apart; it is a Go template with .ClusterID (--cluster-id) and .StorageClass:
+//
+//	subsystemNamePrefix: "{{ .ClusterID }}-{{ .StorageClass }}-"
+//
+// Before a subsystem is created, its name is checked against the existing subsystems so a
+// collision fails with AlreadyExists instead of sharing another volume's subsystem.
+
+// SubsystemNamePrefixParam is the StorageClass parameter prefixing NVMe-oF subsystem names.
+const SubsystemNamePrefixParam = "subsystemNamePrefix"
+
+// maxNQNLength is the maximum length of an NVMe Qualified Name (NVMe base specification).
+const maxNQNLength = 223
+
+// subsystemNamePrefixPattern matches the characters allowed in a rendered subsystem name prefix.
+var subsystemNamePrefixPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]*$`)
+
+// SubsystemNameContext holds the variables available in subsystemNamePrefix.
+type SubsystemNameContext struct {
+	// ClusterID is the cluster identifier of the driver (empty if --cluster-id is not set).
+	ClusterID string
+	// StorageClass is the name of the StorageClass of the volume.
+	StorageClass string
+}
+
+// subsystemNQN returns the NQN of the dedicated subsystem of a volume.
+func (s *ControllerService) subsystemNQN(params map[string]string, volumeName string) (string, error) {
+	nqnPrefix := params["subsystemNQN"]
+	if nqnPrefix == "" {
+		nqnPrefix = defaultNQNPrefix
+	}
+	namePrefix, err := renderSubsystemNamePrefix(params[SubsystemNamePrefixParam], SubsystemNameContext{
+		ClusterID:    s.clusterID,
+		StorageClass: params["csi.storage.k8s.io/sc/name"],
+	})
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "invalid %s: %v", SubsystemNamePrefixParam, err)
+	}
+
+	nqn := generateNQN(nqnPrefix, namePrefix+volumeName)
+	if len(nqn) > maxNQNLength {
+		return "", status.Errorf(codes.InvalidArgument, "NVMe-oF subsystem NQN %s is %d characters long, the maximum is %d; shorten %s or subsystemNQN",
+			nqn, len(nqn), maxNQNLength, SubsystemNamePrefixParam)
+	}
+	return nqn, nil
+}
+
+// renderSubsystemNamePrefix renders a subsystemNamePrefix template. The result is lowercased
+// and must consist of letters, digits, '.' and '-'. An empty template renders to "".
+func renderSubsystemNamePrefix(value string, ctx SubsystemNameContext) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	tmpl, err := template.New(SubsystemNamePrefixParam).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template %q: %w", value, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ctx); err != nil {
+		return "", fmt.Errorf("failed to render template %q: %w", value, err)
+	}
+	prefix := strings.ToLower(buf.String())
+	if prefix == "" {
+		return "", nil
+	}
+	if !subsystemNamePrefixPattern.MatchString(prefix) {
+		return "", fmt.Errorf("%q (rendered from %q) may only contain letters, digits, '.' and '-' and must start with a letter or digit", prefix, value)
+	}
+	return prefix, nil
+}
+
+// checkSubsystemNameFree fails with AlreadyExists if a subsystem named nqn already exists.
+func (s *ControllerService) checkSubsystemNameFree(ctx context.Context, nqn string) error {
+	existing, err := s.apiClient.QueryNVMeOFSubsystem(ctx, nqn)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "cannot check whether NVMe-oF subsystem name %s is in use: %v", nqn, err)
+	}
+	if len(existing) == 0 {
+		return nil
+	}
+	klog.Errorf("NVMe-oF subsystem name %s is already used by subsystem %d", nqn, existing[0].ID)
+	return status.Errorf(codes.AlreadyExists,
+		"NVMe-oF subsystem %s already exists (ID %d) and belongs to another volume; set %s on the StorageClass (e.g. %q) to keep subsystem names of clusters and StorageClasses apart",
+		nqn, existing[0].ID, SubsystemNamePrefixParam, "{{ .ClusterID }}-{{ .StorageClass }}-")
+}
@@ -0,0 +1,66 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// noNVMeOFSubsystems is a QueryNVMeOFSubsystem mock finding no subsystem of the queried name.
+func noNVMeOFSubsystems(context.Context, string) ([]tnsapi.NVMeOFSubsystem, error) {
+	return nil, nil
+}
+
+func TestSubsystemNQN(t *testing.T) {
+	tests := []struct {
+		params   map[string]string
+		name     string
+		want     string
+		wantCode codes.Code
+	}{
+		{name: "default", params: map[string]string{}, want: defaultNQNPrefix + ":pvc-1"},
+		{name: "custom NQN prefix", params: map[string]string{"subsystemNQN": "nqn.2025-01.com.example"}, want: "nqn.2025-01.com.example:pvc-1"},
+		{
+			name:   "cluster and storage class",
+			params: map[string]string{SubsystemNamePrefixParam: "{{ .ClusterID }}-{{ .StorageClass }}-", "csi.storage.k8s.io/sc/name": "NVMe-Fast"},
+			want:   defaultNQNPrefix + ":prod-nvme-fast-pvc-1",
+		},
+		{name: "literal", params: map[string]string{SubsystemNamePrefixParam: "team.a-"}, want: defaultNQNPrefix + ":team.a-pvc-1"},
+		{name: "renders empty", params: map[string]string{SubsystemNamePrefixParam: "{{ .StorageClass }}"}, want: defaultNQNPrefix + ":pvc-1"},
+		{name: "invalid characters", params: map[string]string{SubsystemNamePrefixParam: "a:b-"}, wantCode: codes.InvalidArgument},
+		{name: "unknown field", params: map[string]string{SubsystemNamePrefixParam: "{{ .Namespace }}-"}, wantCode: codes.InvalidArgument},
+		{name: "too long", params: map[string]string{SubsystemNamePrefixParam: strings.Repeat("a", maxNQNLength)}, wantCode: codes.InvalidArgument},
+	}
+	s := &ControllerService{clusterID: "prod"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.subsystemNQN(tt.params, "pvc-1")
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("subsystemNQN() error = %v, want code %v", err, tt.wantCode)
+			}
+			if got != tt.want {
+				t.Errorf("subsystemNQN() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSubsystemNameFree(t *testing.T) {
+	mockClient := &MockAPIClientForSnapshots{QueryNVMeOFSubsystemFunc: noNVMeOFSubsystems}
+	s := NewControllerService(mockClient, NewNodeRegistry(), "")
+	if err := s.checkSubsystemNameFree(context.Background(), defaultNQNPrefix+":pvc-1"); err != nil {
+		t.Errorf("checkSubsystemNameFree() of a free name error = %v", err)
+	}
+
+	mockClient.QueryNVMeOFSubsystemFunc = func(_ context.Context, nqn string) ([]tnsapi.NVMeOFSubsystem, error) {
+		return []tnsapi.NVMeOFSubsystem{{ID: 7, Name: nqn}}, nil
+	}
+	err := s.checkSubsystemNameFree(context.Background(), defaultNQNPrefix+":pvc-1")
+	if status.Code(err) != codes.AlreadyExists || !strings.Contains(err.Error(), SubsystemNamePrefixParam) {
+		t.Errorf("checkSubsystemNameFree() of a taken name error = %v, want AlreadyExists naming %s", err, SubsystemNamePrefixParam)
+	}
+}
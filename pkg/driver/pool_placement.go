@@ -0,0 +1,226 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"k8s.io/klog/v2"
+)
+
+// Multi-pool placement.
+//
+// A StorageClass may list several pools (pools: tank1,tank2) instead of one pool. CreateVolume
+// then picks one per volume with the poolPlacement strategy and provisions exactly as if the
+// StorageClass had named that pool, so every protocol path works unchanged:
+//
+//	most-free    the pool with the most free space (default)
+//	round-robin  the pools in turn
+//
+// A volume stays on the pool it was first created on: retries and re-created PVCs find the
+// existing dataset in any of the pools, and clones go to the pool of their source, as ZFS
+// clones cannot cross pools. The chosen pool is recorded in the volume context ("pool").
+// GetCapacity reports the free space of all pools, and the largest free space of a single
+// pool as the maximum volume size, since a volume cannot span pools.
+const (
+	// PoolsParam is the StorageClass parameter listing the pools to place volumes on.
+	PoolsParam = "pools"
+	// PoolPlacementParam is the StorageClass parameter selecting the placement strategy.
+	PoolPlacementParam = "poolPlacement"
+
+	// PoolPlacementMostFree places volumes on the pool with the most free space.
+	PoolPlacementMostFree = "most-free"
+	// PoolPlacementRoundRobin places volumes on the pools in turn.
+	PoolPlacementRoundRobin = "round-robin"
+)
+
+// poolRoundRobin hands out the pools of each pool list in turn.
+type poolRoundRobin struct {
+	next map[string]int // pool list (comma-joined) -> index of the next pool
+	mu   sync.Mutex
+}
+
+// pick returns the next pool of pools.
+func (r *poolRoundRobin) pick(pools []string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next == nil {
+		r.next = make(map[string]int)
+	}
+	key := strings.Join(pools, ",")
+	i := r.next[key] % len(pools)
+	r.next[key] = i + 1
+	return pools[i]
+}
+
+// parsePoolPlacement returns the pools and placement strategy of StorageClass parameters,
+// or nil pools if the pools parameter is not set.
+func parsePoolPlacement(params map[string]string) ([]string, string, error) {
+	if params[PoolsParam] == "" {
+		return nil, "", nil
+	}
+	var pools []string
+	for _, pool := range strings.Split(params[PoolsParam], ",") {
+		pool = strings.TrimSpace(pool)
+		if pool == "" || strings.Contains(pool, "/") {
+			return nil, "", status.Errorf(codes.InvalidArgument, "invalid %s %q: must be a comma-separated list of pool names", PoolsParam, params[PoolsParam])
+		}
+		if !slices.Contains(pools, pool) {
+			pools = append(pools, pool)
+		}
+	}
+	switch {
+	case params["pool"] != "":
+		return nil, "", status.Errorf(codes.InvalidArgument, "pool and %s are mutually exclusive", PoolsParam)
+	case params["parentDataset"] != "":
+		return nil, "", status.Errorf(codes.InvalidArgument, "parentDataset cannot be combined with %s", PoolsParam)
+	case params[VolumeTypeParam] != "":
+		return nil, "", status.Errorf(codes.InvalidArgument, "%s cannot be combined with %s", VolumeTypeParam, PoolsParam)
+	}
+
+	strategy := strings.ToLower(strings.TrimSpace(params[PoolPlacementParam]))
+	switch strategy {
+	case "":
+		strategy = PoolPlacementMostFree
+	case PoolPlacementMostFree, PoolPlacementRoundRobin:
+	default:
+		return nil, "", status.Errorf(codes.InvalidArgument, "invalid %s %q: must be %s or %s",
+			PoolPlacementParam, params[PoolPlacementParam], PoolPlacementMostFree, PoolPlacementRoundRobin)
+	}
+	return pools, strategy, nil
+}
+
+// applyPoolPlacement returns req with the pool parameter set to the pool chosen from its pools
+// parameter. req is returned unchanged if the StorageClass does not list pools.
+func (s *ControllerService) applyPoolPlacement(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeRequest, error) {
+	pools, strategy, err := parsePoolPlacement(req.GetParameters())
+	if err != nil || pools == nil {
+		return req, err
+	}
+
+	pool, err := s.placeVolume(ctx, req, pools, strategy)
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("Placing volume %s on pool %s (pools: %s, strategy: %s)", req.GetName(), pool, strings.Join(pools, ","), strategy)
+
+	placed, ok := proto.Clone(req).(*csi.CreateVolumeRequest)
+	if !ok {
+		return nil, status.Error(codes.Internal, "failed to copy CreateVolume request")
+	}
+	placed.Parameters["pool"] = pool
+	return placed, nil
+}
+
+// placeVolume chooses the pool of a new volume.
+func (s *ControllerService) placeVolume(ctx context.Context, req *csi.CreateVolumeRequest, pools []string, strategy string) (string, error) {
+	if pool := contentSourcePool(req.GetVolumeContentSource()); pool != "" {
+		if !slices.Contains(pools, pool) {
+			return "", status.Errorf(codes.InvalidArgument, "volume content source is on pool %s, which is not in %s %s",
+				pool, PoolsParam, strings.Join(pools, ","))
+		}
+		return pool, nil
+	}
+
+	volumeName, err := ResolveVolumeName(req.GetParameters(), req.GetName())
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "Failed to resolve volume name: %v", err)
+	}
+	for _, pool := range pools {
+		if dataset, dsErr := s.apiClient.Dataset(ctx, fmt.Sprintf("%s/%s", pool, volumeName)); dsErr == nil && dataset != nil {
+			klog.V(4).Infof("Volume %s already exists on pool %s", req.GetName(), pool)
+			return pool, nil
+		}
+	}
+
+	if strategy == PoolPlacementRoundRobin {
+		return s.poolCursor.pick(pools), nil
+	}
+	return s.mostFreePool(ctx, pools)
+}
+
+// mostFreePool returns the pool with the most free space. Pools that cannot be queried are skipped.
+func (s *ControllerService) mostFreePool(ctx context.Context, pools []string) (string, error) {
+	best, bestFree := "", int64(-1)
+	for _, name := range pools {
+		pool, err := s.apiClient.QueryPool(ctx, name)
+		if err != nil {
+			klog.Warningf("Skipping pool %s for placement: %v", name, err)
+			continue
+		}
+		if free := pool.Properties.Free.Parsed; free > bestFree {
+			best, bestFree = name, free
+		}
+	}
+	if best == "" {
+		return "", status.Errorf(codes.Unavailable, "none of the pools %s could be queried", strings.Join(pools, ","))
+	}
+	return best, nil
+}
+
+// contentSourcePool returns the pool of a snapshot or volume a volume is created from, or ""
+// if it cannot be told from the ID.
+func contentSourcePool(source *csi.VolumeContentSource) string {
+	var dataset string
+	switch {
+	case source.GetSnapshot() != nil:
+		meta, err := decodeSnapshotID(source.GetSnapshot().GetSnapshotId())
+		if err != nil {
+			return ""
+		}
+		dataset = meta.DatasetName
+	case source.GetVolume() != nil:
+		dataset = source.GetVolume().GetVolumeId()
+		if !isDatasetPathVolumeID(dataset) {
+			return ""
+		}
+	}
+	pool, _, _ := strings.Cut(dataset, "/")
+	return pool
+}
+
+// placedPool returns the pool of a volume created on a pool chosen from a pools list.
+func placedPool(volume *csi.Volume) string {
+	dataset := volume.GetVolumeContext()[VolumeContextKeyDatasetName]
+	if dataset == "" {
+		dataset = volume.GetVolumeId()
+	}
+	pool, _, _ := strings.Cut(dataset, "/")
+	return pool
+}
+
+// poolsCapacity returns the GetCapacity response of a pools list: the free space of all
+// pools and, as the maximum volume size, the largest free space of one. Pools that cannot
+// be queried are left out.
+func (s *ControllerService) poolsCapacity(ctx context.Context, pools []string) (*csi.GetCapacityResponse, error) {
+	resp := &csi.GetCapacityResponse{}
+	var lastErr error
+	queried := 0
+	for _, name := range pools {
+		pool, err := s.apiClient.QueryPool(ctx, name)
+		if err != nil {
+			klog.Errorf("Failed to query pool %s: %v", name, err)
+			lastErr = err
+			continue
+		}
+		queried++
+		free := pool.Properties.Free.Parsed
+		resp.AvailableCapacity += free
+		if resp.GetMaximumVolumeSize() == nil || free > resp.GetMaximumVolumeSize().GetValue() {
+			resp.MaximumVolumeSize = wrapperspb.Int64(free)
+		}
+	}
+	if queried == 0 {
+		return nil, status.Errorf(codes.Internal, "Failed to query pool capacity: %v", lastErr)
+	}
+	klog.V(4).Infof("Pools %s capacity: available=%d bytes, largest volume=%d bytes",
+		strings.Join(pools, ","), resp.GetAvailableCapacity(), resp.GetMaximumVolumeSize().GetValue())
+	return resp, nil
+}
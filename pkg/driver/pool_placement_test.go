@@ -0,0 +1,153 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// poolsWithFree returns a QueryPool mock reporting the given free bytes per pool.
+// Pools missing from free fail to be queried.
+func poolsWithFree(free map[string]int64) func(ctx context.Context, poolName string) (*tnsapi.Pool, error) {
+	return func(_ context.Context, poolName string) (*tnsapi.Pool, error) {
+		bytes, ok := free[poolName]
+		if !ok {
+			return nil, errors.New("pool not found")
+		}
+		pool := &tnsapi.Pool{Name: poolName}
+		pool.Properties.Free.Parsed = bytes
+		return pool, nil
+	}
+}
+
+func TestParsePoolPlacement(t *testing.T) {
+	tests := []struct {
+		params       map[string]string
+		name         string
+		wantStrategy string
+		wantPools    []string
+		wantCode     codes.Code
+	}{
+		{name: "no pools", params: map[string]string{"pool": "tank"}},
+		{
+			name:         "default strategy",
+			params:       map[string]string{PoolsParam: "tank1, tank2,tank1"},
+			wantPools:    []string{"tank1", "tank2"},
+			wantStrategy: PoolPlacementMostFree,
+		},
+		{
+			name:         "round robin",
+			params:       map[string]string{PoolsParam: "tank1,tank2", PoolPlacementParam: "Round-Robin"},
+			wantPools:    []string{"tank1", "tank2"},
+			wantStrategy: PoolPlacementRoundRobin,
+		},
+		{name: "unknown strategy", params: map[string]string{PoolsParam: "tank1", PoolPlacementParam: "random"}, wantCode: codes.InvalidArgument},
+		{name: "empty entry", params: map[string]string{PoolsParam: "tank1,,tank2"}, wantCode: codes.InvalidArgument},
+		{name: "dataset path", params: map[string]string{PoolsParam: "tank1/k8s"}, wantCode: codes.InvalidArgument},
+		{name: "with pool", params: map[string]string{PoolsParam: "tank1", "pool": "tank"}, wantCode: codes.InvalidArgument},
+		{name: "with parentDataset", params: map[string]string{PoolsParam: "tank1", "parentDataset": "tank1/k8s"}, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pools, strategy, err := parsePoolPlacement(tt.params)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("parsePoolPlacement() error = %v, want code %v", err, tt.wantCode)
+			}
+			if len(pools) != len(tt.wantPools) || strategy != tt.wantStrategy {
+				t.Fatalf("parsePoolPlacement() = %v, %q, want %v, %q", pools, strategy, tt.wantPools, tt.wantStrategy)
+			}
+			for i := range pools {
+				if pools[i] != tt.wantPools[i] {
+					t.Errorf("pools[%d] = %q, want %q", i, pools[i], tt.wantPools[i])
+				}
+			}
+		})
+	}
+}
+
+func TestApplyPoolPlacement(t *testing.T) {
+	s := &ControllerService{apiClient: &mockAPIClient{
+		queryPoolFunc: poolsWithFree(map[string]int64{"tank1": 10 << 30, "tank2": 50 << 30}),
+	}}
+	newReq := func(params map[string]string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{Name: "pvc-1", Parameters: params}
+	}
+
+	req := newReq(map[string]string{PoolsParam: "tank1,tank2,tank3"})
+	placed, err := s.applyPoolPlacement(context.Background(), req)
+	if err != nil {
+		t.Fatalf("applyPoolPlacement() error = %v", err)
+	}
+	if got := placed.GetParameters()["pool"]; got != "tank2" {
+		t.Errorf("most-free placed on %q, want tank2", got)
+	}
+	if req.GetParameters()["pool"] != "" {
+		t.Error("applyPoolPlacement() modified the request")
+	}
+
+	var got []string
+	for range 3 {
+		placed, err := s.applyPoolPlacement(context.Background(), newReq(map[string]string{PoolsParam: "tank1,tank2", PoolPlacementParam: PoolPlacementRoundRobin}))
+		if err != nil {
+			t.Fatalf("applyPoolPlacement() error = %v", err)
+		}
+		got = append(got, placed.GetParameters()["pool"])
+	}
+	if got[0] != "tank1" || got[1] != "tank2" || got[2] != "tank1" {
+		t.Errorf("round-robin placed on %v, want [tank1 tank2 tank1]", got)
+	}
+
+	clone := newReq(map[string]string{PoolsParam: "tank1,tank2"})
+	clone.VolumeContentSource = &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Volume{Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "tank1/pvc-src"}},
+	}
+	if placed, err := s.applyPoolPlacement(context.Background(), clone); err != nil || placed.GetParameters()["pool"] != "tank1" {
+		t.Errorf("clone placed on %q (error %v), want the source pool tank1", placed.GetParameters()["pool"], err)
+	}
+	clone.Parameters[PoolsParam] = "tank2"
+	if _, err := s.applyPoolPlacement(context.Background(), clone); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("clone from a pool outside pools: error = %v, want InvalidArgument", err)
+	}
+
+	single := newReq(map[string]string{"pool": "tank"})
+	if placed, err := s.applyPoolPlacement(context.Background(), single); err != nil || placed != single {
+		t.Errorf("applyPoolPlacement() without pools = %v, %v, want the request unchanged", placed, err)
+	}
+
+	down := &ControllerService{apiClient: &mockAPIClient{queryPoolFunc: poolsWithFree(nil)}}
+	if _, err := down.applyPoolPlacement(context.Background(), newReq(map[string]string{PoolsParam: "tank1"})); status.Code(err) != codes.Unavailable {
+		t.Errorf("no queryable pool: error = %v, want Unavailable", err)
+	}
+}
+
+func TestGetCapacityPools(t *testing.T) {
+	s := &ControllerService{apiClient: &mockAPIClient{
+		queryPoolFunc: poolsWithFree(map[string]int64{"tank1": 10 << 30, "tank2": 50 << 30}),
+	}}
+	resp, err := s.GetCapacity(context.Background(), &csi.GetCapacityRequest{Parameters: map[string]string{PoolsParam: "tank1,tank2,tank3"}})
+	if err != nil {
+		t.Fatalf("GetCapacity() error = %v", err)
+	}
+	if resp.GetAvailableCapacity() != 60<<30 {
+		t.Errorf("AvailableCapacity = %d, want %d", resp.GetAvailableCapacity(), int64(60<<30))
+	}
+	if resp.GetMaximumVolumeSize().GetValue() != 50<<30 {
+		t.Errorf("MaximumVolumeSize = %d, want %d", resp.GetMaximumVolumeSize().GetValue(), int64(50<<30))
+	}
+
+	if _, err := s.GetCapacity(context.Background(), &csi.GetCapacityRequest{Parameters: map[string]string{PoolsParam: "tank3"}}); status.Code(err) != codes.Internal {
+		t.Errorf("GetCapacity() of unqueryable pools: error = %v, want Internal", err)
+	}
+}
+
+func TestPlacedPool(t *testing.T) {
+	volume := &csi.Volume{VolumeId: "tank2/pvc-1", VolumeContext: map[string]string{VolumeContextKeyDatasetName: "tank2/pvc-1"}}
+	if got := placedPool(volume); got != "tank2" {
+		t.Errorf("placedPool() = %q, want tank2", got)
+	}
+}
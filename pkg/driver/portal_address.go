@@ -0,0 +1,124 @@
+package driver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+)
+
+// Portal addresses.
+//
+// The server StorageClass parameter is an IPv4 address, an IPv6 address (with or without
+// brackets) or a hostname. NFS mount sources and iSCSI portals need IPv6 literals in
+// brackets, nvme-cli wants them bare, so the address is kept bare and formatted per use.
+//
+// On dual-stack networks server may list one address per family ("192.0.2.10,2001:db8::10").
+// Nodes pick the address of their --portal-ip-family, or the first one listed when it is not
+// set. When binding a subsystem to an NVMe-oF port, the controller prefers a port listening on
+// one of the listed addresses, then one listening on a wildcard of the same family.
+
+// Portal IP families (--portal-ip-family).
+const (
+	PortalIPFamilyIPv4 = "ipv4"
+	PortalIPFamilyIPv6 = "ipv6"
+)
+
+// ParsePortalIPFamily validates a --portal-ip-family value ("" = first listed address).
+func ParsePortalIPFamily(value string) (string, error) {
+	family := strings.ToLower(strings.TrimSpace(value))
+	switch family {
+	case "", PortalIPFamilyIPv4, PortalIPFamilyIPv6:
+		return family, nil
+	}
+	return "", fmt.Errorf("invalid portal IP family %q: must be %s or %s", value, PortalIPFamilyIPv4, PortalIPFamilyIPv6)
+}
+
+// portalAddresses returns the addresses listed in a server parameter, without IPv6 brackets.
+func portalAddresses(server string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(server, ",") {
+		if addr = strings.Trim(strings.TrimSpace(addr), "[]"); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// addressFamily returns the IP family of an address, or "" for hostnames.
+func addressFamily(addr string) string {
+	ip := net.ParseIP(strings.Trim(addr, "[]"))
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return PortalIPFamilyIPv4
+	}
+	return PortalIPFamilyIPv6
+}
+
+// selectPortalAddress returns the address of server to connect to: the first one of family,
+// or the first one listed if none matches or family is "". Hostnames match every family.
+func selectPortalAddress(server, family string) string {
+	addrs := portalAddresses(server)
+	if len(addrs) == 0 {
+		return ""
+	}
+	for _, addr := range addrs {
+		if f := addressFamily(addr); family == "" || f == "" || f == family {
+			return addr
+		}
+	}
+	return addrs[0]
+}
+
+// nfsMountSource returns the mount source of an NFS export, bracketing IPv6 addresses.
+func nfsMountSource(server, share string) string {
+	server = strings.Trim(server, "[]")
+	if addressFamily(server) == PortalIPFamilyIPv6 {
+		server = "[" + server + "]"
+	}
+	return server + ":" + share
+}
+
+// portalHostPort returns "address:port", bracketing IPv6 addresses.
+func portalHostPort(server, port string) string {
+	return net.JoinHostPort(strings.Trim(server, "[]"), port)
+}
+
+// selectNVMeOFPort returns the port a subsystem of server should be bound to: a port of the
+// transport listening on one of the server's addresses, else one listening on a wildcard or
+// another address of the same family, else the first port of the transport. Returns nil if
+// no port serves the transport.
+func selectNVMeOFPort(ports []tnsapi.NVMeOFPort, transport, server string) *tnsapi.NVMeOFPort {
+	addrs := portalAddresses(server)
+	var sameFamily, first *tnsapi.NVMeOFPort
+	for i := range ports {
+		port := &ports[i]
+		if !portMatchesTransport(port, transport) {
+			continue
+		}
+		if first == nil {
+			first = port
+		}
+		portAddr := strings.Trim(port.Address, "[]")
+		for _, addr := range addrs {
+			if ip := net.ParseIP(addr); addr == portAddr || (ip != nil && ip.Equal(net.ParseIP(portAddr))) {
+				return port
+			}
+			if sameFamily == nil && addressFamily(addr) != "" && addressFamily(addr) == addressFamily(portAddr) {
+				sameFamily = port
+			}
+		}
+	}
+	if sameFamily != nil {
+		return sameFamily
+	}
+	return first
+}
+
+// portalAddress returns the address of a volume's server the node connects to.
+func (s *NodeService) portalAddress(server string) string {
+	return selectPortalAddress(server, s.portalIPFamily)
+}
@@ -0,0 +1,60 @@
+package driver
+
+import "testing"
+
+func TestSelectPortalAddress(t *testing.T) {
+	tests := []struct {
+		name   string
+		server string
+		family string
+		want   string
+	}{
+		{name: "ipv4", server: "192.0.2.10", want: "192.0.2.10"},
+		{name: "bracketed ipv6", server: "[2001:db8::10]", want: "2001:db8::10"},
+		{name: "hostname", server: "truenas.local", family: PortalIPFamilyIPv6, want: "truenas.local"},
+		{name: "dual-stack first listed", server: "192.0.2.10,2001:db8::10", want: "192.0.2.10"},
+		{name: "dual-stack prefers ipv6", server: "192.0.2.10, [2001:db8::10]", family: PortalIPFamilyIPv6, want: "2001:db8::10"},
+		{name: "dual-stack prefers ipv4", server: "2001:db8::10,192.0.2.10", family: PortalIPFamilyIPv4, want: "192.0.2.10"},
+		{name: "no address of family", server: "192.0.2.10", family: PortalIPFamilyIPv6, want: "192.0.2.10"},
+		{name: "empty", server: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectPortalAddress(tt.server, tt.family); got != tt.want {
+				t.Errorf("selectPortalAddress(%q, %q) = %q, want %q", tt.server, tt.family, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPortalAddressFormatting(t *testing.T) {
+	tests := []struct {
+		server       string
+		wantSource   string
+		wantHostPort string
+	}{
+		{server: "192.0.2.10", wantSource: "192.0.2.10:/mnt/tank/pvc", wantHostPort: "192.0.2.10:3260"},
+		{server: "2001:db8::10", wantSource: "[2001:db8::10]:/mnt/tank/pvc", wantHostPort: "[2001:db8::10]:3260"},
+		{server: "[2001:db8::10]", wantSource: "[2001:db8::10]:/mnt/tank/pvc", wantHostPort: "[2001:db8::10]:3260"},
+		{server: "truenas.local", wantSource: "truenas.local:/mnt/tank/pvc", wantHostPort: "truenas.local:3260"},
+	}
+	for _, tt := range tests {
+		if got := nfsMountSource(tt.server, "/mnt/tank/pvc"); got != tt.wantSource {
+			t.Errorf("nfsMountSource(%q) = %q, want %q", tt.server, got, tt.wantSource)
+		}
+		if got := portalHostPort(tt.server, "3260"); got != tt.wantHostPort {
+			t.Errorf("portalHostPort(%q) = %q, want %q", tt.server, got, tt.wantHostPort)
+		}
+	}
+}
+
+func TestParsePortalIPFamily(t *testing.T) {
+	for _, value := range []string{"", "ipv4", "IPv6"} {
+		if _, err := ParsePortalIPFamily(value); err != nil {
+			t.Errorf("ParsePortalIPFamily(%q) error = %v", value, err)
+		}
+	}
+	if _, err := ParsePortalIPFamily("inet6"); err == nil {
+		t.Error("ParsePortalIPFamily(\"inet6\") succeeded, want error")
+	}
+}
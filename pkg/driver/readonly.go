@@ -0,0 +1,83 @@
+package driver
+
+import (
+	"context"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Read-only volumes.
+//
+// A volume restored or cloned with only reader access modes (ReadOnlyMany / ReadWriteOncePod
+// readers) is typically used to verify a backup or inspect a snapshot. Such volumes are made
+// read-only at the storage level too, not just through ro mounts, so a misconfigured pod can
+// never modify the data:
+//   - NFS/SMB: dataset readonly=on and a read-only share
+//   - iSCSI: ZVOL readonly=on and a read-only extent
+//   - NVMe-oF: ro mounts only (the kernel target cannot attach a read-only ZVOL)
+//
+// The volume context carries readOnly=true so the node stages the volume read-only as well.
+
+// zfsReadonlyOn is the TrueNAS API value for readonly=on.
+const zfsReadonlyOn = "ON"
+
+// isReadOnlyAccessMode reports whether an access mode only allows reading.
+func isReadOnlyAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return mode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY ||
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+}
+
+// isReadOnlyVolumeRequest reports whether every requested capability is read-only.
+func isReadOnlyVolumeRequest(caps []*csi.VolumeCapability) bool {
+	if len(caps) == 0 {
+		return false
+	}
+	for _, c := range caps {
+		if !isReadOnlyAccessMode(c.GetAccessMode().GetMode()) {
+			return false
+		}
+	}
+	return true
+}
+
+// isReadOnlyContentSourceRequest reports whether a CreateVolume request is a restore/clone that
+// must be read-only at the storage level.
+func isReadOnlyContentSourceRequest(req *csi.CreateVolumeRequest) bool {
+	return req.GetVolumeContentSource() != nil && isReadOnlyVolumeRequest(req.GetVolumeCapabilities())
+}
+
+// setDatasetReadOnly sets ZFS readonly=on on a cloned dataset or ZVOL.
+// NVMe-oF ZVOLs are skipped: the kernel target opens namespaces read-write.
+func (s *ControllerService) setDatasetReadOnly(ctx context.Context, datasetID, protocol string) error {
+	if protocol == ProtocolNVMeOF {
+		klog.V(4).Infof("Not setting readonly=on on NVMe-oF ZVOL %s; read-only is enforced by node mounts", datasetID)
+		return nil
+	}
+	if _, err := s.apiClient.UpdateDataset(ctx, datasetID, tnsapi.DatasetUpdateParams{Readonly: zfsReadonlyOn}); err != nil {
+		return status.Errorf(codes.Internal, "Failed to make dataset %s read-only: %v", datasetID, err)
+	}
+	klog.V(4).Infof("Set readonly=on on %s", datasetID)
+	return nil
+}
+
+// readOnlyStageMountOptions returns mount options that stage a filesystem read-only.
+// Journaled filesystems also skip log replay, which would write to the device.
+func readOnlyStageMountOptions(options []string, fsType string) []string {
+	options = append(options, "ro")
+	switch fsType {
+	case "ext3", "ext4":
+		options = append(options, "noload")
+	case "xfs":
+		options = append(options, "norecovery")
+	}
+	return options
+}
+
+// isReadOnlyVolumeContext reports whether a volume was provisioned read-only.
+func isReadOnlyVolumeContext(volumeContext map[string]string) bool {
+	return volumeContext[VolumeContextKeyReadOnly] == VolumeContextValueTrue
+}
@@ -0,0 +1,131 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+)
+
+func readOnlyTestCapability(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}
+}
+
+func TestIsReadOnlyVolumeRequest(t *testing.T) {
+	ro := readOnlyTestCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)
+	roSingle := readOnlyTestCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY)
+	rw := readOnlyTestCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+
+	tests := []struct {
+		name string
+		caps []*csi.VolumeCapability
+		want bool
+	}{
+		{name: "no capabilities", caps: nil, want: false},
+		{name: "reader only", caps: []*csi.VolumeCapability{ro}, want: true},
+		{name: "single and multi node readers", caps: []*csi.VolumeCapability{ro, roSingle}, want: true},
+		{name: "mixed reader and writer", caps: []*csi.VolumeCapability{ro, rw}, want: false},
+		{name: "writer", caps: []*csi.VolumeCapability{rw}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isReadOnlyVolumeRequest(tt.caps); got != tt.want {
+				t.Errorf("isReadOnlyVolumeRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadOnlyStageMountOptions(t *testing.T) {
+	tests := []struct {
+		fsType string
+		want   []string
+	}{
+		{fsType: "ext4", want: []string{"noatime", "ro", "noload"}},
+		{fsType: "xfs", want: []string{"noatime", "ro", "norecovery"}},
+		{fsType: "btrfs", want: []string{"noatime", "ro"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fsType, func(t *testing.T) {
+			if got := readOnlyStageMountOptions([]string{"noatime"}, tt.fsType); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readOnlyStageMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetupReadOnlyNFSClone(t *testing.T) {
+	ctx := context.Background()
+	dataset := &tnsapi.Dataset{ID: "tank/csi/pvc-ro", Name: "tank/csi/pvc-ro", Mountpoint: "/mnt/tank/csi/pvc-ro"}
+	info := &cloneInfo{Mode: "cow", SnapshotID: "tank/csi/pvc-src@snap-1", OriginSnapshot: "tank/csi/pvc-src@snap-1"}
+
+	var updates []tnsapi.DatasetUpdateParams
+	var shareParams tnsapi.NFSShareCreateParams
+	deleted := false
+	mock := &MockAPIClientForSnapshots{
+		UpdateDatasetFunc: func(_ context.Context, _ string, params tnsapi.DatasetUpdateParams) (*tnsapi.Dataset, error) {
+			updates = append(updates, params)
+			return dataset, nil
+		},
+		CreateNFSShareFunc: func(_ context.Context, params tnsapi.NFSShareCreateParams) (*tnsapi.NFSShare, error) {
+			shareParams = params
+			return &tnsapi.NFSShare{ID: 9, Path: params.Path, Enabled: true}, nil
+		},
+		DeleteDatasetFunc: func(_ context.Context, _ string) error {
+			deleted = true
+			return nil
+		},
+	}
+	service := NewControllerService(mock, NewNodeRegistry(), "")
+
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-ro",
+		Parameters:         map[string]string{"server": "10.0.0.1"},
+		VolumeCapabilities: []*csi.VolumeCapability{readOnlyTestCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)},
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: info.SnapshotID}},
+		},
+	}
+	if _, err := service.provisionFromClone(ctx, req, dataset, ProtocolNFS, info); err != nil {
+		t.Fatalf("provisionFromClone() error = %v", err)
+	}
+	if len(updates) == 0 || updates[0].Readonly != zfsReadonlyOn {
+		t.Errorf("dataset updates = %+v, want readonly=%s first", updates, zfsReadonlyOn)
+	}
+	if !shareParams.ReadOnly {
+		t.Error("NFS share for a read-only restore was not exported read-only")
+	}
+
+	// Writable restores are left alone
+	updates = nil
+	req.VolumeCapabilities = []*csi.VolumeCapability{readOnlyTestCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)}
+	if _, err := service.provisionFromClone(ctx, req, dataset, ProtocolNFS, info); err != nil {
+		t.Fatalf("provisionFromClone() error = %v", err)
+	}
+	for _, u := range updates {
+		if u.Readonly != "" {
+			t.Errorf("writable restore updated readonly to %q", u.Readonly)
+		}
+	}
+	if shareParams.ReadOnly {
+		t.Error("writable restore exported read-only")
+	}
+
+	// Failing to lock the clone removes it instead of exporting it writable
+	mock.UpdateDatasetFunc = func(_ context.Context, _ string, _ tnsapi.DatasetUpdateParams) (*tnsapi.Dataset, error) {
+		return nil, errors.New("update failed")
+	}
+	req.VolumeCapabilities = []*csi.VolumeCapability{readOnlyTestCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY)}
+	if _, err := service.provisionFromClone(ctx, req, dataset, ProtocolNFS, info); err == nil {
+		t.Error("provisionFromClone() succeeded although readonly could not be set")
+	}
+	if !deleted {
+		t.Error("clone was not cleaned up after readonly failure")
+	}
+}
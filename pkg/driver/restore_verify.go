@@ -0,0 +1,149 @@
+package driver
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Restore verification.
+//
+// With the StorageClass parameter verifyRestore: "true", a volume restored from a snapshot or
+// cloned from a volume is checked before CreateVolume returns it, so a copy damaged on the way
+// (e.g. by NVMe-oF metadata races right after cloning) fails the restore instead of being
+// handed to a workload:
+//
+//   - every regular snapshot records its ZFS guid and referenced size when it is taken
+//     (PropertySnapshotChecksum); the source snapshot must still match the record
+//   - linked clones (COW, promoted, restores of detached snapshots) must not have been written
+//     to since their origin (written = 0, like an empty zfs diff) and must reference as much
+//     data as the snapshot
+//   - send/receive copies must have received a snapshot with the source snapshot's guid,
+//     which zfs receive preserves
+//
+// Failed checks return DataLoss and remove the restored dataset, so the provisioner retries.
+
+// VerifyRestoreParam is the StorageClass parameter enabling restore verification.
+const VerifyRestoreParam = "verifyRestore"
+
+// restoreChecksum is what a restored volume is verified against.
+type restoreChecksum struct {
+	source     string // Snapshot or detached snapshot dataset the volume is restored from
+	guid       string // ZFS guid of the source snapshot; empty for detached snapshot datasets
+	referenced int64
+}
+
+// snapshotChecksum returns the PropertySnapshotChecksum value of a snapshot, or "" if TrueNAS
+// did not report its guid.
+func snapshotChecksum(snapshot *tnsapi.Snapshot) string {
+	guid := snapshot.GUID()
+	if guid == "" {
+		return ""
+	}
+	return guid + ":" + strconv.FormatInt(snapshot.ReferencedBytes(), 10)
+}
+
+// parseSnapshotChecksum splits a PropertySnapshotChecksum value.
+func parseSnapshotChecksum(value string) (guid string, referenced int64, ok bool) {
+	guid, size, found := strings.Cut(value, ":")
+	referenced, err := strconv.ParseInt(size, 10, 64)
+	if !found || guid == "" || err != nil {
+		return "", 0, false
+	}
+	return guid, referenced, true
+}
+
+// restoreSourceChecksum returns the checksum of the snapshot a volume is restored from, after
+// checking it against the checksum recorded when the snapshot was taken.
+func (s *ControllerService) restoreSourceChecksum(ctx context.Context, snapshotMeta *SnapshotMetadata) (*restoreChecksum, error) {
+	if snapshotMeta.Detached {
+		dataset, err := s.apiClient.Dataset(ctx, snapshotMeta.DatasetName)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot verify restore: failed to read detached snapshot %s: %v", snapshotMeta.DatasetName, err)
+		}
+		return &restoreChecksum{source: snapshotMeta.DatasetName, referenced: dataset.ReferencedBytes()}, nil
+	}
+
+	snapshots, err := s.apiClient.QuerySnapshotsWithProperties(ctx, []interface{}{
+		[]interface{}{"id", "=", snapshotMeta.SnapshotName},
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot verify restore: failed to read snapshot %s: %v", snapshotMeta.SnapshotName, err)
+	}
+	if len(snapshots) == 0 {
+		return nil, status.Errorf(codes.NotFound, "Snapshot not found: %s", snapshotMeta.SnapshotName)
+	}
+	snapshot := &snapshots[0]
+	current := &restoreChecksum{source: snapshot.ID, guid: snapshot.GUID(), referenced: snapshot.ReferencedBytes()}
+	if current.guid == "" {
+		return nil, status.Errorf(codes.Internal, "cannot verify restore: TrueNAS did not report the guid of snapshot %s", snapshot.ID)
+	}
+
+	recorded, ok := tnsapi.GetSnapshotPropertyValue(*snapshot, tnsapi.PropertySnapshotChecksum)
+	if !ok {
+		klog.V(4).Infof("Snapshot %s has no recorded checksum; verifying the restore against its current state", snapshot.ID)
+		return current, nil
+	}
+	guid, referenced, ok := parseSnapshotChecksum(recorded)
+	if !ok {
+		klog.Warningf("Ignoring invalid %s %q on snapshot %s", tnsapi.PropertySnapshotChecksum, recorded, snapshot.ID)
+		return current, nil
+	}
+	if guid != current.guid || referenced != current.referenced {
+		return nil, status.Errorf(codes.DataLoss, "snapshot %s does not match the checksum recorded when it was taken: guid %s, %d bytes referenced, want guid %s, %d bytes",
+			snapshot.ID, current.guid, current.referenced, guid, referenced)
+	}
+	return current, nil
+}
+
+// verifyRestoredClone checks that a linked clone is unchanged since its origin.
+func (s *ControllerService) verifyRestoredClone(ctx context.Context, expected *restoreChecksum, datasetName string) error {
+	dataset, err := s.apiClient.Dataset(ctx, datasetName)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot verify restored volume %s: %v", datasetName, err)
+	}
+	if dataset.Written == nil {
+		return status.Errorf(codes.Internal, "cannot verify restored volume %s: TrueNAS did not report its written property", datasetName)
+	}
+	if written := dataset.WrittenBytes(); written != 0 {
+		return status.Errorf(codes.DataLoss, "restored volume %s differs from %s: %d bytes written since the clone was created",
+			datasetName, expected.source, written)
+	}
+	if referenced := dataset.ReferencedBytes(); referenced != expected.referenced {
+		return status.Errorf(codes.DataLoss, "restored volume %s references %d bytes, but %s references %d",
+			datasetName, referenced, expected.source, expected.referenced)
+	}
+	klog.Infof("Verified restored volume %s against %s (%d bytes referenced)", datasetName, expected.source, expected.referenced)
+	return nil
+}
+
+// verifyReceivedSnapshot checks that a send/receive copy received the source snapshot.
+func (s *ControllerService) verifyReceivedSnapshot(ctx context.Context, expected *restoreChecksum, snapshotName string) error {
+	snapshots, err := s.apiClient.QuerySnapshotsWithProperties(ctx, []interface{}{
+		[]interface{}{"id", "=", snapshotName},
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot verify received snapshot %s: %v", snapshotName, err)
+	}
+	if len(snapshots) == 0 {
+		return status.Errorf(codes.DataLoss, "restored volume has no snapshot %s: the send/receive copy of %s is incomplete", snapshotName, expected.source)
+	}
+	if guid := snapshots[0].GUID(); guid != expected.guid {
+		return status.Errorf(codes.DataLoss, "received snapshot %s has guid %s, but %s has guid %s",
+			snapshotName, guid, expected.source, expected.guid)
+	}
+	klog.Infof("Verified received snapshot %s against %s (guid %s)", snapshotName, expected.source, expected.guid)
+	return nil
+}
+
+// failRestoreVerification removes a restored dataset that failed verification and returns err.
+func (s *ControllerService) failRestoreVerification(ctx context.Context, datasetName string, err error) error {
+	klog.Errorf("Restore verification failed, removing %s: %v", datasetName, err)
+	s.cleanupPartialClone(ctx, datasetName)
+	return err
+}
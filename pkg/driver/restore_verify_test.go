@@ -0,0 +1,123 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRestoreVerificationIntegration(t *testing.T) {
+	controller, _ := newIntegrationController(t)
+	ctx := context.Background()
+
+	params := map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local"}
+	createVolume := func(name string, source *csi.VolumeContentSource) (*csi.CreateVolumeResponse, error) {
+		return controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:          name,
+			CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			Parameters:          params,
+			VolumeContentSource: source,
+		})
+	}
+	source, err := createVolume("pvc-source", nil)
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	snapshot, err := controller.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: source.GetVolume().GetVolumeId()})
+	if err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+	zfsSnapshot := source.GetVolume().GetVolumeId() + "@snap-1"
+	snapshots, err := controller.apiClient.QuerySnapshotsWithProperties(ctx, []interface{}{[]interface{}{"id", "=", zfsSnapshot}})
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("QuerySnapshotsWithProperties() = %v, %v", snapshots, err)
+	}
+	if checksum, _ := tnsapi.GetSnapshotPropertyValue(snapshots[0], tnsapi.PropertySnapshotChecksum); checksum != snapshotChecksum(&snapshots[0]) || checksum == "" {
+		t.Errorf("recorded checksum = %q, want %q", checksum, snapshotChecksum(&snapshots[0]))
+	}
+
+	params[VerifyRestoreParam] = VolumeContextValueTrue
+	fromSnapshot := &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+		Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshot.GetSnapshot().GetSnapshotId()},
+	}}
+	if _, err := createVolume("pvc-restored", fromSnapshot); err != nil {
+		t.Fatalf("verified restore error = %v", err)
+	}
+	fromVolume := &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Volume{
+		Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: source.GetVolume().GetVolumeId()},
+	}}
+	if _, err := createVolume("pvc-cloned", fromVolume); err != nil {
+		t.Fatalf("verified clone error = %v", err)
+	}
+
+	// A snapshot no longer matching its recorded checksum is not restored
+	if err := controller.apiClient.SetSnapshotProperties(ctx, zfsSnapshot, map[string]string{tnsapi.PropertySnapshotChecksum: "42:0"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createVolume("pvc-tampered", fromSnapshot); status.Code(err) != codes.DataLoss {
+		t.Errorf("restore of a changed snapshot error = %v, want DataLoss", err)
+	}
+	if dataset, err := controller.apiClient.Dataset(ctx, "tank/pvc-tampered"); err == nil && dataset != nil {
+		t.Errorf("dataset %s created for a failed verification", dataset.ID)
+	}
+}
+
+func TestVerifyRestoredClone(t *testing.T) {
+	written := map[string]interface{}{"rawvalue": "0"}
+	referenced := map[string]interface{}{"rawvalue": "4096"}
+	s := &ControllerService{apiClient: &mockAPIClient{
+		datasetFunc: func(_ context.Context, datasetID string) (*tnsapi.Dataset, error) {
+			return &tnsapi.Dataset{ID: datasetID, Written: written, Referenced: referenced}, nil
+		},
+	}}
+	ctx := context.Background()
+	expected := &restoreChecksum{source: "tank/pvc-1@snap", guid: "123", referenced: 4096}
+
+	if err := s.verifyRestoredClone(ctx, expected, "tank/pvc-2"); err != nil {
+		t.Errorf("verifyRestoredClone() unchanged clone error = %v", err)
+	}
+	written = map[string]interface{}{"rawvalue": "512"}
+	if err := s.verifyRestoredClone(ctx, expected, "tank/pvc-2"); status.Code(err) != codes.DataLoss {
+		t.Errorf("verifyRestoredClone() written clone error = %v, want DataLoss", err)
+	}
+	written, referenced = map[string]interface{}{"rawvalue": "0"}, map[string]interface{}{"rawvalue": "1024"}
+	if err := s.verifyRestoredClone(ctx, expected, "tank/pvc-2"); status.Code(err) != codes.DataLoss {
+		t.Errorf("verifyRestoredClone() short clone error = %v, want DataLoss", err)
+	}
+	written = nil
+	if err := s.verifyRestoredClone(ctx, expected, "tank/pvc-2"); status.Code(err) != codes.Internal {
+		t.Errorf("verifyRestoredClone() without written error = %v, want Internal", err)
+	}
+
+	// The mock reports no snapshots: the received snapshot is missing
+	if err := s.verifyReceivedSnapshot(ctx, expected, "tank/pvc-2@snap"); status.Code(err) != codes.DataLoss {
+		t.Errorf("verifyReceivedSnapshot() missing snapshot error = %v, want DataLoss", err)
+	}
+}
+
+func TestParseSnapshotChecksum(t *testing.T) {
+	snapshot := &tnsapi.Snapshot{Properties: map[string]interface{}{
+		"guid":       map[string]interface{}{"rawvalue": "9876543210"},
+		"referenced": map[string]interface{}{"rawvalue": "1073741824"},
+	}}
+	guid, referenced, ok := parseSnapshotChecksum(snapshotChecksum(snapshot))
+	if !ok || guid != "9876543210" || referenced != 1<<30 {
+		t.Errorf("parseSnapshotChecksum() = %q, %d, %v", guid, referenced, ok)
+	}
+	for _, invalid := range []string{"", "123", ":42", "123:big"} {
+		if _, _, ok := parseSnapshotChecksum(invalid); ok {
+			t.Errorf("parseSnapshotChecksum(%q) ok", invalid)
+		}
+	}
+	if checksum := snapshotChecksum(&tnsapi.Snapshot{}); checksum != "" {
+		t.Errorf("snapshotChecksum() without guid = %q", checksum)
+	}
+}
@@ -0,0 +1,153 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/klog/v2"
+)
+
+// DNS server endpoints.
+//
+// The server StorageClass parameter may be a hostname (e.g. truenas.storage.svc.example).
+// By default it is stored as is in the PV and resolved by the node at mount time, so a DNS
+// change after a TrueNAS failover reaches new mounts without touching PVs. The node records
+// the hostname with each staged volume and resolves it again when it remounts a stale NFS
+// volume or reconnects an NVMe-oF session after a restart.
+//
+// With serverResolution: controller, CreateVolume resolves the hostname instead and pins the
+// PV to the addresses it resolved to (one per family, see portal_address.go).
+
+// ServerResolutionParam is the StorageClass parameter selecting where server hostnames are resolved.
+const ServerResolutionParam = "serverResolution"
+
+// Server resolution modes.
+const (
+	ServerResolutionNode       = "node"       // PVs keep the hostname, nodes resolve it at mount time (default)
+	ServerResolutionController = "controller" // CreateVolume resolves the hostname and pins the PV to the addresses
+)
+
+// lookupIPFunc resolves a host name to IP addresses, like net.Resolver.LookupIP.
+type lookupIPFunc func(ctx context.Context, network, host string) ([]net.IP, error)
+
+// parseServerResolution validates the serverResolution parameter ("" = node).
+func parseServerResolution(params map[string]string) (string, error) {
+	switch mode := strings.ToLower(params[ServerResolutionParam]); mode {
+	case "", ServerResolutionNode:
+		return ServerResolutionNode, nil
+	case ServerResolutionController:
+		return mode, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "invalid %s %q: must be %s or %s",
+			ServerResolutionParam, params[ServerResolutionParam], ServerResolutionNode, ServerResolutionController)
+	}
+}
+
+// resolveHost resolves host to one IP address, preferring family ("" = any).
+func resolveHost(ctx context.Context, lookup lookupIPFunc, host, family string) (string, error) {
+	ips, err := lookup(ctx, "ip", host)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("failed to resolve %s: no addresses", host)
+	}
+	for _, ip := range ips {
+		if family == "" || addressFamily(ip.String()) == family {
+			return ip.String(), nil
+		}
+	}
+	return ips[0].String(), nil
+}
+
+// pinServerAddresses replaces the hostnames listed in server with the first IPv4 and the first
+// IPv6 address they resolve to, keeping the order of the list.
+func pinServerAddresses(ctx context.Context, lookup lookupIPFunc, server string) (string, error) {
+	var pinned []string
+	add := func(addr string) {
+		for _, p := range pinned {
+			if p == addr {
+				return
+			}
+		}
+		pinned = append(pinned, addr)
+	}
+	for _, addr := range portalAddresses(server) {
+		if addressFamily(addr) != "" {
+			add(addr)
+			continue
+		}
+		ips, err := lookup(ctx, "ip", addr)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", addr, err)
+		}
+		if len(ips) == 0 {
+			return "", fmt.Errorf("failed to resolve %s: no addresses", addr)
+		}
+		seen := make(map[string]bool)
+		for _, ip := range ips {
+			if family := addressFamily(ip.String()); !seen[family] {
+				seen[family] = true
+				add(ip.String())
+			}
+		}
+	}
+	return strings.Join(pinned, ","), nil
+}
+
+// applyServerPinning resolves the server hostnames of a CreateVolume request when its
+// serverResolution is controller, returning a copy of the request with the pinned server.
+func (s *ControllerService) applyServerPinning(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeRequest, error) {
+	mode, err := parseServerResolution(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+	server := req.GetParameters()["server"]
+	if mode != ServerResolutionController || server == "" {
+		return req, nil
+	}
+	lookup := s.lookupIP
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupIP
+	}
+	addrs, err := pinServerAddresses(ctx, lookup, server)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "cannot pin server: %v", err)
+	}
+	if addrs == server {
+		return req, nil
+	}
+	pinned, ok := proto.Clone(req).(*csi.CreateVolumeRequest)
+	if !ok {
+		return nil, status.Error(codes.Internal, "failed to copy CreateVolume request")
+	}
+	pinned.Parameters["server"] = addrs
+	klog.Infof("Pinned server %s of volume %s to %s", server, req.GetName(), addrs)
+	return pinned, nil
+}
+
+// resolvePortal returns the IP address of a volume's server the node connects to. Hostnames
+// are resolved now, preferring --portal-ip-family; endpoint is the hostname ("" if server
+// lists an address).
+func (s *NodeService) resolvePortal(ctx context.Context, server string) (addr, endpoint string, err error) {
+	addr = s.portalAddress(server)
+	if addr == "" || addressFamily(addr) != "" || s.testMode {
+		return addr, "", nil
+	}
+	lookup := s.lookupIP
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupIP
+	}
+	resolved, err := resolveHost(ctx, lookup, addr, s.portalIPFamily)
+	if err != nil {
+		return "", "", status.Errorf(codes.Unavailable, "cannot resolve server: %v", err)
+	}
+	klog.V(4).Infof("Resolved server %s to %s", addr, resolved)
+	return resolved, addr, nil
+}
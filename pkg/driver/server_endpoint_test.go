@@ -0,0 +1,129 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errNoSuchHost = errors.New("no such host")
+
+// fakeLookupIP resolves host names from a static table.
+func fakeLookupIP(hosts map[string][]string) lookupIPFunc {
+	return func(_ context.Context, _, host string) ([]net.IP, error) {
+		addrs, ok := hosts[host]
+		if !ok {
+			return nil, errNoSuchHost
+		}
+		ips := make([]net.IP, 0, len(addrs))
+		for _, addr := range addrs {
+			ips = append(ips, net.ParseIP(addr))
+		}
+		return ips, nil
+	}
+}
+
+func TestPinServerAddresses(t *testing.T) {
+	lookup := fakeLookupIP(map[string][]string{
+		"truenas.local": {"192.0.2.10", "192.0.2.11", "2001:db8::10"},
+		"v6.local":      {"2001:db8::20"},
+	})
+	tests := []struct {
+		server  string
+		want    string
+		wantErr bool
+	}{
+		{server: "192.0.2.10", want: "192.0.2.10"},
+		{server: "truenas.local", want: "192.0.2.10,2001:db8::10"},
+		{server: "v6.local,192.0.2.10", want: "2001:db8::20,192.0.2.10"},
+		{server: "truenas.local,[2001:db8::10]", want: "192.0.2.10,2001:db8::10"},
+		{server: "unknown.local", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := pinServerAddresses(context.Background(), lookup, tt.server)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pinServerAddresses(%q) error = %v, wantErr %v", tt.server, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("pinServerAddresses(%q) = %q, want %q", tt.server, got, tt.want)
+		}
+	}
+}
+
+func TestResolvePortal(t *testing.T) {
+	lookup := fakeLookupIP(map[string][]string{"truenas.local": {"192.0.2.10", "2001:db8::10"}})
+	tests := []struct {
+		name         string
+		server       string
+		family       string
+		wantAddr     string
+		wantEndpoint string
+		wantCode     codes.Code
+	}{
+		{name: "address", server: "192.0.2.20", wantAddr: "192.0.2.20"},
+		{name: "hostname", server: "truenas.local", wantAddr: "192.0.2.10", wantEndpoint: "truenas.local"},
+		{name: "hostname prefers ipv6", server: "truenas.local", family: PortalIPFamilyIPv6, wantAddr: "2001:db8::10", wantEndpoint: "truenas.local"},
+		{name: "unresolvable", server: "unknown.local", wantCode: codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &NodeService{lookupIP: lookup, portalIPFamily: tt.family}
+			addr, endpoint, err := node.resolvePortal(context.Background(), tt.server)
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Errorf("resolvePortal(%q) error = %v, want %v", tt.server, err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolvePortal(%q) error = %v", tt.server, err)
+			}
+			if addr != tt.wantAddr || endpoint != tt.wantEndpoint {
+				t.Errorf("resolvePortal(%q) = %q, %q, want %q, %q", tt.server, addr, endpoint, tt.wantAddr, tt.wantEndpoint)
+			}
+		})
+	}
+}
+
+func TestServerResolutionIntegration(t *testing.T) {
+	tests := []struct {
+		name       string
+		resolution string
+		wantServer string
+	}{
+		{name: "node keeps hostname", resolution: "", wantServer: "truenas.local"},
+		{name: "controller pins addresses", resolution: ServerResolutionController, wantServer: "192.0.2.10,2001:db8::10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, _ := newIntegrationController(t)
+			controller.lookupIP = fakeLookupIP(map[string][]string{"truenas.local": {"192.0.2.10", "2001:db8::10"}})
+
+			params := map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local"}
+			if tt.resolution != "" {
+				params[ServerResolutionParam] = tt.resolution
+			}
+			resp, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:          "pvc-dns",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				}},
+				Parameters: params,
+			})
+			if err != nil {
+				t.Fatalf("CreateVolume() error = %v", err)
+			}
+			if got := resp.GetVolume().GetVolumeContext()["server"]; got != tt.wantServer {
+				t.Errorf("volume context server = %q, want %q", got, tt.wantServer)
+			}
+		})
+	}
+}
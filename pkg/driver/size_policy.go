@@ -0,0 +1,283 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// Volume size policy.
+//
+// A CreateVolume request without a required size gets the default size: the defaultSize
+// StorageClass parameter, else --default-volume-size (1Gi). The capacityRounding parameter,
+// else --capacity-rounding, then rounds the capacity up: "gib" to whole GiB, "volblocksize"
+// to whole blocks of NVMe-oF/iSCSI ZVOLs, "none" keeps it as requested. The resulting size is
+// what every provisioning path (new volumes, clones, restores, adoption) creates and reports,
+// and expansion rounds the same way.
+//
+// The minSize and maxSize StorageClass parameters bound the capacity of volumes provisioned
+// from the class, so that tiny requests do not create pathological ZVOLs and huge ones cannot
+// consume the pool. CreateVolume rejects requests outside the range with InvalidArgument.
+//
+// The external-resizer does not pass StorageClass parameters to ControllerExpandVolume, so
+// maxSize and capacityRounding are recorded on the dataset (tns-csi:max_size,
+// tns-csi:capacity_rounding) and applied on expansion.
+const (
+	// MinSizeParam is the StorageClass parameter setting the minimum volume size.
+	MinSizeParam = "minSize"
+	// MaxSizeParam is the StorageClass parameter setting the maximum volume size.
+	MaxSizeParam = "maxSize"
+	// DefaultSizeParam is the StorageClass parameter setting the size of volumes requested without one.
+	DefaultSizeParam = "defaultSize"
+	// CapacityRoundingParam is the StorageClass parameter selecting the capacity rounding mode.
+	CapacityRoundingParam = "capacityRounding"
+)
+
+// Capacity rounding modes.
+const (
+	capacityRoundingNone         = "none"
+	capacityRoundingGiB          = "gib"
+	capacityRoundingVolblocksize = "volblocksize"
+)
+
+// defaultZvolBlockSize is the volblocksize of ZVOLs created without zfs.volblocksize.
+const defaultZvolBlockSize = 16 * 1024
+
+// ParseDefaultVolumeSize parses the size of volumes requested without one (e.g. "10Gi").
+// An empty value selects the 1 GiB minimum.
+func ParseDefaultVolumeSize(value string) (int64, error) {
+	if value == "" {
+		return MinVolumeSize, nil
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil || q.Value() < MinVolumeSize {
+		return 0, fmt.Errorf("invalid default volume size %q: must be a size of at least 1Gi", value)
+	}
+	return q.Value(), nil
+}
+
+// ParseCapacityRounding validates a capacity rounding mode. An empty value selects "none".
+func ParseCapacityRounding(value string) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(value))
+	switch mode {
+	case "":
+		return capacityRoundingNone, nil
+	case capacityRoundingNone, capacityRoundingGiB, capacityRoundingVolblocksize:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid capacity rounding %q: must be %s, %s or %s",
+			value, capacityRoundingNone, capacityRoundingGiB, capacityRoundingVolblocksize)
+	}
+}
+
+// roundCapacity rounds a capacity up according to mode. blockSize is the ZVOL block size
+// for "volblocksize" (0 for filesystem datasets, which are not rounded).
+func roundCapacity(capacity int64, mode string, blockSize int64) int64 {
+	switch mode {
+	case capacityRoundingGiB:
+		return roundUpToGiB(capacity)
+	case capacityRoundingVolblocksize:
+		if blockSize > 0 {
+			return (capacity + blockSize - 1) / blockSize * blockSize
+		}
+	}
+	return capacity
+}
+
+// zvolBlockSize returns the volblocksize a CreateVolume request provisions its ZVOL with,
+// or 0 for filesystem protocols.
+func (s *ControllerService) zvolBlockSize(params map[string]string, protocol string) int64 {
+	if !isBlockProtocol(protocol) {
+		return 0
+	}
+	if merged, err := s.zfsParameters(params, true); err == nil {
+		params = merged
+	}
+	if size, err := parseZFSBlockSize(strings.ToLower(strings.TrimSpace(params["zfs.volblocksize"]))); err == nil && size > 0 {
+		return size
+	}
+	return defaultZvolBlockSize
+}
+
+// applyCapacityPolicy returns req with the default size and capacity rounding applied to its
+// capacity range. req is returned unchanged if its capacity needs no adjustment.
+func (s *ControllerService) applyCapacityPolicy(req *csi.CreateVolumeRequest, protocol string) (*csi.CreateVolumeRequest, error) {
+	params := req.GetParameters()
+
+	defaultSize := s.defaultVolumeSize
+	if value := params[DefaultSizeParam]; value != "" {
+		size, err := ParseDefaultVolumeSize(value)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %v", DefaultSizeParam, err)
+		}
+		defaultSize = size
+	}
+	if defaultSize <= 0 {
+		defaultSize = MinVolumeSize
+	}
+
+	rounding := s.capacityRounding
+	if value := params[CapacityRoundingParam]; value != "" {
+		mode, err := ParseCapacityRounding(value)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %v", CapacityRoundingParam, err)
+		}
+		rounding = mode
+	}
+	if _, err := parseAdoptExistingWithDifferentSize(params[AdoptExistingWithDifferentSizeParam]); err != nil {
+		return nil, err
+	}
+
+	required := req.GetCapacityRange().GetRequiredBytes()
+	limit := req.GetCapacityRange().GetLimitBytes()
+	capacity := required
+	if capacity == 0 {
+		capacity = defaultSize
+	}
+	rounded := roundCapacity(capacity, rounding, s.zvolBlockSize(params, protocol))
+	if limit > 0 && rounded > limit {
+		// Rounding must not exceed the limit; the default size gives way to it as well
+		rounded = min(capacity, limit)
+	}
+	if rounded == required {
+		return req, nil
+	}
+
+	klog.V(4).Infof("Provisioning volume %s with %d bytes (requested %d, default %d, rounding %s)",
+		req.GetName(), rounded, required, defaultSize, rounding)
+	adjusted, ok := proto.Clone(req).(*csi.CreateVolumeRequest)
+	if !ok {
+		return req, nil
+	}
+	adjusted.CapacityRange = &csi.CapacityRange{RequiredBytes: rounded, LimitBytes: limit}
+	return adjusted, nil
+}
+
+// sizePolicy holds the size limits of a StorageClass; 0 = no limit.
+type sizePolicy struct {
+	minBytes int64
+	maxBytes int64
+}
+
+// parseSizePolicy parses the minSize/maxSize parameters. Returns nil if neither is set.
+func parseSizePolicy(params map[string]string) (*sizePolicy, error) {
+	if params[MinSizeParam] == "" && params[MaxSizeParam] == "" {
+		return nil, nil //nolint:nilnil // nil policy means no size limits
+	}
+	policy := &sizePolicy{}
+	for _, p := range []struct {
+		name string
+		dst  *int64
+	}{{MinSizeParam, &policy.minBytes}, {MaxSizeParam, &policy.maxBytes}} {
+		value := params[p.name]
+		if value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil || q.Value() <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be a size such as 10Gi", p.name, value)
+		}
+		*p.dst = q.Value()
+	}
+	if policy.maxBytes > 0 && policy.minBytes > policy.maxBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "%s %s is larger than %s %s",
+			MinSizeParam, params[MinSizeParam], MaxSizeParam, params[MaxSizeParam])
+	}
+	return policy, nil
+}
+
+// check rejects a capacity outside the policy's limits.
+func (p *sizePolicy) check(capacity int64) error {
+	if p == nil {
+		return nil
+	}
+	if p.minBytes > 0 && capacity < p.minBytes {
+		return status.Errorf(codes.InvalidArgument, "requested capacity %s is below the StorageClass %s of %s",
+			formatUsageBytes(capacity), MinSizeParam, formatUsageBytes(p.minBytes))
+	}
+	if p.maxBytes > 0 && capacity > p.maxBytes {
+		return status.Errorf(codes.InvalidArgument, "requested capacity %s exceeds the StorageClass %s of %s",
+			formatUsageBytes(capacity), MaxSizeParam, formatUsageBytes(p.maxBytes))
+	}
+	return nil
+}
+
+// validateSizeParams validates the minSize/maxSize parameters against a CreateVolume request
+// whose capacity policy has been applied.
+func validateSizeParams(req *csi.CreateVolumeRequest) (*sizePolicy, error) {
+	policy, err := parseSizePolicy(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+	if err := policy.check(req.GetCapacityRange().GetRequiredBytes()); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// recordSizePolicy stores the maxSize and capacityRounding parameters on a newly provisioned
+// volume's dataset.
+func (s *ControllerService) recordSizePolicy(ctx context.Context, datasetID string, params map[string]string) error {
+	props := make(map[string]string)
+	if policy, _ := parseSizePolicy(params); policy != nil && policy.maxBytes > 0 {
+		props[tnsapi.PropertyMaxSize] = strconv.FormatInt(policy.maxBytes, 10)
+	}
+	if params[CapacityRoundingParam] != "" {
+		mode, _ := ParseCapacityRounding(params[CapacityRoundingParam])
+		props[tnsapi.PropertyCapacityRounding] = mode
+	}
+	if len(props) == 0 {
+		return nil
+	}
+	if err := s.apiClient.SetDatasetProperties(ctx, datasetID, props); err != nil {
+		return status.Errorf(codes.Internal, "Failed to record size policy on %s: %v", datasetID, err)
+	}
+	return nil
+}
+
+// expansionCapacity returns the capacity to expand a volume to: requiredBytes rounded like the
+// volume was at creation, within the maxSize recorded on the dataset.
+func (s *ControllerService) expansionCapacity(ctx context.Context, datasetID string, capacityRange *csi.CapacityRange) (int64, error) {
+	required := capacityRange.GetRequiredBytes()
+	dataset, err := s.apiClient.GetDatasetWithProperties(ctx, datasetID)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "Failed to read size policy of %s: %v", datasetID, err)
+	}
+	if dataset == nil {
+		return required, nil // Reported as NotFound by the expansion itself
+	}
+
+	rounding := s.capacityRounding
+	if mode := dataset.UserProperties[tnsapi.PropertyCapacityRounding].Value; mode != "" {
+		rounding = mode
+	}
+	var blockSize int64
+	if dataset.Type == datasetTypeVolume {
+		blockSize = parsedPropertyInt(dataset.Volblocksize)
+		if blockSize == 0 {
+			blockSize = defaultZvolBlockSize
+		}
+	}
+	capacity := roundCapacity(required, rounding, blockSize)
+	if limit := capacityRange.GetLimitBytes(); limit > 0 && capacity > limit {
+		capacity = required
+	}
+
+	maxBytes := tnsapi.StringToInt64(dataset.UserProperties[tnsapi.PropertyMaxSize].Value)
+	if maxBytes > 0 {
+		klog.V(4).Infof("Checking expansion of %s to %d bytes against %s %d", datasetID, capacity, MaxSizeParam, maxBytes)
+		if err := (&sizePolicy{maxBytes: maxBytes}).check(capacity); err != nil {
+			return 0, err
+		}
+	}
+	return capacity, nil
+}
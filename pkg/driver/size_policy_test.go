@@ -0,0 +1,135 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateSizeParams(t *testing.T) {
+	tests := []struct {
+		params   map[string]string
+		name     string
+		required int64
+		wantCode codes.Code
+	}{
+		{name: "no limits", params: map[string]string{}, required: 100 * testGiB},
+		{name: "within range", params: map[string]string{MinSizeParam: "5Gi", MaxSizeParam: "50Gi"}, required: 10 * testGiB},
+		{name: "at maximum", params: map[string]string{MaxSizeParam: "50Gi"}, required: 50 * testGiB},
+		{name: "below minimum", params: map[string]string{MinSizeParam: "5Gi"}, required: 2 * testGiB, wantCode: codes.InvalidArgument},
+		{name: "above maximum", params: map[string]string{MaxSizeParam: "50Gi"}, required: 51 * testGiB, wantCode: codes.InvalidArgument},
+		{name: "default size below minimum", params: map[string]string{MinSizeParam: "5Gi"}, wantCode: codes.InvalidArgument},
+		{name: "invalid size", params: map[string]string{MaxSizeParam: "huge"}, required: testGiB, wantCode: codes.InvalidArgument},
+		{name: "minimum above maximum", params: map[string]string{MinSizeParam: "10Gi", MaxSizeParam: "5Gi"}, required: 8 * testGiB, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &csi.CreateVolumeRequest{
+				Name:          "pvc-1",
+				Parameters:    tt.params,
+				CapacityRange: &csi.CapacityRange{RequiredBytes: tt.required},
+			}
+			s := &ControllerService{}
+			req, err := s.applyCapacityPolicy(req, ProtocolNFS)
+			if err == nil {
+				_, err = validateSizeParams(req)
+			}
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("validateSizeParams() code = %s, want %s (error: %v)", status.Code(err), tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestApplyCapacityPolicy(t *testing.T) {
+	const mib = int64(1 << 20)
+
+	//nolint:govet // fieldalignment not critical for test code
+	tests := []struct {
+		name            string
+		defaultSize     int64
+		rounding        string
+		protocol        string
+		params          map[string]string
+		required, limit int64
+		want            int64
+		wantCode        codes.Code
+	}{
+		{name: "built-in default", protocol: ProtocolNFS, want: testGiB},
+		{name: "flag default", defaultSize: 5 * testGiB, protocol: ProtocolNFS, want: 5 * testGiB},
+		{name: "StorageClass default wins", defaultSize: 5 * testGiB, protocol: ProtocolNFS,
+			params: map[string]string{DefaultSizeParam: "10Gi"}, want: 10 * testGiB},
+		{name: "no rounding", protocol: ProtocolNFS, required: testGiB + mib, want: testGiB + mib},
+		{name: "round to GiB", rounding: capacityRoundingGiB, protocol: ProtocolNFS, required: testGiB + mib, want: 2 * testGiB},
+		{name: "StorageClass rounding wins", rounding: capacityRoundingNone, protocol: ProtocolNFS,
+			params: map[string]string{CapacityRoundingParam: "GiB"}, required: testGiB + mib, want: 2 * testGiB},
+		{name: "volblocksize from profile", rounding: capacityRoundingVolblocksize, protocol: ProtocolNVMeOF,
+			params: map[string]string{WorkloadProfileParam: "vm"}, required: testGiB + 1, want: testGiB + 64*1024},
+		{name: "volblocksize from zfs parameter", rounding: capacityRoundingVolblocksize, protocol: ProtocolISCSI,
+			params: map[string]string{"zfs.volblocksize": "128K"}, required: testGiB + 1, want: testGiB + 128*1024},
+		{name: "volblocksize ignored for datasets", rounding: capacityRoundingVolblocksize, protocol: ProtocolSMB,
+			required: testGiB + 1, want: testGiB + 1},
+		{name: "rounding stays within limit", rounding: capacityRoundingGiB, protocol: ProtocolNFS,
+			required: testGiB + mib, limit: testGiB + 2*mib, want: testGiB + mib},
+		{name: "invalid StorageClass default", protocol: ProtocolNFS,
+			params: map[string]string{DefaultSizeParam: "100Mi"}, wantCode: codes.InvalidArgument},
+		{name: "invalid StorageClass rounding", protocol: ProtocolNFS,
+			params: map[string]string{CapacityRoundingParam: "tib"}, wantCode: codes.InvalidArgument},
+		{name: "invalid adoptExistingWithDifferentSize", protocol: ProtocolNFS,
+			params: map[string]string{AdoptExistingWithDifferentSizeParam: "shrink"}, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &ControllerService{defaultVolumeSize: tt.defaultSize, capacityRounding: tt.rounding}
+			req := &csi.CreateVolumeRequest{
+				Name:          "pvc-1",
+				Parameters:    tt.params,
+				CapacityRange: &csi.CapacityRange{RequiredBytes: tt.required, LimitBytes: tt.limit},
+			}
+			got, err := s.applyCapacityPolicy(req, tt.protocol)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("applyCapacityPolicy() code = %s, want %s (error: %v)", status.Code(err), tt.wantCode, err)
+			}
+			if err != nil {
+				return
+			}
+			if capacity := got.GetCapacityRange().GetRequiredBytes(); capacity != tt.want {
+				t.Errorf("capacity = %d, want %d", capacity, tt.want)
+			}
+			if req.GetCapacityRange().GetRequiredBytes() != tt.required {
+				t.Error("original request was modified")
+			}
+		})
+	}
+}
+
+func TestExpansionCapacity(t *testing.T) {
+	mockClient := &MockAPIClientForSnapshots{
+		GetDatasetWithPropertiesFunc: func(ctx context.Context, datasetID string) (*tnsapi.DatasetWithProperties, error) {
+			props := map[string]tnsapi.UserProperty{}
+			if datasetID == "tank/pvc-gib" {
+				props[tnsapi.PropertyCapacityRounding] = tnsapi.UserProperty{Value: capacityRoundingGiB}
+			}
+			return &tnsapi.DatasetWithProperties{
+				Dataset: tnsapi.Dataset{ID: datasetID, Type: datasetTypeVolume,
+					Volblocksize: map[string]interface{}{"parsed": float64(64 * 1024)}},
+				UserProperties: props,
+			}, nil
+		},
+	}
+	s := NewControllerService(mockClient, NewNodeRegistry(), "")
+	s.capacityRounding = capacityRoundingVolblocksize
+
+	got, err := s.expansionCapacity(context.Background(), "tank/pvc-block", &csi.CapacityRange{RequiredBytes: 2*testGiB + 1})
+	if err != nil || got != 2*testGiB+64*1024 {
+		t.Errorf("volblocksize rounding: got (%d, %v), want %d", got, err, 2*testGiB+64*1024)
+	}
+	got, err = s.expansionCapacity(context.Background(), "tank/pvc-gib", &csi.CapacityRange{RequiredBytes: 2*testGiB + 1})
+	if err != nil || got != 3*testGiB {
+		t.Errorf("recorded GiB rounding: got (%d, %v), want %d", got, err, 3*testGiB)
+	}
+}
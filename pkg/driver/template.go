@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strings"
 	"text/template"
+	"time"
 
 	"k8s.io/klog/v2"
 )
@@ -28,6 +29,9 @@ const (
 	// ParamNameTemplate is the StorageClass parameter for full name template.
 	// Example: "{{ .PVCNamespace }}-{{ .PVCName }}".
 	ParamNameTemplate = "nameTemplate"
+	// ParamVolumeNameTemplate is an alias of ParamNameTemplate.
+	// Example: "{{ .PVCNamespace }}-{{ .PVCName }}".
+	ParamVolumeNameTemplate = "volumeNameTemplate"
 	// ParamNamePrefix is the StorageClass parameter for simple prefix.
 	// Example: "prod-".
 	ParamNamePrefix = "namePrefix"
@@ -51,10 +55,24 @@ type VolumeNameContext struct {
 	PVName string
 }
 
+// CommentContext holds the context variables available for comment templates: the name
+// templating variables plus details that trace a dataset or share back to its origin.
+type CommentContext struct {
+	VolumeNameContext
+	// ClusterID is the cluster identifier of the driver (empty if --cluster-id is not set).
+	ClusterID string
+	// DriverVersion is the version of the driver that created the volume.
+	DriverVersion string
+	// CreationTime is when the volume was created, in RFC 3339 format (UTC).
+	CreationTime string
+}
+
 // nameTemplateConfig holds parsed template configuration from StorageClass parameters.
 type nameTemplateConfig struct {
 	// template is the parsed Go template (nil if no template specified)
 	template *template.Template
+	// templateStr is the raw template text (used to detect tokens that need PVC metadata)
+	templateStr string
 	// prefix is a simple prefix to prepend (used if no template)
 	prefix string
 	// suffix is a simple suffix to append (used if no template)
@@ -67,8 +85,17 @@ var (
 	ErrVolumeNameEmpty = errors.New("volume name cannot be empty")
 	// ErrVolumeNameInvalid is returned when the volume name contains invalid characters.
 	ErrVolumeNameInvalid = errors.New("invalid volume name: must start with alphanumeric and contain only alphanumeric, hyphen, underscore, colon, or period")
+	// ErrConflictingNameTemplates is returned when nameTemplate and volumeNameTemplate disagree.
+	ErrConflictingNameTemplates = errors.New("nameTemplate and volumeNameTemplate are both set with different values")
+	// ErrTemplateMetadataMissing is returned when a template uses PVC tokens but the provisioner did not pass PVC metadata.
+	ErrTemplateMetadataMissing = errors.New("name template uses PVC metadata that was not provided (run csi-provisioner with --extra-create-metadata)")
+	// ErrDatasetNameTooLong is returned when the full dataset path exceeds the ZFS name length limit.
+	ErrDatasetNameTooLong = errors.New("dataset name exceeds the ZFS limit")
 )
 
+// maxZFSDatasetNameLength is the longest full dataset path ZFS accepts (ZFS_MAX_DATASET_NAME_LEN minus the NUL).
+const maxZFSDatasetNameLength = 255
+
 // validNameRegex matches valid ZFS dataset/zvol names.
 // ZFS names can contain alphanumeric characters, hyphens, underscores, colons, and periods.
 // They cannot start with a hyphen.
@@ -80,6 +107,12 @@ var validNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._:-]*$`)
 //nolint:nilnil // nil, nil is the expected return when no templating is configured
 func parseNameTemplateConfig(params map[string]string) (*nameTemplateConfig, error) {
 	templateStr := params[ParamNameTemplate]
+	if alias := params[ParamVolumeNameTemplate]; alias != "" {
+		if templateStr != "" && templateStr != alias {
+			return nil, ErrConflictingNameTemplates
+		}
+		templateStr = alias
+	}
 	prefix := params[ParamNamePrefix]
 	suffix := params[ParamNameSuffix]
 
@@ -100,6 +133,7 @@ func parseNameTemplateConfig(params map[string]string) (*nameTemplateConfig, err
 			return nil, fmt.Errorf("invalid nameTemplate '%s': %w", templateStr, err)
 		}
 		config.template = tmpl
+		config.templateStr = templateStr
 		klog.V(4).Infof("Parsed name template: %s", templateStr)
 	}
 
@@ -133,6 +167,11 @@ func renderVolumeName(config *nameTemplateConfig, ctx VolumeNameContext) (string
 	}
 
 	if config.template != nil {
+		// Without PVC metadata every PVC would render the same name from a PVC-based template
+		if strings.Contains(config.templateStr, ".PVC") && ctx.PVCName == "" && ctx.PVCNamespace == "" {
+			return "", ErrTemplateMetadataMissing
+		}
+
 		// Use full template
 		var buf bytes.Buffer
 		if err := config.template.Execute(&buf, ctx); err != nil {
@@ -229,6 +268,14 @@ func validateVolumeName(name string) error {
 	return nil
 }
 
+// validateDatasetNameLength checks that a full dataset path fits the ZFS name length limit.
+func validateDatasetNameLength(datasetName string) error {
+	if len(datasetName) > maxZFSDatasetNameLength {
+		return fmt.Errorf("%w: %q is %d characters (max %d)", ErrDatasetNameTooLong, datasetName, len(datasetName), maxZFSDatasetNameLength)
+	}
+	return nil
+}
+
 // ResolveVolumeName is the main entry point for volume name resolution.
 // It extracts templating configuration from StorageClass parameters,
 // builds the template context, and renders the final volume name.
@@ -254,21 +301,42 @@ func ResolveVolumeName(params map[string]string, pvName string) (string, error)
 	return renderVolumeName(config, ctx)
 }
 
+// ParseCommentTemplate parses a comment template such as the --comment-template default.
+// Returns nil for an empty template.
+func ParseCommentTemplate(value string) (*template.Template, error) {
+	if value == "" {
+		return nil, nil //nolint:nilnil // nil template means no comment is configured
+	}
+	tmpl, err := template.New("comment").Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commentTemplate '%s': %w", value, err)
+	}
+	return tmpl, nil
+}
+
 // ResolveComment resolves a dataset comment from a commentTemplate StorageClass parameter.
 // Returns "" if no commentTemplate is configured.
 // Unlike volume names, comments are free-form text and are not sanitized or validated.
 func ResolveComment(params map[string]string, pvName string) (string, error) {
-	templateStr := params[ParamCommentTemplate]
-	if templateStr == "" {
-		return "", nil
-	}
+	return resolveCommentTemplate(params, nil, CommentContext{
+		VolumeNameContext: extractVolumeNameContext(params, pvName),
+		CreationTime:      time.Now().UTC().Format(time.RFC3339),
+	})
+}
 
-	tmpl, err := template.New("comment").Parse(templateStr)
+// resolveCommentTemplate renders the commentTemplate StorageClass parameter, or defaultTmpl
+// if the StorageClass sets none. Returns "" if neither is set.
+func resolveCommentTemplate(params map[string]string, defaultTmpl *template.Template, ctx CommentContext) (string, error) {
+	tmpl, err := ParseCommentTemplate(params[ParamCommentTemplate])
 	if err != nil {
-		return "", fmt.Errorf("invalid commentTemplate '%s': %w", templateStr, err)
+		return "", err
+	}
+	if tmpl == nil {
+		tmpl = defaultTmpl
+	}
+	if tmpl == nil {
+		return "", nil
 	}
-
-	ctx := extractVolumeNameContext(params, pvName)
 
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, ctx); err != nil {
@@ -277,3 +345,14 @@ func ResolveComment(params map[string]string, pvName string) (string, error) {
 
 	return buf.String(), nil
 }
+
+// resolveComment resolves the dataset comment of a new volume from the commentTemplate
+// StorageClass parameter or the driver's --comment-template default.
+func (s *ControllerService) resolveComment(params map[string]string, pvName string) (string, error) {
+	return resolveCommentTemplate(params, s.commentTemplate, CommentContext{
+		VolumeNameContext: extractVolumeNameContext(params, pvName),
+		ClusterID:         s.clusterID,
+		DriverVersion:     s.driverVersion,
+		CreationTime:      time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -1,7 +1,16 @@
 package driver
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestParseNameTemplateConfig(t *testing.T) {
@@ -63,6 +72,30 @@ func TestParseNameTemplateConfig(t *testing.T) {
 			},
 			wantNil: false,
 		},
+		{
+			name: "volumeNameTemplate alias",
+			params: map[string]string{
+				ParamVolumeNameTemplate: "{{ .PVCNamespace }}-{{ .PVCName }}",
+			},
+			wantNil: false,
+		},
+		{
+			name: "nameTemplate and volumeNameTemplate agree",
+			params: map[string]string{
+				ParamNameTemplate:       "{{ .PVCName }}",
+				ParamVolumeNameTemplate: "{{ .PVCName }}",
+			},
+			wantNil: false,
+		},
+		{
+			name: "nameTemplate and volumeNameTemplate conflict",
+			params: map[string]string{
+				ParamNameTemplate:       "{{ .PVCName }}",
+				ParamVolumeNameTemplate: "{{ .PVName }}",
+			},
+			wantErr:     true,
+			errContains: "both set",
+		},
 	}
 
 	for _, tt := range tests {
@@ -470,6 +503,24 @@ func TestResolveVolumeName(t *testing.T) {
 			pvName: "pvc-abc123",
 			want:   "cache-redis-master-0",
 		},
+		{
+			name: "volumeNameTemplate alias",
+			params: map[string]string{
+				ParamVolumeNameTemplate: "{{ .PVCNamespace }}-{{ .PVCName }}",
+				CSIPVCName:              "data",
+				CSIPVCNamespace:         "app",
+			},
+			pvName: "pvc-abc123",
+			want:   "app-data",
+		},
+		{
+			name: "template needs PVC metadata that is missing",
+			params: map[string]string{
+				ParamVolumeNameTemplate: "{{ .PVCNamespace }}-{{ .PVCName }}",
+			},
+			pvName:  "pvc-abc123",
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -486,6 +537,100 @@ func TestResolveVolumeName(t *testing.T) {
 	}
 }
 
+func TestValidateDatasetNameLength(t *testing.T) {
+	if err := validateDatasetNameLength("tank/k8s/" + strings.Repeat("a", 63)); err != nil {
+		t.Errorf("validateDatasetNameLength() unexpected error: %v", err)
+	}
+	err := validateDatasetNameLength("tank/" + strings.Repeat("a/", 130))
+	if !errors.Is(err, ErrDatasetNameTooLong) {
+		t.Errorf("validateDatasetNameLength() error = %v, want %v", err, ErrDatasetNameTooLong)
+	}
+}
+
+func TestCheckVolumeNameCollision(t *testing.T) {
+	templateParams := map[string]string{
+		"parentDataset":         "tank/k8s",
+		ParamVolumeNameTemplate: "{{ .PVCName }}",
+		CSIPVCName:              "data",
+		CSIPVCNamespace:         "app",
+	}
+
+	tests := []struct {
+		existing *tnsapi.DatasetWithProperties
+		params   map[string]string
+		name     string
+		wantCode codes.Code
+	}{
+		{
+			name:     "no template skips lookup",
+			params:   map[string]string{"parentDataset": "tank/k8s"},
+			existing: &tnsapi.DatasetWithProperties{},
+			wantCode: codes.OK,
+		},
+		{
+			name:     "templated name is free",
+			params:   templateParams,
+			wantCode: codes.OK,
+		},
+		{
+			name:   "existing dataset belongs to the same PVC",
+			params: templateParams,
+			existing: &tnsapi.DatasetWithProperties{UserProperties: map[string]tnsapi.UserProperty{
+				tnsapi.PropertyManagedBy:    {Value: tnsapi.ManagedByValue},
+				tnsapi.PropertyPVCName:      {Value: "data"},
+				tnsapi.PropertyPVCNamespace: {Value: "app"},
+			}},
+			wantCode: codes.OK,
+		},
+		{
+			name:   "existing dataset belongs to another PVC",
+			params: templateParams,
+			existing: &tnsapi.DatasetWithProperties{UserProperties: map[string]tnsapi.UserProperty{
+				tnsapi.PropertyManagedBy:    {Value: tnsapi.ManagedByValue},
+				tnsapi.PropertyPVCName:      {Value: "data"},
+				tnsapi.PropertyPVCNamespace: {Value: "other"},
+			}},
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name:     "existing dataset is not managed by tns-csi",
+			params:   templateParams,
+			existing: &tnsapi.DatasetWithProperties{},
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name: "rendered dataset path too long",
+			params: map[string]string{
+				"parentDataset":         "tank/" + strings.Repeat("deep/", 50),
+				ParamVolumeNameTemplate: "{{ .PVName }}-volume",
+			},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookedUp := false
+			mockClient := &MockAPIClientForSnapshots{
+				GetDatasetWithPropertiesFunc: func(_ context.Context, _ string) (*tnsapi.DatasetWithProperties, error) {
+					lookedUp = true
+					return tt.existing, nil
+				},
+			}
+			controller := NewControllerService(mockClient, NewNodeRegistry(), "")
+			req := &csi.CreateVolumeRequest{Name: "pvc-12345", Parameters: tt.params}
+
+			err := controller.checkVolumeNameCollision(context.Background(), req, tt.params)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("checkVolumeNameCollision() code = %v, want %v (err: %v)", got, tt.wantCode, err)
+			}
+			if tt.params[ParamVolumeNameTemplate] == "" && lookedUp {
+				t.Error("checkVolumeNameCollision() looked up dataset without a name template")
+			}
+		})
+	}
+}
+
 func TestResolveComment(t *testing.T) {
 	tests := []struct {
 		params      map[string]string
@@ -572,6 +717,40 @@ func TestResolveComment(t *testing.T) {
 	}
 }
 
+func TestControllerResolveComment(t *testing.T) {
+	defaultTmpl, err := ParseCommentTemplate("{{ .ClusterID }} {{ .PVCNamespace }}/{{ .PVCName }} {{ .DriverVersion }}")
+	if err != nil {
+		t.Fatalf("ParseCommentTemplate() error = %v", err)
+	}
+	s := &ControllerService{clusterID: "prod", driverVersion: "v1.2.3", commentTemplate: defaultTmpl}
+	params := map[string]string{CSIPVCName: "data", CSIPVCNamespace: "db"}
+
+	got, err := s.resolveComment(params, "pvc-1")
+	if err != nil || got != "prod db/data v1.2.3" {
+		t.Errorf("resolveComment() with driver default = %q, %v", got, err)
+	}
+
+	params[ParamCommentTemplate] = "{{ .PVName }} created {{ .CreationTime }}"
+	got, err = s.resolveComment(params, "pvc-1")
+	if err != nil {
+		t.Fatalf("resolveComment() error = %v", err)
+	}
+	created, ok := strings.CutPrefix(got, "pvc-1 created ")
+	if !ok {
+		t.Fatalf("resolveComment() = %q, StorageClass template not used", got)
+	}
+	if _, err := time.Parse(time.RFC3339, created); err != nil {
+		t.Errorf("CreationTime %q is not RFC 3339: %v", created, err)
+	}
+
+	if got, err := (&ControllerService{}).resolveComment(map[string]string{}, "pvc-1"); got != "" || err != nil {
+		t.Errorf("resolveComment() without templates = %q, %v", got, err)
+	}
+	if _, err := ParseCommentTemplate("{{ .Invalid"); err == nil {
+		t.Error("ParseCommentTemplate() accepted an invalid template")
+	}
+}
+
 // stringContains is a helper function for string contains check in tests.
 func stringContains(s, substr string) bool {
 	for i := 0; i <= len(s)-len(substr); i++ {
@@ -0,0 +1,113 @@
+package driver
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/klog/v2"
+)
+
+// Per-namespace tenant datasets.
+//
+// --tenant-dataset-map-file names a file, usually a mounted ConfigMap, with one
+// "<namespace> <parentDataset>" pair per line. CreateVolume looks up the PVC namespace
+// (csi.storage.k8s.io/pvc/namespace, set by the provisioner's --extra-create-metadata) and
+// provisions volumes of a listed namespace below its dataset instead of the StorageClass's
+// pool, parentDataset or pools, so each tenant's volumes share one ZFS hierarchy whose quota
+// the administrator sets independently. A bare pool name places them directly on that pool.
+// Namespaces that are not listed use the StorageClass as before. Tenant datasets must exist;
+// they are not created by the driver. Kubelet refreshes mounted ConfigMaps, so edits apply
+// to new volumes without restarting the driver.
+
+// tenantDatasetMap resolves PVC namespaces to parent datasets through a mapping file. A nil
+// map resolves no namespace.
+type tenantDatasetMap struct {
+	path string
+}
+
+// newTenantDatasetMap returns a tenant map reading path, or nil if path is empty.
+func newTenantDatasetMap(path string) *tenantDatasetMap {
+	if path == "" {
+		return nil
+	}
+	return &tenantDatasetMap{path: path}
+}
+
+// resolve returns the parent dataset of namespace, or "" if it has none. The file is read
+// on every call.
+func (m *tenantDatasetMap) resolve(namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+	return m.entries()[namespace]
+}
+
+// entries returns the parent dataset of every listed namespace. The first line of a namespace
+// wins.
+func (m *tenantDatasetMap) entries() map[string]string {
+	if m == nil {
+		return nil
+	}
+	f, err := os.Open(m.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("Failed to read tenant dataset map %s: %v", m.path, err)
+		}
+		return nil
+	}
+	defer func() { _ = f.Close() }()
+
+	datasets := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if _, seen := datasets[fields[0]]; !seen {
+			datasets[fields[0]] = strings.Trim(fields[1], "/")
+		}
+	}
+	return datasets
+}
+
+// applyTenantDataset returns req with the pool and parentDataset parameters set to the
+// dataset of the PVC namespace. req is returned unchanged if the namespace is not mapped
+// or the StorageClass provisions directory volumes, which live in a shared dataset.
+func (s *ControllerService) applyTenantDataset(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeRequest, error) {
+	params := req.GetParameters()
+	namespace := params[CSIPVCNamespace]
+	dataset := s.tenantDatasets.resolve(namespace)
+	if dataset == "" || params[VolumeTypeParam] != "" {
+		return req, nil
+	}
+
+	if parent, err := s.apiClient.Dataset(ctx, dataset); err != nil || parent == nil {
+		if err != nil && !isNotFoundError(err) {
+			return nil, status.Errorf(codes.Unavailable, "failed to look up tenant dataset %s of namespace %s: %v", dataset, namespace, err)
+		}
+		return nil, status.Errorf(codes.FailedPrecondition, "tenant dataset %s of namespace %s does not exist", dataset, namespace)
+	}
+	klog.Infof("Placing volume %s of namespace %s below tenant dataset %s", req.GetName(), namespace, dataset)
+
+	placed, ok := proto.Clone(req).(*csi.CreateVolumeRequest)
+	if !ok {
+		return nil, status.Error(codes.Internal, "failed to copy CreateVolume request")
+	}
+	pool, _, nested := strings.Cut(dataset, "/")
+	placed.Parameters["pool"] = pool
+	delete(placed.Parameters, "parentDataset")
+	if nested {
+		placed.Parameters["parentDataset"] = dataset
+	}
+	delete(placed.Parameters, PoolsParam)
+	delete(placed.Parameters, PoolPlacementParam)
+	return placed, nil
+}
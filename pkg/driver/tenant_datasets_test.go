@@ -0,0 +1,72 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestApplyTenantDataset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants")
+	mapping := "# <namespace> <parentDataset>\nteam-a tank/tenants/team-a/\nteam-b  fast # whole pool\nteam-c tank/tenants/team-c\nbroken\n"
+	if err := os.WriteFile(path, []byte(mapping), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := &ControllerService{
+		tenantDatasets: newTenantDatasetMap(path),
+		apiClient: &mockAPIClient{datasetFunc: func(_ context.Context, datasetID string) (*tnsapi.Dataset, error) {
+			if datasetID == "tank/tenants/team-c" {
+				return nil, nil
+			}
+			return &tnsapi.Dataset{ID: datasetID}, nil
+		}},
+	}
+	newReq := func(namespace string, params map[string]string) *csi.CreateVolumeRequest {
+		params[CSIPVCNamespace] = namespace
+		return &csi.CreateVolumeRequest{Name: "pvc-1", Parameters: params}
+	}
+
+	tests := []struct {
+		req        *csi.CreateVolumeRequest
+		name       string
+		wantPool   string
+		wantParent string
+		wantCode   codes.Code
+	}{
+		{name: "nested dataset", req: newReq("team-a", map[string]string{"pool": "tank", "parentDataset": "tank/k8s"}), wantPool: "tank", wantParent: "tank/tenants/team-a"},
+		{name: "pool", req: newReq("team-b", map[string]string{"parentDataset": "tank/k8s"}), wantPool: "fast"},
+		{name: "replaces pools", req: newReq("team-a", map[string]string{PoolsParam: "tank,fast", PoolPlacementParam: PoolPlacementRoundRobin}), wantPool: "tank", wantParent: "tank/tenants/team-a"},
+		{name: "unmapped", req: newReq("other", map[string]string{"pool": "tank", "parentDataset": "tank/k8s"}), wantPool: "tank", wantParent: "tank/k8s"},
+		{name: "directory volume", req: newReq("team-a", map[string]string{"parentDataset": "tank/shared", VolumeTypeParam: VolumeTypeSubdir}), wantParent: "tank/shared"},
+		{name: "missing dataset", req: newReq("team-c", map[string]string{"pool": "tank"}), wantCode: codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			placed, err := s.applyTenantDataset(context.Background(), tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("applyTenantDataset() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			params := placed.GetParameters()
+			if params["pool"] != tt.wantPool || params["parentDataset"] != tt.wantParent {
+				t.Errorf("pool = %q, parentDataset = %q; want %q, %q", params["pool"], params["parentDataset"], tt.wantPool, tt.wantParent)
+			}
+			if placed != tt.req && (params[PoolsParam] != "" || params[PoolPlacementParam] != "") {
+				t.Errorf("pools parameters kept: %v", params)
+			}
+		})
+	}
+
+	var none *tenantDatasetMap
+	if got := none.resolve("team-a"); got != "" {
+		t.Errorf("nil map resolved team-a to %q", got)
+	}
+}
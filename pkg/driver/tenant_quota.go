@@ -0,0 +1,152 @@
+package driver
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// Tenant quota sync.
+//
+// With tenant datasets (tenant_datasets.go) and the informer caches (--kube-informers), the
+// controller keeps the ZFS quota of each tenant dataset equal to the storage its namespace may
+// request through this driver's StorageClasses, so ZFS and Kubernetes accounting agree:
+//
+//   - when every StorageClass of the driver has a "<class>.storageclass.storage.k8s.io/requests.storage"
+//     limit in the namespace's ResourceQuotas, their sum, capped by a "requests.storage" limit
+//   - otherwise the "requests.storage" limit alone
+//   - of several ResourceQuotas limiting the same resource, the smallest one
+//
+// Namespaces without such a limit, or with a limit of 0 (ZFS has no zero quota), leave the
+// dataset's quota alone. The quota the driver set is recorded in tns-csi:tenant_quota; it is
+// only removed when the limit disappears while the dataset still has that quota, so quotas set
+// by an administrator stay.
+
+// DefaultTenantQuotaSyncInterval is how often tenant dataset quotas are compared with ResourceQuotas.
+const DefaultTenantQuotaSyncInterval = time.Minute
+
+// storageClassRequestsStorage returns the ResourceQuota resource limiting storage of a StorageClass.
+func storageClassRequestsStorage(class string) corev1.ResourceName {
+	return corev1.ResourceName(class + ".storageclass.storage.k8s.io/" + string(corev1.ResourceRequestsStorage))
+}
+
+// tenantStorageLimit returns the storage a namespace with quotas may request through classes,
+// or false if it is not limited.
+func tenantStorageLimit(quotas []*corev1.ResourceQuota, classes []string) (int64, bool) {
+	smallest := func(resource corev1.ResourceName) (int64, bool) {
+		limit, found := int64(0), false
+		for _, quota := range quotas {
+			if hard, ok := quota.Spec.Hard[resource]; ok && (!found || hard.Value() < limit) {
+				limit, found = hard.Value(), true
+			}
+		}
+		return limit, found
+	}
+
+	total, limited := smallest(corev1.ResourceRequestsStorage)
+	if len(classes) == 0 {
+		return total, limited
+	}
+	var sum int64
+	for _, class := range classes {
+		limit, found := smallest(storageClassRequestsStorage(class))
+		if !found {
+			return total, limited
+		}
+		sum += limit
+	}
+	if limited && total < sum {
+		return total, true
+	}
+	return sum, true
+}
+
+// runTenantQuotaSync keeps tenant dataset quotas in sync with ResourceQuotas until stopCh is closed.
+func (s *ControllerService) runTenantQuotaSync(interval time.Duration, stopCh <-chan struct{}) {
+	klog.Infof("Tenant quota sync enabled: comparing tenant dataset quotas with ResourceQuotas every %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		s.syncTenantQuotas(ctx)
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// syncTenantQuotas updates the quota of every tenant dataset whose namespace limit changed.
+func (s *ControllerService) syncTenantQuotas(ctx context.Context) {
+	classes, known := s.kubeView.storageClasses()
+	if !known {
+		klog.V(4).Info("Tenant quota sync skipped: StorageClass cache not available")
+		return
+	}
+	for namespace, dataset := range s.tenantDatasets.entries() {
+		quotas, known := s.kubeView.resourceQuotas(namespace)
+		if !known {
+			klog.V(4).Info("Tenant quota sync skipped: ResourceQuota cache not available")
+			return
+		}
+		limit, limited := tenantStorageLimit(quotas, classes)
+		s.syncTenantQuota(ctx, namespace, dataset, limit, limited)
+	}
+}
+
+// syncTenantQuota sets the quota of one tenant dataset to limit, or removes the quota the
+// driver set earlier when the namespace is no longer limited.
+func (s *ControllerService) syncTenantQuota(ctx context.Context, namespace, dataset string, limit int64, limited bool) {
+	current, err := s.apiClient.GetDatasetWithProperties(ctx, dataset)
+	if err != nil || current == nil {
+		klog.Warningf("Tenant quota sync: failed to read tenant dataset %s of namespace %s: %v", dataset, namespace, err)
+		return
+	}
+	quota := parsedPropertyInt(current.Quota)
+	recorded, hasRecorded := current.UserProperties[tnsapi.PropertyTenantQuota]
+
+	if !limited {
+		if !hasRecorded {
+			return
+		}
+		if recorded.Value == strconv.FormatInt(quota, 10) {
+			none := int64(0)
+			if _, err := s.apiClient.UpdateDataset(ctx, dataset, tnsapi.DatasetUpdateParams{Quota: &none}); err != nil {
+				klog.Warningf("Tenant quota sync: failed to remove quota of %s: %v", dataset, err)
+				return
+			}
+			klog.Infof("Removed quota of tenant dataset %s: namespace %s has no storage ResourceQuota", dataset, namespace)
+		}
+		if err := s.apiClient.ClearDatasetProperties(ctx, dataset, []string{tnsapi.PropertyTenantQuota}); err != nil {
+			klog.Warningf("Tenant quota sync: failed to clear %s of %s: %v", tnsapi.PropertyTenantQuota, dataset, err)
+		}
+		return
+	}
+
+	if limit <= 0 {
+		klog.V(4).Infof("Tenant quota sync: namespace %s may not request storage, leaving quota of %s alone", namespace, dataset)
+		return
+	}
+	if quota == limit && recorded.Value == strconv.FormatInt(limit, 10) {
+		return
+	}
+	if quota != limit {
+		if _, err := s.apiClient.UpdateDataset(ctx, dataset, tnsapi.DatasetUpdateParams{Quota: &limit}); err != nil {
+			klog.Warningf("Tenant quota sync: failed to set quota of %s to %d bytes: %v", dataset, limit, err)
+			return
+		}
+		klog.Infof("Set quota of tenant dataset %s to %d bytes (was %d) from the ResourceQuotas of namespace %s", dataset, limit, quota, namespace)
+	}
+	if err := s.apiClient.SetDatasetProperties(ctx, dataset, map[string]string{tnsapi.PropertyTenantQuota: strconv.FormatInt(limit, 10)}); err != nil {
+		klog.Warningf("Tenant quota sync: failed to record quota of %s: %v", dataset, err)
+	}
+}
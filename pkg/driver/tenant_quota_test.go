@@ -0,0 +1,128 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testResourceQuota(namespace, name string, hard map[corev1.ResourceName]string) *corev1.ResourceQuota {
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{}},
+	}
+	for resourceName, value := range hard {
+		quota.Spec.Hard[resourceName] = resource.MustParse(value)
+	}
+	return quota
+}
+
+func TestTenantStorageLimit(t *testing.T) {
+	nfs, nvme := storageClassRequestsStorage("tns-nfs"), storageClassRequestsStorage("tns-nvme")
+	tests := []struct {
+		name        string
+		quotas      []*corev1.ResourceQuota
+		wantLimit   int64
+		wantLimited bool
+	}{
+		{name: "no quotas"},
+		{
+			name:   "unrelated quota",
+			quotas: []*corev1.ResourceQuota{testResourceQuota("a", "q", map[corev1.ResourceName]string{corev1.ResourcePods: "10"})},
+		},
+		{
+			name:      "per class",
+			quotas:    []*corev1.ResourceQuota{testResourceQuota("a", "q", map[corev1.ResourceName]string{nfs: "100Gi", nvme: "50Gi"})},
+			wantLimit: 150 << 30, wantLimited: true,
+		},
+		{
+			name:      "capped by requests.storage",
+			quotas:    []*corev1.ResourceQuota{testResourceQuota("a", "q", map[corev1.ResourceName]string{nfs: "100Gi", nvme: "50Gi", corev1.ResourceRequestsStorage: "120Gi"})},
+			wantLimit: 120 << 30, wantLimited: true,
+		},
+		{
+			name:      "class without limit",
+			quotas:    []*corev1.ResourceQuota{testResourceQuota("a", "q", map[corev1.ResourceName]string{nfs: "100Gi", corev1.ResourceRequestsStorage: "200Gi"})},
+			wantLimit: 200 << 30, wantLimited: true,
+		},
+		{
+			name:   "class without limit and no total",
+			quotas: []*corev1.ResourceQuota{testResourceQuota("a", "q", map[corev1.ResourceName]string{nfs: "100Gi"})},
+		},
+		{
+			name: "smallest of several quotas",
+			quotas: []*corev1.ResourceQuota{
+				testResourceQuota("a", "q1", map[corev1.ResourceName]string{nfs: "100Gi", nvme: "50Gi"}),
+				testResourceQuota("a", "q2", map[corev1.ResourceName]string{nfs: "10Gi"}),
+			},
+			wantLimit: 60 << 30, wantLimited: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, limited := tenantStorageLimit(tt.quotas, []string{"tns-nfs", "tns-nvme"})
+			if limit != tt.wantLimit || limited != tt.wantLimited {
+				t.Errorf("tenantStorageLimit() = %d, %v; want %d, %v", limit, limited, tt.wantLimit, tt.wantLimited)
+			}
+		})
+	}
+}
+
+func TestSyncTenantQuotas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants")
+	if err := os.WriteFile(path, []byte("team-a tank/tenants/team-a\nteam-b tank/tenants/team-b\nteam-c tank/tenants/team-c\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	kube := fake.NewClientset(
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "tns-nfs"}, Provisioner: testDriverName},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "other"}, Provisioner: "other.csi.io"},
+		testResourceQuota("team-a", "storage", map[corev1.ResourceName]string{storageClassRequestsStorage("tns-nfs"): "100Gi"}),
+	)
+
+	// team-a: limited, quota not set yet. team-b: driver-set quota whose ResourceQuota is gone.
+	// team-c: an administrator's quota, never touched.
+	datasets := map[string]*tnsapi.DatasetWithProperties{
+		"tank/tenants/team-a": {},
+		"tank/tenants/team-b": {UserProperties: map[string]tnsapi.UserProperty{tnsapi.PropertyTenantQuota: {Value: "1073741824"}}},
+		"tank/tenants/team-c": {},
+	}
+	datasets["tank/tenants/team-b"].Quota = map[string]interface{}{"parsed": float64(1 << 30)}
+	datasets["tank/tenants/team-c"].Quota = map[string]interface{}{"parsed": float64(5 << 30)}
+	updates := map[string]int64{}
+	s := &ControllerService{
+		kubeView:       newTestClusterView(t, kube),
+		tenantDatasets: newTenantDatasetMap(path),
+		apiClient: &mockAPIClient{
+			getDatasetWithPropertiesFunc: func(_ context.Context, datasetID string) (*tnsapi.DatasetWithProperties, error) {
+				return datasets[datasetID], nil
+			},
+			updateDatasetFunc: func(_ context.Context, datasetID string, params tnsapi.DatasetUpdateParams) (*tnsapi.Dataset, error) {
+				updates[datasetID] = *params.Quota
+				return &tnsapi.Dataset{ID: datasetID}, nil
+			},
+		},
+	}
+
+	s.syncTenantQuotas(context.Background())
+	if len(updates) != 2 || updates["tank/tenants/team-a"] != 100<<30 || updates["tank/tenants/team-b"] != 0 {
+		t.Errorf("quota updates = %v; want team-a set to 100Gi and team-b removed", updates)
+	}
+
+	// Already in sync: nothing to do
+	datasets["tank/tenants/team-a"].Quota = map[string]interface{}{"parsed": float64(100 << 30)}
+	datasets["tank/tenants/team-a"].UserProperties = map[string]tnsapi.UserProperty{tnsapi.PropertyTenantQuota: {Value: "107374182400"}}
+	delete(datasets["tank/tenants/team-b"].UserProperties, tnsapi.PropertyTenantQuota)
+	clear(updates)
+	s.syncTenantQuotas(context.Background())
+	if len(updates) != 0 {
+		t.Errorf("quota updates of synced datasets = %v, want none", updates)
+	}
+}
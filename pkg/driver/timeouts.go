@@ -0,0 +1,89 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc"
+)
+
+// Operation timeouts.
+//
+// Each class of operation gets its own limit so that slow storage systems or networks can be
+// accommodated without raising every timeout at once. Limits only ever shorten a context: a
+// caller deadline that expires earlier (e.g. the CSI sidecar's --timeout) still wins.
+
+// Default operation timeouts.
+const (
+	// DefaultProvisioningTimeout bounds a single storage API call made while provisioning.
+	DefaultProvisioningTimeout = 2 * time.Minute
+
+	// DefaultJobTimeout bounds waiting for a long-running storage job such as a replication.
+	DefaultJobTimeout = time.Hour
+
+	// DefaultNodeStageTimeout bounds NodeStageVolume, NodePublishVolume and their counterparts.
+	DefaultNodeStageTimeout = 5 * time.Minute
+
+	// DefaultMountTimeout bounds a single NFS or SMB mount command.
+	DefaultMountTimeout = 30 * time.Second
+)
+
+// Timeouts configures the operation timeouts. Zero values use the defaults.
+type Timeouts struct {
+	Provisioning time.Duration // Single storage API call (controller and node)
+	Job          time.Duration // Waiting for a storage job to finish
+	NodeStage    time.Duration // Node stage/publish RPCs
+	Mount        time.Duration // NFS and SMB mount commands
+	APIConnect   time.Duration // Storage API WebSocket dial (0 = tnsapi default)
+	APIAuth      time.Duration // Storage API authentication (0 = tnsapi default)
+}
+
+func (t Timeouts) provisioning() time.Duration {
+	return durationOrDefault(t.Provisioning, DefaultProvisioningTimeout)
+}
+
+func (t Timeouts) nodeStage() time.Duration {
+	return durationOrDefault(t.NodeStage, DefaultNodeStageTimeout)
+}
+
+func (t Timeouts) mount() time.Duration {
+	return durationOrDefault(t.Mount, DefaultMountTimeout)
+}
+
+// apiTimeouts returns the storage API client timeouts.
+func (t Timeouts) apiTimeouts() tnsapi.Timeouts {
+	return tnsapi.Timeouts{
+		Connect: t.APIConnect,
+		Auth:    t.APIAuth,
+		Call:    t.provisioning(),
+		Job:     durationOrDefault(t.Job, DefaultJobTimeout),
+	}
+}
+
+// rpcTimeout returns the deadline applied to a CSI RPC, or 0 for RPCs without one.
+func (t Timeouts) rpcTimeout(method string) time.Duration {
+	switch method {
+	case "NodeStageVolume", "NodeUnstageVolume", "NodePublishVolume", "NodeUnpublishVolume":
+		return t.nodeStage()
+	default:
+		return 0
+	}
+}
+
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// timeoutInterceptor applies the per-class RPC deadline to incoming CSI calls.
+func (d *Driver) timeoutInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if timeout := d.config.Timeouts.rpcTimeout(rpcMethodName(info.FullMethod)); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return handler(ctx, req)
+}
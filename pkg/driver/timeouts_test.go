@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func TestTimeoutsDefaults(t *testing.T) {
+	var zero Timeouts
+	if zero.provisioning() != DefaultProvisioningTimeout || zero.nodeStage() != DefaultNodeStageTimeout || zero.mount() != DefaultMountTimeout {
+		t.Errorf("zero Timeouts do not use defaults: %v %v %v", zero.provisioning(), zero.nodeStage(), zero.mount())
+	}
+	api := zero.apiTimeouts()
+	if api.Call != DefaultProvisioningTimeout || api.Job != DefaultJobTimeout || api.Connect != 0 || api.Auth != 0 {
+		t.Errorf("unexpected API timeouts %+v", api)
+	}
+
+	custom := Timeouts{Provisioning: time.Minute, Job: 3 * time.Hour, Mount: time.Minute, APIAuth: 20 * time.Second}
+	api = custom.apiTimeouts()
+	if api.Call != time.Minute || api.Job != 3*time.Hour || api.Auth != 20*time.Second || custom.mount() != time.Minute {
+		t.Errorf("custom values not applied: %+v", api)
+	}
+}
+
+func TestTimeoutInterceptor(t *testing.T) {
+	d := &Driver{config: Config{Timeouts: Timeouts{NodeStage: time.Minute}}}
+
+	tests := []struct {
+		method       string
+		wantDeadline bool
+	}{
+		{method: "/csi.v1.Node/NodeStageVolume", wantDeadline: true},
+		{method: "/csi.v1.Node/NodeUnpublishVolume", wantDeadline: true},
+		{method: "/csi.v1.Controller/CreateVolume", wantDeadline: false},
+		{method: "/csi.v1.Identity/Probe", wantDeadline: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			var deadline time.Time
+			var hasDeadline bool
+			handler := func(ctx context.Context, _ interface{}) (interface{}, error) {
+				deadline, hasDeadline = ctx.Deadline()
+				return nil, nil
+			}
+			if _, err := d.timeoutInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler); err != nil {
+				t.Fatalf("interceptor returned error: %v", err)
+			}
+			if hasDeadline != tt.wantDeadline {
+				t.Fatalf("deadline set = %v, want %v", hasDeadline, tt.wantDeadline)
+			}
+			if hasDeadline && time.Until(deadline) > time.Minute {
+				t.Errorf("deadline in %v is later than the configured minute", time.Until(deadline))
+			}
+		})
+	}
+
+	// A shorter caller deadline is kept
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	want, _ := ctx.Deadline()
+	handler := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		if got, _ := ctx.Deadline(); !got.Equal(want) {
+			t.Errorf("deadline = %v, want caller deadline %v", got, want)
+		}
+		return nil, nil
+	}
+	if _, err := d.timeoutInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeStageVolume"}, handler); err != nil {
+		t.Fatalf("interceptor returned error: %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/metrics"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// Volume usage alerts.
+//
+// An NFS or SMB volume is a dataset with a refquota. Applications only see a full quota as
+// ENOSPC, which is hard to trace back to the volume. With --usage-alert-thresholds the
+// controller periodically compares each dataset's used space with its provisioned capacity,
+// exports the ratio as a metric and emits a Warning event on the bound PVC whenever a volume
+// crosses a threshold upwards. Dropping below a threshold re-arms it.
+//
+// Block volumes (iSCSI, NVMe-oF) are not monitored: ZVOL usage says nothing about how full
+// the filesystem on top of it is.
+
+// Usage alert defaults.
+const (
+	// DefaultUsageAlertInterval is how often volume usage is checked.
+	DefaultUsageAlertInterval = 5 * time.Minute
+
+	// usageAlertEventReason is the reason of PVC events emitted for high usage.
+	usageAlertEventReason = "VolumeUsageHigh"
+
+	// usageAlertComponent is the event source component.
+	usageAlertComponent = "tns-csi-controller"
+)
+
+// ParseUsageAlertThresholds parses a comma-separated list of percentages (e.g. "80,90,95").
+// Returns the thresholds in ascending order, or nil for an empty list.
+func ParseUsageAlertThresholds(value string) ([]int, error) {
+	var thresholds []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSuffix(strings.TrimSpace(field), "%")
+		if field == "" {
+			continue
+		}
+		pct, err := strconv.Atoi(field)
+		if err != nil || pct <= 0 || pct > 100 {
+			return nil, fmt.Errorf("invalid usage alert threshold %q: must be a percentage between 1 and 100", field)
+		}
+		if !slices.Contains(thresholds, pct) {
+			thresholds = append(thresholds, pct)
+		}
+	}
+	slices.Sort(thresholds)
+	return thresholds, nil
+}
+
+// usageMonitor watches filesystem volume usage and raises PVC warning events.
+type usageMonitor struct {
+	apiClient   tnsapi.ClientInterface
+	kube        kubernetes.Interface
+	recorder    record.EventRecorder
+	levels      map[string]int  // volume ID -> highest threshold currently exceeded
+	growLimited map[string]bool // volume IDs already reported at their autoGrow maximum
+	view        *clusterView    // Cached PVs and PVCs (nil without --kube-informers)
+	clusterID   string
+	thresholds  []int
+	interval    time.Duration
+	// autoGrowEnabled expands volumes carrying an autoGrow policy (see autogrow.go)
+	autoGrowEnabled bool
+}
+
+// newUsageMonitor creates a usage monitor that reports through the in-cluster Kubernetes API.
+func newUsageMonitor(apiClient tnsapi.ClientInterface, clusterID string, thresholds []int, interval time.Duration, autoGrow bool) (*usageMonitor, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+	}
+	kube, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kube.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: usageAlertComponent})
+
+	return &usageMonitor{
+		apiClient:       apiClient,
+		kube:            kube,
+		recorder:        recorder,
+		levels:          make(map[string]int),
+		growLimited:     make(map[string]bool),
+		clusterID:       clusterID,
+		thresholds:      thresholds,
+		interval:        interval,
+		autoGrowEnabled: autoGrow,
+	}, nil
+}
+
+// run checks volume usage every interval until stopCh is closed.
+func (m *usageMonitor) run(stopCh <-chan struct{}) {
+	klog.Infof("Volume usage monitor started (alert thresholds %v%%, autoGrow %v, every %s)", m.thresholds, m.autoGrowEnabled, m.interval)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), m.interval)
+		m.check(ctx)
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// check runs one usage pass over all managed filesystem volumes.
+func (m *usageMonitor) check(ctx context.Context) {
+	datasets, err := m.apiClient.FindManagedDatasets(ctx, "")
+	if err != nil {
+		klog.Warningf("Volume usage check skipped: %v", err)
+		return
+	}
+
+	seen := make(map[string]bool, len(datasets))
+	for i := range datasets {
+		ds := &datasets[i]
+		prop := func(name string) string { return ds.UserProperties[name].Value }
+
+		if ds.Type != datasetTypeFilesystem {
+			continue
+		}
+		if owner := prop(tnsapi.PropertyClusterID); owner != "" && owner != m.clusterID {
+			continue
+		}
+		capacity := tnsapi.StringToInt64(prop(tnsapi.PropertyCapacityBytes))
+		used, ok := ds.Used["parsed"].(float64)
+		if capacity <= 0 || !ok {
+			continue
+		}
+
+		seen[ds.ID] = true
+		pvcNamespace, pvcName := prop(tnsapi.PropertyPVCNamespace), prop(tnsapi.PropertyPVCName)
+		if pvcName == "" {
+			// Provisioned without PVC metadata: the PV cache may still know the claim
+			pvcNamespace, pvcName = m.view.claimForVolume(ds.ID, prop(tnsapi.PropertyCSIVolumeName))
+		}
+		ratio := used / float64(capacity)
+		metrics.SetVolumeUsage(ds.ID, prop(tnsapi.PropertyProtocol), pvcNamespace, pvcName, int64(used), ratio)
+
+		level := m.exceededThreshold(ratio)
+		if level > m.levels[ds.ID] && pvcName != "" && pvcNamespace != "" {
+			m.event(ctx, pvcNamespace, pvcName, corev1.EventTypeWarning, usageAlertEventReason, fmt.Sprintf(
+				"Volume %s is %.0f%% full (%s of %s used, alert threshold %d%%); writes fail with ENOSPC once the quota is reached",
+				ds.ID, ratio*100, formatUsageBytes(int64(used)), formatUsageBytes(capacity), level))
+		}
+		m.levels[ds.ID] = level
+
+		if m.autoGrowEnabled {
+			m.autoGrow(ctx, ds, capacity, int64(used))
+		}
+	}
+
+	// Forget deleted volumes
+	for volumeID := range m.levels {
+		if !seen[volumeID] {
+			delete(m.levels, volumeID)
+			delete(m.growLimited, volumeID)
+			metrics.DeleteVolumeUsage(volumeID)
+		}
+	}
+}
+
+// exceededThreshold returns the highest threshold reached by ratio, or 0.
+func (m *usageMonitor) exceededThreshold(ratio float64) int {
+	level := 0
+	for _, pct := range m.thresholds {
+		if ratio*100 >= float64(pct) {
+			level = pct
+		}
+	}
+	return level
+}
+
+// event emits an event on a PVC. Missing PVCs (e.g. released volumes) are skipped.
+func (m *usageMonitor) event(ctx context.Context, namespace, name, eventType, reason, message string) {
+	pvc, known := m.view.pvc(namespace, name)
+	if !known {
+		var err error
+		if pvc, err = m.kube.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{}); err != nil {
+			klog.V(4).Infof("Not raising %s event for PVC %s/%s: %v", reason, namespace, name, err)
+			return
+		}
+	}
+	if pvc == nil {
+		klog.V(4).Infof("Not raising %s event for PVC %s/%s: PVC not found", reason, namespace, name)
+		return
+	}
+	klog.Warningf("PVC %s/%s: %s", namespace, name, message)
+	m.recorder.Event(pvc, eventType, reason, message)
+}
+
+// formatUsageBytes formats a byte count with a binary unit (e.g. "9.5Gi").
+func formatUsageBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ci", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,156 @@
+package driver
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestParseUsageAlertThresholds(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []int
+		wantErr bool
+	}{
+		{value: "", want: nil},
+		{value: "80,90,95", want: []int{80, 90, 95}},
+		{value: "95, 80%,90,80", want: []int{80, 90, 95}},
+		{value: "0", wantErr: true},
+		{value: "101", wantErr: true},
+		{value: "80,high", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseUsageAlertThresholds(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseUsageAlertThresholds(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseUsageAlertThresholds(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageMonitorCheck(t *testing.T) {
+	ctx := context.Background()
+	const gib = 1 << 30
+
+	used := float64(5 * gib)
+	dataset := func() tnsapi.DatasetWithProperties {
+		return tnsapi.DatasetWithProperties{
+			Dataset: tnsapi.Dataset{
+				ID:   "tank/csi/pvc-logs",
+				Type: datasetTypeFilesystem,
+				Used: map[string]interface{}{"parsed": used},
+			},
+			UserProperties: map[string]tnsapi.UserProperty{
+				tnsapi.PropertyCapacityBytes: {Value: "10737418240"},
+				tnsapi.PropertyProtocol:      {Value: ProtocolNFS},
+				tnsapi.PropertyPVCName:       {Value: "logs"},
+				tnsapi.PropertyPVCNamespace:  {Value: "default"},
+			},
+		}
+	}
+	client := &MockAPIClientForSnapshots{
+		FindManagedDatasetsFunc: func(_ context.Context, _ string) ([]tnsapi.DatasetWithProperties, error) {
+			return []tnsapi.DatasetWithProperties{dataset()}, nil
+		},
+	}
+	kube := fake.NewClientset(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "logs", Namespace: "default"}})
+	recorder := record.NewFakeRecorder(10)
+	monitor := &usageMonitor{
+		apiClient:  client,
+		kube:       kube,
+		recorder:   recorder,
+		levels:     make(map[string]int),
+		thresholds: []int{80, 90, 95},
+	}
+
+	expectEvents := func(want int) []string {
+		t.Helper()
+		var events []string
+		for len(recorder.Events) > 0 {
+			events = append(events, <-recorder.Events)
+		}
+		if len(events) != want {
+			t.Fatalf("got %d events %v, want %d", len(events), events, want)
+		}
+		return events
+	}
+
+	// Below every threshold: nothing to report
+	monitor.check(ctx)
+	expectEvents(0)
+
+	// Crossing 90% reports once, with the highest threshold reached
+	used = 9.2 * gib
+	monitor.check(ctx)
+	events := expectEvents(1)
+	if !strings.Contains(events[0], usageAlertEventReason) || !strings.Contains(events[0], "threshold 90%") {
+		t.Errorf("event = %q, want %s at the 90%% threshold", events[0], usageAlertEventReason)
+	}
+	monitor.check(ctx)
+	expectEvents(0)
+
+	// Dropping below re-arms the threshold
+	used = 7 * gib
+	monitor.check(ctx)
+	used = 9.6 * gib
+	monitor.check(ctx)
+	expectEvents(1)
+
+	// Deleted volumes are forgotten
+	client.FindManagedDatasetsFunc = func(_ context.Context, _ string) ([]tnsapi.DatasetWithProperties, error) {
+		return nil, nil
+	}
+	monitor.check(ctx)
+	if len(monitor.levels) != 0 {
+		t.Errorf("levels = %v after volume deletion, want empty", monitor.levels)
+	}
+}
+
+func TestUsageMonitorSkipsOtherVolumes(t *testing.T) {
+	ctx := context.Background()
+	full := map[string]interface{}{"parsed": float64(1 << 30)}
+	props := func(extra map[string]string) map[string]tnsapi.UserProperty {
+		p := map[string]tnsapi.UserProperty{
+			tnsapi.PropertyCapacityBytes: {Value: "1073741824"},
+			tnsapi.PropertyPVCName:       {Value: "data"},
+			tnsapi.PropertyPVCNamespace:  {Value: "default"},
+		}
+		for k, v := range extra {
+			p[k] = tnsapi.UserProperty{Value: v}
+		}
+		return p
+	}
+	client := &MockAPIClientForSnapshots{
+		FindManagedDatasetsFunc: func(_ context.Context, _ string) ([]tnsapi.DatasetWithProperties, error) {
+			return []tnsapi.DatasetWithProperties{
+				{Dataset: tnsapi.Dataset{ID: "tank/csi/zvol", Type: "VOLUME", Used: full}, UserProperties: props(nil)},
+				{Dataset: tnsapi.Dataset{ID: "tank/csi/other", Type: datasetTypeFilesystem, Used: full}, UserProperties: props(map[string]string{tnsapi.PropertyClusterID: "other"})},
+			}, nil
+		},
+	}
+	recorder := record.NewFakeRecorder(10)
+	monitor := &usageMonitor{
+		apiClient:  client,
+		kube:       fake.NewClientset(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"}}),
+		recorder:   recorder,
+		levels:     make(map[string]int),
+		clusterID:  "mine",
+		thresholds: []int{80},
+	}
+
+	monitor.check(ctx)
+	if len(recorder.Events) != 0 {
+		t.Errorf("got %d events for ZVOLs and foreign volumes, want none", len(recorder.Events))
+	}
+}
@@ -0,0 +1,195 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"k8s.io/klog/v2"
+)
+
+// Volume context versions.
+//
+// A PV's volume attributes are the VolumeContext CreateVolume returned when the volume was
+// provisioned, and Kubernetes never updates them. Volumes provisioned by older releases
+// therefore lack keys that later releases added: NVMe-oF volumes without nsid and block volumes
+// without expectedCapacity or datasetName take slower or degraded paths on every stage.
+//
+// CreateVolume stamps contexts with contextVersion. On NodeStageVolume, contexts of an older
+// version are migrated: the missing attributes are looked up on TrueNAS once and the migrated
+// context is kept until the volume is unstaged. A lookup that fails is logged and staging goes on
+// with what the context has, as older releases did.
+//
+// Versions:
+//
+//	1 (no contextVersion key) - releases before versioning; block volumes may lack datasetName,
+//	  expectedCapacity and (NVMe-oF) nsid, and early contexts lack protocol
+//	2 - contextVersion; block volumes always carry datasetName and expectedCapacity, NVMe-oF
+//	  volumes nqn and nsid
+
+// VolumeContextKeyContextVersion is the volume context key of the context version.
+const VolumeContextKeyContextVersion = "contextVersion"
+
+// Volume context versions.
+const (
+	// volumeContextVersionLegacy is the version of contexts without a contextVersion key.
+	volumeContextVersionLegacy = 1
+	// volumeContextVersion is the version of the contexts this release creates.
+	volumeContextVersion = 2
+)
+
+// errNamespaceNotFound is returned when no NVMe-oF namespace exports a volume's ZVOL.
+var errNamespaceNotFound = errors.New("no NVMe-oF namespace exports the ZVOL")
+
+// contextVersion returns the version of a volume context.
+func contextVersion(volumeContext map[string]string) int {
+	version, err := strconv.Atoi(volumeContext[VolumeContextKeyContextVersion])
+	if err != nil || version < volumeContextVersionLegacy {
+		return volumeContextVersionLegacy
+	}
+	return version
+}
+
+// stampVolumeContext marks a context created by this release with its version.
+func stampVolumeContext(volumeContext map[string]string) {
+	volumeContext[VolumeContextKeyContextVersion] = strconv.Itoa(volumeContextVersion)
+}
+
+// volumeContextMigrations keeps migrated contexts of staged volumes.
+type volumeContextMigrations struct {
+	contexts map[string]map[string]string
+	mu       sync.Mutex
+}
+
+// get returns the migrated context of a volume, if there is one.
+func (m *volumeContextMigrations) get(volumeID string) (map[string]string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	volumeContext, ok := m.contexts[volumeID]
+	return volumeContext, ok
+}
+
+// put keeps the migrated context of a volume.
+func (m *volumeContextMigrations) put(volumeID string, volumeContext map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.contexts == nil {
+		m.contexts = make(map[string]map[string]string)
+	}
+	m.contexts[volumeID] = volumeContext
+}
+
+// forget drops the migrated context of an unstaged volume.
+func (m *volumeContextMigrations) forget(volumeID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.contexts, volumeID)
+}
+
+// migrateVolumeContext returns the context of a volume staged by NodeStageVolume, with the
+// attributes older releases did not record backfilled from TrueNAS.
+func (s *NodeService) migrateVolumeContext(ctx context.Context, volumeID string, volumeContext map[string]string) map[string]string {
+	version := contextVersion(volumeContext)
+	if version >= volumeContextVersion || !s.features.Enabled(FeatureVolumeContextMigration) {
+		return volumeContext
+	}
+	if migrated, ok := s.contextMigrations.get(volumeID); ok {
+		return migrated
+	}
+
+	migrated := maps.Clone(volumeContext)
+	if migrated == nil {
+		migrated = make(map[string]string)
+	}
+	protocol := getProtocolFromVolumeContext(volumeContext)
+	backfilled := make(map[string]string)
+	set := func(key, value string) {
+		if migrated[key] == "" && value != "" {
+			migrated[key] = value
+			backfilled[key] = value
+		}
+	}
+	set(VolumeContextKeyProtocol, protocol)
+
+	var err error
+	if isBlockProtocol(protocol) {
+		err = s.backfillBlockContext(ctx, volumeID, migrated, set)
+	}
+	if err != nil {
+		klog.Warningf("Volume %s has a version %d volume context and missing attributes could not be looked up, staging with what it has: %v",
+			volumeID, version, err)
+		return migrated
+	}
+
+	stampVolumeContext(migrated)
+	s.contextMigrations.put(volumeID, migrated)
+	if len(backfilled) > 0 {
+		keys := slices.Sorted(maps.Keys(backfilled))
+		klog.Infof("Migrated volume context of %s from version %d to %d, backfilled %v from TrueNAS", volumeID, version, volumeContextVersion, keys)
+	}
+	return migrated
+}
+
+// backfillBlockContext looks up the dataset name, capacity and NVMe-oF namespace of a block volume.
+func (s *NodeService) backfillBlockContext(ctx context.Context, volumeID string, migrated map[string]string, set func(key, value string)) error {
+	set(VolumeContextKeyDatasetName, migrated[VolumeContextKeyDatasetID])
+	set(VolumeContextKeyDatasetName, volumeID)
+	datasetName := migrated[VolumeContextKeyDatasetName]
+
+	needsCapacity := migrated[VolumeContextKeyExpectedCapacity] == ""
+	needsNamespace := getProtocolFromVolumeContext(migrated) == ProtocolNVMeOF &&
+		(migrated[VolumeContextKeyNSID] == "" || migrated[VolumeContextKeyNQN] == "")
+	if (!needsCapacity && !needsNamespace) || s.apiClient == nil {
+		return nil
+	}
+
+	if needsCapacity {
+		dataset, err := s.apiClient.Dataset(ctx, datasetName)
+		if err != nil {
+			return fmt.Errorf("failed to look up ZVOL %s: %w", datasetName, err)
+		}
+		if size, ok := dataset.Volsize["parsed"].(float64); ok && size > 0 {
+			set(VolumeContextKeyExpectedCapacity, strconv.FormatInt(int64(size), 10))
+		}
+	}
+
+	if needsNamespace {
+		namespace, err := s.volumeNamespace(ctx, datasetName, migrated[VolumeContextKeyNVMeOFNamespaceID])
+		if err != nil {
+			return err
+		}
+		set(VolumeContextKeyNSID, strconv.Itoa(namespaceNSID(namespace)))
+		if namespace.Subsys != nil {
+			set(VolumeContextKeyNQN, namespace.Subsys.SubNQN)
+		}
+	}
+	return nil
+}
+
+// volumeNamespace returns the NVMe-oF namespace of a ZVOL, by ID when the context has one.
+func (s *NodeService) volumeNamespace(ctx context.Context, datasetName, namespaceID string) (*tnsapi.NVMeOFNamespace, error) {
+	if id, err := strconv.Atoi(namespaceID); err == nil && id > 0 {
+		namespace, err := s.apiClient.QueryNVMeOFNamespaceByID(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up NVMe-oF namespace %d: %w", id, err)
+		}
+		if namespace != nil {
+			return namespace, nil
+		}
+	}
+	namespaces, err := s.apiClient.QueryAllNVMeOFNamespaces(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list NVMe-oF namespaces: %w", err)
+	}
+	for i := range namespaces {
+		if namespaces[i].GetDevice() == "zvol/"+datasetName {
+			return &namespaces[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", errNamespaceNotFound, datasetName)
+}
@@ -0,0 +1,137 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestMigrateVolumeContextIntegration(t *testing.T) {
+	controller, _ := newIntegrationController(t)
+	node := NewNodeService("node-1", controller.apiClient, true, NewNodeRegistry(), false, 1)
+	ctx := context.Background()
+
+	create := func(name, protocol string) *csi.Volume {
+		t.Helper()
+		resp, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:          name,
+			CapacityRange: &csi.CapacityRange{RequiredBytes: 2 << 30},
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			Parameters: map[string]string{"protocol": protocol, "pool": "tank", "server": "truenas.local"},
+		})
+		if err != nil {
+			t.Fatalf("CreateVolume(%s) error = %v", name, err)
+		}
+		if got := contextVersion(resp.GetVolume().GetVolumeContext()); got != volumeContextVersion {
+			t.Fatalf("CreateVolume(%s) context version = %d, want %d", name, got, volumeContextVersion)
+		}
+		return resp.GetVolume()
+	}
+	nvmeof := create("pvc-nvmeof", ProtocolNVMeOF)
+	// The fake TrueNAS has no iSCSI service: an iSCSI context of the same ZVOL stands in
+	iscsi := &csi.Volume{VolumeId: nvmeof.GetVolumeId(), VolumeContext: map[string]string{
+		VolumeContextKeyProtocol:         ProtocolISCSI,
+		VolumeContextKeyServer:           "truenas.local",
+		VolumeContextKeyISCSIIQN:         "iqn.2005-10.org.freenas.ctl:pvc-nvmeof",
+		VolumeContextKeyDatasetName:      nvmeof.GetVolumeContext()[VolumeContextKeyDatasetName],
+		VolumeContextKeyExpectedCapacity: nvmeof.GetVolumeContext()[VolumeContextKeyExpectedCapacity],
+		VolumeContextKeyContextVersion:   nvmeof.GetVolumeContext()[VolumeContextKeyContextVersion],
+	}}
+
+	// legacy returns the context of a volume as an older release recorded it
+	legacy := func(vol *csi.Volume, dropped ...string) map[string]string {
+		volumeContext := maps.Clone(vol.GetVolumeContext())
+		delete(volumeContext, VolumeContextKeyContextVersion)
+		for _, key := range dropped {
+			delete(volumeContext, key)
+		}
+		return volumeContext
+	}
+
+	tests := []struct {
+		name          string
+		volume        *csi.Volume
+		volumeContext map[string]string
+	}{
+		{
+			name:          "nvmeof without nsid and expectedCapacity",
+			volume:        nvmeof,
+			volumeContext: legacy(nvmeof, VolumeContextKeyNSID, VolumeContextKeyExpectedCapacity),
+		},
+		{
+			name:   "nvmeof without protocol, datasetName and namespace ID",
+			volume: nvmeof,
+			volumeContext: legacy(nvmeof, VolumeContextKeyProtocol, VolumeContextKeyDatasetName, VolumeContextKeyDatasetID,
+				VolumeContextKeyNVMeOFNamespaceID, VolumeContextKeyNSID, VolumeContextKeyExpectedCapacity),
+		},
+		{
+			name:          "iscsi without expectedCapacity",
+			volume:        iscsi,
+			volumeContext: legacy(iscsi, VolumeContextKeyExpectedCapacity),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			volumeID := tt.volume.GetVolumeId()
+			node.contextMigrations.forget(volumeID)
+			original := maps.Clone(tt.volumeContext)
+
+			got := node.migrateVolumeContext(ctx, volumeID, tt.volumeContext)
+			want := tt.volume.GetVolumeContext()
+			for _, key := range []string{
+				VolumeContextKeyProtocol, VolumeContextKeyDatasetName, VolumeContextKeyNQN,
+				VolumeContextKeyNSID, VolumeContextKeyExpectedCapacity, VolumeContextKeyContextVersion,
+			} {
+				if got[key] != want[key] {
+					t.Errorf("migrated %s = %q, want %q", key, got[key], want[key])
+				}
+			}
+			if !maps.Equal(tt.volumeContext, original) {
+				t.Error("migrateVolumeContext() modified the request context")
+			}
+			if cached, ok := node.contextMigrations.get(volumeID); !ok || !maps.Equal(cached, got) {
+				t.Error("migrated context not kept for the next stage")
+			}
+		})
+	}
+
+	current := nvmeof.GetVolumeContext()
+	if got := node.migrateVolumeContext(ctx, nvmeof.GetVolumeId(), current); !maps.Equal(got, current) {
+		t.Errorf("current context changed: %v", got)
+	}
+
+	nfs := map[string]string{VolumeContextKeyServer: "truenas.local", VolumeContextKeyShare: "/mnt/tank/pvc-nfs"}
+	got := node.migrateVolumeContext(ctx, "tank/pvc-nfs", nfs)
+	if got[VolumeContextKeyProtocol] != ProtocolNFS || contextVersion(got) != volumeContextVersion {
+		t.Errorf("migrated NFS context = %v, want protocol nfs and the current version", got)
+	}
+}
+
+func TestMigrateVolumeContextLookupFailure(t *testing.T) {
+	controller, srv := newIntegrationController(t)
+	node := NewNodeService("node-1", controller.apiClient, true, NewNodeRegistry(), false, 1)
+	srv.Handle("pool.dataset.query", func(_ []json.RawMessage) (interface{}, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	legacy := map[string]string{
+		VolumeContextKeyProtocol:    ProtocolISCSI,
+		VolumeContextKeyServer:      "truenas.local",
+		VolumeContextKeyISCSIIQN:    "iqn.2005-10.org.freenas.ctl:pvc-1",
+		VolumeContextKeyDatasetName: "tank/pvc-1",
+	}
+	got := node.migrateVolumeContext(context.Background(), "tank/pvc-1", legacy)
+	if contextVersion(got) != volumeContextVersionLegacy || got[VolumeContextKeyISCSIIQN] != legacy[VolumeContextKeyISCSIIQN] {
+		t.Errorf("context after failed lookup = %v, want the legacy context", got)
+	}
+	if _, ok := node.contextMigrations.get("tank/pvc-1"); ok {
+		t.Error("failed migration kept; the next stage would not retry it")
+	}
+}
@@ -0,0 +1,176 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/klog/v2"
+)
+
+// Volume groups.
+//
+// Applications such as Kafka or Cassandra spread one logical dataset over many volumes, so
+// per-volume snapshots are not consistent with each other. A volume group places a set of
+// volumes in one shared child dataset of their parent, <parentDataset>/<group>/<volume>:
+//
+//   - the StorageClass parameter volumeGroup names the group, rendered like nameTemplate
+//     (e.g. "{{ .PVCNamespace }}-kafka")
+//   - the PVC annotation tns.csi.io/volume-group overrides it per PVC (needs --kube-informers)
+//
+// The group dataset is created with the first volume and removed with the last one. ZFS
+// snapshots a dataset and all its descendants atomically, so the GroupController service
+// (controller_group_snapshot.go) takes crash-consistent VolumeGroupSnapshots of a group, and
+// the group can be replicated or destroyed as one dataset. A group belongs to the namespace of
+// its first volume; volumes of other namespaces cannot join it.
+const (
+	// VolumeGroupParam is the StorageClass parameter naming the volume group of new volumes.
+	VolumeGroupParam = "volumeGroup"
+	// VolumeGroupAnnotation is the PVC annotation naming the volume group of its volume.
+	VolumeGroupAnnotation = "tns.csi.io/volume-group"
+)
+
+// errEmptyVolumeGroup is returned when a volumeGroup template renders an empty name.
+var errEmptyVolumeGroup = errors.New("volume group name is empty")
+
+// resolveVolumeGroup returns the volume group of a new volume, or "" if it has none.
+func (s *ControllerService) resolveVolumeGroup(params map[string]string, pvName string) (string, error) {
+	if pvc, _ := s.kubeView.pvc(params[CSIPVCNamespace], params[CSIPVCName]); pvc != nil {
+		if group := strings.TrimSpace(pvc.Annotations[VolumeGroupAnnotation]); group != "" {
+			return sanitizeVolumeName(group), nil
+		}
+	}
+	if params[VolumeGroupParam] == "" {
+		return "", nil
+	}
+
+	tmpl, err := template.New(VolumeGroupParam).Option("missingkey=error").Parse(params[VolumeGroupParam])
+	if err != nil {
+		return "", fmt.Errorf("invalid %s %q: %w", VolumeGroupParam, params[VolumeGroupParam], err)
+	}
+	var group strings.Builder
+	if err := tmpl.Execute(&group, extractVolumeNameContext(params, pvName)); err != nil {
+		return "", fmt.Errorf("failed to render %s %q: %w", VolumeGroupParam, params[VolumeGroupParam], err)
+	}
+	name := sanitizeVolumeName(strings.TrimSpace(group.String()))
+	if name == "" {
+		return "", errEmptyVolumeGroup
+	}
+	return name, nil
+}
+
+// applyVolumeGroup returns req with the parentDataset parameter set to the volume group
+// dataset of the new volume, creating the group dataset if needed. req is returned unchanged
+// if the volume belongs to no group.
+func (s *ControllerService) applyVolumeGroup(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeRequest, error) {
+	params := req.GetParameters()
+	group, err := s.resolveVolumeGroup(params, req.GetName())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Failed to resolve volume group: %v", err)
+	}
+	if group == "" {
+		return req, nil
+	}
+	if params[VolumeTypeParam] != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s cannot be combined with %s", VolumeGroupParam, VolumeTypeParam)
+	}
+	parent := params["parentDataset"]
+	if parent == "" {
+		parent = params["pool"]
+	}
+	if parent == "" {
+		// Missing pool/parentDataset is reported by the protocol-specific validation
+		return req, nil
+	}
+
+	groupDataset := parent + "/" + group
+	if err := s.ensureVolumeGroup(ctx, groupDataset, group, params[CSIPVCNamespace]); err != nil {
+		return nil, err
+	}
+	klog.V(4).Infof("Placing volume %s in volume group %s", req.GetName(), groupDataset)
+
+	placed, ok := proto.Clone(req).(*csi.CreateVolumeRequest)
+	if !ok {
+		return nil, status.Error(codes.Internal, "failed to copy CreateVolume request")
+	}
+	placed.Parameters["parentDataset"] = groupDataset
+	return placed, nil
+}
+
+// ensureVolumeGroup creates the dataset of a volume group, or checks that an existing one is
+// the group of namespace.
+func (s *ControllerService) ensureVolumeGroup(ctx context.Context, groupDataset, group, namespace string) error {
+	existing, err := s.apiClient.GetDatasetWithProperties(ctx, groupDataset)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to look up volume group %s: %v", groupDataset, err)
+	}
+	if existing == nil {
+		if _, err := s.apiClient.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: groupDataset, Type: "FILESYSTEM"}); err != nil {
+			// Another volume of the group may have created it meanwhile
+			if existing, _ = s.apiClient.GetDatasetWithProperties(ctx, groupDataset); existing == nil {
+				return status.Errorf(codes.Internal, "failed to create volume group %s: %v", groupDataset, err)
+			}
+		} else {
+			props := map[string]string{
+				tnsapi.PropertyVolumeGroup:          group,
+				tnsapi.PropertyVolumeGroupNamespace: namespace,
+			}
+			if s.clusterID != "" {
+				props[tnsapi.PropertyClusterID] = s.clusterID
+			}
+			if err := s.apiClient.SetDatasetProperties(ctx, groupDataset, props); err != nil {
+				return status.Errorf(codes.Internal, "failed to mark volume group %s: %v", groupDataset, err)
+			}
+			klog.Infof("Created volume group %s for namespace %s", groupDataset, namespace)
+			return nil
+		}
+	}
+
+	if existing.UserProperties[tnsapi.PropertyVolumeGroup].Value == "" {
+		return status.Errorf(codes.FailedPrecondition, "dataset %s exists but is not a volume group", groupDataset)
+	}
+	if owner := existing.UserProperties[tnsapi.PropertyVolumeGroupNamespace].Value; owner != namespace {
+		return status.Errorf(codes.PermissionDenied, "volume group %s belongs to namespace %s", groupDataset, owner)
+	}
+	return nil
+}
+
+// removeEmptyVolumeGroup deletes the volume group dataset parent if no volume is left in it.
+// Other datasets are left alone.
+func (s *ControllerService) removeEmptyVolumeGroup(ctx context.Context, parent string) {
+	group, err := s.apiClient.GetDatasetWithProperties(ctx, parent)
+	if err != nil || group == nil || group.UserProperties[tnsapi.PropertyVolumeGroup].Value == "" {
+		return
+	}
+	children, err := s.apiClient.QueryAllDatasets(ctx, parent+"/")
+	if err != nil || len(children) > 0 {
+		return // Volumes left, or still being deleted in the background
+	}
+	if err := s.apiClient.DeleteDataset(ctx, parent); err != nil && !isNotFoundError(err) {
+		klog.Warningf("Failed to delete empty volume group %s: %v", parent, err)
+		return
+	}
+	klog.Infof("Deleted volume group %s with its last volume", parent)
+}
+
+// volumeGroupOf returns the volume group dataset of a volume ID, or "" if the volume is not
+// in a group.
+func (s *ControllerService) volumeGroupOf(ctx context.Context, volumeID string) string {
+	if !isDatasetPathVolumeID(volumeID) {
+		return ""
+	}
+	parent := path.Dir(volumeID)
+	group, err := s.apiClient.GetDatasetWithProperties(ctx, parent)
+	if err != nil || group == nil || group.UserProperties[tnsapi.PropertyVolumeGroup].Value == "" {
+		return ""
+	}
+	return parent
+}
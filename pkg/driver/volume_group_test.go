@@ -0,0 +1,165 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestResolveVolumeGroup(t *testing.T) {
+	s := &ControllerService{}
+	tests := []struct {
+		params  map[string]string
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "none", params: map[string]string{}},
+		{name: "literal", params: map[string]string{VolumeGroupParam: "kafka"}, want: "kafka"},
+		{name: "template", params: map[string]string{VolumeGroupParam: "{{ .PVCNamespace }}-kafka", CSIPVCNamespace: "prod"}, want: "prod-kafka"},
+		{name: "unknown field", params: map[string]string{VolumeGroupParam: "{{ .Nope }}"}, wantErr: true},
+		{name: "empty", params: map[string]string{VolumeGroupParam: "{{ .PVCNamespace }}"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.resolveVolumeGroup(tt.params, "pvc-1")
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("resolveVolumeGroup() = %q, %v; want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVolumeGroupSnapshotIntegration(t *testing.T) {
+	controller, srv := newIntegrationController(t)
+	ctx := context.Background()
+
+	capabilities := []*csi.VolumeCapability{{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}}
+	createVolume := func(name, namespace string) (string, error) {
+		resp, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:               name,
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+			VolumeCapabilities: capabilities,
+			Parameters: map[string]string{
+				"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local",
+				VolumeGroupParam: "{{ .PVCNamespace }}-kafka", CSIPVCNamespace: namespace,
+			},
+		})
+		return resp.GetVolume().GetVolumeId(), err
+	}
+
+	var volumeIDs []string
+	for _, name := range []string{"pvc-a", "pvc-b", "pvc-c"} {
+		volumeID, err := createVolume(name, "prod")
+		if err != nil {
+			t.Fatalf("CreateVolume(%s) error = %v", name, err)
+		}
+		if !strings.HasPrefix(volumeID, "tank/prod-kafka/") {
+			t.Fatalf("volume %s = %s, want it in tank/prod-kafka", name, volumeID)
+		}
+		volumeIDs = append(volumeIDs, volumeID)
+	}
+	// The group belongs to the namespace of its first volume
+	if _, err := createVolume("pvc-other", "prod"); err != nil {
+		t.Fatalf("CreateVolume(pvc-other) error = %v", err)
+	}
+
+	req := &csi.CreateVolumeGroupSnapshotRequest{Name: "groupsnap-1", SourceVolumeIds: volumeIDs[:2]}
+	created, err := controller.CreateVolumeGroupSnapshot(ctx, req)
+	if err != nil {
+		t.Fatalf("CreateVolumeGroupSnapshot() error = %v", err)
+	}
+	group := created.GetGroupSnapshot()
+	if group.GetGroupSnapshotId() != "group:tank/prod-kafka@groupsnap-1" || len(group.GetSnapshots()) != 2 {
+		t.Fatalf("group snapshot = %v, want 2 snapshots of tank/prod-kafka", group)
+	}
+	for _, snapshot := range group.GetSnapshots() {
+		if snapshot.GetGroupSnapshotId() != group.GetGroupSnapshotId() || !snapshot.GetReadyToUse() {
+			t.Errorf("member snapshot = %v", snapshot)
+		}
+	}
+	snapshots, err := controller.apiClient.QuerySnapshots(ctx, []interface{}{[]interface{}{"dataset", "=", volumeIDs[2]}})
+	if err != nil || len(snapshots) != 0 {
+		t.Errorf("unrequested volume snapshots = %v, %v; want none", snapshots, err)
+	}
+
+	again, err := controller.CreateVolumeGroupSnapshot(ctx, req)
+	if err != nil || again.GetGroupSnapshot().GetGroupSnapshotId() != group.GetGroupSnapshotId() {
+		t.Fatalf("repeated CreateVolumeGroupSnapshot() = %v, %v", again, err)
+	}
+	got, err := controller.GetVolumeGroupSnapshot(ctx, &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: group.GetGroupSnapshotId()})
+	if err != nil || len(got.GetGroupSnapshot().GetSnapshots()) != 2 {
+		t.Fatalf("GetVolumeGroupSnapshot() = %v, %v; want 2 snapshots", got, err)
+	}
+
+	// Volumes of different groups cannot be snapshotted together
+	if _, err := createVolume("pvc-dev", "dev"); err != nil {
+		t.Fatalf("CreateVolume(pvc-dev) error = %v", err)
+	}
+	_, err = controller.CreateVolumeGroupSnapshot(ctx, &csi.CreateVolumeGroupSnapshotRequest{
+		Name: "groupsnap-2", SourceVolumeIds: []string{volumeIDs[0], "tank/dev-kafka/pvc-dev"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolumeGroupSnapshot() across groups error = %v, want InvalidArgument", err)
+	}
+
+	snapshotIDs := make([]string, 0, len(group.GetSnapshots()))
+	for _, snapshot := range group.GetSnapshots() {
+		snapshotIDs = append(snapshotIDs, snapshot.GetSnapshotId())
+	}
+	if _, err := controller.DeleteVolumeGroupSnapshot(ctx, &csi.DeleteVolumeGroupSnapshotRequest{
+		GroupSnapshotId: group.GetGroupSnapshotId(), SnapshotIds: snapshotIDs,
+	}); err != nil {
+		t.Fatalf("DeleteVolumeGroupSnapshot() error = %v", err)
+	}
+	if _, err := controller.GetVolumeGroupSnapshot(ctx, &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: group.GetGroupSnapshotId()}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetVolumeGroupSnapshot() after delete error = %v, want NotFound", err)
+	}
+
+	// The group dataset goes with its last volume
+	for _, volumeID := range append(volumeIDs, "tank/prod-kafka/pvc-other", "tank/dev-kafka/pvc-dev") {
+		if _, err := controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+			t.Fatalf("DeleteVolume(%s) error = %v", volumeID, err)
+		}
+	}
+	for kind, n := range srv.Counts() {
+		if n != 0 {
+			t.Errorf("%d %s left on TrueNAS after deleting the group", n, kind)
+		}
+	}
+	if group, err := controller.apiClient.Dataset(ctx, "tank/prod-kafka"); err == nil && group != nil {
+		t.Errorf("volume group dataset left: %v", group.ID)
+	}
+}
+
+func TestEnsureVolumeGroupOwnership(t *testing.T) {
+	s := &ControllerService{apiClient: &mockAPIClient{
+		getDatasetWithPropertiesFunc: func(_ context.Context, datasetID string) (*tnsapi.DatasetWithProperties, error) {
+			props := map[string]tnsapi.UserProperty{}
+			if datasetID == "tank/kafka" {
+				props[tnsapi.PropertyVolumeGroup] = tnsapi.UserProperty{Value: "kafka"}
+				props[tnsapi.PropertyVolumeGroupNamespace] = tnsapi.UserProperty{Value: "prod"}
+			}
+			return &tnsapi.DatasetWithProperties{Dataset: tnsapi.Dataset{ID: datasetID}, UserProperties: props}, nil
+		},
+	}}
+	ctx := context.Background()
+
+	if err := s.ensureVolumeGroup(ctx, "tank/kafka", "kafka", "prod"); err != nil {
+		t.Errorf("ensureVolumeGroup() own group error = %v", err)
+	}
+	if err := s.ensureVolumeGroup(ctx, "tank/kafka", "kafka", "dev"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("ensureVolumeGroup() other namespace error = %v, want PermissionDenied", err)
+	}
+	if err := s.ensureVolumeGroup(ctx, "tank/data", "data", "prod"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("ensureVolumeGroup() plain dataset error = %v, want FailedPrecondition", err)
+	}
+}
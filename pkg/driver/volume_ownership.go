@@ -0,0 +1,296 @@
+package driver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Volume ownership and SELinux contexts.
+//
+// Pods that do not run as root cannot write to a new volume whose root directory belongs to
+// root, and on SELinux-enforcing nodes (e.g. OpenShift) containers cannot write to mounts
+// labeled nfs_t or unlabeled_t, whatever their owner. Kubernetes fixes ownership only through
+// fsGroup, which kubelet applies by walking the whole volume (fsGroupPolicy File), and labels
+// only for drivers with seLinuxMount. The node can take care of both when it stages a volume:
+//
+//   - the StorageClass parameters volumeOwner ("<uid>[:<gid>]") and volumeMode (octal, e.g.
+//     "2775") set the owner and mode of the volume's root directory
+//   - with --volume-mount-group the node advertises VOLUME_MOUNT_GROUP, so kubelet passes the
+//     pod's fsGroup (VolumeMountGroup) instead of changing ownership itself, and the node gives
+//     the root directory that group with group rwx and setgid, so new files inherit it
+//   - the StorageClass parameter seLinuxContext mounts the volume with context="<context>"
+//     (e.g. system_u:object_r:container_file_t:s0), labeling every file of NFS, SMB and block
+//     filesystem mounts
+//
+// Only the root directory changes, never existing content; read-only and raw block volumes are
+// left alone. SMB mounts cannot change ownership after mounting and get uid=, gid= and dir_mode=
+// mount options instead. A context=, fscontext=, defcontext= or rootcontext= mount option, such
+// as the one kubelet adds with seLinuxMount, takes precedence over seLinuxContext.
+
+// StorageClass parameters for volume ownership and SELinux labels, also used as their volume
+// context keys.
+const (
+	VolumeOwnerParam    = "volumeOwner"
+	VolumeModeParam     = "volumeMode"
+	SELinuxContextParam = "seLinuxContext"
+)
+
+// mountGroupMode is added to the root directory mode of volumes staged with a VolumeMountGroup:
+// group rwx, and setgid so new files and directories inherit the group.
+const mountGroupMode = 0o2070
+
+// seLinuxMountOptionKeys are the mount options choosing the SELinux label of a mount.
+var seLinuxMountOptionKeys = []string{"context", "fscontext", "defcontext", "rootcontext"}
+
+// volumeOwnership is the root directory ownership requested for a volume. Negative IDs and a
+// zero mode leave the attribute alone.
+type volumeOwnership struct {
+	uid, gid int
+	mode     uint32
+}
+
+// parseVolumeOwner parses a volumeOwner value: "<uid>" or "<uid>:<gid>".
+func parseVolumeOwner(value string) (uid, gid int, err error) {
+	uidPart, gidPart, hasGID := strings.Cut(value, ":")
+	uid, err = strconv.Atoi(uidPart)
+	if err != nil || uid < 0 {
+		return 0, 0, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be <uid> or <uid>:<gid>", VolumeOwnerParam, value)
+	}
+	gid = -1
+	if hasGID {
+		if gid, err = strconv.Atoi(gidPart); err != nil || gid < 0 {
+			return 0, 0, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be <uid> or <uid>:<gid>", VolumeOwnerParam, value)
+		}
+	}
+	return uid, gid, nil
+}
+
+// parseVolumeMode parses a volumeMode value: an octal mode of up to 07777.
+func parseVolumeMode(value string) (uint32, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o7777 {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be an octal mode such as 0775 or 2775", VolumeModeParam, value)
+	}
+	return uint32(mode), nil
+}
+
+// validateSELinuxContext checks that a seLinuxContext value is a full user:role:type:level
+// context that can be passed as a quoted mount option.
+func validateSELinuxContext(value string) error {
+	if strings.Count(value, ":") < 3 || strings.ContainsAny(value, "\" \t\n") {
+		return status.Errorf(codes.InvalidArgument, "invalid %s %q: must be a user:role:type:level context such as system_u:object_r:container_file_t:s0",
+			SELinuxContextParam, value)
+	}
+	return nil
+}
+
+// volumeOwnershipVolumeContext validates the ownership and SELinux parameters of a CreateVolume
+// request and returns the volume context entries of the volume (nil if none are set).
+func volumeOwnershipVolumeContext(params map[string]string) (map[string]string, error) {
+	volumeContext := make(map[string]string)
+	if owner := strings.TrimSpace(params[VolumeOwnerParam]); owner != "" {
+		if _, _, err := parseVolumeOwner(owner); err != nil {
+			return nil, err
+		}
+		volumeContext[VolumeOwnerParam] = owner
+	}
+	if mode := strings.TrimSpace(params[VolumeModeParam]); mode != "" {
+		if _, err := parseVolumeMode(mode); err != nil {
+			return nil, err
+		}
+		volumeContext[VolumeModeParam] = mode
+	}
+	if seLinuxContext := strings.TrimSpace(params[SELinuxContextParam]); seLinuxContext != "" {
+		if err := validateSELinuxContext(seLinuxContext); err != nil {
+			return nil, err
+		}
+		volumeContext[SELinuxContextParam] = seLinuxContext
+	}
+	if len(volumeContext) == 0 {
+		return nil, nil
+	}
+	return volumeContext, nil
+}
+
+// requestedOwnership returns the root directory ownership of a volume from its volume context
+// and the VolumeMountGroup of its capability, which takes precedence over the volumeOwner group.
+// ok is false if nothing is requested.
+func requestedOwnership(volumeContext map[string]string, capability *csi.VolumeCapability) (ownership volumeOwnership, ok bool, err error) {
+	ownership = volumeOwnership{uid: -1, gid: -1}
+	if owner := volumeContext[VolumeOwnerParam]; owner != "" {
+		if ownership.uid, ownership.gid, err = parseVolumeOwner(owner); err != nil {
+			return ownership, false, err
+		}
+		ok = true
+	}
+	if mode := volumeContext[VolumeModeParam]; mode != "" {
+		if ownership.mode, err = parseVolumeMode(mode); err != nil {
+			return ownership, false, err
+		}
+		ok = true
+	}
+	if group := capability.GetMount().GetVolumeMountGroup(); group != "" {
+		gid, convErr := strconv.Atoi(group)
+		if convErr != nil || gid < 0 {
+			return ownership, false, status.Errorf(codes.InvalidArgument, "invalid volume mount group %q", group)
+		}
+		ownership.gid = gid
+		ok = true
+	}
+	return ownership, ok, nil
+}
+
+// stagedVolumeRoot returns the root directory of a staged filesystem volume: the staging path,
+// or the volume's subdirectory of a shared NFS export.
+func stagedVolumeRoot(stagingTargetPath string, volumeContext map[string]string) string {
+	if subPath := volumeContext[VolumeContextKeySubPath]; subPath != "" && filepath.IsLocal(subPath) {
+		return filepath.Join(stagingTargetPath, subPath)
+	}
+	return stagingTargetPath
+}
+
+// applyVolumeOwnership sets the owner and mode of the root directory of a staged volume as its
+// volume context and VolumeMountGroup request. No-op for raw block, read-only and SMB volumes,
+// and on Windows nodes, whose volumes have ACLs instead of owners and modes.
+func applyVolumeOwnership(req *csi.NodeStageVolumeRequest, volumeContext map[string]string, protocol string) error {
+	capability := req.GetVolumeCapability()
+	if capability.GetBlock() != nil || protocol == ProtocolSMB || runtime.GOOS == goosWindows ||
+		isReadOnlyVolumeContext(volumeContext) || isReadOnlyAccessMode(capability.GetAccessMode().GetMode()) {
+		return nil
+	}
+	ownership, ok, err := requestedOwnership(volumeContext, capability)
+	if err != nil || !ok {
+		return err
+	}
+	if capability.GetMount().GetVolumeMountGroup() != "" {
+		ownership.mode |= mountGroupMode
+	}
+
+	root := stagedVolumeRoot(req.GetStagingTargetPath(), volumeContext)
+	if err := setDirOwnership(root, ownership); err != nil {
+		if errors.Is(err, os.ErrPermission) && protocol == ProtocolNFS {
+			return status.Errorf(codes.FailedPrecondition,
+				"cannot set the ownership of volume %s: %v (the NFS export maps root to an unprivileged user; set nfs.mapallUser or the share's maproot)", req.GetVolumeId(), err)
+		}
+		return status.Errorf(codes.Internal, "cannot set the ownership of volume %s: %v", req.GetVolumeId(), err)
+	}
+	return nil
+}
+
+// setDirOwnership changes the owner, group and mode of a directory where they differ from the
+// requested ones. A mode of just mountGroupMode (a VolumeMountGroup without volumeMode) is added
+// to the existing permissions; other modes replace them.
+func setDirOwnership(path string, ownership volumeOwnership) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	uid, gid := -1, -1
+	if owner, group, ok := fileOwner(info); ok {
+		if ownership.uid >= 0 && owner != ownership.uid {
+			uid = ownership.uid
+		}
+		if ownership.gid >= 0 && group != ownership.gid {
+			gid = ownership.gid
+		}
+	}
+	if uid >= 0 || gid >= 0 {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+		klog.Infof("Changed owner of volume root %s to %d:%d", path, ownership.uid, ownership.gid)
+	}
+
+	if ownership.mode == 0 {
+		return nil
+	}
+	current := info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	want := fileMode(ownership.mode)
+	if ownership.mode == mountGroupMode {
+		want |= current
+	}
+	if want == current {
+		return nil
+	}
+	if err := os.Chmod(path, want); err != nil {
+		return err
+	}
+	klog.Infof("Changed mode of volume root %s to %s", path, want)
+	return nil
+}
+
+// fileMode converts an octal Unix mode to an os.FileMode.
+func fileMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode) & os.ModePerm
+	if mode&0o4000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// smbOwnershipMountOptions adds the uid=, gid= and dir_mode= options carrying the requested
+// ownership of an SMB volume to its StorageClass mount options, unless they set them already.
+func smbOwnershipMountOptions(options []string, volumeContext map[string]string, capability *csi.VolumeCapability) ([]string, error) {
+	ownership, ok, err := requestedOwnership(volumeContext, capability)
+	if err != nil || !ok {
+		return options, err
+	}
+	var extra []string
+	if ownership.uid >= 0 {
+		extra = append(extra, "uid="+strconv.Itoa(ownership.uid))
+	}
+	if ownership.gid >= 0 {
+		extra = append(extra, "gid="+strconv.Itoa(ownership.gid))
+	}
+	if ownership.mode != 0 {
+		extra = append(extra, "dir_mode=0"+strconv.FormatUint(uint64(ownership.mode), 8))
+	}
+	return addMissingMountOptions(options, extra...), nil
+}
+
+// seLinuxMountOptions adds context="<seLinuxContext>" to the mount options of a volume with a
+// seLinuxContext, unless they choose an SELinux label already.
+func seLinuxMountOptions(options []string, volumeContext map[string]string) []string {
+	seLinuxContext := volumeContext[SELinuxContextParam]
+	if seLinuxContext == "" {
+		return options
+	}
+	for _, opt := range options {
+		for _, key := range seLinuxMountOptionKeys {
+			if strings.HasPrefix(opt, key+"=") {
+				return options
+			}
+		}
+	}
+	return append(append([]string(nil), options...), `context="`+seLinuxContext+`"`)
+}
+
+// addMissingMountOptions appends the options whose key is not in options yet.
+func addMissingMountOptions(options []string, extra ...string) []string {
+	keys := make(map[string]bool, len(options))
+	for _, opt := range options {
+		keys[extractOptionKey(opt)] = true
+	}
+	result := append([]string(nil), options...)
+	for _, opt := range extra {
+		if !keys[extractOptionKey(opt)] {
+			result = append(result, opt)
+		}
+	}
+	return result
+}
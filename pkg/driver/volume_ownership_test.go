@@ -0,0 +1,186 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func mountCapability(group string, mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{VolumeMountGroup: group}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}
+}
+
+func TestVolumeOwnershipVolumeContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "none", params: map[string]string{"protocol": ProtocolNFS}},
+		{
+			name:   "all",
+			params: map[string]string{VolumeOwnerParam: "1000:2000", VolumeModeParam: "2775", SELinuxContextParam: "system_u:object_r:container_file_t:s0:c1,c2"},
+			want:   map[string]string{VolumeOwnerParam: "1000:2000", VolumeModeParam: "2775", SELinuxContextParam: "system_u:object_r:container_file_t:s0:c1,c2"},
+		},
+		{name: "uid only", params: map[string]string{VolumeOwnerParam: "1000"}, want: map[string]string{VolumeOwnerParam: "1000"}},
+		{name: "negative uid", params: map[string]string{VolumeOwnerParam: "-1:0"}, wantErr: true},
+		{name: "named owner", params: map[string]string{VolumeOwnerParam: "postgres"}, wantErr: true},
+		{name: "decimal mode", params: map[string]string{VolumeModeParam: "999"}, wantErr: true},
+		{name: "mode too large", params: map[string]string{VolumeModeParam: "17777"}, wantErr: true},
+		{name: "type only", params: map[string]string{SELinuxContextParam: "container_file_t"}, wantErr: true},
+		{name: "quoted context", params: map[string]string{SELinuxContextParam: `system_u:object_r:container_file_t:s0"`}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := volumeOwnershipVolumeContext(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("volumeOwnershipVolumeContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("volumeOwnershipVolumeContext() = %v, want %v", got, tt.want)
+			}
+			for key, value := range tt.want {
+				if got[key] != value {
+					t.Errorf("volumeOwnershipVolumeContext()[%s] = %q, want %q", key, got[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyVolumeOwnership(t *testing.T) {
+	uid, gid := os.Getuid(), os.Getgid()
+	rwo := csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER
+	tests := []struct {
+		name          string
+		volumeContext map[string]string
+		capability    *csi.VolumeCapability
+		protocol      string
+		subPath       string
+		wantMode      os.FileMode
+	}{
+		{
+			name:          "owner and mode",
+			volumeContext: map[string]string{VolumeOwnerParam: strconv.Itoa(uid) + ":" + strconv.Itoa(gid), VolumeModeParam: "2775"},
+			capability:    mountCapability("", rwo),
+			protocol:      ProtocolNVMeOF,
+			wantMode:      0o775 | os.ModeSetgid,
+		},
+		{
+			name:       "mount group",
+			capability: mountCapability(strconv.Itoa(gid), rwo),
+			protocol:   ProtocolNFS,
+			wantMode:   0o750 | 0o070 | os.ModeSetgid,
+		},
+		{
+			name:          "shared export subdirectory",
+			volumeContext: map[string]string{VolumeModeParam: "0777", VolumeContextKeySubPath: "pvc-1"},
+			capability:    mountCapability("", rwo),
+			protocol:      ProtocolNFS,
+			subPath:       "pvc-1",
+			wantMode:      0o777,
+		},
+		{
+			name:          "read-only",
+			volumeContext: map[string]string{VolumeModeParam: "0777"},
+			capability:    mountCapability("", csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY),
+			protocol:      ProtocolNFS,
+			wantMode:      0o750,
+		},
+		{
+			name:          "smb uses mount options",
+			volumeContext: map[string]string{VolumeModeParam: "0777"},
+			capability:    mountCapability("", rwo),
+			protocol:      ProtocolSMB,
+			wantMode:      0o750,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			staging := t.TempDir()
+			root := filepath.Join(staging, tt.subPath)
+			if err := os.MkdirAll(root, 0o750); err != nil {
+				t.Fatal(err)
+			}
+			// Clear bits a umask or setgid parent may have added
+			if err := os.Chmod(root, 0o750); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(staging, 0o750); err != nil {
+				t.Fatal(err)
+			}
+
+			req := &csi.NodeStageVolumeRequest{VolumeId: "vol", StagingTargetPath: staging, VolumeCapability: tt.capability}
+			if err := applyVolumeOwnership(req, tt.volumeContext, tt.protocol); err != nil {
+				t.Fatalf("applyVolumeOwnership() error = %v", err)
+			}
+			info, err := os.Stat(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode() & (os.ModePerm | os.ModeSetgid); got != tt.wantMode {
+				t.Errorf("mode of %s = %s, want %s", root, got, tt.wantMode)
+			}
+			if tt.subPath != "" {
+				if info, _ := os.Stat(staging); info.Mode().Perm() != 0o750 {
+					t.Errorf("shared export root mode = %s, want it unchanged", info.Mode())
+				}
+			}
+		})
+	}
+}
+
+func TestOwnershipMountOptions(t *testing.T) {
+	seLinux := map[string]string{SELinuxContextParam: "system_u:object_r:container_file_t:s0:c1,c2"}
+	if got, want := seLinuxMountOptions([]string{"vers=4.2"}, seLinux), []string{"vers=4.2", `context="system_u:object_r:container_file_t:s0:c1,c2"`}; !slices.Equal(got, want) {
+		t.Errorf("seLinuxMountOptions() = %v, want %v", got, want)
+	}
+	kubelet := []string{`context="system_u:object_r:container_file_t:s0:c5,c6"`}
+	if got := seLinuxMountOptions(kubelet, seLinux); !slices.Equal(got, kubelet) {
+		t.Errorf("seLinuxMountOptions() with a context option = %v, want it kept", got)
+	}
+
+	owner := map[string]string{VolumeOwnerParam: "1000:1000", VolumeModeParam: "0770"}
+	got, err := smbOwnershipMountOptions([]string{"gid=3000"}, owner, mountCapability("", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER))
+	if err != nil {
+		t.Fatalf("smbOwnershipMountOptions() error = %v", err)
+	}
+	if want := []string{"gid=3000", "uid=1000", "dir_mode=0770"}; !slices.Equal(got, want) {
+		t.Errorf("smbOwnershipMountOptions() = %v, want %v", got, want)
+	}
+	got, err = smbOwnershipMountOptions(nil, nil, mountCapability("4000", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER))
+	if err != nil || !slices.Equal(got, []string{"gid=4000"}) {
+		t.Errorf("smbOwnershipMountOptions() with a mount group = %v, %v; want [gid=4000]", got, err)
+	}
+}
+
+func TestNodeGetCapabilitiesVolumeMountGroup(t *testing.T) {
+	hasMountGroup := func(service *NodeService) bool {
+		t.Helper()
+		resp, err := service.NodeGetCapabilities(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("NodeGetCapabilities() error = %v", err)
+		}
+		return slices.ContainsFunc(resp.GetCapabilities(), func(c *csi.NodeServiceCapability) bool {
+			return c.GetRpc().GetType() == csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP
+		})
+	}
+
+	service := NewNodeService("test-node", nil, true, nil, false, 5)
+	if hasMountGroup(service) {
+		t.Error("NodeGetCapabilities() advertises VOLUME_MOUNT_GROUP by default")
+	}
+	service.volumeMountGroup = true
+	if !hasMountGroup(service) {
+		t.Error("NodeGetCapabilities() does not advertise VOLUME_MOUNT_GROUP with --volume-mount-group")
+	}
+}
@@ -0,0 +1,372 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// Volume populator.
+//
+// A PVC opts in to prepopulation with a dataSourceRef to a DatasetPopulator naming a "golden"
+// dataset on the storage system, e.g. a base image or a seeded database directory:
+//
+//	apiVersion: tns.csi.io/v1alpha1
+//	kind: DatasetPopulator
+//	metadata: {name: postgres-seed, namespace: app}
+//	spec: {dataset: tank/golden/postgres-seed}
+//	---
+//	kind: PersistentVolumeClaim
+//	spec:
+//	  dataSourceRef: {apiGroup: tns.csi.io, kind: DatasetPopulator, name: postgres-seed}
+//
+// The external-provisioner leaves claims with such a data source alone. With
+// --volume-populator-sources the controller provisions them the way Kubernetes volume populators
+// do: it creates a "prime" PVC in --volume-populator-namespace with the claim's StorageClass,
+// size, modes and selected node, and the source dataset in an annotation. CreateVolume of the
+// prime PVC clones the source (createVolumeFromPopulator), so the data is in place before the
+// volume exists. Once the prime PVC is bound, its PV is handed over to the claim and the prime
+// PVC is deleted. Prime PVCs whose claim disappeared are deleted with their volume.
+//
+// Sources must be one of the --volume-populator-sources datasets or below one, so namespaces can
+// only copy data the administrator published.
+
+// Volume populator settings.
+const (
+	// DatasetPopulatorKind is the kind of the populator objects PVCs refer to in dataSourceRef.
+	DatasetPopulatorKind = "DatasetPopulator"
+
+	// volumePopulatorInterval is how often pending claims are looked for.
+	volumePopulatorInterval = 10 * time.Second
+
+	// populatorPrimePrefix prefixes the names of prime PVCs, followed by the claim's UID.
+	populatorPrimePrefix = "tns-csi-populate-"
+
+	// populatorSourceAnnotation holds the source dataset of a prime PVC.
+	populatorSourceAnnotation = "tns.csi.io/populate-from"
+
+	// populatorClaimAnnotation holds the namespace/name of the claim a prime PVC provisions for.
+	populatorClaimAnnotation = "tns.csi.io/populated-claim"
+
+	// populatorClaimUIDLabel holds the UID of the claim a prime PVC provisions for.
+	populatorClaimUIDLabel = "tns.csi.io/populated-claim-uid"
+
+	// annSelectedNode is the node the scheduler picked for a WaitForFirstConsumer claim.
+	annSelectedNode = "volume.kubernetes.io/selected-node"
+
+	volumePopulatorComponent = "tns-csi-volume-populator"
+)
+
+// datasetPopulatorGVR identifies DatasetPopulator objects.
+var datasetPopulatorGVR = schema.GroupVersionResource{
+	Group: TNSVolumeGroup, Version: TNSVolumeVersion, Resource: "datasetpopulators",
+}
+
+// errPopulatorNamespaceRequired is returned when populator sources are configured without a
+// namespace for the prime PVCs.
+var errPopulatorNamespaceRequired = errors.New("--volume-populator-sources requires --volume-populator-namespace")
+
+// volumePopulator provisions PVCs whose dataSourceRef is a DatasetPopulator. A nil
+// *volumePopulator is valid and populates nothing.
+type volumePopulator struct {
+	kube       kubernetes.Interface
+	dyn        dynamic.Interface
+	recorder   record.EventRecorder
+	driverName string
+	namespace  string   // Namespace of the prime PVCs
+	sources    []string // Datasets sources must be or be below
+}
+
+// parsePopulatorSources parses the comma-separated --volume-populator-sources list.
+func parsePopulatorSources(value string) []string {
+	var sources []string
+	for _, source := range strings.Split(value, ",") {
+		if source = strings.Trim(strings.TrimSpace(source), "/"); source != "" {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// newVolumePopulator creates the volume populator using the in-cluster Kubernetes API.
+func newVolumePopulator(driverName, namespace string, sources []string) (*volumePopulator, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+	}
+	kube, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic Kubernetes client: %w", err)
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kube.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: volumePopulatorComponent})
+
+	return &volumePopulator{
+		kube:       kube,
+		dyn:        dyn,
+		recorder:   recorder,
+		driverName: driverName,
+		namespace:  namespace,
+		sources:    sources,
+	}, nil
+}
+
+// allowed reports whether dataset is one of the configured sources or below one.
+func (p *volumePopulator) allowed(dataset string) bool {
+	for _, source := range p.sources {
+		if dataset == source || strings.HasPrefix(dataset, source+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceFor returns the populator source of a CreateVolume request, or nil if it does not
+// provision a prime PVC. It needs the PVC parameters of --extra-create-metadata.
+func (p *volumePopulator) sourceFor(ctx context.Context, req *csi.CreateVolumeRequest) (*populatorSource, error) {
+	params := req.GetParameters()
+	name := params[CSIPVCName]
+	if p == nil || params[CSIPVCNamespace] != p.namespace || !strings.HasPrefix(name, populatorPrimePrefix) {
+		return nil, nil //nolint:nilnil // nil source means "not a populated volume"
+	}
+	prime, err := p.kube.CoreV1().PersistentVolumeClaims(p.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "Failed to read populator claim %s/%s: %v", p.namespace, name, err)
+	}
+	dataset, ok := prime.Annotations[populatorSourceAnnotation]
+	if !ok {
+		return nil, nil //nolint:nilnil // not created by the populator
+	}
+	if !p.allowed(dataset) {
+		return nil, status.Errorf(codes.PermissionDenied, "Populator source %s is not below --volume-populator-sources", dataset)
+	}
+	return &populatorSource{scheme: populatorSchemeDataset, ref: dataset}, nil
+}
+
+// run provisions pending populated claims until stopCh is closed.
+func (p *volumePopulator) run(stopCh <-chan struct{}) {
+	klog.Infof("Volume populator enabled: %s sources below %s, prime PVCs in namespace %s",
+		DatasetPopulatorKind, strings.Join(p.sources, ", "), p.namespace)
+
+	ticker := time.NewTicker(volumePopulatorInterval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), volumePopulatorInterval)
+		p.reconcile(ctx)
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// isPopulatedClaim reports whether a PVC refers to a DatasetPopulator.
+func isPopulatedClaim(pvc *corev1.PersistentVolumeClaim) bool {
+	ref := pvc.Spec.DataSourceRef
+	return ref != nil && ref.APIGroup != nil && *ref.APIGroup == TNSVolumeGroup && ref.Kind == DatasetPopulatorKind
+}
+
+// primeName returns the name of the prime PVC of a claim.
+func primeName(pvc *corev1.PersistentVolumeClaim) string {
+	return populatorPrimePrefix + string(pvc.UID)
+}
+
+// reconcile advances every populated claim one step and deletes prime PVCs no longer needed.
+func (p *volumePopulator) reconcile(ctx context.Context) {
+	pvcs, err := p.kube.CoreV1().PersistentVolumeClaims("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("Volume populator: failed to list PVCs: %v", err)
+		return
+	}
+	claims := make(map[types.UID]*corev1.PersistentVolumeClaim)
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if !isPopulatedClaim(pvc) || pvc.DeletionTimestamp != nil {
+			continue
+		}
+		claims[pvc.UID] = pvc
+		if err := p.populate(ctx, pvc); err != nil {
+			klog.Warningf("Volume populator: PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+		}
+	}
+
+	// Prime PVCs of deleted claims, or of claims that own their PV by now
+	for i := range pvcs.Items {
+		prime := &pvcs.Items[i]
+		uid, ok := prime.Labels[populatorClaimUIDLabel]
+		if !ok || prime.Namespace != p.namespace || prime.DeletionTimestamp != nil {
+			continue
+		}
+		if claim := claims[types.UID(uid)]; claim != nil && claim.Spec.VolumeName == "" {
+			continue
+		}
+		if err := p.kube.CoreV1().PersistentVolumeClaims(p.namespace).Delete(ctx, prime.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			klog.Warningf("Volume populator: failed to delete prime PVC %s/%s: %v", p.namespace, prime.Name, err)
+			continue
+		}
+		klog.Infof("Volume populator: deleted prime PVC %s/%s of %s", p.namespace, prime.Name, prime.Annotations[populatorClaimAnnotation])
+	}
+}
+
+// populate advances one claim: create its prime PVC, then hand the bound prime PV over to it.
+func (p *volumePopulator) populate(ctx context.Context, pvc *corev1.PersistentVolumeClaim) error {
+	if pvc.Spec.VolumeName != "" {
+		return nil
+	}
+	class, err := p.storageClass(ctx, pvc)
+	if err != nil || class == nil {
+		return err
+	}
+	if class.VolumeBindingMode != nil && *class.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer && pvc.Annotations[annSelectedNode] == "" {
+		return nil // Wait for the scheduler to pick a node
+	}
+
+	prime, err := p.kube.CoreV1().PersistentVolumeClaims(p.namespace).Get(ctx, primeName(pvc), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return p.createPrime(ctx, pvc)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read prime PVC: %w", err)
+	}
+	if prime.Spec.VolumeName == "" {
+		return nil // Still provisioning
+	}
+
+	pv, err := p.kube.CoreV1().PersistentVolumes().Get(ctx, prime.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read PV %s of the prime PVC: %w", prime.Spec.VolumeName, err)
+	}
+	if ref := pv.Spec.ClaimRef; ref != nil && ref.UID != prime.UID {
+		return nil // Already handed over; the PV controller binds the claim
+	}
+	return p.rebind(ctx, pv, pvc)
+}
+
+// storageClass returns the StorageClass of a claim, or nil if it is not provisioned by this driver.
+func (p *volumePopulator) storageClass(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (*storagev1.StorageClass, error) {
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return nil, nil //nolint:nilnil // no class, not ours to provision
+	}
+	class, err := p.kube.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read StorageClass %s: %w", *pvc.Spec.StorageClassName, err)
+	}
+	if class.Provisioner != p.driverName {
+		p.recorder.Eventf(pvc, corev1.EventTypeWarning, "PopulatorUnsupported",
+			"%s sources can only populate volumes of %s StorageClasses", DatasetPopulatorKind, p.driverName)
+		return nil, nil //nolint:nilnil // another driver's class
+	}
+	return class, nil
+}
+
+// createPrime creates the prime PVC of a claim once its DatasetPopulator names an allowed source.
+func (p *volumePopulator) createPrime(ctx context.Context, pvc *corev1.PersistentVolumeClaim) error {
+	dataset, err := p.populatorDataset(ctx, pvc)
+	if err != nil {
+		p.recorder.Event(pvc, corev1.EventTypeWarning, "PopulatorSourceInvalid", err.Error())
+		return err
+	}
+
+	annotations := map[string]string{
+		populatorSourceAnnotation: dataset,
+		populatorClaimAnnotation:  pvc.Namespace + "/" + pvc.Name,
+	}
+	if node := pvc.Annotations[annSelectedNode]; node != "" {
+		annotations[annSelectedNode] = node
+	}
+	prime := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        primeName(pvc),
+			Namespace:   p.namespace,
+			Labels:      map[string]string{populatorClaimUIDLabel: string(pvc.UID)},
+			Annotations: annotations,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      pvc.Spec.AccessModes,
+			Resources:        pvc.Spec.Resources,
+			StorageClassName: pvc.Spec.StorageClassName,
+			VolumeMode:       pvc.Spec.VolumeMode,
+		},
+	}
+	if _, err := p.kube.CoreV1().PersistentVolumeClaims(p.namespace).Create(ctx, prime, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create prime PVC: %w", err)
+	}
+	klog.Infof("Volume populator: populating PVC %s/%s from %s (prime PVC %s/%s)", pvc.Namespace, pvc.Name, dataset, p.namespace, prime.Name)
+	p.recorder.Eventf(pvc, corev1.EventTypeNormal, "Populating", "Populating volume from dataset %s", dataset)
+	return nil
+}
+
+// populatorDataset returns the source dataset of the DatasetPopulator a claim refers to.
+func (p *volumePopulator) populatorDataset(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (string, error) {
+	name := pvc.Spec.DataSourceRef.Name
+	obj, err := p.dyn.Resource(datasetPopulatorGVR).Namespace(pvc.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s %s/%s: %w", DatasetPopulatorKind, pvc.Namespace, name, err)
+	}
+	spec, _ := obj.Object["spec"].(map[string]interface{})
+	dataset, _ := spec["dataset"].(string)
+	dataset = strings.Trim(dataset, "/")
+	switch {
+	case dataset == "" || strings.Contains(dataset, "@"):
+		return "", fmt.Errorf("%s %s/%s: spec.dataset %q is not a dataset path", DatasetPopulatorKind, pvc.Namespace, name, dataset)
+	case !p.allowed(dataset):
+		return "", fmt.Errorf("%s %s/%s: dataset %s is not below the allowed populator sources (%s)",
+			DatasetPopulatorKind, pvc.Namespace, name, dataset, strings.Join(p.sources, ", "))
+	}
+	return dataset, nil
+}
+
+// rebind hands the PV of a prime PVC over to the claim it was provisioned for.
+func (p *volumePopulator) rebind(ctx context.Context, pv *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"claimRef": map[string]interface{}{
+				"apiVersion":      "v1",
+				"kind":            "PersistentVolumeClaim",
+				"namespace":       pvc.Namespace,
+				"name":            pvc.Name,
+				"uid":             string(pvc.UID),
+				"resourceVersion": pvc.ResourceVersion,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode PV patch: %w", err)
+	}
+	if _, err := p.kube.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to hand PV %s over: %w", pv.Name, err)
+	}
+	klog.Infof("Volume populator: PV %s populated for PVC %s/%s", pv.Name, pvc.Namespace, pvc.Name)
+	p.recorder.Eventf(pvc, corev1.EventTypeNormal, "Populated", "Volume %s populated", pv.Name)
+	return nil
+}
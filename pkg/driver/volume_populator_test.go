@@ -0,0 +1,224 @@
+package driver
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+const testPopulatorNamespace = "kube-system"
+
+func testDatasetPopulator(name, dataset string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": TNSVolumeGroup + "/" + TNSVolumeVersion,
+		"kind":       DatasetPopulatorKind,
+		"metadata":   map[string]interface{}{"name": name, "namespace": "apps"},
+		"spec":       map[string]interface{}{"dataset": dataset},
+	}}
+}
+
+func testPopulatedClaim(populator string) *corev1.PersistentVolumeClaim {
+	group := TNSVolumeGroup
+	class := "tns-nfs"
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "apps", UID: "claim-uid", ResourceVersion: "7"},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			StorageClassName: &class,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+			DataSourceRef: &corev1.TypedObjectReference{APIGroup: &group, Kind: DatasetPopulatorKind, Name: populator},
+		},
+	}
+}
+
+func newTestVolumePopulator(objects ...runtime.Object) *volumePopulator {
+	class := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "tns-nfs"}, Provisioner: testDriverName}
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{datasetPopulatorGVR: DatasetPopulatorKind + "List"},
+		testDatasetPopulator("seed", "tank/golden/postgres"),
+		testDatasetPopulator("private", "tank/k8s/other-tenant"),
+	)
+	return &volumePopulator{
+		kube:       fake.NewSimpleClientset(append(objects, class)...),
+		dyn:        dyn,
+		recorder:   record.NewFakeRecorder(10),
+		driverName: testDriverName,
+		namespace:  testPopulatorNamespace,
+		sources:    []string{"tank/golden"},
+	}
+}
+
+func TestParsePopulatorSources(t *testing.T) {
+	got := parsePopulatorSources(" tank/golden/ ,,pool2/seeds")
+	if want := []string{"tank/golden", "pool2/seeds"}; !slices.Equal(got, want) {
+		t.Errorf("parsePopulatorSources() = %v, want %v", got, want)
+	}
+	p := &volumePopulator{sources: got}
+	for dataset, want := range map[string]bool{
+		"tank/golden":          true,
+		"tank/golden/postgres": true,
+		"tank/goldenrod":       false,
+		"tank/k8s/pvc-1":       false,
+	} {
+		if p.allowed(dataset) != want {
+			t.Errorf("allowed(%q) = %v, want %v", dataset, !want, want)
+		}
+	}
+}
+
+func TestVolumePopulatorReconcile(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("creates the prime PVC", func(t *testing.T) {
+		claim := testPopulatedClaim("seed")
+		p := newTestVolumePopulator(claim)
+		p.reconcile(ctx)
+
+		prime, err := p.kube.CoreV1().PersistentVolumeClaims(testPopulatorNamespace).Get(ctx, populatorPrimePrefix+"claim-uid", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("prime PVC not created: %v", err)
+		}
+		if prime.Annotations[populatorSourceAnnotation] != "tank/golden/postgres" || prime.Labels[populatorClaimUIDLabel] != "claim-uid" {
+			t.Errorf("prime PVC metadata = %v / %v", prime.Annotations, prime.Labels)
+		}
+		if *prime.Spec.StorageClassName != "tns-nfs" || prime.Spec.DataSourceRef != nil {
+			t.Errorf("prime PVC spec = %+v", prime.Spec)
+		}
+	})
+
+	t.Run("refuses sources outside the allowed datasets", func(t *testing.T) {
+		p := newTestVolumePopulator(testPopulatedClaim("private"))
+		p.reconcile(ctx)
+
+		primes, _ := p.kube.CoreV1().PersistentVolumeClaims(testPopulatorNamespace).List(ctx, metav1.ListOptions{})
+		if len(primes.Items) != 0 {
+			t.Errorf("prime PVC created for a source outside --volume-populator-sources: %v", primes.Items)
+		}
+	})
+
+	t.Run("waits for the selected node", func(t *testing.T) {
+		wait := storagev1.VolumeBindingWaitForFirstConsumer
+		class := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "tns-wffc"}, Provisioner: testDriverName, VolumeBindingMode: &wait}
+		claim := testPopulatedClaim("seed")
+		claim.Spec.StorageClassName = &class.Name
+		p := newTestVolumePopulator(claim, class)
+		p.reconcile(ctx)
+
+		primes, _ := p.kube.CoreV1().PersistentVolumeClaims(testPopulatorNamespace).List(ctx, metav1.ListOptions{})
+		if len(primes.Items) != 0 {
+			t.Errorf("prime PVC created before a node was selected")
+		}
+	})
+
+	t.Run("hands the bound PV over and deletes the prime PVC", func(t *testing.T) {
+		claim := testPopulatedClaim("seed")
+		prime := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: populatorPrimePrefix + "claim-uid", Namespace: testPopulatorNamespace, UID: "prime-uid",
+				Labels: map[string]string{populatorClaimUIDLabel: "claim-uid"},
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{VolumeName: "pvc-prime"},
+		}
+		pv := &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc-prime"},
+			Spec: corev1.PersistentVolumeSpec{
+				ClaimRef: &corev1.ObjectReference{Namespace: testPopulatorNamespace, Name: prime.Name, UID: prime.UID},
+			},
+		}
+		p := newTestVolumePopulator(claim, prime, pv)
+		p.reconcile(ctx)
+
+		got, err := p.kube.CoreV1().PersistentVolumes().Get(ctx, "pvc-prime", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref := got.Spec.ClaimRef; ref.Namespace != "apps" || ref.Name != "data" || ref.UID != types.UID("claim-uid") {
+			t.Fatalf("PV claimRef = %+v, want apps/data", ref)
+		}
+
+		// The PV controller binds the claim; the prime PVC is then deleted
+		claim.Spec.VolumeName = "pvc-prime"
+		if _, err := p.kube.CoreV1().PersistentVolumeClaims("apps").Update(ctx, claim, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		p.reconcile(ctx)
+		if _, err := p.kube.CoreV1().PersistentVolumeClaims(testPopulatorNamespace).Get(ctx, prime.Name, metav1.GetOptions{}); err == nil {
+			t.Error("prime PVC not deleted after the claim was bound")
+		}
+	})
+
+	t.Run("deletes the prime PVC of a deleted claim", func(t *testing.T) {
+		prime := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name: populatorPrimePrefix + "gone", Namespace: testPopulatorNamespace,
+			Labels: map[string]string{populatorClaimUIDLabel: "gone"},
+		}}
+		p := newTestVolumePopulator(prime)
+		p.reconcile(ctx)
+		if _, err := p.kube.CoreV1().PersistentVolumeClaims(testPopulatorNamespace).Get(ctx, prime.Name, metav1.GetOptions{}); err == nil {
+			t.Error("prime PVC of a deleted claim not deleted")
+		}
+	})
+}
+
+func TestVolumePopulatorSourceFor(t *testing.T) {
+	ctx := context.Background()
+	prime := func(name, dataset string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: testPopulatorNamespace,
+			Annotations: map[string]string{populatorSourceAnnotation: dataset},
+		}}
+	}
+	p := newTestVolumePopulator(
+		prime(populatorPrimePrefix+"ok", "tank/golden/postgres"),
+		prime(populatorPrimePrefix+"tampered", "tank/k8s/other-tenant"),
+	)
+	request := func(namespace, name string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{Name: "pvc-1", Parameters: map[string]string{CSIPVCNamespace: namespace, CSIPVCName: name}}
+	}
+
+	tests := []struct {
+		populator *volumePopulator
+		req       *csi.CreateVolumeRequest
+		name      string
+		wantRef   string
+		wantCode  codes.Code
+	}{
+		{name: "prime PVC", populator: p, req: request(testPopulatorNamespace, populatorPrimePrefix+"ok"), wantRef: "tank/golden/postgres"},
+		{name: "source outside the allowed datasets", populator: p, req: request(testPopulatorNamespace, populatorPrimePrefix+"tampered"), wantCode: codes.PermissionDenied},
+		{name: "prefix in another namespace", populator: p, req: request("apps", populatorPrimePrefix+"ok")},
+		{name: "regular PVC", populator: p, req: request(testPopulatorNamespace, "data")},
+		{name: "populator disabled", req: request(testPopulatorNamespace, populatorPrimePrefix+"ok")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := tt.populator.sourceFor(ctx, tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("sourceFor() error = %v, want %v", err, tt.wantCode)
+			}
+			var ref string
+			if source != nil {
+				ref = source.ref
+			}
+			if ref != tt.wantRef {
+				t.Errorf("sourceFor() = %q, want %q", ref, tt.wantRef)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/metrics"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"k8s.io/klog/v2"
+)
+
+// Volume statistics exporter.
+//
+// Kubelet volume stats only cover mounted filesystem volumes and say nothing about ZFS:
+// snapshots, compression and block volumes are invisible to them. With --volume-stats-interval
+// the controller periodically exports the ZFS usage of every volume of this cluster, labeled
+// with its PV, PVC namespace and StorageClass. Each pass costs two queries, however many
+// volumes exist: one for all managed datasets and one for all snapshot IDs.
+
+// volumeStatsExporter publishes per-volume ZFS statistics as metrics.
+type volumeStatsExporter struct {
+	apiClient tnsapi.ClientInterface
+	exported  map[string]bool // volume IDs with exported metrics
+	clusterID string
+	interval  time.Duration
+}
+
+// newVolumeStatsExporter creates a volume statistics exporter.
+func newVolumeStatsExporter(apiClient tnsapi.ClientInterface, clusterID string, interval time.Duration) *volumeStatsExporter {
+	return &volumeStatsExporter{
+		apiClient: apiClient,
+		exported:  make(map[string]bool),
+		clusterID: clusterID,
+		interval:  interval,
+	}
+}
+
+// run exports volume statistics every interval until stopCh is closed.
+func (e *volumeStatsExporter) run(stopCh <-chan struct{}) {
+	klog.Infof("Volume statistics exporter started (every %s)", e.interval)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+		e.collect(ctx)
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// collect runs one export pass over all managed volumes.
+func (e *volumeStatsExporter) collect(ctx context.Context) {
+	datasets, err := e.apiClient.FindManagedDatasets(ctx, "")
+	if err != nil {
+		klog.Warningf("Volume statistics export skipped: %v", err)
+		return
+	}
+	snapshotIDs, err := e.apiClient.QuerySnapshotIDs(ctx, []interface{}{})
+	if err != nil {
+		klog.Warningf("Volume statistics export skipped: %v", err)
+		return
+	}
+	snapshots := make(map[string]int)
+	for _, id := range snapshotIDs {
+		if dataset, _, ok := strings.Cut(id, "@"); ok {
+			snapshots[dataset]++
+		}
+	}
+
+	seen := make(map[string]bool, len(datasets))
+	for i := range datasets {
+		ds := &datasets[i]
+		prop := func(name string) string { return ds.UserProperties[name].Value }
+
+		if owner := prop(tnsapi.PropertyClusterID); owner != "" && owner != e.clusterID {
+			continue
+		}
+		if prop(tnsapi.PropertyDetachedSnapshot) == VolumeContextValueTrue {
+			continue
+		}
+		pv := prop(tnsapi.PropertyPVName)
+		if pv == "" {
+			pv = prop(tnsapi.PropertyCSIVolumeName)
+		}
+
+		seen[ds.ID] = true
+		e.exported[ds.ID] = true
+		metrics.SetVolumeStats(metrics.VolumeStats{
+			VolumeID:         ds.ID,
+			PV:               pv,
+			Namespace:        prop(tnsapi.PropertyPVCNamespace),
+			StorageClass:     prop(tnsapi.PropertyStorageClass),
+			UsedBytes:        ds.UsedBytes(),
+			ReferencedBytes:  ds.ReferencedBytes(),
+			CompressionRatio: ds.CompressionRatio(),
+			Snapshots:        snapshots[ds.ID],
+		})
+	}
+
+	// Forget deleted volumes
+	for volumeID := range e.exported {
+		if !seen[volumeID] {
+			delete(e.exported, volumeID)
+			metrics.DeleteVolumeStats(volumeID)
+		}
+	}
+	klog.V(4).Infof("Exported statistics of %d volumes", len(seen))
+}
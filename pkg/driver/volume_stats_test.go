@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gatheredVolumeStat returns the value of a tns_csi_volume_stats_* gauge of a volume.
+func gatheredVolumeStat(t *testing.T, name, volumeID string) (float64, bool) {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "volume_id" && label.GetValue() == volumeID {
+					return metric.GetGauge().GetValue(), true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+func TestVolumeStatsExporterIntegration(t *testing.T) {
+	controller, _ := newIntegrationController(t)
+	ctx := context.Background()
+
+	created, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:          "pvc-stats",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}},
+		Parameters: map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local"},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	volumeID := created.GetVolume().GetVolumeId()
+	for _, name := range []string{"daily", "weekly"} {
+		if _, err := controller.apiClient.CreateSnapshot(ctx, tnsapi.SnapshotCreateParams{Dataset: volumeID, Name: name}); err != nil {
+			t.Fatalf("CreateSnapshot(%s) error = %v", name, err)
+		}
+	}
+
+	exporter := newVolumeStatsExporter(controller.apiClient, "", time.Minute)
+	exporter.collect(ctx)
+	if got, ok := gatheredVolumeStat(t, "tns_csi_volume_stats_snapshot_count", volumeID); !ok || got != 2 {
+		t.Errorf("snapshot count of %s = %v (exported %v), want 2", volumeID, got, ok)
+	}
+	if got, ok := gatheredVolumeStat(t, "tns_csi_volume_stats_compression_ratio", volumeID); !ok || got != 1 {
+		t.Errorf("compression ratio of %s = %v (exported %v), want 1", volumeID, got, ok)
+	}
+
+	if _, err := controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+		t.Fatalf("DeleteVolume() error = %v", err)
+	}
+	exporter.collect(ctx)
+	if _, ok := gatheredVolumeStat(t, "tns_csi_volume_stats_used_bytes", volumeID); ok {
+		t.Errorf("statistics of deleted volume %s still exported", volumeID)
+	}
+}
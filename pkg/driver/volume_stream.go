@@ -0,0 +1,148 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/dashboard"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"k8s.io/klog/v2"
+)
+
+// Volume stream export and import.
+//
+// The admin API actions export and import copy single volumes off and onto TrueNAS as raw zfs
+// send streams (tnsapi.SendSnapshot, tnsapi.ReceiveSnapshot), so backup tooling can store them
+// anywhere that speaks HTTP, e.g. through S3 presigned URLs, without the TrueNAS API key:
+//
+//   - export sends a snapshot of a volume (the named one, or a temporary snapshot removed
+//     afterwards) with an HTTP PUT to the request URL
+//   - import fetches the request URL with an HTTP GET and receives the stream into a new dataset
+//
+// Streams are raw (zfs send -w) and carry the dataset properties, so encrypted volumes stay
+// encrypted and an imported dataset keeps its tns-csi metadata, from which `kubectl tns-csi adopt`
+// generates the PV and PVC of a restored volume.
+
+// exportSnapshotPrefix names the temporary snapshots of exports.
+const exportSnapshotPrefix = "tns-csi-export-"
+
+// Volume stream errors.
+var (
+	errStreamURL         = fmt.Errorf("%w: url must be an http or https URL", errInvalidAdminRequest)
+	errStreamMissingArgs = fmt.Errorf("%w: volumeId or dataset, and url are required", errInvalidAdminRequest)
+	errExportNotVolume   = fmt.Errorf("%w: not a volume managed by tns-csi", errInvalidAdminRequest)
+	errStreamEndpoint    = errors.New("stream endpoint rejected the request")
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// streamURL validates the URL of an export or import.
+func streamURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errStreamURL
+	}
+	return u.String(), nil
+}
+
+// exportVolume sends a snapshot of a volume to req.URL.
+func (s *ControllerService) exportVolume(ctx context.Context, req dashboard.ExportRequest) (*dashboard.StreamResult, error) {
+	if req.VolumeID == "" || req.URL == "" {
+		return nil, errStreamMissingArgs
+	}
+	target, err := streamURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	dataset, err := s.apiClient.GetDatasetWithProperties(ctx, req.VolumeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up volume %s: %w", req.VolumeID, err)
+	}
+	if dataset == nil || dataset.UserProperties[tnsapi.PropertyManagedBy].Value != tnsapi.ManagedByValue {
+		return nil, fmt.Errorf("%s: %w", req.VolumeID, errExportNotVolume)
+	}
+
+	snapshot := req.Snapshot
+	if snapshot == "" {
+		snapshot = exportSnapshotPrefix + strconv.FormatInt(time.Now().Unix(), 10)
+		if _, err := s.apiClient.CreateSnapshot(ctx, tnsapi.SnapshotCreateParams{Dataset: req.VolumeID, Name: snapshot}); err != nil {
+			return nil, fmt.Errorf("failed to snapshot %s for export: %w", req.VolumeID, err)
+		}
+		defer func() {
+			if err := s.apiClient.DeleteSnapshot(context.WithoutCancel(ctx), req.VolumeID+"@"+snapshot); err != nil {
+				klog.Warningf("Failed to delete export snapshot %s@%s: %v", req.VolumeID, snapshot, err)
+			}
+		}()
+	}
+
+	stream, err := s.apiClient.SendSnapshot(ctx, tnsapi.SnapshotSendParams{Snapshot: req.VolumeID + "@" + snapshot, Raw: true, Properties: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = stream.Close() }()
+
+	counted := &countingReader{r: stream}
+	put, err := http.NewRequestWithContext(ctx, http.MethodPut, target, counted)
+	if err != nil {
+		return nil, err
+	}
+	put.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := http.DefaultClient.Do(put)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload stream of %s: %w", req.VolumeID, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%w: PUT returned %s", errStreamEndpoint, resp.Status)
+	}
+
+	klog.Infof("Exported volume %s (snapshot %s, %d bytes)", req.VolumeID, snapshot, counted.n)
+	return &dashboard.StreamResult{Dataset: req.VolumeID, Snapshot: snapshot, Bytes: counted.n}, nil
+}
+
+// importVolume receives the stream at req.URL into req.Dataset.
+func (s *ControllerService) importVolume(ctx context.Context, req dashboard.ImportRequest) (*dashboard.StreamResult, error) {
+	if req.Dataset == "" || req.URL == "" {
+		return nil, errStreamMissingArgs
+	}
+	source, err := streamURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	get, err := http.NewRequestWithContext(ctx, http.MethodGet, source, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(get)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download stream for %s: %w", req.Dataset, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: GET returned %s", errStreamEndpoint, resp.Status)
+	}
+
+	counted := &countingReader{r: resp.Body}
+	if err := s.apiClient.ReceiveSnapshot(ctx, tnsapi.SnapshotReceiveParams{Dataset: req.Dataset, Force: req.Force}, counted); err != nil {
+		return nil, err
+	}
+	klog.Infof("Imported stream into dataset %s (%d bytes)", req.Dataset, counted.n)
+	return &dashboard.StreamResult{Dataset: req.Dataset, Bytes: counted.n}, nil
+}
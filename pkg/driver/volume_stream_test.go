@@ -0,0 +1,89 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/dashboard"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+)
+
+func TestVolumeStreamIntegration(t *testing.T) {
+	controller, _ := newIntegrationController(t)
+	ctx := context.Background()
+
+	resp, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:          "pvc-stream",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}},
+		Parameters: map[string]string{"protocol": ProtocolNFS, "pool": "tank", "server": "truenas.local"},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	volumeID := resp.GetVolume().GetVolumeId()
+
+	// A bucket standing in for object storage behind presigned URLs
+	var mu sync.Mutex
+	var stored []byte
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			stored, _ = io.ReadAll(r.Body)
+		case http.MethodGet:
+			_, _ = w.Write(stored)
+		}
+	}))
+	t.Cleanup(bucket.Close)
+
+	exported, err := controller.exportVolume(ctx, dashboard.ExportRequest{VolumeID: volumeID, URL: bucket.URL + "/stream"})
+	if err != nil {
+		t.Fatalf("exportVolume() error = %v", err)
+	}
+	if exported.Bytes == 0 || exported.Bytes != int64(len(stored)) {
+		t.Errorf("exported %d bytes, bucket holds %d", exported.Bytes, len(stored))
+	}
+	snapshots, err := controller.apiClient.QuerySnapshots(ctx, []interface{}{[]interface{}{"dataset", "=", volumeID}})
+	if err != nil || len(snapshots) != 0 {
+		t.Errorf("snapshots after export = %v, %v; want the temporary snapshot removed", snapshots, err)
+	}
+
+	imported, err := controller.importVolume(ctx, dashboard.ImportRequest{Dataset: "tank/restored", URL: bucket.URL + "/stream"})
+	if err != nil {
+		t.Fatalf("importVolume() error = %v", err)
+	}
+	if imported.Bytes != exported.Bytes {
+		t.Errorf("imported %d bytes, want %d", imported.Bytes, exported.Bytes)
+	}
+	restored, err := controller.apiClient.GetDatasetWithProperties(ctx, "tank/restored")
+	if err != nil || restored == nil || restored.UserProperties[tnsapi.PropertyManagedBy].Value != tnsapi.ManagedByValue {
+		t.Fatalf("restored dataset = %v, %v; want it to keep the tns-csi properties", restored, err)
+	}
+	if _, err := controller.importVolume(ctx, dashboard.ImportRequest{Dataset: "tank/restored", URL: bucket.URL + "/stream"}); !errors.Is(err, tnsapi.ErrJobFailed) {
+		t.Errorf("importVolume() into an existing dataset error = %v, want ErrJobFailed", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		req  dashboard.ExportRequest
+	}{
+		{name: "missing url", req: dashboard.ExportRequest{VolumeID: volumeID}},
+		{name: "not http", req: dashboard.ExportRequest{VolumeID: volumeID, URL: "file:///tmp/stream"}},
+		{name: "unmanaged dataset", req: dashboard.ExportRequest{VolumeID: "tank", URL: bucket.URL}},
+	} {
+		if _, err := controller.exportVolume(ctx, tt.req); !errors.Is(err, errInvalidAdminRequest) {
+			t.Errorf("%s: exportVolume() error = %v, want an invalid request", tt.name, err)
+		}
+	}
+}
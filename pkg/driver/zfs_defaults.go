@@ -0,0 +1,178 @@
+package driver
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// Driver-wide default ZFS properties.
+//
+// Platform defaults such as compression=zstd or atime=off would otherwise have to be repeated
+// as zfs.* parameters in every StorageClass. --default-zfs-properties sets them for all volumes
+// the controller creates, and --default-zfs-properties-file names a file, usually a mounted
+// ConfigMap, with one "name=value" pair per line that is re-read for every volume.
+//
+// Precedence, highest first:
+//  1. zfs.* StorageClass parameters
+//  2. the workloadProfile StorageClass parameter
+//  3. --default-zfs-properties-file
+//  4. --default-zfs-properties
+//
+// Properties that only exist on filesystem datasets (recordsize, atime, ...) are not applied
+// to NVMe-oF/iSCSI ZVOLs, and ZVOL-only properties (volblocksize, sparse) are not applied to
+// NFS/SMB datasets, so one set of defaults serves every protocol.
+
+// zfsDatasetOnlyProperties cannot be set on ZVOLs.
+var zfsDatasetOnlyProperties = []string{
+	"recordsize", zfsAtime, "snapdir", "exec", "aclmode", "acltype", "casesensitivity", zfsQoSSpecialSmallBlocks,
+}
+
+// zfsZvolOnlyProperties cannot be set on filesystem datasets.
+var zfsZvolOnlyProperties = []string{"volblocksize", "sparse"}
+
+// ParseDefaultZFSProperties parses a comma-separated "name=value" list such as
+// "compression=zstd,atime=off". Names may carry the "zfs." prefix of StorageClass parameters.
+// An empty value returns nil.
+func ParseDefaultZFSProperties(value string) (map[string]string, error) {
+	var props map[string]string
+	for _, field := range strings.Split(value, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		name, propValue, err := parseZFSDefault(field)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkZFSPropertyValue(name, propValue); err != nil {
+			return nil, fmt.Errorf("invalid default ZFS properties: %w", err)
+		}
+		if props == nil {
+			props = make(map[string]string)
+		}
+		props[name] = propValue
+	}
+	if _, err := parseZFSQoSProperties(prefixZFSProperties(props), false); err != nil {
+		return nil, fmt.Errorf("invalid default ZFS properties: %w", err)
+	}
+	return props, nil
+}
+
+// parseZFSDefault parses one "name=value" default.
+func parseZFSDefault(field string) (string, string, error) {
+	name, value, ok := strings.Cut(field, "=")
+	name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "zfs.")
+	value = strings.TrimSpace(value)
+	if !ok || name == "" || value == "" {
+		return "", "", fmt.Errorf("invalid default ZFS property %q: must be name=value", strings.TrimSpace(field))
+	}
+	return name, value, nil
+}
+
+// prefixZFSProperties returns props keyed by their zfs.* StorageClass parameter names.
+func prefixZFSProperties(props map[string]string) map[string]string {
+	prefixed := make(map[string]string, len(props))
+	for name, value := range props {
+		prefixed["zfs."+name] = value
+	}
+	return prefixed
+}
+
+// zfsDefaults holds the driver-wide default ZFS properties. A nil value has none.
+type zfsDefaults struct {
+	flag map[string]string // --default-zfs-properties
+	path string            // --default-zfs-properties-file (empty = none)
+}
+
+// newZFSDefaults returns the defaults of the flags, or nil if neither is set.
+func newZFSDefaults(props map[string]string, path string) *zfsDefaults {
+	if len(props) == 0 && path == "" {
+		return nil
+	}
+	return &zfsDefaults{flag: props, path: path}
+}
+
+// properties returns the current defaults. The file is read on every call, so ConfigMap
+// edits apply to the next volume; invalid lines are skipped with a warning.
+func (d *zfsDefaults) properties() map[string]string {
+	if d == nil {
+		return nil
+	}
+	props := maps.Clone(d.flag)
+	if d.path == "" {
+		return props
+	}
+	f, err := os.Open(d.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("Failed to read default ZFS properties %s: %v", d.path, err)
+		}
+		return props
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, value, err := parseZFSDefault(line)
+		if err == nil {
+			err = checkZFSPropertyValue(name, value)
+		}
+		if err != nil {
+			klog.Warningf("Ignoring line in %s: %v", d.path, err)
+			continue
+		}
+		if props == nil {
+			props = make(map[string]string)
+		}
+		props[name] = value
+	}
+	return props
+}
+
+// applyDefaultZFSProperties returns params with the default properties that apply to the
+// volume type added as zfs.* parameters. Parameters already set are kept.
+func applyDefaultZFSProperties(params, defaults map[string]string, zvol bool) map[string]string {
+	if len(defaults) == 0 {
+		return params
+	}
+	skip := zfsZvolOnlyProperties
+	if zvol {
+		skip = zfsDatasetOnlyProperties
+	}
+	merged := maps.Clone(params)
+	if merged == nil {
+		merged = make(map[string]string)
+	}
+	for name, value := range defaults {
+		if slices.Contains(skip, name) {
+			continue
+		}
+		if _, set := merged["zfs."+name]; !set {
+			merged["zfs."+name] = value
+		}
+	}
+	return merged
+}
+
+// zfsParameters returns the StorageClass parameters of a new volume with the workload profile
+// and the driver-wide default ZFS properties expanded into zfs.* parameters, and validates them.
+func (s *ControllerService) zfsParameters(params map[string]string, zvol bool) (map[string]string, error) {
+	merged, err := applyWorkloadProfile(params, zvol)
+	if err != nil {
+		return nil, err
+	}
+	merged = applyDefaultZFSProperties(merged, s.zfsDefaults.properties(), zvol)
+	if err := validateZFSProperties(merged, zvol); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
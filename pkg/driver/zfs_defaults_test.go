@@ -0,0 +1,109 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDefaultZFSProperties(t *testing.T) {
+	tests := []struct {
+		want    map[string]string
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "empty", value: ""},
+		{
+			name:  "properties",
+			value: " compression=zstd, ATIME = off,zfs.logbias=throughput",
+			want:  map[string]string{"compression": "zstd", "atime": "off", "logbias": "throughput"},
+		},
+		{name: "missing value", value: "compression=", wantErr: true},
+		{name: "missing name", value: "=zstd", wantErr: true},
+		{name: "no separator", value: "compression", wantErr: true},
+		{name: "invalid qos value", value: "primarycache=some", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDefaultZFSProperties(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDefaultZFSProperties(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseDefaultZFSProperties(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZFSParametersPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zfs-properties")
+	content := "# platform defaults\ncompression=zstd\nsync=always # file wins over the flag\nbroken line\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := &ControllerService{zfsDefaults: newZFSDefaults(map[string]string{
+		"compression": "lz4", "sync": "standard", "atime": "off", "volblocksize": "32K", "dedup": "on",
+	}, path)}
+
+	tests := []struct {
+		params map[string]string
+		want   map[string]string
+		name   string
+		zvol   bool
+	}{
+		{
+			name:   "dataset defaults",
+			params: map[string]string{"pool": "tank"},
+			want: map[string]string{
+				"pool": "tank", "zfs.compression": "zstd", "zfs.sync": "always", "zfs.atime": "off", "zfs.dedup": "on",
+			},
+		},
+		{
+			name:   "zvol skips dataset-only properties",
+			params: map[string]string{},
+			zvol:   true,
+			want: map[string]string{
+				"zfs.compression": "zstd", "zfs.sync": "always", "zfs.volblocksize": "32K", "zfs.dedup": "on",
+			},
+		},
+		{
+			name:   "workload profile and explicit parameters win",
+			params: map[string]string{WorkloadProfileParam: "database", "zfs.dedup": "off"},
+			zvol:   true,
+			want: map[string]string{
+				WorkloadProfileParam: "database", "zfs.volblocksize": "16K", "zfs.logbias": "latency",
+				"zfs.sync": "standard", "zfs.compression": "lz4", "zfs.dedup": "off",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.zfsParameters(tt.params, tt.zvol)
+			if err != nil {
+				t.Fatalf("zfsParameters() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("zfsParameters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.zfsParameters(map[string]string{}, false)
+	if err != nil {
+		t.Fatalf("zfsParameters() error = %v", err)
+	}
+	if got["zfs.compression"] != "lz4" {
+		t.Errorf("without the file, zfs.compression = %q, want the flag's lz4", got["zfs.compression"])
+	}
+
+	params := map[string]string{"pool": "tank"}
+	if got, _ := (&ControllerService{}).zfsParameters(params, false); !reflect.DeepEqual(got, params) {
+		t.Errorf("no defaults: zfsParameters() = %v, want %v", got, params)
+	}
+}
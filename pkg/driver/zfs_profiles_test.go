@@ -0,0 +1,85 @@
+package driver
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestApplyWorkloadProfile(t *testing.T) {
+	tests := []struct {
+		params   map[string]string
+		want     map[string]string
+		name     string
+		zvol     bool
+		wantCode codes.Code
+	}{
+		{
+			name:   "no profile",
+			params: map[string]string{"zfs.compression": "zstd"},
+			want:   map[string]string{"zfs.compression": "zstd"},
+		},
+		{
+			name:   "database dataset",
+			params: map[string]string{WorkloadProfileParam: "database"},
+			want: map[string]string{
+				"zfs.recordsize": "16K", "zfs.logbias": "latency", "zfs.sync": "standard",
+				"zfs.compression": "lz4", "zfs.atime": "off",
+			},
+		},
+		{
+			name:   "vm zvol",
+			params: map[string]string{WorkloadProfileParam: "VM"},
+			zvol:   true,
+			want: map[string]string{
+				"zfs.volblocksize": "64K", "zfs.logbias": "latency", "zfs.sync": "standard", "zfs.compression": "lz4",
+			},
+		},
+		{
+			name:   "explicit parameters win",
+			params: map[string]string{WorkloadProfileParam: "database", "zfs.sync": "always", "zfs.volblocksize": "8K"},
+			zvol:   true,
+			want: map[string]string{
+				"zfs.volblocksize": "8K", "zfs.logbias": "latency", "zfs.sync": "always", "zfs.compression": "lz4",
+			},
+		},
+		{
+			name:     "unknown profile",
+			params:   map[string]string{WorkloadProfileParam: "analytics"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyWorkloadProfile(tt.params, tt.zvol)
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Fatalf("applyWorkloadProfile() error = %v, want code %v", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyWorkloadProfile() unexpected error: %v", err)
+			}
+			for key, value := range tt.want {
+				if got[key] != value {
+					t.Errorf("%s = %q, want %q", key, got[key], value)
+				}
+			}
+			if tt.zvol && got["zfs.recordsize"] != "" {
+				t.Errorf("zvol profile set zfs.recordsize = %q", got["zfs.recordsize"])
+			}
+		})
+	}
+}
+
+func TestApplyWorkloadProfileDoesNotModifyParams(t *testing.T) {
+	params := map[string]string{WorkloadProfileParam: "general"}
+	if _, err := applyWorkloadProfile(params, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != 1 {
+		t.Errorf("StorageClass parameters modified: %v", params)
+	}
+}
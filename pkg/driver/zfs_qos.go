@@ -0,0 +1,129 @@
+package driver
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// ZFS QoS hints.
+//
+// ZFS has no hard IOPS/bandwidth limits per dataset, but a few native properties decide how a
+// volume competes for the shared ARC/L2ARC, SLOG and special vdevs. Exposing them per
+// StorageClass lets noisy volume classes (logs, scratch, backups) be kept away from latency
+// sensitive ones. They are validated up front and applied right after the dataset/ZVOL is created.
+const (
+	zfsQoSLogbias            = "logbias"
+	zfsQoSPrimarycache       = "primarycache"
+	zfsQoSSecondarycache     = "secondarycache"
+	zfsQoSSpecialSmallBlocks = "special_small_blocks"
+
+	// maxSpecialSmallBlocks is the largest special_small_blocks value ZFS accepts (1M).
+	maxSpecialSmallBlocks = 1 << 20
+)
+
+// zfsPropertySetter is implemented by API clients that can set native ZFS properties.
+type zfsPropertySetter interface {
+	SetZFSProperties(ctx context.Context, datasetID string, properties map[string]string) error
+}
+
+// isZFSQoSProperty reports whether a "zfs." parameter name (without prefix) is a QoS hint.
+func isZFSQoSProperty(name string) bool {
+	switch name {
+	case zfsQoSLogbias, zfsQoSPrimarycache, zfsQoSSecondarycache, zfsQoSSpecialSmallBlocks:
+		return true
+	}
+	return false
+}
+
+// parseZFSQoSProperties extracts and validates QoS hints from StorageClass parameters:
+//   - zfs.logbias: latency, throughput
+//   - zfs.primarycache / zfs.secondarycache: all, metadata, none
+//   - zfs.special_small_blocks: 0 or a power of two up to 1M (e.g. "16K"); datasets only
+//
+// Returns native ZFS property names mapped to lowercase values, or nil if none are set.
+func parseZFSQoSProperties(params map[string]string, zvol bool) (map[string]string, error) {
+	var props map[string]string
+	for key, value := range params {
+		name, ok := strings.CutPrefix(key, "zfs.")
+		if !ok || !isZFSQoSProperty(name) {
+			continue
+		}
+		value = strings.ToLower(strings.TrimSpace(value))
+
+		switch name {
+		case zfsQoSLogbias:
+			if value != "latency" && value != "throughput" {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be latency or throughput", key, value)
+			}
+		case zfsQoSPrimarycache, zfsQoSSecondarycache:
+			if value != "all" && value != "metadata" && value != "none" {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be all, metadata or none", key, value)
+			}
+		case zfsQoSSpecialSmallBlocks:
+			if zvol {
+				return nil, status.Errorf(codes.InvalidArgument, "%s is only supported for filesystem datasets (NFS, SMB)", key)
+			}
+			size, err := parseZFSBlockSize(value)
+			if err != nil || (size != 0 && (size < 512 || size > maxSpecialSmallBlocks || size&(size-1) != 0)) {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be 0 or a power of two between 512 and 1M", key, value)
+			}
+			value = strconv.FormatInt(size, 10)
+		}
+
+		if props == nil {
+			props = make(map[string]string)
+		}
+		props[name] = value
+	}
+	return props, nil
+}
+
+// parseZFSBlockSize parses a ZFS size such as "512", "16K" or "1M" into bytes.
+func parseZFSBlockSize(value string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "k"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "m"):
+		multiplier = 1 << 20
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid size %q", value)
+	}
+	return n * multiplier, nil
+}
+
+// applyZFSQoSProperties sets QoS hints on a newly created dataset or ZVOL.
+// Failures are logged, not fatal: the volume works, just without the tuning.
+func (s *ControllerService) applyZFSQoSProperties(ctx context.Context, datasetID string, props map[string]string) {
+	if len(props) == 0 {
+		return
+	}
+	setter, ok := s.apiClient.(zfsPropertySetter)
+	if !ok {
+		klog.Warningf("API client cannot set native ZFS properties; skipping QoS hints for %s", datasetID)
+		return
+	}
+
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if err := setter.SetZFSProperties(ctx, datasetID, props); err != nil {
+		klog.Warningf("Failed to apply ZFS QoS properties %v to %s: %v (volume will still work)", names, datasetID, err)
+		return
+	}
+	klog.V(4).Infof("Applied ZFS QoS properties %v to %s", names, datasetID)
+}
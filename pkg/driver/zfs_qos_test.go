@@ -0,0 +1,169 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseZFSQoSProperties(t *testing.T) {
+	tests := []struct {
+		params   map[string]string
+		want     map[string]string
+		name     string
+		zvol     bool
+		wantCode codes.Code
+	}{
+		{
+			name:   "no QoS parameters",
+			params: map[string]string{"zfs.compression": "lz4"},
+		},
+		{
+			name: "dataset hints are normalized",
+			params: map[string]string{
+				"zfs.logbias":              "Throughput",
+				"zfs.primarycache":         "METADATA",
+				"zfs.secondarycache":       "none",
+				"zfs.special_small_blocks": "16K",
+			},
+			want: map[string]string{
+				"logbias":              "throughput",
+				"primarycache":         "metadata",
+				"secondarycache":       "none",
+				"special_small_blocks": "16384",
+			},
+		},
+		{
+			name:   "special_small_blocks can be disabled",
+			params: map[string]string{"zfs.special_small_blocks": "0"},
+			want:   map[string]string{"special_small_blocks": "0"},
+		},
+		{
+			name:   "zvol cache hints",
+			params: map[string]string{"zfs.primarycache": "all", "zfs.logbias": "latency"},
+			zvol:   true,
+			want:   map[string]string{"primarycache": "all", "logbias": "latency"},
+		},
+		{
+			name:     "invalid logbias",
+			params:   map[string]string{"zfs.logbias": "fast"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "invalid cache mode",
+			params:   map[string]string{"zfs.secondarycache": "some"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "special_small_blocks not a power of two",
+			params:   map[string]string{"zfs.special_small_blocks": "12K"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "special_small_blocks too large",
+			params:   map[string]string{"zfs.special_small_blocks": "2M"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "special_small_blocks rejected for zvols",
+			params:   map[string]string{"zfs.special_small_blocks": "16K"},
+			zvol:     true,
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseZFSQoSProperties(tt.params, tt.zvol)
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Fatalf("parseZFSQoSProperties() error = %v, want code %v", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseZFSQoSProperties() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseZFSQoSProperties() = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+// qosRecordingClient records native ZFS property updates on top of MockAPIClientForSnapshots.
+type qosRecordingClient struct {
+	*MockAPIClientForSnapshots
+	applied map[string]map[string]string
+}
+
+func (c *qosRecordingClient) SetZFSProperties(_ context.Context, datasetID string, properties map[string]string) error {
+	c.applied[datasetID] = properties
+	return nil
+}
+
+func TestCreateVolumeAppliesZFSQoS(t *testing.T) {
+	ctx := context.Background()
+	client := &qosRecordingClient{
+		MockAPIClientForSnapshots: &MockAPIClientForSnapshots{
+			QueryAllDatasetsFunc: func(_ context.Context, _ string) ([]tnsapi.Dataset, error) {
+				return nil, nil
+			},
+			CreateDatasetFunc: func(_ context.Context, params tnsapi.DatasetCreateParams) (*tnsapi.Dataset, error) {
+				return &tnsapi.Dataset{ID: params.Name, Name: params.Name, Mountpoint: "/mnt/" + params.Name}, nil
+			},
+			QueryAllNFSSharesFunc: func(_ context.Context, _ string) ([]tnsapi.NFSShare, error) {
+				return nil, nil
+			},
+			CreateNFSShareFunc: func(_ context.Context, params tnsapi.NFSShareCreateParams) (*tnsapi.NFSShare, error) {
+				return &tnsapi.NFSShare{ID: 1, Path: params.Path, Enabled: true}, nil
+			},
+		},
+		applied: make(map[string]map[string]string),
+	}
+	service := NewControllerService(client, NewNodeRegistry(), "")
+
+	req := &csi.CreateVolumeRequest{
+		Name: "pvc-qos",
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		}},
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+		Parameters: map[string]string{
+			"protocol":         ProtocolNFS,
+			"pool":             "tank",
+			"parentDataset":    "tank/csi",
+			"server":           "10.0.0.1",
+			"zfs.logbias":      "throughput",
+			"zfs.primarycache": "metadata",
+		},
+	}
+	if _, err := service.CreateVolume(ctx, req); err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	got := client.applied["tank/csi/pvc-qos"]
+	if got["logbias"] != "throughput" || got["primarycache"] != "metadata" {
+		t.Errorf("applied QoS properties = %v", got)
+	}
+
+	// Invalid hints are rejected before anything is created
+	req.Name = "pvc-qos-bad"
+	req.Parameters["zfs.logbias"] = "fast"
+	_, err := service.CreateVolume(ctx, req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolume() with invalid logbias error = %v, want InvalidArgument", err)
+	}
+	if _, ok := client.applied["tank/csi/pvc-qos-bad"]; ok {
+		t.Error("QoS properties applied for a rejected request")
+	}
+}
@@ -0,0 +1,112 @@
+package driver
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// zfs.* parameter validation.
+//
+// TrueNAS rejects invalid property values only when the dataset or ZVOL is created, with an
+// error that does not name the StorageClass parameter. The enumerated properties are checked
+// up front instead, so a bad value fails CreateVolume with InvalidArgument listing the valid
+// options. Values are compared case-insensitively; unknown properties and properties that do
+// not apply to the volume type are ignored as before. QoS hints are validated separately
+// (see parseZFSQoSProperties).
+
+// zfsPropertyRule lists the accepted values of an enumerated ZFS property.
+type zfsPropertyRule struct {
+	allowed string   // valid options for error messages (empty = values joined)
+	values  []string // accepted values, compared case-insensitively
+}
+
+// zfsPropertyRules holds the enumerated zfs.* parameters (see DatasetCreateParams and ZvolCreateParams).
+var zfsPropertyRules = map[string]zfsPropertyRule{
+	"compression": {
+		values: slices.Concat(
+			[]string{"off", "on", "lz4", "gzip"}, numberedValues("gzip-", 1, 9),
+			[]string{"zstd"}, numberedValues("zstd-", 1, 19), []string{"lzjb", "zle"}),
+		allowed: "off, on, lz4, gzip, gzip-1 to gzip-9, zstd, zstd-1 to zstd-19, lzjb, zle",
+	},
+	"dedup":           {values: []string{"off", "on", "verify", "sha256", "sha512"}},
+	zfsAtime:          {values: []string{"on", "off"}},
+	"sync":            {values: []string{"standard", "always", "disabled"}},
+	"recordsize":      {values: zfsBlockSizes(512, 1<<20)},
+	"copies":          {values: []string{"1", "2", "3"}},
+	"snapdir":         {values: []string{"hidden", "visible"}},
+	"readonly":        {values: []string{"on", "off"}},
+	"exec":            {values: []string{"on", "off"}},
+	"aclmode":         {values: []string{"passthrough", "restricted", "discard", "groupmask"}},
+	"acltype":         {values: []string{"off", "nfsv4", "posix"}},
+	"casesensitivity": {values: []string{"sensitive", "insensitive", "mixed"}},
+	"sparse":          {values: []string{"true", "false"}},
+	"volblocksize":    {values: zfsBlockSizes(512, 128<<10)},
+}
+
+// numberedValues returns prefix+n for n from first to last.
+func numberedValues(prefix string, first, last int) []string {
+	values := make([]string, 0, last-first+1)
+	for n := first; n <= last; n++ {
+		values = append(values, prefix+strconv.Itoa(n))
+	}
+	return values
+}
+
+// zfsBlockSizes returns the power-of-two sizes from minSize to maxSize as ZFS writes them ("512", "1K", "1M").
+func zfsBlockSizes(minSize, maxSize int64) []string {
+	var sizes []string
+	for size := minSize; size <= maxSize; size *= 2 {
+		switch {
+		case size >= 1<<20:
+			sizes = append(sizes, fmt.Sprintf("%dM", size>>20))
+		case size >= 1<<10:
+			sizes = append(sizes, fmt.Sprintf("%dK", size>>10))
+		default:
+			sizes = append(sizes, strconv.FormatInt(size, 10))
+		}
+	}
+	return sizes
+}
+
+// checkZFSPropertyValue returns an error if value is not accepted for the ZFS property name.
+// Properties without a rule are accepted.
+func checkZFSPropertyValue(name, value string) error {
+	rule, ok := zfsPropertyRules[name]
+	if !ok {
+		return nil
+	}
+	for _, valid := range rule.values {
+		if strings.EqualFold(value, valid) {
+			return nil
+		}
+	}
+	allowed := rule.allowed
+	if allowed == "" {
+		allowed = strings.Join(rule.values, ", ")
+	}
+	return fmt.Errorf("invalid zfs.%s %q: must be one of %s", name, value, allowed)
+}
+
+// validateZFSProperties checks the zfs.* parameters that apply to a dataset or ZVOL.
+func validateZFSProperties(params map[string]string, zvol bool) error {
+	skip := zfsZvolOnlyProperties
+	if zvol {
+		skip = zfsDatasetOnlyProperties
+	}
+	for _, key := range slices.Sorted(maps.Keys(params)) {
+		name, ok := strings.CutPrefix(key, "zfs.")
+		if !ok || slices.Contains(skip, name) {
+			continue
+		}
+		if err := checkZFSPropertyValue(name, params[key]); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateZFSProperties(t *testing.T) {
+	tests := []struct {
+		params  map[string]string
+		name    string
+		wantMsg string
+		zvol    bool
+	}{
+		{
+			name: "valid dataset properties",
+			params: map[string]string{
+				"zfs.compression": "ZSTD-3", "zfs.recordsize": "1m", "zfs.atime": "off", "zfs.copies": "2",
+				"zfs.aclmode": "restricted", "zfs.casesensitivity": "insensitive", "pool": "tank",
+			},
+		},
+		{
+			name:   "valid zvol properties",
+			params: map[string]string{"zfs.volblocksize": "16K", "zfs.sparse": "true", "zfs.sync": "always"},
+			zvol:   true,
+		},
+		{
+			name:    "recordsize too large",
+			params:  map[string]string{"zfs.recordsize": "3M"},
+			wantMsg: "invalid zfs.recordsize \"3M\": must be one of 512, 1K, 2K",
+		},
+		{
+			name:    "unknown compression",
+			params:  map[string]string{"zfs.compression": "zstd-20"},
+			wantMsg: "gzip-1 to gzip-9",
+		},
+		{
+			name:    "invalid copies",
+			params:  map[string]string{"zfs.copies": "4"},
+			wantMsg: "must be one of 1, 2, 3",
+		},
+		{
+			name:    "invalid volblocksize",
+			params:  map[string]string{"zfs.volblocksize": "256K"},
+			zvol:    true,
+			wantMsg: "invalid zfs.volblocksize",
+		},
+		{
+			name:   "dataset-only property on a zvol is ignored",
+			params: map[string]string{"zfs.recordsize": "3M"},
+			zvol:   true,
+		},
+		{
+			name:   "unknown property is ignored",
+			params: map[string]string{"zfs.redundant_metadata": "most"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateZFSProperties(tt.params, tt.zvol)
+			if tt.wantMsg == "" {
+				if err != nil {
+					t.Fatalf("validateZFSProperties() error = %v", err)
+				}
+				return
+			}
+			if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("validateZFSProperties() error = %v, want InvalidArgument containing %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestZFSParametersValidation(t *testing.T) {
+	s := &ControllerService{}
+	for protocol, param := range map[string]string{
+		ProtocolNFS:    "zfs.recordsize",
+		ProtocolSMB:    "zfs.atime",
+		ProtocolNVMeOF: "zfs.volblocksize",
+		ProtocolISCSI:  "zfs.compression",
+	} {
+		_, err := s.zfsParameters(map[string]string{param: "bogus"}, protocol == ProtocolNVMeOF || protocol == ProtocolISCSI)
+		if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), param) {
+			t.Errorf("%s: zfsParameters(%s=bogus) error = %v, want InvalidArgument naming the parameter", protocol, param, err)
+		}
+	}
+}
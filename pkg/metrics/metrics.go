@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -117,6 +118,25 @@ var (
 		[]string{labelOperation},
 	)
 
+	// gRPC error metrics by status code (operations_total only distinguishes success/error).
+	grpcErrorsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_errors_total",
+			Help:      "Total number of failed CSI gRPC calls by operation and status code",
+		},
+		[]string{labelOperation, "code"},
+	)
+
+	grpcPanicsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_panics_total",
+			Help:      "Total number of CSI gRPC calls that panicked, by operation",
+		},
+		[]string{labelOperation},
+	)
+
 	// Volume operation metrics with protocol labels.
 	volumeOperationsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -181,6 +201,53 @@ var (
 		},
 	)
 
+	// Storage API endpoint metrics (HA pairs with one endpoint per controller).
+	apiEndpointActive = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "api_endpoint_active",
+			Help:      "Storage API endpoint in use (1) or standby (0)",
+		},
+		[]string{"url"},
+	)
+
+	apiFailoversTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "api_failovers_total",
+			Help:      "Total number of switches to another storage API endpoint",
+		},
+	)
+
+	// Storage job metrics (replications, cloud syncs and other long-running TrueNAS jobs).
+	jobsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_total",
+			Help:      "Total number of storage jobs waited for, by method and final state",
+		},
+		[]string{"method", "state"},
+	)
+
+	jobDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_duration_seconds",
+			Help:      "Duration of storage jobs in seconds",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 14), // 1s to ~2.3h
+		},
+		[]string{"method"},
+	)
+
+	jobProgressRatio = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "job_progress_ratio",
+			Help:      "Progress of a running storage job (0-1)",
+		},
+		[]string{"job_id", "method"},
+	)
+
 	// NVMe-oF connect concurrency metrics.
 	nvmeConnectConcurrent = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -198,6 +265,35 @@ var (
 		},
 	)
 
+	// Controller operation concurrency metrics (--max-concurrent-provisions etc.).
+	controllerOpsConcurrent = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "controller_operations_concurrent",
+			Help:      "Number of limited controller operations currently in progress by class",
+		},
+		[]string{labelOperation},
+	)
+
+	controllerOpsQueued = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "controller_operations_queued",
+			Help:      "Number of controller operations waiting for a concurrency slot by class",
+		},
+		[]string{labelOperation},
+	)
+
+	controllerOpsWait = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "controller_operations_wait_seconds",
+			Help:      "Time controller operations waited for a concurrency slot by class",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15), // 10ms to ~160s
+		},
+		[]string{labelOperation},
+	)
+
 	// Volume capacity metrics.
 	volumeCapacityBytes = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -207,6 +303,123 @@ var (
 		},
 		[]string{"volume_id", labelProtocol},
 	)
+
+	// Volume usage metrics (filesystem volumes, updated by the controller usage monitor).
+	volumeUsedBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "volume_used_bytes",
+			Help:      "Space used by a volume in bytes",
+		},
+		[]string{"volume_id", labelProtocol, "pvc_namespace", "pvc_name"},
+	)
+	volumeUsageRatio = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "volume_usage_ratio",
+			Help:      "Fraction of a volume's quota in use (0-1)",
+		},
+		[]string{"volume_id", labelProtocol, "pvc_namespace", "pvc_name"},
+	)
+
+	// Volume statistics metrics (all volumes, updated by the controller stats exporter).
+	volumeStatsLabels    = []string{"volume_id", "pv", "namespace", "storageclass"}
+	volumeStatsUsedBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "volume_stats_used_bytes",
+			Help:      "Space used by a volume's dataset or ZVOL including snapshots in bytes",
+		},
+		volumeStatsLabels,
+	)
+	volumeStatsReferencedBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "volume_stats_referenced_bytes",
+			Help:      "Data referenced by a volume's dataset or ZVOL in bytes",
+		},
+		volumeStatsLabels,
+	)
+	volumeStatsCompressionRatio = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "volume_stats_compression_ratio",
+			Help:      "ZFS compression ratio achieved by a volume (1 = uncompressed)",
+		},
+		volumeStatsLabels,
+	)
+	volumeStatsSnapshotCount = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "volume_stats_snapshot_count",
+			Help:      "Number of ZFS snapshots of a volume",
+		},
+		volumeStatsLabels,
+	)
+
+	// Kubernetes awareness of the controller (--kube-informers, orphan GC).
+	kubeInformerAvailable = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "kube_informer_available",
+			Help:      "Whether the controller's cache of a Kubernetes resource is synced (1) or unavailable (0)",
+		},
+		[]string{"resource"},
+	)
+	orphanedVolumes = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "orphaned_volumes",
+			Help:      "Number of volumes of this cluster no PersistentVolume refers to, as of the last orphan scan",
+		},
+	)
+	orphanedVolumesDeleted = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "orphaned_volumes_deleted_total",
+			Help:      "Total number of orphaned volumes deleted by the orphan GC",
+		},
+	)
+	retainedVolumesMarkedAdoptable = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retained_volumes_marked_adoptable_total",
+			Help:      "Total number of volumes of Retain PersistentVolumes marked adoptable by the orphan scan",
+		},
+	)
+	auditFindings = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "audit_findings",
+			Help:      "Number of mismatches between Kubernetes and TrueNAS of each kind, as of the last consistency audit",
+		},
+		[]string{"kind"},
+	)
+
+	// Administrative maintenance mode (--maintenance-dir).
+	maintenanceMode = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "maintenance_mode",
+			Help:      "Whether maintenance mode is on (1) or off (0)",
+		},
+	)
+	featureEnabled = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "feature_enabled",
+			Help:      "Whether a feature gate is enabled (1) or disabled (0)",
+		},
+		[]string{"feature", "stage"},
+	)
+	maintenanceRejected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "maintenance_rejected_total",
+			Help:      "Total number of CSI requests refused with Unavailable because of maintenance mode",
+		},
+		[]string{"method"},
+	)
 )
 
 // RecordCSIOperation records the outcome of a CSI operation.
@@ -215,6 +428,16 @@ func RecordCSIOperation(operation, status string, duration time.Duration) {
 	csiOperationDuration.WithLabelValues(operation).Observe(duration.Seconds())
 }
 
+// RecordGRPCError records a failed gRPC call with its status code.
+func RecordGRPCError(operation, code string) {
+	grpcErrorsTotal.WithLabelValues(operation, code).Inc()
+}
+
+// RecordGRPCPanic records a gRPC call that panicked.
+func RecordGRPCPanic(operation string) {
+	grpcPanicsTotal.WithLabelValues(operation).Inc()
+}
+
 // RecordVolumeOperation records the outcome of a volume operation with protocol.
 func RecordVolumeOperation(protocol, operation, status string, duration time.Duration) {
 	volumeOperationsTotal.WithLabelValues(protocol, operation, status).Inc()
@@ -250,6 +473,22 @@ func SetWSConnectionDuration(duration time.Duration) {
 	wsConnectionDuration.Set(duration.Seconds())
 }
 
+// SetActiveAPIEndpoint marks the storage API endpoint in use among all configured endpoints.
+func SetActiveAPIEndpoint(active string, endpoints []string) {
+	for _, url := range endpoints {
+		if url == active {
+			apiEndpointActive.WithLabelValues(url).Set(1)
+		} else {
+			apiEndpointActive.WithLabelValues(url).Set(0)
+		}
+	}
+}
+
+// RecordAPIFailover increments the storage API endpoint switch counter.
+func RecordAPIFailover() {
+	apiFailoversTotal.Inc()
+}
+
 // SetVolumeCapacity sets the capacity of a volume.
 func SetVolumeCapacity(volumeID, protocol string, bytes int64) {
 	volumeCapacityBytes.WithLabelValues(volumeID, protocol).Set(float64(bytes))
@@ -260,6 +499,109 @@ func DeleteVolumeCapacity(volumeID, protocol string) {
 	volumeCapacityBytes.DeleteLabelValues(volumeID, protocol)
 }
 
+// SetVolumeUsage records the used space and quota usage ratio of a volume.
+func SetVolumeUsage(volumeID, protocol, pvcNamespace, pvcName string, usedBytes int64, ratio float64) {
+	volumeUsedBytes.WithLabelValues(volumeID, protocol, pvcNamespace, pvcName).Set(float64(usedBytes))
+	volumeUsageRatio.WithLabelValues(volumeID, protocol, pvcNamespace, pvcName).Set(ratio)
+}
+
+// DeleteVolumeUsage removes the usage metrics of a volume.
+func DeleteVolumeUsage(volumeID string) {
+	volumeUsedBytes.DeletePartialMatch(prometheus.Labels{"volume_id": volumeID})
+	volumeUsageRatio.DeletePartialMatch(prometheus.Labels{"volume_id": volumeID})
+}
+
+// VolumeStats holds the storage statistics of a volume.
+type VolumeStats struct {
+	VolumeID         string
+	PV               string
+	Namespace        string
+	StorageClass     string
+	UsedBytes        int64
+	ReferencedBytes  int64
+	CompressionRatio float64
+	Snapshots        int
+}
+
+// SetVolumeStats records the storage statistics of a volume.
+func SetVolumeStats(stats VolumeStats) {
+	labels := []string{stats.VolumeID, stats.PV, stats.Namespace, stats.StorageClass}
+	volumeStatsUsedBytes.WithLabelValues(labels...).Set(float64(stats.UsedBytes))
+	volumeStatsReferencedBytes.WithLabelValues(labels...).Set(float64(stats.ReferencedBytes))
+	volumeStatsCompressionRatio.WithLabelValues(labels...).Set(stats.CompressionRatio)
+	volumeStatsSnapshotCount.WithLabelValues(labels...).Set(float64(stats.Snapshots))
+}
+
+// DeleteVolumeStats removes the storage statistics of a volume.
+func DeleteVolumeStats(volumeID string) {
+	match := prometheus.Labels{"volume_id": volumeID}
+	volumeStatsUsedBytes.DeletePartialMatch(match)
+	volumeStatsReferencedBytes.DeletePartialMatch(match)
+	volumeStatsCompressionRatio.DeletePartialMatch(match)
+	volumeStatsSnapshotCount.DeletePartialMatch(match)
+}
+
+// SetJobProgress records the progress of a running storage job.
+func SetJobProgress(jobID int, method string, ratio float64) {
+	jobProgressRatio.WithLabelValues(strconv.Itoa(jobID), method).Set(ratio)
+}
+
+// RecordJobCompletion records a finished storage job and removes its progress gauge.
+func RecordJobCompletion(jobID int, method, state string, duration time.Duration) {
+	jobProgressRatio.DeleteLabelValues(strconv.Itoa(jobID), method)
+	jobsTotal.WithLabelValues(method, state).Inc()
+	jobDuration.WithLabelValues(method).Observe(duration.Seconds())
+}
+
+// DeleteJobProgress removes the progress gauge of a job that is no longer watched.
+func DeleteJobProgress(jobID int, method string) {
+	jobProgressRatio.DeleteLabelValues(strconv.Itoa(jobID), method)
+}
+
+// SetKubeInformerAvailable records whether the controller's cache of a Kubernetes resource is usable.
+func SetKubeInformerAvailable(resource string, available bool) {
+	value := 0.0
+	if available {
+		value = 1
+	}
+	kubeInformerAvailable.WithLabelValues(resource).Set(value)
+}
+
+// SetOrphanedVolumes records the number of orphaned volumes found by the last orphan scan.
+func SetOrphanedVolumes(count int) { orphanedVolumes.Set(float64(count)) }
+
+// RecordOrphanedVolumeDeleted counts a volume deleted by the orphan GC.
+func RecordOrphanedVolumeDeleted() { orphanedVolumesDeleted.Inc() }
+
+// RecordRetainedVolumeMarkedAdoptable counts a retained volume marked adoptable by the orphan scan.
+func RecordRetainedVolumeMarkedAdoptable() { retainedVolumesMarkedAdoptable.Inc() }
+
+// SetAuditFindings records the number of findings of a kind of the last consistency audit.
+func SetAuditFindings(kind string, count int) {
+	auditFindings.WithLabelValues(kind).Set(float64(count))
+}
+
+// SetMaintenanceMode records whether maintenance mode is on.
+func SetMaintenanceMode(enabled bool) {
+	value := 0.0
+	if enabled {
+		value = 1
+	}
+	maintenanceMode.Set(value)
+}
+
+// SetFeatureEnabled records the setting of a feature gate.
+func SetFeatureEnabled(feature, stage string, enabled bool) {
+	value := 0.0
+	if enabled {
+		value = 1
+	}
+	featureEnabled.WithLabelValues(feature, stage).Set(value)
+}
+
+// RecordMaintenanceRejected counts a CSI request refused because of maintenance mode.
+func RecordMaintenanceRejected(method string) { maintenanceRejected.WithLabelValues(method).Inc() }
+
 // NVMeConnectWaiting increments the waiting gauge.
 func NVMeConnectWaiting() { nvmeConnectWaiting.Inc() }
 
@@ -272,6 +614,21 @@ func NVMeConnectStart() { nvmeConnectConcurrent.Inc() }
 // NVMeConnectDone decrements the concurrent gauge.
 func NVMeConnectDone() { nvmeConnectConcurrent.Dec() }
 
+// ControllerOpQueued increments the queue length of a controller operation class.
+func ControllerOpQueued(class string) { controllerOpsQueued.WithLabelValues(class).Inc() }
+
+// ControllerOpDequeued decrements the queue length and records how long the operation waited.
+func ControllerOpDequeued(class string, wait time.Duration) {
+	controllerOpsQueued.WithLabelValues(class).Dec()
+	controllerOpsWait.WithLabelValues(class).Observe(wait.Seconds())
+}
+
+// ControllerOpStart increments the in-progress gauge of a controller operation class.
+func ControllerOpStart(class string) { controllerOpsConcurrent.WithLabelValues(class).Inc() }
+
+// ControllerOpDone decrements the in-progress gauge of a controller operation class.
+func ControllerOpDone(class string) { controllerOpsConcurrent.WithLabelValues(class).Dec() }
+
 // OperationTimer helps time operations and record metrics automatically.
 type OperationTimer struct {
 	start     time.Time
@@ -22,6 +22,12 @@ func TestMetricsAvailability(t *testing.T) {
 	RecordWSMessageDuration("pool.dataset.create", 100*time.Millisecond)
 	SetWSConnectionDuration(5 * time.Minute)
 	SetVolumeCapacity("test-vol", ProtocolNFS, 1024*1024*1024)
+	SetVolumeUsage("test-vol", ProtocolNFS, "default", "data", 512*1024*1024, 0.5)
+	SetVolumeStats(VolumeStats{VolumeID: "tank/pvc-1", PV: "pvc-1", Namespace: "default", StorageClass: "nfs", UsedBytes: 1 << 20, CompressionRatio: 1.5, Snapshots: 2})
+	SetJobProgress(7, "replication.run_onetime", 0.4)
+	RecordJobCompletion(8, "replication.run_onetime", "SUCCESS", time.Minute)
+	RecordGRPCError(OpCreateVolume, "Unavailable")
+	RecordGRPCPanic(OpNodeStage)
 
 	// Create a test HTTP server with the metrics handler
 	server := httptest.NewServer(promhttp.Handler())
@@ -63,6 +69,17 @@ func TestMetricsAvailability(t *testing.T) {
 		"tns_csi_websocket_message_duration_seconds",
 		"tns_csi_websocket_connection_duration_seconds",
 		"tns_csi_volume_capacity_bytes",
+		"tns_csi_volume_used_bytes",
+		"tns_csi_volume_usage_ratio",
+		"tns_csi_volume_stats_used_bytes",
+		"tns_csi_volume_stats_referenced_bytes",
+		"tns_csi_volume_stats_compression_ratio",
+		"tns_csi_volume_stats_snapshot_count",
+		"tns_csi_jobs_total",
+		"tns_csi_job_duration_seconds",
+		"tns_csi_job_progress_ratio",
+		"tns_csi_grpc_errors_total",
+		"tns_csi_grpc_panics_total",
 	}
 
 	for _, metric := range expectedMetrics {
@@ -73,6 +90,8 @@ func TestMetricsAvailability(t *testing.T) {
 
 	// Clean up
 	DeleteVolumeCapacity("test-vol", ProtocolNFS)
+	DeleteVolumeUsage("test-vol")
+	DeleteJobProgress(7, "replication.run_onetime")
 }
 
 func TestRecordCSIOperation(t *testing.T) {
@@ -117,6 +136,10 @@ func TestWebSocketMetrics(t *testing.T) {
 
 	// Test connection duration
 	SetWSConnectionDuration(5 * time.Minute)
+
+	// Test HA endpoint metrics
+	SetActiveAPIEndpoint("wss://b/api/current", []string{"wss://a/api/current", "wss://b/api/current"})
+	RecordAPIFailover()
 }
 
 func TestVolumeCapacityMetrics(t *testing.T) {
@@ -0,0 +1,55 @@
+//go:build windows
+
+// Package mount provides Windows-specific mount utilities for CSI driver operations.
+// Windows has no bind mounts: staged and published volumes are symbolic links (to an SMB
+// global mapping or another volume path) or volume mount points (partition access paths of
+// iSCSI disks), both of which are reparse points.
+package mount
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"k8s.io/klog/v2"
+)
+
+// IsMounted checks if a path is a symbolic link or a volume mount point.
+func IsMounted(_ context.Context, targetPath string) (bool, error) {
+	info, err := os.Lstat(targetPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat path: %w", err)
+	}
+
+	// Symbolic links report ModeSymlink, mount points and other reparse points ModeIrregular
+	mounted := info.Mode()&(os.ModeSymlink|os.ModeIrregular) != 0
+	klog.V(5).Infof("Path %s mounted status: %v", targetPath, mounted)
+	return mounted, nil
+}
+
+// IsDeviceMounted checks if a device path is mounted. Windows nodes stage no raw block
+// devices, so this is the same check as IsMounted.
+func IsDeviceMounted(ctx context.Context, targetPath string) (bool, error) {
+	return IsMounted(ctx, targetPath)
+}
+
+// Unmount removes the symbolic link or mount point at a path, leaving its target alone.
+// Partition access paths of iSCSI disks should be removed with Remove-PartitionAccessPath
+// first; removing the reparse point only detaches the path.
+func Unmount(ctx context.Context, targetPath string) error {
+	mounted, err := IsMounted(ctx, targetPath)
+	if err != nil {
+		return err
+	}
+	if !mounted {
+		klog.V(4).Infof("Path %s is not mounted, skipping unmount", targetPath)
+		return nil
+	}
+	if err := os.Remove(targetPath); err != nil {
+		return fmt.Errorf("failed to unmount: %w", err)
+	}
+	return nil
+}
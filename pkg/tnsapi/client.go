@@ -8,6 +8,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	neturl "net/url"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -46,19 +49,22 @@ const (
 
 // Static errors for client operations.
 var (
-	ErrAuthenticationRejected = errors.New("authentication failed: Storage system rejected API key - verify key is correct and not revoked in System Settings -> API Keys")
-	ErrResponseIDMismatch     = errors.New("authentication response ID mismatch")
-	ErrClientClosed           = errors.New("client is closed")
-	ErrConnectionClosed       = errors.New("connection closed while waiting for response")
-	ErrCloneFailed            = errors.New("clone operation returned false (unsuccessful)")
-	ErrClonedDatasetNotFound  = errors.New("cloned dataset not found after successful clone")
-	ErrSubsystemNotFound      = errors.New("subsystem not found - ensure subsystem is pre-configured in TrueNAS")
-	ErrMultipleSubsystems     = errors.New("multiple subsystems found with same NQN")
-	ErrListSubsystemsFailed   = errors.New("failed to list NVMe-oF subsystems with all methods")
-	ErrDatasetNotFound        = errors.New("dataset not found")
-	ErrJobNotFound            = errors.New("job not found")
-	ErrJobFailed              = errors.New("job failed")
-	ErrJobAborted             = errors.New("job was aborted")
+	ErrAuthenticationRejected  = errors.New("authentication failed: Storage system rejected API key - verify key is correct and not revoked in System Settings -> API Keys")
+	ErrResponseIDMismatch      = errors.New("authentication response ID mismatch")
+	ErrClientClosed            = errors.New("client is closed")
+	ErrEmptyAPIKey             = errors.New("API key must not be empty")
+	ErrUnsupportedProxyScheme  = errors.New("unsupported proxy URL scheme")
+	ErrConnectionClosed        = errors.New("connection closed while waiting for response")
+	ErrCloneFailed             = errors.New("clone operation returned false (unsuccessful)")
+	ErrClonedDatasetNotFound   = errors.New("cloned dataset not found after successful clone")
+	ErrSubsystemNotFound       = errors.New("subsystem not found - ensure subsystem is pre-configured in TrueNAS")
+	ErrMultipleSubsystems      = errors.New("multiple subsystems found with same NQN")
+	ErrListSubsystemsFailed    = errors.New("failed to list NVMe-oF subsystems with all methods")
+	ErrDatasetNotFound         = errors.New("dataset not found")
+	ErrJobNotFound             = errors.New("job not found")
+	ErrCloudCredentialNotFound = errors.New("cloud credential not found")
+	ErrJobFailed               = errors.New("job failed")
+	ErrJobAborted              = errors.New("job was aborted")
 
 	// Deletion operation errors - TrueNAS API returned false (unsuccessful).
 	ErrDatasetDeletionFailed           = errors.New("dataset deletion returned false (unsuccessful)")
@@ -80,7 +86,8 @@ type Client struct {
 	conn          *websocket.Conn
 	pending       map[string]chan *Response
 	closeCh       chan struct{}
-	url           string
+	url           string   // Endpoint in use
+	endpoints     []string // HA endpoints from a comma-separated URL (nil = url only)
 	apiKey        string
 	connectedAt   time.Time // Track connection start time for metrics
 	retryInterval time.Duration
@@ -88,7 +95,62 @@ type Client struct {
 	maxRetries    int
 	closed        bool
 	reconnecting  bool
-	skipTLSVerify bool // Skip TLS certificate verification
+	skipTLSVerify bool           // Skip TLS certificate verification
+	proxyURL      string         // Explicit HTTP/HTTPS/SOCKS5 proxy (empty = use HTTPS_PROXY/NO_PROXY from environment)
+	faults        *FaultInjector // Test-only fault injection (nil = disabled)
+	timeouts      Timeouts
+	jobs          jobEvents      // core.get_jobs events for WatchJob
+	payloads      payloadSampler // Sampling of logged raw payloads
+	schemas       schemaChecker  // TrueNAS version and reported response schema mismatches
+}
+
+// ClientOption configures optional Client behavior.
+type ClientOption func(*Client)
+
+// WithProxyURL routes the WebSocket connection through the given proxy.
+// Supported schemes are http, https and socks5. An empty value keeps the default
+// behavior of honoring HTTP_PROXY/HTTPS_PROXY/NO_PROXY from the environment.
+func WithProxyURL(proxyURL string) ClientOption {
+	return func(c *Client) {
+		c.proxyURL = strings.TrimSpace(proxyURL)
+	}
+}
+
+// Default timeouts for establishing a storage API session.
+const (
+	DefaultConnectTimeout = 10 * time.Second
+	DefaultAuthTimeout    = 10 * time.Second
+)
+
+// Timeouts bounds storage API operations.
+// Zero Connect and Auth values use the defaults; zero Call and Job values disable the limit,
+// leaving only the caller's context deadline.
+type Timeouts struct {
+	Connect time.Duration // WebSocket dial
+	Auth    time.Duration // API key authentication
+	Call    time.Duration // A single Call, including its connection retries
+	Job     time.Duration // Waiting for a job in WaitForJob or WatchJob
+}
+
+func (t Timeouts) connect() time.Duration {
+	if t.Connect > 0 {
+		return t.Connect
+	}
+	return DefaultConnectTimeout
+}
+
+func (t Timeouts) auth() time.Duration {
+	if t.Auth > 0 {
+		return t.Auth
+	}
+	return DefaultAuthTimeout
+}
+
+// WithTimeouts sets the timeouts for connecting, authenticating, calls and job waits.
+func WithTimeouts(t Timeouts) ClientOption {
+	return func(c *Client) {
+		c.timeouts = t
+	}
 }
 
 // Request represents a storage API WebSocket request (JSON-RPC 2.0 format).
@@ -100,11 +162,14 @@ type Request struct {
 }
 
 // Response represents a storage API WebSocket response.
+// Notifications (e.g. collection_update events) have no ID and carry Method and Params instead.
 type Response struct {
 	Error  *Error          `json:"error,omitempty"`
 	ID     string          `json:"id"`
 	Msg    string          `json:"msg,omitempty"`
 	Result json.RawMessage `json:"result,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
 }
 
 // Error represents a storage API error.
@@ -179,21 +244,46 @@ func isAuthenticationError(err error) bool {
 
 // NewClient creates a new storage API client.
 // skipTLSVerify should be set to true only for self-signed certificates (common in TrueNAS deployments).
-func NewClient(url, apiKey string, skipTLSVerify bool) (*Client, error) {
+// url may list the API endpoints of both controllers of an HA pair, separated by commas.
+func NewClient(url, apiKey string, skipTLSVerify bool, opts ...ClientOption) (*Client, error) {
 	klog.V(4).Infof("Creating new storage API client for %s (skipTLSVerify=%v)", url, skipTLSVerify)
 
+	endpoints := ParseAPIURLs(url)
+	if len(endpoints) == 0 {
+		endpoints = []string{url}
+	}
+	startURL := endpoints[0]
+
 	// Trim whitespace from API key (common issue with secrets)
 	apiKey = strings.TrimSpace(apiKey)
 	klog.V(5).Infof("API key length after trim: %d characters", len(apiKey))
 
-	c := &Client{
-		url:           url,
-		apiKey:        apiKey,
-		pending:       make(map[string]chan *Response),
-		closeCh:       make(chan struct{}),
-		maxRetries:    5,
-		retryInterval: 5 * time.Second,
-		skipTLSVerify: skipTLSVerify,
+	// Resolve fault injection once so rule counters survive connection retries
+	envFaults := faultInjectorFromEnv()
+
+	newInstance := func() *Client {
+		inst := &Client{
+			url:           startURL,
+			endpoints:     endpoints,
+			apiKey:        apiKey,
+			pending:       make(map[string]chan *Response),
+			closeCh:       make(chan struct{}),
+			maxRetries:    5,
+			retryInterval: 5 * time.Second,
+			skipTLSVerify: skipTLSVerify,
+			faults:        envFaults,
+		}
+		for _, opt := range opts {
+			opt(inst)
+		}
+		return inst
+	}
+
+	c := newInstance()
+
+	// Validate proxy configuration up front - a malformed proxy URL is not worth retrying
+	if _, err := c.proxyFunc(); err != nil {
+		return nil, err
 	}
 
 	// Connect to WebSocket with retry logic
@@ -202,23 +292,18 @@ func NewClient(url, apiKey string, skipTLSVerify bool) (*Client, error) {
 	retryDelays := []time.Duration{0, 5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second}
 
 	var lastConnErr error
+	standbys := make(map[string]bool) // HA endpoints found to be the standby controller
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
 		if attempt > 1 {
 			klog.Warningf("Connection attempt %d/%d to TrueNAS failed: %v", attempt-1, maxAttempts, lastConnErr)
-			delay := retryDelays[attempt-1]
-			klog.Infof("Retrying connection in %v...", delay)
-			time.Sleep(delay)
+			// Move on to the peer of a standby controller right away
+			if delay := retryDelays[attempt-1]; !errors.Is(lastConnErr, ErrStandbyController) || standbys[startURL] {
+				klog.Infof("Retrying connection in %v...", delay)
+				time.Sleep(delay)
+			}
 
 			// Create a fresh client instance for retry to avoid goroutine conflicts
-			c = &Client{
-				url:           url,
-				apiKey:        apiKey,
-				pending:       make(map[string]chan *Response),
-				closeCh:       make(chan struct{}),
-				maxRetries:    5,
-				retryInterval: 5 * time.Second,
-				skipTLSVerify: skipTLSVerify,
-			}
+			c = newInstance()
 		}
 
 		klog.V(4).Infof("Attempting to connect to TrueNAS (attempt %d/%d)", attempt, maxAttempts)
@@ -256,6 +341,18 @@ func NewClient(url, apiKey string, skipTLSVerify bool) (*Client, error) {
 			continue
 		}
 
+		// Connected to the standby head of an HA pair - retry starting with its peer
+		if err := c.checkActiveController(false); err != nil {
+			c.Close()
+			lastConnErr = err
+			standbys[c.url] = true
+			startURL = c.nextEndpoint()
+			if attempt == maxAttempts {
+				return nil, fmt.Errorf("failed to connect after %d attempts: %w", maxAttempts, err)
+			}
+			continue
+		}
+
 		// Success — only log at info level if retries were needed
 		if attempt > 1 {
 			klog.Infof("Successfully connected to TrueNAS on attempt %d/%d", attempt, maxAttempts)
@@ -269,39 +366,45 @@ func NewClient(url, apiKey string, skipTLSVerify bool) (*Client, error) {
 	return nil, fmt.Errorf("failed to initialize client after %d attempts: %w", maxAttempts, lastConnErr)
 }
 
-// connect establishes WebSocket connection.
+// connect establishes the WebSocket connection, trying the active endpoint first and then
+// its HA peers (see endpoints.go).
 func (c *Client) connect() error {
-	klog.V(4).Infof("Connecting to storage WebSocket at %s", c.url)
+	endpoints := c.apiEndpoints()
+	start := max(slices.Index(endpoints, c.url), 0)
+
+	var errs []error
+	for i := range endpoints {
+		url := endpoints[(start+i)%len(endpoints)]
+		if err := c.dial(url); err != nil {
+			if len(endpoints) > 1 {
+				klog.Warningf("Storage API endpoint %s unreachable: %v", url, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		c.setActiveEndpoint(url)
+		return nil
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// dial establishes a WebSocket connection to url.
+func (c *Client) dial(url string) error {
+	klog.V(4).Infof("Connecting to storage WebSocket at %s", url)
 
-	// Configure HTTP client with TLS settings
-	httpClient := &http.Client{}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeouts.connect())
+	defer cancel()
 
-	// For wss:// connections, configure TLS based on skipTLSVerify setting
-	if strings.HasPrefix(c.url, "wss://") {
-		var tlsConfig *tls.Config
-		if c.skipTLSVerify {
-			klog.V(4).Info("TLS certificate verification disabled (skipTLSVerify=true)")
-			//nolint:gosec // G402: TLS InsecureSkipVerify set true - intentional when user explicitly enables skipTLSVerify for self-signed certs
-			tlsConfig = &tls.Config{
-				InsecureSkipVerify: true,
-				MinVersion:         tls.VersionTLS12,
-			}
-		} else {
-			// Use secure TLS config with system CA pool
-			tlsConfig = &tls.Config{
-				MinVersion: tls.VersionTLS12,
-			}
-		}
-		httpClient.Transport = &http.Transport{
-			TLSClientConfig: tlsConfig,
-		}
+	httpClient, err := c.httpClient(url)
+	if err != nil {
+		return err
 	}
 
 	// coder/websocket handles ping/pong automatically
-	conn, resp, err := websocket.Dial(ctx, c.url, &websocket.DialOptions{
+	conn, resp, err := websocket.Dial(ctx, url, &websocket.DialOptions{
 		HTTPClient: httpClient,
 	})
 	if resp != nil && resp.Body != nil {
@@ -321,6 +424,8 @@ func (c *Client) connect() error {
 
 	c.conn = conn
 	c.connectedAt = time.Now()
+	// Subscriptions do not survive the connection they were made on
+	c.jobs.connectionReset()
 
 	// Update connection metrics
 	metrics.SetWSConnectionStatus(true)
@@ -328,22 +433,80 @@ func (c *Client) connect() error {
 	return nil
 }
 
+// httpClient returns an HTTP client with the proxy and TLS settings for connections to url.
+func (c *Client) httpClient(url string) (*http.Client, error) {
+	proxy, err := c.proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	// Configure HTTP client with proxy and TLS settings
+	transport := &http.Transport{
+		Proxy: proxy,
+	}
+
+	// For wss:// and https:// connections, configure TLS based on skipTLSVerify setting
+	if strings.HasPrefix(url, "wss://") || strings.HasPrefix(url, "https://") {
+		var tlsConfig *tls.Config
+		if c.skipTLSVerify {
+			klog.V(4).Info("TLS certificate verification disabled (skipTLSVerify=true)")
+			//nolint:gosec // G402: TLS InsecureSkipVerify set true - intentional when user explicitly enables skipTLSVerify for self-signed certs
+			tlsConfig = &tls.Config{
+				InsecureSkipVerify: true,
+				MinVersion:         tls.VersionTLS12,
+			}
+		} else {
+			// Use secure TLS config with system CA pool
+			tlsConfig = &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// proxyFunc returns the proxy selector for the WebSocket dialer.
+// An explicit proxy URL takes precedence over HTTPS_PROXY/HTTP_PROXY/NO_PROXY.
+func (c *Client) proxyFunc() (func(*http.Request) (*neturl.URL, error), error) {
+	if c.proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	u, err := neturl.Parse(c.proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %q: %w", c.proxyURL, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("%w: %q (expected http, https or socks5)", ErrUnsupportedProxyScheme, u.Scheme)
+	}
+
+	klog.V(4).Infof("Using proxy %s for storage WebSocket connection", u.Redacted())
+	return http.ProxyURL(u), nil
+}
+
 // authenticate performs API key authentication using JSON-RPC 2.0.
 func (c *Client) authenticate() error {
 	klog.V(4).Info("Authenticating with storage system using auth.login_with_api_key")
 
 	// Storage system uses JSON-RPC 2.0 for authentication
 	// Call auth.login_with_api_key with the API key
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeouts.auth())
 	defer cancel()
 
+	c.mu.Lock()
+	apiKey := c.apiKey
+	c.mu.Unlock()
+
 	var authResult bool
-	if err := c.Call(ctx, methodAuthLoginWithAPIKey, []interface{}{c.apiKey}, &authResult); err != nil {
+	if err := c.Call(ctx, methodAuthLoginWithAPIKey, []interface{}{apiKey}, &authResult); err != nil {
 		return fmt.Errorf("authentication failed: %w", err)
 	}
 
 	if !authResult {
-		klog.Errorf("Storage system rejected API key (length: %d)", len(c.apiKey))
+		klog.Errorf("Storage system rejected API key (length: %d)", len(apiKey))
 		return ErrAuthenticationRejected
 	}
 
@@ -351,54 +514,108 @@ func (c *Client) authenticate() error {
 	return nil
 }
 
+// UpdateAPIKey re-authenticates the current session with a new API key.
+// The existing WebSocket connection is kept, so in-flight calls are not interrupted.
+// The new key is only stored (and used for future reconnects) after the storage
+// system accepts it; on failure the client keeps using the previous key.
+func (c *Client) UpdateAPIKey(ctx context.Context, apiKey string) error {
+	apiKey = strings.TrimSpace(apiKey)
+	if apiKey == "" {
+		return ErrEmptyAPIKey
+	}
+
+	c.mu.Lock()
+	unchanged := apiKey == c.apiKey
+	c.mu.Unlock()
+	if unchanged {
+		klog.V(4).Info("API key unchanged, skipping re-authentication")
+		return nil
+	}
+
+	klog.Info("Re-authenticating with storage system using updated API key")
+
+	var authResult bool
+	if err := c.Call(ctx, methodAuthLoginWithAPIKey, []interface{}{apiKey}, &authResult); err != nil {
+		return fmt.Errorf("re-authentication with updated API key failed: %w", err)
+	}
+
+	if !authResult {
+		klog.Errorf("Storage system rejected updated API key (length: %d)", len(apiKey))
+		return ErrAuthenticationRejected
+	}
+
+	c.mu.Lock()
+	c.apiKey = apiKey
+	c.mu.Unlock()
+
+	klog.Info("Successfully re-authenticated with updated API key")
+	return nil
+}
+
 // authenticateDirect performs API key authentication by directly reading from WebSocket
 // This is used during reconnection when readLoop is blocked and can't handle responses.
 func (c *Client) authenticateDirect() error {
 	klog.V(4).Info("Authenticating with storage system using auth.login_with_api_key (direct mode)")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeouts.auth())
 	defer cancel()
 
+	c.mu.Lock()
+	apiKey := c.apiKey
+	c.mu.Unlock()
+
+	var authResult bool
+	if err := c.callDirect(ctx, methodAuthLoginWithAPIKey, []interface{}{apiKey}, &authResult); err != nil {
+		return fmt.Errorf("authentication error: %w", err)
+	}
+
+	if !authResult {
+		klog.Errorf("Storage system rejected API key (length: %d)", len(apiKey))
+		return ErrAuthenticationRejected
+	}
+
+	klog.V(4).Info("Successfully authenticated with storage system (direct mode)")
+	return nil
+}
+
+// callDirect makes a JSON-RPC 2.0 call by reading the response directly from the WebSocket.
+// This is used during reconnection when readLoop is blocked and can't handle responses.
+// Params are not logged since they may contain credentials.
+func (c *Client) callDirect(ctx context.Context, method string, params []interface{}, result interface{}) error {
 	c.mu.Lock()
 
 	// Generate request ID
 	id := strconv.FormatUint(atomic.AddUint64(&c.reqID, 1), 10)
 
-	// Create authentication request
 	req := &Request{
 		ID:      id,
 		JSONRPC: "2.0",
-		Method:  methodAuthLoginWithAPIKey,
-		Params:  []interface{}{c.apiKey},
+		Method:  method,
+		Params:  params,
 	}
 
-	// Send request (log method only, not params which contain sensitive data)
-	klog.V(5).Infof("Sending authentication request: method=%s, id=%s", req.Method, req.ID)
+	klog.V(5).Infof("Sending direct request: method=%s, id=%s", req.Method, req.ID)
 	if err := wsjson.Write(ctx, c.conn, req); err != nil {
 		c.mu.Unlock()
-		return fmt.Errorf("failed to send authentication request: %w", err)
+		return fmt.Errorf("failed to send %s request: %w", method, err)
 	}
 	c.mu.Unlock()
 
 	// Read response directly (don't use readLoop)
 	_, rawMsg, err := c.conn.Read(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to read authentication response: %w", err)
+		return fmt.Errorf("failed to read %s response: %w", method, err)
 	}
 
-	klog.V(5).Infof("Received raw response: %s", string(rawMsg))
+	klog.V(5).Infof("Received raw response: %s", RedactString(string(rawMsg)))
 
-	// Parse response
 	var resp Response
 	if err := json.Unmarshal(rawMsg, &resp); err != nil {
-		return fmt.Errorf("failed to unmarshal authentication response: %w", err)
+		return fmt.Errorf("failed to unmarshal %s response: %w", method, err)
 	}
 
-	klog.V(5).Infof("Parsed response: %+v", resp)
-
-	// Check for errors
 	if resp.Error != nil {
-		return fmt.Errorf("authentication error: %w", resp.Error)
+		return resp.Error
 	}
 
 	// Verify response ID matches
@@ -406,20 +623,11 @@ func (c *Client) authenticateDirect() error {
 		return fmt.Errorf("%w: expected %s, got %s", ErrResponseIDMismatch, id, resp.ID)
 	}
 
-	// Parse auth result
-	var authResult bool
-	if resp.Result != nil {
-		if err := json.Unmarshal(resp.Result, &authResult); err != nil {
-			return fmt.Errorf("failed to unmarshal authentication result: %w", err)
+	if result != nil && resp.Result != nil {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("failed to unmarshal %s result: %w", method, err)
 		}
 	}
-
-	if !authResult {
-		klog.Errorf("Storage system rejected API key (length: %d)", len(c.apiKey))
-		return ErrAuthenticationRejected
-	}
-
-	klog.V(4).Info("Successfully authenticated with storage system (direct mode)")
 	return nil
 }
 
@@ -445,6 +653,12 @@ func (c *Client) Call(ctx context.Context, method string, params []interface{},
 	timer := metrics.NewWSMessageTimer(method)
 	defer timer.Observe()
 
+	if c.timeouts.Call > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeouts.Call)
+		defer cancel()
+	}
+
 	// Retry configuration: 3 attempts with exponential backoff (1s, 2s, 4s)
 	const maxRetries = 3
 	var lastErr error
@@ -531,6 +745,12 @@ func (c *Client) callOnce(ctx context.Context, method string, params []interface
 		return fmt.Errorf("failed to send request: %w", err)
 	}
 	metrics.RecordWSMessage("sent")
+	fault := c.faults.match(method)
+	if fault != nil && fault.Reconnect {
+		klog.Warningf("Fault injection: closing connection after %s (id=%s)", method, id)
+		//nolint:errcheck,gosec // G104: the read loop picks up the closed connection and reconnects
+		c.conn.CloseNow()
+	}
 	c.mu.Unlock()
 
 	// Wait for response
@@ -541,6 +761,11 @@ func (c *Client) callOnce(ctx context.Context, method string, params []interface
 			return ErrConnectionClosed
 		}
 		metrics.RecordWSMessage("received")
+		if fault != nil {
+			if err := c.injectResponseFault(ctx, fault, method, resp); err != nil {
+				return err
+			}
+		}
 		if resp.Error != nil {
 			return resp.Error
 		}
@@ -549,6 +774,7 @@ func (c *Client) callOnce(ctx context.Context, method string, params []interface
 				return fmt.Errorf("failed to unmarshal result: %w", err)
 			}
 		}
+		c.checkResponseSchema(ctx, method, resp.Result)
 		return nil
 	case <-ctx.Done():
 		c.mu.Lock()
@@ -560,6 +786,37 @@ func (c *Client) callOnce(ctx context.Context, method string, params []interface
 	}
 }
 
+// injectResponseFault applies the response faults of a rule to a received response.
+// It returns an error when the fault keeps the response from reaching the caller.
+func (c *Client) injectResponseFault(ctx context.Context, fault *FaultRule, method string, resp *Response) error {
+	if fault.Drop {
+		klog.Warningf("Fault injection: dropping response to %s (id=%s)", method, resp.ID)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.closeCh:
+			return ErrClientClosed
+		}
+	}
+	if fault.Delay > 0 {
+		klog.Warningf("Fault injection: delaying response to %s (id=%s) by %v", method, resp.ID, fault.Delay)
+		select {
+		case <-time.After(fault.Delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.closeCh:
+			return ErrClientClosed
+		}
+	}
+	if fault.Duplicate {
+		if rawMsg, err := json.Marshal(resp); err == nil {
+			klog.Warningf("Fault injection: duplicating response to %s (id=%s)", method, resp.ID)
+			c.processResponse(rawMsg)
+		}
+	}
+	return nil
+}
+
 // readLoop reads responses from WebSocket.
 func (c *Client) readLoop() {
 	defer c.cleanupReadLoop()
@@ -638,13 +895,22 @@ func (c *Client) reinitializeConnection() bool {
 		return true // Continue loop to retry
 	}
 
+	if err := c.checkActiveController(true); err != nil {
+		klog.Errorf("Connection reinitialization failed: %v, will retry", err)
+		// Drop the standby connection so the next read error reconnects, starting with the peer
+		c.useEndpoint(c.nextEndpoint())
+		//nolint:errcheck,gosec // G104: Intentionally ignoring close error, the connection is abandoned
+		c.conn.Close(websocket.StatusGoingAway, "standby controller")
+		return true
+	}
+
 	klog.Info("Successfully reinitialized WebSocket connection")
 	return true
 }
 
 // processResponse unmarshals and dispatches a response to the waiting caller.
 func (c *Client) processResponse(rawMsg []byte) {
-	klog.V(5).Infof("Received raw response: %s", string(rawMsg))
+	klog.V(5).Infof("Received raw response: %s", RedactString(string(rawMsg)))
 
 	var resp Response
 	if err := json.Unmarshal(rawMsg, &resp); err != nil {
@@ -652,7 +918,12 @@ func (c *Client) processResponse(rawMsg []byte) {
 		return
 	}
 
-	klog.V(5).Infof("Parsed response: %+v", resp)
+	klog.V(5).Infof("Parsed response: %s", Redact(resp))
+
+	if resp.ID == "" && resp.Method != "" {
+		c.handleNotification(resp.Method, resp.Params)
+		return
+	}
 
 	c.mu.Lock()
 	if ch, ok := c.pending[resp.ID]; ok {
@@ -734,6 +1005,12 @@ func (c *Client) reconnect() bool {
 			continue
 		}
 
+		if err := c.checkActiveController(true); err != nil {
+			klog.Errorf("Reconnection attempt %d failed: %v", attempt, err)
+			c.useEndpoint(c.nextEndpoint())
+			continue
+		}
+
 		klog.Infof("Successfully reconnected on attempt %d", attempt)
 		return true
 	}
@@ -837,9 +1114,13 @@ type Pool struct {
 		Capacity struct {
 			Parsed int64 `json:"parsed"` // Capacity percentage (0-100)
 		} `json:"capacity"`
+		Dedupratio map[string]interface{} `json:"dedupratio,omitempty"` // Pool-wide deduplication ratio, e.g. "1.25x"
 	} `json:"properties"`
 }
 
+// DedupRatio returns the pool-wide deduplication ratio (1 = no savings), or 0 if not reported.
+func (p *Pool) DedupRatio() float64 { return propertyRatio(p.Properties.Dedupratio) }
+
 // QueryPool retrieves information about a specific ZFS pool.
 func (c *Client) QueryPool(ctx context.Context, poolName string) (*Pool, error) {
 	klog.V(4).Infof("Querying pool: %s", poolName)
@@ -959,13 +1240,71 @@ type DatasetCreateParams struct {
 
 // Dataset represents a ZFS dataset.
 type Dataset struct {
-	Available  map[string]interface{} `json:"available,omitempty"`
-	Used       map[string]interface{} `json:"used,omitempty"`
-	Volsize    map[string]interface{} `json:"volsize,omitempty"` // ZVOL size (for VOLUME type datasets)
-	ID         string                 `json:"id"`
-	Name       string                 `json:"name"`
-	Type       string                 `json:"type"`
-	Mountpoint string                 `json:"mountpoint,omitempty"`
+	Available     map[string]interface{} `json:"available,omitempty"`
+	Used          map[string]interface{} `json:"used,omitempty"`
+	Referenced    map[string]interface{} `json:"referenced,omitempty"`    // Data referenced by the dataset, excluding snapshots
+	LogicalUsed   map[string]interface{} `json:"logicalused,omitempty"`   // Used space before compression
+	CompressRatio map[string]interface{} `json:"compressratio,omitempty"` // Achieved compression ratio, e.g. "1.52"
+	Deduplication map[string]interface{} `json:"deduplication,omitempty"` // Deduplication setting: OFF, ON, VERIFY, ...
+	Volsize       map[string]interface{} `json:"volsize,omitempty"`       // ZVOL size (for VOLUME type datasets)
+	RefQuota      map[string]interface{} `json:"refquota,omitempty"`      // Quota of FILESYSTEM datasets
+	Quota         map[string]interface{} `json:"quota,omitempty"`         // Quota including descendants and snapshots
+	Volblocksize  map[string]interface{} `json:"volblocksize,omitempty"`  // ZVOL block size
+	Written       map[string]interface{} `json:"written,omitempty"`       // Data written since the latest snapshot, or since the origin of a clone
+	ID            string                 `json:"id"`
+	Name          string                 `json:"name"`
+	Type          string                 `json:"type"`
+	Mountpoint    string                 `json:"mountpoint,omitempty"`
+}
+
+// UsedBytes returns the space used by the dataset and its snapshots, or 0 if not reported.
+func (d *Dataset) UsedBytes() int64 { return datasetBytes(d.Used) }
+
+// ReferencedBytes returns the data referenced by the dataset, or 0 if not reported.
+func (d *Dataset) ReferencedBytes() int64 { return datasetBytes(d.Referenced) }
+
+// WrittenBytes returns the data written since the latest snapshot or clone origin, or 0 if not reported.
+func (d *Dataset) WrittenBytes() int64 { return datasetBytes(d.Written) }
+
+// LogicalUsedBytes returns the used space before compression, or 0 if not reported.
+func (d *Dataset) LogicalUsedBytes() int64 { return datasetBytes(d.LogicalUsed) }
+
+// CompressionRatio returns the achieved compression ratio (1 = uncompressed), or 0 if not reported.
+func (d *Dataset) CompressionRatio() float64 { return propertyRatio(d.CompressRatio) }
+
+// DeduplicationEnabled reports whether deduplication is enabled on the dataset.
+func (d *Dataset) DeduplicationEnabled() bool {
+	value, _ := d.Deduplication["value"].(string)
+	return value != "" && !strings.EqualFold(value, "off")
+}
+
+// propertyRatio extracts a ratio property reported as {"parsed": 1.52 or "1.52x", "rawvalue": "1.52", ...}.
+func propertyRatio(prop map[string]interface{}) float64 {
+	switch parsed := prop["parsed"].(type) {
+	case float64:
+		return parsed
+	case string:
+		ratio, _ := strconv.ParseFloat(strings.TrimSuffix(parsed, "x"), 64)
+		return ratio
+	}
+	if raw, ok := prop["rawvalue"].(string); ok {
+		ratio, _ := strconv.ParseFloat(strings.TrimSuffix(raw, "x"), 64)
+		return ratio
+	}
+	return 0
+}
+
+// datasetBytes extracts a numeric dataset property reported as {"parsed": <bytes>, "rawvalue": "<bytes>", ...}.
+func datasetBytes(prop map[string]interface{}) int64 {
+	if parsed, ok := prop["parsed"].(float64); ok {
+		return int64(parsed)
+	}
+	if raw, ok := prop["rawvalue"].(string); ok {
+		if size, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return size
+		}
+	}
+	return 0
 }
 
 // CreateDataset creates a new ZFS dataset.
@@ -982,20 +1321,32 @@ func (c *Client) CreateDataset(ctx context.Context, params DatasetCreateParams)
 	return &result, nil
 }
 
-// DeleteDataset deletes a ZFS dataset.
-func (c *Client) DeleteDataset(ctx context.Context, datasetID string) error {
-	klog.Infof("DeleteDataset: Starting deletion of dataset %s", datasetID)
+// DatasetDeleteOptions controls how DeleteDatasetWithOptions destroys a dataset.
+type DatasetDeleteOptions struct {
+	Recursive bool // Also destroy child datasets and snapshots
+	Force     bool // Unmount busy datasets
+}
 
+// DeleteDataset deletes a ZFS dataset with all its child datasets and snapshots.
+func (c *Client) DeleteDataset(ctx context.Context, datasetID string) error {
 	// Recursive delete removes the dataset and all child snapshots atomically.
 	// This is safe because the caller's guard (datasetHasCSIManagedSnapshots) already
 	// verified no CSI-managed snapshots exist before reaching this point.
 	// Matches democratic-csi's approach: guard first, then recursive delete.
+	return c.DeleteDatasetWithOptions(ctx, datasetID, DatasetDeleteOptions{Recursive: true, Force: true})
+}
+
+// DeleteDatasetWithOptions deletes a ZFS dataset. Without Recursive, TrueNAS refuses to
+// delete a dataset that has child datasets or snapshots.
+func (c *Client) DeleteDatasetWithOptions(ctx context.Context, datasetID string, opts DatasetDeleteOptions) error {
+	klog.Infof("DeleteDataset: Starting deletion of dataset %s (recursive=%v, force=%v)", datasetID, opts.Recursive, opts.Force)
+
 	var result bool
 	params := []interface{}{
 		datasetID,
 		map[string]interface{}{
-			"recursive": true,
-			"force":     true,
+			"recursive": opts.Recursive,
+			"force":     opts.Force,
 		},
 	}
 	err := c.Call(ctx, "pool.dataset.delete", params, &result)
@@ -1017,6 +1368,42 @@ func (c *Client) DeleteDataset(ctx context.Context, datasetID string) error {
 	return nil
 }
 
+// StartDatasetDelete starts deleting a ZFS dataset as a TrueNAS job and returns the job ID.
+// pool.dataset.delete runs inline, so it is submitted through core.bulk. The bulk job succeeds
+// even if the deletion fails, so callers must confirm the dataset is gone.
+func (c *Client) StartDatasetDelete(ctx context.Context, datasetID string, opts DatasetDeleteOptions) (int, error) {
+	klog.Infof("StartDatasetDelete: Submitting deletion of dataset %s (recursive=%v, force=%v)", datasetID, opts.Recursive, opts.Force)
+
+	var jobID int
+	params := []interface{}{
+		"pool.dataset.delete",
+		[]interface{}{
+			[]interface{}{datasetID, map[string]interface{}{
+				"recursive": opts.Recursive,
+				"force":     opts.Force,
+			}},
+		},
+	}
+	if err := c.Call(ctx, "core.bulk", params, &jobID); err != nil {
+		return 0, fmt.Errorf("failed to start deletion of dataset %s: %w", datasetID, err)
+	}
+
+	klog.Infof("StartDatasetDelete: Started job %d for dataset %s", jobID, datasetID)
+	return jobID, nil
+}
+
+// RenameDataset renames a ZFS dataset, together with its child datasets and snapshots.
+func (c *Client) RenameDataset(ctx context.Context, datasetID, newName string) error {
+	klog.V(4).Infof("Renaming dataset %s to %s", datasetID, newName)
+
+	var result interface{}
+	params := []interface{}{datasetID, map[string]interface{}{"new_name": newName}}
+	if err := c.Call(ctx, "pool.dataset.rename", params, &result); err != nil {
+		return fmt.Errorf("failed to rename dataset %s to %s: %w", datasetID, newName, err)
+	}
+	return nil
+}
+
 // Dataset retrieves dataset information.
 // Returns ErrDatasetNotFound if the dataset does not exist.
 func (c *Client) Dataset(ctx context.Context, datasetID string) (*Dataset, error) {
@@ -1049,9 +1436,13 @@ type NFSShareCreateParams struct {
 	Comment      string   `json:"comment,omitempty"`
 	MaprootUser  string   `json:"maproot_user,omitempty"`
 	MaprootGroup string   `json:"maproot_group,omitempty"`
+	MapallUser   string   `json:"mapall_user,omitempty"`
+	MapallGroup  string   `json:"mapall_group,omitempty"`
 	Hosts        []string `json:"hosts,omitempty"`
 	Networks     []string `json:"networks,omitempty"`
+	Security     []string `json:"security,omitempty"` // SYS, KRB5, KRB5I, KRB5P ("" = server default)
 	Enabled      bool     `json:"enabled"`
+	ReadOnly     bool     `json:"ro,omitempty"`
 }
 
 // NFSShare represents an NFS share.
@@ -1096,6 +1487,47 @@ func (c *Client) DeleteNFSShare(ctx context.Context, shareID int) error {
 	return nil
 }
 
+// NFSShareUpdateParams holds parameters for updating an NFS share.
+type NFSShareUpdateParams struct {
+	Hosts   *[]string `json:"hosts,omitempty"`   // Hosts allowed to mount the share (empty = any host, nil = unchanged)
+	Comment string    `json:"comment,omitempty"` // Share comment (empty = unchanged)
+}
+
+// UpdateNFSShare updates an existing NFS share.
+func (c *Client) UpdateNFSShare(ctx context.Context, shareID int, params NFSShareUpdateParams) (*NFSShare, error) {
+	klog.V(4).Infof("Updating NFS share %d: %s", shareID, Redact(params))
+
+	var result NFSShare
+	err := c.Call(ctx, "sharing.nfs.update", []interface{}{shareID, params}, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update NFS share %d: %w", shareID, err)
+	}
+
+	return &result, nil
+}
+
+// NFSConfig represents the NFS service configuration.
+type NFSConfig struct {
+	V4Domain     string   `json:"v4_domain"`
+	Protocols    []string `json:"protocols"`      // NFSV3, NFSV4 (TrueNAS 24.04+)
+	V4Krb        bool     `json:"v4_krb"`         // Kerberos required for NFSv4
+	V4KrbEnabled bool     `json:"v4_krb_enabled"` // Kerberos usable: v4_krb set or a keytab is configured
+}
+
+// GetNFSConfig retrieves the NFS service configuration.
+func (c *Client) GetNFSConfig(ctx context.Context) (*NFSConfig, error) {
+	klog.V(4).Infof("Getting NFS service configuration")
+
+	var result NFSConfig
+	err := c.Call(ctx, "nfs.config", []interface{}{}, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get NFS config: %w", err)
+	}
+
+	klog.V(4).Infof("NFS config: v4_krb_enabled=%t, v4_domain=%s", result.V4KrbEnabled, result.V4Domain)
+	return &result, nil
+}
+
 // QueryNFSShare queries NFS shares by path.
 func (c *Client) QueryNFSShare(ctx context.Context, path string) ([]NFSShare, error) {
 	klog.V(4).Infof("Querying NFS shares for path: %s", path)
@@ -1138,11 +1570,16 @@ func (c *Client) QueryNFSShareByID(ctx context.Context, shareID int) (*NFSShare,
 
 // SMBShareCreateParams represents parameters for SMB share creation.
 type SMBShareCreateParams struct {
-	Name    string `json:"name"`
-	Path    string `json:"path"`
-	Comment string `json:"comment,omitempty"`
-	Purpose string `json:"purpose,omitempty"` // DEFAULT_SHARE, LEGACY_SHARE, etc.
-	Enabled bool   `json:"enabled"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Comment  string `json:"comment,omitempty"`
+	Purpose  string `json:"purpose,omitempty"` // DEFAULT_SHARE, LEGACY_SHARE, etc.
+	Enabled  bool   `json:"enabled"`
+	ReadOnly bool   `json:"ro,omitempty"`
+
+	// Browsable and GuestOK are left at the TrueNAS defaults (browsable, no guest access) when nil.
+	Browsable *bool `json:"browsable,omitempty"`
+	GuestOK   *bool `json:"guestok,omitempty"`
 }
 
 // SMBShare represents an SMB share returned by TrueNAS.
@@ -1284,6 +1721,17 @@ func (c *Client) FilesystemStat(ctx context.Context, path string) error {
 	return nil
 }
 
+// FilesystemMkdir creates a directory on TrueNAS with the given octal mode (e.g. "777").
+// The parent directory must exist.
+func (c *Client) FilesystemMkdir(ctx context.Context, path, mode string) error {
+	var result map[string]interface{}
+	err := c.Call(ctx, "filesystem.mkdir", []interface{}{path, map[string]interface{}{"mode": mode}}, &result)
+	if err != nil {
+		return fmt.Errorf("filesystem.mkdir failed for %s: %w", path, err)
+	}
+	return nil
+}
+
 // GetFilesystemACL retrieves the ACL information for a path.
 // Returns the acltype ("NFS4" or "POSIX1E") and the full ACL response.
 // This is useful for diagnosing ACL issues on ZFS clones.
@@ -1299,35 +1747,59 @@ func (c *Client) GetFilesystemACL(ctx context.Context, path string) (string, err
 	return acltype, nil
 }
 
+// NFS4ACE is an NFSv4 access control entry with a BASIC permission set. Entries are inherited
+// by new files and directories.
+type NFS4ACE struct {
+	Tag   string // owner@, group@, everyone@, USER or GROUP
+	Who   string // User or group name for USER/GROUP entries (e.g. "CORP\k8s-devs")
+	Perms string // FULL_CONTROL, MODIFY, READ or TRAVERSE
+}
+
+// NFS4 ACE tags and BASIC permission sets.
+const (
+	ACETagOwner      = "owner@"
+	ACETagGroup      = "group@"
+	ACETagEveryone   = "everyone@"
+	ACETagNamedGroup = "GROUP"
+
+	ACLPermsFullControl = aclFullControl
+	ACLPermsModify      = "MODIFY"
+	ACLPermsRead        = "READ"
+)
+
+// OpenSMBACL grants owner@, group@ and everyone@ full control.
+var OpenSMBACL = []NFS4ACE{
+	{Tag: ACETagOwner, Perms: ACLPermsFullControl},
+	{Tag: ACETagGroup, Perms: ACLPermsFullControl},
+	{Tag: ACETagEveryone, Perms: ACLPermsFullControl},
+}
+
 // SetFilesystemACL sets NFSv4 ACLs on a dataset to allow full access for SMB users.
 // SMB datasets are created with share_type=SMB which gives them NFSv4 ACLs, but
 // the default ACL only grants access to root. This sets everyone@ FULL_CONTROL
 // so any authenticated SMB user can read/write.
 func (c *Client) SetFilesystemACL(ctx context.Context, path string) error {
 	klog.Infof("SetFilesystemACL: setting NFSv4 ACL on %s (owner@/group@/everyone@ FULL_CONTROL)", path)
+	return c.SetFilesystemNFS4ACL(ctx, path, OpenSMBACL)
+}
 
-	dacl := []map[string]interface{}{
-		{
-			aclTagKey:   "owner@",
-			"id":        -1,
-			aclTypeKey:  aclTypeAllow,
-			aclPermsKey: map[string]string{aclBasic: aclFullControl},
-			aclFlagsKey: map[string]string{aclBasic: aclInherit},
-		},
-		{
-			aclTagKey:   "group@",
+// SetFilesystemNFS4ACL replaces the NFSv4 ACL of path with aces and waits for the job to finish.
+// Named USER/GROUP entries are resolved by TrueNAS, including Active Directory accounts.
+func (c *Client) SetFilesystemNFS4ACL(ctx context.Context, path string, aces []NFS4ACE) error {
+	dacl := make([]map[string]interface{}, 0, len(aces))
+	for _, ace := range aces {
+		entry := map[string]interface{}{
+			aclTagKey:   ace.Tag,
 			"id":        -1,
 			aclTypeKey:  aclTypeAllow,
-			aclPermsKey: map[string]string{aclBasic: aclFullControl},
+			aclPermsKey: map[string]string{aclBasic: ace.Perms},
 			aclFlagsKey: map[string]string{aclBasic: aclInherit},
-		},
-		{
-			aclTagKey:   "everyone@",
-			"id":        -1,
-			aclTypeKey:  aclTypeAllow,
-			aclPermsKey: map[string]string{aclBasic: aclFullControl},
-			aclFlagsKey: map[string]string{aclBasic: aclInherit},
-		},
+		}
+		if ace.Who != "" {
+			entry["who"] = ace.Who
+			delete(entry, "id")
+		}
+		dacl = append(dacl, entry)
 	}
 
 	params := map[string]interface{}{
@@ -1340,13 +1812,13 @@ func (c *Client) SetFilesystemACL(ctx context.Context, path string) error {
 		return fmt.Errorf("filesystem.setacl call failed for %s: %w", path, err)
 	}
 
-	klog.Infof("SetFilesystemACL: filesystem.setacl submitted as job %d for %s, waiting for completion", jobID, path)
+	klog.Infof("SetFilesystemNFS4ACL: filesystem.setacl submitted as job %d for %s, waiting for completion", jobID, path)
 
 	if err := c.WaitForJob(ctx, jobID, 1*time.Second); err != nil {
 		return fmt.Errorf("filesystem.setacl job %d failed for %s: %w", jobID, path, err)
 	}
 
-	klog.Infof("SetFilesystemACL: successfully set NFSv4 ACL on %s", path)
+	klog.Infof("SetFilesystemNFS4ACL: successfully set NFSv4 ACL (%d entries) on %s", len(aces), path)
 	return nil
 }
 
@@ -1411,7 +1883,8 @@ func (c *Client) CreateZvol(ctx context.Context, params ZvolCreateParams) (*Data
 type NVMeOFSubsystemCreateParams struct {
 	Name         string `json:"name"`
 	Subnqn       string `json:"subnqn"`
-	AllowAnyHost bool   `json:"allow_any_host"` // Allow any host to connect
+	Serial       string `json:"serial,omitempty"` // Controller serial number ("" = assigned by TrueNAS)
+	AllowAnyHost bool   `json:"allow_any_host"`   // Allow any host to connect
 }
 
 // NVMeOFSubsystem represents an NVMe-oF subsystem.
@@ -1762,19 +2235,13 @@ func (ps *NVMeOFPortSubsystem) GetSubsystemID() int {
 func (c *Client) QuerySubsystemPortBindings(ctx context.Context, subsystemID int) ([]NVMeOFPortSubsystem, error) {
 	klog.V(4).Infof("Querying port bindings for subsystem %d", subsystemID)
 
-	// First, get raw JSON to debug the actual field names
+	// Query the raw JSON first so the payload can be logged for debugging field names
 	var rawResult json.RawMessage
 	err := c.Call(ctx, "nvmet.port_subsys.query", []interface{}{}, &rawResult)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query port-subsystem bindings: %w", err)
 	}
-
-	// Log raw JSON for debugging (first 2000 chars to avoid log spam)
-	rawStr := string(rawResult)
-	if len(rawStr) > 2000 {
-		rawStr = rawStr[:2000] + "..."
-	}
-	klog.Infof("QuerySubsystemPortBindings: Raw JSON response: %s", rawStr)
+	c.logPayload("nvmet.port_subsys.query", rawResult)
 
 	// Now unmarshal into our struct
 	var allBindings []NVMeOFPortSubsystem
@@ -1782,7 +2249,7 @@ func (c *Client) QuerySubsystemPortBindings(ctx context.Context, subsystemID int
 		return nil, fmt.Errorf("failed to unmarshal port-subsystem bindings: %w", err)
 	}
 
-	klog.Infof("QuerySubsystemPortBindings: Found %d total port bindings", len(allBindings))
+	klog.V(5).Infof("QuerySubsystemPortBindings: Found %d total port bindings", len(allBindings))
 
 	// Filter for this specific subsystem
 	var result []NVMeOFPortSubsystem
@@ -1795,7 +2262,7 @@ func (c *Client) QuerySubsystemPortBindings(ctx context.Context, subsystemID int
 		}
 	}
 
-	klog.Infof("Found %d port binding(s) for subsystem %d", len(result), subsystemID)
+	klog.V(4).Infof("Found %d port binding(s) for subsystem %d", len(result), subsystemID)
 	return result, nil
 }
 
@@ -1845,11 +2312,12 @@ type DatasetUpdateParams struct {
 	Comments            string `json:"comments,omitempty"`             // Comments
 	Acltype             string `json:"acltype,omitempty"`              // ACL type: OFF, NFSV4, POSIX
 	Aclmode             string `json:"aclmode,omitempty"`              // ACL mode: PASSTHROUGH, RESTRICTED, DISCARD
+	Readonly            string `json:"readonly,omitempty"`             // Read-only mode: ON, OFF
 }
 
 // UpdateDataset updates a ZFS dataset or ZVOL.
 func (c *Client) UpdateDataset(ctx context.Context, datasetID string, params DatasetUpdateParams) (*Dataset, error) {
-	klog.V(4).Infof("Updating dataset: %s with params: %+v", datasetID, params)
+	klog.V(4).Infof("Updating dataset: %s with params: %s", datasetID, Redact(params))
 
 	var result Dataset
 	err := c.Call(ctx, "pool.dataset.update", []interface{}{datasetID, params}, &result)
@@ -1881,6 +2349,79 @@ type Snapshot struct {
 	Properties map[string]interface{} `json:"properties"` // ZFS properties
 }
 
+// CreationTime returns when the snapshot was taken, from its ZFS "creation" property.
+// TrueNAS reports it as {"rawvalue": "<unix seconds>", "parsed": {"$date": <unix millis>}, ...}.
+// Returns the zero time if the property is missing or cannot be parsed
+// (createtxg orders snapshots but carries no wall-clock time).
+func (s *Snapshot) CreationTime() time.Time {
+	prop, ok := s.Properties["creation"].(map[string]interface{})
+	if !ok {
+		return time.Time{}
+	}
+	if raw, ok := prop["rawvalue"].(string); ok {
+		if secs, err := strconv.ParseInt(raw, 10, 64); err == nil && secs > 0 {
+			return time.Unix(secs, 0)
+		}
+	}
+	switch parsed := prop["parsed"].(type) {
+	case map[string]interface{}:
+		if millis, ok := parsed["$date"].(float64); ok && millis > 0 {
+			return time.UnixMilli(int64(millis))
+		}
+	case float64:
+		if parsed > 0 {
+			return time.Unix(int64(parsed), 0)
+		}
+	}
+	return time.Time{}
+}
+
+// RestoreSizeBytes returns the space a volume restored from the snapshot needs: the
+// volume size for zvol snapshots, otherwise the data the snapshot references (falling
+// back to its used space). Returns 0 if none of these properties are present.
+func (s *Snapshot) RestoreSizeBytes() int64 {
+	for _, name := range []string{"volsize", "referenced", "used"} {
+		if size := s.bytesProperty(name); size > 0 {
+			return size
+		}
+	}
+	return 0
+}
+
+// ReferencedBytes returns the data the snapshot references, or 0 if not reported.
+func (s *Snapshot) ReferencedBytes() int64 { return s.bytesProperty("referenced") }
+
+// GUID returns the ZFS guid of the snapshot, which zfs send/receive preserves, or "" if not reported.
+func (s *Snapshot) GUID() string {
+	prop, ok := s.Properties["guid"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	if raw, ok := prop["rawvalue"].(string); ok {
+		return raw
+	}
+	value, _ := prop["value"].(string)
+	return value
+}
+
+// bytesProperty extracts a numeric ZFS property reported as
+// {"rawvalue": "<bytes>", "parsed": <bytes>, ...}.
+func (s *Snapshot) bytesProperty(name string) int64 {
+	prop, ok := s.Properties[name].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	if raw, ok := prop["rawvalue"].(string); ok {
+		if size, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return size
+		}
+	}
+	if parsed, ok := prop["parsed"].(float64); ok {
+		return int64(parsed)
+	}
+	return 0
+}
+
 // CreateSnapshot creates a new ZFS snapshot.
 func (c *Client) CreateSnapshot(ctx context.Context, params SnapshotCreateParams) (*Snapshot, error) {
 	klog.V(4).Infof("Creating snapshot %s for dataset %s", params.Name, params.Dataset)
@@ -2047,6 +2588,31 @@ func (c *Client) CloneSnapshot(ctx context.Context, params CloneSnapshotParams)
 	return &datasets[0], nil
 }
 
+// HoldSnapshot places a ZFS user hold on a snapshot.
+// A held snapshot cannot be destroyed: a plain delete fails and a deferred delete
+// (defer=true) only takes effect once every hold is released.
+// TrueNAS uses a fixed hold tag, so a snapshot carries at most one hold from the API.
+func (c *Client) HoldSnapshot(ctx context.Context, snapshotID string) error {
+	klog.V(4).Infof("Placing hold on snapshot %s", snapshotID)
+
+	var result json.RawMessage
+	if err := c.Call(ctx, "pool.snapshot.hold", []interface{}{snapshotID}, &result); err != nil {
+		return fmt.Errorf("failed to hold snapshot %s: %w", snapshotID, err)
+	}
+	return nil
+}
+
+// ReleaseSnapshot releases the ZFS user hold placed by HoldSnapshot.
+func (c *Client) ReleaseSnapshot(ctx context.Context, snapshotID string) error {
+	klog.V(4).Infof("Releasing hold on snapshot %s", snapshotID)
+
+	var result json.RawMessage
+	if err := c.Call(ctx, "pool.snapshot.release", []interface{}{snapshotID}, &result); err != nil {
+		return fmt.Errorf("failed to release snapshot %s: %w", snapshotID, err)
+	}
+	return nil
+}
+
 // PromoteDataset promotes a cloned dataset to become independent from its origin snapshot.
 // After promotion, the clone becomes a standalone dataset with no dependency on the parent.
 // This is essential for "detached snapshots" where you want an independent copy of data.
@@ -2135,23 +2701,70 @@ func (c *Client) QueryAllNFSShares(ctx context.Context, pathFilter string) ([]NF
 	return result, nil
 }
 
+// Paginated query helpers
+//
+// TrueNAS query methods accept "order_by" and "limit" query options. Listing thousands
+// of datasets in one response is slow and can exceed the call timeout, so these helpers
+// fetch one bounded page at a time, ordered by ID.
+
+// Query option keys for server-side pagination.
+const (
+	queryOptOrderBy = "order_by"
+	queryOptLimit   = "limit"
+)
+
+// DefaultQueryPageSize is the page size used when callers stream a full listing page by page.
+const DefaultQueryPageSize = 500
+
+// pagedQueryOptions returns query options for an ID-ordered page of at most limit results.
+// A non-positive limit means no limit.
+func pagedQueryOptions(limit int) map[string]interface{} {
+	opts := map[string]interface{}{
+		queryOptOrderBy: []string{"id"},
+	}
+	if limit > 0 {
+		opts[queryOptLimit] = limit
+	}
+	return opts
+}
+
+// QueryDatasetsPageAfter returns up to limit datasets (with user properties) whose ID sorts after afterID,
+// ordered by ID. This is keyset pagination: unlike offsets, the cursor stays valid when datasets are
+// created or deleted between pages. An empty afterID starts at the beginning.
+func (c *Client) QueryDatasetsPageAfter(ctx context.Context, afterID string, limit int) ([]DatasetWithProperties, error) {
+	klog.V(5).Infof("Querying datasets page after %q (limit %d)", afterID, limit)
+
+	queryFilters := []interface{}{}
+	if afterID != "" {
+		queryFilters = append(queryFilters, []interface{}{"id", ">", afterID})
+	}
+
+	queryOpts := pagedQueryOptions(limit)
+	queryOpts[queryOptExtra] = map[string]interface{}{
+		queryOptFlat:           true,
+		queryOptUserProperties: true,
+	}
+
+	var result []DatasetWithProperties
+	if err := c.Call(ctx, "pool.dataset.query", []interface{}{queryFilters, queryOpts}, &result); err != nil {
+		return nil, fmt.Errorf("failed to query datasets page: %w", err)
+	}
+
+	klog.V(5).Infof("Datasets page returned %d datasets", len(result))
+	return result, nil
+}
+
 // QueryAllNVMeOFNamespaces queries all NVMe-oF namespaces.
 func (c *Client) QueryAllNVMeOFNamespaces(ctx context.Context) ([]NVMeOFNamespace, error) {
 	klog.V(5).Info("Querying all NVMe-oF namespaces")
 
-	// First, get raw JSON to debug the actual field names
+	// Query the raw JSON first so the payload can be logged for debugging field names
 	var rawResult json.RawMessage
 	err := c.Call(ctx, "nvmet.namespace.query", []interface{}{}, &rawResult)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query NVMe-oF namespaces: %w", err)
 	}
-
-	// Log raw JSON for debugging (first 2000 chars to avoid log spam)
-	rawStr := string(rawResult)
-	if len(rawStr) > 2000 {
-		rawStr = rawStr[:2000] + "..."
-	}
-	klog.Infof("QueryAllNVMeOFNamespaces: Raw JSON response: %s", rawStr)
+	sampled := c.logPayload("nvmet.namespace.query", rawResult)
 
 	// Now unmarshal into our struct
 	var result []NVMeOFNamespace
@@ -2159,13 +2772,13 @@ func (c *Client) QueryAllNVMeOFNamespaces(ctx context.Context) ([]NVMeOFNamespac
 		return nil, fmt.Errorf("failed to unmarshal NVMe-oF namespaces: %w", err)
 	}
 
-	klog.Infof("QueryAllNVMeOFNamespaces: Found %d NVMe-oF namespaces", len(result))
-	// Log first 3 namespaces for debugging
+	klog.V(5).Infof("QueryAllNVMeOFNamespaces: Found %d NVMe-oF namespaces", len(result))
+	// Log the first 3 namespaces as decoded along with sampled payloads
 	for i, ns := range result {
-		if i >= 3 {
+		if !sampled || i >= 3 {
 			break
 		}
-		klog.Infof("QueryAllNVMeOFNamespaces: Sample namespace %d: ID=%d, Device='%s', DevicePath='%s', SubsystemID=%d, SubsystemNQN='%s', NSID=%d", i, ns.ID, ns.Device, ns.DevicePath, ns.GetSubsystemID(), ns.GetSubsystemNQN(), ns.NSID)
+		klog.V(payloadLogLevel).Infof("QueryAllNVMeOFNamespaces: Sample namespace %d: ID=%d, Device='%s', DevicePath='%s', SubsystemID=%d, SubsystemNQN='%s', NSID=%d", i, ns.ID, ns.Device, ns.DevicePath, ns.GetSubsystemID(), ns.GetSubsystemNQN(), ns.NSID)
 	}
 	return result, nil
 }
@@ -2228,6 +2841,139 @@ func (c *Client) SetDatasetProperties(ctx context.Context, datasetID string, pro
 	return nil
 }
 
+// DatasetUpdateBatch accumulates user property changes and regular dataset updates
+// (comment, quota, ...) so they can be applied with a single pool.dataset.update call.
+// Volume creation otherwise needs one call for the metadata properties and another
+// for the comment.
+//
+//nolint:govet // fieldalignment: keeping fields in logical order for readability
+type DatasetUpdateBatch struct {
+	DatasetID string
+	Params    DatasetUpdateParams
+	set       map[string]string
+	remove    []string
+}
+
+// NewDatasetUpdateBatch returns an empty batch for the given dataset.
+func NewDatasetUpdateBatch(datasetID string) *DatasetUpdateBatch {
+	return &DatasetUpdateBatch{DatasetID: datasetID, set: make(map[string]string)}
+}
+
+// SetProperty queues a user property to be set.
+func (b *DatasetUpdateBatch) SetProperty(key, value string) *DatasetUpdateBatch {
+	b.set[key] = value
+	return b
+}
+
+// SetProperties queues several user properties to be set.
+func (b *DatasetUpdateBatch) SetProperties(properties map[string]string) *DatasetUpdateBatch {
+	for key, value := range properties {
+		b.set[key] = value
+	}
+	return b
+}
+
+// RemoveProperty queues a user property to be removed (inherited).
+func (b *DatasetUpdateBatch) RemoveProperty(key string) *DatasetUpdateBatch {
+	delete(b.set, key)
+	b.remove = append(b.remove, key)
+	return b
+}
+
+// SetComment queues a dataset comment update. An empty comment is ignored.
+func (b *DatasetUpdateBatch) SetComment(comment string) *DatasetUpdateBatch {
+	b.Params.Comments = comment
+	return b
+}
+
+// Properties returns the queued user properties to set.
+func (b *DatasetUpdateBatch) Properties() map[string]string {
+	return b.set
+}
+
+// Empty reports whether the batch has nothing to apply.
+func (b *DatasetUpdateBatch) Empty() bool {
+	return len(b.set) == 0 && len(b.remove) == 0 && b.Params == (DatasetUpdateParams{})
+}
+
+// userPropertiesUpdate builds the user_properties_update list, sorted by key for a stable payload.
+func (b *DatasetUpdateBatch) userPropertiesUpdate() []map[string]interface{} {
+	keys := make([]string, 0, len(b.set))
+	for key := range b.set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	update := make([]map[string]interface{}, 0, len(b.set)+len(b.remove))
+	for _, key := range keys {
+		update = append(update, map[string]interface{}{queryOptKey: key, "value": b.set[key]})
+	}
+	for _, key := range b.remove {
+		update = append(update, map[string]interface{}{queryOptKey: key, "remove": true})
+	}
+	return update
+}
+
+// datasetBatchUpdater is implemented by API clients that can apply a DatasetUpdateBatch in one call.
+type datasetBatchUpdater interface {
+	ApplyDatasetUpdateBatch(ctx context.Context, batch *DatasetUpdateBatch) error
+}
+
+// Apply applies the batch. Clients that support batching (the real Client) get a single
+// pool.dataset.update call; other ClientInterface implementations fall back to the
+// individual UpdateDataset, SetDatasetProperties and ClearDatasetProperties calls.
+func (b *DatasetUpdateBatch) Apply(ctx context.Context, client ClientInterface) error {
+	if b.Empty() {
+		return nil
+	}
+	if batcher, ok := client.(datasetBatchUpdater); ok {
+		return batcher.ApplyDatasetUpdateBatch(ctx, b)
+	}
+
+	// Regular fields first so metadata (e.g. capacity) is only recorded once a resize succeeded
+	if b.Params != (DatasetUpdateParams{}) {
+		if _, err := client.UpdateDataset(ctx, b.DatasetID, b.Params); err != nil {
+			return err
+		}
+	}
+	if len(b.set) > 0 {
+		if err := client.SetDatasetProperties(ctx, b.DatasetID, b.set); err != nil {
+			return err
+		}
+	}
+	if len(b.remove) > 0 {
+		if err := client.ClearDatasetProperties(ctx, b.DatasetID, b.remove); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// datasetUpdateBatchParams is the pool.dataset.update payload for a batch:
+// the regular update fields plus the user property changes.
+type datasetUpdateBatchParams struct {
+	DatasetUpdateParams
+	UserPropertiesUpdate []map[string]interface{} `json:"user_properties_update,omitempty"`
+}
+
+// ApplyDatasetUpdateBatch applies all queued changes of a batch with a single pool.dataset.update call.
+func (c *Client) ApplyDatasetUpdateBatch(ctx context.Context, batch *DatasetUpdateBatch) error {
+	params := datasetUpdateBatchParams{
+		DatasetUpdateParams:  batch.Params,
+		UserPropertiesUpdate: batch.userPropertiesUpdate(),
+	}
+	klog.V(4).Infof("Applying batched update to dataset %s: %d properties set, %d removed, params: %s",
+		batch.DatasetID, len(batch.set), len(batch.remove), Redact(batch.Params))
+
+	var result Dataset
+	if err := c.Call(ctx, "pool.dataset.update", []interface{}{batch.DatasetID, params}, &result); err != nil {
+		return fmt.Errorf("failed to update dataset %s: %w", batch.DatasetID, err)
+	}
+
+	klog.V(4).Infof("Successfully applied batched update to dataset: %s", batch.DatasetID)
+	return nil
+}
+
 // SetSnapshotProperties sets ZFS user properties on a snapshot.
 // Properties are stored in the ZFS snapshot's user_properties field.
 // This is used to track CSI metadata like NFS share IDs, NVMe-oF subsystem IDs, etc.
@@ -2443,20 +3189,51 @@ func (c *Client) InheritDatasetProperty(ctx context.Context, datasetID, property
 }
 
 // ClearDatasetProperties removes multiple ZFS user properties from a dataset.
-// This is a convenience method that calls InheritDatasetProperty for each property.
+// All properties are removed with a single pool.dataset.update call.
 func (c *Client) ClearDatasetProperties(ctx context.Context, datasetID string, propertyNames []string) error {
 	klog.V(4).Infof("Clearing %d user properties from dataset: %s", len(propertyNames), datasetID)
 
+	if len(propertyNames) == 0 {
+		return nil
+	}
+
+	batch := NewDatasetUpdateBatch(datasetID)
 	for _, name := range propertyNames {
-		if err := c.InheritDatasetProperty(ctx, datasetID, name); err != nil {
-			return fmt.Errorf("failed to clear property %s: %w", name, err)
-		}
+		batch.RemoveProperty(name)
+	}
+	if err := c.ApplyDatasetUpdateBatch(ctx, batch); err != nil {
+		return fmt.Errorf("failed to clear properties %v: %w", propertyNames, err)
 	}
 
 	klog.V(4).Infof("Successfully cleared %d user properties from dataset: %s", len(propertyNames), datasetID)
 	return nil
 }
 
+// SetZFSProperties sets native ZFS properties (e.g. logbias, primarycache) on a dataset or ZVOL.
+// pool.dataset.update only exposes a subset of native properties, so this goes through
+// zfs.dataset.update, which passes values straight to ZFS (use lowercase ZFS values).
+func (c *Client) SetZFSProperties(ctx context.Context, datasetID string, properties map[string]string) error {
+	klog.V(4).Infof("Setting %d native ZFS properties on dataset: %s", len(properties), datasetID)
+
+	if len(properties) == 0 {
+		return nil
+	}
+
+	zfsProps := make(map[string]interface{}, len(properties))
+	for name, value := range properties {
+		zfsProps[name] = map[string]string{"value": value}
+	}
+	params := map[string]interface{}{"properties": zfsProps}
+
+	var result interface{}
+	if err := c.Call(ctx, "zfs.dataset.update", []interface{}{datasetID, params}, &result); err != nil {
+		return fmt.Errorf("failed to set ZFS properties on dataset %s: %w", datasetID, err)
+	}
+
+	klog.V(4).Infof("Successfully set native ZFS properties on dataset: %s", datasetID)
+	return nil
+}
+
 // ReplicationRunOnetimeParams contains parameters for running a one-time replication task.
 // This is used for creating detached snapshots via zfs send/receive.
 //
@@ -2480,43 +3257,6 @@ type ReplicationRunOnetimeParams struct {
 	AllowFromScratch        bool     `json:"allow_from_scratch"`         // Allow initial full send
 }
 
-// ReplicationJobState represents the state of a replication job.
-//
-//nolint:govet // fieldalignment: prefer readability over memory alignment for API response structs
-type ReplicationJobState struct {
-	ID          int                    `json:"id"`
-	Method      string                 `json:"method"`
-	State       string                 `json:"state"` // "WAITING", "RUNNING", "SUCCESS", "FAILED"
-	Progress    map[string]interface{} `json:"progress"`
-	Error       string                 `json:"error"`
-	Result      interface{}            `json:"result"`
-	TimeStarted *ejsonDate             `json:"time_started,omitempty"`
-	TimeEnded   *ejsonDate             `json:"time_finished,omitempty"`
-}
-
-type ejsonDate struct {
-	time.Time
-}
-
-func (e *ejsonDate) UnmarshalJSON(data []byte) error {
-	aux := struct {
-		Time int64 `json:"$date"`
-	}{}
-	if err := json.Unmarshal(data, &aux); err != nil {
-		return err
-	}
-	e.Time = time.UnixMilli(aux.Time)
-	return nil
-}
-
-func (e ejsonDate) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		Time int64 `json:"$date"`
-	}{
-		Time: e.UnixMilli(),
-	})
-}
-
 // RunOnetimeReplication runs a one-time replication task using zfs send/receive.
 // This is the core method for creating detached snapshots - it performs a full
 // data copy from source to destination without maintaining ZFS clone dependencies.
@@ -2540,82 +3280,81 @@ func (c *Client) RunOnetimeReplication(ctx context.Context, params ReplicationRu
 	return jobID, nil
 }
 
-// GetJobStatus retrieves the status of a job by its ID.
-// Used to poll for completion of long-running operations like replication.
-func (c *Client) GetJobStatus(ctx context.Context, jobID int) (*ReplicationJobState, error) {
-	klog.V(5).Infof("Getting job status for job %d", jobID)
-
-	var result ReplicationJobState
-	err := c.Call(ctx, "core.get_jobs", []interface{}{
-		[]interface{}{
-			[]interface{}{"id", "=", jobID},
-		},
-	}, &[]ReplicationJobState{result})
+// RunOnetimeReplicationAndWait runs a one-time replication and waits for completion.
+// This is a convenience method that combines RunOnetimeReplication and WaitForJob.
+func (c *Client) RunOnetimeReplicationAndWait(ctx context.Context, params ReplicationRunOnetimeParams, pollInterval time.Duration) error {
+	jobID, err := c.RunOnetimeReplication(ctx, params)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get job status: %w", err)
+		return err
 	}
 
-	// Query returns an array, we need to get the first element
-	var jobs []ReplicationJobState
-	err = c.Call(ctx, "core.get_jobs", []interface{}{
+	return c.WaitForJob(ctx, jobID, pollInterval)
+}
+
+// CloudSyncCredential is a cloud provider credential configured on TrueNAS (Credentials > Backup Credentials).
+type CloudSyncCredential struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	ID       int    `json:"id"`
+}
+
+// CloudSyncTaskParams describes a one-time cloud sync transfer between a local path and a bucket.
+//
+//nolint:govet // fieldalignment: prefer readability over memory alignment for config structs
+type CloudSyncTaskParams struct {
+	Description  string                 `json:"description"`
+	Path         string                 `json:"path"`          // Local path under /mnt
+	Credentials  int                    `json:"credentials"`   // Cloud credential ID
+	Direction    string                 `json:"direction"`     // "PUSH" (to bucket) or "PULL" (from bucket)
+	TransferMode string                 `json:"transfer_mode"` // "COPY", "SYNC" or "MOVE"
+	Attributes   map[string]interface{} `json:"attributes"`    // Provider attributes, e.g. bucket and folder
+	Transfers    int                    `json:"transfers,omitempty"`
+}
+
+// CloudSyncEntry is a single entry returned when listing a bucket folder.
+type CloudSyncEntry struct {
+	Path    string `json:"Path"`
+	Name    string `json:"Name"`
+	ModTime string `json:"ModTime"`
+	Size    int64  `json:"Size"`
+	IsDir   bool   `json:"IsDir"`
+}
+
+// CloudSyncCredentialByName looks up a cloud credential by name.
+func (c *Client) CloudSyncCredentialByName(ctx context.Context, name string) (*CloudSyncCredential, error) {
+	var result []CloudSyncCredential
+	err := c.Call(ctx, "cloudsync.credentials.query", []interface{}{
 		[]interface{}{
-			[]interface{}{"id", "=", jobID},
+			[]interface{}{"name", "=", name},
 		},
-	}, &jobs)
+	}, &result)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get job status: %w", err)
+		return nil, fmt.Errorf("failed to query cloud credentials: %w", err)
 	}
-
-	if len(jobs) == 0 {
-		return nil, fmt.Errorf("job %d: %w", jobID, ErrJobNotFound)
+	if len(result) == 0 {
+		return nil, fmt.Errorf("cloud credential %q: %w", name, ErrCloudCredentialNotFound)
 	}
-
-	return &jobs[0], nil
+	return &result[0], nil
 }
 
-// WaitForJob waits for a job to complete, polling at the specified interval.
-// Returns nil if the job succeeds, or an error if it fails or times out.
-func (c *Client) WaitForJob(ctx context.Context, jobID int, pollInterval time.Duration) error {
-	klog.V(4).Infof("Waiting for job %d to complete", jobID)
-
-	ticker := time.NewTicker(pollInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return fmt.Errorf("context canceled while waiting for job %d: %w", jobID, ctx.Err())
-		case <-ticker.C:
-			status, err := c.GetJobStatus(ctx, jobID)
-			if err != nil {
-				klog.Warningf("Failed to get job %d status: %v", jobID, err)
-				continue
-			}
+// RunOnetimeCloudSync starts a cloud sync transfer without creating a persistent task.
+// Returns the job ID for tracking progress.
+func (c *Client) RunOnetimeCloudSync(ctx context.Context, params CloudSyncTaskParams) (int, error) {
+	klog.Infof("RunOnetimeCloudSync: Starting %s of %s (credential %d)", params.Direction, params.Path, params.Credentials)
 
-			klog.V(5).Infof("Job %d state: %s", jobID, status.State)
-
-			switch status.State {
-			case "SUCCESS":
-				klog.V(4).Infof("Job %d completed successfully", jobID)
-				return nil
-			case "FAILED":
-				return fmt.Errorf("job %d: %w: %s", jobID, ErrJobFailed, status.Error)
-			case "ABORTED":
-				return fmt.Errorf("job %d: %w", jobID, ErrJobAborted)
-			case "WAITING", "RUNNING":
-				// Still in progress, continue polling
-				continue
-			default:
-				klog.Warningf("Unknown job state: %s", status.State)
-			}
-		}
+	var jobID int
+	err := c.Call(ctx, "cloudsync.sync_onetime", []interface{}{params, map[string]interface{}{"dry_run": false}}, &jobID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start one-time cloud sync: %w", err)
 	}
+
+	klog.Infof("RunOnetimeCloudSync: Started job %d for %s", jobID, params.Path)
+	return jobID, nil
 }
 
-// RunOnetimeReplicationAndWait runs a one-time replication and waits for completion.
-// This is a convenience method that combines RunOnetimeReplication and WaitForJob.
-func (c *Client) RunOnetimeReplicationAndWait(ctx context.Context, params ReplicationRunOnetimeParams, pollInterval time.Duration) error {
-	jobID, err := c.RunOnetimeReplication(ctx, params)
+// RunOnetimeCloudSyncAndWait starts a one-time cloud sync and waits for it to finish.
+func (c *Client) RunOnetimeCloudSyncAndWait(ctx context.Context, params CloudSyncTaskParams, pollInterval time.Duration) error {
+	jobID, err := c.RunOnetimeCloudSync(ctx, params)
 	if err != nil {
 		return err
 	}
@@ -2623,6 +3362,21 @@ func (c *Client) RunOnetimeReplicationAndWait(ctx context.Context, params Replic
 	return c.WaitForJob(ctx, jobID, pollInterval)
 }
 
+// ListCloudSyncDirectory lists a folder in a bucket using the given cloud credential.
+func (c *Client) ListCloudSyncDirectory(ctx context.Context, credentialID int, attributes map[string]interface{}) ([]CloudSyncEntry, error) {
+	var result []CloudSyncEntry
+	err := c.Call(ctx, "cloudsync.list_directory", []interface{}{
+		map[string]interface{}{
+			"credentials": credentialID,
+			"attributes":  attributes,
+		},
+	}, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cloud directory: %w", err)
+	}
+	return result, nil
+}
+
 // FindDatasetsByProperty searches for datasets that have a specific ZFS user property value.
 // This is useful for:
 // - Finding all volumes managed by tns-csi (property: tns-csi:managed_by, value: tns-csi)
@@ -2901,6 +3655,7 @@ type ISCSIExtentCreateParams struct {
 	Blocksize   int    `json:"blocksize,omitempty"`
 	InsecureTPC bool   `json:"insecure_tpc,omitempty"`
 	Xen         bool   `json:"xen,omitempty"`
+	ReadOnly    bool   `json:"ro,omitempty"`
 }
 
 // ISCSIExtent represents an iSCSI extent.
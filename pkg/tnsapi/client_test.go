@@ -23,7 +23,8 @@ type mockWSServer struct {
 	authResult      bool
 	authError       *Error
 	expectAuthKey   string
-	disconnectAfter int // Disconnect after N messages (0 = never)
+	failoverStatus  string // Result of failover.status ("" = echo default)
+	disconnectAfter int    // Disconnect after N messages (0 = never)
 	mu              sync.Mutex
 	msgCount        int
 }
@@ -103,6 +104,14 @@ func (m *mockWSServer) defaultHandler(ctx context.Context, conn *websocket.Conn)
 			continue
 		}
 
+		if req.Method == methodFailoverStatus && m.failoverStatus != "" {
+			respBytes, errMarshal := json.Marshal(Response{ID: req.ID, Result: json.RawMessage(`"` + m.failoverStatus + `"`)})
+			if errMarshal == nil {
+				conn.Write(ctx, websocket.MessageText, respBytes)
+			}
+			continue
+		}
+
 		// Echo back other requests with success
 		resp := Response{
 			ID:     req.ID,
@@ -220,6 +229,143 @@ func TestNewClientConnectionFailure(t *testing.T) {
 	}
 }
 
+func TestParseAPIURLs(t *testing.T) {
+	got := ParseAPIURLs(" wss://a/api/current, ,wss://b/api/current,wss://a/api/current")
+	want := []string{"wss://a/api/current", "wss://b/api/current"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("ParseAPIURLs() = %v, want %v", got, want)
+	}
+	if got := ParseAPIURLs(""); len(got) != 0 {
+		t.Errorf("ParseAPIURLs(\"\") = %v, want none", got)
+	}
+}
+
+func TestNewClientHAEndpoints(t *testing.T) {
+	active := newMockWSServer()
+	defer active.Close()
+	active.failoverStatus = "MASTER"
+
+	t.Run("unreachable first endpoint", func(t *testing.T) {
+		client, err := NewClient("ws://127.0.0.1:1/api/current,"+active.URL(), "test-api-key", false)
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+		defer cleanupClient(client)
+		if client.url != active.URL() {
+			t.Errorf("connected to %s, want %s", client.url, active.URL())
+		}
+	})
+
+	t.Run("standby first endpoint", func(t *testing.T) {
+		standby := newMockWSServer()
+		defer standby.Close()
+		standby.failoverStatus = failoverStatusBackup
+
+		client, err := NewClient(standby.URL()+","+active.URL(), "test-api-key", false)
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+		defer cleanupClient(client)
+		if client.url != active.URL() {
+			t.Errorf("connected to %s, want %s", client.url, active.URL())
+		}
+	})
+}
+
+// TestStreamRequestDuringFailover moves the client to the peer endpoint while stream requests
+// read the endpoint in use. Run with -race.
+func TestStreamRequestDuringFailover(t *testing.T) {
+	failover := make(chan struct{})
+	first := newMockWSServer()
+	defer first.Close()
+	first.handler = func(conn *websocket.Conn) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		// The failover drops the connections of the old active controller
+		select {
+		case <-failover:
+		default:
+			go func() {
+				select {
+				case <-failover:
+					conn.Close(websocket.StatusGoingAway, "failover")
+				case <-ctx.Done():
+				}
+			}()
+		}
+		for {
+			_, message, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			var req Request
+			if json.Unmarshal(message, &req) != nil {
+				continue
+			}
+			result := json.RawMessage(`true`)
+			if req.Method == methodFailoverStatus {
+				result = json.RawMessage(`"MASTER"`)
+				select {
+				case <-failover:
+					result = json.RawMessage(`"` + failoverStatusBackup + `"`)
+				default:
+				}
+			}
+			respBytes, err := json.Marshal(Response{ID: req.ID, Result: result})
+			if err == nil {
+				conn.Write(ctx, websocket.MessageText, respBytes)
+			}
+		}
+	}
+	peer := newMockWSServer()
+	defer peer.Close()
+	peer.failoverStatus = "MASTER"
+
+	fastRetry := func(c *Client) { c.retryInterval = 10 * time.Millisecond }
+	client, err := NewClient(first.URL()+","+peer.URL(), "test-api-key", false, fastRetry)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer cleanupClient(client)
+	endpoint := func() string {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.url
+	}
+	if endpoint() != first.URL() {
+		t.Fatalf("connected to %s, want %s", endpoint(), first.URL())
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// The mock servers only speak WebSocket: the requests fail, reading the endpoint is the point
+			if resp, err := client.streamRequest(context.Background(), http.MethodGet, "/_download", "", nil); err == nil {
+				resp.Body.Close()
+			}
+		}
+	}()
+
+	close(failover)
+	deadline := time.Now().Add(5 * time.Second)
+	for endpoint() != peer.URL() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+	if got := endpoint(); got != peer.URL() {
+		t.Errorf("endpoint after failover = %s, want %s", got, peer.URL())
+	}
+}
+
 func TestClientCall(t *testing.T) {
 	//nolint:govet // fieldalignment not critical for test code
 	tests := []struct {
@@ -589,6 +735,114 @@ func TestAuthenticateDirect(t *testing.T) {
 	}
 }
 
+func TestUpdateAPIKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		newKey  string
+		wantKey string
+		wantErr bool
+	}{
+		{
+			name:    "accepted key with surrounding whitespace",
+			newKey:  "  test-api-key\n",
+			wantKey: "test-api-key",
+		},
+		{
+			name:    "rejected key keeps previous key",
+			newKey:  "rotated-key",
+			wantKey: "old-key",
+			wantErr: true,
+		},
+		{
+			name:    "empty key",
+			newKey:  "   ",
+			wantKey: "old-key",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockWSServer()
+			defer server.Close()
+
+			// Simulate a client whose session was established with a key that has since been rotated
+			client := &Client{
+				url:           server.URL(),
+				apiKey:        "old-key",
+				pending:       make(map[string]chan *Response),
+				closeCh:       make(chan struct{}),
+				maxRetries:    5,
+				retryInterval: 5 * time.Second,
+			}
+			if err := client.connect(); err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			go client.readLoop()
+			defer cleanupClient(client)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err := client.UpdateAPIKey(ctx, tt.newKey)
+			if tt.wantErr && err == nil {
+				t.Error("Expected error but got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+
+			client.mu.Lock()
+			gotKey := client.apiKey
+			client.mu.Unlock()
+			if gotKey != tt.wantKey {
+				t.Errorf("apiKey = %q, want %q", gotKey, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestProxyFunc(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxyURL  string
+		wantProxy string
+		wantErr   bool
+	}{
+		{name: "explicit http proxy", proxyURL: "http://proxy.example.com:3128", wantProxy: "http://proxy.example.com:3128"},
+		{name: "explicit socks5 proxy", proxyURL: "socks5://10.0.0.1:1080", wantProxy: "socks5://10.0.0.1:1080"},
+		{name: "unsupported scheme", proxyURL: "ftp://proxy.example.com", wantErr: true},
+		{name: "malformed URL", proxyURL: "http://[::1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{}
+			WithProxyURL(tt.proxyURL)(client)
+
+			proxy, err := client.proxyFunc()
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			req, _ := http.NewRequest(http.MethodGet, "https://truenas.example.com/api/current", http.NoBody)
+			got, err := proxy(req)
+			if err != nil {
+				t.Fatalf("proxy() error: %v", err)
+			}
+			if got == nil || got.String() != tt.wantProxy {
+				t.Errorf("proxy() = %v, want %s", got, tt.wantProxy)
+			}
+		})
+	}
+}
+
 func TestClientClose(t *testing.T) {
 	server := newMockWSServer()
 	defer server.Close()
@@ -755,6 +1009,7 @@ func TestQueryPool(t *testing.T) {
 								Capacity struct {
 									Parsed int64 `json:"parsed"`
 								} `json:"capacity"`
+								Dedupratio map[string]interface{} `json:"dedupratio,omitempty"`
 							}{
 								Size: struct {
 									Parsed int64 `json:"parsed"`
@@ -880,3 +1135,343 @@ func TestQueryPool(t *testing.T) {
 		})
 	}
 }
+
+func TestDatasetUpdateBatchPayload(t *testing.T) {
+	batch := NewDatasetUpdateBatch("tank/pvc-1").
+		SetProperties(map[string]string{PropertyManagedBy: ManagedByValue, PropertyCapacityBytes: "1024"}).
+		SetProperty(PropertyPVName, "pvc-1").
+		RemoveProperty(PropertyAdoptable).
+		SetComment("hello")
+
+	if batch.Empty() {
+		t.Fatal("Empty() = true for a batch with changes")
+	}
+
+	data, err := json.Marshal(datasetUpdateBatchParams{
+		DatasetUpdateParams:  batch.Params,
+		UserPropertiesUpdate: batch.userPropertiesUpdate(),
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	want := `{"comments":"hello","user_properties_update":[` +
+		`{"key":"tns-csi:capacity_bytes","value":"1024"},` +
+		`{"key":"tns-csi:managed_by","value":"tns-csi"},` +
+		`{"key":"tns-csi:pv_name","value":"pvc-1"},` +
+		`{"key":"tns-csi:adoptable","remove":true}]}`
+	if string(data) != want {
+		t.Errorf("payload = %s, want %s", data, want)
+	}
+
+	if !NewDatasetUpdateBatch("tank/pvc-1").SetComment("").Empty() {
+		t.Error("Empty() = false for a batch with only an empty comment")
+	}
+}
+
+// fallbackUpdater is a ClientInterface without batch support that records individual update calls.
+type fallbackUpdater struct {
+	ClientInterface
+	calls []string
+}
+
+func (f *fallbackUpdater) UpdateDataset(_ context.Context, _ string, _ DatasetUpdateParams) (*Dataset, error) {
+	f.calls = append(f.calls, "update")
+	return &Dataset{}, nil
+}
+
+func (f *fallbackUpdater) SetDatasetProperties(_ context.Context, _ string, _ map[string]string) error {
+	f.calls = append(f.calls, "set")
+	return nil
+}
+
+func (f *fallbackUpdater) ClearDatasetProperties(_ context.Context, _ string, _ []string) error {
+	f.calls = append(f.calls, "clear")
+	return nil
+}
+
+func TestDatasetUpdateBatchApplyFallback(t *testing.T) {
+	client := &fallbackUpdater{}
+	size := int64(2048)
+	batch := NewDatasetUpdateBatch("tank/pvc-1").SetProperty(PropertyCapacityBytes, "2048").RemoveProperty(PropertyAdoptable)
+	batch.Params.Quota = &size
+
+	if err := batch.Apply(context.Background(), client); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if got := strings.Join(client.calls, ","); got != "update,set,clear" {
+		t.Errorf("calls = %s, want update,set,clear", got)
+	}
+
+	client.calls = nil
+	if err := NewDatasetUpdateBatch("tank/pvc-1").Apply(context.Background(), client); err != nil {
+		t.Fatalf("Apply of empty batch failed: %v", err)
+	}
+	if len(client.calls) != 0 {
+		t.Errorf("empty batch made calls: %v", client.calls)
+	}
+}
+
+func TestClearDatasetPropertiesSingleCall(t *testing.T) {
+	server := newMockWSServer()
+	defer server.Close()
+
+	var (
+		mu      sync.Mutex
+		updates []Request
+	)
+	server.handler = func(conn *websocket.Conn) {
+		ctx := context.Background()
+		for {
+			_, message, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			var req Request
+			if json.Unmarshal(message, &req) != nil {
+				continue
+			}
+			result := json.RawMessage(`true`)
+			if req.Method == "pool.dataset.update" {
+				mu.Lock()
+				updates = append(updates, req)
+				mu.Unlock()
+				result = json.RawMessage(`{"id":"tank/pvc-1","name":"tank/pvc-1"}`)
+			}
+			respBytes, err := json.Marshal(Response{ID: req.ID, Result: result})
+			if err != nil {
+				return
+			}
+			_ = conn.Write(ctx, websocket.MessageText, respBytes)
+		}
+	}
+
+	client, err := NewClient(server.URL(), "test-api-key", false)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer cleanupClient(client)
+
+	props := []string{PropertyAdoptable, PropertyPVCName, PropertyPVCNamespace}
+	if err := client.ClearDatasetProperties(context.Background(), "tank/pvc-1", props); err != nil {
+		t.Fatalf("ClearDatasetProperties failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(updates) != 1 {
+		t.Fatalf("got %d pool.dataset.update calls, want 1", len(updates))
+	}
+	raw, err := json.Marshal(updates[0].Params)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	for _, name := range props {
+		if !strings.Contains(string(raw), `{"key":"`+name+`","remove":true}`) {
+			t.Errorf("update params %s missing removal of %s", raw, name)
+		}
+	}
+}
+
+func TestPagedQueryOptions(t *testing.T) {
+	data, err := json.Marshal(pagedQueryOptions(500))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if want := `{"limit":500,"order_by":["id"]}`; string(data) != want {
+		t.Errorf("options = %s, want %s", data, want)
+	}
+
+	data, err = json.Marshal(pagedQueryOptions(0))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if want := `{"order_by":["id"]}`; string(data) != want {
+		t.Errorf("options = %s, want %s", data, want)
+	}
+}
+
+func TestSetZFSPropertiesPayload(t *testing.T) {
+	server := newMockWSServer()
+	defer server.Close()
+
+	var (
+		mu      sync.Mutex
+		updates []Request
+	)
+	server.handler = func(conn *websocket.Conn) {
+		ctx := context.Background()
+		for {
+			_, message, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			var req Request
+			if json.Unmarshal(message, &req) != nil {
+				continue
+			}
+			if req.Method == "zfs.dataset.update" {
+				mu.Lock()
+				updates = append(updates, req)
+				mu.Unlock()
+			}
+			respBytes, err := json.Marshal(Response{ID: req.ID, Result: json.RawMessage(`true`)})
+			if err != nil {
+				return
+			}
+			_ = conn.Write(ctx, websocket.MessageText, respBytes)
+		}
+	}
+
+	client, err := NewClient(server.URL(), "test-api-key", false)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer cleanupClient(client)
+
+	props := map[string]string{"logbias": "throughput", "primarycache": "metadata"}
+	if err := client.SetZFSProperties(context.Background(), "tank/pvc-1", props); err != nil {
+		t.Fatalf("SetZFSProperties failed: %v", err)
+	}
+	if err := client.SetZFSProperties(context.Background(), "tank/pvc-1", nil); err != nil {
+		t.Fatalf("SetZFSProperties with no properties failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(updates) != 1 {
+		t.Fatalf("got %d zfs.dataset.update calls, want 1", len(updates))
+	}
+	raw, err := json.Marshal(updates[0].Params)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	want := `["tank/pvc-1",{"properties":{"logbias":{"value":"throughput"},"primarycache":{"value":"metadata"}}}]`
+	if string(raw) != want {
+		t.Errorf("update params = %s, want %s", raw, want)
+	}
+}
+
+func TestClientTimeouts(t *testing.T) {
+	server := newMockWSServer()
+	defer server.Close()
+
+	client, err := NewClient(server.URL(), "test-api-key", false,
+		WithTimeouts(Timeouts{Call: 200 * time.Millisecond, Job: 300 * time.Millisecond}),
+		WithFaultInjector(NewFaultInjector(FaultRule{Method: "slow.method", Drop: true})))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer cleanupClient(client)
+
+	// A call without a caller deadline is bounded by the call timeout
+	var result bool
+	start := time.Now()
+	err = client.Call(context.Background(), "slow.method", nil, &result)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Call error = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Call returned after %v, want about 200ms", elapsed)
+	}
+
+	// The mock server never reports the job as finished, so only the job timeout ends the wait
+	err = client.WaitForJob(context.Background(), 42, 50*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitForJob error = %v, want DeadlineExceeded", err)
+	}
+
+	// Other calls are unaffected
+	if err := client.Call(context.Background(), "fast.method", nil, &result); err != nil {
+		t.Errorf("Call failed: %v", err)
+	}
+}
+
+func TestSnapshotCreationTime(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties map[string]interface{}
+		want       int64
+	}{
+		{
+			name:       "rawvalue",
+			properties: map[string]interface{}{"creation": map[string]interface{}{"rawvalue": "1704110400", "value": "Mon Jan  1 12:00 2024"}},
+			want:       1704110400,
+		},
+		{
+			name:       "parsed date",
+			properties: map[string]interface{}{"creation": map[string]interface{}{"parsed": map[string]interface{}{"$date": float64(1704110400000)}}},
+			want:       1704110400,
+		},
+		{
+			name:       "parsed seconds",
+			properties: map[string]interface{}{"creation": map[string]interface{}{"parsed": float64(1704110400)}},
+			want:       1704110400,
+		},
+		{
+			name:       "unparsable rawvalue falls back to parsed",
+			properties: map[string]interface{}{"creation": map[string]interface{}{"rawvalue": "-", "parsed": map[string]interface{}{"$date": float64(1704110400000)}}},
+			want:       1704110400,
+		},
+		{name: "missing property", properties: map[string]interface{}{}},
+		{name: "no properties"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snap := Snapshot{ID: "tank/vol@snap", Properties: tt.properties}
+			got := snap.CreationTime()
+			if tt.want == 0 {
+				if !got.IsZero() {
+					t.Errorf("CreationTime() = %v, want zero time", got)
+				}
+				return
+			}
+			if got.Unix() != tt.want {
+				t.Errorf("CreationTime() = %d, want %d", got.Unix(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotRestoreSizeBytes(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties map[string]interface{}
+		want       int64
+	}{
+		{
+			name: "zvol uses volsize",
+			properties: map[string]interface{}{
+				"volsize":    map[string]interface{}{"rawvalue": "10737418240", "parsed": float64(10737418240)},
+				"referenced": map[string]interface{}{"rawvalue": "1048576", "parsed": float64(1048576)},
+			},
+			want: 10737418240,
+		},
+		{
+			name:       "filesystem uses referenced",
+			properties: map[string]interface{}{"referenced": map[string]interface{}{"rawvalue": "1048576"}},
+			want:       1048576,
+		},
+		{
+			name:       "parsed value",
+			properties: map[string]interface{}{"referenced": map[string]interface{}{"parsed": float64(4096)}},
+			want:       4096,
+		},
+		{
+			name:       "falls back to used",
+			properties: map[string]interface{}{"used": map[string]interface{}{"rawvalue": "8192"}},
+			want:       8192,
+		},
+		{name: "no properties"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snap := Snapshot{ID: "tank/vol@snap", Properties: tt.properties}
+			if got := snap.RestoreSizeBytes(); got != tt.want {
+				t.Errorf("RestoreSizeBytes() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
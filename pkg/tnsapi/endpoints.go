@@ -0,0 +1,107 @@
+package tnsapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/fenio/tns-csi/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// HA endpoints.
+//
+// Each controller of a TrueNAS HA pair has its own API address. The API URL may list both,
+// separated by commas. The client connects to the first endpoint that answers, and on every
+// (re)connect asks it for its failover status: the standby controller reports BACKUP and is
+// skipped in favor of its peer. After a failover the old active controller drops the
+// connection, and the reconnect logic moves to the new one. The endpoint in use is exported
+// as the api_endpoint_active metric.
+
+// methodFailoverStatus reports the HA role of a controller ("MASTER", "BACKUP", "SINGLE", ...).
+const methodFailoverStatus = "failover.status"
+
+// failoverStatusBackup is the failover status of the standby controller.
+const failoverStatusBackup = "BACKUP"
+
+// ErrStandbyController is returned when an endpoint is the standby controller of an HA pair.
+var ErrStandbyController = errors.New("storage controller is the HA standby")
+
+// ParseAPIURLs splits a comma-separated list of API URLs, dropping empty entries.
+func ParseAPIURLs(value string) []string {
+	var urls []string
+	for _, field := range strings.Split(value, ",") {
+		if url := strings.TrimSpace(field); url != "" && !slices.Contains(urls, url) {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// apiEndpoints returns the endpoints to try, in configuration order.
+func (c *Client) apiEndpoints() []string {
+	if len(c.endpoints) == 0 {
+		return []string{c.url}
+	}
+	return c.endpoints
+}
+
+// nextEndpoint returns the endpoint after the one in use.
+func (c *Client) nextEndpoint() string {
+	endpoints := c.apiEndpoints()
+	idx := slices.Index(endpoints, c.url)
+	return endpoints[(idx+1)%len(endpoints)]
+}
+
+// useEndpoint makes url the endpoint in use. Only the connection goroutine changes it, but
+// streamRequest reads it from others, so writes take c.mu.
+func (c *Client) useEndpoint(url string) {
+	c.mu.Lock()
+	c.url = url
+	c.mu.Unlock()
+}
+
+// setActiveEndpoint records a successful connection to url.
+func (c *Client) setActiveEndpoint(url string) {
+	endpoints := c.apiEndpoints()
+	if url != c.url {
+		klog.Warningf("Switched storage API endpoint from %s to %s", c.url, url)
+		metrics.RecordAPIFailover()
+	}
+	c.useEndpoint(url)
+	if len(endpoints) > 1 {
+		metrics.SetActiveAPIEndpoint(url, endpoints)
+	}
+}
+
+// checkActiveController returns ErrStandbyController if the connected endpoint is the standby
+// controller of an HA pair. It is a no-op with a single endpoint, and a failing status call
+// (e.g. on systems without HA) is not treated as standby. direct reads the response from the
+// connection, for use while readLoop is blocked in reconnect.
+func (c *Client) checkActiveController(direct bool) error {
+	if len(c.apiEndpoints()) < 2 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeouts.auth())
+	defer cancel()
+
+	var status string
+	var err error
+	if direct {
+		err = c.callDirect(ctx, methodFailoverStatus, []interface{}{}, &status)
+	} else {
+		err = c.Call(ctx, methodFailoverStatus, []interface{}{}, &status)
+	}
+	if err != nil {
+		klog.V(4).Infof("Failover status of %s unavailable, assuming it is active: %v", c.url, err)
+		return nil
+	}
+	if status == failoverStatusBackup {
+		return fmt.Errorf("%w: %s", ErrStandbyController, c.url)
+	}
+	klog.V(4).Infof("Storage API endpoint %s failover status: %s", c.url, status)
+	return nil
+}
@@ -0,0 +1,155 @@
+package tnsapi
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Static errors for fault specification parsing.
+var (
+	ErrInvalidFaultSpec = errors.New("invalid fault specification")
+)
+
+// FaultRule describes a fault injected into calls of matching RPC methods.
+// Faults are for resilience testing only; production clients never carry a FaultInjector.
+//
+//nolint:govet // fieldalignment: fields are ordered for readability
+type FaultRule struct {
+	// Method is a path.Match pattern for the RPC method name ("*" matches every method).
+	Method string
+	// Delay holds each matching response back before it is delivered.
+	Delay time.Duration
+	// Probability is the chance that a matching call is affected (0 means always).
+	Probability float64
+	// Count limits how many calls the rule affects (0 means unlimited).
+	Count int
+	// Drop discards the response, so the caller waits until its context expires.
+	Drop bool
+	// Duplicate delivers the response a second time, like a server resending it.
+	Duplicate bool
+	// Reconnect closes the connection right after the request is sent, forcing the
+	// client through its reconnect and retry paths.
+	Reconnect bool
+}
+
+// FaultInjector decides which calls are hit by which fault rule.
+// A nil *FaultInjector injects nothing.
+type FaultInjector struct {
+	rand  *rand.Rand
+	rules []FaultRule
+	hits  []int
+	mu    sync.Mutex
+}
+
+// NewFaultInjector creates a FaultInjector; the first matching rule applies to a call.
+func NewFaultInjector(rules ...FaultRule) *FaultInjector {
+	return &FaultInjector{
+		rules: rules,
+		hits:  make([]int, len(rules)),
+		rand:  rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), 0)), //nolint:gosec // G404/G115: test-only randomness
+	}
+}
+
+// WithFaultInjector injects faults into the client's RPC calls.
+func WithFaultInjector(faults *FaultInjector) ClientOption {
+	return func(c *Client) {
+		c.faults = faults
+	}
+}
+
+// match returns the fault to inject into a call of method, or nil.
+// Authentication is never faulted so that forced reconnects can always recover.
+func (f *FaultInjector) match(method string) *FaultRule {
+	if f == nil || method == methodAuthLoginWithAPIKey {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.rules {
+		rule := &f.rules[i]
+		if ok, err := path.Match(rule.Method, method); err != nil || !ok {
+			continue
+		}
+		if rule.Count > 0 && f.hits[i] >= rule.Count {
+			continue
+		}
+		if rule.Probability > 0 && f.rand.Float64() >= rule.Probability {
+			return nil
+		}
+		f.hits[i]++
+		fault := *rule
+		return &fault
+	}
+	return nil
+}
+
+// ParseFaultRules parses a fault specification of the form
+//
+//	METHOD:ACTION[,ACTION...][;METHOD:ACTION...]
+//
+// where ACTION is one of drop, duplicate, reconnect, delay=DURATION,
+// probability=FLOAT or count=INT, for example
+// "pool.dataset.create:delay=2s,count=1;nvmet.*:drop,probability=0.1".
+func ParseFaultRules(spec string) ([]FaultRule, error) {
+	var rules []FaultRule
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		method, actions, ok := strings.Cut(entry, ":")
+		method = strings.TrimSpace(method)
+		if !ok || method == "" {
+			return nil, fmt.Errorf("%w: %q: expected METHOD:ACTION", ErrInvalidFaultSpec, entry)
+		}
+		if _, err := path.Match(method, ""); err != nil {
+			return nil, fmt.Errorf("%w: %q: bad method pattern: %w", ErrInvalidFaultSpec, entry, err)
+		}
+
+		rule := FaultRule{Method: method}
+		for _, action := range strings.Split(actions, ",") {
+			if err := rule.setAction(strings.TrimSpace(action)); err != nil {
+				return nil, fmt.Errorf("%w: %q: %w", ErrInvalidFaultSpec, entry, err)
+			}
+		}
+		if !rule.Drop && !rule.Duplicate && !rule.Reconnect && rule.Delay == 0 {
+			return nil, fmt.Errorf("%w: %q: no fault action given", ErrInvalidFaultSpec, entry)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// setAction applies one ACTION of a fault specification to the rule.
+func (r *FaultRule) setAction(action string) error {
+	name, value, hasValue := strings.Cut(action, "=")
+	var err error
+	switch {
+	case name == "drop" && !hasValue:
+		r.Drop = true
+	case name == "duplicate" && !hasValue:
+		r.Duplicate = true
+	case name == "reconnect" && !hasValue:
+		r.Reconnect = true
+	case name == "delay" && hasValue:
+		r.Delay, err = time.ParseDuration(value)
+	case name == "probability" && hasValue:
+		r.Probability, err = strconv.ParseFloat(value, 64)
+		if err == nil && (r.Probability < 0 || r.Probability > 1) {
+			err = fmt.Errorf("probability %v is outside [0, 1]", r.Probability)
+		}
+	case name == "count" && hasValue:
+		r.Count, err = strconv.Atoi(value)
+	default:
+		err = fmt.Errorf("unknown action %q", action)
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+//go:build faultinject
+
+package tnsapi
+
+import (
+	"os"
+
+	"k8s.io/klog/v2"
+)
+
+// faultSpecEnv names the environment variable holding the fault specification
+// (see ParseFaultRules). It is only read by binaries built with -tags faultinject.
+const faultSpecEnv = "TNS_CSI_FAULTS"
+
+// faultInjectorFromEnv builds a FaultInjector from TNS_CSI_FAULTS, or returns nil when unset.
+func faultInjectorFromEnv() *FaultInjector {
+	spec := os.Getenv(faultSpecEnv)
+	if spec == "" {
+		return nil
+	}
+	rules, err := ParseFaultRules(spec)
+	if err != nil {
+		klog.Errorf("Ignoring %s: %v", faultSpecEnv, err)
+		return nil
+	}
+	klog.Warningf("Fault injection enabled from %s: %q", faultSpecEnv, spec)
+	return NewFaultInjector(rules...)
+}
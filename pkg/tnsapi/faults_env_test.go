@@ -0,0 +1,23 @@
+//go:build faultinject
+
+package tnsapi
+
+import "testing"
+
+func TestFaultInjectorFromEnv(t *testing.T) {
+	t.Setenv(faultSpecEnv, "")
+	if faultInjectorFromEnv() != nil {
+		t.Error("expected no injector when TNS_CSI_FAULTS is unset")
+	}
+
+	t.Setenv(faultSpecEnv, "*:explode")
+	if faultInjectorFromEnv() != nil {
+		t.Error("expected an invalid spec to be ignored")
+	}
+
+	t.Setenv(faultSpecEnv, "pool.dataset.create:drop,count=1")
+	faults := faultInjectorFromEnv()
+	if fault := faults.match("pool.dataset.create"); fault == nil || !fault.Drop {
+		t.Errorf("got %+v, want drop fault from environment", fault)
+	}
+}
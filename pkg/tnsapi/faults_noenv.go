@@ -0,0 +1,9 @@
+//go:build !faultinject
+
+package tnsapi
+
+// faultInjectorFromEnv is a no-op in regular builds: TNS_CSI_FAULTS is only
+// honored by binaries built with -tags faultinject.
+func faultInjectorFromEnv() *FaultInjector {
+	return nil
+}
@@ -0,0 +1,186 @@
+package tnsapi
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseFaultRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []FaultRule
+		wantErr bool
+	}{
+		{
+			name: "empty spec",
+			spec: "",
+		},
+		{
+			name: "single rule",
+			spec: "pool.dataset.create:drop",
+			want: []FaultRule{{Method: "pool.dataset.create", Drop: true}},
+		},
+		{
+			name: "multiple rules with options",
+			spec: "pool.dataset.create:delay=2s,count=1; nvmet.*:duplicate,reconnect,probability=0.25;",
+			want: []FaultRule{
+				{Method: "pool.dataset.create", Delay: 2 * time.Second, Count: 1},
+				{Method: "nvmet.*", Duplicate: true, Reconnect: true, Probability: 0.25},
+			},
+		},
+		{name: "missing action", spec: "pool.dataset.create", wantErr: true},
+		{name: "missing method", spec: ":drop", wantErr: true},
+		{name: "only options", spec: "*:count=3", wantErr: true},
+		{name: "unknown action", spec: "*:explode", wantErr: true},
+		{name: "bad duration", spec: "*:delay=soon", wantErr: true},
+		{name: "probability out of range", spec: "*:drop,probability=2", wantErr: true},
+		{name: "bad pattern", spec: "[:drop", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFaultRules(tt.spec)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidFaultSpec) {
+					t.Fatalf("ParseFaultRules(%q) error = %v, want ErrInvalidFaultSpec", tt.spec, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFaultRules(%q) unexpected error: %v", tt.spec, err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseFaultRules(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("rule %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFaultInjectorMatch(t *testing.T) {
+	var nilInjector *FaultInjector
+	if nilInjector.match("pool.dataset.create") != nil {
+		t.Error("nil injector matched a call")
+	}
+
+	faults := NewFaultInjector(
+		FaultRule{Method: "pool.dataset.*", Drop: true, Count: 2},
+		FaultRule{Method: "*", Duplicate: true},
+	)
+	if faults.match(methodAuthLoginWithAPIKey) != nil {
+		t.Error("authentication must never be faulted")
+	}
+	for i := 0; i < 2; i++ {
+		if fault := faults.match("pool.dataset.create"); fault == nil || !fault.Drop {
+			t.Fatalf("call %d: got %+v, want drop fault", i, fault)
+		}
+	}
+	if fault := faults.match("pool.dataset.create"); fault == nil || fault.Drop || !fault.Duplicate {
+		t.Errorf("after count is exhausted: got %+v, want fallback duplicate fault", fault)
+	}
+
+	never := NewFaultInjector(FaultRule{Method: "*", Drop: true, Probability: 1e-12})
+	for i := 0; i < 100; i++ {
+		if never.match("pool.query") != nil {
+			t.Fatal("rule with negligible probability matched")
+		}
+	}
+}
+
+// withRetryInterval shortens the reconnect backoff so reconnect tests run quickly.
+func withRetryInterval(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.retryInterval = d
+	}
+}
+
+func TestClientFaultInjection(t *testing.T) {
+	tests := []struct {
+		check   func(t *testing.T, err error, elapsed time.Duration)
+		rule    FaultRule
+		timeout time.Duration
+		name    string
+	}{
+		{
+			name:    "delay",
+			rule:    FaultRule{Method: "test.method", Delay: 200 * time.Millisecond},
+			timeout: 5 * time.Second,
+			check: func(t *testing.T, err error, elapsed time.Duration) {
+				t.Helper()
+				if err != nil {
+					t.Fatalf("delayed call failed: %v", err)
+				}
+				if elapsed < 200*time.Millisecond {
+					t.Errorf("call returned after %v, want at least 200ms", elapsed)
+				}
+			},
+		},
+		{
+			name:    "drop",
+			rule:    FaultRule{Method: "test.*", Drop: true},
+			timeout: 200 * time.Millisecond,
+			check: func(t *testing.T, err error, _ time.Duration) {
+				t.Helper()
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Fatalf("dropped call error = %v, want DeadlineExceeded", err)
+				}
+			},
+		},
+		{
+			name:    "duplicate",
+			rule:    FaultRule{Method: "*", Duplicate: true},
+			timeout: 5 * time.Second,
+			check: func(t *testing.T, err error, _ time.Duration) {
+				t.Helper()
+				if err != nil {
+					t.Fatalf("call with duplicated response failed: %v", err)
+				}
+			},
+		},
+		{
+			name:    "reconnect",
+			rule:    FaultRule{Method: "test.method", Reconnect: true, Count: 1},
+			timeout: 10 * time.Second,
+			check: func(t *testing.T, err error, _ time.Duration) {
+				t.Helper()
+				if err != nil {
+					t.Fatalf("call was not retried after forced reconnect: %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockWSServer()
+			defer server.Close()
+
+			client, err := NewClient(server.URL(), "test-api-key", false,
+				WithFaultInjector(NewFaultInjector(tt.rule)), withRetryInterval(10*time.Millisecond))
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			defer cleanupClient(client)
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			var result bool
+			start := time.Now()
+			err = client.Call(ctx, "test.method", nil, &result)
+			tt.check(t, err, time.Since(start))
+
+			// The client stays usable after the fault
+			if err := client.Call(context.Background(), "other.method", nil, &result); err != nil {
+				t.Errorf("follow-up call failed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package tnsapi
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/klog/v2"
+)
+
+// Per-host access lists.
+//
+// NVMe-oF subsystems created with allow_any_host=false only accept the hosts linked to them:
+// nvmet.host records a host NQN once, nvmet.host_subsys links it to a subsystem. iSCSI targets
+// accept the initiators of the initiator groups in their portal/initiator groups; an initiator
+// group without initiators accepts any initiator.
+
+// NVMeOFHost is an NVMe host known to the NVMe-oF target.
+type NVMeOFHost struct {
+	HostNQN string `json:"hostnqn"`
+	ID      int    `json:"id"`
+}
+
+// NVMeOFHostSubsystem links a host to a subsystem it may connect to.
+// Like port bindings, TrueNAS returns the linked objects nested ("host", "subsys").
+type NVMeOFHostSubsystem struct {
+	Host   NVMeOFHost `json:"host"`
+	Subsys struct {
+		ID int `json:"id"`
+	} `json:"subsys"`
+	ID       int `json:"id"`
+	HostID   int `json:"host_id"`
+	SubsysID int `json:"subsys_id"`
+}
+
+// GetHostID returns the linked host ID from the direct or the nested field.
+func (hs *NVMeOFHostSubsystem) GetHostID() int {
+	if hs.HostID != 0 {
+		return hs.HostID
+	}
+	return hs.Host.ID
+}
+
+// GetSubsystemID returns the linked subsystem ID from the direct or the nested field.
+func (hs *NVMeOFHostSubsystem) GetSubsystemID() int {
+	if hs.SubsysID != 0 {
+		return hs.SubsysID
+	}
+	return hs.Subsys.ID
+}
+
+// NVMeOFHostByNQN finds an NVMe host by its NQN. Returns nil, nil when the host is not known.
+func (c *Client) NVMeOFHostByNQN(ctx context.Context, hostNQN string) (*NVMeOFHost, error) {
+	klog.V(4).Infof("Querying NVMe-oF host: %s", hostNQN)
+
+	var result []NVMeOFHost
+	err := c.Call(ctx, "nvmet.host.query", []interface{}{
+		[]interface{}{[]interface{}{"hostnqn", "=", hostNQN}},
+	}, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query NVMe-oF host %s: %w", hostNQN, err)
+	}
+	if len(result) == 0 {
+		return nil, nil //nolint:nilnil // nil, nil indicates "not found"
+	}
+	return &result[0], nil
+}
+
+// CreateNVMeOFHost registers an NVMe host NQN with the NVMe-oF target.
+func (c *Client) CreateNVMeOFHost(ctx context.Context, hostNQN string) (*NVMeOFHost, error) {
+	klog.V(4).Infof("Creating NVMe-oF host: %s", hostNQN)
+
+	var result NVMeOFHost
+	err := c.Call(ctx, "nvmet.host.create", []interface{}{
+		map[string]interface{}{"hostnqn": hostNQN},
+	}, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create NVMe-oF host %s: %w", hostNQN, err)
+	}
+
+	klog.V(4).Infof("Successfully created NVMe-oF host with ID: %d", result.ID)
+	return &result, nil
+}
+
+// QueryNVMeOFHostSubsystems returns the hosts linked to a subsystem.
+func (c *Client) QueryNVMeOFHostSubsystems(ctx context.Context, subsystemID int) ([]NVMeOFHostSubsystem, error) {
+	klog.V(4).Infof("Querying host links of subsystem %d", subsystemID)
+
+	var all []NVMeOFHostSubsystem
+	if err := c.Call(ctx, "nvmet.host_subsys.query", []interface{}{}, &all); err != nil {
+		return nil, fmt.Errorf("failed to query host-subsystem links: %w", err)
+	}
+
+	var result []NVMeOFHostSubsystem
+	for _, link := range all {
+		if link.GetSubsystemID() == subsystemID {
+			result = append(result, link)
+		}
+	}
+	return result, nil
+}
+
+// AddHostToSubsystem allows a host to connect to a subsystem.
+func (c *Client) AddHostToSubsystem(ctx context.Context, hostID, subsystemID int) error {
+	klog.V(4).Infof("Adding host %d to subsystem %d", hostID, subsystemID)
+
+	var result map[string]interface{}
+	err := c.Call(ctx, "nvmet.host_subsys.create", []interface{}{
+		map[string]interface{}{
+			"host_id":   hostID,
+			"subsys_id": subsystemID,
+		},
+	}, &result)
+	if err != nil {
+		return fmt.Errorf("failed to add host %d to subsystem %d: %w", hostID, subsystemID, err)
+	}
+	return nil
+}
+
+// RemoveHostFromSubsystem deletes a host-subsystem link.
+func (c *Client) RemoveHostFromSubsystem(ctx context.Context, hostSubsysID int) error {
+	klog.V(4).Infof("Removing host-subsystem link: %d", hostSubsysID)
+
+	var result bool
+	if err := c.Call(ctx, "nvmet.host_subsys.delete", []interface{}{hostSubsysID}, &result); err != nil {
+		return fmt.Errorf("failed to remove host-subsystem link %d: %w", hostSubsysID, err)
+	}
+	return nil
+}
+
+// ISCSIInitiatorCreateParams represents parameters for iSCSI initiator group creation.
+type ISCSIInitiatorCreateParams struct {
+	Comment    string   `json:"comment,omitempty"`
+	Initiators []string `json:"initiators"` // Allowed initiator IQNs (empty = any initiator)
+}
+
+// CreateISCSIInitiator creates an iSCSI initiator group.
+func (c *Client) CreateISCSIInitiator(ctx context.Context, params ISCSIInitiatorCreateParams) (*ISCSIInitiator, error) {
+	klog.V(4).Infof("Creating iSCSI initiator group: %v", params.Initiators)
+
+	var result ISCSIInitiator
+	if err := c.Call(ctx, "iscsi.initiator.create", []interface{}{params}, &result); err != nil {
+		return nil, fmt.Errorf("failed to create iSCSI initiator group: %w", err)
+	}
+
+	klog.V(4).Infof("Successfully created iSCSI initiator group with ID: %d", result.ID)
+	return &result, nil
+}
+
+// UpdateISCSITargetGroups replaces the portal/initiator groups of an iSCSI target.
+func (c *Client) UpdateISCSITargetGroups(ctx context.Context, targetID int, groups []ISCSITargetGroup) (*ISCSITarget, error) {
+	klog.V(4).Infof("Updating groups of iSCSI target %d: %+v", targetID, groups)
+
+	if groups == nil {
+		groups = []ISCSITargetGroup{}
+	}
+	var result ISCSITarget
+	err := c.Call(ctx, "iscsi.target.update", []interface{}{
+		targetID,
+		map[string]interface{}{"groups": groups},
+	}, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update iSCSI target %d: %w", targetID, err)
+	}
+	return &result, nil
+}
@@ -3,6 +3,7 @@ package tnsapi
 
 import (
 	"context"
+	"io"
 	"time"
 )
 
@@ -17,6 +18,9 @@ type ClientInterface interface {
 	// Dataset operations
 	CreateDataset(ctx context.Context, params DatasetCreateParams) (*Dataset, error)
 	DeleteDataset(ctx context.Context, datasetID string) error
+	DeleteDatasetWithOptions(ctx context.Context, datasetID string, opts DatasetDeleteOptions) error
+	StartDatasetDelete(ctx context.Context, datasetID string, opts DatasetDeleteOptions) (int, error)
+	RenameDataset(ctx context.Context, datasetID, newName string) error
 	Dataset(ctx context.Context, datasetID string) (*Dataset, error)
 	UpdateDataset(ctx context.Context, datasetID string, params DatasetUpdateParams) (*Dataset, error)
 	QueryAllDatasets(ctx context.Context, prefix string) ([]Dataset, error)
@@ -37,10 +41,14 @@ type ClientInterface interface {
 
 	// NFS share operations
 	CreateNFSShare(ctx context.Context, params NFSShareCreateParams) (*NFSShare, error)
+	UpdateNFSShare(ctx context.Context, shareID int, params NFSShareUpdateParams) (*NFSShare, error)
 	DeleteNFSShare(ctx context.Context, shareID int) error
 	QueryNFSShare(ctx context.Context, path string) ([]NFSShare, error)
 	QueryNFSShareByID(ctx context.Context, shareID int) (*NFSShare, error)
 	QueryAllNFSShares(ctx context.Context, pathPrefix string) ([]NFSShare, error)
+	GetNFSConfig(ctx context.Context) (*NFSConfig, error)
+	ListNFS4Clients(ctx context.Context) ([]NFS4Client, error)
+	ExpireNFS4Client(ctx context.Context, clientID string) error
 
 	// SMB share operations
 	CreateSMBShare(ctx context.Context, params SMBShareCreateParams) (*SMBShare, error)
@@ -52,8 +60,10 @@ type ClientInterface interface {
 
 	// Filesystem operations
 	FilesystemStat(ctx context.Context, path string) error
+	FilesystemMkdir(ctx context.Context, path, mode string) error
 	GetFilesystemACL(ctx context.Context, path string) (string, error)
 	SetFilesystemACL(ctx context.Context, path string) error
+	SetFilesystemNFS4ACL(ctx context.Context, path string, aces []NFS4ACE) error
 
 	// ZVOL operations
 	CreateZvol(ctx context.Context, params ZvolCreateParams) (*Dataset, error)
@@ -75,15 +85,23 @@ type ClientInterface interface {
 	QuerySubsystemPortBindings(ctx context.Context, subsystemID int) ([]NVMeOFPortSubsystem, error)
 	QueryNVMeOFPorts(ctx context.Context) ([]NVMeOFPort, error)
 
+	NVMeOFHostByNQN(ctx context.Context, hostNQN string) (*NVMeOFHost, error)
+	CreateNVMeOFHost(ctx context.Context, hostNQN string) (*NVMeOFHost, error)
+	QueryNVMeOFHostSubsystems(ctx context.Context, subsystemID int) ([]NVMeOFHostSubsystem, error)
+	AddHostToSubsystem(ctx context.Context, hostID, subsystemID int) error
+	RemoveHostFromSubsystem(ctx context.Context, hostSubsysID int) error
+
 	// iSCSI operations
 	GetISCSIGlobalConfig(ctx context.Context) (*ISCSIGlobalConfig, error)
 	QueryISCSIPortals(ctx context.Context) ([]ISCSIPortal, error)
 	QueryISCSIInitiators(ctx context.Context) ([]ISCSIInitiator, error)
+	CreateISCSIInitiator(ctx context.Context, params ISCSIInitiatorCreateParams) (*ISCSIInitiator, error)
 
 	CreateISCSITarget(ctx context.Context, params ISCSITargetCreateParams) (*ISCSITarget, error)
 	DeleteISCSITarget(ctx context.Context, targetID int, force bool) error
 	QueryISCSITargets(ctx context.Context, filters []interface{}) ([]ISCSITarget, error)
 	ISCSITargetByName(ctx context.Context, name string) (*ISCSITarget, error)
+	UpdateISCSITargetGroups(ctx context.Context, targetID int, groups []ISCSITargetGroup) (*ISCSITarget, error)
 
 	CreateISCSIExtent(ctx context.Context, params ISCSIExtentCreateParams) (*ISCSIExtent, error)
 	DeleteISCSIExtent(ctx context.Context, extentID int, removeFile, force bool) error
@@ -106,6 +124,12 @@ type ClientInterface interface {
 	QuerySnapshotsWithProperties(ctx context.Context, filters []interface{}) ([]Snapshot, error)
 	QuerySnapshotIDs(ctx context.Context, filters []interface{}) ([]string, error)
 	CloneSnapshot(ctx context.Context, params CloneSnapshotParams) (*Dataset, error)
+	HoldSnapshot(ctx context.Context, snapshotID string) error
+	ReleaseSnapshot(ctx context.Context, snapshotID string) error
+
+	// Raw zfs send streams (volume export and import)
+	SendSnapshot(ctx context.Context, params SnapshotSendParams) (io.ReadCloser, error)
+	ReceiveSnapshot(ctx context.Context, params SnapshotReceiveParams, stream io.Reader) error
 
 	// Dataset promotion (for detached clones)
 	// PromoteDataset promotes a cloned dataset to become independent from its origin snapshot.
@@ -118,9 +142,9 @@ type ClientInterface interface {
 	RunOnetimeReplication(ctx context.Context, params ReplicationRunOnetimeParams) (int, error)
 
 	// GetJobStatus retrieves the status of a job by its ID.
-	GetJobStatus(ctx context.Context, jobID int) (*ReplicationJobState, error)
+	GetJobStatus(ctx context.Context, jobID int) (*Job, error)
 
-	// WaitForJob waits for a job to complete with polling.
+	// WaitForJob waits for a job to complete, logging and recording its progress.
 	WaitForJob(ctx context.Context, jobID int, pollInterval time.Duration) error
 
 	// RunOnetimeReplicationAndWait runs a one-time replication and waits for completion.
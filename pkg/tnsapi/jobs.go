@@ -0,0 +1,437 @@
+package tnsapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// Jobs.
+//
+// Long-running TrueNAS operations (replications, cloud syncs, ACL updates) run as jobs. WatchJob
+// follows a job to completion: when the storage system supports core.subscribe, job updates are
+// pushed as core.get_jobs collection events and polling only runs as a slow safety net (events
+// are lost while the connection is re-established); otherwise core.get_jobs is polled. Polls are
+// jittered so that many concurrent waits do not hit the API in lockstep.
+
+// Job states reported by core.get_jobs.
+const (
+	JobStateWaiting = "WAITING"
+	JobStateRunning = "RUNNING"
+	JobStateSuccess = "SUCCESS"
+	JobStateFailed  = "FAILED"
+	JobStateAborted = "ABORTED"
+)
+
+const (
+	methodCoreGetJobs            = "core.get_jobs"
+	methodCoreSubscribe          = "core.subscribe"
+	notificationCollectionUpdate = "collection_update"
+
+	// jsonRPCMethodNotFound is the JSON-RPC error code for an unknown method.
+	jsonRPCMethodNotFound = -32601
+
+	// jobEventPollFactor slows polling down while job events are delivered.
+	jobEventPollFactor = 5
+
+	// jobPollJitter is the maximum fraction by which a poll interval is shortened or lengthened.
+	jobPollJitter = 0.2
+
+	// jobProgressLogStep is the progress (in percent) between two info-level progress logs.
+	jobProgressLogStep = 10
+)
+
+// Job is a TrueNAS job as reported by core.get_jobs.
+//
+//nolint:govet // fieldalignment: prefer readability over memory alignment for API response structs
+type Job struct {
+	ID          int           `json:"id"`
+	Method      string        `json:"method"`
+	Arguments   []interface{} `json:"arguments"`
+	Description string        `json:"description"`
+	State       string        `json:"state"`
+	Abortable   bool          `json:"abortable"`
+	Progress    JobProgress   `json:"progress"`
+	Error       string        `json:"error"`
+	Result      interface{}   `json:"result"`
+	TimeStarted *ejsonDate    `json:"time_started,omitempty"`
+	TimeEnded   *ejsonDate    `json:"time_finished,omitempty"`
+}
+
+// JobProgress is the progress of a job.
+type JobProgress struct {
+	Description string  `json:"description"`
+	Percent     float64 `json:"percent"`
+}
+
+// Finished reports whether the job reached a final state.
+func (j *Job) Finished() bool {
+	return j.State != JobStateWaiting && j.State != JobStateRunning
+}
+
+// BulkErrors returns the errors of the calls a core.bulk job ran. The job itself succeeds even
+// when calls fail.
+func (j *Job) BulkErrors() []string {
+	results, ok := j.Result.([]interface{})
+	if !ok {
+		return nil
+	}
+	var errs []string
+	for _, r := range results {
+		if entry, ok := r.(map[string]interface{}); ok {
+			if msg, ok := entry["error"].(string); ok && msg != "" {
+				errs = append(errs, msg)
+			}
+		}
+	}
+	return errs
+}
+
+// duration returns how long the job ran, falling back to the time since since when TrueNAS did
+// not report start and end times.
+func (j *Job) duration(since time.Time) time.Duration {
+	if j.TimeStarted != nil && j.TimeEnded != nil && !j.TimeStarted.IsZero() && !j.TimeEnded.IsZero() {
+		return j.TimeEnded.Sub(j.TimeStarted.Time)
+	}
+	return time.Since(since)
+}
+
+type ejsonDate struct {
+	time.Time
+}
+
+func (e *ejsonDate) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		Time int64 `json:"$date"`
+	}{}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	e.Time = time.UnixMilli(aux.Time)
+	return nil
+}
+
+func (e ejsonDate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Time int64 `json:"$date"`
+	}{
+		Time: e.UnixMilli(),
+	})
+}
+
+// QueryJobs returns the jobs TrueNAS still tracks (running and recently finished) matching filters.
+func (c *Client) QueryJobs(ctx context.Context, filters []interface{}) ([]Job, error) {
+	if filters == nil {
+		filters = []interface{}{}
+	}
+	var jobs []Job
+	if err := c.Call(ctx, methodCoreGetJobs, []interface{}{filters}, &jobs); err != nil {
+		return nil, fmt.Errorf("failed to query jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// GetJobStatus retrieves the status of a job by its ID.
+func (c *Client) GetJobStatus(ctx context.Context, jobID int) (*Job, error) {
+	klog.V(5).Infof("Getting job status for job %d", jobID)
+
+	jobs, err := c.QueryJobs(ctx, []interface{}{[]interface{}{"id", "=", jobID}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job status: %w", err)
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("job %d: %w", jobID, ErrJobNotFound)
+	}
+	return &jobs[0], nil
+}
+
+// AbortJob asks TrueNAS to abort a running job. Only jobs reported as abortable can be aborted.
+func (c *Client) AbortJob(ctx context.Context, jobID int) error {
+	klog.Infof("Aborting job %d", jobID)
+	var result json.RawMessage
+	if err := c.Call(ctx, "core.job_abort", []interface{}{jobID}, &result); err != nil {
+		return fmt.Errorf("failed to abort job %d: %w", jobID, err)
+	}
+	return nil
+}
+
+// WaitForJob waits for a job to complete, polling at the specified interval.
+// Returns nil if the job succeeds, or an error if it fails or times out.
+func (c *Client) WaitForJob(ctx context.Context, jobID int, pollInterval time.Duration) error {
+	_, err := c.WatchJob(ctx, jobID, pollInterval, nil)
+	return err
+}
+
+// WatchJob waits for a job to finish and returns its final state. Progress changes are sent to
+// progress, if not nil, without blocking: a reader that falls behind misses intermediate
+// updates. The channel is not closed. A job that fails or is aborted is returned together with
+// an error wrapping ErrJobFailed or ErrJobAborted.
+func (c *Client) WatchJob(ctx context.Context, jobID int, pollInterval time.Duration, progress chan<- JobProgress) (*Job, error) {
+	klog.V(4).Infof("Waiting for job %d to complete", jobID)
+
+	if c.timeouts.Job > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeouts.Job)
+		defer cancel()
+	}
+
+	events := c.jobs.watch(jobID)
+	defer c.jobs.unwatch(jobID, events)
+
+	w := &jobWatch{id: jobID, progress: progress, started: time.Now()}
+	defer w.abandon()
+
+	// The first poll is not slowed down: the job may have finished before the subscription
+	subscribed := c.subscribeJobEvents(ctx)
+	timer := time.NewTimer(jitter(pollInterval))
+	defer timer.Stop()
+
+	for {
+		var job *Job
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("context canceled while waiting for job %d: %w", jobID, ctx.Err())
+		case job = <-events:
+		case <-timer.C:
+			// Re-subscribe after a reconnect dropped the subscription
+			subscribed = c.subscribeJobEvents(ctx)
+			timer.Reset(jitter(jobPollInterval(pollInterval, subscribed)))
+
+			status, err := c.GetJobStatus(ctx, jobID)
+			if err != nil {
+				klog.Warningf("Failed to get job %d status: %v", jobID, err)
+				continue
+			}
+			job = status
+		}
+
+		if done, err := w.update(job); done {
+			return job, err
+		}
+	}
+}
+
+// jobPollInterval returns the polling interval, slowed down while job events are delivered.
+func jobPollInterval(pollInterval time.Duration, subscribed bool) time.Duration {
+	if subscribed {
+		return pollInterval * jobEventPollFactor
+	}
+	return pollInterval
+}
+
+// jitter randomly shortens or lengthens d by up to jobPollJitter.
+func jitter(d time.Duration) time.Duration {
+	spread := time.Duration(float64(d) * jobPollJitter)
+	if spread <= 0 {
+		return d
+	}
+	//nolint:gosec // G404: jitter does not need a cryptographic random source
+	return d - spread + rand.N(2*spread+1)
+}
+
+// jobWatch tracks the state of one job followed by WatchJob.
+//
+//nolint:govet // fieldalignment: prefer readability over memory alignment
+type jobWatch struct {
+	id         int
+	method     string
+	progress   chan<- JobProgress
+	last       JobProgress
+	lastLogged float64 // Percent of the last info-level log, rounded down to jobProgressLogStep
+	started    time.Time
+	reported   bool
+	finished   bool
+}
+
+// update processes a job update. It returns done once the job reached a final state, with an
+// error describing a failed or aborted job.
+func (w *jobWatch) update(job *Job) (done bool, err error) {
+	w.method = job.Method
+	w.reportProgress(job)
+
+	switch job.State {
+	case JobStateWaiting, JobStateRunning:
+		return false, nil
+	case JobStateSuccess:
+		w.finish(job)
+		klog.V(4).Infof("Job %d completed successfully", w.id)
+		return true, nil
+	case JobStateFailed:
+		w.finish(job)
+		return true, fmt.Errorf("job %d: %w: %s", w.id, ErrJobFailed, job.Error)
+	case JobStateAborted:
+		w.finish(job)
+		return true, fmt.Errorf("job %d: %w", w.id, ErrJobAborted)
+	default:
+		klog.Warningf("Unknown job state: %s", job.State)
+		return false, nil
+	}
+}
+
+// reportProgress forwards, logs and records progress changes.
+func (w *jobWatch) reportProgress(job *Job) {
+	p := job.Progress
+	if w.reported && p == w.last {
+		return
+	}
+	w.last, w.reported = p, true
+
+	if w.progress != nil {
+		select {
+		case w.progress <- p:
+		default:
+		}
+	}
+
+	if p.Percent >= w.lastLogged+jobProgressLogStep {
+		w.lastLogged = p.Percent - float64(int(p.Percent)%jobProgressLogStep)
+		klog.Infof("Job %d (%s): %.0f%% %s", w.id, job.Method, p.Percent, p.Description)
+	} else {
+		klog.V(4).Infof("Job %d (%s): %.0f%% %s", w.id, job.Method, p.Percent, p.Description)
+	}
+	if !job.Finished() {
+		metrics.SetJobProgress(w.id, job.Method, p.Percent/100)
+	}
+}
+
+func (w *jobWatch) finish(job *Job) {
+	w.finished = true
+	metrics.RecordJobCompletion(w.id, job.Method, job.State, job.duration(w.started))
+}
+
+// abandon drops the progress gauge of a job that is no longer watched before it finished.
+func (w *jobWatch) abandon() {
+	if !w.finished && w.method != "" {
+		metrics.DeleteJobProgress(w.id, w.method)
+	}
+}
+
+// jobEvents fans core.get_jobs collection events out to WatchJob callers.
+//
+//nolint:govet // fieldalignment: prefer readability over memory alignment
+type jobEvents struct {
+	mu          sync.Mutex
+	watchers    map[int][]chan *Job
+	subscribed  bool // Subscribed to core.get_jobs on the current connection
+	unsupported bool // The storage system does not support core.subscribe
+}
+
+// watch registers a channel receiving updates of a job.
+func (e *jobEvents) watch(jobID int) chan *Job {
+	ch := make(chan *Job, 1)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.watchers == nil {
+		e.watchers = make(map[int][]chan *Job)
+	}
+	e.watchers[jobID] = append(e.watchers[jobID], ch)
+	return ch
+}
+
+func (e *jobEvents) unwatch(jobID int, ch chan *Job) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	watchers := e.watchers[jobID]
+	for i := range watchers {
+		if watchers[i] == ch {
+			watchers = append(watchers[:i], watchers[i+1:]...)
+			break
+		}
+	}
+	if len(watchers) == 0 {
+		delete(e.watchers, jobID)
+	} else {
+		e.watchers[jobID] = watchers
+	}
+}
+
+// dispatch delivers a job update without blocking, replacing an update not yet consumed.
+func (e *jobEvents) dispatch(job *Job) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ch := range e.watchers[job.ID] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- job
+	}
+}
+
+// connectionReset forgets the subscription of a replaced connection.
+func (e *jobEvents) connectionReset() {
+	e.mu.Lock()
+	e.subscribed = false
+	e.mu.Unlock()
+}
+
+// subscribeJobEvents subscribes to core.get_jobs events on the current connection if needed.
+// It reports whether job events are delivered.
+func (c *Client) subscribeJobEvents(ctx context.Context) bool {
+	c.jobs.mu.Lock()
+	subscribed, unsupported := c.jobs.subscribed, c.jobs.unsupported
+	c.jobs.mu.Unlock()
+	if subscribed || unsupported {
+		return subscribed
+	}
+
+	var subscription json.RawMessage
+	if err := c.Call(ctx, methodCoreSubscribe, []interface{}{methodCoreGetJobs}, &subscription); err != nil {
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.Code == jsonRPCMethodNotFound {
+			klog.V(4).Infof("Job events not supported by the storage system, polling job status")
+			c.jobs.mu.Lock()
+			c.jobs.unsupported = true
+			c.jobs.mu.Unlock()
+		} else {
+			klog.V(4).Infof("Failed to subscribe to job events, polling job status: %v", err)
+		}
+		return false
+	}
+
+	c.jobs.mu.Lock()
+	c.jobs.subscribed = true
+	c.jobs.mu.Unlock()
+	klog.V(4).Info("Subscribed to job events")
+	return true
+}
+
+// collectionUpdate is the payload of a collection_update notification.
+type collectionUpdate struct {
+	Fields     json.RawMessage `json:"fields"`
+	Msg        string          `json:"msg"`
+	Collection string          `json:"collection"`
+	ID         int             `json:"id"`
+}
+
+// handleNotification processes a server-initiated JSON-RPC notification.
+func (c *Client) handleNotification(method string, params json.RawMessage) {
+	if method != notificationCollectionUpdate {
+		klog.V(5).Infof("Ignoring notification %s", method)
+		return
+	}
+	var update collectionUpdate
+	if err := json.Unmarshal(params, &update); err != nil {
+		klog.V(4).Infof("Ignoring malformed collection update: %v", err)
+		return
+	}
+	if update.Collection != methodCoreGetJobs || len(update.Fields) == 0 {
+		return
+	}
+	var job Job
+	if err := json.Unmarshal(update.Fields, &job); err != nil {
+		klog.V(4).Infof("Ignoring malformed job event: %v", err)
+		return
+	}
+	if job.ID == 0 {
+		job.ID = update.ID
+	}
+	c.jobs.dispatch(&job)
+}
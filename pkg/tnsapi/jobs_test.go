@@ -0,0 +1,47 @@
+package tnsapi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	const d = 10 * time.Second
+	seen := make(map[time.Duration]bool)
+	for range 100 {
+		got := jitter(d)
+		if got < 8*time.Second || got > 12*time.Second {
+			t.Fatalf("jitter(%v) = %v, want within 20%%", d, got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Error("jitter() always returned the same interval")
+	}
+	if got := jitter(0); got != 0 {
+		t.Errorf("jitter(0) = %v", got)
+	}
+}
+
+func TestJobWatchUpdate(t *testing.T) {
+	progress := make(chan JobProgress, 1)
+	w := &jobWatch{id: 1, progress: progress, started: time.Now()}
+
+	running := &Job{ID: 1, Method: "replication.run_onetime", State: JobStateRunning, Progress: JobProgress{Percent: 30}}
+	if done, err := w.update(running); done || err != nil {
+		t.Fatalf("update(running) = %v, %v", done, err)
+	}
+	// A full channel does not block the watch
+	running.Progress.Percent = 60
+	if done, _ := w.update(running); done {
+		t.Fatal("update(running) reported done")
+	}
+	if p := <-progress; p.Percent != 30 {
+		t.Errorf("progress = %+v, want the first update", p)
+	}
+
+	failed := &Job{ID: 1, Method: "replication.run_onetime", State: JobStateFailed, Error: "boom"}
+	if done, err := w.update(failed); !done || err == nil {
+		t.Errorf("update(failed) = %v, %v; want done with error", done, err)
+	}
+}
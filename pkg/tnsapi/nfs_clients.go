@@ -0,0 +1,101 @@
+package tnsapi
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// NFSv4 client state.
+//
+// The NFS server keeps opens, locks and delegations per NFSv4 client until the client's lease
+// expires. A node that fails without unmounting holds its locks for a lease period or longer
+// (courteous servers keep the state of unreachable clients until another client conflicts with
+// it), so a volume moved to another node can find its files still locked. nfs.get_nfs4_clients
+// lists the clients known to nfsd. TrueNAS has no API method to expire one; nfsd itself expires a
+// client when "expire" is written to /proc/fs/nfsd/clients/<id>/ctl, which ExpireNFS4Client does
+// through filesystem.file_receive. That relies on the file upload method writing to procfs as
+// root, which TrueNAS does not document, and on a kernel with nfsd client directories (Linux 5.3
+// and later, TrueNAS SCALE); the driver only calls it when explicitly enabled.
+
+const (
+	methodNFS4Clients         = "nfs.get_nfs4_clients"
+	methodFilesystemReceive   = "filesystem.file_receive"
+	nfsdClientsPath           = "/proc/fs/nfsd/clients/"
+	nfsdClientExpireOperation = "expire\n"
+)
+
+// NFSv4 client errors.
+var (
+	ErrInvalidNFS4ClientID    = errors.New("invalid NFSv4 client ID")
+	ErrNFS4ClientExpireFailed = errors.New("NFSv4 client expiry returned false (unsuccessful)")
+	ErrNFS4ClientCtlNotFound  = errors.New("nfsd client control file not found (client already gone, or no /proc/fs/nfsd/clients on this TrueNAS version)")
+)
+
+// NFS4Client is an NFSv4 client known to the NFS server.
+type NFS4Client struct {
+	Info map[string]interface{} `json:"info"` // Contents of the nfsd info file (address, name, status, ...)
+	ID   string                 `json:"id"`   // nfsd client directory name
+}
+
+// Address returns the IP address the client connects from, without its port.
+func (c NFS4Client) Address() string {
+	addr, ok := c.Info["address"].(string)
+	if !ok {
+		return ""
+	}
+	addr = strings.Trim(addr, `"`)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// Name returns the client's identifier string, e.g. "Linux NFSv4.2 worker-1".
+func (c NFS4Client) Name() string {
+	name, _ := c.Info["name"].(string)
+	return strings.Trim(name, `"`)
+}
+
+// ListNFS4Clients returns the NFSv4 clients currently known to the NFS server.
+func (c *Client) ListNFS4Clients(ctx context.Context) ([]NFS4Client, error) {
+	klog.V(4).Infof("Listing NFSv4 clients")
+
+	var result []NFS4Client
+	if err := c.Call(ctx, methodNFS4Clients, []interface{}{}, &result); err != nil {
+		return nil, fmt.Errorf("failed to list NFSv4 clients: %w", err)
+	}
+	return result, nil
+}
+
+// ExpireNFS4Client makes the NFS server drop an NFSv4 client and release its opens, locks and
+// delegations.
+func (c *Client) ExpireNFS4Client(ctx context.Context, clientID string) error {
+	if clientID == "" || strings.ContainsAny(clientID, "/.") {
+		return fmt.Errorf("%w: %q", ErrInvalidNFS4ClientID, clientID)
+	}
+	klog.V(4).Infof("Expiring NFSv4 client %s", clientID)
+
+	var result bool
+	err := c.Call(ctx, methodFilesystemReceive, []interface{}{
+		nfsdClientsPath + clientID + "/ctl",
+		base64.StdEncoding.EncodeToString([]byte(nfsdClientExpireOperation)),
+	}, &result)
+	if err != nil {
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.Data != nil && apiErr.Data.ErrorName == "ENOENT" {
+			return fmt.Errorf("%w: client %s", ErrNFS4ClientCtlNotFound, clientID)
+		}
+		return fmt.Errorf("failed to expire NFSv4 client %s: %w", clientID, err)
+	}
+	if !result {
+		return fmt.Errorf("%w: client %s", ErrNFS4ClientExpireFailed, clientID)
+	}
+	klog.Infof("Expired NFSv4 client %s", clientID)
+	return nil
+}
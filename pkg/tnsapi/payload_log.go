@@ -0,0 +1,179 @@
+package tnsapi
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Payload logging.
+//
+// nvmet.namespace.query and nvmet.port_subsys.query return every namespace and port binding of
+// the system and run on each NVMe-oF operation, so their raw responses are logged at
+// payloadLogLevel only and sampled: a response is logged when it differs from the last one logged
+// for the method or payloadSampleInterval after it; responses skipped in between are counted and
+// reported with the next sample. For a one-off look at the payload format, WithSchemaDump logs a
+// single annotated schema snapshot of each response type instead.
+
+const (
+	payloadLogLevel       = 6
+	payloadSampleInterval = 10 * time.Minute
+	maxLoggedPayload      = 2000 // Bytes of a raw payload logged
+)
+
+// WithSchemaDump logs an annotated schema of the first response of each sampled query (field
+// names, JSON types and example values, redacted) once at Info level.
+func WithSchemaDump(enabled bool) ClientOption {
+	return func(c *Client) {
+		c.payloads.dumpSchema = enabled
+	}
+}
+
+// payloadSampler decides which raw responses of a method are logged.
+type payloadSampler struct {
+	mu         sync.Mutex
+	last       map[string]payloadSample
+	dumped     map[string]bool
+	now        func() time.Time // nil = time.Now
+	dumpSchema bool
+}
+
+// payloadSample is the last logged response of a method.
+type payloadSample struct {
+	at         time.Time
+	hash       uint64
+	suppressed int // Responses not logged since
+}
+
+// sample reports whether a response of method is logged, and how many were skipped before it.
+func (s *payloadSampler) sample(method string, raw []byte) (bool, int) {
+	h := fnv.New64a()
+	h.Write(raw) //nolint:errcheck,gosec // hash.Hash writes never fail
+	hash := h.Sum64()
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil {
+		s.last = make(map[string]payloadSample)
+	}
+	last, seen := s.last[method]
+	if seen && last.hash == hash && now().Sub(last.at) < payloadSampleInterval {
+		last.suppressed++
+		s.last[method] = last
+		return false, 0
+	}
+	s.last[method] = payloadSample{at: now(), hash: hash}
+	return true, last.suppressed
+}
+
+// firstResponse reports whether this is the first response of method seen for the schema dump.
+func (s *payloadSampler) firstResponse(method string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dumpSchema || s.dumped[method] {
+		return false
+	}
+	if s.dumped == nil {
+		s.dumped = make(map[string]bool)
+	}
+	s.dumped[method] = true
+	return true
+}
+
+// logPayload logs a raw response of method, subject to sampling, and its schema if dumping is
+// enabled. It reports whether the payload was logged, for callers logging details along with it.
+func (c *Client) logPayload(method string, raw json.RawMessage) bool {
+	if c.payloads.firstResponse(method) {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(RedactString(string(raw))), &doc); err == nil {
+			if schema, err := json.MarshalIndent(inferSchema(doc), "", "  "); err == nil {
+				klog.Infof("API schema snapshot of %s (%d bytes):\n%s", method, len(raw), schema)
+			}
+		}
+	}
+
+	if !klog.V(payloadLogLevel).Enabled() {
+		return false
+	}
+	logged, suppressed := c.payloads.sample(method, raw)
+	if !logged {
+		return false
+	}
+	payload := RedactString(string(raw))
+	if len(payload) > maxLoggedPayload {
+		payload = payload[:maxLoggedPayload] + "..."
+	}
+	klog.V(payloadLogLevel).Infof("%s: raw response (%d bytes, %d unchanged responses not logged since the last sample): %s",
+		method, len(raw), suppressed, payload)
+	return true
+}
+
+// schemaNode describes the JSON values seen at one position of a payload.
+type schemaNode struct {
+	Type    string                 `json:"type"` // JSON types seen, e.g. "string" or "null|string"
+	Example interface{}            `json:"example,omitempty"`
+	Count   int                    `json:"count,omitempty"` // Array elements
+	Items   *schemaNode            `json:"items,omitempty"`
+	Fields  map[string]*schemaNode `json:"fields,omitempty"`
+}
+
+// inferSchema returns the schema of a decoded JSON value, merging the elements of arrays.
+func inferSchema(v interface{}) *schemaNode {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		node := &schemaNode{Type: "object", Fields: make(map[string]*schemaNode, len(val))}
+		for key, field := range val {
+			node.Fields[key] = inferSchema(field)
+		}
+		return node
+	case []interface{}:
+		node := &schemaNode{Type: "array", Count: len(val)}
+		for _, item := range val {
+			node.Items = mergeSchema(node.Items, inferSchema(item))
+		}
+		return node
+	case string:
+		return &schemaNode{Type: "string", Example: val}
+	case float64:
+		return &schemaNode{Type: "number", Example: val}
+	case bool:
+		return &schemaNode{Type: "boolean", Example: val}
+	default:
+		return &schemaNode{Type: "null"}
+	}
+}
+
+// mergeSchema combines two schemas of values at the same position.
+func mergeSchema(a, b *schemaNode) *schemaNode {
+	if a == nil {
+		return b
+	}
+	if a.Type != b.Type {
+		types := append(strings.Split(a.Type, "|"), strings.Split(b.Type, "|")...)
+		slices.Sort(types)
+		a.Type = strings.Join(slices.Compact(types), "|")
+	}
+	if a.Example == nil {
+		a.Example = b.Example
+	}
+	a.Count += b.Count
+	if b.Items != nil {
+		a.Items = mergeSchema(a.Items, b.Items)
+	}
+	for key, field := range b.Fields {
+		if a.Fields == nil {
+			a.Fields = make(map[string]*schemaNode)
+		}
+		a.Fields[key] = mergeSchema(a.Fields[key], field)
+	}
+	return a
+}
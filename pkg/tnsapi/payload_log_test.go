@@ -0,0 +1,90 @@
+package tnsapi
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPayloadSampler(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &payloadSampler{now: func() time.Time { return now }}
+	const method = "nvmet.namespace.query"
+
+	steps := []struct {
+		name           string
+		payload        string
+		advance        time.Duration
+		wantLogged     bool
+		wantSuppressed int
+	}{
+		{name: "first response", payload: `[1]`, wantLogged: true},
+		{name: "unchanged", payload: `[1]`, advance: time.Minute, wantLogged: false},
+		{name: "unchanged again", payload: `[1]`, advance: time.Minute, wantLogged: false},
+		{name: "changed", payload: `[1,2]`, advance: time.Minute, wantLogged: true, wantSuppressed: 2},
+		{name: "unchanged", payload: `[1,2]`, advance: time.Minute, wantLogged: false},
+		{name: "sample interval elapsed", payload: `[1,2]`, advance: payloadSampleInterval, wantLogged: true, wantSuppressed: 1},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		logged, suppressed := s.sample(method, []byte(step.payload))
+		if logged != step.wantLogged || suppressed != step.wantSuppressed {
+			t.Errorf("%s: sample() = %v, %d; want %v, %d", step.name, logged, suppressed, step.wantLogged, step.wantSuppressed)
+		}
+	}
+
+	if logged, _ := s.sample("nvmet.port_subsys.query", []byte(`[1,2]`)); !logged {
+		t.Error("methods must be sampled independently")
+	}
+}
+
+func TestPayloadSamplerSchemaDumpOnce(t *testing.T) {
+	s := &payloadSampler{}
+	if s.firstResponse("nvmet.namespace.query") {
+		t.Error("schema dumped without WithSchemaDump")
+	}
+	s.dumpSchema = true
+	if !s.firstResponse("nvmet.namespace.query") || s.firstResponse("nvmet.namespace.query") {
+		t.Error("schema must be dumped exactly once per method")
+	}
+	if !s.firstResponse("nvmet.port_subsys.query") {
+		t.Error("schema of another method not dumped")
+	}
+}
+
+func TestInferSchema(t *testing.T) {
+	var doc interface{}
+	payload := `[
+		{"id": 1, "device_path": "zvol/tank/pvc-1", "subsys": {"id": 3, "nqn": "nqn.x"}, "locked": null},
+		{"id": 2, "device_path": "zvol/tank/pvc-2", "subsys": {"id": 4}, "locked": true, "enabled": true}
+	]`
+	if err := json.Unmarshal([]byte(payload), &doc); err != nil {
+		t.Fatal(err)
+	}
+	schema := inferSchema(doc)
+
+	if schema.Type != "array" || schema.Count != 2 || schema.Items == nil {
+		t.Fatalf("schema = %+v, want an array of 2 items", schema)
+	}
+	fields := schema.Items.Fields
+	tests := []struct {
+		field, wantType string
+	}{
+		{"id", "number"},
+		{"device_path", "string"},
+		{"subsys", "object"},
+		{"locked", "boolean|null"},
+		{"enabled", "boolean"},
+	}
+	for _, tt := range tests {
+		if got := fields[tt.field]; got == nil || got.Type != tt.wantType {
+			t.Errorf("field %s = %+v, want type %s", tt.field, got, tt.wantType)
+		}
+	}
+	if fields["device_path"].Example != "zvol/tank/pvc-1" {
+		t.Errorf("device_path example = %v", fields["device_path"].Example)
+	}
+	if subsys := fields["subsys"].Fields; subsys["id"] == nil || subsys["nqn"] == nil {
+		t.Errorf("subsys fields = %+v, want id and nqn merged from both items", subsys)
+	}
+}
@@ -51,6 +51,32 @@ const (
 	// PropertyCreatedAt stores the timestamp when the volume was created.
 	// Value: RFC3339 timestamp, e.g., "2024-01-15T10:30:00Z".
 	PropertyCreatedAt = "tns-csi:created_at"
+
+	// PropertyCreateGeneration identifies the CreateVolume attempt that last claimed the
+	// volume for finalizing (creating its share or namespace) (mutable).
+	// Value: random hex string, e.g., "9f2c4e1a7b3d5f60".
+	PropertyCreateGeneration = "tns-csi:create_generation"
+
+	// PropertyMaxSize stores the StorageClass maxSize limit that expansion must respect.
+	// Value: size in bytes as string, e.g., "53687091200".
+	PropertyMaxSize = "tns-csi:max_size"
+
+	// PropertyCapacityRounding stores the StorageClass capacityRounding mode applied on expansion.
+	// Value: "none", "gib" or "volblocksize".
+	PropertyCapacityRounding = "tns-csi:capacity_rounding"
+
+	// PropertyRecursiveDelete records the StorageClass recursiveDelete opt-out.
+	// Value: "false"; absent = child datasets and snapshots are deleted with the volume.
+	PropertyRecursiveDelete = "tns-csi:recursive_delete"
+
+	// PropertyPendingDelete marks a volume dataset that is being destroyed in the background
+	// (--async-delete-min-size). The controller retries the deletion until the dataset is gone.
+	// Value: "true".
+	PropertyPendingDelete = "tns-csi:pending_delete"
+
+	// PropertyDeleteJobID stores the TrueNAS job destroying a dataset marked pending_delete.
+	// Value: e.g., "1234" (integer stored as string).
+	PropertyDeleteJobID = "tns-csi:delete_job_id"
 )
 
 // Adoption metadata properties - for cross-cluster volume adoption.
@@ -69,9 +95,19 @@ const (
 	// Value: e.g., "default".
 	PropertyPVCNamespace = "tns-csi:pvc_namespace"
 
+	// PropertyPVName stores the PersistentVolume name (the CSI volume name from the provisioner).
+	// Differs from PropertyCSIVolumeName when a name template is used.
+	// Value: e.g., "pvc-1234abcd-...".
+	PropertyPVName = "tns-csi:pv_name"
+
 	// PropertyStorageClass stores the original StorageClass name for adoption.
 	// Value: e.g., "truenas-nfs".
 	PropertyStorageClass = "tns-csi:storage_class"
+
+	// PropertyReclaimPolicy records the reclaim policy of the volume's PersistentVolume, as last
+	// seen by the controller, so retained volumes are recognized after their PV is gone.
+	// Value: "Retain" or "Delete".
+	PropertyReclaimPolicy = "tns-csi:reclaim_policy"
 )
 
 // NFS-specific properties.
@@ -83,6 +119,30 @@ const (
 	// PropertyNFSSharePath stores the NFS export path (stable identifier).
 	// Value: e.g., "/mnt/tank/csi/pvc-xxx".
 	PropertyNFSSharePath = "tns-csi:nfs_share_path"
+
+	// PropertyNFSSharePending marks a volume whose NFS share is still being created in the
+	// background (deferShareCreation). Removed once the share exists.
+	// Value: "true".
+	PropertyNFSSharePending = "tns-csi:nfs_share_pending"
+
+	// PropertyNFSShareMapall stores the user:group every NFS client is mapped to, for
+	// multi-writer volumes whose share is created in the background.
+	// Value: e.g., "root:wheel".
+	PropertyNFSShareMapall = "tns-csi:nfs_share_mapall"
+
+	// PropertyNFSShareSecurity stores the Kerberos flavor of an NFS volume whose share is
+	// created in the background (nfs.security).
+	// Value: "krb5", "krb5i" or "krb5p".
+	PropertyNFSShareSecurity = "tns-csi:nfs_share_security"
+
+	// PropertyNFSShareStrategy records how an NFS volume is exported. Only set for volumes
+	// reached through their parent dataset's export; nfs_share_path then holds that export.
+	// Value: "parent".
+	PropertyNFSShareStrategy = "tns-csi:nfs_share_strategy"
+
+	// PropertyAutoGrow stores the autoGrow policy of an NFS/SMB volume.
+	// Value: e.g., "20%:max=500Gi".
+	PropertyAutoGrow = "tns-csi:autogrow"
 )
 
 // NVMe-oF-specific properties.
@@ -98,6 +158,16 @@ const (
 	// PropertyNVMeSubsystemNQN stores the NVMe-oF subsystem NQN (stable identifier).
 	// Value: e.g., "nqn.2024.io.truenas:nvme:pvc-xxx".
 	PropertyNVMeSubsystemNQN = "tns-csi:nvmeof_subsystem_nqn"
+
+	// PropertyNVMeNextNSID stores the next NSID to allocate in the volume's subsystem (mutable).
+	// NSIDs only move forward so a freed NSID is not handed out again right away.
+	// Value: e.g., "3" (integer stored as string).
+	PropertyNVMeNextNSID = "tns-csi:nvmeof_next_nsid"
+
+	// PropertyNVMeNSIDReleasedAt records when the volume's namespace was found released (mutable).
+	// Once the NSID cool-down has passed since then, allocation restarts at NSID 1.
+	// Value: Unix timestamp in seconds, e.g., "1767225600".
+	PropertyNVMeNSIDReleasedAt = "tns-csi:nvmeof_nsid_released_at"
 )
 
 // iSCSI-specific properties (future).
@@ -160,12 +230,17 @@ const (
 	// PropertySnapshotCSIName stores the CSI snapshot name (legacy).
 	// Value: e.g., "snapshot-12345678-1234-1234-1234-123456789012".
 	PropertySnapshotCSIName = "tns-csi:snapshot_csi_name"
+
+	// PropertySnapshotChecksum stores the GUID and referenced bytes of a snapshot, recorded when
+	// it was taken, so restores with verifyRestore can check the snapshot and its copies.
+	// Value: "<guid>:<referenced bytes>", e.g., "1234567890123456789:1073741824".
+	PropertySnapshotChecksum = "tns-csi:snapshot_checksum"
 )
 
 // Clone/content source properties.
 const (
 	// PropertyContentSourceType stores the content source type for cloned volumes.
-	// Value: "snapshot" or "volume".
+	// Value: "snapshot", "volume" or "populator".
 	PropertyContentSourceType = "tns-csi:content_source_type"
 
 	// PropertyContentSourceID stores the content source ID for cloned volumes.
@@ -183,6 +258,44 @@ const (
 	PropertyOriginSnapshot = "tns-csi:origin_snapshot"
 )
 
+// Snapshot preview properties.
+// Previews are temporary read-only clones of a snapshot shared over NFS for browsing.
+// They are intentionally NOT marked with PropertyManagedBy so they never appear as volumes.
+const (
+	// PropertyPreviewSource stores the ZFS snapshot a preview was cloned from.
+	// Value: Full ZFS snapshot path, e.g., "pool/dataset@snapshot".
+	PropertyPreviewSource = "tns-csi:preview_source"
+
+	// PropertyPreviewExpiresAt stores when a preview is torn down (RFC3339, UTC).
+	PropertyPreviewExpiresAt = "tns-csi:preview_expires_at"
+
+	// PropertyPreviewShareID stores the NFS share ID exporting the preview.
+	PropertyPreviewShareID = "tns-csi:preview_share_id"
+)
+
+// Tenant dataset properties.
+// Tenant datasets are parents of volumes, not volumes, so they are NOT marked with PropertyManagedBy.
+const (
+	// PropertyTenantQuota stores the quota the driver last set on a tenant dataset from the
+	// namespace's ResourceQuotas, so a quota set by an administrator is never removed.
+	// Value: bytes, e.g., "107374182400".
+	PropertyTenantQuota = "tns-csi:tenant_quota"
+)
+
+// Volume group properties.
+// Volume group datasets hold the volumes of one group; like tenant datasets they are NOT
+// marked with PropertyManagedBy.
+const (
+	// PropertyVolumeGroup marks a volume group dataset and stores the group name.
+	PropertyVolumeGroup = "tns-csi:volume_group"
+
+	// PropertyVolumeGroupNamespace stores the namespace a volume group belongs to.
+	PropertyVolumeGroupNamespace = "tns-csi:volume_group_namespace"
+
+	// PropertyGroupSnapshotName stores the CSI name of the group snapshot a snapshot belongs to.
+	PropertyGroupSnapshotName = "tns-csi:group_snapshot_name"
+)
+
 // Clone mode values.
 const (
 	// CloneModeCOW indicates a standard COW clone (clone depends on snapshot).
@@ -224,6 +337,9 @@ const (
 	// ContentSourceVolume indicates the volume was created from another volume (clone).
 	ContentSourceVolume = "volume"
 
+	// ContentSourcePopulator indicates the volume was prepopulated from a DatasetPopulator dataSourceRef.
+	ContentSourcePopulator = "populator"
+
 	// DeleteStrategyDelete is the default strategy - volume is deleted when PVC is deleted.
 	DeleteStrategyDelete = "delete"
 
@@ -245,18 +361,29 @@ func PropertyNames() []string {
 		PropertyProtocol,
 		PropertyDeleteStrategy,
 		PropertyCreatedAt,
+		PropertyCreateGeneration,
+		PropertyMaxSize,
+		PropertyCapacityRounding,
+		PropertyRecursiveDelete,
+		PropertyPendingDelete,
+		PropertyDeleteJobID,
 		// Adoption properties
 		PropertyAdoptable,
 		PropertyPVCName,
 		PropertyPVCNamespace,
+		PropertyPVName,
 		PropertyStorageClass,
+		PropertyReclaimPolicy,
 		// NFS properties
 		PropertyNFSShareID,
 		PropertyNFSSharePath,
+		PropertyNFSSharePending,
 		// NVMe-oF properties
 		PropertyNVMeSubsystemID,
 		PropertyNVMeNamespaceID,
 		PropertyNVMeSubsystemNQN,
+		PropertyNVMeNextNSID,
+		PropertyNVMeNSIDReleasedAt,
 		// iSCSI properties
 		PropertyISCSIIQN,
 		PropertyISCSITargetID,
@@ -271,6 +398,7 @@ func PropertyNames() []string {
 		PropertySourceDataset,
 		PropertySnapshotSourceVolume,
 		PropertySnapshotCSIName,
+		PropertySnapshotChecksum,
 		// Clone properties
 		PropertyContentSourceType,
 		PropertyContentSourceID,
@@ -283,35 +411,34 @@ func PropertyNames() []string {
 	}
 }
 
-// NFSVolumeParams contains parameters for creating NFS volume properties.
-type NFSVolumeParams struct {
+// VolumeParams contains the parameters the properties of every volume share.
+type VolumeParams struct {
 	VolumeID       string
 	CreatedAt      string
 	DeleteStrategy string
-	SharePath      string
 	PVCName        string
 	PVCNamespace   string
+	PVName         string
 	StorageClass   string
 	ClusterID      string
 	CapacityBytes  int64
-	ShareID        int
 	Adoptable      bool // Mark volume as adoptable for cross-cluster adoption
 }
 
-// NFSVolumePropertiesV1 returns Schema v1 properties for an NFS volume.
-//
-//nolint:dupl // Intentionally similar structure to SMB volume properties
-func NFSVolumePropertiesV1(params NFSVolumeParams) map[string]string {
+// VolumePropertiesV1 returns Schema v1 properties for a volume of a protocol. resources are
+// the properties of the protocol's storage resources (NFSShareProperties, ...).
+func VolumePropertiesV1(protocol string, params VolumeParams, resources map[string]string) map[string]string {
 	props := map[string]string{
 		PropertySchemaVersion:  SchemaVersionV1,
 		PropertyManagedBy:      ManagedByValue,
 		PropertyCSIVolumeName:  params.VolumeID,
 		PropertyCapacityBytes:  int64ToString(params.CapacityBytes),
-		PropertyProtocol:       ProtocolNFS,
+		PropertyProtocol:       protocol,
 		PropertyCreatedAt:      params.CreatedAt,
 		PropertyDeleteStrategy: params.DeleteStrategy,
-		PropertyNFSShareID:     intToString(params.ShareID),
-		PropertyNFSSharePath:   params.SharePath,
+	}
+	for k, v := range resources {
+		props[k] = v
 	}
 	// Add adoption properties if provided
 	if params.PVCName != "" {
@@ -320,6 +447,9 @@ func NFSVolumePropertiesV1(params NFSVolumeParams) map[string]string {
 	if params.PVCNamespace != "" {
 		props[PropertyPVCNamespace] = params.PVCNamespace
 	}
+	if params.PVName != "" {
+		props[PropertyPVName] = params.PVName
+	}
 	if params.StorageClass != "" {
 		props[PropertyStorageClass] = params.StorageClass
 	}
@@ -332,6 +462,72 @@ func NFSVolumePropertiesV1(params NFSVolumeParams) map[string]string {
 	return props
 }
 
+// NFSShareProperties returns the properties of an NFS volume's share.
+func NFSShareProperties(shareID int, sharePath string) map[string]string {
+	return map[string]string{
+		PropertyNFSShareID:   intToString(shareID),
+		PropertyNFSSharePath: sharePath,
+	}
+}
+
+// SMBShareProperties returns the properties of an SMB volume's share.
+func SMBShareProperties(shareID int, shareName string) map[string]string {
+	return map[string]string{
+		PropertySMBShareID:   intToString(shareID),
+		PropertySMBShareName: shareName,
+	}
+}
+
+// ISCSITargetProperties returns the properties of an iSCSI volume's target and extent.
+func ISCSITargetProperties(targetID, extentID int, targetIQN string) map[string]string {
+	return map[string]string{
+		PropertyISCSITargetID: intToString(targetID),
+		PropertyISCSIExtentID: intToString(extentID),
+		PropertyISCSIIQN:      targetIQN,
+	}
+}
+
+// NVMeOFSubsystemProperties returns the properties of an NVMe-oF volume's subsystem and namespace.
+func NVMeOFSubsystemProperties(subsystemID, namespaceID int, subsystemNQN string) map[string]string {
+	return map[string]string{
+		PropertyNVMeSubsystemID:  intToString(subsystemID),
+		PropertyNVMeNamespaceID:  intToString(namespaceID),
+		PropertyNVMeSubsystemNQN: subsystemNQN,
+	}
+}
+
+// NFSVolumeParams contains parameters for creating NFS volume properties.
+type NFSVolumeParams struct {
+	VolumeID       string
+	CreatedAt      string
+	DeleteStrategy string
+	SharePath      string
+	PVCName        string
+	PVCNamespace   string
+	PVName         string
+	StorageClass   string
+	ClusterID      string
+	CapacityBytes  int64
+	ShareID        int
+	Adoptable      bool // Mark volume as adoptable for cross-cluster adoption
+}
+
+// NFSVolumePropertiesV1 returns Schema v1 properties for an NFS volume.
+func NFSVolumePropertiesV1(params NFSVolumeParams) map[string]string {
+	return VolumePropertiesV1(ProtocolNFS, VolumeParams{
+		VolumeID:       params.VolumeID,
+		CreatedAt:      params.CreatedAt,
+		DeleteStrategy: params.DeleteStrategy,
+		PVCName:        params.PVCName,
+		PVCNamespace:   params.PVCNamespace,
+		PVName:         params.PVName,
+		StorageClass:   params.StorageClass,
+		ClusterID:      params.ClusterID,
+		CapacityBytes:  params.CapacityBytes,
+		Adoptable:      params.Adoptable,
+	}, NFSShareProperties(params.ShareID, params.SharePath))
+}
+
 // NFSVolumeProperties returns properties to set when creating an NFS volume.
 //
 // Deprecated: Use NFSVolumePropertiesV1 for new volumes.
@@ -355,6 +551,7 @@ type NVMeOFVolumeParams struct {
 	SubsystemNQN   string
 	PVCName        string
 	PVCNamespace   string
+	PVName         string
 	StorageClass   string
 	ClusterID      string
 	CapacityBytes  int64
@@ -364,38 +561,19 @@ type NVMeOFVolumeParams struct {
 }
 
 // NVMeOFVolumePropertiesV1 returns Schema v1 properties for an NVMe-oF volume.
-//
-//nolint:dupl // Intentionally similar structure to iSCSI volume properties
 func NVMeOFVolumePropertiesV1(params NVMeOFVolumeParams) map[string]string {
-	props := map[string]string{
-		PropertySchemaVersion:    SchemaVersionV1,
-		PropertyManagedBy:        ManagedByValue,
-		PropertyCSIVolumeName:    params.VolumeID,
-		PropertyCapacityBytes:    int64ToString(params.CapacityBytes),
-		PropertyProtocol:         ProtocolNVMeOF,
-		PropertyCreatedAt:        params.CreatedAt,
-		PropertyDeleteStrategy:   params.DeleteStrategy,
-		PropertyNVMeSubsystemID:  intToString(params.SubsystemID),
-		PropertyNVMeNamespaceID:  intToString(params.NamespaceID),
-		PropertyNVMeSubsystemNQN: params.SubsystemNQN,
-	}
-	// Add adoption properties if provided
-	if params.PVCName != "" {
-		props[PropertyPVCName] = params.PVCName
-	}
-	if params.PVCNamespace != "" {
-		props[PropertyPVCNamespace] = params.PVCNamespace
-	}
-	if params.StorageClass != "" {
-		props[PropertyStorageClass] = params.StorageClass
-	}
-	if params.Adoptable {
-		props[PropertyAdoptable] = PropertyValueTrue
-	}
-	if params.ClusterID != "" {
-		props[PropertyClusterID] = params.ClusterID
-	}
-	return props
+	return VolumePropertiesV1(ProtocolNVMeOF, VolumeParams{
+		VolumeID:       params.VolumeID,
+		CreatedAt:      params.CreatedAt,
+		DeleteStrategy: params.DeleteStrategy,
+		PVCName:        params.PVCName,
+		PVCNamespace:   params.PVCNamespace,
+		PVName:         params.PVName,
+		StorageClass:   params.StorageClass,
+		ClusterID:      params.ClusterID,
+		CapacityBytes:  params.CapacityBytes,
+		Adoptable:      params.Adoptable,
+	}, NVMeOFSubsystemProperties(params.SubsystemID, params.NamespaceID, params.SubsystemNQN))
 }
 
 // NVMeOFVolumeProperties returns properties to set when creating an NVMe-oF volume.
@@ -423,6 +601,7 @@ type ISCSIVolumeParams struct {
 	TargetIQN      string
 	PVCName        string
 	PVCNamespace   string
+	PVName         string
 	StorageClass   string
 	ClusterID      string
 	CapacityBytes  int64
@@ -432,38 +611,19 @@ type ISCSIVolumeParams struct {
 }
 
 // ISCSIVolumePropertiesV1 returns Schema v1 properties for an iSCSI volume.
-//
-//nolint:dupl // Intentionally similar structure to NVMe-oF volume properties
 func ISCSIVolumePropertiesV1(params ISCSIVolumeParams) map[string]string {
-	props := map[string]string{
-		PropertySchemaVersion:  SchemaVersionV1,
-		PropertyManagedBy:      ManagedByValue,
-		PropertyCSIVolumeName:  params.VolumeID,
-		PropertyCapacityBytes:  int64ToString(params.CapacityBytes),
-		PropertyProtocol:       ProtocolISCSI,
-		PropertyCreatedAt:      params.CreatedAt,
-		PropertyDeleteStrategy: params.DeleteStrategy,
-		PropertyISCSITargetID:  intToString(params.TargetID),
-		PropertyISCSIExtentID:  intToString(params.ExtentID),
-		PropertyISCSIIQN:       params.TargetIQN,
-	}
-	// Add adoption properties if provided
-	if params.PVCName != "" {
-		props[PropertyPVCName] = params.PVCName
-	}
-	if params.PVCNamespace != "" {
-		props[PropertyPVCNamespace] = params.PVCNamespace
-	}
-	if params.StorageClass != "" {
-		props[PropertyStorageClass] = params.StorageClass
-	}
-	if params.Adoptable {
-		props[PropertyAdoptable] = PropertyValueTrue
-	}
-	if params.ClusterID != "" {
-		props[PropertyClusterID] = params.ClusterID
-	}
-	return props
+	return VolumePropertiesV1(ProtocolISCSI, VolumeParams{
+		VolumeID:       params.VolumeID,
+		CreatedAt:      params.CreatedAt,
+		DeleteStrategy: params.DeleteStrategy,
+		PVCName:        params.PVCName,
+		PVCNamespace:   params.PVCNamespace,
+		PVName:         params.PVName,
+		StorageClass:   params.StorageClass,
+		ClusterID:      params.ClusterID,
+		CapacityBytes:  params.CapacityBytes,
+		Adoptable:      params.Adoptable,
+	}, ISCSITargetProperties(params.TargetID, params.ExtentID, params.TargetIQN))
 }
 
 // SMBVolumeParams contains parameters for creating SMB volume properties.
@@ -474,6 +634,7 @@ type SMBVolumeParams struct {
 	ShareName      string
 	PVCName        string
 	PVCNamespace   string
+	PVName         string
 	StorageClass   string
 	ClusterID      string
 	CapacityBytes  int64
@@ -482,37 +643,19 @@ type SMBVolumeParams struct {
 }
 
 // SMBVolumePropertiesV1 returns Schema v1 properties for an SMB volume.
-//
-//nolint:dupl // Intentionally similar structure to NFS volume properties
 func SMBVolumePropertiesV1(params SMBVolumeParams) map[string]string {
-	props := map[string]string{
-		PropertySchemaVersion:  SchemaVersionV1,
-		PropertyManagedBy:      ManagedByValue,
-		PropertyCSIVolumeName:  params.VolumeID,
-		PropertyCapacityBytes:  int64ToString(params.CapacityBytes),
-		PropertyProtocol:       ProtocolSMB,
-		PropertyCreatedAt:      params.CreatedAt,
-		PropertyDeleteStrategy: params.DeleteStrategy,
-		PropertySMBShareID:     intToString(params.ShareID),
-		PropertySMBShareName:   params.ShareName,
-	}
-	// Add adoption properties if provided
-	if params.PVCName != "" {
-		props[PropertyPVCName] = params.PVCName
-	}
-	if params.PVCNamespace != "" {
-		props[PropertyPVCNamespace] = params.PVCNamespace
-	}
-	if params.StorageClass != "" {
-		props[PropertyStorageClass] = params.StorageClass
-	}
-	if params.Adoptable {
-		props[PropertyAdoptable] = PropertyValueTrue
-	}
-	if params.ClusterID != "" {
-		props[PropertyClusterID] = params.ClusterID
-	}
-	return props
+	return VolumePropertiesV1(ProtocolSMB, VolumeParams{
+		VolumeID:       params.VolumeID,
+		CreatedAt:      params.CreatedAt,
+		DeleteStrategy: params.DeleteStrategy,
+		PVCName:        params.PVCName,
+		PVCNamespace:   params.PVCNamespace,
+		PVName:         params.PVName,
+		StorageClass:   params.StorageClass,
+		ClusterID:      params.ClusterID,
+		CapacityBytes:  params.CapacityBytes,
+		Adoptable:      params.Adoptable,
+	}, SMBShareProperties(params.ShareID, params.ShareName))
 }
 
 // SnapshotParams contains parameters for creating snapshot properties.
@@ -17,18 +17,29 @@ func TestPropertyNames(t *testing.T) {
 		PropertyProtocol,
 		PropertyDeleteStrategy,
 		PropertyCreatedAt,
+		PropertyCreateGeneration,
+		PropertyMaxSize,
+		PropertyCapacityRounding,
+		PropertyRecursiveDelete,
+		PropertyPendingDelete,
+		PropertyDeleteJobID,
 		// Adoption properties
 		PropertyAdoptable,
 		PropertyPVCName,
 		PropertyPVCNamespace,
+		PropertyPVName,
 		PropertyStorageClass,
+		PropertyReclaimPolicy,
 		// NFS properties
 		PropertyNFSShareID,
 		PropertyNFSSharePath,
+		PropertyNFSSharePending,
 		// NVMe-oF properties
 		PropertyNVMeSubsystemID,
 		PropertyNVMeNamespaceID,
 		PropertyNVMeSubsystemNQN,
+		PropertyNVMeNextNSID,
+		PropertyNVMeNSIDReleasedAt,
 		// iSCSI properties
 		PropertyISCSIIQN,
 		PropertyISCSITargetID,
@@ -43,6 +54,7 @@ func TestPropertyNames(t *testing.T) {
 		PropertySourceDataset,
 		PropertySnapshotSourceVolume,
 		PropertySnapshotCSIName,
+		PropertySnapshotChecksum,
 		// Clone properties
 		PropertyContentSourceType,
 		PropertyContentSourceID,
@@ -441,14 +453,18 @@ func TestPropertyConstants(t *testing.T) {
 		PropertyAdoptable,
 		PropertyPVCName,
 		PropertyPVCNamespace,
+		PropertyPVName,
 		PropertyStorageClass,
 		// NFS properties
 		PropertyNFSShareID,
 		PropertyNFSSharePath,
+		PropertyNFSSharePending,
 		// NVMe-oF properties
 		PropertyNVMeSubsystemID,
 		PropertyNVMeNamespaceID,
 		PropertyNVMeSubsystemNQN,
+		PropertyNVMeNextNSID,
+		PropertyNVMeNSIDReleasedAt,
 		// iSCSI properties
 		PropertyISCSIIQN,
 		PropertyISCSITargetID,
@@ -460,6 +476,7 @@ func TestPropertyConstants(t *testing.T) {
 		PropertySourceDataset,
 		PropertySnapshotSourceVolume,
 		PropertySnapshotCSIName,
+		PropertySnapshotChecksum,
 		// Clone properties
 		PropertyContentSourceType,
 		PropertyContentSourceID,
@@ -525,6 +542,7 @@ func TestNFSVolumePropertiesV1(t *testing.T) {
 		SharePath:      "/mnt/tank/csi/pvc-12345678",
 		PVCName:        "my-data",
 		PVCNamespace:   "default",
+		PVName:         "pvc-12345678-1234-1234-1234-123456789012",
 		StorageClass:   "truenas-nfs",
 		CapacityBytes:  10737418240,
 		ShareID:        42,
@@ -570,6 +588,9 @@ func TestNFSVolumePropertiesV1(t *testing.T) {
 	if props[PropertyPVCNamespace] != params.PVCNamespace {
 		t.Errorf("PropertyPVCNamespace = %q, want %q", props[PropertyPVCNamespace], params.PVCNamespace)
 	}
+	if props[PropertyPVName] != params.PVName {
+		t.Errorf("PropertyPVName = %q, want %q", props[PropertyPVName], params.PVName)
+	}
 	if props[PropertyStorageClass] != params.StorageClass {
 		t.Errorf("PropertyStorageClass = %q, want %q", props[PropertyStorageClass], params.StorageClass)
 	}
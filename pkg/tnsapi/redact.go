@@ -0,0 +1,108 @@
+package tnsapi
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// Log redaction.
+//
+// Storage API payloads can carry authentication material: API keys returned by api_key.*
+// calls, CHAP secrets of iSCSI auth groups, dataset encryption keys and passphrases, and
+// passwords in share or mount configurations. Everything logged from a payload goes through
+// Redact (structured values) or RedactString (raw JSON and key=value text), which replace
+// the values of sensitive keys with RedactedValue. The key list errs on the side of redacting.
+
+// RedactedValue replaces sensitive values in logged payloads.
+const RedactedValue = "***stripped***"
+
+// sensitiveKeySubstrings match (case-insensitively) the keys whose values are redacted.
+var sensitiveKeySubstrings = []string{
+	"password",
+	"passphrase",
+	"secret", // CHAP secret/peersecret, client secrets
+	"token",
+	"api_key",
+	"apikey",
+	"private_key",
+	"privatekey",
+}
+
+// sensitiveExactKeys are redacted only on an exact match inside sensitiveExactParents, as
+// substrings would catch too much (e.g. "key" in user_properties_update entries).
+var sensitiveExactKeys = []string{"key"}
+
+// sensitiveExactParents are the objects whose sensitiveExactKeys are redacted: dataset
+// encryption options and call results (api_key.create returns the new key as "key").
+var sensitiveExactParents = []string{"encryption_options", "result"}
+
+// sensitiveTextPattern matches sensitive "key": "value" and key=value pairs in unstructured text.
+var sensitiveTextPattern = regexp.MustCompile(
+	`(?i)("?[\w-]*(?:password|passphrase|secret|token|api_?key|private_?key)[\w-]*"?\s*[:=]\s*)("(?:[^"\\]|\\.)*"|[^\s,;}\]]+)`)
+
+// isSensitiveKey reports whether the value of key (inside the object named parent) is redacted.
+func isSensitiveKey(parent, key string) bool {
+	lower := strings.ToLower(key)
+	for _, substr := range sensitiveKeySubstrings {
+		if strings.Contains(lower, substr) {
+			return true
+		}
+	}
+	for _, exact := range sensitiveExactKeys {
+		if lower != exact {
+			continue
+		}
+		for _, p := range sensitiveExactParents {
+			if strings.EqualFold(parent, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Redact returns the JSON encoding of v with sensitive values replaced, for logging.
+func Redact(v interface{}) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return RedactString(strings.TrimSpace(strings.ReplaceAll(err.Error(), "\n", " ")))
+	}
+	return RedactString(string(raw))
+}
+
+// RedactString returns a JSON document or free-form text with sensitive values replaced.
+func RedactString(s string) string {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(s), &doc); err == nil {
+		if redacted, err := json.Marshal(redactValue("", doc)); err == nil {
+			return string(redacted)
+		}
+	}
+	return sensitiveTextPattern.ReplaceAllString(s, "${1}"+RedactedValue)
+}
+
+// redactValue redacts a decoded JSON value found under the key parent.
+func redactValue(parent string, v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if isSensitiveKey(parent, key) && field != nil {
+				value[key] = RedactedValue
+				continue
+			}
+			value[key] = redactValue(key, field)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactValue(parent, item)
+		}
+		return value
+	case string:
+		// Free-form strings (e.g. share options, error messages) may embed key=value secrets
+		return sensitiveTextPattern.ReplaceAllString(value, "${1}"+RedactedValue)
+	default:
+		return v
+	}
+}
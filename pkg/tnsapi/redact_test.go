@@ -0,0 +1,187 @@
+package tnsapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/coder/websocket"
+	"k8s.io/klog/v2"
+)
+
+func TestRedactString(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		secret string // must not appear in the output
+		keep   string // must still appear in the output
+	}{
+		{
+			name:   "api key result",
+			input:  `{"id":"7","result":{"id":3,"name":"csi","key":"3-abcdefSECRET"}}`,
+			secret: "abcdefSECRET",
+			keep:   `"name":"csi"`,
+		},
+		{
+			name:   "chap secrets",
+			input:  `{"id":"8","result":[{"tag":1,"user":"csi","secret":"chapSECRET1","peersecret":"chapSECRET2"}]}`,
+			secret: "chapSECRET",
+			keep:   `"user":"csi"`,
+		},
+		{
+			name:   "encryption options",
+			input:  `{"params":[{"name":"tank/pvc-1","encryption_options":{"algorithm":"AES-256-GCM","key":"deadbeefSECRET","passphrase":null}}]}`,
+			secret: "deadbeefSECRET",
+			keep:   `"algorithm":"AES-256-GCM"`,
+		},
+		{
+			name:   "user property keys are kept",
+			input:  `{"user_properties_update":[{"key":"tns-csi:managed_by","value":"tns-csi"}]}`,
+			secret: "",
+			keep:   `"key":"tns-csi:managed_by"`,
+		},
+		{
+			name:   "password embedded in a string",
+			input:  `{"result":{"options":"vers=3.0,password=mountSECRET,uid=0"}}`,
+			secret: "mountSECRET",
+			keep:   "uid=0",
+		},
+		{
+			name:   "mount command line",
+			input:  "-t cifs -o vers=3.0,password=mountSECRET,guest //nas/share /staging",
+			secret: "mountSECRET",
+			keep:   "//nas/share /staging",
+		},
+		{
+			name:   "truncated json",
+			input:  `{"result":{"api_key":"1-truncSECRET","na`,
+			secret: "truncSECRET",
+			keep:   `"result"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RedactString(tt.input)
+			if tt.secret != "" && strings.Contains(got, tt.secret) {
+				t.Errorf("RedactString() = %s, still contains %q", got, tt.secret)
+			}
+			if !strings.Contains(got, tt.keep) {
+				t.Errorf("RedactString() = %s, lost %q", got, tt.keep)
+			}
+		})
+	}
+}
+
+func TestRedactStruct(t *testing.T) {
+	got := Redact(map[string]interface{}{
+		"encryption_options": map[string]string{"passphrase": "phraseSECRET"},
+		"comment":            "CSI Volume: pvc-1",
+	})
+	if strings.Contains(got, "phraseSECRET") || !strings.Contains(got, "CSI Volume: pvc-1") {
+		t.Errorf("Redact() = %s", got)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes by klog.
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestNoSecretsInLogs runs authentication and calls returning secrets with all log levels
+// enabled and checks that no secret reaches the log output.
+func TestNoSecretsInLogs(t *testing.T) {
+	const apiKey = "1-authKeySECRET"
+	secrets := []string{"authKeySECRET", "newKeySECRET", "chapSECRET", "smbSECRET"}
+
+	flags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flags)
+	for name, value := range map[string]string{"v": "10", "logtostderr": "false", "alsologtostderr": "false", "stderrthreshold": "FATAL"} {
+		if err := flags.Set(name, value); err != nil {
+			t.Fatalf("set klog flag %s: %v", name, err)
+		}
+	}
+	logs := &syncBuffer{}
+	klog.SetOutput(logs)
+	t.Cleanup(func() {
+		_ = flags.Set("v", "0")
+		_ = flags.Set("logtostderr", "true")
+		klog.SetOutput(os.Stderr)
+	})
+
+	server := newMockWSServer()
+	server.expectAuthKey = apiKey
+	server.handler = func(conn *websocket.Conn) {
+		ctx := context.Background()
+		for {
+			_, message, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			var req Request
+			if json.Unmarshal(message, &req) != nil {
+				continue
+			}
+			result := json.RawMessage(`true`)
+			switch req.Method {
+			case "api_key.create":
+				result = json.RawMessage(`{"id":4,"name":"csi-rotated","key":"4-newKeySECRET"}`)
+			case "iscsi.auth.query":
+				result = json.RawMessage(`[{"id":1,"tag":1,"user":"csi","secret":"chapSECRET1","peersecret":"chapSECRET2"}]`)
+			case "sharing.smb.query":
+				result = json.RawMessage(`[{"id":2,"name":"pvc-1","auxsmbconf":"password=smbSECRET"}]`)
+			}
+			respBytes, _ := json.Marshal(Response{ID: req.ID, Result: result})
+			if conn.Write(ctx, websocket.MessageText, respBytes) != nil {
+				return
+			}
+		}
+	}
+	defer server.Close()
+
+	client, err := NewClient(server.URL(), apiKey, false)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer cleanupClient(client)
+
+	ctx := context.Background()
+	for _, method := range []string{"api_key.create", "iscsi.auth.query", "sharing.smb.query"} {
+		var result interface{}
+		if err := client.Call(ctx, method, []interface{}{}, &result); err != nil {
+			t.Fatalf("Call(%s) error = %v", method, err)
+		}
+	}
+	if err := client.UpdateAPIKey(ctx, "1-authKeySECRET-rotated"); err != nil {
+		t.Logf("UpdateAPIKey() error = %v", err) // the mock only accepts the original key
+	}
+
+	klog.Flush()
+	output := logs.String()
+	if !strings.Contains(output, "api_key.create") {
+		t.Fatalf("verbose logs not captured:\n%s", output)
+	}
+	for _, secret := range secrets {
+		if strings.Contains(output, secret) {
+			t.Errorf("secret %q found in logs:\n%s", secret, output)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package tnsapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Response schema checks.
+//
+// Field names of the NVMe-oF responses drifted between TrueNAS releases, which the decoding
+// structs absorb with alternative fields and RawMessage guessing; when a release renames a field
+// again, the driver quietly decodes zero IDs. To make such breakage diagnosable, the objects of
+// the responses listed in responseSchemas are compared with the fields expected for the TrueNAS
+// version the client is connected to (system.version_short, detected on the first checked
+// response). Each unexpected or missing field is logged in a structured warning once per method
+// and field. Decoding itself stays lenient.
+
+// methodSystemVersion returns the TrueNAS version, e.g. "25.04.2".
+const methodSystemVersion = "system.version_short"
+
+// versionDetectTimeout bounds the version query made for the first checked response.
+const versionDetectTimeout = 10 * time.Second
+
+// responseSchema lists the top-level fields of the objects a method returns, from a TrueNAS
+// version on.
+type responseSchema struct {
+	method   string
+	since    string   // First TrueNAS version returning this schema
+	required []string // Fields the driver relies on
+	optional []string // Fields known to be returned that the driver ignores or only sometimes gets
+}
+
+// responseSchemas are the checked response schemas. A method may have several entries; the one
+// with the highest since not above the detected version applies, or the newest one if the
+// version is unknown.
+var responseSchemas = []responseSchema{
+	{
+		method:   "nvmet.subsys.query",
+		since:    "25.04",
+		required: []string{"id", "name", "subnqn"},
+		optional: []string{"serial", "allow_any_host", "pi_enable", "qix_max", "ieee_oui", "ana", "hosts", "namespaces", "ports", "enabled"},
+	},
+	{
+		method:   "nvmet.namespace.query",
+		since:    "25.04",
+		required: []string{"id", "nsid", "device_path", "subsys"},
+		optional: []string{"subsys_id", "device_type", "filesize", "device_uuid", "device_nguid", "enabled", "locked"},
+	},
+	{
+		method:   "nvmet.port.query",
+		since:    "25.04",
+		required: []string{"id", "addr_trtype", "addr_traddr", "addr_trsvcid"},
+		optional: []string{"index", "addr_adrfam", "inline_data_size", "max_queue_size", "pi_enable", "enabled"},
+	},
+	{
+		method:   "nvmet.port_subsys.query",
+		since:    "25.04",
+		required: []string{"id", "port_id", "subsys_id"},
+		optional: []string{"port", "subsys"},
+	},
+}
+
+// schemaFor returns the schema of method's responses for a TrueNAS version ("" = unknown).
+func schemaFor(method, version string) (responseSchema, bool) {
+	var best responseSchema
+	found := false
+	for _, schema := range responseSchemas {
+		if schema.method != method || (version != "" && compareVersions(schema.since, version) > 0) {
+			continue
+		}
+		if !found || compareVersions(schema.since, best.since) > 0 {
+			best, found = schema, true
+		}
+	}
+	return best, found
+}
+
+// diffSchema returns the fields of the objects in a result (an array of objects or a single
+// object) that the schema does not know, and the required fields missing from any of them.
+func diffSchema(schema responseSchema, result json.RawMessage) (unexpected, missing []string) {
+	var objects []map[string]json.RawMessage
+	if err := json.Unmarshal(result, &objects); err != nil {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(result, &object); err != nil {
+			return nil, nil
+		}
+		objects = append(objects, object)
+	}
+
+	for _, object := range objects {
+		for field := range object {
+			if !slices.Contains(schema.required, field) && !slices.Contains(schema.optional, field) &&
+				!slices.Contains(unexpected, field) {
+				unexpected = append(unexpected, field)
+			}
+		}
+		for _, field := range schema.required {
+			if _, ok := object[field]; !ok && !slices.Contains(missing, field) {
+				missing = append(missing, field)
+			}
+		}
+	}
+	slices.Sort(unexpected)
+	slices.Sort(missing)
+	return unexpected, missing
+}
+
+// schemaChecker holds the detected TrueNAS version and the fields already reported.
+type schemaChecker struct {
+	mu       sync.Mutex
+	reported map[string]bool // method + " " + kind + " " + field
+	version  string
+	detected bool
+}
+
+// unreported returns the fields of method not reported before as kind ("unexpected" or
+// "missing") and marks them as reported.
+func (s *schemaChecker) unreported(method, kind string, fields []string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reported == nil {
+		s.reported = make(map[string]bool)
+	}
+	var fresh []string
+	for _, field := range fields {
+		if key := method + " " + kind + " " + field; !s.reported[key] {
+			s.reported[key] = true
+			fresh = append(fresh, field)
+		}
+	}
+	return fresh
+}
+
+// truenasVersion returns the TrueNAS version, querying it on first use. It returns "" if the
+// version cannot be determined.
+func (c *Client) truenasVersion(ctx context.Context) string {
+	c.schemas.mu.Lock()
+	defer c.schemas.mu.Unlock()
+	if c.schemas.detected {
+		return c.schemas.version
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, versionDetectTimeout)
+	defer cancel()
+	var version string
+	err := c.Call(ctx, methodSystemVersion, []interface{}{}, &version)
+	if err != nil && (isConnectionError(err) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		// Transient - try again with the next checked response
+		klog.V(4).Infof("Failed to detect TrueNAS version, will retry: %v", err)
+		return ""
+	}
+	if err != nil {
+		klog.V(4).Infof("TrueNAS version unavailable, checking responses against the newest schemas: %v", err)
+	} else {
+		klog.V(4).Infof("Detected TrueNAS version %s", version)
+	}
+	c.schemas.version, c.schemas.detected = version, true
+	return version
+}
+
+// checkResponseSchema logs the unexpected and missing fields of a response of method that were
+// not reported yet.
+func (c *Client) checkResponseSchema(ctx context.Context, method string, result json.RawMessage) {
+	if _, ok := schemaFor(method, ""); !ok {
+		return
+	}
+	version := c.truenasVersion(ctx)
+	schema, ok := schemaFor(method, version)
+	if !ok {
+		return
+	}
+
+	unexpected, missing := diffSchema(schema, result)
+	unexpected = c.schemas.unreported(method, "unexpected", unexpected)
+	missing = c.schemas.unreported(method, "missing", missing)
+	if len(unexpected) == 0 && len(missing) == 0 {
+		return
+	}
+	if version == "" {
+		version = "unknown"
+	}
+	klog.Warningf("TrueNAS response schema mismatch: method=%s truenasVersion=%s schemaSince=%s unexpectedFields=%v missingFields=%v",
+		method, version, schema.since, unexpected, missing)
+}
+
+// compareVersions compares dotted TrueNAS versions numerically, ignoring any non-numeric prefix
+// ("TrueNAS-SCALE-") or suffix ("-RC.1"). Missing components count as 0.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := range max(len(pa), len(pb)) {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionParts returns the numeric components of the first dotted number in a version string.
+func versionParts(version string) []int {
+	start := strings.IndexAny(version, "0123456789")
+	if start < 0 {
+		return nil
+	}
+	var parts []int
+	for _, part := range strings.Split(version[start:], ".") {
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		if end == 0 {
+			break
+		}
+		n, _ := strconv.Atoi(part[:end]) //nolint:errcheck // digits only
+		parts = append(parts, n)
+		if end < len(part) {
+			break
+		}
+	}
+	return parts
+}
@@ -0,0 +1,146 @@
+package tnsapi
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"25.04", "25.04.0", 0},
+		{"25.04.2", "25.04", 1},
+		{"25.04", "25.10", -1},
+		{"TrueNAS-SCALE-25.10.1", "25.10", 1},
+		{"25.10-RC.1", "25.10", 0},
+		{"26.04", "25.10.2.1", 1},
+		{"", "25.04", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSchemaForVersion(t *testing.T) {
+	saved := responseSchemas
+	t.Cleanup(func() { responseSchemas = saved })
+	responseSchemas = []responseSchema{
+		{method: "nvmet.namespace.query", since: "25.04", required: []string{"device_path"}},
+		{method: "nvmet.namespace.query", since: "25.10", required: []string{"device"}},
+	}
+
+	tests := []struct {
+		version, wantSince string
+		wantFound          bool
+	}{
+		{version: "25.04.2", wantSince: "25.04", wantFound: true},
+		{version: "25.10.0", wantSince: "25.10", wantFound: true},
+		{version: "26.04", wantSince: "25.10", wantFound: true},
+		{version: "", wantSince: "25.10", wantFound: true}, // Unknown version: newest schema
+		{version: "24.10", wantFound: false},
+	}
+	for _, tt := range tests {
+		schema, found := schemaFor("nvmet.namespace.query", tt.version)
+		if found != tt.wantFound || (found && schema.since != tt.wantSince) {
+			t.Errorf("schemaFor(%q) = %q, %v; want %q, %v", tt.version, schema.since, found, tt.wantSince, tt.wantFound)
+		}
+	}
+	if _, found := schemaFor("pool.dataset.query", ""); found {
+		t.Error("schemaFor() found a schema for an unchecked method")
+	}
+}
+
+func TestDiffSchema(t *testing.T) {
+	schema := responseSchema{
+		method:   "nvmet.port_subsys.query",
+		required: []string{"id", "port_id", "subsys_id"},
+		optional: []string{"port", "subsys"},
+	}
+	tests := []struct {
+		name                     string
+		result                   string
+		wantUnexpected, wantMiss []string
+	}{
+		{
+			name:   "matching",
+			result: `[{"id": 1, "port_id": 1, "subsys_id": 2, "subsys": {}}]`,
+		},
+		{
+			name:           "renamed field",
+			result:         `[{"id": 1, "port_id": 1, "subsys_id": 2}, {"id": 2, "port_id": 1, "subsystem_id": 3}]`,
+			wantUnexpected: []string{"subsystem_id"},
+			wantMiss:       []string{"subsys_id"},
+		},
+		{
+			name:           "single object",
+			result:         `{"id": 1, "portid": 1, "subsys_id": 2}`,
+			wantUnexpected: []string{"portid"},
+			wantMiss:       []string{"port_id"},
+		},
+		{name: "empty", result: `[]`},
+		{name: "not objects", result: `true`},
+	}
+	for _, tt := range tests {
+		unexpected, missing := diffSchema(schema, json.RawMessage(tt.result))
+		if !slices.Equal(unexpected, tt.wantUnexpected) || !slices.Equal(missing, tt.wantMiss) {
+			t.Errorf("%s: diffSchema() = %v, %v; want %v, %v", tt.name, unexpected, missing, tt.wantUnexpected, tt.wantMiss)
+		}
+	}
+}
+
+func TestSchemaCheckerReportsFieldsOnce(t *testing.T) {
+	var s schemaChecker
+	if got := s.unreported("nvmet.namespace.query", "unexpected", []string{"a", "b"}); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("first report = %v, want [a b]", got)
+	}
+	if got := s.unreported("nvmet.namespace.query", "unexpected", []string{"b", "c"}); !slices.Equal(got, []string{"c"}) {
+		t.Errorf("second report = %v, want [c]", got)
+	}
+	if got := s.unreported("nvmet.namespace.query", "missing", []string{"b"}); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("missing field = %v, want it reported apart from unexpected ones", got)
+	}
+	if got := s.unreported("nvmet.subsys.query", "unexpected", []string{"a"}); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("other method = %v, want [a]", got)
+	}
+}
+
+// TestNVMeOFPortResponses decodes nvmet.port.query objects as TrueNAS returns them for each
+// transport: FC ports carry the port's names as addr_traddr and no service ID.
+func TestNVMeOFPortResponses(t *testing.T) {
+	schema, _ := schemaFor("nvmet.port.query", "25.10")
+	tests := []struct {
+		name   string
+		result string
+		want   NVMeOFPort
+	}{
+		{
+			name:   "tcp",
+			result: `{"id": 1, "index": 1, "addr_trtype": "TCP", "addr_trsvcid": 4420, "addr_traddr": "192.0.2.10", "addr_adrfam": "IPV4", "inline_data_size": null, "max_queue_size": null, "pi_enable": null, "enabled": true}`,
+			want:   NVMeOFPort{ID: 1, Transport: "TCP", Address: "192.0.2.10", Port: 4420},
+		},
+		{
+			name:   "rdma",
+			result: `{"id": 2, "index": 2, "addr_trtype": "RDMA", "addr_trsvcid": 4421, "addr_traddr": "198.51.100.10", "addr_adrfam": "IPV4", "inline_data_size": null, "max_queue_size": null, "pi_enable": null, "enabled": true}`,
+			want:   NVMeOFPort{ID: 2, Transport: "RDMA", Address: "198.51.100.10", Port: 4421},
+		},
+		{
+			name:   "fc",
+			result: `{"id": 3, "index": 3, "addr_trtype": "FC", "addr_trsvcid": null, "addr_traddr": "nn-0x200000109b123456:pn-0x100000109b123456", "addr_adrfam": "FC", "inline_data_size": null, "max_queue_size": null, "pi_enable": null, "enabled": true}`,
+			want:   NVMeOFPort{ID: 3, Transport: "FC", Address: "nn-0x200000109b123456:pn-0x100000109b123456"},
+		},
+	}
+	for _, tt := range tests {
+		if unexpected, missing := diffSchema(schema, json.RawMessage(tt.result)); len(unexpected) > 0 || len(missing) > 0 {
+			t.Errorf("%s: diffSchema() = %v, %v; want no differences", tt.name, unexpected, missing)
+		}
+		var port NVMeOFPort
+		if err := json.Unmarshal([]byte(tt.result), &port); err != nil || port != tt.want {
+			t.Errorf("%s: decoded port = %+v, %v; want %+v", tt.name, port, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package tnsapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Raw ZFS send streams.
+//
+// TrueNAS serves job input and output over HTTP next to its WebSocket API: core.download starts
+// a job and returns a one-time /_download URL streaming the job's output, and a multipart POST to
+// /_upload starts a job reading the uploaded file. SendSnapshot and ReceiveSnapshot run
+// zfs.snapshot.send and zfs.snapshot.receive this way, so a single volume can leave or enter
+// TrueNAS as a portable `zfs send` stream without a replication target. Both authenticate with the
+// API key and use the TLS and proxy settings of the WebSocket connection.
+
+const (
+	methodCoreDownload    = "core.download"
+	methodSnapshotSend    = "zfs.snapshot.send"
+	methodSnapshotReceive = "zfs.snapshot.receive"
+
+	// uploadPath is the HTTP endpoint receiving job input.
+	uploadPath = "/_upload/"
+
+	// streamJobPollInterval is how often a job still running after its transfer ended is polled.
+	streamJobPollInterval = 2 * time.Second
+)
+
+// ErrStreamTransfer is returned when TrueNAS rejects a stream download or upload.
+var ErrStreamTransfer = errors.New("stream transfer failed")
+
+// SnapshotSendParams selects the stream SendSnapshot produces.
+type SnapshotSendParams struct {
+	Snapshot        string `json:"name"`                       // pool/dataset@snapshot
+	IncrementalBase string `json:"incremental_base,omitempty"` // Earlier snapshot of the dataset for an incremental stream
+	Raw             bool   `json:"raw"`                        // Send encrypted datasets without decrypting them (zfs send -w)
+	Properties      bool   `json:"properties"`                 // Include dataset properties (zfs send -p)
+}
+
+// SnapshotReceiveParams selects where ReceiveSnapshot stores a stream.
+type SnapshotReceiveParams struct {
+	Dataset string `json:"dataset"` // Dataset to create, or to update with an incremental stream
+	Force   bool   `json:"force"`   // Roll the dataset back to its latest snapshot first (zfs receive -F)
+}
+
+// sendStream is a zfs send stream being downloaded. Reaching its end waits for the send job,
+// so a stream cut short by a failed send ends with the job's error instead of io.EOF.
+type sendStream struct {
+	ctx   context.Context //nolint:containedctx // the stream outlives SendSnapshot
+	c     *Client
+	body  io.ReadCloser
+	jobID int
+}
+
+// Read implements io.Reader.
+func (s *sendStream) Read(p []byte) (int, error) {
+	n, err := s.body.Read(p)
+	if errors.Is(err, io.EOF) {
+		if jobErr := s.c.waitForStreamJob(s.ctx, s.jobID); jobErr != nil {
+			return n, fmt.Errorf("zfs send job %d failed: %w", s.jobID, jobErr)
+		}
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (s *sendStream) Close() error {
+	return s.body.Close()
+}
+
+// SendSnapshot starts a zfs send of a snapshot and returns the stream. The caller must close it.
+func (c *Client) SendSnapshot(ctx context.Context, params SnapshotSendParams) (io.ReadCloser, error) {
+	klog.V(4).Infof("Sending snapshot %s as a stream (incremental from %q)", params.Snapshot, params.IncrementalBase)
+
+	filename := strings.NewReplacer("/", "_", "@", "_").Replace(params.Snapshot) + ".zfs"
+	var started []json.RawMessage
+	if err := c.Call(ctx, methodCoreDownload, []interface{}{methodSnapshotSend, []interface{}{params}, filename}, &started); err != nil {
+		return nil, fmt.Errorf("failed to start zfs send of %s: %w", params.Snapshot, err)
+	}
+	var jobID int
+	var path string
+	if len(started) != 2 || json.Unmarshal(started[0], &jobID) != nil || json.Unmarshal(started[1], &path) != nil {
+		return nil, fmt.Errorf("%w: unexpected %s result for %s", ErrStreamTransfer, methodCoreDownload, params.Snapshot)
+	}
+
+	resp, err := c.streamRequest(ctx, http.MethodGet, path, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download zfs send stream of %s: %w", params.Snapshot, err)
+	}
+	return &sendStream{ctx: ctx, c: c, body: resp.Body, jobID: jobID}, nil
+}
+
+// ReceiveSnapshot uploads a zfs send stream and waits until TrueNAS received it.
+func (c *Client) ReceiveSnapshot(ctx context.Context, params SnapshotReceiveParams, stream io.Reader) error {
+	klog.V(4).Infof("Receiving stream into dataset %s (force=%v)", params.Dataset, params.Force)
+
+	data, err := json.Marshal(map[string]interface{}{"method": methodSnapshotReceive, "params": []interface{}{params}})
+	if err != nil {
+		return fmt.Errorf("failed to encode upload request: %w", err)
+	}
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		//nolint:errcheck,gosec // CloseWithError(nil) closes the pipe normally
+		writer.CloseWithError(writeUploadForm(form, data, stream))
+	}()
+
+	resp, err := c.streamRequest(ctx, http.MethodPost, uploadPath, form.FormDataContentType(), body)
+	if err != nil {
+		//nolint:errcheck,gosec // stops the form writer
+		body.CloseWithError(err)
+		return fmt.Errorf("failed to upload stream into %s: %w", params.Dataset, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var started struct {
+		JobID int `json:"job_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&started); err != nil {
+		return fmt.Errorf("%w: unexpected upload response for %s: %w", ErrStreamTransfer, params.Dataset, err)
+	}
+	if err := c.waitForStreamJob(ctx, started.JobID); err != nil {
+		return fmt.Errorf("zfs receive into %s failed: %w", params.Dataset, err)
+	}
+	klog.Infof("Received stream into dataset %s", params.Dataset)
+	return nil
+}
+
+// waitForStreamJob waits for the job behind a finished transfer, which has usually completed by then.
+func (c *Client) waitForStreamJob(ctx context.Context, jobID int) error {
+	if job, err := c.GetJobStatus(ctx, jobID); err == nil && job.Finished() {
+		_, err := (&jobWatch{id: jobID, started: time.Now()}).update(job)
+		return err
+	}
+	return c.WaitForJob(ctx, jobID, streamJobPollInterval)
+}
+
+// writeUploadForm writes the /_upload form: the job call as "data", then the stream as "file".
+func writeUploadForm(form *multipart.Writer, data []byte, stream io.Reader) error {
+	if err := form.WriteField("data", string(data)); err != nil {
+		return err
+	}
+	file, err := form.CreateFormFile("file", "stream.zfs")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, stream); err != nil {
+		return err
+	}
+	return form.Close()
+}
+
+// streamRequest sends an HTTP request to path on the storage system in use and returns the
+// response if it succeeded. The caller closes the response body.
+func (c *Client) streamRequest(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	c.mu.Lock()
+	wsURL, apiKey := c.url, c.apiKey
+	c.mu.Unlock()
+
+	base, err := httpBaseURL(wsURL)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := c.httpClient(base)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer func() { _ = resp.Body.Close() }()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%w: %s %s: %s: %s", ErrStreamTransfer, method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+// httpBaseURL returns the HTTP(S) origin of a WebSocket API URL.
+func httpBaseURL(wsURL string) (string, error) {
+	u, err := neturl.Parse(wsURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid API URL %q: %w", wsURL, err)
+	}
+	switch u.Scheme {
+	case "wss":
+		u.Scheme = "https"
+	case "ws":
+		u.Scheme = "http"
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
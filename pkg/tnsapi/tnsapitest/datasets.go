@@ -0,0 +1,1103 @@
+package tnsapitest
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+)
+
+// state is the emulated TrueNAS configuration. All access goes through Server.mu.
+type state struct {
+	pools      map[string]record
+	datasets   map[string]record
+	snapshots  map[string]record
+	nfsShares  map[int]record
+	smbShares  map[int]record
+	subsystems map[int]record
+	namespaces map[int]record
+	ports      map[int]record
+	portSubsys map[int]record
+	hosts      map[int]record
+	hostSubsys map[int]record
+	jobs       map[int]record
+	// nfs4Clients holds the NFSv4 clients of the NFS service by nfsd client ID
+	nfs4Clients map[string]record
+	// noNFSDClientDirs emulates kernels without /proc/fs/nfsd/clients
+	noNFSDClientDirs bool
+	// downloads holds the output of core.download jobs until it is fetched
+	downloads map[int][]byte
+	// subscriptions holds the collections clients subscribed to with core.subscribe;
+	// events are their pending collection_update notifications.
+	subscriptions map[string]bool
+	events        []event
+	nextID        int
+	txg           int
+}
+
+func newState() *state {
+	return &state{
+		pools:      make(map[string]record),
+		datasets:   make(map[string]record),
+		snapshots:  make(map[string]record),
+		nfsShares:  make(map[int]record),
+		smbShares:  make(map[int]record),
+		subsystems: make(map[int]record),
+		namespaces: make(map[int]record),
+		ports:      make(map[int]record),
+		portSubsys: make(map[int]record),
+		hosts:      make(map[int]record),
+		hostSubsys: make(map[int]record),
+		jobs:       make(map[int]record),
+		downloads:  make(map[int][]byte),
+
+		nfs4Clients:   make(map[string]record),
+		subscriptions: make(map[string]bool),
+	}
+}
+
+// register adds the emulated methods to handlers.
+func (st *state) register(handlers map[string]HandlerFunc) {
+	for method, handler := range map[string]HandlerFunc{
+		"pool.query":               st.poolQuery,
+		"pool.dataset.create":      st.datasetCreate,
+		"pool.dataset.update":      st.datasetUpdate,
+		"pool.dataset.delete":      st.datasetDelete,
+		"pool.dataset.query":       st.datasetQuery,
+		"pool.dataset.promote":     st.datasetPromote,
+		"pool.dataset.rename":      st.datasetRename,
+		"pool.snapshot.create":     st.snapshotCreate,
+		"pool.snapshot.delete":     st.snapshotDelete,
+		"pool.snapshot.query":      st.snapshotQuery,
+		"pool.snapshot.update":     st.snapshotUpdate,
+		"pool.snapshot.clone":      st.snapshotClone,
+		"pool.snapshot.hold":       st.snapshotHold,
+		"pool.snapshot.release":    st.snapshotRelease,
+		"filesystem.stat":          st.filesystemStat,
+		"filesystem.mkdir":         st.filesystemMkdir,
+		"filesystem.setacl":        st.filesystemSetACL,
+		"filesystem.file_receive":  st.filesystemFileReceive,
+		"core.get_jobs":            st.jobQuery,
+		"core.job_abort":           st.jobAbort,
+		"core.download":            st.coreDownload,
+		"core.bulk":                func(params []json.RawMessage) (interface{}, error) { return st.bulk(handlers, params) },
+		"core.subscribe":           st.subscribe,
+		"sharing.nfs.create":       st.nfsCreate,
+		"sharing.nfs.update":       st.nfsUpdate,
+		"sharing.nfs.delete":       st.nfsDelete,
+		"sharing.nfs.query":        st.nfsQuery,
+		"nfs.config":               st.nfsConfig,
+		"nfs.get_nfs4_clients":     st.nfs4ClientQuery,
+		"sharing.smb.create":       st.smbCreate,
+		"sharing.smb.update":       st.smbUpdate,
+		"sharing.smb.delete":       st.smbDelete,
+		"sharing.smb.query":        st.smbQuery,
+		"nvmet.subsys.create":      st.subsysCreate,
+		"nvmet.subsys.delete":      st.subsysDelete,
+		"nvmet.subsys.query":       st.subsysQuery,
+		"nvmet.namespace.create":   st.namespaceCreate,
+		"nvmet.namespace.delete":   st.namespaceDelete,
+		"nvmet.namespace.query":    st.namespaceQuery,
+		"nvmet.port.query":         st.portQuery,
+		"nvmet.port_subsys.create": st.portSubsysCreate,
+		"nvmet.port_subsys.delete": st.portSubsysDelete,
+		"nvmet.port_subsys.query":  st.portSubsysQuery,
+		"nvmet.host.create":        st.hostCreate,
+		"nvmet.host.query":         st.hostQuery,
+		"nvmet.host_subsys.create": st.hostSubsysCreate,
+		"nvmet.host_subsys.delete": st.hostSubsysDelete,
+		"nvmet.host_subsys.query":  st.hostSubsysQuery,
+	} {
+		handlers[method] = handler
+	}
+}
+
+// newID returns the next numeric object ID. IDs are unique across object types.
+func (st *state) newID() int {
+	st.nextID++
+	return st.nextID
+}
+
+// parsedValue builds a TrueNAS property value ({"value", "rawvalue", "parsed"}).
+func parsedValue(v interface{}) map[string]interface{} {
+	var raw string
+	switch v := v.(type) {
+	case int64:
+		raw = strconv.FormatInt(v, 10)
+	case string:
+		raw = v
+	}
+	return map[string]interface{}{"value": raw, "rawvalue": raw, "parsed": v}
+}
+
+// Pools
+
+func (st *state) addPool(name string, size int64) {
+	st.pools[name] = record{"id": float64(len(st.pools) + 1), "name": name, "status": "ONLINE", "path": "/mnt/" + name, "size": float64(size)}
+}
+
+// allocated returns the bytes reserved in a pool: zvol sizes plus filesystem quotas.
+func (st *state) allocated(pool string) int64 {
+	var total int64
+	for id, ds := range st.datasets {
+		if poolOf(id) != pool {
+			continue
+		}
+		if v, ok := ds["volsize"].(float64); ok && ds["sparse"] != true {
+			total += int64(v)
+		} else if v, ok := ds["refquota"].(float64); ok {
+			total += int64(v)
+		}
+	}
+	return total
+}
+
+// free returns the unreserved bytes in a pool.
+func (st *state) free(pool string) int64 {
+	p, ok := st.pools[pool]
+	if !ok {
+		return 0
+	}
+	return int64(p["size"].(float64)) - st.allocated(pool)
+}
+
+func poolOf(datasetID string) string {
+	pool, _, _ := strings.Cut(datasetID, "/")
+	return pool
+}
+
+func parentOf(datasetID string) string {
+	if i := strings.LastIndex(datasetID, "/"); i >= 0 {
+		return datasetID[:i]
+	}
+	return ""
+}
+
+func (st *state) poolQuery(params []json.RawMessage) (interface{}, error) {
+	filters, opts, err := parseQuery("pool.query", params)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]record, 0, len(st.pools))
+	for name, p := range st.pools {
+		size := int64(p["size"].(float64))
+		allocated := st.allocated(name)
+		records = append(records, record{
+			"id":     p["id"],
+			"name":   name,
+			"status": p["status"],
+			"path":   p["path"],
+			"properties": map[string]interface{}{
+				"size":      parsedValue(size),
+				"allocated": parsedValue(allocated),
+				"free":      parsedValue(size - allocated),
+				"capacity":  parsedValue(allocated * 100 / size),
+			},
+		})
+	}
+	return query("pool.query", records, filters, opts)
+}
+
+// Datasets
+
+// datasetCreateParams covers both tnsapi.DatasetCreateParams and tnsapi.ZvolCreateParams.
+type datasetCreateParams struct {
+	Sparse   *bool  `json:"sparse"`
+	RefQuota *int64 `json:"refquota"`
+	Volsize  *int64 `json:"volsize"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Comments string `json:"comments"`
+}
+
+// datasetProperties are the string properties stored as {"value": ...} objects when set on create.
+var datasetProperties = []string{
+	"compression", "deduplication", "atime", "sync", "recordsize", "volblocksize", "snapdir",
+	"readonly", "exec", "aclmode", "acltype", "casesensitivity", "share_type",
+}
+
+func (st *state) datasetCreate(params []json.RawMessage) (interface{}, error) {
+	var p datasetCreateParams
+	var raw map[string]interface{}
+	if err := decodeParams("pool.dataset.create", params, &p); err != nil {
+		return nil, err
+	}
+	if err := decodeParams("pool.dataset.create", params, &raw); err != nil {
+		return nil, err
+	}
+	if p.Name == "" {
+		return nil, errInvalid("pool.dataset.create.name: attribute required")
+	}
+	if _, ok := st.datasets[p.Name]; ok {
+		return nil, errExists("Path %s already exists", p.Name)
+	}
+	parent := parentOf(p.Name)
+	if _, ok := st.pools[poolOf(p.Name)]; !ok || parent == "" {
+		return nil, errNotFound("Pool for %s does not exist", p.Name)
+	}
+	if _, ok := st.datasets[parent]; !ok && parent != poolOf(p.Name) {
+		return nil, errNotFound("Parent dataset %s does not exist", parent)
+	}
+	if p.Type == "" {
+		p.Type = "FILESYSTEM"
+	}
+
+	st.txg++
+	ds := record{
+		"id":              p.Name,
+		"name":            p.Name,
+		"pool":            poolOf(p.Name),
+		"type":            p.Type,
+		"createtxg":       strconv.Itoa(st.txg),
+		"user_properties": map[string]interface{}{},
+	}
+	switch p.Type {
+	case "FILESYSTEM":
+		ds["mountpoint"] = "/mnt/" + p.Name
+		if p.RefQuota != nil {
+			if *p.RefQuota > st.free(poolOf(p.Name)) {
+				return nil, errNoSpace("Not enough space in pool %s for quota %d", poolOf(p.Name), *p.RefQuota)
+			}
+			ds["refquota"] = float64(*p.RefQuota)
+		}
+	case "VOLUME":
+		if p.Volsize == nil || *p.Volsize <= 0 {
+			return nil, errInvalid("pool.dataset.create.volsize: volsize is required for volumes")
+		}
+		sparse := p.Sparse != nil && *p.Sparse
+		if !sparse && *p.Volsize > st.free(poolOf(p.Name)) {
+			return nil, errNoSpace("Not enough space in pool %s for volume size %d", poolOf(p.Name), *p.Volsize)
+		}
+		ds["volsize"] = float64(*p.Volsize)
+		ds["sparse"] = sparse
+	default:
+		return nil, errInvalid("pool.dataset.create.type: invalid type %q", p.Type)
+	}
+	for _, name := range datasetProperties {
+		if v, ok := raw[name].(string); ok && v != "" {
+			ds[name] = parsedValue(v)
+		}
+	}
+	if p.Comments != "" {
+		ds["comments"] = parsedValue(p.Comments)
+	}
+	st.datasets[p.Name] = ds
+	return st.datasetView(ds, true), nil
+}
+
+// datasetView returns a dataset as pool.dataset.query reports it, with live space accounting.
+func (st *state) datasetView(ds record, withUserProperties bool) record {
+	view := make(record, len(ds)+2)
+	for k, v := range ds {
+		if strings.HasPrefix(k, "_") || (k == "user_properties" && !withUserProperties) {
+			continue // "_" keys are emulator bookkeeping
+		}
+		view[k] = v
+	}
+	available := st.free(poolOf(ds["id"].(string)))
+	if quota, ok := ds["refquota"].(float64); ok {
+		available = int64(quota)
+	}
+	view["available"] = parsedValue(available)
+	view["used"] = parsedValue(int64(0))
+	view["referenced"] = parsedValue(int64(0))
+	view["written"] = parsedValue(int64(0))
+	view["logicalused"] = parsedValue(int64(0))
+	view["compressratio"] = parsedValue("1.00")
+	if volsize, ok := ds["volsize"].(float64); ok {
+		view["volsize"] = parsedValue(int64(volsize))
+	}
+	if quota, ok := ds["refquota"].(float64); ok {
+		view["refquota"] = parsedValue(int64(quota))
+	}
+	return view
+}
+
+// userPropertyUpdate is one entry of user_properties_update.
+type userPropertyUpdate struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Remove bool   `json:"remove"`
+}
+
+type datasetUpdateParams struct {
+	RefQuota             *int64               `json:"refquota"`
+	Quota                *int64               `json:"quota"`
+	Volsize              *int64               `json:"volsize"`
+	Comments             *string              `json:"comments"`
+	UserPropertiesUpdate []userPropertyUpdate `json:"user_properties_update"`
+}
+
+func (st *state) datasetUpdate(params []json.RawMessage) (interface{}, error) {
+	var id string
+	var p datasetUpdateParams
+	var raw map[string]interface{}
+	if err := decodeParams("pool.dataset.update", params, &id, &p); err != nil {
+		return nil, err
+	}
+	if err := decodeParams("pool.dataset.update", params, &id, &raw); err != nil {
+		return nil, err
+	}
+	ds, ok := st.datasets[id]
+	if !ok {
+		return nil, errNotFound("Dataset %s does not exist", id)
+	}
+
+	if p.Volsize != nil {
+		if ds["type"] != "VOLUME" {
+			return nil, errInvalid("pool.dataset.update.volsize: %s is not a volume", id)
+		}
+		current := int64(ds["volsize"].(float64))
+		if *p.Volsize < current {
+			return nil, errInvalid("pool.dataset.update.volsize: shrinking volume %s is not allowed", id)
+		}
+		if ds["sparse"] != true && *p.Volsize-current > st.free(poolOf(id)) {
+			return nil, errNoSpace("Not enough space in pool %s to grow %s", poolOf(id), id)
+		}
+		ds["volsize"] = float64(*p.Volsize)
+	}
+	for _, quota := range []*int64{p.RefQuota, p.Quota} {
+		if quota == nil {
+			continue
+		}
+		current, _ := ds["refquota"].(float64)
+		if *quota-int64(current) > st.free(poolOf(id)) {
+			return nil, errNoSpace("Not enough space in pool %s for quota %d", poolOf(id), *quota)
+		}
+		ds["refquota"] = float64(*quota)
+	}
+	if p.Comments != nil && *p.Comments != "" {
+		ds["comments"] = parsedValue(*p.Comments)
+	}
+	for _, name := range datasetProperties {
+		if v, ok := raw[name].(string); ok && v != "" {
+			ds[name] = parsedValue(v)
+		}
+	}
+	applyUserProperties(ds["user_properties"].(map[string]interface{}), p.UserPropertiesUpdate, nil)
+	return st.datasetView(ds, true), nil
+}
+
+// applyUserProperties applies user_properties_update entries and removals to props.
+func applyUserProperties(props map[string]interface{}, updates []userPropertyUpdate, remove []string) {
+	for _, u := range updates {
+		if u.Remove {
+			delete(props, u.Key)
+			continue
+		}
+		props[u.Key] = map[string]interface{}{"value": u.Value, "rawvalue": u.Value, "source": "LOCAL", "parsed": u.Value}
+	}
+	for _, key := range remove {
+		delete(props, key)
+	}
+}
+
+func (st *state) datasetDelete(params []json.RawMessage) (interface{}, error) {
+	var id string
+	var opts struct {
+		Recursive bool `json:"recursive"`
+	}
+	if err := decodeParams("pool.dataset.delete", params, &id, &opts); err != nil {
+		return nil, err
+	}
+	if _, ok := st.datasets[id]; !ok {
+		return nil, errNotFound("Dataset %s does not exist", id)
+	}
+
+	var doomed []string
+	for child := range st.datasets {
+		if strings.HasPrefix(child, id+"/") {
+			doomed = append(doomed, child)
+		}
+	}
+	if len(doomed) > 0 && !opts.Recursive {
+		return nil, errBusy("Dataset %s has children", id)
+	}
+	for snapID := range st.snapshots {
+		if snapshotDataset(snapID) == id && !opts.Recursive {
+			return nil, errBusy("Dataset %s has snapshots", id)
+		}
+	}
+	doomed = append(doomed, id)
+
+	for _, ds := range doomed {
+		for snapID := range st.snapshots {
+			if snapshotDataset(snapID) == ds && st.hasClones(snapID) && !containsAll(doomed, st.clonesOf(snapID)) {
+				return nil, errBusy("Snapshot %s has dependent clones", snapID)
+			}
+			if snapshotDataset(snapID) == ds && st.snapshots[snapID]["held"] == true {
+				return nil, errBusy("Snapshot %s is held", snapID)
+			}
+		}
+	}
+	for _, ds := range doomed {
+		origin, _ := st.datasets[ds]["origin"].(string)
+		delete(st.datasets, ds)
+		st.removeAttachments(ds)
+		for snapID := range st.snapshots {
+			if snapshotDataset(snapID) == ds {
+				delete(st.snapshots, snapID)
+			}
+		}
+		st.collectDeferredSnapshot(origin)
+	}
+	return true, nil
+}
+
+func (st *state) datasetRename(params []json.RawMessage) (interface{}, error) {
+	var id string
+	var opts struct {
+		NewName string `json:"new_name"`
+		Force   bool   `json:"force"`
+	}
+	if err := decodeParams("pool.dataset.rename", params, &id, &opts); err != nil {
+		return nil, err
+	}
+	if _, ok := st.datasets[id]; !ok {
+		return nil, errNotFound("Dataset %s does not exist", id)
+	}
+	if _, ok := st.datasets[opts.NewName]; ok {
+		return nil, errExists("Dataset %s already exists", opts.NewName)
+	}
+	parent := parentOf(opts.NewName)
+	if poolOf(opts.NewName) != poolOf(id) || parent == "" {
+		return nil, errInvalid("pool.dataset.rename.new_name: cannot rename %s to %s", id, opts.NewName)
+	}
+	if _, ok := st.datasets[parent]; !ok && parent != poolOf(id) {
+		return nil, errNotFound("Parent dataset %s does not exist", parent)
+	}
+	if st.hasAttachments(id) && !opts.Force {
+		return nil, errBusy("Dataset %s is in use by shares or namespaces", id)
+	}
+
+	moved := func(name string) (string, bool) {
+		if name == id || strings.HasPrefix(name, id+"/") || strings.HasPrefix(name, id+"@") {
+			return opts.NewName + name[len(id):], true
+		}
+		return "", false
+	}
+	for oldID, ds := range st.datasets {
+		newID, ok := moved(oldID)
+		if !ok {
+			continue
+		}
+		delete(st.datasets, oldID)
+		ds["id"], ds["name"] = newID, newID
+		if _, ok := ds["mountpoint"]; ok {
+			ds["mountpoint"] = "/mnt/" + newID
+		}
+		st.datasets[newID] = ds
+	}
+	for oldID, snap := range st.snapshots {
+		newID, ok := moved(oldID)
+		if !ok {
+			continue
+		}
+		delete(st.snapshots, oldID)
+		snap["id"], snap["name"], snap["dataset"] = newID, newID, snapshotDataset(newID)
+		st.snapshots[newID] = snap
+	}
+	for _, ds := range st.datasets {
+		if origin, ok := ds["origin"].(string); ok {
+			if newOrigin, ok := moved(origin); ok {
+				ds["origin"] = newOrigin
+			}
+		}
+	}
+	return nil, nil
+}
+
+// hasAttachments reports whether shares or namespaces use a dataset or its children.
+func (st *state) hasAttachments(id string) bool {
+	under := func(path, prefix string) bool { return path == prefix || strings.HasPrefix(path, prefix+"/") }
+	for _, share := range st.nfsShares {
+		if path, _ := share["path"].(string); under(path, "/mnt/"+id) {
+			return true
+		}
+	}
+	for _, share := range st.smbShares {
+		if path, _ := share["path"].(string); under(path, "/mnt/"+id) {
+			return true
+		}
+	}
+	for _, ns := range st.namespaces {
+		if path, _ := ns["device_path"].(string); under(path, "zvol/"+id) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeAttachments deletes the shares and namespaces of a deleted dataset, like the
+// TrueNAS attachment delegates do.
+func (st *state) removeAttachments(id string) {
+	for shareID, share := range st.nfsShares {
+		if share["path"] == "/mnt/"+id {
+			delete(st.nfsShares, shareID)
+		}
+	}
+	for shareID, share := range st.smbShares {
+		if share["path"] == "/mnt/"+id {
+			delete(st.smbShares, shareID)
+		}
+	}
+	for nsID, ns := range st.namespaces {
+		if ns["device_path"] == "zvol/"+id {
+			delete(st.namespaces, nsID)
+		}
+	}
+}
+
+// containsAll reports whether ids contains every candidate.
+func containsAll(ids, candidates []string) bool {
+	for _, c := range candidates {
+		found := false
+		for _, id := range ids {
+			if c == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (st *state) datasetQuery(params []json.RawMessage) (interface{}, error) {
+	filters, opts, err := parseQuery("pool.dataset.query", params)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]record, 0, len(st.datasets))
+	for _, ds := range st.datasets {
+		records = append(records, st.datasetView(ds, opts.extra("user_properties")))
+	}
+	return query("pool.dataset.query", records, filters, opts)
+}
+
+func (st *state) datasetPromote(params []json.RawMessage) (interface{}, error) {
+	var id string
+	if err := decodeParams("pool.dataset.promote", params, &id); err != nil {
+		return nil, err
+	}
+	ds, ok := st.datasets[id]
+	if !ok {
+		return nil, errNotFound("Dataset %s does not exist", id)
+	}
+	origin, ok := ds["origin"].(string)
+	if !ok {
+		return nil, errInvalid("Dataset %s is not a clone", id)
+	}
+	// Like zfs promote: the origin snapshot moves to the promoted clone, and the former source
+	// dataset (plus any other clones of the snapshot) become clones of the moved snapshot.
+	delete(ds, "origin")
+	snap, ok := st.snapshots[origin]
+	if !ok {
+		return nil, nil
+	}
+	delete(st.snapshots, origin)
+	newID := id + "@" + snap["snapshot_name"].(string)
+	snap["id"], snap["name"], snap["dataset"] = newID, newID, id
+	st.snapshots[newID] = snap
+	for _, other := range st.datasets {
+		if other["origin"] == origin {
+			other["origin"] = newID
+		}
+	}
+	if source, ok := st.datasets[snapshotDataset(origin)]; ok {
+		source["origin"] = newID
+	}
+	return nil, nil
+}
+
+// Snapshots
+
+func snapshotDataset(snapshotID string) string {
+	ds, _, _ := strings.Cut(snapshotID, "@")
+	return ds
+}
+
+// clonesOf returns the datasets cloned from a snapshot.
+func (st *state) clonesOf(snapshotID string) []string {
+	var clones []string
+	for id, ds := range st.datasets {
+		if ds["origin"] == snapshotID {
+			clones = append(clones, id)
+		}
+	}
+	return clones
+}
+
+func (st *state) hasClones(snapshotID string) bool {
+	return len(st.clonesOf(snapshotID)) > 0
+}
+
+// collectDeferredSnapshot removes a snapshot whose deletion was deferred once its last clone is gone.
+func (st *state) collectDeferredSnapshot(snapshotID string) {
+	snap, ok := st.snapshots[snapshotID]
+	if ok && snap["defer_destroy"] == true && snap["held"] != true && !st.hasClones(snapshotID) {
+		delete(st.snapshots, snapshotID)
+	}
+}
+
+func (st *state) snapshotCreate(params []json.RawMessage) (interface{}, error) {
+	var p struct {
+		Dataset   string `json:"dataset"`
+		Name      string `json:"name"`
+		Recursive bool   `json:"recursive"`
+	}
+	if err := decodeParams("pool.snapshot.create", params, &p); err != nil {
+		return nil, err
+	}
+	if _, ok := st.datasets[p.Dataset]; !ok {
+		return nil, errNotFound("Dataset %s does not exist", p.Dataset)
+	}
+	// A recursive snapshot covers the dataset and all its descendants in one transaction group
+	datasets := []string{p.Dataset}
+	if p.Recursive {
+		for id := range st.datasets {
+			if strings.HasPrefix(id, p.Dataset+"/") {
+				datasets = append(datasets, id)
+			}
+		}
+	}
+	for _, dataset := range datasets {
+		if id := dataset + "@" + p.Name; st.snapshots[id] != nil {
+			return nil, errExists("Snapshot %s already exists", id)
+		}
+	}
+	st.txg++
+	created := time.Now()
+	for _, dataset := range datasets {
+		st.snapshots[dataset+"@"+p.Name] = st.newSnapshot(dataset, p.Name, created)
+	}
+	return st.snapshots[p.Dataset+"@"+p.Name], nil
+}
+
+// newSnapshot returns the record of a snapshot of dataset taken in the current transaction group.
+func (st *state) newSnapshot(dataset, name string, created time.Time) record {
+	id := dataset + "@" + name
+	snap := record{
+		"id":            id,
+		"name":          id,
+		"snapshot_name": name,
+		"dataset":       dataset,
+		"pool":          poolOf(dataset),
+		"type":          "SNAPSHOT",
+		"createtxg":     strconv.Itoa(st.txg),
+		"properties": map[string]interface{}{
+			"creation": map[string]interface{}{
+				"value":    created.Format("Mon Jan _2 15:04 2006"),
+				"rawvalue": strconv.FormatInt(created.Unix(), 10),
+				"parsed":   map[string]interface{}{"$date": created.UnixMilli()},
+				"source":   "NONE",
+			},
+			"guid": parsedValue(int64(1_000_000_000 + st.newID())),
+		},
+	}
+	if volsize, ok := st.datasets[dataset]["volsize"].(float64); ok {
+		snap["properties"].(map[string]interface{})["volsize"] = parsedValue(int64(volsize))
+	}
+	return snap
+}
+
+func (st *state) snapshotDelete(params []json.RawMessage) (interface{}, error) {
+	var id string
+	var opts struct {
+		Defer bool `json:"defer"`
+	}
+	if err := decodeParams("pool.snapshot.delete", params, &id, &opts); err != nil {
+		return nil, err
+	}
+	snap, ok := st.snapshots[id]
+	if !ok {
+		return nil, errNotFound("Snapshot %s does not exist", id)
+	}
+	if st.hasClones(id) || snap["held"] == true {
+		if !opts.Defer {
+			if snap["held"] == true {
+				return nil, errBusy("Snapshot %s is held", id)
+			}
+			return nil, errBusy("Snapshot %s has dependent clones: %s", id, strings.Join(st.clonesOf(id), ", "))
+		}
+		snap["defer_destroy"] = true
+		return true, nil
+	}
+	delete(st.snapshots, id)
+	return true, nil
+}
+
+// snapshotHold places the "truenas" user hold on a snapshot.
+func (st *state) snapshotHold(params []json.RawMessage) (interface{}, error) {
+	var id string
+	if err := decodeParams("pool.snapshot.hold", params, &id); err != nil {
+		return nil, err
+	}
+	snap, ok := st.snapshots[id]
+	if !ok {
+		return nil, errNotFound("Snapshot %s does not exist", id)
+	}
+	if snap["held"] == true {
+		return nil, errExists("Tag truenas already exists on %s", id)
+	}
+	snap["held"] = true
+	return nil, nil
+}
+
+func (st *state) snapshotRelease(params []json.RawMessage) (interface{}, error) {
+	var id string
+	if err := decodeParams("pool.snapshot.release", params, &id); err != nil {
+		return nil, err
+	}
+	snap, ok := st.snapshots[id]
+	if !ok {
+		return nil, errNotFound("Snapshot %s does not exist", id)
+	}
+	if snap["held"] != true {
+		return nil, errNotFound("No such tag truenas on %s", id)
+	}
+	delete(snap, "held")
+	st.collectDeferredSnapshot(id)
+	return nil, nil
+}
+
+func (st *state) snapshotQuery(params []json.RawMessage) (interface{}, error) {
+	filters, opts, err := parseQuery("pool.snapshot.query", params)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]record, 0, len(st.snapshots))
+	for _, snap := range st.snapshots {
+		if snap["defer_destroy"] == true {
+			continue // zfs hides snapshots pending deferred destruction from listings
+		}
+		records = append(records, st.snapshotView(snap))
+	}
+	return query("pool.snapshot.query", records, filters, opts)
+}
+
+// snapshotView adds the read-only clones property, listing the datasets cloned from the snapshot.
+func (st *state) snapshotView(snap record) record {
+	clones := st.clonesOf(snap["id"].(string))
+	if len(clones) == 0 {
+		return snap
+	}
+	sort.Strings(clones)
+	props := make(map[string]interface{}, len(snap["properties"].(map[string]interface{}))+1)
+	for k, v := range snap["properties"].(map[string]interface{}) {
+		props[k] = v
+	}
+	props["clones"] = parsedValue(strings.Join(clones, ","))
+	view := make(record, len(snap))
+	for k, v := range snap {
+		view[k] = v
+	}
+	view["properties"] = props
+	return view
+}
+
+func (st *state) snapshotUpdate(params []json.RawMessage) (interface{}, error) {
+	var id string
+	var p struct {
+		UserPropertiesUpdate []userPropertyUpdate `json:"user_properties_update"`
+		UserPropertiesRemove []string             `json:"user_properties_remove"`
+	}
+	if err := decodeParams("pool.snapshot.update", params, &id, &p); err != nil {
+		return nil, err
+	}
+	snap, ok := st.snapshots[id]
+	if !ok {
+		return nil, errNotFound("Snapshot %s does not exist", id)
+	}
+	applyUserProperties(snap["properties"].(map[string]interface{}), p.UserPropertiesUpdate, p.UserPropertiesRemove)
+	return snap, nil
+}
+
+func (st *state) snapshotClone(params []json.RawMessage) (interface{}, error) {
+	var p struct {
+		Snapshot   string `json:"snapshot"`
+		DatasetDst string `json:"dataset_dst"`
+	}
+	if err := decodeParams("pool.snapshot.clone", params, &p); err != nil {
+		return nil, err
+	}
+	if _, ok := st.snapshots[p.Snapshot]; !ok {
+		return nil, errNotFound("Snapshot %s does not exist", p.Snapshot)
+	}
+	if _, ok := st.datasets[p.DatasetDst]; ok {
+		return nil, errExists("Path %s already exists", p.DatasetDst)
+	}
+	if poolOf(p.DatasetDst) != poolOf(p.Snapshot) {
+		return nil, errInvalid("Clone %s must be in the pool of %s", p.DatasetDst, p.Snapshot)
+	}
+	if parent := parentOf(p.DatasetDst); parent != poolOf(p.DatasetDst) {
+		if _, ok := st.datasets[parent]; !ok {
+			return nil, errNotFound("Parent dataset %s does not exist", parent)
+		}
+	}
+
+	source := st.datasets[snapshotDataset(p.Snapshot)]
+	st.txg++
+	clone := record{
+		"id":              p.DatasetDst,
+		"name":            p.DatasetDst,
+		"pool":            poolOf(p.DatasetDst),
+		"type":            source["type"],
+		"origin":          p.Snapshot,
+		"createtxg":       strconv.Itoa(st.txg),
+		"user_properties": map[string]interface{}{},
+	}
+	if source["type"] == "FILESYSTEM" {
+		clone["mountpoint"] = "/mnt/" + p.DatasetDst
+	} else {
+		clone["volsize"] = source["volsize"]
+		clone["sparse"] = true // clones share blocks with their origin
+	}
+	st.datasets[p.DatasetDst] = clone
+	return true, nil
+}
+
+// Filesystem and jobs
+
+// pathDataset returns the dataset mounted at or above a /mnt path.
+func (st *state) pathDataset(path string) (string, bool) {
+	id := strings.TrimPrefix(strings.TrimSuffix(path, "/"), "/mnt/")
+	for id != "" {
+		if ds, ok := st.datasets[id]; ok && ds["type"] == "FILESYSTEM" {
+			return id, true
+		}
+		if _, ok := st.pools[id]; ok {
+			return id, true
+		}
+		id = parentOf(id)
+	}
+	return "", false
+}
+
+func (st *state) filesystemStat(params []json.RawMessage) (interface{}, error) {
+	var path string
+	if err := decodeParams("filesystem.stat", params, &path); err != nil {
+		return nil, err
+	}
+	id := strings.TrimPrefix(strings.TrimSuffix(path, "/"), "/mnt/")
+	ds, isDataset := st.datasets[id]
+	_, isPool := st.pools[id]
+	if !(isDataset && ds["type"] == "FILESYSTEM") && !isPool && !st.isDirectory(path) {
+		return nil, errNotFound("Path %s not found", path)
+	}
+	return record{"realpath": path, "type": "DIRECTORY", "mode": float64(0o40777)}, nil
+}
+
+// isDirectory reports whether path was created with filesystem.mkdir.
+func (st *state) isDirectory(path string) bool {
+	id, ok := st.pathDataset(path)
+	if !ok {
+		return false
+	}
+	dirs, _ := st.datasetDirs(id)
+	_, ok = dirs[strings.TrimSuffix(path, "/")]
+	return ok
+}
+
+// datasetDirs returns the directories created inside a dataset (pools have none).
+func (st *state) datasetDirs(id string) (map[string]interface{}, bool) {
+	ds, ok := st.datasets[id]
+	if !ok {
+		return nil, false
+	}
+	dirs, ok := ds["_directories"].(map[string]interface{})
+	if !ok {
+		dirs = map[string]interface{}{}
+		ds["_directories"] = dirs
+	}
+	return dirs, true
+}
+
+func (st *state) filesystemMkdir(params []json.RawMessage) (interface{}, error) {
+	var path string
+	if err := decodeParams("filesystem.mkdir", params, &path); err != nil {
+		return nil, err
+	}
+	path = strings.TrimSuffix(path, "/")
+	parent := path[:strings.LastIndex(path, "/")]
+	if _, err := st.filesystemStat([]json.RawMessage{mustJSON(parent)}); err != nil {
+		return nil, errNotFound("Parent directory %s does not exist", parent)
+	}
+	if _, err := st.filesystemStat([]json.RawMessage{mustJSON(path)}); err == nil {
+		return nil, errExists("Path %s already exists", path)
+	}
+	id, _ := st.pathDataset(parent)
+	dirs, ok := st.datasetDirs(id)
+	if !ok {
+		return nil, errInvalid("Cannot create directories directly in pool %s", id)
+	}
+	dirs[path] = true
+	return record{"realpath": path, "type": "DIRECTORY"}, nil
+}
+
+func (st *state) filesystemSetACL(params []json.RawMessage) (interface{}, error) {
+	var p struct {
+		Path string        `json:"path"`
+		DACL []interface{} `json:"dacl"`
+	}
+	if err := decodeParams("filesystem.setacl", params, &p); err != nil {
+		return nil, err
+	}
+	if _, err := st.filesystemStat([]json.RawMessage{mustJSON(p.Path)}); err != nil {
+		return nil, err
+	}
+	if id, ok := st.pathDataset(p.Path); ok {
+		if ds, ok := st.datasets[id]; ok {
+			ds["_acl"] = p.DACL
+		}
+	}
+	// setacl is a job in TrueNAS; the emulation completes it immediately
+	id := st.addJob("filesystem.setacl", []interface{}{p.Path})
+	st.updateJob(id, tnsapi.JobStateSuccess, 100, "")
+	return id, nil
+}
+
+// addJob adds a running job and returns its ID.
+func (st *state) addJob(method string, arguments []interface{}) int {
+	if arguments == nil {
+		arguments = []interface{}{}
+	}
+	id := st.newID()
+	st.jobs[id] = record{
+		"id":           float64(id),
+		"method":       method,
+		"arguments":    arguments,
+		"state":        tnsapi.JobStateRunning,
+		"abortable":    true,
+		"progress":     record{"percent": float64(0), "description": ""},
+		"error":        nil,
+		"time_started": record{"$date": float64(time.Now().UnixMilli())},
+	}
+	st.publish("core.get_jobs", id, st.jobs[id])
+	return id
+}
+
+// updateJob sets the state and progress of a job. The description of a FAILED job is its error.
+func (st *state) updateJob(id int, state string, percent float64, description string) bool {
+	job, ok := st.jobs[id]
+	if !ok {
+		return false
+	}
+	job["state"] = state
+	job["progress"] = record{"percent": percent, "description": description}
+	if state == tnsapi.JobStateFailed {
+		job["error"] = description
+	}
+	if state != tnsapi.JobStateWaiting && state != tnsapi.JobStateRunning {
+		job["time_finished"] = record{"$date": float64(time.Now().UnixMilli())}
+	}
+	st.publish("core.get_jobs", id, job)
+	return true
+}
+
+func (st *state) jobAbort(params []json.RawMessage) (interface{}, error) {
+	var id int
+	if err := decodeParams("core.job_abort", params, &id); err != nil {
+		return nil, err
+	}
+	job, ok := st.jobs[id]
+	if !ok {
+		return nil, errNotFound("Job %d does not exist", id)
+	}
+	if job["state"] != tnsapi.JobStateWaiting && job["state"] != tnsapi.JobStateRunning {
+		return nil, errInvalid("Job %d is not running", id)
+	}
+	progress, _ := job["progress"].(record)
+	percent, _ := progress["percent"].(float64)
+	st.updateJob(id, tnsapi.JobStateAborted, percent, "")
+	return nil, nil
+}
+
+// bulk runs core.bulk: the calls run one after another in a job that succeeds even when calls
+// fail, with each call's result or error in the job result.
+func (st *state) bulk(handlers map[string]HandlerFunc, params []json.RawMessage) (interface{}, error) {
+	var method string
+	var calls [][]json.RawMessage
+	if err := decodeParams("core.bulk", params, &method, &calls); err != nil {
+		return nil, err
+	}
+	handler, ok := handlers[method]
+	if !ok {
+		return nil, errInvalid("core.bulk: method %s is not emulated", method)
+	}
+	results := make([]interface{}, 0, len(calls))
+	for _, call := range calls {
+		result, err := handler(call)
+		entry := record{"job_id": nil, "result": result, "error": nil}
+		if err != nil {
+			entry["result"], entry["error"] = nil, err.Error()
+		}
+		results = append(results, entry)
+	}
+	id := st.addJob("core.bulk", []interface{}{method, calls})
+	st.jobs[id]["result"] = results
+	st.updateJob(id, tnsapi.JobStateSuccess, 100, "")
+	return id, nil
+}
+
+func (st *state) jobQuery(params []json.RawMessage) (interface{}, error) {
+	filters, opts, err := parseQuery("core.get_jobs", params)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]record, 0, len(st.jobs))
+	for _, job := range st.jobs {
+		records = append(records, job)
+	}
+	return query("core.get_jobs", records, filters, opts)
+}
+
+// Events
+
+// event is a pending collection_update notification.
+type event struct {
+	collection string
+	fields     json.RawMessage
+	id         int
+}
+
+func (st *state) subscribe(params []json.RawMessage) (interface{}, error) {
+	var collection string
+	if err := decodeParams("core.subscribe", params, &collection); err != nil {
+		return nil, err
+	}
+	if collection != "core.get_jobs" {
+		return nil, errInvalid("Collection %s is not supported", collection)
+	}
+	st.subscriptions[collection] = true
+	return "sub-" + strconv.Itoa(st.newID()), nil
+}
+
+// publish queues a collection_update event if a client subscribed to the collection.
+// Fields are marshaled right away because the record may change before the event is sent.
+func (st *state) publish(collection string, id int, fields record) {
+	if !st.subscriptions[collection] {
+		return
+	}
+	st.events = append(st.events, event{collection: collection, id: id, fields: mustJSON(fields)})
+}
+
+// takeEvents returns and clears the pending events.
+func (st *state) takeEvents() []event {
+	events := st.events
+	st.events = nil
+	return events
+}
+
+func mustJSON(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
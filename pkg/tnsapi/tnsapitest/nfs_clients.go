@@ -0,0 +1,89 @@
+package tnsapitest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NFSv4 clients.
+//
+// nfs.get_nfs4_clients lists the clients added with AddNFS4Client. filesystem.file_receive is
+// only emulated for writes of "expire" to /proc/fs/nfsd/clients/<id>/ctl, which drop the client
+// as nfsd would. SetNFSDClientDirs(false) emulates kernels without those directories.
+
+const nfsdClientsPath = "/proc/fs/nfsd/clients/"
+
+// AddNFS4Client adds an NFSv4 client connected from address (host:port) with the given client
+// name and returns its nfsd client ID.
+func (s *Server) AddNFS4Client(address, name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := strconv.Itoa(s.state.newID())
+	s.state.nfs4Clients[id] = record{
+		"id": id,
+		"info": map[string]interface{}{
+			"clientid":      "0x" + strconv.FormatInt(int64(len(s.state.nfs4Clients)+1), 16),
+			"address":       `"` + address + `"`,
+			"status":        "confirmed",
+			"name":          `"` + name + `"`,
+			"minor version": 2,
+		},
+		"states": []interface{}{},
+	}
+	return id
+}
+
+// SetNFSDClientDirs sets whether /proc/fs/nfsd/clients exists (true by default); without it,
+// expiring any client fails with ENOENT.
+func (s *Server) SetNFSDClientDirs(exist bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.noNFSDClientDirs = !exist
+}
+
+// NFS4Clients returns the IDs of the NFSv4 clients the server knows, sorted.
+func (s *Server) NFS4Clients() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.state.nfs4Clients))
+	for id := range s.state.nfs4Clients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (st *state) nfs4ClientQuery(_ []json.RawMessage) (interface{}, error) {
+	clients := make([]record, 0, len(st.nfs4Clients))
+	for _, client := range st.nfs4Clients {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i]["id"].(string) < clients[j]["id"].(string) })
+	return clients, nil
+}
+
+func (st *state) filesystemFileReceive(params []json.RawMessage) (interface{}, error) {
+	var path, content string
+	if err := decodeParams("filesystem.file_receive", params, &path, &content); err != nil {
+		return nil, err
+	}
+	id, ok := strings.CutSuffix(strings.TrimPrefix(path, nfsdClientsPath), "/ctl")
+	if !ok || !strings.HasPrefix(path, nfsdClientsPath) {
+		return nil, errInvalid("filesystem.file_receive: writing %s is not emulated", path)
+	}
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return nil, errInvalid("filesystem.file_receive: content is not base64: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != "expire" {
+		return nil, errInvalid("filesystem.file_receive: invalid nfsd client operation %q", data)
+	}
+	if _, exists := st.nfs4Clients[id]; !exists || st.noNFSDClientDirs {
+		return nil, errNotFound("%s: No such file or directory", path)
+	}
+	delete(st.nfs4Clients, id)
+	return true, nil
+}
@@ -0,0 +1,321 @@
+package tnsapitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// NVMe-oF targets (nvmet.*)
+
+// subsystemNQNPrefix is the UUID-qualified prefix TrueNAS puts in front of subsystem names.
+const subsystemNQNPrefix = "nqn.2011-06.com.truenas:uuid:00000000-0000-0000-0000-000000000000:"
+
+func (st *state) addNVMeOFPort(id int, transport, address string, port int) {
+	st.ports[id] = record{
+		"id":           float64(id),
+		"index":        float64(id),
+		"addr_trtype":  transport,
+		"addr_traddr":  address,
+		"addr_trsvcid": float64(port),
+		"addr_adrfam":  "IPV4",
+		"enabled":      true,
+	}
+	if id > st.nextID {
+		st.nextID = id
+	}
+}
+
+func (st *state) subsysCreate(params []json.RawMessage) (interface{}, error) {
+	var p struct {
+		Name         string `json:"name"`
+		Subnqn       string `json:"subnqn"`
+		Serial       string `json:"serial"`
+		AllowAnyHost bool   `json:"allow_any_host"`
+	}
+	if err := decodeParams("nvmet.subsys.create", params, &p); err != nil {
+		return nil, err
+	}
+	if p.Name == "" {
+		return nil, errInvalid("nvmet.subsys.create.name: attribute required")
+	}
+	for _, existing := range st.subsystems {
+		if existing["name"] == p.Name {
+			return nil, errExists("nvmet.subsys.create.name: subsystem %s already exists", p.Name)
+		}
+	}
+	subnqn := p.Subnqn
+	if subnqn == "" {
+		subnqn = subsystemNQNPrefix + p.Name
+	}
+	id := st.newID()
+	serial := p.Serial
+	if serial == "" {
+		serial = fmt.Sprintf("%020d", id)
+	}
+	subsys := record{
+		"id":             float64(id),
+		"name":           p.Name,
+		"subnqn":         subnqn,
+		"serial":         serial,
+		"allow_any_host": p.AllowAnyHost,
+		"enabled":        true,
+	}
+	st.subsystems[id] = subsys
+	return subsys, nil
+}
+
+func (st *state) subsysDelete(params []json.RawMessage) (interface{}, error) {
+	var id int
+	if err := decodeParams("nvmet.subsys.delete", params, &id); err != nil {
+		return nil, err
+	}
+	if _, ok := st.subsystems[id]; !ok {
+		return nil, errNotFound("NVMe-oF subsystem %d does not exist", id)
+	}
+	for _, ns := range st.namespaces {
+		if ns["subsys_id"] == float64(id) {
+			return nil, errBusy("NVMe-oF subsystem %d still has namespaces", id)
+		}
+	}
+	for bindingID, binding := range st.portSubsys {
+		if binding["subsys_id"] == float64(id) {
+			delete(st.portSubsys, bindingID)
+		}
+	}
+	for linkID, link := range st.hostSubsys {
+		if link["subsys_id"] == float64(id) {
+			delete(st.hostSubsys, linkID)
+		}
+	}
+	delete(st.subsystems, id)
+	return true, nil
+}
+
+func (st *state) subsysQuery(params []json.RawMessage) (interface{}, error) {
+	filters, opts, err := parseQuery("nvmet.subsys.query", params)
+	if err != nil {
+		return nil, err
+	}
+	return query("nvmet.subsys.query", values(st.subsystems), filters, opts)
+}
+
+// subsysRef is the nested subsystem object of namespace and port binding records.
+func subsysRef(subsys record) map[string]interface{} {
+	return map[string]interface{}{"id": subsys["id"], "name": subsys["name"], "subnqn": subsys["subnqn"]}
+}
+
+func (st *state) namespaceCreate(params []json.RawMessage) (interface{}, error) {
+	var p struct {
+		DevicePath string `json:"device_path"`
+		DeviceType string `json:"device_type"`
+		SubsysID   int    `json:"subsys_id"`
+		NSID       int    `json:"nsid"`
+	}
+	if err := decodeParams("nvmet.namespace.create", params, &p); err != nil {
+		return nil, err
+	}
+	subsys, ok := st.subsystems[p.SubsysID]
+	if !ok {
+		return nil, errNotFound("nvmet.namespace.create.subsys_id: subsystem %d does not exist", p.SubsysID)
+	}
+	if p.DeviceType == "ZVOL" {
+		zvol := strings.TrimPrefix(p.DevicePath, "zvol/")
+		if ds, ok := st.datasets[zvol]; !ok || ds["type"] != "VOLUME" {
+			return nil, errNotFound("nvmet.namespace.create.device_path: zvol %s does not exist", zvol)
+		}
+	}
+
+	used := make(map[int]bool)
+	for _, ns := range st.namespaces {
+		if ns["subsys_id"] == float64(p.SubsysID) {
+			used[int(ns["nsid"].(float64))] = true
+			if ns["device_path"] == p.DevicePath {
+				return nil, errExists("nvmet.namespace.create.device_path: %s is already a namespace of subsystem %d", p.DevicePath, p.SubsysID)
+			}
+		}
+	}
+	nsid := p.NSID
+	if nsid == 0 {
+		for nsid = 1; used[nsid]; nsid++ {
+		}
+	} else if used[nsid] {
+		return nil, errExists("nvmet.namespace.create.nsid: NSID %d is in use in subsystem %d", nsid, p.SubsysID)
+	}
+
+	id := st.newID()
+	ns := record{
+		"id":          float64(id),
+		"nsid":        float64(nsid),
+		"device_path": p.DevicePath,
+		"device_type": p.DeviceType,
+		"subsys_id":   float64(p.SubsysID),
+		"subsys":      subsysRef(subsys),
+		"enabled":     true,
+	}
+	st.namespaces[id] = ns
+	return ns, nil
+}
+
+func (st *state) namespaceDelete(params []json.RawMessage) (interface{}, error) {
+	var id int
+	if err := decodeParams("nvmet.namespace.delete", params, &id); err != nil {
+		return nil, err
+	}
+	if _, ok := st.namespaces[id]; !ok {
+		return nil, errNotFound("NVMe-oF namespace %d does not exist", id)
+	}
+	delete(st.namespaces, id)
+	return true, nil
+}
+
+func (st *state) namespaceQuery(params []json.RawMessage) (interface{}, error) {
+	filters, opts, err := parseQuery("nvmet.namespace.query", params)
+	if err != nil {
+		return nil, err
+	}
+	return query("nvmet.namespace.query", values(st.namespaces), filters, opts)
+}
+
+func (st *state) portQuery(params []json.RawMessage) (interface{}, error) {
+	filters, opts, err := parseQuery("nvmet.port.query", params)
+	if err != nil {
+		return nil, err
+	}
+	return query("nvmet.port.query", values(st.ports), filters, opts)
+}
+
+func (st *state) portSubsysCreate(params []json.RawMessage) (interface{}, error) {
+	var p struct {
+		PortID   int `json:"port_id"`
+		SubsysID int `json:"subsys_id"`
+	}
+	if err := decodeParams("nvmet.port_subsys.create", params, &p); err != nil {
+		return nil, err
+	}
+	port, ok := st.ports[p.PortID]
+	if !ok {
+		return nil, errNotFound("nvmet.port_subsys.create.port_id: port %d does not exist", p.PortID)
+	}
+	subsys, ok := st.subsystems[p.SubsysID]
+	if !ok {
+		return nil, errNotFound("nvmet.port_subsys.create.subsys_id: subsystem %d does not exist", p.SubsysID)
+	}
+	for _, binding := range st.portSubsys {
+		if binding["port_id"] == float64(p.PortID) && binding["subsys_id"] == float64(p.SubsysID) {
+			return nil, errExists("nvmet.port_subsys.create: subsystem %d is already bound to port %d", p.SubsysID, p.PortID)
+		}
+	}
+	id := st.newID()
+	binding := record{
+		"id":        float64(id),
+		"port_id":   float64(p.PortID),
+		"subsys_id": float64(p.SubsysID),
+		"port":      map[string]interface{}{"id": port["id"], "addr_trtype": port["addr_trtype"], "addr_traddr": port["addr_traddr"]},
+		"subsys":    subsysRef(subsys),
+	}
+	st.portSubsys[id] = binding
+	return binding, nil
+}
+
+func (st *state) portSubsysDelete(params []json.RawMessage) (interface{}, error) {
+	var id int
+	if err := decodeParams("nvmet.port_subsys.delete", params, &id); err != nil {
+		return nil, err
+	}
+	if _, ok := st.portSubsys[id]; !ok {
+		return nil, errNotFound("NVMe-oF port binding %d does not exist", id)
+	}
+	delete(st.portSubsys, id)
+	return true, nil
+}
+
+func (st *state) portSubsysQuery(params []json.RawMessage) (interface{}, error) {
+	filters, opts, err := parseQuery("nvmet.port_subsys.query", params)
+	if err != nil {
+		return nil, err
+	}
+	return query("nvmet.port_subsys.query", values(st.portSubsys), filters, opts)
+}
+
+func (st *state) hostCreate(params []json.RawMessage) (interface{}, error) {
+	var p struct {
+		HostNQN string `json:"hostnqn"`
+	}
+	if err := decodeParams("nvmet.host.create", params, &p); err != nil {
+		return nil, err
+	}
+	if p.HostNQN == "" {
+		return nil, errInvalid("nvmet.host.create.hostnqn: attribute required")
+	}
+	for _, existing := range st.hosts {
+		if existing["hostnqn"] == p.HostNQN {
+			return nil, errExists("nvmet.host.create.hostnqn: host %s already exists", p.HostNQN)
+		}
+	}
+	id := st.newID()
+	host := record{"id": float64(id), "hostnqn": p.HostNQN}
+	st.hosts[id] = host
+	return host, nil
+}
+
+func (st *state) hostQuery(params []json.RawMessage) (interface{}, error) {
+	filters, opts, err := parseQuery("nvmet.host.query", params)
+	if err != nil {
+		return nil, err
+	}
+	return query("nvmet.host.query", values(st.hosts), filters, opts)
+}
+
+func (st *state) hostSubsysCreate(params []json.RawMessage) (interface{}, error) {
+	var p struct {
+		HostID   int `json:"host_id"`
+		SubsysID int `json:"subsys_id"`
+	}
+	if err := decodeParams("nvmet.host_subsys.create", params, &p); err != nil {
+		return nil, err
+	}
+	host, ok := st.hosts[p.HostID]
+	if !ok {
+		return nil, errNotFound("nvmet.host_subsys.create.host_id: host %d does not exist", p.HostID)
+	}
+	subsys, ok := st.subsystems[p.SubsysID]
+	if !ok {
+		return nil, errNotFound("nvmet.host_subsys.create.subsys_id: subsystem %d does not exist", p.SubsysID)
+	}
+	for _, link := range st.hostSubsys {
+		if link["host_id"] == float64(p.HostID) && link["subsys_id"] == float64(p.SubsysID) {
+			return nil, errExists("nvmet.host_subsys.create: host %d is already allowed on subsystem %d", p.HostID, p.SubsysID)
+		}
+	}
+	id := st.newID()
+	link := record{
+		"id":        float64(id),
+		"host_id":   float64(p.HostID),
+		"subsys_id": float64(p.SubsysID),
+		"host":      map[string]interface{}{"id": host["id"], "hostnqn": host["hostnqn"]},
+		"subsys":    subsysRef(subsys),
+	}
+	st.hostSubsys[id] = link
+	return link, nil
+}
+
+func (st *state) hostSubsysDelete(params []json.RawMessage) (interface{}, error) {
+	var id int
+	if err := decodeParams("nvmet.host_subsys.delete", params, &id); err != nil {
+		return nil, err
+	}
+	if _, ok := st.hostSubsys[id]; !ok {
+		return nil, errNotFound("NVMe-oF host link %d does not exist", id)
+	}
+	delete(st.hostSubsys, id)
+	return true, nil
+}
+
+func (st *state) hostSubsysQuery(params []json.RawMessage) (interface{}, error) {
+	filters, opts, err := parseQuery("nvmet.host_subsys.query", params)
+	if err != nil {
+		return nil, err
+	}
+	return query("nvmet.host_subsys.query", values(st.hostSubsys), filters, opts)
+}
@@ -0,0 +1,221 @@
+package tnsapitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// record is a stored API object in its JSON form (strings, float64 numbers, bools, maps, slices),
+// so filters compare stored values the same way TrueNAS compares decoded JSON.
+type record map[string]interface{}
+
+// toRecord converts an API struct or map to a record.
+func toRecord(v interface{}) record {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("tnsapitest: cannot marshal %T: %v", v, err))
+	}
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		panic(fmt.Sprintf("tnsapitest: %T is not a JSON object: %v", v, err))
+	}
+	return rec
+}
+
+// lookup returns the value of a field; dotted names ("subsys.id") descend into objects.
+func (r record) lookup(field string) (interface{}, bool) {
+	var value interface{} = map[string]interface{}(r)
+	for _, part := range strings.Split(field, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// queryOptions are the TrueNAS query options the server honors. Extra options are
+// method specific (e.g. user_properties for pool.dataset.query).
+type queryOptions struct {
+	Extra   map[string]interface{} `json:"extra"`
+	OrderBy []string               `json:"order_by"`
+	Select  []string               `json:"select"`
+	Offset  int                    `json:"offset"`
+	Limit   int                    `json:"limit"`
+}
+
+// extra reports whether a boolean extra option is set.
+func (o queryOptions) extra(name string) bool {
+	v, _ := o.Extra[name].(bool)
+	return v
+}
+
+// parseQuery decodes the [filters, options] parameters of a query method.
+func parseQuery(method string, params []json.RawMessage) ([][]interface{}, queryOptions, error) {
+	var filters [][]interface{}
+	var opts queryOptions
+	if err := decodeParams(method, params, &filters, &opts); err != nil {
+		return nil, opts, err
+	}
+	for _, filter := range filters {
+		if len(filter) != 3 {
+			return nil, opts, errInvalid("%s: filters must be [field, operator, value] triples, got %v", method, filter)
+		}
+		if _, ok := filter[0].(string); !ok {
+			return nil, opts, errInvalid("%s: filter field must be a string, got %v", method, filter[0])
+		}
+	}
+	return filters, opts, nil
+}
+
+// query filters, orders and pages records like the TrueNAS datastore does. Records are
+// ordered by "id" unless order_by says otherwise.
+func query(method string, records []record, filters [][]interface{}, opts queryOptions) ([]record, error) {
+	var result []record
+	for _, rec := range records {
+		match := true
+		for _, filter := range filters {
+			ok, err := matchFilter(rec, filter[0].(string), fmt.Sprint(filter[1]), filter[2])
+			if err != nil {
+				return nil, errInvalid("%s: %v", method, err)
+			}
+			if !ok {
+				match = false
+				break
+			}
+		}
+		if match {
+			result = append(result, rec)
+		}
+	}
+
+	orderBy := opts.OrderBy
+	if len(orderBy) == 0 {
+		orderBy = []string{"id"}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		for _, field := range orderBy {
+			desc := strings.HasPrefix(field, "-")
+			field = strings.TrimPrefix(field, "-")
+			a, _ := result[i].lookup(field)
+			b, _ := result[j].lookup(field)
+			if c := compare(a, b); c != 0 {
+				return (c < 0) != desc
+			}
+		}
+		return false
+	})
+
+	if opts.Offset > 0 {
+		if opts.Offset >= len(result) {
+			result = nil
+		} else {
+			result = result[opts.Offset:]
+		}
+	}
+	if opts.Limit > 0 && opts.Limit < len(result) {
+		result = result[:opts.Limit]
+	}
+
+	if len(opts.Select) > 0 {
+		for i, rec := range result {
+			selected := make(record, len(opts.Select))
+			for _, field := range opts.Select {
+				if v, ok := rec[field]; ok {
+					selected[field] = v
+				}
+			}
+			result[i] = selected
+		}
+	}
+
+	if result == nil {
+		result = []record{}
+	}
+	return result, nil
+}
+
+// matchFilter evaluates one [field, operator, value] filter.
+func matchFilter(rec record, field, op string, want interface{}) (bool, error) {
+	got, ok := rec.lookup(field)
+	if !ok {
+		return op == "!=" || op == "nin", nil
+	}
+	switch op {
+	case "=":
+		return equal(got, want), nil
+	case "!=":
+		return !equal(got, want), nil
+	case ">":
+		return compare(got, want) > 0, nil
+	case ">=":
+		return compare(got, want) >= 0, nil
+	case "<":
+		return compare(got, want) < 0, nil
+	case "<=":
+		return compare(got, want) <= 0, nil
+	case "^":
+		return strings.HasPrefix(fmt.Sprint(got), fmt.Sprint(want)), nil
+	case "$":
+		return strings.HasSuffix(fmt.Sprint(got), fmt.Sprint(want)), nil
+	case "~":
+		re, err := regexp.Compile(fmt.Sprint(want))
+		if err != nil {
+			return false, fmt.Errorf("invalid regex %q: %w", want, err)
+		}
+		return re.MatchString(fmt.Sprint(got)), nil
+	case "in", "nin":
+		values, ok := want.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("operator %q needs a list, got %v", op, want)
+		}
+		found := false
+		for _, v := range values {
+			if equal(got, v) {
+				found = true
+				break
+			}
+		}
+		return found == (op == "in"), nil
+	default:
+		return false, fmt.Errorf("unsupported filter operator %q", op)
+	}
+}
+
+// equal compares two decoded JSON values.
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// compare orders two decoded JSON values: numbers numerically, everything else as strings.
+// Missing values sort first.
+func compare(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	fa, aNum := a.(float64)
+	fb, bNum := b.(float64)
+	if aNum && bNum {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
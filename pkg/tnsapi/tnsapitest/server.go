@@ -0,0 +1,411 @@
+// Package tnsapitest provides an in-process TrueNAS WebSocket API server for integration tests.
+//
+// Server speaks the JSON-RPC 2.0 dialect used by tnsapi.Client and keeps its state in memory:
+// pools, datasets and zvols (with user properties), snapshots and clones, NFS and SMB shares,
+// NFSv4 clients, NVMe-oF subsystems, namespaces and port bindings, jobs (with core.get_jobs events for clients
+// that subscribe), and zfs send streams through the /_download and /_upload HTTP endpoints. Query methods honor TrueNAS filters and the order_by, offset, limit
+// and select options, so controller and node code can be exercised against a real client
+// without a TrueNAS system:
+//
+//	srv := tnsapitest.NewServer()
+//	defer srv.Close()
+//	client, err := tnsapi.NewClient(srv.URL(), tnsapitest.APIKey, false)
+//
+// Methods the server does not emulate fail with a JSON-RPC "method not found" error. Tests can
+// add methods or override emulated ones (e.g. to inject failures) with Handle.
+package tnsapitest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/coder/websocket"
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+)
+
+// Server defaults.
+const (
+	// APIKey is the only API key the server accepts.
+	APIKey = "tnsapitest-api-key" //nolint:gosec // test credential
+
+	// DefaultPool is the pool every new server starts with.
+	DefaultPool = "tank"
+
+	// DefaultPoolSize is the size of DefaultPool in bytes (1 TiB).
+	DefaultPoolSize int64 = 1 << 40
+
+	// DefaultNVMeOFPortID is the ID of the NVMe/TCP port every new server starts with.
+	DefaultNVMeOFPortID = 1
+)
+
+// JSON-RPC error codes returned by the server.
+const (
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeCallError      = -32001
+)
+
+// HandlerFunc handles one JSON-RPC method. params holds the raw positional parameters.
+// The result is marshaled as the response result; a returned *tnsapi.Error is sent as is,
+// any other error as a generic call error.
+type HandlerFunc func(params []json.RawMessage) (interface{}, error)
+
+// Server is an in-process TrueNAS API server.
+//
+//nolint:govet // fieldalignment: struct layout prioritizes readability over memory optimization
+type Server struct {
+	httpServer *httptest.Server
+	builtins   map[string]HandlerFunc          // emulated methods, called with mu held
+	overrides  map[string]HandlerFunc          // methods registered with Handle, called without mu
+	conns      map[*websocket.Conn]*sync.Mutex // connection -> write lock
+	calls      []string
+	state      *state
+	mu         sync.Mutex
+}
+
+// request is an incoming JSON-RPC request.
+type request struct {
+	ID     string            `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// response is an outgoing JSON-RPC response.
+type response struct {
+	Error   *tnsapi.Error   `json:"error,omitempty"`
+	JSONRPC string          `json:"jsonrpc"`
+	ID      string          `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+}
+
+// NewServer starts a server with DefaultPool and an NVMe/TCP port (DefaultNVMeOFPortID).
+// Call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		builtins:  make(map[string]HandlerFunc),
+		overrides: make(map[string]HandlerFunc),
+		conns:     make(map[*websocket.Conn]*sync.Mutex),
+		state:     newState(),
+	}
+	s.state.addPool(DefaultPool, DefaultPoolSize)
+	s.state.addNVMeOFPort(DefaultNVMeOFPortID, "TCP", "0.0.0.0", 4420)
+	s.state.register(s.builtins)
+	s.httpServer = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// URL returns the WebSocket URL to pass to tnsapi.NewClient.
+func (s *Server) URL() string {
+	return "ws" + strings.TrimPrefix(s.httpServer.URL, "http") + "/api/current"
+}
+
+// Close drops all connections and shuts the server down.
+func (s *Server) Close() {
+	s.DropConnections()
+	s.httpServer.Close()
+}
+
+// Handle registers handler for method, replacing the emulated implementation if there is one.
+// Handlers registered here run without the server lock and may block.
+func (s *Server) Handle(method string, handler HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[method] = handler
+}
+
+// Calls returns the methods called so far, in order, including authentication.
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+// DropConnections closes all open client connections, as a TrueNAS restart would.
+// State is kept, so clients that reconnect see the same datasets and shares.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	conns := make([]*websocket.Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+	for _, conn := range conns {
+		//nolint:errcheck,gosec // closing anyway
+		conn.Close(websocket.StatusGoingAway, "server restarting")
+	}
+}
+
+// AddPool adds a pool of the given size in bytes.
+func (s *Server) AddPool(name string, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.addPool(name, size)
+}
+
+// AddJob adds a running job, as a long-running TrueNAS method would start, and returns its ID.
+func (s *Server) AddJob(method string, arguments ...interface{}) int {
+	s.mu.Lock()
+	id := s.state.addJob(method, arguments)
+	events := s.state.takeEvents()
+	s.mu.Unlock()
+	s.broadcast(events)
+	return id
+}
+
+// UpdateJob sets the state and progress of a job; the description of a FAILED job is its error.
+// Clients subscribed to core.get_jobs receive the change as a collection_update event.
+// It reports whether the job exists.
+func (s *Server) UpdateJob(id int, state string, percent float64, description string) bool {
+	s.mu.Lock()
+	ok := s.state.updateJob(id, state, percent, description)
+	events := s.state.takeEvents()
+	s.mu.Unlock()
+	s.broadcast(events)
+	return ok
+}
+
+// DatasetExists reports whether a dataset or zvol exists.
+func (s *Server) DatasetExists(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.state.datasets[id]
+	return ok
+}
+
+// Counts returns the number of datasets (including zvols), snapshots, NFS shares, SMB shares,
+// NVMe-oF subsystems and NVMe-oF namespaces, keyed by those names. Useful for leak checks.
+func (s *Server) Counts() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]int{
+		"datasets":   len(s.state.datasets),
+		"snapshots":  len(s.state.snapshots),
+		"nfs":        len(s.state.nfsShares),
+		"smb":        len(s.state.smbShares),
+		"subsystems": len(s.state.subsystems),
+		"namespaces": len(s.state.namespaces),
+	}
+}
+
+// serveWebSocket serves one client connection.
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	writeMu := &sync.Mutex{}
+	s.mu.Lock()
+	s.conns[conn] = writeMu
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		//nolint:errcheck,gosec // connection is done either way
+		conn.Close(websocket.StatusNormalClosure, "")
+	}()
+
+	ctx := r.Context()
+	var (
+		authenticated bool
+		authMu        sync.Mutex
+	)
+	for {
+		_, message, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		var req request
+		if err := json.Unmarshal(message, &req); err != nil {
+			continue
+		}
+
+		go func() {
+			var resp response
+			if req.Method == "auth.login_with_api_key" {
+				resp = s.authenticate(req)
+				authMu.Lock()
+				authenticated = authenticated || string(resp.Result) == "true"
+				authMu.Unlock()
+			} else {
+				authMu.Lock()
+				ok := authenticated
+				authMu.Unlock()
+				if ok {
+					resp = s.dispatch(req)
+				} else {
+					resp = errorResponse(req.ID, callError("EPERM", 1, "Not authenticated"))
+				}
+			}
+
+			data, err := json.Marshal(resp)
+			if err != nil {
+				return
+			}
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			//nolint:errcheck,gosec // a failed write means the client is gone
+			conn.Write(ctx, websocket.MessageText, data)
+		}()
+	}
+}
+
+// authenticate handles auth.login_with_api_key.
+func (s *Server) authenticate(req request) response {
+	s.mu.Lock()
+	s.calls = append(s.calls, req.Method)
+	s.mu.Unlock()
+
+	var key string
+	if len(req.Params) > 0 {
+		//nolint:errcheck,gosec // a malformed key is rejected below
+		json.Unmarshal(req.Params[0], &key)
+	}
+	result := json.RawMessage("false")
+	if key == APIKey {
+		result = json.RawMessage("true")
+	}
+	return response{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+// dispatch runs the handler for a request and builds the response.
+func (s *Server) dispatch(req request) response {
+	s.mu.Lock()
+	s.calls = append(s.calls, req.Method)
+	if handler, ok := s.overrides[req.Method]; ok {
+		s.mu.Unlock()
+		result, err := handler(req.Params)
+		return buildResponse(req.ID, result, err)
+	}
+
+	handler, ok := s.builtins[req.Method]
+	if !ok {
+		s.mu.Unlock()
+		return errorResponse(req.ID, &tnsapi.Error{Code: codeMethodNotFound, Message: "Method not found: " + req.Method})
+	}
+	// Results are marshaled with the lock held: they may share maps with the server state
+	result, err := handler(req.Params)
+	resp := buildResponse(req.ID, result, err)
+	events := s.state.takeEvents()
+	s.mu.Unlock()
+
+	s.broadcast(events)
+	return resp
+}
+
+// broadcast sends events to all connections as collection_update notifications.
+func (s *Server) broadcast(events []event) {
+	if len(events) == 0 {
+		return
+	}
+	messages := make([][]byte, 0, len(events))
+	for _, ev := range events {
+		data, err := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  "collection_update",
+			"params": map[string]interface{}{
+				"msg":        "changed",
+				"collection": ev.collection,
+				"id":         ev.id,
+				"fields":     ev.fields,
+			},
+		})
+		if err == nil {
+			messages = append(messages, data)
+		}
+	}
+
+	s.mu.Lock()
+	conns := make(map[*websocket.Conn]*sync.Mutex, len(s.conns))
+	for conn, writeMu := range s.conns {
+		conns[conn] = writeMu
+	}
+	s.mu.Unlock()
+	for conn, writeMu := range conns {
+		writeMu.Lock()
+		for _, data := range messages {
+			//nolint:errcheck,gosec // a failed write means the client is gone
+			conn.Write(context.Background(), websocket.MessageText, data)
+		}
+		writeMu.Unlock()
+	}
+}
+
+// buildResponse marshals a handler result or error into a response.
+func buildResponse(id string, result interface{}, err error) response {
+	if err != nil {
+		apiErr, ok := err.(*tnsapi.Error) //nolint:errorlint // handlers return *tnsapi.Error unwrapped
+		if !ok {
+			apiErr = callError("EFAULT", 14, "%v", err)
+		}
+		return errorResponse(id, apiErr)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(id, callError("EFAULT", 14, "failed to marshal result: %v", err))
+	}
+	return response{JSONRPC: "2.0", ID: id, Result: data}
+}
+
+// errorResponse builds an error response.
+func errorResponse(id string, err *tnsapi.Error) response {
+	return response{JSONRPC: "2.0", ID: id, Error: err}
+}
+
+// callError builds a TrueNAS "Method call error" with an errno name, like the middleware does.
+func callError(errname string, errno int, format string, args ...interface{}) *tnsapi.Error {
+	return &tnsapi.Error{
+		Code:    codeCallError,
+		Message: "Method call error",
+		Data: &tnsapi.ErrorData{
+			Error:     errno,
+			ErrorName: errname,
+			Reason:    fmt.Sprintf("[%s] %s", errname, fmt.Sprintf(format, args...)),
+		},
+	}
+}
+
+// Common TrueNAS errors.
+func errNotFound(format string, args ...interface{}) *tnsapi.Error {
+	return callError("ENOENT", 2, format, args...)
+}
+
+func errExists(format string, args ...interface{}) *tnsapi.Error {
+	return callError("EEXIST", 17, format, args...)
+}
+
+func errBusy(format string, args ...interface{}) *tnsapi.Error {
+	return callError("EBUSY", 16, format, args...)
+}
+
+func errNoSpace(format string, args ...interface{}) *tnsapi.Error {
+	return callError("ENOSPC", 28, format, args...)
+}
+
+func errInvalid(format string, args ...interface{}) *tnsapi.Error {
+	return callError("EINVAL", 22, format, args...)
+}
+
+// invalidParams reports a request whose parameters could not be decoded.
+func invalidParams(method string, err error) *tnsapi.Error {
+	return &tnsapi.Error{Code: codeInvalidParams, Message: fmt.Sprintf("Invalid params for %s: %v", method, err)}
+}
+
+// decodeParams decodes positional parameters into targets. Missing trailing parameters
+// leave their targets untouched.
+func decodeParams(method string, params []json.RawMessage, targets ...interface{}) error {
+	for i, target := range targets {
+		if i >= len(params) || string(params[i]) == "null" {
+			break
+		}
+		if err := json.Unmarshal(params[i], target); err != nil {
+			return invalidParams(method, err)
+		}
+	}
+	return nil
+}
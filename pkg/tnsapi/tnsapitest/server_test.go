@@ -0,0 +1,531 @@
+package tnsapitest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+)
+
+// newTestClient starts a server and connects a client to it.
+func newTestClient(t *testing.T) (*Server, *tnsapi.Client) {
+	t.Helper()
+	srv := NewServer()
+	client, err := tnsapi.NewClient(srv.URL(), APIKey, false)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		srv.Close()
+	})
+	return srv, client
+}
+
+func TestAuthentication(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	if _, err := tnsapi.NewClient(srv.URL(), "wrong-key", false); !errors.Is(err, tnsapi.ErrAuthenticationRejected) {
+		t.Errorf("NewClient() with wrong key error = %v, want ErrAuthenticationRejected", err)
+	}
+}
+
+func TestDatasetLifecycle(t *testing.T) {
+	srv, client := newTestClient(t)
+	ctx := context.Background()
+
+	pool, err := client.QueryPool(ctx, DefaultPool)
+	if err != nil || pool.Properties.Size.Parsed != DefaultPoolSize {
+		t.Fatalf("QueryPool() = %+v, %v; want size %d", pool, err, DefaultPoolSize)
+	}
+	if _, err := client.QueryPool(ctx, "missing"); !errors.Is(err, tnsapi.ErrPoolNotFound) {
+		t.Errorf("QueryPool(missing) error = %v, want ErrPoolNotFound", err)
+	}
+
+	if _, err := client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: "tank/csi/pvc-1", Type: "FILESYSTEM"}); err == nil ||
+		!strings.Contains(err.Error(), "ENOENT") {
+		t.Errorf("CreateDataset() without parent error = %v, want ENOENT", err)
+	}
+	if _, err := client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: "tank/csi", Type: "FILESYSTEM"}); err != nil {
+		t.Fatalf("CreateDataset(tank/csi) error = %v", err)
+	}
+	quota := int64(1 << 30)
+	ds, err := client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: "tank/csi/pvc-1", Type: "FILESYSTEM", RefQuota: &quota})
+	if err != nil {
+		t.Fatalf("CreateDataset(tank/csi/pvc-1) error = %v", err)
+	}
+	if ds.Mountpoint != "/mnt/tank/csi/pvc-1" || ds.Available["parsed"] != float64(quota) {
+		t.Errorf("created dataset = %+v, want mountpoint /mnt/tank/csi/pvc-1 and available %d", ds, quota)
+	}
+	if _, err := client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: "tank/csi/pvc-1", Type: "FILESYSTEM"}); err == nil {
+		t.Error("CreateDataset() of an existing dataset succeeded")
+	}
+
+	if err := client.SetDatasetProperties(ctx, "tank/csi/pvc-1", map[string]string{
+		tnsapi.PropertyManagedBy:     tnsapi.ManagedByValue,
+		tnsapi.PropertyCSIVolumeName: "pvc-1",
+	}); err != nil {
+		t.Fatalf("SetDatasetProperties() error = %v", err)
+	}
+	found, err := client.FindDatasetByCSIVolumeName(ctx, "tank/csi", "pvc-1")
+	if err != nil || found == nil || found.ID != "tank/csi/pvc-1" {
+		t.Errorf("FindDatasetByCSIVolumeName() = %+v, %v; want tank/csi/pvc-1", found, err)
+	}
+	if err := client.ClearDatasetProperties(ctx, "tank/csi/pvc-1", []string{tnsapi.PropertyCSIVolumeName}); err != nil {
+		t.Fatalf("ClearDatasetProperties() error = %v", err)
+	}
+	props, err := client.GetAllDatasetProperties(ctx, "tank/csi/pvc-1")
+	if err != nil || len(props) != 1 || props[tnsapi.PropertyManagedBy] != tnsapi.ManagedByValue {
+		t.Errorf("GetAllDatasetProperties() = %v, %v; want only %s", props, err, tnsapi.PropertyManagedBy)
+	}
+
+	if err := client.DeleteDataset(ctx, "tank/csi/pvc-1"); err != nil {
+		t.Fatalf("DeleteDataset() error = %v", err)
+	}
+	if _, err := client.Dataset(ctx, "tank/csi/pvc-1"); !errors.Is(err, tnsapi.ErrDatasetNotFound) {
+		t.Errorf("Dataset() after delete error = %v, want ErrDatasetNotFound", err)
+	}
+	if srv.DatasetExists("tank/csi/pvc-1") {
+		t.Error("dataset still exists on the server after delete")
+	}
+}
+
+func TestDatasetRenameAndBulkDelete(t *testing.T) {
+	srv, client := newTestClient(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"tank/csi", "tank/csi/pvc-1", "tank/csi/pvc-1/child"} {
+		if _, err := client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: name, Type: "FILESYSTEM"}); err != nil {
+			t.Fatalf("CreateDataset(%s) error = %v", name, err)
+		}
+	}
+	if _, err := client.CreateSnapshot(ctx, tnsapi.SnapshotCreateParams{Dataset: "tank/csi/pvc-1", Name: "snap"}); err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+
+	if err := client.RenameDataset(ctx, "tank/csi/pvc-1", "tank/csi/.deleting-pvc-1"); err != nil {
+		t.Fatalf("RenameDataset() error = %v", err)
+	}
+	for _, id := range []string{"tank/csi/.deleting-pvc-1", "tank/csi/.deleting-pvc-1/child"} {
+		if !srv.DatasetExists(id) {
+			t.Errorf("dataset %s missing after rename", id)
+		}
+	}
+	if srv.DatasetExists("tank/csi/pvc-1") {
+		t.Error("old dataset name still exists after rename")
+	}
+	snapshots, err := client.QuerySnapshotIDs(ctx, []interface{}{[]interface{}{"dataset", "=", "tank/csi/.deleting-pvc-1"}})
+	if err != nil || len(snapshots) != 1 || snapshots[0] != "tank/csi/.deleting-pvc-1@snap" {
+		t.Errorf("snapshots after rename = %v, %v; want tank/csi/.deleting-pvc-1@snap", snapshots, err)
+	}
+
+	jobID, err := client.StartDatasetDelete(ctx, "tank/csi/.deleting-pvc-1", tnsapi.DatasetDeleteOptions{Recursive: true, Force: true})
+	if err != nil {
+		t.Fatalf("StartDatasetDelete() error = %v", err)
+	}
+	job, err := client.GetJobStatus(ctx, jobID)
+	if err != nil || job.State != tnsapi.JobStateSuccess {
+		t.Fatalf("GetJobStatus() = %+v, %v; want SUCCESS", job, err)
+	}
+	if srv.DatasetExists("tank/csi/.deleting-pvc-1") || srv.DatasetExists("tank/csi/.deleting-pvc-1/child") {
+		t.Error("datasets still exist after bulk delete")
+	}
+
+	// The bulk job succeeds even when the deletion fails
+	jobID, err = client.StartDatasetDelete(ctx, "tank/csi/missing", tnsapi.DatasetDeleteOptions{})
+	if err != nil {
+		t.Fatalf("StartDatasetDelete(missing) error = %v", err)
+	}
+	if job, err := client.GetJobStatus(ctx, jobID); err != nil || job.State != tnsapi.JobStateSuccess {
+		t.Errorf("GetJobStatus(missing) = %+v, %v; want SUCCESS", job, err)
+	}
+}
+
+func TestQueryPaging(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"tank/a", "tank/b", "tank/c", "tank/d"} {
+		if _, err := client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: name, Type: "FILESYSTEM"}); err != nil {
+			t.Fatalf("CreateDataset(%s) error = %v", name, err)
+		}
+	}
+
+	var ids []string
+	after := ""
+	for {
+		page, err := client.QueryDatasetsPageAfter(ctx, after, 3)
+		if err != nil {
+			t.Fatalf("QueryDatasetsPageAfter() error = %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, ds := range page {
+			ids = append(ids, ds.ID)
+		}
+		after = page[len(page)-1].ID
+	}
+	if strings.Join(ids, ",") != "tank/a,tank/b,tank/c,tank/d" {
+		t.Errorf("paged dataset IDs = %v, want tank/a..tank/d in order", ids)
+	}
+}
+
+func TestShares(t *testing.T) {
+	srv, client := newTestClient(t)
+	ctx := context.Background()
+
+	if _, err := client.CreateNFSShare(ctx, tnsapi.NFSShareCreateParams{Path: "/mnt/tank/vol", Enabled: true}); err == nil {
+		t.Error("CreateNFSShare() for a missing path succeeded")
+	}
+	if _, err := client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: "tank/vol", Type: "FILESYSTEM"}); err != nil {
+		t.Fatalf("CreateDataset() error = %v", err)
+	}
+
+	nfs, err := client.CreateNFSShare(ctx, tnsapi.NFSShareCreateParams{Path: "/mnt/tank/vol", Enabled: true})
+	if err != nil {
+		t.Fatalf("CreateNFSShare() error = %v", err)
+	}
+	shares, err := client.QueryNFSShare(ctx, "/mnt/tank/vol")
+	if err != nil || len(shares) != 1 || shares[0].ID != nfs.ID {
+		t.Errorf("QueryNFSShare() = %+v, %v; want share %d", shares, err, nfs.ID)
+	}
+
+	smb, err := client.CreateSMBShare(ctx, tnsapi.SMBShareCreateParams{Name: "vol", Path: "/mnt/tank/vol", Enabled: true})
+	if err != nil {
+		t.Fatalf("CreateSMBShare() error = %v", err)
+	}
+	if _, err := client.CreateSMBShare(ctx, tnsapi.SMBShareCreateParams{Name: "VOL", Path: "/mnt/tank/vol"}); err == nil {
+		t.Error("CreateSMBShare() with a duplicate name succeeded")
+	}
+	if err := client.SetFilesystemACL(ctx, "/mnt/tank/vol"); err != nil {
+		t.Errorf("SetFilesystemACL() error = %v", err)
+	}
+
+	if err := client.DeleteSMBShare(ctx, smb.ID); err != nil {
+		t.Errorf("DeleteSMBShare() error = %v", err)
+	}
+	if share, err := client.QuerySMBShareByID(ctx, smb.ID); err != nil || share != nil {
+		t.Errorf("QuerySMBShareByID() after delete = %+v, %v; want nil", share, err)
+	}
+
+	// Deleting the dataset removes the remaining NFS share, like TrueNAS attachment delegates
+	if err := client.DeleteDataset(ctx, "tank/vol"); err != nil {
+		t.Fatalf("DeleteDataset() error = %v", err)
+	}
+	if counts := srv.Counts(); counts["nfs"] != 0 || counts["smb"] != 0 {
+		t.Errorf("share counts after dataset delete = %v, want none", counts)
+	}
+}
+
+func TestNVMeOFTargets(t *testing.T) {
+	srv, client := newTestClient(t)
+	ctx := context.Background()
+
+	zvol, err := client.CreateZvol(ctx, tnsapi.ZvolCreateParams{Name: "tank/pvc-blk", Type: "VOLUME", Volsize: 1 << 30})
+	if err != nil {
+		t.Fatalf("CreateZvol() error = %v", err)
+	}
+	if zvol.Volsize["parsed"] != float64(1<<30) {
+		t.Errorf("zvol volsize = %v, want %d", zvol.Volsize, 1<<30)
+	}
+
+	subsys, err := client.CreateNVMeOFSubsystem(ctx, tnsapi.NVMeOFSubsystemCreateParams{Name: "nqn.2137.csi.tns:pvc-blk", AllowAnyHost: true})
+	if err != nil {
+		t.Fatalf("CreateNVMeOFSubsystem() error = %v", err)
+	}
+	if err := client.AddSubsystemToPort(ctx, subsys.ID, DefaultNVMeOFPortID); err != nil {
+		t.Fatalf("AddSubsystemToPort() error = %v", err)
+	}
+	ns, err := client.CreateNVMeOFNamespace(ctx, tnsapi.NVMeOFNamespaceCreateParams{
+		DevicePath: "zvol/" + zvol.ID, DeviceType: "ZVOL", SubsysID: subsys.ID,
+	})
+	if err != nil {
+		t.Fatalf("CreateNVMeOFNamespace() error = %v", err)
+	}
+	if ns.NSID != 1 || ns.GetSubsystemID() != subsys.ID {
+		t.Errorf("namespace = %+v, want NSID 1 in subsystem %d", ns, subsys.ID)
+	}
+
+	found, err := client.NVMeOFSubsystemByNQN(ctx, "nqn.2137.csi.tns:pvc-blk")
+	if err != nil || found.ID != subsys.ID {
+		t.Errorf("NVMeOFSubsystemByNQN() = %+v, %v; want subsystem %d", found, err, subsys.ID)
+	}
+	bindings, err := client.QuerySubsystemPortBindings(ctx, subsys.ID)
+	if err != nil || len(bindings) != 1 || bindings[0].GetPortID() != DefaultNVMeOFPortID {
+		t.Errorf("QuerySubsystemPortBindings() = %+v, %v; want one binding to port %d", bindings, err, DefaultNVMeOFPortID)
+	}
+
+	if err := client.DeleteNVMeOFSubsystem(ctx, subsys.ID); err == nil {
+		t.Error("DeleteNVMeOFSubsystem() with a namespace attached succeeded")
+	}
+	if err := client.DeleteNVMeOFNamespace(ctx, ns.ID); err != nil {
+		t.Fatalf("DeleteNVMeOFNamespace() error = %v", err)
+	}
+	if err := client.DeleteNVMeOFSubsystem(ctx, subsys.ID); err != nil {
+		t.Fatalf("DeleteNVMeOFSubsystem() error = %v", err)
+	}
+	if err := client.DeleteDataset(ctx, zvol.ID); err != nil {
+		t.Fatalf("DeleteDataset() error = %v", err)
+	}
+	for kind, n := range srv.Counts() {
+		if n != 0 {
+			t.Errorf("%d %s left after cleanup", n, kind)
+		}
+	}
+}
+
+func TestSnapshotsAndClones(t *testing.T) {
+	srv, client := newTestClient(t)
+	ctx := context.Background()
+
+	if _, err := client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: "tank/src", Type: "FILESYSTEM"}); err != nil {
+		t.Fatalf("CreateDataset() error = %v", err)
+	}
+	snap, err := client.CreateSnapshot(ctx, tnsapi.SnapshotCreateParams{Dataset: "tank/src", Name: "snap-1"})
+	if err != nil || snap.ID != "tank/src@snap-1" {
+		t.Fatalf("CreateSnapshot() = %+v, %v; want tank/src@snap-1", snap, err)
+	}
+	clone, err := client.CloneSnapshot(ctx, tnsapi.CloneSnapshotParams{Snapshot: snap.ID, Dataset: "tank/clone"})
+	if err != nil || clone.ID != "tank/clone" {
+		t.Fatalf("CloneSnapshot() = %+v, %v; want tank/clone", clone, err)
+	}
+
+	// A snapshot with clones is kept (hidden) until its last clone is gone
+	if err := client.DeleteSnapshot(ctx, snap.ID); err != nil {
+		t.Fatalf("DeleteSnapshot(defer) error = %v", err)
+	}
+	snaps, err := client.QuerySnapshots(ctx, []interface{}{[]interface{}{"dataset", "=", "tank/src"}})
+	if err != nil || len(snaps) != 0 {
+		t.Errorf("QuerySnapshots() after deferred delete = %+v, %v; want none", snaps, err)
+	}
+	if err := client.DeleteDataset(ctx, "tank/clone"); err != nil {
+		t.Fatalf("DeleteDataset(clone) error = %v", err)
+	}
+	if counts := srv.Counts(); counts["snapshots"] != 0 {
+		t.Errorf("snapshots left after deleting the last clone: %d", counts["snapshots"])
+	}
+}
+
+func TestSnapshotHolds(t *testing.T) {
+	srv, client := newTestClient(t)
+	ctx := context.Background()
+
+	if _, err := client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: "tank/src", Type: "FILESYSTEM"}); err != nil {
+		t.Fatalf("CreateDataset() error = %v", err)
+	}
+	snap, err := client.CreateSnapshot(ctx, tnsapi.SnapshotCreateParams{Dataset: "tank/src", Name: "snap-1"})
+	if err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+	if err := client.HoldSnapshot(ctx, snap.ID); err != nil {
+		t.Fatalf("HoldSnapshot() error = %v", err)
+	}
+
+	// A held snapshot survives a deferred delete until it is released
+	if err := client.DeleteSnapshot(ctx, snap.ID); err != nil {
+		t.Fatalf("DeleteSnapshot(defer) error = %v", err)
+	}
+	if counts := srv.Counts(); counts["snapshots"] != 1 {
+		t.Fatalf("held snapshot destroyed by deferred delete")
+	}
+	if err := client.ReleaseSnapshot(ctx, snap.ID); err != nil {
+		t.Fatalf("ReleaseSnapshot() error = %v", err)
+	}
+	if counts := srv.Counts(); counts["snapshots"] != 0 {
+		t.Errorf("snapshots left after releasing a deferred delete: %d", counts["snapshots"])
+	}
+}
+
+func TestJobEvents(t *testing.T) {
+	srv, client := newTestClient(t)
+	ctx := context.Background()
+
+	id := srv.AddJob("replication.run_onetime", map[string]interface{}{"target_dataset": "tank/copy"})
+	progress := make(chan tnsapi.JobProgress, 10)
+	type result struct {
+		job *tnsapi.Job
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// Polling is too slow to finish the test: updates must arrive as events
+		job, err := client.WatchJob(ctx, id, time.Hour, progress)
+		done <- result{job, err}
+	}()
+
+	waitForCall(t, srv, "core.subscribe")
+	srv.UpdateJob(id, tnsapi.JobStateRunning, 50, "Sending tank/src@snap-1")
+	srv.UpdateJob(id, tnsapi.JobStateSuccess, 100, "")
+
+	select {
+	case r := <-done:
+		if r.err != nil || r.job.State != tnsapi.JobStateSuccess {
+			t.Fatalf("WatchJob() = %+v, %v; want SUCCESS", r.job, r.err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("WatchJob() did not return after the job finished")
+	}
+	if p := <-progress; p.Percent != 50 || p.Description != "Sending tank/src@snap-1" {
+		t.Errorf("first progress = %+v, want 50%% Sending", p)
+	}
+}
+
+func TestJobPollingWithoutEvents(t *testing.T) {
+	srv, client := newTestClient(t)
+	ctx := context.Background()
+	srv.Handle("core.subscribe", func(_ []json.RawMessage) (interface{}, error) {
+		return nil, &tnsapi.Error{Code: -32601, Message: "Method not found: core.subscribe"}
+	})
+
+	failed := srv.AddJob("replication.run_onetime")
+	srv.UpdateJob(failed, tnsapi.JobStateFailed, 10, "target dataset is busy")
+	job, err := client.WatchJob(ctx, failed, 10*time.Millisecond, nil)
+	if !errors.Is(err, tnsapi.ErrJobFailed) || !strings.Contains(err.Error(), "target dataset is busy") || job == nil {
+		t.Errorf("WatchJob(failed) = %+v, %v; want ErrJobFailed with the job error", job, err)
+	}
+
+	aborted := srv.AddJob("replication.run_onetime")
+	if err := client.AbortJob(ctx, aborted); err != nil {
+		t.Fatalf("AbortJob() error = %v", err)
+	}
+	if err := client.WaitForJob(ctx, aborted, 10*time.Millisecond); !errors.Is(err, tnsapi.ErrJobAborted) {
+		t.Errorf("WaitForJob(aborted) error = %v, want ErrJobAborted", err)
+	}
+	if err := client.AbortJob(ctx, aborted); err == nil {
+		t.Error("AbortJob() of a finished job succeeded")
+	}
+}
+
+// waitForCall waits until the server received a call of method.
+func waitForCall(t *testing.T, srv *Server, method string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, call := range srv.Calls() {
+			if call == method {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s was not called", method)
+}
+
+func TestHandleOverridesAndUnknownMethods(t *testing.T) {
+	srv, client := newTestClient(t)
+	ctx := context.Background()
+
+	srv.Handle("pool.dataset.create", func(_ []json.RawMessage) (interface{}, error) {
+		return nil, errNoSpace("out of space")
+	})
+	if _, err := client.CreateDataset(ctx, tnsapi.DatasetCreateParams{Name: "tank/x", Type: "FILESYSTEM"}); err == nil ||
+		!strings.Contains(err.Error(), "ENOSPC") {
+		t.Errorf("CreateDataset() with overridden handler error = %v, want ENOSPC", err)
+	}
+
+	if err := client.Call(ctx, "system.info", nil, nil); err == nil || !strings.Contains(err.Error(), "Method not found") {
+		t.Errorf("Call(system.info) error = %v, want method not found", err)
+	}
+
+	calls := srv.Calls()
+	if len(calls) == 0 || calls[0] != "auth.login_with_api_key" {
+		t.Errorf("Calls() = %v, want authentication first", calls)
+	}
+}
+
+func TestNFS4Clients(t *testing.T) {
+	srv, client := newTestClient(t)
+	ctx := context.Background()
+
+	id := srv.AddNFS4Client("10.0.0.11:871", "Linux NFSv4.2 worker-1")
+	clients, err := client.ListNFS4Clients(ctx)
+	if err != nil {
+		t.Fatalf("ListNFS4Clients() error = %v", err)
+	}
+	if len(clients) != 1 || clients[0].ID != id || clients[0].Address() != "10.0.0.11" || clients[0].Name() != "Linux NFSv4.2 worker-1" {
+		t.Fatalf("ListNFS4Clients() = %+v, want client %s from 10.0.0.11", clients, id)
+	}
+
+	if err := client.ExpireNFS4Client(ctx, id); err != nil {
+		t.Fatalf("ExpireNFS4Client() error = %v", err)
+	}
+	if got := srv.NFS4Clients(); len(got) != 0 {
+		t.Errorf("NFS4Clients() after expiry = %v, want none", got)
+	}
+	if err := client.ExpireNFS4Client(ctx, id); !errors.Is(err, tnsapi.ErrNFS4ClientCtlNotFound) {
+		t.Errorf("ExpireNFS4Client() of an expired client error = %v, want ErrNFS4ClientCtlNotFound", err)
+	}
+
+	// Kernels without nfsd client directories cannot expire clients at all
+	other := srv.AddNFS4Client("10.0.0.12:702", "Linux NFSv4.2 worker-2")
+	srv.SetNFSDClientDirs(false)
+	if err := client.ExpireNFS4Client(ctx, other); !errors.Is(err, tnsapi.ErrNFS4ClientCtlNotFound) {
+		t.Errorf("ExpireNFS4Client() without /proc/fs/nfsd/clients error = %v, want ErrNFS4ClientCtlNotFound", err)
+	}
+	if got := srv.NFS4Clients(); !slices.Equal(got, []string{other}) {
+		t.Errorf("NFS4Clients() after a failed expiry = %v, want %s kept", got, other)
+	}
+	if err := client.ExpireNFS4Client(ctx, "../"+id); !errors.Is(err, tnsapi.ErrInvalidNFS4ClientID) {
+		t.Errorf("ExpireNFS4Client() with a path error = %v, want ErrInvalidNFS4ClientID", err)
+	}
+}
+
+func TestSnapshotStreams(t *testing.T) {
+	srv, client := newTestClient(t)
+	ctx := context.Background()
+
+	if _, err := client.CreateZvol(ctx, tnsapi.ZvolCreateParams{Name: "tank/src", Type: "VOLUME", Volsize: 1 << 30}); err != nil {
+		t.Fatalf("CreateDataset() error = %v", err)
+	}
+	if err := client.SetDatasetProperties(ctx, "tank/src", map[string]string{tnsapi.PropertyProtocol: "nvmeof"}); err != nil {
+		t.Fatalf("SetDatasetProperties() error = %v", err)
+	}
+	for _, name := range []string{"snap-1", "snap-2"} {
+		if _, err := client.CreateSnapshot(ctx, tnsapi.SnapshotCreateParams{Dataset: "tank/src", Name: name}); err != nil {
+			t.Fatalf("CreateSnapshot(%s) error = %v", name, err)
+		}
+	}
+
+	transfer := func(send tnsapi.SnapshotSendParams, receive tnsapi.SnapshotReceiveParams) error {
+		stream, err := client.SendSnapshot(ctx, send)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = stream.Close() }()
+		return client.ReceiveSnapshot(ctx, receive, stream)
+	}
+
+	if err := transfer(tnsapi.SnapshotSendParams{Snapshot: "tank/src@snap-1", Properties: true}, tnsapi.SnapshotReceiveParams{Dataset: "tank/dst"}); err != nil {
+		t.Fatalf("full stream transfer error = %v", err)
+	}
+	dst, err := client.GetDatasetWithProperties(ctx, "tank/dst")
+	if err != nil || dst == nil || dst.Type != "VOLUME" || dst.UserProperties[tnsapi.PropertyProtocol].Value != "nvmeof" {
+		t.Fatalf("received dataset = %+v, %v; want a zvol with the sent properties", dst, err)
+	}
+	if err := transfer(tnsapi.SnapshotSendParams{Snapshot: "tank/src@snap-2", IncrementalBase: "tank/src@snap-1"}, tnsapi.SnapshotReceiveParams{Dataset: "tank/dst"}); err != nil {
+		t.Fatalf("incremental stream transfer error = %v", err)
+	}
+	if snaps, err := client.QuerySnapshotIDs(ctx, []interface{}{[]interface{}{"dataset", "=", "tank/dst"}}); err != nil || len(snaps) != 2 {
+		t.Errorf("received snapshots = %v, %v; want snap-1 and snap-2", snaps, err)
+	}
+
+	// A full stream does not overwrite an existing dataset without force
+	err = transfer(tnsapi.SnapshotSendParams{Snapshot: "tank/src@snap-1"}, tnsapi.SnapshotReceiveParams{Dataset: "tank/dst"})
+	if !errors.Is(err, tnsapi.ErrJobFailed) {
+		t.Errorf("receive into an existing dataset error = %v, want ErrJobFailed", err)
+	}
+	if _, err := client.SendSnapshot(ctx, tnsapi.SnapshotSendParams{Snapshot: "tank/src@missing"}); err == nil {
+		t.Error("SendSnapshot() of a missing snapshot succeeded")
+	}
+	if counts := srv.Counts(); counts["datasets"] != 2 {
+		t.Errorf("datasets = %d, want 2", counts["datasets"])
+	}
+}
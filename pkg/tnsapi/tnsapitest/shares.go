@@ -0,0 +1,177 @@
+package tnsapitest
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// NFS and SMB shares
+
+// checkSharePath checks that a share path is an existing filesystem dataset or a directory in one.
+func (st *state) checkSharePath(method, path string) error {
+	if !strings.HasPrefix(path, "/mnt/") {
+		return errInvalid("%s.path: path must be under /mnt, got %q", method, path)
+	}
+	if _, err := st.filesystemStat([]json.RawMessage{mustJSON(path)}); err != nil {
+		return errNotFound("%s.path: %s does not exist", method, path)
+	}
+	return nil
+}
+
+func (st *state) nfsCreate(params []json.RawMessage) (interface{}, error) {
+	var share record
+	if err := decodeParams("sharing.nfs.create", params, &share); err != nil {
+		return nil, err
+	}
+	path, _ := share["path"].(string)
+	if err := st.checkSharePath("sharing.nfs.create", path); err != nil {
+		return nil, err
+	}
+	for _, existing := range st.nfsShares {
+		if existing["path"] == path {
+			return nil, errExists("sharing.nfs.create.path: export for %s already exists", path)
+		}
+	}
+	id := st.newID()
+	share["id"] = float64(id)
+	setDefault(share, "comment", "")
+	setDefault(share, "hosts", []interface{}{})
+	setDefault(share, "networks", []interface{}{})
+	setDefault(share, "ro", false)
+	setDefault(share, "enabled", true)
+	st.nfsShares[id] = share
+	return share, nil
+}
+
+func (st *state) nfsUpdate(params []json.RawMessage) (interface{}, error) {
+	var id int
+	var update record
+	if err := decodeParams("sharing.nfs.update", params, &id, &update); err != nil {
+		return nil, err
+	}
+	share, ok := st.nfsShares[id]
+	if !ok {
+		return nil, errNotFound("NFS share %d does not exist", id)
+	}
+	for k, v := range update {
+		if k != "id" {
+			share[k] = v
+		}
+	}
+	return share, nil
+}
+
+func (st *state) nfsDelete(params []json.RawMessage) (interface{}, error) {
+	var id int
+	if err := decodeParams("sharing.nfs.delete", params, &id); err != nil {
+		return nil, err
+	}
+	if _, ok := st.nfsShares[id]; !ok {
+		return nil, errNotFound("NFS share %d does not exist", id)
+	}
+	delete(st.nfsShares, id)
+	return true, nil
+}
+
+func (st *state) nfsQuery(params []json.RawMessage) (interface{}, error) {
+	filters, opts, err := parseQuery("sharing.nfs.query", params)
+	if err != nil {
+		return nil, err
+	}
+	return query("sharing.nfs.query", values(st.nfsShares), filters, opts)
+}
+
+// nfsConfig returns an NFS service without Kerberos; tests needing it register a handler.
+func (st *state) nfsConfig(_ []json.RawMessage) (interface{}, error) {
+	return record{
+		"v4_domain":      "",
+		"protocols":      []interface{}{"NFSV3", "NFSV4"},
+		"v4_krb":         false,
+		"v4_krb_enabled": false,
+	}, nil
+}
+
+func (st *state) smbCreate(params []json.RawMessage) (interface{}, error) {
+	var share record
+	if err := decodeParams("sharing.smb.create", params, &share); err != nil {
+		return nil, err
+	}
+	name, _ := share["name"].(string)
+	if name == "" {
+		return nil, errInvalid("sharing.smb.create.name: attribute required")
+	}
+	path, _ := share["path"].(string)
+	if err := st.checkSharePath("sharing.smb.create", path); err != nil {
+		return nil, err
+	}
+	for _, existing := range st.smbShares {
+		if strings.EqualFold(existing["name"].(string), name) {
+			return nil, errExists("sharing.smb.create.name: share name %s already exists", name)
+		}
+	}
+	id := st.newID()
+	share["id"] = float64(id)
+	setDefault(share, "comment", "")
+	setDefault(share, "purpose", "DEFAULT_SHARE")
+	setDefault(share, "ro", false)
+	setDefault(share, "browsable", true)
+	setDefault(share, "guestok", false)
+	setDefault(share, "enabled", true)
+	share["locked"] = false
+	st.smbShares[id] = share
+	return share, nil
+}
+
+func (st *state) smbUpdate(params []json.RawMessage) (interface{}, error) {
+	var id int
+	var update record
+	if err := decodeParams("sharing.smb.update", params, &id, &update); err != nil {
+		return nil, err
+	}
+	share, ok := st.smbShares[id]
+	if !ok {
+		return nil, errNotFound("SMB share %d does not exist", id)
+	}
+	for k, v := range update {
+		if k != "id" {
+			share[k] = v
+		}
+	}
+	return share, nil
+}
+
+func (st *state) smbDelete(params []json.RawMessage) (interface{}, error) {
+	var id int
+	if err := decodeParams("sharing.smb.delete", params, &id); err != nil {
+		return nil, err
+	}
+	if _, ok := st.smbShares[id]; !ok {
+		return nil, errNotFound("SMB share %d does not exist", id)
+	}
+	delete(st.smbShares, id)
+	return true, nil
+}
+
+func (st *state) smbQuery(params []json.RawMessage) (interface{}, error) {
+	filters, opts, err := parseQuery("sharing.smb.query", params)
+	if err != nil {
+		return nil, err
+	}
+	return query("sharing.smb.query", values(st.smbShares), filters, opts)
+}
+
+// setDefault sets a field the request did not set, like TrueNAS schema defaults.
+func setDefault(rec record, field string, value interface{}) {
+	if _, ok := rec[field]; !ok {
+		rec[field] = value
+	}
+}
+
+// values returns the records of an ID-keyed table.
+func values(table map[int]record) []record {
+	records := make([]record, 0, len(table))
+	for _, rec := range table {
+		records = append(records, rec)
+	}
+	return records
+}
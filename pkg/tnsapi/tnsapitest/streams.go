@@ -0,0 +1,235 @@
+package tnsapitest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fenio/tns-csi/pkg/tnsapi"
+)
+
+// Raw ZFS send streams.
+//
+// core.download of zfs.snapshot.send queues the stream of a snapshot for one GET of the returned
+// /_download URL; a multipart POST of zfs.snapshot.receive to /_upload receives one. Streams are
+// opaque to clients: the emulation encodes the dataset type, size, user properties (with
+// properties=true), snapshot name and guid as JSON instead of a real `zfs send` stream. Incremental
+// streams need the base snapshot on the receiving dataset.
+
+const (
+	methodSnapshotSend    = "zfs.snapshot.send"
+	methodSnapshotReceive = "zfs.snapshot.receive"
+	downloadPath          = "/_download/"
+	uploadPath            = "/_upload/"
+)
+
+// sendStream is the emulated content of a zfs send stream.
+type sendStream struct {
+	UserProperties  map[string]interface{} `json:"user_properties,omitempty"`
+	Volsize         *float64               `json:"volsize,omitempty"`
+	Type            string                 `json:"type"`
+	Snapshot        string                 `json:"snapshot"`
+	GUID            interface{}            `json:"guid"`
+	IncrementalBase string                 `json:"incremental_base,omitempty"`
+}
+
+// serveHTTP routes job transfers to their handlers and everything else to the WebSocket API.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, downloadPath):
+		s.serveDownload(w, r)
+	case strings.HasPrefix(r.URL.Path, uploadPath):
+		s.serveUpload(w, r)
+	default:
+		s.serveWebSocket(w, r)
+	}
+}
+
+// authorized reports whether an HTTP request carries the API key.
+func authorized(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Authorization") != "Bearer "+APIKey {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// serveDownload serves the output of a core.download job once and completes the job.
+func (s *Server) serveDownload(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, downloadPath))
+	s.mu.Lock()
+	data, ok := s.state.downloads[id]
+	if ok {
+		delete(s.state.downloads, id)
+		s.state.updateJob(id, tnsapi.JobStateSuccess, 100, "")
+	}
+	events := s.state.takeEvents()
+	s.mu.Unlock()
+	s.broadcast(events)
+
+	if err != nil || !ok {
+		http.Error(w, "No such download", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data) //nolint:errcheck,gosec // a failed write means the client is gone
+}
+
+// serveUpload starts the job named by the "data" field of an upload with the "file" field as input.
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	var call struct {
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(r.FormValue("data")), &call); err != nil {
+		http.Error(w, "Invalid data field", http.StatusBadRequest)
+		return
+	}
+	if call.Method != methodSnapshotReceive {
+		http.Error(w, "Method "+call.Method+" does not accept uploads", http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing file field", http.StatusBadRequest)
+		return
+	}
+	defer func() { _ = file.Close() }()
+	stream, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Failed to read upload", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, call.Method)
+	id := s.state.snapshotReceive(call.Params, stream)
+	events := s.state.takeEvents()
+	s.mu.Unlock()
+	s.broadcast(events)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"job_id": id}) //nolint:errcheck,errchkjson,gosec // test server
+}
+
+// coreDownload starts a job whose output is served at the returned /_download URL.
+// Only zfs.snapshot.send is emulated.
+func (st *state) coreDownload(params []json.RawMessage) (interface{}, error) {
+	var method, filename string
+	var args []json.RawMessage
+	if err := decodeParams("core.download", params, &method, &args, &filename); err != nil {
+		return nil, err
+	}
+	if method != methodSnapshotSend {
+		return nil, errInvalid("core.download: method %s is not emulated", method)
+	}
+	var p tnsapi.SnapshotSendParams
+	if err := decodeParams(method, args, &p); err != nil {
+		return nil, err
+	}
+	if _, ok := st.snapshots[p.Snapshot]; !ok {
+		return nil, errNotFound("Snapshot %s does not exist", p.Snapshot)
+	}
+	dataset, name, _ := strings.Cut(p.Snapshot, "@")
+	guid := st.snapshots[p.Snapshot]["properties"].(map[string]interface{})["guid"]
+	stream := sendStream{Type: st.datasets[dataset]["type"].(string), Snapshot: name, GUID: guid}
+	if p.IncrementalBase != "" {
+		base, baseName, _ := strings.Cut(p.IncrementalBase, "@")
+		if _, ok := st.snapshots[p.IncrementalBase]; !ok || base != dataset {
+			return nil, errNotFound("Incremental base %s is not a snapshot of %s", p.IncrementalBase, dataset)
+		}
+		stream.IncrementalBase = baseName
+	}
+	if volsize, ok := st.datasets[dataset]["volsize"].(float64); ok {
+		stream.Volsize = &volsize
+	}
+	if p.Properties {
+		stream.UserProperties = st.datasets[dataset]["user_properties"].(map[string]interface{})
+	}
+	data, err := json.Marshal(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	id := st.addJob(method, []interface{}{p})
+	st.downloads[id] = data
+	return []interface{}{id, downloadPath + strconv.Itoa(id)}, nil
+}
+
+// snapshotReceive runs a zfs.snapshot.receive job for an uploaded stream and returns its ID.
+// The job has finished when it is returned.
+func (st *state) snapshotReceive(params []json.RawMessage, data []byte) int {
+	var p tnsapi.SnapshotReceiveParams
+	id := st.addJob(methodSnapshotReceive, []interface{}{})
+	if err := decodeParams(methodSnapshotReceive, params, &p); err != nil {
+		st.updateJob(id, tnsapi.JobStateFailed, 0, err.Error())
+		return id
+	}
+	st.jobs[id]["arguments"] = []interface{}{p}
+	if reason := st.receive(p, data); reason != "" {
+		st.updateJob(id, tnsapi.JobStateFailed, 0, reason)
+		return id
+	}
+	st.updateJob(id, tnsapi.JobStateSuccess, 100, "")
+	return id
+}
+
+// receive stores a stream in p.Dataset and returns why it failed, or "".
+func (st *state) receive(p tnsapi.SnapshotReceiveParams, data []byte) string {
+	var stream sendStream
+	if err := json.Unmarshal(data, &stream); err != nil || stream.Snapshot == "" {
+		return "invalid backup stream"
+	}
+	existing, exists := st.datasets[p.Dataset]
+
+	if stream.IncrementalBase != "" {
+		if !exists || st.snapshots[p.Dataset+"@"+stream.IncrementalBase] == nil {
+			return "destination " + p.Dataset + " does not have snapshot " + stream.IncrementalBase + " of the incremental stream"
+		}
+	} else {
+		if exists && !p.Force {
+			return "destination " + p.Dataset + " exists; must specify -F to overwrite it"
+		}
+		if exists {
+			for snapshotID := range st.snapshots {
+				if strings.HasPrefix(snapshotID, p.Dataset+"@") {
+					delete(st.snapshots, snapshotID)
+				}
+			}
+			delete(st.datasets, p.Dataset)
+		}
+		create := map[string]interface{}{"name": p.Dataset, "type": stream.Type, "volsize": stream.Volsize, "sparse": true}
+		raw, err := json.Marshal(create)
+		if err != nil {
+			return err.Error()
+		}
+		if _, err := st.datasetCreate([]json.RawMessage{raw}); err != nil {
+			return err.Error()
+		}
+		existing = st.datasets[p.Dataset]
+	}
+
+	if st.snapshots[p.Dataset+"@"+stream.Snapshot] != nil {
+		return "destination " + p.Dataset + "@" + stream.Snapshot + " exists"
+	}
+	props := existing["user_properties"].(map[string]interface{})
+	for key, value := range stream.UserProperties {
+		props[key] = value
+	}
+	st.txg++
+	received := st.newSnapshot(p.Dataset, stream.Snapshot, time.Now())
+	if stream.GUID != nil {
+		received["properties"].(map[string]interface{})["guid"] = stream.GUID // zfs receive keeps the guid
+	}
+	st.snapshots[p.Dataset+"@"+stream.Snapshot] = received
+	return ""
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
@@ -37,6 +38,8 @@ var (
 	ErrISCSIExtentNotFound = errors.New("iSCSI extent not found")
 	// ErrISCSITargetExtentNotFound indicates an iSCSI target-extent was not found.
 	ErrISCSITargetExtentNotFound = errors.New("iSCSI target-extent not found")
+	// ErrStreamsNotSupported indicates zfs send streams are not emulated.
+	ErrStreamsNotSupported = errors.New("zfs send streams are not supported by the mock")
 )
 
 // MockClient is a mock implementation of the TrueNAS API client for sanity testing.
@@ -110,9 +113,10 @@ type mockSnapshot struct {
 }
 
 type mockSubsystem struct {
-	Name string
-	NQN  string
-	ID   int
+	Name   string
+	NQN    string
+	Serial string
+	ID     int
 }
 
 type mockNamespace struct {
@@ -203,6 +207,7 @@ func (m *MockClient) QueryPool(ctx context.Context, poolName string) (*tnsapi.Po
 			Capacity struct {
 				Parsed int64 `json:"parsed"`
 			} `json:"capacity"`
+			Dedupratio map[string]interface{} `json:"dedupratio,omitempty"`
 		}{
 			Size: struct {
 				Parsed int64 `json:"parsed"`
@@ -272,6 +277,40 @@ func (m *MockClient) DeleteDataset(ctx context.Context, id string) error {
 	return fmt.Errorf("dataset %s: %w", id, ErrDatasetNotFound)
 }
 
+// DeleteDatasetWithOptions mocks pool.dataset.delete with explicit options.
+func (m *MockClient) DeleteDatasetWithOptions(ctx context.Context, id string, _ tnsapi.DatasetDeleteOptions) error {
+	return m.DeleteDataset(ctx, id)
+}
+
+// StartDatasetDelete mocks core.bulk of pool.dataset.delete (deletes immediately).
+func (m *MockClient) StartDatasetDelete(ctx context.Context, id string, _ tnsapi.DatasetDeleteOptions) (int, error) {
+	if err := m.DeleteDataset(ctx, id); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// RenameDataset mocks pool.dataset.rename.
+func (m *MockClient) RenameDataset(ctx context.Context, id, newName string) error {
+	m.logCall("RenameDataset", id, newName)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ds, exists := m.datasets[id]
+	if !exists {
+		return fmt.Errorf("dataset %s: %w", id, ErrDatasetNotFound)
+	}
+	delete(m.datasets, id)
+	ds.ID = newName
+	ds.Name = newName
+	if ds.Mountpoint != "" {
+		ds.Mountpoint = "/mnt/" + newName
+	}
+	m.datasets[newName] = ds
+	return nil
+}
+
 // Dataset mocks pool.dataset.query.
 func (m *MockClient) Dataset(ctx context.Context, name string) (*tnsapi.Dataset, error) {
 	m.logCall("Dataset", name)
@@ -556,6 +595,28 @@ func (m *MockClient) CreateNFSShare(ctx context.Context, params tnsapi.NFSShareC
 	}, nil
 }
 
+// UpdateNFSShare mocks sharing.nfs.update.
+func (m *MockClient) UpdateNFSShare(_ context.Context, id int, params tnsapi.NFSShareUpdateParams) (*tnsapi.NFSShare, error) {
+	m.logCall("UpdateNFSShare", id)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	share, exists := m.nfsShares[id]
+	if !exists {
+		return nil, fmt.Errorf("NFS share %d: %w", id, ErrNFSShareNotFound)
+	}
+	if params.Comment != "" {
+		share.Comment = params.Comment
+		m.nfsShares[id] = share
+	}
+	result := &tnsapi.NFSShare{ID: id, Path: share.Path, Comment: share.Comment, Enabled: share.Enabled}
+	if params.Hosts != nil {
+		result.Hosts = *params.Hosts
+	}
+	return result, nil
+}
+
 // DeleteNFSShare mocks sharing.nfs.delete.
 func (m *MockClient) DeleteNFSShare(ctx context.Context, id int) error {
 	m.logCall("DeleteNFSShare", id)
@@ -762,6 +823,11 @@ func (m *MockClient) FilesystemStat(ctx context.Context, path string) error {
 	return nil
 }
 
+// FilesystemMkdir mocks filesystem.mkdir.
+func (m *MockClient) FilesystemMkdir(ctx context.Context, path, mode string) error {
+	return nil
+}
+
 // GetFilesystemACL mocks filesystem.getacl.
 func (m *MockClient) GetFilesystemACL(ctx context.Context, path string) (string, error) {
 	return "NFS4", nil
@@ -772,6 +838,11 @@ func (m *MockClient) SetFilesystemACL(ctx context.Context, path string) error {
 	return nil
 }
 
+// SetFilesystemNFS4ACL mocks filesystem.setacl with explicit entries.
+func (m *MockClient) SetFilesystemNFS4ACL(ctx context.Context, path string, aces []tnsapi.NFS4ACE) error {
+	return nil
+}
+
 // CreateZvol mocks pool.dataset.create for ZVOLs.
 func (m *MockClient) CreateZvol(ctx context.Context, params tnsapi.ZvolCreateParams) (*tnsapi.Dataset, error) {
 	m.logCall("CreateZvol", params.Name, params.Volsize)
@@ -815,15 +886,17 @@ func (m *MockClient) CreateNVMeOFSubsystem(ctx context.Context, params tnsapi.NV
 	nqn := fmt.Sprintf("nqn.2014-08.org.nvmexpress:uuid:test-%d:%s", subsysID, params.Name)
 
 	m.subsystems[params.Name] = mockSubsystem{
-		ID:   subsysID,
-		Name: params.Name,
-		NQN:  nqn,
+		ID:     subsysID,
+		Name:   params.Name,
+		NQN:    nqn,
+		Serial: params.Serial,
 	}
 
 	return &tnsapi.NVMeOFSubsystem{
-		ID:   subsysID,
-		Name: params.Name,
-		NQN:  nqn,
+		ID:     subsysID,
+		Name:   params.Name,
+		NQN:    nqn,
+		Serial: params.Serial,
 	}, nil
 }
 
@@ -895,9 +968,10 @@ func (m *MockClient) ListAllNVMeOFSubsystems(ctx context.Context) ([]tnsapi.NVMe
 	result := make([]tnsapi.NVMeOFSubsystem, 0, len(m.subsystems))
 	for _, subsys := range m.subsystems {
 		result = append(result, tnsapi.NVMeOFSubsystem{
-			ID:   subsys.ID,
-			Name: subsys.Name,
-			NQN:  subsys.NQN,
+			ID:     subsys.ID,
+			Name:   subsys.Name,
+			NQN:    subsys.NQN,
+			Serial: subsys.Serial,
 		})
 	}
 
@@ -1007,6 +1081,36 @@ func (m *MockClient) QueryNVMeOFPorts(ctx context.Context) ([]tnsapi.NVMeOFPort,
 	}, nil
 }
 
+// NVMeOFHostByNQN mocks nvmet.host.query. No hosts are registered.
+func (m *MockClient) NVMeOFHostByNQN(ctx context.Context, hostNQN string) (*tnsapi.NVMeOFHost, error) {
+	m.logCall("NVMeOFHostByNQN", hostNQN)
+	return nil, nil
+}
+
+// CreateNVMeOFHost mocks nvmet.host.create.
+func (m *MockClient) CreateNVMeOFHost(ctx context.Context, hostNQN string) (*tnsapi.NVMeOFHost, error) {
+	m.logCall("CreateNVMeOFHost", hostNQN)
+	return &tnsapi.NVMeOFHost{ID: 1, HostNQN: hostNQN}, nil
+}
+
+// QueryNVMeOFHostSubsystems mocks nvmet.host_subsys.query.
+func (m *MockClient) QueryNVMeOFHostSubsystems(ctx context.Context, subsystemID int) ([]tnsapi.NVMeOFHostSubsystem, error) {
+	m.logCall("QueryNVMeOFHostSubsystems", subsystemID)
+	return nil, nil
+}
+
+// AddHostToSubsystem mocks nvmet.host_subsys.create.
+func (m *MockClient) AddHostToSubsystem(ctx context.Context, hostID, subsystemID int) error {
+	m.logCall("AddHostToSubsystem", hostID, subsystemID)
+	return nil
+}
+
+// RemoveHostFromSubsystem mocks nvmet.host_subsys.delete.
+func (m *MockClient) RemoveHostFromSubsystem(ctx context.Context, hostSubsysID int) error {
+	m.logCall("RemoveHostFromSubsystem", hostSubsysID)
+	return nil
+}
+
 // RemoveSubsystemFromPort mocks nvmet.port_subsys.delete.
 func (m *MockClient) RemoveSubsystemFromPort(ctx context.Context, portSubsysID int) error {
 	m.logCall("RemoveSubsystemFromPort", portSubsysID)
@@ -1190,6 +1294,25 @@ func (m *MockClient) CloneSnapshot(ctx context.Context, params tnsapi.CloneSnaps
 	}, nil
 }
 
+// HoldSnapshot mocks pool.snapshot.hold.
+func (m *MockClient) HoldSnapshot(ctx context.Context, snapshotID string) error {
+	m.logCall("HoldSnapshot", snapshotID)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.snapshots[snapshotID]; !exists {
+		return fmt.Errorf("snapshot %s: %w", snapshotID, ErrSnapshotNotFound)
+	}
+	return nil
+}
+
+// ReleaseSnapshot mocks pool.snapshot.release.
+func (m *MockClient) ReleaseSnapshot(ctx context.Context, snapshotID string) error {
+	m.logCall("ReleaseSnapshot", snapshotID)
+	return nil
+}
+
 // PromoteDataset mocks pool.dataset.promote.
 // This simulates promoting a cloned dataset to become independent from its origin.
 func (m *MockClient) PromoteDataset(ctx context.Context, datasetID string) error {
@@ -1212,6 +1335,18 @@ func (m *MockClient) PromoteDataset(ctx context.Context, datasetID string) error
 
 // RunOnetimeReplication mocks replication.run_onetime.
 // This simulates a one-time zfs send/receive operation for detached snapshots.
+// SendSnapshot is not supported by the mock.
+func (m *MockClient) SendSnapshot(ctx context.Context, params tnsapi.SnapshotSendParams) (io.ReadCloser, error) {
+	m.logCall("SendSnapshot", params.Snapshot)
+	return nil, ErrStreamsNotSupported
+}
+
+// ReceiveSnapshot is not supported by the mock.
+func (m *MockClient) ReceiveSnapshot(ctx context.Context, params tnsapi.SnapshotReceiveParams, stream io.Reader) error {
+	m.logCall("ReceiveSnapshot", params.Dataset)
+	return ErrStreamsNotSupported
+}
+
 func (m *MockClient) RunOnetimeReplication(ctx context.Context, params tnsapi.ReplicationRunOnetimeParams) (int, error) {
 	m.logCall("RunOnetimeReplication", params.SourceDatasets, params.TargetDataset)
 
@@ -1250,14 +1385,14 @@ func (m *MockClient) RunOnetimeReplication(ctx context.Context, params tnsapi.Re
 }
 
 // GetJobStatus mocks core.get_jobs to get job status.
-func (m *MockClient) GetJobStatus(ctx context.Context, jobID int) (*tnsapi.ReplicationJobState, error) {
+func (m *MockClient) GetJobStatus(ctx context.Context, jobID int) (*tnsapi.Job, error) {
 	m.logCall("GetJobStatus", jobID)
 
 	// Return a completed job status for the mock
-	return &tnsapi.ReplicationJobState{
+	return &tnsapi.Job{
 		ID:       jobID,
-		State:    "SUCCESS",
-		Progress: map[string]interface{}{"percent": float64(100)},
+		State:    tnsapi.JobStateSuccess,
+		Progress: tnsapi.JobProgress{Percent: 100},
 	}, nil
 }
 
@@ -1357,6 +1492,27 @@ func (m *MockClient) GetCallLog() []string {
 // iSCSI operations
 // =============================================================================
 
+// GetNFSConfig returns the NFS service configuration.
+func (m *MockClient) GetNFSConfig(ctx context.Context) (*tnsapi.NFSConfig, error) {
+	m.logCall("GetNFSConfig")
+
+	return &tnsapi.NFSConfig{
+		Protocols: []string{"NFSV3", "NFSV4"},
+	}, nil
+}
+
+// ListNFS4Clients returns no NFSv4 clients.
+func (m *MockClient) ListNFS4Clients(ctx context.Context) ([]tnsapi.NFS4Client, error) {
+	m.logCall("ListNFS4Clients")
+	return nil, nil
+}
+
+// ExpireNFS4Client does nothing; the mock has no NFSv4 clients.
+func (m *MockClient) ExpireNFS4Client(ctx context.Context, clientID string) error {
+	m.logCall("ExpireNFS4Client", clientID)
+	return nil
+}
+
 // GetISCSIGlobalConfig returns the global iSCSI configuration.
 func (m *MockClient) GetISCSIGlobalConfig(ctx context.Context) (*tnsapi.ISCSIGlobalConfig, error) {
 	m.logCall("GetISCSIGlobalConfig")
@@ -1399,6 +1555,28 @@ func (m *MockClient) QueryISCSIInitiators(ctx context.Context) ([]tnsapi.ISCSIIn
 	}, nil
 }
 
+// CreateISCSIInitiator creates an iSCSI initiator group.
+func (m *MockClient) CreateISCSIInitiator(ctx context.Context, params tnsapi.ISCSIInitiatorCreateParams) (*tnsapi.ISCSIInitiator, error) {
+	m.logCall("CreateISCSIInitiator", params.Comment)
+	return &tnsapi.ISCSIInitiator{ID: 2, Tag: 2, Comment: params.Comment, Initiators: params.Initiators}, nil
+}
+
+// UpdateISCSITargetGroups replaces the groups of an iSCSI target.
+func (m *MockClient) UpdateISCSITargetGroups(ctx context.Context, targetID int, groups []tnsapi.ISCSITargetGroup) (*tnsapi.ISCSITarget, error) {
+	m.logCall("UpdateISCSITargetGroups", targetID)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	target, exists := m.iscsiTargets[targetID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %d", ErrISCSITargetNotFound, targetID)
+	}
+	target.Groups = groups
+	m.iscsiTargets[targetID] = target
+	return &tnsapi.ISCSITarget{ID: target.ID, Name: target.Name, Alias: target.Alias, Mode: target.Mode, Groups: target.Groups}, nil
+}
+
 // CreateISCSITarget creates a new iSCSI target.
 func (m *MockClient) CreateISCSITarget(ctx context.Context, params tnsapi.ISCSITargetCreateParams) (*tnsapi.ISCSITarget, error) {
 	m.logCall("CreateISCSITarget", params.Name)
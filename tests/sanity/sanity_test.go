@@ -1,13 +1,17 @@
 package sanity
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/fenio/tns-csi/pkg/driver"
 	sanity "github.com/kubernetes-csi/csi-test/v5/pkg/sanity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 const (
@@ -68,6 +72,10 @@ func TestSanity(t *testing.T) {
 	default:
 	}
 
+	// kubelet registers a node plugin (NodeGetInfo) before anything is attached to its node; the
+	// controller knows in-process nodes from that registration
+	registerNode(t, endpoint)
+
 	// Configure sanity test
 	sanityCfg := sanity.NewTestConfig()
 	sanityCfg.Address = endpoint
@@ -97,6 +105,21 @@ func TestSanity(t *testing.T) {
 	drv.Stop()
 }
 
+// registerNode calls NodeGetInfo on the driver like kubelet's plugin registration does.
+func registerNode(t *testing.T, endpoint string) {
+	t.Helper()
+	conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect to driver: %v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := csi.NewNodeClient(conn).NodeGetInfo(ctx, &csi.NodeGetInfoRequest{}); err != nil {
+		t.Fatalf("NodeGetInfo failed: %v", err)
+	}
+}
+
 // TestSanityIdentity runs only Identity service sanity tests.
 // These tests don't require a TrueNAS backend and can run immediately.
 func TestSanityIdentity(t *testing.T) {
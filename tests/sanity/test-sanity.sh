@@ -8,9 +8,9 @@ set -o pipefail
 SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
 PROJECT_ROOT="$(cd "${SCRIPT_DIR}/../.." && pwd)"
 
-# Phase 3 baseline: 75 tests passing (Identity, Controller, Node, and Snapshot services fully functional)
+# Phase 3 baseline: 77 tests passing (Identity, Controller, Node, and Snapshot services fully functional)
 # All CSI specification compliance tests passing (100% pass rate)
-BASELINE_PASS_COUNT=75
+BASELINE_PASS_COUNT=77
 
 echo "=== CSI Sanity Tests ==="
 echo "Project root: ${PROJECT_ROOT}"